@@ -3,24 +3,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"sentra/cmd/sentra/commands"
 	"sentra/internal/buildutil"
+	"sentra/internal/builtins"
 	"sentra/internal/compiler"
 	"sentra/internal/compregister"
 	"sentra/internal/debugger"
 	"sentra/internal/errors"
 	"sentra/internal/formatter"
 	"sentra/internal/lexer"
+	"sentra/internal/limits"
+	"sentra/internal/lint"
 	"sentra/internal/lsp"
 	"sentra/internal/parser"
 	"sentra/internal/packages"
+	"sentra/internal/profiler"
+	"sentra/internal/replay"
 	"sentra/internal/repl"
+	"sentra/internal/sandbox"
 	"sentra/internal/testing"
 	"sentra/internal/vm"
 	"sentra/internal/vmregister"
@@ -136,17 +148,44 @@ func main() {
 			return
 		}
 
+		pm := packages.NewPackageManager("")
 		switch args[1] {
 		case "search":
-			if err := commands.PackageSearchCommand(args[2:]); err != nil {
+			if len(args) < 3 {
+				log.Fatal("Usage: sentra pkg search <query>")
+			}
+			if err := pm.SearchPackages(strings.Join(args[2:], " ")); err != nil {
 				log.Fatalf("Error: %v", err)
 			}
 		case "info":
-			if err := commands.PackageInfoCommand(args[2:]); err != nil {
+			if len(args) < 3 {
+				log.Fatal("Usage: sentra pkg info <package>")
+			}
+			if err := pm.ShowPackageInfo(args[2]); err != nil {
 				log.Fatalf("Error: %v", err)
 			}
 		case "publish":
-			if err := commands.PackagePublishCommand(args[2:]); err != nil {
+			registryName := ""
+			for i := 2; i < len(args); i++ {
+				if args[i] == "--registry" && i+1 < len(args) {
+					registryName = args[i+1]
+					i++
+				} else if strings.HasPrefix(args[i], "--registry=") {
+					registryName = strings.TrimPrefix(args[i], "--registry=")
+				}
+			}
+			if err := pm.PublishPackage(registryName); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		case "login":
+			if len(args) < 4 {
+				log.Fatal("Usage: sentra pkg login <registry> <token>")
+			}
+			if err := pm.Login(args[2], args[3]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		case "registries":
+			if err := pm.ListRegistries(); err != nil {
 				log.Fatalf("Error: %v", err)
 			}
 		case "list":
@@ -162,7 +201,14 @@ func main() {
 	}
 
 	if cmd == "repl" {
-		repl.Start()
+		repl.Start(func(registerVM *vmregister.RegisterVM) {
+			configureModules(registerVM, "<repl>")
+		})
+		return
+	}
+
+	if cmd == "dap" {
+		runDAP()
 		return
 	}
 
@@ -176,18 +222,33 @@ func main() {
 		return
 	}
 
-	if cmd == "check" && len(args) > 1 {
-		checkSyntax(args[1])
+	if cmd == "bench" {
+		runBenchmarks(args[1:])
+		return
+	}
+
+	if cmd == "profile" {
+		analyzeProfile(args[1:])
+		return
+	}
+
+	if cmd == "disasm" {
+		disassembleFile(args[1:])
 		return
 	}
 
-	if cmd == "fmt" && len(args) > 1 {
-		formatCode(args[1])
+	if cmd == "check" {
+		checkSyntax(args[1:])
 		return
 	}
 
-	if cmd == "lint" && len(args) > 1 {
-		lintCode(args[1])
+	if cmd == "fmt" {
+		formatCode(args[1:])
+		return
+	}
+
+	if cmd == "lint" {
+		lintCode(args[1:])
 		return
 	}
 
@@ -197,13 +258,51 @@ func main() {
 	}
 
 	if cmd == "run" && len(args) > 1 {
-		// Filter out optimization flags from file arguments
-		var filename string
-		for _, arg := range args[1:] {
+		// Filter out optimization flags from file arguments. Everything
+		// after the file name is passed to the script as args.
+		var filename, profileFile, recordFile, replayFile string
+		var scriptArgs []string
+		var runLimits limits.Limits
+		permissions := sandbox.NewPolicy()
+		trace := false
+		for i := 1; i < len(args); i++ {
+			arg := args[i]
+			if arg == "--profile" && i+1 < len(args) {
+				profileFile = args[i+1]
+				i++
+				continue
+			}
+			if arg == "--record" && i+1 < len(args) {
+				recordFile = args[i+1]
+				i++
+				continue
+			}
+			if arg == "--replay" && i+1 < len(args) {
+				replayFile = args[i+1]
+				i++
+				continue
+			}
+			if (arg == "--max-instructions" || arg == "--max-memory" || arg == "--timeout") && i+1 < len(args) {
+				if err := parseLimit(&runLimits, arg, args[i+1]); err != nil {
+					log.Fatal(err)
+				}
+				i++
+				continue
+			}
+			if arg == "--trace" {
+				trace = true
+				continue
+			}
+			if ok, err := permissions.ParseFlag(arg); err != nil {
+				log.Fatal(err)
+			} else if ok {
+				continue
+			}
 			if arg != "--production" && arg != "-p" && arg != "--fast" && arg != "-f" &&
 			   arg != "--hotfix" && arg != "-h" && arg != "--super" && arg != "-s" &&
 			   arg != "--stackfix" && arg != "--sf" && arg != "--oldvm" && arg != "--stack" {
 				filename = arg
+				scriptArgs = args[i+1:]
 				break
 			}
 		}
@@ -233,6 +332,10 @@ func main() {
 		// Create scanner with file information
 		scanner := lexer.NewScannerWithFile(string(fullSource), filename)
 		tokens := scanner.ScanTokens()
+		if len(scanner.Errors) > 0 {
+			fmt.Fprintf(os.Stderr, "%s\n", scanner.Errors[0].Error())
+			os.Exit(1)
+		}
 
 		// --- And here ---
 		// fmt.Println("===== TOKENS =====")
@@ -267,7 +370,7 @@ func main() {
 
 		// Check if using old stack-based VM
 		useOldVM := false
-		for _, arg := range os.Args {
+		for _, arg := range args[1 : len(args)-len(scriptArgs)] {
 			if arg == "--oldvm" || arg == "--stack" {
 				useOldVM = true
 				break
@@ -275,36 +378,89 @@ func main() {
 		}
 
 		var result interface{}
+		exitCode := 0
+
+		if useOldVM && profileFile != "" {
+			log.Fatal("--profile is not supported by the stack VM")
+		}
+
+		// Record the builtins that depend on the outside world, or play
+		// them back from an earlier recording
+		var session replay.Session
+		var recorder *replay.Recorder
+		var player *replay.Player
+		switch {
+		case recordFile != "" && replayFile != "":
+			log.Fatal("--record and --replay cannot be used together")
+		case recordFile != "":
+			recorder = replay.NewRecorder(filename, source, scriptArgs)
+			session = recorder
+		case replayFile != "":
+			player, err = replay.Load(replayFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !player.Matches(source) {
+				fmt.Fprintf(os.Stderr, "warning: %s has changed since it was recorded in %s\n", filename, replayFile)
+			}
+			if len(scriptArgs) == 0 {
+				scriptArgs = player.Log().Args
+			}
+			session = player
+		}
 
 		if useOldVM {
 			// Use old stack-based VM for compatibility
 			hc := compiler.NewHoistingCompilerWithDebug(filename)
+			hc.SetLines(p.StmtLines())
 			chunk := hc.CompileWithHoisting(stmts)
+			if len(hc.Errors) > 0 {
+				log.Fatalf("Compilation error: %v", hc.Errors[0])
+			}
 			enhancedVM := vm.NewVM(chunk)
 			enhancedVM.SetFilePath(filename)
+			enhancedVM.SetArgs(scriptArgs)
+			if session != nil {
+				enhancedVM.SetReplay(session)
+			}
+			if permissions.Restricted() {
+				enhancedVM.SetPermissions(permissions)
+			}
+			if trace {
+				enhancedVM.SetTrace(os.Stderr)
+			}
+			if runLimits != (limits.Limits{}) {
+				enhancedVM.StartLimits(runLimits)
+			}
 			result, err = enhancedVM.Run()
+			enhancedVM.StopLimits()
 		} else {
 			// Use new register-based VM with JIT (default)
 			// IMPORTANT: Create VM first so it registers all built-in functions
 			registerVM := vmregister.NewRegisterVM()
-
-			// Set up module loader for file-based imports
-			registerVM.SetModuleLoader(createModuleLoader())
-			registerVM.SetCurrentFile(filename)
-
-			// Set up module search paths (current directory and lib directory)
-			absPath, _ := filepath.Abs(filename)
-			modulePaths := []string{
-				filepath.Dir(absPath),         // Directory containing the main file
-				".",                           // Current working directory
-				filepath.Join(filepath.Dir(absPath), "lib"), // lib subdirectory
+			registerVM.SetArgs(scriptArgs)
+			if session != nil {
+				registerVM.SetReplay(session)
+			}
+			if permissions.Restricted() {
+				registerVM.SetPermissions(permissions)
 			}
-			registerVM.SetModulePaths(modulePaths)
+			if profileFile != "" {
+				// Statement probes give the profile line numbers
+				registerVM.SetCoverage(vmregister.NewCoverage())
+			}
+
+			// Set up module loader, search paths and package imports
+			configureModules(registerVM, filename)
 
 			// Get the VM's global name mappings to pass to the compiler
 			// This ensures the compiler uses the same IDs as the VM
 			globalNames, nextID := registerVM.GetGlobalNames()
 			c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+			c.SetLines(p.StmtLines())
+			if coverage := registerVM.Coverage(); coverage != nil {
+				c.EnableCoverage(coverage, filename, p.StmtLines())
+			}
 
 			mainFn, compileErr := c.Compile(stmts)
 			if compileErr != nil {
@@ -312,7 +468,38 @@ func main() {
 			}
 
 			// Run compiled code
+			if profileFile != "" {
+				registerVM.StartProfile(10 * time.Millisecond)
+			}
+			if trace {
+				registerVM.SetTrace(os.Stderr)
+			}
+			if runLimits != (limits.Limits{}) {
+				registerVM.StartLimits(runLimits)
+			}
 			result, err = registerVM.Execute(mainFn, nil)
+			if exitErr, ok := err.(*vmregister.ExitError); ok {
+				// exit() or a signal the script does not handle
+				exitCode, err = exitErr.Code, nil
+			}
+			// Cleanup handlers run however the script ended
+			if handlerErr := registerVM.RunExitHandlers(); handlerErr != nil && err == nil {
+				err = handlerErr
+			}
+			registerVM.StopLimits()
+			if profileFile != "" {
+				writeProfile(registerVM.StopProfile(), profileFile)
+			}
+		}
+		if recorder != nil {
+			// Kept however the script ended, as a failed run is often
+			// the one worth investigating
+			if saveErr := recorder.Save(recordFile); saveErr != nil {
+				log.Fatalf("Could not write %s: %v", recordFile, saveErr)
+			}
+		}
+		if player != nil && err == nil && player.Remaining() > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %d recorded calls were not replayed\n", player.Remaining())
 		}
 		if err != nil {
 			if sentraErr, ok := err.(*errors.SentraError); ok {
@@ -324,6 +511,9 @@ func main() {
 		}
 		// Don't print the result unless it's meaningful
 		_ = result		
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
 		return
 	}
 
@@ -352,6 +542,10 @@ func createModuleLoader() vmregister.ModuleLoader {
 		// Compile the module using VM's global names for consistency
 		globalNames, nextID := vm.GetGlobalNames()
 		c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+		c.SetLines(p.StmtLines())
+		if coverage := vm.Coverage(); coverage != nil {
+			c.EnableCoverage(coverage, modulePath, p.StmtLines())
+		}
 
 		fn, err := c.Compile(stmts)
 		if err != nil {
@@ -362,165 +556,335 @@ func createModuleLoader() vmregister.ModuleLoader {
 	}
 }
 
-func checkSyntax(filename string) {
-	source, err := os.ReadFile(filename)
+// sourceFiles expands the file, directory and glob arguments of check, lint
+// and fmt, defaulting to the current directory
+func sourceFiles(paths []string) []string {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := packages.SourceFiles(paths)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	return files
+}
 
-	// Create scanner with file information
-	scanner := lexer.NewScannerWithFile(string(source), filename)
-	tokens := scanner.ScanTokens()
+// parallel calls fn for 0..n-1 on all CPUs and waits for every call
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
 
-	// Check for lexer errors
-	if scanner.HadError() {
-		fmt.Fprintf(os.Stderr, "Syntax errors found in %s\n", filename)
+// parseOutputFormat handles the --format option shared by check and lint
+func parseOutputFormat(arg string, next func() string) (string, bool) {
+	var format string
+	switch {
+	case arg == "--format":
+		format = next()
+	case strings.HasPrefix(arg, "--format="):
+		format = strings.TrimPrefix(arg, "--format=")
+	default:
+		return "", false
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (use text or json)\n", format)
 		os.Exit(1)
 	}
+	return format, true
+}
 
-	// Create parser with source for error reporting
-	p := parser.NewParserWithSource(tokens, string(source), filename)
-	
-	// Try to parse
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if err, ok := r.(*errors.SentraError); ok {
-					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-					os.Exit(1)
-				} else if err, ok := r.(error); ok {
-					fmt.Fprintf(os.Stderr, "Syntax error: %v\n", err)
-					os.Exit(1)
-				} else {
-					fmt.Fprintf(os.Stderr, "Syntax error: %v\n", r)
-					os.Exit(1)
-				}
-			}
-		}()
-		p.Parse()
-	}()
-
-	// If we get here, syntax is valid
-	fmt.Printf("%s: syntax is valid\n", filename)
-	os.Exit(0)
+// jsonError is a parse failure in --format json output
+type jsonError struct {
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
 }
 
-func lintCode(filename string) {
-	source, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
+func toJSONError(err error) *jsonError {
+	if err == nil {
+		return nil
 	}
+	if se, ok := err.(*errors.SentraError); ok {
+		return &jsonError{Message: se.Message, Line: se.Location.Line, Column: se.Location.Column}
+	}
+	return &jsonError{Message: err.Error()}
+}
 
-	// Parse the code
-	scanner := lexer.NewScannerWithFile(string(source), filename)
+func printJSON(v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(out))
+}
+
+// parseSource reports the first syntax error in a file, if any
+func parseSource(source, filename string) (err error) {
+	scanner := lexer.NewScannerWithFile(source, filename)
 	tokens := scanner.ScanTokens()
-	
+	if len(scanner.Errors) > 0 {
+		return scanner.Errors[0]
+	}
 	if scanner.HadError() {
-		fmt.Fprintf(os.Stderr, "Syntax errors found, cannot lint\n")
-		os.Exit(1)
+		return fmt.Errorf("Syntax errors found in %s", filename)
 	}
 
-	p := parser.NewParserWithSource(tokens, string(source), filename)
-	
-	var stmts []parser.Stmt
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "Parse error: %v\n", r)
-				os.Exit(1)
+	p := parser.NewParserWithSource(tokens, source, filename)
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("Syntax error: %v", r)
 			}
-		}()
-		stmts = p.Parse()
+		}
 	}()
+	p.Parse()
+	if len(p.Errors) > 0 {
+		return p.Errors[0]
+	}
+	return nil
+}
 
-	// Perform linting checks
-	warnings := 0
-	errors := 0
-	
-	// Check for unused variables (simplified)
-	declaredVars := make(map[string]bool)
-	usedVars := make(map[string]bool)
-	
-	// Walk through statements to find declarations and usage
-	var walkStmt func(parser.Stmt)
-	var walkExpr func(parser.Expr)
-	
-	walkExpr = func(expr parser.Expr) {
-		if expr == nil {
+func checkSyntax(args []string) {
+	format := "text"
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		next := func() string {
+			i++
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+		if f, ok := parseOutputFormat(args[i], next); ok {
+			format = f
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			fmt.Fprintf(os.Stderr, "Unknown check option: %s\n", args[i])
+			os.Exit(1)
+		}
+		paths = append(paths, args[i])
+	}
+	files := sourceFiles(paths)
+
+	results := make([]error, len(files))
+	parallel(len(files), func(i int) {
+		source, err := os.ReadFile(files[i])
+		if err != nil {
+			results[i] = fmt.Errorf("Error reading file: %v", err)
 			return
 		}
-		switch e := expr.(type) {
-		case *parser.Variable:
-			usedVars[e.Name] = true
-		case *parser.Binary:
-			walkExpr(e.Left)
-			walkExpr(e.Right)
-		case *parser.CallExpr:
-			walkExpr(e.Callee)
-			for _, arg := range e.Args {
-				walkExpr(arg)
-			}
-		case *parser.Assign:
-			// Assignment uses the variable
-			usedVars[e.Name] = true
-			walkExpr(e.Value)
+		results[i] = parseSource(string(source), files[i])
+	})
+
+	invalid := 0
+	for _, err := range results {
+		if err != nil {
+			invalid++
 		}
 	}
-	
-	walkStmt = func(stmt parser.Stmt) {
-		switch s := stmt.(type) {
-		case *parser.LetStmt:
-			declaredVars[s.Name] = true
-			walkExpr(s.Expr)
-		case *parser.FunctionStmt:
-			// Don't check function names as unused
-			for _, bodyStmt := range s.Body {
-				walkStmt(bodyStmt)
+
+	if format == "json" {
+		type fileResult struct {
+			File  string     `json:"file"`
+			Valid bool       `json:"valid"`
+			Error *jsonError `json:"error,omitempty"`
+		}
+		report := struct {
+			Files   []fileResult `json:"files"`
+			Summary struct {
+				Files   int `json:"files"`
+				Invalid int `json:"invalid"`
+			} `json:"summary"`
+		}{Files: []fileResult{}}
+		for i, file := range files {
+			report.Files = append(report.Files, fileResult{File: file, Valid: results[i] == nil, Error: toJSONError(results[i])})
+		}
+		report.Summary.Files = len(files)
+		report.Summary.Invalid = invalid
+		printJSON(report)
+	} else {
+		for i, file := range files {
+			if results[i] == nil {
+				if len(files) == 1 {
+					fmt.Printf("%s: syntax is valid\n", file)
+				}
+				continue
+			}
+			if _, ok := results[i].(*errors.SentraError); ok {
+				fmt.Fprintf(os.Stderr, "%s\n", results[i].Error())
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", file, results[i])
 			}
-		case *parser.ExpressionStmt:
-			walkExpr(s.Expr)
-		case *parser.IfStmt:
-			walkExpr(s.Condition)
-			for _, thenStmt := range s.Then {
-				walkStmt(thenStmt)
+		}
+		if len(files) > 1 {
+			if invalid > 0 {
+				fmt.Printf("\nChecked %d files: %d with syntax errors\n", len(files), invalid)
+			} else {
+				fmt.Printf("Checked %d files: syntax is valid\n", len(files))
 			}
-			for _, elseStmt := range s.Else {
-				walkStmt(elseStmt)
+		}
+	}
+
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+func lintCode(args []string) {
+	format := "text"
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		next := func() string {
+			i++
+			if i < len(args) {
+				return args[i]
 			}
-		case *parser.WhileStmt:
-			walkExpr(s.Condition)
-			for _, bodyStmt := range s.Body {
-				walkStmt(bodyStmt)
+			return ""
+		}
+		if f, ok := parseOutputFormat(args[i], next); ok {
+			format = f
+			continue
+		}
+		switch arg := args[i]; {
+		case arg == "--list-rules":
+			for _, rule := range lint.Rules() {
+				fmt.Printf("  %-24s %-8s %s\n", rule.ID, rule.Severity, rule.Description)
 			}
-		case *parser.ReturnStmt:
-			walkExpr(s.Value)
+			return
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "Unknown lint option: %s\n", arg)
+			os.Exit(1)
+		default:
+			paths = append(paths, arg)
 		}
 	}
-	
-	for _, stmt := range stmts {
-		walkStmt(stmt)
+	files := sourceFiles(paths)
+
+	type fileResult struct {
+		File        string            `json:"file"`
+		Error       *jsonError        `json:"error,omitempty"`
+		Diagnostics []lint.Diagnostic `json:"diagnostics"`
+		err         error
 	}
-	
-	// Report unused variables
-	for varName := range declaredVars {
-		if !usedVars[varName] && !strings.HasPrefix(varName, "_") {
-			fmt.Printf("Warning: Variable '%s' is declared but never used\n", varName)
-			warnings++
+	results := make([]fileResult, len(files))
+	parallel(len(files), func(i int) {
+		result := fileResult{File: files[i], Diagnostics: []lint.Diagnostic{}}
+		defer func() { results[i] = result }()
+
+		source, err := os.ReadFile(files[i])
+		if err != nil {
+			result.err = fmt.Errorf("Error reading file: %v", err)
+			return
+		}
+		config, err := lint.LoadConfig(files[i])
+		if err != nil {
+			result.err = err
+			return
+		}
+		diags, err := lint.Lint(string(source), files[i], config)
+		if err != nil {
+			result.err = err
+			return
+		}
+		result.Diagnostics = append(result.Diagnostics, diags...)
+	})
+
+	var errorCount, warnings, infos, failed int
+	for i := range results {
+		if results[i].err != nil {
+			failed++
+			results[i].Error = toJSONError(results[i].err)
+		}
+		for _, d := range results[i].Diagnostics {
+			switch d.Severity {
+			case lint.Error:
+				errorCount++
+			case lint.Warning:
+				warnings++
+			case lint.Info:
+				infos++
+			}
 		}
 	}
-	
-	// Check for other issues
-	// TODO: Add more linting rules
-	
-	if errors > 0 {
-		fmt.Printf("\n%s: %d errors, %d warnings\n", filename, errors, warnings)
-		os.Exit(1)
-	} else if warnings > 0 {
-		fmt.Printf("\n%s: %d warnings\n", filename, warnings)
+
+	if format == "json" {
+		report := struct {
+			Files   []fileResult `json:"files"`
+			Summary struct {
+				Files    int `json:"files"`
+				Failed   int `json:"failed"`
+				Errors   int `json:"errors"`
+				Warnings int `json:"warnings"`
+				Infos    int `json:"infos"`
+			} `json:"summary"`
+		}{Files: results}
+		report.Summary.Files = len(files)
+		report.Summary.Failed = failed
+		report.Summary.Errors = errorCount
+		report.Summary.Warnings = warnings
+		report.Summary.Infos = infos
+		printJSON(report)
 	} else {
-		fmt.Printf("%s: no issues found\n", filename)
+		// One group per file with findings, separated by blank lines
+		first := true
+		for _, result := range results {
+			if result.err == nil && len(result.Diagnostics) == 0 {
+				continue
+			}
+			if !first {
+				fmt.Println()
+			}
+			first = false
+			if result.err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", result.File, result.err)
+				continue
+			}
+			for _, d := range result.Diagnostics {
+				fmt.Println(d)
+				if d.Hint != "" {
+					fmt.Printf("    hint: %s\n", d.Hint)
+				}
+			}
+		}
+
+		switch {
+		case len(files) > 1:
+			if first {
+				fmt.Printf("Linted %d files: no issues found\n", len(files))
+			} else {
+				fmt.Printf("\nLinted %d files: %d errors, %d warnings", len(files), errorCount, warnings)
+				if failed > 0 {
+					fmt.Printf(", %d files could not be linted", failed)
+				}
+				fmt.Println()
+			}
+		case failed > 0:
+		case errorCount > 0:
+			fmt.Printf("\n%s: %d errors, %d warnings\n", files[0], errorCount, warnings)
+		case len(results[0].Diagnostics) > 0:
+			fmt.Printf("\n%s: %d warnings\n", files[0], warnings)
+		default:
+			fmt.Printf("%s: no issues found\n", files[0])
+		}
+	}
+
+	if errorCount > 0 || failed > 0 {
+		os.Exit(1)
 	}
 }
 
@@ -528,9 +892,12 @@ func generateDocs(args []string) {
 	// Parse options
 	outputDir := "./docs"
 	var files []string
+	withBuiltins := false
 	
 	for i := 0; i < len(args); i++ {
-		if args[i] == "-o" || args[i] == "--output" {
+		if args[i] == "--builtins" {
+			withBuiltins = true
+		} else if args[i] == "-o" || args[i] == "--output" {
 			if i+1 < len(args) {
 				outputDir = args[i+1]
 				i++ // Skip next arg
@@ -550,7 +917,7 @@ func generateDocs(args []string) {
 		files = matches
 	}
 	
-	if len(files) == 0 {
+	if len(files) == 0 && !withBuiltins {
 		fmt.Println("No Sentra files found to document")
 		return
 	}
@@ -561,6 +928,19 @@ func generateDocs(args []string) {
 		os.Exit(1)
 	}
 	
+	// The builtin reference comes from the same registry both VMs use
+	if withBuiltins {
+		builtinsFile := filepath.Join(outputDir, "builtins.md")
+		if err := os.WriteFile(builtinsFile, []byte(builtins.Reference()), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing builtin reference: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Printf("Builtin reference written to %s\n", builtinsFile)
+			return
+		}
+	}
+	
 	// Generate documentation for each file
 	for _, file := range files {
 		generateFileDoc(file, outputDir)
@@ -610,7 +990,7 @@ func generateFileDoc(filename, outputDir string) {
 	walkStmt = func(stmt parser.Stmt) {
 		switch s := stmt.(type) {
 		case *parser.FunctionStmt:
-			sig := fmt.Sprintf("fn %s(%s)", s.Name, strings.Join(s.Params, ", "))
+			sig := fmt.Sprintf("fn %s(%s)", s.Name, strings.Join(s.ParamNames(), ", "))
 			functions = append(functions, sig)
 		}
 	}
@@ -651,46 +1031,70 @@ func generateIndexDoc(files []string, outputDir string) {
 	}
 }
 
-func formatCode(filename string) {
-	source, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
+// formatCode implements sentra fmt [--check] [--diff] [files or directories...].
+// Files are rewritten in place unless --check or --diff is given; both exit
+// with status 1 when any file is not formatted.
+func formatCode(args []string) {
+	check, diff := false, false
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "--check":
+			check = true
+		case "--diff":
+			diff = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "Unknown fmt option: %s\n", arg)
+				os.Exit(1)
+			}
+			paths = append(paths, arg)
+		}
 	}
+	files := sourceFiles(paths)
 
-	// Parse the code first to ensure it's valid
-	scanner := lexer.NewScannerWithFile(string(source), filename)
-	tokens := scanner.ScanTokens()
-	
-	if scanner.HadError() {
-		fmt.Fprintf(os.Stderr, "Cannot format file with syntax errors\n")
-		os.Exit(1)
-	}
+	failed, unformatted := false, false
+	for _, filename := range files {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			failed = true
+			continue
+		}
+		config, err := formatter.LoadConfig(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error in formatter config: %v\n", err)
+			os.Exit(1)
+		}
 
-	p := parser.NewParserWithSource(tokens, string(source), filename)
-	
-	var stmts []parser.Stmt
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "Cannot format file with syntax errors: %v\n", r)
-				os.Exit(1)
+		formatted, err := formatter.FormatSource(string(source), filename, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			failed = true
+			continue
+		}
+		if formatted == string(source) {
+			continue
+		}
+		unformatted = true
+
+		if diff {
+			fmt.Print(formatter.Diff(filename, string(source), formatted))
+		} else if check {
+			fmt.Println(filename)
+		} else {
+			if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing formatted file: %v\n", err)
+				failed = true
+				continue
 			}
-		}()
-		stmts = p.Parse()
-	}()
+			fmt.Printf("%s: formatted successfully\n", filename)
+		}
+	}
 
-	// Format the code
-	formatter := formatter.NewFormatter()
-	formatted := formatter.Format(stmts)
-	
-	// Write the formatted code back to the file
-	if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing formatted file: %v\n", err)
+	if failed || ((check || diff) && unformatted) {
 		os.Exit(1)
 	}
-	
-	fmt.Printf("%s: formatted successfully\n", filename)
 }
 
 func runWithDebugger(args []string) {
@@ -737,6 +1141,10 @@ func runWithDebugger(args []string) {
 	// Compile with debug information
 	compiler := compiler.NewStmtCompilerWithDebug(filename)
 	chunk := compiler.Compile(stmts)
+	if len(compiler.Errors) > 0 {
+		fmt.Fprintf(os.Stderr, "Compilation error: %v\n", compiler.Errors[0])
+		os.Exit(1)
+	}
 
 	// Create VM and debugger
 	enhancedVM := vm.NewVM(chunk)
@@ -772,97 +1180,550 @@ func runWithDebugger(args []string) {
 	fmt.Println("\nProgram execution completed")
 }
 
+// runDAP serves the Debug Adapter Protocol on stdio for editors
+func runDAP() {
+	// stdout carries the protocol, so diagnostics must go to stderr
+	log.SetOutput(os.Stderr)
+	server := debugger.NewDAPServer(os.Stdin, os.Stdout, loadDebugProgram)
+	if err := server.Serve(); err != nil {
+		log.Fatalf("dap: %v", err)
+	}
+}
+
+// loadDebugProgram compiles a program on the register VM with a debug probe
+// before each statement. Modules it imports are instrumented by the module loader.
+func loadDebugProgram(program string) (*vmregister.RegisterVM, *vmregister.FunctionObj, error) {
+	program, err := filepath.Abs(program)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, err := os.ReadFile(program)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	var stmts []parser.Stmt
+	var lines map[parser.Stmt]int
+	parseErr := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		scanner := lexer.NewScannerWithFile(string(source), program)
+		p := parser.NewParserWithSource(scanner.ScanTokens(), string(source), program)
+		stmts = p.Parse()
+		lines = p.StmtLines()
+		return nil
+	}()
+	if parseErr != nil {
+		return nil, nil, parseErr
+	}
+	
+	registerVM := vmregister.NewRegisterVM()
+	configureModules(registerVM, program)
+	registerVM.SetCoverage(vmregister.NewDebugCoverage())
+	
+	globalNames, nextID := registerVM.GetGlobalNames()
+	c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+	c.EnableCoverage(registerVM.Coverage(), program, lines)
+	mainFn, err := c.Compile(stmts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compilation error: %w", err)
+	}
+	return registerVM, mainFn, nil
+}
+
 func runTests(args []string) {
-	var testFiles []string
+	config := &testing.TestConfig{
+		Timeout:      30 * time.Second,
+		OutputFormat: "text",
+	}
+	var patterns []string
+	outputFile := ""
+	lcovFile, htmlFile := "", ""
 	
-	if len(args) == 0 {
-		// Discover test files in current directory
-		matches, err := testing.DiscoverTests(".", "*_test.sn")
-		if err != nil {
-			log.Fatalf("Error discovering tests: %v", err)
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--verbose" || arg == "-v":
+			config.Verbose = true
+		case arg == "--failfast":
+			config.FailFast = true
+		case arg == "--timeout" && i+1 < len(args):
+			timeout, err := time.ParseDuration(args[i+1])
+			if err != nil {
+				log.Fatalf("Invalid --timeout: %v", err)
+			}
+			config.Timeout = timeout
+			i++
+		case arg == "--run" && i+1 < len(args):
+			config.Filter = args[i+1]
+			i++
+		case arg == "--format" && i+1 < len(args):
+			config.OutputFormat = args[i+1]
+			i++
+		case (arg == "--output" || arg == "-o") && i+1 < len(args):
+			outputFile = args[i+1]
+			i++
+		case arg == "--cover":
+			config.Coverage = true
+		case arg == "--coverprofile" && i+1 < len(args):
+			config.Coverage = true
+			lcovFile = args[i+1]
+			i++
+		case arg == "--coverhtml" && i+1 < len(args):
+			config.Coverage = true
+			htmlFile = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "-"):
+			log.Fatalf("Unknown test flag: %s", arg)
+		default:
+			patterns = append(patterns, arg)
 		}
-		testFiles = matches
-		
-		if len(testFiles) == 0 {
-			fmt.Println("No test files found (looking for *_test.sn)")
-			return
+	}
+	
+	switch config.OutputFormat {
+	case "text", "json", "junit", "tap":
+	default:
+		log.Fatalf("Unknown test format: %s (expected text, json, junit or tap)", config.OutputFormat)
+	}
+	if outputFile != "" {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			log.Fatalf("Cannot create report file: %v", err)
 		}
-	} else {
-		// Run specific test files
-		for _, pattern := range args {
-			matches, err := filepath.Glob(pattern)
+		defer f.Close()
+		config.Output = f
+	}
+	
+	var testFiles []string
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			// Discover test files under the directory
+			matches, err := testing.DiscoverTests(pattern, "*_test.sn")
 			if err != nil {
-				log.Fatalf("Error finding test files: %v", err)
+				log.Fatalf("Error discovering tests: %v", err)
 			}
 			testFiles = append(testFiles, matches...)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Fatalf("Error finding test files: %v", err)
 		}
+		testFiles = append(testFiles, matches...)
 	}
 	
-	fmt.Printf("Running %d test file(s)...\n", len(testFiles))
+	if len(testFiles) == 0 {
+		fmt.Println("No test files found (looking for *_test.sn)")
+		return
+	}
 	
-	// Create test runner (not used in simplified version)
-	// config := &testing.TestConfig{
-	// 	Verbose:      true,
-	// 	OutputFormat: "text",
-	// 	FailFast:     false,
-	// }
-	// runner := testing.NewTestRunner(config)
+	var coverage *vmregister.Coverage
+	if config.Coverage {
+		coverage = vmregister.NewCoverage()
+	}
 	
-	// Process each test file
+	runner := testing.NewTestRunner(config)
 	for _, testFile := range testFiles {
-		fmt.Printf("\nLoading test file: %s\n", testFile)
-		
-		source, err := os.ReadFile(testFile)
+		suite, err := testing.LoadSuite(testFile, func(registerVM *vmregister.RegisterVM) {
+			configureModules(registerVM, testFile)
+			registerVM.SetCoverage(coverage)
+		})
 		if err != nil {
-			log.Printf("Error reading test file %s: %v", testFile, err)
-			continue
+			suite = testing.ErrorSuite(testFile, err)
 		}
-		
-		// Parse and compile the test file
-		scanner := lexer.NewScannerWithFile(string(source), testFile)
-		tokens := scanner.ScanTokens()
-		p := parser.NewParserWithSource(tokens, string(source), testFile)
-		
-		var stmts []interface{}
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					if err, ok := r.(*errors.SentraError); ok {
-						fmt.Fprintf(os.Stderr, "Parse error in %s:\n%s\n", testFile, err.Error())
-					} else {
-						fmt.Fprintf(os.Stderr, "Parse error in %s: %v\n", testFile, r)
-					}
+		runner.AddSuite(suite)
+	}
+	
+	stats := runner.Run()
+	if coverage != nil {
+		writeCoverageReports(coverage, lcovFile, htmlFile)
+	}
+	if outputFile != "" {
+		fmt.Printf("%d passed, %d failed, %d skipped; report written to %s\n",
+			stats.PassedTests, stats.FailedTests, stats.SkippedTests, outputFile)
+	}
+	if stats.FailedTests > 0 {
+		if f, ok := config.Output.(*os.File); ok {
+			f.Close() // os.Exit skips deferred calls
+		}
+		os.Exit(1)
+	}
+}
+
+func runBenchmarks(args []string) {
+	config := &testing.BenchConfig{
+		BenchTime: time.Second,
+		Count:     5,
+		Warmup:    100 * time.Millisecond,
+		Threshold: 10,
+	}
+	var patterns []string
+	saveFile, baselineFile := "", ""
+	
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--time" && i+1 < len(args):
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				log.Fatalf("Invalid --time: %s", args[i+1])
+			}
+			config.BenchTime = d
+			i++
+		case arg == "--count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid --count: %s", args[i+1])
+			}
+			config.Count = n
+			i++
+		case arg == "--warmup" && i+1 < len(args):
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil {
+				log.Fatalf("Invalid --warmup: %v", err)
+			}
+			config.Warmup = d
+			i++
+		case arg == "--run" && i+1 < len(args):
+			config.Filter = args[i+1]
+			i++
+		case arg == "--save" && i+1 < len(args):
+			saveFile = args[i+1]
+			i++
+		case arg == "--baseline" && i+1 < len(args):
+			baselineFile = args[i+1]
+			i++
+		case arg == "--threshold" && i+1 < len(args):
+			t, err := strconv.ParseFloat(strings.TrimSuffix(args[i+1], "%"), 64)
+			if err != nil || t < 0 {
+				log.Fatalf("Invalid --threshold: %s", args[i+1])
+			}
+			config.Threshold = t
+			i++
+		case strings.HasPrefix(arg, "-"):
+			log.Fatalf("Unknown bench flag: %s", arg)
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+	
+	var baseline testing.BenchBaseline
+	if baselineFile != "" {
+		var err error
+		if baseline, err = testing.ReadBenchBaseline(baselineFile); err != nil {
+			log.Fatalf("Cannot read baseline: %v", err)
+		}
+	}
+	
+	var benchFiles []string
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			for _, glob := range []string{"*_bench.sn", "*_test.sn"} {
+				matches, err := testing.DiscoverTests(pattern, glob)
+				if err != nil {
+					log.Fatalf("Error discovering benchmarks: %v", err)
 				}
-			}()
-			parsed := p.Parse()
-			for _, s := range parsed {
-				stmts = append(stmts, s)
+				benchFiles = append(benchFiles, matches...)
 			}
-		}()
-		
-		if len(stmts) == 0 {
 			continue
 		}
-		
-		// Compile with debug information
-		c := compiler.NewStmtCompilerWithDebug(testFile)
-		chunk := c.Compile(stmts)
-		
-		// Create VM (testing functions are already included in stdlib)
-		enhancedVM := vm.NewVM(chunk)
-		enhancedVM.SetFilePath(testFile)
-		
-		// Run the test file
-		_, err = enhancedVM.Run()
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Fatalf("Error finding benchmark files: %v", err)
+		}
+		benchFiles = append(benchFiles, matches...)
+	}
+	
+	var results []testing.BenchResult
+	for _, benchFile := range benchFiles {
+		benchmarks, closeFile, err := testing.LoadBenchmarks(benchFile, func(registerVM *vmregister.RegisterVM) {
+			configureModules(registerVM, benchFile)
+		})
+		if err != nil {
+			results = append(results, testing.BenchResult{Name: benchFile, Error: err.Error()})
+			continue
+		}
+		for _, b := range benchmarks {
+			if config.Filter != "" && !strings.Contains(b.Name, config.Filter) {
+				continue
+			}
+			results = append(results, testing.RunBenchmark(b, config))
+		}
+		closeFile()
+	}
+	
+	if len(results) == 0 {
+		fmt.Println("No benchmarks found (looking for bench_* functions in *_bench.sn and *_test.sn)")
+		return
+	}
+	
+	regressions := testing.PrintBenchResults(os.Stdout, results, baseline, config.Threshold)
+	failed := len(regressions) > 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed = true
+		}
+	}
+	
+	if saveFile != "" {
+		if err := testing.WriteBenchBaseline(saveFile, results); err != nil {
+			log.Fatalf("Cannot save baseline: %v", err)
+		}
+		fmt.Printf("baseline written to %s\n", saveFile)
+	}
+	if len(regressions) > 0 {
+		fmt.Printf("\n%d benchmark(s) regressed by more than %.0f%% against %s\n", len(regressions), config.Threshold, baselineFile)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parseLimit sets the resource limit of a run flag such as --max-memory
+func parseLimit(l *limits.Limits, flag, value string) error {
+	switch flag {
+	case "--max-instructions":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || n == 0 {
+			return fmt.Errorf("--max-instructions: invalid count %q", value)
+		}
+		l.MaxInstructions = n
+	case "--max-memory":
+		n, err := limits.ParseSize(value)
+		if err != nil || n == 0 {
+			return fmt.Errorf("--max-memory: invalid size %q", value)
+		}
+		l.MaxMemory = n
+		// Collect garbage harder near the limit rather than fail early
+		debug.SetMemoryLimit(int64(n))
+	case "--timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("--timeout: invalid duration %q", value)
+		}
+		l.Timeout = d
+	}
+	return nil
+}
+
+// writeProfile saves a profile from run --profile, as folded stacks for
+// flame graph tools if the file ends in .folded or .txt and as pprof
+// otherwise
+func writeProfile(profile *profiler.Profile, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Cannot write profile: %v", err)
+	}
+	if ext := filepath.Ext(path); ext == ".folded" || ext == ".txt" {
+		err = profile.WriteFolded(f)
+	} else {
+		err = profile.WritePprof(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Cannot write profile: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "profile written to %s (%d samples)\n", path, profile.Total())
+}
+
+// analyzeProfile prints the functions that took the most samples in a
+// profile and can convert it to folded stacks for flame graphs
+func analyzeProfile(args []string) {
+	top, lines := 20, false
+	profileFile, foldedFile := "", ""
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--top" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				log.Fatalf("Invalid --top: %s", args[i+1])
+			}
+			top = n
+			i++
+		case arg == "--lines":
+			lines = true
+		case arg == "--folded" && i+1 < len(args):
+			foldedFile = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "-"):
+			log.Fatalf("Unknown profile flag: %s", arg)
+		case profileFile == "":
+			profileFile = arg
+		default:
+			log.Fatalf("Unexpected argument: %s", arg)
+		}
+	}
+	if profileFile == "" {
+		fmt.Println("Usage: sentra profile [--top n] [--lines] [--folded out.folded] <profile>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(profileFile)
+	if err != nil {
+		log.Fatalf("Cannot read profile: %v", err)
+	}
+	profile, err := profiler.Read(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Cannot read profile %s: %v", profileFile, err)
+	}
+
+	if foldedFile != "" {
+		out, err := os.Create(foldedFile)
+		if err != nil {
+			log.Fatalf("Cannot write folded stacks: %v", err)
+		}
+		err = profile.WriteFolded(out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Cannot write folded stacks: %v", err)
+		}
+		fmt.Printf("folded stacks written to %s\n", foldedFile)
+		return
+	}
+
+	total := profile.Total()
+	if total == 0 {
+		fmt.Println("The profile has no samples")
+		return
+	}
+	summary := fmt.Sprintf("%d samples", total)
+	if profile.Period > 0 {
+		summary += fmt.Sprintf(", %v", time.Duration(total)*profile.Period)
+	}
+	if profile.Duration > 0 {
+		summary += fmt.Sprintf(" over %v", profile.Duration.Round(time.Millisecond))
+	}
+	fmt.Println(summary)
+
+	stats := profile.Top(lines)
+	if top > 0 && len(stats) > top {
+		stats = stats[:top]
+	}
+	percent := func(n int64) float64 { return 100 * float64(n) / float64(total) }
+	fmt.Printf("%10s %7s %10s %7s  %s\n", "flat", "flat%", "cum", "cum%", "function")
+	for _, stat := range stats {
+		fmt.Printf("%10d %6.1f%% %10d %6.1f%%  %s\n", stat.Flat, percent(stat.Flat), stat.Cum, percent(stat.Cum), stat.Function)
+	}
+}
+
+// disassembleFile prints the bytecode a script compiles to, for the
+// register VM or, with --stack, for the stack VM
+func disassembleFile(args []string) {
+	useOldVM, filename := false, ""
+	for _, arg := range args {
+		switch {
+		case arg == "--stack" || arg == "--oldvm":
+			useOldVM = true
+		case strings.HasPrefix(arg, "-"):
+			log.Fatalf("Unknown disasm flag: %s", arg)
+		case filename == "":
+			filename = arg
+		default:
+			log.Fatalf("Unexpected argument: %s", arg)
+		}
+	}
+	if filename == "" {
+		fmt.Println("Usage: sentra disasm [--stack] <file.sn>")
+		os.Exit(1)
+	}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatalf("Could not read file: %v", err)
+	}
+	if err := parseSource(string(source), filename); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	scanner := lexer.NewScannerWithFile(string(source), filename)
+	p := parser.NewParserWithSource(scanner.ScanTokens(), string(source), filename)
+	stmts := p.Parse()
+
+	if useOldVM {
+		hc := compiler.NewHoistingCompilerWithDebug(filename)
+		hc.SetLines(p.StmtLines())
+		chunk := hc.CompileWithHoisting(stmts)
+		if len(hc.Errors) > 0 {
+			log.Fatalf("Compilation error: %v", hc.Errors[0])
+		}
+		err = compiler.Disassemble(os.Stdout, chunk)
+	} else {
+		registerVM := vmregister.NewRegisterVM()
+		globalNames, nextID := registerVM.GetGlobalNames()
+		c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+		c.SetLines(p.StmtLines())
+		mainFn, compileErr := c.Compile(stmts)
+		if compileErr != nil {
+			log.Fatalf("Compilation error: %v", compileErr)
+		}
+		err = registerVM.Disassemble(os.Stdout, mainFn)
+	}
+	if err != nil {
+		log.Fatalf("Cannot write listing: %v", err)
+	}
+}
+
+// writeCoverageReports prints a coverage summary and writes the optional
+// LCOV and HTML reports
+func writeCoverageReports(coverage *vmregister.Coverage, lcovFile, htmlFile string) {
+	files := testing.SummarizeCoverage(coverage)
+	fmt.Println()
+	testing.PrintCoverageSummary(os.Stdout, files)
+	
+	write := func(path string, fn func(io.Writer, []testing.FileCoverage) error) {
+		if path == "" {
+			return
+		}
+		f, err := os.Create(path)
+		if err == nil {
+			err = fn(f, files)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error running tests in %s: %v\n", testFile, err)
+			log.Fatalf("Cannot write coverage report: %v", err)
 		}
+		fmt.Printf("coverage report written to %s\n", path)
+	}
+	write(lcovFile, testing.WriteLCOV)
+	write(htmlFile, testing.WriteCoverageHTML)
+}
+
+// configureModules installs the module loader, search paths and package
+// resolver used to run filename on the register VM
+func configureModules(registerVM *vmregister.RegisterVM, filename string) {
+	registerVM.SetModuleLoader(createModuleLoader())
+	registerVM.SetCurrentFile(filename)
+	
+	absPath, _ := filepath.Abs(filename)
+	modulePaths := []string{
+		filepath.Dir(absPath),                       // Directory containing the main file
+		".",                                         // Current working directory
+		filepath.Join(filepath.Dir(absPath), "lib"), // lib subdirectory
 	}
 	
-	// Run all collected tests
-	// Note: In a full implementation, tests would be collected during VM execution
-	// and then run here. For now, we'll just show the summary.
-	fmt.Println("\nTest execution completed")
+	// Resolve package imports from the project's installed dependencies
+	pkgResolver := packages.NewModulePathResolver(filepath.Dir(absPath))
+	if err := pkgResolver.Err(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	registerVM.SetModuleResolver(pkgResolver.Resolve)
+	modulePaths = append(modulePaths, pkgResolver.SearchPaths()...)
+	registerVM.SetModulePaths(modulePaths)
 }
 
 func showUsage() {
@@ -871,11 +1732,15 @@ func showUsage() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  sentra run <file.sn>       Run a Sentra script              (alias: r)")
-	fmt.Println("  sentra check <file.sn>     Check syntax without running     (alias: c)")
-	fmt.Println("  sentra lint <file.sn>      Check for code quality issues    (alias: l)")
-	fmt.Println("  sentra fmt <file.sn>       Format Sentra code               (alias: f)")
+	fmt.Println("  sentra check [paths...]    Check syntax without running     (alias: c)")
+	fmt.Println("  sentra lint [paths...]     Check for code quality issues    (alias: l)")
+	fmt.Println("  sentra fmt [files...]      Format Sentra code               (alias: f)")
 	fmt.Println("  sentra debug <file.sn>     Debug a Sentra script            (alias: d)")
+	fmt.Println("  sentra dap                 Debug adapter for editors (stdio)")
 	fmt.Println("  sentra test [files...]     Run test files (*_test.sn)       (alias: t)")
+	fmt.Println("  sentra bench [files...]    Run benchmarks (bench_* functions)")
+	fmt.Println("  sentra profile <file>      Show the hot functions in a profile")
+	fmt.Println("  sentra disasm <file.sn>    Show the bytecode of a script")
 	fmt.Println("  sentra repl                Start interactive REPL           (alias: i)")
 	fmt.Println()
 	fmt.Println("Project Management:")
@@ -941,6 +1806,11 @@ func handlePackageCommands(args []string) {
 			}
 			
 		case "download":
+			for _, flag := range args[2:] {
+				if flag == "--locked" || flag == "--frozen" {
+					pm.SetLocked(true)
+				}
+			}
 			if err := pm.DownloadDependencies(); err != nil {
 				log.Fatalf("Error: %v", err)
 			}
@@ -1023,7 +1893,7 @@ func showVersion() {
 	fmt.Println("Architecture:")
 	fmt.Println("  Execution:    NaN-boxing + Template JIT")
 	fmt.Println("  Registers:    256 per function frame")
-	fmt.Println("  Optimizations: Instruction fusion, peephole")
+	fmt.Println("  Optimizations: Constant folding, dead code elimination, instruction fusion, peephole")
 	fmt.Println()
 
 	// Check for dev environment
@@ -1093,7 +1963,7 @@ func updateSentra() {
 // suggestCommand suggests similar commands when an unknown command is entered
 func suggestCommand(cmd string) {
 	allCommands := []string{
-		"run", "repl", "test", "check", "lint", "fmt", "debug",
+		"run", "repl", "test", "bench", "profile", "disasm", "check", "lint", "fmt", "debug", "dap",
 		"init", "build", "watch", "clean",
 		"mod", "get",
 		"help", "version", "completion",
@@ -1206,11 +2076,104 @@ DESCRIPTION:
 
 OPTIONS:
   --oldvm, --stack    Use the legacy stack-based VM for compatibility
+  --profile <file>    Sample the call stack 100 times a second and write a
+                      profile for sentra profile or go tool pprof. A file
+                      ending in .folded or .txt gets folded stacks for flame
+                      graph tools instead.
+  --trace             Log every instruction to stderr before it runs, with
+                      its function, line and the call and stack depth
+  --record <file>     Save the results of HTTP, DNS, socket and database
+                      calls, commands, the environment, the clock and random
+                      numbers to a replay file
+  --replay <file>     Run the script again with the results saved in a
+                      replay file instead of the outside world, and with its
+                      recorded args unless others are given
+  --max-instructions <n>
+                      Stop the script after n bytecode instructions
+  --max-memory <size> Stop the script when the heap grows past size, such
+                      as 512MB or 2G
+  --timeout <duration>
+                      Stop the script after a time such as 30s or 5m
+  --allow-<cap>[=<list>]
+                      Sandbox the script and allow a capability, for the
+                      comma separated paths, hosts, programs or variables
+                      given or for all. Capabilities: net, read, write,
+                      exec, env, sys. --allow-all allows everything.
+  --deny-<cap>[=<list>]
+                      Refuse a capability, or only the resources listed,
+                      whatever is allowed
+
+  A script that exceeds a limit gets an error it can catch once, with a
+  tenth more of the resource to clean up; exceeding it again ends the run.
+  Limits turn off the JIT.
+
+  Without --allow flags everything is allowed except what is denied. With
+  them, builtins that use anything not allowed fail with a permission
+  denied error.
 
 EXAMPLES:
   sentra run scanner.sn
   sentra r api-server.sn --port=8080
-  sentra run --oldvm legacy-script.sn`,
+  sentra run --oldvm legacy-script.sn
+  sentra run --profile scan.pprof scanner.sn
+  sentra run --trace scanner.sn 2> trace.txt
+  sentra run --record incident.json triage.sn 10.0.0.5
+  sentra run --replay incident.json triage.sn
+  sentra run --timeout 30s --max-memory 256MB untrusted.sn
+  sentra run --allow-net --allow-read=/var/log --deny-exec script.sn`,
+
+		"profile": `sentra profile - Analyze a profile
+
+USAGE:
+  sentra profile [options] <profile>
+
+DESCRIPTION:
+  Reads a profile written by sentra run --profile, or folded stacks, and
+  lists the functions that were running (flat) or on the call stack (cum)
+  in the most samples.
+
+  Samples are taken at calls, loop iterations and returns from builtins,
+  and count the wall time since the previous sample, so a builtin such as
+  sleep or http_get shows the time it blocked, under the function that
+  called it.
+
+OPTIONS:
+  --top <n>            Number of functions to show (default 20, 0 for all)
+  --lines              Count each line of a function separately
+  --folded <file>      Write folded stacks for flamegraph.pl or speedscope
+                       instead of listing functions
+
+EXAMPLES:
+  sentra run --profile scan.pprof scanner.sn
+  sentra profile scan.pprof
+  sentra profile --lines --top 10 scan.pprof
+  sentra profile --folded scan.folded scan.pprof
+  flamegraph.pl scan.folded > scan.svg
+  go tool pprof -http=:8080 scan.pprof`,
+
+		"disasm": `sentra disasm - Show the bytecode of a script
+
+USAGE:
+  sentra disasm [--stack] <file.sn>
+
+DESCRIPTION:
+  Compiles a script without running it and lists the bytecode of the
+  script and of every function it defines. Each instruction shows its
+  source line, operands and, after the semicolon, the constant, global or
+  jump target it refers to. Jump targets are marked with >, and the
+  constant table follows each function.
+
+  The listing uses the same format as sentra run --trace, so a trace can be
+  read against it, and disasm --stack against a trace of run --stack when
+  the two VMs disagree.
+
+OPTIONS:
+  --oldvm, --stack     Show the bytecode of the legacy stack-based VM
+
+EXAMPLES:
+  sentra disasm scanner.sn
+  sentra disasm --stack scanner.sn
+  sentra run --trace scanner.sn 2> trace.txt`,
 
 		"repl": `sentra repl - Start the interactive REPL
 
@@ -1220,7 +2183,29 @@ USAGE:
 
 DESCRIPTION:
   Starts an interactive Read-Eval-Print Loop for experimenting with Sentra code.
-  Supports multi-line input, command history, and immediate feedback.
+  Variables, functions and imports persist between entries, and the value of
+  an expression is printed.
+
+  Lines with unclosed brackets continue on a "..." prompt. History is saved
+  to ~/.sentra_history (override with SENTRA_HISTORY). Tab completes globals,
+  builtins, keywords and, after a dot, module exports and map keys.
+
+COMMANDS:
+  :vars                List variables defined in the session
+  :funcs               List functions defined in the session
+  :type <expr>         Show the type of an expression
+  :doc <name>          Show the signature and documentation of a function
+  :time <expr>         Evaluate an expression and show how long it took
+  :load <file.sn>      Run a script in the session
+  :reset               Discard all definitions
+  :help                List commands
+
+KEYS:
+  Up/Down, Ctrl-P/N    Browse history
+  Ctrl-A/E, Home/End   Start/end of line
+  Ctrl-K/U/W           Delete to end/start of line, previous word
+  Ctrl-C               Cancel the current entry or interrupt a running one
+  Ctrl-D               Exit on an empty line
 
 EXAMPLES:
   sentra repl
@@ -1229,18 +2214,68 @@ EXAMPLES:
 		"test": `sentra test - Run test files
 
 USAGE:
-  sentra test [files...]
-  sentra t [files...]             # Using alias
+  sentra test [options] [files or directories...]
+  sentra t [options] [files...]   # Using alias
 
 DESCRIPTION:
   Runs Sentra test files (matching *_test.sn pattern). If no files are specified,
-  discovers and runs all test files in the current directory.
+  discovers test files under the current directory.
+
+  Each top-level function named test_* is run as a test. Optional before_all,
+  after_all, before_each and after_each functions run around the tests.
+  Call skip("reason") inside a test to skip it. Exits with status 1 if any
+  test fails.
+
+OPTIONS:
+  -v, --verbose        Show extra output for passing tests
+  --failfast           Stop after the first failing test
+  --timeout <dur>      Per-test timeout (default 30s, 0 disables)
+  --run <substring>    Only run tests whose name contains substring
+  --format <fmt>       Report format: text (default), json, junit or tap
+  -o, --output <file>  Write the report to a file instead of stdout
+  --cover              Print line coverage of modules imported by tests
+  --coverprofile <f>   Also write coverage in LCOV format
+  --coverhtml <f>      Also write an HTML coverage report
 
 EXAMPLES:
   sentra test
   sentra test src/*_test.sn
+  sentra test --failfast --timeout 5s tests/
+  sentra test --format junit -o report.xml
+  sentra test --coverhtml coverage.html
   sentra t lib/utils_test.sn`,
 
+		"bench": `sentra bench - Run benchmarks
+
+USAGE:
+  sentra bench [options] [files or directories...]
+
+DESCRIPTION:
+  Runs each top-level function named bench_* in *_bench.sn and *_test.sn
+  files. After a warmup, the iteration count is calibrated so one sample
+  takes about --time, then --count samples are taken and reported as
+  mean ns/op with the coefficient of variation.
+
+  With --baseline, results are compared against a file written by --save.
+  A benchmark that is slower by more than --threshold percent (and by more
+  than the combined measurement noise) is reported as a REGRESSION and the
+  command exits with status 1.
+
+OPTIONS:
+  --time <dur>         Target duration of each sample (default 1s)
+  --count <n>          Number of samples per benchmark (default 5)
+  --warmup <dur>       Warmup time before measuring (default 100ms)
+  --run <substring>    Only run benchmarks whose name contains substring
+  --save <file>        Write results as a JSON baseline
+  --baseline <file>    Compare results against a saved baseline
+  --threshold <pct>    Slowdown reported as a regression (default 10)
+
+EXAMPLES:
+  sentra bench
+  sentra bench --save bench.json benchmarks/
+  sentra bench --baseline bench.json --threshold 5
+  sentra bench --run hash --time 200ms crypto_bench.sn`,
+
 		"build": `sentra build - Build the project
 
 USAGE:
@@ -1262,47 +2297,109 @@ EXAMPLES:
 		"fmt": `sentra fmt - Format Sentra code
 
 USAGE:
-  sentra fmt <file.sn>
+  sentra fmt [options] [files or directories...]
   sentra f <file.sn>              # Using alias
 
 DESCRIPTION:
   Formats Sentra source code according to the official style guide.
-  Modifies the file in-place.
+  Modifies files in-place. Directories are searched for .sn files;
+  with no arguments the current directory is formatted.
+
+OPTIONS:
+  --check    List files that are not formatted, exit 1 if any
+  --diff     Print the changes as a diff instead of writing them
+
+CONFIGURATION:
+  Settings are read from the nearest .sentrafmt file, or from the
+  [fmt] section of sentra.toml:
+
+    indent_width = 4          # Spaces per indentation level
+    max_line_length = 100     # Wrap longer calls, arrays and maps (0 = never)
+    trailing_commas = true    # Trailing comma in wrapped arrays and maps
+    brace_style = "same_line" # or "next_line"
 
 EXAMPLES:
   sentra fmt scanner.sn
-  sentra f src/*.sn`,
+  sentra fmt --check .
+  sentra fmt --diff src/`,
 
 		"lint": `sentra lint - Check code quality
 
 USAGE:
-  sentra lint <file.sn>
+  sentra lint [options] [files, directories or patterns...]
   sentra l <file.sn>              # Using alias
+  sentra lint --list-rules        # Show every rule and its default severity
+
+OPTIONS:
+  --format text|json    Output format (default: text)
 
 DESCRIPTION:
-  Analyzes Sentra code for potential issues:
-  - Unused variables
-  - Unreachable code
-  - Missing error handling
-  - Style violations
+  Analyzes Sentra code for potential issues. Each finding names the rule
+  that reported it:
+  - unused-variable      Variables that are never read
+  - unused-import        Imports that are never used
+  - shadowed-variable    Declarations hiding an outer one of the same name
+  - unreachable-code     Statements after return, throw, break or continue
+  - empty-catch          Catch blocks that silently discard the error
+
+  Security rules:
+  - hardcoded-credential     Passwords, tokens and API keys in the source
+  - weak-hash                MD5 or SHA-1 digests compared for integrity
+  - command-injection        Unvalidated input passed to os_exec
+  - plaintext-http           Requests to http:// or ws:// URLs
+  - unchecked-network-error  Network calls with no try and no null check
+
+  Findings are grouped per file. With no paths the current directory is
+  linted; directories are searched recursively, skipping the project's
+  excludes. Exits with status 1 if any finding has severity error or a file
+  cannot be parsed.
+
+CONFIGURATION:
+  Severities are set in the [lint] section of sentra.toml:
+
+    [lint]
+    shadowed-variable = "off"     # off, info, warning or error
+    empty-catch = "error"
+
+  Rules can be silenced in the code itself:
+
+    let tmp = 1  // sentra:disable unused-variable
+    // sentra:disable empty-catch       (applies to the next line)
+    // sentra:disable-file shadowed-variable
+
+  Without rule names every rule is disabled.
 
 EXAMPLES:
   sentra lint scanner.sn
-  sentra l src/main.sn`,
+  sentra lint 'src/**/*.sn'
+  sentra lint --format json .`,
 
 		"check": `sentra check - Check syntax
 
 USAGE:
-  sentra check <file.sn>
+  sentra check [options] [files, directories or patterns...]
   sentra c <file.sn>              # Using alias
 
+OPTIONS:
+  --format text|json    Output format (default: text)
+
 DESCRIPTION:
   Validates Sentra code syntax without executing it.
   Faster than running the code and useful for CI/CD pipelines.
 
+  With no paths the whole current directory is checked. Directories are
+  searched recursively for .sn files, skipping hidden directories,
+  node_modules and the exclude list of sentra.toml:
+
+    [project]
+    exclude = ["vendor", "tests/fixtures/**"]
+
+  Files are parsed in parallel; only files with errors are reported.
+
 EXAMPLES:
   sentra check scanner.sn
-  sentra c src/*.sn`,
+  sentra c src/
+  sentra check 'src/**/*.sn' --format json`,
 
 		"debug": `sentra debug - Debug a script
 
@@ -1318,6 +2415,20 @@ EXAMPLES:
   sentra debug scanner.sn
   sentra d api-server.sn`,
 
+		"dap": `sentra dap - Debug Adapter Protocol server
+
+USAGE:
+  sentra dap
+
+DESCRIPTION:
+  Speaks the Debug Adapter Protocol on stdin/stdout so editors such as
+  VS Code can debug .sn files: breakpoints, stepping, call stacks and
+  variable inspection. Editors start this command themselves; program
+  output is forwarded to the editor's debug console.
+
+  Launch arguments: "program" (required), "stopOnEntry" and "cwd".
+  Watch and hover expressions may be variable paths such as cfg.hosts[0].`,
+
 		"init": `sentra init - Initialize a new project
 
 USAGE:
@@ -1366,7 +2477,9 @@ USAGE:
 
 COMMANDS:
   init <path>                     Initialize a new module
-  download                        Download all dependencies
+  download [--locked]             Download all dependencies; --locked
+                                  (or --frozen, the default in CI) fails
+                                  on any not pinned in sentra.lock
   tidy                           Clean up dependencies
   vendor                         Copy dependencies to vendor/
   list                           List all dependencies
@@ -1374,6 +2487,7 @@ COMMANDS:
 EXAMPLES:
   sentra mod init github.com/user/project
   sentra mod download
+  sentra mod download --locked
   sentra mod tidy`,
 
 		"get": `sentra get - Add a dependency
@@ -1412,17 +2526,23 @@ func showPackageUsage() {
 	fmt.Println("COMMANDS:")
 	fmt.Println("  search <query>     Search for packages in the registry")
 	fmt.Println("  info <package>     Show detailed package information")
-	fmt.Println("  publish            Publish current package to registry")
+	fmt.Println("  publish [--registry <name>]  Publish current package to registry")
+	fmt.Println("  login <registry> <token>     Save an access token for a registry")
+	fmt.Println("  registries         List configured registries in resolution order")
 	fmt.Println("  list               List installed packages")
 	fmt.Println()
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  sentra pkg search network")
 	fmt.Println("  sentra pkg info github.com/sentra-security/network")
-	fmt.Println("  sentra pkg publish")
+	fmt.Println("  sentra pkg publish --registry internal")
+	fmt.Println("  sentra pkg login internal $TOKEN")
 	fmt.Println("  sentra pkg list")
 	fmt.Println()
-	fmt.Println("PACKAGE REGISTRY:")
-	fmt.Println("  https://packages.sentra-lang.org")
+	fmt.Println("PACKAGE REGISTRIES:")
+	fmt.Println("  Default: https://packages.sentra-lang.org")
+	fmt.Println("  Add [registry.<name>] sections (url) to sentra.toml or (url, token_env)")
+	fmt.Println("  to ~/.sentra/registries.toml; registries are tried in order on fetch.")
+	fmt.Println("  SENTRA_REGISTRY and SENTRA_REGISTRY_TOKEN override from the environment.")
 }
 
 // generateCompletion generates shell completion scripts
@@ -1449,7 +2569,7 @@ _sentra() {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
-    commands="run repl test check lint fmt debug init build watch clean mod get help version completion"
+    commands="run repl test bench profile disasm check lint fmt debug init build watch clean mod get help version completion"
     aliases="r i t c l f d b w"
 
     case "${prev}" in
@@ -1465,6 +2585,10 @@ _sentra() {
             COMPREPLY=( $(compgen -f -X '!*_test.sn' -- ${cur}) )
             return 0
             ;;
+        bench)
+            COMPREPLY=( $(compgen -f -X '!*.sn' -- ${cur}) )
+            return 0
+            ;;
         mod)
             COMPREPLY=( $(compgen -W "init download tidy vendor list" -- ${cur}) )
             return 0
@@ -1497,6 +2621,9 @@ _sentra() {
         'i:Start interactive REPL (alias)'
         'test:Run test files'
         't:Run test files (alias)'
+        'bench:Run benchmarks'
+        'profile:Analyze a profile'
+        'disasm:Show the bytecode of a script'
         'check:Check syntax'
         'c:Check syntax (alias)'
         'lint:Check code quality'
@@ -1525,6 +2652,9 @@ _sentra() {
         test|t)
             _files -g "*_test.sn"
             ;;
+        bench)
+            _files -g "*.sn"
+            ;;
         mod)
             _arguments \
                 '1: :(init download tidy vendor list)'
@@ -1554,6 +2684,9 @@ complete -c sentra -f -n "__fish_use_subcommand" -a "repl" -d "Start interactive
 complete -c sentra -f -n "__fish_use_subcommand" -a "i" -d "Start interactive REPL (alias)"
 complete -c sentra -f -n "__fish_use_subcommand" -a "test" -d "Run test files"
 complete -c sentra -f -n "__fish_use_subcommand" -a "t" -d "Run test files (alias)"
+complete -c sentra -f -n "__fish_use_subcommand" -a "bench" -d "Run benchmarks"
+complete -c sentra -f -n "__fish_use_subcommand" -a "profile" -d "Analyze a profile"
+complete -c sentra -f -n "__fish_use_subcommand" -a "disasm" -d "Show the bytecode of a script"
 complete -c sentra -f -n "__fish_use_subcommand" -a "check" -d "Check syntax"
 complete -c sentra -f -n "__fish_use_subcommand" -a "c" -d "Check syntax (alias)"
 complete -c sentra -f -n "__fish_use_subcommand" -a "lint" -d "Check code quality"
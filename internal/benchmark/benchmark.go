@@ -0,0 +1,217 @@
+// Package benchmark runs compliance benchmarks such as the CIS benchmarks
+// against collected data. A benchmark is a list of controls, each a query
+// for items of a data source (the resources of a cloud account, the files,
+// settings and packages of a host) and the condition they are expected to
+// meet. Benchmarks are JSON data files or are defined by Sentra scripts,
+// and the same engine runs them against any target that answers their
+// queries.
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Item is one thing a query returns, such as a resource or a file, as
+// plain values
+type Item = map[string]interface{}
+
+// Benchmark is a set of controls for a platform
+type Benchmark struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Version     string     `json:"version"`
+	Platform    string     `json:"platform"` // aws, azure, gcp, linux, or empty for any
+	Description string     `json:"description"`
+	Controls    []*Control `json:"controls"`
+	Source      string     `json:"-"` // builtin, the file it was loaded from, or script
+}
+
+// Control is a check of a benchmark. Its query selects the items to check
+// and each is expected to meet the expect condition, or, for controls
+// defined in Sentra, to pass the Check function.
+type Control struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Section     string     `json:"section"`
+	Description string     `json:"description"`
+	Level       int        `json:"level"`    // CIS profile level, 1 when not given
+	Severity    string     `json:"severity"` // critical, high, medium or low
+	Manual      bool       `json:"manual"`   // Checked by a person: reported, never scored
+	Query       Query      `json:"query"`
+	Expect      *Condition `json:"expect"`
+	// Mode is all when every item has to meet the condition, any when one
+	// item meeting it is enough, and none when no item may meet it
+	Mode string `json:"mode"`
+	// Empty is the result when the query returns no items: pass or fail.
+	// It is pass for all and none and fail for any when not given.
+	Empty       string `json:"empty"`
+	Remediation string `json:"remediation"`
+
+	// Check replaces Expect for controls defined in Sentra. It returns
+	// whether an item passes and why it does not.
+	Check func(item Item) (bool, string, error) `json:"-"`
+}
+
+// Query selects items from a data source of the target. Args narrow the
+// source down, such as the path of a file or the type of a resource.
+type Query struct {
+	Source string                 `json:"source"`
+	Args   map[string]interface{} `json:"args"`
+	Where  *Condition             `json:"where"` // Only items that meet it are checked
+
+	// Collect replaces the target's source for queries defined in Sentra
+	Collect func() ([]Item, error) `json:"-"`
+}
+
+var modes = map[string]bool{"all": true, "any": true, "none": true}
+
+// Parse reads a benchmark from JSON
+func Parse(data []byte) (*Benchmark, error) {
+	b, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Decode reads a benchmark from JSON without validating it, for callers
+// that add Check and Collect functions first
+func Decode(data []byte) (*Benchmark, error) {
+	var b Benchmark
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// LoadFile reads the benchmark of a JSON file, or every benchmark of the
+// .json files in a directory
+func LoadFile(path string) ([]*Benchmark, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no benchmark files in %s", path)
+		}
+	}
+	var benchmarks []*Benchmark
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		b, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		b.Source = file
+		benchmarks = append(benchmarks, b)
+	}
+	return benchmarks, nil
+}
+
+// Validate checks a benchmark and fills in the defaults of its controls
+func (b *Benchmark) Validate() error {
+	if b.ID == "" {
+		return fmt.Errorf("benchmark has no id")
+	}
+	if b.Title == "" {
+		b.Title = b.ID
+	}
+	b.Platform = strings.ToLower(b.Platform)
+	if len(b.Controls) == 0 {
+		return fmt.Errorf("benchmark %s has no controls", b.ID)
+	}
+	seen := map[string]bool{}
+	for i, c := range b.Controls {
+		if c == nil || c.ID == "" {
+			return fmt.Errorf("control %d of %s has no id", i+1, b.ID)
+		}
+		if seen[c.ID] {
+			return fmt.Errorf("control %s of %s is defined twice", c.ID, b.ID)
+		}
+		seen[c.ID] = true
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("control %s: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Control) validate() error {
+	if c.Title == "" {
+		c.Title = c.ID
+	}
+	if c.Level == 0 {
+		c.Level = 1
+	}
+	c.Severity = strings.ToLower(c.Severity)
+	switch c.Severity {
+	case "":
+		c.Severity = "medium"
+	case "critical", "high", "medium", "low":
+	default:
+		return fmt.Errorf("unknown severity '%s'", c.Severity)
+	}
+	if c.Manual {
+		return nil
+	}
+	c.Mode = strings.ToLower(c.Mode)
+	if c.Mode == "" {
+		c.Mode = "all"
+	}
+	if !modes[c.Mode] {
+		return fmt.Errorf("unknown mode '%s', expected all, any or none", c.Mode)
+	}
+	switch c.Empty {
+	case "":
+		c.Empty = "pass"
+		if c.Mode == "any" {
+			c.Empty = "fail"
+		}
+	case "pass", "fail":
+	default:
+		return fmt.Errorf("empty must be pass or fail, got '%s'", c.Empty)
+	}
+	if c.Query.Source == "" && c.Query.Collect == nil {
+		return fmt.Errorf("query has no source")
+	}
+	if c.Query.Where != nil {
+		if err := c.Query.Where.compile(); err != nil {
+			return fmt.Errorf("where: %v", err)
+		}
+	}
+	if c.Check != nil {
+		return nil
+	}
+	if c.Expect == nil {
+		// Only none can do without: no item may be returned
+		if c.Mode != "none" {
+			return fmt.Errorf("no expect condition")
+		}
+		return nil
+	}
+	if err := c.Expect.compile(); err != nil {
+		return fmt.Errorf("expect: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package benchmark
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinBenchmarks(t *testing.T) {
+	e := NewEngine()
+	for _, id := range []string{"cis-aws-foundations", "cis-azure-foundations", "cis-linux"} {
+		b, ok := e.Get(strings.ToUpper(id))
+		if !ok {
+			t.Fatalf("no built-in %s", id)
+		}
+		if b.Source != "builtin" || len(b.Controls) == 0 {
+			t.Errorf("%s: source %q with %d controls", id, b.Source, len(b.Controls))
+		}
+	}
+	if got := len(e.List()); got != 3 {
+		t.Errorf("List returned %d benchmarks", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct{ json, want string }{
+		{`{"id": "x", "controls": []}`, "has no controls"},
+		{`{"id": "x", "controlz": []}`, "unknown field"},
+		{`{"id": "x", "controls": [{"id": "1", "query": {"source": "s"}}]}`, "no expect condition"},
+		{`{"id": "x", "controls": [{"id": "1", "expect": {"field": "a", "op": "eq", "value": 1}}]}`, "query has no source"},
+		{`{"id": "x", "controls": [{"id": "1", "query": {"source": "s"}, "expect": {"field": "a", "op": "like", "value": 1}}]}`, "unknown op 'like'"},
+		{`{"id": "x", "controls": [{"id": "1", "query": {"source": "s"}, "expect": {"field": "a", "op": "eq"}}]}`, "has no value"},
+		{`{"id": "x", "controls": [{"id": "1", "query": {"source": "s"}, "expect": {"field": "a", "op": "mode_within", "value": "rw"}}]}`, "octal mode"},
+		{`{"id": "x", "controls": [{"id": "1", "query": {"source": "s"}, "mode": "some", "expect": {"field": "a", "op": "exists"}}]}`, "unknown mode 'some'"},
+		{`{"id": "x", "controls": [{"id": "1", "manual": true}, {"id": "1", "manual": true}]}`, "defined twice"},
+	} {
+		if _, err := Parse([]byte(tc.json)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.json, err, tc.want)
+		}
+	}
+}
+
+func TestConditionEval(t *testing.T) {
+	item := Item{
+		"name":    "web",
+		"config":  map[string]interface{}{"port": 22, "tls": "TLS1_2", "tags": []interface{}{"prod", "public"}},
+		"mode":    "0640",
+		"setting": "Yes",
+	}
+	for _, tc := range []struct {
+		cond Condition
+		want bool
+	}{
+		{Condition{Field: "config.port", Op: "eq", Value: "22"}, true},
+		{Condition{Field: "config.port", Op: "lt", Value: 1024}, true},
+		{Condition{Field: "config.port", Op: "in", Value: []interface{}{80, 443}}, false},
+		{Condition{Field: "config.tags", Op: "contains", Value: "PUBLIC"}, true},
+		{Condition{Field: "config.tags.0", Op: "eq", Value: "prod"}, true},
+		{Condition{Field: "config.missing", Op: "ne", Value: 1}, true},
+		{Condition{Field: "config.missing", Op: "eq", Value: 1}, false},
+		{Condition{Field: "config.missing", Op: "not_exists"}, true},
+		{Condition{Field: "setting", Op: "eq", Value: "yes"}, true},
+		{Condition{Field: "name", Op: "matches", Value: "^w.b$"}, true},
+		{Condition{Field: "mode", Op: "mode_within", Value: "0644"}, true},
+		{Condition{Field: "mode", Op: "mode_within", Value: "0600"}, false},
+		{Condition{Any: []*Condition{{Field: "name", Op: "eq", Value: "db"}, {Field: "config.tls", Op: "eq", Value: "TLS1_2"}}}, true},
+		{Condition{Not: &Condition{Field: "config.port", Op: "exists"}}, false},
+	} {
+		cond := tc.cond
+		if err := cond.compile(); err != nil {
+			t.Fatalf("%s: %v", cond.String(), err)
+		}
+		if got, why := cond.Eval(item); got != tc.want {
+			t.Errorf("%s = %v (%s), want %v", cond.String(), got, why, tc.want)
+		}
+	}
+
+	cond := &Condition{Field: "config.port", Op: "gt", Value: 1024}
+	cond.compile()
+	if _, why := cond.Eval(item); why != "config.port is 22, expected gt 1024" {
+		t.Errorf("reason = %q", why)
+	}
+}
+
+func TestRunModes(t *testing.T) {
+	data := map[string][]Item{
+		"resource": {
+			{"id": "a", "type": "bucket", "config": map[string]interface{}{"encrypted": true, "public": false}},
+			{"id": "b", "type": "bucket", "config": map[string]interface{}{"encrypted": false, "public": true}},
+			{"id": "root", "type": "account", "config": map[string]interface{}{"mfa": true}},
+		},
+	}
+	b, err := Parse([]byte(`{
+		"id": "test", "platform": "aws",
+		"controls": [
+			{"id": "1.1", "section": "Accounts", "query": {"source": "resource", "args": {"type": "account"}},
+			 "expect": {"field": "config.mfa", "op": "eq", "value": true}},
+			{"id": "2.1", "section": "Storage", "severity": "high", "query": {"source": "resource", "args": {"type": "bucket"}},
+			 "expect": {"field": "config.encrypted", "op": "eq", "value": true}, "remediation": "encrypt it"},
+			{"id": "2.2", "section": "Storage", "mode": "any", "query": {"source": "resource", "args": {"type": "bucket"}},
+			 "expect": {"field": "config.public", "op": "eq", "value": false}},
+			{"id": "2.3", "section": "Storage", "mode": "none", "query": {"source": "resource", "args": {"type": "bucket"}},
+			 "expect": {"field": "config.public", "op": "eq", "value": true}},
+			{"id": "2.4", "section": "Storage", "level": 2, "query": {"source": "resource", "args": {"type": "queue"}},
+			 "empty": "fail", "expect": {"field": "id", "op": "exists"}},
+			{"id": "3.1", "section": "Logging", "query": {"source": "trail"}, "expect": {"field": "id", "op": "exists"}},
+			{"id": "4.1", "section": "Monitoring", "manual": true}
+		]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine()
+	if err := e.Add(b); err != nil {
+		t.Fatal(err)
+	}
+	target := NewDataTarget("prod", "AWS", data)
+
+	report, err := e.Run("test", target, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[string]*ControlResult{}
+	for _, r := range report.Results {
+		status[r.Control.ID] = r
+	}
+	for id, want := range map[string]string{"1.1": "pass", "2.1": "fail", "2.2": "pass", "2.3": "fail", "2.4": "fail", "3.1": "error", "4.1": "manual"} {
+		if got := status[id].Status; got != want {
+			t.Errorf("%s: %s, want %s (%+v)", id, got, want, status[id])
+		}
+	}
+	if ev := status["2.1"].Evidence; len(ev) != 1 || ev[0].Item != "b" || ev[0].Reason != "config.encrypted is false, expected eq true" {
+		t.Errorf("2.1 evidence = %+v", ev)
+	}
+	if ev := status["2.3"].Evidence; len(ev) != 1 || ev[0].Item != "b" {
+		t.Errorf("2.3 evidence = %+v", ev)
+	}
+	if !strings.Contains(status["3.1"].Error, "no trail data for prod") {
+		t.Errorf("3.1 error = %s", status["3.1"].Error)
+	}
+	if report.Passed != 2 || report.Failed != 3 || report.Errors != 1 || report.Manual != 1 || report.Score != 40 {
+		t.Errorf("report %d passed, %d failed, %d errors, %d manual, score %v",
+			report.Passed, report.Failed, report.Errors, report.Manual, report.Score)
+	}
+	if len(report.Sections) != 4 || report.Sections[1].Name != "Storage" || report.Sections[1].Score != 25 {
+		t.Errorf("sections = %+v", report.Sections[1])
+	}
+
+	report, _ = e.Run("test", target, Options{Level: 1, Controls: []string{"2"}, Skip: []string{"2.3"}})
+	if len(report.Results) != 2 || report.Skipped != 5 {
+		t.Errorf("options ran %d controls and skipped %d", len(report.Results), report.Skipped)
+	}
+
+	m := ReportToMap(report)
+	if m["benchmark"] != "test" || len(m["controls"].([]interface{})) != 2 {
+		t.Errorf("ReportToMap = %v", m)
+	}
+
+	if _, err := e.Run("test", NewDataTarget("vm", "linux", data), Options{}); err == nil ||
+		!strings.Contains(err.Error(), "benchmark test is for aws, vm is linux") {
+		t.Errorf("platform mismatch: %v", err)
+	}
+	if _, err := e.Run("nope", target, Options{}); err == nil {
+		t.Error("unknown benchmark ran")
+	}
+}
+
+func TestCheckFunctions(t *testing.T) {
+	b := &Benchmark{ID: "scripted", Controls: []*Control{
+		{ID: "1", Query: Query{Collect: func() ([]Item, error) {
+			return []Item{{"name": "a", "n": 1}, {"name": "b", "n": 5}}, nil
+		}}, Check: func(item Item) (bool, string, error) {
+			n, _ := number(item["n"])
+			if n > 3 {
+				return false, "n is too big", nil
+			}
+			return true, "", nil
+		}},
+	}}
+	e := NewEngine()
+	if err := e.Add(b); err != nil {
+		t.Fatal(err)
+	}
+	report, err := e.Run("scripted", NewDataTarget("none", "", nil), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report.Results[0]
+	if r.Status != "fail" || r.Checked != 2 || len(r.Evidence) != 1 || r.Evidence[0].Reason != "n is too big" {
+		t.Errorf("result = %+v", r)
+	}
+}
+
+// writeFiles writes files under a root, making their directories
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHostTarget(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"etc/passwd":                    "root:x:0:0:root:/root:/bin/bash\ntoor:x:0:0::/root:/bin/sh\nbob:$1$hash:1000:1000::/home/bob:/bin/bash\n",
+		"etc/group":                     "root:x:0:\nsudo:x:27:bob,alice\n",
+		"etc/shadow":                    "root:!:19000:0:99999:7:::\nbob::19000:0:99999:7:::\n",
+		"etc/login.defs":                "# comment\nPASS_MAX_DAYS\t99999\nPASS_MIN_DAYS 0\n",
+		"etc/ssh/sshd_config":           "Include /etc/ssh/sshd_config.d/*.conf\nPermitRootLogin yes\nX11Forwarding yes\nMatch User bob\n  MaxAuthTries 2\n",
+		"etc/ssh/sshd_config.d/10.conf": "PermitRootLogin=no\n",
+		"etc/sysctl.conf":               "net.ipv4.ip_forward = 1\n",
+		"etc/sysctl.d/99-hard.conf":     "kernel.randomize_va_space=2\n",
+		"etc/fstab":                     "/dev/sda1 / ext4 defaults 0 1\ntmpfs /tmp tmpfs nodev,nosuid 0 0\n",
+		"etc/modprobe.d/cramfs.conf":    "install cramfs /bin/false\nblacklist cramfs\n",
+		"var/lib/dpkg/status":           "Package: telnet\nStatus: install ok installed\nVersion: 0.17\n\nPackage: xinetd\nStatus: deinstall ok config-files\nVersion: 2.3\n",
+	})
+	os.MkdirAll(filepath.Join(root, "etc/systemd/system/multi-user.target.wants"), 0755)
+	os.Symlink("/lib/systemd/system/ssh.service", filepath.Join(root, "etc/systemd/system/multi-user.target.wants/ssh.service"))
+	os.Symlink("/dev/null", filepath.Join(root, "etc/systemd/system/avahi-daemon.service"))
+	os.Chmod(filepath.Join(root, "etc/shadow"), 0600)
+
+	host, err := NewHostTarget(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(source string, args map[string]interface{}) []Item {
+		t.Helper()
+		items, err := host.Query(source, args)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		return items
+	}
+
+	ssh := query("sshd_config", nil)
+	if v, _ := Lookup(filterKey(ssh, "permitrootlogin")[0], "value"); v != "no" {
+		t.Errorf("PermitRootLogin = %v, want the included no", v)
+	}
+	if len(filterKey(ssh, "maxauthtries")) != 0 {
+		t.Error("a Match block setting was read as global")
+	}
+	if items := query("sysctl", map[string]interface{}{"name": []interface{}{"net.ipv4.ip_forward", "kernel.randomize_va_space", "fs.suid_dumpable"}}); len(items) != 2 || items[0]["value"] != "1" {
+		t.Errorf("sysctl = %v", items)
+	}
+	if items := query("config", map[string]interface{}{"path": "/etc/login.defs", "key": "pass_max_days"}); len(items) != 1 || items[0]["value"] != "99999" {
+		t.Errorf("login.defs = %v", items)
+	}
+	if items := query("package", nil); len(items) != 1 || items[0]["name"] != "telnet" {
+		t.Errorf("packages = %v", items)
+	}
+	if items := query("service", nil); len(items) != 2 || items[0]["masked"] != true || items[1]["name"] != "ssh.service" {
+		t.Errorf("services = %v", items)
+	}
+	if items := query("group", nil); len(items[1]["members"].([]interface{})) != 2 {
+		t.Errorf("groups = %v", items)
+	}
+	if items := query("file", map[string]interface{}{"path": []interface{}{"/etc/shadow", "/etc/gshadow"}}); items[0]["mode"] != "0600" || items[1]["exists"] != false {
+		t.Errorf("files = %v", items)
+	}
+	if items := query("file_lines", map[string]interface{}{"path": "/etc/modprobe.d/*.conf", "pattern": "^install"}); len(items) != 1 {
+		t.Errorf("file_lines = %v", items)
+	}
+	if _, err := host.Query("registry", nil); err == nil {
+		t.Error("unknown source was answered")
+	}
+
+	report, err := NewEngine().Run("cis-linux", host, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[string]*ControlResult{}
+	for _, r := range report.Results {
+		status[r.Control.ID] = r
+	}
+	for id, want := range map[string]string{
+		"1.1.1.1": "pass", // install cramfs /bin/false
+		"1.1.1.3": "fail", // udf is not disabled
+		"1.1.2.1": "pass",
+		"1.1.2.2": "fail", // no noexec
+		"1.5.2":   "pass",
+		"1.5.3":   "fail", // not set
+		"2.2.1":   "pass", // xinetd was removed
+		"2.3.1":   "fail", // telnet
+		"3.2.1":   "fail",
+		"5.2.7":   "pass",
+		"5.2.12":  "fail",
+		"5.5.1.2": "fail",
+		"6.2.1":   "fail", // bob's hash is in /etc/passwd
+		"6.2.2":   "fail", // bob has no password
+		"6.2.9":   "fail", // toor
+	} {
+		if r := status[id]; r == nil || r.Status != want {
+			t.Errorf("%s: %+v, want %s", id, r, want)
+		}
+	}
+	if ev := status["6.2.9"].Evidence; len(ev) != 1 || ev[0].Item != "toor" {
+		t.Errorf("6.2.9 evidence = %+v", ev)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json": `{"id": "custom-a", "platform": "linux", "controls": [{"id": "1", "query": {"source": "user"}, "expect": {"field": "shell", "op": "ne", "value": "/bin/sh"}}]}`,
+		"b.json": `{"id": "custom-b", "controls": [{"id": "1", "manual": true}]}`,
+	})
+	e := NewEngine()
+	loaded, err := e.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Source != filepath.Join(dir, "a.json") {
+		t.Errorf("loaded %v", loaded)
+	}
+	if _, ok := e.Get("custom-b"); !ok {
+		t.Error("custom-b was not added")
+	}
+	writeFiles(t, dir, map[string]string{"c.json": `{"id": "bad"}`})
+	if _, err := e.Load(dir); err == nil || !strings.Contains(err.Error(), "c.json") {
+		t.Errorf("bad file: %v", err)
+	}
+}
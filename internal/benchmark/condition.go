@@ -0,0 +1,350 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition is what an item is expected to meet: a comparison of one of
+// its fields, or all, any or not of other conditions
+type Condition struct {
+	All   []*Condition `json:"all"`
+	Any   []*Condition `json:"any"`
+	Not   *Condition   `json:"not"`
+	Field string       `json:"field"` // Dot separated path into the item
+	Op    string       `json:"op"`
+	Value interface{}  `json:"value"`
+
+	re   *regexp.Regexp
+	mode uint32
+}
+
+// ops are the comparisons of a field, and whether they take a value
+var ops = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"in": true, "not_in": true, "contains": true, "not_contains": true,
+	"matches": true, "not_matches": true, "mode_within": true,
+	"exists": false, "not_exists": false, "empty": false, "not_empty": false,
+}
+
+// compile checks a condition and prepares its regular expressions and
+// modes
+func (c *Condition) compile() error {
+	forms := 0
+	if c.All != nil {
+		forms++
+	}
+	if c.Any != nil {
+		forms++
+	}
+	if c.Not != nil {
+		forms++
+	}
+	if c.Field != "" || c.Op != "" {
+		forms++
+	}
+	if forms != 1 {
+		return fmt.Errorf("a condition needs exactly one of all, any, not or field and op")
+	}
+	for _, sub := range append(append([]*Condition{}, c.All...), c.Any...) {
+		if sub == nil {
+			return fmt.Errorf("empty condition")
+		}
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	if c.Not != nil {
+		return c.Not.compile()
+	}
+	if c.Field == "" && c.All == nil && c.Any == nil {
+		return fmt.Errorf("op %s has no field", c.Op)
+	}
+	if c.Field == "" {
+		return nil
+	}
+	c.Op = strings.ToLower(c.Op)
+	needsValue, ok := ops[c.Op]
+	if !ok {
+		return fmt.Errorf("unknown op '%s'", c.Op)
+	}
+	if needsValue && c.Value == nil {
+		return fmt.Errorf("op %s on %s has no value", c.Op, c.Field)
+	}
+	switch c.Op {
+	case "matches", "not_matches":
+		re, err := regexp.Compile(fmt.Sprint(c.Value))
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.Field, err)
+		}
+		c.re = re
+	case "in", "not_in":
+		if _, ok := c.Value.([]interface{}); !ok {
+			return fmt.Errorf("op %s on %s needs a list", c.Op, c.Field)
+		}
+	case "mode_within":
+		m, err := strconv.ParseUint(fmt.Sprint(c.Value), 8, 32)
+		if err != nil {
+			return fmt.Errorf("mode_within on %s needs an octal mode such as \"0644\"", c.Field)
+		}
+		c.mode = uint32(m)
+	}
+	return nil
+}
+
+// String describes a condition, as reports show it
+func (c *Condition) String() string {
+	switch {
+	case c.All != nil:
+		return "all of (" + joinConditions(c.All) + ")"
+	case c.Any != nil:
+		return "any of (" + joinConditions(c.Any) + ")"
+	case c.Not != nil:
+		return "not " + c.Not.String()
+	}
+	if !ops[c.Op] {
+		return c.Field + " " + c.Op
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, c.Op, format(c.Value))
+}
+
+func joinConditions(list []*Condition) string {
+	parts := make([]string, len(list))
+	for i, c := range list {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Eval reports whether an item meets the condition and, when it does not,
+// why
+func (c *Condition) Eval(item Item) (bool, string) {
+	switch {
+	case c.All != nil:
+		for _, sub := range c.All {
+			if ok, why := sub.Eval(item); !ok {
+				return false, why
+			}
+		}
+		return true, ""
+	case c.Any != nil:
+		var reasons []string
+		for _, sub := range c.Any {
+			ok, why := sub.Eval(item)
+			if ok {
+				return true, ""
+			}
+			reasons = append(reasons, why)
+		}
+		return false, strings.Join(reasons, "; ")
+	case c.Not != nil:
+		if ok, _ := c.Not.Eval(item); ok {
+			return false, "expected not " + c.Not.String()
+		}
+		return true, ""
+	}
+	v, found := Lookup(item, c.Field)
+	if c.compare(v, found) {
+		return true, ""
+	}
+	if !found {
+		return false, fmt.Sprintf("%s is not set, expected %s", c.Field, c.expectation())
+	}
+	return false, fmt.Sprintf("%s is %s, expected %s", c.Field, format(v), c.expectation())
+}
+
+func (c *Condition) expectation() string {
+	if !ops[c.Op] {
+		return strings.ReplaceAll(c.Op, "_", " ")
+	}
+	return strings.ReplaceAll(c.Op, "_", " ") + " " + format(c.Value)
+}
+
+func (c *Condition) compare(v interface{}, found bool) bool {
+	switch c.Op {
+	case "exists":
+		return found
+	case "not_exists":
+		return !found
+	case "empty":
+		return isEmpty(v)
+	case "not_empty":
+		return !isEmpty(v)
+	}
+	if !found {
+		// A missing setting is not equal to anything and contains nothing
+		switch c.Op {
+		case "ne", "not_in", "not_contains", "not_matches":
+			return true
+		}
+		return false
+	}
+	switch c.Op {
+	case "eq":
+		return equal(v, c.Value)
+	case "ne":
+		return !equal(v, c.Value)
+	case "lt", "le", "gt", "ge":
+		a, ok1 := number(v)
+		b, ok2 := number(c.Value)
+		if !ok1 || !ok2 {
+			return false
+		}
+		switch c.Op {
+		case "lt":
+			return a < b
+		case "le":
+			return a <= b
+		case "gt":
+			return a > b
+		}
+		return a >= b
+	case "in", "not_in":
+		in := false
+		for _, want := range c.Value.([]interface{}) {
+			if equal(v, want) {
+				in = true
+				break
+			}
+		}
+		return in == (c.Op == "in")
+	case "contains", "not_contains":
+		return contains(v, c.Value) == (c.Op == "contains")
+	case "matches", "not_matches":
+		return c.re.MatchString(fmt.Sprint(v)) == (c.Op == "matches")
+	case "mode_within":
+		m, ok := mode(v)
+		return ok && m&^c.mode == 0
+	}
+	return false
+}
+
+// Lookup returns the value at a dot separated path of an item's fields
+func Lookup(item Item, path string) (interface{}, bool) {
+	var v interface{} = item
+	for _, key := range strings.Split(path, ".") {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			next, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case map[string]string:
+			next, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(m) {
+				return nil, false
+			}
+			v = m[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// number reads a number, from a numeric string too since settings read
+// from files are strings
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal compares numbers by value and other values by their text, with
+// strings compared without case as configuration files write yes and no
+// either way
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+	}
+	return strings.EqualFold(strings.TrimSpace(fmt.Sprint(a)), strings.TrimSpace(fmt.Sprint(b)))
+}
+
+func contains(v, want interface{}) bool {
+	switch list := v.(type) {
+	case []interface{}:
+		for _, item := range list {
+			if equal(item, want) {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for _, item := range list {
+			if equal(item, want) {
+				return true
+			}
+		}
+		return false
+	case nil:
+		return false
+	}
+	return strings.Contains(strings.ToLower(fmt.Sprint(v)), strings.ToLower(fmt.Sprint(want)))
+}
+
+func isEmpty(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(x) == ""
+	case []interface{}:
+		return len(x) == 0
+	case []string:
+		return len(x) == 0
+	case map[string]interface{}:
+		return len(x) == 0
+	}
+	return false
+}
+
+// mode reads file permissions, a number or an octal string such as "0640"
+func mode(v interface{}) (uint32, bool) {
+	if s, ok := v.(string); ok {
+		m, err := strconv.ParseUint(s, 8, 32)
+		return uint32(m), err == nil
+	}
+	n, ok := number(v)
+	return uint32(n), ok
+}
+
+// format shows a value in a reason
+func format(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return strconv.Quote(x)
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = format(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,425 @@
+package benchmark
+
+import (
+	"embed"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed benchmarks/*.json
+var builtinFiles embed.FS
+
+// Target is what a benchmark runs against: a cloud account, a host or
+// data a script collected
+type Target interface {
+	Name() string
+	Platform() string // aws, azure, gcp or linux, empty when unknown
+	// Query returns the items of a data source
+	Query(source string, args map[string]interface{}) ([]Item, error)
+}
+
+// DataTarget answers queries from collected data: the items of a source
+// whose fields equal the query's args
+type DataTarget struct {
+	name     string
+	platform string
+	data     map[string][]Item
+}
+
+// NewDataTarget returns a target for data collected elsewhere, items by
+// source name
+func NewDataTarget(name, platform string, data map[string][]Item) *DataTarget {
+	return &DataTarget{name: name, platform: strings.ToLower(platform), data: data}
+}
+
+func (t *DataTarget) Name() string     { return t.name }
+func (t *DataTarget) Platform() string { return t.platform }
+
+func (t *DataTarget) Query(source string, args map[string]interface{}) ([]Item, error) {
+	items, ok := t.data[source]
+	if !ok {
+		return nil, fmt.Errorf("no %s data for %s", source, t.name)
+	}
+	var matched []Item
+	for _, item := range items {
+		match := true
+		for key, want := range args {
+			if v, found := Lookup(item, key); !found || !equal(v, want) {
+				match = false
+				break
+			}
+		}
+		if match {
+			matched = append(matched, item)
+		}
+	}
+	return matched, nil
+}
+
+// Engine holds the benchmarks that can be run
+type Engine struct {
+	mu         sync.RWMutex
+	benchmarks map[string]*Benchmark
+}
+
+// NewEngine returns an engine with the built-in benchmarks
+func NewEngine() *Engine {
+	e := &Engine{benchmarks: map[string]*Benchmark{}}
+	entries, _ := builtinFiles.ReadDir("benchmarks")
+	for _, entry := range entries {
+		data, err := builtinFiles.ReadFile(path.Join("benchmarks", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		b, err := Parse(data)
+		if err != nil {
+			panic(fmt.Sprintf("built-in benchmark %s: %v", entry.Name(), err))
+		}
+		b.Source = "builtin"
+		e.benchmarks[strings.ToLower(b.ID)] = b
+	}
+	return e
+}
+
+// Add adds a benchmark, replacing one with the same ID
+func (e *Engine) Add(b *Benchmark) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.benchmarks[strings.ToLower(b.ID)] = b
+	return nil
+}
+
+// Load adds the benchmarks of a JSON file or directory
+func (e *Engine) Load(path string) ([]*Benchmark, error) {
+	benchmarks, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, b := range benchmarks {
+		e.benchmarks[strings.ToLower(b.ID)] = b
+	}
+	return benchmarks, nil
+}
+
+// Get returns a benchmark by ID, which is not case sensitive
+func (e *Engine) Get(id string) (*Benchmark, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	b, ok := e.benchmarks[strings.ToLower(id)]
+	return b, ok
+}
+
+// List returns the benchmarks ordered by ID
+func (e *Engine) List() []*Benchmark {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	list := make([]*Benchmark, 0, len(e.benchmarks))
+	for _, b := range e.benchmarks {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Options narrow down which controls of a benchmark are run
+type Options struct {
+	Level    int      // Highest profile level to run, all when 0
+	Controls []string // Only these controls, or the controls of these sections such as "1" or "1.2"
+	Skip     []string // Controls or sections not to run
+}
+
+// selected reports whether a control ID is one of a list, or in a section
+// of it
+func selected(id string, list []string) bool {
+	for _, want := range list {
+		if id == want || strings.HasPrefix(id, want+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Evidence is an item that failed a control
+type Evidence struct {
+	Item   string
+	Reason string
+}
+
+// ControlResult is the outcome of a control: pass, fail, error, or manual
+// for controls a person has to check
+type ControlResult struct {
+	Control  *Control
+	Status   string
+	Checked  int // Items checked
+	Evidence []Evidence
+	Error    string
+}
+
+// SectionScore is the score of the controls of a section
+type SectionScore struct {
+	Name   string
+	Passed int
+	Failed int
+	Score  float64
+}
+
+// Report is the result of running a benchmark against a target
+type Report struct {
+	Benchmark *Benchmark
+	Target    string
+	Platform  string
+	Started   time.Time
+	Duration  time.Duration
+	Results   []*ControlResult
+	Sections  []*SectionScore
+	Passed    int
+	Failed    int
+	Errors    int
+	Manual    int
+	Skipped   int // Left out by the options
+	// Score is the percentage of the automated controls that passed.
+	// Controls that could not be checked do not count.
+	Score float64
+}
+
+// Run runs a benchmark against a target
+func (e *Engine) Run(id string, target Target, opts Options) (*Report, error) {
+	b, ok := e.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown benchmark '%s'", id)
+	}
+	if b.Platform != "" && target.Platform() != "" && !strings.EqualFold(b.Platform, target.Platform()) {
+		return nil, fmt.Errorf("benchmark %s is for %s, %s is %s", b.ID, b.Platform, target.Name(), target.Platform())
+	}
+	report := &Report{Benchmark: b, Target: target.Name(), Platform: target.Platform(), Started: time.Now()}
+	sections := map[string]*SectionScore{}
+	var order []string
+	for _, c := range b.Controls {
+		if opts.Level > 0 && c.Level > opts.Level ||
+			len(opts.Controls) > 0 && !selected(c.ID, opts.Controls) || selected(c.ID, opts.Skip) {
+			report.Skipped++
+			continue
+		}
+		result := runControl(c, target)
+		report.Results = append(report.Results, result)
+
+		s, ok := sections[c.Section]
+		if !ok {
+			s = &SectionScore{Name: c.Section}
+			sections[c.Section] = s
+			order = append(order, c.Section)
+		}
+		switch result.Status {
+		case "pass":
+			report.Passed++
+			s.Passed++
+		case "fail":
+			report.Failed++
+			s.Failed++
+		case "error":
+			report.Errors++
+		case "manual":
+			report.Manual++
+		}
+	}
+	for _, name := range order {
+		s := sections[name]
+		s.Score = score(s.Passed, s.Failed)
+		report.Sections = append(report.Sections, s)
+	}
+	report.Score = score(report.Passed, report.Failed)
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+func score(passed, failed int) float64 {
+	if passed+failed == 0 {
+		return 100
+	}
+	return math.Round(float64(passed)/float64(passed+failed)*1000) / 10
+}
+
+// runControl queries the items of a control and checks them
+func runControl(c *Control, target Target) *ControlResult {
+	result := &ControlResult{Control: c}
+	if c.Manual {
+		result.Status = "manual"
+		return result
+	}
+	var items []Item
+	var err error
+	if c.Query.Collect != nil {
+		items, err = c.Query.Collect()
+	} else {
+		items, err = target.Query(c.Query.Source, c.Query.Args)
+	}
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	if c.Query.Where != nil {
+		var matched []Item
+		for _, item := range items {
+			if ok, _ := c.Query.Where.Eval(item); ok {
+				matched = append(matched, item)
+			}
+		}
+		items = matched
+	}
+	result.Checked = len(items)
+	if len(items) == 0 {
+		result.Status = c.Empty
+		if c.Empty == "fail" {
+			result.Evidence = []Evidence{{Item: c.Query.Source, Reason: "nothing found"}}
+		}
+		return result
+	}
+
+	passed := 0
+	for _, item := range items {
+		ok, why, err := check(c, item)
+		if err != nil {
+			result.Status, result.Error = "error", err.Error()
+			return result
+		}
+		switch {
+		case c.Mode == "none" && ok:
+			if why == "" {
+				why = "found"
+				if c.Expect != nil {
+					why = "matches " + c.Expect.String()
+				}
+			}
+			result.Evidence = append(result.Evidence, Evidence{Item: label(item), Reason: why})
+		case c.Mode == "none":
+		case ok:
+			passed++
+		case c.Mode == "all":
+			result.Evidence = append(result.Evidence, Evidence{Item: label(item), Reason: why})
+		}
+	}
+	result.Status = "pass"
+	switch c.Mode {
+	case "all", "none":
+		if len(result.Evidence) > 0 {
+			result.Status = "fail"
+		}
+	case "any":
+		if passed == 0 {
+			result.Status = "fail"
+			reason := "no item meets the control"
+			if c.Expect != nil {
+				reason = "no item meets " + c.Expect.String()
+			}
+			result.Evidence = []Evidence{{Item: c.Query.Source, Reason: reason}}
+		}
+	}
+	return result
+}
+
+// check runs the check of a control on an item. For none, ok means the
+// item is one the control does not allow.
+func check(c *Control, item Item) (bool, string, error) {
+	if c.Check != nil {
+		ok, why, err := c.Check(item)
+		if c.Mode == "none" {
+			// A Sentra check of a none control passes items it allows
+			return !ok, why, err
+		}
+		return ok, why, err
+	}
+	if c.Expect == nil {
+		return true, "", nil
+	}
+	ok, why := c.Expect.Eval(item)
+	return ok, why, nil
+}
+
+// label names an item in evidence
+func label(item Item) string {
+	for _, key := range []string{"id", "path", "name", "key", "mountpoint"} {
+		if v, ok := item[key]; ok && fmt.Sprint(v) != "" {
+			return fmt.Sprint(v)
+		}
+	}
+	return fmt.Sprint(item)
+}
+
+// BenchmarkToMap converts a benchmark to the map benchmark_list returns
+func BenchmarkToMap(b *Benchmark) map[string]interface{} {
+	sections := []interface{}{}
+	seen := map[string]bool{}
+	for _, c := range b.Controls {
+		if !seen[c.Section] {
+			seen[c.Section] = true
+			sections = append(sections, c.Section)
+		}
+	}
+	return map[string]interface{}{
+		"id":          b.ID,
+		"title":       b.Title,
+		"version":     b.Version,
+		"platform":    b.Platform,
+		"description": b.Description,
+		"source":      b.Source,
+		"controls":    len(b.Controls),
+		"sections":    sections,
+	}
+}
+
+// ReportToMap converts a report to the map benchmark_run returns
+func ReportToMap(r *Report) map[string]interface{} {
+	controls := []interface{}{}
+	for _, res := range r.Results {
+		evidence := []interface{}{}
+		for _, e := range res.Evidence {
+			evidence = append(evidence, map[string]interface{}{"item": e.Item, "reason": e.Reason})
+		}
+		c := res.Control
+		controls = append(controls, map[string]interface{}{
+			"id":          c.ID,
+			"title":       c.Title,
+			"section":     c.Section,
+			"level":       c.Level,
+			"severity":    c.Severity,
+			"status":      res.Status,
+			"checked":     res.Checked,
+			"evidence":    evidence,
+			"error":       res.Error,
+			"remediation": c.Remediation,
+		})
+	}
+	sections := []interface{}{}
+	for _, s := range r.Sections {
+		sections = append(sections, map[string]interface{}{
+			"name": s.Name, "passed": s.Passed, "failed": s.Failed, "score": s.Score,
+		})
+	}
+	return map[string]interface{}{
+		"benchmark":   r.Benchmark.ID,
+		"title":       r.Benchmark.Title,
+		"version":     r.Benchmark.Version,
+		"target":      r.Target,
+		"platform":    r.Platform,
+		"timestamp":   r.Started.Format(time.RFC3339),
+		"duration_ms": r.Duration.Milliseconds(),
+		"score":       r.Score,
+		"passed":      r.Passed,
+		"failed":      r.Failed,
+		"errors":      r.Errors,
+		"manual":      r.Manual,
+		"skipped":     r.Skipped,
+		"sections":    sections,
+		"controls":    controls,
+	}
+}
@@ -0,0 +1,662 @@
+package benchmark
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostTarget answers queries about a Linux system from its files: the
+// running host when root is "/", or a mounted image or container file
+// system, where what only a running kernel knows falls back to the
+// configuration that would apply at boot
+type HostTarget struct {
+	root    string
+	name    string
+	running bool // /proc describes this system
+}
+
+// NewHostTarget returns a target for the Linux system under root
+func NewHostTarget(root string) (*HostTarget, error) {
+	if root == "" {
+		root = "/"
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	t := &HostTarget{root: abs, name: abs, running: abs == "/"}
+	if t.running {
+		if host, err := os.Hostname(); err == nil {
+			t.name = host
+		}
+	}
+	return t, nil
+}
+
+func (t *HostTarget) Name() string     { return t.name }
+func (t *HostTarget) Platform() string { return "linux" }
+
+// hostSources are the data sources of a host and the args they take
+var hostSources = map[string]func(t *HostTarget, args map[string]interface{}) ([]Item, error){
+	"file":          (*HostTarget).files,
+	"file_lines":    (*HostTarget).fileLines,
+	"config":        (*HostTarget).config,
+	"sshd_config":   (*HostTarget).sshdConfig,
+	"sysctl":        (*HostTarget).sysctl,
+	"mount":         (*HostTarget).mounts,
+	"package":       (*HostTarget).packages,
+	"service":       (*HostTarget).services,
+	"user":          (*HostTarget).users,
+	"shadow":        (*HostTarget).shadow,
+	"group":         (*HostTarget).groups,
+	"modprobe":      (*HostTarget).modprobe,
+	"kernel_module": (*HostTarget).kernelModules,
+}
+
+// HostSources lists the data sources a host answers
+func HostSources() []string {
+	names := make([]string, 0, len(hostSources))
+	for name := range hostSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *HostTarget) Query(source string, args map[string]interface{}) ([]Item, error) {
+	fn, ok := hostSources[source]
+	if !ok {
+		return nil, fmt.Errorf("unknown host data source '%s'", source)
+	}
+	return fn(t, args)
+}
+
+// path returns where a path of the system is under the root
+func (t *HostTarget) path(p string) string {
+	return filepath.Join(t.root, filepath.FromSlash(p))
+}
+
+// arg reads a string arg
+func arg(args map[string]interface{}, key string) string {
+	if v, ok := args[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// argList reads an arg that is a string or a list of strings
+func argList(args map[string]interface{}, key string) []string {
+	switch v := args[key].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	case []string:
+		return v
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// lines returns the lines of a file without comments and blank lines. A
+// missing file has no lines.
+func (t *HostTarget) lines(p string) ([]string, error) {
+	f, err := os.Open(t.path(p))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// glob returns the files matching a pattern of the system, as system paths
+func (t *HostTarget) glob(pattern string) []string {
+	matches, _ := filepath.Glob(t.path(pattern))
+	sort.Strings(matches)
+	paths := make([]string, len(matches))
+	for i, m := range matches {
+		rel, _ := filepath.Rel(t.root, m)
+		paths[i] = "/" + filepath.ToSlash(rel)
+	}
+	return paths
+}
+
+// files describes the files of the path arg, which may be a glob: exists,
+// type, mode, owner and group
+func (t *HostTarget) files(args map[string]interface{}) ([]Item, error) {
+	paths := argList(args, "path")
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("file needs a path")
+	}
+	users, groups := t.names("/etc/passwd"), t.names("/etc/group")
+	var items []Item
+	for _, p := range paths {
+		matches := []string{p}
+		if strings.ContainsAny(p, "*?[") {
+			matches = t.glob(p)
+		}
+		for _, m := range matches {
+			info, err := os.Lstat(t.path(m))
+			if os.IsNotExist(err) {
+				items = append(items, Item{"path": m, "exists": false})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			typ := "file"
+			switch {
+			case info.IsDir():
+				typ = "directory"
+			case info.Mode()&os.ModeSymlink != 0:
+				typ = "symlink"
+			case !info.Mode().IsRegular():
+				typ = "other"
+			}
+			perm := uint32(info.Mode().Perm())
+			if info.Mode()&os.ModeSetuid != 0 {
+				perm |= 04000
+			}
+			if info.Mode()&os.ModeSetgid != 0 {
+				perm |= 02000
+			}
+			if info.Mode()&os.ModeSticky != 0 {
+				perm |= 01000
+			}
+			item := Item{
+				"path":   m,
+				"exists": true,
+				"type":   typ,
+				"mode":   fmt.Sprintf("%04o", perm),
+				"size":   info.Size(),
+			}
+			if uid, gid, ok := fileOwner(info); ok {
+				item["uid"], item["gid"] = uid, gid
+				item["owner"], item["group"] = firstNonEmpty(users[uid], strconv.Itoa(uid)), firstNonEmpty(groups[gid], strconv.Itoa(gid))
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// names maps the IDs of /etc/passwd or /etc/group to names
+func (t *HostTarget) names(p string) map[int]string {
+	names := map[int]string{}
+	lines, _ := t.lines(p)
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[2]); err == nil {
+			if _, dup := names[id]; !dup {
+				names[id] = fields[0]
+			}
+		}
+	}
+	return names
+}
+
+// fileLines returns the lines of the files of the path arg that match the
+// pattern arg, or all of them, without comments
+func (t *HostTarget) fileLines(args map[string]interface{}) ([]Item, error) {
+	paths := argList(args, "path")
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("file_lines needs a path")
+	}
+	var re *regexp.Regexp
+	if p := arg(args, "pattern"); p != "" {
+		var err error
+		if re, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("file_lines: %v", err)
+		}
+	}
+	var items []Item
+	for _, p := range paths {
+		files := []string{p}
+		if strings.ContainsAny(p, "*?[") {
+			files = t.glob(p)
+		}
+		for _, file := range files {
+			lines, err := t.lines(file)
+			if err != nil {
+				return nil, err
+			}
+			for i, line := range lines {
+				if re == nil || re.MatchString(line) {
+					items = append(items, Item{"path": file, "line": line, "number": i + 1})
+				}
+			}
+		}
+	}
+	return items, nil
+}
+
+// config reads the settings of key value files such as /etc/login.defs.
+// The separator arg splits keys from values, white space by default. A
+// key set more than once has its last value.
+func (t *HostTarget) config(args map[string]interface{}) ([]Item, error) {
+	paths := argList(args, "path")
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("config needs a path")
+	}
+	sep := arg(args, "separator")
+	values := map[string]Item{}
+	var order []string
+	for _, p := range paths {
+		files := []string{p}
+		if strings.ContainsAny(p, "*?[") {
+			files = t.glob(p)
+		}
+		for _, file := range files {
+			lines, err := t.lines(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range lines {
+				key, value := splitSetting(line, sep)
+				if key == "" {
+					continue
+				}
+				if _, seen := values[key]; !seen {
+					order = append(order, key)
+				}
+				values[key] = Item{"key": key, "value": value, "path": file}
+			}
+		}
+	}
+	items := make([]Item, len(order))
+	for i, key := range order {
+		items[i] = values[key]
+	}
+	if key := arg(args, "key"); key != "" {
+		return filterKey(items, key), nil
+	}
+	return items, nil
+}
+
+func splitSetting(line, sep string) (string, string) {
+	var key, value string
+	if sep == "" {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return "", ""
+		}
+		key, value = fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	} else {
+		var found bool
+		if key, value, found = strings.Cut(line, sep); !found {
+			return "", ""
+		}
+	}
+	return strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"`)
+}
+
+func filterKey(items []Item, key string) []Item {
+	var matched []Item
+	for _, item := range items {
+		if strings.EqualFold(fmt.Sprint(item["key"]), key) {
+			matched = append(matched, item)
+		}
+	}
+	return matched
+}
+
+// sshdConfig reads the global settings of the OpenSSH server, following
+// Include as sshd does. Keywords are lower case and the first value of a
+// keyword is the one that applies.
+func (t *HostTarget) sshdConfig(args map[string]interface{}) ([]Item, error) {
+	values := map[string]Item{}
+	var order []string
+	var read func(file string, depth int) (bool, error)
+	read = func(file string, depth int) (bool, error) {
+		lines, err := t.lines(file)
+		if err != nil || depth > 8 {
+			return false, err
+		}
+		for _, line := range lines {
+			key, value := splitSetting(strings.Replace(line, "=", " ", 1), "")
+			key = strings.ToLower(key)
+			switch key {
+			case "":
+				continue
+			case "match":
+				// Settings after Match apply to some connections only
+				return true, nil
+			case "include":
+				for _, pattern := range strings.Fields(value) {
+					if !strings.HasPrefix(pattern, "/") {
+						pattern = "/etc/ssh/" + pattern
+					}
+					for _, inc := range t.glob(pattern) {
+						if stop, err := read(inc, depth+1); stop || err != nil {
+							return stop, err
+						}
+					}
+				}
+				continue
+			}
+			if _, seen := values[key]; !seen {
+				values[key] = Item{"key": key, "value": value, "path": file}
+				order = append(order, key)
+			}
+		}
+		return false, nil
+	}
+	if _, err := read("/etc/ssh/sshd_config", 0); err != nil {
+		return nil, err
+	}
+	items := make([]Item, len(order))
+	for i, key := range order {
+		items[i] = values[key]
+	}
+	if key := arg(args, "key"); key != "" {
+		return filterKey(items, key), nil
+	}
+	return items, nil
+}
+
+// sysctl returns kernel parameters by name: the running value from
+// /proc/sys on a live host, otherwise the value sysctl.conf and
+// sysctl.d set
+func (t *HostTarget) sysctl(args map[string]interface{}) ([]Item, error) {
+	names := argList(args, "name")
+	if len(names) == 0 {
+		return nil, fmt.Errorf("sysctl needs a name")
+	}
+	configured := map[string]string{}
+	for _, file := range append(append(t.glob("/usr/lib/sysctl.d/*.conf"), t.glob("/etc/sysctl.d/*.conf")...), "/etc/sysctl.conf") {
+		lines, _ := t.lines(file)
+		for _, line := range lines {
+			if key, value := splitSetting(line, "="); key != "" {
+				configured[strings.ReplaceAll(key, "/", ".")] = value
+			}
+		}
+	}
+	var items []Item
+	for _, name := range names {
+		if t.running {
+			data, err := os.ReadFile(t.path("/proc/sys/" + strings.ReplaceAll(name, ".", "/")))
+			if err == nil {
+				items = append(items, Item{"name": name, "value": strings.Join(strings.Fields(string(data)), " "),
+					"configured": configured[name], "source": "running"})
+				continue
+			}
+		}
+		if v, ok := configured[name]; ok {
+			items = append(items, Item{"name": name, "value": v, "configured": v, "source": "configured"})
+		}
+	}
+	return items, nil
+}
+
+// mounts returns the mounted file systems, or those of /etc/fstab for a
+// system that is not running
+func (t *HostTarget) mounts(args map[string]interface{}) ([]Item, error) {
+	file := "/etc/fstab"
+	if t.running {
+		file = "/proc/mounts"
+	}
+	lines, err := t.lines(file)
+	if err != nil {
+		return nil, err
+	}
+	want := arg(args, "mountpoint")
+	var items []Item
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 4 || want != "" && f[1] != want {
+			continue
+		}
+		options := []interface{}{}
+		for _, o := range strings.Split(f[3], ",") {
+			options = append(options, o)
+		}
+		items = append(items, Item{"device": f[0], "mountpoint": f[1], "fstype": f[2], "options": options})
+	}
+	return items, nil
+}
+
+// packages returns the installed packages of dpkg and apk
+func (t *HostTarget) packages(args map[string]interface{}) ([]Item, error) {
+	want := map[string]bool{}
+	for _, name := range argList(args, "name") {
+		want[name] = true
+	}
+	var items []Item
+	add := func(name, version, manager string) {
+		if name != "" && (len(want) == 0 || want[name]) {
+			items = append(items, Item{"name": name, "version": version, "manager": manager})
+		}
+	}
+	if data, err := os.ReadFile(t.path("/var/lib/dpkg/status")); err == nil {
+		for _, stanza := range strings.Split(string(data), "\n\n") {
+			var name, version string
+			installed := false
+			for _, line := range strings.Split(stanza, "\n") {
+				switch {
+				case strings.HasPrefix(line, "Package: "):
+					name = strings.TrimPrefix(line, "Package: ")
+				case strings.HasPrefix(line, "Version: "):
+					version = strings.TrimPrefix(line, "Version: ")
+				case strings.HasPrefix(line, "Status: "):
+					installed = strings.HasSuffix(line, " installed")
+				}
+			}
+			if installed {
+				add(name, version, "dpkg")
+			}
+		}
+	}
+	if data, err := os.ReadFile(t.path("/lib/apk/db/installed")); err == nil {
+		var name, version string
+		for _, line := range strings.Split(string(data)+"\n", "\n") {
+			switch {
+			case strings.HasPrefix(line, "P:"):
+				name = line[2:]
+			case strings.HasPrefix(line, "V:"):
+				version = line[2:]
+			case line == "":
+				add(name, version, "apk")
+				name, version = "", ""
+			}
+		}
+	}
+	return items, nil
+}
+
+// services returns the systemd units enabled to start, from the links in
+// the .wants directories, and the masked units
+func (t *HostTarget) services(args map[string]interface{}) ([]Item, error) {
+	units := map[string]Item{}
+	for _, dir := range []string{"/etc/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"} {
+		for _, link := range t.glob(dir + "/*.wants/*") {
+			name := filepath.Base(link)
+			if _, seen := units[name]; !seen {
+				units[name] = Item{"name": name, "enabled": true, "masked": false,
+					"wanted_by": strings.TrimSuffix(filepath.Base(filepath.Dir(link)), ".wants")}
+			}
+		}
+	}
+	for _, link := range t.glob("/etc/systemd/system/*") {
+		if target, err := os.Readlink(t.path(link)); err == nil && target == "/dev/null" {
+			units[filepath.Base(link)] = Item{"name": filepath.Base(link), "enabled": false, "masked": true}
+		}
+	}
+	want := map[string]bool{}
+	for _, name := range argList(args, "name") {
+		want[name] = true
+		if !strings.Contains(name, ".") {
+			want[name+".service"] = true
+		}
+	}
+	names := make([]string, 0, len(units))
+	for name := range units {
+		if len(want) == 0 || want[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := make([]Item, len(names))
+	for i, name := range names {
+		items[i] = units[name]
+	}
+	return items, nil
+}
+
+// users returns the accounts of /etc/passwd
+func (t *HostTarget) users(args map[string]interface{}) ([]Item, error) {
+	lines, err := t.lines("/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, line := range lines {
+		f := strings.Split(line, ":")
+		if len(f) < 7 {
+			continue
+		}
+		uid, _ := strconv.Atoi(f[2])
+		gid, _ := strconv.Atoi(f[3])
+		items = append(items, Item{"name": f[0], "uid": uid, "gid": gid, "home": f[5], "shell": f[6],
+			"password_field": f[1]})
+	}
+	return items, nil
+}
+
+// shadow returns the password state of the accounts of /etc/shadow,
+// which only root can read: whether a password is set, empty or locked,
+// and the aging settings
+func (t *HostTarget) shadow(args map[string]interface{}) ([]Item, error) {
+	lines, err := t.lines("/etc/shadow")
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, line := range lines {
+		f := strings.Split(line, ":")
+		if len(f) < 8 {
+			continue
+		}
+		hash := f[1]
+		item := Item{
+			"name":           f[0],
+			"empty_password": hash == "",
+			"locked":         strings.HasPrefix(hash, "!") || hash == "*",
+			"password_set":   hash != "" && !strings.HasPrefix(hash, "!") && hash != "*",
+		}
+		for i, key := range []string{"last_change", "min_days", "max_days", "warn_days", "inactive_days"} {
+			if n, err := strconv.Atoi(f[i+2]); err == nil {
+				item[key] = n
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// groups returns the groups of /etc/group with their members
+func (t *HostTarget) groups(args map[string]interface{}) ([]Item, error) {
+	lines, err := t.lines("/etc/group")
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, line := range lines {
+		f := strings.Split(line, ":")
+		if len(f) < 4 {
+			continue
+		}
+		gid, _ := strconv.Atoi(f[2])
+		members := []interface{}{}
+		for _, m := range strings.Split(f[3], ",") {
+			if m != "" {
+				members = append(members, m)
+			}
+		}
+		items = append(items, Item{"name": f[0], "gid": gid, "members": members})
+	}
+	return items, nil
+}
+
+// modprobe returns the directives of the modprobe configuration, such as
+// install cramfs /bin/false, for the module arg or every module
+func (t *HostTarget) modprobe(args map[string]interface{}) ([]Item, error) {
+	want := arg(args, "module")
+	var items []Item
+	for _, dir := range []string{"/lib/modprobe.d", "/usr/lib/modprobe.d", "/etc/modprobe.d"} {
+		for _, file := range t.glob(dir + "/*.conf") {
+			lines, err := t.lines(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range lines {
+				f := strings.Fields(line)
+				if len(f) < 2 || want != "" && f[1] != want {
+					continue
+				}
+				items = append(items, Item{"directive": f[0], "module": f[1], "value": strings.Join(f[2:], " "), "path": file})
+			}
+		}
+	}
+	return items, nil
+}
+
+// kernelModules returns the loaded kernel modules of a running host
+func (t *HostTarget) kernelModules(args map[string]interface{}) ([]Item, error) {
+	if !t.running {
+		return nil, fmt.Errorf("kernel_module needs a running host")
+	}
+	lines, err := t.lines("/proc/modules")
+	if err != nil {
+		return nil, err
+	}
+	want := arg(args, "name")
+	var items []Item
+	for _, line := range lines {
+		if f := strings.Fields(line); len(f) > 0 && (want == "" || f[0] == want) {
+			items = append(items, Item{"name": f[0]})
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+// internal/benchmark/owner_unix.go
+package benchmark
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of a file
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
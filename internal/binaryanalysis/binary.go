@@ -0,0 +1,484 @@
+// Package binaryanalysis parses PE, ELF and Mach-O executables for static
+// triage: headers, sections with their entropy, imports and exports,
+// signatures, exploit mitigations, packer heuristics and embedded strings.
+package binaryanalysis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Analysis is what static analysis of an executable found
+type Analysis struct {
+	Path        string
+	Format      string // PE, ELF or Mach-O
+	Arch        string
+	Bits        int
+	Type        string // executable, library, object, driver or core
+	EntryPoint  uint64
+	ImageBase   uint64 // Section addresses of PE files are relative to it
+	Size        int64
+	SHA256      string
+	Entropy     float64 // Of the whole file, in bits per byte
+	Sections    []Section
+	Libraries   []string
+	Imports     []Import
+	Exports     []string
+	Stripped    bool // Has no symbol table beyond what linking needs
+	Signed      bool
+	Signature   string          // Authenticode, code signature, module signature or none
+	Protections map[string]bool // Exploit mitigations, such as nx and aslr
+	Packers     []string        // Packers and protectors whose marks were found
+	Packed      bool
+	Indicators  []string // Why the file looks packed or tampered with
+	URLs        []string // Found in its strings, up to 50
+	IPs         []string
+	Arches      []string // Every architecture of a universal Mach-O binary
+}
+
+// Section is a section of an executable
+type Section struct {
+	Name           string
+	VirtualAddress uint64
+	VirtualSize    uint64
+	Offset         uint64
+	Size           uint64 // In the file
+	Flags          string // r, w and x, such as r-x
+	Entropy        float64
+}
+
+// Import is a function an executable imports, with its library when the
+// format records it
+type Import struct {
+	Library  string
+	Function string
+}
+
+// String is a run of printable text in a file
+type String struct {
+	Offset   int64
+	Value    string
+	Encoding string // ascii or utf-16le
+}
+
+// Analyze parses the executable at path
+func Analyze(path string) (*Analysis, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	a, err := AnalyzeReader(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	a.Path = path
+	return a, nil
+}
+
+// AnalyzeReader parses an executable of size bytes, such as a file in a
+// container image layer
+func AnalyzeReader(r io.ReaderAt, size int64) (*Analysis, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, fmt.Errorf("not a PE, ELF or Mach-O file")
+	}
+	a := &Analysis{Size: size, Protections: map[string]bool{}}
+	var err error
+	switch {
+	case magic[0] == 'M' && magic[1] == 'Z':
+		err = analyzePE(r, a)
+	case bytes.Equal(magic, []byte("\x7fELF")):
+		err = analyzeELF(r, a)
+	case isMachO(magic):
+		err = analyzeMachO(r, a)
+	default:
+		return nil, fmt.Errorf("not a PE, ELF or Mach-O file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.Signature == "" {
+		a.Signature = "none"
+	}
+
+	if err := a.scanFile(r); err != nil {
+		return nil, err
+	}
+	a.detectPackers()
+	return a, nil
+}
+
+// scanFile hashes the file and reads its entropy, packer marks, URLs and
+// IP addresses in one pass
+func (a *Analysis) scanFile(r io.ReaderAt) error {
+	h := sha256.New()
+	var counts [256]int64
+	urls, ips := map[string]bool{}, map[string]bool{}
+	err := eachString(io.NewSectionReader(r, 0, a.Size), 6, func(s String) bool {
+		if packer, ok := marks[s.Value]; ok {
+			a.addPacker(packer, fmt.Sprintf("%q at offset %d", s.Value, s.Offset))
+		}
+		for _, u := range urlPattern.FindAllString(s.Value, -1) {
+			if len(urls) < 50 && !urls[u] {
+				urls[u] = true
+				a.URLs = append(a.URLs, u)
+			}
+		}
+		for _, ip := range ipPattern.FindAllString(s.Value, -1) {
+			if len(ips) < 50 && !ips[ip] && validIPv4(ip) {
+				ips[ip] = true
+				a.IPs = append(a.IPs, ip)
+			}
+		}
+		return true
+	}, func(chunk []byte) {
+		h.Write(chunk)
+		for _, b := range chunk {
+			counts[b]++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	a.SHA256 = hex.EncodeToString(h.Sum(nil))
+	a.Entropy = entropyOf(counts, a.Size)
+	return nil
+}
+
+// Strings returns the printable ASCII and UTF-16LE runs of at least
+// minLength characters in a file, up to max of them
+func Strings(path string, minLength, max int) ([]String, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var found []String
+	err = eachString(f, minLength, func(s String) bool {
+		found = append(found, s)
+		return max <= 0 || len(found) < max
+	}, nil)
+	return found, err
+}
+
+// eachString calls fn with each string of at least minLength characters
+// in r, until fn returns false, and chunk with each block read
+func eachString(r io.Reader, minLength int, fn func(String) bool, chunk func([]byte)) error {
+	if minLength < 1 {
+		minLength = 1
+	}
+	var ascii []byte
+	var asciiStart int64
+	// A UTF-16LE character is a printable byte and a zero; strings at even
+	// and odd offsets are read separately
+	var wide [2][]byte
+	var wideStart [2]int64
+	flush := func(run *[]byte, start int64, encoding string) bool {
+		ok := true
+		if len(*run) >= minLength {
+			ok = fn(String{Offset: start, Value: string(*run), Encoding: encoding})
+		}
+		*run = (*run)[:0]
+		return ok
+	}
+
+	var offset int64
+	var prev byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		if chunk != nil && n > 0 {
+			chunk(buf[:n])
+		}
+		for _, b := range buf[:n] {
+			if printable(b) {
+				if len(ascii) == 0 {
+					asciiStart = offset
+				}
+				ascii = append(ascii, b)
+			} else if !flush(&ascii, asciiStart, "ascii") {
+				return nil
+			}
+			if offset > 0 {
+				// The character that would start at the previous byte
+				p := (offset - 1) % 2
+				if b == 0 && printable(prev) {
+					if len(wide[p]) == 0 {
+						wideStart[p] = offset - 1
+					}
+					wide[p] = append(wide[p], prev)
+				} else if !flush(&wide[p], wideStart[p], "utf-16le") {
+					return nil
+				}
+			}
+			prev = b
+			offset++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flush(&ascii, asciiStart, "ascii") && flush(&wide[0], wideStart[0], "utf-16le") {
+		flush(&wide[1], wideStart[1], "utf-16le")
+	}
+	return nil
+}
+
+func printable(b byte) bool {
+	return b >= 0x20 && b < 0x7f || b == '\t'
+}
+
+var (
+	urlPattern = regexp.MustCompile(`\b(?:https?|ftp)://[A-Za-z0-9.\-]+(?::\d+)?(?:/[^\s"'<>]*)?`)
+	ipPattern  = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+)
+
+func validIPv4(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if len(part) > 1 && part[0] == '0' {
+			return false
+		}
+		if n, err := strconv.Atoi(part); err != nil || n > 255 {
+			return false
+		}
+	}
+	return s != "0.0.0.0"
+}
+
+// Entropy returns the Shannon entropy of data in bits per byte, from 0
+// for a repeated byte to 8 for random data
+func Entropy(data []byte) float64 {
+	var counts [256]int64
+	for _, b := range data {
+		counts[b]++
+	}
+	return entropyOf(counts, int64(len(data)))
+}
+
+func entropyOf(counts [256]int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return math.Round(entropy*1000) / 1000
+}
+
+// sectionEntropy reads a section's bytes from the file for its entropy
+func sectionEntropy(r io.ReaderAt, offset, size uint64) float64 {
+	if size == 0 {
+		return 0
+	}
+	var counts [256]int64
+	sr := io.NewSectionReader(r, int64(offset), int64(size))
+	buf := make([]byte, 64*1024)
+	var total int64
+	for {
+		n, err := sr.Read(buf)
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		total += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return entropyOf(counts, total)
+}
+
+// packerSections are section names that packers and protectors leave
+var packerSections = map[string]string{
+	"UPX0": "UPX", "UPX1": "UPX", "UPX2": "UPX", ".upx": "UPX",
+	".aspack": "ASPack", ".adata": "ASPack",
+	".MPRESS1": "MPRESS", ".MPRESS2": "MPRESS",
+	".petite": "Petite",
+	".nsp0":   "NsPack", ".nsp1": "NsPack", ".nsp2": "NsPack",
+	".themida": "Themida", ".winlice": "WinLicense",
+	".vmp0": "VMProtect", ".vmp1": "VMProtect", ".vmp2": "VMProtect",
+	".enigma1": "Enigma Protector", ".enigma2": "Enigma Protector",
+	"PEC2": "PECompact", "pec1": "PECompact", ".pec": "PECompact",
+	".MaskPE": "MaskPE", ".perplex": "Perplex",
+	".packed": "generic packer", ".RLPack": "RLPack",
+}
+
+// marks are strings that packers leave in the files they pack. They must
+// match a whole string, so that tools which mention them are not flagged.
+var marks = map[string]string{
+	"$Info: This file is packed with the UPX executable packer http://upx.sf.net $": "UPX",
+}
+
+func (a *Analysis) addPacker(name, evidence string) {
+	for _, p := range a.Packers {
+		if p == name {
+			return
+		}
+	}
+	a.Packers = append(a.Packers, name)
+	a.Indicators = append(a.Indicators, name+" packer: "+evidence)
+}
+
+// detectPackers applies the heuristics that do not depend on the format:
+// packer section names, encrypted or compressed code, sections that are
+// writable and executable, and code that is only unpacked in memory
+func (a *Analysis) detectPackers() {
+	for _, s := range a.Sections {
+		if p, ok := packerSections[s.Name]; ok {
+			a.addPacker(p, "section "+s.Name)
+		}
+	}
+	highEntropyCode := false
+	for _, s := range a.Sections {
+		exec := strings.Contains(s.Flags, "x")
+		switch {
+		case exec && s.Entropy > 7.2:
+			highEntropyCode = true
+			a.Indicators = append(a.Indicators, fmt.Sprintf("executable section %s has entropy %.2f, typical of compressed or encrypted code", s.Name, s.Entropy))
+		case exec && strings.Contains(s.Flags, "w") && a.Format != "ELF":
+			// ELF object files and some loaders have legitimate rwx
+			// sections; in PE and Mach-O they are a packer's stub
+			a.Indicators = append(a.Indicators, fmt.Sprintf("section %s is writable and executable", s.Name))
+		}
+		if exec && s.Size == 0 && s.VirtualSize > 0 {
+			a.Indicators = append(a.Indicators, fmt.Sprintf("executable section %s is empty in the file but %d bytes in memory", s.Name, s.VirtualSize))
+		}
+	}
+	if a.EntryPoint != 0 && len(a.Sections) > 0 {
+		if s := a.sectionAt(a.EntryPoint - a.ImageBase); s == nil {
+			a.Indicators = append(a.Indicators, "entry point is outside every section")
+		} else if !strings.Contains(s.Flags, "x") {
+			a.Indicators = append(a.Indicators, fmt.Sprintf("entry point is in non-executable section %s", s.Name))
+		}
+	}
+	fewImports := a.Format == "PE" && len(a.Imports) > 0 && len(a.Imports) <= 5
+	if fewImports && a.imports("LoadLibraryA", "LoadLibraryW", "GetProcAddress") {
+		a.Indicators = append(a.Indicators, fmt.Sprintf("only %d imports, with runtime linking through LoadLibrary and GetProcAddress", len(a.Imports)))
+	}
+	a.Packed = len(a.Packers) > 0 || highEntropyCode && (fewImports || a.Entropy > 7)
+	sort.Strings(a.Packers)
+}
+
+// sectionAt returns the section loaded at a virtual address
+func (a *Analysis) sectionAt(addr uint64) *Section {
+	for i, s := range a.Sections {
+		size := s.VirtualSize
+		if s.Size > size {
+			size = s.Size
+		}
+		if addr >= s.VirtualAddress && addr < s.VirtualAddress+size {
+			return &a.Sections[i]
+		}
+	}
+	return nil
+}
+
+// imports reports whether any of the functions is imported
+func (a *Analysis) imports(functions ...string) bool {
+	for _, imp := range a.Imports {
+		for _, fn := range functions {
+			if imp.Function == fn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flags formats read, write and execute permissions as r, w and x
+func flags(r, w, x bool) string {
+	b := []byte("---")
+	if r {
+		b[0] = 'r'
+	}
+	if w {
+		b[1] = 'w'
+	}
+	if x {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+// AnalysisToMap converts an analysis to a map
+func AnalysisToMap(a *Analysis) map[string]interface{} {
+	sections := make([]interface{}, len(a.Sections))
+	for i, s := range a.Sections {
+		sections[i] = map[string]interface{}{
+			"name":            s.Name,
+			"virtual_address": int64(s.VirtualAddress),
+			"virtual_size":    int64(s.VirtualSize),
+			"offset":          int64(s.Offset),
+			"size":            int64(s.Size),
+			"flags":           s.Flags,
+			"entropy":         s.Entropy,
+		}
+	}
+	imports := make([]interface{}, len(a.Imports))
+	for i, imp := range a.Imports {
+		imports[i] = map[string]interface{}{"library": imp.Library, "function": imp.Function}
+	}
+	protections := make(map[string]interface{}, len(a.Protections))
+	for k, v := range a.Protections {
+		protections[k] = v
+	}
+	return map[string]interface{}{
+		"path":        a.Path,
+		"format":      a.Format,
+		"arch":        a.Arch,
+		"bits":        a.Bits,
+		"type":        a.Type,
+		"entry_point": int64(a.EntryPoint),
+		"image_base":  int64(a.ImageBase),
+		"size":        a.Size,
+		"sha256":      a.SHA256,
+		"entropy":     a.Entropy,
+		"sections":    sections,
+		"libraries":   stringList(a.Libraries),
+		"imports":     imports,
+		"exports":     stringList(a.Exports),
+		"stripped":    a.Stripped,
+		"signed":      a.Signed,
+		"signature":   a.Signature,
+		"protections": protections,
+		"packers":     stringList(a.Packers),
+		"packed":      a.Packed,
+		"indicators":  stringList(a.Indicators),
+		"urls":        stringList(a.URLs),
+		"ips":         stringList(a.IPs),
+		"arches":      stringList(a.Arches),
+	}
+}
+
+// StringToMap converts a string found in a file to a map
+func StringToMap(s String) map[string]interface{} {
+	return map[string]interface{}{"offset": s.Offset, "value": s.Value, "encoding": s.Encoding}
+}
+
+func stringList(items []string) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, s := range items {
+		list[i] = s
+	}
+	return list
+}
@@ -0,0 +1,177 @@
+package binaryanalysis
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// upxDLL builds a 64-bit DLL laid out like a UPX-packed one: an empty
+// UPX0, random data in UPX1, and an export directory naming two functions
+func upxDLL(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     3,
+		SizeOfOptionalHeader: uint16(binary.Size(pe.OptionalHeader64{})),
+		Characteristics:      pe.IMAGE_FILE_DLL | pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	})
+	oh := pe.OptionalHeader64{
+		Magic:               0x20b,
+		AddressOfEntryPoint: 0x2000,
+		ImageBase:           0x180000000,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfImage:         0x4000,
+		SizeOfHeaders:       0x200,
+		Subsystem:           pe.IMAGE_SUBSYSTEM_WINDOWS_GUI,
+		DllCharacteristics: pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE |
+			pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT,
+		NumberOfRvaAndSizes: 16,
+	}
+	oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_EXPORT] = pe.DataDirectory{VirtualAddress: 0x3000, Size: 0x60}
+	oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY] = pe.DataDirectory{VirtualAddress: 0x1400, Size: 8}
+	binary.Write(&buf, binary.LittleEndian, oh)
+
+	rwx := uint32(pe.IMAGE_SCN_MEM_READ | pe.IMAGE_SCN_MEM_WRITE | pe.IMAGE_SCN_MEM_EXECUTE)
+	for _, s := range []struct {
+		name                 string
+		va, vsize, raw, size uint32
+		flags                uint32
+	}{
+		{"UPX0", 0x1000, 0x1000, 0, 0, rwx | pe.IMAGE_SCN_CNT_UNINITIALIZED_DATA},
+		{"UPX1", 0x2000, 0x1000, 0x200, 0x1000, rwx | pe.IMAGE_SCN_CNT_INITIALIZED_DATA},
+		{".edata", 0x3000, 0x200, 0x1200, 0x200, pe.IMAGE_SCN_MEM_READ | pe.IMAGE_SCN_CNT_INITIALIZED_DATA},
+	} {
+		h := pe.SectionHeader32{VirtualSize: s.vsize, VirtualAddress: s.va,
+			SizeOfRawData: s.size, PointerToRawData: s.raw, Characteristics: s.flags}
+		copy(h.Name[:], s.name)
+		binary.Write(&buf, binary.LittleEndian, h)
+	}
+	buf.Write(make([]byte, 0x200-buf.Len()))
+
+	packed := make([]byte, 0x1000)
+	rand.New(rand.NewSource(1)).Read(packed)
+	buf.Write(packed)
+
+	edata := make([]byte, 0x200)
+	binary.LittleEndian.PutUint32(edata[24:], 2)      // NumberOfNames
+	binary.LittleEndian.PutUint32(edata[32:], 0x3028) // AddressOfNames
+	binary.LittleEndian.PutUint32(edata[0x28:], 0x3040)
+	binary.LittleEndian.PutUint32(edata[0x2c:], 0x3050)
+	copy(edata[0x40:], "ServiceMain\x00")
+	copy(edata[0x50:], "Install\x00")
+	copy(edata[0x100:], "http://198.51.100.7/stage2.bin\x00")
+	buf.Write(edata)
+	buf.Write(make([]byte, 8)) // The certificate table
+	return buf.Bytes()
+}
+
+func TestAnalyzePE(t *testing.T) {
+	data := upxDLL(t)
+	a, err := AnalyzeReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Format != "PE" || a.Arch != "amd64" || a.Bits != 64 || a.Type != "library" {
+		t.Errorf("got %s %s %d-bit %s", a.Format, a.Arch, a.Bits, a.Type)
+	}
+	if a.EntryPoint != 0x180002000 || len(a.Sections) != 3 || a.Sections[1].Flags != "rwx" {
+		t.Errorf("entry point %#x, sections %+v", a.EntryPoint, a.Sections)
+	}
+	if a.Sections[1].Entropy < 7.5 || a.Sections[0].Entropy != 0 {
+		t.Errorf("section entropy %v and %v", a.Sections[0].Entropy, a.Sections[1].Entropy)
+	}
+	if len(a.Exports) != 2 || a.Exports[0] != "Install" || a.Exports[1] != "ServiceMain" {
+		t.Errorf("exports %v", a.Exports)
+	}
+	if !a.Signed || a.Signature != "Authenticode" {
+		t.Errorf("signature %q", a.Signature)
+	}
+	if !a.Protections["aslr"] || !a.Protections["nx"] || a.Protections["cfg"] {
+		t.Errorf("protections %v", a.Protections)
+	}
+	if !a.Packed || len(a.Packers) != 1 || a.Packers[0] != "UPX" {
+		t.Errorf("packers %v, indicators %v", a.Packers, a.Indicators)
+	}
+	if len(a.URLs) != 1 || a.URLs[0] != "http://198.51.100.7/stage2.bin" || len(a.IPs) != 1 {
+		t.Errorf("urls %v, ips %v", a.URLs, a.IPs)
+	}
+	if m := AnalysisToMap(a); m["format"] != "PE" || len(m["sections"].([]interface{})) != 3 {
+		t.Errorf("map %v", m)
+	}
+}
+
+func TestAnalyzeExecutable(t *testing.T) {
+	path, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	a, err := Analyze(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := map[string]string{"linux": "ELF", "windows": "PE", "darwin": "Mach-O"}[runtime.GOOS]
+	if format != "" && a.Format != format {
+		t.Errorf("format %s on %s", a.Format, runtime.GOOS)
+	}
+	if a.Type != "executable" || len(a.Sections) == 0 || len(a.SHA256) != 64 || a.Packed {
+		t.Errorf("got %s with %d sections, sha256 %q, packed %v: %v", a.Type, len(a.Sections), a.SHA256, a.Packed, a.Indicators)
+	}
+}
+
+func TestAnalyzeRejectsOtherFiles(t *testing.T) {
+	data := []byte("#!/bin/sh\necho hi\n")
+	if _, err := AnalyzeReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("expected an error for a shell script")
+	}
+	// Java class files share the universal Mach-O magic
+	class := []byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 52, 0, 0}
+	if _, err := AnalyzeReader(bytes.NewReader(class), int64(len(class))); err == nil {
+		t.Error("expected an error for a Java class file")
+	}
+}
+
+func TestStrings(t *testing.T) {
+	var data []byte
+	data = append(data, 0x01, 0x02)
+	data = append(data, "cmd.exe /c whoami\x00\xff"...)
+	data = append(data, 0x03) // Puts the wide string at an odd offset
+	for _, c := range "powershell" {
+		data = append(data, byte(c), 0)
+	}
+	data = append(data, 0xff, 0xff, 'a', 'b', 0x00)
+	path := filepath.Join(t.TempDir(), "sample.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := Strings(path, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("got %+v", found)
+	}
+	if found[0] != (String{Offset: 2, Value: "cmd.exe /c whoami", Encoding: "ascii"}) {
+		t.Errorf("got %+v", found[0])
+	}
+	if found[1] != (String{Offset: 22, Value: "powershell", Encoding: "utf-16le"}) {
+		t.Errorf("got %+v", found[1])
+	}
+	if limited, _ := Strings(path, 4, 1); len(limited) != 1 {
+		t.Errorf("max 1 returned %d strings", len(limited))
+	}
+}
@@ -0,0 +1,164 @@
+package binaryanalysis
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var elfMachines = map[elf.Machine]string{
+	elf.EM_386:     "386",
+	elf.EM_X86_64:  "amd64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_MIPS:    "mips",
+	elf.EM_PPC:     "ppc",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_RISCV:   "riscv",
+	elf.EM_S390:    "s390x",
+	elf.EM_SPARCV9: "sparc64",
+}
+
+// moduleSignature ends Linux kernel modules that are signed
+const moduleSignature = "~Module signature appended~\n"
+
+func analyzeELF(r io.ReaderAt, a *Analysis) error {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a.Format = "ELF"
+	a.Arch = elfMachines[f.Machine]
+	if a.Arch == "" {
+		a.Arch = strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+	a.Bits = 32
+	if f.Class == elf.ELFCLASS64 {
+		a.Bits = 64
+	}
+	a.EntryPoint = f.Entry
+
+	var interp, stack, relro *elf.Prog
+	for _, p := range f.Progs {
+		switch p.Type {
+		case elf.PT_INTERP:
+			interp = p
+		case elf.PT_GNU_STACK:
+			stack = p
+		case elf.PT_GNU_RELRO:
+			relro = p
+		}
+	}
+	switch f.Type {
+	case elf.ET_EXEC:
+		a.Type = "executable"
+	case elf.ET_DYN:
+		// Position independent executables are shared objects with an
+		// interpreter
+		a.Type = "library"
+		if interp != nil {
+			a.Type = "executable"
+		}
+	case elf.ET_REL:
+		a.Type = "object"
+	case elf.ET_CORE:
+		a.Type = "core"
+	default:
+		a.Type = f.Type.String()
+	}
+
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		sec := Section{
+			Name:           s.Name,
+			VirtualAddress: s.Addr,
+			VirtualSize:    s.Size,
+			Offset:         s.Offset,
+			Size:           s.Size,
+			Flags: flags(s.Flags&elf.SHF_ALLOC != 0,
+				s.Flags&elf.SHF_WRITE != 0,
+				s.Flags&elf.SHF_EXECINSTR != 0),
+		}
+		if s.Type == elf.SHT_NOBITS {
+			sec.Size = 0
+		} else {
+			sec.Entropy = sectionEntropy(r, s.Offset, s.Size)
+		}
+		a.Sections = append(a.Sections, sec)
+	}
+	if len(a.Sections) == 0 {
+		// Packers strip the section headers; the loadable segments remain
+		for i, p := range f.Progs {
+			if p.Type != elf.PT_LOAD {
+				continue
+			}
+			a.Sections = append(a.Sections, Section{
+				Name:           fmt.Sprintf("LOAD%d", i),
+				VirtualAddress: p.Vaddr,
+				VirtualSize:    p.Memsz,
+				Offset:         p.Off,
+				Size:           p.Filesz,
+				Flags:          flags(p.Flags&elf.PF_R != 0, p.Flags&elf.PF_W != 0, p.Flags&elf.PF_X != 0),
+				Entropy:        sectionEntropy(r, p.Off, p.Filesz),
+			})
+		}
+	}
+
+	a.Libraries, _ = f.ImportedLibraries()
+	symbols, _ := f.ImportedSymbols()
+	for _, sym := range symbols {
+		a.Imports = append(a.Imports, Import{Library: sym.Library, Function: sym.Name})
+	}
+	dynamic, _ := f.DynamicSymbols()
+	for _, sym := range dynamic {
+		typ, bind := elf.ST_TYPE(sym.Info), elf.ST_BIND(sym.Info)
+		if sym.Section != elf.SHN_UNDEF && (bind == elf.STB_GLOBAL || bind == elf.STB_WEAK) &&
+			(typ == elf.STT_FUNC || typ == elf.STT_OBJECT) {
+			a.Exports = append(a.Exports, sym.Name)
+		}
+	}
+	sort.Strings(a.Exports)
+
+	if a.Size > int64(len(moduleSignature)) {
+		trailer := make([]byte, len(moduleSignature))
+		if _, err := r.ReadAt(trailer, a.Size-int64(len(trailer))); err == nil && string(trailer) == moduleSignature {
+			a.Signed = true
+			a.Signature = "module signature"
+		}
+	}
+
+	if f.Type == elf.ET_EXEC || f.Type == elf.ET_DYN {
+		a.Protections["nx"] = stack != nil && stack.Flags&elf.PF_X == 0
+		a.Protections["pie"] = f.Type == elf.ET_DYN
+		a.Protections["relro"] = relro != nil
+		a.Protections["full_relro"] = relro != nil && bindNow(f)
+		a.Protections["stack_canary"] = a.imports("__stack_chk_fail")
+		a.Protections["fortify"] = false
+		for _, imp := range a.Imports {
+			if strings.HasPrefix(imp.Function, "__") && strings.HasSuffix(imp.Function, "_chk") && imp.Function != "__stack_chk_fail" {
+				a.Protections["fortify"] = true
+				break
+			}
+		}
+	}
+	a.Stripped = f.Section(".symtab") == nil
+	return nil
+}
+
+// bindNow reports whether the dynamic linker resolves every symbol at
+// startup, which lets the whole GOT be made read-only
+func bindNow(f *elf.File) bool {
+	if v, _ := f.DynValue(elf.DT_BIND_NOW); len(v) > 0 {
+		return true
+	}
+	if v, _ := f.DynValue(elf.DT_FLAGS); len(v) > 0 && elf.DynFlag(v[0])&elf.DF_BIND_NOW != 0 {
+		return true
+	}
+	v, _ := f.DynValue(elf.DT_FLAGS_1)
+	return len(v) > 0 && elf.DynFlag1(v[0])&elf.DF_1_NOW != 0
+}
@@ -0,0 +1,174 @@
+package binaryanalysis
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	machoCore             = 0x4
+	machoKextBundle       = 0xb
+	machoPIE              = 0x200000
+	machoAllowStackExec   = 0x20000
+	machoNoHeapExec       = 0x1000000
+	loadCmdCodeSignature  = 0x1d
+	loadCmdMain           = 0x80000028
+	machoSectionTypeMask  = 0xff
+	machoZerofill         = 0x1
+	machoGBZerofill       = 0xc
+	machoThreadLocalZeros = 0x12
+)
+
+// isMachO reports whether magic starts a thin or universal Mach-O file
+func isMachO(magic []byte) bool {
+	switch binary.BigEndian.Uint32(magic) {
+	case macho.Magic32, macho.Magic64, 0xcefaedfe, 0xcffaedfe, macho.MagicFat:
+		return true
+	}
+	return false
+}
+
+var machoCPUs = map[macho.Cpu]string{
+	macho.Cpu386:   "386",
+	macho.CpuAmd64: "amd64",
+	macho.CpuArm:   "arm",
+	macho.CpuArm64: "arm64",
+	macho.CpuPpc:   "ppc",
+	macho.CpuPpc64: "ppc64",
+}
+
+func machoCPU(cpu macho.Cpu) string {
+	if name, ok := machoCPUs[cpu]; ok {
+		return name
+	}
+	return strings.ToLower(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
+
+func analyzeMachO(r io.ReaderAt, a *Analysis) error {
+	var f *macho.File
+	magic := make([]byte, 4)
+	r.ReadAt(magic, 0)
+	if binary.BigEndian.Uint32(magic) == macho.MagicFat {
+		// Java class files share the magic, and fail here
+		fat, err := macho.NewFatFile(r)
+		if err != nil {
+			return err
+		}
+		defer fat.Close()
+		for _, arch := range fat.Arches {
+			a.Arches = append(a.Arches, machoCPU(arch.Cpu))
+		}
+		// The first architecture is analyzed
+		f = fat.Arches[0].File
+	} else {
+		var err error
+		if f, err = macho.NewFile(r); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	a.Format = "Mach-O"
+	a.Arch = machoCPU(f.Cpu)
+	a.Bits = 32
+	if f.Magic == macho.Magic64 {
+		a.Bits = 64
+	}
+	switch f.Type {
+	case macho.TypeExec:
+		a.Type = "executable"
+	case macho.TypeDylib, macho.TypeBundle:
+		a.Type = "library"
+	case macho.TypeObj:
+		a.Type = "object"
+	case machoKextBundle:
+		a.Type = "driver"
+	case machoCore:
+		a.Type = "core"
+	default:
+		a.Type = f.Type.String()
+	}
+
+	var text *macho.Segment
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) < 8 {
+			continue
+		}
+		switch f.ByteOrder.Uint32(raw) {
+		case loadCmdCodeSignature:
+			a.Signed = true
+			a.Signature = "code signature"
+		case loadCmdMain:
+			// The entry point is an offset into __TEXT, found below
+			if len(raw) >= 16 {
+				a.EntryPoint = f.ByteOrder.Uint64(raw[8:])
+			}
+		}
+		if seg, ok := l.(*macho.Segment); ok && seg.Name == "__TEXT" {
+			text = seg
+		}
+	}
+	if a.EntryPoint != 0 && text != nil {
+		a.EntryPoint += text.Addr - text.Offset
+	}
+
+	for _, s := range f.Sections {
+		var prot uint32
+		if seg := f.Segment(s.Seg); seg != nil {
+			prot = seg.Prot
+		}
+		sec := Section{
+			Name:           s.Seg + "," + s.Name,
+			VirtualAddress: s.Addr,
+			VirtualSize:    s.Size,
+			Offset:         uint64(s.Offset),
+			Size:           s.Size,
+			Flags:          flags(prot&1 != 0, prot&2 != 0, prot&4 != 0),
+		}
+		switch s.Flags & machoSectionTypeMask {
+		case machoZerofill, machoGBZerofill, machoThreadLocalZeros:
+			sec.Size = 0
+		default:
+			// Data reads from the architecture's slice of a universal
+			// binary, which the section offset is relative to
+			if data, err := s.Data(); err == nil {
+				sec.Entropy = Entropy(data)
+			}
+		}
+		a.Sections = append(a.Sections, sec)
+	}
+
+	a.Libraries, _ = f.ImportedLibraries()
+	symbols, _ := f.ImportedSymbols()
+	for _, sym := range symbols {
+		a.Imports = append(a.Imports, Import{Function: sym})
+	}
+	a.Stripped = true
+	if f.Symtab != nil {
+		for _, sym := range f.Symtab.Syms {
+			// N_SECT: defined in the file; N_EXT: visible outside it
+			if sym.Type&0x0e != 0x0e {
+				continue
+			}
+			if sym.Type&0x01 != 0 {
+				a.Exports = append(a.Exports, sym.Name)
+			} else {
+				a.Stripped = false
+			}
+		}
+	}
+	sort.Strings(a.Exports)
+
+	if f.Type == macho.TypeExec || f.Type == macho.TypeDylib || f.Type == macho.TypeBundle {
+		a.Protections["pie"] = f.Flags&machoPIE != 0 || f.Type != macho.TypeExec
+		a.Protections["nx"] = f.Flags&machoAllowStackExec == 0
+		a.Protections["nx_heap"] = f.Flags&machoNoHeapExec != 0
+		a.Protections["stack_canary"] = a.imports("___stack_chk_fail", "___stack_chk_guard")
+		a.Protections["arc"] = a.imports("_objc_release")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package binaryanalysis
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARM:   "arm",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+	pe.IMAGE_FILE_MACHINE_IA64:  "ia64",
+}
+
+func analyzePE(r io.ReaderAt, a *Analysis) error {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a.Format = "PE"
+	a.Arch = peMachines[f.Machine]
+	if a.Arch == "" {
+		a.Arch = "unknown"
+	}
+
+	var dirs []pe.DataDirectory
+	var subsystem, dllCharacteristics uint16
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		a.Bits = 32
+		a.ImageBase = uint64(oh.ImageBase)
+		a.EntryPoint = a.ImageBase + uint64(oh.AddressOfEntryPoint)
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+		subsystem, dllCharacteristics = oh.Subsystem, oh.DllCharacteristics
+	case *pe.OptionalHeader64:
+		a.Bits = 64
+		a.ImageBase = oh.ImageBase
+		a.EntryPoint = a.ImageBase + uint64(oh.AddressOfEntryPoint)
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+		subsystem, dllCharacteristics = oh.Subsystem, oh.DllCharacteristics
+	}
+	switch {
+	case f.OptionalHeader == nil:
+		a.Type = "object"
+	case f.Characteristics&pe.IMAGE_FILE_DLL != 0:
+		a.Type = "library"
+	case subsystem == pe.IMAGE_SUBSYSTEM_NATIVE:
+		a.Type = "driver"
+	default:
+		a.Type = "executable"
+	}
+
+	for _, s := range f.Sections {
+		a.Sections = append(a.Sections, Section{
+			Name:           s.Name,
+			VirtualAddress: uint64(s.VirtualAddress),
+			VirtualSize:    uint64(s.VirtualSize),
+			Offset:         uint64(s.Offset),
+			Size:           uint64(s.Size),
+			Flags: flags(s.Characteristics&pe.IMAGE_SCN_MEM_READ != 0,
+				s.Characteristics&pe.IMAGE_SCN_MEM_WRITE != 0,
+				s.Characteristics&pe.IMAGE_SCN_MEM_EXECUTE != 0),
+			Entropy: sectionEntropy(r, uint64(s.Offset), uint64(s.Size)),
+		})
+	}
+
+	// debug/pe reports imports as "function:library"
+	symbols, _ := f.ImportedSymbols()
+	libraries := map[string]bool{}
+	for _, sym := range symbols {
+		fn, lib, _ := strings.Cut(sym, ":")
+		a.Imports = append(a.Imports, Import{Library: lib, Function: fn})
+		if !libraries[strings.ToLower(lib)] {
+			libraries[strings.ToLower(lib)] = true
+			a.Libraries = append(a.Libraries, lib)
+		}
+	}
+	a.Exports = peExports(f, dirs)
+
+	// The security directory holds the Authenticode signature, at a file
+	// offset rather than an address
+	if len(dirs) > pe.IMAGE_DIRECTORY_ENTRY_SECURITY && dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY].Size > 0 {
+		a.Signed = true
+		a.Signature = "Authenticode"
+	}
+
+	if f.OptionalHeader != nil {
+		a.Protections["aslr"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE != 0
+		a.Protections["nx"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT != 0
+		a.Protections["cfg"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF != 0
+		a.Protections["force_integrity"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_FORCE_INTEGRITY != 0
+		if a.Bits == 64 {
+			a.Protections["high_entropy_aslr"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA != 0
+		} else {
+			a.Protections["seh"] = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_NO_SEH == 0
+		}
+	}
+	return nil
+}
+
+// peExports reads the names in the export directory
+func peExports(f *pe.File, dirs []pe.DataDirectory) []string {
+	if len(dirs) <= pe.IMAGE_DIRECTORY_ENTRY_EXPORT || dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT].Size == 0 {
+		return nil
+	}
+	data := map[*pe.Section][]byte{}
+	// at returns the image from an address to the end of its section
+	at := func(rva uint32) []byte {
+		for _, s := range f.Sections {
+			if rva < s.VirtualAddress || rva >= s.VirtualAddress+max(s.VirtualSize, s.Size) {
+				continue
+			}
+			if _, ok := data[s]; !ok {
+				data[s], _ = s.Data()
+			}
+			if off := rva - s.VirtualAddress; off < uint32(len(data[s])) {
+				return data[s][off:]
+			}
+			return nil
+		}
+		return nil
+	}
+
+	dir := at(dirs[pe.IMAGE_DIRECTORY_ENTRY_EXPORT].VirtualAddress)
+	if len(dir) < 40 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint32(dir[24:])
+	names := binary.LittleEndian.Uint32(dir[32:])
+	var exports []string
+	for i := uint32(0); i < count && i < 65536; i++ {
+		ptr := at(names + 4*i)
+		if len(ptr) < 4 {
+			break
+		}
+		name := at(binary.LittleEndian.Uint32(ptr))
+		if end := bytes.IndexByte(name, 0); end > 0 {
+			exports = append(exports, string(name[:end]))
+		}
+	}
+	sort.Strings(exports)
+	return exports
+}
@@ -324,6 +324,9 @@ func LinkModules(graph *ModuleGraph) ([]byte, error) {
 	for _, stmt := range combinedAST {
 		stmt.Accept(c)
 	}
+	if len(c.Errors) > 0 {
+		return nil, c.Errors[0]
+	}
 	c.Chunk.WriteOp(0) // EOF marker
 	
 	return c.Chunk.Code, nil
@@ -0,0 +1,19 @@
+// internal/builtins/arrays.go
+package builtins
+
+import "fmt"
+
+func init() {
+	implement("array_contains", func(args []Value) (Value, error) {
+		arr, ok := args[0].([]Value)
+		if !ok {
+			return nil, fmt.Errorf("array_contains expects an array")
+		}
+		for _, elem := range arr {
+			if equal(elem, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,141 @@
+// internal/builtins/builtins.go
+
+// Package builtins is the single registry of Sentra's native functions.
+// Each entry carries the name, arity, parameter names and documentation of
+// a builtin and, for functions that do not depend on VM state, a shared
+// implementation over plain Go values. Both VMs register their natives
+// against it, and the REPL, language server and doc generator read it, so
+// they all describe the same set of functions.
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Value is a VM independent value passed to and returned by shared
+// implementations. It is one of nil, bool, int64, float64, string,
+// []Value or map[string]Value. Each VM converts its own values to and
+// from these at the call boundary.
+type Value = any
+
+// Func is a shared builtin implementation
+type Func func(args []Value) (Value, error)
+
+// Builtin describes one native function
+type Builtin struct {
+	Name     string
+	Arity    int      // -1 for variadic functions
+	Params   []string // A trailing "..." marks variadic or optional parameters
+	Returns  string
+	Doc      string
+	Category string
+	Fn       Func // Shared implementation, nil if each VM provides its own
+}
+
+// Signature renders the builtin as fn name(params) -> returns
+func (b *Builtin) Signature() string {
+	sig := "fn " + b.Name + "(" + strings.Join(b.Params, ", ") + ")"
+	if b.Returns != "" {
+		sig += " -> " + b.Returns
+	}
+	return sig
+}
+
+// ParamNames returns the parameter names without the variadic marker, as
+// used to bind keyword arguments
+func (b *Builtin) ParamNames() []string {
+	names := make([]string, len(b.Params))
+	for i, param := range b.Params {
+		names[i] = strings.TrimSuffix(param, "...")
+	}
+	return names
+}
+
+// Call runs the shared implementation after checking the argument count
+func (b *Builtin) Call(args []Value) (Value, error) {
+	if b.Fn == nil {
+		return nil, fmt.Errorf("%s has no shared implementation", b.Name)
+	}
+	if b.Arity >= 0 && len(args) != b.Arity {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", b.Name, b.Arity, len(args))
+	}
+	return b.Fn(args)
+}
+
+var (
+	registryOnce sync.Once
+	registry     map[string]*Builtin
+	sorted       []*Builtin
+	impls        = map[string]Func{}
+)
+
+// implement attaches a shared implementation to a documented builtin. It is
+// called from the init functions of this package.
+func implement(name string, fn Func) {
+	impls[name] = fn
+}
+
+func load() {
+	registryOnce.Do(func() {
+		registry = make(map[string]*Builtin)
+		for _, category := range categories {
+			for name, e := range category.entries {
+				b := &Builtin{
+					Name:     name,
+					Returns:  e.returns,
+					Doc:      e.doc,
+					Category: category.name,
+					Fn:       impls[name],
+				}
+				if e.params != "" {
+					b.Params = strings.Split(e.params, ", ")
+				}
+				b.Arity = len(b.Params)
+				if b.Arity > 0 && strings.HasSuffix(b.Params[b.Arity-1], "...") {
+					b.Arity = -1
+				}
+				registry[name] = b
+				sorted = append(sorted, b)
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Name < sorted[j].Name
+		})
+	})
+}
+
+// Lookup returns the builtin called name, or nil if there is none
+func Lookup(name string) *Builtin {
+	load()
+	return registry[name]
+}
+
+// All returns every builtin, sorted by name
+func All() []*Builtin {
+	load()
+	return sorted
+}
+
+// Shared returns the builtins that have a shared implementation, sorted by
+// name
+func Shared() []*Builtin {
+	var shared []*Builtin
+	for _, b := range All() {
+		if b.Fn != nil {
+			shared = append(shared, b)
+		}
+	}
+	return shared
+}
+
+// Categories returns the category names in reference order
+func Categories() []string {
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = category.name
+	}
+	return names
+}
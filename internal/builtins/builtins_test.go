@@ -0,0 +1,79 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	seen := make(map[string]string)
+	for _, category := range categories {
+		for name := range category.entries {
+			if other, ok := seen[name]; ok {
+				t.Errorf("%s: documented in both %s and %s", name, other, category.name)
+			}
+			seen[name] = category.name
+		}
+	}
+	for name := range impls {
+		if Lookup(name) == nil {
+			t.Errorf("%s: implemented but not documented", name)
+		}
+	}
+	for _, b := range All() {
+		if b.Doc == "" {
+			t.Errorf("%s: missing documentation", b.Name)
+		}
+	}
+	if b := Lookup("exit"); b == nil || b.Arity != -1 || b.Signature() != "fn exit(code...)" {
+		t.Errorf("exit: got %+v", b)
+	}
+}
+
+func TestSharedBuiltins(t *testing.T) {
+	tests := []struct {
+		name string
+		args []Value
+		want Value
+	}{
+		{"starts_with", []Value{"sentra", "sen"}, true},
+		{"ends_with", []Value{"sentra", "sen"}, false},
+		{"char_code", []Value{"A"}, int64(65)},
+		{"char_code", []Value{""}, int64(0)},
+		{"sql_escape", []Value{`it's "x"`}, `it''s ""x""`},
+		{"array_contains", []Value{[]Value{int64(1), 2.5}, 1.0}, true},
+		{"array_contains", []Value{[]Value{[]Value{int64(1)}}, []Value{1.0}}, true},
+		{"array_contains", []Value{[]Value{"a"}, "b"}, false},
+		{"date_add", []Value{int64(0), int64(2), "days"}, int64(172800)},
+		{"date_diff", []Value{int64(0), int64(5400), "hours"}, 1.5},
+		{"date_diff", []Value{int64(0), int64(7200), "hours"}, int64(2)},
+		{"date_format", []Value{int64(86400), "2006-01-02"}, "1970-01-02"},
+		{"parse_date", []Value{"1970-01-02", "2006-01-02"}, int64(86400)},
+	}
+	for _, tt := range tests {
+		got, err := Lookup(tt.name).Call(tt.args)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.name, tt.args, err)
+			continue
+		}
+		if !equal(got, tt.want) {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.args, got, tt.want)
+		}
+	}
+
+	if _, err := Lookup("starts_with").Call([]Value{"x"}); err == nil || !strings.Contains(err.Error(), "expects 2 arguments") {
+		t.Errorf("got %v, want an arity error", err)
+	}
+	if _, err := Lookup("date_add").Call([]Value{int64(0), int64(1), "weeks"}); err == nil {
+		t.Error("date_add accepted an unknown unit")
+	}
+}
+
+func TestReference(t *testing.T) {
+	ref := Reference()
+	for _, want := range []string{"## Core\n", "### starts_with\n", "fn starts_with(text, prefix) -> bool"} {
+		if !strings.Contains(ref, want) {
+			t.Errorf("reference is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// internal/builtins/channels.go
+package builtins
+
+import (
+	"fmt"
+
+	"sentra/internal/concurrency"
+)
+
+// Channels are values, unlike the named concurrent maps and queues. Each
+// VM wraps a *concurrency.Channel in its own channel type and passes it
+// through unchanged.
+
+func channel(name string, v Value) (*concurrency.Channel, error) {
+	ch, ok := v.(*concurrency.Channel)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a channel", name)
+	}
+	return ch, nil
+}
+
+// selectCases reads the cases of chan_select: a channel to receive from,
+// or a [channel, value] pair to send
+func selectCases(v Value) ([]concurrency.SelectCase, error) {
+	list, ok := v.([]Value)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("chan_select expects an array of cases")
+	}
+	cases := make([]concurrency.SelectCase, len(list))
+	for i, c := range list {
+		if pair, ok := c.([]Value); ok && len(pair) == 2 {
+			ch, err := channel("chan_select", pair[0])
+			if err != nil {
+				return nil, fmt.Errorf("chan_select case %d: %w", i, err)
+			}
+			cases[i] = concurrency.SelectCase{Channel: ch, Send: true, Value: pair[1]}
+			continue
+		}
+		ch, ok := c.(*concurrency.Channel)
+		if !ok {
+			return nil, fmt.Errorf("chan_select case %d must be a channel or a [channel, value] pair", i)
+		}
+		cases[i] = concurrency.SelectCase{Channel: ch}
+	}
+	return cases, nil
+}
+
+func init() {
+	implement("chan_new", withArgs("chan_new", 0, 1, func(args []Value) (Value, error) {
+		capacity := 0.0
+		if len(args) == 1 {
+			var ok bool
+			if capacity, ok = toNumber(args[0]); !ok || capacity < 0 {
+				return nil, fmt.Errorf("chan_new expects a capacity of at least 0")
+			}
+		}
+		return concurrency.NewChannel(int(capacity)), nil
+	}))
+	implement("chan_send", withArgs("chan_send", 2, 3, func(args []Value) (Value, error) {
+		ch, err := channel("chan_send", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("chan_send", args, 2, concurrency.Forever)
+		if err != nil {
+			return nil, err
+		}
+		sent, err := ch.Send(args[1], wait)
+		if err != nil {
+			return nil, fmt.Errorf("chan_send: %w", err)
+		}
+		return sent, nil
+	}))
+	implement("chan_recv", withArgs("chan_recv", 1, 2, func(args []Value) (Value, error) {
+		ch, err := channel("chan_recv", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("chan_recv", args, 1, concurrency.Forever)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := ch.Recv(wait)
+		return value, nil
+	}))
+	implement("chan_select", withArgs("chan_select", 1, 2, func(args []Value) (Value, error) {
+		cases, err := selectCases(args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("chan_select", args, 1, concurrency.Forever)
+		if err != nil {
+			return nil, err
+		}
+		index, value, ok, err := concurrency.Select(cases, wait)
+		if err != nil {
+			return nil, fmt.Errorf("chan_select case %d: %w", index, err)
+		}
+		return map[string]Value{"index": int64(index), "value": value, "ok": ok}, nil
+	}))
+	implement("chan_close", func(args []Value) (Value, error) {
+		ch, err := channel("chan_close", args[0])
+		if err != nil {
+			return nil, err
+		}
+		ch.Close()
+		return nil, nil
+	})
+	implement("chan_done", func(args []Value) (Value, error) {
+		ch, err := channel("chan_done", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.Drained(), nil
+	})
+	implement("chan_len", func(args []Value) (Value, error) {
+		ch, err := channel("chan_len", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(ch.Len()), nil
+	})
+	implement("chan_cap", func(args []Value) (Value, error) {
+		ch, err := channel("chan_cap", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(ch.Cap()), nil
+	})
+}
@@ -0,0 +1,202 @@
+// internal/builtins/concurrent.go
+package builtins
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sentra/internal/concurrency"
+)
+
+// Concurrent maps and queues are named, like worker pools and semaphores,
+// and live for the whole process, so every VM in it reaches the same ones.
+// Values are copied in and out, so a script never shares an array or map
+// with another goroutine.
+var (
+	concMu     sync.Mutex
+	concMaps   = map[string]*concurrency.Map{}
+	concQueues = map[string]*concurrency.Queue{}
+)
+
+func concMap(name string, id Value) (*concurrency.Map, error) {
+	concMu.Lock()
+	defer concMu.Unlock()
+	m, ok := concMaps[toString(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: concurrent map not found: %s", name, toString(id))
+	}
+	return m, nil
+}
+
+func concQueue(name string, id Value) (*concurrency.Queue, error) {
+	concMu.Lock()
+	defer concMu.Unlock()
+	q, ok := concQueues[toString(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: queue not found: %s", name, toString(id))
+	}
+	return q, nil
+}
+
+// optionalWait returns the wait in milliseconds at args[i], or def if it
+// is left out
+func optionalWait(name string, args []Value, i int, def time.Duration) (time.Duration, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	ms, ok := toNumber(args[i])
+	if !ok {
+		return 0, fmt.Errorf("%s expects the timeout in milliseconds", name)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// withArgs checks the argument count of a builtin with optional parameters
+func withArgs(name string, min, max int, fn Func) Func {
+	return func(args []Value) (Value, error) {
+		if len(args) < min || len(args) > max {
+			return nil, fmt.Errorf("%s expects %d to %d arguments, got %d", name, min, max, len(args))
+		}
+		return fn(args)
+	}
+}
+
+func init() {
+	implement("conc_map_create", func(args []Value) (Value, error) {
+		concMu.Lock()
+		defer concMu.Unlock()
+		id := toString(args[0])
+		if _, ok := concMaps[id]; ok {
+			return false, nil
+		}
+		concMaps[id] = concurrency.NewMap()
+		return true, nil
+	})
+	implement("conc_map_get", func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_get", args[0])
+		if err != nil {
+			return nil, err
+		}
+		value, _ := m.Get(toString(args[1]))
+		return value, nil
+	})
+	implement("conc_map_set", func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_set", args[0])
+		if err != nil {
+			return nil, err
+		}
+		m.Set(toString(args[1]), args[2])
+		return nil, nil
+	})
+	implement("conc_map_delete", func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_delete", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return m.Delete(toString(args[1])), nil
+	})
+	implement("conc_map_incr", withArgs("conc_map_incr", 2, 3, func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_incr", args[0])
+		if err != nil {
+			return nil, err
+		}
+		delta := 1.0
+		if len(args) == 3 {
+			var ok bool
+			if delta, ok = toNumber(args[2]); !ok {
+				return nil, fmt.Errorf("conc_map_incr expects a numeric amount")
+			}
+		}
+		var current Value
+		m.Update(toString(args[1]), func(value interface{}, ok bool) interface{} {
+			n, _ := toNumber(value)
+			current = number(n + delta)
+			return current
+		})
+		return current, nil
+	}))
+	implement("conc_map_keys", func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_keys", args[0])
+		if err != nil {
+			return nil, err
+		}
+		keys := m.Keys()
+		result := make([]Value, len(keys))
+		for i, key := range keys {
+			result[i] = key
+		}
+		return result, nil
+	})
+	implement("conc_map_len", func(args []Value) (Value, error) {
+		m, err := concMap("conc_map_len", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(m.Len()), nil
+	})
+
+	implement("conc_queue_create", func(args []Value) (Value, error) {
+		capacity, ok := toNumber(args[1])
+		if !ok || capacity < 1 {
+			return nil, fmt.Errorf("conc_queue_create expects a capacity of at least 1")
+		}
+		concMu.Lock()
+		defer concMu.Unlock()
+		id := toString(args[0])
+		if _, ok := concQueues[id]; ok {
+			return false, nil
+		}
+		concQueues[id] = concurrency.NewQueue(int(capacity))
+		return true, nil
+	})
+	implement("conc_queue_push", withArgs("conc_queue_push", 2, 3, func(args []Value) (Value, error) {
+		q, err := concQueue("conc_queue_push", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("conc_queue_push", args, 2, 0)
+		if err != nil {
+			return nil, err
+		}
+		pushed, err := q.Push(args[1], wait)
+		if err != nil {
+			return nil, fmt.Errorf("conc_queue_push: %s: %w", toString(args[0]), err)
+		}
+		return pushed, nil
+	}))
+	implement("conc_queue_pop", withArgs("conc_queue_pop", 1, 2, func(args []Value) (Value, error) {
+		q, err := concQueue("conc_queue_pop", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("conc_queue_pop", args, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := q.Pop(wait)
+		return value, nil
+	}))
+	implement("conc_queue_close", func(args []Value) (Value, error) {
+		q, err := concQueue("conc_queue_close", args[0])
+		if err != nil {
+			return nil, err
+		}
+		q.Close()
+		return nil, nil
+	})
+	implement("conc_queue_done", func(args []Value) (Value, error) {
+		q, err := concQueue("conc_queue_done", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return q.Closed(), nil
+	})
+	implement("conc_queue_len", func(args []Value) (Value, error) {
+		q, err := concQueue("conc_queue_len", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(q.Len()), nil
+	})
+}
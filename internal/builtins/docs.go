@@ -0,0 +1,837 @@
+// internal/builtins/docs.go
+package builtins
+
+type entry struct {
+	params  string // Comma separated parameter names
+	returns string
+	doc     string
+}
+
+// categories documents every builtin, grouped as in the generated
+// reference. Every function either VM registers needs an entry here (see
+// the builtins tests in both VMs).
+var categories = []struct {
+	name    string
+	entries map[string]entry
+}{
+	{"Core", map[string]entry{
+		"print":      {"value", "", "Prints a value to stdout followed by a newline."},
+		"log":        {"value", "", "Prints a value to stdout followed by a newline."},
+		"len":        {"value", "int", "Returns the length of a string, array or bytes, or the number of keys in a map."},
+		"typeof":     {"value", "string", "Returns the type name of a value."},
+		"type":       {"value", "string", "Returns the type name of a value."},
+		"str":        {"value", "string", "Converts a value to its string form."},
+		"range":      {"start, end", "array", "Returns the integers from start up to (not including) end."},
+		"keys":       {"map", "array", "Returns the keys of a map."},
+		"has_key":    {"map, key", "bool", "Reports whether a map contains key."},
+		"clone":      {"value", "any", "Returns a shallow copy of an array or map, or any other value as is. The copy shares nothing with the original once either is changed."},
+		"deep_clone": {"value", "any", "Returns a copy of an array or map and of every array and map inside it."},
+		"sleep":      {"ms", "", "Pauses execution for ms milliseconds."},
+		"exit":       {"code...", "", "Ends the script with an exit code, 0 by default, after running the on_exit handlers."},
+	}},
+	{"Signals", map[string]entry{
+		"on_signal": {"signal, handler", "", "Calls handler with the signal name when the process receives signal, such as \"SIGINT\", instead of exiting."},
+		"on_exit":   {"handler", "", "Calls handler when the script ends, including by exit or an unhandled SIGINT, SIGTERM or SIGHUP."},
+	}},
+	{"Logging", map[string]entry{
+		"log_debug":       {"message, fields...", "", "Logs message at debug level with an optional map of fields."},
+		"log_info":        {"message, fields...", "", "Logs message at info level with an optional map of fields."},
+		"log_warn":        {"message, fields...", "", "Logs message at warn level with an optional map of fields."},
+		"log_error":       {"message, fields...", "", "Logs message at error level with an optional map of fields."},
+		"log_set_level":   {"level", "string", "Sets the minimum level logged, one of debug, info, warn or error, and returns the previous one."},
+		"log_set_fields":  {"fields", "", "Sets fields added to every log record, or clears them when fields is null."},
+		"log_add_sink":    {"kind, options...", "string", "Sends logs to stderr, stdout, a file or syslog, replacing the default stderr output, and returns the sink id."},
+		"log_remove_sink": {"id", "bool", "Stops sending logs to a sink, returning whether it existed."},
+	}},
+	{"Metrics", map[string]entry{
+		"metrics_counter":   {"name, help, options...", "string", "Defines a counter. options may list its label names as labels."},
+		"metrics_gauge":     {"name, help, options...", "string", "Defines a gauge. options may list its label names as labels."},
+		"metrics_histogram": {"name, help, options...", "string", "Defines a histogram. options may list its label names as labels and its bucket bounds as buckets."},
+		"metrics_inc":       {"name, amount...", "", "Adds amount, 1 by default, to a counter or gauge. A map of label values may follow."},
+		"metrics_dec":       {"name, amount...", "", "Subtracts amount, 1 by default, from a gauge. A map of label values may follow."},
+		"metrics_set":       {"name, value, labels...", "", "Sets a gauge."},
+		"metrics_observe":   {"name, value, labels...", "", "Records a value in a histogram."},
+		"metrics_get":       {"name, labels...", "number", "Returns the value of a counter or gauge, or a map of count and sum for a histogram."},
+		"metrics_expose":    {"", "string", "Returns every metric in the Prometheus text format."},
+		"metrics_serve":     {"address", "string", "Serves the metrics at /metrics on address, such as \":9464\", in the background and returns the address."},
+		"metrics_shutdown":  {"", "bool", "Stops serving the metrics, returning whether they were being served."},
+	}},
+	{"Scheduler", map[string]entry{
+		"schedule_every":  {"interval, fn", "string", "Schedules fn to run every interval, such as \"30s\", \"5m\" or \"1d\" or a number of milliseconds, and returns the job id."},
+		"schedule_cron":   {"expr, fn", "string", "Schedules fn on a five field cron expression such as \"0 2 * * *\" and returns the job id."},
+		"schedule_cancel": {"id", "bool", "Cancels a scheduled job, returning whether it existed."},
+		"schedule_jobs":   {"", "array", "Returns the scheduled jobs, soonest first, as maps with id, spec, next_run, last_run and runs."},
+		"schedule_start":  {"", "", "Runs scheduled jobs as they fall due until schedule_stop is called or no jobs are left."},
+		"schedule_stop":   {"", "", "Makes schedule_start return once the running job finishes."},
+	}},
+	{"Strings", map[string]entry{
+		"upper":              {"str", "string", "Converts a string to upper case."},
+		"lower":              {"str", "string", "Converts a string to lower case."},
+		"trim":               {"str", "string", "Removes leading and trailing whitespace."},
+		"split":              {"str, sep", "array", "Splits a string on every occurrence of sep."},
+		"join":               {"array, sep", "string", "Joins array elements into a string separated by sep."},
+		"replace":            {"str, old, new", "string", "Replaces every occurrence of old with new."},
+		"contains":           {"str, substr", "bool", "Reports whether substr is within str."},
+		"startswith":         {"str, prefix", "bool", "Reports whether str begins with prefix."},
+		"endswith":           {"str, suffix", "bool", "Reports whether str ends with suffix."},
+		"char_at":            {"str, index", "string", "Returns the character at index."},
+		"slice":              {"value, start, end...", "any", "Returns a copy of value from start up to end, like value[start:end]."},
+		"index_of":           {"str, substr", "int", "Returns the index of the first occurrence of substr, or -1."},
+		"char":               {"code", "string", "Returns the character with the given character code."},
+		"is_alphanumeric":    {"str", "bool", "Reports whether str contains only letters and digits."},
+		"starts_with":        {"text, prefix", "bool", "Reports whether text starts with prefix."},
+		"ends_with":          {"text, suffix", "bool", "Reports whether text ends with suffix."},
+		"char_code":          {"s", "int", "Returns the code of the first byte of s, or 0 if empty."},
+		"match":              {"text, pattern", "bool", "Reports whether text matches a regular expression."},
+		"split_string":       {"str, sep", "array", "Alias of split."},
+		"join_strings":       {"array, sep", "string", "Alias of join."},
+		"string_contains":    {"str, substr", "bool", "Alias of contains."},
+		"string_starts_with": {"str, prefix", "bool", "Alias of startswith."},
+		"string_ends_with":   {"str, suffix", "bool", "Alias of endswith."},
+		"string_lower":       {"str", "string", "Alias of lower."},
+		"string_upper":       {"str", "string", "Alias of upper."},
+		"string_index":       {"str, substr", "int", "Alias of index_of."},
+		"string_substring":   {"str, start, end", "string", "Returns the part of str between start and end."},
+		"string_trim":        {"str, cutset...", "string", "Trims whitespace, or the characters in cutset, from both ends."},
+		"string_replace":     {"str, old, new", "string", "Alias of replace."},
+		"string_to_int":      {"str", "int", "Parses an integer, returning 0 if str is not a number."},
+		"string_to_float":    {"str", "float", "Parses a float, returning 0 if str is not a number."},
+		"string_to_bytes":    {"str", "array", "Returns the bytes of a string as an array of integers."},
+		"bytes_to_string":    {"bytes", "string", "Builds a string from bytes or an array of byte values."},
+		"bytes":              {"value, encoding...", "bytes", "Converts a string (utf8, hex or base64) or an array of byte values to bytes."},
+		"byte_at":            {"str, index", "int", "Returns the byte value at index."},
+	}},
+	{"Conversion", map[string]entry{
+		"parse_int":   {"str", "int", "Parses an integer from a string."},
+		"parse_float": {"str", "float", "Parses a float from a string."},
+	}},
+	{"Hex", map[string]entry{
+		"char_from_hex": {"hex", "string", "Decodes a hex byte such as \"41\" into a character."},
+		"hex_from_char": {"str", "string", "Returns the hex code of the first character."},
+		"hex_to_int":    {"hex", "int", "Parses a hexadecimal string."},
+		"int_to_hex":    {"value", "string", "Formats an integer as hexadecimal."},
+		"byte_to_hex":   {"value", "string", "Formats a byte as two hex digits."},
+	}},
+	{"Math", map[string]entry{
+		"abs":   {"n", "number", "Returns the absolute value."},
+		"sqrt":  {"n", "float", "Returns the square root."},
+		"floor": {"n", "int", "Rounds down to the nearest integer."},
+		"ceil":  {"n", "int", "Rounds up to the nearest integer."},
+		"round": {"n", "int", "Rounds to the nearest integer."},
+		"pow":   {"base, exp", "float", "Returns base raised to exp."},
+		"min":   {"a, b", "number", "Returns the smaller of two numbers."},
+		"max":   {"a, b", "number", "Returns the larger of two numbers."},
+		"sin":   {"n", "float", "Returns the sine of n radians."},
+		"cos":   {"n", "float", "Returns the cosine of n radians."},
+		"tan":   {"n", "float", "Returns the tangent of n radians."},
+	}},
+	{"Random", map[string]entry{
+		"random":              {"", "float", "Returns a random float in [0, 1)."},
+		"randint":             {"min, max", "int", "Returns a random integer between min and max."},
+		"random_int":          {"min, max", "int", "Returns a random integer between min and max."},
+		"generate_random":     {"length", "string", "Returns length cryptographically random bytes."},
+		"generate_random_hex": {"length", "string", "Returns a random hex string of length bytes."},
+		"generate_id":         {"", "string", "Returns a random unique identifier."},
+	}},
+	{"Arrays", map[string]entry{
+		"sort":           {"array", "array", "Returns a sorted copy of an array."},
+		"push":           {"array, value", "array", "Appends value to an array."},
+		"pop":            {"array", "any", "Removes and returns the last element."},
+		"remove":         {"array, index", "any", "Removes and returns the element at index."},
+		"insert":         {"array, index, value", "array", "Inserts value at index."},
+		"first":          {"array", "any", "Returns the first element, or nil if empty."},
+		"last":           {"array", "any", "Returns the last element, or nil if empty."},
+		"shift":          {"array", "any", "Removes and returns the first element."},
+		"unshift":        {"array, value", "array", "Prepends value to an array."},
+		"reverse":        {"array", "array", "Returns the elements in reverse order."},
+		"sum":            {"array", "number", "Returns the sum of the elements."},
+		"avg":            {"array", "float", "Returns the mean of the elements."},
+		"min_arr":        {"array", "number", "Returns the smallest element."},
+		"max_arr":        {"array", "number", "Returns the largest element."},
+		"unique":         {"array", "array", "Returns the elements with duplicates removed."},
+		"flatten":        {"array", "array", "Flattens one level of nested arrays."},
+		"zip":            {"array1, array2", "array", "Pairs up elements of two arrays."},
+		"enumerate":      {"array", "array", "Returns [index, value] pairs."},
+		"count":          {"array, value", "int", "Counts the elements equal to value."},
+		"fill":           {"n, value", "array", "Returns an array of n copies of value."},
+		"filter":         {"array, fn", "array", "Returns the elements for which fn returns a truthy value."},
+		"array_contains": {"array, value", "bool", "Reports whether an array contains value."},
+		"array_sort":     {"array", "array", "Returns a sorted copy of an array."},
+		"clear":          {"array", "array", "Removes every element of an array."},
+	}},
+	{"Date and time", map[string]entry{
+		"date":             {"", "string", "Returns the current date as YYYY-MM-DD."},
+		"time":             {"", "int", "Returns the current Unix time in seconds."},
+		"time_ms":          {"", "int", "Returns the current Unix time in milliseconds."},
+		"timestamp":        {"", "int", "Alias of time_ms."},
+		"now":              {"", "string", "Returns the current local time."},
+		"datetime":         {"", "string", "Returns the current date and time."},
+		"time_now":         {"", "int", "Returns the current Unix time in seconds."},
+		"format_timestamp": {"timestamp", "string", "Formats a Unix timestamp as a readable date and time."},
+		"format_time":      {"timestamp, format", "string", "Formats a Unix timestamp using a Go layout string."},
+		"date_add":         {"timestamp, amount, unit", "int", "Adds an amount of seconds, minutes, hours, days, months or years to a Unix timestamp."},
+		"date_diff":        {"from, to, unit", "float", "Returns the time between two timestamps in seconds, minutes, hours or days."},
+		"date_format":      {"timestamp, format", "string", "Formats a Unix timestamp using a Go layout string."},
+		"parse_date":       {"text, format", "int", "Parses a date with a Go layout string and returns its Unix timestamp."},
+	}},
+	{"JSON", map[string]entry{
+		"json_encode":    {"value", "string", "Encodes a value as JSON."},
+		"json_decode":    {"json", "any", "Decodes a JSON string."},
+		"json_parse":     {"json", "any", "Alias of json_decode."},
+		"json_stringify": {"value", "string", "Alias of json_encode."},
+	}},
+	{"CSV", map[string]entry{
+		"csv_parse":  {"text, options...", "array", "Parses CSV text into an array of rows."},
+		"csv_rows":   {"path, options...", "iterator", "Iterates over the rows of a CSV file without reading it whole."},
+		"csv_encode": {"rows, options...", "string", "Encodes an array of maps or arrays as CSV."},
+		"csv_write":  {"path, rows, options...", "", "Writes an array of maps or arrays to a CSV file."},
+	}},
+	{"Files", map[string]entry{
+		"read_file":          {"path", "string", "Reads a whole file."},
+		"write_file":         {"path, content", "bool", "Writes content to a file, replacing it."},
+		"file_exists":        {"path", "bool", "Reports whether a file exists."},
+		"append_file":        {"path, content", "bool", "Appends content to a file, creating it if needed."},
+		"list_dir":           {"path", "array", "Lists the names of the entries in a directory."},
+		"mkdir":              {"path", "bool", "Creates a directory and any missing parents."},
+		"remove_file":        {"path", "bool", "Deletes a file or empty directory."},
+		"rename_file":        {"from, to", "bool", "Renames or moves a file."},
+		"file_read":          {"path", "string", "Alias of read_file."},
+		"file_stat":          {"path", "map", "Returns size, mode and modification time of a file."},
+		"file_open":          {"path", "string", "Opens a file for streaming and returns its handle."},
+		"file_read_line":     {"handle", "string", "Reads the next line of an open file, or null at the end."},
+		"file_read_chunk":    {"handle, size", "bytes", "Reads up to size bytes of an open file, or null at the end."},
+		"file_close":         {"handle", "bool", "Closes a file opened with file_open."},
+		"file_lines":         {"path", "iterator", "Iterates over the lines of a file without reading it whole."},
+		"fs_hash":            {"path, algorithm", "bytes", "Hashes a file with md5, sha1 or sha256, returning the digest."},
+		"fs_verify_checksum": {"path, expected, algorithm", "bool", "Checks a file against an expected hash."},
+		"fs_info":            {"path", "map", "Returns detailed file metadata including permissions."},
+	}},
+	{"Compression", map[string]entry{
+		"gzip_compress":      {"data", "array", "Gzip-compresses a string, returning bytes."},
+		"gzip_decompress":    {"bytes", "string", "Decompresses gzip bytes."},
+		"deflate_compress":   {"data", "array", "Deflate-compresses a string, returning bytes."},
+		"deflate_decompress": {"bytes", "string", "Decompresses deflate bytes."},
+	}},
+	{"Regex", map[string]entry{
+		"regex_match":    {"pattern, text", "bool", "Reports whether text matches pattern."},
+		"regex_find":     {"pattern, text", "string", "Returns the first match of pattern in text."},
+		"regex_find_all": {"pattern, text", "array", "Returns all matches of pattern in text."},
+		"regex_replace":  {"pattern, replacement, text", "string", "Replaces every match of pattern."},
+		"regex_split":    {"pattern, text", "array", "Splits text around matches of pattern."},
+	}},
+	{"HTTP client", map[string]entry{
+		"http_get":      {"url", "map", "Sends a GET request and returns status, headers and body."},
+		"http_post":     {"url, body, headers...", "map", "Sends a POST request; a map body is sent as JSON."},
+		"http_request":  {"method, url, headers, body", "map", "Sends an HTTP request with custom headers."},
+		"http_json":     {"method, url, data", "map", "Sends data as JSON and decodes a JSON response."},
+		"http_download": {"url", "string", "Downloads a URL and returns the body."},
+		"fetch":         {"url", "string", "Fetches a URL and returns the body."},
+		"http_put":      {"url, body, headers", "map", "Sends a PUT request."},
+		"http_delete":   {"url", "map", "Sends a DELETE request."},
+	}},
+	{"HTTP server", map[string]entry{
+		"http_server_create":    {"address, port", "string", "Creates an HTTP server and returns its id."},
+		"http_server_start":     {"server_id", "bool", "Starts serving requests in the background."},
+		"http_server_stop":      {"server_id", "bool", "Stops a running server."},
+		"http_server_add_route": {"server_id, method, path, handler", "bool", "Adds a route to a server."},
+		"http_server_static":    {"server_id, url_path, directory", "bool", "Serves files from directory under url_path."},
+		"http_server_route":     {"server_id, method, path, handler", "bool", "Alias of http_server_add_route."},
+		"http_response":         {"status, body, headers", "map", "Builds a response for a route handler to return."},
+	}},
+	{"Sockets", map[string]entry{
+		"socket_create":        {"type, address, port", "string", "Connects a TCP or UDP socket and returns its id."},
+		"socket_listen":        {"type, address, port", "string", "Listens on address:port and returns a listener id."},
+		"socket_accept":        {"listener_id", "string", "Waits for a connection and returns its socket id."},
+		"socket_send":          {"socket_id, data", "int", "Sends a string and returns the bytes written."},
+		"socket_receive":       {"socket_id, max_bytes", "bytes", "Receives up to max_bytes."},
+		"socket_send_bytes":    {"socket_id, bytes", "int", "Sends an array of byte values."},
+		"socket_receive_bytes": {"socket_id, max_bytes", "array", "Receives up to max_bytes as byte values."},
+		"socket_close":         {"socket_id", "bool", "Closes a socket or listener."},
+		"set_timeout":          {"socket_id, ms", "", "Reserved; socket timeouts are set per operation."},
+	}},
+	{"WebSockets", map[string]entry{
+		"ws_connect":                {"url", "string", "Opens a WebSocket connection and returns its id."},
+		"ws_send":                   {"conn_id, message", "bool", "Sends a text message."},
+		"ws_receive":                {"conn_id, timeout_ms", "string", "Waits for the next message."},
+		"ws_close":                  {"conn_id", "bool", "Closes a WebSocket connection."},
+		"ws_ping":                   {"conn_id", "bool", "Sends a ping frame."},
+		"ws_server_listen":          {"address, port", "string", "Starts a WebSocket server and returns its id."},
+		"ws_server_accept":          {"server_id, timeout_sec", "string", "Waits for a client and returns its id."},
+		"ws_server_broadcast":       {"server_id, message", "int", "Sends a message to every client."},
+		"ws_server_clients":         {"server_id", "array", "Lists connected client ids."},
+		"ws_server_send_to":         {"server_id, client_id, message", "bool", "Sends a message to one client."},
+		"ws_server_stop":            {"server_id", "bool", "Stops a WebSocket server."},
+		"ws_send_binary":            {"conn_id, data", "bool", "Sends a string or bytes as a binary message."},
+		"ws_listen":                 {"address, port", "map", "Alias of ws_server_listen."},
+		"ws_server_get_clients":     {"server_id", "array", "Alias of ws_server_clients."},
+		"ws_server_receive_from":    {"server_id, client_id", "string", "Waits for the next message from one client."},
+		"ws_server_disconnect":      {"server_id, client_id", "bool", "Disconnects a client."},
+		"ws_server_wait_connection": {"server_id, timeout_sec", "map", "Waits for a client to connect, or returns nil on timeout."},
+	}},
+	{"Network scanning", map[string]entry{
+		"tcp_scan":                  {"host, port, timeout_ms", "bool", "Reports whether a TCP port is open."},
+		"tcp_connect":               {"host, port, timeout_ms", "bool", "Attempts a TCP connection."},
+		"port_scan":                 {"host, start_port, end_port, options...", "array", "Scans a TCP or UDP port range in parallel and returns the open ports with their fingerprinted service, product, version and cpe. options is a scan type or a map of type, timing (T0-T5), parallelism, timeout, retries, delay, a progress callback, all, fingerprint and extra probes."},
+		"ping":                      {"host, count, timeout_ms...", "any", "Sends count ICMP echo requests and returns a map of the replies, loss and round trips. With only a host, returns whether it answered. Uses UDP and TCP probes where raw sockets need root."},
+		"ping_sweep":                {"subnet, timeout_ms...", "array", "Pings every address of a subnet up to a /16 and returns the results of the hosts that answered."},
+		"traceroute":                {"host, max_hops, timeout_ms...", "array", "Returns the hops to a host as maps of ttl, ip, rtt_ms and reached."},
+		"scan_ports":                {"target, port_range", "array", "Scans ports given as \"1-1024\" or \"22,80,443\"."},
+		"scan_network":              {"cidr", "array", "Discovers live hosts in a network."},
+		"scan_service_version":      {"target, port, options...", "map", "Fingerprints the service on a port from its banner and answers to probes, returning its service, product, version, info, cpe and banner. options may set a timeout and extra probes."},
+		"scan_os_fingerprint":       {"target", "map", "Guesses the operating system of a host."},
+		"scan_vulnerabilities":      {"target", "array", "Checks open services for known weaknesses."},
+		"network_scan":              {"subnet", "array", "Finds live hosts in a subnet."},
+		"advanced_port_scan":        {"target, start_port, end_port, scan_type", "array", "Scans a port range with a tcp, syn or udp scan."},
+		"discover_network_topology": {"subnet", "map", "Maps the hosts and routes of a subnet."},
+		"dns_lookup":                {"hostname, record_type, options...", "array", "Resolves A, AAAA, CNAME, NS, MX, TXT, SRV, CAA or PTR records as strings. options may name a server, a doh URL, tcp and a timeout in milliseconds."},
+		"dns_query":                 {"hostname, record_type, options...", "array", "Resolves DNS records as maps with their name, type, ttl and the fields of their type."},
+		"dns_reverse":               {"ip, options...", "array", "Returns the names of an address from its PTR records."},
+		"analyze_ssl":               {"host, port", "map", "Inspects the TLS configuration of a server."},
+		"snmp_get":                  {"host, oids, options...", "any", "Reads an SNMP variable, or an array of them, as maps of oid, name, type and value. OIDs may start with a name such as sysName.0. options set the version (1, 2c or 3), community, port, timeout, retries and the SNMPv3 user, auth_protocol, auth_password, priv_protocol, priv_password and context."},
+		"snmp_walk":                 {"host, oid, options...", "array", "Reads every SNMP variable under an OID, with GETBULK except in version 1. Takes the options of snmp_get."},
+	}},
+	{"Mail", map[string]entry{
+		"mail_send":         {"host, message, options...", "map", "Sends a message map of from, to, cc, bcc, subject, text, html, headers and attachments over SMTP and returns its message_id, the server's response and the accepted and rejected recipients. options set the port, tls (starttls, require, implicit or none), username, password, helo, timeout and insecure."},
+		"mail_check_smtp":   {"host, options...", "map", "Reports the banner, EHLO extensions, STARTTLS support, TLS version, certificate and authentication mechanisms of an SMTP server, with the problems found."},
+		"mail_check_domain": {"domain, options...", "map", "Checks the MX, SPF, DMARC and DKIM records of a domain and lists their problems. options may name DKIM selectors and dns options."},
+		"mail_fetch":        {"host, options", "array", "Fetches the newest messages of an IMAP mailbox matching search, from, subject, since and unseen, without marking them seen. Messages are maps of their headers, auth_results, text, html and attachments."},
+		"mail_mailboxes":    {"host, options", "array", "Lists the mailboxes of an IMAP account."},
+	}},
+	{"Notifications", map[string]entry{
+		"notify_slack":     {"webhook_url, message, options...", "map", "Posts a message to a Slack incoming webhook. message is text or a map of title, text, severity, url and fields; {{ name }} placeholders in it are filled from the data option, such as a finding or an incident. options set data, channel, username, icon, retries, backoff_ms, timeout_ms and insecure. Returns the status, attempts and body of the answer."},
+		"notify_teams":     {"webhook_url, message, options...", "map", "Posts a message to a Microsoft Teams webhook as an Adaptive Card. Takes the message and options of notify_slack but channel, username and icon."},
+		"notify_pagerduty": {"routing_key, message, options...", "map", "Sends an event to PagerDuty's Events API v2. options set action, trigger, acknowledge or resolve, dedup_key, source, url and those of notify_slack. The result holds the dedup_key of the alert."},
+		"notify_webhook":   {"url, payload, options...", "map", "Sends a payload to a webhook as JSON, filling the placeholders of its strings from data. options set method, headers, secret, which signs the body in X-Sentra-Signature with HMAC-SHA256, and those of notify_teams."},
+		"notify_render":    {"template, data", "string", "Fills the {{ name }} placeholders of a template from a map; dotted names read nested maps and arrays and missing values are empty."},
+	}},
+	{"Ticketing", map[string]entry{
+		"ticket_connect":       {"type, url, options...", "string", "Returns the id of a tracker of a jira project or a servicenow table. options set project, issue_type, table, dedup_field, which holds the fingerprint in ServiceNow, username, password, token, fields, a map of ticket fields to {{ name }} templates of the finding, priorities and statuses, which map severities and finding statuses to the tracker's, transition_fields, fingerprint_fields, insecure and timeout_ms."},
+		"ticket_sync":          {"tracker, finding, options...", "map", "Creates the ticket of a finding or updates the one with its fingerprint, and moves it to the ticket status of the finding's status, closing it when it is fixed. The fingerprint option replaces the worked out one. Returns the key, id, url, status, fingerprint, action, created, updated or none, and transitioned of the ticket."},
+		"ticket_sync_report":   {"tracker, report_id", "array", "Syncs every finding of a report with ticket_sync."},
+		"ticket_sync_incident": {"tracker, incident_id", "map", "Syncs an incident with ticket_sync, fingerprinted by its id."},
+		"ticket_find":          {"tracker, finding", "map", "Returns the ticket of a finding or a fingerprint, or nil."},
+		"ticket_get":           {"tracker, key", "map", "Returns a ticket by its key, to read back its status."},
+		"ticket_fingerprint":   {"finding, fields...", "string", "Returns the fingerprint of a finding: a hash of its title, type, host, target, port, path, url, parameter, cve, cwe, rule and location, or of the given fields. A fingerprint field is returned as it is."},
+	}},
+	{"LDAP and Active Directory", map[string]entry{
+		"ldap_connect":          {"host, options...", "string", "Connects and binds to an LDAP server and returns the connection id. options set the port, tls (ldaps, starttls or none), username and password, domain with password or hash for an NTLM bind, base_dn, timeout and insecure."},
+		"ldap_search":           {"conn, filter, options...", "array", "Searches with an LDAP filter and returns maps of each entry's dn and attribute values, reading results in pages. options set the base, scope (sub, one or base), attributes, size_limit and page_size."},
+		"ldap_close":            {"conn", "bool", "Closes an LDAP connection."},
+		"ad_kerberoastable":     {"conn", "array", "Returns the enabled user accounts with a service principal name."},
+		"ad_asrep_roastable":    {"conn", "array", "Returns the enabled user accounts that do not require Kerberos pre-authentication."},
+		"ad_stale_accounts":     {"conn, days...", "array", "Returns the enabled user accounts that have not logged on for days, 90 by default."},
+		"ad_privileged_members": {"conn, groups...", "array", "Returns the privileged groups, or the named ones, with their members including those of nested groups."},
+		"ad_password_policy":    {"conn", "map", "Returns the domain password policy with its problems, and the fine-grained policies under fine_grained."},
+	}},
+	{"gRPC", map[string]entry{
+		"grpc_connect": {"target, options...", "string", "Connects to a gRPC server and returns the connection id. The services come from the proto files, or from server reflection without them. options set proto, import_paths, tls, ca, cert and key for mTLS, server_name, insecure, metadata sent with every call and timeout."},
+		"grpc_methods": {"conn", "array", "Lists the methods of a gRPC connection's services with their input and output types and whether they stream."},
+		"grpc_call":    {"conn, method, request, options...", "map", "Calls a gRPC method such as pkg.Service/Method with a request map, or an array of maps for client streaming. options set metadata, where an empty value drops a connection header, and timeout. Returns ok, the status code, status and message, the response, all responses of a stream, headers, trailers and time_ms."},
+		"grpc_close":   {"conn", "bool", "Closes a gRPC connection."},
+	}},
+	{"MQTT and AMQP", map[string]entry{
+		"mqtt_connect":       {"host, options...", "string", "Connects to an MQTT broker with MQTT 3.1.1 and returns the connection id. options set port, tls, ca, cert and key for client certificates, insecure, username, password, client_id and timeout. A refused connection fails with the broker's reason."},
+		"mqtt_subscribe":     {"conn, topics, qos...", "array", "Subscribes to topic filters and returns the topic, granted QoS and whether the broker granted each, so topic ACLs can be tested."},
+		"mqtt_publish":       {"conn, topic, payload, options...", "bool", "Publishes a message. options set qos and retain."},
+		"mqtt_messages":      {"conn, options...", "array", "Returns and forgets the messages received on a connection, with topic, payload, qos, retained and time. options set wait in milliseconds for the first message and max."},
+		"mqtt_close":         {"conn", "bool", "Disconnects from an MQTT broker."},
+		"amqp_connect":       {"host, options...", "string", "Connects to an AMQP 0-9-1 broker such as RabbitMQ and returns the connection id. options set port, tls, ca, cert, key, insecure, username and password, which default to guest, vhost and timeout."},
+		"amqp_declare_queue": {"conn, queue, options...", "map", "Declares a queue and returns its name and message and consumer counts. options set durable, auto_delete, and passive to only check that the queue exists."},
+		"amqp_publish":       {"conn, exchange, routing_key, body, options...", "bool", "Publishes a message to an exchange, or with an empty exchange to the queue named by the routing key. options set content_type, headers and persistent."},
+		"amqp_get":           {"conn, queue, options...", "array", "Reads messages from a queue. options set max, 1 by default, and ack to remove them; otherwise they are put back."},
+		"amqp_close":         {"conn", "bool", "Closes an AMQP connection."},
+	}},
+	{"Firewall", map[string]entry{
+		"firewall_add":         {"action, protocol, port, source", "bool", "Adds a rule to the in-memory firewall."},
+		"firewall_check":       {"source_ip, port", "string", "Returns the action the firewall applies to a connection."},
+		"firewall_create_rule": {"chain, protocol, src_ip, dst_ip, src_port, dst_port, action", "string", "Creates a firewall rule and returns its id."},
+		"firewall_delete_rule": {"rule_id", "bool", "Deletes a firewall rule."},
+		"firewall_list_rules":  {"chain", "array", "Lists the rules in a chain."},
+		"firewall_block_ip":    {"ip", "bool", "Blocks all traffic from an address."},
+		"firewall_allow_ip":    {"ip", "bool", "Allows all traffic from an address."},
+		"firewall_get_stats":   {"", "map", "Returns firewall rule and packet counters."},
+		"firewall_enable":      {"", "bool", "Enables the firewall."},
+		"firewall_disable":     {"", "bool", "Disables the firewall."},
+	}},
+	{"Proxies", map[string]entry{
+		"proxy_start":                      {"port, options", "string", "Starts a forward proxy and returns its id."},
+		"proxy_stop":                       {"proxy_id", "bool", "Stops a proxy."},
+		"proxy_set_upstream":               {"proxy_id, upstream_url", "bool", "Routes proxy traffic through an upstream proxy."},
+		"proxy_get_stats":                  {"proxy_id", "map", "Returns request counters for a proxy."},
+		"proxy_get_logs":                   {"proxy_id, limit", "array", "Returns the most recent proxied requests."},
+		"proxy_add_filter":                 {"proxy_id, filter", "", "Not yet supported."},
+		"reverse_proxy_create":             {"port, backends", "string", "Starts a reverse proxy over backend URLs."},
+		"reverse_proxy_add_backend":        {"proxy_id, backend_url, weight", "string", "Adds a backend and returns its id."},
+		"reverse_proxy_remove_backend":     {"proxy_id, backend_id", "bool", "Removes a backend."},
+		"reverse_proxy_set_load_balancing": {"proxy_id, algorithm", "bool", "Selects round_robin, least_conn or weighted balancing."},
+		"reverse_proxy_get_health":         {"proxy_id", "map", "Returns the health of each backend."},
+	}},
+	{"Intrusion detection", map[string]entry{
+		"ids_start":         {"interface, rules", "string", "Starts intrusion detection on an interface."},
+		"ids_stop":          {"ids_id", "bool", "Stops intrusion detection."},
+		"ids_get_alerts":    {"ids_id, severity, limit", "array", "Returns alerts at or above severity."},
+		"ids_get_stats":     {"ids_id", "map", "Returns packet and alert counters."},
+		"ids_block_threat":  {"threat_id", "bool", "Blocks the source of a detected threat."},
+		"ids_whitelist_ip":  {"ip", "bool", "Excludes an address from detection."},
+		"ids_add_rule":      {"ids_id, rule", "", "Not yet supported."},
+		"detect_intrusions": {"interface, duration", "array", "Watches an interface for intrusion signatures."},
+	}},
+	{"Traffic monitoring and capture", map[string]entry{
+		"monitor_start":           {"interface", "string", "Starts monitoring traffic on an interface."},
+		"monitor_stop":            {"monitor_id", "bool", "Stops a monitor."},
+		"monitor_get_bandwidth":   {"monitor_id", "map", "Returns inbound and outbound throughput."},
+		"monitor_get_connections": {"monitor_id", "array", "Returns active connections."},
+		"monitor_get_protocols":   {"monitor_id", "map", "Returns traffic broken down by protocol."},
+		"monitor_get_top_talkers": {"monitor_id, limit", "array", "Returns the hosts sending the most traffic."},
+		"monitor_get_flows":       {"monitor_id, filter", "array", "Returns flows matching a filter map."},
+		"monitor_export_pcap":     {"monitor_id, filename", "bool", "Writes captured traffic to a pcap file."},
+		"capture_start":           {"interface, filter", "map", "Starts capturing packets that match a tcpdump filter in the background and returns the capture's id, link_type and counts."},
+		"capture_stop":            {"capture_id", "bool", "Stops a packet capture. Its packets can still be read and saved."},
+		"capture_get_packets":     {"capture_id, count", "array", "Returns up to count of the packets a capture kept, oldest first, or all of them if count is 0."},
+		"capture_analyze_packet":  {"packet", "map", "Decodes the data of a packet again and adds the sizes of its layers and a dump of their fields."},
+		"capture_save_pcap":       {"capture_id, filename", "bool", "Writes the packets of a capture to a pcap file."},
+		"capture_stream":          {"interface, filter, callback, options...", "int", "Calls callback with each packet matching a filter until it returns false or options' count or duration_ms is reached, and returns the packets handled. options may also set snaplen and promisc."},
+		"pcap_read":               {"filename, filter...", "array", "Reads the packets of a pcap or pcapng file that match a filter."},
+		"pcap_each":               {"filename, callback, filter...", "int", "Calls callback with each packet of a pcap or pcapng file until it returns false."},
+		"pcap_write":              {"filename, packets", "int", "Writes packet maps, with their data and link_type, to a pcap file."},
+		"analyze_traffic":         {"interface, duration", "map", "Summarises traffic on an interface."},
+		"packet_capture":          {"interface, filter, count", "array", "Captures count packets matching a filter."},
+	}},
+	{"Security helpers", map[string]entry{
+		"sha256":            {"data", "string", "Returns the hex SHA-256 digest."},
+		"sha1":              {"data", "string", "Returns the hex SHA-1 digest."},
+		"md5":               {"data", "string", "Returns the hex MD5 digest."},
+		"base64_encode":     {"data", "string", "Encodes a string or bytes as base64."},
+		"base64_decode":     {"data", "string", "Decodes a base64 string."},
+		"hex_encode":        {"data", "string", "Encodes a string or bytes as hex."},
+		"hex_decode":        {"data", "string", "Decodes a hex string."},
+		"is_valid_ip":       {"ip", "bool", "Reports whether a string is an IPv4 or IPv6 address."},
+		"is_private_ip":     {"ip", "bool", "Reports whether an address is in a private range."},
+		"check_password":    {"password", "int", "Scores password strength from 0 to 100."},
+		"generate_password": {"length", "string", "Generates a random strong password."},
+		"generate_api_key":  {"prefix, length", "string", "Generates a random API key with a prefix."},
+		"check_threat":      {"data", "bool", "Checks data against known threat signatures."},
+	}},
+	{"Cryptography", map[string]entry{
+		"hmac_sha256":        {"key, data", "string", "Returns the hex HMAC-SHA256 of data."},
+		"hmac":               {"hash, key, data", "string", "Returns the hex HMAC of data with sha1, sha256, sha384 or sha512."},
+		"random_bytes":       {"n, encoding...", "string", "Returns n bytes from the secure random source as hex, or base64 or base64url if encoding says so."},
+		"aes_gcm_encrypt":    {"key, plaintext, aad...", "string", "Encrypts and authenticates plaintext, and the additional data aad, with AES-GCM under a hex key of 16, 24 or 32 bytes. Returns the random nonce and the ciphertext in base64."},
+		"aes_gcm_decrypt":    {"key, ciphertext, aad...", "string", "Decrypts what aes_gcm_encrypt returned, and fails if the ciphertext or aad were changed."},
+		"rsa_generate_key":   {"bits...", "map", "Generates an RSA key of 2048 bits unless told otherwise. Returns type, bits, and private_key and public_key in PEM."},
+		"ecdsa_generate_key": {"curve...", "map", "Generates an ECDSA key on P-256, P-384 or P-521, P-256 by default. Returns type, bits, curve, and private_key and public_key in PEM."},
+		"crypto_sign":        {"private_key, data, options...", "string", "Signs data with a PEM RSA or ECDSA key and returns the signature in base64. options set hash, sha256 by default, and padding, pkcs1 or pss, for RSA keys."},
+		"crypto_verify":      {"public_key, data, signature, options...", "bool", "Checks a base64 signature with a PEM public key or certificate, with the options it was made with."},
+		"jwt_create":         {"claims, key, options...", "string", "Signs a JWT with a secret, HS256, or a PEM private key, RS256 or the ES algorithm of its curve. options set alg, kid, header parameters and expires_in, in seconds, which sets exp and iat."},
+		"jwt_verify":         {"token, key, options...", "map", "Returns the claims of a JWT after checking its signature with a secret or PEM public key, and its exp and nbf. Only the algorithm the key signs with by default is allowed, unless options set algorithms. options may also set issuer, audience and leeway, in seconds."},
+		"jwt_decode":         {"token", "map", "Returns the header, claims, algorithm and signature of a JWT without verifying it."},
+	}},
+	{"Password auditing", map[string]entry{
+		"hash_identify": {"hash", "array", "Returns the formats a password hash may be in, likeliest first, with name, hashcat_mode, john_format and whether hash_crack can crack it. Also takes pwdump and /etc/shadow lines."},
+		"hash_crack":    {"hashes, wordlist, options...", "map", "Tries the words of a wordlist file, gzipped or not, or an array of words against a hash or an array of them: raw MD5, SHA and NTLM hashes, bcrypt, md5crypt, SHA-crypt, phpass, Django, LDAP, MySQL and NetNTLMv2, or pwdump and /etc/shadow lines. Returns cracked, with hash, user, format and password, uncracked, tried, elapsed_ms and status: cracked, exhausted, limit, timeout or stopped. options set format, rules, hashcat rules or default, limit, timeout_ms, and progress, a function called with tried, cracked, remaining, elapsed_ms and rate every progress_interval_ms that stops cracking by returning false."},
+	}},
+	{"SIEM", map[string]entry{
+		"siem_parse_log":        {"path, format", "array", "Parses a log file in a known format into events."},
+		"siem_analyze":          {"events", "map", "Summarises events and flags anomalies."},
+		"siem_analyze_logs":     {"events", "map", "Alias of siem_analyze."},
+		"siem_correlate":        {"events", "array", "Applies correlation rules to events."},
+		"siem_correlate_events": {"events", "array", "Alias of siem_correlate."},
+		"siem_detect_threats":   {"events", "array", "Detects known attack patterns in events."},
+		"siem_add_rule":         {"rule", "bool", "Adds a correlation rule."},
+		"siem_get_rules":        {"", "array", "Lists correlation rules."},
+		"siem_load_sigma":       {"path, options...", "map", "Loads Sigma rules from a file or directory into the correlation engine."},
+		"siem_formats":          {"", "array", "Lists the supported log formats."},
+		"siem_get_formats":      {"", "array", "Alias of siem_formats."},
+		"siem_parse_event":      {"line, format", "map", "Parses a single log line into an event."},
+		"siem_export_events":    {"events, format, path", "bool", "Writes events to a file."},
+		"siem_send_syslog":      {"events, server, protocol", "bool", "Sends events to a syslog server."},
+		"siem_parse_syslog":     {"line", "map", "Parses an RFC 3164 or RFC 5424 syslog message."},
+		"siem_syslog_listen":    {"options, callback", "int", "Receives syslog over UDP, TCP or TLS and calls back with each message until it returns false."},
+		"siem_forwarder":        {"type, options", "string", "Creates a batching forwarder to Splunk HEC or Elasticsearch and returns its handle."},
+		"siem_forward":          {"forwarder, events", "int", "Queues an event or an array of events for a forwarder."},
+		"siem_forward_flush":    {"forwarder", "map", "Sends the pending events of a forwarder and returns its stats."},
+		"siem_forward_close":    {"forwarder", "map", "Flushes and closes a forwarder and returns its stats."},
+	}},
+	{"Threat intelligence", map[string]entry{
+		"threat_lookup_ip":         {"ip", "map", "Looks up the reputation of an IP address."},
+		"threat_lookup_domain":     {"domain", "map", "Looks up the reputation of a domain."},
+		"threat_extract_iocs":      {"text", "map", "Extracts IPs, domains, URLs and hashes from text."},
+		"threat_lookup_hash":       {"hash", "map", "Looks up the reputation of a file hash."},
+		"threat_bulk_lookup":       {"indicators", "map", "Looks up an array of indicators."},
+		"threat_get_reputation":    {"indicator", "string", "Returns the reputation of an indicator."},
+		"threat_set_api_key":       {"source, api_key", "bool", "Sets the API key of an intelligence source."},
+		"threat_generate_md5":      {"data", "string", "Returns the hex MD5 digest."},
+		"threat_generate_sha1":     {"data", "string", "Returns the hex SHA-1 digest."},
+		"threat_generate_sha256":   {"data", "string", "Returns the hex SHA-256 digest."},
+		"threat_stix_import":       {"bundle, options...", "map", "Adds the indicators of a STIX 2.1 bundle to the store the lookups consult."},
+		"threat_taxii_collections": {"url, options...", "array", "Lists the collections of a TAXII 2.1 server."},
+		"threat_taxii_poll":        {"url, collection, options...", "map", "Adds the indicators a TAXII collection gained since the last poll to the store the lookups consult."},
+		"threat_indicators":        {"", "array", "Lists the stored STIX indicators that have not expired."},
+		"threat_cache_open":        {"path, options...", "bool", "Keeps lookup results in an SQLite file, with ttl_hours and negative_ttl_hours options."},
+		"threat_set_rate_limit":    {"source, requests, window_ms", "bool", "Sets how many requests a threat intelligence source takes per window."},
+		"threat_prefetch":          {"indicators", "map", "Looks up the indicators not cached yet within each source's rate limit."},
+		"threat_stats":             {"", "map", "Reports the lookup cache and the requests, throttling and backoff of each source."},
+		"misp_configure":           {"url, api_key, options...", "bool", "Sets the MISP instance the misp_ functions use, with insecure and timeout_ms options."},
+		"misp_search":              {"query", "array", "Searches MISP attributes by value, or by a map of restSearch parameters."},
+		"misp_add_event":           {"event", "map", "Creates a MISP event from info, threat_level, analysis, distribution, tags and attributes."},
+		"misp_add_sighting":        {"value, options...", "map", "Records a sighting, false_positive or expiration of the MISP attributes with a value."},
+	}},
+	{"Vulnerability scoring", map[string]entry{
+		"cvss_score":    {"vector", "map", "Scores a CVSS 3.0, 3.1 or 4.0 vector. Returns version, vector, base_score, score, the score of all the metrics the vector gives, severity, nomenclature, CVSS-B, CVSS-BT, CVSS-BE or CVSS-BTE, and metrics; CVSS 3 vectors add temporal_score and environmental_score."},
+		"cvss_severity": {"score", "string", "Rates a CVSS score as none, low, medium, high or critical."},
+		"epss_lookup":   {"cve, options...", "map", "Returns the EPSS score of a CVE, the probability it is exploited in the next 30 days, as cve, epss, percentile and date, or nil when EPSS does not score it. An array of CVEs returns a map of the scored ones. Answers are cached for a day. options set epss_file, a daily EPSS export used instead of the API, cache_dir, offline, which uses only files and the cache, and timeout_ms."},
+		"kev_lookup":    {"cve, options...", "map", "Returns the entry of a CVE in CISA's catalog of known exploited vulnerabilities, with vendor, product, name, date_added, required_action, due_date, ransomware and cwes, or nil when it is not in it. The catalog is cached for a day. options as for epss_lookup, with kev_file, the catalog as JSON."},
+		"vuln_enrich":   {"findings, options...", "map", "Adds to a finding map, or to each of an array of them, cvss_score, cvss_version and severity from its cvss_vector, and epss, epss_percentile, kev, kev_due_date and kev_ransomware from its cve. options as for epss_lookup and kev_lookup."},
+		"vulndb_update": {"sources..., options...", "map", "Downloads into the local vulnerability database the sources given, nvd, the NVD's CVE feeds, by default, and osv:<ecosystem>, the OSV export of an ecosystem such as osv:PyPI or osv:Debian. The NVD feeds are downloaded in full the first time or after a week, and only recently modified CVEs otherwise. options set db_dir, the database's directory, by default sentra/vulndb in the user's cache directory, full and timeout_ms. Returns the database's status as vulndb_status does."},
+		"vulndb_import": {"path, options...", "map", "Adds NVD feeds, .json or .json.gz, and OSV exports, .zip, copied from a connected host to the local vulnerability database, a file or a directory of them. options set db_dir. Returns the database's status."},
+		"vulndb_status": {"options...", "map", "Returns the dir of the local vulnerability database, when its CVEs were updated, nvd_updated, how many it has, cves, and its OSV exports, each with ecosystem, updated and size. options set db_dir."},
+		"cve_lookup":    {"id, options...", "map", "Returns a CVE from the local vulnerability database as cve, description, published, modified, cvss_vector, cvss_score, severity, cwes, references, cpes, the products it affects with their version ranges, and advisories, the OSV advisories of it; an OSV id returns its advisory. Returns nil when the database does not know it. options set db_dir."},
+		"product_match": {"products, options...", "array", "Returns the vulnerabilities the local database knows of in a product version, as findings with title, cve, advisory, aliases, source, nvd or osv, product, version, description, cvss_vector, cvss_score, severity, criteria, fixed and conditions, the platforms it must run on to be affected. A product is a CPE 2.3 name, or a map of cpe, or vendor, product and version, as service fingerprints give, or ecosystem, name and version, an OSV package; packages of container_scan_image match by their source package. An array of products returns the matches of all, leaving out those without a version, with the host of each. options set db_dir and ecosystem, for packages without one."},
+	}},
+	{"Incident response", map[string]entry{
+		"incident_create":      {"title, description, severity, source", "string", "Opens an incident and returns its id."},
+		"incident_list":        {"filter", "array", "Lists incidents matching a filter map, or all if nil."},
+		"incident_metrics":     {"", "map", "Returns incident counts and response times."},
+		"ir_create_incident":   {"title, description, severity, source", "map", "Opens an incident."},
+		"ir_get_incident":      {"incident_id", "map", "Returns an incident."},
+		"ir_update_incident":   {"incident_id, updates", "bool", "Updates the fields of an incident."},
+		"ir_close_incident":    {"incident_id, resolution", "bool", "Closes an incident."},
+		"ir_list_incidents":    {"filters", "array", "Lists incidents matching a filter map."},
+		"ir_collect_evidence":  {"incident_id, type, value, source", "bool", "Attaches evidence to an incident."},
+		"ir_create_playbook":   {"name, description, category, steps", "map", "Creates a response playbook."},
+		"ir_list_playbooks":    {"", "array", "Lists response playbooks."},
+		"ir_execute_playbook":  {"incident_id, playbook_id", "map", "Runs a playbook against an incident."},
+		"ir_execute_action":    {"incident_id, action_id, parameters", "map", "Runs a single response action. Given an executor in parameters, the action is carried out by it, on the target parameter or the ip, host or user the action takes, and dry_run only says what it would do."},
+		"ir_register_executor": {"name, type, options...", "map", "Sets up an executor of response actions under a name: iptables or pf on this host (table, chain), paloalto (url, api_key, tag), crowdstrike or defender (client_id, client_secret, tenant_id), ad (host, username, password, domain), okta (url, token), or script, whose handler gets the action, target, params and dry_run. dry_run makes every action it is given a dry run."},
+		"ir_list_executors":    {"", "array", "Lists the registered executors with their type and actions."},
+		"ir_execute":           {"incident_id, executor, action, target, options...", "map", "Has an executor carry out block_ip, unblock_ip, isolate_host, release_host, disable_account or enable_account for an incident and records it among its actions. options set dry_run, comment, isolation_type and params for script executors."},
+		"ir_audit_log":         {"incident_id...", "array", "Returns the actions given to executors, whether carried out, failed or dry runs, with their time, executor, action, target, status, result and error."},
+		"ir_set_audit_file":    {"path", "bool", "Appends each later audit entry to a file as a line of JSON; an empty path stops it."},
+		"ir_get_metrics":       {"", "map", "Returns incident counts and response times."},
+		"ir_build_timeline":    {"incident_id, options...", "array", "Merges the history and response actions of an incident, the modification times of its file evidence and other sources into one timeline sorted by time, events without a time last. Each entry is a map of time, timestamp, source, type, host, user, summary and details. options set events, SIEM events from siem_parse_log, artifacts, records of the forensics_ functions, images, memory image ids whose process start times are added, paths, files and directories whose modification times are added, entries, timeline entries of any other source, and start and end, which drop timed events outside them."},
+		"ir_export_timeline":   {"timeline, format, path", "bool", "Writes a timeline from ir_build_timeline to a file as csv, with the details of each entry as JSON, or as json."},
+	}},
+	{"Cloud, containers and reporting", map[string]entry{
+		"cloud_provider_add":        {"name, type, credentials", "bool", "Registers an AWS, Azure or GCP account for scanning. Credentials left out come from the provider's default chain: environment, profiles and instance identity."},
+		"cloud_scan":                {"provider", "map", "Collects the account's resources from the provider APIs, across regions, and checks them against the provider's policies."},
+		"cloud_resources":           {"provider", "array", "Lists the resources the last scan of a provider collected, with their configuration."},
+		"container_scan_image":      {"image, options...", "map", "Scans an image archive, local image or registry image layer by layer for vulnerable packages, secrets and packed executables."},
+		"container_scan_dockerfile": {"path", "map", "Checks a Dockerfile against best practices."},
+		"report_create":             {"id, title, description, target", "string", "Creates a security report."},
+		"report_add_finding":        {"report_id, finding", "bool", "Adds a finding map to a report. A cvss_vector is scored and rates the finding when it has no severity."},
+		"report_export":             {"report_id, format, filename, options...", "string", "Exports a report as json, xml, csv, html, markdown or pdf and returns the filename. Relative filenames are under the output directory. options set template, the template html, markdown and pdf reports are rendered with: executive, technical, the default, or one from report_template_add. PDFs of the built-in templates are written natively, those of custom HTML templates need wkhtmltopdf or Chromium."},
+		"report_template_add":       {"id, template, options...", "bool", "Adds a Go template of a report. It sees the report's fields, Sorted, its findings from the most severe, Severities, the count of each severity, and the functions lower, upper, join, date, severityColor and chart, an SVG bar chart of the severities in HTML or a text one in Markdown. options set format, html or markdown, name, description and stylesheet, CSS available as .Stylesheet."},
+		"report_fingerprint":        {"finding", "string", "Returns the fingerprint of a finding map, a hash of its title, host, parameter, cwe, cve and the custom fields type, port, path, url, rule and location. Findings with the same fingerprint are the same finding: adding one again to a report merges it into the first, and report_diff matches them across scans."},
+		"report_findings":           {"report_id", "array", "Lists the findings of a report as maps, with their fingerprints."},
+		"report_diff":               {"baseline, report, options...", "map", "Compares a report with a baseline, each a report id or a report exported as JSON, and returns maps of new, the findings not in the baseline, regressed, those that were fixed there or got more severe, with a reason, fixed, those no longer found, suppressed, expired, the suppressions that expired, and unchanged, a count. Suppressed findings are neither new nor regressed. options set suppressions, the path of a suppression file or an array of suppressions, and at, an RFC 3339 time expiry is checked at instead of now."},
+		"report_load_suppressions":  {"path", "array", "Checks a suppression file, a JSON array of suppressions or an object with them under suppressions. Each matches findings by fingerprint or by title and target, path patterns, cwe and cve, and needs a justification; owner and expires, a date or RFC 3339 time, are optional."},
+		"report_templates":          {"", "array", "Lists the report templates as maps of id, name, description, format and custom."},
+		"report_render":             {"report_id, template", "string", "Renders a report with an html or markdown template."},
+		"cloud_findings":            {"status", "array", "Lists cloud findings with a status, or all of them for an empty status."},
+		"cloud_resolve_finding":     {"finding_id", "bool", "Marks a cloud finding resolved."},
+		"cloud_auto_remediate":      {"finding_id", "map", "Applies the fix for a cloud finding."},
+		"cloud_benchmark_run":       {"provider, benchmark", "map", "Runs a benchmark against the resources of a cloud account, scanning it first if it was never scanned. benchmark is an ID from benchmark_list or cis for the CIS foundations benchmark of the provider. Returns the report of benchmark_run."},
+		"cloud_compliance_report":   {"format", "string", "Summarises cloud compliance as text or json."},
+		"cloud_cost_analysis":       {"provider", "map", "Estimates the cost of a cloud account."},
+		"cloud_validate_iam":        {"policy_json", "map", "Checks an IAM policy for risky permissions."},
+		"container_add_policy":      {"policy", "bool", "Adds a container security policy."},
+		"container_validate_policy": {"image, policy_id", "map", "Checks a scanned image against a policy."},
+		"container_get_scan_result": {"image", "map", "Returns the last scan of an image, or nil."},
+	}},
+	{"Web security testing", map[string]entry{
+		"web_client_create":        {"client_id, config", "string", "Creates an HTTP client with timeouts and headers. proxy is a proxy URL (http, https, socks5 or socks5h, with user:password@), an array of them to chain through, env (the default) or none; http2 negotiates HTTP/2 over TLS and h2c speaks it in the clear. An auth map of type (basic, ntlm or negotiate), username and password, or domain, hash, realm, kdc, spn, krb5_conf, keytab and ccache, answers the authentication challenges of servers."},
+		"web_request":              {"client_id, method, url, options...", "map", "Sends a request with a web client and returns its status_code, status, headers, body, content_type, proto, url after redirects and time_ms. options set headers, a body, cookies sent on top of the client's jar and a proxy for this request only."},
+		"web_post_json":            {"client_id, url, data", "map", "Posts JSON with a web client."},
+		"web_login":                {"client_id, url, options", "map", "Logs a web client in through the HTML form at url, filling username and password into it with its hidden fields and CSRF token. options also take username_field, password_field, fields, success and failure texts, and csrf_header to send the token on later requests. Returns authenticated, status_code, url, csrf_token, cookies and the reason of a failure; the session stays in the client's cookie jar."},
+		"web_oauth2_token":         {"client_id, options", "map", "Gets an OAuth 2.0 token with the client_credentials or device flow, from options token_url, device_url, client_id, client_secret, scope, audience, auth_style (basic or body) and params. The device flow calls on_code with the user_code and verification_uri, or prints them, and waits for approval. Unless apply is false, the client sends the token as a bearer token from then on."},
+		"web_cookies":              {"client_id, url", "array", "Returns the name and value of the cookies a web client holds for url."},
+		"web_set_cookie":           {"client_id, url, name, value", "bool", "Stores a cookie in a web client's jar for the site of url."},
+		"web_clear_cookies":        {"client_id", "bool", "Empties a web client's cookie jar, ending its sessions."},
+		"web_on_request":           {"client_id, callback", "int", "Calls callback with the method, url, headers and body of each request of a web client before it is sent. Changes to the map, or a map it returns, change the request; returning false drops it. Returns the hook's ID."},
+		"web_on_response":          {"client_id, callback", "int", "Calls callback with the request and the response of each request of a web client, for logging, timing and passive checks. Returns the hook's ID."},
+		"web_remove_hook":          {"client_id, hook_id...", "bool", "Removes a hook of a web client, or all of them without hook_id."},
+		"web_crawl":                {"client_id, start_url, options...", "map", "Follows the links of a site breadth first with a web client, keeping its session. options set max_depth (3), max_pages (100), domains in scope (the start host, or *.example.com for subdomains), exclude patterns never requested, robots (true obeys robots.txt) and a delay in milliseconds. Returns the pages, their urls, the forms, the endpoints with their method and params, and the external and disallowed links."},
+		"web_scan_vulnerabilities": {"client_id, url, options...", "map", "Injects payloads into the parameters of url with a web client and returns the vulnerabilities found, with the payload, evidence, request and response of each. options set the checks (xss, sqli, sqli_time, command, traversal, ssrf, nosql, xxe, xpath and disclosure), payloads replacing those of a check, params, method, stored_urls checked for stored XSS, time_delay and canary_wait in milliseconds, and a canary map of listen address and public url for SSRF callbacks."},
+		"web_graphql":              {"client_id, endpoint, query, variables, operation_name...", "map", "Runs a GraphQL query or mutation with a web client, with optional variables and the operation to run. Returns the status_code, data, errors and extensions."},
+		"web_graphql_schema":       {"client_id, endpoint", "map", "Reads the schema of a GraphQL endpoint by introspection: the query, mutation and subscription types, and each type with its kind, fields, arguments and enum values."},
+		"web_test_graphql":         {"client_id, endpoint, options...", "map", "Tests a GraphQL endpoint for introspection, field suggestions, batching, alias overloading, field duplication, missing depth limits and queries over GET. options set the batch_size (10), aliases (100), depth (15) and duplicates (500) of the probes. Returns whether introspection is enabled, the schema and the vulnerabilities."},
+		"web_test_injection":       {"endpoint, type, params", "map", "Tests the params of an endpoint with the payloads of one check, such as sql, xss, command, traversal, nosql, xxe or xpath."},
+		"web_test_cors":            {"endpoint, origin", "map", "Checks the CORS policy for an origin."},
+		"web_test_headers":         {"endpoint", "map", "Checks for missing security headers."},
+		"web_test_rate_limit":      {"endpoint, requests, duration", "map", "Checks whether an endpoint enforces rate limits."},
+		"web_api_scan":             {"base_url, options", "map", "Discovers and tests API endpoints."},
+		"web_test_auth":            {"endpoint, config", "map", "Tests authentication handling."},
+		"web_fuzz_api":             {"endpoint, config", "map", "Fuzzes an API endpoint with generated inputs."},
+		"web_create_client":        {"client_id, config", "bool", "Alias of web_client_create."},
+		"test_injection":           {"endpoint, type, params", "map", "Alias of web_test_injection."},
+		"test_cors":                {"endpoint, origin", "map", "Alias of web_test_cors."},
+		"test_headers":             {"endpoint", "map", "Alias of web_test_headers."},
+		"test_rate_limiting":       {"endpoint, requests, duration", "map", "Alias of web_test_rate_limit."},
+		"test_authentication":      {"endpoint, config", "map", "Alias of web_test_auth."},
+		"test_authorization":       {"endpoint, config", "map", "Tests access control between roles."},
+		"test_jwt":                 {"endpoint, token", "map", "Checks a JWT for weak algorithms and claims."},
+		"api_scan":                 {"base_url, options", "map", "Alias of web_api_scan."},
+		"fuzz_api":                 {"endpoint, config", "map", "Alias of web_fuzz_api."},
+		"scan_openapi":             {"spec_url, base_url", "map", "Tests the endpoints of an OpenAPI spec."},
+	}},
+	{"Databases", map[string]entry{
+		"db_connect":          {"id, type, dsn, options...", "string", "Connects to sqlite, postgres, mysql, mssql, mongodb or redis under an id. options set timeout for connecting and each query, max_open, max_idle and max_lifetime for the pool, and tls, ca, cert, key and insecure. MongoDB queries are command documents in JSON and Redis queries are commands, both with ? placeholders."},
+		"db_execute":          {"conn_id, query, args...", "int", "Runs a statement, binding args or one array of them to its ? placeholders, and returns the rows affected."},
+		"db_query":            {"conn_id, query, args...", "array", "Runs a query, binding args or one array of them to its ? placeholders, and returns rows as maps."},
+		"db_close":            {"conn_id", "bool", "Closes a database connection."},
+		"db_exec":             {"conn_id, query, args...", "int", "Alias of db_execute."},
+		"db_begin":            {"conn_id", "bool", "Starts a transaction that the connection's statements run in until db_commit or db_rollback. SQL databases only."},
+		"db_commit":           {"conn_id", "bool", "Commits the connection's transaction."},
+		"db_rollback":         {"conn_id", "bool", "Rolls back the connection's transaction."},
+		"db_cursor":           {"conn_id, query, args...", "string", "Runs a query like db_query and returns a cursor id to read its rows one at a time with db_next, for large result sets."},
+		"db_next":             {"cursor_id", "map", "Returns the next row of a cursor, or nil after the last one, when the cursor is closed."},
+		"db_cursor_close":     {"cursor_id", "bool", "Closes a cursor before its last row."},
+		"sql_connect":         {"id, type, dsn, options...", "string", "Alias of db_connect."},
+		"sql_execute":         {"conn_id, query, args...", "int", "Alias of db_execute."},
+		"sql_query":           {"conn_id, query, args...", "array", "Alias of db_query."},
+		"sql_close":           {"conn_id", "bool", "Alias of db_close."},
+		"db_scan_services":    {"host", "array", "Finds database services on a host."},
+		"db_test_credentials": {"host, port, type, database", "array", "Tries default credentials against a database."},
+		"db_security_scan":    {"conn_id", "map", "Reads the settings, accounts and encryption of a db_connect connection with collectors for its engine, such as MySQL validate_password and PostgreSQL pg_hba.conf rules, and returns findings by severity with a risk_score. What the account may not read is listed in errors."},
+		"db_test_injection":   {"conn_id, query, payload", "map", "Tests a query for SQL injection."},
+		"db_audit_privileges": {"conn_id", "map", "Lists the accounts of a db_connect connection with their privileges and risk_level, and the authentication and privilege findings."},
+		"db_check_encryption": {"conn_id", "map", "Checks encryption in transit on the connection and at rest, which is nil when the database cannot tell, with the encryption findings as issues."},
+		"db_backup_security":  {"conn_id", "map", "Checks how backups are protected."},
+		"db_compliance_check": {"conn_id, framework", "map", "Checks a database against a compliance framework."},
+		"sql_query_one":       {"conn_id, query, args...", "map", "Runs a query and returns the first row, or nil."},
+		"sql_list":            {"", "array", "Lists open database connections."},
+		"sql_escape":          {"text", "string", "Escapes quotes in a string for SQL."},
+	}},
+	{"Environment", map[string]entry{
+		"env":     {"name, default...", "string", "Returns an environment variable, or default (null) if it is unset. With no name, returns every variable as a map."},
+		"set_env": {"name, value", "", "Sets an environment variable, or unsets it when value is null."},
+	}},
+	{"Processes", map[string]entry{
+		"process_run":         {"command, args, options...", "map", "Runs a command to completion and returns its exit code, signal and output."},
+		"process_spawn":       {"command, args, options...", "string", "Starts a command and returns its handle."},
+		"process_write":       {"handle, data", "int", "Writes a string or bytes to the input of a process."},
+		"process_close_stdin": {"handle", "", "Closes the input of a process."},
+		"process_wait":        {"handle", "map", "Waits for a process to exit, calling its output callbacks, and returns its result."},
+		"process_kill":        {"handle, signal...", "bool", "Sends a signal to a process, SIGKILL by default."},
+		"os_exec":             {"command, args", "string", "Runs a command and returns its output."},
+		"os_kill":             {"pid, force", "bool", "Stops a process, forcibly if force is true."},
+		"os_services":         {"", "array", "Lists system services."},
+	}},
+	{"OS and memory forensics", map[string]entry{
+		"os_processes":          {"", "array", "Lists running processes."},
+		"os_ports":              {"", "array", "Lists listening ports."},
+		"os_info":               {"", "map", "Returns operating system details."},
+		"os_privileges":         {"", "map", "Returns the privileges of the current user."},
+		"os_users":              {"", "array", "Lists local user accounts."},
+		"reg_read":              {"path, name...", "any", "Returns the data of a Windows registry value, from a key path like HKLM\\SOFTWARE\\Microsoft, as a string, int, array of strings or bytes. Without a name it reads the key's default value."},
+		"reg_enum":              {"path", "map", "Returns the path, subkey names and values, each with its name, type and data, of a Windows registry key."},
+		"win_services":          {"", "array", "Returns the configuration of every Windows service: name, display_name, bin_path, executable, start_type, type, account, state, the sddl of its DACL and whether its path is unquoted."},
+		"win_service_audit":     {"", "array", "Returns findings for Windows services with unquoted paths with spaces, or whose service object, program or program directory Everyone, Users or other unprivileged principals can change."},
+		"win_startup_items":     {"", "array", "Returns what Windows runs at boot or logon from the Run and RunOnce keys, changes to Winlogon and the startup folders, with name, command, location, scope and executable."},
+		"win_security_policy":   {"path...", "map", "Exports the Windows local security policy with secedit, or reads an export at path, and returns its settings by section and findings for settings that fall short of a CIS-based baseline. Exporting needs an elevated process."},
+		"mem_enum_processes":    {"", "array", "Enumerates processes with memory details."},
+		"mem_find_process":      {"name", "array", "Finds processes by name."},
+		"mem_get_process_tree":  {"", "map", "Returns the parent/child process tree."},
+		"fs_create_baseline":    {"path, recursive", "bool", "Records file hashes for later integrity checks."},
+		"fs_verify_integrity":   {"path", "map", "Compares files against their baseline."},
+		"fs_watch":              {"path, callback, options...", "int", "Calls back with each file created, modified or deleted under a path as it happens, with its type, path, changes and sha256, size and mode before and after. options set recursive, exclude, count, duration_ms, debounce_ms and poll_ms; returning false stops. Returns the changes handled."},
+		"fs_scan_directory":     {"path, recursive", "array", "Lists files with their hashes and permissions."},
+		"fs_calculate_hash":     {"path, hash_type", "string", "Returns the md5, sha1 or sha256 digest of a file."},
+		"mem_get_process_info":  {"pid", "map", "Returns details of a process."},
+		"mem_get_children":      {"pid", "array", "Lists the child processes of a process."},
+		"mem_get_regions":       {"pid", "array", "Lists the memory regions of a live process with their address, size, protection, state, type and mapped path. Works on Linux and Windows; a missing privilege raises an error that names it."},
+		"mem_read":              {"pid, address, size", "bytes", "Reads memory of a live process at an address given as a number or hex string."},
+		"mem_dump_process":      {"pid, output_path, options...", "map", "Writes the readable regions of a process to a file and returns each region's file_offset, the bytes written and their sha256. options set executable_only and max_bytes."},
+		"mem_scan_malware":      {"pid", "array", "Scans the memory of a process for malware signatures."},
+		"mem_detect_injection":  {"pid", "array", "Finds injected code in a process."},
+		"mem_detect_hollowing":  {"pid", "map", "Checks a process for hollowing."},
+		"mem_analyze_injection": {"pid", "map", "Summarises code injection in a process."},
+		"mem_image_open":        {"path, options...", "map", "Opens a raw or LiME physical memory image for offline analysis and returns its id, format, size, ranges, os and the Linux version banners found in it, which name the kernel it came from. options set symbols, the path of the Volatility 3 symbol table (.json or .json.gz) of that kernel, which the analyses need."},
+		"mem_image_info":        {"image", "map", "Describes an open memory image like mem_image_open."},
+		"mem_image_close":       {"image", "bool", "Closes a memory image."},
+		"mem_image_processes":   {"image", "array", "Lists the processes of a memory image with pid, ppid, name, uid, threads, kernel_thread and offset. Linux images walk the task list; Windows images are scanned for process objects, which also finds exited processes with their create_time and exited."},
+		"mem_image_modules":     {"image", "array", "Lists the kernel modules loaded in a Linux memory image with their name, base, size and offset."},
+		"mem_image_connections": {"image", "array", "Lists the IPv4 and IPv6 sockets the processes of a Linux memory image held open with pid, process, fd, protocol, family, local_addr, local_port, remote_addr, remote_port and the TCP state."},
+		"mem_image_handles":     {"image, options...", "array", "Lists the files and sockets the processes of a Linux memory image held open that look suspicious, such as deleted or memfd files, files in /tmp or /dev/shm, raw memory devices and packet sockets, with pid, process, fd, type, path and suspicious, the reason it stands out. options set all to list every handle."},
+	}},
+	{"Disk forensics", map[string]entry{
+		"forensics_carve":           {"source, output_dir, options...", "array", "Carves jpeg, png, gif, pdf, zip, gzip, sqlite, pe and elf files out of a raw disk image or device by their magic numbers and structure, writing each to output_dir, or only hashing it when output_dir is empty. Returns maps of type, offset, size, path and sha256. options set types, a list of those names, max_size, 64MB by default, and max_files, 1000 by default."},
+		"forensics_browser_history": {"options...", "array", "Returns the visits and downloads in the Chrome, Chromium, Edge, Brave, Firefox and Safari histories of every user. Each record is a map of time, timestamp, source, type, user, path, the file it was read from, summary and data, the url, title and transition of a visit or the url, target and bytes of a download. options set root, the file system searched such as a mounted image, or path, one history database."},
+		"forensics_shell_history":   {"options...", "array", "Returns the commands in the bash, zsh, fish and PowerShell histories of every user as records like forensics_browser_history with data of command and shell. Commands have a time when the shell recorded one. options set root or path."},
+		"forensics_scheduled_tasks": {"options...", "array", "Returns the cron jobs of the system and user crontabs, the scripts of the cron.daily and similar directories, and the Windows scheduled tasks, as records with data of command, schedule and, for Windows tasks, name, author, description, run_level, hidden and enabled. Cron jobs carry the time their file changed. options set root or path."},
+		"forensics_prefetch":        {"options...", "array", "Returns a record for each of the last run times kept in the Windows prefetch files, compressed or not, with data of executable, hash, run_count, run and the files the program loaded. options set root or path."},
+		"forensics_shimcache":       {"options...", "array", "Returns the entries of the Windows application compatibility cache read from the SYSTEM hive, most recent first, with the file's modification time and data of path, position and, on Windows 7 and 8, executed. options set root or path, a SYSTEM hive file."},
+		"forensics_collect":         {"options...", "map", "Runs the artifact collectors and returns records, every record merged by time, and errors, the message of each collector that failed by name. options set root and artifacts, a list of browser_history, shell_history, scheduled_tasks, prefetch and shimcache, all by default."},
+	}},
+	{"Binary analysis", map[string]entry{
+		"bin_analyze": {"path", "map", "Parses a PE, ELF or Mach-O executable and returns its format, arch, type, entry point, sha256, entropy, sections with their flags and entropy, libraries, imports, exports, signature, protections such as nx, aslr, pie and relro, and the URLs and IPs in its strings. packed, packers and indicators report packer section names, compressed or encrypted code, writable code and entry points outside the code."},
+		"bin_strings": {"path, options...", "array", "Returns the printable ASCII and UTF-16LE strings of a file as maps of offset, value and encoding. options set min_length, 4 by default, and max, 10000 by default or 0 for no limit."},
+	}},
+	{"Infrastructure as code", map[string]entry{
+		"iac_scan":     {"path, options...", "map", "Parses the Terraform, CloudFormation and ARM templates in a file or directory and evaluates the misconfiguration rules against their resources. Returns files, resources, findings sorted by severity, a summary of counts by severity, passed when nothing is critical or high, and errors for files that could not be parsed. options set skip, a list of rule IDs, and severity, the lowest severity reported."},
+		"iac_parse":    {"path", "map", "Returns the resources of the templates under a path with their type, name, address, format, file, line and attributes, variables and locals resolved where they are known."},
+		"iac_add_rule": {"rule", "string", "Adds a rule map of id, title, severity, remediation, resource_types and check, a function given each resource that returns nil or false when it passes, true, a description or a list of descriptions. Replaces a rule with the same id."},
+		"iac_rules":    {"", "array", "Returns the built-in and added rules."},
+	}},
+	{"Compliance benchmarks", map[string]entry{
+		"benchmark_run":    {"id, target, options...", "map", "Runs a benchmark against a target: local for this host, the path of a mounted Linux file system, the name of a scanned cloud provider, or a map of data source names to arrays of items. id cis picks the CIS benchmark of the target's platform. Returns score, the percentage of automated controls that passed, passed, failed, errors, manual, skipped, sections with their scores and controls with status, evidence and remediation. options set level, controls and skip, lists of control IDs or section prefixes, and name and platform for a map target."},
+		"benchmark_load":   {"path", "array", "Loads the benchmarks of a JSON file or of the .json files in a directory, replacing benchmarks with the same id."},
+		"benchmark_define": {"benchmark", "string", "Adds a benchmark map of id, title, version, platform and controls, each with the keys of the JSON files. A control may have a check function in place of expect, given each item and returning problems like an iac_add_rule check, and a collect function returning the items in place of the query."},
+		"benchmark_list":   {"", "array", "Returns the built-in, loaded and defined benchmarks with their id, title, version, platform, source, number of controls and sections."},
+	}},
+	{"Cryptanalysis and machine learning", map[string]entry{
+		"crypto_generate_key":        {"bits", "string", "Generates a random key of the given size."},
+		"crypto_hash_sha256":         {"data", "string", "Returns the hex SHA-256 digest."},
+		"crypto_analyze_certificate": {"pem", "map", "Inspects a certificate for weak parameters."},
+		"ml_detect_anomalies":        {"data, model", "map", "Scores data points for anomalies."},
+		"ml_classify_threat":         {"features, model", "map", "Classifies a threat from a feature map."},
+		"ml_list_models":             {"", "array", "Lists the available models."},
+		"crypto_analyze_tls":         {"host, port", "map", "Checks the TLS versions and ciphers of a server."},
+		"ct_search":                  {"domain, options...", "array", "Returns the unexpired certificates the certificate transparency logs recorded for a domain and its subdomains, newest first, from crt.sh: id, issuer, common_name, names, serial, not_before, not_after, days_left and logged_at. options set exact, to leave subdomains out, include_expired, timeout_ms and url, of another service with the API of crt.sh."},
+		"cert_expiry_monitor":        {"hosts, days..., options...", "array", "Checks the certificates of endpoints, host, host:port, URLs or maps of host, port and server_name, and returns findings for those that expire within days, 30 by default, are not valid for their name or not trusted, or could not be checked, with host, port, status, severity, title, problems, subject, issuer, names, not_after and days_left. options set all, to return every endpoint, ca_file, of CAs to trust besides the system's, timeout_ms and concurrency."},
+		"ml_train_model":             {"name, type, training_data", "map", "Trains a model on an array of samples."},
+		"ml_get_model_info":          {"name", "map", "Returns details of a model."},
+		"ml_analyze_behavior":        {"entity_id, behavior", "map", "Scores the behaviour of an entity against its baseline."},
+		"ml_create_threat_profile":   {"name, type, indicators", "map", "Creates a threat profile from indicators."},
+	}},
+	{"Concurrency", map[string]entry{
+		"worker_pool_create":       {"id, size, buffer", "bool", "Creates a worker pool."},
+		"worker_pool_start":        {"id", "bool", "Starts a worker pool."},
+		"rate_limiter_create":      {"id, rate, burst", "bool", "Creates a token-bucket rate limiter."},
+		"semaphore_create":         {"id, capacity", "bool", "Creates a counting semaphore."},
+		"task_queue_create":        {"id, buffer", "bool", "Creates a buffered task queue."},
+		"conc_create_worker_pool":  {"id, size, buffer", "bool", "Alias of worker_pool_create."},
+		"conc_start_worker_pool":   {"id", "bool", "Alias of worker_pool_start."},
+		"conc_submit_job":          {"pool_id, job_id, handler, data, reply...", "bool", "Submits a job to a worker pool. handler is a job type or a function, which runs with a copy of data on a VM of its own. The result goes to the reply channel if given, else to conc_job_result."},
+		"conc_job_result":          {"pool_id, timeout_ms...", "map", "Returns the next job result of a worker pool, waiting up to timeout_ms for one, or nil."},
+		"conc_create_rate_limiter": {"id, rate, burst", "bool", "Alias of rate_limiter_create."},
+		"conc_acquire_token":       {"limiter_id, timeout_ms", "bool", "Waits up to timeout_ms for a rate limiter token."},
+		"conc_get_metrics":         {"", "map", "Returns pool, limiter and queue statistics."},
+		"conc_map_create":          {"id", "bool", "Creates a map that scripts and workers can share safely. Returns false if it already exists."},
+		"conc_map_get":             {"id, key", "any", "Returns a copy of the value under key, or nil."},
+		"conc_map_set":             {"id, key, value", "", "Stores a copy of value under key."},
+		"conc_map_delete":          {"id, key", "bool", "Removes key and reports whether it was present."},
+		"conc_map_incr":            {"id, key, amount...", "number", "Adds amount, 1 by default, to the number under key in one step and returns the result."},
+		"conc_map_keys":            {"id", "array", "Returns the keys of a concurrent map in sorted order."},
+		"conc_map_len":             {"id", "int", "Returns the number of keys in a concurrent map."},
+		"conc_queue_create":        {"id, capacity", "bool", "Creates a first-in first-out queue holding up to capacity values. Returns false if it already exists."},
+		"conc_queue_push":          {"id, value, timeout_ms...", "bool", "Queues a copy of value, waiting up to timeout_ms for room, and reports whether it was queued."},
+		"conc_queue_pop":           {"id, timeout_ms...", "any", "Removes the oldest value, waiting up to timeout_ms for one, or returns nil."},
+		"conc_queue_close":         {"id", "", "Closes a queue. Queued values can still be popped."},
+		"conc_queue_done":          {"id", "bool", "Reports whether a queue is closed and empty."},
+		"conc_queue_len":           {"id", "int", "Returns the number of queued values."},
+		"chan_new":                 {"capacity...", "channel", "Creates a channel buffering up to capacity values, 0 by default, so that a send waits for a receiver."},
+		"chan_send":                {"channel, value, timeout_ms...", "bool", "Sends a copy of value, waiting up to timeout_ms, or for as long as it takes without one, and reports whether it was sent. Sending on a closed channel is an error."},
+		"chan_recv":                {"channel, timeout_ms...", "any", "Receives the next value, waiting up to timeout_ms, or for as long as it takes without one. Returns nil on a timeout or once the channel is closed and drained."},
+		"chan_select":              {"cases, timeout_ms...", "map", "Runs the first ready case, a channel to receive from or a [channel, value] pair to send, and returns its index, value and ok, which is false for a closed and drained channel. index is -1 if no case was ready within timeout_ms; a timeout of 0 acts as a default clause."},
+		"chan_close":               {"channel", "", "Closes a channel. Values already sent can still be received, and a for loop over the channel ends once they are."},
+		"chan_done":                {"channel", "bool", "Reports whether a channel is closed and drained."},
+		"chan_len":                 {"channel", "int", "Returns the number of values buffered in a channel."},
+		"chan_cap":                 {"channel", "int", "Returns the buffer size of a channel."},
+		"mutex_new":                {"id", "bool", "Creates a mutex that scripts and workers can share. Returns false if it already exists."},
+		"mutex_lock":               {"id, timeout_ms...", "bool", "Locks a mutex, waiting up to timeout_ms, or for as long as it takes without one, and reports whether it was locked."},
+		"mutex_unlock":             {"id", "", "Unlocks a mutex. Unlocking one that is not locked is an error."},
+		"mutex_locked":             {"id", "bool", "Reports whether a mutex is locked."},
+		"atomic_new":               {"id, value...", "bool", "Creates an integer counter that scripts and workers can change atomically, starting at value or 0. Returns false if it already exists."},
+		"atomic_add":               {"id, delta...", "int", "Adds delta, 1 by default, to a counter and returns the result."},
+		"atomic_get":               {"id", "int", "Returns the value of a counter."},
+		"atomic_set":               {"id, value", "", "Sets a counter to value."},
+		"atomic_cas":               {"id, old, new", "bool", "Sets a counter to new if it is old, and reports whether it did."},
+		"waitgroup_new":            {"id", "bool", "Creates a wait group, which waits for a number of tasks to finish. Returns false if it already exists."},
+		"waitgroup_add":            {"id, count...", "", "Adds count, 1 by default, to the tasks a wait group waits for."},
+		"waitgroup_done":           {"id", "", "Marks one task of a wait group as finished."},
+		"waitgroup_wait":           {"id, timeout_ms...", "bool", "Waits up to timeout_ms, or for as long as it takes without one, for every task of a wait group to finish, and reports whether they did."},
+		"waitgroup_count":          {"id", "int", "Returns the number of tasks a wait group still waits for."},
+	}},
+	{"Blockchain", map[string]entry{
+		"blockchain_connect":             {"network, endpoint", "map", "Connects to a blockchain node."},
+		"blockchain_analyze_transaction": {"conn_id, tx_hash", "map", "Analyses a transaction for risk."},
+		"blockchain_audit_contract":      {"conn_id, address", "map", "Audits a smart contract for known flaws."},
+		"blockchain_check_wallet":        {"conn_id, address", "map", "Scores the risk of a wallet."},
+		"blockchain_trace_funds":         {"conn_id, address, depth", "map", "Follows funds out of an address."},
+		"blockchain_analyze_defi":        {"conn_id, protocol_address", "map", "Analyses a DeFi protocol."},
+		"blockchain_nft_analysis":        {"conn_id, contract", "map", "Analyses an NFT contract."},
+		"blockchain_compliance_check":    {"conn_id, address, jurisdiction", "map", "Checks an address against sanctions and AML rules."},
+	}},
+	{"IoT and mobile", map[string]entry{
+		"iot_scan_device":           {"type, device_id", "map", "Scans an IoT device."},
+		"iot_firmware_analysis":     {"device_id, firmware_path", "map", "Analyses device firmware."},
+		"iot_protocol_security":     {"host, protocol, options...", "map", "Audits the MQTT or AMQP broker at host for anonymous access, default credentials, missing TLS, open topic ACLs and readable queues, and returns the issues and a score. protocol is mqtt, mqtts, amqp or amqps; options are those of mqtt_connect or amqp_connect, with topics, publish_topics and wait for MQTT and queues for AMQP."},
+		"iot_network_analysis":      {"device_id", "map", "Analyses the traffic of a device."},
+		"iot_device_authentication": {"device_id", "map", "Checks how a device authenticates."},
+		"iot_data_protection":       {"device_id", "map", "Checks how a device protects data."},
+		"iot_threat_modeling":       {"device_id", "map", "Builds a threat model for a device."},
+		"iot_compliance_check":      {"device_id, standard", "map", "Checks a device against a standard."},
+		"mobile_scan_device":        {"type, device_id", "map", "Scans a mobile device."},
+		"mobile_analyze_app":        {"device_id, app_id, app_path", "map", "Analyses a mobile app."},
+		"mobile_check_permissions":  {"device_id, app_id", "map", "Checks the permissions of an app."},
+		"mobile_data_protection":    {"device_id, app_id", "map", "Checks how an app protects data."},
+		"mobile_network_security":   {"device_id", "map", "Checks the network settings of a device."},
+		"mobile_threat_detection":   {"device_id", "map", "Looks for threats on a device."},
+		"mobile_forensic_analysis":  {"device_id", "map", "Collects forensic artifacts from a device."},
+		"mobile_compliance_check":   {"device_id, framework", "map", "Checks a device against a framework."},
+	}},
+	{"Compliance", map[string]entry{
+		"compliance_assess_framework":     {"framework, organization, scope", "map", "Assesses an organization against a framework."},
+		"compliance_gap_analysis":         {"current, target", "map", "Lists the gaps between two frameworks."},
+		"compliance_risk_assessment":      {"framework, asset_type", "map", "Rates the risk to a type of asset."},
+		"compliance_audit_trail":          {"start_date, end_date, filter", "map", "Returns audit events in a date range."},
+		"compliance_evidence_management":  {"control_id, action", "map", "Manages the evidence for a control."},
+		"compliance_remediation_tracking": {"tracking_id", "map", "Returns the progress of a remediation."},
+		"compliance_reporting":            {"framework, type, period", "map", "Creates a compliance report."},
+	}},
+	{"Arrays and data frames", map[string]entry{
+		"array_create":        {"data", "array", "Creates an n-dimensional array from nested arrays."},
+		"array_zeros":         {"shape...", "array", "Creates an array of zeros."},
+		"array_ones":          {"shape...", "array", "Creates an array of ones."},
+		"array_arange":        {"start, stop, step", "array", "Creates evenly spaced values in [start, stop)."},
+		"array_linspace":      {"start, stop, num", "array", "Creates num evenly spaced values from start to stop."},
+		"array_mean":          {"array", "float", "Returns the mean of all elements."},
+		"array_std":           {"array", "float", "Returns the standard deviation."},
+		"array_sum":           {"array", "float", "Returns the sum of all elements."},
+		"array_min":           {"array", "float", "Returns the smallest element."},
+		"array_max":           {"array", "float", "Returns the largest element."},
+		"array_add":           {"array1, array2", "array", "Adds two arrays element-wise."},
+		"array_multiply":      {"array1, array2", "array", "Multiplies two arrays element-wise."},
+		"array_dot":           {"array1, array2", "array", "Returns the matrix product."},
+		"array_transpose":     {"array", "array", "Transposes a 2D array."},
+		"array_reshape":       {"array, shape...", "array", "Returns the array with a new shape."},
+		"df_create":           {"columns", "map", "Creates a data frame from a map of column arrays."},
+		"df_read_csv":         {"path", "map", "Reads a CSV file into a data frame."},
+		"df_select":           {"df, columns", "map", "Not yet implemented."},
+		"df_filter":           {"df, condition", "map", "Not yet implemented."},
+		"df_groupby":          {"df, column", "map", "Not yet implemented."},
+		"df_join":             {"left, right, column", "map", "Not yet implemented."},
+		"df_sort":             {"df, column, ascending", "map", "Not yet implemented."},
+		"df_describe":         {"df", "map", "Not yet implemented."},
+		"df_head":             {"df, n", "map", "Not yet implemented."},
+		"df_tail":             {"df, n", "map", "Not yet implemented."},
+		"df_to_csv":           {"df, path", "bool", "Not yet implemented."},
+		"df_to_json":          {"df", "string", "Not yet implemented."},
+		"df_add_column":       {"df, name, values", "map", "Not yet implemented."},
+		"df_drop_column":      {"df, name", "map", "Not yet implemented."},
+		"df_fillna":           {"df, value", "map", "Not yet implemented."},
+		"series_create":       {"data, name", "map", "Creates a named series."},
+		"series_mean":         {"series", "float", "Returns the mean."},
+		"series_median":       {"series", "float", "Returns the median."},
+		"series_std":          {"series", "float", "Returns the standard deviation."},
+		"series_min":          {"series", "float", "Returns the smallest value."},
+		"series_max":          {"series", "float", "Returns the largest value."},
+		"series_sum":          {"series", "float", "Returns the sum."},
+		"series_value_counts": {"series", "map", "Counts occurrences of each value."},
+		"series_unique":       {"series", "array", "Returns the distinct values."},
+		"series_sort":         {"series, ascending", "map", "Returns a sorted series."},
+	}},
+	{"Testing", map[string]entry{
+		"assert_equal":     {"expected, actual, message", "", "Fails the test unless actual equals expected."},
+		"assert_not_equal": {"expected, actual, message", "", "Fails the test if actual equals expected."},
+		"assert_true":      {"condition, message", "", "Fails the test unless condition is true."},
+		"assert_false":     {"condition, message", "", "Fails the test unless condition is false."},
+		"assert_contains":  {"haystack, needle, message", "", "Fails the test unless haystack contains needle."},
+		"assert_nil":       {"value, message", "", "Fails the test unless value is nil."},
+		"assert_not_nil":   {"value, message", "", "Fails the test if value is nil."},
+		"skip":             {"reason", "", "Skips the current test."},
+		"test_summary":     {"", "map", "Returns the pass and fail counts of the assertions so far."},
+		"assert":           {"condition, message", "", "Fails with message unless condition is true."},
+	}},
+}
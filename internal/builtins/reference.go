@@ -0,0 +1,29 @@
+// internal/builtins/reference.go
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reference renders every builtin as a Markdown document, one section per
+// category
+func Reference() string {
+	load()
+	var doc strings.Builder
+	doc.WriteString("# Sentra Builtin Functions\n")
+	for _, category := range categories {
+		names := make([]string, 0, len(category.entries))
+		for name := range category.entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&doc, "\n## %s\n", category.name)
+		for _, name := range names {
+			b := registry[name]
+			fmt.Fprintf(&doc, "\n### %s\n\n```sentra\n%s\n```\n\n%s\n", b.Name, b.Signature(), b.Doc)
+		}
+	}
+	return doc.String()
+}
@@ -0,0 +1,48 @@
+// internal/builtins/strings.go
+package builtins
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	implement("starts_with", func(args []Value) (Value, error) {
+		return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+	})
+	implement("ends_with", func(args []Value) (Value, error) {
+		return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+	})
+	implement("char_code", func(args []Value) (Value, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("char_code expects string as argument")
+		}
+		if len(s) == 0 {
+			return int64(0), nil
+		}
+		return int64(s[0]), nil
+	})
+	implement("sql_escape", func(args []Value) (Value, error) {
+		var escaped strings.Builder
+		for _, ch := range toString(args[0]) {
+			switch ch {
+			case '\'':
+				escaped.WriteString("''")
+			case '"':
+				escaped.WriteString("\"\"")
+			case '\\':
+				escaped.WriteString("\\\\")
+			case '\n':
+				escaped.WriteString("\\n")
+			case '\r':
+				escaped.WriteString("\\r")
+			case '\t':
+				escaped.WriteString("\\t")
+			default:
+				escaped.WriteRune(ch)
+			}
+		}
+		return escaped.String(), nil
+	})
+}
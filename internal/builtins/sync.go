@@ -0,0 +1,220 @@
+// internal/builtins/sync.go
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"sentra/internal/concurrency"
+)
+
+// Mutexes, atomic counters and wait groups are named, like concurrent maps
+// and queues, so that worker pool jobs, which run with copies of the
+// script's values, reach the same ones as the script.
+var (
+	concMutexes    = map[string]*concurrency.Mutex{}
+	concCounters   = map[string]*atomic.Int64{}
+	concWaitGroups = map[string]*concurrency.WaitGroup{}
+)
+
+func concMutex(name string, id Value) (*concurrency.Mutex, error) {
+	concMu.Lock()
+	defer concMu.Unlock()
+	m, ok := concMutexes[toString(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: mutex not found: %s", name, toString(id))
+	}
+	return m, nil
+}
+
+func concCounter(name string, id Value) (*atomic.Int64, error) {
+	concMu.Lock()
+	defer concMu.Unlock()
+	n, ok := concCounters[toString(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: counter not found: %s", name, toString(id))
+	}
+	return n, nil
+}
+
+func concWaitGroup(name string, id Value) (*concurrency.WaitGroup, error) {
+	concMu.Lock()
+	defer concMu.Unlock()
+	wg, ok := concWaitGroups[toString(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: wait group not found: %s", name, toString(id))
+	}
+	return wg, nil
+}
+
+// wholeNumber returns an argument that must be a whole number
+func wholeNumber(name string, v Value) (int64, error) {
+	n, ok := toNumber(v)
+	if !ok || n != math.Trunc(n) {
+		return 0, fmt.Errorf("%s expects a whole number, got %s", name, toString(v))
+	}
+	return int64(n), nil
+}
+
+func init() {
+	implement("mutex_new", func(args []Value) (Value, error) {
+		concMu.Lock()
+		defer concMu.Unlock()
+		id := toString(args[0])
+		if _, ok := concMutexes[id]; ok {
+			return false, nil
+		}
+		concMutexes[id] = concurrency.NewMutex()
+		return true, nil
+	})
+	implement("mutex_lock", withArgs("mutex_lock", 1, 2, func(args []Value) (Value, error) {
+		m, err := concMutex("mutex_lock", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("mutex_lock", args, 1, concurrency.Forever)
+		if err != nil {
+			return nil, err
+		}
+		return m.Lock(wait), nil
+	}))
+	implement("mutex_unlock", func(args []Value) (Value, error) {
+		m, err := concMutex("mutex_unlock", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Unlock(); err != nil {
+			return nil, fmt.Errorf("mutex_unlock: %s: %w", toString(args[0]), err)
+		}
+		return nil, nil
+	})
+	implement("mutex_locked", func(args []Value) (Value, error) {
+		m, err := concMutex("mutex_locked", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return m.Locked(), nil
+	})
+
+	implement("atomic_new", withArgs("atomic_new", 1, 2, func(args []Value) (Value, error) {
+		var initial int64
+		if len(args) == 2 {
+			var err error
+			if initial, err = wholeNumber("atomic_new", args[1]); err != nil {
+				return nil, err
+			}
+		}
+		concMu.Lock()
+		defer concMu.Unlock()
+		id := toString(args[0])
+		if _, ok := concCounters[id]; ok {
+			return false, nil
+		}
+		concCounters[id] = new(atomic.Int64)
+		concCounters[id].Store(initial)
+		return true, nil
+	}))
+	implement("atomic_add", withArgs("atomic_add", 1, 2, func(args []Value) (Value, error) {
+		n, err := concCounter("atomic_add", args[0])
+		if err != nil {
+			return nil, err
+		}
+		delta := int64(1)
+		if len(args) == 2 {
+			if delta, err = wholeNumber("atomic_add", args[1]); err != nil {
+				return nil, err
+			}
+		}
+		return n.Add(delta), nil
+	}))
+	implement("atomic_get", func(args []Value) (Value, error) {
+		n, err := concCounter("atomic_get", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return n.Load(), nil
+	})
+	implement("atomic_set", func(args []Value) (Value, error) {
+		n, err := concCounter("atomic_set", args[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := wholeNumber("atomic_set", args[1])
+		if err != nil {
+			return nil, err
+		}
+		n.Store(value)
+		return nil, nil
+	})
+	implement("atomic_cas", func(args []Value) (Value, error) {
+		n, err := concCounter("atomic_cas", args[0])
+		if err != nil {
+			return nil, err
+		}
+		old, err := wholeNumber("atomic_cas", args[1])
+		if err != nil {
+			return nil, err
+		}
+		value, err := wholeNumber("atomic_cas", args[2])
+		if err != nil {
+			return nil, err
+		}
+		return n.CompareAndSwap(old, value), nil
+	})
+
+	implement("waitgroup_new", func(args []Value) (Value, error) {
+		concMu.Lock()
+		defer concMu.Unlock()
+		id := toString(args[0])
+		if _, ok := concWaitGroups[id]; ok {
+			return false, nil
+		}
+		concWaitGroups[id] = concurrency.NewWaitGroup()
+		return true, nil
+	})
+	implement("waitgroup_add", withArgs("waitgroup_add", 1, 2, func(args []Value) (Value, error) {
+		wg, err := concWaitGroup("waitgroup_add", args[0])
+		if err != nil {
+			return nil, err
+		}
+		delta := int64(1)
+		if len(args) == 2 {
+			if delta, err = wholeNumber("waitgroup_add", args[1]); err != nil {
+				return nil, err
+			}
+		}
+		if err := wg.Add(int(delta)); err != nil {
+			return nil, fmt.Errorf("waitgroup_add: %s: %w", toString(args[0]), err)
+		}
+		return nil, nil
+	}))
+	implement("waitgroup_done", func(args []Value) (Value, error) {
+		wg, err := concWaitGroup("waitgroup_done", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := wg.Add(-1); err != nil {
+			return nil, fmt.Errorf("waitgroup_done: %s: %w", toString(args[0]), err)
+		}
+		return nil, nil
+	})
+	implement("waitgroup_wait", withArgs("waitgroup_wait", 1, 2, func(args []Value) (Value, error) {
+		wg, err := concWaitGroup("waitgroup_wait", args[0])
+		if err != nil {
+			return nil, err
+		}
+		wait, err := optionalWait("waitgroup_wait", args, 1, concurrency.Forever)
+		if err != nil {
+			return nil, err
+		}
+		return wg.Wait(wait), nil
+	}))
+	implement("waitgroup_count", func(args []Value) (Value, error) {
+		wg, err := concWaitGroup("waitgroup_count", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return int64(wg.Count()), nil
+	})
+}
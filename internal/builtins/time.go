@@ -0,0 +1,94 @@
+// internal/builtins/time.go
+package builtins
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	implement("date_format", func(args []Value) (Value, error) {
+		timestamp, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("date_format expects number as first argument")
+		}
+		format, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("date_format expects string as second argument")
+		}
+		return time.Unix(int64(timestamp), 0).Format(format), nil
+	})
+	implement("parse_date", func(args []Value) (Value, error) {
+		text, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("parse_date expects string as first argument")
+		}
+		format, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("parse_date expects string as second argument")
+		}
+		t, err := time.Parse(format, text)
+		if err != nil {
+			return nil, fmt.Errorf("parse_date error: %v", err)
+		}
+		return t.Unix(), nil
+	})
+	implement("date_add", func(args []Value) (Value, error) {
+		timestamp, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("date_add expects number as first argument")
+		}
+		amount, ok := toNumber(args[1])
+		if !ok {
+			return nil, fmt.Errorf("date_add expects number as second argument")
+		}
+		unit, ok := args[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("date_add expects string as third argument")
+		}
+		t := time.Unix(int64(timestamp), 0)
+		switch unit {
+		case "seconds":
+			t = t.Add(time.Duration(amount) * time.Second)
+		case "minutes":
+			t = t.Add(time.Duration(amount) * time.Minute)
+		case "hours":
+			t = t.Add(time.Duration(amount) * time.Hour)
+		case "days":
+			t = t.AddDate(0, 0, int(amount))
+		case "months":
+			t = t.AddDate(0, int(amount), 0)
+		case "years":
+			t = t.AddDate(int(amount), 0, 0)
+		default:
+			return nil, fmt.Errorf("date_add: unknown unit '%s'", unit)
+		}
+		return t.Unix(), nil
+	})
+	implement("date_diff", func(args []Value) (Value, error) {
+		from, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("date_diff expects number as first argument")
+		}
+		to, ok := toNumber(args[1])
+		if !ok {
+			return nil, fmt.Errorf("date_diff expects number as second argument")
+		}
+		unit, ok := args[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("date_diff expects string as third argument")
+		}
+		diff := time.Unix(int64(to), 0).Sub(time.Unix(int64(from), 0))
+		switch unit {
+		case "seconds":
+			return number(diff.Seconds()), nil
+		case "minutes":
+			return number(diff.Minutes()), nil
+		case "hours":
+			return number(diff.Hours()), nil
+		case "days":
+			return number(diff.Hours() / 24), nil
+		}
+		return nil, fmt.Errorf("date_diff: unknown unit '%s'", unit)
+	})
+}
@@ -0,0 +1,88 @@
+// internal/builtins/values.go
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// toString formats a value the way print shows it
+func toString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []Value:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			parts[i] = toString(elem)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// toNumber returns a numeric argument as a float
+func toNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// number returns whole numbers as ints, as the register VM's own builtins
+// do, and others as floats
+func number(f float64) Value {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return f
+}
+
+// equal compares two values, treating ints and floats of the same value as
+// equal
+func equal(a, b Value) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	switch a := a.(type) {
+	case []Value:
+		b, ok := b.([]Value)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]Value:
+		b, ok := b.(map[string]Value)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, item := range a {
+			other, ok := b[key]
+			if !ok || !equal(item, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
@@ -12,6 +12,7 @@ type Chunk struct {
 	Code      []byte
 	Constants []interface{}
 	Debug     []DebugInfo // Debug info for each instruction
+	Line      int         // Source line recorded by WriteOp and WriteByte
 }
 
 func NewChunk() *Chunk {
@@ -25,7 +26,7 @@ func NewChunk() *Chunk {
 func (c *Chunk) WriteOp(op OpCode) {
 	c.Code = append(c.Code, byte(op))
 	// Add default debug info - should be overridden by WriteOpWithDebug
-	c.Debug = append(c.Debug, DebugInfo{})
+	c.Debug = append(c.Debug, DebugInfo{Line: c.Line})
 }
 
 func (c *Chunk) WriteOpWithDebug(op OpCode, debug DebugInfo) {
@@ -36,7 +37,7 @@ func (c *Chunk) WriteOpWithDebug(op OpCode, debug DebugInfo) {
 func (c *Chunk) WriteByte(b byte) {
 	c.Code = append(c.Code, b)
 	// Add default debug info for operands
-	c.Debug = append(c.Debug, DebugInfo{})
+	c.Debug = append(c.Debug, DebugInfo{Line: c.Line})
 }
 
 func (c *Chunk) WriteByteWithDebug(b byte, debug DebugInfo) {
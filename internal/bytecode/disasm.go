@@ -0,0 +1,165 @@
+package bytecode
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var opNames = [...]string{
+	OpConstant:     "CONSTANT",
+	OpAdd:          "ADD",
+	OpSub:          "SUB",
+	OpMul:          "MUL",
+	OpDiv:          "DIV",
+	OpMod:          "MOD",
+	OpNegate:       "NEGATE",
+	OpEqual:        "EQUAL",
+	OpNotEqual:     "NOT_EQUAL",
+	OpGreater:      "GREATER",
+	OpLess:         "LESS",
+	OpGreaterEqual: "GREATER_EQUAL",
+	OpLessEqual:    "LESS_EQUAL",
+	OpNil:          "NIL",
+	OpPop:          "POP",
+	OpDup:          "DUP",
+	OpPrint:        "PRINT",
+	OpJump:         "JUMP",
+	OpJumpIfFalse:  "JUMP_IF_FALSE",
+	OpLoop:         "LOOP",
+	OpDefineGlobal: "DEFINE_GLOBAL",
+	OpGetGlobal:    "GET_GLOBAL",
+	OpSetGlobal:    "SET_GLOBAL",
+	OpGetLocal:     "GET_LOCAL",
+	OpSetLocal:     "SET_LOCAL",
+	OpCall:         "CALL",
+	OpClosure:      "CLOSURE",
+	OpGetUpvalue:   "GET_UPVALUE",
+	OpSetUpvalue:   "SET_UPVALUE",
+	OpReturn:       "RETURN",
+	OpArray:        "ARRAY",
+	OpIndex:        "INDEX",
+	OpSetIndex:     "SET_INDEX",
+	OpArrayLen:     "ARRAY_LEN",
+	OpMap:          "MAP",
+	OpMapGet:       "MAP_GET",
+	OpMapSet:       "MAP_SET",
+	OpMapDelete:    "MAP_DELETE",
+	OpMapKeys:      "MAP_KEYS",
+	OpMapValues:    "MAP_VALUES",
+	OpConcat:       "CONCAT",
+	OpStringLen:    "STRING_LEN",
+	OpSubstring:    "SUBSTRING",
+	OpToString:     "TO_STRING",
+	OpAnd:          "AND",
+	OpOr:           "OR",
+	OpNot:          "NOT",
+	OpIterStart:    "ITER_START",
+	OpIterNext:     "ITER_NEXT",
+	OpIterEnd:      "ITER_END",
+	OpImport:       "IMPORT",
+	OpExport:       "EXPORT",
+	OpTry:          "TRY",
+	OpCatch:        "CATCH",
+	OpThrow:        "THROW",
+	OpTypeOf:       "TYPE_OF",
+	OpIsType:       "IS_TYPE",
+	OpLoadFast:     "LOAD_FAST",
+	OpStoreFast:    "STORE_FAST",
+	OpBuildList:    "BUILD_LIST",
+	OpBuildMap:     "BUILD_MAP",
+	OpUnpack:       "UNPACK",
+	OpSpread:       "SPREAD",
+	OpSpawn:        "SPAWN",
+	OpChannelNew:   "CHANNEL_NEW",
+	OpChannelSend:  "CHANNEL_SEND",
+	OpChannelRecv:  "CHANNEL_RECV",
+	OpSelect:       "SELECT",
+	OpSlice:        "SLICE",
+	OpCallKw:       "CALL_KW",
+	OpTailCall:     "TAIL_CALL",
+	OpImportName:   "IMPORT_NAME",
+}
+
+func (op OpCode) String() string {
+	if int(op) < len(opNames) && opNames[op] != "" {
+		return opNames[op]
+	}
+	return fmt.Sprintf("OP_%d", op)
+}
+
+// Instruction renders the instruction at offset as its opcode, operands and
+// a comment with the constant or jump target it refers to, and returns the
+// offset of the next instruction
+func (c *Chunk) Instruction(offset int) (string, int) {
+	op := OpCode(c.Code[offset])
+	name := op.String()
+	operand := func(i int) int {
+		if offset+i < len(c.Code) {
+			return int(c.Code[offset+i])
+		}
+		return 0
+	}
+	short := func() int { return operand(1)<<8 | operand(2) }
+
+	switch op {
+	case OpConstant, OpGetGlobal, OpSetGlobal, OpDefineGlobal, OpImport, OpExport:
+		k := operand(1)
+		return fmt.Sprintf("%-13s K%-12d ; %s", name, k, c.Constant(k)), offset + 2
+	case OpGetLocal, OpSetLocal, OpLoadFast, OpStoreFast:
+		return fmt.Sprintf("%-13s L%d", name, operand(1)), offset + 2
+	case OpCall, OpTailCall, OpIterNext:
+		return fmt.Sprintf("%-13s %d", name, operand(1)), offset + 2
+	case OpCallKw:
+		k := operand(2)
+		return fmt.Sprintf("%-13s %d K%-10d ; %s", name, operand(1), k, c.Constant(k)), offset + 3
+	case OpImportName:
+		path, export := operand(1), operand(2)
+		return fmt.Sprintf("%-13s K%d K%-10d ; %s %s", name, path, export, c.Constant(path), c.Constant(export)), offset + 3
+	case OpArray, OpBuildList, OpMap, OpBuildMap:
+		return fmt.Sprintf("%-13s %d", name, short()), offset + 3
+	case OpJump, OpJumpIfFalse, OpLoop, OpTry:
+		target, _ := c.JumpTarget(offset)
+		return fmt.Sprintf("%-13s %-13d ; to %04d", name, short(), target), offset + 3
+	}
+	return name, offset + 1
+}
+
+// JumpTarget returns the offset the instruction at offset may jump to
+func (c *Chunk) JumpTarget(offset int) (int, bool) {
+	if offset+2 >= len(c.Code) {
+		return 0, false
+	}
+	distance := int(c.Code[offset+1])<<8 | int(c.Code[offset+2])
+	switch OpCode(c.Code[offset]) {
+	case OpJump, OpJumpIfFalse:
+		return offset + 3 + distance, true
+	case OpLoop:
+		return offset + 3 - distance, true
+	case OpTry:
+		return offset + distance, true
+	}
+	return 0, false
+}
+
+// Constant renders constant k as it would be written in source
+func (c *Chunk) Constant(k int) string {
+	if k >= len(c.Constants) {
+		return "?"
+	}
+	switch v := c.Constants[k].(type) {
+	case nil:
+		return "nil"
+	case string:
+		s := strconv.Quote(v)
+		if len(s) > 40 {
+			s = s[:36] + `..."`
+		}
+		return s
+	case fmt.Stringer:
+		return v.String()
+	case float64, int, int64, bool:
+		return fmt.Sprint(v)
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
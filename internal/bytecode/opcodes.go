@@ -91,4 +91,19 @@ const (
 	OpChannelSend
 	OpChannelRecv
 	OpSelect
+	
+	// Slicing: value[start:end]
+	OpSlice
+	
+	// Calls with keyword arguments: argument count, constant index of the
+	// comma separated names of the last arguments
+	OpCallKw
+	
+	// A call whose result the function returns: argument count. The callee
+	// may run in the returning function's frame.
+	OpTailCall
+	
+	// A selective import: constant indexes of the module path and of the
+	// export to push from the module on top of the stack, which stays
+	OpImportName
 )
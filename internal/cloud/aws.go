@@ -0,0 +1,790 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	iamVersion = "2010-05-08"
+	stsVersion = "2011-06-15"
+	ec2Version = "2016-11-15"
+)
+
+// awsServices are the services the AWS collector reads
+var awsServices = []string{"iam", "s3", "ec2", "cloudtrail"}
+
+// awsCollector reads IAM users and account settings, S3 buckets, EC2
+// security groups and CloudTrail trails. Regional services are read in
+// every enabled region, or the regions setting, a few regions at a time.
+type awsCollector struct {
+	client   *apiClient
+	creds    *awsCredentialChain
+	region   string   // Home region, for global services and discovery
+	regions  []string // Regions to read, all enabled when empty
+	endpoint string   // Endpoint for every service, such as LocalStack
+	services map[string]bool
+}
+
+func newAWSCollector(settings map[string]string, rate float64, timeout time.Duration) (*awsCollector, error) {
+	services, err := serviceSet(settings["services"], awsServices...)
+	if err != nil {
+		return nil, fmt.Errorf("AWS: %v", err)
+	}
+	region := settings["region"]
+	if region == "" {
+		region = firstNonEmpty(awsEnv("AWS_REGION"), awsEnv("AWS_DEFAULT_REGION"), profileRegion(settings["profile"]), "us-east-1")
+	}
+	c := &awsCollector{
+		client:   newAPIClient(rate, timeout, xmlOrJSONError),
+		region:   region,
+		regions:  splitList(settings["regions"]),
+		endpoint: strings.TrimSuffix(settings["endpoint"], "/"),
+		services: services,
+	}
+	c.creds = &awsCredentialChain{settings: settings, client: c.client, region: region, sts: c.url("sts", region)}
+	return c, nil
+}
+
+// xmlOrJSONError reads an AWS error in the format of the protocol that
+// returned it
+func xmlOrJSONError(status int, body []byte) *apiError {
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "{") {
+		return jsonError(status, body)
+	}
+	return xmlError(status, body)
+}
+
+// url returns the endpoint of a service in a region
+func (c *awsCollector) url(service, region string) string {
+	if c.endpoint != "" {
+		return c.endpoint + "/"
+	}
+	if service == "iam" {
+		return "https://iam.amazonaws.com/"
+	}
+	return "https://" + service + "." + region + ".amazonaws.com/"
+}
+
+// signer signs requests to a service in a region with the current
+// credentials. IAM is signed for us-east-1 wherever it is called from.
+func (c *awsCollector) signer(service, region string) func(*http.Request, []byte) error {
+	if service == "iam" {
+		region = "us-east-1"
+	}
+	return func(req *http.Request, body []byte) error {
+		creds, err := c.creds.get(req.Context())
+		if err != nil {
+			return err
+		}
+		signV4(req, body, creds, region, service, time.Now())
+		return nil
+	}
+}
+
+func (c *awsCollector) query(ctx context.Context, service, region, action string, params url.Values, out interface{}) error {
+	version := map[string]string{"iam": iamVersion, "sts": stsVersion, "ec2": ec2Version}[service]
+	if err := queryCall(ctx, c.client, c.url(service, region), action, version, params, c.signer(service, region), out); err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	return nil
+}
+
+// jsonCall calls an action of an API with the AWS JSON 1.1 protocol
+func (c *awsCollector) jsonCall(ctx context.Context, service, region, target string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	body, err := c.client.do(ctx, request{
+		method: http.MethodPost,
+		url:    c.url(service, region),
+		body:   payload,
+		headers: map[string]string{
+			"Content-Type": "application/x-amz-json-1.1",
+			"X-Amz-Target": target,
+		},
+		sign: c.signer(service, region),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", target[strings.LastIndex(target, ".")+1:], err)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *awsCollector) collect(ctx context.Context) (*Inventory, error) {
+	var identity struct {
+		Account string `xml:"GetCallerIdentityResult>Account"`
+		Arn     string `xml:"GetCallerIdentityResult>Arn"`
+	}
+	if err := c.query(ctx, "sts", c.region, "GetCallerIdentity", nil, &identity); err != nil {
+		return nil, fmt.Errorf("AWS: %w", err)
+	}
+	inv := &Inventory{Account: identity.Account}
+
+	regions := c.regions
+	if len(regions) == 0 && (c.services["ec2"] || c.services["cloudtrail"]) {
+		var resp struct {
+			Regions []string `xml:"regionInfo>item>regionName"`
+		}
+		if err := c.query(ctx, "ec2", c.region, "DescribeRegions", nil, &resp); err != nil {
+			inv.fail("ec2", c.region, err)
+			regions = []string{c.region}
+		} else {
+			regions = resp.Regions
+		}
+	}
+	inv.Regions = append([]string{}, regions...)
+
+	if c.services["iam"] {
+		c.collectIAM(ctx, inv)
+	}
+	if c.services["s3"] {
+		c.collectS3(ctx, inv)
+	}
+	var trails []CloudResource
+	forEach(regions, 4, func(region string) {
+		if c.services["ec2"] {
+			c.collectSecurityGroups(ctx, inv, region)
+		}
+		if c.services["cloudtrail"] {
+			found := c.collectTrails(ctx, inv, region)
+			inv.mu.Lock()
+			trails = append(trails, found...)
+			inv.mu.Unlock()
+		}
+	})
+	if c.services["cloudtrail"] {
+		multiRegion := false
+		for _, t := range trails {
+			if t.Config["is_multi_region"] == true && t.Config["is_logging"] == true {
+				multiRegion = true
+			}
+		}
+		inv.add(CloudResource{
+			ID:     "arn:aws:cloudtrail::" + inv.Account + ":account",
+			Type:   "aws:cloudtrail:account",
+			Name:   inv.Account,
+			Region: "global",
+			Config: map[string]interface{}{"trails": len(trails), "multi_region_logging": multiRegion},
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	inv.sort()
+	return inv, nil
+}
+
+// iamUser is a user of ListUsers
+type iamUser struct {
+	UserName         string    `xml:"UserName"`
+	Arn              string    `xml:"Arn"`
+	CreateDate       time.Time `xml:"CreateDate"`
+	PasswordLastUsed time.Time `xml:"PasswordLastUsed"`
+}
+
+func (c *awsCollector) collectIAM(ctx context.Context, inv *Inventory) {
+	var summary struct {
+		Entries []struct {
+			Key   string `xml:"key"`
+			Value int    `xml:"value"`
+		} `xml:"GetAccountSummaryResult>SummaryMap>entry"`
+	}
+	if err := c.query(ctx, "iam", c.region, "GetAccountSummary", nil, &summary); err != nil {
+		inv.fail("iam", "", err)
+	} else {
+		values := map[string]int{}
+		for _, e := range summary.Entries {
+			values[e.Key] = e.Value
+		}
+		inv.add(CloudResource{
+			ID:     "arn:aws:iam::" + inv.Account + ":root",
+			Type:   "aws:iam:root",
+			Name:   "root",
+			Region: "global",
+			Config: map[string]interface{}{
+				"mfa_enabled":         values["AccountMFAEnabled"] == 1,
+				"access_keys_present": values["AccountAccessKeysPresent"] == 1,
+				"users":               values["Users"],
+				"policies":            values["Policies"],
+			},
+		})
+	}
+
+	var policy struct {
+		Policy struct {
+			MinimumPasswordLength      int  `xml:"MinimumPasswordLength"`
+			RequireSymbols             bool `xml:"RequireSymbols"`
+			RequireNumbers             bool `xml:"RequireNumbers"`
+			RequireUppercaseCharacters bool `xml:"RequireUppercaseCharacters"`
+			RequireLowercaseCharacters bool `xml:"RequireLowercaseCharacters"`
+			MaxPasswordAge             int  `xml:"MaxPasswordAge"`
+			PasswordReusePrevention    int  `xml:"PasswordReusePrevention"`
+		} `xml:"GetAccountPasswordPolicyResult>PasswordPolicy"`
+	}
+	err := c.query(ctx, "iam", c.region, "GetAccountPasswordPolicy", nil, &policy)
+	if err != nil && !isCode(err, "NoSuchEntity") {
+		inv.fail("iam", "", err)
+	} else {
+		p := policy.Policy
+		inv.add(CloudResource{
+			ID:     "arn:aws:iam::" + inv.Account + ":password-policy",
+			Type:   "aws:iam:password_policy",
+			Name:   "password policy",
+			Region: "global",
+			Config: map[string]interface{}{
+				"exists":            err == nil,
+				"minimum_length":    p.MinimumPasswordLength,
+				"require_symbols":   p.RequireSymbols,
+				"require_numbers":   p.RequireNumbers,
+				"require_uppercase": p.RequireUppercaseCharacters,
+				"require_lowercase": p.RequireLowercaseCharacters,
+				"max_age":           p.MaxPasswordAge,
+				"reuse_prevention":  p.PasswordReusePrevention,
+			},
+		})
+	}
+
+	marker := ""
+	for {
+		params := url.Values{"MaxItems": {"1000"}}
+		if marker != "" {
+			params.Set("Marker", marker)
+		}
+		var resp struct {
+			Users       []iamUser `xml:"ListUsersResult>Users>member"`
+			IsTruncated bool      `xml:"ListUsersResult>IsTruncated"`
+			Marker      string    `xml:"ListUsersResult>Marker"`
+		}
+		if err := c.query(ctx, "iam", c.region, "ListUsers", params, &resp); err != nil {
+			inv.fail("iam", "", err)
+			return
+		}
+		for _, u := range resp.Users {
+			if r, err := c.iamUser(ctx, u); err != nil {
+				inv.fail("iam", "", fmt.Errorf("user %s: %w", u.UserName, err))
+			} else {
+				inv.add(r)
+			}
+		}
+		if !resp.IsTruncated || resp.Marker == "" {
+			return
+		}
+		marker = resp.Marker
+	}
+}
+
+// iamUser reads the credentials and policies of a user
+func (c *awsCollector) iamUser(ctx context.Context, u iamUser) (CloudResource, error) {
+	user := url.Values{"UserName": {u.UserName}}
+	now := time.Now()
+
+	var mfa struct {
+		Devices []string `xml:"ListMFADevicesResult>MFADevices>member>SerialNumber"`
+	}
+	if err := c.query(ctx, "iam", c.region, "ListMFADevices", user, &mfa); err != nil {
+		return CloudResource{}, err
+	}
+
+	consoleAccess := true
+	if err := c.query(ctx, "iam", c.region, "GetLoginProfile", user, nil); err != nil {
+		if !isCode(err, "NoSuchEntity") {
+			return CloudResource{}, err
+		}
+		consoleAccess = false
+	}
+
+	var keys struct {
+		Keys []struct {
+			ID         string    `xml:"AccessKeyId"`
+			Status     string    `xml:"Status"`
+			CreateDate time.Time `xml:"CreateDate"`
+		} `xml:"ListAccessKeysResult>AccessKeyMetadata>member"`
+	}
+	if err := c.query(ctx, "iam", c.region, "ListAccessKeys", user, &keys); err != nil {
+		return CloudResource{}, err
+	}
+	accessKeys := []interface{}{}
+	active, oldest := 0, 0
+	for _, k := range keys.Keys {
+		var used struct {
+			LastUsed time.Time `xml:"GetAccessKeyLastUsedResult>AccessKeyLastUsed>LastUsedDate"`
+		}
+		if err := c.query(ctx, "iam", c.region, "GetAccessKeyLastUsed", url.Values{"AccessKeyId": {k.ID}}, &used); err != nil {
+			return CloudResource{}, err
+		}
+		age := days(now, k.CreateDate)
+		if k.Status == "Active" {
+			active++
+			if age > oldest {
+				oldest = age
+			}
+		}
+		accessKeys = append(accessKeys, map[string]interface{}{
+			"id":              k.ID,
+			"status":          k.Status,
+			"created":         k.CreateDate.Format(time.RFC3339),
+			"age_days":        age,
+			"last_used":       timeOrEmpty(used.LastUsed),
+			"days_since_used": daysSince(now, used.LastUsed),
+		})
+	}
+
+	var attached struct {
+		Names []string `xml:"ListAttachedUserPoliciesResult>AttachedPolicies>member>PolicyName"`
+	}
+	if err := c.query(ctx, "iam", c.region, "ListAttachedUserPolicies", user, &attached); err != nil {
+		return CloudResource{}, err
+	}
+	var inline struct {
+		Names []string `xml:"ListUserPoliciesResult>PolicyNames>member"`
+	}
+	if err := c.query(ctx, "iam", c.region, "ListUserPolicies", user, &inline); err != nil {
+		return CloudResource{}, err
+	}
+
+	return CloudResource{
+		ID:     u.Arn,
+		Type:   "aws:iam:user",
+		Name:   u.UserName,
+		Region: "global",
+		Config: map[string]interface{}{
+			"console_access":           consoleAccess,
+			"mfa_enabled":              len(mfa.Devices) > 0,
+			"mfa_devices":              len(mfa.Devices),
+			"access_keys":              accessKeys,
+			"active_access_keys":       active,
+			"oldest_active_key_days":   oldest,
+			"attached_policies":        toList(attached.Names),
+			"inline_policies":          len(inline.Names),
+			"created":                  u.CreateDate.Format(time.RFC3339),
+			"password_last_used":       timeOrEmpty(u.PasswordLastUsed),
+			"days_since_password_used": daysSince(now, u.PasswordLastUsed),
+			"administrator_access":     contains(attached.Names, "AdministratorAccess"),
+		},
+	}, nil
+}
+
+// s3PublicAccessBlock is the public access block of a bucket or account
+type s3PublicAccessBlock struct {
+	BlockPublicAcls       bool `xml:"BlockPublicAcls"`
+	IgnorePublicAcls      bool `xml:"IgnorePublicAcls"`
+	BlockPublicPolicy     bool `xml:"BlockPublicPolicy"`
+	RestrictPublicBuckets bool `xml:"RestrictPublicBuckets"`
+}
+
+func (b s3PublicAccessBlock) all() bool {
+	return b.BlockPublicAcls && b.IgnorePublicAcls && b.BlockPublicPolicy && b.RestrictPublicBuckets
+}
+
+func (b s3PublicAccessBlock) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"block_public_acls":       b.BlockPublicAcls,
+		"ignore_public_acls":      b.IgnorePublicAcls,
+		"block_public_policy":     b.BlockPublicPolicy,
+		"restrict_public_buckets": b.RestrictPublicBuckets,
+	}
+}
+
+// s3Get sends a GET to S3 and decodes the XML response. bucket is empty
+// for the service itself.
+func (c *awsCollector) s3Get(ctx context.Context, region, bucket, query string, out interface{}) error {
+	var u string
+	switch {
+	case c.endpoint != "" && bucket != "":
+		u = c.endpoint + "/" + bucket + "?" + query
+	case c.endpoint != "":
+		u = c.endpoint + "/?" + query
+	case bucket == "":
+		u = "https://s3." + region + ".amazonaws.com/?" + query
+	case strings.Contains(bucket, "."):
+		// Virtual hosted names with dots do not match the certificate
+		u = "https://s3." + region + ".amazonaws.com/" + bucket + "?" + query
+	default:
+		u = "https://" + bucket + ".s3." + region + ".amazonaws.com/?" + query
+	}
+	body, err := c.client.do(ctx, request{url: strings.TrimSuffix(u, "?"), sign: c.signer("s3", region)})
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(body, out)
+}
+
+func (c *awsCollector) collectS3(ctx context.Context, inv *Inventory) {
+	account, err := c.accountPublicAccessBlock(ctx, inv.Account)
+	if err != nil {
+		inv.fail("s3", "", fmt.Errorf("account public access block: %w", err))
+	} else {
+		config := account.toMap()
+		config["public_access_blocked"] = account.all()
+		inv.add(CloudResource{
+			ID:     "arn:aws:s3:::" + inv.Account,
+			Type:   "aws:s3:account",
+			Name:   inv.Account,
+			Region: "global",
+			Config: config,
+		})
+	}
+
+	type bucket struct {
+		Name         string    `xml:"Name"`
+		CreationDate time.Time `xml:"CreationDate"`
+		Region       string    `xml:"BucketRegion"`
+	}
+	var buckets []bucket
+	token := ""
+	for {
+		query := "max-buckets=1000"
+		if token != "" {
+			query += "&continuation-token=" + url.QueryEscape(token)
+		}
+		var resp struct {
+			Buckets []bucket `xml:"Buckets>Bucket"`
+			Token   string   `xml:"ContinuationToken"`
+		}
+		if err := c.s3Get(ctx, "us-east-1", "", query, &resp); err != nil {
+			inv.fail("s3", "", fmt.Errorf("ListBuckets: %w", err))
+			return
+		}
+		buckets = append(buckets, resp.Buckets...)
+		if resp.Token == "" {
+			break
+		}
+		token = resp.Token
+	}
+
+	names := make([]string, len(buckets))
+	byName := map[string]bucket{}
+	for i, b := range buckets {
+		names[i] = b.Name
+		byName[b.Name] = b
+	}
+	forEach(names, 4, func(name string) {
+		b := byName[name]
+		r, err := c.s3Bucket(ctx, b.Name, b.Region, account)
+		if err != nil {
+			inv.fail("s3", "", fmt.Errorf("bucket %s: %w", b.Name, err))
+			return
+		}
+		r.Config["created"] = b.CreationDate.Format(time.RFC3339)
+		inv.add(r)
+	})
+}
+
+// accountPublicAccessBlock reads the public access block of the account,
+// which applies to every bucket
+func (c *awsCollector) accountPublicAccessBlock(ctx context.Context, account string) (s3PublicAccessBlock, error) {
+	var block s3PublicAccessBlock
+	u := "https://" + account + ".s3-control." + c.region + ".amazonaws.com/v20180820/configuration/publicAccessBlock"
+	if c.endpoint != "" {
+		u = c.endpoint + "/v20180820/configuration/publicAccessBlock"
+	}
+	body, err := c.client.do(ctx, request{
+		url:     u,
+		headers: map[string]string{"X-Amz-Account-Id": account},
+		sign:    c.signer("s3", c.region),
+	})
+	if isCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		return block, nil
+	}
+	if err != nil {
+		return block, err
+	}
+	return block, xml.Unmarshal(body, &block)
+}
+
+// publicGroups are the ACL grantees that make a bucket public
+var publicGroups = map[string]bool{
+	"http://acs.amazonaws.com/groups/global/AllUsers":           true,
+	"http://acs.amazonaws.com/groups/global/AuthenticatedUsers": true,
+}
+
+// s3Bucket reads the access, encryption, logging and versioning settings of
+// a bucket
+func (c *awsCollector) s3Bucket(ctx context.Context, name, region string, account s3PublicAccessBlock) (CloudResource, error) {
+	if region == "" {
+		var location struct {
+			Region string `xml:",chardata"`
+		}
+		if err := c.s3Get(ctx, "us-east-1", name, "location", &location); err != nil {
+			return CloudResource{}, err
+		}
+		switch region = strings.TrimSpace(location.Region); region {
+		case "":
+			region = "us-east-1"
+		case "EU":
+			region = "eu-west-1"
+		}
+	}
+
+	var block s3PublicAccessBlock
+	if err := c.s3Get(ctx, region, name, "publicAccessBlock", &block); err != nil && !isCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		return CloudResource{}, err
+	}
+	var status struct {
+		IsPublic bool `xml:"IsPublic"`
+	}
+	if err := c.s3Get(ctx, region, name, "policyStatus", &status); err != nil && !isCode(err, "NoSuchBucketPolicy") {
+		return CloudResource{}, err
+	}
+	var acl struct {
+		Grants []struct {
+			URI        string `xml:"Grantee>URI"`
+			Permission string `xml:"Permission"`
+		} `xml:"AccessControlList>Grant"`
+	}
+	if err := c.s3Get(ctx, region, name, "acl", &acl); err != nil {
+		return CloudResource{}, err
+	}
+	publicGrants := []interface{}{}
+	for _, g := range acl.Grants {
+		if publicGroups[g.URI] {
+			publicGrants = append(publicGrants, g.URI[strings.LastIndex(g.URI, "/")+1:]+" "+g.Permission)
+		}
+	}
+	var encryption struct {
+		Algorithm string `xml:"Rule>ApplyServerSideEncryptionByDefault>SSEAlgorithm"`
+		KeyID     string `xml:"Rule>ApplyServerSideEncryptionByDefault>KMSMasterKeyID"`
+	}
+	if err := c.s3Get(ctx, region, name, "encryption", &encryption); err != nil && !isCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+		return CloudResource{}, err
+	}
+	var logging struct {
+		Target string `xml:"LoggingEnabled>TargetBucket"`
+	}
+	if err := c.s3Get(ctx, region, name, "logging", &logging); err != nil {
+		return CloudResource{}, err
+	}
+	var versioning struct {
+		Status    string `xml:"Status"`
+		MfaDelete string `xml:"MfaDelete"`
+	}
+	if err := c.s3Get(ctx, region, name, "versioning", &versioning); err != nil {
+		return CloudResource{}, err
+	}
+
+	config := map[string]interface{}{
+		"public_access_blocked": block.all() || account.all(),
+		"public_access_block":   block.toMap(),
+		// Ignored ACLs and restricted policies do not make a bucket public
+		"public_acl":         len(publicGrants) > 0 && !block.IgnorePublicAcls && !account.IgnorePublicAcls,
+		"public_acl_grants":  publicGrants,
+		"public_policy":      status.IsPublic && !block.RestrictPublicBuckets && !account.RestrictPublicBuckets,
+		"encryption":         encryption.Algorithm,
+		"encryption_enabled": encryption.Algorithm != "",
+		"kms_key":            encryption.KeyID,
+		"logging_enabled":    logging.Target != "",
+		"logging_target":     logging.Target,
+		"versioning_enabled": versioning.Status == "Enabled",
+		"mfa_delete":         versioning.MfaDelete == "Enabled",
+	}
+	return CloudResource{ID: "arn:aws:s3:::" + name, Type: "aws:s3:bucket", Name: name, Region: region, Config: config}, nil
+}
+
+// ec2Permission is an ingress or egress rule of a security group
+type ec2Permission struct {
+	Protocol string   `xml:"ipProtocol"`
+	FromPort *int     `xml:"fromPort"`
+	ToPort   *int     `xml:"toPort"`
+	IPv4     []string `xml:"ipRanges>item>cidrIp"`
+	IPv6     []string `xml:"ipv6Ranges>item>cidrIpv6"`
+	Groups   []string `xml:"groups>item>groupId"`
+	Prefixes []string `xml:"prefixListIds>item>prefixListId"`
+}
+
+func (c *awsCollector) collectSecurityGroups(ctx context.Context, inv *Inventory, region string) {
+	token := ""
+	for {
+		params := url.Values{"MaxResults": {"1000"}}
+		if token != "" {
+			params.Set("NextToken", token)
+		}
+		var resp struct {
+			Groups []struct {
+				ID          string          `xml:"groupId"`
+				Name        string          `xml:"groupName"`
+				Description string          `xml:"groupDescription"`
+				VpcID       string          `xml:"vpcId"`
+				Ingress     []ec2Permission `xml:"ipPermissions>item"`
+				Egress      []ec2Permission `xml:"ipPermissionsEgress>item"`
+				Tags        []struct {
+					Key   string `xml:"key"`
+					Value string `xml:"value"`
+				} `xml:"tagSet>item"`
+			} `xml:"securityGroupInfo>item"`
+			NextToken string `xml:"nextToken"`
+		}
+		if err := c.query(ctx, "ec2", region, "DescribeSecurityGroups", params, &resp); err != nil {
+			inv.fail("ec2", region, err)
+			return
+		}
+		for _, g := range resp.Groups {
+			ingress := []interface{}{}
+			open := []interface{}{}
+			for _, p := range g.Ingress {
+				from, to := -1, -1
+				if p.FromPort != nil {
+					from = *p.FromPort
+				}
+				if p.ToPort != nil {
+					to = *p.ToPort
+				}
+				ports := portRange(p.Protocol, from, to)
+				sources := append(append(append([]string{}, p.IPv4...), p.IPv6...), append(p.Groups, p.Prefixes...)...)
+				for _, src := range sources {
+					if openToWorld(src) {
+						open = append(open, ports)
+						break
+					}
+				}
+				ingress = append(ingress, map[string]interface{}{
+					"protocol": p.Protocol,
+					"ports":    ports,
+					"sources":  toList(sources),
+				})
+			}
+			tags := map[string]string{}
+			for _, t := range g.Tags {
+				tags[t.Key] = t.Value
+			}
+			inv.add(CloudResource{
+				ID:     "arn:aws:ec2:" + region + ":" + inv.Account + ":security-group/" + g.ID,
+				Type:   "aws:ec2:security_group",
+				Name:   g.Name,
+				Region: region,
+				Tags:   tags,
+				Config: map[string]interface{}{
+					"group_id":         g.ID,
+					"vpc_id":           g.VpcID,
+					"description":      g.Description,
+					"default":          g.Name == "default",
+					"ingress":          ingress,
+					"ingress_rules":    len(g.Ingress),
+					"egress_rules":     len(g.Egress),
+					"open_ports":       open,
+					"admin_ports_open": adminExposed(open),
+				},
+			})
+		}
+		if resp.NextToken == "" {
+			return
+		}
+		token = resp.NextToken
+	}
+}
+
+const cloudTrailTarget = "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101."
+
+// collectTrails reads the trails whose home is a region, so that each
+// multi-region trail is read once
+func (c *awsCollector) collectTrails(ctx context.Context, inv *Inventory, region string) []CloudResource {
+	var resp struct {
+		Trails []struct {
+			Name                     string `json:"Name"`
+			TrailARN                 string `json:"TrailARN"`
+			HomeRegion               string `json:"HomeRegion"`
+			S3BucketName             string `json:"S3BucketName"`
+			IsMultiRegionTrail       bool   `json:"IsMultiRegionTrail"`
+			IsOrganizationTrail      bool   `json:"IsOrganizationTrail"`
+			LogFileValidationEnabled bool   `json:"LogFileValidationEnabled"`
+			KmsKeyID                 string `json:"KmsKeyId"`
+			CloudWatchLogsLogGroup   string `json:"CloudWatchLogsLogGroupArn"`
+		} `json:"trailList"`
+	}
+	if err := c.jsonCall(ctx, "cloudtrail", region, cloudTrailTarget+"DescribeTrails", map[string]interface{}{"includeShadowTrails": false}, &resp); err != nil {
+		inv.fail("cloudtrail", region, err)
+		return nil
+	}
+	var trails []CloudResource
+	for _, t := range resp.Trails {
+		var status struct {
+			IsLogging bool `json:"IsLogging"`
+		}
+		if err := c.jsonCall(ctx, "cloudtrail", region, cloudTrailTarget+"GetTrailStatus", map[string]string{"Name": t.TrailARN}, &status); err != nil {
+			inv.fail("cloudtrail", region, fmt.Errorf("trail %s: %w", t.Name, err))
+			continue
+		}
+		r := CloudResource{
+			ID:     t.TrailARN,
+			Type:   "aws:cloudtrail:trail",
+			Name:   t.Name,
+			Region: region,
+			Config: map[string]interface{}{
+				"is_multi_region":     t.IsMultiRegionTrail,
+				"is_logging":          status.IsLogging,
+				"is_organization":     t.IsOrganizationTrail,
+				"log_file_validation": t.LogFileValidationEnabled,
+				"kms_encrypted":       t.KmsKeyID != "",
+				"s3_bucket":           t.S3BucketName,
+				"cloudwatch_logs":     t.CloudWatchLogsLogGroup != "",
+			},
+		}
+		inv.add(r)
+		trails = append(trails, r)
+	}
+	return trails
+}
+
+func awsEnv(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func toList(items []string) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, s := range items {
+		list[i] = s
+	}
+	return list
+}
+
+func contains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// days returns the whole days from t to now
+func days(now, t time.Time) int {
+	if t.IsZero() {
+		return 0
+	}
+	return int(now.Sub(t).Hours() / 24)
+}
+
+// daysSince returns the days since t, or -1 when it never happened
+func daysSince(now, t time.Time) int {
+	if t.IsZero() {
+		return -1
+	}
+	return days(now, t)
+}
+
+func timeOrEmpty(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
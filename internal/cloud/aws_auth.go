@@ -0,0 +1,466 @@
+package cloud
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the keys requests are signed with
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // Zero for long term keys
+	Source          string    // Where the chain found them
+}
+
+// awsCredentialChain finds credentials the way the AWS SDKs do: keys given
+// to cloud_provider_add, the environment, the shared credentials and
+// config files, web identity tokens, the container credentials endpoint
+// and the EC2 instance metadata service. When a role is given it is then
+// assumed with STS. Temporary credentials are fetched again before they
+// expire.
+type awsCredentialChain struct {
+	settings map[string]string
+	client   *apiClient
+	region   string
+	sts      string // STS endpoint
+
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+func (c *awsCredentialChain) get(ctx context.Context) (*awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds != nil && (c.creds.Expires.IsZero() || time.Until(c.creds.Expires) > 5*time.Minute) {
+		return c.creds, nil
+	}
+	creds, err := c.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if role := c.settings["role_arn"]; role != "" {
+		if creds, err = c.assumeRole(ctx, creds, role, c.settings["external_id"]); err != nil {
+			return nil, err
+		}
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+func (c *awsCredentialChain) resolve(ctx context.Context) (*awsCredentials, error) {
+	s := c.settings
+	if s["access_key_id"] != "" || s["secret_access_key"] != "" {
+		if s["access_key_id"] == "" || s["secret_access_key"] == "" {
+			return nil, fmt.Errorf("access_key_id and secret_access_key must be given together")
+		}
+		return &awsCredentials{AccessKeyID: s["access_key_id"], SecretAccessKey: s["secret_access_key"],
+			SessionToken: s["session_token"], Source: "credentials"}, nil
+	}
+	profile := s["profile"]
+	if profile == "" {
+		if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret,
+				SessionToken: os.Getenv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+		}
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	explicit := profile != ""
+	if profile == "" {
+		profile = "default"
+	}
+	if creds, err := c.fromProfile(ctx, profile, 0); creds != nil || err != nil {
+		return creds, err
+	}
+	if explicit {
+		return nil, fmt.Errorf("profile '%s' not found in the shared credentials or config file", profile)
+	}
+	if tokenFile, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+		return c.webIdentity(ctx, tokenFile, role, os.Getenv("AWS_ROLE_SESSION_NAME"))
+	}
+	if creds, err := c.container(ctx); creds != nil || err != nil {
+		return creds, err
+	}
+	if creds, err := c.instanceMetadata(ctx); creds != nil {
+		return creds, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found in the credentials map, environment, shared files, container or instance metadata (%v)", err)
+	}
+	return nil, fmt.Errorf("no AWS credentials found in the credentials map, environment, shared files, container or instance metadata")
+}
+
+// awsFile returns the path of a shared file, ~/.aws/credentials or
+// ~/.aws/config unless the environment names another
+func awsFile(env, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// readINI reads the sections of an AWS shared file. The config file names
+// profiles "profile x" except for the default.
+func readINI(path string, config bool) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		return sections
+	}
+	defer f.Close()
+	var current map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if config {
+				name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			}
+			current = map[string]string{}
+			sections[name] = current
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && current != nil {
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return sections
+}
+
+// fromProfile reads a profile of the shared files, following
+// source_profile to assume role_arn
+func (c *awsCredentialChain) fromProfile(ctx context.Context, profile string, depth int) (*awsCredentials, error) {
+	if depth > 4 {
+		return nil, fmt.Errorf("profile '%s': source_profile chain is too long", profile)
+	}
+	creds := readINI(awsFile("AWS_SHARED_CREDENTIALS_FILE", "credentials"), false)[profile]
+	config := readINI(awsFile("AWS_CONFIG_FILE", "config"), true)[profile]
+	merged := map[string]string{}
+	for k, v := range config {
+		merged[k] = v
+	}
+	for k, v := range creds {
+		merged[k] = v
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	if role := merged["role_arn"]; role != "" {
+		var source *awsCredentials
+		var err error
+		switch {
+		case merged["source_profile"] != "" && merged["source_profile"] != profile:
+			source, err = c.fromProfile(ctx, merged["source_profile"], depth+1)
+		case merged["aws_access_key_id"] != "":
+			source = &awsCredentials{AccessKeyID: merged["aws_access_key_id"], SecretAccessKey: merged["aws_secret_access_key"],
+				SessionToken: merged["aws_session_token"]}
+		case merged["web_identity_token_file"] != "":
+			return c.webIdentity(ctx, merged["web_identity_token_file"], role, merged["role_session_name"])
+		case merged["credential_source"] == "Ec2InstanceMetadata":
+			source, err = c.instanceMetadata(ctx)
+		case merged["credential_source"] == "EcsContainer":
+			source, err = c.container(ctx)
+		case merged["credential_source"] == "Environment":
+			source = &awsCredentials{AccessKeyID: os.Getenv("AWS_ACCESS_KEY_ID"), SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if source == nil || source.AccessKeyID == "" {
+			return nil, fmt.Errorf("profile '%s': no credentials to assume %s with", profile, role)
+		}
+		return c.assumeRole(ctx, source, role, merged["external_id"])
+	}
+	if merged["aws_access_key_id"] == "" {
+		if merged["sso_start_url"] != "" || merged["sso_session"] != "" {
+			return nil, fmt.Errorf("profile '%s' uses IAM Identity Center; export the credentials of an aws sso login session instead", profile)
+		}
+		if merged["credential_process"] != "" {
+			return nil, fmt.Errorf("profile '%s': credential_process is not supported", profile)
+		}
+		return nil, nil
+	}
+	return &awsCredentials{AccessKeyID: merged["aws_access_key_id"], SecretAccessKey: merged["aws_secret_access_key"],
+		SessionToken: merged["aws_session_token"], Source: "profile " + profile}, nil
+}
+
+// profileRegion returns the region of a profile in the config file
+func profileRegion(profile string) string {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	return readINI(awsFile("AWS_CONFIG_FILE", "config"), true)[profile]["region"]
+}
+
+// stsCredentials is the credentials element of STS responses
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+func (c *awsCredentialChain) assumeRole(ctx context.Context, source *awsCredentials, role, externalID string) (*awsCredentials, error) {
+	params := url.Values{"RoleArn": {role}, "RoleSessionName": {"sentra-" + time.Now().Format("20060102T150405")}}
+	if externalID != "" {
+		params.Set("ExternalId", externalID)
+	}
+	var resp struct {
+		Credentials stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	}
+	signer := func(req *http.Request, body []byte) error {
+		signV4(req, body, source, c.region, "sts", time.Now())
+		return nil
+	}
+	if err := queryCall(ctx, c.client, c.sts, "AssumeRole", "2011-06-15", params, signer, &resp); err != nil {
+		return nil, fmt.Errorf("assume role %s: %w", role, err)
+	}
+	return stsToCredentials(resp.Credentials, "role "+role), nil
+}
+
+func (c *awsCredentialChain) webIdentity(ctx context.Context, tokenFile, role, session string) (*awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("web identity token: %v", err)
+	}
+	if session == "" {
+		session = "sentra-" + time.Now().Format("20060102T150405")
+	}
+	params := url.Values{"RoleArn": {role}, "RoleSessionName": {session}, "WebIdentityToken": {strings.TrimSpace(string(token))}}
+	var resp struct {
+		Credentials stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	// The token is the authentication, the request is not signed
+	if err := queryCall(ctx, c.client, c.sts, "AssumeRoleWithWebIdentity", "2011-06-15", params, nil, &resp); err != nil {
+		return nil, fmt.Errorf("assume role %s with web identity: %w", role, err)
+	}
+	return stsToCredentials(resp.Credentials, "web identity "+role), nil
+}
+
+func stsToCredentials(s stsCredentials, source string) *awsCredentials {
+	return &awsCredentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey,
+		SessionToken: s.SessionToken, Expires: s.Expiration, Source: source}
+}
+
+// metadataCredentials is the credentials document of the container and
+// instance metadata endpoints
+type metadataCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// container reads the credentials of an ECS task or EKS pod identity
+func (c *awsCredentialChain) container(ctx context.Context) (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		endpoint = "http://169.254.170.2" + rel
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	headers := map[string]string{}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("container credentials token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		headers["Authorization"] = token
+	}
+	body, err := c.client.do(ctx, request{url: endpoint, headers: headers})
+	if err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	var m metadataCredentials
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("container credentials: %v", err)
+	}
+	return &awsCredentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey,
+		SessionToken: m.Token, Expires: m.Expiration, Source: "container"}, nil
+}
+
+// instanceMetadata reads the credentials of the instance profile of an
+// EC2 instance with IMDSv2
+func (c *awsCredentialChain) instanceMetadata(ctx context.Context) (*awsCredentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	base := strings.TrimSuffix(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+	// Off EC2 the address does not answer, which should not hold up a scan
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	imds := &apiClient{http: &http.Client{Timeout: 2 * time.Second}, limit: &limiter{}}
+	token, err := imds.do(ctx, request{method: http.MethodPut, url: base + "/latest/api/token",
+		headers: map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21600"}})
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+	role, err := imds.do(ctx, request{url: base + "/latest/meta-data/iam/security-credentials/", headers: headers})
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	if name == "" {
+		return nil, fmt.Errorf("the instance has no instance profile")
+	}
+	body, err := imds.do(ctx, request{url: base + "/latest/meta-data/iam/security-credentials/" + name, headers: headers})
+	if err != nil {
+		return nil, err
+	}
+	var m metadataCredentials
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("instance metadata credentials: %v", err)
+	}
+	return &awsCredentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey,
+		SessionToken: m.Token, Expires: m.Expiration, Source: "instance profile " + name}, nil
+}
+
+// queryCall calls an action of an API with the query protocol, as IAM,
+// STS and EC2 use, and decodes the XML response into out
+func queryCall(ctx context.Context, client *apiClient, endpoint, action, version string, params url.Values, sign func(*http.Request, []byte) error, out interface{}) error {
+	form := url.Values{"Action": {action}, "Version": {version}}
+	for k, v := range params {
+		form[k] = v
+	}
+	body, err := client.do(ctx, request{
+		method:  http.MethodPost,
+		url:     endpoint,
+		body:    []byte(form.Encode()),
+		headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+		sign:    sign,
+	})
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
+
+// signV4 signs a request with AWS Signature Version 4
+func signV4(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+	host := req.URL.Host
+	if req.Host != "" {
+		host = req.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method, path, canonicalQuery(req.URL.Query()), canonicalHeaders.String(), signed, payload,
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, signature))
+}
+
+// canonicalQuery encodes a query as SigV4 wants: sorted, with spaces as
+// %20 and ~ unescaped
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(s), "+", "%20"), "%7E", "~")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
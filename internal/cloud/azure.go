@@ -0,0 +1,342 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureServices are the services the Azure collector reads
+var azureServices = []string{"storage", "network"}
+
+// azureCollector reads the storage accounts and network security groups
+// of a subscription, or of every subscription the identity can read, with
+// the Resource Manager API
+type azureCollector struct {
+	client       *apiClient
+	settings     map[string]string
+	endpoint     string // Resource Manager
+	authority    string // Microsoft Entra ID
+	subscription string
+	services     map[string]bool
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newAzureCollector(settings map[string]string, rate float64, timeout time.Duration) (*azureCollector, error) {
+	services, err := serviceSet(settings["services"], azureServices...)
+	if err != nil {
+		return nil, fmt.Errorf("Azure: %v", err)
+	}
+	for _, key := range []string{"tenant_id", "client_id", "client_secret", "subscription_id"} {
+		if settings[key] == "" {
+			settings[key] = os.Getenv("AZURE_" + strings.ToUpper(key))
+		}
+	}
+	return &azureCollector{
+		client:       newAPIClient(rate, timeout, jsonError),
+		settings:     settings,
+		endpoint:     strings.TrimSuffix(firstNonEmpty(settings["endpoint"], "https://management.azure.com"), "/"),
+		authority:    strings.TrimSuffix(firstNonEmpty(settings["authority"], "https://login.microsoftonline.com"), "/"),
+		subscription: settings["subscription_id"],
+		services:     services,
+	}, nil
+}
+
+// accessToken returns a Resource Manager token for the service principal
+// of tenant_id, client_id and client_secret, or else for the managed
+// identity of the VM or App Service the script runs on
+func (c *azureCollector) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expires) > 5*time.Minute {
+		return c.token, nil
+	}
+	var resp struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	var body []byte
+	var err error
+	s := c.settings
+	switch {
+	case s["client_id"] != "" && s["client_secret"] != "" && s["tenant_id"] != "":
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {s["client_id"]},
+			"client_secret": {s["client_secret"]},
+			"scope":         {c.endpoint + "/.default"},
+		}
+		body, err = c.client.do(ctx, request{
+			method:  http.MethodPost,
+			url:     c.authority + "/" + url.PathEscape(s["tenant_id"]) + "/oauth2/v2.0/token",
+			body:    []byte(form.Encode()),
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+		})
+	case os.Getenv("IDENTITY_ENDPOINT") != "" && os.Getenv("IDENTITY_HEADER") != "":
+		// App Service and Functions
+		q := url.Values{"resource": {c.endpoint + "/"}, "api-version": {"2019-08-01"}}
+		if s["client_id"] != "" {
+			q.Set("client_id", s["client_id"])
+		}
+		body, err = c.client.do(ctx, request{
+			url:     os.Getenv("IDENTITY_ENDPOINT") + "?" + q.Encode(),
+			headers: map[string]string{"X-IDENTITY-HEADER": os.Getenv("IDENTITY_HEADER")},
+		})
+	default:
+		q := url.Values{"resource": {c.endpoint + "/"}, "api-version": {"2018-02-01"}}
+		if s["client_id"] != "" {
+			q.Set("client_id", s["client_id"])
+		}
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		imds := &apiClient{http: &http.Client{Timeout: 2 * time.Second}, limit: &limiter{}, parseError: jsonError}
+		body, err = imds.do(ctx, request{
+			url:     "http://169.254.169.254/metadata/identity/oauth2/token?" + q.Encode(),
+			headers: map[string]string{"Metadata": "true"},
+		})
+		if err != nil {
+			return "", fmt.Errorf("no Azure credentials: give tenant_id, client_id and client_secret, or run with a managed identity (%v)", err)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Azure token: %w", err)
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.AccessToken == "" {
+		return "", fmt.Errorf("Azure token: invalid response")
+	}
+	seconds, _ := resp.ExpiresIn.Int64()
+	if seconds == 0 {
+		seconds = 3600
+	}
+	c.token, c.expires = resp.AccessToken, time.Now().Add(time.Duration(seconds)*time.Second)
+	return c.token, nil
+}
+
+func (c *azureCollector) sign(req *http.Request, body []byte) error {
+	token, err := c.accessToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// list reads every page of a Resource Manager list, following nextLink
+func (c *azureCollector) list(ctx context.Context, path, apiVersion string, each func(json.RawMessage) error) error {
+	next := c.endpoint + path + "?api-version=" + apiVersion
+	for next != "" {
+		body, err := c.client.do(ctx, request{url: next, sign: c.sign})
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+		for _, item := range page.Value {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+		next = page.NextLink
+	}
+	return nil
+}
+
+func (c *azureCollector) collect(ctx context.Context) (*Inventory, error) {
+	if _, err := c.accessToken(ctx); err != nil {
+		return nil, err
+	}
+	subscriptions := splitList(c.subscription)
+	if len(subscriptions) == 0 {
+		err := c.list(ctx, "/subscriptions", "2022-12-01", func(raw json.RawMessage) error {
+			var sub struct {
+				ID    string `json:"subscriptionId"`
+				State string `json:"state"`
+			}
+			if json.Unmarshal(raw, &sub) == nil && sub.State != "Disabled" {
+				subscriptions = append(subscriptions, sub.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Azure: list subscriptions: %w", err)
+		}
+		if len(subscriptions) == 0 {
+			return nil, fmt.Errorf("Azure: the identity cannot read any subscription")
+		}
+	}
+	inv := &Inventory{Account: strings.Join(subscriptions, ",")}
+	locations := map[string]bool{}
+	forEach(subscriptions, 4, func(sub string) {
+		if c.services["storage"] {
+			c.collectStorage(ctx, inv, sub)
+		}
+		if c.services["network"] {
+			c.collectNSGs(ctx, inv, sub)
+		}
+	})
+	for _, r := range inv.Resources {
+		locations[r.Region] = true
+	}
+	for loc := range locations {
+		inv.Regions = append(inv.Regions, loc)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	inv.sort()
+	return inv, nil
+}
+
+func (c *azureCollector) collectStorage(ctx context.Context, inv *Inventory, sub string) {
+	path := "/subscriptions/" + sub + "/providers/Microsoft.Storage/storageAccounts"
+	err := c.list(ctx, path, "2023-01-01", func(raw json.RawMessage) error {
+		var account struct {
+			ID         string            `json:"id"`
+			Name       string            `json:"name"`
+			Location   string            `json:"location"`
+			Tags       map[string]string `json:"tags"`
+			Properties struct {
+				HTTPSOnly      *bool  `json:"supportsHttpsTrafficOnly"`
+				AllowPublic    *bool  `json:"allowBlobPublicAccess"`
+				MinimumTLS     string `json:"minimumTlsVersion"`
+				PublicNetwork  string `json:"publicNetworkAccess"`
+				AllowSharedKey *bool  `json:"allowSharedKeyAccess"`
+				Encryption     struct {
+					KeySource string `json:"keySource"`
+					Services  struct {
+						Blob struct {
+							Enabled bool `json:"enabled"`
+						} `json:"blob"`
+					} `json:"services"`
+				} `json:"encryption"`
+				NetworkACLs struct {
+					DefaultAction string `json:"defaultAction"`
+				} `json:"networkAcls"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(raw, &account); err != nil {
+			return fmt.Errorf("invalid storage account: %v", err)
+		}
+		p := account.Properties
+		inv.add(CloudResource{
+			ID:     account.ID,
+			Type:   "azure:storage:account",
+			Name:   account.Name,
+			Region: account.Location,
+			Tags:   account.Tags,
+			Config: map[string]interface{}{
+				"subscription":       sub,
+				"encryption_enabled": p.Encryption.Services.Blob.Enabled,
+				"key_source":         p.Encryption.KeySource,
+				// Both default to true when the API leaves them out
+				"https_only":               p.HTTPSOnly == nil || *p.HTTPSOnly,
+				"allow_blob_public_access": p.AllowPublic != nil && *p.AllowPublic,
+				"allow_shared_key_access":  p.AllowSharedKey == nil || *p.AllowSharedKey,
+				"minimum_tls_version":      p.MinimumTLS,
+				"public_network_access":    firstNonEmpty(p.PublicNetwork, "Enabled"),
+				"network_default_action":   firstNonEmpty(p.NetworkACLs.DefaultAction, "Allow"),
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		inv.fail("storage", sub, err)
+	}
+}
+
+// azureRule is a security rule of a network security group
+type azureRule struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Direction        string   `json:"direction"`
+		Access           string   `json:"access"`
+		Protocol         string   `json:"protocol"`
+		Priority         int      `json:"priority"`
+		Source           string   `json:"sourceAddressPrefix"`
+		Sources          []string `json:"sourceAddressPrefixes"`
+		DestinationPort  string   `json:"destinationPortRange"`
+		DestinationPorts []string `json:"destinationPortRanges"`
+	} `json:"properties"`
+}
+
+func (c *azureCollector) collectNSGs(ctx context.Context, inv *Inventory, sub string) {
+	path := "/subscriptions/" + sub + "/providers/Microsoft.Network/networkSecurityGroups"
+	err := c.list(ctx, path, "2023-09-01", func(raw json.RawMessage) error {
+		var nsg struct {
+			ID         string            `json:"id"`
+			Name       string            `json:"name"`
+			Location   string            `json:"location"`
+			Tags       map[string]string `json:"tags"`
+			Properties struct {
+				Rules []azureRule `json:"securityRules"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(raw, &nsg); err != nil {
+			return fmt.Errorf("invalid network security group: %v", err)
+		}
+		inbound := []interface{}{}
+		open := []interface{}{}
+		for _, rule := range nsg.Properties.Rules {
+			p := rule.Properties
+			if !strings.EqualFold(p.Direction, "Inbound") || !strings.EqualFold(p.Access, "Allow") {
+				continue
+			}
+			sources := append([]string{}, p.Sources...)
+			if p.Source != "" {
+				sources = append(sources, p.Source)
+			}
+			ports := append([]string{}, p.DestinationPorts...)
+			if p.DestinationPort != "" {
+				ports = append(ports, p.DestinationPort)
+			}
+			for _, src := range sources {
+				for _, port := range ports {
+					if port == "*" {
+						port = "all"
+					}
+					inbound = append(inbound, map[string]interface{}{
+						"name":     rule.Name,
+						"source":   src,
+						"port":     port,
+						"protocol": p.Protocol,
+						"priority": p.Priority,
+					})
+					if openToWorld(src) {
+						open = append(open, port)
+					}
+				}
+			}
+		}
+		inv.add(CloudResource{
+			ID:     nsg.ID,
+			Type:   "azure:network:nsg",
+			Name:   nsg.Name,
+			Region: nsg.Location,
+			Tags:   nsg.Tags,
+			Config: map[string]interface{}{
+				"subscription":     sub,
+				"inbound_rules":    inbound,
+				"open_ports":       open,
+				"admin_ports_open": adminExposed(open),
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		inv.fail("network", sub, err)
+	}
+}
@@ -0,0 +1,68 @@
+// internal/cloud/benchmark.go
+package cloud
+
+import (
+	"fmt"
+	"strings"
+
+	"sentra/internal/benchmark"
+)
+
+// Benchmarks returns the benchmark engine of the module, which has the
+// built-in CIS benchmarks and those loaded or defined by scripts
+func (c *CSPMModule) Benchmarks() *benchmark.Engine {
+	return c.benchmarks
+}
+
+// BenchmarkTarget returns the resources of the last scan of a provider as
+// a benchmark target whose resource source the cloud benchmarks query
+func (c *CSPMModule) BenchmarkTarget(providerName string) (benchmark.Target, error) {
+	c.mu.Lock()
+	provider, exists := c.providers[providerName]
+	var items []benchmark.Item
+	var providerType string
+	scanned := exists && !provider.LastScanned.IsZero()
+	if scanned {
+		providerType = provider.Type
+		for _, r := range provider.Resources {
+			items = append(items, ResourceToMap(r))
+		}
+	}
+	c.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("provider %s not found", providerName)
+	}
+	if !scanned {
+		return nil, fmt.Errorf("provider %s has not been scanned", providerName)
+	}
+	return benchmark.NewDataTarget(providerName, providerType, map[string][]benchmark.Item{"resource": items}), nil
+}
+
+// RunBenchmark runs a benchmark against the resources of a provider. The
+// name is a benchmark ID, or cis for the CIS foundations benchmark of the
+// provider's type. A provider that has not been scanned yet is scanned
+// first.
+func (c *CSPMModule) RunBenchmark(providerName, name string, opts benchmark.Options) (*benchmark.Report, error) {
+	c.mu.Lock()
+	provider, exists := c.providers[providerName]
+	scanned := exists && !provider.LastScanned.IsZero()
+	c.mu.Unlock()
+	if exists && !scanned {
+		if _, err := c.ScanProvider(providerName); err != nil {
+			return nil, err
+		}
+	}
+	target, err := c.BenchmarkTarget(providerName)
+	if err != nil {
+		return nil, err
+	}
+	id := name
+	switch strings.ToLower(name) {
+	case "", "cis", "cis-foundations":
+		id = "cis-" + target.Platform() + "-foundations"
+		if _, ok := c.benchmarks.Get(id); !ok {
+			return nil, fmt.Errorf("no CIS benchmark for %s", target.Platform())
+		}
+	}
+	return c.benchmarks.Run(id, target, opts)
+}
@@ -0,0 +1,315 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiError is an error a cloud API returned
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// isCode reports whether err is an API error with one of the codes
+func isCode(err error, codes ...string) bool {
+	var e *apiError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// throttleCodes are the error codes APIs return when a caller is over its
+// request rate, retried like HTTP 429
+var throttleCodes = map[string]bool{
+	"Throttling": true, "ThrottlingException": true, "ThrottledException": true,
+	"RequestLimitExceeded": true, "TooManyRequestsException": true, "SlowDown": true,
+	"RequestThrottled": true, "RequestThrottledException": true, "rateLimitExceeded": true,
+	"userRateLimitExceeded": true, "TooManyRequests": true,
+}
+
+// limiter spaces requests out to a rate shared by the collectors of an
+// account
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(perSecond float64) *limiter {
+	if perSecond <= 0 {
+		return &limiter{}
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next request may be sent
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	if d := time.Until(at); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// apiClient sends the requests of a collector, within its rate and with
+// retries when the API is throttling or unavailable
+type apiClient struct {
+	http    *http.Client
+	limit   *limiter
+	retries int
+	// parseError reads the error of a failed response in the provider's
+	// format
+	parseError func(status int, body []byte) *apiError
+}
+
+func newAPIClient(rate float64, timeout time.Duration, parseError func(int, []byte) *apiError) *apiClient {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConnsPerHost:   8,
+	}
+	return &apiClient{
+		http:       &http.Client{Transport: transport, Timeout: 2 * timeout},
+		limit:      newLimiter(rate),
+		retries:    5,
+		parseError: parseError,
+	}
+}
+
+// request is a request to send, built again for each attempt so that it
+// is signed with a fresh date
+type request struct {
+	method  string
+	url     string
+	body    []byte
+	headers map[string]string
+	// sign adds authentication to the request
+	sign func(req *http.Request, body []byte) error
+}
+
+// do sends a request and returns the body of a 2xx response. Throttling,
+// 5xx responses and network errors are retried with exponential backoff.
+func (c *apiClient) do(ctx context.Context, r request) ([]byte, error) {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if err := c.limit.wait(ctx); err != nil {
+			return nil, err
+		}
+		body, retryAfter, err := c.send(ctx, r)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= c.retries || !retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		if backoff < 20*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *apiClient) send(ctx context.Context, r request) ([]byte, time.Duration, error) {
+	method := r.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.body == nil {
+		req.Body, req.ContentLength = nil, 0
+	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	if r.sign != nil {
+		if err := r.sign(req, r.body); err != nil {
+			return nil, 0, err
+		}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return body, 0, nil
+	}
+	var retryAfter time.Duration
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		retryAfter = time.Duration(s) * time.Second
+	}
+	var apiErr *apiError
+	if c.parseError != nil {
+		apiErr = c.parseError(resp.StatusCode, body)
+	}
+	if apiErr == nil {
+		apiErr = &apiError{Message: strings.TrimSpace(string(body))}
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, retryAfter, apiErr
+}
+
+// retryable reports whether a request that failed with err may succeed
+// if sent again
+func retryable(err error) bool {
+	var e *apiError
+	if !errors.As(err, &e) {
+		// Connection errors; a cancelled context is checked by the caller
+		return true
+	}
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500 || throttleCodes[e.Code]
+}
+
+// xmlError reads the code and message of an XML error response. AWS
+// nests them differently per protocol, so the first Code and Message
+// elements are taken wherever they are.
+func xmlError(status int, body []byte) *apiError {
+	e := &apiError{}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	var field *string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			field = nil
+			switch t.Name.Local {
+			case "Code":
+				if e.Code == "" {
+					field = &e.Code
+				}
+			case "Message":
+				if e.Message == "" {
+					field = &e.Message
+				}
+			}
+		case xml.CharData:
+			if field != nil {
+				*field += string(t)
+			}
+		case xml.EndElement:
+			field = nil
+		}
+	}
+	if e.Code == "" && e.Message == "" {
+		return nil
+	}
+	e.Code, e.Message = strings.TrimSpace(e.Code), strings.TrimSpace(e.Message)
+	return e
+}
+
+// jsonError reads the error of a JSON response: AWS's __type and message,
+// or the error object of Azure and Google APIs
+func jsonError(status int, body []byte) *apiError {
+	var doc struct {
+		Type        string          `json:"__type"`
+		Code        string          `json:"code"`
+		Message     string          `json:"message"`
+		MessageCap  string          `json:"Message"`
+		Error       json.RawMessage `json:"error"`
+		Description string          `json:"error_description"`
+	}
+	if json.Unmarshal(body, &doc) != nil {
+		return nil
+	}
+	e := &apiError{Code: doc.Code, Message: doc.Message}
+	if doc.Type != "" {
+		// com.amazonaws.cloudtrail#TrailNotFoundException
+		e.Code = doc.Type[strings.LastIndex(doc.Type, "#")+1:]
+	}
+	if e.Message == "" {
+		e.Message = doc.MessageCap
+	}
+	if len(doc.Error) > 0 {
+		var s string
+		var obj struct {
+			Code    interface{} `json:"code"`
+			Message string      `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		}
+		if json.Unmarshal(doc.Error, &s) == nil {
+			// OAuth token endpoints
+			e.Code, e.Message = s, doc.Description
+		} else if json.Unmarshal(doc.Error, &obj) == nil {
+			e.Code, e.Message = fmt.Sprint(obj.Code), obj.Message
+			if len(obj.Errors) > 0 && obj.Errors[0].Reason != "" {
+				e.Code = obj.Errors[0].Reason
+			}
+		}
+	}
+	if e.Code == "" && e.Message == "" {
+		return nil
+	}
+	return e
+}
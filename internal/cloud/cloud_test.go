@@ -0,0 +1,535 @@
+package cloud
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sentra/internal/benchmark"
+)
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+}
+
+func TestNewCollectorCredentials(t *testing.T) {
+	if _, err := newCollector("Oracle", nil); err == nil || !strings.Contains(err.Error(), "unknown provider type") {
+		t.Errorf("unknown type: %v", err)
+	}
+	if _, err := newCollector("AWS", map[string]string{"access_kye": "x"}); err == nil || !strings.Contains(err.Error(), "unknown credential 'access_kye'") {
+		t.Errorf("unknown key: %v", err)
+	}
+	if _, err := newCollector("aws", map[string]string{"services": "iam,lambda"}); err == nil || !strings.Contains(err.Error(), "unknown service 'lambda'") {
+		t.Errorf("unknown service: %v", err)
+	}
+	if _, err := newCollector("GCP", map[string]string{"credentials_json": `{"type":"service_account"}`}); err == nil {
+		t.Error("service account key without a private key was accepted")
+	}
+	if _, err := newCollector("AWS", map[string]string{"access_key": "AKID", "secret_key": "secret", "rate_limit": "5"}); err != nil {
+		t.Errorf("old credential names: %v", err)
+	}
+}
+
+// credentialScope returns the region and service a request was signed for
+func credentialScope(r *http.Request) (region, service string) {
+	auth := r.Header.Get("Authorization")
+	i := strings.Index(auth, "Credential=")
+	if i < 0 {
+		return "", ""
+	}
+	parts := strings.Split(strings.SplitN(auth[i+len("Credential="):], ",", 2)[0], "/")
+	if len(parts) < 4 {
+		return "", ""
+	}
+	return parts[2], parts[3]
+}
+
+func xmlStatus(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<ErrorResponse><Error><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>", code, code)
+}
+
+// fakeAWS answers the STS, IAM, EC2, CloudTrail and S3 calls of a scan
+// for an account with problems in each service. ListUsers is throttled
+// once, and users, buckets and security groups come in two pages.
+func fakeAWS(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	throttled := false
+	recent := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-200 * 24 * time.Hour).UTC().Format(time.RFC3339)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDTEST/") {
+			xmlStatus(w, 403, "InvalidClientTokenId")
+			return
+		}
+		region, service := credentialScope(r)
+
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			switch {
+			case strings.HasSuffix(target, "DescribeTrails") && region == "us-east-1":
+				fmt.Fprint(w, `{"trailList":[{"Name":"main","TrailARN":"arn:aws:cloudtrail:us-east-1:123456789012:trail/main",
+					"HomeRegion":"us-east-1","IsMultiRegionTrail":true,"LogFileValidationEnabled":false,"S3BucketName":"logs"}]}`)
+			case strings.HasSuffix(target, "DescribeTrails"):
+				fmt.Fprint(w, `{"trailList":[]}`)
+			case strings.HasSuffix(target, "GetTrailStatus"):
+				fmt.Fprint(w, `{"IsLogging":true}`)
+			default:
+				w.WriteHeader(400)
+				fmt.Fprint(w, `{"__type":"com.amazonaws.cloudtrail#UnknownOperationException","message":"unknown"}`)
+			}
+			return
+		}
+
+		if service == "s3" {
+			bucket := strings.Trim(r.URL.Path, "/")
+			q := r.URL.Query()
+			switch {
+			case r.URL.Path == "/v20180820/configuration/publicAccessBlock":
+				if r.Header.Get("X-Amz-Account-Id") != "123456789012" {
+					xmlStatus(w, 400, "InvalidRequest")
+					return
+				}
+				xmlStatus(w, 404, "NoSuchPublicAccessBlockConfiguration")
+			case bucket == "" && q.Get("continuation-token") == "":
+				fmt.Fprint(w, `<ListAllMyBucketsResult><Buckets><Bucket><Name>logs</Name><CreationDate>2023-01-01T00:00:00Z</CreationDate>
+					<BucketRegion>us-east-1</BucketRegion></Bucket></Buckets><ContinuationToken>c2</ContinuationToken></ListAllMyBucketsResult>`)
+			case bucket == "":
+				fmt.Fprint(w, `<ListAllMyBucketsResult><Buckets><Bucket><Name>public-site</Name><CreationDate>2023-01-01T00:00:00Z</CreationDate>
+					<BucketRegion>eu-west-1</BucketRegion></Bucket></Buckets></ListAllMyBucketsResult>`)
+			case q.Has("publicAccessBlock") && bucket == "logs":
+				fmt.Fprint(w, `<PublicAccessBlockConfiguration><BlockPublicAcls>true</BlockPublicAcls><IgnorePublicAcls>true</IgnorePublicAcls>
+					<BlockPublicPolicy>true</BlockPublicPolicy><RestrictPublicBuckets>true</RestrictPublicBuckets></PublicAccessBlockConfiguration>`)
+			case q.Has("publicAccessBlock"):
+				xmlStatus(w, 404, "NoSuchPublicAccessBlockConfiguration")
+			case q.Has("policyStatus") && bucket == "public-site":
+				fmt.Fprint(w, `<PolicyStatus><IsPublic>true</IsPublic></PolicyStatus>`)
+			case q.Has("policyStatus"):
+				xmlStatus(w, 404, "NoSuchBucketPolicy")
+			case q.Has("acl") && bucket == "public-site":
+				fmt.Fprint(w, `<AccessControlPolicy><AccessControlList><Grant><Grantee><URI>http://acs.amazonaws.com/groups/global/AllUsers</URI></Grantee>
+					<Permission>READ</Permission></Grant></AccessControlList></AccessControlPolicy>`)
+			case q.Has("acl"):
+				fmt.Fprint(w, `<AccessControlPolicy><AccessControlList></AccessControlList></AccessControlPolicy>`)
+			case q.Has("encryption"):
+				fmt.Fprint(w, `<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm>
+					</ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`)
+			case q.Has("logging") && bucket == "public-site":
+				fmt.Fprint(w, `<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket></LoggingEnabled></BucketLoggingStatus>`)
+			case q.Has("logging"):
+				fmt.Fprint(w, `<BucketLoggingStatus></BucketLoggingStatus>`)
+			case q.Has("versioning"):
+				fmt.Fprint(w, `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`)
+			default:
+				xmlStatus(w, 400, "InvalidRequest")
+			}
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		user := form.Get("UserName")
+		switch form.Get("Action") {
+		case "GetCallerIdentity":
+			fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Account>123456789012</Account>
+				<Arn>arn:aws:iam::123456789012:user/audit</Arn></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+		case "GetAccountSummary":
+			fmt.Fprint(w, `<GetAccountSummaryResponse><GetAccountSummaryResult><SummaryMap>
+				<entry><key>AccountMFAEnabled</key><value>0</value></entry>
+				<entry><key>AccountAccessKeysPresent</key><value>1</value></entry>
+				<entry><key>Users</key><value>2</value></entry>
+				</SummaryMap></GetAccountSummaryResult></GetAccountSummaryResponse>`)
+		case "GetAccountPasswordPolicy":
+			xmlStatus(w, 404, "NoSuchEntity")
+		case "ListUsers":
+			mu.Lock()
+			first := !throttled
+			throttled = true
+			mu.Unlock()
+			if first {
+				xmlStatus(w, 400, "Throttling")
+				return
+			}
+			if form.Get("Marker") == "" {
+				fmt.Fprintf(w, `<ListUsersResponse><ListUsersResult><Users><member><UserName>alice</UserName>
+					<Arn>arn:aws:iam::123456789012:user/alice</Arn><CreateDate>%s</CreateDate><PasswordLastUsed>%s</PasswordLastUsed></member></Users>
+					<IsTruncated>true</IsTruncated><Marker>m2</Marker></ListUsersResult></ListUsersResponse>`, old, recent)
+				return
+			}
+			fmt.Fprintf(w, `<ListUsersResponse><ListUsersResult><Users><member><UserName>bob</UserName>
+				<Arn>arn:aws:iam::123456789012:user/bob</Arn><CreateDate>%s</CreateDate></member></Users>
+				<IsTruncated>false</IsTruncated></ListUsersResult></ListUsersResponse>`, old)
+		case "ListMFADevices":
+			devices := ""
+			if user == "bob" {
+				devices = "<member><SerialNumber>arn:aws:iam::123456789012:mfa/bob</SerialNumber></member>"
+			}
+			fmt.Fprintf(w, `<ListMFADevicesResponse><ListMFADevicesResult><MFADevices>%s</MFADevices></ListMFADevicesResult></ListMFADevicesResponse>`, devices)
+		case "GetLoginProfile":
+			if user == "bob" {
+				xmlStatus(w, 404, "NoSuchEntity")
+				return
+			}
+			fmt.Fprint(w, `<GetLoginProfileResponse><GetLoginProfileResult><LoginProfile><UserName>alice</UserName></LoginProfile></GetLoginProfileResult></GetLoginProfileResponse>`)
+		case "ListAccessKeys":
+			keys := ""
+			if user == "bob" {
+				keys = fmt.Sprintf("<member><AccessKeyId>AKIABOB</AccessKeyId><Status>Active</Status><CreateDate>%s</CreateDate></member>", old)
+			}
+			fmt.Fprintf(w, `<ListAccessKeysResponse><ListAccessKeysResult><AccessKeyMetadata>%s</AccessKeyMetadata></ListAccessKeysResult></ListAccessKeysResponse>`, keys)
+		case "GetAccessKeyLastUsed":
+			fmt.Fprintf(w, `<GetAccessKeyLastUsedResponse><GetAccessKeyLastUsedResult><AccessKeyLastUsed><LastUsedDate>%s</LastUsedDate>
+				</AccessKeyLastUsed></GetAccessKeyLastUsedResult></GetAccessKeyLastUsedResponse>`, recent)
+		case "ListAttachedUserPolicies":
+			policies := ""
+			if user == "alice" {
+				policies = "<member><PolicyName>AdministratorAccess</PolicyName></member>"
+			}
+			fmt.Fprintf(w, `<ListAttachedUserPoliciesResponse><ListAttachedUserPoliciesResult><AttachedPolicies>%s</AttachedPolicies>
+				</ListAttachedUserPoliciesResult></ListAttachedUserPoliciesResponse>`, policies)
+		case "ListUserPolicies":
+			fmt.Fprint(w, `<ListUserPoliciesResponse><ListUserPoliciesResult><PolicyNames></PolicyNames></ListUserPoliciesResult></ListUserPoliciesResponse>`)
+		case "DescribeSecurityGroups":
+			switch {
+			case region == "us-east-1" && form.Get("NextToken") == "":
+				fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>sg-1</groupId><groupName>bastion</groupName>
+					<vpcId>vpc-1</vpcId><ipPermissions><item><ipProtocol>tcp</ipProtocol><fromPort>22</fromPort><toPort>22</toPort>
+					<ipRanges><item><cidrIp>0.0.0.0/0</cidrIp></item></ipRanges></item></ipPermissions></item></securityGroupInfo>
+					<nextToken>t2</nextToken></DescribeSecurityGroupsResponse>`)
+			case region == "us-east-1":
+				fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>sg-2</groupId><groupName>default</groupName>
+					<vpcId>vpc-1</vpcId></item></securityGroupInfo></DescribeSecurityGroupsResponse>`)
+			default:
+				fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>sg-3</groupId><groupName>web</groupName>
+					<vpcId>vpc-2</vpcId><ipPermissions><item><ipProtocol>tcp</ipProtocol><fromPort>443</fromPort><toPort>443</toPort>
+					<ipRanges><item><cidrIp>0.0.0.0/0</cidrIp></item></ipRanges></item></ipPermissions></item></securityGroupInfo>
+					</DescribeSecurityGroupsResponse>`)
+			}
+		default:
+			xmlStatus(w, 400, "InvalidAction")
+		}
+	}))
+}
+
+// failedRules returns the sorted rule IDs of the findings of a report
+func failedRules(report *ComplianceReport) []string {
+	var rules []string
+	for _, pr := range report.PolicyResults {
+		for _, f := range pr.Findings {
+			rules = append(rules, f.RuleID+" "+f.ResourceID)
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func countTypes(resources []CloudResource) map[string]int {
+	counts := map[string]int{}
+	for _, r := range resources {
+		counts[r.Type]++
+	}
+	return counts
+}
+
+func TestScanAWS(t *testing.T) {
+	srv := fakeAWS(t)
+	defer srv.Close()
+
+	cspm := NewCSPMModule()
+	err := cspm.AddProvider("prod", "AWS", map[string]string{
+		"access_key_id": "AKIDTEST", "secret_access_key": "secret", "region": "us-east-1",
+		"regions": "us-east-1, eu-west-1", "endpoint": srv.URL, "rate_limit": "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := cspm.ScanProvider("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Account != "123456789012" || !reflect.DeepEqual(report.Regions, []string{"eu-west-1", "us-east-1"}) {
+		t.Errorf("account %s, regions %v", report.Account, report.Regions)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors: %v", report.Errors)
+	}
+
+	resources, _ := cspm.Resources("prod")
+	wantTypes := map[string]int{
+		"aws:iam:root": 1, "aws:iam:password_policy": 1, "aws:iam:user": 2, "aws:s3:account": 1, "aws:s3:bucket": 2,
+		"aws:ec2:security_group": 3, "aws:cloudtrail:trail": 1, "aws:cloudtrail:account": 1,
+	}
+	if got := countTypes(resources); !reflect.DeepEqual(got, wantTypes) {
+		t.Errorf("resources = %v\nwant %v", got, wantTypes)
+	}
+
+	want := []string{
+		"aws-s3-public-grants arn:aws:s3:::public-site",
+		"cis-1.1 arn:aws:iam::123456789012:root",
+		"cis-1.10 arn:aws:iam::123456789012:user/alice",
+		"cis-1.14 arn:aws:iam::123456789012:user/bob",
+		"cis-1.4 arn:aws:iam::123456789012:root",
+		"cis-1.8 arn:aws:iam::123456789012:password-policy",
+		"cis-2.1 arn:aws:s3:::logs",
+		"cis-2.1.5 arn:aws:s3:::123456789012",
+		"cis-2.2 arn:aws:s3:::public-site",
+		"cis-3.2 arn:aws:cloudtrail:us-east-1:123456789012:trail/main",
+		"cis-5.2 arn:aws:ec2:us-east-1:123456789012:security-group/sg-1",
+	}
+	if got := failedRules(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("findings =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if report.CriticalFindings != 3 {
+		t.Errorf("critical findings = %d, want 3", report.CriticalFindings)
+	}
+
+	// A rescan replaces the findings and keeps the resolved ones resolved
+	open := cspm.GetFindings("open")
+	if err := cspm.ResolveFinding(open[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cspm.ScanProvider("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if all := cspm.GetFindings(""); len(all) != len(want) {
+		t.Errorf("%d findings after rescan, want %d", len(all), len(want))
+	}
+	resolved := cspm.GetFindings("resolved")
+	if len(resolved) != 1 || resolved[0].ID != open[0].ID {
+		t.Errorf("resolved after rescan = %v", resolved)
+	}
+}
+
+func TestRunBenchmarkAWS(t *testing.T) {
+	srv := fakeAWS(t)
+	defer srv.Close()
+
+	cspm := NewCSPMModule()
+	cspm.AddProvider("prod", "AWS", map[string]string{
+		"access_key_id": "AKIDTEST", "secret_access_key": "secret", "region": "us-east-1", "endpoint": srv.URL, "rate_limit": "0",
+	})
+	if _, err := cspm.BenchmarkTarget("prod"); err == nil || !strings.Contains(err.Error(), "has not been scanned") {
+		t.Errorf("target before a scan: %v", err)
+	}
+	// The provider is scanned first
+	report, err := cspm.RunBenchmark("prod", "cis", benchmark.Options{Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, r := range report.Results {
+		if r.Status == "fail" {
+			failed = append(failed, r.Control.ID)
+		} else if r.Status == "error" {
+			t.Errorf("%s: %s", r.Control.ID, r.Error)
+		}
+	}
+	want := []string{"1.4", "1.5", "1.8", "1.9", "1.10", "1.14", "1.16", "2.1.5", "2.1.5.account", "3.2", "3.4", "5.2"}
+	if !reflect.DeepEqual(failed, want) || report.Score != 20 || report.Manual != 1 {
+		t.Errorf("failed %v, score %v, %d manual", failed, report.Score, report.Manual)
+	}
+	if _, err := cspm.RunBenchmark("prod", "cis-linux", benchmark.Options{}); err == nil || !strings.Contains(err.Error(), "is for linux") {
+		t.Errorf("linux benchmark on AWS: %v", err)
+	}
+}
+
+func TestScanAWSBadCredentials(t *testing.T) {
+	srv := fakeAWS(t)
+	defer srv.Close()
+
+	cspm := NewCSPMModule()
+	cspm.AddProvider("prod", "AWS", map[string]string{"access_key_id": "AKIDOTHER", "secret_access_key": "x", "endpoint": srv.URL})
+	if _, err := cspm.ScanProvider("prod"); err == nil || !strings.Contains(err.Error(), "InvalidClientTokenId") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestScanAzure(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			r.ParseForm()
+			if r.PostForm.Get("client_secret") != "s3cret" {
+				w.WriteHeader(401)
+				fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"tok","expires_in":3600}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(401)
+			fmt.Fprint(w, `{"error":{"code":"InvalidAuthenticationToken","message":"no token"}}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/subscriptions/sub-1/providers/Microsoft.Storage/storageAccounts":
+			if r.URL.Query().Get("$skiptoken") == "" {
+				fmt.Fprintf(w, `{"value":[{"id":"/subscriptions/sub-1/storageAccounts/good","name":"good","location":"westeurope",
+					"properties":{"supportsHttpsTrafficOnly":true,"allowBlobPublicAccess":false,"encryption":{"services":{"blob":{"enabled":true}}}}}],
+					"nextLink":"%s%s?api-version=2023-01-01&$skiptoken=2"}`, srv.URL, r.URL.Path)
+				return
+			}
+			fmt.Fprint(w, `{"value":[{"id":"/subscriptions/sub-1/storageAccounts/open","name":"open","location":"eastus",
+				"properties":{"supportsHttpsTrafficOnly":false,"allowBlobPublicAccess":true,"encryption":{"services":{"blob":{"enabled":true}}}}}]}`)
+		case "/subscriptions/sub-1/providers/Microsoft.Network/networkSecurityGroups":
+			fmt.Fprint(w, `{"value":[{"id":"/subscriptions/sub-1/networkSecurityGroups/jump","name":"jump","location":"eastus",
+				"properties":{"securityRules":[{"name":"rdp","properties":{"direction":"Inbound","access":"Allow","protocol":"Tcp",
+				"priority":100,"sourceAddressPrefix":"*","destinationPortRange":"3389"}},
+				{"name":"web","properties":{"direction":"Inbound","access":"Allow","protocol":"Tcp","priority":110,
+				"sourceAddressPrefix":"Internet","destinationPortRange":"443"}}]}}]}`)
+		default:
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"error":{"code":"NotFound","message":"not found"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	cspm := NewCSPMModule()
+	err := cspm.AddProvider("corp", "Azure", map[string]string{
+		"tenant_id": "tenant-1", "client_id": "app", "client_secret": "s3cret", "subscription_id": "sub-1",
+		"endpoint": srv.URL, "authority": srv.URL, "rate_limit": "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := cspm.ScanProvider("corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors: %v", report.Errors)
+	}
+	want := []string{
+		"azure-1.2 /subscriptions/sub-1/storageAccounts/open",
+		"azure-1.3 /subscriptions/sub-1/storageAccounts/open",
+		"azure-2.1 /subscriptions/sub-1/networkSecurityGroups/jump",
+	}
+	if got := failedRules(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %v, want %v", got, want)
+	}
+
+	cspm.AddProvider("wrong", "Azure", map[string]string{
+		"tenant_id": "tenant-1", "client_id": "app", "client_secret": "guess", "subscription_id": "sub-1",
+		"endpoint": srv.URL, "authority": srv.URL,
+	})
+	if _, err := cspm.ScanProvider("wrong"); err == nil || !strings.Contains(err.Error(), "invalid_client: bad secret") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestScanGCP(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			if len(parts) != 3 {
+				w.WriteHeader(400)
+				fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad assertion"}`)
+				return
+			}
+			sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			var c struct {
+				Iss string `json:"iss"`
+				Aud string `json:"aud"`
+			}
+			json.Unmarshal(claims, &c)
+			if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig) != nil || c.Iss != "scanner@p.iam.gserviceaccount.com" || c.Aud != srv.URL+"/token" {
+				w.WriteHeader(400)
+				fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad signature"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"gtok","expires_in":3600}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer gtok" {
+			w.WriteHeader(401)
+			fmt.Fprint(w, `{"error":{"code":401,"message":"unauthenticated"}}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/storage/v1/b":
+			if r.URL.Query().Get("pageToken") == "" {
+				fmt.Fprint(w, `{"items":[{"name":"assets","location":"US","iamConfiguration":{"uniformBucketLevelAccess":{"enabled":true}}}],"nextPageToken":"p2"}`)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"name":"private","location":"EU","iamConfiguration":{"uniformBucketLevelAccess":{"enabled":true}}}]}`)
+		case "/storage/v1/b/assets/iam":
+			fmt.Fprint(w, `{"bindings":[{"role":"roles/storage.objectViewer","members":["allUsers"]}]}`)
+		case "/storage/v1/b/private/iam":
+			fmt.Fprint(w, `{"bindings":[{"role":"roles/storage.admin","members":["user:ops@example.com"]}]}`)
+		case "/compute/v1/projects/p/global/firewalls":
+			fmt.Fprint(w, `{"items":[{"name":"allow-ssh","network":"global/networks/default","direction":"INGRESS","sourceRanges":["0.0.0.0/0"],
+				"allowed":[{"IPProtocol":"tcp","ports":["22"]}]},{"name":"allow-internal","network":"global/networks/default",
+				"direction":"INGRESS","sourceRanges":["10.0.0.0/8"],"allowed":[{"IPProtocol":"all"}]}]}`)
+		case "/compute/v1/projects/p/aggregated/instances":
+			fmt.Fprint(w, `{"items":{"zones/us-central1-a":{"instances":[{"name":"vm-1","zone":"projects/p/zones/us-central1-a",
+				"serviceAccounts":[{"email":"123-compute@developer.gserviceaccount.com","scopes":["https://www.googleapis.com/auth/cloud-platform"]}]}]},
+				"zones/us-east1-b":{"warning":{"code":"NO_RESULTS_ON_PAGE"}}}}`)
+		default:
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"not found"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	creds, _ := json.Marshal(map[string]string{
+		"type": "service_account", "project_id": "p", "client_email": "scanner@p.iam.gserviceaccount.com",
+		"private_key": pemKey, "token_uri": srv.URL + "/token",
+	})
+	cspm := NewCSPMModule()
+	if err := cspm.AddProvider("gcp", "GCP", map[string]string{"credentials_json": string(creds), "endpoint": srv.URL, "rate_limit": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := cspm.ScanProvider("gcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Account != "p" || len(report.Errors) > 0 {
+		t.Fatalf("account %s, errors %v", report.Account, report.Errors)
+	}
+	want := []string{
+		"gcp-1.1 //storage.googleapis.com/projects/_/buckets/assets",
+		"gcp-2.2 projects/p/zones/us-central1-a/instances/vm-1",
+		"gcp-3.1 projects/p/global/firewalls/allow-ssh",
+	}
+	if got := failedRules(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %v, want %v", got, want)
+	}
+}
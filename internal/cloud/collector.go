@@ -0,0 +1,237 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inventory is what a collector read from an account
+type Inventory struct {
+	Account   string
+	Regions   []string
+	Resources []CloudResource
+	Errors    []string // Services and regions that could not be read
+
+	mu sync.Mutex
+}
+
+func (inv *Inventory) add(r CloudResource) {
+	if r.LastScanned.IsZero() {
+		r.LastScanned = time.Now()
+	}
+	inv.mu.Lock()
+	inv.Resources = append(inv.Resources, r)
+	inv.mu.Unlock()
+}
+
+// fail records a part of the account that could not be read. The scan
+// goes on without it.
+func (inv *Inventory) fail(service, region string, err error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if region != "" {
+		service += " " + region
+	}
+	inv.Errors = append(inv.Errors, fmt.Sprintf("%s: %v", service, err))
+}
+
+// sort orders the resources by type, region and ID, so reports do not
+// depend on the order regions answered in
+func (inv *Inventory) sort() {
+	sort.SliceStable(inv.Resources, func(i, j int) bool {
+		a, b := inv.Resources[i], inv.Resources[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.ID < b.ID
+	})
+	sort.Strings(inv.Regions)
+	sort.Strings(inv.Errors)
+}
+
+// collector reads the resources of an account from a provider's APIs
+type collector interface {
+	collect(ctx context.Context) (*Inventory, error)
+}
+
+// settingAliases are the credential keys accepted by each provider, with
+// the older names cloud_provider_add took
+var settingAliases = map[string]map[string]string{
+	"aws": {
+		"access_key_id": "", "secret_access_key": "", "session_token": "", "profile": "", "region": "",
+		"regions": "", "role_arn": "", "external_id": "", "endpoint": "", "rate_limit": "", "timeout": "",
+		"services": "", "access_key": "access_key_id", "secret_key": "secret_access_key",
+	},
+	"azure": {
+		"tenant_id": "", "client_id": "", "client_secret": "", "subscription_id": "", "endpoint": "",
+		"authority": "", "rate_limit": "", "timeout": "", "services": "",
+	},
+	"gcp": {
+		"project_id": "", "credentials_json": "", "credentials_file": "", "endpoint": "", "rate_limit": "",
+		"timeout": "", "services": "",
+	},
+}
+
+// newCollector returns the collector of a provider type, checking its
+// credentials map
+func newCollector(providerType string, credentials map[string]string) (collector, error) {
+	kind := strings.ToLower(providerType)
+	aliases, ok := settingAliases[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider type '%s', expected AWS, Azure or GCP", providerType)
+	}
+	settings := map[string]string{}
+	for key, value := range credentials {
+		canonical, ok := aliases[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown credential '%s'", providerType, key)
+		}
+		if canonical == "" {
+			canonical = key
+		}
+		settings[canonical] = value
+	}
+	rate, timeout, err := clientSettings(settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", providerType, err)
+	}
+	switch kind {
+	case "aws":
+		return newAWSCollector(settings, rate, timeout)
+	case "azure":
+		return newAzureCollector(settings, rate, timeout)
+	}
+	return newGCPCollector(settings, rate, timeout)
+}
+
+// clientSettings reads rate_limit, requests per second with 10 by
+// default, and timeout in milliseconds
+func clientSettings(settings map[string]string) (float64, time.Duration, error) {
+	rate, timeout := 10.0, time.Duration(0)
+	if s := settings["rate_limit"]; s != "" {
+		r, err := strconv.ParseFloat(s, 64)
+		if err != nil || r < 0 {
+			return 0, 0, fmt.Errorf("rate_limit must be a number of requests per second, 0 for no limit")
+		}
+		rate = r
+	}
+	if s := settings["timeout"]; s != "" {
+		ms, err := strconv.ParseFloat(s, 64)
+		if err != nil || ms <= 0 {
+			return 0, 0, fmt.Errorf("timeout must be a positive number of milliseconds")
+		}
+		timeout = time.Duration(ms * float64(time.Millisecond))
+	}
+	return rate, timeout, nil
+}
+
+// serviceSet reads the services setting, a comma separated list of the
+// services to collect, all of them when empty
+func serviceSet(s string, known ...string) (map[string]bool, error) {
+	set := map[string]bool{}
+	if strings.TrimSpace(s) == "" {
+		for _, name := range known {
+			set[name] = true
+		}
+		return set, nil
+	}
+	for _, name := range splitList(s) {
+		found := false
+		for _, k := range known {
+			if strings.EqualFold(name, k) {
+				set[k], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown service '%s', expected %s", name, strings.Join(known, ", "))
+		}
+	}
+	return set, nil
+}
+
+// splitList splits a comma separated setting
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// forEach runs fn for each item with at most n at a time
+func forEach(items []string, n int, fn func(item string)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	for _, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(item)
+		}(item)
+	}
+	wg.Wait()
+}
+
+// openToWorld reports whether a source address range is the whole internet
+func openToWorld(source string) bool {
+	switch strings.TrimSpace(source) {
+	case "0.0.0.0/0", "::/0", "*", "Internet", "Any", "any", "0.0.0.0":
+		return true
+	}
+	return false
+}
+
+// portRange describes the ports of a rule, all ports for a protocol of -1
+// or all, or a range that covers all of them
+func portRange(protocol string, from, to int) string {
+	if protocol == "-1" || strings.EqualFold(protocol, "all") || protocol == "*" || from <= 0 && to >= 65535 {
+		return "all"
+	}
+	if from == to {
+		return strconv.Itoa(from)
+	}
+	return fmt.Sprintf("%d-%d", from, to)
+}
+
+// coversPort reports whether a port range from portRange includes a port
+func coversPort(ports string, port int) bool {
+	if ports == "all" || ports == "*" {
+		return true
+	}
+	from, to, found := strings.Cut(ports, "-")
+	if !found {
+		to = from
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(from))
+	end, err2 := strconv.Atoi(strings.TrimSpace(to))
+	return err1 == nil && err2 == nil && start <= port && port <= end
+}
+
+// adminPorts are the remote administration ports that should never be
+// open to the internet: SSH, RDP, WinRM and common database ports
+var adminPorts = []int{22, 3389, 5985, 5986, 23, 1433, 3306, 5432, 6379, 9200, 27017}
+
+// adminExposed reports whether any of the port ranges open to the
+// internet covers a remote administration port
+func adminExposed(open []interface{}) bool {
+	for _, item := range open {
+		for _, port := range adminPorts {
+			if coversPort(fmt.Sprint(item), port) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,18 +2,26 @@
 package cloud
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"sentra/internal/benchmark"
 )
 
 // CSPMModule provides Cloud Security Posture Management capabilities
 type CSPMModule struct {
-	providers map[string]*CloudProvider
-	policies  map[string]*SecurityPolicy
-	findings  []SecurityFinding
+	mu          sync.Mutex
+	providers   map[string]*CloudProvider
+	policies    map[string]*SecurityPolicy
+	findings    []SecurityFinding
+	lastFinding int
+	benchmarks  *benchmark.Engine
 }
 
 // CloudProvider represents a cloud service provider
@@ -22,18 +30,24 @@ type CloudProvider struct {
 	Type        string // AWS, Azure, GCP
 	Credentials map[string]string
 	Resources   []CloudResource
+	Account     string   // Account, subscriptions or project of the last scan
+	Regions     []string // Regions of the last scan
+	Errors      []string // Services and regions the last scan could not read
+	LastScanned time.Time
+
+	collector collector
 }
 
 // CloudResource represents a cloud resource
 type CloudResource struct {
-	ID           string
-	Type         string
-	Name         string
-	Region       string
-	Tags         map[string]string
-	Config       map[string]interface{}
-	LastScanned  time.Time
-	Compliance   ComplianceStatus
+	ID          string
+	Type        string
+	Name        string
+	Region      string
+	Tags        map[string]string
+	Config      map[string]interface{}
+	LastScanned time.Time
+	Compliance  ComplianceStatus
 }
 
 // SecurityPolicy represents a security policy or benchmark
@@ -48,16 +62,21 @@ type SecurityPolicy struct {
 
 // PolicyRule represents a single rule in a policy
 type PolicyRule struct {
-	ID          string
-	Description string
-	Check       func(CloudResource) bool
-	Remediation string
+	ID           string
+	Description  string
+	ResourceType string // Only resources of this type are checked
+	Severity     string // The policy's severity when empty
+	Check        func(CloudResource) bool
+	Remediation  string
 }
 
 // SecurityFinding represents a security issue found
 type SecurityFinding struct {
 	ID           string
+	Provider     string
 	ResourceID   string
+	ResourceType string
+	Region       string
 	PolicyID     string
 	RuleID       string
 	Severity     string
@@ -78,17 +97,37 @@ type ComplianceStatus struct {
 // NewCSPMModule creates a new CSPM module
 func NewCSPMModule() *CSPMModule {
 	cspm := &CSPMModule{
-		providers: make(map[string]*CloudProvider),
-		policies:  make(map[string]*SecurityPolicy),
-		findings:  []SecurityFinding{},
+		providers:  make(map[string]*CloudProvider),
+		policies:   make(map[string]*SecurityPolicy),
+		findings:   []SecurityFinding{},
+		benchmarks: benchmark.NewEngine(),
 	}
-	
+
 	// Initialize default policies
 	cspm.initializeDefaultPolicies()
-	
+
 	return cspm
 }
 
+// configBool reads a boolean setting of a resource, false when missing
+func configBool(r CloudResource, key string) bool {
+	b, _ := r.Config[key].(bool)
+	return b
+}
+
+// configInt reads a numeric setting of a resource
+func configInt(r CloudResource, key string) (int, bool) {
+	switch n := r.Config[key].(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
 // initializeDefaultPolicies sets up common security policies
 func (c *CSPMModule) initializeDefaultPolicies() {
 	// AWS CIS Benchmark policies
@@ -100,56 +139,163 @@ func (c *CSPMModule) initializeDefaultPolicies() {
 		Severity:    "HIGH",
 		Rules: []PolicyRule{
 			{
-				ID:          "cis-1.1",
-				Description: "Ensure MFA is enabled for root account",
+				ID:           "cis-1.4",
+				Description:  "Ensure no root account access key exists",
+				ResourceType: "aws:iam:root",
+				Severity:     "CRITICAL",
+				Check:        func(r CloudResource) bool { return !configBool(r, "access_keys_present") },
+				Remediation:  "Delete the access keys of the root account",
+			},
+			{
+				ID:           "cis-1.1",
+				Description:  "Ensure MFA is enabled for root account",
+				ResourceType: "aws:iam:root",
+				Severity:     "CRITICAL",
+				Check:        func(r CloudResource) bool { return configBool(r, "mfa_enabled") },
+				Remediation:  "Enable MFA for root account in IAM console",
+			},
+			{
+				ID:           "cis-1.8",
+				Description:  "Ensure IAM password policy requires minimum length of 14 or greater",
+				ResourceType: "aws:iam:password_policy",
+				Severity:     "MEDIUM",
 				Check: func(r CloudResource) bool {
-					if r.Type != "aws:iam:root" {
-						return true
-					}
-					mfa, ok := r.Config["mfa_enabled"].(bool)
-					return ok && mfa
+					n, _ := configInt(r, "minimum_length")
+					return configBool(r, "exists") && n >= 14
 				},
-				Remediation: "Enable MFA for root account in IAM console",
+				Remediation: "Set a password policy with a minimum length of 14 in IAM account settings",
 			},
 			{
-				ID:          "cis-2.1",
-				Description: "Ensure S3 bucket logging is enabled",
+				ID:           "cis-1.10",
+				Description:  "Ensure MFA is enabled for all IAM users that have a console password",
+				ResourceType: "aws:iam:user",
 				Check: func(r CloudResource) bool {
-					if r.Type != "aws:s3:bucket" {
-						return true
-					}
-					logging, ok := r.Config["logging_enabled"].(bool)
-					return ok && logging
+					return !configBool(r, "console_access") || configBool(r, "mfa_enabled")
 				},
-				Remediation: "Enable server access logging for S3 bucket",
+				Remediation: "Assign an MFA device to the user or remove its console password",
 			},
 			{
-				ID:          "cis-2.2",
-				Description: "Ensure S3 bucket public access is blocked",
+				ID:           "cis-1.12",
+				Description:  "Ensure credentials unused for 45 days or greater are disabled",
+				ResourceType: "aws:iam:user",
+				Severity:     "MEDIUM",
 				Check: func(r CloudResource) bool {
-					if r.Type != "aws:s3:bucket" {
-						return true
+					if n, _ := configInt(r, "days_since_password_used"); configBool(r, "console_access") && n > 45 {
+						return false
+					}
+					keys, _ := r.Config["access_keys"].([]interface{})
+					for _, k := range keys {
+						key, _ := k.(map[string]interface{})
+						if key["status"] != "Active" {
+							continue
+						}
+						used, _ := configInt(CloudResource{Config: key}, "days_since_used")
+						age, _ := configInt(CloudResource{Config: key}, "age_days")
+						if used > 45 || used < 0 && age > 45 {
+							return false
+						}
 					}
-					public, ok := r.Config["public_access_blocked"].(bool)
-					return ok && public
+					return true
 				},
-				Remediation: "Block public access in S3 bucket settings",
+				Remediation: "Remove the console password or deactivate the access keys the user has not used in 45 days",
 			},
 			{
-				ID:          "cis-3.1",
-				Description: "Ensure CloudTrail is enabled in all regions",
+				ID:           "cis-1.14",
+				Description:  "Ensure access keys are rotated every 90 days or less",
+				ResourceType: "aws:iam:user",
+				Severity:     "MEDIUM",
 				Check: func(r CloudResource) bool {
-					if r.Type != "aws:cloudtrail:trail" {
+					n, _ := configInt(r, "oldest_active_key_days")
+					return n <= 90
+				},
+				Remediation: "Create a new access key, move applications to it and deactivate the old one",
+			},
+			{
+				ID:           "cis-2.1",
+				Description:  "Ensure S3 bucket logging is enabled",
+				ResourceType: "aws:s3:bucket",
+				Severity:     "MEDIUM",
+				Check:        func(r CloudResource) bool { return configBool(r, "logging_enabled") },
+				Remediation:  "Enable server access logging for S3 bucket",
+			},
+			{
+				ID:           "cis-2.2",
+				Description:  "Ensure S3 bucket public access is blocked",
+				ResourceType: "aws:s3:bucket",
+				Check:        func(r CloudResource) bool { return configBool(r, "public_access_blocked") },
+				Remediation:  "Block public access in S3 bucket settings",
+			},
+			{
+				ID:           "aws-s3-public-grants",
+				Description:  "Ensure S3 buckets do not grant public access through ACLs or bucket policies",
+				ResourceType: "aws:s3:bucket",
+				Severity:     "CRITICAL",
+				Check: func(r CloudResource) bool {
+					return !configBool(r, "public_acl") && !configBool(r, "public_policy")
+				},
+				Remediation: "Remove the AllUsers and AuthenticatedUsers grants and public statements from the bucket",
+			},
+			{
+				ID:           "cis-2.1.5",
+				Description:  "Ensure S3 Block Public Access is enabled for the account",
+				ResourceType: "aws:s3:account",
+				Check:        func(r CloudResource) bool { return configBool(r, "public_access_blocked") },
+				Remediation:  "Turn on all four Block Public Access settings for the account",
+			},
+			{
+				ID:           "cis-3.1",
+				Description:  "Ensure CloudTrail is enabled in all regions",
+				ResourceType: "aws:cloudtrail:trail",
+				Check:        func(r CloudResource) bool { return configBool(r, "is_multi_region") },
+				Remediation:  "Enable multi-region CloudTrail",
+			},
+			{
+				ID:           "aws-cloudtrail-multi-region",
+				Description:  "Ensure a multi-region CloudTrail trail is logging",
+				ResourceType: "aws:cloudtrail:account",
+				Check:        func(r CloudResource) bool { return configBool(r, "multi_region_logging") },
+				Remediation:  "Create a multi-region trail and start logging",
+			},
+			{
+				ID:           "aws-cloudtrail-logging",
+				Description:  "Ensure CloudTrail trails are logging",
+				ResourceType: "aws:cloudtrail:trail",
+				Check:        func(r CloudResource) bool { return configBool(r, "is_logging") },
+				Remediation:  "Start logging for the trail",
+			},
+			{
+				ID:           "cis-3.2",
+				Description:  "Ensure CloudTrail log file validation is enabled",
+				ResourceType: "aws:cloudtrail:trail",
+				Severity:     "MEDIUM",
+				Check:        func(r CloudResource) bool { return configBool(r, "log_file_validation") },
+				Remediation:  "Enable log file validation for the trail",
+			},
+			{
+				ID:           "cis-5.2",
+				Description:  "Ensure no security groups allow ingress from 0.0.0.0/0 to remote administration ports",
+				ResourceType: "aws:ec2:security_group",
+				Check:        func(r CloudResource) bool { return !configBool(r, "admin_ports_open") },
+				Remediation:  "Restrict the ingress rules to known address ranges or use Session Manager",
+			},
+			{
+				ID:           "cis-5.4",
+				Description:  "Ensure the default security group of every VPC restricts all traffic",
+				ResourceType: "aws:ec2:security_group",
+				Severity:     "MEDIUM",
+				Check: func(r CloudResource) bool {
+					if !configBool(r, "default") {
 						return true
 					}
-					multiRegion, ok := r.Config["is_multi_region"].(bool)
-					return ok && multiRegion
+					in, _ := configInt(r, "ingress_rules")
+					out, _ := configInt(r, "egress_rules")
+					return in == 0 && out == 0
 				},
-				Remediation: "Enable multi-region CloudTrail",
+				Remediation: "Remove all inbound and outbound rules from the default security group",
 			},
 		},
 	}
-	
+
 	// Azure Security Center policies
 	c.policies["azure-sc-baseline"] = &SecurityPolicy{
 		ID:          "azure-sc-baseline",
@@ -159,45 +305,37 @@ func (c *CSPMModule) initializeDefaultPolicies() {
 		Severity:    "HIGH",
 		Rules: []PolicyRule{
 			{
-				ID:          "azure-1.1",
-				Description: "Ensure storage accounts use encryption",
-				Check: func(r CloudResource) bool {
-					if r.Type != "azure:storage:account" {
-						return true
-					}
-					encrypted, ok := r.Config["encryption_enabled"].(bool)
-					return ok && encrypted
-				},
-				Remediation: "Enable encryption for storage account",
+				ID:           "azure-1.1",
+				Description:  "Ensure storage accounts use encryption",
+				ResourceType: "azure:storage:account",
+				Check:        func(r CloudResource) bool { return configBool(r, "encryption_enabled") },
+				Remediation:  "Enable encryption for storage account",
 			},
 			{
-				ID:          "azure-2.1",
-				Description: "Ensure network security groups are restrictive",
-				Check: func(r CloudResource) bool {
-					if r.Type != "azure:network:nsg" {
-						return true
-					}
-					// Check for overly permissive rules
-					rules, ok := r.Config["inbound_rules"].([]interface{})
-					if !ok {
-						return false
-					}
-					for _, rule := range rules {
-						if r, ok := rule.(map[string]interface{}); ok {
-							if src, ok := r["source"].(string); ok && src == "*" {
-								if port, ok := r["port"].(string); ok && (port == "22" || port == "3389") {
-									return false
-								}
-							}
-						}
-					}
-					return true
-				},
-				Remediation: "Restrict NSG rules to specific IP ranges",
+				ID:           "azure-1.2",
+				Description:  "Ensure storage accounts only accept secure transfer",
+				ResourceType: "azure:storage:account",
+				Severity:     "MEDIUM",
+				Check:        func(r CloudResource) bool { return configBool(r, "https_only") },
+				Remediation:  "Enable secure transfer required for the storage account",
+			},
+			{
+				ID:           "azure-1.3",
+				Description:  "Ensure storage accounts do not allow public blob access",
+				ResourceType: "azure:storage:account",
+				Check:        func(r CloudResource) bool { return !configBool(r, "allow_blob_public_access") },
+				Remediation:  "Disable allow blob anonymous access on the storage account",
+			},
+			{
+				ID:           "azure-2.1",
+				Description:  "Ensure network security groups are restrictive",
+				ResourceType: "azure:network:nsg",
+				Check:        func(r CloudResource) bool { return !configBool(r, "admin_ports_open") },
+				Remediation:  "Restrict NSG rules to specific IP ranges",
 			},
 		},
 	}
-	
+
 	// GCP Security Command Center policies
 	c.policies["gcp-scc-baseline"] = &SecurityPolicy{
 		ID:          "gcp-scc-baseline",
@@ -207,147 +345,158 @@ func (c *CSPMModule) initializeDefaultPolicies() {
 		Severity:    "HIGH",
 		Rules: []PolicyRule{
 			{
-				ID:          "gcp-1.1",
-				Description: "Ensure Cloud Storage buckets are not public",
-				Check: func(r CloudResource) bool {
-					if r.Type != "gcp:storage:bucket" {
-						return true
-					}
-					public, ok := r.Config["public_access"].(bool)
-					return ok && !public
-				},
-				Remediation: "Remove public access from Cloud Storage bucket",
+				ID:           "gcp-1.1",
+				Description:  "Ensure Cloud Storage buckets are not public",
+				ResourceType: "gcp:storage:bucket",
+				Severity:     "CRITICAL",
+				Check:        func(r CloudResource) bool { return !configBool(r, "public_access") },
+				Remediation:  "Remove public access from Cloud Storage bucket",
 			},
 			{
-				ID:          "gcp-2.1",
-				Description: "Ensure Compute instances use service accounts",
+				ID:           "gcp-1.2",
+				Description:  "Ensure Cloud Storage buckets have uniform bucket-level access",
+				ResourceType: "gcp:storage:bucket",
+				Severity:     "MEDIUM",
+				Check:        func(r CloudResource) bool { return configBool(r, "uniform_access") },
+				Remediation:  "Enable uniform bucket-level access on the bucket",
+			},
+			{
+				ID:           "gcp-2.1",
+				Description:  "Ensure Compute instances use service accounts",
+				ResourceType: "gcp:compute:instance",
+				Severity:     "MEDIUM",
 				Check: func(r CloudResource) bool {
-					if r.Type != "gcp:compute:instance" {
-						return true
-					}
 					sa, ok := r.Config["service_account"].(string)
 					return ok && sa != ""
 				},
 				Remediation: "Assign service account to Compute instance",
 			},
+			{
+				ID:           "gcp-2.2",
+				Description:  "Ensure instances do not use the default service account with full access to all Cloud APIs",
+				ResourceType: "gcp:compute:instance",
+				Check: func(r CloudResource) bool {
+					return !configBool(r, "default_service_account") || !configBool(r, "full_api_access")
+				},
+				Remediation: "Give the instance a dedicated service account or narrow its access scopes",
+			},
+			{
+				ID:           "gcp-3.1",
+				Description:  "Ensure firewall rules do not allow remote administration from the internet",
+				ResourceType: "gcp:compute:firewall",
+				Check:        func(r CloudResource) bool { return !configBool(r, "admin_ports_open") },
+				Remediation:  "Restrict the source ranges of the firewall rule or use Identity-Aware Proxy",
+			},
 		},
 	}
 }
 
 // AddProvider adds a cloud provider configuration
 func (c *CSPMModule) AddProvider(name, providerType string, credentials map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if _, exists := c.providers[name]; exists {
 		return fmt.Errorf("provider %s already exists", name)
 	}
-	
+
+	coll, err := newCollector(providerType, credentials)
+	if err != nil {
+		return err
+	}
 	c.providers[name] = &CloudProvider{
 		Name:        name,
 		Type:        providerType,
 		Credentials: credentials,
 		Resources:   []CloudResource{},
+		collector:   coll,
 	}
-	
+
 	return nil
 }
 
 // ScanProvider scans a cloud provider for resources and compliance
 func (c *CSPMModule) ScanProvider(providerName string) (*ComplianceReport, error) {
+	return c.ScanProviderContext(context.Background(), providerName)
+}
+
+// ScanProviderContext collects the resources of a provider from its APIs
+// and evaluates the policies of the provider type against them. The
+// findings of an earlier scan of the provider are replaced, keeping the
+// status of findings that were resolved or ignored.
+func (c *CSPMModule) ScanProviderContext(ctx context.Context, providerName string) (*ComplianceReport, error) {
+	c.mu.Lock()
 	provider, exists := c.providers[providerName]
+	c.mu.Unlock()
 	if !exists {
 		return nil, fmt.Errorf("provider %s not found", providerName)
 	}
-	
-	// Simulate resource discovery
-	resources := c.discoverResources(provider)
-	provider.Resources = resources
-	
+
+	inv, err := provider.collector.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	provider.Resources = inv.Resources
+	provider.Account, provider.Regions, provider.Errors = inv.Account, inv.Regions, inv.Errors
+	provider.LastScanned = time.Now()
+
+	previous := map[string]SecurityFinding{}
+	kept := c.findings[:0]
+	for _, f := range c.findings {
+		if f.Provider == providerName {
+			previous[f.PolicyID+"|"+f.RuleID+"|"+f.ResourceID] = f
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	c.findings = kept
+
 	// Run security policies
 	report := &ComplianceReport{
 		Provider:  providerName,
-		Timestamp: time.Now(),
-		Resources: len(resources),
+		Account:   inv.Account,
+		Regions:   inv.Regions,
+		Errors:    inv.Errors,
+		Timestamp: provider.LastScanned,
+		Resources: len(inv.Resources),
+	}
+
+	ids := make([]string, 0, len(c.policies))
+	for id := range c.policies {
+		ids = append(ids, id)
 	}
-	
-	for _, policy := range c.policies {
-		if policy.Provider != provider.Type && policy.Provider != "*" {
+	sort.Strings(ids)
+	for _, id := range ids {
+		policy := c.policies[id]
+		if !strings.EqualFold(policy.Provider, provider.Type) && policy.Provider != "*" {
 			continue
 		}
-		
-		policyResult := c.evaluatePolicy(policy, resources)
+
+		policyResult := c.evaluatePolicy(provider, policy, inv.Resources, previous)
 		report.PolicyResults = append(report.PolicyResults, policyResult)
 	}
-	
+
 	// Calculate overall compliance score
 	report.calculateScore()
-	
+
 	return report, nil
 }
 
-// discoverResources simulates resource discovery
-func (c *CSPMModule) discoverResources(provider *CloudProvider) []CloudResource {
-	// In a real implementation, this would use cloud provider APIs
-	// For demonstration, we'll simulate some resources
-	
-	resources := []CloudResource{}
-	
-	switch provider.Type {
-	case "AWS":
-		resources = append(resources, CloudResource{
-			ID:     "bucket-001",
-			Type:   "aws:s3:bucket",
-			Name:   "sensitive-data-bucket",
-			Region: "us-east-1",
-			Config: map[string]interface{}{
-				"logging_enabled":       true,
-				"public_access_blocked": false, // Non-compliant
-				"encryption":           "AES256",
-			},
-			LastScanned: time.Now(),
-		})
-		resources = append(resources, CloudResource{
-			ID:     "trail-001",
-			Type:   "aws:cloudtrail:trail",
-			Name:   "main-trail",
-			Region: "us-east-1",
-			Config: map[string]interface{}{
-				"is_multi_region": false, // Non-compliant
-				"is_logging":      true,
-			},
-			LastScanned: time.Now(),
-		})
-		
-	case "Azure":
-		resources = append(resources, CloudResource{
-			ID:     "storage-001",
-			Type:   "azure:storage:account",
-			Name:   "productiondata",
-			Region: "eastus",
-			Config: map[string]interface{}{
-				"encryption_enabled": true,
-				"https_only":        true,
-			},
-			LastScanned: time.Now(),
-		})
-		
-	case "GCP":
-		resources = append(resources, CloudResource{
-			ID:     "bucket-001",
-			Type:   "gcp:storage:bucket",
-			Name:   "app-uploads",
-			Region: "us-central1",
-			Config: map[string]interface{}{
-				"public_access": true, // Non-compliant
-				"versioning":    true,
-			},
-			LastScanned: time.Now(),
-		})
+// Resources returns the resources of the last scan of a provider
+func (c *CSPMModule) Resources(providerName string) ([]CloudResource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	provider, exists := c.providers[providerName]
+	if !exists {
+		return nil, fmt.Errorf("provider %s not found", providerName)
 	}
-	
-	return resources
+	return append([]CloudResource{}, provider.Resources...), nil
 }
 
 // evaluatePolicy evaluates a security policy against resources
-func (c *CSPMModule) evaluatePolicy(policy *SecurityPolicy, resources []CloudResource) *PolicyResult {
+func (c *CSPMModule) evaluatePolicy(provider *CloudProvider, policy *SecurityPolicy, resources []CloudResource, previous map[string]SecurityFinding) *PolicyResult {
 	result := &PolicyResult{
 		PolicyID:     policy.ID,
 		PolicyName:   policy.Name,
@@ -356,45 +505,64 @@ func (c *CSPMModule) evaluatePolicy(policy *SecurityPolicy, resources []CloudRes
 		FailedChecks: 0,
 		Findings:     []SecurityFinding{},
 	}
-	
+
 	for _, resource := range resources {
 		for _, rule := range policy.Rules {
+			if rule.ResourceType != "" && rule.ResourceType != resource.Type {
+				continue
+			}
 			result.TotalChecks++
-			
+
 			if rule.Check(resource) {
 				result.PassedChecks++
+				continue
+			}
+			result.FailedChecks++
+
+			severity := rule.Severity
+			if severity == "" {
+				severity = policy.Severity
+			}
+			finding := SecurityFinding{
+				Provider:     provider.Name,
+				ResourceID:   resource.ID,
+				ResourceType: resource.Type,
+				Region:       resource.Region,
+				PolicyID:     policy.ID,
+				RuleID:       rule.ID,
+				Severity:     severity,
+				Description:  fmt.Sprintf("%s - Resource: %s", rule.Description, resource.Name),
+				Remediation:  rule.Remediation,
+				FoundAt:      time.Now(),
+				Status:       "open",
+			}
+			// A finding that is still there keeps its ID and status
+			if old, ok := previous[policy.ID+"|"+rule.ID+"|"+resource.ID]; ok {
+				finding.ID, finding.FoundAt, finding.Status = old.ID, old.FoundAt, old.Status
 			} else {
-				result.FailedChecks++
-				
-				// Create finding
-				finding := SecurityFinding{
-					ID:          fmt.Sprintf("finding-%d", len(c.findings)+1),
-					ResourceID:  resource.ID,
-					PolicyID:    policy.ID,
-					RuleID:      rule.ID,
-					Severity:    policy.Severity,
-					Description: fmt.Sprintf("%s - Resource: %s", rule.Description, resource.Name),
-					Remediation: rule.Remediation,
-					FoundAt:     time.Now(),
-					Status:      "open",
-				}
-				
-				c.findings = append(c.findings, finding)
-				result.Findings = append(result.Findings, finding)
+				c.lastFinding++
+				finding.ID = fmt.Sprintf("finding-%d", c.lastFinding)
 			}
+
+			c.findings = append(c.findings, finding)
+			result.Findings = append(result.Findings, finding)
 		}
 	}
-	
+
+	result.ComplianceScore = 100
 	if result.TotalChecks > 0 {
 		result.ComplianceScore = float64(result.PassedChecks) / float64(result.TotalChecks) * 100
 	}
-	
+
 	return result
 }
 
 // ComplianceReport represents a compliance scan report
 type ComplianceReport struct {
 	Provider         string
+	Account          string
+	Regions          []string
+	Errors           []string
 	Timestamp        time.Time
 	Resources        int
 	PolicyResults    []*PolicyResult
@@ -421,11 +589,11 @@ func (r *ComplianceReport) calculateScore() {
 	if len(r.PolicyResults) == 0 {
 		return
 	}
-	
+
 	totalScore := 0.0
 	for _, result := range r.PolicyResults {
 		totalScore += result.ComplianceScore
-		
+
 		// Count findings by severity
 		for _, finding := range result.Findings {
 			switch finding.Severity {
@@ -440,19 +608,18 @@ func (r *ComplianceReport) calculateScore() {
 			}
 		}
 	}
-	
+
 	r.OverallScore = totalScore / float64(len(r.PolicyResults))
 }
 
 // GetFindings returns all security findings
 func (c *CSPMModule) GetFindings(status string) []SecurityFinding {
-	if status == "" {
-		return c.findings
-	}
-	
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	filtered := []SecurityFinding{}
 	for _, finding := range c.findings {
-		if finding.Status == status {
+		if status == "" || finding.Status == status {
 			filtered = append(filtered, finding)
 		}
 	}
@@ -461,6 +628,8 @@ func (c *CSPMModule) GetFindings(status string) []SecurityFinding {
 
 // ResolveFinding marks a finding as resolved
 func (c *CSPMModule) ResolveFinding(findingID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for i := range c.findings {
 		if c.findings[i].ID == findingID {
 			c.findings[i].Status = "resolved"
@@ -472,6 +641,8 @@ func (c *CSPMModule) ResolveFinding(findingID string) error {
 
 // GenerateReport generates a compliance report
 func (c *CSPMModule) GenerateReport(format string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	report := struct {
 		Providers []string
 		Policies  int
@@ -490,12 +661,13 @@ func (c *CSPMModule) GenerateReport(format string) (string, error) {
 		Policies:  len(c.policies),
 		Timestamp: time.Now(),
 	}
-	
+
 	// Collect provider names
 	for name := range c.providers {
 		report.Providers = append(report.Providers, name)
 	}
-	
+	sort.Strings(report.Providers)
+
 	// Count findings
 	for _, finding := range c.findings {
 		report.Findings.Total++
@@ -504,7 +676,7 @@ func (c *CSPMModule) GenerateReport(format string) (string, error) {
 		} else if finding.Status == "resolved" {
 			report.Findings.Resolved++
 		}
-		
+
 		switch finding.Severity {
 		case "CRITICAL":
 			report.Findings.Critical++
@@ -516,12 +688,12 @@ func (c *CSPMModule) GenerateReport(format string) (string, error) {
 			report.Findings.Low++
 		}
 	}
-	
+
 	switch format {
 	case "json":
 		data, err := json.MarshalIndent(report, "", "  ")
 		return string(data), err
-		
+
 	default:
 		return fmt.Sprintf(`Cloud Security Posture Report
 =============================
@@ -558,14 +730,14 @@ By Severity:
 // ValidateIAMPolicy validates IAM policies for security issues
 func (c *CSPMModule) ValidateIAMPolicy(policyJSON string) ([]string, error) {
 	issues := []string{}
-	
+
 	// Check for overly permissive actions
 	if strings.Contains(policyJSON, `"*"`) {
 		if strings.Contains(policyJSON, `"Action"`) || strings.Contains(policyJSON, `"Resource"`) {
 			issues = append(issues, "Policy contains wildcard (*) permissions")
 		}
 	}
-	
+
 	// Check for admin access
 	adminPatterns := []string{
 		`"iam:*"`,
@@ -577,17 +749,17 @@ func (c *CSPMModule) ValidateIAMPolicy(policyJSON string) ([]string, error) {
 			issues = append(issues, fmt.Sprintf("Policy grants administrative access: %s", pattern))
 		}
 	}
-	
+
 	// Check for missing conditions
 	if !strings.Contains(policyJSON, `"Condition"`) {
 		issues = append(issues, "Policy lacks conditional access controls")
 	}
-	
+
 	// Check for external principals
 	externalPattern := regexp.MustCompile(`"Principal":\s*{\s*"AWS":\s*"\*"`)
 	if externalPattern.MatchString(policyJSON) {
 		issues = append(issues, "Policy allows access from any AWS principal")
 	}
-	
+
 	return issues, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,557 @@
+package cloud
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcpServices are the services the GCP collector reads
+var gcpServices = []string{"storage", "compute"}
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcpCollector reads the Cloud Storage buckets, firewall rules and Compute
+// Engine instances of a project
+type gcpCollector struct {
+	client   *apiClient
+	settings map[string]string
+	storage  string // Cloud Storage JSON API
+	compute  string // Compute Engine API
+	services map[string]bool
+
+	key *gcpKey // Service account or user credentials, nil on GCE
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// gcpKey is an application default credentials file: a service account
+// key, or the user credentials gcloud auth application-default login saves
+type gcpKey struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+	QuotaProject string `json:"quota_project_id"`
+}
+
+func newGCPCollector(settings map[string]string, rate float64, timeout time.Duration) (*gcpCollector, error) {
+	services, err := serviceSet(settings["services"], gcpServices...)
+	if err != nil {
+		return nil, fmt.Errorf("GCP: %v", err)
+	}
+	c := &gcpCollector{
+		client:   newAPIClient(rate, timeout, jsonError),
+		settings: settings,
+		storage:  "https://storage.googleapis.com/storage/v1",
+		compute:  "https://compute.googleapis.com/compute/v1",
+		services: services,
+	}
+	if e := strings.TrimSuffix(settings["endpoint"], "/"); e != "" {
+		c.storage, c.compute = e+"/storage/v1", e+"/compute/v1"
+	}
+	data := []byte(settings["credentials_json"])
+	if len(data) == 0 {
+		path := firstNonEmpty(settings["credentials_file"], os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+		if path == "" {
+			// gcloud auth application-default login
+			if dir, err := os.UserConfigDir(); err == nil {
+				if p := filepath.Join(dir, "gcloud", "application_default_credentials.json"); fileExists(p) {
+					path = p
+				}
+			}
+			if home, err := os.UserHomeDir(); err == nil && path == "" {
+				if p := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json"); fileExists(p) {
+					path = p
+				}
+			}
+		}
+		if path != "" {
+			if data, err = os.ReadFile(path); err != nil {
+				return nil, fmt.Errorf("GCP credentials: %v", err)
+			}
+		}
+	}
+	if len(data) > 0 {
+		var key gcpKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, fmt.Errorf("GCP credentials: %v", err)
+		}
+		switch key.Type {
+		case "service_account":
+			if key.ClientEmail == "" || key.PrivateKey == "" {
+				return nil, fmt.Errorf("GCP credentials: service account key has no client_email or private_key")
+			}
+		case "authorized_user":
+			if key.RefreshToken == "" {
+				return nil, fmt.Errorf("GCP credentials: user credentials have no refresh_token")
+			}
+		default:
+			return nil, fmt.Errorf("GCP credentials: unsupported type '%s', expected service_account or authorized_user", key.Type)
+		}
+		if key.TokenURI == "" {
+			key.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		c.key = &key
+	}
+	return c, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// metadataHost is the GCE metadata server, which GCE_METADATA_HOST
+// overrides as in the Google client libraries
+func metadataHost() string {
+	return "http://" + firstNonEmpty(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+}
+
+// accessToken returns an OAuth token for the credentials, from a signed
+// service account assertion, a user refresh token or the metadata server
+func (c *gcpCollector) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expires) > 5*time.Minute {
+		return c.token, nil
+	}
+	var body []byte
+	var err error
+	switch {
+	case c.key != nil && c.key.Type == "service_account":
+		var assertion string
+		if assertion, err = c.assertion(); err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		body, err = c.client.do(ctx, request{method: http.MethodPost, url: c.key.TokenURI, body: []byte(form.Encode()),
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}})
+	case c.key != nil:
+		form := url.Values{"grant_type": {"refresh_token"}, "client_id": {c.key.ClientID},
+			"client_secret": {c.key.ClientSecret}, "refresh_token": {c.key.RefreshToken}}
+		body, err = c.client.do(ctx, request{method: http.MethodPost, url: c.key.TokenURI, body: []byte(form.Encode()),
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}})
+	default:
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		meta := &apiClient{http: &http.Client{Timeout: 2 * time.Second}, limit: &limiter{}}
+		body, err = meta.do(ctx, request{url: metadataHost() + "/computeMetadata/v1/instance/service-accounts/default/token",
+			headers: map[string]string{"Metadata-Flavor": "Google"}})
+		if err != nil {
+			return "", fmt.Errorf("no GCP credentials: give credentials_json or credentials_file, set GOOGLE_APPLICATION_CREDENTIALS, or run on GCP (%v)", err)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("GCP token: %w", err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.AccessToken == "" {
+		return "", fmt.Errorf("GCP token: invalid response")
+	}
+	if resp.ExpiresIn == 0 {
+		resp.ExpiresIn = 3600
+	}
+	c.token, c.expires = resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second)
+	return c.token, nil
+}
+
+// assertion returns the JWT a service account exchanges for a token,
+// signed with its private key
+func (c *gcpCollector) assertion() (string, error) {
+	block, _ := pem.Decode([]byte(c.key.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("GCP credentials: private_key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("GCP credentials: %v", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("GCP credentials: private_key is not an RSA key")
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.key.ClientEmail,
+		"scope": gcpScope,
+		"aud":   c.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	signing := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + enc.EncodeToString(sig), nil
+}
+
+func (c *gcpCollector) sign(req *http.Request, body []byte) error {
+	token, err := c.accessToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if c.key != nil && c.key.QuotaProject != "" {
+		req.Header.Set("X-Goog-User-Project", c.key.QuotaProject)
+	}
+	return nil
+}
+
+func (c *gcpCollector) get(ctx context.Context, u string, out interface{}) error {
+	body, err := c.client.do(ctx, request{url: u, sign: c.sign})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// pages reads every page of a list, following nextPageToken
+func (c *gcpCollector) pages(ctx context.Context, u string, each func(body []byte) error) error {
+	token := ""
+	for {
+		page := u
+		if token != "" {
+			sep := "?"
+			if strings.Contains(u, "?") {
+				sep = "&"
+			}
+			page += sep + "pageToken=" + url.QueryEscape(token)
+		}
+		body, err := c.client.do(ctx, request{url: page, sign: c.sign})
+		if err != nil {
+			return err
+		}
+		if err := each(body); err != nil {
+			return err
+		}
+		var next struct {
+			Token string `json:"nextPageToken"`
+		}
+		json.Unmarshal(body, &next)
+		if next.Token == "" {
+			return nil
+		}
+		token = next.Token
+	}
+}
+
+// projectID returns the project setting, the project of the credentials
+// or the project of the GCE instance
+func (c *gcpCollector) projectID(ctx context.Context) (string, error) {
+	if p := firstNonEmpty(c.settings["project_id"], os.Getenv("GOOGLE_CLOUD_PROJECT")); p != "" {
+		return p, nil
+	}
+	if c.key != nil && c.key.ProjectID != "" {
+		return c.key.ProjectID, nil
+	}
+	if c.key == nil {
+		meta := &apiClient{http: &http.Client{Timeout: 2 * time.Second}, limit: &limiter{}}
+		body, err := meta.do(ctx, request{url: metadataHost() + "/computeMetadata/v1/project/project-id",
+			headers: map[string]string{"Metadata-Flavor": "Google"}})
+		if err == nil && len(body) > 0 {
+			return strings.TrimSpace(string(body)), nil
+		}
+	}
+	return "", fmt.Errorf("GCP: no project_id given")
+}
+
+func (c *gcpCollector) collect(ctx context.Context) (*Inventory, error) {
+	if _, err := c.accessToken(ctx); err != nil {
+		return nil, err
+	}
+	project, err := c.projectID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{Account: project}
+	if c.services["storage"] {
+		c.collectBuckets(ctx, inv, project)
+	}
+	if c.services["compute"] {
+		c.collectFirewalls(ctx, inv, project)
+		c.collectInstances(ctx, inv, project)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	regions := map[string]bool{}
+	for _, r := range inv.Resources {
+		if r.Region != "" && r.Region != "global" {
+			regions[r.Region] = true
+		}
+	}
+	for region := range regions {
+		inv.Regions = append(inv.Regions, region)
+	}
+	inv.sort()
+	return inv, nil
+}
+
+func (c *gcpCollector) collectBuckets(ctx context.Context, inv *Inventory, project string) {
+	type bucket struct {
+		Name             string            `json:"name"`
+		Location         string            `json:"location"`
+		Labels           map[string]string `json:"labels"`
+		IAMConfiguration struct {
+			Uniform struct {
+				Enabled bool `json:"enabled"`
+			} `json:"uniformBucketLevelAccess"`
+			PublicAccessPrevention string `json:"publicAccessPrevention"`
+		} `json:"iamConfiguration"`
+		Encryption struct {
+			KMSKey string `json:"defaultKmsKeyName"`
+		} `json:"encryption"`
+		Logging struct {
+			LogBucket string `json:"logBucket"`
+		} `json:"logging"`
+		Versioning struct {
+			Enabled bool `json:"enabled"`
+		} `json:"versioning"`
+		RetentionPolicy *struct {
+			IsLocked bool `json:"isLocked"`
+		} `json:"retentionPolicy"`
+	}
+	var buckets []bucket
+	err := c.pages(ctx, c.storage+"/b?project="+url.QueryEscape(project)+"&maxResults=1000", func(body []byte) error {
+		var page struct {
+			Items []bucket `json:"items"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+		buckets = append(buckets, page.Items...)
+		return nil
+	})
+	if err != nil {
+		inv.fail("storage", "", err)
+		return
+	}
+	names := make([]string, len(buckets))
+	byName := map[string]bucket{}
+	for i, b := range buckets {
+		names[i] = b.Name
+		byName[b.Name] = b
+	}
+	forEach(names, 4, func(name string) {
+		b := byName[name]
+		var policy struct {
+			Bindings []struct {
+				Role    string   `json:"role"`
+				Members []string `json:"members"`
+			} `json:"bindings"`
+		}
+		if err := c.get(ctx, c.storage+"/b/"+url.PathEscape(b.Name)+"/iam", &policy); err != nil {
+			inv.fail("storage", "", fmt.Errorf("bucket %s: %w", b.Name, err))
+			return
+		}
+		publicRoles := []interface{}{}
+		for _, binding := range policy.Bindings {
+			for _, m := range binding.Members {
+				if m == "allUsers" || m == "allAuthenticatedUsers" {
+					publicRoles = append(publicRoles, m+" "+binding.Role)
+				}
+			}
+		}
+		inv.add(CloudResource{
+			ID:     "//storage.googleapis.com/projects/_/buckets/" + b.Name,
+			Type:   "gcp:storage:bucket",
+			Name:   b.Name,
+			Region: strings.ToLower(b.Location),
+			Tags:   b.Labels,
+			Config: map[string]interface{}{
+				"project":                  project,
+				"public_access":            len(publicRoles) > 0 && b.IAMConfiguration.PublicAccessPrevention != "enforced",
+				"public_roles":             publicRoles,
+				"uniform_access":           b.IAMConfiguration.Uniform.Enabled,
+				"public_access_prevention": firstNonEmpty(b.IAMConfiguration.PublicAccessPrevention, "inherited"),
+				"kms_key":                  b.Encryption.KMSKey,
+				"logging_enabled":          b.Logging.LogBucket != "",
+				"versioning":               b.Versioning.Enabled,
+				"retention_locked":         b.RetentionPolicy != nil && b.RetentionPolicy.IsLocked,
+			},
+		})
+	})
+}
+
+func (c *gcpCollector) collectFirewalls(ctx context.Context, inv *Inventory, project string) {
+	err := c.pages(ctx, c.compute+"/projects/"+url.PathEscape(project)+"/global/firewalls", func(body []byte) error {
+		var page struct {
+			Items []struct {
+				ID           string   `json:"id"`
+				Name         string   `json:"name"`
+				Network      string   `json:"network"`
+				Direction    string   `json:"direction"`
+				Disabled     bool     `json:"disabled"`
+				Priority     int      `json:"priority"`
+				SourceRanges []string `json:"sourceRanges"`
+				TargetTags   []string `json:"targetTags"`
+				Allowed      []struct {
+					Protocol string   `json:"IPProtocol"`
+					Ports    []string `json:"ports"`
+				} `json:"allowed"`
+				LogConfig struct {
+					Enable bool `json:"enable"`
+				} `json:"logConfig"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+		for _, fw := range page.Items {
+			var ports []string
+			for _, a := range fw.Allowed {
+				if len(a.Ports) == 0 || a.Protocol == "all" {
+					ports = append(ports, "all")
+				}
+				ports = append(ports, a.Ports...)
+			}
+			open := []interface{}{}
+			if fw.Direction != "EGRESS" && !fw.Disabled {
+				for _, src := range fw.SourceRanges {
+					if openToWorld(src) {
+						for _, p := range ports {
+							open = append(open, p)
+						}
+						break
+					}
+				}
+			}
+			inv.add(CloudResource{
+				ID:     "projects/" + project + "/global/firewalls/" + fw.Name,
+				Type:   "gcp:compute:firewall",
+				Name:   fw.Name,
+				Region: "global",
+				Config: map[string]interface{}{
+					"project":          project,
+					"network":          fw.Network[strings.LastIndex(fw.Network, "/")+1:],
+					"direction":        fw.Direction,
+					"disabled":         fw.Disabled,
+					"priority":         fw.Priority,
+					"source_ranges":    toList(fw.SourceRanges),
+					"target_tags":      toList(fw.TargetTags),
+					"ports":            toList(ports),
+					"open_ports":       open,
+					"admin_ports_open": adminExposed(open),
+					"logging":          fw.LogConfig.Enable,
+				},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		inv.fail("compute", "", fmt.Errorf("firewalls: %w", err))
+	}
+}
+
+func (c *gcpCollector) collectInstances(ctx context.Context, inv *Inventory, project string) {
+	type instance struct {
+		ID              string            `json:"id"`
+		Name            string            `json:"name"`
+		Zone            string            `json:"zone"`
+		Status          string            `json:"status"`
+		Labels          map[string]string `json:"labels"`
+		ServiceAccounts []struct {
+			Email  string   `json:"email"`
+			Scopes []string `json:"scopes"`
+		} `json:"serviceAccounts"`
+		NetworkInterfaces []struct {
+			AccessConfigs []struct {
+				NatIP string `json:"natIP"`
+			} `json:"accessConfigs"`
+		} `json:"networkInterfaces"`
+		ShieldedConfig struct {
+			SecureBoot bool `json:"enableSecureBoot"`
+		} `json:"shieldedInstanceConfig"`
+		Metadata struct {
+			Items []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"items"`
+		} `json:"metadata"`
+	}
+	u := c.compute + "/projects/" + url.PathEscape(project) + "/aggregated/instances"
+	err := c.pages(ctx, u, func(body []byte) error {
+		var page struct {
+			Items map[string]struct {
+				Instances []instance `json:"instances"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+		for _, scoped := range page.Items {
+			for _, in := range scoped.Instances {
+				zone := in.Zone[strings.LastIndex(in.Zone, "/")+1:]
+				sa, fullAccess := "", false
+				if len(in.ServiceAccounts) > 0 {
+					sa = in.ServiceAccounts[0].Email
+					fullAccess = contains(in.ServiceAccounts[0].Scopes, gcpScope)
+				}
+				var publicIPs []string
+				for _, nic := range in.NetworkInterfaces {
+					for _, ac := range nic.AccessConfigs {
+						if ac.NatIP != "" {
+							publicIPs = append(publicIPs, ac.NatIP)
+						}
+					}
+				}
+				metadata := map[string]string{}
+				for _, item := range in.Metadata.Items {
+					metadata[item.Key] = item.Value
+				}
+				inv.add(CloudResource{
+					ID:     "projects/" + project + "/zones/" + zone + "/instances/" + in.Name,
+					Type:   "gcp:compute:instance",
+					Name:   in.Name,
+					Region: zone,
+					Tags:   in.Labels,
+					Config: map[string]interface{}{
+						"project":                 project,
+						"status":                  in.Status,
+						"service_account":         sa,
+						"default_service_account": strings.HasSuffix(sa, "-compute@developer.gserviceaccount.com"),
+						"full_api_access":         fullAccess,
+						"public_ips":              toList(publicIPs),
+						"secure_boot":             in.ShieldedConfig.SecureBoot,
+						"oslogin":                 strings.EqualFold(metadata["enable-oslogin"], "true"),
+						"serial_port":             strings.EqualFold(metadata["serial-port-enable"], "true"),
+					},
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		inv.fail("compute", "", fmt.Errorf("instances: %w", err))
+	}
+}
@@ -4,6 +4,8 @@ package cloud
 import (
 	"fmt"
 	"time"
+
+	"sentra/internal/benchmark"
 )
 
 // GetCloudModule returns a cloud security module instance for the VM
@@ -31,11 +33,17 @@ func CloudScan(cspm interface{}, providerName string) (map[string]interface{}, e
 	if err != nil {
 		return nil, err
 	}
-	
-	// Convert to generic map
+	return ReportToMap(report), nil
+}
+
+// ReportToMap converts a compliance report to the map cloud_scan returns
+func ReportToMap(report *ComplianceReport) map[string]interface{} {
 	result := make(map[string]interface{})
 	result["provider"] = report.Provider
-	result["timestamp"] = report.Timestamp.String()
+	result["account"] = report.Account
+	result["regions"] = toList(report.Regions)
+	result["errors"] = toList(report.Errors)
+	result["timestamp"] = report.Timestamp.Format(time.RFC3339)
 	result["resources"] = report.Resources
 	result["resources_scanned"] = report.Resources  // Add alias for compatibility
 	result["overall_score"] = report.OverallScore
@@ -59,14 +67,7 @@ func CloudScan(cspm interface{}, providerName string) (map[string]interface{}, e
 		// Add findings
 		findings := []interface{}{}
 		for _, f := range pr.Findings {
-			findingMap := make(map[string]interface{})
-			findingMap["id"] = f.ID
-			findingMap["resource_id"] = f.ResourceID
-			findingMap["severity"] = f.Severity
-			findingMap["description"] = f.Description
-			findingMap["remediation"] = f.Remediation
-			findingMap["status"] = f.Status
-			findings = append(findings, findingMap)
+			findings = append(findings, FindingToMap(f))
 		}
 		policyMap["findings"] = findings
 		policies = append(policies, policyMap)
@@ -74,6 +75,60 @@ func CloudScan(cspm interface{}, providerName string) (map[string]interface{}, e
 	result["policy_results"] = policies
 	result["policies"] = policies  // Add alias for compatibility
 	
+	return result
+}
+
+// FindingToMap converts a security finding to the map cloud_findings
+// returns
+func FindingToMap(f SecurityFinding) map[string]interface{} {
+	findingMap := make(map[string]interface{})
+	findingMap["id"] = f.ID
+	findingMap["provider"] = f.Provider
+	findingMap["resource_id"] = f.ResourceID
+	findingMap["resource_type"] = f.ResourceType
+	findingMap["region"] = f.Region
+	findingMap["policy_id"] = f.PolicyID
+	findingMap["rule_id"] = f.RuleID
+	findingMap["severity"] = f.Severity
+	findingMap["description"] = f.Description
+	findingMap["remediation"] = f.Remediation
+	findingMap["status"] = f.Status
+	findingMap["found_at"] = f.FoundAt.Format(time.RFC3339)
+	return findingMap
+}
+
+// ResourceToMap converts a collected resource to the map cloud_resources
+// returns
+func ResourceToMap(r CloudResource) map[string]interface{} {
+	tags := make(map[string]interface{}, len(r.Tags))
+	for k, v := range r.Tags {
+		tags[k] = v
+	}
+	return map[string]interface{}{
+		"id":           r.ID,
+		"type":         r.Type,
+		"name":         r.Name,
+		"region":       r.Region,
+		"tags":         tags,
+		"config":       r.Config,
+		"last_scanned": r.LastScanned.Format(time.RFC3339),
+	}
+}
+
+// CloudResources returns the resources of the last scan of a provider
+func CloudResources(cspm interface{}, providerName string) ([]map[string]interface{}, error) {
+	module, ok := cspm.(*CSPMModule)
+	if !ok {
+		return nil, fmt.Errorf("invalid CSPM module")
+	}
+	resources, err := module.Resources(providerName)
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for _, r := range resources {
+		result = append(result, ResourceToMap(r))
+	}
 	return result, nil
 }
 
@@ -88,17 +143,7 @@ func CloudGetFindings(cspm interface{}, status string) []map[string]interface{}
 	result := []map[string]interface{}{}
 	
 	for _, f := range findings {
-		findingMap := make(map[string]interface{})
-		findingMap["id"] = f.ID
-		findingMap["resource_id"] = f.ResourceID
-		findingMap["policy_id"] = f.PolicyID
-		findingMap["rule_id"] = f.RuleID
-		findingMap["severity"] = f.Severity
-		findingMap["description"] = f.Description
-		findingMap["remediation"] = f.Remediation
-		findingMap["status"] = f.Status
-		findingMap["found_at"] = f.FoundAt.String()
-		result = append(result, findingMap)
+		result = append(result, FindingToMap(f))
 	}
 	
 	return result
@@ -154,18 +199,17 @@ func CloudCostAnalysis(providerName string) map[string]interface{} {
 	return costReport
 }
 
-// CloudBenchmarkRun runs a compliance benchmark
-func CloudBenchmarkRun(provider, benchmark string) map[string]interface{} {
-	// Simulate running a compliance benchmark
-	result := make(map[string]interface{})
-	result["provider"] = provider
-	result["benchmark"] = benchmark
-	result["score"] = 85.5
-	result["passed"] = 170
-	result["failed"] = 30
-	result["skipped"] = 10
-	
-	return result
+// CloudBenchmarkRun runs a compliance benchmark against a provider
+func CloudBenchmarkRun(cspm interface{}, providerName, name string) (map[string]interface{}, error) {
+	module, ok := cspm.(*CSPMModule)
+	if !ok {
+		return nil, fmt.Errorf("invalid CSPM module")
+	}
+	report, err := module.RunBenchmark(providerName, name, benchmark.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return benchmark.ReportToMap(report), nil
 }
 
 // CloudAutoRemediate performs auto-remediation for a finding
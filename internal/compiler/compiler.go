@@ -172,6 +172,19 @@ func (c *Compiler) VisitIndexExpr(expr *parser.IndexExpr) interface{} {
 	return nil
 }
 
+func (c *Compiler) VisitSliceExpr(expr *parser.SliceExpr) interface{} {
+	expr.Object.Accept(c)
+	for _, bound := range []parser.Expr{expr.Start, expr.End} {
+		if bound != nil {
+			bound.Accept(c)
+		} else {
+			c.chunk.WriteOp(bytecode.OpNil)
+		}
+	}
+	c.chunk.WriteOp(bytecode.OpSlice)
+	return nil
+}
+
 func (c *Compiler) VisitSetIndexExpr(expr *parser.SetIndexExpr) interface{} {
 	expr.Object.Accept(c)
 	expr.Index.Accept(c)
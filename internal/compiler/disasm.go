@@ -0,0 +1,73 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"sentra/internal/bytecode"
+)
+
+// String names the function, for disassembly
+func (f *Function) String() string {
+	return "function " + f.Name
+}
+
+// Disassemble writes a listing of a chunk compiled for the stack VM and of
+// every function it defines: each instruction with its source line,
+// operands and jump target, followed by the constant table
+func Disassemble(w io.Writer, chunk *bytecode.Chunk) error {
+	bw := bufio.NewWriter(w)
+	disassembleChunk(bw, &Function{Name: "<script>", Chunk: chunk}, make(map[*Function]bool))
+	return bw.Flush()
+}
+
+func disassembleChunk(w *bufio.Writer, fn *Function, seen map[*Function]bool) {
+	if seen[fn] {
+		return
+	}
+	seen[fn] = true
+	chunk := fn.Chunk
+
+	fmt.Fprintf(w, "function %s (%d params, %d locals, %d bytes, %d constants)\n",
+		fn.Name, fn.Arity, fn.Locals, len(chunk.Code), len(chunk.Constants))
+
+	targets := make(map[int]bool)
+	for offset := 0; offset < len(chunk.Code); {
+		if target, ok := chunk.JumpTarget(offset); ok {
+			targets[target] = true
+		}
+		_, offset = chunk.Instruction(offset)
+	}
+
+	lastLine := 0
+	for offset := 0; offset < len(chunk.Code); {
+		line := ""
+		if l := chunk.GetDebugInfo(offset).Line; l != 0 && l != lastLine {
+			line = strconv.Itoa(l)
+			lastLine = l
+		}
+		mark := " "
+		if targets[offset] {
+			mark = ">"
+		}
+		text, next := chunk.Instruction(offset)
+		fmt.Fprintf(w, "  %5s %s%04d  %s\n", line, mark, offset, text)
+		offset = next
+	}
+
+	if len(chunk.Constants) > 0 {
+		fmt.Fprintf(w, "constants:\n")
+		for i := range chunk.Constants {
+			fmt.Fprintf(w, "  K%-4d %s\n", i, chunk.Constant(i))
+		}
+	}
+
+	for _, k := range chunk.Constants {
+		if sub, ok := k.(*Function); ok {
+			fmt.Fprintln(w)
+			disassembleChunk(w, sub, seen)
+		}
+	}
+}
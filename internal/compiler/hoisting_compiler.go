@@ -3,6 +3,7 @@ package compiler
 
 import (
 	"sentra/internal/bytecode"
+	"sentra/internal/optimizer"
 	"sentra/internal/parser"
 )
 
@@ -33,6 +34,8 @@ func NewHoistingCompilerWithDebug(fileName string) *HoistingCompiler {
 
 // CompileWithHoisting performs two-pass compilation for function hoisting
 func (hc *HoistingCompiler) CompileWithHoisting(stmts []parser.Stmt) *bytecode.Chunk {
+	stmts = optimizer.Optimize(stmts, hc.stmtLines)
+
 	// First pass: Collect all function declarations
 	hc.collectFunctions(stmts)
 	
@@ -41,7 +44,7 @@ func (hc *HoistingCompiler) CompileWithHoisting(stmts []parser.Stmt) *bytecode.C
 	
 	// Second pass: Compile all statements with functions available
 	for _, stmt := range stmts {
-		stmt.Accept(hc)
+		hc.compileStmt(stmt, hc)
 	}
 	
 	hc.emitOp(bytecode.OpReturn)
@@ -131,24 +134,23 @@ func (hc *HoistingCompiler) precompileFunctions() {
 		// Create a new compiler for the function body
 		fnCompiler := NewStmtCompilerWithDebug(hc.FileName)
 		fnCompiler.Chunk = fnChunk
-		fnCompiler.currentFunction = &Function{
-			Name:   name,
-			Arity:  len(fnStmt.Params),
-			Params: fnStmt.Params,
-			Chunk:  fnChunk,
+		fnCompiler.stmtLines = hc.stmtLines
+		fn := &Function{
+			Name:       name,
+			Arity:      len(fnStmt.Params),
+			IsVariadic: fnStmt.Variadic,
+			Params:     fnStmt.Params,
+			Chunk:      fnChunk,
 		}
+		fnCompiler.currentFunction = fn
 		fnCompiler.parent = hc.StmtCompiler
-		
-		// Add parameters as locals
-		for _, param := range fnStmt.Params {
-			fnCompiler.locals = append(fnCompiler.locals, param)
-			fnCompiler.localCount++
-		}
+		fnCompiler.defineParams(fn, fnStmt.Defaults)
 		
 		// Compile the function body
 		for _, stmt := range fnStmt.Body {
-			stmt.Accept(fnCompiler)
+			fnCompiler.compileStmt(stmt, fnCompiler)
 		}
+		hc.Errors = append(hc.Errors, fnCompiler.Errors...)
 		
 		// Add implicit return if not present
 		if len(fnChunk.Code) == 0 || 
@@ -156,14 +158,6 @@ func (hc *HoistingCompiler) precompileFunctions() {
 			fnCompiler.emitOp(bytecode.OpReturn)
 		}
 		
-		// Create function object
-		fn := &Function{
-			Name:   name,
-			Arity:  len(fnStmt.Params),
-			Params: fnStmt.Params,
-			Chunk:  fnChunk,
-		}
-		
 		// Add function object as a constant
 		fnIndex := hc.Chunk.AddConstant(fn)
 		
@@ -2,8 +2,10 @@
 package compiler
 
 import (
+	"fmt"
 	"sentra/internal/bytecode"
 	"sentra/internal/parser"
+	"strings"
 )
 
 type StmtCompiler struct {
@@ -16,13 +18,29 @@ type StmtCompiler struct {
 	localCount      int           // Number of locals
 	parent          *StmtCompiler // Parent compiler for closures
 	knownGlobals    map[string]bool // Known global variables/functions for reference checking
+	stmtLines       map[parser.Stmt]int // Statement lines set by SetLines
+	loops           []*loopContext  // Enclosing loops, innermost last
+	tryDepth        int             // Enclosing try blocks
+	Errors          []error         // Problems found while compiling, such as a break outside a loop
+}
+
+// loopContext tracks a loop being compiled, for break and continue
+type loopContext struct {
+	label         string
+	continueAt    int  // Where continue jumps back to; -1 if it comes after the body
+	iterating     bool // A for-in loop, with an entry on the VM's iteration stack
+	breakJumps    []int
+	continueJumps []int
 }
 
 type Function struct {
-	Name   string
-	Arity  int
-	Params []string
-	Chunk  *bytecode.Chunk
+	Name       string
+	Arity      int
+	Optional   int // Trailing parameters that may be left out
+	IsVariadic bool
+	Params     []string
+	Locals     int // Local slots a call needs, parameters included
+	Chunk      *bytecode.Chunk
 }
 
 func NewStmtCompiler() *StmtCompiler {
@@ -57,13 +75,32 @@ func (c *StmtCompiler) Compile(stmts []interface{}) *bytecode.Chunk {
 	for i, stmt := range stmts {
 		if s, ok := stmt.(parser.Stmt); ok {
 			c.currentLine = i + 1 // Simple line estimation
-			s.Accept(c)
+			c.compileStmt(s, c)
 		}
 	}
 	c.emitOp(bytecode.OpReturn)
 	return c.Chunk
 }
 
+// SetLines makes the compiler record the source line of every statement in
+// the debug info of the chunk, from the statement lines of the parser (see
+// parser.StmtLines)
+func (c *StmtCompiler) SetLines(lines map[parser.Stmt]int) {
+	c.stmtLines = lines
+}
+
+// compileStmt compiles a statement with v, which is c or a compiler that
+// embeds it, and marks the code it emits with its line
+func (c *StmtCompiler) compileStmt(stmt parser.Stmt, v parser.StmtVisitor) interface{} {
+	if line, ok := c.stmtLines[stmt]; ok {
+		// Code after a nested block, like a loop's jump back, belongs to
+		// the enclosing statement
+		defer func(outer int) { c.currentLine, c.Chunk.Line = outer, outer }(c.currentLine)
+		c.currentLine, c.Chunk.Line = line, line
+	}
+	return stmt.Accept(v)
+}
+
 // Helper methods for emitting bytecode with debug info
 func (c *StmtCompiler) emitOp(op bytecode.OpCode) {
 	debug := bytecode.DebugInfo{
@@ -102,9 +139,7 @@ func (c *StmtCompiler) VisitLetStmt(stmt *parser.LetStmt) interface{} {
 	
 	// If we're inside a function, create a local
 	if c.currentFunction != nil && c.currentFunction.Name != "<script>" {
-		c.locals = append(c.locals, stmt.Name)
-		localSlot := c.localCount
-		c.localCount++
+		localSlot := c.addLocal(stmt.Name)
 		// Emit OpSetLocal to store the value in the local slot
 		c.emitOp(bytecode.OpSetLocal)
 		c.emitByte(byte(localSlot))
@@ -162,32 +197,30 @@ func (c *StmtCompiler) VisitExpressionStmt(stmt *parser.ExpressionStmt) interfac
 
 func (c *StmtCompiler) VisitFunctionStmt(stmt *parser.FunctionStmt) interface{} {
 	subCompiler := NewStmtCompiler()
+	subCompiler.stmtLines = c.stmtLines
 	
 	// Initialize locals tracking
 	subCompiler.locals = make([]string, 0, 256)
 	subCompiler.localCount = 0
 
 	function := &Function{
-		Name:   stmt.Name,
-		Arity:  len(stmt.Params),
-		Chunk:  subCompiler.Chunk,
-		Params: stmt.Params,
+		Name:       stmt.Name,
+		Arity:      len(stmt.Params),
+		IsVariadic: stmt.Variadic,
+		Chunk:      subCompiler.Chunk,
+		Params:     stmt.Params,
 	}
 
 	// Set the current function for the subcompiler
 	subCompiler.currentFunction = function
-	
-	// Add parameters as locals
-	for _, param := range stmt.Params {
-		subCompiler.locals = append(subCompiler.locals, param)
-		subCompiler.localCount++
-	}
+	subCompiler.defineParams(function, stmt.Defaults)
 
 	// Compile function body
 	for _, s := range stmt.Body {
-		s.Accept(subCompiler)
+		subCompiler.compileStmt(s, subCompiler)
 	}
 	subCompiler.Chunk.WriteOp(bytecode.OpReturn)
+	c.Errors = append(c.Errors, subCompiler.Errors...)
 
 	idx := c.Chunk.AddConstant(function)
 	c.Chunk.WriteOp(bytecode.OpConstant)
@@ -200,9 +233,51 @@ func (c *StmtCompiler) VisitFunctionStmt(stmt *parser.FunctionStmt) interface{}
 	return nil
 }
 
+// addLocal gives name the next local slot of the current function and
+// returns it. Scopes hand their slots back when they end, so the function
+// records the most it has in use at once.
+func (c *StmtCompiler) addLocal(name string) int {
+	c.locals = append(c.locals, name)
+	slot := c.localCount
+	c.localCount++
+	if c.localCount > c.currentFunction.Locals {
+		c.currentFunction.Locals = c.localCount
+	}
+	return slot
+}
+
+// defineParams adds the parameters of function as its first locals and
+// fills in their defaults when the argument is left out or null. The
+// parameters after the last one that must be passed are optional.
+func (c *StmtCompiler) defineParams(function *Function, defaults []parser.Expr) {
+	required := 0
+	for i, param := range function.Params {
+		c.addLocal(param)
+		rest := function.IsVariadic && i == len(function.Params)-1
+		if !rest && (defaults == nil || defaults[i] == nil) {
+			required = i + 1
+		}
+	}
+	function.Optional = function.Arity - required
+
+	for i, def := range defaults {
+		if def == nil {
+			continue
+		}
+		ifStmt := &parser.IfStmt{
+			Condition: &parser.Binary{Left: &parser.Variable{Name: function.Params[i]}, Operator: "==", Right: &parser.Literal{Value: nil}},
+			Then:      []parser.Stmt{&parser.AssignmentStmt{Name: function.Params[i], Value: def}},
+		}
+		ifStmt.Accept(c)
+	}
+}
+
 func (c *StmtCompiler) VisitReturnStmt(stmt *parser.ReturnStmt) interface{} {
 	if stmt.Value != nil {
 		stmt.Value.Accept(c)
+		if call, ok := stmt.Value.(*parser.CallExpr); ok && len(call.Names) == 0 {
+			c.tailCall()
+		}
 	} else {
 		c.Chunk.WriteOp(bytecode.OpNil)
 	}
@@ -210,6 +285,17 @@ func (c *StmtCompiler) VisitReturnStmt(stmt *parser.ReturnStmt) interface{} {
 	return nil
 }
 
+// tailCall turns the call just compiled into a tail call if it returns
+// from a function outside any try block, where the VM may run the callee in
+// the returning function's frame
+func (c *StmtCompiler) tailCall() {
+	at := len(c.Chunk.Code) - 2
+	if c.currentFunction.Name == "<script>" || c.tryDepth > 0 || at < 0 || bytecode.OpCode(c.Chunk.Code[at]) != bytecode.OpCall {
+		return
+	}
+	c.Chunk.Code[at] = byte(bytecode.OpTailCall)
+}
+
 func (c *StmtCompiler) VisitIfStmt(stmt *parser.IfStmt) interface{} {
 	// Compile condition
 	stmt.Condition.Accept(c)
@@ -222,7 +308,7 @@ func (c *StmtCompiler) VisitIfStmt(stmt *parser.IfStmt) interface{} {
 	
 	// Compile then branch
 	for _, s := range stmt.Then {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
 	
 	// Jump over else branch
@@ -243,7 +329,7 @@ func (c *StmtCompiler) VisitIfStmt(stmt *parser.IfStmt) interface{} {
 	// Compile else branch if present
 	if len(stmt.Else) > 0 {
 		for _, s := range stmt.Else {
-			s.Accept(c)
+			c.compileStmt(s, c)
 		}
 		
 		// Patch jump-over-else offset
@@ -260,32 +346,25 @@ func (c *StmtCompiler) VisitIfStmt(stmt *parser.IfStmt) interface{} {
 
 func (c *StmtCompiler) VisitWhileStmt(stmt *parser.WhileStmt) interface{} {
 	loopStart := len(c.Chunk.Code)
+	loop := c.pushLoop(stmt.Label, loopStart, false)
 	
 	// Compile condition
 	stmt.Condition.Accept(c)
 	
 	// Jump if false (exit loop)
-	c.Chunk.WriteOp(bytecode.OpJumpIfFalse)
-	jumpPos := len(c.Chunk.Code)
-	c.Chunk.WriteByte(0) // Placeholder
-	c.Chunk.WriteByte(0)
+	jumpPos := c.emitJump(bytecode.OpJumpIfFalse)
 	
 	// Compile body
 	for _, s := range stmt.Body {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
 	
 	// Loop back
-	c.Chunk.WriteOp(bytecode.OpLoop)
-	loopOffset := len(c.Chunk.Code) - loopStart + 2 // +2 for the offset bytes
-	c.Chunk.WriteByte(byte(loopOffset >> 8))
-	c.Chunk.WriteByte(byte(loopOffset & 0xff))
+	c.emitLoop(loopStart)
 	
 	// Patch jump offset
-	endPos := len(c.Chunk.Code)
-	jumpOffset := endPos - jumpPos - 2
-	c.Chunk.Code[jumpPos] = byte(jumpOffset >> 8)
-	c.Chunk.Code[jumpPos+1] = byte(jumpOffset & 0xff)
+	c.patchJump(jumpPos)
+	c.popLoop(loop)
 	
 	return nil
 }
@@ -303,10 +382,11 @@ func (c *StmtCompiler) VisitForStmt(stmt *parser.ForStmt) interface{} {
 	
 	// Compile initialization
 	if stmt.Init != nil {
-		stmt.Init.Accept(c)
+		c.compileStmt(stmt.Init, c)
 	}
 	
 	loopStart := len(c.Chunk.Code)
+	loop := c.pushLoop(stmt.Label, -1, false) // continue goes to the update
 	
 	// Compile condition
 	if stmt.Condition != nil {
@@ -319,33 +399,26 @@ func (c *StmtCompiler) VisitForStmt(stmt *parser.ForStmt) interface{} {
 	}
 	
 	// Jump if false (exit loop)
-	c.Chunk.WriteOp(bytecode.OpJumpIfFalse)
-	jumpPos := len(c.Chunk.Code)
-	c.Chunk.WriteByte(0) // Placeholder
-	c.Chunk.WriteByte(0)
+	jumpPos := c.emitJump(bytecode.OpJumpIfFalse)
 	
 	// Compile body
 	for _, s := range stmt.Body {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
 	
 	// Compile update
+	c.setContinue(loop)
 	if stmt.Update != nil {
 		stmt.Update.Accept(c)
 		c.Chunk.WriteOp(bytecode.OpPop) // Pop update result
 	}
 	
 	// Loop back
-	c.Chunk.WriteOp(bytecode.OpLoop)
-	loopOffset := len(c.Chunk.Code) - loopStart + 2 // +2 for the offset bytes
-	c.Chunk.WriteByte(byte(loopOffset >> 8))
-	c.Chunk.WriteByte(byte(loopOffset & 0xff))
+	c.emitLoop(loopStart)
 	
 	// Patch jump offset
-	endPos := len(c.Chunk.Code)
-	jumpOffset := endPos - jumpPos - 2
-	c.Chunk.Code[jumpPos] = byte(jumpOffset >> 8)
-	c.Chunk.Code[jumpPos+1] = byte(jumpOffset & 0xff)
+	c.patchJump(jumpPos)
+	c.popLoop(loop)
 	
 	// Restore the local scope
 	c.localCount = savedLocalCount
@@ -362,71 +435,161 @@ func (c *StmtCompiler) VisitForInStmt(stmt *parser.ForInStmt) interface{} {
 	c.Chunk.WriteOp(bytecode.OpIterStart)
 	
 	loopStart := len(c.Chunk.Code)
+	loop := c.pushLoop(stmt.Label, loopStart, true)
 	
-	// Check if iteration is done
+	// Check if iteration is done. With operand 1, OpIterNext pushes the key
+	// and value before the boolean instead of just the element.
+	pair := stmt.Key != ""
 	c.Chunk.WriteOp(bytecode.OpIterNext)
-	// OpIterNext pushes: element, boolean - we need to handle both
+	if pair {
+		c.Chunk.WriteByte(1)
+	} else {
+		c.Chunk.WriteByte(0)
+	}
 	
 	// Duplicate the boolean for the jump test
 	c.Chunk.WriteOp(bytecode.OpDup) // Stack: element, boolean, boolean
-	c.Chunk.WriteOp(bytecode.OpJumpIfFalse)
-	jumpPos := len(c.Chunk.Code)
-	c.Chunk.WriteByte(0) // Placeholder
-	c.Chunk.WriteByte(0)
+	jumpPos := c.emitJump(bytecode.OpJumpIfFalse)
 	
 	// Pop the boolean, leaving element on stack
 	c.Chunk.WriteOp(bytecode.OpPop) // Stack: element
 	
-	// Store iteration value in variable (use SetGlobal to update existing var)
+	// Store iteration values in variables (use SetGlobal to update existing
+	// vars) and pop them, so the body starts and ends with a clean stack
 	idx := c.Chunk.AddConstant(stmt.Variable)
 	c.Chunk.WriteOp(bytecode.OpSetGlobal)
 	c.Chunk.WriteByte(byte(idx))
+	c.Chunk.WriteOp(bytecode.OpPop)
+	if pair {
+		idx = c.Chunk.AddConstant(stmt.Key)
+		c.Chunk.WriteOp(bytecode.OpSetGlobal)
+		c.Chunk.WriteByte(byte(idx))
+		c.Chunk.WriteOp(bytecode.OpPop)
+	}
 	
 	// Compile body
 	for _, s := range stmt.Body {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
 	
 	// Loop back
-	c.Chunk.WriteOp(bytecode.OpLoop)
-	loopOffset := len(c.Chunk.Code) - loopStart + 2 // +2 for the offset bytes
-	c.Chunk.WriteByte(byte(loopOffset >> 8))
-	c.Chunk.WriteByte(byte(loopOffset & 0xff))
+	c.emitLoop(loopStart)
 	
 	// Jump target for loop end - clean up stack
-	endPos := len(c.Chunk.Code)
-	jumpOffset := endPos - jumpPos - 2
-	c.Chunk.Code[jumpPos] = byte(jumpOffset >> 8)
-	c.Chunk.Code[jumpPos+1] = byte(jumpOffset & 0xff)
+	c.patchJump(jumpPos)
 	
 	// Pop the boolean that caused the jump (false)
 	c.Chunk.WriteOp(bytecode.OpPop)
-	// Pop the element that was left on stack
+	// Pop the dummy element (and value) pushed at the end
 	c.Chunk.WriteOp(bytecode.OpPop)
+	if pair {
+		c.Chunk.WriteOp(bytecode.OpPop)
+	}
 	
-	// End iteration - clean up iteration state
+	// End iteration - clean up iteration state. A break lands here too.
+	c.popLoop(loop)
 	c.Chunk.WriteOp(bytecode.OpIterEnd)
 	
 	return nil
 }
 
+// pushLoop starts tracking a loop for break and continue. continueAt is
+// where continue jumps back to, or -1 when that code follows the body and
+// setContinue marks it.
+func (c *StmtCompiler) pushLoop(label string, continueAt int, iterating bool) *loopContext {
+	loop := &loopContext{label: label, continueAt: continueAt, iterating: iterating}
+	c.loops = append(c.loops, loop)
+	return loop
+}
+
+// setContinue makes the current position the continue target of loop
+func (c *StmtCompiler) setContinue(loop *loopContext) {
+	loop.continueAt = len(c.Chunk.Code)
+	for _, pos := range loop.continueJumps {
+		c.patchJump(pos)
+	}
+	loop.continueJumps = nil
+}
+
+// popLoop patches the loop's break jumps to the current position and
+// stops tracking it
+func (c *StmtCompiler) popLoop(loop *loopContext) {
+	for _, pos := range loop.breakJumps {
+		c.patchJump(pos)
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+// targetLoop finds the loop a break or continue refers to, the innermost
+// or the one with the given label, and ends the iterations of the for-in
+// loops between here and there
+func (c *StmtCompiler) targetLoop(keyword, label string) *loopContext {
+	target := -1
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		if label == "" || c.loops[i].label == label {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		if label == "" {
+			c.Errors = append(c.Errors, fmt.Errorf("%s outside of loop", keyword))
+		} else {
+			c.Errors = append(c.Errors, fmt.Errorf("%s to unknown loop label '%s'", keyword, label))
+		}
+		return nil
+	}
+	for i := len(c.loops) - 1; i > target; i-- {
+		if c.loops[i].iterating {
+			c.Chunk.WriteOp(bytecode.OpIterEnd)
+		}
+	}
+	return c.loops[target]
+}
+
+// emitJump writes a forward jump and returns the position of its offset
+// for patchJump
+func (c *StmtCompiler) emitJump(op bytecode.OpCode) int {
+	c.Chunk.WriteOp(op)
+	pos := len(c.Chunk.Code)
+	c.Chunk.WriteByte(0) // Placeholder
+	c.Chunk.WriteByte(0)
+	return pos
+}
+
+// patchJump points the jump whose offset is at pos to the current position
+func (c *StmtCompiler) patchJump(pos int) {
+	jumpOffset := len(c.Chunk.Code) - pos - 2
+	c.Chunk.Code[pos] = byte(jumpOffset >> 8)
+	c.Chunk.Code[pos+1] = byte(jumpOffset & 0xff)
+}
+
+// emitLoop writes a jump back to start
+func (c *StmtCompiler) emitLoop(start int) {
+	c.Chunk.WriteOp(bytecode.OpLoop)
+	loopOffset := len(c.Chunk.Code) - start + 2 // +2 for the offset bytes
+	c.Chunk.WriteByte(byte(loopOffset >> 8))
+	c.Chunk.WriteByte(byte(loopOffset & 0xff))
+}
+
 func (c *StmtCompiler) VisitBreakStmt(stmt *parser.BreakStmt) interface{} {
-	// For now, just emit a no-op with nil
-	// Proper implementation requires loop context tracking
-	idx := c.Chunk.AddConstant(nil)
-	c.Chunk.WriteOp(bytecode.OpConstant)
-	c.Chunk.WriteByte(byte(idx))
-	c.Chunk.WriteOp(bytecode.OpPop)
+	if loop := c.targetLoop("break", stmt.Label); loop != nil {
+		loop.breakJumps = append(loop.breakJumps, c.emitJump(bytecode.OpJump))
+	}
 	return nil
 }
 
 func (c *StmtCompiler) VisitContinueStmt(stmt *parser.ContinueStmt) interface{} {
-	// For now, just emit a no-op with nil
-	// Proper implementation requires loop context tracking
-	idx := c.Chunk.AddConstant(nil)
-	c.Chunk.WriteOp(bytecode.OpConstant)
-	c.Chunk.WriteByte(byte(idx))
-	c.Chunk.WriteOp(bytecode.OpPop)
+	loop := c.targetLoop("continue", stmt.Label)
+	if loop == nil {
+		return nil
+	}
+	if loop.continueAt < 0 {
+		// A for loop's update is not compiled yet
+		loop.continueJumps = append(loop.continueJumps, c.emitJump(bytecode.OpJump))
+	} else {
+		c.emitLoop(loop.continueAt)
+	}
 	return nil
 }
 
@@ -435,6 +598,21 @@ func (c *StmtCompiler) VisitImportStmt(stmt *parser.ImportStmt) interface{} {
 	c.Chunk.WriteOp(bytecode.OpImport)
 	c.Chunk.WriteByte(byte(idx))
 	
+	// Selective import: copy each requested export into its own global
+	if len(stmt.Names) > 0 {
+		for _, n := range stmt.Names {
+			keyIdx := c.Chunk.AddConstant(n.Name)
+			c.Chunk.WriteOp(bytecode.OpImportName)
+			c.Chunk.WriteByte(byte(idx))
+			c.Chunk.WriteByte(byte(keyIdx))
+			nameIdx := c.Chunk.AddConstant(n.LocalName())
+			c.Chunk.WriteOp(bytecode.OpDefineGlobal)
+			c.Chunk.WriteByte(byte(nameIdx))
+		}
+		c.Chunk.WriteOp(bytecode.OpPop)
+		return nil
+	}
+	
 	// Store the module in a global variable
 	// Use alias if provided, otherwise use the module path as the name
 	varName := stmt.Alias
@@ -451,7 +629,7 @@ func (c *StmtCompiler) VisitImportStmt(stmt *parser.ImportStmt) interface{} {
 
 func (c *StmtCompiler) VisitExportStmt(stmt *parser.ExportStmt) interface{} {
 	// First compile the statement being exported
-	stmt.Stmt.Accept(c)
+	c.compileStmt(stmt.Stmt, c)
 	
 	// For exported functions and variables, we need to get their value
 	switch s := stmt.Stmt.(type) {
@@ -480,7 +658,8 @@ func (c *StmtCompiler) VisitExportStmt(stmt *parser.ExportStmt) interface{} {
 }
 
 func (c *StmtCompiler) VisitClassStmt(stmt *parser.ClassStmt) interface{} {
-	// TODO: Implement class compilation
+	// Methods are closures over the instance, which this VM cannot create
+	c.Errors = append(c.Errors, fmt.Errorf("line %d: struct %s: structs require the register VM, not --oldvm", stmt.Line, stmt.Name))
 	return nil
 }
 
@@ -492,9 +671,14 @@ func (c *StmtCompiler) VisitTryStmt(stmt *parser.TryStmt) interface{} {
 	c.Chunk.WriteByte(0)
 	
 	// Compile try block
+	c.tryDepth++
 	for _, s := range stmt.TryBlock {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
+	c.tryDepth--
+	
+	// The try block finished without an error, so leave its handler
+	c.Chunk.WriteOp(bytecode.OpCatch)
 	
 	// Jump over catch block if no error
 	c.Chunk.WriteOp(bytecode.OpJump)
@@ -502,9 +686,9 @@ func (c *StmtCompiler) VisitTryStmt(stmt *parser.TryStmt) interface{} {
 	c.Chunk.WriteByte(0)
 	c.Chunk.WriteByte(0)
 	
-	// Patch catch offset
+	// Patch catch offset, which the VM counts from the OpTry instruction
 	catchStart := len(c.Chunk.Code)
-	catchOffset := catchStart - catchPos - 2
+	catchOffset := catchStart - (catchPos - 1)
 	c.Chunk.Code[catchPos] = byte(catchOffset >> 8)
 	c.Chunk.Code[catchPos+1] = byte(catchOffset & 0xff)
 	
@@ -517,7 +701,7 @@ func (c *StmtCompiler) VisitTryStmt(stmt *parser.TryStmt) interface{} {
 	}
 	
 	for _, s := range stmt.CatchBlock {
-		s.Accept(c)
+		c.compileStmt(s, c)
 	}
 	
 	// Patch jump offset
@@ -529,7 +713,7 @@ func (c *StmtCompiler) VisitTryStmt(stmt *parser.TryStmt) interface{} {
 	// Compile finally block if present
 	if len(stmt.FinallyBlock) > 0 {
 		for _, s := range stmt.FinallyBlock {
-			s.Accept(c)
+			c.compileStmt(s, c)
 		}
 	}
 	
@@ -542,74 +726,51 @@ func (c *StmtCompiler) VisitThrowStmt(stmt *parser.ThrowStmt) interface{} {
 	return nil
 }
 
+// VisitMatchStmt compiles a match as a chain of comparisons. The value
+// stays on the stack while the patterns are tested and is popped before
+// the chosen arm runs, or at the end when no arm matches.
 func (c *StmtCompiler) VisitMatchStmt(stmt *parser.MatchStmt) interface{} {
 	// Evaluate the value to match
 	stmt.Value.Accept(c)
 	
-	// Keep track of jump addresses
 	var endJumps []int
-	
-	for i, matchCase := range stmt.Cases {
-		// Check if this is the default case (_)
-		isDefault := false
-		if lit, ok := matchCase.Pattern.(*parser.Literal); ok {
-			if str, ok := lit.Value.(string); ok && str == "_" {
-				isDefault = true
-			}
-		}
-		
-		var jumpToNext int
-		if !isDefault {
-			// Duplicate the value for comparison
+	for _, matchCase := range stmt.Cases {
+		var bodyJumps []int
+		nextCase := -1
+		for i, pattern := range matchCase.Patterns {
+			// Compare a copy of the value with the pattern
 			c.Chunk.WriteOp(bytecode.OpDup)
-			
-			// Compile the pattern
-			matchCase.Pattern.Accept(c)
-			
-			// Compare with the value
+			pattern.Accept(c)
 			c.Chunk.WriteOp(bytecode.OpEqual)
-			
-			// Jump to next case if not equal
-			c.Chunk.WriteOp(bytecode.OpJumpIfFalse)
-			jumpToNext = len(c.Chunk.Code)
-			c.Chunk.WriteByte(0) // Placeholder
-			c.Chunk.WriteByte(0)
-			
-			// Pop the comparison result
-			c.Chunk.WriteOp(bytecode.OpPop)
+			if i < len(matchCase.Patterns)-1 {
+				// Equal: go to the body, otherwise try the next pattern
+				c.Chunk.WriteOp(bytecode.OpNot)
+				bodyJumps = append(bodyJumps, c.emitJump(bytecode.OpJumpIfFalse))
+			} else {
+				nextCase = c.emitJump(bytecode.OpJumpIfFalse)
+			}
 		}
-		
-		// Compile the case body
-		for _, s := range matchCase.Body {
-			s.Accept(c)
+		for _, pos := range bodyJumps {
+			c.patchJump(pos)
 		}
 		
-		// Jump to end after executing the case
-		if i < len(stmt.Cases)-1 {
-			c.Chunk.WriteOp(bytecode.OpJump)
-			endJumpAddr := len(c.Chunk.Code)
-			c.Chunk.WriteByte(0) // Placeholder
-			c.Chunk.WriteByte(0)
-			endJumps = append(endJumps, endJumpAddr)
+		// Matched: drop the value and run the case body
+		c.Chunk.WriteOp(bytecode.OpPop)
+		for _, s := range matchCase.Body {
+			c.compileStmt(s, c)
 		}
+		endJumps = append(endJumps, c.emitJump(bytecode.OpJump))
 		
-		// Patch the jump to next case
-		if !isDefault && jumpToNext > 0 {
-			jumpOffset := len(c.Chunk.Code) - jumpToNext - 2
-			c.Chunk.Code[jumpToNext] = byte(jumpOffset >> 8)
-			c.Chunk.Code[jumpToNext+1] = byte(jumpOffset)
+		if nextCase >= 0 {
+			c.patchJump(nextCase)
 		}
 	}
 	
-	// Pop the original value at the end
+	// No case matched
 	c.Chunk.WriteOp(bytecode.OpPop)
 	
-	// Patch all end jumps
-	endAddr := len(c.Chunk.Code)
-	for _, endJump := range endJumps {
-		jumpOffset := endAddr - endJump - 2
-		c.Chunk.Code[endJump] = byte(jumpOffset >> 8)
-		c.Chunk.Code[endJump+1] = byte(jumpOffset)
+	for _, pos := range endJumps {
+		c.patchJump(pos)
 	}
 	
 	return nil
@@ -617,7 +778,13 @@ func (c *StmtCompiler) VisitMatchStmt(stmt *parser.MatchStmt) interface{} {
 
 // Expression visitors
 func (c *StmtCompiler) VisitLiteralExpr(expr *parser.Literal) interface{} {
-	idx := c.Chunk.AddConstant(expr.Value)
+	value := expr.Value
+	// The parser keeps whole numbers as int64, but every number in this
+	// VM is a float64
+	if n, ok := value.(int64); ok {
+		value = float64(n)
+	}
+	idx := c.Chunk.AddConstant(value)
 	c.Chunk.WriteOp(bytecode.OpConstant)
 	c.Chunk.WriteByte(byte(idx))
 	return nil
@@ -692,6 +859,13 @@ func (c *StmtCompiler) VisitCallExpr(expr *parser.CallExpr) interface{} {
 	}
 	// Compile callee (leaves function on stack)
 	expr.Callee.Accept(c)
+	if len(expr.Names) > 0 {
+		names := c.Chunk.AddConstant(strings.Join(expr.Names, ","))
+		c.Chunk.WriteOp(bytecode.OpCallKw)
+		c.Chunk.WriteByte(byte(len(expr.Args)))
+		c.Chunk.WriteByte(byte(names))
+		return nil
+	}
 	// Emit OpCall with arg count
 	c.Chunk.WriteOp(bytecode.OpCall)
 	c.Chunk.WriteByte(byte(len(expr.Args)))
@@ -741,7 +915,7 @@ func (c *StmtCompiler) VisitIfExpr(expr *parser.IfExpr) interface{} {
 func (c *StmtCompiler) VisitBlockExpr(expr *parser.BlockExpr) interface{} {
 	var result interface{}
 	for _, stmt := range expr.Stmts {
-		result = stmt.Accept(c)
+		result = c.compileStmt(stmt, c)
 	}
 	return result
 }
@@ -771,11 +945,44 @@ func (c *StmtCompiler) VisitMapExpr(expr *parser.MapExpr) interface{} {
 
 func (c *StmtCompiler) VisitIndexExpr(expr *parser.IndexExpr) interface{} {
 	expr.Object.Accept(c)
+	end := -1
+	if expr.Optional {
+		end = c.emitNullJump()
+	}
 	expr.Index.Accept(c)
 	c.Chunk.WriteOp(bytecode.OpIndex)
+	if end >= 0 {
+		c.patchJump(end)
+	}
+	return nil
+}
+
+func (c *StmtCompiler) VisitSliceExpr(expr *parser.SliceExpr) interface{} {
+	expr.Object.Accept(c)
+	for _, bound := range []parser.Expr{expr.Start, expr.End} {
+		if bound != nil {
+			bound.Accept(c)
+		} else {
+			c.Chunk.WriteOp(bytecode.OpNil)
+		}
+	}
+	c.Chunk.WriteOp(bytecode.OpSlice)
 	return nil
 }
 
+// emitNullJump starts a null-safe access of the value on top of the
+// stack. It returns a jump, taken with the null value left as the result,
+// for the caller to patch past the access.
+func (c *StmtCompiler) emitNullJump() int {
+	c.Chunk.WriteOp(bytecode.OpDup)
+	c.Chunk.WriteOp(bytecode.OpNil)
+	c.Chunk.WriteOp(bytecode.OpEqual)
+	notNull := c.emitJump(bytecode.OpJumpIfFalse)
+	end := c.emitJump(bytecode.OpJump)
+	c.patchJump(notNull)
+	return end
+}
+
 func (c *StmtCompiler) VisitSetIndexExpr(expr *parser.SetIndexExpr) interface{} {
 	expr.Object.Accept(c)
 	expr.Index.Accept(c)
@@ -797,6 +1004,17 @@ func (c *StmtCompiler) VisitUnaryExpr(expr *parser.UnaryExpr) interface{} {
 
 func (c *StmtCompiler) VisitLogicalExpr(expr *parser.LogicalExpr) interface{} {
 	expr.Left.Accept(c)
+	if expr.Operator == "??" {
+		// Keep left unless it is null
+		c.Chunk.WriteOp(bytecode.OpDup)
+		c.Chunk.WriteOp(bytecode.OpNil)
+		c.Chunk.WriteOp(bytecode.OpEqual)
+		end := c.emitJump(bytecode.OpJumpIfFalse)
+		c.Chunk.WriteOp(bytecode.OpPop)
+		expr.Right.Accept(c)
+		c.patchJump(end)
+		return nil
+	}
 	expr.Right.Accept(c)
 	switch expr.Operator {
 	case "&&":
@@ -820,6 +1038,7 @@ func (c *StmtCompiler) VisitInterpolationExpr(expr *parser.InterpolationExpr) in
 func (c *StmtCompiler) VisitLambdaExpr(expr *parser.LambdaExpr) interface{} {
 	// Create a new chunk for the lambda
 	subCompiler := NewStmtCompiler()
+	subCompiler.stmtLines = c.stmtLines
 	subCompiler.parent = c // Set parent for closure support
 	
 	// Initialize locals tracking
@@ -827,26 +1046,22 @@ func (c *StmtCompiler) VisitLambdaExpr(expr *parser.LambdaExpr) interface{} {
 	subCompiler.localCount = 0
 	
 	function := &Function{
-		Name:   "<lambda>",
-		Arity:  len(expr.Params),
-		Chunk:  subCompiler.Chunk,
-		Params: expr.Params,
+		Name:       "<lambda>",
+		Arity:      len(expr.Params),
+		IsVariadic: expr.Variadic,
+		Chunk:      subCompiler.Chunk,
+		Params:     expr.Params,
 	}
 	
 	// Set the current function for the subcompiler
 	subCompiler.currentFunction = function
-	
-	// Add parameters as locals
-	for _, param := range expr.Params {
-		subCompiler.locals = append(subCompiler.locals, param)
-		subCompiler.localCount++
-	}
+	subCompiler.defineParams(function, expr.Defaults)
 	
 	// Compile the body
 	if blockExpr, ok := expr.Body.(*parser.BlockExpr); ok {
 		// Block body - compile statements
 		for _, stmt := range blockExpr.Stmts {
-			stmt.Accept(subCompiler)
+			subCompiler.compileStmt(stmt, subCompiler)
 		}
 		subCompiler.Chunk.WriteOp(bytecode.OpReturn)
 	} else {
@@ -854,6 +1069,7 @@ func (c *StmtCompiler) VisitLambdaExpr(expr *parser.LambdaExpr) interface{} {
 		expr.Body.Accept(subCompiler)
 		subCompiler.Chunk.WriteOp(bytecode.OpReturn)
 	}
+	c.Errors = append(c.Errors, subCompiler.Errors...)
 	
 	// Push the function as a constant
 	idx := c.Chunk.AddConstant(function)
@@ -865,10 +1081,17 @@ func (c *StmtCompiler) VisitLambdaExpr(expr *parser.LambdaExpr) interface{} {
 
 func (c *StmtCompiler) VisitPropertyExpr(expr *parser.PropertyExpr) interface{} {
 	expr.Object.Accept(c)
+	end := -1
+	if expr.Optional {
+		end = c.emitNullJump()
+	}
 	idx := c.Chunk.AddConstant(expr.Property)
 	c.Chunk.WriteOp(bytecode.OpConstant)
 	c.Chunk.WriteByte(byte(idx))
 	c.Chunk.WriteOp(bytecode.OpIndex)
+	if end >= 0 {
+		c.patchJump(end)
+	}
 	return nil
 }
 
@@ -2,6 +2,9 @@ package compregister
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sentra/internal/optimizer"
 	"sentra/internal/parser"
 	"sentra/internal/vmregister"
 )
@@ -29,14 +32,35 @@ type Compiler struct {
 	// Loop management (for break/continue)
 	loopStack []LoopInfo
 
+	// Set while compiling a struct method, whose closure holds the
+	// instance as upvalue 0
+	inMethod bool
+
+	// Set in a function body outside any try block, where a returned call
+	// can run in the returning function's frame
+	tailCalls bool
+
 	// Error tracking
 	errors []error
+
+	// Coverage instrumentation (nil when disabled)
+	coverage     *vmregister.Coverage
+	coverageFile string
+	stmtLines    map[parser.Stmt]int
+
+	// Source line of each instruction in code, kept when stmtLines is set
+	lines []int32
+	line  int32
 }
 
 // LoopInfo tracks loop state for break/continue
 type LoopInfo struct {
-	startPC    int
-	breakJumps []int // PCs of break jumps to patch
+	label         string // Name from `label: for ...`, if any
+	startPC       int
+	continuePC    int   // Where continue jumps to; -1 until it is emitted
+	iterReg       int   // Iterator register of a for-in loop, or -1
+	breakJumps    []int // PCs of break jumps to patch
+	continueJumps []int // PCs of forward continue jumps to patch
 }
 
 // Scope tracks local variables
@@ -143,9 +167,22 @@ func (c *Compiler) findConsecutiveRegisters(n int) int {
 
 // Compile compiles statements to a FunctionObj
 func (c *Compiler) Compile(stmts []parser.Stmt) (*vmregister.FunctionObj, error) {
-	// Compile all statements
+	// Coverage and the debugger need every statement as written
+	if c.coverage == nil {
+		stmts = optimizer.Optimize(stmts, c.stmtLines)
+	}
+
+	// Top level functions are hoisted, so a script can call a function
+	// defined further down
 	for _, stmt := range stmts {
-		c.compileStmt(stmt)
+		if _, ok := stmt.(*parser.FunctionStmt); ok {
+			c.compileStmt(stmt)
+		}
+	}
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*parser.FunctionStmt); !ok {
+			c.compileStmt(stmt)
+		}
 	}
 
 	// Add implicit return nil
@@ -162,6 +199,7 @@ func (c *Compiler) Compile(stmts []parser.Stmt) (*vmregister.FunctionObj, error)
 		Name:      "<main>",
 		Arity:     0,
 		Code:      c.code,
+		Lines:     c.lines,
 		Constants: c.constants,
 	}
 
@@ -172,6 +210,9 @@ func (c *Compiler) Compile(stmts []parser.Stmt) (*vmregister.FunctionObj, error)
 func (c *Compiler) emit(instr vmregister.Instruction) int {
 	pos := len(c.code)
 	c.code = append(c.code, instr)
+	if c.stmtLines != nil {
+		c.lines = append(c.lines, c.line)
+	}
 	return pos
 }
 
@@ -309,8 +350,15 @@ func (c *Compiler) evalConstantExpr(expr parser.Expr) (interface{}, bool) {
 				return float64(int64(lf) % int64(rf)), true
 			}
 		case "==":
+			// 2 + 2 folds to a float, so numbers compare by value
+			if lfok && rfok {
+				return lf == rf, true
+			}
 			return left == right, true
 		case "!=":
+			if lfok && rfok {
+				return lf != rf, true
+			}
 			return left != right, true
 		case "<":
 			if lfok && rfok {
@@ -367,6 +415,24 @@ func (c *Compiler) resolveLocal(name string) int {
 	return -1 // Global
 }
 
+// visibleLocals lists the locals in scope, innermost first, for debuggers
+func (c *Compiler) visibleLocals() []vmregister.LocalVar {
+	var locals []vmregister.LocalVar
+	seen := make(map[string]bool)
+	for scope := c.scope; scope != nil && scope.depth > 0; scope = scope.parent {
+		start := len(locals)
+		for name, reg := range scope.locals {
+			if !seen[name] {
+				seen[name] = true
+				locals = append(locals, vmregister.LocalVar{Name: name, Reg: reg})
+			}
+		}
+		// Declaration order within a scope
+		sort.Slice(locals[start:], func(i, j int) bool { return locals[start+i].Reg < locals[start+j].Reg })
+	}
+	return locals
+}
+
 // pushScope creates a new scope
 func (c *Compiler) pushScope() {
 	c.scope = &Scope{
@@ -392,8 +458,44 @@ func (c *Compiler) error(msg string) {
 	c.errors = append(c.errors, fmt.Errorf("compile error: %s", msg))
 }
 
+// EnableCoverage makes the compiler emit an OP_COVER probe before every
+// statement with a known line (see parser.StmtLines)
+func (c *Compiler) EnableCoverage(cov *vmregister.Coverage, file string, lines map[parser.Stmt]int) {
+	c.coverage = cov
+	c.coverageFile = file
+	c.stmtLines = lines
+}
+
+// SetLines makes the compiler record the source line of every instruction
+// in FunctionObj.Lines, from the statement lines of the parser (see
+// parser.StmtLines). EnableCoverage records them too.
+func (c *Compiler) SetLines(lines map[parser.Stmt]int) {
+	c.stmtLines = lines
+}
+
 // compileStmt compiles a statement
 func (c *Compiler) compileStmt(stmt parser.Stmt) {
+	if line, ok := c.stmtLines[stmt]; ok {
+		// Code after a nested block, like a loop's jump back, belongs to
+		// the enclosing statement
+		defer func(outer int32) { c.line = outer }(c.line)
+		c.line = int32(line)
+	}
+	if c.coverage != nil {
+		if line, ok := c.stmtLines[stmt]; ok {
+			var id uint16
+			var ok bool
+			if c.coverage.Debug {
+				id, ok = c.coverage.StatementProbe(c.coverageFile, line, c.visibleLocals())
+			} else {
+				id, ok = c.coverage.Probe(c.coverageFile, line)
+			}
+			if ok {
+				c.emit(vmregister.CreateABx(vmregister.OP_COVER, 0, id))
+			}
+		}
+	}
+
 	switch s := stmt.(type) {
 	case *parser.PrintStmt:
 		c.compilePrintStmt(s)
@@ -661,21 +763,24 @@ func (c *Compiler) compileExpressionStmt(s *parser.ExpressionStmt) {
 func (c *Compiler) compileFunctionStmt(s *parser.FunctionStmt) {
 	// Save current compilation state
 	parentCode := c.code
+	parentLines := c.lines
 	parentConsts := c.constants
 	parentAllocator := c.allocator
+	parentLoops := c.loopStack
+	parentTailCalls := c.tailCalls
 
 	// Create new compilation state for function
 	c.code = make([]vmregister.Instruction, 0)
+	c.lines = nil
 	c.constants = make([]vmregister.Value, 0)
 	c.allocator = NewRegisterAllocator()
+	c.loopStack = nil // break and continue cannot reach loops outside the body
+	c.tailCalls = true
 
 	// Create scope for function
 	c.pushScope()
 
-	// Define parameters as locals
-	for _, param := range s.Params {
-		c.defineLocal(param)
-	}
+	c.defineParams(s.Params, s.Defaults)
 
 	// Compile function body
 	for _, stmt := range s.Body {
@@ -688,10 +793,14 @@ func (c *Compiler) compileFunctionStmt(s *parser.FunctionStmt) {
 	// Create function object
 	fn := &vmregister.FunctionObj{
 		Object:    vmregister.Object{Type: vmregister.OBJ_FUNCTION},
-		Name:      s.Name,
-		Arity:     len(s.Params),
-		Code:      c.code,
-		Constants: c.constants,
+		Name:       s.Name,
+		Arity:      len(s.Params),
+		Params:     s.Params,
+		Code:       c.code,
+		Lines:      c.lines,
+		Constants:  c.constants,
+		Upvalues:   c.selfUpvalues(),
+		IsVariadic: s.Variadic,
 	}
 
 	// Pop function scope
@@ -699,8 +808,11 @@ func (c *Compiler) compileFunctionStmt(s *parser.FunctionStmt) {
 
 	// Restore parent compilation state
 	c.code = parentCode
+	c.lines = parentLines
 	c.constants = parentConsts
 	c.allocator = parentAllocator
+	c.loopStack = parentLoops
+	c.tailCalls = parentTailCalls
 
 	// Add function to constants and create closure
 	fnIdx := c.addConstant(vmregister.BoxFunction(fn))
@@ -719,10 +831,38 @@ func (c *Compiler) compileFunctionStmt(s *parser.FunctionStmt) {
 	}
 }
 
+// defineParams defines the parameters of the function being compiled as
+// locals, in order, and fills in their defaults:
+//
+//	if port == null { port = 443 }  ; for each default
+func (c *Compiler) defineParams(params []string, defaults []parser.Expr) []int {
+	regs := make([]int, len(params))
+	for i, param := range params {
+		regs[i] = c.defineLocal(param)
+	}
+	for i, def := range defaults {
+		if def == nil {
+			continue
+		}
+		c.compileIfStmt(&parser.IfStmt{
+			Condition: &parser.Binary{Left: &parser.Variable{Name: params[i]}, Operator: "==", Right: &parser.Literal{Value: nil}},
+			Then:      []parser.Stmt{&parser.AssignmentStmt{Name: params[i], Value: def}},
+		})
+	}
+	return regs
+}
+
 // compileReturnStmt compiles a return statement
 func (c *Compiler) compileReturnStmt(s *parser.ReturnStmt) {
 	if s.Value != nil {
 		reg := c.compileExpr(s.Value)
+		if call, ok := s.Value.(*parser.CallExpr); ok && len(call.Names) == 0 && c.tailCalls {
+			// The call just compiled becomes a tail call
+			last := len(c.code) - 1
+			if instr := c.code[last]; instr.OpCode() == vmregister.OP_CALL && int(instr.A()) == reg {
+				c.code[last] = vmregister.CreateABC(vmregister.OP_TAILCALL, instr.A(), instr.B(), instr.C())
+			}
+		}
 		c.emit(vmregister.CreateABC(vmregister.OP_RETURN, uint8(reg), 2, 0))
 		c.allocator.Free(reg)
 	} else {
@@ -891,7 +1031,7 @@ func (c *Compiler) compileWhileStmt(s *parser.WhileStmt) {
 	// OPTIMIZATION: Detect comparison conditions and use fused comparison-jump
 	// This hoists constant loads outside the loop and uses LTJ/LEJ/etc.
 	if binary, ok := s.Condition.(*parser.Binary); ok {
-		if c.tryCompileOptimizedWhile(binary, s.Body) {
+		if c.tryCompileOptimizedWhile(binary, s.Body, s.Label) {
 			return
 		}
 	}
@@ -899,10 +1039,7 @@ func (c *Compiler) compileWhileStmt(s *parser.WhileStmt) {
 	loopStart := len(c.code)
 
 	// Push loop info for break/continue
-	c.loopStack = append(c.loopStack, LoopInfo{
-		startPC:    loopStart,
-		breakJumps: make([]int, 0),
-	})
+	c.pushLoop(s.Label, loopStart, loopStart, -1)
 
 	// Compile condition
 	condReg := c.compileExpr(s.Condition)
@@ -926,20 +1063,14 @@ func (c *Compiler) compileWhileStmt(s *parser.WhileStmt) {
 	// Patch exit jump
 	c.patchJump(exitJump)
 
-	// Patch break jumps
-	loopInfo := c.loopStack[len(c.loopStack)-1]
-	for _, breakPC := range loopInfo.breakJumps {
-		c.patchJumpAt(breakPC)
-	}
-
-	// Pop loop info
-	c.loopStack = c.loopStack[:len(c.loopStack)-1]
+	// Patch break jumps and pop loop info
+	c.popLoop()
 }
 
 // tryCompileOptimizedWhile tries to compile an optimized while loop
 // Hoists constant loads outside the loop for better performance
 // Returns true if optimization was applied
-func (c *Compiler) tryCompileOptimizedWhile(cond *parser.Binary, body []parser.Stmt) bool {
+func (c *Compiler) tryCompileOptimizedWhile(cond *parser.Binary, body []parser.Stmt, label string) bool {
 	// Detect comparison operators
 	var cmpOp vmregister.OpCode
 	switch cond.Operator {
@@ -992,10 +1123,7 @@ func (c *Compiler) tryCompileOptimizedWhile(cond *parser.Binary, body []parser.S
 	loopStart := len(c.code)
 
 	// Push loop info for break/continue
-	c.loopStack = append(c.loopStack, LoopInfo{
-		startPC:    loopStart,
-		breakJumps: make([]int, 0),
-	})
+	c.pushLoop(label, loopStart, loopStart, -1)
 
 	// Emit comparison: result = left cmpOp limit
 	c.emit(vmregister.CreateABC(cmpOp, uint8(resultReg), uint8(leftReg), uint8(limitReg)))
@@ -1018,14 +1146,8 @@ func (c *Compiler) tryCompileOptimizedWhile(cond *parser.Binary, body []parser.S
 	// Patch exit jump
 	c.patchJump(exitJump)
 
-	// Patch break jumps
-	loopInfo := c.loopStack[len(c.loopStack)-1]
-	for _, breakPC := range loopInfo.breakJumps {
-		c.patchJumpAt(breakPC)
-	}
-
-	// Pop loop info
-	c.loopStack = c.loopStack[:len(c.loopStack)-1]
+	// Patch break jumps and pop loop info
+	c.popLoop()
 
 	// Unlock and free registers
 	c.allocator.Unlock(limitReg)
@@ -1046,6 +1168,8 @@ func (c *Compiler) literalToValue(lit *parser.Literal) vmregister.Value {
 		return vmregister.BoxNumber(v)
 	case string:
 		return vmregister.BoxString(v)
+	case []byte:
+		return vmregister.BoxBytes(v)
 	case bool:
 		return vmregister.BoxBool(v)
 	default:
@@ -1064,11 +1188,9 @@ func (c *Compiler) compileForStmt(s *parser.ForStmt) {
 
 	loopStart := len(c.code)
 
-	// Push loop info
-	c.loopStack = append(c.loopStack, LoopInfo{
-		startPC:    loopStart,
-		breakJumps: make([]int, 0),
-	})
+	// Push loop info; continue jumps forward to the update, which
+	// follows the body
+	c.pushLoop(s.Label, loopStart, -1, -1)
 
 	var exitJump int
 
@@ -1084,6 +1206,7 @@ func (c *Compiler) compileForStmt(s *parser.ForStmt) {
 	c.compileStmtsOptimized(s.Body)
 
 	// Compile update expression
+	c.setContinue()
 	if s.Update != nil {
 		updateReg := c.compileExpr(s.Update)
 		c.allocator.Free(updateReg)
@@ -1098,14 +1221,8 @@ func (c *Compiler) compileForStmt(s *parser.ForStmt) {
 		c.patchJump(exitJump)
 	}
 
-	// Patch break jumps
-	loopInfo := c.loopStack[len(c.loopStack)-1]
-	for _, breakPC := range loopInfo.breakJumps {
-		c.patchJumpAt(breakPC)
-	}
-
-	// Pop loop info and scope
-	c.loopStack = c.loopStack[:len(c.loopStack)-1]
+	// Patch break jumps, pop loop info and scope
+	c.popLoop()
 	c.popScope()
 }
 
@@ -1128,23 +1245,30 @@ func (c *Compiler) compileForInStmt(s *parser.ForInStmt) {
 	c.emit(vmregister.CreateABC(vmregister.OP_ITERINIT, uint8(iterReg), uint8(iterableReg), 0))
 	c.allocator.Free(iterableReg)
 
-	// Define loop variable
+	// Define loop variables
+	keyReg := -1
+	if s.Key != "" {
+		keyReg = c.defineLocal(s.Key)
+	}
 	varReg := c.defineLocal(s.Variable)
 
 	loopStart := len(c.code)
 
 	// Push loop info
-	c.loopStack = append(c.loopStack, LoopInfo{
-		startPC:    loopStart,
-		breakJumps: make([]int, 0),
-	})
+	c.pushLoop(s.Label, loopStart, loopStart, iterReg)
 
 	// ITERNEXT - advances iterator, jumps if done
 	iterNextPC := c.emit(vmregister.CreateAsBx(vmregister.OP_ITERNEXT, uint8(iterReg), 0))
 
-	// Get current value into loop variable
-	// The iterator stores current value at iterReg+2
-	c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(varReg), uint8(iterReg+2), 0))
+	// Get current value into loop variable(s). The iterator stores the key
+	// at iterReg+1, the value at iterReg+3 and, for one-variable loops, the
+	// element or map key at iterReg+2.
+	if keyReg >= 0 {
+		c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(keyReg), uint8(iterReg+1), 0))
+		c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(varReg), uint8(iterReg+3), 0))
+	} else {
+		c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(varReg), uint8(iterReg+2), 0))
+	}
 
 	// Compile body
 	for _, stmt := range s.Body {
@@ -1158,14 +1282,10 @@ func (c *Compiler) compileForInStmt(s *parser.ForInStmt) {
 	// Patch ITERNEXT jump to after loop
 	c.patchJumpAt(iterNextPC)
 
-	// Patch break jumps
-	loopInfo := c.loopStack[len(c.loopStack)-1]
-	for _, breakPC := range loopInfo.breakJumps {
-		c.patchJumpAt(breakPC)
-	}
-
-	// Pop loop info
-	c.loopStack = c.loopStack[:len(c.loopStack)-1]
+	// Patch break jumps and pop loop info. A break lands here with the
+	// iterator still live, so release it.
+	c.popLoop()
+	c.emit(vmregister.CreateABC(vmregister.OP_ITEREND, uint8(iterReg), 0, 0))
 
 	// Free all 4 iterator registers
 	for i := 0; i < 4; i++ {
@@ -1178,28 +1298,95 @@ func (c *Compiler) compileForInStmt(s *parser.ForInStmt) {
 
 // compileBreakStmt compiles a break statement
 func (c *Compiler) compileBreakStmt(s *parser.BreakStmt) {
-	if len(c.loopStack) == 0 {
-		c.error("break outside of loop")
+	target := c.findLoop("break", s.Label)
+	if target < 0 {
 		return
 	}
+	c.leaveLoops(target)
 	// Add jump to be patched later
 	jumpPC := c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0))
-	c.loopStack[len(c.loopStack)-1].breakJumps = append(
-		c.loopStack[len(c.loopStack)-1].breakJumps, jumpPC)
+	c.loopStack[target].breakJumps = append(c.loopStack[target].breakJumps, jumpPC)
 }
 
 // compileContinueStmt compiles a continue statement
 func (c *Compiler) compileContinueStmt(s *parser.ContinueStmt) {
-	if len(c.loopStack) == 0 {
-		c.error("continue outside of loop")
+	target := c.findLoop("continue", s.Label)
+	if target < 0 {
 		return
 	}
-	// Jump back to loop start
-	loopInfo := c.loopStack[len(c.loopStack)-1]
-	offset := loopInfo.startPC - len(c.code) - 1
+	c.leaveLoops(target)
+	loop := &c.loopStack[target]
+	if loop.continuePC < 0 {
+		// The continue code (a for loop's update) is not emitted yet
+		loop.continueJumps = append(loop.continueJumps, c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0)))
+		return
+	}
+	offset := loop.continuePC - len(c.code) - 1
 	c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, int16(offset)))
 }
 
+// pushLoop starts tracking a loop. continuePC is where continue jumps to,
+// or -1 when that code follows the body and setContinue marks it later.
+// iterReg is the iterator register of a for-in loop, or -1.
+func (c *Compiler) pushLoop(label string, startPC, continuePC, iterReg int) {
+	c.loopStack = append(c.loopStack, LoopInfo{
+		label:      label,
+		startPC:    startPC,
+		continuePC: continuePC,
+		iterReg:    iterReg,
+	})
+}
+
+// setContinue makes the current position the continue target of the
+// innermost loop
+func (c *Compiler) setContinue() {
+	loop := &c.loopStack[len(c.loopStack)-1]
+	loop.continuePC = len(c.code)
+	for _, pc := range loop.continueJumps {
+		c.patchJumpAt(pc)
+	}
+	loop.continueJumps = nil
+}
+
+// popLoop patches the break jumps of the innermost loop to the current
+// position and stops tracking it
+func (c *Compiler) popLoop() {
+	loop := c.loopStack[len(c.loopStack)-1]
+	for _, breakPC := range loop.breakJumps {
+		c.patchJumpAt(breakPC)
+	}
+	c.loopStack = c.loopStack[:len(c.loopStack)-1]
+}
+
+// findLoop returns the index in the loop stack of the loop a break or
+// continue refers to: the innermost one, or the one with the given label
+func (c *Compiler) findLoop(keyword, label string) int {
+	if len(c.loopStack) == 0 {
+		c.error(keyword + " outside of loop")
+		return -1
+	}
+	if label == "" {
+		return len(c.loopStack) - 1
+	}
+	for i := len(c.loopStack) - 1; i >= 0; i-- {
+		if c.loopStack[i].label == label {
+			return i
+		}
+	}
+	c.error(fmt.Sprintf("%s to unknown loop label '%s'", keyword, label))
+	return -1
+}
+
+// leaveLoops releases the iterators of the for-in loops nested inside
+// loopStack[target], which a jump to the target loop leaves
+func (c *Compiler) leaveLoops(target int) {
+	for i := len(c.loopStack) - 1; i > target; i-- {
+		if reg := c.loopStack[i].iterReg; reg >= 0 {
+			c.emit(vmregister.CreateABC(vmregister.OP_ITEREND, uint8(reg), 0, 0))
+		}
+	}
+}
+
 // compileImportStmt compiles an import statement
 func (c *Compiler) compileImportStmt(s *parser.ImportStmt) {
 	// Add module path as constant
@@ -1209,6 +1396,20 @@ func (c *Compiler) compileImportStmt(s *parser.ImportStmt) {
 	moduleReg := c.allocator.Alloc()
 	c.emit(vmregister.CreateABx(vmregister.OP_IMPORT, uint8(moduleReg), pathIdx))
 
+	// Selective import: bind each requested export to its own global
+	if len(s.Names) > 0 {
+		valueReg := c.allocator.Alloc()
+		for _, n := range s.Names {
+			keyIdx := c.addStringConstant(n.Name)
+			c.emit(vmregister.CreateABC(vmregister.OP_GETTABLEK, uint8(valueReg), uint8(moduleReg), uint8(keyIdx)))
+			globalID := c.getOrAssignGlobalID(n.LocalName())
+			c.emit(vmregister.CreateABx(vmregister.OP_SETGLOBAL, uint8(valueReg), globalID))
+		}
+		c.allocator.Free(valueReg)
+		c.allocator.Free(moduleReg)
+		return
+	}
+
 	// Store module in global (using alias or last path component)
 	name := s.Alias
 	if name == "" {
@@ -1251,9 +1452,12 @@ func (c *Compiler) compileTryStmt(s *parser.TryStmt) {
 
 	// Compile try block
 	c.pushScope()
+	parentTailCalls := c.tailCalls
+	c.tailCalls = false
 	for _, stmt := range s.TryBlock {
 		c.compileStmt(stmt)
 	}
+	c.tailCalls = parentTailCalls
 	c.popScope()
 
 	// ENDTRY
@@ -1297,14 +1501,210 @@ func (c *Compiler) compileThrowStmt(s *parser.ThrowStmt) {
 	c.allocator.Free(reg)
 }
 
-// compileClassStmt compiles a class statement
+// compileClassStmt compiles a struct declaration into its constructor, a
+// function taking the fields in order. The instance is a map of the
+// fields; each method is a closure over the instance, stored with
+// SETMETHOD so that it is reached with a dot but is not one of the items:
+//
+//	if severity == null { severity = "low" }  ; for each default
+//	NEWTABLE  self
+//	SETTABLEK self "host" host                ; for each field
+//	CLOSURE   t describe                      ; upvalue 0 = self
+//	SETMETHOD self "describe" t               ; for each method
+//	RETURN    self
 func (c *Compiler) compileClassStmt(s *parser.ClassStmt) {
-	c.error("class statements not yet supported")
+	// Save current compilation state
+	parentCode := c.code
+	parentLines := c.lines
+	parentConsts := c.constants
+	parentAllocator := c.allocator
+	parentLoops := c.loopStack
+	parentInMethod := c.inMethod
+	parentTailCalls := c.tailCalls
+
+	c.code = make([]vmregister.Instruction, 0)
+	c.lines = nil
+	c.constants = make([]vmregister.Value, 0)
+	c.allocator = NewRegisterAllocator()
+	c.loopStack = nil
+	c.inMethod = false
+	c.tailCalls = false
+
+	c.pushScope()
+	fieldRegs := c.defineParams(s.Fields, s.Defaults)
+
+	selfReg := c.defineLocal("self")
+	c.emit(vmregister.CreateABC(vmregister.OP_NEWTABLE, uint8(selfReg), 0, uint8(len(s.Fields))))
+	for i, field := range s.Fields {
+		keyIdx := c.addStringConstant(field)
+		c.emit(vmregister.CreateABC(vmregister.OP_SETTABLEK, uint8(selfReg), uint8(keyIdx), uint8(fieldRegs[i])))
+	}
+	for _, method := range s.Methods {
+		fnIdx := c.compileMethod(s.Name, method, selfReg)
+		closureReg := c.allocator.Alloc()
+		c.emit(vmregister.CreateABx(vmregister.OP_CLOSURE, uint8(closureReg), fnIdx))
+		keyIdx := c.addStringConstant(method.Name)
+		c.emit(vmregister.CreateABC(vmregister.OP_SETMETHOD, uint8(selfReg), uint8(keyIdx), uint8(closureReg)))
+		c.allocator.Free(closureReg)
+	}
+	c.emit(vmregister.CreateABC(vmregister.OP_RETURN, uint8(selfReg), 2, 0))
+
+	fn := &vmregister.FunctionObj{
+		Object:    vmregister.Object{Type: vmregister.OBJ_FUNCTION},
+		Name:      s.Name,
+		Arity:     len(s.Fields),
+		Params:    s.Fields,
+		Code:      c.code,
+		Lines:     c.lines,
+		Constants: c.constants,
+	}
+	c.popScope()
+
+	// Restore parent compilation state
+	c.code = parentCode
+	c.lines = parentLines
+	c.constants = parentConsts
+	c.allocator = parentAllocator
+	c.loopStack = parentLoops
+	c.inMethod = parentInMethod
+	c.tailCalls = parentTailCalls
+
+	fnIdx := c.addConstant(vmregister.BoxFunction(fn))
+	if c.scopeDepth == 0 {
+		globalID := c.getOrAssignGlobalID(s.Name)
+		closureReg := c.allocator.Alloc()
+		c.emit(vmregister.CreateABx(vmregister.OP_CLOSURE, uint8(closureReg), fnIdx))
+		c.emit(vmregister.CreateABx(vmregister.OP_SETGLOBAL, uint8(closureReg), globalID))
+		c.allocator.Free(closureReg)
+	} else {
+		reg := c.defineLocal(s.Name)
+		c.emit(vmregister.CreateABx(vmregister.OP_CLOSURE, uint8(reg), fnIdx))
+	}
 }
 
-// compileMatchStmt compiles a match statement
+// compileMethod compiles a method of struct structName, run by the
+// constructor with the instance in selfReg, and returns its constant index
+func (c *Compiler) compileMethod(structName string, m *parser.FunctionStmt, selfReg int) uint16 {
+	parentCode := c.code
+	parentLines := c.lines
+	parentConsts := c.constants
+	parentAllocator := c.allocator
+	parentLoops := c.loopStack
+	parentInMethod := c.inMethod
+	parentTailCalls := c.tailCalls
+
+	c.code = make([]vmregister.Instruction, 0)
+	c.lines = nil
+	c.constants = make([]vmregister.Value, 0)
+	c.allocator = NewRegisterAllocator()
+	c.loopStack = nil
+	c.inMethod = true
+	c.tailCalls = true
+
+	// The constructor's locals are not visible to the method
+	parentScope, parentDepth := c.scope, c.scopeDepth
+	c.scope, c.scopeDepth = nil, 0
+	c.pushScope()
+	c.defineParams(m.Params, m.Defaults)
+	for _, stmt := range m.Body {
+		c.compileStmt(stmt)
+	}
+	c.emit(vmregister.CreateABC(vmregister.OP_RETURN, 0, 1, 0))
+
+	fn := &vmregister.FunctionObj{
+		Object:    vmregister.Object{Type: vmregister.OBJ_FUNCTION},
+		Name:       structName + "." + m.Name,
+		Arity:      len(m.Params),
+		Params:     m.Params,
+		Code:       c.code,
+		Lines:      c.lines,
+		Constants:  c.constants,
+		Upvalues:   []vmregister.UpvalueDesc{{Index: uint8(selfReg), IsLocal: true}},
+		IsVariadic: m.Variadic,
+	}
+	c.popScope()
+	c.scope, c.scopeDepth = parentScope, parentDepth
+
+	c.code = parentCode
+	c.lines = parentLines
+	c.constants = parentConsts
+	c.allocator = parentAllocator
+	c.loopStack = parentLoops
+	c.inMethod = parentInMethod
+	c.tailCalls = parentTailCalls
+
+	return c.addConstant(vmregister.BoxFunction(fn))
+}
+
+// selfUpvalues returns the upvalues of a function nested in a method,
+// which passes the method's self on as its own upvalue 0
+func (c *Compiler) selfUpvalues() []vmregister.UpvalueDesc {
+	if !c.inMethod {
+		return nil
+	}
+	return []vmregister.UpvalueDesc{{Index: 0, IsLocal: false}}
+}
+
+// compileMatchStmt compiles a match statement into a chain of equality
+// tests. The value is evaluated once; each arm compares it with its
+// patterns in order and jumps to its body on the first equal one:
+//
+//	EQ   t, value, pattern1
+//	TEST t 1          ; equal: take the jump to the body
+//	JMP  body
+//	EQ   t, value, pattern2
+//	TEST t 0          ; last pattern, not equal: on to the next arm
+//	JMP  next
+//	body: ...
+//	JMP  end
 func (c *Compiler) compileMatchStmt(s *parser.MatchStmt) {
-	c.error("match statements not yet supported")
+	valueReg := c.compileExpr(s.Value)
+	valueWasLocked := c.allocator.locked[valueReg]
+	c.allocator.Lock(valueReg) // Keep the value alive while the arms run
+
+	var endJumps []int
+	for i, arm := range s.Cases {
+		var bodyJumps []int
+		nextArm := -1
+		for j, pattern := range arm.Patterns {
+			patternReg := c.compileExpr(pattern)
+			resultReg := c.allocator.Alloc()
+			c.emit(vmregister.CreateABC(vmregister.OP_EQ, uint8(resultReg), uint8(valueReg), uint8(patternReg)))
+			c.allocator.Free(patternReg)
+			c.allocator.Free(resultReg)
+			if j < len(arm.Patterns)-1 {
+				c.emit(vmregister.CreateABC(vmregister.OP_TEST, uint8(resultReg), 0, 1))
+				bodyJumps = append(bodyJumps, c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0)))
+			} else {
+				c.emit(vmregister.CreateABC(vmregister.OP_TEST, uint8(resultReg), 0, 0))
+				nextArm = c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0))
+			}
+		}
+		for _, jump := range bodyJumps {
+			c.patchJump(jump)
+		}
+
+		c.pushScope()
+		for _, stmt := range arm.Body {
+			c.compileStmt(stmt)
+		}
+		c.popScope()
+
+		if i < len(s.Cases)-1 {
+			endJumps = append(endJumps, c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0)))
+		}
+		if nextArm >= 0 {
+			c.patchJump(nextArm)
+		}
+	}
+	for _, jump := range endJumps {
+		c.patchJump(jump)
+	}
+
+	if !valueWasLocked {
+		c.allocator.Unlock(valueReg)
+	}
+	c.allocator.Free(valueReg)
 }
 
 // patchJump patches a jump instruction at the given PC to jump to current position
@@ -1360,6 +1760,8 @@ func (c *Compiler) compileExpr(expr parser.Expr) int {
 		return c.compileMapExpr(e)
 	case *parser.IndexExpr:
 		return c.compileIndexExpr(e)
+	case *parser.SliceExpr:
+		return c.compileSliceExpr(e)
 	case *parser.PropertyExpr:
 		return c.compilePropertyExpr(e)
 	case *parser.LambdaExpr:
@@ -1396,6 +1798,9 @@ func (c *Compiler) compileLiteral(e *parser.Literal) int {
 	case string:
 		constIdx := c.addStringConstant(v)
 		c.emit(vmregister.CreateABx(vmregister.OP_LOADK, uint8(reg), constIdx))
+	case []byte:
+		constIdx := c.addConstant(vmregister.BoxBytes(v))
+		c.emit(vmregister.CreateABx(vmregister.OP_LOADK, uint8(reg), constIdx))
 	case bool:
 		var val uint8 = 0
 		if v {
@@ -1417,6 +1822,11 @@ func (c *Compiler) compileVariable(e *parser.Variable) int {
 		// Local variable - return the register directly
 		return localReg
 	}
+	if e.Name == "self" && c.inMethod {
+		reg := c.allocator.Alloc()
+		c.emit(vmregister.CreateABC(vmregister.OP_GETUPVAL, uint8(reg), 0, 0))
+		return reg
+	}
 	// Global variable
 	reg := c.allocator.Alloc()
 	globalID := c.getOrAssignGlobalID(e.Name)
@@ -1425,6 +1835,11 @@ func (c *Compiler) compileVariable(e *parser.Variable) int {
 }
 
 func (c *Compiler) compileBinary(e *parser.Binary) int {
+	// The parser builds && and || as binary expressions, but they short-circuit
+	if e.Operator == "&&" || e.Operator == "||" {
+		return c.compileLogicalExpr(&parser.LogicalExpr{Left: e.Left, Operator: e.Operator, Right: e.Right})
+	}
+
 	// OPTIMIZATION 1: Constant folding - evaluate constant expressions at compile time
 	// This handles cases like: 2 * 3 + 1, 10 / 2, "hello" + "world", etc.
 	if c.isConstantExpr(e) {
@@ -1599,7 +2014,14 @@ func (c *Compiler) compileLogicalExpr(e *parser.LogicalExpr) int {
 	// Short-circuit evaluation
 	c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(resultReg), uint8(leftReg), 0))
 
-	if e.Operator == "&&" {
+	if e.Operator == "??" {
+		// If left is not null, skip right
+		isNull := c.allocator.Alloc()
+		c.emit(vmregister.CreateABC(vmregister.OP_LOADNIL, uint8(isNull), 0, 0))
+		c.emit(vmregister.CreateABC(vmregister.OP_EQ, uint8(isNull), uint8(leftReg), uint8(isNull)))
+		c.emit(vmregister.CreateABC(vmregister.OP_TEST, uint8(isNull), 0, 0))
+		c.allocator.Free(isNull)
+	} else if e.Operator == "&&" {
 		// If left is false, skip right
 		c.emit(vmregister.CreateABC(vmregister.OP_TEST, uint8(leftReg), 0, 0))
 	} else { // "||"
@@ -1638,8 +2060,12 @@ func (c *Compiler) compileCallExpr(e *parser.CallExpr) int {
 	// Now compile the callee
 	calleeReg := c.compileExpr(e.Callee)
 
-	// Find consecutive slots for the call: callee + args
+	// Find consecutive slots for the call: callee + args, and the names of
+	// keyword arguments
 	numSlots := 1 + len(e.Args)
+	if len(e.Names) > 0 {
+		numSlots++
+	}
 	baseReg := c.findConsecutiveRegisters(numSlots)
 
 	// Move callee to baseReg if needed
@@ -1664,6 +2090,15 @@ func (c *Compiler) compileCallExpr(e *parser.CallExpr) int {
 		}
 	}
 
+	if len(e.Names) > 0 {
+		// CALLKW baseReg numArgs+1 wantResults+1, names after the arguments
+		namesReg := baseReg + 1 + len(e.Args)
+		c.emit(vmregister.CreateABx(vmregister.OP_LOADK, uint8(namesReg), c.addStringConstant(strings.Join(e.Names, ","))))
+		c.emit(vmregister.CreateABC(vmregister.OP_CALLKW, uint8(baseReg), uint8(len(e.Args)+1), 2))
+		c.allocator.Free(namesReg)
+		return baseReg
+	}
+
 	// CALL baseReg numArgs+1 wantResults+1
 	c.emit(vmregister.CreateABC(vmregister.OP_CALL, uint8(baseReg), uint8(len(e.Args)+1), 2))
 
@@ -1701,46 +2136,107 @@ func (c *Compiler) compileMapExpr(e *parser.MapExpr) int {
 
 func (c *Compiler) compileIndexExpr(e *parser.IndexExpr) int {
 	objReg := c.compileExpr(e.Object)
-	indexReg := c.compileExpr(e.Index)
 	resultReg := c.allocator.Alloc()
+	skip := -1
+	if e.Optional {
+		skip = c.emitNullJump(objReg, resultReg)
+	}
+	indexReg := c.compileExpr(e.Index)
 
 	c.emit(vmregister.CreateABC(vmregister.OP_GETTABLE, uint8(resultReg), uint8(objReg), uint8(indexReg)))
+	if skip >= 0 {
+		c.patchJump(skip)
+	}
 
 	c.allocator.Free(objReg)
 	c.allocator.Free(indexReg)
 	return resultReg
 }
 
+func (c *Compiler) compileSliceExpr(e *parser.SliceExpr) int {
+	objReg := c.compileExpr(e.Object)
+	bounds := make([]int, 2)
+	for i, bound := range []parser.Expr{e.Start, e.End} {
+		if bound != nil {
+			bounds[i] = c.compileExpr(bound)
+		} else {
+			bounds[i] = c.allocator.Alloc()
+			c.emit(vmregister.CreateABC(vmregister.OP_LOADNIL, uint8(bounds[i]), 0, 0))
+		}
+	}
+
+	// SLICE reads its bounds from two consecutive registers
+	baseReg := c.findConsecutiveRegisters(2)
+	for i, reg := range bounds {
+		c.emit(vmregister.CreateABC(vmregister.OP_MOVE, uint8(baseReg+i), uint8(reg), 0))
+		c.allocator.Free(reg)
+	}
+	resultReg := c.allocator.Alloc()
+	c.emit(vmregister.CreateABC(vmregister.OP_SLICE, uint8(resultReg), uint8(objReg), uint8(baseReg)))
+
+	c.allocator.Free(objReg)
+	c.allocator.Free(baseReg)
+	c.allocator.Free(baseReg + 1)
+	return resultReg
+}
+
 func (c *Compiler) compilePropertyExpr(e *parser.PropertyExpr) int {
 	objReg := c.compileExpr(e.Object)
 	resultReg := c.allocator.Alloc()
+	skip := -1
+	if e.Optional {
+		skip = c.emitNullJump(objReg, resultReg)
+	}
 
 	// Use constant key
 	keyIdx := c.addStringConstant(e.Property)
 	c.emit(vmregister.CreateABC(vmregister.OP_GETTABLEK, uint8(resultReg), uint8(objReg), uint8(keyIdx)))
+	if skip >= 0 {
+		c.patchJump(skip)
+	}
 
 	c.allocator.Free(objReg)
 	return resultReg
 }
 
+// emitNullJump starts a null-safe access of the value in valueReg: it sets
+// resultReg to null and returns a jump, taken when the value is null, for
+// the caller to patch past the access
+//
+//	LOADNIL result
+//	EQ      t, value, result
+//	TEST    t 1           ; not null: skip the jump
+//	JMP     end
+func (c *Compiler) emitNullJump(valueReg, resultReg int) int {
+	c.emit(vmregister.CreateABC(vmregister.OP_LOADNIL, uint8(resultReg), 0, 0))
+	isNull := c.allocator.Alloc()
+	c.emit(vmregister.CreateABC(vmregister.OP_EQ, uint8(isNull), uint8(valueReg), uint8(resultReg)))
+	c.emit(vmregister.CreateABC(vmregister.OP_TEST, uint8(isNull), 0, 1))
+	c.allocator.Free(isNull)
+	return c.emit(vmregister.CreateAsBx(vmregister.OP_JMP, 0, 0))
+}
+
 func (c *Compiler) compileLambdaExpr(e *parser.LambdaExpr) int {
 	// Save current compilation state
 	parentCode := c.code
+	parentLines := c.lines
 	parentConsts := c.constants
 	parentAllocator := c.allocator
+	parentLoops := c.loopStack
+	parentTailCalls := c.tailCalls
 
 	// Create new compilation state for lambda
 	c.code = make([]vmregister.Instruction, 0)
+	c.lines = nil
 	c.constants = make([]vmregister.Value, 0)
 	c.allocator = NewRegisterAllocator()
+	c.loopStack = nil // break and continue cannot reach loops outside the body
+	c.tailCalls = true
 
 	// Create scope for lambda
 	c.pushScope()
 
-	// Define parameters as locals
-	for _, param := range e.Params {
-		c.defineLocal(param)
-	}
+	c.defineParams(e.Params, e.Defaults)
 
 	// Compile lambda body (expression)
 	if e.Body != nil {
@@ -1753,10 +2249,14 @@ func (c *Compiler) compileLambdaExpr(e *parser.LambdaExpr) int {
 	// Create function object
 	fn := &vmregister.FunctionObj{
 		Object:    vmregister.Object{Type: vmregister.OBJ_FUNCTION},
-		Name:      "<lambda>",
-		Arity:     len(e.Params),
-		Code:      c.code,
-		Constants: c.constants,
+		Name:       "<lambda>",
+		Arity:      len(e.Params),
+		Params:     e.Params,
+		Code:       c.code,
+		Lines:      c.lines,
+		Constants:  c.constants,
+		Upvalues:   c.selfUpvalues(),
+		IsVariadic: e.Variadic,
 	}
 
 	// Pop lambda scope
@@ -1764,8 +2264,11 @@ func (c *Compiler) compileLambdaExpr(e *parser.LambdaExpr) int {
 
 	// Restore parent compilation state
 	c.code = parentCode
+	c.lines = parentLines
 	c.constants = parentConsts
 	c.allocator = parentAllocator
+	c.loopStack = parentLoops
+	c.tailCalls = parentTailCalls
 
 	// Add function to constants and create closure
 	fnIdx := c.addConstant(vmregister.BoxFunction(fn))
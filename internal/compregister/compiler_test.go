@@ -0,0 +1,859 @@
+package compregister
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sentra/internal/compiler"
+	"sentra/internal/lexer"
+	"sentra/internal/limits"
+	"sentra/internal/parser"
+	"sentra/internal/profiler"
+	stackvm "sentra/internal/vm"
+	"sentra/internal/vmregister"
+)
+
+func run(t *testing.T, source string) map[string]vmregister.Value {
+	t.Helper()
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	vm := vmregister.NewRegisterVM()
+	globalNames, nextID := vm.GetGlobalNames()
+	fn, err := NewCompilerWithGlobals(globalNames, nextID).Compile(stmts)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := vm.Execute(fn, nil); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return vm.GetGlobals()
+}
+
+func TestStructs(t *testing.T) {
+	globals := run(t, `
+struct Finding {
+    host, port
+    severity = "low"
+
+    fn describe() {
+        return self.host + ":" + str(self.port) + " " + self.severity
+    }
+
+    fn escalate(level) {
+        self.severity = level
+        return self.describe()
+    }
+
+    fn deferred() {
+        let describe = fn() => self.describe()
+        return describe()
+    }
+}
+
+let a = Finding("10.0.0.5", 22)
+let b = Finding("10.0.0.6", 443, "medium")
+let before = a.describe()
+let after = a.escalate("high")
+let other = b.describe()
+let nested = b.deferred()
+let severity = a["severity"]
+let method_item = a["describe"]
+`)
+
+	tests := map[string]string{
+		"before":   "10.0.0.5:22 low",
+		"after":    "10.0.0.5:22 high",
+		"other":    "10.0.0.6:443 medium",
+		"nested":   "10.0.0.6:443 medium",
+		"severity": "high",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	// Methods are reached with a dot but are not items of the instance
+	if !vmregister.IsNil(globals["method_item"]) {
+		t.Errorf("method_item: got %s, want null", vmregister.ToString(globals["method_item"]))
+	}
+}
+
+func TestParameterDefaults(t *testing.T) {
+	globals := run(t, `
+fn scan(host, port = 443, proto = "tcp") {
+    return host + ":" + str(port) + "/" + proto
+}
+fn log_all(prefix, ...items) {
+    return prefix + " " + str(items)
+}
+let f = fn(a, b = a * 2, ...rest) => [a, b, rest]
+
+let defaults = scan("10.0.0.5")
+let some = scan("10.0.0.5", 22)
+let explicit_null = scan("10.0.0.5", null, "udp")
+let no_items = log_all("x")
+let items = log_all("x", 1, 2, 3)
+let lambda_short = f(1)
+let lambda_long = f(1, 5, 6, 7)
+`)
+
+	tests := map[string]string{
+		"defaults":      "10.0.0.5:443/tcp",
+		"some":          "10.0.0.5:22/tcp",
+		"explicit_null": "10.0.0.5:443/udp",
+		"no_items":      "x []",
+		"items":         "x [1, 2, 3]",
+		"lambda_short":  "[1, 2, []]",
+		"lambda_long":   "[1, 5, [6, 7]]",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestKeywordArguments(t *testing.T) {
+	globals := run(t, `
+fn scan(host, port = 443, proto = "tcp") {
+    return host + ":" + str(port) + "/" + proto
+}
+fn first(x, ...rest) => [x, rest]
+let pair = fn(a, b) => [a, b]
+struct Target {
+    host, port = 80
+
+    fn url(scheme = "http", path = "/") {
+        return scheme + "://" + self.host + ":" + str(self.port) + path
+    }
+}
+
+let skipped = scan("10.0.0.5", proto = "udp")
+let reordered = scan(port = 22, host = "10.0.0.6")
+let lambda = pair(b = 2, a = 1)
+let rest = first(x = 1)
+let target = Target(port = 8443, host = "example.com")
+let method = target.url(path = "/admin")
+let native = slice("hello world", end = 5, start = 0)
+`)
+
+	tests := map[string]string{
+		"skipped":   "10.0.0.5:443/udp",
+		"reordered": "10.0.0.6:22/tcp",
+		"lambda":    "[1, 2]",
+		"rest":      "[1, []]",
+		"method":    "http://example.com:8443/admin",
+		"native":    "hello",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	for src, want := range map[string]string{
+		`fn f(a) { return a }
+f(b = 1)`: "no parameter named 'b'",
+		`fn f(a) { return a }
+f(1, a = 2)`: "got argument 'a' twice",
+		`let n = 5
+n(a = 1)`: "cannot call",
+	} {
+		stmts := parser.NewParserWithSource(lexer.NewScanner(src).ScanTokens(), src, "test").Parse()
+		vm := vmregister.NewRegisterVM()
+		globalNames, nextID := vm.GetGlobalNames()
+		fn, err := NewCompilerWithGlobals(globalNames, nextID).Compile(stmts)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		if _, err := vm.Execute(fn, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.txt")
+	if err := os.WriteFile(path, []byte("sentra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	globals := run(t, `
+let magic = hex"de ad be ef"
+let kind = type(magic)
+let size = len(magic)
+let first = magic[0]
+let missing = magic[10]
+let middle = hex_encode(magic[1:3])
+let joined = hex_encode(magic + hex"00ff")
+let b64 = base64_encode(magic)
+let from_b64 = bytes("3q2+7w==", "base64") == magic
+let from_hex = bytes("DE AD BE EF", "hex") == magic
+let from_array = bytes([104, 105]) == bytes("hi")
+let text = "got " + bytes("hi")
+let back = bytes_to_string(bytes("ok"))
+let total = 0
+for b in hex"0102ff" { total = total + b }
+let digest = hex_encode(fs_hash(r"`+path+`", "sha256")) == sha256("sentra")
+`)
+
+	tests := map[string]string{
+		"kind":       "bytes",
+		"size":       "4",
+		"first":      "222",
+		"missing":    "nil",
+		"middle":     "adbe",
+		"joined":     "deadbeef00ff",
+		"b64":        "3q2+7w==",
+		"from_b64":   "true",
+		"from_hex":   "true",
+		"from_array": "true",
+		"text":       "got hi",
+		"back":       "ok",
+		"total":      "258",
+		"digest":     "true",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	source := `
+fn busy() {
+    let i = 0
+    while i < 1000000 { i = i + 1 }
+}
+fn wait() { sleep(100) }
+busy()
+wait()
+`
+	p := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test")
+	stmts := p.Parse()
+	vm := vmregister.NewRegisterVM()
+	vm.SetCoverage(vmregister.NewCoverage())
+	globalNames, nextID := vm.GetGlobalNames()
+	c := NewCompilerWithGlobals(globalNames, nextID)
+	c.EnableCoverage(vm.Coverage(), "scan.sn", p.StmtLines())
+	fn, err := c.Compile(stmts)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	vm.StartProfile(5 * time.Millisecond)
+	if _, err := vm.Execute(fn, nil); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	profile := vm.StopProfile()
+
+	cum := make(map[string]int64)
+	for _, stat := range profile.Top(false) {
+		cum[strings.Fields(stat.Function)[0]] = stat.Cum
+	}
+	// sleep blocks for 20 periods and is sampled under wait
+	if cum["sleep"] < 10 || cum["wait"] < cum["sleep"] || cum["(main)"] != profile.Total() {
+		t.Errorf("unexpected profile: %v", profile.Top(false))
+	}
+	lines := make(map[string]bool)
+	for _, stat := range profile.Top(true) {
+		lines[stat.Function] = true
+	}
+	if !lines["wait (scan.sn:6)"] || !lines["(main) (scan.sn:8)"] {
+		t.Errorf("unexpected lines: %v", profile.Top(true))
+	}
+	if profile.Period != 5*time.Millisecond || profile.Duration < 100*time.Millisecond {
+		t.Errorf("period %v, duration %v", profile.Period, profile.Duration)
+	}
+
+	// Both formats read back the same stacks
+	var folded, pprof bytes.Buffer
+	if err := profile.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	if err := profile.WritePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(folded.String(), "(main);wait;sleep ") {
+		t.Errorf("folded stacks: %s", folded.String())
+	}
+	decoded, err := profiler.Read(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refolded bytes.Buffer
+	decoded.WriteFolded(&refolded)
+	if refolded.String() != folded.String() || decoded.Period != profile.Period {
+		t.Errorf("pprof round trip: got\n%s\nwant\n%s", refolded.String(), folded.String())
+	}
+	rereadFolded, err := profiler.Read(bytes.NewReader(folded.Bytes()))
+	if err != nil || rereadFolded.Total() != profile.Total() {
+		t.Errorf("folded round trip: %v, %d samples", err, rereadFolded.Total())
+	}
+}
+
+func TestDisassembleAndTrace(t *testing.T) {
+	source := `fn add(a, b) {
+    return a + b
+}
+let total = 0
+let i = 0
+while i < 3 {
+    total = total + add(i, 1)
+    i = i + 1
+}
+`
+	p := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "trace.sn")
+	stmts := p.Parse()
+	vm := vmregister.NewRegisterVM()
+	globalNames, nextID := vm.GetGlobalNames()
+	c := NewCompilerWithGlobals(globalNames, nextID)
+	c.SetLines(p.StmtLines())
+	fn, err := c.Compile(stmts)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if len(fn.Lines) != len(fn.Code) {
+		t.Fatalf("got %d lines for %d instructions", len(fn.Lines), len(fn.Code))
+	}
+
+	var listing bytes.Buffer
+	if err := vm.Disassemble(&listing, fn); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"function <main> (0 params",
+		"      1  0000  CLOSURE     R0 K0          ; add",
+		"      4  0002  LOADK       R0 K1          ; 0",
+		"SETGLOBAL   R0 G" + strconv.Itoa(int(globalNames["total"])) + " ",
+		"JMP         +",
+		"     6 >0006  GETGLOBAL",
+		"; to 0006",
+		"constants:\n  K0    function add\n  K1    0\n",
+		"function add (2 params, 3 instructions, 0 constants)",
+		"      2  0000  ADD         R2 R0 R1",
+	} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing is missing %q:\n%s", want, listing.String())
+		}
+	}
+
+	var trace bytes.Buffer
+	vm.SetTrace(&trace)
+	if _, err := vm.Execute(fn, nil); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	vm.SetTrace(nil)
+	if got := vmregister.ToString(vm.GetGlobals()["total"]); got != "6" {
+		t.Errorf("total: got %s, want 6", got)
+	}
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if !strings.HasPrefix(lines[0], "<main>               1  0000  CLOSURE") || !strings.HasSuffix(lines[0], "frames=1 regs=128") {
+		t.Errorf("first trace line: %q", lines[0])
+	}
+	adds := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "add                  2  0000  ADD") && strings.Contains(line, "frames=2") {
+			adds++
+		}
+	}
+	if adds != 3 {
+		t.Errorf("got %d traced additions in add, want 3:\n%s", adds, trace.String())
+	}
+}
+
+// The register VM must give the same results as the stack VM it replaces
+func TestStackVMConformance(t *testing.T) {
+	source := `
+fn add(a, b) {
+    return a + b
+}
+let total = add(2, 3)
+let both = total > 4 && total < 6
+let either = total < 0 || total == 5
+let skipped = total == 5 && false
+let items = [1, 2, 3]
+let popped = pop(items)
+let size = len(items)
+let prefixed = starts_with("sentra", "sen")
+let suffixed = ends_with("sentra", "xx")
+let found = array_contains(items, 2)
+let sorted = array_sort([3, 1, 2])
+let cleared = [1, 2, 3]
+clear(cleared)
+let label = "n=" + size
+let valid = {"valid": false}
+let invalid = !valid["valid"]
+let folded = 2 + 2 == 4
+`
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	globals := run(t, source)
+
+	for _, name := range []string{"total", "both", "either", "skipped", "popped", "size", "prefixed",
+		"suffixed", "found", "sorted", "cleared", "label", "invalid", "folded"} {
+		want, _ := stack.GetGlobalVariable(name)
+		if got := vmregister.ToString(globals[name]); got != stackvm.ToString(want) {
+			t.Errorf("%s: register VM gave %q, stack VM %q", name, got, stackvm.ToString(want))
+		}
+	}
+}
+
+// exampleSkips lists the examples TestExampleConformance does not run,
+// either because they need the network or a service, or because the two
+// VMs are known to disagree on them.
+var exampleSkips = map[string]string{
+	"advanced_network_demo.sn":  "port_scan lists closed ports on the stack VM",
+	"all_modules_test.sn":       "needs database and network services",
+	"api_working_demo.sn":       "len(nil) is 0 on the stack VM and an error on the register VM",
+	"complete_test.sn":          "test_summary counts differ",
+	"concurrency_simple.sn":     "prints the goroutine count",
+	"container_minimal.sn":      "pulls from Docker Hub",
+	"container_simple.sn":       "pulls from Docker Hub",
+	"error_handling_demo.sn":    "type() names and map order differ",
+	"http_demo.sn":              "fetches api.github.com",
+	"http_server_demo.sn":       "runs a server",
+	"memory_simple.sn":          "prints the process count",
+	"memory_simple_working.sn":  "prints live process usage",
+	"network_comprehensive.sn":  "fetches api.github.com",
+	"network_scanner_simple.sn": "scans the local network",
+	"network_showcase.sn":       "fetches httpbin.org",
+	"network_simple.sn":         "resolves google.com",
+	"network_test.sn":           "fetches api.github.com",
+	"os_simple.sn":              "prints the machine's connections and users",
+	"sentra_showcase.sn":        "prints random keys and the current time",
+	"siem_demo.sn":              "siem_parse_event fails on the stack VM",
+	"stdlib_demo.sn":            "sort is not in place on the stack VM",
+	"tcp_client_demo.sn":        "connects to a server that is not running",
+	"tcp_server_demo.sn":        "runs a server",
+	"test_builtins.sn":          "prints the current time",
+	"test_format.sn":            "does not parse",
+	"test_validator.sn":         "imports a module from example-project",
+	"udp_demo.sn":               "socket builtins return an id on the stack VM and a map on the register VM",
+	"websocket_chatroom.sn":     "does not parse",
+	"websocket_client_demo.sn":  "connects to a server that is not running",
+	"websocket_demo.sn":         "does not parse",
+	"websocket_server_demo.sn":  "runs a server",
+	"websocket_server_old.sn":   "prints a time-based id",
+}
+
+// captureStdout runs f and returns what it printed.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+	f()
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExampleConformance(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.sn")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			if reason, ok := exampleSkips[name]; ok {
+				t.Skip(reason)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := string(data)
+			parse := func() []parser.Stmt {
+				return parser.NewParserWithSource(lexer.NewScannerWithFile(source, file).ScanTokens(), source, file).Parse()
+			}
+
+			var stack *stackvm.EnhancedVM
+			var stackErr error
+			stackOut := captureStdout(t, func() {
+				defer func() {
+					if r := recover(); r != nil {
+						stackErr = fmt.Errorf("panic: %v", r)
+					}
+				}()
+				hc := compiler.NewHoistingCompilerWithDebug(file)
+				stack = stackvm.NewVM(hc.CompileWithHoisting(parse()))
+				stack.SetFilePath(file)
+				_, stackErr = stack.Run()
+			})
+
+			vm := vmregister.NewRegisterVM()
+			var regErr error
+			regOut := captureStdout(t, func() {
+				globalNames, nextID := vm.GetGlobalNames()
+				fn, err := NewCompilerWithGlobals(globalNames, nextID).Compile(parse())
+				if err != nil {
+					t.Fatalf("register compile failed: %v", err)
+				}
+				_, regErr = vm.Execute(fn, nil)
+			})
+
+			if (stackErr == nil) != (regErr == nil) {
+				t.Errorf("stack VM error %v, register VM error %v", stackErr, regErr)
+			}
+			if stackOut != regOut {
+				t.Errorf("output differs\nstack VM:\n%s\nregister VM:\n%s", stackOut, regOut)
+			}
+			globals := vm.GetGlobals()
+			for _, stmt := range parse() {
+				let, ok := stmt.(*parser.LetStmt)
+				if !ok {
+					continue
+				}
+				// Maps print in no fixed order, so only scalars are compared
+				got := globals[let.Name]
+				if !vmregister.IsNumber(got) && !vmregister.IsBool(got) && !vmregister.IsString(got) && !vmregister.IsNil(got) {
+					continue
+				}
+				want, _ := stack.GetGlobalVariable(let.Name)
+				if vmregister.ToString(got) != stackvm.ToString(want) {
+					t.Errorf("%s: register VM gave %q, stack VM %q", let.Name, vmregister.ToString(got), stackvm.ToString(want))
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterVMFixes(t *testing.T) {
+	dir := t.TempDir()
+	globals := run(t, `
+let early = later(2)
+fn later(n) {
+    return n * 2
+}
+let items = [1, 2]
+items.push(3)
+items.unshift(0)
+let popped = items.pop()
+let size = items.length
+let x = 0 - 5
+let negative = x < 0
+let above = x > 150
+let skipped = false && missing()
+fn check(v) {
+    if v == null {
+        throw "missing"
+    }
+    return v
+}
+fn each(values) {
+    let out = []
+    for v in values {
+        try {
+            push(out, check(v))
+        } catch e {
+            push(out, e)
+        }
+    }
+    return out
+}
+let caught = each([1, null, 2])
+let counted = len({"a": 1, "b": 2})
+let s = "port"
+let joined = s + 1
+let r = range(0, 3)
+let ranged = []
+for i in r {
+    push(ranged, i)
+}
+let evens = filter([1, 2, 3, 4], fn(x) => x % 2 == 0)
+import io
+let dir = r"`+dir+`"
+io.mkdir(dir + "/out")
+io.writefile(dir + "/out/a.txt", "one")
+io.append(dir + "/out/a.txt", " two")
+io.rename(dir + "/out/a.txt", dir + "/out/b.txt")
+let listed = io.listdir(dir + "/out")
+let appended = io.readfile(dir + "/out/b.txt")
+io.remove(dir + "/out/b.txt")
+let removed = io.exists(dir + "/out/b.txt")
+`)
+
+	tests := map[string]string{
+		"negative": "true",
+		"above":    "false",
+		"skipped":  "false",
+		"early":    "4",
+		"items":    "[0, 1, 2]",
+		"popped":   "3",
+		"size":     "3",
+		"caught":   "[1, missing, 2]",
+		"counted":  "2",
+		"joined":   "port1",
+		"ranged":   "[0, 1, 2]",
+		"evens":    "[2, 4]",
+		"listed":   "[b.txt]",
+		"appended": "one two",
+		"removed":  "false",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Optimized code must give the same results as the code it replaces, on
+// both VMs
+func TestOptimizerConformance(t *testing.T) {
+	source := `
+let quotient = 10 / 4
+let whole = 10 / 2
+let rest = 17 % 5
+let negative = -(3 * 4)
+let joined = "a" + "b" + "c"
+let calls = 0
+fn bump() {
+    calls = calls + 1
+    return calls
+}
+fn work(n) {
+    let unused = bump()
+    let constant = [1, 2, 3]
+    if 2 > 3 {
+        return -1
+    } else {
+        return n * 2
+    }
+    bump()
+}
+let doubled = work(21)
+if false {
+    calls = 100
+}
+while 1 > 2 {
+    calls = 200
+}
+`
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	globals := run(t, source)
+
+	want := map[string]string{"quotient": "2.5", "whole": "5", "rest": "2", "negative": "-12",
+		"joined": "abc", "doubled": "42", "calls": "1"}
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	source := `
+fn is_even(n) {
+    if n == 0 {
+        return true
+    }
+    return is_odd(n - 1)
+}
+fn is_odd(n) {
+    if n == 0 {
+        return false
+    }
+    return is_even(n - 1)
+}
+fn skip(n, step = 2) {
+    if n <= 0 {
+        return n
+    }
+    return skip(n - step)
+}
+fn first(n, ...rest) {
+    if n > 0 {
+        return first(n - 1, n)
+    }
+    return len(rest)
+}
+let countdown = fn(n) {
+    if n == 0 {
+        return "done"
+    }
+    return countdown(n - 1)
+}
+fn size(items) {
+    return len(items)
+}
+let even = is_even(50001)
+let skipped = skip(30001)
+let rest = first(5000)
+let done = countdown(20000)
+let sized = size([1, 2, 3])
+`
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	globals := run(t, source)
+
+	want := map[string]string{"even": "false", "skipped": "-1", "rest": "1", "done": "done", "sized": "3"}
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+
+	// A call that is not in tail position still uses a frame
+	deep := `
+fn depth(n) {
+    if n == 0 {
+        return 0
+    }
+    return 1 + depth(n - 1)
+}
+depth(100000)
+`
+	stmts = parser.NewParserWithSource(lexer.NewScanner(deep).ScanTokens(), deep, "test").Parse()
+	_, stackErr := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts)).Run()
+	vm := vmregister.NewRegisterVM()
+	globalNames, nextID := vm.GetGlobalNames()
+	fn, err := NewCompilerWithGlobals(globalNames, nextID).Compile(stmts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, registerErr := vm.Execute(fn, nil)
+	for name, err := range map[string]error{"stack": stackErr, "register": registerErr} {
+		var depthErr *limits.DepthError
+		if !errors.As(err, &depthErr) || depthErr.Function != "depth" {
+			t.Errorf("%s VM: got %v, want a DepthError for depth", name, err)
+		}
+	}
+	// The stack VM runs out of frames, the register VM out of registers
+	// first, and neither should claim a depth it does not enforce
+	const tail = " calling depth: only a call in tail position, return depth(...), reuses its frame"
+	if want := "maximum recursion depth of 1024 exceeded" + tail; stackErr == nil || stackErr.Error() != want {
+		t.Errorf("stack VM: got %v, want %q", stackErr, want)
+	}
+	var depthErr *limits.DepthError
+	if errors.As(registerErr, &depthErr) {
+		if want := fmt.Sprintf("out of registers at recursion depth %d", depthErr.Depth) + tail; !depthErr.Registers || depthErr.Error() != want {
+			t.Errorf("register VM: got %v, want %q", depthErr, want)
+		}
+		depthErr.Registers = false
+		if want := "maximum recursion depth of 2000 exceeded" + tail; depthErr.Error() != want {
+			t.Errorf("register VM at its frame limit: got %v, want %q", depthErr, want)
+		}
+	}
+
+	catching := deep[:strings.LastIndex(deep, "depth(")] + `
+let caught = ""
+try {
+    depth(100000)
+} catch e {
+    caught = e
+}
+`
+	globals = run(t, catching)
+	if got := vmregister.ToString(globals["caught"]); !strings.HasPrefix(got, "out of registers at recursion depth") {
+		t.Errorf("register VM caught %q, want the register exhaustion error", got)
+	}
+	stmts = parser.NewParserWithSource(lexer.NewScanner(catching).ScanTokens(), catching, "test").Parse()
+	stack = stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	if got, _ := stack.GetGlobalVariable("caught"); !strings.Contains(stackvm.ToString(got), "maximum recursion depth") {
+		t.Errorf("stack VM caught %q, want the recursion depth error", stackvm.ToString(got))
+	}
+}
+
+func TestSlices(t *testing.T) {
+	globals := run(t, `
+let arr = [1, 2, 3, 4, 5, 6]
+let s = "hello world"
+let n = 2
+let middle = arr[1:4]
+let head = arr[:n]
+let tail = arr[-2:]
+let word = s[6:]
+let prefix = s[:5]
+let inner = s[-5:-1]
+let empty = arr[4:1]
+let clamped = arr[:100]
+let copy = arr[:]
+copy[0] = 99
+let original = arr[0]
+let builtin = slice(arr, 2)
+let builtin_end = slice(s, 0, 4)
+`)
+
+	tests := map[string]string{
+		"middle":      "[2, 3, 4]",
+		"head":        "[1, 2]",
+		"tail":        "[5, 6]",
+		"word":        "world",
+		"prefix":      "hello",
+		"inner":       "worl",
+		"empty":       "[]",
+		"clamped":     "[1, 2, 3, 4, 5, 6]",
+		"original":    "1",
+		"builtin":     "[3, 4, 5, 6]",
+		"builtin_end": "hell",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	globals := run(t, `
+let result = {"items": [1, 2, 3], "meta": null}
+let missing = null
+let calls = 0
+fn count() {
+    calls = calls + 1
+    return 1
+}
+let length = result?.items?.length ?? 0
+let no_length = missing?.items?.length ?? 0
+let no_meta = result?.meta?.count ?? "none"
+let first = result?.["items"]?.[0]
+let kept = false ?? count()
+`)
+
+	tests := map[string]string{
+		"length":    "3",
+		"no_length": "0",
+		"no_meta":   "none",
+		"first":     "1",
+		"kept":      "false",
+		"calls":     "0",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package concurrency
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrChannelClosed is returned when sending on a closed channel
+var ErrChannelClosed = errors.New("send on closed channel")
+
+// Forever is the wait of an operation that blocks until it can go ahead
+const Forever time.Duration = -1
+
+// Channel passes values between goroutines like a Go channel, with
+// timeouts on every operation. Closing it never makes a sender panic:
+// sends fail with ErrChannelClosed, and receivers still get the values
+// buffered before the close.
+type Channel struct {
+	items     chan interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewChannel creates a channel that buffers up to capacity values. With a
+// capacity of 0 a send waits for a receiver.
+func NewChannel(capacity int) *Channel {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &Channel{
+		items: make(chan interface{}, capacity),
+		done:  make(chan struct{}),
+	}
+}
+
+// timeout returns the channel that fires after wait, or nil, which never
+// fires, for Forever. stop releases its timer.
+func timeout(wait time.Duration) (fire <-chan time.Time, stop func()) {
+	if wait < 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(wait)
+	return timer.C, func() { timer.Stop() }
+}
+
+// Send passes value on, waiting up to wait for a receiver or room in the
+// buffer. It reports whether the value was sent.
+func (c *Channel) Send(value interface{}, wait time.Duration) (bool, error) {
+	if c.closed() {
+		return false, ErrChannelClosed
+	}
+	select {
+	case c.items <- value:
+		return true, nil
+	default:
+	}
+	if wait == 0 {
+		return false, nil
+	}
+	fire, stop := timeout(wait)
+	defer stop()
+	select {
+	case c.items <- value:
+		return true, nil
+	case <-c.done:
+		return false, ErrChannelClosed
+	case <-fire:
+		return false, nil
+	}
+}
+
+// Recv takes the next value, waiting up to wait for one. It reports false
+// if none arrived in time or the channel is closed and drained.
+func (c *Channel) Recv(wait time.Duration) (interface{}, bool) {
+	select {
+	case value := <-c.items:
+		return value, true
+	default:
+	}
+	if wait == 0 {
+		return nil, false
+	}
+	fire, stop := timeout(wait)
+	defer stop()
+	select {
+	case value := <-c.items:
+		return value, true
+	case <-c.done:
+		return c.drain()
+	case <-fire:
+		return nil, false
+	}
+}
+
+// drain takes a value buffered before the close, if any is left
+func (c *Channel) drain() (interface{}, bool) {
+	select {
+	case value := <-c.items:
+		return value, true
+	default:
+		return nil, false
+	}
+}
+
+// Close stops the channel accepting values. Closing it again does nothing.
+func (c *Channel) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Channel) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drained reports whether the channel is closed and every value sent on it
+// has been received
+func (c *Channel) Drained() bool {
+	return c.closed() && len(c.items) == 0
+}
+
+// Len returns the number of buffered values
+func (c *Channel) Len() int {
+	return len(c.items)
+}
+
+// Cap returns the size of the buffer
+func (c *Channel) Cap() int {
+	return cap(c.items)
+}
+
+// SelectCase is one operation of a Select: a receive from Channel, or a
+// send of Value on it
+type SelectCase struct {
+	Channel *Channel
+	Send    bool
+	Value   interface{}
+}
+
+// Select waits up to wait for the first of cases that can go ahead and
+// runs it. It returns the index of that case, or -1 if none could before
+// the wait ran out, which with a wait of 0 is a default clause. For a
+// receive, ok is false if the channel is closed and drained. A send on a
+// closed channel returns ErrChannelClosed.
+func Select(cases []SelectCase, wait time.Duration) (index int, value interface{}, ok bool, err error) {
+	for i, c := range cases {
+		if c.Send && c.Channel.closed() {
+			return i, nil, false, ErrChannelClosed
+		}
+	}
+	// Each case waits on its channel and on the close of it, which wakes
+	// receivers of a drained channel and fails senders
+	selects := make([]reflect.SelectCase, 0, 2*len(cases)+1)
+	for _, c := range cases {
+		op := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Channel.items)}
+		if c.Send {
+			value := reflect.ValueOf(&c.Value).Elem()
+			op = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.Channel.items), Send: value}
+		}
+		selects = append(selects, op, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Channel.done)})
+	}
+	switch {
+	case wait == 0:
+		selects = append(selects, reflect.SelectCase{Dir: reflect.SelectDefault})
+	case wait > 0:
+		fire, stop := timeout(wait)
+		defer stop()
+		selects = append(selects, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(fire)})
+	}
+
+	chosen, received, _ := reflect.Select(selects)
+	if chosen == 2*len(cases) {
+		return -1, nil, false, nil
+	}
+	index = chosen / 2
+	c := cases[index]
+	switch {
+	case chosen%2 == 1 && c.Send:
+		return index, nil, false, ErrChannelClosed
+	case chosen%2 == 1:
+		value, ok = c.Channel.drain()
+		return index, value, ok, nil
+	case c.Send:
+		return index, nil, true, nil
+	}
+	return index, received.Interface(), true, nil
+}
@@ -17,6 +17,7 @@ type ConcurrencyModule struct {
 	TaskQueues    map[string]*TaskQueue
 	ConnectionPools map[string]*ConnectionPool
 	Semaphores    map[string]*Semaphore
+	Scheduler     *Scheduler
 	Metrics       *ConcurrencyMetrics
 	mu            sync.RWMutex
 }
@@ -53,6 +54,12 @@ type Job struct {
 	Timeout  time.Duration
 	Priority int
 	Created  time.Time
+
+	// Handler runs the job instead of the built-in job for Type. ctx is
+	// done when the job times out or the pool stops.
+	Handler func(ctx context.Context, data interface{}) (interface{}, error)
+	// Done receives the result instead of the pool's Results channel
+	Done func(JobResult)
 }
 
 // JobResult represents the result of a job execution
@@ -157,6 +164,7 @@ func NewConcurrencyModule() *ConcurrencyModule {
 		TaskQueues:      make(map[string]*TaskQueue),
 		ConnectionPools: make(map[string]*ConnectionPool),
 		Semaphores:      make(map[string]*Semaphore),
+		Scheduler:       NewScheduler(),
 		Metrics:         &ConcurrencyMetrics{},
 	}
 }
@@ -243,17 +251,21 @@ func (cm *ConcurrencyModule) runWorker(worker *Worker) {
 			result.WorkerID = worker.ID
 
 			// Send result
-			select {
-			case worker.Pool.Results <- result:
-				atomic.AddInt64(&worker.Pool.TasksDone, 1)
-				atomic.AddInt64(&cm.Metrics.TasksProcessing, -1)
-				if result.Success {
-					atomic.AddInt64(&cm.Metrics.TasksCompleted, 1)
-				} else {
-					atomic.AddInt64(&cm.Metrics.TasksFailed, 1)
+			if job.Done != nil {
+				job.Done(result)
+			} else {
+				select {
+				case worker.Pool.Results <- result:
+				case <-worker.Pool.Ctx.Done():
+					return
 				}
-			case <-worker.Pool.Ctx.Done():
-				return
+			}
+			atomic.AddInt64(&worker.Pool.TasksDone, 1)
+			atomic.AddInt64(&cm.Metrics.TasksProcessing, -1)
+			if result.Success {
+				atomic.AddInt64(&cm.Metrics.TasksCompleted, 1)
+			} else {
+				atomic.AddInt64(&cm.Metrics.TasksFailed, 1)
 			}
 
 		case <-worker.Quit:
@@ -281,39 +293,43 @@ func (cm *ConcurrencyModule) executeJob(job Job, worker *Worker) JobResult {
 		defer cancel()
 	}
 
-	// Execute job based on type
-	done := make(chan struct{})
+	// Execute job based on type. The job fills in its own copy of the
+	// result, since a job that times out may still be running.
+	done := make(chan JobResult, 1)
 	go func() {
+		out := result
 		defer func() {
 			if r := recover(); r != nil {
-				result.Error = fmt.Errorf("job panicked: %v", r)
+				out.Error = fmt.Errorf("job panicked: %v", r)
 			}
-			close(done)
+			done <- out
 		}()
 
-		switch job.Type {
-		case "port_scan":
-			result.Result, result.Error = cm.executePortScan(job.Data)
-		case "vuln_scan":
-			result.Result, result.Error = cm.executeVulnScan(job.Data)
-		case "hash_calculate":
-			result.Result, result.Error = cm.executeHashCalculation(job.Data)
-		case "network_probe":
-			result.Result, result.Error = cm.executeNetworkProbe(job.Data)
-		case "file_scan":
-			result.Result, result.Error = cm.executeFileScan(job.Data)
+		switch {
+		case job.Handler != nil:
+			out.Result, out.Error = job.Handler(ctx, job.Data)
+		case job.Type == "port_scan":
+			out.Result, out.Error = cm.executePortScan(job.Data)
+		case job.Type == "vuln_scan":
+			out.Result, out.Error = cm.executeVulnScan(job.Data)
+		case job.Type == "hash_calculate":
+			out.Result, out.Error = cm.executeHashCalculation(job.Data)
+		case job.Type == "network_probe":
+			out.Result, out.Error = cm.executeNetworkProbe(job.Data)
+		case job.Type == "file_scan":
+			out.Result, out.Error = cm.executeFileScan(job.Data)
 		default:
-			result.Error = fmt.Errorf("unknown job type: %s", job.Type)
+			out.Error = fmt.Errorf("unknown job type: %s", job.Type)
 		}
 
-		if result.Error == nil {
-			result.Success = true
+		if out.Error == nil {
+			out.Success = true
 		}
 	}()
 
 	// Wait for completion or timeout
 	select {
-	case <-done:
+	case result = <-done:
 		return result
 	case <-ctx.Done():
 		result.Error = fmt.Errorf("job timed out")
@@ -375,6 +391,56 @@ func (cm *ConcurrencyModule) SubmitJob(poolID string, job Job) error {
 	}
 }
 
+// NextResult takes the next result from a worker pool, waiting up to wait
+// for one, or until the pool stops for Forever. ok is false if none
+// arrived in time.
+func (cm *ConcurrencyModule) NextResult(poolID string, wait time.Duration) (result JobResult, ok bool, err error) {
+	cm.mu.RLock()
+	pool, exists := cm.WorkerPools[poolID]
+	cm.mu.RUnlock()
+
+	if !exists {
+		return JobResult{}, false, fmt.Errorf("worker pool not found: %s", poolID)
+	}
+
+	select {
+	case result = <-pool.Results:
+		return result, true, nil
+	default:
+	}
+	if wait == 0 {
+		return JobResult{}, false, nil
+	}
+	fire, stop := timeout(wait)
+	defer stop()
+	select {
+	case result = <-pool.Results:
+		return result, true, nil
+	case <-pool.Ctx.Done():
+		return JobResult{}, false, nil
+	case <-fire:
+		return JobResult{}, false, nil
+	}
+}
+
+// Map returns the result as a map for scripts: the job id, whether it
+// succeeded, the value it returned or its error message, the worker that
+// ran it and how long it took
+func (r JobResult) Map() map[string]interface{} {
+	var message interface{}
+	if r.Error != nil {
+		message = r.Error.Error()
+	}
+	return map[string]interface{}{
+		"id":          r.JobID,
+		"ok":          r.Success,
+		"value":       r.Result,
+		"error":       message,
+		"worker":      int64(r.WorkerID),
+		"duration_ms": float64(r.Duration) / float64(time.Millisecond),
+	}
+}
+
 // CreateRateLimiter creates a new rate limiter
 func (cm *ConcurrencyModule) CreateRateLimiter(id string, rate int, burst int) (*RateLimiter, error) {
 	cm.mu.Lock()
@@ -0,0 +1,313 @@
+package concurrency
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduler keeps recurring jobs and works out when each is next due. It
+// does not run them: the caller asks for due jobs and runs them itself, so
+// jobs that must run on one goroutine (such as script callbacks) can.
+type Scheduler struct {
+	jobs    map[string]*ScheduledJob
+	counter uint64
+	mu      sync.Mutex
+}
+
+// ScheduledJob is a job that runs at a fixed interval or on a cron schedule
+type ScheduledJob struct {
+	ID      string
+	Spec    string      // The interval or cron expression it was created with
+	Payload interface{} // What to run, for the caller
+	Next    time.Time
+	LastRun time.Time
+	Runs    int
+
+	interval time.Duration
+	cron     *CronSchedule
+}
+
+// NewScheduler creates an empty scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*ScheduledJob)}
+}
+
+// ParseInterval parses an interval such as "30s", "5m", "1h30m" or "2d"
+func ParseInterval(spec string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(spec, "d"); ok {
+		var n float64
+		n, err = strconv.ParseFloat(days, 64)
+		d = time.Duration(n * float64(24*time.Hour))
+	} else {
+		d, err = time.ParseDuration(spec)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", spec)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("interval must be positive, got %q", spec)
+	}
+	return d, nil
+}
+
+// Every adds a job that first runs one interval from now
+func (s *Scheduler) Every(interval time.Duration, spec string, payload interface{}) *ScheduledJob {
+	job := &ScheduledJob{Spec: spec, Payload: payload, interval: interval}
+	job.Next = time.Now().Add(interval)
+	s.add(job)
+	return job
+}
+
+// Cron adds a job that runs on a cron schedule
+func (s *Scheduler) Cron(expr string, payload interface{}) (*ScheduledJob, error) {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+	job := &ScheduledJob{Spec: expr, Payload: payload, cron: schedule}
+	job.Next = schedule.Next(time.Now())
+	if job.Next.IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", expr)
+	}
+	s.add(job)
+	return job, nil
+}
+
+func (s *Scheduler) add(job *ScheduledJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counter++
+	job.ID = "job_" + strconv.FormatUint(s.counter, 10)
+	s.jobs[job.ID] = job
+}
+
+// Cancel removes a job, reporting whether it existed
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[id]
+	delete(s.jobs, id)
+	return ok
+}
+
+// Jobs returns the scheduled jobs, soonest first
+func (s *Scheduler) Jobs() []*ScheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Next.Equal(jobs[j].Next) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].Next.Before(jobs[j].Next)
+	})
+	return jobs
+}
+
+// NextRun returns when the soonest job is due. ok is false if there are no
+// jobs.
+func (s *Scheduler) NextRun() (next time.Time, ok bool) {
+	jobs := s.Jobs()
+	if len(jobs) == 0 {
+		return time.Time{}, false
+	}
+	return jobs[0].Next, true
+}
+
+// Due returns the jobs due at now, soonest first, and moves each to its
+// next run. Runs missed while the caller was busy are skipped rather than
+// run back to back.
+func (s *Scheduler) Due(now time.Time) []*ScheduledJob {
+	var due []*ScheduledJob
+	for _, job := range s.Jobs() {
+		if job.Next.After(now) {
+			break
+		}
+		due = append(due, job)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range due {
+		job.LastRun = now
+		job.Runs++
+		if job.cron != nil {
+			job.Next = job.cron.Next(now)
+			if job.Next.IsZero() {
+				delete(s.jobs, job.ID)
+			}
+			continue
+		}
+		for !job.Next.After(now) {
+			job.Next = job.Next.Add(job.interval)
+		}
+	}
+	return due
+}
+
+// CronSchedule is a parsed five field cron expression
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values
+	domStar, dowStar              bool   // Whether the field was *
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronDays = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// ParseCron parses a cron expression: minute, hour, day of month, month and
+// day of week, each *, a value, a range a-b or a list of them, optionally
+// with a /step. Months and days may be named (JAN, MON) and Sunday is 0 or
+// 7. The macros @hourly, @daily, @weekly, @monthly and @yearly are also
+// accepted.
+func ParseCron(expr string) (*CronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	c := &CronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron minute: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron hour: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron day of month: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("cron month: %v", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("cron day of week: %v", err)
+	}
+	// 7 is another name for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField returns the bit set of values a field allows
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToUpper(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q is backwards", rangePart)
+			}
+		default:
+			n, err := value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// dayMatches applies the cron rule that when both day fields are
+// restricted, a day matching either of them is enough
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after t the schedule matches, in t's time
+// zone, or the zero time if it matches none in the next five years
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
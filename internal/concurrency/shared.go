@@ -0,0 +1,128 @@
+package concurrency
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed is returned when pushing to a closed queue
+var ErrQueueClosed = errors.New("queue is closed")
+
+// Map is a string keyed map that is safe for concurrent use. Script arrays
+// and maps belong to the goroutine running the script, so values that
+// stages of a pipeline share, like results keyed by host, go through a
+// Map instead.
+type Map struct {
+	mu    sync.RWMutex
+	items map[string]interface{}
+}
+
+// NewMap creates an empty map
+func NewMap() *Map {
+	return &Map{items: make(map[string]interface{})}
+}
+
+// Get returns the value stored under key
+func (m *Map) Get(key string) (interface{}, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.items[key]
+	return value, ok
+}
+
+// Set stores value under key
+func (m *Map) Set(key string, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = value
+}
+
+// Delete removes key and reports whether it was present
+func (m *Map) Delete(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.items[key]
+	delete(m.items, key)
+	return ok
+}
+
+// Update replaces the value under key with fn of the current one, as one
+// step that no other goroutine can interleave with, and returns the new
+// value
+func (m *Map) Update(key string, fn func(value interface{}, ok bool) interface{}) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.items[key]
+	value = fn(value, ok)
+	m.items[key] = value
+	return value
+}
+
+// Len returns the number of keys
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.items)
+}
+
+// Keys returns the keys in sorted order
+func (m *Map) Keys() []string {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.items))
+	for key := range m.items {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// Queue is a bounded first-in first-out queue that is safe for concurrent
+// use, for producer/consumer pipelines. A full queue holds back producers
+// until a consumer catches up. Closing it tells consumers that no more
+// values are coming; they still receive the values already queued.
+type Queue struct {
+	ch *Channel
+}
+
+// NewQueue creates a queue that holds up to capacity values
+func NewQueue(capacity int) *Queue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Queue{ch: NewChannel(capacity)}
+}
+
+// Push adds value to the queue, waiting up to wait for room. It reports
+// whether the value was added, or ErrQueueClosed if the queue is closed.
+func (q *Queue) Push(value interface{}, wait time.Duration) (bool, error) {
+	pushed, err := q.ch.Send(value, max(wait, 0))
+	if err != nil {
+		return false, ErrQueueClosed
+	}
+	return pushed, nil
+}
+
+// Pop removes the oldest value, waiting up to wait for one. It reports
+// false if none arrived in time or the queue is closed and empty.
+func (q *Queue) Pop(wait time.Duration) (interface{}, bool) {
+	return q.ch.Recv(max(wait, 0))
+}
+
+// Close stops the queue accepting values
+func (q *Queue) Close() {
+	q.ch.Close()
+}
+
+// Closed reports whether the queue is closed and empty, so a consumer has
+// seen every value
+func (q *Queue) Closed() bool {
+	return q.ch.Drained()
+}
+
+// Len returns the number of queued values
+func (q *Queue) Len() int {
+	return q.ch.Len()
+}
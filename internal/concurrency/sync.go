@@ -0,0 +1,123 @@
+package concurrency
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotLocked is returned when unlocking a mutex that is not locked
+var ErrNotLocked = errors.New("mutex is not locked")
+
+// ErrNegativeCount is returned when a wait group's counter would go below 0
+var ErrNegativeCount = errors.New("wait group counter would go below 0")
+
+// Mutex is a lock that, unlike sync.Mutex, can be waited for with a
+// timeout. Like sync.Mutex it is not tied to the goroutine that locked it.
+type Mutex struct {
+	ch chan struct{}
+}
+
+// NewMutex creates an unlocked mutex
+func NewMutex() *Mutex {
+	return &Mutex{ch: make(chan struct{}, 1)}
+}
+
+// Lock takes the lock, waiting up to wait for it, and reports whether it
+// did
+func (m *Mutex) Lock(wait time.Duration) bool {
+	select {
+	case m.ch <- struct{}{}:
+		return true
+	default:
+	}
+	if wait == 0 {
+		return false
+	}
+	fire, stop := timeout(wait)
+	defer stop()
+	select {
+	case m.ch <- struct{}{}:
+		return true
+	case <-fire:
+		return false
+	}
+}
+
+// Unlock releases the lock
+func (m *Mutex) Unlock() error {
+	select {
+	case <-m.ch:
+		return nil
+	default:
+		return ErrNotLocked
+	}
+}
+
+// Locked reports whether the mutex is locked
+func (m *Mutex) Locked() bool {
+	return len(m.ch) == 1
+}
+
+// WaitGroup waits for a number of tasks to finish, like sync.WaitGroup,
+// with a timeout on the wait
+type WaitGroup struct {
+	mu    sync.Mutex
+	count int
+	zero  chan struct{} // Closed while count is 0
+}
+
+// NewWaitGroup creates a wait group with nothing to wait for
+func NewWaitGroup() *WaitGroup {
+	wg := &WaitGroup{zero: make(chan struct{})}
+	close(wg.zero)
+	return wg
+}
+
+// Add adds delta, which may be negative, to the number of tasks
+func (wg *WaitGroup) Add(delta int) error {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.count+delta < 0 {
+		return ErrNegativeCount
+	}
+	if wg.count == 0 && delta > 0 {
+		wg.zero = make(chan struct{})
+	}
+	wg.count += delta
+	if wg.count == 0 && delta < 0 {
+		close(wg.zero)
+	}
+	return nil
+}
+
+// Wait waits up to wait for the number of tasks to reach 0 and reports
+// whether it did
+func (wg *WaitGroup) Wait(wait time.Duration) bool {
+	wg.mu.Lock()
+	zero := wg.zero
+	wg.mu.Unlock()
+	select {
+	case <-zero:
+		return true
+	default:
+	}
+	if wait == 0 {
+		return false
+	}
+	fire, stop := timeout(wait)
+	defer stop()
+	select {
+	case <-zero:
+		return true
+	case <-fire:
+		return false
+	}
+}
+
+// Count returns the number of tasks not yet done
+func (wg *WaitGroup) Count() int {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return wg.count
+}
@@ -0,0 +1,84 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// openDaemon exports an image from the local Docker daemon, as docker
+// save does, to read it like an archive. The daemon is the one DOCKER_HOST
+// names, or /var/run/docker.sock.
+func openDaemon(name string, opts ScanOptions) (*Image, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{ResponseHeaderTimeout: timeout}
+	base := ""
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		socket := strings.TrimPrefix(host, "unix://")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		base = "http://docker"
+	case strings.HasPrefix(host, "tcp://"):
+		transport.DialContext = dialer.DialContext
+		base = "http://" + strings.TrimPrefix(host, "tcp://")
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST '%s': use unix:// or tcp://", host)
+	}
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(base + "/images/" + url.PathEscape(name) + "/get")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the Docker daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		return nil, fmt.Errorf("Docker daemon returned %s for %s: %s", resp.Status, name, e.Message)
+	}
+
+	tmp, err := os.CreateTemp("", "sentra-image-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(tmp, resp.Body)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to export %s: %w", name, err)
+	}
+	store, err := openTarStore(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	// The export is removed with the image
+	store.temp = true
+	img, err := readLayout(store, opts.Platform)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	img.Name = name
+	return img, nil
+}
@@ -0,0 +1,520 @@
+package container
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Image is a container image to scan: its configuration, and its layers
+// from whichever source it came from
+type Image struct {
+	Name         string
+	ID           string // The digest of its configuration
+	OS           string
+	Architecture string
+	Config       ImageConfig
+	Layers       []Layer
+	store        blobStore
+}
+
+// ImageConfig is the part of an image's configuration the scanner checks
+type ImageConfig struct {
+	User         string
+	ExposedPorts []string
+	Env          []string
+	Entrypoint   []string
+	Cmd          []string
+	Healthcheck  bool
+	Labels       map[string]string
+}
+
+// Layer is a layer of an image, a tar archive of the files it changes
+type Layer struct {
+	Digest    string
+	Size      int64
+	MediaType string
+	Command   string // The instruction that created it
+	Created   time.Time
+	blob      string // Its name in the image's store
+}
+
+// blobStore reads the files of an image: the entries of an archive, the
+// files of a directory or the blobs of a registry
+type blobStore interface {
+	open(name string) (io.ReadCloser, error)
+	size(name string) int64
+	Close() error
+}
+
+// Close removes the temporary files of the image
+func (img *Image) Close() error {
+	if img.store == nil {
+		return nil
+	}
+	return img.store.Close()
+}
+
+// OpenImage finds an image by reference: the path of a docker save or OCI
+// layout archive or directory, the name of an image in the local Docker
+// daemon, or a registry reference such as alpine:3.19 or
+// ghcr.io/org/app@sha256:.... opts.Source picks one, or by default a
+// path that exists and otherwise a registry.
+func OpenImage(ref string, opts ScanOptions) (*Image, error) {
+	source := opts.Source
+	if source == "" || source == "auto" {
+		source = "registry"
+		if _, err := os.Stat(ref); err == nil {
+			source = "file"
+		} else if looksLikePath(ref) {
+			return nil, err
+		}
+	}
+	switch source {
+	case "file":
+		return openArchive(ref, opts.Platform)
+	case "docker":
+		return openDaemon(ref, opts)
+	case "registry":
+		return openRegistry(ref, opts)
+	}
+	return nil, fmt.Errorf("unknown image source '%s': use file, docker or registry", source)
+}
+
+func looksLikePath(ref string) bool {
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(ref, suffix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, ".") || filepath.IsAbs(ref)
+}
+
+// openArchive opens an image saved with docker save, or in an OCI image
+// layout, as a tar archive, compressed or not, or a directory
+func openArchive(name, platform string) (*Image, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	var store blobStore
+	if info.IsDir() {
+		store = dirStore(name)
+	} else if store, err = openTarStore(name); err != nil {
+		return nil, err
+	}
+	img, err := readLayout(store, platform)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	img.Name = name
+	return img, nil
+}
+
+// tarStore reads the entries of a tar archive in place
+type tarStore struct {
+	f       *os.File
+	entries map[string]tarEntry
+	temp    bool // f is a decompressed copy to remove
+}
+
+type tarEntry struct {
+	offset, size int64
+}
+
+// countingReader counts the bytes read through it, which gives the
+// offset of each entry's data in the archive
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func openTarStore(name string) (*tarStore, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &tarStore{f: f, entries: map[string]tarEntry{}}
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		// Compressed archives are read in place once decompressed
+		if err := s.decompress(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	links := map[string]string{}
+	cr := &countingReader{r: s.f}
+	tr := tar.NewReader(cr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("not an image archive: %w", err)
+		}
+		entry := path.Clean(strings.TrimPrefix(h.Name, "./"))
+		switch h.Typeflag {
+		case tar.TypeReg:
+			s.entries[entry] = tarEntry{offset: cr.n, size: h.Size}
+		case tar.TypeSymlink, tar.TypeLink:
+			// docker save links the layers that images share
+			target := h.Linkname
+			if h.Typeflag == tar.TypeSymlink {
+				target = path.Join(path.Dir(entry), target)
+			}
+			links[entry] = path.Clean(target)
+		}
+	}
+	for entry, target := range links {
+		if e, ok := s.entries[target]; ok {
+			s.entries[entry] = e
+		}
+	}
+	return s, nil
+}
+
+func (s *tarStore) decompress() error {
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(s.f)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "sentra-image-*.tar")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, gz)
+	s.f.Close()
+	s.f, s.temp = tmp, true
+	if err != nil {
+		return fmt.Errorf("failed to decompress image archive: %w", err)
+	}
+	return nil
+}
+
+func (s *tarStore) open(name string) (io.ReadCloser, error) {
+	e, ok := s.entries[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the archive", name)
+	}
+	return io.NopCloser(io.NewSectionReader(s.f, e.offset, e.size)), nil
+}
+
+func (s *tarStore) size(name string) int64 {
+	return s.entries[path.Clean(name)].size
+}
+
+func (s *tarStore) Close() error {
+	err := s.f.Close()
+	if s.temp {
+		os.Remove(s.f.Name())
+	}
+	return err
+}
+
+// dirStore reads an extracted archive
+type dirStore string
+
+func (d dirStore) file(name string) (string, error) {
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return "", fmt.Errorf("%s is outside the image directory", name)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d dirStore) open(name string) (io.ReadCloser, error) {
+	file, err := d.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(file)
+}
+
+func (d dirStore) size(name string) int64 {
+	file, err := d.file(name)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (d dirStore) Close() error { return nil }
+
+// readBlob reads a whole file of a store, up to 64MB
+func readBlob(store blobStore, name string) ([]byte, error) {
+	r, err := store.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, 64<<20))
+}
+
+// readLayout reads an image from a docker save archive, whose
+// manifest.json lists the configuration and layers, or an OCI image
+// layout, whose index.json leads to them
+func readLayout(store blobStore, platform string) (*Image, error) {
+	if data, err := readBlob(store, "manifest.json"); err == nil {
+		var manifests []struct {
+			Config string
+			Layers []string
+		}
+		if err := json.Unmarshal(data, &manifests); err != nil || len(manifests) == 0 {
+			return nil, fmt.Errorf("invalid manifest.json")
+		}
+		m := manifests[0]
+		config, err := readBlob(store, m.Config)
+		if err != nil {
+			return nil, err
+		}
+		img, diffIDs, err := imageFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		for i, blob := range m.Layers {
+			l := Layer{blob: blob, Size: store.size(blob), MediaType: "application/vnd.docker.image.rootfs.diff.tar"}
+			if i < len(diffIDs) {
+				l.Digest = diffIDs[i]
+			}
+			img.Layers = append(img.Layers, l)
+		}
+		img.describeLayers(config)
+		img.store = store
+		return img, nil
+	}
+
+	data, err := readBlob(store, "index.json")
+	if err != nil {
+		return nil, fmt.Errorf("neither a docker save archive nor an OCI image layout")
+	}
+	manifest, err := resolveManifest(data, platform, func(digest string) ([]byte, error) {
+		return readBlob(store, blobPath(digest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	img, err := imageFromManifest(manifest, func(digest string) ([]byte, error) {
+		return readBlob(store, blobPath(digest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range img.Layers {
+		img.Layers[i].blob = blobPath(img.Layers[i].Digest)
+	}
+	img.store = store
+	return img, nil
+}
+
+// blobPath is where an OCI image layout keeps a blob
+func blobPath(digest string) string {
+	alg, hash, _ := strings.Cut(digest, ":")
+	return "blobs/" + alg + "/" + hash
+}
+
+// descriptor points to a manifest, configuration or layer by digest
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+// manifest is an image manifest, or an index of the manifests of each
+// platform of an image
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// resolveManifest follows indexes down to the image manifest for the
+// platform, os/arch[/variant], by default linux and the architecture
+// Sentra runs on. Without a platform, an image with only another
+// architecture is taken as it is.
+func resolveManifest(data []byte, platform string, fetch func(digest string) ([]byte, error)) (*manifest, error) {
+	want := platform
+	if want == "" {
+		want = "linux/" + runtime.GOARCH
+	}
+	for depth := 0; depth < 4; depth++ {
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+		if len(m.Manifests) == 0 {
+			if m.Config.Digest == "" {
+				return nil, fmt.Errorf("manifest has no configuration")
+			}
+			return &m, nil
+		}
+		d, err := selectPlatform(m.Manifests, want, platform != "")
+		if err != nil {
+			return nil, err
+		}
+		if data, err = fetch(d.Digest); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("image indexes are nested too deeply")
+}
+
+func selectPlatform(list []descriptor, want string, strict bool) (descriptor, error) {
+	var available []string
+	var fallback *descriptor
+	for i, d := range list {
+		if d.Platform == nil {
+			// An OCI layout names the image itself
+			if len(list) == 1 {
+				return d, nil
+			}
+			continue
+		}
+		p := d.Platform.OS + "/" + d.Platform.Architecture
+		if p == "unknown/unknown" {
+			// Attestations, not images
+			continue
+		}
+		available = append(available, p)
+		if p == want || d.Platform.Variant != "" && p+"/"+d.Platform.Variant == want {
+			return d, nil
+		}
+		if fallback == nil {
+			fallback = &list[i]
+		}
+	}
+	if fallback != nil && !strict {
+		return *fallback, nil
+	}
+	return descriptor{}, fmt.Errorf("image has no %s variant; it has %s", want, strings.Join(available, ", "))
+}
+
+// imageFromManifest reads the configuration an image manifest points to,
+// and its layers
+func imageFromManifest(m *manifest, fetch func(digest string) ([]byte, error)) (*Image, error) {
+	config, err := fetch(m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := imageFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range m.Layers {
+		img.Layers = append(img.Layers, Layer{Digest: l.Digest, Size: l.Size, MediaType: l.MediaType})
+	}
+	img.describeLayers(config)
+	return img, nil
+}
+
+// configJSON is the part of an image configuration the scanner reads
+type configJSON struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		User         string              `json:"User"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Env          []string            `json:"Env"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		Cmd          []string            `json:"Cmd"`
+		Healthcheck  *struct {
+			Test []string `json:"Test"`
+		} `json:"Healthcheck"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		Created    time.Time `json:"created"`
+		CreatedBy  string    `json:"created_by"`
+		EmptyLayer bool      `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// imageFromConfig reads an image configuration, and returns the digests
+// of the uncompressed layers it lists
+func imageFromConfig(data []byte) (*Image, []string, error) {
+	var c configJSON
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, nil, fmt.Errorf("invalid image configuration: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	img := &Image{
+		ID:           "sha256:" + hex.EncodeToString(sum[:]),
+		OS:           c.OS,
+		Architecture: c.Architecture,
+		Config: ImageConfig{
+			User:       c.Config.User,
+			Env:        c.Config.Env,
+			Entrypoint: c.Config.Entrypoint,
+			Cmd:        c.Config.Cmd,
+			Labels:     c.Config.Labels,
+		},
+	}
+	for port := range c.Config.ExposedPorts {
+		img.Config.ExposedPorts = append(img.Config.ExposedPorts, port)
+	}
+	if hc := c.Config.Healthcheck; hc != nil && len(hc.Test) > 0 && hc.Test[0] != "NONE" {
+		img.Config.Healthcheck = true
+	}
+	return img, c.RootFS.DiffIDs, nil
+}
+
+// describeLayers takes the instruction that created each layer, and when,
+// from the history in the configuration
+func (img *Image) describeLayers(config []byte) {
+	var c configJSON
+	if json.Unmarshal(config, &c) != nil {
+		return
+	}
+	i := 0
+	for _, h := range c.History {
+		if h.EmptyLayer {
+			continue
+		}
+		if i >= len(img.Layers) {
+			break
+		}
+		img.Layers[i].Command = strings.TrimSpace(strings.TrimPrefix(h.CreatedBy, "/bin/sh -c #(nop) "))
+		img.Layers[i].Created = h.Created
+		i++
+	}
+}
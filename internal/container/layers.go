@@ -0,0 +1,375 @@
+package container
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"sentra/internal/binaryanalysis"
+)
+
+// Limits on what the scan reads from layers into memory
+const (
+	maxPackageDB  = 256 << 20
+	maxSecretFile = 1 << 20
+	maxExecutable = 32 << 20
+)
+
+// openLayer returns the tar stream of a layer, decompressing gzip and
+// zstd layers
+func openLayer(store blobStore, l Layer) (io.ReadCloser, error) {
+	rc, err := store.open(l.blob)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rc)
+	magic, _ := br.Peek(4)
+	var r io.Reader = br
+	closeFn := rc.Close
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		r = gz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		r = zr
+		closeFn = func() error {
+			zr.Close()
+			return rc.Close()
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, closer(closeFn)}, nil
+}
+
+type closer func() error
+
+func (c closer) Close() error { return c() }
+
+// tracked is a file the scan reads once every layer is applied: the
+// os-release and package databases
+type tracked struct {
+	layer int
+	data  []byte
+}
+
+// layerWalk is the state of a scan as it applies each layer
+type layerWalk struct {
+	img      *Image
+	opts     ScanOptions
+	result   *ScanResult
+	files    map[string]tracked
+	packages map[string][]Package       // The packages of each database
+	previous map[string]map[string]bool // Package keys of each database
+	added    map[string]int             // The layer that installed each package key
+}
+
+// walkLayers reads each layer in order, keeping the package databases and
+// os-release the image ends with, and reporting the secrets, suspicious
+// executables and setuid files each layer adds. Files a later layer
+// deletes are still reported, since they remain in the image.
+func (w *layerWalk) walkLayers() error {
+	for i, l := range w.img.Layers {
+		info, err := w.walkLayer(i, l)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", l.Digest, err)
+		}
+		w.result.Layers = append(w.result.Layers, info)
+		// Packages are attributed to the first layer their database
+		// lists them in
+		for name, f := range w.files {
+			kind := packageDB(name)
+			if kind == "" || f.layer != i {
+				continue
+			}
+			pkgs, err := parsePackages(kind, f.data)
+			if err != nil {
+				w.warn(fmt.Sprintf("/%s in layer %s: %v", name, l.Digest, err))
+				delete(w.packages, name)
+				continue
+			}
+			w.packages[name] = pkgs
+			keys := map[string]bool{}
+			for _, p := range pkgs {
+				keys[p.key()] = true
+				if !w.previous[name][p.key()] {
+					w.added[p.key()] = i
+				}
+			}
+			w.previous[name] = keys
+		}
+	}
+	return nil
+}
+
+func (w *layerWalk) warn(msg string) {
+	w.result.Warnings = append(w.result.Warnings, msg)
+}
+
+func (w *layerWalk) walkLayer(i int, l Layer) (LayerInfo, error) {
+	info := LayerInfo{ID: l.Digest, Size: l.Size, Command: l.Command, Created: l.Created}
+	r, err := openLayer(w.img.store, l)
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+
+	var setuid []string
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+		name := path.Clean(strings.TrimPrefix(strings.TrimPrefix(h.Name, "./"), "/"))
+		dir, base := path.Split(name)
+		if strings.HasPrefix(base, ".wh.") {
+			w.whiteout(path.Clean(dir), base)
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			if h.Typeflag == tar.TypeDir || h.Typeflag == tar.TypeSymlink || h.Typeflag == tar.TypeLink {
+				// A file replaced by a link or directory is gone
+				delete(w.files, name)
+			}
+			continue
+		}
+		info.Files++
+		if l.Size == 0 {
+			info.Size += h.Size
+		}
+		if h.Mode&0o6000 != 0 && h.Mode&0o111 != 0 {
+			setuid = append(setuid, "/"+name)
+		}
+
+		if packageDB(name) != "" || name == "etc/os-release" || name == "usr/lib/os-release" {
+			if h.Size > maxPackageDB {
+				w.warn(fmt.Sprintf("/%s in layer %s is too large to read", name, l.Digest))
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return info, err
+			}
+			w.files[name] = tracked{layer: i, data: data}
+			continue
+		}
+
+		if h.Size < 4 {
+			continue
+		}
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(tr, head); err != nil {
+			return info, err
+		}
+		if isExecutable(head) {
+			if !w.opts.NoMalware && h.Size <= maxExecutable {
+				if err := w.checkExecutable(tr, head, h, name, l); err != nil {
+					return info, err
+				}
+			}
+			continue
+		}
+		if !w.opts.NoSecrets && h.Size <= maxSecretFile && !skipSecrets(name) {
+			rest, err := io.ReadAll(tr)
+			if err != nil {
+				return info, err
+			}
+			w.result.Secrets = append(w.result.Secrets, findSecrets(append(head, rest...), "/"+name, l.Digest)...)
+		}
+	}
+
+	if len(setuid) > 0 {
+		sort.Strings(setuid)
+		shown := setuid
+		if len(shown) > 5 {
+			shown = append(shown[:5:5], fmt.Sprintf("and %d more", len(setuid)-5))
+		}
+		w.result.ComplianceIssues = append(w.result.ComplianceIssues, ComplianceIssue{
+			RuleID:      "CIS-4.8",
+			Category:    "File Permissions",
+			Severity:    "low",
+			Description: fmt.Sprintf("%d setuid or setgid executables: %s", len(setuid), strings.Join(shown, ", ")),
+			Remediation: "Remove the setuid and setgid bits with chmod a-s unless the application needs them",
+			Layer:       l.Digest,
+		})
+	}
+	return info, nil
+}
+
+// whiteout applies an overlay whiteout: .wh.name deletes name, and
+// .wh..wh..opq hides everything earlier layers put in the directory
+func (w *layerWalk) whiteout(dir, base string) {
+	if base == ".wh..wh..opq" {
+		for name := range w.files {
+			if strings.HasPrefix(name, dir+"/") {
+				delete(w.files, name)
+			}
+		}
+		return
+	}
+	target := path.Join(dir, strings.TrimPrefix(base, ".wh."))
+	for name := range w.files {
+		if name == target || strings.HasPrefix(name, target+"/") {
+			delete(w.files, name)
+		}
+	}
+}
+
+// isExecutable reports whether a file starts like an ELF, PE or Mach-O
+// executable
+func isExecutable(head []byte) bool {
+	if bytes.Equal(head, []byte("\x7fELF")) || head[0] == 'M' && head[1] == 'Z' {
+		return true
+	}
+	switch string(head) {
+	case "\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe":
+		return true
+	}
+	return false
+}
+
+// tempDirs are where droppers leave executables
+var tempDirs = []string{"tmp/", "var/tmp/", "dev/shm/"}
+
+// checkExecutable reports packed executables, which hide what they do
+// from scanners, and executables in temporary directories
+func (w *layerWalk) checkExecutable(tr io.Reader, head []byte, h *tar.Header, name string, l Layer) error {
+	data := make([]byte, h.Size)
+	copy(data, head)
+	if _, err := io.ReadFull(tr, data[len(head):]); err != nil {
+		return err
+	}
+	inTemp := false
+	for _, dir := range tempDirs {
+		if strings.HasPrefix(name, dir) {
+			inTemp = true
+		}
+	}
+	a, err := binaryanalysis.AnalyzeReader(bytes.NewReader(data), h.Size)
+	if err != nil {
+		// Not an executable after all
+		return nil
+	}
+	if a.Packed {
+		packer := "Generic"
+		if len(a.Packers) > 0 {
+			packer = a.Packers[0]
+		}
+		w.result.Malware = append(w.result.Malware, MalwareFinding{
+			Name:     "Packed." + packer,
+			Type:     "packed executable",
+			File:     "/" + name,
+			Hash:     a.SHA256,
+			Severity: "high",
+			Details:  strings.Join(a.Indicators, "; "),
+			Layer:    l.Digest,
+		})
+	} else if inTemp {
+		sum := sha256.Sum256(data)
+		w.result.Malware = append(w.result.Malware, MalwareFinding{
+			Name:     "Suspicious.TempExecutable",
+			Type:     "executable in a temporary directory",
+			File:     "/" + name,
+			Hash:     hex.EncodeToString(sum[:]),
+			Severity: "medium",
+			Details:  fmt.Sprintf("%s %s %s", a.Format, a.Arch, a.Type),
+			Layer:    l.Digest,
+		})
+	}
+	return nil
+}
+
+// secretPatterns are credentials with a recognisable format
+var secretPatterns = []struct {
+	kind     string
+	pattern  *regexp.Regexp
+	severity string
+}{
+	{"AWS Access Key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), "critical"},
+	{"Private Key", regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`), "high"},
+	{"GitHub Token", regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}\b`), "critical"},
+	{"GitLab Token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20}\b`), "critical"},
+	{"Slack Token", regexp.MustCompile(`\bxox[baprs]-[A-Za-z0-9-]{10,}\b`), "high"},
+	{"Google API Key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`), "high"},
+	{"Stripe Key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`), "critical"},
+	{"npm Token", regexp.MustCompile(`_authToken=[A-Za-z0-9_\-]{20,}`), "high"},
+}
+
+// envSecret matches environment variables whose names say they hold a
+// credential
+var envSecret = regexp.MustCompile(`(?i)^[A-Z0-9_]*(PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_KEY|ACCESS_KEY)[A-Z0-9_]*=(.{6,})$`)
+
+// skipSecrets reports whether a path holds documentation or test
+// fixtures, whose example keys are not secrets
+func skipSecrets(name string) bool {
+	for _, prefix := range []string{"usr/share/doc/", "usr/share/man/", "usr/share/locale/", "usr/include/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "test" || part == "tests" || part == "testdata" || part == "fixtures" {
+			return true
+		}
+	}
+	return false
+}
+
+// findSecrets returns the credentials in a text file
+func findSecrets(data []byte, file, layer string) []SecretFinding {
+	if bytes.IndexByte(data[:min(len(data), 8192)], 0) >= 0 {
+		// Binary files are not text configuration
+		return nil
+	}
+	var found []SecretFinding
+	for _, p := range secretPatterns {
+		for _, loc := range p.pattern.FindAllIndex(data, 5) {
+			found = append(found, SecretFinding{
+				Type:     p.kind,
+				File:     file,
+				Line:     bytes.Count(data[:loc[0]], []byte("\n")) + 1,
+				Match:    mask(string(data[loc[0]:loc[1]])),
+				Severity: p.severity,
+				Layer:    layer,
+			})
+		}
+	}
+	return found
+}
+
+// mask hides all but the start of a secret
+func mask(s string) string {
+	keep := 4
+	if strings.HasPrefix(s, "-----") {
+		return s
+	}
+	if len(s) <= keep {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:keep] + strings.Repeat("*", len(s)-keep)
+}
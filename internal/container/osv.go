@@ -0,0 +1,545 @@
+package container
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sentra/internal/cvss"
+)
+
+// osvAPI and osvFeeds are where OSV serves queries and the exports of
+// each ecosystem; tests point them elsewhere
+var (
+	osvAPI   = "https://api.osv.dev/v1"
+	osvFeeds = "https://osv-vulnerabilities.storage.googleapis.com"
+)
+
+// osvTTL is how long cached OSV answers and exports are used
+const osvTTL = 24 * time.Hour
+
+// osvVuln is an OSV advisory
+type osvVuln struct {
+	ID       string   `json:"id"`
+	Modified string   `json:"modified"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Details  string   `json:"details"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions          []string               `json:"versions"`
+		EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+		DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+	} `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// ecosystem returns the OSV ecosystem of an image's distribution, from
+// its os-release, or "" for distributions OSV does not cover
+func ecosystem(release map[string]string) string {
+	version := release["VERSION_ID"]
+	major, _, _ := strings.Cut(version, ".")
+	switch release["ID"] {
+	case "alpine":
+		parts := strings.Split(version, ".")
+		if len(parts) < 2 {
+			return ""
+		}
+		return "Alpine:v" + parts[0] + "." + parts[1]
+	case "debian":
+		if major == "" {
+			return ""
+		}
+		return "Debian:" + major
+	case "ubuntu":
+		if version == "" {
+			return ""
+		}
+		if strings.Contains(release["VERSION"], "LTS") {
+			return "Ubuntu:" + version + ":LTS"
+		}
+		return "Ubuntu:" + version
+	case "rocky":
+		return "Rocky Linux:" + major
+	case "almalinux":
+		return "AlmaLinux:" + major
+	case "wolfi":
+		return "Wolfi"
+	case "chainguard":
+		return "Chainguard"
+	case "mageia":
+		return "Mageia:" + version
+	}
+	return ""
+}
+
+// osvName is the name and version a package has in OSV advisories: its
+// source package for Debian, Ubuntu and Alpine, its own for rpm
+func osvName(p Package) (string, string) {
+	if p.Type == "rpm" {
+		return p.Name, p.Version
+	}
+	return p.Source, p.SourceVersion
+}
+
+// vulnSource finds the advisories that affect package versions of an
+// ecosystem
+type vulnSource interface {
+	lookup(eco string, pkgs []Package) (map[string][]*osvVuln, error)
+}
+
+// newVulnSource returns the vulnerability database opts.VulnDB names:
+// osv for the OSV API, feed for the OSV export of the ecosystem, or the
+// path of an OSV export as a zip file or a directory of JSON files
+func newVulnSource(opts ScanOptions) (vulnSource, error) {
+	cacheDir := opts.CacheDir
+	if cacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+		cacheDir = filepath.Join(dir, "sentra", "osv")
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch opts.VulnDB {
+	case "", "osv":
+		return &osvClient{http: client, cacheDir: cacheDir}, nil
+	case "feed":
+		return &osvFeed{http: client, cacheDir: cacheDir}, nil
+	}
+	if _, err := os.Stat(opts.VulnDB); err != nil {
+		return nil, fmt.Errorf("vulnerability database: %w", err)
+	}
+	return osvExport(opts.VulnDB), nil
+}
+
+// osvClient asks the OSV API which advisories affect each package
+// version, caching the answers and advisories on disk
+type osvClient struct {
+	http     *http.Client
+	cacheDir string
+}
+
+type osvQuery struct {
+	Package struct {
+		Name      string `json:"name"`
+		Ecosystem string `json:"ecosystem"`
+	} `json:"package"`
+	Version   string `json:"version"`
+	PageToken string `json:"page_token,omitempty"`
+}
+
+// cached reads a cache file written less than osvTTL ago
+func cached(file string) ([]byte, bool) {
+	info, err := os.Stat(file)
+	if err != nil || time.Since(info.ModTime()) > osvTTL {
+		return nil, false
+	}
+	data, err := os.ReadFile(file)
+	return data, err == nil
+}
+
+func writeCache(file string, data []byte) {
+	if os.MkdirAll(filepath.Dir(file), 0o755) == nil {
+		os.WriteFile(file, data, 0o644)
+	}
+}
+
+func (c *osvClient) lookup(eco string, pkgs []Package) (map[string][]*osvVuln, error) {
+	// Each name and version is asked about once, however many packages
+	// it is the source of
+	type query struct{ name, version, file string }
+	var pending []query
+	ids := map[string][]string{}
+	seen := map[string]bool{}
+	for _, p := range pkgs {
+		name, version := osvName(p)
+		key := name + "@" + version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		sum := sha256.Sum256([]byte(eco + "\x00" + key))
+		file := filepath.Join(c.cacheDir, "queries", hex.EncodeToString(sum[:])+".json")
+		if data, ok := cached(file); ok {
+			var found []string
+			if json.Unmarshal(data, &found) == nil {
+				ids[key] = found
+				continue
+			}
+		}
+		pending = append(pending, query{name, version, file})
+	}
+
+	for start := 0; start < len(pending); start += 1000 {
+		batch := pending[start:min(start+1000, len(pending))]
+		queries := make([]osvQuery, len(batch))
+		for i, q := range batch {
+			queries[i].Package.Name, queries[i].Package.Ecosystem, queries[i].Version = q.name, eco, q.version
+		}
+		for len(queries) > 0 {
+			var resp struct {
+				Results []struct {
+					Vulns []struct {
+						ID string `json:"id"`
+					} `json:"vulns"`
+					NextPageToken string `json:"next_page_token"`
+				} `json:"results"`
+			}
+			if err := c.post("/querybatch", map[string]interface{}{"queries": queries}, &resp); err != nil {
+				return nil, err
+			}
+			var more []osvQuery
+			for i, r := range resp.Results {
+				if i >= len(queries) {
+					break
+				}
+				key := queries[i].Package.Name + "@" + queries[i].Version
+				for _, v := range r.Vulns {
+					ids[key] = append(ids[key], v.ID)
+				}
+				if r.NextPageToken != "" {
+					q := queries[i]
+					q.PageToken = r.NextPageToken
+					more = append(more, q)
+				}
+			}
+			queries = more
+		}
+		for _, q := range batch {
+			data, _ := json.Marshal(ids[q.name+"@"+q.version])
+			writeCache(q.file, data)
+		}
+	}
+
+	vulns := map[string]*osvVuln{}
+	found := map[string][]*osvVuln{}
+	for key, list := range ids {
+		for _, id := range list {
+			if vulns[id] == nil {
+				v, err := c.vuln(id)
+				if err != nil {
+					return nil, err
+				}
+				vulns[id] = v
+			}
+			found[key] = append(found[key], vulns[id])
+		}
+	}
+	return found, nil
+}
+
+// vuln returns an advisory by id
+func (c *osvClient) vuln(id string) (*osvVuln, error) {
+	file := filepath.Join(c.cacheDir, "vulns", url.PathEscape(id)+".json")
+	data, ok := cached(file)
+	if !ok {
+		resp, err := c.http.Get(osvAPI + "/vulns/" + url.PathEscape(id))
+		if err != nil {
+			return nil, fmt.Errorf("OSV request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("OSV returned %s for %s", resp.Status, id)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 16<<20)); err != nil {
+			return nil, err
+		}
+		writeCache(file, data)
+	}
+	var v osvVuln
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid OSV advisory %s: %w", id, err)
+	}
+	return &v, nil
+}
+
+func (c *osvClient) post(path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Post(osvAPI+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("OSV request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OSV returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// osvFeed downloads the OSV export of an ecosystem, at most daily, and
+// matches versions locally; once cached it works offline
+type osvFeed struct {
+	http     *http.Client
+	cacheDir string
+}
+
+func (f *osvFeed) lookup(eco string, pkgs []Package) (map[string][]*osvVuln, error) {
+	base, _, _ := strings.Cut(eco, ":")
+	file := filepath.Join(f.cacheDir, "feeds", base+".zip")
+	if _, fresh := cached(file); !fresh {
+		if err := f.download(base, file); err != nil {
+			if _, statErr := os.Stat(file); statErr != nil {
+				return nil, err
+			}
+			// A stale export is better than none
+		}
+	}
+	return osvExport(file).lookup(eco, pkgs)
+}
+
+func (f *osvFeed) download(base, file string) error {
+	resp, err := f.http.Get(osvFeeds + "/" + url.PathEscape(base) + "/all.zip")
+	if err != nil {
+		return fmt.Errorf("failed to download the %s OSV export: %w", base, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download the %s OSV export: %s", base, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), base+"-*.zip")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, resp.Body)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to download the %s OSV export: %w", base, err)
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// osvExport is an OSV export on disk: a zip file like the all.zip of each
+// ecosystem, or a directory of advisories as JSON files
+type osvExport string
+
+func (e osvExport) lookup(eco string, pkgs []Package) (map[string][]*osvVuln, error) {
+	wanted := map[string][]Package{}
+	for _, p := range pkgs {
+		name, _ := osvName(p)
+		wanted[name] = append(wanted[name], p)
+	}
+	found := map[string][]*osvVuln{}
+	seen := map[string]bool{}
+	err := e.each(func(data []byte) {
+		var v osvVuln
+		if json.Unmarshal(data, &v) != nil {
+			return
+		}
+		for _, a := range v.Affected {
+			if a.Package.Ecosystem != eco {
+				continue
+			}
+			for _, p := range wanted[a.Package.Name] {
+				name, version := osvName(p)
+				key := name + "@" + version
+				if seen[key+" "+v.ID] {
+					continue
+				}
+				if ok, _ := v.affects(eco, name, version, p.Type); ok {
+					seen[key+" "+v.ID] = true
+					found[key] = append(found[key], &v)
+				}
+			}
+		}
+	})
+	return found, err
+}
+
+// each calls fn with every advisory of the export
+func (e osvExport) each(fn func([]byte)) error {
+	info, err := os.Stat(string(e))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return filepath.Walk(string(e), func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(file, ".json") {
+				return err
+			}
+			data, err := os.ReadFile(file)
+			if err == nil {
+				fn(data)
+			}
+			return nil
+		})
+	}
+	z, err := zip.OpenReader(string(e))
+	if err != nil {
+		return fmt.Errorf("invalid OSV export %s: %w", e, err)
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err == nil {
+			fn(data)
+		}
+	}
+	return nil
+}
+
+// affects reports whether an advisory affects a version of a package, and
+// the version that fixes it if there is one
+func (v *osvVuln) affects(eco, name, version, kind string) (bool, string) {
+	for _, a := range v.Affected {
+		if a.Package.Ecosystem != eco || a.Package.Name != name {
+			continue
+		}
+		for _, listed := range a.Versions {
+			if listed == version {
+				return true, v.fixedIn(eco, name, version, kind)
+			}
+		}
+		for _, r := range a.Ranges {
+			if r.Type != "ECOSYSTEM" {
+				continue
+			}
+			if hit, fixed := inRange(r.Events, version, kind); hit {
+				return true, fixed
+			}
+		}
+	}
+	return false, ""
+}
+
+// fixedIn returns the first fixed version after version
+func (v *osvVuln) fixedIn(eco, name, version, kind string) string {
+	for _, a := range v.Affected {
+		if a.Package.Ecosystem != eco || a.Package.Name != name {
+			continue
+		}
+		for _, r := range a.Ranges {
+			for _, e := range r.Events {
+				if fixed := e["fixed"]; fixed != "" && CompareVersions(kind, version, fixed) < 0 {
+					return fixed
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// inRange reports whether version falls between an introduced event and
+// the fixed or last_affected event after it
+func inRange(events []map[string]string, version, kind string) (bool, string) {
+	type event struct{ kind, version string }
+	var list []event
+	for _, e := range events {
+		for k, v := range e {
+			list = append(list, event{k, v})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].version == "0" || list[j].version == "0" {
+			return list[i].version == "0" && list[j].version != "0"
+		}
+		return CompareVersions(kind, list[i].version, list[j].version) < 0
+	})
+	affected := false
+	for _, e := range list {
+		switch e.kind {
+		case "introduced":
+			if e.version == "0" || CompareVersions(kind, version, e.version) >= 0 {
+				affected = true
+			}
+		case "fixed":
+			if CompareVersions(kind, version, e.version) >= 0 {
+				affected = false
+			} else if affected {
+				return true, e.version
+			}
+		case "last_affected":
+			if CompareVersions(kind, version, e.version) > 0 {
+				affected = false
+			} else if affected {
+				return true, ""
+			}
+		}
+	}
+	return affected, ""
+}
+
+// severity rates an advisory from its CVSS 4 or 3 vector, or the severity
+// its distribution gives it
+func (v *osvVuln) severity() (string, float64) {
+	for _, kind := range []string{"CVSS_V4", "CVSS_V3"} {
+		for _, s := range v.Severity {
+			if s.Type == kind {
+				if score, err := cvss.Calculate(s.Score); err == nil {
+					return score.Severity, score.Score
+				}
+			}
+		}
+	}
+	for _, s := range v.Severity {
+		if s.Type == "Ubuntu" {
+			return normalizeSeverity(s.Score), 0
+		}
+	}
+	candidates := []map[string]interface{}{v.DatabaseSpecific}
+	for _, a := range v.Affected {
+		candidates = append(candidates, a.EcosystemSpecific, a.DatabaseSpecific)
+	}
+	for _, m := range candidates {
+		for _, key := range []string{"severity", "urgency"} {
+			if s, ok := m[key].(string); ok && s != "" {
+				return normalizeSeverity(s), 0
+			}
+		}
+	}
+	return "unknown", 0
+}
+
+// normalizeSeverity maps the ratings distributions use to critical,
+// high, medium, low or unknown
+func normalizeSeverity(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return "critical"
+	case "high", "important":
+		return "high"
+	case "medium", "moderate":
+		return "medium"
+	case "low", "negligible", "unimportant":
+		return "low"
+	}
+	return "unknown"
+}
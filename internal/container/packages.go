@@ -0,0 +1,362 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Package is an operating system package installed in an image
+type Package struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Arch          string `json:"arch"`
+	Source        string `json:"source"` // The source package, which advisories name
+	SourceVersion string `json:"source_version"`
+	Type          string `json:"type"`  // apk, dpkg or rpm
+	Layer         string `json:"layer"` // The digest of the layer that installed this version
+}
+
+// key identifies a package version, to find the layer that installed it
+func (p Package) key() string {
+	return p.Type + "/" + p.Name + "@" + p.Version + "/" + p.Arch
+}
+
+// packageDB returns the kind of package database at a path in an image,
+// or "" for other files
+func packageDB(name string) string {
+	switch name {
+	case "lib/apk/db/installed":
+		return "apk"
+	case "var/lib/dpkg/status":
+		return "dpkg"
+	case "var/lib/rpm/rpmdb.sqlite", "usr/lib/sysimage/rpm/rpmdb.sqlite":
+		return "rpm-sqlite"
+	case "var/lib/rpm/Packages", "usr/lib/sysimage/rpm/Packages":
+		return "rpm-bdb"
+	case "var/lib/rpm/Packages.db", "usr/lib/sysimage/rpm/Packages.db":
+		return "rpm-ndb"
+	}
+	// Distroless images have a file per package
+	if path.Dir(name) == "var/lib/dpkg/status.d" && !strings.Contains(path.Base(name), ".") {
+		return "dpkg"
+	}
+	return ""
+}
+
+// parsePackages reads the packages in a package database
+func parsePackages(kind string, data []byte) ([]Package, error) {
+	switch kind {
+	case "apk":
+		return parseAPK(data), nil
+	case "dpkg":
+		return parseDpkg(data), nil
+	case "rpm-sqlite":
+		return parseRPMSQLite(data)
+	case "rpm-bdb":
+		return parseRPMBerkeley(data)
+	case "rpm-ndb":
+		return nil, fmt.Errorf("rpm ndb package databases are not supported")
+	}
+	return nil, fmt.Errorf("unknown package database %s", kind)
+}
+
+// stanzas splits a file of blank line separated records of key/value
+// lines, with sep between the key and value, calling fn with each
+func stanzas(data []byte, sep string, fn func(fields map[string]string)) {
+	fields := map[string]string{}
+	last := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(fields) > 0 {
+				fn(fields)
+			}
+			fields, last = map[string]string{}, ""
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// dpkg continues long fields on indented lines
+			if last != "" {
+				fields[last] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, sep)
+		if !ok {
+			continue
+		}
+		last = key
+		fields[key] = strings.TrimSpace(value)
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+}
+
+// parseAPK reads Alpine's lib/apk/db/installed, where P is the name, V the
+// version, A the architecture and o the origin package
+func parseAPK(data []byte) []Package {
+	var pkgs []Package
+	stanzas(data, ":", func(f map[string]string) {
+		if f["P"] == "" || f["V"] == "" {
+			return
+		}
+		p := Package{Name: f["P"], Version: f["V"], Arch: f["A"], Source: f["o"], SourceVersion: f["V"], Type: "apk"}
+		if p.Source == "" {
+			p.Source = p.Name
+		}
+		pkgs = append(pkgs, p)
+	})
+	return pkgs
+}
+
+// parseDpkg reads Debian's var/lib/dpkg/status, skipping packages that
+// were removed but left their configuration behind
+func parseDpkg(data []byte) []Package {
+	var pkgs []Package
+	stanzas(data, ":", func(f map[string]string) {
+		if f["Package"] == "" || f["Version"] == "" {
+			return
+		}
+		if status := strings.Fields(f["Status"]); len(status) == 3 && status[2] != "installed" {
+			return
+		}
+		p := Package{Name: f["Package"], Version: f["Version"], Arch: f["Architecture"], Type: "dpkg"}
+		// Source is the source package, with its version when it differs
+		p.Source, p.SourceVersion = p.Name, p.Version
+		if src := f["Source"]; src != "" {
+			name, version, ok := strings.Cut(src, " (")
+			p.Source = name
+			if ok {
+				p.SourceVersion = strings.TrimSuffix(version, ")")
+			}
+		}
+		pkgs = append(pkgs, p)
+	})
+	return pkgs
+}
+
+// parseRPMSQLite reads the rpmdb.sqlite of Fedora, RHEL 9 and later
+func parseRPMSQLite(data []byte) ([]Package, error) {
+	tmp, err := os.CreateTemp("", "sentra-rpmdb-*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", tmp.Name()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT blob FROM Packages")
+	if err != nil {
+		return nil, fmt.Errorf("invalid rpm database: %w", err)
+	}
+	defer rows.Close()
+	var pkgs []Package
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		if p, err := parseRPMHeader(blob); err == nil && p.Name != "gpg-pubkey" {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs, rows.Err()
+}
+
+// Berkeley DB hash database layout, which the Packages file of RHEL 8 and
+// older uses
+const (
+	bdbHashMagic    = 0x061561
+	bdbPageHeader   = 26
+	bdbHashPage     = 13
+	bdbHashUnsorted = 2
+	bdbKeyData      = 1
+	bdbOffPage      = 3
+)
+
+// parseRPMBerkeley reads the header blobs stored as the values of a
+// Berkeley DB hash database
+func parseRPMBerkeley(data []byte) ([]Package, error) {
+	if len(data) < 512 {
+		return nil, fmt.Errorf("invalid rpm database")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[12:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[12:]) != bdbHashMagic {
+			return nil, fmt.Errorf("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := int(order.Uint32(data[20:]))
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("invalid Berkeley DB page size %d", pageSize)
+	}
+	page := func(n uint32) []byte {
+		start := int(n) * pageSize
+		if start+pageSize > len(data) {
+			return nil
+		}
+		return data[start : start+pageSize]
+	}
+	// overflow reads a value stored across a chain of overflow pages
+	overflow := func(n, length uint32) []byte {
+		var value []byte
+		for n != 0 && uint32(len(value)) < length {
+			p := page(n)
+			if p == nil {
+				return nil
+			}
+			used := int(order.Uint16(p[22:]))
+			if bdbPageHeader+used > len(p) {
+				return nil
+			}
+			value = append(value, p[bdbPageHeader:bdbPageHeader+used]...)
+			n = order.Uint32(p[16:])
+		}
+		return value
+	}
+
+	var pkgs []Package
+	for n := 1; n < len(data)/pageSize; n++ {
+		p := page(uint32(n))
+		if p[25] != bdbHashPage && p[25] != bdbHashUnsorted {
+			continue
+		}
+		entries := int(order.Uint16(p[20:]))
+		// Items alternate between keys and values; only values are read
+		for i := 1; i < entries; i += 2 {
+			if bdbPageHeader+2*i+2 > len(p) {
+				break
+			}
+			offset := int(order.Uint16(p[bdbPageHeader+2*i:]))
+			if offset+12 > len(p) {
+				continue
+			}
+			var blob []byte
+			switch p[offset] {
+			case bdbOffPage:
+				blob = overflow(order.Uint32(p[offset+4:]), order.Uint32(p[offset+8:]))
+			case bdbKeyData:
+				end := int(order.Uint16(p[bdbPageHeader+2*(i-1):]))
+				if end > offset && end <= len(p) {
+					blob = p[offset+1 : end]
+				}
+			}
+			if pkg, err := parseRPMHeader(blob); err == nil && pkg.Name != "gpg-pubkey" {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// rpm header tags and types
+const (
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagArch      = 1022
+	rpmTagSourceRPM = 1044
+	rpmTypeInt32    = 4
+	rpmTypeString   = 6
+)
+
+// parseRPMHeader reads the name, version and source of a package from an
+// rpm header: an index of tags into a data store, all big-endian
+func parseRPMHeader(blob []byte) (Package, error) {
+	if len(blob) < 8 {
+		return Package{}, fmt.Errorf("rpm header too short")
+	}
+	count := int(binary.BigEndian.Uint32(blob))
+	size := int(binary.BigEndian.Uint32(blob[4:]))
+	if count > 100000 || size > len(blob) || 8+16*count+size > len(blob) {
+		return Package{}, fmt.Errorf("invalid rpm header")
+	}
+	store := blob[8+16*count : 8+16*count+size]
+	var name, version, release, arch, source string
+	var epoch int32 = -1
+	for i := 0; i < count; i++ {
+		entry := blob[8+16*i:]
+		tag := binary.BigEndian.Uint32(entry)
+		typ := binary.BigEndian.Uint32(entry[4:])
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:])))
+		if offset < 0 || offset >= len(store) {
+			continue
+		}
+		str := func() string {
+			if typ != rpmTypeString {
+				return ""
+			}
+			if end := bytes.IndexByte(store[offset:], 0); end >= 0 {
+				return string(store[offset : offset+end])
+			}
+			return ""
+		}
+		switch tag {
+		case rpmTagName:
+			name = str()
+		case rpmTagVersion:
+			version = str()
+		case rpmTagRelease:
+			release = str()
+		case rpmTagArch:
+			arch = str()
+		case rpmTagSourceRPM:
+			source = str()
+		case rpmTagEpoch:
+			if typ == rpmTypeInt32 && offset+4 <= len(store) {
+				epoch = int32(binary.BigEndian.Uint32(store[offset:]))
+			}
+		}
+	}
+	if name == "" || version == "" {
+		return Package{}, fmt.Errorf("rpm header has no name or version")
+	}
+	p := Package{Name: name, Version: version, Arch: arch, Type: "rpm"}
+	if release != "" {
+		p.Version += "-" + release
+	}
+	if epoch > 0 {
+		p.Version = fmt.Sprintf("%d:%s", epoch, p.Version)
+	}
+	p.Source, p.SourceVersion = name, p.Version
+	// The source rpm is name-version-release.src.rpm
+	if base := strings.TrimSuffix(strings.TrimSuffix(source, ".src.rpm"), ".nosrc.rpm"); base != source {
+		parts := strings.Split(base, "-")
+		if len(parts) >= 3 {
+			p.Source = strings.Join(parts[:len(parts)-2], "-")
+		}
+	}
+	return p, nil
+}
+
+// osRelease reads the ID, VERSION_ID and VERSION of an os-release file
+func osRelease(data []byte) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	return fields
+}
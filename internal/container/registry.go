@@ -0,0 +1,368 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const dockerHub = "registry-1.docker.io"
+
+// manifestTypes are the manifest formats the scanner reads, indexes first
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// parseReference splits an image reference into its registry, repository
+// and tag or digest, with Docker Hub's defaults: alpine is
+// registry-1.docker.io, library/alpine and latest
+func parseReference(ref string) (host, repo, reference string, err error) {
+	if ref == "" || strings.ContainsAny(ref, " \t\n") {
+		return "", "", "", fmt.Errorf("invalid image reference '%s'", ref)
+	}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		if reference == "" {
+			reference = name[i+1:]
+		}
+		name = name[:i]
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+	host, repo = dockerHub, name
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, repo = first, rest
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = dockerHub
+	}
+	if host == dockerHub && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return host, repo, reference, nil
+}
+
+// registryClient pulls the manifests and blobs of a repository with the
+// distribution API, authenticating with a bearer token or basic auth
+// when the registry asks
+type registryClient struct {
+	http     *http.Client
+	base     string
+	repo     string
+	username string
+	password string
+	auth     string // The Authorization header, once the registry asked
+}
+
+func newRegistryClient(host, repo string, opts ScanOptions) *registryClient {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: opts.Insecure},
+	}
+	c := &registryClient{
+		http:     &http.Client{Transport: transport},
+		base:     "https://" + host + "/v2/" + repo,
+		repo:     repo,
+		username: opts.Username,
+		password: opts.Password,
+	}
+	if c.username == "" {
+		c.username, c.password = dockerCredentials(host)
+	}
+	return c
+}
+
+// dockerCredentials reads the credentials docker login saved for a
+// registry in ~/.docker/config.json, or $DOCKER_CONFIG/config.json
+func dockerCredentials(host string) (username, password string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+	keys := []string{host, "https://" + host, "http://" + host}
+	if host == dockerHub {
+		keys = append(keys, "https://index.docker.io/v1/", "docker.io", "index.docker.io")
+	}
+	for _, key := range keys {
+		if entry, ok := config.Auths[key]; ok && entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				continue
+			}
+			username, password, _ = strings.Cut(string(decoded), ":")
+			return username, password
+		}
+	}
+	return "", ""
+}
+
+// get requests a manifest or blob, authenticating and retrying when the
+// registry answers 401
+func (c *registryClient) get(path string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(context.Background(), "GET", c.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := c.authenticate(challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry returned %s for %s: %s", resp.Status, path, registryError(body))
+		}
+		return resp, nil
+	}
+}
+
+// registryError returns the message of a distribution API error body
+func registryError(body []byte) string {
+	var e struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &e) == nil && len(e.Errors) > 0 {
+		return e.Errors[0].Code + ": " + e.Errors[0].Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// authenticate answers a WWW-Authenticate challenge: Basic with the
+// credentials, or Bearer with a pull token from the realm it names
+func (c *registryClient) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("registry requires credentials: set username and password")
+		}
+		c.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("registry asked for unsupported authentication '%s'", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("registry sent an invalid token realm '%s'", params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.repo + ":pull"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get a registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry token request returned %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("invalid registry token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("registry token response has no token")
+	}
+	c.auth = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return scheme, params
+}
+
+// fetch reads a whole manifest or blob, checking its digest
+func (c *registryClient) fetch(path, digest string, accept []string) ([]byte, error) {
+	resp, err := c.get(path, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	if alg, want, ok := strings.Cut(digest, ":"); ok && alg == "sha256" {
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+			return nil, fmt.Errorf("%s does not match its digest", digest)
+		}
+	}
+	return data, nil
+}
+
+// open streams a layer blob, checking its digest as it is read
+func (c *registryClient) open(digest string) (io.ReadCloser, error) {
+	resp, err := c.get("/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	if alg, want, ok := strings.Cut(digest, ":"); ok && alg == "sha256" {
+		return &verifyingReader{ReadCloser: resp.Body, hash: sha256.New(), want: want, digest: digest}, nil
+	}
+	return resp.Body, nil
+}
+
+func (c *registryClient) size(string) int64 { return 0 }
+
+func (c *registryClient) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// verifyingReader fails at the end of a blob whose digest is wrong
+type verifyingReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	want   string
+	digest string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.want {
+		return n, fmt.Errorf("layer %s does not match its digest", v.digest)
+	}
+	return n, err
+}
+
+// openRegistry pulls the manifest and configuration of an image from its
+// registry; layers are streamed as the scan reads them
+func openRegistry(ref string, opts ScanOptions) (*Image, error) {
+	host, repo, reference, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	c := newRegistryClient(host, repo, opts)
+	digest := ""
+	if strings.Contains(reference, ":") {
+		digest = reference
+	}
+	data, err := c.fetch("/manifests/"+reference, digest, manifestTypes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	// Indexes lead to manifests, and manifests to a configuration blob
+	m, err := resolveManifest(data, opts.Platform, func(d string) ([]byte, error) {
+		return c.fetch("/manifests/"+d, d, manifestTypes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	img, err := imageFromManifest(m, func(d string) ([]byte, error) {
+		return c.fetch("/blobs/"+d, d, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	for i := range img.Layers {
+		img.Layers[i].blob = img.Layers[i].Digest
+	}
+	img.Name = ref
+	img.store = c
+	return img, nil
+}
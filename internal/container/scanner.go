@@ -1,18 +1,13 @@
 package container
 
 import (
-	"archive/tar"
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,11 +15,9 @@ import (
 
 // ContainerScanner provides container security scanning capabilities
 type ContainerScanner struct {
-	policies      map[string]*SecurityPolicy
-	vulnDB        *VulnerabilityDatabase
-	scanResults   map[string]*ScanResult
-	resultsMutex  sync.RWMutex
-	tempDir       string
+	policies     map[string]*SecurityPolicy
+	scanResults  map[string]*ScanResult
+	resultsMutex sync.RWMutex
 }
 
 // Value interface for VM compatibility
@@ -32,30 +25,37 @@ type Value interface{}
 
 // ScanResult represents container scan results
 type ScanResult struct {
-	ImageID         string                 `json:"image_id"`
-	ImageName       string                 `json:"image_name"`
-	ScanTime        time.Time              `json:"scan_time"`
-	Layers          []LayerInfo            `json:"layers"`
-	Vulnerabilities []Vulnerability        `json:"vulnerabilities"`
-	ComplianceIssues []ComplianceIssue     `json:"compliance_issues"`
-	Secrets         []SecretFinding        `json:"secrets"`
-	Malware         []MalwareFinding       `json:"malware"`
-	RiskScore       int                    `json:"risk_score"`
-	Summary         ScanSummary            `json:"summary"`
+	ImageID          string            `json:"image_id"`
+	ImageName        string            `json:"image_name"`
+	ScanTime         time.Time         `json:"scan_time"`
+	OS               string            `json:"os"`
+	Ecosystem        string            `json:"ecosystem"`
+	Layers           []LayerInfo       `json:"layers"`
+	Packages         []Package         `json:"packages"`
+	Vulnerabilities  []Vulnerability   `json:"vulnerabilities"`
+	ComplianceIssues []ComplianceIssue `json:"compliance_issues"`
+	Secrets          []SecretFinding   `json:"secrets"`
+	Malware          []MalwareFinding  `json:"malware"`
+	Warnings         []string          `json:"warnings"`
+	RiskScore        int               `json:"risk_score"`
+	Summary          ScanSummary       `json:"summary"`
 }
 
 // LayerInfo represents container layer information
 type LayerInfo struct {
-	ID       string   `json:"id"`
-	Size     int64    `json:"size"`
-	Command  string   `json:"command"`
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	Command  string    `json:"command"`
 	Created  time.Time `json:"created"`
-	Files    []string `json:"files"`
+	Files    int       `json:"files"`
+	Packages int       `json:"packages"` // Package versions it installed
+	Findings int       `json:"findings"`
 }
 
 // Vulnerability represents a security vulnerability
 type Vulnerability struct {
 	ID          string   `json:"id"`
+	Aliases     []string `json:"aliases"`
 	Package     string   `json:"package"`
 	Version     string   `json:"version"`
 	Severity    string   `json:"severity"`
@@ -87,142 +87,464 @@ type SecretFinding struct {
 
 // MalwareFinding represents detected malware
 type MalwareFinding struct {
-	Name      string `json:"name"`
-	Type      string `json:"type"`
-	File      string `json:"file"`
-	Hash      string `json:"hash"`
-	Severity  string `json:"severity"`
-	Layer     string `json:"layer"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	File     string `json:"file"`
+	Hash     string `json:"hash"`
+	Severity string `json:"severity"`
+	Details  string `json:"details"`
+	Layer    string `json:"layer"`
 }
 
 // ScanSummary provides scan statistics
 type ScanSummary struct {
 	TotalVulnerabilities int            `json:"total_vulnerabilities"`
-	BySeverity          map[string]int `json:"by_severity"`
-	TotalSecrets        int            `json:"total_secrets"`
-	TotalMalware        int            `json:"total_malware"`
-	ComplianceScore     float64        `json:"compliance_score"`
-	Passed              bool           `json:"passed"`
+	BySeverity           map[string]int `json:"by_severity"`
+	TotalSecrets         int            `json:"total_secrets"`
+	TotalMalware         int            `json:"total_malware"`
+	ComplianceScore      float64        `json:"compliance_score"`
+	Passed               bool           `json:"passed"`
 }
 
 // SecurityPolicy defines security scanning policies
 type SecurityPolicy struct {
-	ID               string            `json:"id"`
-	Name             string            `json:"name"`
-	Rules            []PolicyRule      `json:"rules"`
-	SeverityThreshold string           `json:"severity_threshold"`
-	BlockOnFail      bool             `json:"block_on_fail"`
+	ID                string       `json:"id"`
+	Name              string       `json:"name"`
+	Rules             []PolicyRule `json:"rules"`
+	SeverityThreshold string       `json:"severity_threshold"`
+	BlockOnFail       bool         `json:"block_on_fail"`
 }
 
 // PolicyRule defines a single policy rule
 type PolicyRule struct {
-	ID          string                 `json:"id"`
-	Type        string                 `json:"type"`
-	Condition   string                 `json:"condition"`
-	Parameters  map[string]interface{} `json:"parameters"`
-	Action      string                 `json:"action"`
-	Severity    string                 `json:"severity"`
-}
-
-// VulnerabilityDatabase manages vulnerability data
-type VulnerabilityDatabase struct {
-	vulns     map[string][]Vulnerability
-	lastUpdate time.Time
-	mu        sync.RWMutex
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Condition  string                 `json:"condition"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Action     string                 `json:"action"`
+	Severity   string                 `json:"severity"`
+}
+
+// ScanOptions configures where an image comes from and what is checked
+type ScanOptions struct {
+	Source    string // auto, file, docker or registry
+	Platform  string // os/arch[/variant] of multi-platform images
+	Username  string // Registry credentials; docker login's are used otherwise
+	Password  string
+	Insecure  bool          // Skip verifying the registry's certificate
+	Timeout   time.Duration // For each registry or daemon request
+	VulnDB    string        // osv, feed, none, or the path of an OSV export
+	CacheDir  string        // For OSV answers and exports
+	NoSecrets bool
+	NoMalware bool
 }
 
 // NewContainerScanner creates a new container scanner instance
 func NewContainerScanner() *ContainerScanner {
 	return &ContainerScanner{
 		policies:    make(map[string]*SecurityPolicy),
-		vulnDB:      NewVulnerabilityDatabase(),
 		scanResults: make(map[string]*ScanResult),
-		tempDir:     filepath.Join(os.TempDir(), "sentra-container-scan"),
 	}
 }
 
-// NewVulnerabilityDatabase creates a new vulnerability database
-func NewVulnerabilityDatabase() *VulnerabilityDatabase {
-	return &VulnerabilityDatabase{
-		vulns: make(map[string][]Vulnerability),
-	}
+// ScanImage scans an image with the default options
+func (cs *ContainerScanner) ScanImage(image string) (*ScanResult, error) {
+	return cs.ScanImageWith(image, ScanOptions{})
 }
 
-// ScanImage performs comprehensive container image scanning
-func (cs *ContainerScanner) ScanImage(imagePath string) (*ScanResult, error) {
+// ScanImageWith opens an image as OpenImage does and scans its layers:
+// the OS packages it installs, matched against OSV advisories, and the
+// secrets, packed executables and setuid files each layer adds, with the
+// compliance of its configuration. Findings name the layer they come from.
+func (cs *ContainerScanner) ScanImageWith(image string, opts ScanOptions) (*ScanResult, error) {
+	img, err := OpenImage(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
 	result := &ScanResult{
-		ImageName:        filepath.Base(imagePath),
+		ImageID:          img.ID,
+		ImageName:        img.Name,
 		ScanTime:         time.Now(),
+		Packages:         []Package{},
 		Vulnerabilities:  []Vulnerability{},
 		ComplianceIssues: []ComplianceIssue{},
 		Secrets:          []SecretFinding{},
 		Malware:          []MalwareFinding{},
+		Warnings:         []string{},
+	}
+	w := &layerWalk{
+		img:      img,
+		opts:     opts,
+		result:   result,
+		files:    map[string]tracked{},
+		packages: map[string][]Package{},
+		previous: map[string]map[string]bool{},
+		added:    map[string]int{},
+	}
+	if err := w.walkLayers(); err != nil {
+		return nil, err
 	}
-	
-	// Calculate image ID
-	result.ImageID = cs.calculateImageID(imagePath)
-	
-	// Extract and scan layers
-	layers, err := cs.extractLayers(imagePath)
-	if err != nil {
-		// For demo purposes, create simulated layers
-		layers = cs.createSimulatedLayers()
-	}
-	result.Layers = layers
-	
-	// Scan for vulnerabilities
-	result.Vulnerabilities = cs.scanVulnerabilities(layers)
-	
-	// Check compliance
-	result.ComplianceIssues = cs.checkCompliance(layers)
-	
-	// Scan for secrets
-	result.Secrets = cs.scanSecrets(layers)
-	
-	// Scan for malware
-	result.Malware = cs.scanMalware(layers)
-	
+	cs.collectPackages(w)
+	if err := cs.scanVulnerabilities(w, opts); err != nil {
+		return nil, err
+	}
+	cs.checkCompliance(img, result)
+	if !opts.NoSecrets {
+		result.Secrets = append(result.Secrets, envSecrets(img.Config.Env)...)
+	}
+	cs.countLayerFindings(result)
+
 	// Calculate risk score and summary
 	result.RiskScore = cs.calculateRiskScore(result)
 	result.Summary = cs.generateSummary(result)
-	
+
 	// Store result
 	cs.resultsMutex.Lock()
 	cs.scanResults[result.ImageID] = result
 	cs.resultsMutex.Unlock()
-	
+
 	return result, nil
 }
 
+// collectPackages records the operating system and the packages the image
+// ends with, each with the layer that installed it
+func (cs *ContainerScanner) collectPackages(w *layerWalk) {
+	result := w.result
+	for _, name := range []string{"etc/os-release", "usr/lib/os-release"} {
+		if f, ok := w.files[name]; ok {
+			release := osRelease(f.data)
+			result.OS = release["PRETTY_NAME"]
+			if result.OS == "" {
+				result.OS = strings.TrimSpace(release["ID"] + " " + release["VERSION_ID"])
+			}
+			result.Ecosystem = ecosystem(release)
+			break
+		}
+	}
+	for name := range w.files {
+		for _, p := range w.packages[name] {
+			if i, ok := w.added[p.key()]; ok && i < len(w.img.Layers) {
+				p.Layer = w.img.Layers[i].Digest
+			}
+			result.Packages = append(result.Packages, p)
+		}
+	}
+	sort.Slice(result.Packages, func(i, j int) bool {
+		a, b := result.Packages[i], result.Packages[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Arch < b.Arch
+	})
+	for i := range result.Layers {
+		for _, p := range result.Packages {
+			if p.Layer == result.Layers[i].ID {
+				result.Layers[i].Packages++
+			}
+		}
+	}
+}
+
+// scanVulnerabilities matches the packages against OSV advisories for the
+// image's distribution. A database that cannot be reached is a warning,
+// so the rest of the scan is still reported.
+func (cs *ContainerScanner) scanVulnerabilities(w *layerWalk, opts ScanOptions) error {
+	result := w.result
+	if opts.VulnDB == "none" || len(result.Packages) == 0 {
+		return nil
+	}
+	if result.Ecosystem == "" {
+		os := result.OS
+		if os == "" {
+			os = "an unknown distribution"
+		}
+		w.warn(fmt.Sprintf("no vulnerability data for %s; packages were not checked", os))
+		return nil
+	}
+	source, err := newVulnSource(opts)
+	if err != nil {
+		return err
+	}
+	found, err := source.lookup(result.Ecosystem, result.Packages)
+	if err != nil {
+		w.warn(fmt.Sprintf("vulnerability lookup failed: %v", err))
+		return nil
+	}
+	for _, p := range result.Packages {
+		name, version := osvName(p)
+		for _, v := range found[name+"@"+version] {
+			severity, score := v.severity()
+			description := v.Summary
+			if description == "" {
+				description, _, _ = strings.Cut(strings.TrimSpace(v.Details), "\n")
+			}
+			result.Vulnerabilities = append(result.Vulnerabilities, Vulnerability{
+				ID:          v.ID,
+				Aliases:     v.Aliases,
+				Package:     p.Name,
+				Version:     p.Version,
+				Severity:    severity,
+				Description: description,
+				FixedIn:     v.fixedIn(result.Ecosystem, name, version, p.Type),
+				CVSSScore:   score,
+				Layer:       p.Layer,
+			})
+		}
+	}
+	sort.SliceStable(result.Vulnerabilities, func(i, j int) bool {
+		return cs.severityLevel(result.Vulnerabilities[i].Severity) > cs.severityLevel(result.Vulnerabilities[j].Severity)
+	})
+	return nil
+}
+
+// checkCompliance checks the image configuration against the CIS Docker
+// Benchmark
+func (cs *ContainerScanner) checkCompliance(img *Image, result *ScanResult) {
+	user, _, _ := strings.Cut(img.Config.User, ":")
+	if user == "" || user == "root" || user == "0" {
+		result.ComplianceIssues = append(result.ComplianceIssues, ComplianceIssue{
+			RuleID:      "CIS-4.1",
+			Category:    "User Configuration",
+			Severity:    "medium",
+			Description: "Container runs as root",
+			Remediation: "Create a user and switch to it with the USER directive",
+			Layer:       "config",
+		})
+	}
+	if !img.Config.Healthcheck {
+		result.ComplianceIssues = append(result.ComplianceIssues, ComplianceIssue{
+			RuleID:      "CIS-4.6",
+			Category:    "Health Monitoring",
+			Severity:    "low",
+			Description: "Image has no HEALTHCHECK",
+			Remediation: "Add a HEALTHCHECK instruction",
+			Layer:       "config",
+		})
+	}
+	for _, port := range img.Config.ExposedPorts {
+		number, _, _ := strings.Cut(port, "/")
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		switch {
+		case n == 22:
+			result.ComplianceIssues = append(result.ComplianceIssues, ComplianceIssue{
+				RuleID:      "CIS-5.6",
+				Category:    "Network Configuration",
+				Severity:    "medium",
+				Description: "Image exposes SSH on port 22",
+				Remediation: "Do not run sshd in containers; use docker exec",
+				Layer:       "config",
+			})
+		case n < 1024 && n != 80 && n != 443:
+			result.ComplianceIssues = append(result.ComplianceIssues, ComplianceIssue{
+				RuleID:      "CIS-5.7",
+				Category:    "Network Configuration",
+				Severity:    "low",
+				Description: fmt.Sprintf("Image exposes privileged port %s", port),
+				Remediation: "Listen on a port above 1024",
+				Layer:       "config",
+			})
+		}
+	}
+}
+
+// envSecrets finds credentials set with ENV, which anyone who pulls the
+// image can read
+func envSecrets(env []string) []SecretFinding {
+	var found []SecretFinding
+	for _, e := range env {
+		m := envSecret.FindStringSubmatch(e)
+		if m == nil {
+			continue
+		}
+		name, _, _ := strings.Cut(e, "=")
+		found = append(found, SecretFinding{
+			Type:     "Environment Variable",
+			File:     "ENV",
+			Match:    name + "=" + mask(m[2]),
+			Severity: "high",
+			Layer:    "config",
+		})
+		for _, s := range findSecrets([]byte(m[2]), "ENV", "config") {
+			found[len(found)-1].Type = s.Type
+			found[len(found)-1].Severity = s.Severity
+		}
+	}
+	return found
+}
+
+// countLayerFindings counts the findings attributed to each layer
+func (cs *ContainerScanner) countLayerFindings(result *ScanResult) {
+	counts := map[string]int{}
+	for _, v := range result.Vulnerabilities {
+		counts[v.Layer]++
+	}
+	for _, c := range result.ComplianceIssues {
+		counts[c.Layer]++
+	}
+	for _, s := range result.Secrets {
+		counts[s.Layer]++
+	}
+	for _, m := range result.Malware {
+		counts[m.Layer]++
+	}
+	for i := range result.Layers {
+		result.Layers[i].Findings = counts[result.Layers[i].ID]
+	}
+}
+
+// ScanResultToMap converts a scan result to the map container_scan_image
+// returns
+func ScanResultToMap(r *ScanResult) map[string]interface{} {
+	layers := make([]interface{}, len(r.Layers))
+	for i, l := range r.Layers {
+		layers[i] = map[string]interface{}{
+			"id":       l.ID,
+			"size":     l.Size,
+			"command":  l.Command,
+			"created":  formatTime(l.Created),
+			"files":    l.Files,
+			"packages": l.Packages,
+			"findings": l.Findings,
+		}
+	}
+	packages := make([]interface{}, len(r.Packages))
+	for i, p := range r.Packages {
+		packages[i] = map[string]interface{}{
+			"name":           p.Name,
+			"version":        p.Version,
+			"arch":           p.Arch,
+			"source":         p.Source,
+			"source_version": p.SourceVersion,
+			"type":           p.Type,
+			"layer":          p.Layer,
+		}
+	}
+	vulns := make([]interface{}, len(r.Vulnerabilities))
+	for i, v := range r.Vulnerabilities {
+		vulns[i] = map[string]interface{}{
+			"id":          v.ID,
+			"aliases":     stringsToList(v.Aliases),
+			"package":     v.Package,
+			"version":     v.Version,
+			"severity":    v.Severity,
+			"description": v.Description,
+			"fixed_in":    v.FixedIn,
+			"cvss_score":  v.CVSSScore,
+			"layer":       v.Layer,
+		}
+	}
+	issues := make([]interface{}, len(r.ComplianceIssues))
+	for i, c := range r.ComplianceIssues {
+		issues[i] = map[string]interface{}{
+			"rule_id":     c.RuleID,
+			"category":    c.Category,
+			"severity":    c.Severity,
+			"description": c.Description,
+			"remediation": c.Remediation,
+			"layer":       c.Layer,
+		}
+	}
+	secrets := make([]interface{}, len(r.Secrets))
+	for i, s := range r.Secrets {
+		secrets[i] = map[string]interface{}{
+			"type":     s.Type,
+			"file":     s.File,
+			"line":     s.Line,
+			"match":    s.Match,
+			"severity": s.Severity,
+			"layer":    s.Layer,
+		}
+	}
+	malware := make([]interface{}, len(r.Malware))
+	for i, m := range r.Malware {
+		malware[i] = map[string]interface{}{
+			"name":     m.Name,
+			"type":     m.Type,
+			"file":     m.File,
+			"hash":     m.Hash,
+			"severity": m.Severity,
+			"details":  m.Details,
+			"layer":    m.Layer,
+		}
+	}
+	bySeverity := map[string]interface{}{}
+	for k, v := range r.Summary.BySeverity {
+		bySeverity[k] = v
+	}
+	return map[string]interface{}{
+		"image_id":          r.ImageID,
+		"image_name":        r.ImageName,
+		"scan_time":         formatTime(r.ScanTime),
+		"os":                r.OS,
+		"ecosystem":         r.Ecosystem,
+		"layers":            layers,
+		"packages":          packages,
+		"vulnerabilities":   vulns,
+		"compliance_issues": issues,
+		"secrets":           secrets,
+		"malware":           malware,
+		"warnings":          stringsToList(r.Warnings),
+		"risk_score":        r.RiskScore,
+		"summary": map[string]interface{}{
+			"total_vulnerabilities": r.Summary.TotalVulnerabilities,
+			"by_severity":           bySeverity,
+			"total_secrets":         r.Summary.TotalSecrets,
+			"total_malware":         r.Summary.TotalMalware,
+			"compliance_score":      r.Summary.ComplianceScore,
+			"passed":                r.Summary.Passed,
+		},
+	}
+}
+
+func stringsToList(items []string) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, s := range items {
+		list[i] = s
+	}
+	return list
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
 // ScanDockerfile analyzes Dockerfile for security issues
 func (cs *ContainerScanner) ScanDockerfile(dockerfilePath string) (*DockerfileAnalysis, error) {
 	analysis := &DockerfileAnalysis{
-		File:     dockerfilePath,
-		Issues:   []DockerfileIssue{},
+		File:          dockerfilePath,
+		Issues:        []DockerfileIssue{},
 		BestPractices: []string{},
 	}
-	
+
 	content, err := os.ReadFile(dockerfilePath)
 	if err != nil {
 		// Return demo analysis
 		return cs.createDemoDockerfileAnalysis(), nil
 	}
-	
+
 	scanner := bufio.NewScanner(bytes.NewReader(content))
 	lineNum := 0
-	
+
 	for scanner.Scan() {
 		lineNum++
 		line := scanner.Text()
-		
+
 		// Check for security issues
 		issues := cs.analyzeDockerfileLine(line, lineNum)
 		analysis.Issues = append(analysis.Issues, issues...)
 	}
-	
+
 	// Add best practice recommendations
 	analysis.BestPractices = cs.getDockerfileBestPractices()
-	
+
 	return analysis, nil
 }
 
@@ -242,182 +564,9 @@ type DockerfileIssue struct {
 	Remediation string `json:"remediation"`
 }
 
-// Helper methods
-
-func (cs *ContainerScanner) calculateImageID(imagePath string) string {
-	hash := sha256.Sum256([]byte(imagePath + time.Now().String()))
-	return hex.EncodeToString(hash[:])[:12]
-}
-
-func (cs *ContainerScanner) extractLayers(imagePath string) ([]LayerInfo, error) {
-	// Open the image file
-	file, err := os.Open(imagePath)
-	if err != nil {
-		// Return simulated layers for demo
-		return cs.createSimulatedLayers(), nil
-	}
-	defer file.Close()
-	
-	// Try to decompress if gzipped
-	gr, err := gzip.NewReader(file)
-	if err != nil {
-		// Not gzipped, use file directly
-		file.Seek(0, 0)
-		tr := tar.NewReader(file)
-		return cs.parseTarLayers(tr)
-	}
-	defer gr.Close()
-	
-	// Parse tar archive
-	tr := tar.NewReader(gr)
-	return cs.parseTarLayers(tr)
-}
-
-func (cs *ContainerScanner) parseTarLayers(tr *tar.Reader) ([]LayerInfo, error) {
-	layers := []LayerInfo{}
-	
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return cs.createSimulatedLayers(), nil
-		}
-		
-		// Process tar entry
-		if header.Typeflag == tar.TypeReg {
-			// This would extract layer information
-			layer := LayerInfo{
-				ID:      fmt.Sprintf("layer_%d", len(layers)),
-				Size:    header.Size,
-				Created: header.ModTime,
-				Files:   []string{header.Name},
-			}
-			layers = append(layers, layer)
-		}
-	}
-	
-	if len(layers) == 0 {
-		return cs.createSimulatedLayers(), nil
-	}
-	return layers, nil
-}
-
-func (cs *ContainerScanner) createSimulatedLayers() []LayerInfo {
-	return []LayerInfo{
-		{
-			ID:      "layer1",
-			Size:    1024000,
-			Command: "FROM ubuntu:20.04",
-			Created: time.Now().Add(-24 * time.Hour),
-			Files:   []string{"/bin", "/usr/bin"},
-		},
-		{
-			ID:      "layer2",
-			Size:    512000,
-			Command: "RUN apt-get update",
-			Created: time.Now().Add(-12 * time.Hour),
-			Files:   []string{"/var/lib/apt"},
-		},
-		{
-			ID:      "layer3",
-			Size:    256000,
-			Command: "COPY app /app",
-			Created: time.Now(),
-			Files:   []string{"/app"},
-		},
-	}
-}
-
-func (cs *ContainerScanner) scanVulnerabilities(layers []LayerInfo) []Vulnerability {
-	vulns := []Vulnerability{
-		{
-			ID:          "CVE-2021-44228",
-			Package:     "log4j",
-			Version:     "2.14.1",
-			Severity:    "critical",
-			Description: "Log4Shell vulnerability",
-			FixedIn:     "2.17.0",
-			CVSSScore:   10.0,
-			Layer:       "layer2",
-		},
-		{
-			ID:          "CVE-2021-3156",
-			Package:     "sudo",
-			Version:     "1.8.31",
-			Severity:    "high",
-			Description: "Heap-based buffer overflow",
-			FixedIn:     "1.9.5p2",
-			CVSSScore:   7.8,
-			Layer:       "layer1",
-		},
-	}
-	
-	// Check against vulnerability database
-	cs.vulnDB.mu.RLock()
-	defer cs.vulnDB.mu.RUnlock()
-	
-	return vulns
-}
-
-func (cs *ContainerScanner) checkCompliance(layers []LayerInfo) []ComplianceIssue {
-	return []ComplianceIssue{
-		{
-			RuleID:      "CIS-1.1.1",
-			Category:    "User Configuration",
-			Severity:    "medium",
-			Description: "Container running as root user",
-			Remediation: "Use USER directive to run as non-root",
-			Layer:       "layer3",
-		},
-		{
-			RuleID:      "CIS-4.1",
-			Category:    "Network Configuration", 
-			Severity:    "high",
-			Description: "Privileged ports exposed",
-			Remediation: "Use ports above 1024",
-			Layer:       "layer3",
-		},
-	}
-}
-
-func (cs *ContainerScanner) scanSecrets(layers []LayerInfo) []SecretFinding {
-	// Define patterns for secret detection
-	_ = regexp.MustCompile(`AKIA[0-9A-Z]{16}`) // AWS Key pattern
-	
-	secrets := []SecretFinding{}
-	
-	// Simulated secret findings
-	secrets = append(secrets, SecretFinding{
-		Type:     "AWS Key",
-		File:     "/app/config.json",
-		Line:     42,
-		Match:    "AKIA****************",
-		Severity: "critical",
-		Layer:    "layer3",
-	})
-	
-	return secrets
-}
-
-func (cs *ContainerScanner) scanMalware(layers []LayerInfo) []MalwareFinding {
-	// Simulated malware detection
-	return []MalwareFinding{
-		{
-			Name:     "Suspicious.Binary",
-			Type:     "trojan",
-			File:     "/tmp/suspicious",
-			Hash:     "abc123def456",
-			Severity: "critical",
-			Layer:    "layer2",
-		},
-	}
-}
-
 func (cs *ContainerScanner) calculateRiskScore(result *ScanResult) int {
 	score := 0
-	
+
 	// Score based on vulnerabilities
 	for _, vuln := range result.Vulnerabilities {
 		switch vuln.Severity {
@@ -431,7 +580,7 @@ func (cs *ContainerScanner) calculateRiskScore(result *ScanResult) int {
 			score += 1
 		}
 	}
-	
+
 	// Score based on compliance
 	for _, issue := range result.ComplianceIssues {
 		switch issue.Severity {
@@ -443,33 +592,33 @@ func (cs *ContainerScanner) calculateRiskScore(result *ScanResult) int {
 			score += 3
 		}
 	}
-	
+
 	// Score based on secrets
 	score += len(result.Secrets) * 30
-	
+
 	// Score based on malware
 	score += len(result.Malware) * 50
-	
+
 	if score > 100 {
 		score = 100
 	}
-	
+
 	return score
 }
 
 func (cs *ContainerScanner) generateSummary(result *ScanResult) ScanSummary {
 	summary := ScanSummary{
 		TotalVulnerabilities: len(result.Vulnerabilities),
-		BySeverity:          make(map[string]int),
-		TotalSecrets:        len(result.Secrets),
-		TotalMalware:        len(result.Malware),
+		BySeverity:           make(map[string]int),
+		TotalSecrets:         len(result.Secrets),
+		TotalMalware:         len(result.Malware),
 	}
-	
+
 	// Count vulnerabilities by severity
 	for _, vuln := range result.Vulnerabilities {
 		summary.BySeverity[vuln.Severity]++
 	}
-	
+
 	// Calculate compliance score
 	totalCompliance := len(result.ComplianceIssues)
 	if totalCompliance == 0 {
@@ -480,18 +629,18 @@ func (cs *ContainerScanner) generateSummary(result *ScanResult) ScanSummary {
 			summary.ComplianceScore = 0
 		}
 	}
-	
+
 	// Determine if scan passed
 	summary.Passed = result.RiskScore < 50 &&
 		summary.BySeverity["critical"] == 0 &&
 		summary.TotalMalware == 0
-	
+
 	return summary
 }
 
 func (cs *ContainerScanner) analyzeDockerfileLine(line string, lineNum int) []DockerfileIssue {
 	issues := []DockerfileIssue{}
-	
+
 	// Check for running as root
 	if strings.Contains(line, "USER root") {
 		issues = append(issues, DockerfileIssue{
@@ -502,7 +651,7 @@ func (cs *ContainerScanner) analyzeDockerfileLine(line string, lineNum int) []Do
 			Remediation: "Use a non-root user with USER directive",
 		})
 	}
-	
+
 	// Check for sudo installation
 	if strings.Contains(line, "apt-get install") && strings.Contains(line, "sudo") {
 		issues = append(issues, DockerfileIssue{
@@ -513,7 +662,7 @@ func (cs *ContainerScanner) analyzeDockerfileLine(line string, lineNum int) []Do
 			Remediation: "Avoid installing sudo in containers",
 		})
 	}
-	
+
 	// Check for ADD instead of COPY
 	if strings.HasPrefix(strings.TrimSpace(line), "ADD ") {
 		issues = append(issues, DockerfileIssue{
@@ -524,7 +673,7 @@ func (cs *ContainerScanner) analyzeDockerfileLine(line string, lineNum int) []Do
 			Remediation: "Use COPY unless you need ADD's tar extraction",
 		})
 	}
-	
+
 	// Check for latest tag
 	if strings.Contains(line, ":latest") {
 		issues = append(issues, DockerfileIssue{
@@ -535,7 +684,7 @@ func (cs *ContainerScanner) analyzeDockerfileLine(line string, lineNum int) []Do
 			Remediation: "Pin to specific version for reproducibility",
 		})
 	}
-	
+
 	return issues
 }
 
@@ -609,31 +758,31 @@ func (cs *ContainerScanner) ValidateAgainstPolicy(result *ScanResult, policyID s
 	if !exists {
 		return false, []string{"Policy not found"}
 	}
-	
+
 	violations := []string{}
-	
+
 	// Check severity threshold
 	for _, vuln := range result.Vulnerabilities {
 		if cs.severityLevel(vuln.Severity) >= cs.severityLevel(policy.SeverityThreshold) {
 			violations = append(violations, fmt.Sprintf("Vulnerability %s exceeds severity threshold", vuln.ID))
 		}
 	}
-	
+
 	// Check for critical vulnerabilities
 	if result.Summary.BySeverity["critical"] > 0 && policy.BlockOnFail {
 		violations = append(violations, "Critical vulnerabilities found")
 	}
-	
+
 	// Check for malware
 	if len(result.Malware) > 0 && policy.BlockOnFail {
 		violations = append(violations, "Malware detected")
 	}
-	
+
 	// Check for exposed secrets
 	if len(result.Secrets) > 0 {
 		violations = append(violations, "Exposed secrets detected")
 	}
-	
+
 	return len(violations) == 0, violations
 }
 
@@ -650,4 +799,4 @@ func (cs *ContainerScanner) severityLevel(severity string) int {
 	default:
 		return 0
 	}
-}
\ No newline at end of file
+}
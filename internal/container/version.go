@@ -0,0 +1,314 @@
+package container
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two versions of a kind of package as its
+// package manager does, returning -1, 0 or 1
+func CompareVersions(kind, a, b string) int {
+	switch kind {
+	case "dpkg":
+		return compareDpkg(a, b)
+	case "rpm":
+		return compareRPM(a, b)
+	case "apk":
+		return compareAPK(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// splitEpoch splits the epoch off epoch:version
+func splitEpoch(v string) (int, string) {
+	if i := strings.Index(v, ":"); i > 0 {
+		if epoch, err := strconv.Atoi(v[:i]); err == nil {
+			return epoch, v[i+1:]
+		}
+	}
+	return 0, v
+}
+
+// compareDpkg compares epoch:upstream-revision versions as dpkg does
+func compareDpkg(a, b string) int {
+	ea, va := splitEpoch(a)
+	eb, vb := splitEpoch(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	ua, ra := va, ""
+	if i := strings.LastIndex(va, "-"); i >= 0 {
+		ua, ra = va[:i], va[i+1:]
+	}
+	ub, rb := vb, ""
+	if i := strings.LastIndex(vb, "-"); i >= 0 {
+		ub, rb = vb[:i], vb[i+1:]
+	}
+	if c := verrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return verrevcmp(ra, rb)
+}
+
+// dpkgOrder ranks a character: ~ before the end of a string, which is
+// before letters, which are before other characters
+func dpkgOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// verrevcmp compares alternating runs of non-digits, by dpkgOrder, and
+// digits, as numbers
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			ac, bc := dpkgOrder(a, i), dpkgOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// compareRPM compares epoch:version-release versions as rpm does
+func compareRPM(a, b string) int {
+	ea, va := splitEpoch(a)
+	eb, vb := splitEpoch(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	ua, ra, _ := strings.Cut(va, "-")
+	ub, rb, _ := strings.Cut(vb, "-")
+	if c := rpmvercmp(ua, ub); c != 0 {
+		return c
+	}
+	if ra == "" || rb == "" {
+		// A version without a release matches any release
+		return 0
+	}
+	return rpmvercmp(ra, rb)
+}
+
+// rpmvercmp compares runs of digits as numbers and runs of letters as
+// strings, where ~ sorts before anything and ^ after the version it ends
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	alnum := func(c byte) bool { return isDigit(c) || isAlpha(c) }
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !alnum(a[i]) && a[i] != '~' && a[i] != '^' {
+			i++
+		}
+		for j < len(b) && !alnum(b[j]) && b[j] != '~' && b[j] != '^' {
+			j++
+		}
+		tildeA, tildeB := i < len(a) && a[i] == '~', j < len(b) && b[j] == '~'
+		if tildeA || tildeB {
+			if !tildeA {
+				return 1
+			}
+			if !tildeB {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		caretA, caretB := i < len(a) && a[i] == '^', j < len(b) && b[j] == '^'
+		if caretA || caretB {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if !caretA {
+				return 1
+			}
+			if !caretB {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		si, sj := i, j
+		numeric := isDigit(a[i])
+		run := isAlpha
+		if numeric {
+			run = isDigit
+		}
+		for i < len(a) && run(a[i]) {
+			i++
+		}
+		for j < len(b) && run(b[j]) {
+			j++
+		}
+		sa, sb := a[si:i], b[sj:j]
+		if sb == "" {
+			// Numbers are newer than letters
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				return sign(len(sa) - len(sb))
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i >= len(a):
+		return -1
+	}
+	return 1
+}
+
+// apkSuffixes rank the suffixes of apk versions: pre-releases before the
+// release, which is before patches and snapshots
+var apkSuffixes = map[string]int{
+	"alpha": -4, "beta": -3, "pre": -2, "rc": -1,
+	"cvs": 1, "svn": 2, "git": 3, "hg": 4, "p": 5,
+}
+
+// apkVersion is a parsed apk version: numbers.numbers[letter][_suffix
+// number]...[~hash][-rrevision]
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes [][2]int
+	revision int
+}
+
+func parseAPKVersion(v string) apkVersion {
+	var p apkVersion
+	if i := strings.LastIndex(v, "-r"); i >= 0 {
+		if rev, err := strconv.Atoi(v[i+2:]); err == nil {
+			p.revision = rev
+			v = v[:i]
+		}
+	}
+	if i := strings.Index(v, "~"); i >= 0 {
+		v = v[:i]
+	}
+	main, rest, _ := strings.Cut(v, "_")
+	if n := len(main); n > 0 && isAlpha(main[n-1]) {
+		p.letter = main[n-1]
+		main = main[:n-1]
+	}
+	p.numbers = strings.Split(main, ".")
+	for _, s := range strings.Split(rest, "_") {
+		if s == "" {
+			continue
+		}
+		name := strings.TrimRightFunc(s, func(r rune) bool { return r >= '0' && r <= '9' })
+		n, _ := strconv.Atoi(s[len(name):])
+		p.suffixes = append(p.suffixes, [2]int{apkSuffixes[name], n})
+	}
+	return p
+}
+
+// compareAPK compares versions as apk does
+func compareAPK(a, b string) int {
+	pa, pb := parseAPKVersion(a), parseAPKVersion(b)
+	for i := 0; i < len(pa.numbers) && i < len(pb.numbers); i++ {
+		na, ea := strconv.Atoi(pa.numbers[i])
+		nb, eb := strconv.Atoi(pb.numbers[i])
+		if ea != nil || eb != nil {
+			if c := strings.Compare(pa.numbers[i], pb.numbers[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if na != nb {
+			return sign(na - nb)
+		}
+	}
+	if len(pa.numbers) != len(pb.numbers) {
+		return sign(len(pa.numbers) - len(pb.numbers))
+	}
+	if pa.letter != pb.letter {
+		return sign(int(pa.letter) - int(pb.letter))
+	}
+	for i := 0; i < len(pa.suffixes) || i < len(pb.suffixes); i++ {
+		// A missing suffix ranks as the release itself
+		var sa, sb [2]int
+		if i < len(pa.suffixes) {
+			sa = pa.suffixes[i]
+		}
+		if i < len(pb.suffixes) {
+			sb = pb.suffixes[i]
+		}
+		if sa[0] != sb[0] {
+			return sign(sa[0] - sb[0])
+		}
+		if sa[1] != sb[1] {
+			return sign(sa[1] - sb[1])
+		}
+	}
+	return sign(pa.revision - pb.revision)
+}
@@ -0,0 +1,259 @@
+package cryptoanalysis
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate monitoring: the certificates TLS endpoints serve, checked
+// for expiry, a name they are not valid for and a chain that is not
+// trusted, for scripts that run on a schedule
+
+// CertEndpoint is a TLS endpoint to check
+type CertEndpoint struct {
+	Host       string
+	Port       int
+	ServerName string // The name the certificate must be valid for, Host by default
+}
+
+func (e CertEndpoint) String() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// name is the name the certificate of an endpoint must be valid for
+func (e CertEndpoint) name() string {
+	if e.ServerName != "" {
+		return e.ServerName
+	}
+	return e.Host
+}
+
+// ParseCertEndpoint parses host, host:port or a URL, on port 443 unless it
+// says otherwise
+func ParseCertEndpoint(s string) (CertEndpoint, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return CertEndpoint{}, err
+		}
+		s = u.Host
+	}
+	e := CertEndpoint{Host: s, Port: 443}
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return CertEndpoint{}, fmt.Errorf("invalid port in %q", s)
+		}
+		e.Host, e.Port = host, n
+	}
+	e.Host = strings.Trim(e.Host, "[]")
+	if e.Host == "" {
+		return CertEndpoint{}, fmt.Errorf("no host in %q", s)
+	}
+	return e, nil
+}
+
+// CertMonitorOptions configure MonitorCertificates
+type CertMonitorOptions struct {
+	Days        int            // Certificates expiring within as many days are reported, 30 by default
+	Timeout     time.Duration  // Of each connection
+	Roots       *x509.CertPool // The CAs to trust, the system's by default
+	Concurrency int
+}
+
+// CertProblem is something wrong with the certificate of an endpoint:
+// expired, expiring, chain_expiring, mismatch, untrusted or, if it could
+// not be checked, error
+type CertProblem struct {
+	Type     string
+	Severity string
+	Detail   string
+}
+
+// CertStatus is the certificate an endpoint serves and its problems
+type CertStatus struct {
+	Endpoint    CertEndpoint
+	Subject     string
+	Issuer      string
+	Names       []string
+	Serial      string
+	Fingerprint string // SHA-256
+	NotBefore   time.Time
+	NotAfter    time.Time
+	DaysLeft    int
+	Problems    []CertProblem
+}
+
+// CheckCertificate connects to an endpoint and checks its certificate
+func CheckCertificate(e CertEndpoint, opts CertMonitorOptions) CertStatus {
+	if opts.Days <= 0 {
+		opts.Days = 30
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	status := CertStatus{Endpoint: e}
+	problem := func(kind, severity, format string, args ...interface{}) {
+		detail := fmt.Sprintf("certificate of %s %s", e, fmt.Sprintf(format, args...))
+		status.Problems = append(status.Problems, CertProblem{Type: kind, Severity: severity, Detail: detail})
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: opts.Timeout}, "tcp", e.String(), &tls.Config{
+		InsecureSkipVerify: true, // Checked below, to tell what is wrong
+		ServerName:         e.name(),
+	})
+	if err != nil {
+		status.Problems = append(status.Problems, CertProblem{Type: "error", Severity: "low", Detail: fmt.Sprintf("could not check the certificate of %s: %v", e, err)})
+		return status
+	}
+	chain := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(chain) == 0 {
+		problem("error", "low", "was not sent")
+		return status
+	}
+
+	leaf := chain[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	status.Subject = leaf.Subject.String()
+	status.Issuer = leaf.Issuer.String()
+	status.Names = certNames(leaf)
+	status.Serial = leaf.SerialNumber.Text(16)
+	status.Fingerprint = hex.EncodeToString(fingerprint[:])
+	status.NotBefore, status.NotAfter = leaf.NotBefore, leaf.NotAfter
+	status.DaysLeft = daysUntil(leaf.NotAfter)
+
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		problem("expired", "high", "expired on %s", leaf.NotAfter.UTC().Format("2006-01-02"))
+	case now.Before(leaf.NotBefore):
+		problem("expired", "high", "is not valid before %s", leaf.NotBefore.UTC().Format("2006-01-02"))
+	case status.DaysLeft < opts.Days:
+		severity := "medium"
+		if status.DaysLeft < 7 {
+			severity = "high"
+		}
+		problem("expiring", severity, "expires in %d days, on %s", status.DaysLeft, leaf.NotAfter.UTC().Format("2006-01-02"))
+	}
+	for _, c := range chain[1:] {
+		if c.Subject.String() == c.Issuer.String() {
+			// A root the server should not send, and that is not checked
+			continue
+		}
+		if days := daysUntil(c.NotAfter); days < opts.Days {
+			problem("chain_expiring", "medium", "has an intermediate, %s, that expires in %d days", c.Subject.CommonName, days)
+		}
+	}
+	if err := leaf.VerifyHostname(e.name()); err != nil {
+		problem("mismatch", "high", "is not valid for %s, only %s", e.name(), strings.Join(status.Names, ", "))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: opts.Roots, Intermediates: intermediates})
+	var invalid x509.CertificateInvalidError
+	var unknown x509.UnknownAuthorityError
+	switch {
+	case err == nil, errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		// Expiry is reported above
+	case errors.As(err, &unknown) && len(chain) == 1 && leaf.Subject.String() == leaf.Issuer.String():
+		problem("untrusted", "medium", "is self-signed")
+	default:
+		problem("untrusted", "medium", "is not trusted: %v", err)
+	}
+	return status
+}
+
+// certNames are the DNS names and IP addresses of a certificate, or its
+// common name if it has neither
+func certNames(c *x509.Certificate) []string {
+	names := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && c.Subject.CommonName != "" {
+		names = append(names, c.Subject.CommonName)
+	}
+	return names
+}
+
+// MonitorCertificates checks the certificates of endpoints, several at a
+// time, and returns their statuses in the same order
+func MonitorCertificates(endpoints []CertEndpoint, opts CertMonitorOptions) []CertStatus {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 16
+	}
+	statuses := make([]CertStatus, len(endpoints))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			statuses[i] = CheckCertificate(e, opts)
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// severityRank orders the severities of problems
+var severityRank = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// CertStatusToMap returns a status as a finding, titled by its worst
+// problem, or ok
+func CertStatusToMap(s CertStatus) map[string]interface{} {
+	m := map[string]interface{}{
+		"host":        s.Endpoint.Host,
+		"port":        s.Endpoint.Port,
+		"server_name": s.Endpoint.name(),
+		"status":      "ok",
+		"severity":    "info",
+	}
+	problems := []interface{}{}
+	worst := -1
+	for i, p := range s.Problems {
+		problems = append(problems, map[string]interface{}{"type": p.Type, "severity": p.Severity, "detail": p.Detail})
+		if worst < 0 || severityRank[p.Severity] > severityRank[s.Problems[worst].Severity] {
+			worst = i
+		}
+	}
+	m["problems"] = problems
+	if worst >= 0 {
+		p := s.Problems[worst]
+		m["status"], m["severity"], m["title"] = p.Type, p.Severity, strings.ToUpper(p.Detail[:1])+p.Detail[1:]
+	} else {
+		m["title"] = "Certificate of " + s.Endpoint.String() + " is valid"
+	}
+	if s.Fingerprint == "" {
+		return m
+	}
+	names := make([]interface{}, len(s.Names))
+	for i, n := range s.Names {
+		names[i] = n
+	}
+	m["subject"] = s.Subject
+	m["issuer"] = s.Issuer
+	m["names"] = names
+	m["serial"] = s.Serial
+	m["fingerprint"] = s.Fingerprint
+	m["not_before"] = s.NotBefore.UTC().Format(time.RFC3339)
+	m["not_after"] = s.NotAfter.UTC().Format(time.RFC3339)
+	m["days_left"] = s.DaysLeft
+	return m
+}
@@ -0,0 +1,510 @@
+package cryptoanalysis
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Wordlist cracking of password hashes, with hashcat style rules, for
+// password audits that only need to find the weak ones
+
+// DefaultRules are the rules "default" stands for: the word as it is and
+// the changes people make to words to pass password policies
+var DefaultRules = []string{
+	":", "l", "u", "c", "r", "d",
+	"$1", "$1 $2", "$1 $2 $3", "$!", "$1 $!", "$0 $1", "$1 $2 $3 $4",
+	"c $1", "c $!", "c $1 $!", "c $1 $2 $3", "c $1 $2", "c $@",
+	"$2 $0 $2 $4", "$2 $0 $2 $5", "$2 $0 $2 $6", "c $2 $0 $2 $4", "c $2 $0 $2 $5", "c $2 $0 $2 $6",
+	"sa@", "so0", "se3", "si1", "sa@ so0 se3 si1", "c sa@ so0 se3", "c so0 $1",
+	"^1", "^!", "] ]", "]",
+}
+
+// ruleFunc is one function of a rule, applied to a word
+type ruleFunc func(word []rune) []rune
+
+// rulePosition reads a hashcat position, 0-9 then A-Z for 10-35
+func rulePosition(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// parseRule parses a hashcat rule: : l u c C t TN r d f { } $X ^X [ ] DN
+// 'N sXY @X and pN
+func parseRule(rule string) ([]ruleFunc, error) {
+	var funcs []ruleFunc
+	for i := 0; i < len(rule); i++ {
+		op := rule[i]
+		args := func(n int) (string, error) {
+			if i+n >= len(rule) {
+				return "", fmt.Errorf("rule %q: %c takes %d characters", rule, op, n)
+			}
+			s := rule[i+1 : i+1+n]
+			i += n
+			return s, nil
+		}
+		position := func() (int, error) {
+			s, err := args(1)
+			if err != nil {
+				return 0, err
+			}
+			n, ok := rulePosition(s[0])
+			if !ok {
+				return 0, fmt.Errorf("rule %q: %c takes a position, not %q", rule, op, s)
+			}
+			return n, nil
+		}
+		var f ruleFunc
+		switch op {
+		case ' ', ':':
+			continue
+		case 'l':
+			f = func(w []rune) []rune { return []rune(strings.ToLower(string(w))) }
+		case 'u':
+			f = func(w []rune) []rune { return []rune(strings.ToUpper(string(w))) }
+		case 'c', 'C':
+			upperFirst := op == 'c'
+			f = func(w []rune) []rune {
+				s := strings.ToLower(string(w))
+				if !upperFirst {
+					s = strings.ToUpper(s)
+				}
+				out := []rune(s)
+				if len(out) > 0 {
+					if upperFirst {
+						out[0] = []rune(strings.ToUpper(string(out[0])))[0]
+					} else {
+						out[0] = []rune(strings.ToLower(string(out[0])))[0]
+					}
+				}
+				return out
+			}
+		case 't':
+			f = func(w []rune) []rune {
+				for i, r := range w {
+					w[i] = toggle(r)
+				}
+				return w
+			}
+		case 'T':
+			n, err := position()
+			if err != nil {
+				return nil, err
+			}
+			f = func(w []rune) []rune {
+				if n < len(w) {
+					w[n] = toggle(w[n])
+				}
+				return w
+			}
+		case 'r':
+			f = func(w []rune) []rune {
+				for i, j := 0, len(w)-1; i < j; i, j = i+1, j-1 {
+					w[i], w[j] = w[j], w[i]
+				}
+				return w
+			}
+		case 'd':
+			f = func(w []rune) []rune { return append(w, w...) }
+		case 'f':
+			f = func(w []rune) []rune {
+				for i := len(w) - 1; i >= 0; i-- {
+					w = append(w, w[i])
+				}
+				return w
+			}
+		case '{':
+			f = func(w []rune) []rune {
+				if len(w) > 0 {
+					w = append(w[1:], w[0])
+				}
+				return w
+			}
+		case '}':
+			f = func(w []rune) []rune {
+				if len(w) > 0 {
+					w = append([]rune{w[len(w)-1]}, w[:len(w)-1]...)
+				}
+				return w
+			}
+		case '$', '^', '@':
+			s, err := args(1)
+			if err != nil {
+				return nil, err
+			}
+			c := rune(s[0])
+			switch op {
+			case '$':
+				f = func(w []rune) []rune { return append(w, c) }
+			case '^':
+				f = func(w []rune) []rune { return append([]rune{c}, w...) }
+			default:
+				f = func(w []rune) []rune { return []rune(strings.ReplaceAll(string(w), string(c), "")) }
+			}
+		case '[':
+			f = func(w []rune) []rune {
+				if len(w) > 0 {
+					w = w[1:]
+				}
+				return w
+			}
+		case ']':
+			f = func(w []rune) []rune {
+				if len(w) > 0 {
+					w = w[:len(w)-1]
+				}
+				return w
+			}
+		case 'D':
+			n, err := position()
+			if err != nil {
+				return nil, err
+			}
+			f = func(w []rune) []rune {
+				if n < len(w) {
+					w = append(w[:n], w[n+1:]...)
+				}
+				return w
+			}
+		case '\'':
+			n, err := position()
+			if err != nil {
+				return nil, err
+			}
+			f = func(w []rune) []rune { return w[:min(n, len(w))] }
+		case 'p':
+			n, err := position()
+			if err != nil {
+				return nil, err
+			}
+			f = func(w []rune) []rune {
+				word := w
+				for j := 0; j < n; j++ {
+					w = append(w, word...)
+				}
+				return w
+			}
+		case 's':
+			s, err := args(2)
+			if err != nil {
+				return nil, err
+			}
+			from, to := string(s[0]), string(s[1])
+			f = func(w []rune) []rune { return []rune(strings.ReplaceAll(string(w), from, to)) }
+		default:
+			return nil, fmt.Errorf("rule %q: unknown function %q", rule, op)
+		}
+		funcs = append(funcs, f)
+	}
+	return funcs, nil
+}
+
+func toggle(r rune) rune {
+	if s := strings.ToLower(string(r)); s != string(r) {
+		return []rune(s)[0]
+	}
+	return []rune(strings.ToUpper(string(r)))[0]
+}
+
+// applyRule applies the functions of a rule to a word
+func applyRule(funcs []ruleFunc, word string) string {
+	w := []rune(word)
+	for _, f := range funcs {
+		w = f(w)
+	}
+	return string(w)
+}
+
+// Wordlist yields the words of a wordlist until yield returns false
+type Wordlist func(yield func(word string) bool) error
+
+// WordlistFile reads a wordlist file, one word a line, gzipped if it ends
+// in .gz
+func WordlistFile(path string) Wordlist {
+	return func(yield func(string) bool) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if !yield(strings.TrimSuffix(scanner.Text(), "\r")) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+}
+
+// WordlistOf yields the words of a slice
+func WordlistOf(words []string) Wordlist {
+	return func(yield func(string) bool) error {
+		for _, w := range words {
+			if !yield(w) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// CrackProgress is how far Crack got
+type CrackProgress struct {
+	Tried     int
+	Cracked   int
+	Remaining int
+	Elapsed   time.Duration
+	Rate      float64 // Candidates a second
+}
+
+// CrackOptions configure Crack
+type CrackOptions struct {
+	Format           string   // The format of all hashes, by default each one's likeliest
+	Rules            []string // hashcat rules tried on each word, or "default" for DefaultRules
+	Limit            int      // Candidates to try at most
+	Timeout          time.Duration
+	Progress         func(CrackProgress) bool // Called every ProgressInterval and at the end; false stops
+	ProgressInterval time.Duration            // A second by default
+}
+
+// Cracked is a hash Crack found the password of
+type Cracked struct {
+	Hash     string // As given
+	User     string
+	Format   string
+	Password string
+}
+
+// CrackResult is the outcome of Crack. Status is cracked when every hash
+// was, exhausted when the wordlist ran out, or limit, timeout or stopped.
+type CrackResult struct {
+	Cracked   []Cracked
+	Uncracked []string
+	Tried     int
+	Elapsed   time.Duration
+	Status    string
+}
+
+// Crack tries the words of a wordlist, changed by rules, against hashes
+// until all are cracked or it runs out. Hashes may be in any format
+// IdentifyHash knows and Crack supports, and pwdump or /etc/shadow lines.
+func Crack(hashes []string, words Wordlist, opts CrackOptions) (*CrackResult, error) {
+	var targets []*hashTarget
+	slow := false
+	for _, h := range hashes {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		t, err := newTarget(h, opts.Format)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+		slow = slow || t.format.slow
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hashes to crack")
+	}
+	var rules [][]ruleFunc
+	for _, rule := range opts.Rules {
+		if rule == "default" {
+			for _, r := range DefaultRules {
+				funcs, _ := parseRule(r)
+				rules = append(rules, funcs)
+			}
+			continue
+		}
+		funcs, err := parseRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, funcs)
+	}
+	if len(rules) == 0 {
+		rules = [][]ruleFunc{nil}
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	c := newCracker(targets)
+	workers := runtime.GOMAXPROCS(0)
+	batchSize := 4096
+	if slow {
+		batchSize = 2 * workers
+	}
+	start, lastProgress := time.Now(), time.Now()
+	result := &CrackResult{Status: "exhausted"}
+	progress := func() bool {
+		lastProgress = time.Now()
+		if opts.Progress == nil {
+			return true
+		}
+		p := CrackProgress{Tried: result.Tried, Cracked: c.cracked(), Elapsed: time.Since(start)}
+		p.Remaining = len(targets) - p.Cracked
+		if seconds := p.Elapsed.Seconds(); seconds > 0 {
+			p.Rate = float64(p.Tried) / seconds
+		}
+		return opts.Progress(p)
+	}
+
+	batch := make([]string, 0, batchSize)
+	// run checks a batch and reports whether to go on
+	run := func() bool {
+		c.check(batch, workers)
+		result.Tried += len(batch)
+		batch = batch[:0]
+		switch {
+		case c.cracked() == len(targets):
+			result.Status = "cracked"
+		case opts.Limit > 0 && result.Tried >= opts.Limit:
+			result.Status = "limit"
+		case opts.Timeout > 0 && time.Since(start) >= opts.Timeout:
+			result.Status = "timeout"
+		case time.Since(lastProgress) >= interval && !progress():
+			result.Status = "stopped"
+		default:
+			return true
+		}
+		return false
+	}
+	stopped := false
+	err := words(func(word string) bool {
+		seen := map[string]bool{}
+		for _, rule := range rules {
+			candidate := applyRule(rule, word)
+			if len(rules) > 1 {
+				if seen[candidate] {
+					continue
+				}
+				seen[candidate] = true
+			}
+			batch = append(batch, candidate)
+			if opts.Limit > 0 && result.Tried+len(batch) >= opts.Limit || len(batch) == batchSize {
+				if !run() {
+					stopped = true
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !stopped && len(batch) > 0 {
+		run()
+	}
+	if result.Status != "stopped" {
+		progress()
+	}
+
+	result.Elapsed = time.Since(start)
+	for _, t := range targets {
+		if password, ok := c.found[t]; ok {
+			result.Cracked = append(result.Cracked, Cracked{Hash: t.line, User: t.user, Format: t.format.name, Password: password})
+		} else {
+			result.Uncracked = append(result.Uncracked, t.line)
+		}
+	}
+	return result, nil
+}
+
+// cracker checks candidates against the targets left, looking unsalted
+// hashes up by digest
+type cracker struct {
+	digests map[*hashFormat]map[string][]*hashTarget
+	salted  []*hashTarget
+	mu      sync.Mutex
+	found   map[*hashTarget]string
+	done    atomic.Int64
+}
+
+func newCracker(targets []*hashTarget) *cracker {
+	c := &cracker{digests: map[*hashFormat]map[string][]*hashTarget{}, found: map[*hashTarget]string{}}
+	for _, t := range targets {
+		if t.format.digest == nil {
+			c.salted = append(c.salted, t)
+			continue
+		}
+		if c.digests[t.format] == nil {
+			c.digests[t.format] = map[string][]*hashTarget{}
+		}
+		c.digests[t.format][t.hash] = append(c.digests[t.format][t.hash], t)
+	}
+	return c
+}
+
+func (c *cracker) cracked() int {
+	return int(c.done.Load())
+}
+
+func (c *cracker) crack(t *hashTarget, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.found[t]; !ok {
+		c.found[t] = password
+		c.done.Add(1)
+	}
+}
+
+func (c *cracker) isCracked(t *hashTarget) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.found[t]
+	return ok
+}
+
+// check tries a batch of candidates on workers goroutines
+func (c *cracker) check(candidates []string, workers int) {
+	var wg sync.WaitGroup
+	next := atomic.Int64{}
+	for w := 0; w < min(workers, len(candidates)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(candidates) {
+					return
+				}
+				password := candidates[i]
+				for format, byDigest := range c.digests {
+					for _, t := range byDigest[format.digest(password)] {
+						c.crack(t, password)
+					}
+				}
+				for _, t := range c.salted {
+					if !c.isCracked(t) && t.format.verify(t, password) {
+						c.crack(t, password)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,137 @@
+package cryptoanalysis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestIdentifyHash(t *testing.T) {
+	for hash, want := range map[string]string{
+		"5f4dcc3b5aa765d61d8327deb882cf99":                             "md5 ntlm md4 lm",
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8":                     "sha1 ripemd160",
+		"$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/":                           "md5crypt",
+		"$2b$04$abcdefghijklmnopqrstuuWbdmHWGpQGMzuXmEdkfEcDzI5Gd3Fia": "bcrypt",
+		"root:$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/:19000:0:99999:7:::": "sha512crypt",
+		"Administrator:500:aad3b435b51404eeaad3b435b51404ee:8846f7eaee8fb117ad06bdd830b7586c:::":                                     "ntlm",
+		"*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19":                                                                                  "mysql5",
+		"$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7":                                                  "yescrypt",
+		"not a hash": "",
+	} {
+		var names []string
+		for _, g := range IdentifyHash(hash) {
+			names = append(names, g.Name)
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("IdentifyHash(%q) = %q, want %q", hash, got, want)
+		}
+	}
+	if g := IdentifyHash("$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/")[0]; g.Hashcat != 500 || g.John != "md5crypt" || !g.Crackable {
+		t.Errorf("md5crypt = %+v", g)
+	}
+}
+
+func TestCryptFormats(t *testing.T) {
+	// Made with openssl passwd and glibc's crypt
+	for setting, want := range map[string]string{
+		"$1$saltsalt$":             "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/",
+		"$apr1$saltsalt$":          "$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/",
+		"$5$saltsalt$":             "$5$saltsalt$gOjOtoMpVhru2uyjeJSEc/JaLQWOXMNmlOnj6T4AtC.",
+		"$6$saltsalt$":             "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/",
+		"$6$rounds=1000$saltsalt$": "$6$rounds=1000$saltsalt$Z/J9iYO1iE9xnr8JPQL57ZWsVRtVjrUv3CiWc/wKWseqXgSqn3HFYJ/Ng7YXa8XlLj.wpdAwHOJJzuGFqBBRa0",
+	} {
+		var got string
+		switch {
+		case strings.HasPrefix(setting, "$1$"):
+			got = md5Crypt("password", setting, "$1$")
+		case strings.HasPrefix(setting, "$apr1$"):
+			got = md5Crypt("password", setting, "$apr1$")
+		default:
+			got = shaCrypt("password", setting, strings.HasPrefix(setting, "$6$"))
+		}
+		if got != want {
+			t.Errorf("crypt(%q) = %q, want %q", setting, got, want)
+		}
+	}
+}
+
+func TestCrack(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("Summer2024"), 4)
+	hashes := []string{
+		"2ac9cb7dc02b3c0083eb70898e549b63", // md5 of Password1
+		"Administrator:500:aad3b435b51404eeaad3b435b51404ee:8846F7EAEE8FB117AD06BDD830B7586C:::",
+		"alice:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/:19000:0:99999:7:::",
+		string(bcryptHash),
+		// hashcat's examples, of the password hashcat
+		"$P$984478476IagS59wHZvyQMArzfx58u.",
+		"admin::N46iSNekpT:08ca45b7d7ea58ee:88dcbe4446168966a153a0064958dac6:5c7830315c7830310000000000000b45c67103d07d7b95acd12ffa11230e0000000052920b85f78d013c31cdb3b92f5d765c783030",
+		"pbkdf2_sha256$1000$seasalt$YIWkt6M1JFXrHg5s0jZjBSc7C2Cz6QvchSJ0h8Y+i7c=",
+		"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"c72bb621c7040cf4b6474063a9a7972690e252f35adbe7cfaff5b1ee2316efe7382e723d41e013a2bfbc33c3007c50c5847d21a889cce838536eac727808de40", // sha512 of a word not in the list
+	}
+	dir := t.TempDir()
+	wordlist := filepath.Join(dir, "words.txt")
+	os.WriteFile(wordlist, []byte("letmein\r\npassword\nsummer\nhashcat\n"), 0644)
+
+	calls := 0
+	result, err := Crack(hashes, WordlistFile(wordlist), CrackOptions{
+		Rules:    []string{"default"},
+		Progress: func(p CrackProgress) bool { calls++; return true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, c := range result.Cracked {
+		got[c.Format+" "+c.User] = c.Password
+	}
+	for key, want := range map[string]string{
+		"md5 ": "Password1", "ntlm Administrator": "password", "md5crypt alice": "password", "bcrypt ": "Summer2024",
+		"phpass ": "hashcat", "netntlmv2 admin": "hashcat", "django_pbkdf2_sha256 ": "password", "ldap_sha ": "password",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %q, want %q", key, got[key], want)
+		}
+	}
+	if len(result.Uncracked) != 1 || result.Status != "exhausted" || calls != 1 || result.Tried == 0 {
+		t.Errorf("uncracked = %v, status = %s, progress calls = %d, tried = %d", result.Uncracked, result.Status, calls, result.Tried)
+	}
+
+	result, err = Crack([]string{"5f4dcc3b5aa765d61d8327deb882cf99"}, WordlistOf([]string{"a", "b", "password", "c"}), CrackOptions{Rules: []string{":", "u"}})
+	if err != nil || result.Status != "cracked" || result.Tried != 8 {
+		t.Errorf("result = %+v, %v", result, err)
+	}
+	result, _ = Crack([]string{"5f4dcc3b5aa765d61d8327deb882cf99"}, WordlistOf([]string{"a", "b", "password"}), CrackOptions{Limit: 2})
+	if result.Status != "limit" || result.Tried != 2 || len(result.Cracked) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if _, err := Crack([]string{"$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7"}, WordlistOf(nil), CrackOptions{}); err == nil || err.Error() != "cannot crack yescrypt hashes" {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := Crack([]string{"5f4dcc3b5aa765d61d8327deb882cf99"}, WordlistOf(nil), CrackOptions{Rules: []string{"$"}}); err == nil || err.Error() != `rule "$": $ takes 1 characters` {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	for rule, want := range map[string]string{
+		":": "p@ssWord", "l": "p@ssword", "u": "P@SSWORD", "c": "P@ssword", "C": "p@SSWORD", "t": "P@SSwORD",
+		"T0": "P@ssWord", "r": "droWss@p", "d": "p@ssWordp@ssWord", "$1 $!": "p@ssWord1!", "^x": "xp@ssWord",
+		"[": "@ssWord", "] ]": "p@ssWo", "D1": "pssWord", "'4": "p@ss", "s@a": "passWord", "@s": "p@Word",
+		"{": "@ssWordp", "}": "dp@ssWor", "f": "p@ssWorddroWss@p", "p1": "p@ssWordp@ssWord",
+	} {
+		funcs, err := parseRule(rule)
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		if got := applyRule(funcs, "p@ssWord"); got != want {
+			t.Errorf("%s = %q, want %q", rule, got, want)
+		}
+	}
+	if _, err := parseRule("x"); err == nil {
+		t.Error("an unknown function was parsed")
+	}
+}
@@ -0,0 +1,169 @@
+package cryptoanalysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificate transparency: the certificates CAs issued for a domain, as
+// the CT logs recorded them, searched through crt.sh
+
+// CTSearchURL is crt.sh, whose JSON output CTSearch reads
+const CTSearchURL = "https://crt.sh/"
+
+// CTCertificate is a certificate a CT log recorded
+type CTCertificate struct {
+	ID         int64
+	Issuer     string
+	CommonName string
+	Names      []string
+	Serial     string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	LoggedAt   time.Time
+}
+
+// CTSearchOptions configure CTSearch
+type CTSearchOptions struct {
+	URL            string // Of crt.sh or a service with its API
+	ExactDomain    bool   // Leaves out the certificates of subdomains
+	IncludeExpired bool
+	Timeout        time.Duration
+}
+
+// crtshEntry is an entry of crt.sh's JSON output
+type crtshEntry struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	EntryTimestamp string `json:"entry_timestamp"`
+}
+
+// CTSearch returns the certificates issued for a domain and, unless
+// ExactDomain is set, its subdomains, newest first. A certificate logged
+// as a precertificate and a certificate is returned once.
+func CTSearch(domain string, opts CTSearchOptions) ([]CTCertificate, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*."), "%.")
+	if domain == "" || strings.ContainsAny(domain, " /%?&") {
+		return nil, fmt.Errorf("not a domain: %q", domain)
+	}
+	base := opts.URL
+	if base == "" {
+		base = CTSearchURL
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+	query := url.Values{"output": {"json"}, "q": {domain}}
+	if !opts.ExactDomain {
+		query.Set("q", "%."+domain)
+	}
+	if !opts.IncludeExpired {
+		query.Set("exclude", "expired")
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "sentra-ct")
+	resp, err := (&http.Client{Timeout: opts.Timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("CT search: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, err
+	}
+	var entries []crtshEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("bad CT search response: %v", err)
+	}
+
+	now := time.Now()
+	seen := map[string]bool{}
+	certs := []CTCertificate{}
+	for _, e := range entries {
+		key := e.IssuerName + "/" + e.SerialNumber
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		c := CTCertificate{
+			ID:         e.ID,
+			Issuer:     e.IssuerName,
+			CommonName: e.CommonName,
+			Serial:     e.SerialNumber,
+			NotBefore:  crtshTime(e.NotBefore),
+			NotAfter:   crtshTime(e.NotAfter),
+			LoggedAt:   crtshTime(e.EntryTimestamp),
+		}
+		if !opts.IncludeExpired && !c.NotAfter.IsZero() && c.NotAfter.Before(now) {
+			continue
+		}
+		names := map[string]bool{}
+		for _, name := range strings.Split(e.NameValue, "\n") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !names[name] {
+				names[name] = true
+				c.Names = append(c.Names, name)
+			}
+		}
+		sort.Strings(c.Names)
+		certs = append(certs, c)
+	}
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotBefore.After(certs[j].NotBefore) })
+	return certs, nil
+}
+
+// crtshTime parses the UTC times of crt.sh, which have no zone
+func crtshTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// CTCertificateToMap returns a CT log entry as a map
+func CTCertificateToMap(c CTCertificate) map[string]interface{} {
+	names := make([]interface{}, len(c.Names))
+	for i, n := range c.Names {
+		names[i] = n
+	}
+	m := map[string]interface{}{
+		"id":          c.ID,
+		"issuer":      c.Issuer,
+		"common_name": c.CommonName,
+		"names":       names,
+		"serial":      c.Serial,
+		"not_before":  c.NotBefore.Format(time.RFC3339),
+		"not_after":   c.NotAfter.Format(time.RFC3339),
+		"logged_at":   c.LoggedAt.Format(time.RFC3339),
+	}
+	if !c.NotAfter.IsZero() {
+		m["days_left"] = daysUntil(c.NotAfter)
+	}
+	return m
+}
+
+// daysUntil counts the whole days until t, negative once it passed
+func daysUntil(t time.Time) int {
+	return int(math.Floor(time.Until(t).Hours() / 24))
+}
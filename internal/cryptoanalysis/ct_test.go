@@ -0,0 +1,79 @@
+package cryptoanalysis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCTSearch(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		future := time.Now().AddDate(0, 2, 0).UTC().Format("2006-01-02T15:04:05")
+		w.Write([]byte(`[
+{"id": 1, "issuer_name": "C=US, O=Let's Encrypt, CN=R3", "common_name": "example.com", "name_value": "example.com\nwww.example.com", "serial_number": "04aa", "not_before": "2024-01-01T00:00:00", "not_after": "` + future + `", "entry_timestamp": "2024-01-01T01:02:03.456"},
+{"id": 2, "issuer_name": "C=US, O=Let's Encrypt, CN=R3", "common_name": "example.com", "name_value": "www.example.com\nexample.com", "serial_number": "04aa", "not_before": "2024-01-01T00:00:00", "not_after": "` + future + `", "entry_timestamp": "2024-01-01T01:02:04"},
+{"id": 3, "issuer_name": "C=US, O=DigiCert Inc, CN=DigiCert", "common_name": "mail.example.com", "name_value": "MAIL.example.com", "serial_number": "0b12", "not_before": "2024-03-01T00:00:00", "not_after": "` + future + `", "entry_timestamp": "2024-03-01T00:00:00"},
+{"id": 4, "issuer_name": "C=US, O=DigiCert Inc, CN=DigiCert", "common_name": "old.example.com", "name_value": "old.example.com", "serial_number": "0b13", "not_before": "2020-03-01T00:00:00", "not_after": "2021-03-01T00:00:00", "entry_timestamp": "2020-03-01T00:00:00"}
+]`))
+	}))
+	defer server.Close()
+
+	certs, err := CTSearch("*.Example.com", CTSearchOptions{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "exclude=expired&output=json&q=%25.example.com" {
+		t.Errorf("query = %s", query)
+	}
+	if len(certs) != 2 || certs[0].CommonName != "mail.example.com" || certs[0].Names[0] != "mail.example.com" ||
+		strings.Join(certs[1].Names, " ") != "example.com www.example.com" || certs[1].LoggedAt.Nanosecond() != 456000000 {
+		t.Errorf("certs = %+v", certs)
+	}
+	if certs, _ := CTSearch("example.com", CTSearchOptions{URL: server.URL, ExactDomain: true, IncludeExpired: true}); len(certs) != 3 || query != "output=json&q=example.com" {
+		t.Errorf("query = %s, certs = %+v", query, certs)
+	}
+	if _, err := CTSearch("example.com/x", CTSearchOptions{URL: server.URL}); err == nil {
+		t.Error("a path was searched")
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	e, err := ParseCertEndpoint(server.URL + "/health")
+	if err != nil || e.Host != "127.0.0.1" {
+		t.Fatalf("endpoint = %+v, %v", e, err)
+	}
+	roots := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	mismatched := e
+	mismatched.ServerName = "other.test"
+	unreachable, _ := ParseCertEndpoint("127.0.0.1:1")
+	statuses := MonitorCertificates([]CertEndpoint{e, mismatched, e, unreachable}, CertMonitorOptions{Roots: roots, Timeout: time.Second})
+	types := func(s CertStatus) string {
+		var kinds []string
+		for _, p := range s.Problems {
+			kinds = append(kinds, p.Type)
+		}
+		return strings.Join(kinds, " ")
+	}
+	if types(statuses[0]) != "" || statuses[0].DaysLeft < 365 || statuses[0].Names[0] != "example.com" {
+		t.Errorf("status = %+v", statuses[0])
+	}
+	if types(statuses[1]) != "mismatch" || types(statuses[3]) != "error" {
+		t.Errorf("statuses = %+v", statuses)
+	}
+
+	status := CheckCertificate(e, CertMonitorOptions{Days: 100000})
+	if types(status) != "expiring untrusted" || status.Problems[1].Detail != "certificate of "+e.String()+" is self-signed" {
+		t.Errorf("status = %+v", status)
+	}
+	m := CertStatusToMap(status)
+	if m["status"] != "expiring" || m["severity"] != "medium" || !strings.HasPrefix(m["title"].(string), "Certificate of 127.0.0.1:") {
+		t.Errorf("map = %v", m)
+	}
+}
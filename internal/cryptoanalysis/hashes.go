@@ -0,0 +1,530 @@
+package cryptoanalysis
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/md4"
+)
+
+// Password hash formats: how to recognise them, their hashcat modes and
+// John the Ripper formats, and how to check a password against the ones
+// Crack supports
+
+// HashGuess is a format a hash may be in
+type HashGuess struct {
+	Name      string // The format Crack takes
+	Hashcat   int    // hashcat's mode, or -1
+	John      string // John the Ripper's format
+	Crackable bool
+}
+
+// hashFormat is a password hash format. Unsalted formats have digest, the
+// normalised hash of a password; salted ones verify, which checks a
+// password against a target.
+type hashFormat struct {
+	name    string
+	hashcat int
+	john    string
+	digest  func(password string) string
+	verify  func(t *hashTarget, password string) bool
+	slow    bool // Costs far more than a fast hash per password
+}
+
+// hashTarget is a hash to crack and the user it belongs to
+type hashTarget struct {
+	line   string // As given
+	user   string
+	hash   string
+	format *hashFormat
+}
+
+var hashFormats = []*hashFormat{
+	{name: "md5", hashcat: 0, john: "raw-md5", digest: hexDigest(md5.New)},
+	{name: "ntlm", hashcat: 1000, john: "nt", digest: func(p string) string { return hex.EncodeToString(ntHash(p)) }},
+	{name: "md4", hashcat: 900, john: "raw-md4", digest: hexDigest(md4.New)},
+	{name: "lm", hashcat: 3000, john: "lm"},
+	{name: "mysql323", hashcat: 200, john: "mysql"},
+	{name: "sha1", hashcat: 100, john: "raw-sha1", digest: hexDigest(sha1.New)},
+	{name: "ripemd160", hashcat: 6000, john: "ripemd-160"},
+	{name: "sha224", hashcat: 1300, john: "raw-sha224", digest: hexDigest(sha256.New224)},
+	{name: "sha256", hashcat: 1400, john: "raw-sha256", digest: hexDigest(sha256.New)},
+	{name: "sha384", hashcat: 10800, john: "raw-sha384", digest: hexDigest(sha512.New384)},
+	{name: "sha512", hashcat: 1700, john: "raw-sha512", digest: hexDigest(sha512.New)},
+	{name: "whirlpool", hashcat: 6100, john: "whirlpool"},
+	{name: "mysql5", hashcat: 300, john: "mysql-sha1", digest: func(p string) string {
+		first := sha1.Sum([]byte(p))
+		second := sha1.Sum(first[:])
+		return "*" + hex.EncodeToString(second[:])
+	}},
+	{name: "ldap_sha", hashcat: 101, john: "nsldap", digest: func(p string) string {
+		sum := sha1.Sum([]byte(p))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	}},
+	{name: "ldap_ssha", hashcat: 111, john: "salted-sha1", verify: verifySSHA},
+	{name: "bcrypt", hashcat: 3200, john: "bcrypt", verify: verifyBcrypt, slow: true},
+	{name: "md5crypt", hashcat: 500, john: "md5crypt", verify: verifyCrypt(func(p, setting string) string { return md5Crypt(p, setting, "$1$") })},
+	{name: "apr1", hashcat: 1600, john: "md5crypt", verify: verifyCrypt(func(p, setting string) string { return md5Crypt(p, setting, "$apr1$") })},
+	{name: "sha256crypt", hashcat: 7400, john: "sha256crypt", verify: verifyCrypt(func(p, setting string) string { return shaCrypt(p, setting, false) }), slow: true},
+	{name: "sha512crypt", hashcat: 1800, john: "sha512crypt", verify: verifyCrypt(func(p, setting string) string { return shaCrypt(p, setting, true) }), slow: true},
+	{name: "phpass", hashcat: 400, john: "phpass", verify: verifyCrypt(phpass)},
+	{name: "django_pbkdf2_sha256", hashcat: 10000, john: "django", verify: verifyDjango, slow: true},
+	{name: "netntlmv2", hashcat: 5600, john: "netntlmv2", verify: verifyNetNTLMv2},
+	{name: "descrypt", hashcat: 1500, john: "descrypt"},
+	{name: "yescrypt", hashcat: -1, john: "crypt"},
+	{name: "argon2", hashcat: 34000, john: "argon2"},
+}
+
+// formatNamed returns a hash format by name
+func formatNamed(name string) *hashFormat {
+	for _, f := range hashFormats {
+		if f.name == strings.ToLower(name) {
+			return f
+		}
+	}
+	return nil
+}
+
+func (f *hashFormat) guess() HashGuess {
+	return HashGuess{Name: f.name, Hashcat: f.hashcat, John: f.john, Crackable: f.digest != nil || f.verify != nil}
+}
+
+var (
+	hexHash     = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	cryptSalt   = regexp.MustCompile(`^[./0-9A-Za-z]+$`)
+	bcryptHash  = regexp.MustCompile(`^\$2[abxy]?\$\d\d\$[./0-9A-Za-z]{53}$`)
+	netNTLMv2   = regexp.MustCompile(`^[^:]*::[^:]*:[0-9a-fA-F]{16}:[0-9a-fA-F]{32}:[0-9a-fA-F]+$`)
+	pwdumpEntry = regexp.MustCompile(`^[^:]*:\d+:[0-9a-fA-F]{32}:[0-9a-fA-F]{32}:`)
+)
+
+// hexLengths are the formats of hex hashes by length, likeliest first
+var hexLengths = map[int][]string{
+	16:  {"mysql323"},
+	32:  {"md5", "ntlm", "md4", "lm"},
+	40:  {"sha1", "ripemd160"},
+	56:  {"sha224"},
+	64:  {"sha256"},
+	96:  {"sha384"},
+	128: {"sha512", "whirlpool"},
+}
+
+// IdentifyHash returns the formats a hash may be in, likeliest first. It
+// also takes pwdump lines, user:rid:lm:nt:::, and /etc/shadow ones.
+func IdentifyHash(hash string) []HashGuess {
+	hash = strings.TrimSpace(hash)
+	var names []string
+	if pwdumpEntry.MatchString(hash) {
+		names = []string{"ntlm"}
+	}
+	_, hash = splitUser(hash)
+	switch {
+	case hash == "", names != nil:
+	case strings.HasPrefix(hash, "$1$"):
+		names = []string{"md5crypt"}
+	case strings.HasPrefix(hash, "$apr1$"):
+		names = []string{"apr1"}
+	case bcryptHash.MatchString(hash):
+		names = []string{"bcrypt"}
+	case strings.HasPrefix(hash, "$5$"):
+		names = []string{"sha256crypt"}
+	case strings.HasPrefix(hash, "$6$"):
+		names = []string{"sha512crypt"}
+	case strings.HasPrefix(hash, "$P$"), strings.HasPrefix(hash, "$H$"):
+		names = []string{"phpass"}
+	case strings.HasPrefix(hash, "$y$"), strings.HasPrefix(hash, "$7$"):
+		names = []string{"yescrypt"}
+	case strings.HasPrefix(hash, "$argon2"):
+		names = []string{"argon2"}
+	case strings.HasPrefix(hash, "pbkdf2_sha256$"):
+		names = []string{"django_pbkdf2_sha256"}
+	case strings.HasPrefix(hash, "{SHA}"):
+		names = []string{"ldap_sha"}
+	case strings.HasPrefix(hash, "{SSHA}"):
+		names = []string{"ldap_ssha"}
+	case netNTLMv2.MatchString(hash):
+		names = []string{"netntlmv2"}
+	case len(hash) == 41 && hash[0] == '*' && hexHash.MatchString(hash[1:]):
+		names = []string{"mysql5"}
+	case len(hash) == 13 && cryptSalt.MatchString(hash):
+		names = []string{"descrypt"}
+	case hexHash.MatchString(hash):
+		names = hexLengths[len(hash)]
+	}
+	guesses := []HashGuess{}
+	for _, name := range names {
+		guesses = append(guesses, formatNamed(name).guess())
+	}
+	return guesses
+}
+
+// splitUser splits the user off pwdump and /etc/shadow lines, taking the
+// NT hash of pwdump ones. Other hashes are returned as they are.
+func splitUser(line string) (user, hash string) {
+	if netNTLMv2.MatchString(line) {
+		return line[:strings.Index(line, ":")], line
+	}
+	if pwdumpEntry.MatchString(line) {
+		fields := strings.Split(line, ":")
+		return fields[0], fields[3]
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) >= 2 && fields[0] != "" && !strings.ContainsAny(fields[0], "${}*") &&
+		(strings.HasPrefix(fields[1], "$") || len(fields[1]) == 13) {
+		return fields[0], fields[1]
+	}
+	return "", line
+}
+
+// newTarget parses a hash to crack, in format or in the likeliest
+// crackable format IdentifyHash finds
+func newTarget(line, format string) (*hashTarget, error) {
+	t := &hashTarget{line: line}
+	t.user, t.hash = splitUser(strings.TrimSpace(line))
+	if format != "" {
+		if t.format = formatNamed(format); t.format == nil {
+			return nil, fmt.Errorf("unknown hash format %q", format)
+		}
+	} else {
+		guesses := IdentifyHash(line)
+		if len(guesses) == 0 {
+			return nil, fmt.Errorf("unrecognised hash %q", t.hash)
+		}
+		t.format = formatNamed(guesses[0].Name)
+	}
+	if t.format.digest == nil && t.format.verify == nil {
+		return nil, fmt.Errorf("cannot crack %s hashes", t.format.name)
+	}
+	if t.format.digest != nil && hexHash.MatchString(t.hash) {
+		t.hash = strings.ToLower(t.hash)
+	}
+	if t.format.name == "mysql5" {
+		t.hash = strings.ToLower(t.hash)
+	}
+	return t, nil
+}
+
+func hexDigest(h func() hash.Hash) func(string) string {
+	return func(p string) string {
+		d := h()
+		d.Write([]byte(p))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+}
+
+// ntHash is the MD4 of a password in UTF-16LE, which Windows stores
+func ntHash(password string) []byte {
+	d := md4.New()
+	d.Write(utf16le(password))
+	return d.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		b[2*i], b[2*i+1] = byte(u), byte(u>>8)
+	}
+	return b
+}
+
+func verifyBcrypt(t *hashTarget, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(t.hash), []byte(password)) == nil
+}
+
+// verifySSHA checks {SSHA}, base64 of the SHA-1 of the password and salt,
+// then the salt
+func verifySSHA(t *hashTarget, password string) bool {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(t.hash, "{SSHA}"))
+	if err != nil || len(raw) <= sha1.Size {
+		return false
+	}
+	sum := sha1.Sum(append([]byte(password), raw[sha1.Size:]...))
+	return subtle.ConstantTimeCompare(sum[:], raw[:sha1.Size]) == 1
+}
+
+// verifyDjango checks pbkdf2_sha256$iterations$salt$hash
+func verifyDjango(t *hashTarget, password string) bool {
+	parts := strings.Split(t.hash, "$")
+	if len(parts) != 4 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, []byte(parts[2]), iterations, len(want))
+	return err == nil && hmac.Equal(key, want)
+}
+
+// verifyNetNTLMv2 checks a captured NTLMv2 response,
+// user::domain:challenge:proof:blob, which is salted by the challenges
+func verifyNetNTLMv2(t *hashTarget, password string) bool {
+	fields := strings.Split(t.hash, ":")
+	challenge, err1 := hex.DecodeString(fields[3])
+	proof, err2 := hex.DecodeString(fields[4])
+	blob, err3 := hex.DecodeString(fields[5])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	mac := hmac.New(md5.New, ntHash(password))
+	mac.Write(utf16le(strings.ToUpper(fields[0]) + fields[2]))
+	mac = hmac.New(md5.New, mac.Sum(nil))
+	mac.Write(challenge)
+	mac.Write(blob)
+	return hmac.Equal(mac.Sum(nil), proof)
+}
+
+// verifyCrypt checks a crypt(3) style hash by hashing the password with
+// its setting, the hash itself
+func verifyCrypt(crypt func(password, setting string) string) func(*hashTarget, string) bool {
+	return func(t *hashTarget, password string) bool {
+		return subtle.ConstantTimeCompare([]byte(crypt(password, t.hash)), []byte(t.hash)) == 1
+	}
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptBase64 appends n characters of the 24 bits of three bytes
+func cryptBase64(out *bytes.Buffer, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+// cryptSetting returns the salt of a crypt hash after its prefix, at most
+// max characters long
+func cryptSetting(setting, prefix string, max int) string {
+	salt := strings.TrimPrefix(setting, prefix)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > max {
+		salt = salt[:max]
+	}
+	return salt
+}
+
+// md5Crypt is the MD5 crypt of FreeBSD, $1$, and Apache, $apr1$
+func md5Crypt(password, setting, magic string) string {
+	pw, salt := []byte(password), []byte(cryptSetting(setting, magic, 8))
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(salt)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic))
+	d.Write(salt)
+	for i := len(pw); i > 0; i -= 16 {
+		d.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	sum := d.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(sum)
+		}
+		if i%3 != 0 {
+			d.Write(salt)
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(sum)
+		} else {
+			d.Write(pw)
+		}
+		sum = d.Sum(nil)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(magic)
+	out.Write(salt)
+	out.WriteByte('$')
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		cryptBase64(&out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+	}
+	cryptBase64(&out, 0, 0, sum[11], 2)
+	return out.String()
+}
+
+// The orders in which SHA-crypt encodes the bytes of its digests
+var (
+	sha256CryptOrder = [][3]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}}
+	sha512CryptOrder = [][3]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10},
+		{53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}}
+)
+
+// shaCrypt is the SHA-256, $5$, and SHA-512, $6$, crypt of glibc
+func shaCrypt(password, setting string, is512 bool) string {
+	prefix, newHash := "$5$", sha256.New
+	if is512 {
+		prefix, newHash = "$6$", sha512.New
+	}
+	rest := strings.TrimPrefix(setting, prefix)
+	rounds, custom := 5000, false
+	if strings.HasPrefix(rest, "rounds=") {
+		end := strings.IndexByte(rest, '$')
+		if end < 0 {
+			return ""
+		}
+		n, err := strconv.Atoi(rest[len("rounds="):end])
+		if err != nil {
+			return ""
+		}
+		rounds, custom = min(max(n, 1000), 999999999), true
+		rest = rest[end+1:]
+	}
+	pw, salt := []byte(password), []byte(cryptSetting(rest, "", 16))
+
+	b := newHash()
+	b.Write(pw)
+	b.Write(salt)
+	b.Write(pw)
+	bSum := b.Sum(nil)
+
+	a := newHash()
+	a.Write(pw)
+	a.Write(salt)
+	for i := len(pw); i > 0; i -= len(bSum) {
+		a.Write(bSum[:min(i, len(bSum))])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(bSum)
+		} else {
+			a.Write(pw)
+		}
+	}
+	sum := a.Sum(nil)
+
+	dp := newHash()
+	for range pw {
+		dp.Write(pw)
+	}
+	p := repeatTo(dp.Sum(nil), len(pw))
+	ds := newHash()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatTo(ds.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		c := newHash()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+		sum = c.Sum(nil)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(prefix)
+	if custom {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+	if is512 {
+		for _, g := range sha512CryptOrder {
+			cryptBase64(&out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+		cryptBase64(&out, 0, 0, sum[63], 2)
+	} else {
+		for _, g := range sha256CryptOrder {
+			cryptBase64(&out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+		cryptBase64(&out, 0, sum[31], sum[30], 3)
+	}
+	return out.String()
+}
+
+// repeatTo repeats b to n bytes
+func repeatTo(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
+
+// phpass is the portable hash of WordPress and phpBB, $P$ or $H$, an
+// iterated MD5
+func phpass(password, setting string) string {
+	if len(setting) < 12 {
+		return ""
+	}
+	log2 := strings.IndexByte(cryptAlphabet, setting[3])
+	if log2 < 7 || log2 > 30 {
+		return ""
+	}
+	salt := setting[4:12]
+	sum := md5.Sum([]byte(salt + password))
+	for i := 0; i < 1<<log2; i++ {
+		sum = md5.Sum(append(sum[:], password...))
+	}
+
+	var out bytes.Buffer
+	out.WriteString(setting[:12])
+	for i := 0; i < len(sum); i += 3 {
+		var b1, b2 byte
+		n := 4
+		if i+1 < len(sum) {
+			b1 = sum[i+1]
+		} else {
+			n = 2
+		}
+		if i+2 < len(sum) {
+			b2 = sum[i+2]
+		} else if n == 4 {
+			n = 3
+		}
+		cryptBase64(&out, b2, b1, sum[i], n)
+	}
+	return out.String()
+}
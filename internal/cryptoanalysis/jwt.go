@@ -0,0 +1,339 @@
+package cryptoanalysis
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JSON Web Tokens, signed with HMAC, RSA or ECDSA. Verification pins the
+// algorithms a key may be used with, so a token cannot pick none, or HMAC
+// keyed with a public key.
+
+// jwtAlgorithm is a JWS signing algorithm
+type jwtAlgorithm struct {
+	family string // HS, RS, PS or ES
+	hash   string
+	curve  string // Of ES algorithms
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"HS256": {"HS", "sha256", ""},
+	"HS384": {"HS", "sha384", ""},
+	"HS512": {"HS", "sha512", ""},
+	"RS256": {"RS", "sha256", ""},
+	"RS384": {"RS", "sha384", ""},
+	"RS512": {"RS", "sha512", ""},
+	"PS256": {"PS", "sha256", ""},
+	"PS384": {"PS", "sha384", ""},
+	"PS512": {"PS", "sha512", ""},
+	"ES256": {"ES", "sha256", "P-256"},
+	"ES384": {"ES", "sha384", "P-384"},
+	"ES512": {"ES", "sha512", "P-521"},
+}
+
+// JWT is a decoded token
+type JWT struct {
+	Header    map[string]interface{}
+	Claims    map[string]interface{}
+	Signature []byte
+}
+
+// JWTOptions configure CreateJWT
+type JWTOptions struct {
+	Algorithm string // HS256 for secrets, RS256 for RSA keys and ES256, ES384 or ES512 for ECDSA ones by default
+	KeyID     string
+	Header    map[string]interface{} // Other header parameters
+	ExpiresIn time.Duration          // Sets exp, and iat if the claims have none
+}
+
+// JWTVerifyOptions configure VerifyJWT. Algorithms are the only ones a
+// token may use, by default the one CreateJWT would sign with the key.
+type JWTVerifyOptions struct {
+	Algorithms []string
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration // Allowed clock skew for exp and nbf
+	Now        time.Time
+}
+
+// isPEM reports whether a key is PEM rather than an HMAC secret
+func isPEM(key string) bool {
+	return strings.Contains(key, "-----BEGIN ")
+}
+
+// defaultAlgorithm is the algorithm a key signs with unless told otherwise
+func defaultAlgorithm(key string) (string, error) {
+	if !isPEM(key) {
+		return "HS256", nil
+	}
+	public, err := ParsePublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	if k, ok := public.(*ecdsa.PublicKey); ok {
+		for name, alg := range jwtAlgorithms {
+			if alg.curve == k.Curve.Params().Name {
+				return name, nil
+			}
+		}
+	}
+	return "RS256", nil
+}
+
+// checkKey checks that a key suits an algorithm: a secret for HMAC, an RSA
+// key for RS and PS, an ECDSA key on its curve for ES
+func checkKey(name string, alg jwtAlgorithm, key string) error {
+	if alg.family == "HS" {
+		if isPEM(key) {
+			return fmt.Errorf("%s takes a secret, not a PEM key", name)
+		}
+		if key == "" {
+			return fmt.Errorf("%s needs a secret", name)
+		}
+		return nil
+	}
+	if !isPEM(key) {
+		return fmt.Errorf("%s takes a PEM key, not a secret", name)
+	}
+	public, err := ParsePublicKey(key)
+	if err != nil {
+		return err
+	}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if alg.family == "ES" {
+			return fmt.Errorf("%s takes an ECDSA key, not an RSA one", name)
+		}
+	case *ecdsa.PublicKey:
+		if alg.family != "ES" {
+			return fmt.Errorf("%s takes an RSA key, not an ECDSA one", name)
+		}
+		if k.Curve.Params().Name != alg.curve {
+			return fmt.Errorf("%s takes a key on %s, not %s", name, alg.curve, k.Curve.Params().Name)
+		}
+	}
+	return nil
+}
+
+// CreateJWT signs claims with a secret or PEM private key
+func CreateJWT(claims map[string]interface{}, key string, opts JWTOptions) (string, error) {
+	name := strings.ToUpper(opts.Algorithm)
+	if name == "" {
+		var err error
+		if name, err = defaultAlgorithm(key); err != nil {
+			return "", err
+		}
+	}
+	alg, ok := jwtAlgorithms[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %q", opts.Algorithm)
+	}
+	if err := checkKey(name, alg, key); err != nil {
+		return "", err
+	}
+
+	header := map[string]interface{}{}
+	for k, v := range opts.Header {
+		header[k] = v
+	}
+	header["alg"], header["typ"] = name, "JWT"
+	if opts.KeyID != "" {
+		header["kid"] = opts.KeyID
+	}
+	if opts.ExpiresIn > 0 {
+		now := time.Now()
+		copied := make(map[string]interface{}, len(claims)+2)
+		for k, v := range claims {
+			copied[k] = v
+		}
+		if _, ok := copied["iat"]; !ok {
+			copied["iat"] = now.Unix()
+		}
+		copied["exp"] = now.Add(opts.ExpiresIn).Unix()
+		claims = copied
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature, err := jwtSign(alg, key, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func jwtSign(alg jwtAlgorithm, key string, input []byte) ([]byte, error) {
+	switch alg.family {
+	case "HS":
+		return HMAC(alg.hash, []byte(key), input)
+	case "RS", "PS":
+		return Sign(key, input, SignOptions{Hash: alg.hash, PSS: alg.family == "PS"})
+	}
+	// ES signatures are r and s, each the size of the curve, not ASN.1
+	signer, err := ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("ES algorithms take an ECDSA key")
+	}
+	d, _, err := digest(alg.hash, input)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, k, d)
+	if err != nil {
+		return nil, err
+	}
+	size := (k.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
+
+func jwtVerify(alg jwtAlgorithm, key string, input, signature []byte) (bool, error) {
+	switch alg.family {
+	case "HS":
+		mac, err := HMAC(alg.hash, []byte(key), input)
+		return err == nil && hmac.Equal(mac, signature), err
+	case "RS", "PS":
+		return Verify(key, input, signature, SignOptions{Hash: alg.hash, PSS: alg.family == "PS"})
+	}
+	public, err := ParsePublicKey(key)
+	if err != nil {
+		return false, err
+	}
+	k := public.(*ecdsa.PublicKey)
+	size := (k.Curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return false, nil
+	}
+	d, _, err := digest(alg.hash, input)
+	if err != nil {
+		return false, err
+	}
+	r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(k, d, r, s), nil
+}
+
+// DecodeJWT decodes a token without verifying it
+func DecodeJWT(token string) (*JWT, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("a JWT has 3 parts, got %d", len(parts))
+	}
+	t := &JWT{}
+	for i, out := range []*map[string]interface{}{&t.Header, &t.Claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT %s: %v", []string{"header", "claims"}[i], err)
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("invalid JWT %s: %v", []string{"header", "claims"}[i], err)
+		}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature: %v", err)
+	}
+	t.Signature = signature
+	return t, nil
+}
+
+// VerifyJWT checks the signature of a token with a secret or PEM public
+// key, then its exp, nbf, iss and aud claims, and returns it
+func VerifyJWT(token, key string, opts JWTVerifyOptions) (*JWT, error) {
+	token = strings.TrimSpace(token)
+	t, err := DecodeJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	allowed := opts.Algorithms
+	if len(allowed) == 0 {
+		name, err := defaultAlgorithm(key)
+		if err != nil {
+			return nil, err
+		}
+		allowed = []string{name}
+	}
+	name, _ := t.Header["alg"].(string)
+	pinned := false
+	for _, a := range allowed {
+		pinned = pinned || strings.EqualFold(a, name)
+	}
+	alg, known := jwtAlgorithms[name]
+	if !pinned || !known {
+		return nil, fmt.Errorf("algorithm %q is not allowed, expected %s", name, strings.Join(allowed, " or "))
+	}
+	if err := checkKey(name, alg, key); err != nil {
+		return nil, err
+	}
+	input := token[:strings.LastIndex(token, ".")]
+	ok, err := jwtVerify(alg, key, []byte(input), t.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if exp, ok := numericDate(t.Claims["exp"]); ok && !now.Before(exp.Add(opts.Leeway)) {
+		return nil, fmt.Errorf("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(t.Claims["nbf"]); ok && now.Before(nbf.Add(-opts.Leeway)) {
+		return nil, fmt.Errorf("token not valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+	if opts.Issuer != "" && t.Claims["iss"] != opts.Issuer {
+		return nil, fmt.Errorf("issuer %v is not %s", t.Claims["iss"], opts.Issuer)
+	}
+	if opts.Audience != "" && !hasAudience(t.Claims["aud"], opts.Audience) {
+		return nil, fmt.Errorf("audience %v does not include %s", t.Claims["aud"], opts.Audience)
+	}
+	return t, nil
+}
+
+// numericDate reads a time claim, seconds since the epoch
+func numericDate(v interface{}) (time.Time, bool) {
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// hasAudience reports whether an aud claim, a string or an array of them,
+// names an audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, item := range a {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
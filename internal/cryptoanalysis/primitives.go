@@ -0,0 +1,305 @@
+package cryptoanalysis
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Crypto primitives: keyed hashes, authenticated encryption, and the
+// RSA and ECDSA keys and signatures that JWTs and signed requests use
+
+// hashes are the hash functions HMAC and signatures take, by name
+var hashes = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// newHash returns the constructor of a hash function by name
+func newHash(name string) (func() hash.Hash, crypto.Hash, error) {
+	switch h := hashes[strings.ToLower(name)]; h {
+	case crypto.SHA1:
+		return sha1.New, h, nil
+	case crypto.SHA256:
+		return sha256.New, h, nil
+	case crypto.SHA384:
+		return sha512.New384, h, nil
+	case crypto.SHA512:
+		return sha512.New, h, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported hash %q, expected sha1, sha256, sha384 or sha512", name)
+}
+
+// HMAC returns the HMAC of data under a key with a hash function by name
+func HMAC(hashName string, key, data []byte) ([]byte, error) {
+	h, _, err := newHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// RandomBytes returns n bytes from the system's secure random source
+func RandomBytes(n int) ([]byte, error) {
+	if n <= 0 || n > 1<<20 {
+		return nil, fmt.Errorf("the number of bytes must be from 1 to 1048576, got %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newGCM returns AES-GCM under a key of 16, 24 or 32 bytes
+func newGCM(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("an AES key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealGCM encrypts and authenticates plaintext and additional data with
+// AES-GCM, returning a random nonce followed by the ciphertext and tag
+func SealGCM(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// OpenGCM decrypts what SealGCM returned, failing when the ciphertext or
+// the additional data were changed
+func OpenGCM(key, sealed, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("message authentication failed")
+	}
+	return plaintext, nil
+}
+
+// KeyPair is a private key and its public key, PEM encoded as PKCS #8 and
+// PKIX
+type KeyPair struct {
+	Type       string // rsa or ecdsa
+	Bits       int    // The modulus size of RSA keys or the curve size of ECDSA ones
+	Curve      string // P-256, P-384 or P-521 for ECDSA keys
+	PrivateKey string
+	PublicKey  string
+}
+
+// GenerateRSAKey generates an RSA key of 2048 bits or more
+func GenerateRSAKey(bits int) (*KeyPair, error) {
+	if bits < 2048 || bits > 8192 || bits%8 != 0 {
+		return nil, fmt.Errorf("RSA keys must be from 2048 to 8192 bits in whole bytes, got %d", bits)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	return keyPair(key, &key.PublicKey)
+}
+
+// curves are the ECDSA curves, by name
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// GenerateECDSAKey generates an ECDSA key on P-256, P-384 or P-521
+func GenerateECDSAKey(curve string) (*KeyPair, error) {
+	c, ok := curves[strings.ToUpper(curve)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q, expected P-256, P-384 or P-521", curve)
+	}
+	key, err := ecdsa.GenerateKey(c, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return keyPair(key, &key.PublicKey)
+}
+
+func keyPair(private crypto.Signer, public crypto.PublicKey) (*KeyPair, error) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	pair := &KeyPair{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		pair.Type, pair.Bits = "rsa", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		pair.Type, pair.Bits, pair.Curve = "ecdsa", k.Curve.Params().BitSize, k.Curve.Params().Name
+	}
+	return pair, nil
+}
+
+// ParsePrivateKey parses a PEM private key: PKCS #8, PKCS #1 for RSA or
+// SEC 1 for ECDSA
+func ParsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("not a PEM private key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("not a private key: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key %T, expected RSA or ECDSA", key)
+}
+
+// ParsePublicKey parses a PEM public key, PKIX or PKCS #1, or takes the
+// public key of a certificate or a private key
+func ParsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("not a PEM public key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		signer, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key %T, expected RSA or ECDSA", key)
+}
+
+// SignOptions choose the hash and, for RSA keys, the padding of signatures
+type SignOptions struct {
+	Hash string // sha256 by default
+	PSS  bool   // RSASSA-PSS instead of PKCS #1 v1.5
+}
+
+// Sign signs data with a PEM private key. ECDSA signatures are ASN.1 DER
+// encoded.
+func Sign(privateKey string, data []byte, opts SignOptions) ([]byte, error) {
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	digest, h, err := digest(opts.Hash, data)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(*rsa.PrivateKey); ok && opts.PSS {
+		return rsa.SignPSS(rand.Reader, k, h, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	}
+	return key.Sign(rand.Reader, digest, h)
+}
+
+// Verify checks a signature of data with a PEM public key
+func Verify(publicKey string, data, signature []byte, opts SignOptions) (bool, error) {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return false, err
+	}
+	digest, h, err := digest(opts.Hash, data)
+	if err != nil {
+		return false, err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if opts.PSS {
+			return rsa.VerifyPSS(k, h, digest, signature, nil) == nil, nil
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest, signature) == nil, nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest, signature), nil
+	}
+	return false, fmt.Errorf("unsupported public key %T", key)
+}
+
+func digest(hashName string, data []byte) ([]byte, crypto.Hash, error) {
+	if hashName == "" {
+		hashName = "sha256"
+	}
+	newH, h, err := newHash(hashName)
+	if err != nil {
+		return nil, 0, err
+	}
+	d := newH()
+	d.Write(data)
+	return d.Sum(nil), h, nil
+}
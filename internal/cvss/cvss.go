@@ -0,0 +1,253 @@
+// Package cvss computes the scores of CVSS vectors, so that vulnerability
+// data from different sources is rated the same way, and looks up how
+// likely a vulnerability is to be exploited in EPSS and whether it is in
+// CISA's catalog of known exploited vulnerabilities.
+package cvss
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Score is what a CVSS vector rates a vulnerability
+type Score struct {
+	Version       string // 3.0, 3.1 or 4.0
+	Vector        string
+	Base          float64
+	Temporal      float64 // CVSS 3, the base score adjusted by the temporal metrics
+	Environmental float64 // CVSS 3, the score with the environmental metrics
+	Score         float64 // The score to rate by, of all the metrics the vector gives
+	Nomenclature  string  // CVSS-B, CVSS-BT, CVSS-BE or CVSS-BTE: which metrics Score covers
+	Severity      string
+	Metrics       map[string]string
+}
+
+// v3Weights are the CVSS 3.x base metric values; PR depends on the scope
+var v3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"S":  {"U": 0, "C": 0},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// v3TemporalWeights are the CVSS 3.x temporal metric values, X being not
+// defined
+var v3TemporalWeights = map[string]map[string]float64{
+	"E":  {"X": 1, "H": 1, "F": 0.97, "P": 0.94, "U": 0.91},
+	"RL": {"X": 1, "U": 1, "W": 0.97, "T": 0.96, "O": 0.95},
+	"RC": {"X": 1, "C": 1, "R": 0.96, "U": 0.92},
+}
+
+// v3Requirements are the values of the security requirements CR, IR and
+// AR. The modified base metrics, MAV to MA, take the values of the base
+// metrics they modify.
+var v3Requirements = map[string]float64{"X": 1, "H": 1.5, "M": 1, "L": 0.5}
+
+// Calculate scores a CVSS 3.0, 3.1 or 4.0 vector
+func Calculate(vector string) (*Score, error) {
+	vector = strings.TrimSpace(vector)
+	switch {
+	case strings.HasPrefix(vector, "CVSS:3.0/"), strings.HasPrefix(vector, "CVSS:3.1/"):
+		return calculateV3(vector)
+	case strings.HasPrefix(vector, "CVSS:4.0/"):
+		return calculateV4(vector)
+	}
+	return nil, fmt.Errorf("not a CVSS 3 or 4 vector: %q", vector)
+}
+
+// parseMetrics splits the metrics of a vector after its version prefix,
+// rejecting metrics given twice and, through valid, unknown metrics and
+// values
+func parseMetrics(vector string, valid func(key, value string) bool) (map[string]string, error) {
+	parts := strings.Split(vector, "/")
+	m := map[string]string{}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed metric %q in %q", part, vector)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("metric %s given twice in %q", key, vector)
+		}
+		if !valid(key, value) {
+			return nil, fmt.Errorf("invalid value %s for %s in %q", value, key, vector)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// BaseScoreV3 returns the base score of a CVSS 3.0 or 3.1 vector such as
+// CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H. Temporal and
+// environmental metrics are ignored.
+func BaseScoreV3(vector string) (float64, error) {
+	vector = strings.TrimSpace(vector)
+	if !strings.HasPrefix(vector, "CVSS:3.0/") && !strings.HasPrefix(vector, "CVSS:3.1/") {
+		return 0, fmt.Errorf("not a CVSS 3 vector: %q", vector)
+	}
+	s, err := calculateV3(vector)
+	if err != nil {
+		return 0, err
+	}
+	return s.Base, nil
+}
+
+// calculateV3 scores a CVSS 3.x vector, its base, temporal and
+// environmental metrics
+func calculateV3(vector string) (*Score, error) {
+	version := strings.TrimPrefix(strings.SplitN(vector, "/", 2)[0], "CVSS:")
+	m, err := parseMetrics(vector, func(key, value string) bool {
+		if weights, ok := v3Weights[key]; ok {
+			_, ok = weights[value]
+			return ok
+		}
+		if weights, ok := v3TemporalWeights[key]; ok {
+			_, ok = weights[value]
+			return ok
+		}
+		switch key {
+		case "CR", "IR", "AR":
+			_, ok := v3Requirements[value]
+			return ok
+		case "MAV", "MAC", "MPR", "MUI", "MS", "MC", "MI", "MA":
+			_, ok := v3Weights[key[1:]][value]
+			return ok || value == "X"
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key := range v3Weights {
+		if m[key] == "" {
+			return nil, fmt.Errorf("missing base metric %s in %q", key, vector)
+		}
+	}
+
+	s := &Score{Version: version, Vector: vector, Metrics: m}
+	s.Base = v3Base(m)
+
+	temporal, temporalGiven := 1.0, false
+	for key, weights := range v3TemporalWeights {
+		value := m[key]
+		if value == "" {
+			value = "X"
+		}
+		temporalGiven = temporalGiven || value != "X"
+		temporal *= weights[value]
+	}
+	s.Temporal = roundUp(s.Base * temporal)
+
+	// The modified metrics take the place of the base ones they modify
+	modified := func(metric string) string {
+		if value := m["M"+metric]; value != "" && value != "X" {
+			return value
+		}
+		return m[metric]
+	}
+	requirement := func(metric string) float64 {
+		if value := m[metric]; value != "" {
+			return v3Requirements[value]
+		}
+		return 1
+	}
+	environmentalGiven := false
+	for key, value := range m {
+		if value != "X" && (strings.HasPrefix(key, "M") || key == "CR" || key == "IR" || key == "AR") {
+			environmentalGiven = true
+		}
+	}
+	miss := math.Min(1-(1-requirement("CR")*v3Weights["C"][modified("C")])*
+		(1-requirement("IR")*v3Weights["I"][modified("I")])*
+		(1-requirement("AR")*v3Weights["A"][modified("A")]), 0.915)
+	changed := modified("S") == "C"
+	impact := 6.42 * miss
+	if changed {
+		if version == "3.0" {
+			impact = 7.52*(miss-0.029) - 3.25*math.Pow(miss-0.02, 15)
+		} else {
+			impact = 7.52*(miss-0.029) - 3.25*math.Pow(miss*0.9731-0.02, 13)
+		}
+	}
+	if impact > 0 {
+		pr := v3PrivilegeWeight(modified("PR"), changed)
+		exploitability := 8.22 * v3Weights["AV"][modified("AV")] * v3Weights["AC"][modified("AC")] * pr * v3Weights["UI"][modified("UI")]
+		if changed {
+			s.Environmental = roundUp(roundUp(math.Min(1.08*(impact+exploitability), 10)) * temporal)
+		} else {
+			s.Environmental = roundUp(roundUp(math.Min(impact+exploitability, 10)) * temporal)
+		}
+	}
+
+	s.Score, s.Nomenclature = s.Base, "CVSS-B"
+	switch {
+	case environmentalGiven && temporalGiven:
+		s.Score, s.Nomenclature = s.Environmental, "CVSS-BTE"
+	case environmentalGiven:
+		s.Score, s.Nomenclature = s.Environmental, "CVSS-BE"
+	case temporalGiven:
+		s.Score, s.Nomenclature = s.Temporal, "CVSS-BT"
+	}
+	s.Severity = Severity(s.Score)
+	return s, nil
+}
+
+// v3Base returns the base score of CVSS 3.x metrics
+func v3Base(m map[string]string) float64 {
+	changed := m["S"] == "C"
+	iss := 1 - (1-v3Weights["C"][m["C"]])*(1-v3Weights["I"][m["I"]])*(1-v3Weights["A"][m["A"]])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0
+	}
+	exploitability := 8.22 * v3Weights["AV"][m["AV"]] * v3Weights["AC"][m["AC"]] * v3PrivilegeWeight(m["PR"], changed) * v3Weights["UI"][m["UI"]]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10))
+	}
+	return roundUp(math.Min(impact+exploitability, 10))
+}
+
+// v3PrivilegeWeight returns the weight of PR, which is higher when the
+// scope changes
+func v3PrivilegeWeight(pr string, changed bool) float64 {
+	if changed && pr == "L" {
+		return 0.68
+	} else if changed && pr == "H" {
+		return 0.5
+	}
+	return v3Weights["PR"][pr]
+}
+
+// roundUp rounds up to one decimal, as CVSS 3.1 defines it to avoid
+// floating point errors
+func roundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// Severity returns the rating of a score: none, low, medium, high or
+// critical
+func Severity(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "medium"
+	case score > 0:
+		return "low"
+	}
+	return "none"
+}
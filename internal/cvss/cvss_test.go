@@ -0,0 +1,127 @@
+package cvss
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBaseScoreV3(t *testing.T) {
+	for vector, want := range map[string]float64{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": 9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": 10.0,
+		"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H": 7.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N": 6.1,
+		"CVSS:3.0/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N": 5.9,
+		"CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:N/I:N/A:N": 0,
+		// Temporal metrics do not change the base score
+		"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N/E:P/RL:O": 6.5,
+	} {
+		got, err := BaseScoreV3(vector)
+		if err != nil {
+			t.Errorf("%s: %v", vector, err)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", vector, got, want)
+		}
+	}
+	for _, vector := range []string{
+		"AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+	} {
+		if _, err := BaseScoreV3(vector); err == nil {
+			t.Errorf("%s: expected an error", vector)
+		}
+	}
+	if Severity(9.8) != "critical" || Severity(6.1) != "medium" || Severity(0) != "none" {
+		t.Error("wrong severity ratings")
+	}
+}
+
+func TestCalculateV3(t *testing.T) {
+	for _, c := range []struct {
+		vector                     string
+		base, temporal, env, score float64
+		nomenclature               string
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8, 9.8, 9.8, 9.8, "CVSS-B"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C", 9.8, 8.8, 8.8, 8.8, "CVSS-BT"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C/CR:L/IR:L/AR:L", 9.8, 8.8, 7.2, 7.2, "CVSS-BTE"},
+		// An attacker needs to be on the local network to reach this host
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/MAV:A", 9.8, 9.8, 8.8, 8.8, "CVSS-BE"},
+		{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:H/A:H/MPR:N/E:X", 9.9, 9.9, 10, 10, "CVSS-BE"},
+	} {
+		s, err := Calculate(c.vector)
+		if err != nil {
+			t.Errorf("%s: %v", c.vector, err)
+			continue
+		}
+		if s.Base != c.base || s.Temporal != c.temporal || s.Environmental != c.env || s.Score != c.score || s.Nomenclature != c.nomenclature {
+			t.Errorf("%s: got %v/%v/%v, score %v %s", c.vector, s.Base, s.Temporal, s.Environmental, s.Score, s.Nomenclature)
+		}
+	}
+	for vector, want := range map[string]string{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:Z":  "invalid value Z for E",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/XX:Y": "invalid value Y for XX",
+		"CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P":               "not a CVSS 3 or 4 vector",
+	} {
+		if _, err := Calculate(vector); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %s", vector, err, want)
+		}
+	}
+}
+
+func TestCalculateV4(t *testing.T) {
+	for vector, want := range map[string]float64{
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N": 9.3,
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:H/SI:H/SA:H": 10,
+		"CVSS:4.0/AV:L/AC:L/AT:N/PR:L/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N": 8.5,
+		"CVSS:4.0/AV:P/AC:H/AT:P/PR:H/UI:A/VC:N/VI:N/VA:N/SC:N/SI:N/SA:N": 0,
+		// Safety impact makes it as severe as it gets
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N/MSI:S": 10,
+		// Supplemental metrics do not change the score
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N/S:P/AU:Y/U:Red": 9.3,
+	} {
+		s, err := Calculate(vector)
+		if err != nil {
+			t.Errorf("%s: %v", vector, err)
+		} else if s.Score != want {
+			t.Errorf("%s: got %v, want %v", vector, s.Score, want)
+		}
+	}
+
+	// Every vector scores between 0 and 10, and no higher than its
+	// macrovector
+	values := func(metric string) []string { return v4Values[metric] }
+	for _, av := range values("AV") {
+		for _, pr := range values("PR") {
+			for _, vc := range values("VC") {
+				for _, sa := range values("SA") {
+					for _, e := range values("E") {
+						vector := "CVSS:4.0/AV:" + av + "/AC:L/AT:N/PR:" + pr + "/UI:P/VC:" + vc + "/VI:L/VA:N/SC:L/SI:N/SA:" + sa + "/E:" + e
+						s, err := Calculate(vector)
+						if err != nil {
+							t.Fatalf("%s: %v", vector, err)
+						}
+						if max := v4Lookup(v4MacroVector(s.Metrics)); s.Score < 0 || s.Score > max {
+							t.Errorf("%s: %v is outside 0-%v", vector, s.Score, max)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	s, _ := Calculate("CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N/E:U/CR:L")
+	if s.Nomenclature != "CVSS-BTE" || s.Base != 9.3 || s.Score >= s.Base {
+		t.Errorf("threat and environment: %s base %v score %v", s.Nomenclature, s.Base, s.Score)
+	}
+	for vector, want := range map[string]string{
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N":      "missing base metric SA",
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:S/SA:N": "invalid value S for SI",
+	} {
+		if _, err := Calculate(vector); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %s", vector, err, want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package cvss
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// epssAPI and kevFeed are where EPSS scores and CISA's catalog of known
+// exploited vulnerabilities are fetched from; tests point them elsewhere
+var (
+	epssAPI = "https://api.first.org/data/v1/epss"
+	kevFeed = "https://www.cisa.gov/sites/default/files/feeds/known_exploited_vulnerabilities.json"
+)
+
+// enrichTTL is how long cached EPSS scores and KEV catalogs are used; both
+// are published daily
+const enrichTTL = 24 * time.Hour
+
+// cveID matches CVE ids
+var cveID = regexp.MustCompile(`^CVE-\d{4}-\d{4,}$`)
+
+// EPSS is the probability that a vulnerability is exploited in the next
+// 30 days, and the share of vulnerabilities less likely to be
+type EPSS struct {
+	CVE        string  `json:"cve"`
+	Score      float64 `json:"epss"`
+	Percentile float64 `json:"percentile"`
+	Date       string  `json:"date"`
+}
+
+// KEV is an entry of the catalog of known exploited vulnerabilities
+type KEV struct {
+	CVE            string   `json:"cveID"`
+	Vendor         string   `json:"vendorProject"`
+	Product        string   `json:"product"`
+	Name           string   `json:"vulnerabilityName"`
+	DateAdded      string   `json:"dateAdded"`
+	Description    string   `json:"shortDescription"`
+	RequiredAction string   `json:"requiredAction"`
+	DueDate        string   `json:"dueDate"`
+	Ransomware     string   `json:"knownRansomwareCampaignUse"` // Known or Unknown
+	Notes          string   `json:"notes"`
+	CWEs           []string `json:"cwes"`
+}
+
+// Options configure where an Enricher finds EPSS scores and the KEV
+// catalog. Files take the place of the services, to work offline.
+type Options struct {
+	CacheDir string // Of the services' answers, in the user's cache directory by default
+	EPSSFile string // A daily EPSS export, CSV or gzipped CSV
+	KEVFile  string // The KEV catalog as JSON
+	Offline  bool   // Only files and cached answers are used
+	Timeout  time.Duration
+}
+
+// Enricher looks up EPSS scores and KEV entries, fetching each once
+type Enricher struct {
+	opts Options
+	http *http.Client
+
+	mu   sync.Mutex
+	epss map[string]*EPSS // A nil entry is a CVE EPSS does not score
+	kev  map[string]KEV
+}
+
+// NewEnricher returns an enricher
+func NewEnricher(opts Options) *Enricher {
+	if opts.CacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+		opts.CacheDir = filepath.Join(dir, "sentra", "vulnintel")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &Enricher{opts: opts, http: &http.Client{Timeout: opts.Timeout}}
+}
+
+// NormalizeCVE returns a CVE id in upper case, or an error for what is
+// not one
+func NormalizeCVE(id string) (string, error) {
+	cve := strings.ToUpper(strings.TrimSpace(id))
+	if !cveID.MatchString(cve) {
+		return "", fmt.Errorf("not a CVE id: %q", id)
+	}
+	return cve, nil
+}
+
+// EPSS returns the EPSS scores of CVEs. CVEs EPSS does not score are left
+// out.
+func (e *Enricher) EPSS(ids []string) (map[string]EPSS, error) {
+	cves := make([]string, 0, len(ids))
+	for _, id := range ids {
+		cve, err := NormalizeCVE(id)
+		if err != nil {
+			return nil, err
+		}
+		cves = append(cves, cve)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.epss == nil {
+		e.epss = make(map[string]*EPSS)
+		if e.opts.EPSSFile != "" {
+			if err := e.loadEPSSFile(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var missing []string
+	for _, cve := range cves {
+		if _, known := e.epss[cve]; !known && e.opts.EPSSFile == "" {
+			missing = append(missing, cve)
+		}
+	}
+	if err := e.fetchEPSS(missing); err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]EPSS)
+	for _, cve := range cves {
+		if s := e.epss[cve]; s != nil {
+			scores[cve] = *s
+		}
+	}
+	return scores, nil
+}
+
+// loadEPSSFile reads a daily EPSS export: a comment with the model version
+// and score date, a header and a line of cve, epss and percentile for each
+// CVE
+func (e *Enricher) loadEPSSFile() error {
+	f, err := os.Open(e.opts.EPSSFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(e.opts.EPSSFile, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", e.opts.EPSSFile, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	date := ""
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			for _, field := range strings.Split(strings.TrimPrefix(text, "#"), ",") {
+				if key, value, _ := strings.Cut(field, ":"); key == "score_date" && len(value) >= 10 {
+					date = value[:10]
+				}
+			}
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if text == "" || fields[0] == "cve" {
+			continue
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("%s:%d: expected cve,epss,percentile", e.opts.EPSSFile, line)
+		}
+		score, err1 := strconv.ParseFloat(fields[1], 64)
+		percentile, err2 := strconv.ParseFloat(fields[2], 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("%s:%d: bad score in %q", e.opts.EPSSFile, line, text)
+		}
+		cve := strings.ToUpper(fields[0])
+		e.epss[cve] = &EPSS{CVE: cve, Score: score, Percentile: percentile, Date: date}
+	}
+	return scanner.Err()
+}
+
+// epssResponse is an answer of the EPSS API, which gives numbers as
+// strings
+type epssResponse struct {
+	Data []struct {
+		CVE        string `json:"cve"`
+		EPSS       string `json:"epss"`
+		Percentile string `json:"percentile"`
+		Date       string `json:"date"`
+	} `json:"data"`
+}
+
+// fetchEPSS asks the EPSS API for the scores of CVEs, a hundred at a time,
+// using the answers cached less than a day ago
+func (e *Enricher) fetchEPSS(cves []string) error {
+	cacheFile := func(cve string) string { return filepath.Join(e.opts.CacheDir, "epss", cve+".json") }
+	var pending []string
+	for _, cve := range cves {
+		if data, ok := readCache(cacheFile(cve)); ok {
+			var s *EPSS
+			if json.Unmarshal(data, &s) == nil {
+				e.epss[cve] = s
+				continue
+			}
+		}
+		pending = append(pending, cve)
+	}
+	if len(pending) > 0 && e.opts.Offline {
+		return fmt.Errorf("no EPSS score of %s is cached and lookups are offline", pending[0])
+	}
+
+	for start := 0; start < len(pending); start += 100 {
+		batch := pending[start:min(start+100, len(pending))]
+		var resp epssResponse
+		if err := e.get(epssAPI+"?cve="+url.QueryEscape(strings.Join(batch, ",")), "EPSS", &resp); err != nil {
+			return err
+		}
+		for _, cve := range batch {
+			e.epss[cve] = nil
+		}
+		for _, d := range resp.Data {
+			score, _ := strconv.ParseFloat(d.EPSS, 64)
+			percentile, _ := strconv.ParseFloat(d.Percentile, 64)
+			cve := strings.ToUpper(d.CVE)
+			e.epss[cve] = &EPSS{CVE: cve, Score: score, Percentile: percentile, Date: d.Date}
+		}
+		for _, cve := range batch {
+			data, _ := json.Marshal(e.epss[cve])
+			writeCache(cacheFile(cve), data)
+		}
+	}
+	return nil
+}
+
+// KEV returns the catalog entry of a CVE, or nil when it is not known to
+// be exploited
+func (e *Enricher) KEV(id string) (*KEV, error) {
+	cve, err := NormalizeCVE(id)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.kev == nil {
+		if err := e.loadKEV(); err != nil {
+			return nil, err
+		}
+	}
+	if entry, ok := e.kev[cve]; ok {
+		return &entry, nil
+	}
+	return nil, nil
+}
+
+// loadKEV reads the KEV catalog from its file, the cache or CISA's feed
+func (e *Enricher) loadKEV() error {
+	var data []byte
+	var err error
+	cacheFile := filepath.Join(e.opts.CacheDir, "kev.json")
+	switch cached, ok := readCache(cacheFile); {
+	case e.opts.KEVFile != "":
+		if data, err = os.ReadFile(e.opts.KEVFile); err != nil {
+			return err
+		}
+	case ok:
+		data = cached
+	case e.opts.Offline:
+		return fmt.Errorf("the KEV catalog is not cached and lookups are offline")
+	default:
+		var raw json.RawMessage
+		if err := e.get(kevFeed, "KEV", &raw); err != nil {
+			return err
+		}
+		data = raw
+		writeCache(cacheFile, data)
+	}
+
+	var catalog struct {
+		Vulnerabilities []KEV `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("KEV catalog: %v", err)
+	}
+	e.kev = make(map[string]KEV, len(catalog.Vulnerabilities))
+	for _, v := range catalog.Vulnerabilities {
+		e.kev[strings.ToUpper(v.CVE)] = v
+	}
+	return nil
+}
+
+// get fetches JSON from a service
+func (e *Enricher) get(rawURL, service string, out interface{}) error {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "sentra-cvss")
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", service, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readCache reads a cache file written less than enrichTTL ago
+func readCache(file string) ([]byte, bool) {
+	info, err := os.Stat(file)
+	if err != nil || time.Since(info.ModTime()) > enrichTTL {
+		return nil, false
+	}
+	data, err := os.ReadFile(file)
+	return data, err == nil
+}
+
+func writeCache(file string, data []byte) {
+	if os.MkdirAll(filepath.Dir(file), 0o755) == nil {
+		os.WriteFile(file, data, 0o644)
+	}
+}
@@ -0,0 +1,98 @@
+package cvss
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEPSS(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var data []map[string]string
+		for _, cve := range strings.Split(r.URL.Query().Get("cve"), ",") {
+			if cve == "CVE-2021-44228" {
+				data = append(data, map[string]string{"cve": cve, "epss": "0.944580000", "percentile": "0.999990000", "date": "2024-05-01"})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "OK", "data": data})
+	}))
+	defer server.Close()
+	defer func(api string) { epssAPI = api }(epssAPI)
+	epssAPI = server.URL
+
+	cache := t.TempDir()
+	scores, err := NewEnricher(Options{CacheDir: cache}).EPSS([]string{"cve-2021-44228", "CVE-2000-0001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := scores["CVE-2021-44228"]; len(scores) != 1 || s.Score != 0.94458 || s.Percentile != 0.99999 || s.Date != "2024-05-01" {
+		t.Errorf("scores = %+v", scores)
+	}
+
+	// Answers are cached, those without a score too
+	offline := NewEnricher(Options{CacheDir: cache, Offline: true})
+	if scores, err := offline.EPSS([]string{"CVE-2021-44228", "CVE-2000-0001"}); err != nil || len(scores) != 1 || requests != 1 {
+		t.Errorf("cached scores = %+v, %v after %d requests", scores, err, requests)
+	}
+	if _, err := offline.EPSS([]string{"CVE-2023-4863"}); err == nil || err.Error() != "no EPSS score of CVE-2023-4863 is cached and lookups are offline" {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := offline.EPSS([]string{"log4shell"}); err == nil || err.Error() != `not a CVE id: "log4shell"` {
+		t.Errorf("err = %v", err)
+	}
+
+	// The daily export is read instead of asking the API
+	file := filepath.Join(t.TempDir(), "epss_scores-2024-05-01.csv.gz")
+	f, _ := os.Create(file)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("#model_version:v2023.03.01,score_date:2024-05-01T00:00:00+0000\ncve,epss,percentile\nCVE-2023-4863,0.42,0.97\n"))
+	gz.Close()
+	f.Close()
+	scores, err = NewEnricher(Options{EPSSFile: file, Offline: true}).EPSS([]string{"CVE-2023-4863", "CVE-2000-0001"})
+	if s := scores["CVE-2023-4863"]; err != nil || len(scores) != 1 || s.Score != 0.42 || s.Date != "2024-05-01" {
+		t.Errorf("scores from the export = %+v, %v", scores, err)
+	}
+}
+
+func TestKEV(t *testing.T) {
+	catalog := `{"title": "CISA Catalog of Known Exploited Vulnerabilities", "vulnerabilities": [
+		{"cveID": "CVE-2021-44228", "vendorProject": "Apache", "product": "Log4j2", "vulnerabilityName": "Apache Log4j2 Remote Code Execution Vulnerability",
+		 "dateAdded": "2021-12-10", "requiredAction": "Apply updates per vendor instructions.", "dueDate": "2021-12-24", "knownRansomwareCampaignUse": "Known", "cwes": ["CWE-20", "CWE-400"]}]}`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(catalog))
+	}))
+	defer server.Close()
+	defer func(feed string) { kevFeed = feed }(kevFeed)
+	kevFeed = server.URL
+
+	cache := t.TempDir()
+	e := NewEnricher(Options{CacheDir: cache})
+	entry, err := e.KEV("CVE-2021-44228")
+	if err != nil || entry == nil || entry.Product != "Log4j2" || entry.Ransomware != "Known" || entry.DueDate != "2021-12-24" || len(entry.CWEs) != 2 {
+		t.Fatalf("entry = %+v, %v", entry, err)
+	}
+	if entry, err := e.KEV("CVE-2000-0001"); entry != nil || err != nil {
+		t.Errorf("entry = %+v, %v", entry, err)
+	}
+	if entry, err := NewEnricher(Options{CacheDir: cache, Offline: true}).KEV("CVE-2021-44228"); entry == nil || err != nil || requests != 1 {
+		t.Errorf("cached entry = %+v, %v after %d requests", entry, err, requests)
+	}
+	if _, err := NewEnricher(Options{CacheDir: t.TempDir(), Offline: true}).KEV("CVE-2021-44228"); err == nil || err.Error() != "the KEV catalog is not cached and lookups are offline" {
+		t.Errorf("err = %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "kev.json")
+	os.WriteFile(file, []byte(catalog), 0644)
+	if entry, err := NewEnricher(Options{KEVFile: file, Offline: true}).KEV("cve-2021-44228"); entry == nil || err != nil {
+		t.Errorf("entry from the file = %+v, %v", entry, err)
+	}
+}
@@ -0,0 +1,977 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Finding is a security issue in a database's settings, accounts or
+// encryption
+type Finding struct {
+	ID          string // Names the check, such as MYSQL-LOCAL-INFILE
+	Category    string // authentication, privileges, configuration, encryption or logging
+	Severity    string // CRITICAL, HIGH, MEDIUM or LOW
+	Description string
+	Evidence    string
+	Remediation string
+}
+
+// DBUser is an account and what it may do
+type DBUser struct {
+	Name       string
+	Host       string // Where a MySQL account may connect from
+	Privileges []string
+	Superuser  bool
+	NoPassword bool
+	Disabled   bool // Locked, or a role that cannot log in
+	Risk       string
+}
+
+// Encryption is how a database encrypts data in transit and at rest
+type Encryption struct {
+	InTransit  bool // The audit's own connection is encrypted
+	TLSVersion string
+	Cipher     string
+	AtRest     *bool  // nil when the database cannot tell
+	AtRestBy   string // What encrypts data at rest, such as TDE
+}
+
+// SecurityReport is what the collectors for a database's engine read
+// from it, and the findings made from that
+type SecurityReport struct {
+	Type       string
+	Version    string
+	Settings   map[string]string
+	Users      []DBUser
+	Encryption Encryption
+	Findings   []Finding
+	Errors     []string // What could not be read, mostly for lack of privileges
+}
+
+// Audit reads a database's settings, accounts and encryption with the
+// collectors for its engine, and reports their security issues. What the
+// connected account may not read is left out and noted in Errors, never
+// guessed.
+func Audit(h *Handle) *SecurityReport {
+	r := &SecurityReport{Type: h.Type, Version: h.Version(), Settings: map[string]string{}}
+	switch h.Type {
+	case "mysql":
+		auditMySQL(h, r)
+	case "postgres":
+		auditPostgres(h, r)
+	case "mssql":
+		auditMSSQL(h, r)
+	case "sqlite":
+		auditSQLite(h, r)
+	case "mongodb":
+		auditMongo(h, r)
+	case "redis":
+		auditRedis(h, r)
+	}
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		return severityRank[r.Findings[i].Severity] > severityRank[r.Findings[j].Severity]
+	})
+	return r
+}
+
+var severityRank = map[string]int{"CRITICAL": 4, "HIGH": 3, "MEDIUM": 2, "LOW": 1}
+
+// RiskScore scores findings from 0 up to 100 by their severity
+func RiskScore(findings []Finding) int {
+	score := 0
+	for _, f := range findings {
+		score += map[string]int{"CRITICAL": 40, "HIGH": 20, "MEDIUM": 10, "LOW": 3}[f.Severity]
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score
+}
+
+// FindingsIn returns the findings in any of the categories
+func (r *SecurityReport) FindingsIn(categories ...string) []Finding {
+	var found []Finding
+	for _, f := range r.Findings {
+		for _, c := range categories {
+			if f.Category == c {
+				found = append(found, f)
+				break
+			}
+		}
+	}
+	return found
+}
+
+func (r *SecurityReport) add(f Finding) {
+	r.Findings = append(r.Findings, f)
+}
+
+// query runs a collector's query, noting in Errors what it failed to read
+func (r *SecurityReport) query(h *Handle, what, query string, args ...interface{}) []map[string]interface{} {
+	rows, err := h.Query(query, args...)
+	if err != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", what, err))
+		return nil
+	}
+	return rows
+}
+
+// text formats a column for settings and evidence; NULL is NULL
+func text(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprint(v)
+}
+
+// truthy reads the many ways databases spell a boolean column
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case string:
+		switch strings.ToUpper(v) {
+		case "Y", "YES", "ON", "TRUE", "T", "1":
+			return true
+		}
+	}
+	return false
+}
+
+// userRisk rates an account: no password is CRITICAL, an administrator
+// reachable from anywhere HIGH, any other administrator MEDIUM
+func userRisk(u DBUser, remote, powerful bool) string {
+	switch {
+	case u.Disabled:
+		return "LOW"
+	case u.NoPassword:
+		return "CRITICAL"
+	case u.Superuser && remote:
+		return "HIGH"
+	case u.Superuser || powerful:
+		return "MEDIUM"
+	}
+	return "LOW"
+}
+
+// tooManyAdmins is the finding for more than three administrators
+func tooManyAdmins(id string, admins []string) Finding {
+	return Finding{
+		ID: id, Category: "privileges", Severity: "MEDIUM",
+		Description: "Too many accounts with administrative privileges",
+		Evidence:    fmt.Sprintf("%d administrators: %s", len(admins), strings.Join(admins, ", ")),
+		Remediation: "Review and reduce administrative privileges",
+	}
+}
+
+func isLoopback(host string) bool {
+	switch strings.ToLower(host) {
+	case "localhost", "127.0.0.1", "::1", "samehost":
+		return true
+	}
+	return false
+}
+
+// mysqlSettings are the server variables the MySQL collector reads
+var mysqlSettings = map[string]bool{
+	"have_ssl": true, "require_secure_transport": true, "tls_version": true,
+	"local_infile": true, "secure_file_priv": true, "log_bin": true, "general_log": true,
+	"validate_password.policy": true, "validate_password.length": true,
+	"validate_password_policy": true, "validate_password_length": true,
+	"default_table_encryption": true, "innodb_encrypt_tables": true,
+	"bind_address": true, "default_authentication_plugin": true,
+}
+
+func auditMySQL(h *Handle, r *SecurityReport) {
+	for _, row := range r.query(h, "server variables", "SHOW GLOBAL VARIABLES") {
+		if name := text(row["Variable_name"]); mysqlSettings[name] {
+			r.Settings[name] = text(row["Value"])
+		}
+	}
+	status := map[string]string{}
+	for _, row := range r.query(h, "session TLS status", "SHOW SESSION STATUS LIKE 'Ssl_%'") {
+		status[text(row["Variable_name"])] = text(row["Value"])
+	}
+	r.Encryption.TLSVersion, r.Encryption.Cipher = status["Ssl_version"], status["Ssl_cipher"]
+	r.Encryption.InTransit = r.Encryption.TLSVersion != ""
+
+	if s := r.Settings; len(s) > 0 {
+		policy, ok := s["validate_password.policy"]
+		length := s["validate_password.length"]
+		if !ok {
+			policy, ok = s["validate_password_policy"]
+			length = s["validate_password_length"]
+		}
+		if !ok {
+			r.add(Finding{
+				ID: "MYSQL-VALIDATE-PASSWORD", Category: "authentication", Severity: "MEDIUM",
+				Description: "No password validation component is installed",
+				Evidence:    "validate_password.policy is not set",
+				Remediation: "INSTALL COMPONENT 'file://component_validate_password' and set a MEDIUM or STRONG policy",
+			})
+		} else {
+			if policy == "LOW" || policy == "0" {
+				r.add(Finding{
+					ID: "MYSQL-PASSWORD-POLICY", Category: "authentication", Severity: "LOW",
+					Description: "Password validation only checks length",
+					Evidence:    "validate_password.policy = " + policy,
+					Remediation: "Set validate_password.policy to MEDIUM or STRONG",
+				})
+			}
+			if n, err := strconv.Atoi(length); err == nil && n < 12 {
+				r.add(Finding{
+					ID: "MYSQL-PASSWORD-LENGTH", Category: "authentication", Severity: "LOW",
+					Description: "Minimum password length is under 12",
+					Evidence:    "validate_password.length = " + length,
+					Remediation: "Set validate_password.length to 12 or more",
+				})
+			}
+		}
+		if s["local_infile"] == "ON" {
+			r.add(Finding{
+				ID: "MYSQL-LOCAL-INFILE", Category: "configuration", Severity: "MEDIUM",
+				Description: "LOAD DATA LOCAL is enabled, so a rogue server or injected query can read client files",
+				Evidence:    "local_infile = ON",
+				Remediation: "Set local_infile = OFF",
+			})
+		}
+		if v, ok := s["secure_file_priv"]; ok && v == "" {
+			r.add(Finding{
+				ID: "MYSQL-SECURE-FILE-PRIV", Category: "configuration", Severity: "HIGH",
+				Description: "Accounts with FILE can read and write files anywhere the server can",
+				Evidence:    "secure_file_priv is empty",
+				Remediation: "Set secure_file_priv to a dedicated directory, or NULL to disable file import and export",
+			})
+		}
+		tlsAvailable := s["have_ssl"] == "YES" || r.Encryption.InTransit
+		if !tlsAvailable {
+			r.add(Finding{
+				ID: "MYSQL-TLS-DISABLED", Category: "encryption", Severity: "HIGH",
+				Description: "The server does not offer TLS, so credentials and data cross the network in plaintext",
+				Evidence:    "have_ssl = " + text(s["have_ssl"]),
+				Remediation: "Configure ssl_cert and ssl_key and restart the server",
+			})
+		} else if s["require_secure_transport"] != "ON" {
+			r.add(Finding{
+				ID: "MYSQL-INSECURE-TRANSPORT", Category: "encryption", Severity: "MEDIUM",
+				Description: "Clients may connect without TLS",
+				Evidence:    "require_secure_transport = " + s["require_secure_transport"],
+				Remediation: "Set require_secure_transport = ON",
+			})
+		}
+		if s["log_bin"] == "OFF" {
+			r.add(Finding{
+				ID: "MYSQL-BINLOG", Category: "logging", Severity: "LOW",
+				Description: "Binary logging is disabled, so changes leave no audit trail",
+				Evidence:    "log_bin = OFF",
+				Remediation: "Enable binary logging",
+			})
+		}
+		encrypted := s["default_table_encryption"] == "ON" || s["innodb_encrypt_tables"] == "ON" || s["innodb_encrypt_tables"] == "FORCE"
+		r.Encryption.AtRest = &encrypted
+		if encrypted {
+			r.Encryption.AtRestBy = "InnoDB tablespace encryption"
+		} else {
+			r.add(Finding{
+				ID: "MYSQL-AT-REST", Category: "encryption", Severity: "MEDIUM",
+				Description: "New tables are not encrypted at rest",
+				Evidence:    "default_table_encryption = " + text(s["default_table_encryption"]),
+				Remediation: "Set up a keyring component and set default_table_encryption = ON",
+			})
+		}
+	}
+
+	var admins []string
+	for _, row := range r.query(h, "accounts", "SELECT * FROM mysql.user") {
+		u := DBUser{Name: text(row["User"]), Host: text(row["Host"])}
+		for col, v := range row {
+			if name, ok := strings.CutSuffix(col, "_priv"); ok && truthy(v) {
+				u.Privileges = append(u.Privileges, strings.ToUpper(strings.ReplaceAll(name, "_", " ")))
+			}
+		}
+		sort.Strings(u.Privileges)
+		plugin := text(row["plugin"])
+		u.NoPassword = text(row["authentication_string"]) == "" && (row["Password"] == nil || text(row["Password"]) == "") &&
+			plugin != "auth_socket" && plugin != "unix_socket"
+		u.Superuser = truthy(row["Super_priv"])
+		u.Disabled = truthy(row["account_locked"])
+		remote := !isLoopback(u.Host)
+		u.Risk = userRisk(u, remote, truthy(row["File_priv"]) || truthy(row["Grant_priv"]))
+		r.Users = append(r.Users, u)
+		if u.Disabled {
+			continue
+		}
+		account := fmt.Sprintf("'%s'@'%s'", u.Name, u.Host)
+		if u.NoPassword {
+			r.add(Finding{
+				ID: "MYSQL-EMPTY-PASSWORD", Category: "authentication", Severity: "CRITICAL",
+				Description: "Account with no password",
+				Evidence:    account + " has no password",
+				Remediation: "Set a strong password, or drop the account",
+			})
+		}
+		if u.Name == "" {
+			r.add(Finding{
+				ID: "MYSQL-ANONYMOUS-USER", Category: "authentication", Severity: "HIGH",
+				Description: "Anonymous account that any user name matches",
+				Evidence:    account,
+				Remediation: "DROP USER ''@'" + u.Host + "'",
+			})
+		}
+		if u.Superuser {
+			admins = append(admins, account)
+			if remote {
+				r.add(Finding{
+					ID: "MYSQL-REMOTE-ADMIN", Category: "privileges", Severity: "HIGH",
+					Description: "Administrator that may connect from other hosts",
+					Evidence:    account + " has SUPER",
+					Remediation: "Limit administrators to localhost, and grant remote accounts only what they need",
+				})
+			}
+		} else if truthy(row["File_priv"]) {
+			r.add(Finding{
+				ID: "MYSQL-FILE-PRIV", Category: "privileges", Severity: "MEDIUM",
+				Description: "Account that can read and write server files",
+				Evidence:    account + " has FILE",
+				Remediation: "REVOKE FILE ON *.* FROM " + account,
+			})
+		}
+	}
+	if len(admins) > 3 {
+		r.add(tooManyAdmins("MYSQL-ADMIN-COUNT", admins))
+	}
+}
+
+func auditPostgres(h *Handle, r *SecurityReport) {
+	for _, row := range r.query(h, "settings", "SELECT name, setting FROM pg_settings WHERE name IN "+
+		"('ssl', 'ssl_min_protocol_version', 'password_encryption', 'log_connections', 'log_statement', 'listen_addresses')") {
+		r.Settings[text(row["name"])] = text(row["setting"])
+	}
+	for _, row := range r.query(h, "session TLS status", "SELECT ssl, version, cipher FROM pg_stat_ssl WHERE pid = pg_backend_pid()") {
+		r.Encryption.InTransit = truthy(row["ssl"])
+		if r.Encryption.InTransit {
+			r.Encryption.TLSVersion, r.Encryption.Cipher = text(row["version"]), text(row["cipher"])
+		}
+	}
+	// PostgreSQL has no encryption at rest of its own; the disk may, which
+	// the server cannot see
+
+	if s := r.Settings; len(s) > 0 {
+		if s["ssl"] != "on" {
+			r.add(Finding{
+				ID: "PG-TLS-DISABLED", Category: "encryption", Severity: "HIGH",
+				Description: "The server does not offer TLS, so credentials and data cross the network in plaintext",
+				Evidence:    "ssl = " + s["ssl"],
+				Remediation: "Set ssl = on with ssl_cert_file and ssl_key_file",
+			})
+		}
+		if s["password_encryption"] == "md5" {
+			r.add(Finding{
+				ID: "PG-MD5-PASSWORDS", Category: "authentication", Severity: "MEDIUM",
+				Description: "New passwords are stored as MD5 hashes",
+				Evidence:    "password_encryption = md5",
+				Remediation: "Set password_encryption = scram-sha-256 and reset passwords",
+			})
+		}
+		if s["log_connections"] == "off" {
+			r.add(Finding{
+				ID: "PG-LOG-CONNECTIONS", Category: "logging", Severity: "LOW",
+				Description: "Connections are not logged",
+				Evidence:    "log_connections = off",
+				Remediation: "Set log_connections = on",
+			})
+		}
+	}
+
+	// pg_hba.conf rules, readable by superusers and pg_read_all_settings
+	for _, row := range r.query(h, "pg_hba.conf rules", "SELECT line_number, type, database, user_name, address, auth_method "+
+		"FROM pg_hba_file_rules WHERE error IS NULL ORDER BY line_number") {
+		kind, method, address := text(row["type"]), text(row["auth_method"]), ""
+		if row["address"] != nil {
+			address = text(row["address"]) + " "
+		}
+		rule := fmt.Sprintf("line %s: %s %s %s %s%s", text(row["line_number"]), kind,
+			text(row["database"]), text(row["user_name"]), address, method)
+		local := kind == "local" || isLoopback(strings.TrimSpace(address))
+		switch {
+		case method == "trust":
+			severity := "CRITICAL"
+			if local {
+				severity = "MEDIUM"
+			}
+			r.add(Finding{
+				ID: "PG-HBA-TRUST", Category: "authentication", Severity: severity,
+				Description: "pg_hba.conf lets clients connect without a password",
+				Evidence:    rule,
+				Remediation: "Use scram-sha-256, peer or cert instead of trust",
+			})
+		case method == "password" && kind != "hostssl" && !local:
+			r.add(Finding{
+				ID: "PG-HBA-PASSWORD", Category: "authentication", Severity: "HIGH",
+				Description: "pg_hba.conf accepts cleartext passwords over unencrypted connections",
+				Evidence:    rule,
+				Remediation: "Use scram-sha-256, and hostssl for remote clients",
+			})
+		case kind == "host" && method != "reject" && !local:
+			r.add(Finding{
+				ID: "PG-HBA-PLAINTEXT", Category: "encryption", Severity: "MEDIUM",
+				Description: "pg_hba.conf lets remote clients connect without TLS",
+				Evidence:    rule,
+				Remediation: "Use hostssl instead of host for remote clients",
+			})
+		}
+	}
+
+	var admins []string
+	for _, row := range r.query(h, "roles", "SELECT rolname, rolsuper, rolcreaterole, rolcreatedb, rolreplication, rolbypassrls, rolcanlogin "+
+		"FROM pg_roles WHERE rolname !~ '^pg_' ORDER BY rolname") {
+		u := DBUser{Name: text(row["rolname"]), Superuser: truthy(row["rolsuper"]), Disabled: !truthy(row["rolcanlogin"])}
+		for _, attr := range []string{"super", "createrole", "createdb", "replication", "bypassrls", "canlogin"} {
+			if truthy(row["rol"+attr]) {
+				u.Privileges = append(u.Privileges, strings.ToUpper(strings.TrimPrefix(attr, "can")))
+			}
+		}
+		powerful := truthy(row["rolcreaterole"]) || truthy(row["rolbypassrls"]) || truthy(row["rolreplication"])
+		u.Risk = userRisk(u, false, powerful)
+		r.Users = append(r.Users, u)
+		if u.Superuser && !u.Disabled {
+			admins = append(admins, u.Name)
+			if u.Name != "postgres" {
+				r.add(Finding{
+					ID: "PG-SUPERUSER", Category: "privileges", Severity: "MEDIUM",
+					Description: "Login role with superuser, which bypasses every permission check",
+					Evidence:    u.Name + " is a superuser",
+					Remediation: "ALTER ROLE " + u.Name + " NOSUPERUSER, and grant what it needs",
+				})
+			}
+		}
+	}
+	if len(admins) > 3 {
+		r.add(tooManyAdmins("PG-ADMIN-COUNT", admins))
+	}
+}
+
+// mssqlFeatures are the server options that run code outside SQL, and how
+// bad it is to have them on
+var mssqlFeatures = []struct{ name, id, severity, description string }{
+	{"xp_cmdshell", "MSSQL-XP-CMDSHELL", "CRITICAL", "xp_cmdshell runs operating system commands"},
+	{"Ole Automation Procedures", "MSSQL-OLE-AUTOMATION", "HIGH", "OLE Automation procedures create COM objects on the server"},
+	{"clr enabled", "MSSQL-CLR", "MEDIUM", "CLR integration runs .NET assemblies on the server"},
+	{"Ad Hoc Distributed Queries", "MSSQL-AD-HOC-QUERIES", "MEDIUM", "Ad hoc distributed queries reach other servers with OPENROWSET"},
+	{"cross db ownership chaining", "MSSQL-OWNERSHIP-CHAINING", "MEDIUM", "Cross database ownership chaining skips permission checks between databases"},
+}
+
+func auditMSSQL(h *Handle, r *SecurityReport) {
+	for _, row := range r.query(h, "server options", "SELECT name, CAST(value_in_use AS int) AS value FROM sys.configurations WHERE name IN "+
+		"('xp_cmdshell', 'Ole Automation Procedures', 'clr enabled', 'Ad Hoc Distributed Queries', 'cross db ownership chaining', 'remote admin connections')") {
+		r.Settings[text(row["name"])] = text(row["value"])
+	}
+	for _, row := range r.query(h, "authentication mode", "SELECT CAST(SERVERPROPERTY('IsIntegratedSecurityOnly') AS int) AS value") {
+		r.Settings["IsIntegratedSecurityOnly"] = text(row["value"])
+	}
+	for _, f := range mssqlFeatures {
+		if r.Settings[f.name] == "1" {
+			r.add(Finding{
+				ID: f.id, Category: "configuration", Severity: f.severity,
+				Description: f.description,
+				Evidence:    f.name + " = 1",
+				Remediation: fmt.Sprintf("EXEC sp_configure '%s', 0; RECONFIGURE", f.name),
+			})
+		}
+	}
+	if r.Settings["IsIntegratedSecurityOnly"] == "0" {
+		r.add(Finding{
+			ID: "MSSQL-MIXED-MODE", Category: "authentication", Severity: "LOW",
+			Description: "SQL Server logins are accepted as well as Windows authentication",
+			Evidence:    "IsIntegratedSecurityOnly = 0",
+			Remediation: "Use Windows authentication only, unless applications need SQL logins",
+		})
+	}
+
+	for _, row := range r.query(h, "session encryption", "SELECT encrypt_option FROM sys.dm_exec_connections WHERE session_id = @@SPID") {
+		r.Encryption.InTransit = truthy(row["encrypt_option"])
+		if !r.Encryption.InTransit {
+			r.add(Finding{
+				ID: "MSSQL-PLAINTEXT", Category: "encryption", Severity: "HIGH",
+				Description: "The server accepted this connection without encryption",
+				Evidence:    "encrypt_option = FALSE",
+				Remediation: "Turn on Force Encryption in SQL Server Configuration Manager",
+			})
+		}
+	}
+	for _, row := range r.query(h, "database encryption", "SELECT is_encrypted FROM sys.databases WHERE name = DB_NAME()") {
+		encrypted := truthy(row["is_encrypted"])
+		r.Encryption.AtRest = &encrypted
+		if encrypted {
+			r.Encryption.AtRestBy = "TDE"
+		} else {
+			r.add(Finding{
+				ID: "MSSQL-TDE", Category: "encryption", Severity: "MEDIUM",
+				Description: "The database is not encrypted at rest",
+				Evidence:    "is_encrypted = 0",
+				Remediation: "Turn on Transparent Data Encryption",
+			})
+		}
+	}
+
+	roles := map[string][]string{}
+	for _, row := range r.query(h, "server role members", "SELECT r.name AS role, m.name AS member FROM sys.server_role_members rm "+
+		"JOIN sys.server_principals r ON rm.role_principal_id = r.principal_id "+
+		"JOIN sys.server_principals m ON rm.member_principal_id = m.principal_id") {
+		roles[text(row["member"])] = append(roles[text(row["member"])], strings.ToUpper(text(row["role"])))
+	}
+	empty := map[string]bool{}
+	for _, row := range r.query(h, "empty passwords", "SELECT name FROM sys.sql_logins WHERE PWDCOMPARE('', password_hash) = 1") {
+		empty[text(row["name"])] = true
+	}
+	var admins []string
+	for _, row := range r.query(h, "logins", "SELECT p.name, p.type, p.is_disabled, l.is_policy_checked FROM sys.server_principals p "+
+		"LEFT JOIN sys.sql_logins l ON l.principal_id = p.principal_id "+
+		"WHERE p.type IN ('S', 'U', 'G') AND p.name NOT LIKE '##%' ORDER BY p.name") {
+		u := DBUser{Name: text(row["name"]), Privileges: roles[text(row["name"])], Disabled: truthy(row["is_disabled"]), NoPassword: empty[text(row["name"])]}
+		for _, role := range u.Privileges {
+			u.Superuser = u.Superuser || role == "SYSADMIN"
+		}
+		u.Risk = userRisk(u, false, len(u.Privileges) > 0)
+		r.Users = append(r.Users, u)
+		if u.Disabled {
+			continue
+		}
+		if u.NoPassword {
+			r.add(Finding{
+				ID: "MSSQL-EMPTY-PASSWORD", Category: "authentication", Severity: "CRITICAL",
+				Description: "Login with an empty password",
+				Evidence:    u.Name + " has an empty password",
+				Remediation: "Set a strong password, or disable the login",
+			})
+		}
+		if u.Name == "sa" {
+			r.add(Finding{
+				ID: "MSSQL-SA-ENABLED", Category: "authentication", Severity: "HIGH",
+				Description: "The sa login is enabled, and is the first one attackers try",
+				Evidence:    "sa is enabled",
+				Remediation: "ALTER LOGIN sa DISABLE, or rename it",
+			})
+		}
+		if text(row["type"]) == "S" && row["is_policy_checked"] != nil && !truthy(row["is_policy_checked"]) {
+			r.add(Finding{
+				ID: "MSSQL-NO-PASSWORD-POLICY", Category: "authentication", Severity: "MEDIUM",
+				Description: "SQL login exempt from the Windows password policy",
+				Evidence:    u.Name + " has CHECK_POLICY = OFF",
+				Remediation: "ALTER LOGIN [" + u.Name + "] WITH CHECK_POLICY = ON",
+			})
+		}
+		if u.Superuser {
+			admins = append(admins, u.Name)
+		}
+	}
+	if len(admins) > 3 {
+		r.add(tooManyAdmins("MSSQL-ADMIN-COUNT", admins))
+	}
+}
+
+func auditSQLite(h *Handle, r *SecurityReport) {
+	for _, pragma := range []string{"secure_delete", "trusted_schema", "journal_mode"} {
+		for _, row := range r.query(h, pragma, "PRAGMA "+pragma) {
+			r.Settings[pragma] = text(row[pragma])
+		}
+	}
+	if r.Settings["secure_delete"] == "0" {
+		r.add(Finding{
+			ID: "SQLITE-SECURE-DELETE", Category: "configuration", Severity: "LOW",
+			Description: "Deleted content stays in the database file until it is overwritten",
+			Evidence:    "secure_delete = 0",
+			Remediation: "PRAGMA secure_delete = ON",
+		})
+	}
+	if r.Settings["trusted_schema"] == "1" {
+		r.add(Finding{
+			ID: "SQLITE-TRUSTED-SCHEMA", Category: "configuration", Severity: "LOW",
+			Description: "Functions in triggers and views of an untrusted schema run",
+			Evidence:    "trusted_schema = 1",
+			Remediation: "PRAGMA trusted_schema = OFF",
+		})
+	}
+
+	// SQLite has no accounts: the file's permissions are its access control
+	var file string
+	for _, row := range r.query(h, "database file", "PRAGMA database_list") {
+		if text(row["name"]) == "main" {
+			file = text(row["file"])
+		}
+	}
+	if file == "" || file == "NULL" {
+		return
+	}
+	r.Settings["file"] = file
+	info, err := os.Stat(file)
+	if err != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf("database file: %v", err))
+		return
+	}
+	mode := info.Mode().Perm()
+	r.Settings["file_mode"] = fmt.Sprintf("%04o", mode)
+	if mode&0o022 != 0 {
+		r.add(Finding{
+			ID: "SQLITE-FILE-WRITABLE", Category: "privileges", Severity: "HIGH",
+			Description: "Other users can write the database file",
+			Evidence:    fmt.Sprintf("%s is mode %04o", file, mode),
+			Remediation: "chmod 600 " + file,
+		})
+	} else if mode&0o004 != 0 {
+		r.add(Finding{
+			ID: "SQLITE-FILE-READABLE", Category: "privileges", Severity: "HIGH",
+			Description: "Every user on the host can read the database file",
+			Evidence:    fmt.Sprintf("%s is mode %04o", file, mode),
+			Remediation: "chmod 600 " + file,
+		})
+	}
+	// An encrypted database (SQLCipher, SEE) does not start with the
+	// plaintext header
+	f, err := os.Open(file)
+	if err != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf("database file: %v", err))
+		return
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	if n, _ := f.Read(header); n == 16 {
+		encrypted := string(header) != "SQLite format 3\x00"
+		r.Encryption.AtRest = &encrypted
+		if !encrypted {
+			r.add(Finding{
+				ID: "SQLITE-AT-REST", Category: "encryption", Severity: "MEDIUM",
+				Description: "The database file is not encrypted",
+				Evidence:    file + " has a plaintext SQLite header",
+				Remediation: "Use SQLCipher, or keep the file on an encrypted volume",
+			})
+		}
+	}
+}
+
+// lookup follows keys through nested maps
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// mongoAdminRoles are the built-in roles that administer a whole server
+var mongoAdminRoles = map[string]bool{
+	"root": true, "__system": true, "userAdminAnyDatabase": true,
+	"dbAdminAnyDatabase": true, "readWriteAnyDatabase": true, "clusterAdmin": true,
+}
+
+func auditMongo(h *Handle, r *SecurityReport) {
+	r.Encryption.InTransit = h.tls
+	if rows := r.query(h, "startup options", `{"getCmdLineOpts": 1, "$db": "admin"}`); len(rows) == 1 {
+		parsed := rows[0]["parsed"]
+		auth := text(lookup(parsed, "security", "authorization"))
+		bind := text(lookup(parsed, "net", "bindIp"))
+		if lookup(parsed, "net", "bindIpAll") == true {
+			bind = "0.0.0.0"
+		}
+		tlsMode := lookup(parsed, "net", "tls", "mode")
+		if tlsMode == nil {
+			tlsMode = lookup(parsed, "net", "ssl", "mode")
+		}
+		r.Settings["security.authorization"] = auth
+		r.Settings["net.bindIp"] = bind
+		r.Settings["net.tls.mode"] = text(tlsMode)
+		if auth != "enabled" {
+			r.add(Finding{
+				ID: "MONGO-NO-AUTH", Category: "authentication", Severity: "CRITICAL",
+				Description: "Access control is off, so anyone who can connect can read and change every database",
+				Evidence:    "security.authorization = " + auth,
+				Remediation: "Create an administrator and set security.authorization: enabled",
+			})
+		}
+		if strings.Contains(bind, "0.0.0.0") || strings.Contains(bind, "::,") || bind == "::" {
+			r.add(Finding{
+				ID: "MONGO-BIND-ALL", Category: "configuration", Severity: "MEDIUM",
+				Description: "The server listens on every interface",
+				Evidence:    "net.bindIp = " + bind,
+				Remediation: "Bind net.bindIp to the addresses clients use",
+			})
+		}
+		switch mode := text(tlsMode); mode {
+		case "requireTLS", "requireSSL":
+		case "allowTLS", "preferTLS", "allowSSL", "preferSSL":
+			r.add(Finding{
+				ID: "MONGO-TLS-OPTIONAL", Category: "encryption", Severity: "MEDIUM",
+				Description: "Clients may connect without TLS",
+				Evidence:    "net.tls.mode = " + mode,
+				Remediation: "Set net.tls.mode: requireTLS",
+			})
+		default:
+			r.add(Finding{
+				ID: "MONGO-TLS-DISABLED", Category: "encryption", Severity: "HIGH",
+				Description: "The server does not offer TLS, so credentials and data cross the network in plaintext",
+				Evidence:    "net.tls.mode = " + mode,
+				Remediation: "Configure net.tls with a certificate and set mode: requireTLS",
+			})
+		}
+		encrypted := lookup(parsed, "security", "enableEncryption") == true
+		r.Encryption.AtRest = &encrypted
+		if encrypted {
+			r.Encryption.AtRestBy = "WiredTiger encrypted storage engine"
+		} else {
+			r.add(Finding{
+				ID: "MONGO-AT-REST", Category: "encryption", Severity: "MEDIUM",
+				Description: "The storage engine does not encrypt data at rest",
+				Evidence:    "security.enableEncryption is not set",
+				Remediation: "Use the encrypted storage engine, or keep data on an encrypted volume",
+			})
+		}
+	}
+
+	var admins []string
+	if rows := r.query(h, "users", `{"usersInfo": {"forAllDBs": true}, "$db": "admin"}`); len(rows) == 1 {
+		users, _ := rows[0]["users"].([]interface{})
+		for _, item := range users {
+			u := DBUser{Name: text(lookup(item, "user")), Host: text(lookup(item, "db"))}
+			roles, _ := lookup(item, "roles").([]interface{})
+			for _, role := range roles {
+				name := text(lookup(role, "role"))
+				u.Privileges = append(u.Privileges, name+"@"+text(lookup(role, "db")))
+				u.Superuser = u.Superuser || mongoAdminRoles[name]
+			}
+			sort.Strings(u.Privileges)
+			u.Risk = userRisk(u, false, false)
+			r.Users = append(r.Users, u)
+			if u.Superuser {
+				admins = append(admins, u.Name+"@"+u.Host)
+			}
+		}
+	}
+	if len(admins) > 3 {
+		r.add(tooManyAdmins("MONGO-ADMIN-COUNT", admins))
+	}
+}
+
+func auditRedis(h *Handle, r *SecurityReport) {
+	r.Encryption.InTransit = h.tls
+	configReadable := true
+	for _, name := range []string{"requirepass", "protected-mode", "bind", "tls-port"} {
+		rows, err := h.Query("CONFIG GET ?", name)
+		if err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("settings: %v", err))
+			configReadable = false
+			break
+		}
+		if len(rows) == 2 {
+			r.Settings[name] = text(rows[1]["value"])
+		}
+	}
+	if configReadable {
+		// Whether a password is set, never the password
+		if r.Settings["requirepass"] != "" {
+			r.Settings["requirepass"] = "set"
+		}
+		r.add(Finding{
+			ID: "REDIS-CONFIG-COMMAND", Category: "configuration", Severity: "MEDIUM",
+			Description: "This account may run CONFIG, which can write files anywhere the server can with dir and dbfilename",
+			Evidence:    "CONFIG GET succeeded",
+			Remediation: "Deny CONFIG with ACL rules, or rename-command CONFIG \"\"",
+		})
+		if r.Settings["protected-mode"] == "no" {
+			r.add(Finding{
+				ID: "REDIS-PROTECTED-MODE", Category: "configuration", Severity: "MEDIUM",
+				Description: "Protected mode is off, so the default user is reachable from other hosts",
+				Evidence:    "protected-mode = no, bind = " + r.Settings["bind"],
+				Remediation: "Set protected-mode yes, and bind to the addresses clients use",
+			})
+		}
+		if !h.tls && (r.Settings["tls-port"] == "" || r.Settings["tls-port"] == "0") {
+			r.add(Finding{
+				ID: "REDIS-TLS-DISABLED", Category: "encryption", Severity: "HIGH",
+				Description: "The server does not offer TLS, so credentials and data cross the network in plaintext",
+				Evidence:    "tls-port = " + text(r.Settings["tls-port"]),
+				Remediation: "Set tls-port, tls-cert-file and tls-key-file, and port 0",
+			})
+		}
+	}
+
+	openDefault := false
+	acl, err := h.Query("ACL LIST")
+	if err != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf("users: %v", err))
+		openDefault = configReadable && r.Settings["requirepass"] == ""
+	}
+	var admins []string
+	for _, row := range acl {
+		// user <name> on|off [nopass] [#hash...] [~keys] [&channels] [+@all]...
+		fields := strings.Fields(text(row["value"]))
+		if len(fields) < 3 || fields[0] != "user" {
+			continue
+		}
+		u := DBUser{Name: fields[1], Disabled: fields[2] == "off"}
+		allKeys, allCommands := false, false
+		for _, f := range fields[3:] {
+			switch {
+			case f == "nopass":
+				u.NoPassword = true
+			case f == "~*" || f == "allkeys":
+				allKeys = true
+				u.Privileges = append(u.Privileges, f)
+			case f == "+@all" || f == "allcommands":
+				allCommands = true
+				u.Privileges = append(u.Privileges, f)
+			case strings.HasPrefix(f, "+") || strings.HasPrefix(f, "-") || strings.HasPrefix(f, "~") || strings.HasPrefix(f, "&"):
+				u.Privileges = append(u.Privileges, f)
+			}
+		}
+		u.Superuser = allKeys && allCommands
+		u.Risk = userRisk(u, false, false)
+		r.Users = append(r.Users, u)
+		if u.Disabled {
+			continue
+		}
+		if u.NoPassword {
+			if u.Name == "default" {
+				openDefault = true
+			} else {
+				r.add(Finding{
+					ID: "REDIS-NOPASS-USER", Category: "authentication", Severity: "CRITICAL",
+					Description: "ACL user with no password",
+					Evidence:    "user " + u.Name + " is on with nopass",
+					Remediation: "ACL SETUSER " + u.Name + " resetpass >password",
+				})
+			}
+		}
+		if u.Superuser {
+			admins = append(admins, u.Name)
+		}
+	}
+	if openDefault {
+		r.add(Finding{
+			ID: "REDIS-NO-AUTH", Category: "authentication", Severity: "CRITICAL",
+			Description: "Clients are logged in as the default user without a password",
+			Evidence:    "the default user has nopass",
+			Remediation: "Set requirepass, or ACL SETUSER default resetpass >password",
+		})
+	}
+	if len(admins) > 3 {
+		r.add(tooManyAdmins("REDIS-ADMIN-COUNT", admins))
+	}
+}
+
+// FindingToMap converts a finding to a map
+func FindingToMap(f Finding) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          f.ID,
+		"category":    f.Category,
+		"severity":    f.Severity,
+		"description": f.Description,
+		"evidence":    f.Evidence,
+		"remediation": f.Remediation,
+	}
+}
+
+func findingsToList(findings []Finding) []interface{} {
+	list := make([]interface{}, len(findings))
+	for i, f := range findings {
+		list[i] = FindingToMap(f)
+	}
+	return list
+}
+
+func stringsToList(items []string) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, s := range items {
+		list[i] = s
+	}
+	return list
+}
+
+// SecurityScanToMap converts a report to the map db_security_scan returns
+func SecurityScanToMap(connID string, r *SecurityReport) map[string]interface{} {
+	settings := make(map[string]interface{}, len(r.Settings))
+	for k, v := range r.Settings {
+		settings[k] = v
+	}
+	return map[string]interface{}{
+		"connection_id": connID,
+		"scan_time":     time.Now().Format("2006-01-02 15:04:05"),
+		"type":          r.Type,
+		"version":       r.Version,
+		"settings":      settings,
+		"findings":      findingsToList(r.Findings),
+		"risk_score":    RiskScore(r.Findings),
+		"errors":        stringsToList(r.Errors),
+	}
+}
+
+// PrivilegeAuditToMap converts a report's accounts to the map
+// db_audit_privileges returns
+func PrivilegeAuditToMap(connID string, r *SecurityReport) map[string]interface{} {
+	users := make([]interface{}, len(r.Users))
+	highRisk := 0
+	for i, u := range r.Users {
+		users[i] = map[string]interface{}{
+			"username":    u.Name,
+			"host":        u.Host,
+			"privileges":  stringsToList(u.Privileges),
+			"superuser":   u.Superuser,
+			"no_password": u.NoPassword,
+			"disabled":    u.Disabled,
+			"risk_level":  u.Risk,
+		}
+		if u.Risk == "HIGH" || u.Risk == "CRITICAL" {
+			highRisk++
+		}
+	}
+	findings := r.FindingsIn("authentication", "privileges")
+	return map[string]interface{}{
+		"connection_id":   connID,
+		"audit_time":      time.Now().Format("2006-01-02 15:04:05"),
+		"users":           users,
+		"total_users":     len(users),
+		"high_risk_users": highRisk,
+		"findings":        findingsToList(findings),
+		"risk_score":      RiskScore(findings),
+		"errors":          stringsToList(r.Errors),
+	}
+}
+
+// EncryptionCheckToMap converts a report's encryption to the map
+// db_check_encryption returns. data_at_rest_encrypted is nil when the
+// database cannot tell.
+func EncryptionCheckToMap(connID string, r *SecurityReport) map[string]interface{} {
+	var atRest interface{}
+	if r.Encryption.AtRest != nil {
+		atRest = *r.Encryption.AtRest
+	}
+	findings := r.FindingsIn("encryption")
+	issues := make([]interface{}, len(findings))
+	for i, f := range findings {
+		issue := FindingToMap(f)
+		issue["type"] = "encryption"
+		issue["message"] = f.Description
+		issues[i] = issue
+	}
+	return map[string]interface{}{
+		"connection_id":          connID,
+		"check_time":             time.Now().Format("2006-01-02 15:04:05"),
+		"ssl_connection":         r.Encryption.InTransit,
+		"tls_version":            r.Encryption.TLSVersion,
+		"cipher":                 r.Encryption.Cipher,
+		"data_at_rest_encrypted": atRest,
+		"transparent_encryption": r.Encryption.AtRestBy != "",
+		"at_rest_method":         r.Encryption.AtRestBy,
+		"issues":                 issues,
+		"compliance_score":       100 - RiskScore(findings),
+		"errors":                 stringsToList(r.Errors),
+	}
+}
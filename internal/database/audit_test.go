@@ -0,0 +1,97 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// findingIDs returns the ids of a report's findings
+func findingIDs(r *SecurityReport) map[string]Finding {
+	ids := map[string]Finding{}
+	for _, f := range r.Findings {
+		ids[f.ID] = f
+	}
+	return ids
+}
+
+func TestAuditSQLite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.db")
+	h, err := Open("sqlite", file, Options{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.Execute("CREATE TABLE users (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Audit(h)
+	ids := findingIDs(r)
+	for _, id := range []string{"SQLITE-FILE-READABLE", "SQLITE-AT-REST", "SQLITE-SECURE-DELETE"} {
+		if _, ok := ids[id]; !ok {
+			t.Errorf("missing %s in %v", id, r.Findings)
+		}
+	}
+	if r.Settings["file_mode"] != "0644" || r.Encryption.AtRest == nil || *r.Encryption.AtRest {
+		t.Errorf("settings %v, encryption %+v", r.Settings, r.Encryption)
+	}
+	if r.Findings[0].Severity != "HIGH" {
+		t.Errorf("findings are not sorted by severity: %v", r.Findings)
+	}
+
+	os.Chmod(file, 0o600)
+	h.Execute("PRAGMA secure_delete = ON")
+	ids = findingIDs(Audit(h))
+	if _, ok := ids["SQLITE-FILE-READABLE"]; ok {
+		t.Error("a mode 0600 file was reported readable")
+	}
+	if _, ok := ids["SQLITE-SECURE-DELETE"]; ok {
+		t.Error("secure_delete was reported off")
+	}
+}
+
+func TestAuditRedis(t *testing.T) {
+	h, err := Open("redis", fakeRedis(t, ""), Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	r := Audit(h)
+	ids := findingIDs(r)
+	for _, id := range []string{"REDIS-NO-AUTH", "REDIS-NOPASS-USER", "REDIS-PROTECTED-MODE", "REDIS-TLS-DISABLED", "REDIS-CONFIG-COMMAND"} {
+		if _, ok := ids[id]; !ok {
+			t.Errorf("missing %s in %v", id, r.Findings)
+		}
+	}
+	if len(r.Users) != 2 || !r.Users[0].Superuser || r.Users[0].Risk != "CRITICAL" || r.Users[1].Superuser {
+		t.Errorf("users: %+v", r.Users)
+	}
+	if RiskScore(r.Findings) != 100 {
+		t.Errorf("risk score %d", RiskScore(r.Findings))
+	}
+
+	h, err = Open("redis", "redis://:s3cret@"+fakeRedis(t, "s3cret"), Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	r = Audit(h)
+	if _, ok := findingIDs(r)["REDIS-NO-AUTH"]; ok {
+		t.Error("a server with a password was reported open")
+	}
+	if r.Settings["requirepass"] != "set" {
+		t.Errorf("requirepass is %q, not hidden", r.Settings["requirepass"])
+	}
+	m := EncryptionCheckToMap("cache", r)
+	if m["ssl_connection"] != false || m["data_at_rest_encrypted"] != nil || len(m["issues"].([]interface{})) != 1 {
+		t.Errorf("encryption: %v", m)
+	}
+	if p := PrivilegeAuditToMap("cache", r); p["total_users"] != 2 || p["high_risk_users"] != 1 {
+		t.Errorf("privileges: %v", p)
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// sqlRunner is what SQL statements run on: the pool, or a transaction
+type sqlRunner interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// runner returns the open transaction, or the pool when there is none
+func (h *Handle) runner() sqlRunner {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tx != nil {
+		return h.tx
+	}
+	return h.SQL
+}
+
+// Begin starts a transaction that the handle's SQL statements run in
+// until Commit or Rollback. MongoDB and Redis have none.
+func (h *Handle) Begin() error {
+	if h.SQL == nil {
+		return fmt.Errorf("%s connections have no SQL transactions", h.Type)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tx != nil {
+		return fmt.Errorf("a transaction is already in progress")
+	}
+	// Not the query context: the transaction is rolled back when it ends
+	tx, err := h.SQL.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	h.tx = tx
+	return nil
+}
+
+// InTransaction reports whether a transaction is in progress
+func (h *Handle) InTransaction() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.tx != nil
+}
+
+// Commit commits the transaction from Begin
+func (h *Handle) Commit() error {
+	tx, err := h.endTx()
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback rolls back the transaction from Begin
+func (h *Handle) Rollback() error {
+	tx, err := h.endTx()
+	if err != nil {
+		return err
+	}
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// endTx takes the open transaction off the handle
+func (h *Handle) endTx() (*sql.Tx, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tx == nil {
+		return nil, fmt.Errorf("no transaction in progress")
+	}
+	tx := h.tx
+	h.tx = nil
+	return tx, nil
+}
+
+// Rows reads the rows of a query one at a time, for result sets too large
+// to hold at once. SQL rows and the documents of MongoDB cursor commands
+// are streamed from the server; other replies are read up front.
+type Rows struct {
+	sql     *sql.Rows
+	columns []string
+	mongo   *mongo.Cursor
+	rows    []map[string]interface{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Rows runs a query like Query, and returns its rows to read with Next.
+// The handle's timeout applies until the query starts returning rows.
+func (h *Handle) Rows(query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if h.timeout > 0 {
+		timer := time.AfterFunc(h.timeout, cancel)
+		defer timer.Stop()
+	}
+	r := &Rows{ctx: ctx, cancel: cancel}
+	var err error
+	switch h.Type {
+	case "mongodb":
+		cmd, db, cmdErr := mongoCommand(query, args)
+		if cmdErr != nil {
+			err = cmdErr
+		} else if mongoCursorCommands[cmd[0].Key] {
+			r.mongo, err = h.Mongo.Database(h.database(db)).RunCommandCursor(ctx, cmd)
+		} else {
+			r.rows, err = h.mongoQuery(ctx, query, args)
+		}
+	case "redis":
+		var reply interface{}
+		if reply, err = h.redisDo(ctx, query, args); err == nil {
+			r.rows = redisRows(reply)
+		}
+	default:
+		if query, err = h.rebind(query); err != nil {
+			break
+		}
+		if r.sql, err = h.runner().QueryContext(ctx, query, args...); err != nil {
+			err = fmt.Errorf("query failed: %w", err)
+			break
+		}
+		r.columns, err = r.sql.Columns()
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Next returns the next row, or nil after the last one. The rows are
+// closed after the last one or an error.
+func (r *Rows) Next() (map[string]interface{}, error) {
+	var row map[string]interface{}
+	var err error
+	switch {
+	case r.sql != nil:
+		if r.sql.Next() {
+			row, err = scanRow(r.sql, r.columns)
+		} else {
+			err = r.sql.Err()
+		}
+	case r.mongo != nil:
+		if r.mongo.Next(r.ctx) {
+			var doc bson.D
+			if err = r.mongo.Decode(&doc); err == nil {
+				row = mongoValue(doc).(map[string]interface{})
+			}
+		} else {
+			err = r.mongo.Err()
+		}
+	case len(r.rows) > 0:
+		row, r.rows = r.rows[0], r.rows[1:]
+	}
+	if row == nil || err != nil {
+		r.Close()
+		return nil, err
+	}
+	return row, nil
+}
+
+// Close stops reading the rows
+func (r *Rows) Close() error {
+	var err error
+	switch {
+	case r.sql != nil:
+		err = r.sql.Close()
+	case r.mongo != nil:
+		err = r.mongo.Close(context.Background())
+	}
+	r.sql, r.mongo, r.rows = nil, nil, nil
+	r.cancel()
+	return err
+}
@@ -5,11 +5,14 @@ import (
 	"database/sql"
 	"fmt"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+
 	// Import common database drivers
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -35,7 +38,8 @@ type DBConnection struct {
 	Username     string
 	Password     string
 	Connected    bool
-	Connection   *sql.DB
+	Connection   *sql.DB // nil for MongoDB and Redis
+	Handle       *Handle
 	LastAccess   time.Time
 	Version      string
 	Privileges   []string
@@ -102,6 +106,8 @@ func getDefaultCredentials() []Credential {
 		{Username: "test", Password: "test", Common: true},
 		{Username: "guest", Password: "", Common: true},
 		{Username: "guest", Password: "guest", Common: true},
+		{Username: "default", Password: "", Common: true},
+		{Username: "default", Password: "redis", Common: true},
 	}
 }
 
@@ -164,92 +170,87 @@ func (db *DatabaseModule) getServiceVersion(host string, port int, service strin
 
 // Connect establishes a database connection
 func (db *DatabaseModule) Connect(id, dbType, host string, port int, database, username, password string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.connect(id, dbType, host, port, database, username, password, Options{})
+}
 
-	var dsn string
-	var err error
+// connect builds the connection string of a database type and connects
+func (db *DatabaseModule) connect(id, dbType, host string, port int, database, username, password string, opts Options) error {
+	kind, err := normalizeType(dbType)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	// MongoDB and Redis connect without credentials when there is no
+	// password, to find servers that need none
+	user := url.UserPassword(username, password)
+	if password == "" {
+		user = nil
+	}
 
-	// Build connection string based on database type
-	switch strings.ToLower(dbType) {
+	var dsn string
+	switch kind {
 	case "mysql":
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", username, password, host, port, database)
-	case "postgres", "postgresql":
-		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			host, port, username, password, database)
-	case "sqlite3", "sqlite":
+		cfg := mysql.NewConfig()
+		cfg.User, cfg.Passwd, cfg.Net, cfg.Addr, cfg.DBName = username, password, "tcp", addr, database
+		dsn = cfg.FormatDSN()
+	case "postgres":
+		u := url.URL{Scheme: "postgres", User: url.UserPassword(username, password), Host: addr, Path: "/" + database}
+		if !opts.TLS {
+			u.RawQuery = "sslmode=disable"
+		}
+		dsn = u.String()
+	case "sqlite":
 		dsn = database // For SQLite, database is the file path
-	case "sqlserver", "mssql":
-		dsn = fmt.Sprintf("server=%s;port=%d;user id=%s;password=%s;database=%s",
-			host, port, username, password, database)
-	default:
-		return fmt.Errorf("unsupported database type: %s", dbType)
+	case "mssql":
+		u := url.URL{Scheme: "sqlserver", User: url.UserPassword(username, password), Host: addr, RawQuery: url.Values{"database": {database}}.Encode()}
+		dsn = u.String()
+	case "mongodb":
+		u := url.URL{Scheme: "mongodb", User: user, Host: addr, Path: "/" + database}
+		if user != nil {
+			u.RawQuery = "authSource=admin"
+		}
+		dsn = u.String()
+	case "redis":
+		u := url.URL{Scheme: "redis", User: user, Host: addr}
+		dsn = u.String()
 	}
 
-	// Attempt connection
-	conn, err := sql.Open(dbType, dsn)
+	h, err := Open(kind, dsn, opts)
 	if err != nil {
 		return err
 	}
 
-	// Test the connection
-	if err = conn.Ping(); err != nil {
-		conn.Close()
-		return err
-	}
-
-	// Create connection object
 	dbConn := &DBConnection{
 		ID:         id,
-		Type:       dbType,
+		Type:       kind,
 		Host:       host,
 		Port:       port,
 		Database:   database,
 		Username:   username,
 		Password:   password,
 		Connected:  true,
-		Connection: conn,
+		Connection: h.SQL,
+		Handle:     h,
 		LastAccess: time.Now(),
 	}
 
 	// Get additional information
-	dbConn.Version = db.getDBVersion(conn, dbType)
-	dbConn.Privileges = db.getUserPrivileges(conn, dbType, username)
+	dbConn.Version = h.Version()
+	dbConn.Privileges = db.getUserPrivileges(h.SQL, kind, username)
 
+	db.mu.Lock()
 	db.Connections[id] = dbConn
+	db.mu.Unlock()
 	return nil
 }
 
-// getDBVersion gets database version information
-func (db *DatabaseModule) getDBVersion(conn *sql.DB, dbType string) string {
-	var query string
-	
-	switch strings.ToLower(dbType) {
-	case "mysql":
-		query = "SELECT VERSION()"
-	case "postgres", "postgresql":
-		query = "SELECT version()"
-	case "sqlite3", "sqlite":
-		query = "SELECT sqlite_version()"
-	case "sqlserver", "mssql":
-		query = "SELECT @@VERSION"
-	default:
-		return "Unknown"
-	}
-
-	var version string
-	err := conn.QueryRow(query).Scan(&version)
-	if err != nil {
-		return "Unknown"
-	}
-	
-	return version
-}
-
 // getUserPrivileges gets user privileges
 func (db *DatabaseModule) getUserPrivileges(conn *sql.DB, dbType, username string) []string {
 	privileges := make([]string, 0)
-	
+	if conn == nil {
+		return privileges
+	}
+
 	var query string
 	switch strings.ToLower(dbType) {
 	case "mysql":
@@ -287,7 +288,7 @@ func (db *DatabaseModule) TestCredentials(host string, port int, dbType string,
 		}
 
 		connID := fmt.Sprintf("test_%s_%d_%s", host, port, cred.Username)
-		err := db.Connect(connID, dbType, host, port, database, cred.Username, cred.Password)
+		err := db.connect(connID, dbType, host, port, database, cred.Username, cred.Password, Options{Timeout: 5 * time.Second})
 		
 		result := map[string]interface{}{
 			"username": cred.Username,
@@ -322,11 +323,28 @@ func (db *DatabaseModule) isCredentialRelevant(dbType, username string) bool {
 	case "sqlserver", "mssql":
 		return username == "sa" || username == "admin" || username == "user" ||
 		       username == "test" || username == "guest"
+	case "mongodb", "mongo":
+		return username == "root" || username == "admin" || username == "user" ||
+		       username == "test"
+	case "redis":
+		return username == "default"
 	default:
 		return true // Test all for unknown types
 	}
 }
 
+// AuditConnection runs the security collectors for a connection's engine
+func (db *DatabaseModule) AuditConnection(connectionID string) (*SecurityReport, error) {
+	db.mu.RLock()
+	conn, exists := db.Connections[connectionID]
+	db.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("connection not found: %s", connectionID)
+	}
+	return Audit(conn.Handle), nil
+}
+
 // ScanForVulnerabilities performs comprehensive database security scanning
 func (db *DatabaseModule) ScanForVulnerabilities(connectionID string) ([]DBScanResult, error) {
 	db.mu.RLock()
@@ -337,19 +355,23 @@ func (db *DatabaseModule) ScanForVulnerabilities(connectionID string) ([]DBScanR
 		return nil, fmt.Errorf("connection not found: %s", connectionID)
 	}
 
+	// Settings, accounts and encryption, from the collectors for the engine
 	var results []DBScanResult
-
-	// Check configuration vulnerabilities
-	configResults := db.checkConfigurationSecurity(conn)
-	results = append(results, configResults...)
-
-	// Check access control
-	accessResults := db.checkAccessControl(conn)
-	results = append(results, accessResults...)
-
-	// Check for dangerous functions/procedures
-	funcResults := db.checkDangerousFunctions(conn)
-	results = append(results, funcResults...)
+	for _, f := range Audit(conn.Handle).Findings {
+		kind := "CONFIG"
+		if f.Category == "authentication" || f.Category == "privileges" {
+			kind = "ACCESS"
+		}
+		results = append(results, DBScanResult{
+			ConnectionID: conn.ID,
+			Type:         kind,
+			Severity:     f.Severity,
+			Description:  f.Description,
+			Evidence:     f.Evidence,
+			Timestamp:    time.Now(),
+			Remediation:  f.Remediation,
+		})
+	}
 
 	// Check database structure for security issues
 	structResults := db.checkDatabaseStructure(conn)
@@ -363,240 +385,6 @@ func (db *DatabaseModule) ScanForVulnerabilities(connectionID string) ([]DBScanR
 	return results, nil
 }
 
-// checkConfigurationSecurity checks database configuration for security issues
-func (db *DatabaseModule) checkConfigurationSecurity(conn *DBConnection) []DBScanResult {
-	var results []DBScanResult
-
-	switch strings.ToLower(conn.Type) {
-	case "mysql":
-		results = append(results, db.checkMySQLConfig(conn)...)
-	case "postgres", "postgresql":
-		results = append(results, db.checkPostgreSQLConfig(conn)...)
-	}
-
-	return results
-}
-
-// checkMySQLConfig checks MySQL-specific configuration
-func (db *DatabaseModule) checkMySQLConfig(conn *DBConnection) []DBScanResult {
-	var results []DBScanResult
-
-	// Check if log_bin is enabled
-	if value := db.getConfigValue(conn.Connection, "SHOW VARIABLES LIKE 'log_bin'"); value == "OFF" {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "CONFIG",
-			Severity:     "MEDIUM",
-			Description:  "Binary logging is disabled",
-			Evidence:     "log_bin = OFF",
-			Timestamp:    time.Now(),
-			Remediation:  "Enable binary logging for audit trail",
-		})
-	}
-
-	// Check for SSL
-	if value := db.getConfigValue(conn.Connection, "SHOW VARIABLES LIKE 'have_ssl'"); value != "YES" {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "CONFIG",
-			Severity:     "HIGH",
-			Description:  "SSL is not available or not enabled",
-			Evidence:     fmt.Sprintf("have_ssl = %s", value),
-			Timestamp:    time.Now(),
-			Remediation:  "Enable SSL for encrypted connections",
-		})
-	}
-
-	// Check for local_infile
-	if value := db.getConfigValue(conn.Connection, "SHOW VARIABLES LIKE 'local_infile'"); value == "ON" {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "CONFIG",
-			Severity:     "MEDIUM",
-			Description:  "local_infile is enabled",
-			Evidence:     "local_infile = ON",
-			Timestamp:    time.Now(),
-			Remediation:  "Disable local_infile to prevent local file access",
-		})
-	}
-
-	return results
-}
-
-// checkPostgreSQLConfig checks PostgreSQL-specific configuration
-func (db *DatabaseModule) checkPostgreSQLConfig(conn *DBConnection) []DBScanResult {
-	var results []DBScanResult
-
-	// Check for SSL
-	if value := db.getConfigValue(conn.Connection, "SHOW ssl"); value != "on" {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "CONFIG",
-			Severity:     "HIGH",
-			Description:  "SSL is not enabled",
-			Evidence:     fmt.Sprintf("ssl = %s", value),
-			Timestamp:    time.Now(),
-			Remediation:  "Enable SSL in postgresql.conf",
-		})
-	}
-
-	// Check log_statement
-	if value := db.getConfigValue(conn.Connection, "SHOW log_statement"); value == "none" {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "CONFIG",
-			Severity:     "MEDIUM",
-			Description:  "Statement logging is disabled",
-			Evidence:     "log_statement = none",
-			Timestamp:    time.Now(),
-			Remediation:  "Enable statement logging for audit trail",
-		})
-	}
-
-	return results
-}
-
-// getConfigValue gets a configuration value from the database
-func (db *DatabaseModule) getConfigValue(conn *sql.DB, query string) string {
-	var name, value string
-	err := conn.QueryRow(query).Scan(&name, &value)
-	if err != nil {
-		return "Unknown"
-	}
-	return value
-}
-
-// checkAccessControl checks database access control
-func (db *DatabaseModule) checkAccessControl(conn *DBConnection) []DBScanResult {
-	var results []DBScanResult
-
-	// Check for users with empty passwords
-	emptyPassUsers := db.getUsersWithEmptyPasswords(conn)
-	for _, username := range emptyPassUsers {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "ACCESS",
-			Severity:     "CRITICAL",
-			Description:  "User account with empty password",
-			Evidence:     fmt.Sprintf("User '%s' has no password", username),
-			Timestamp:    time.Now(),
-			Remediation:  "Set strong passwords for all user accounts",
-		})
-	}
-
-	// Check for excessive privileges
-	adminUsers := db.getUsersWithAdminPrivileges(conn)
-	if len(adminUsers) > 3 {
-		results = append(results, DBScanResult{
-			ConnectionID: conn.ID,
-			Type:         "ACCESS",
-			Severity:     "MEDIUM",
-			Description:  "Too many users with administrative privileges",
-			Evidence:     fmt.Sprintf("%d users have admin privileges", len(adminUsers)),
-			Timestamp:    time.Now(),
-			Remediation:  "Review and reduce administrative privileges",
-		})
-	}
-
-	return results
-}
-
-// getUsersWithEmptyPasswords finds users with empty passwords
-func (db *DatabaseModule) getUsersWithEmptyPasswords(conn *DBConnection) []string {
-	var users []string
-	var query string
-
-	switch strings.ToLower(conn.Type) {
-	case "mysql":
-		query = "SELECT User FROM mysql.user WHERE authentication_string = '' OR Password = ''"
-	case "postgres", "postgresql":
-		// PostgreSQL doesn't store passwords directly, check role settings
-		return users // Skip for PostgreSQL
-	default:
-		return users
-	}
-
-	rows, err := conn.Connection.Query(query)
-	if err != nil {
-		return users
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var username string
-		if rows.Scan(&username) == nil {
-			users = append(users, username)
-		}
-	}
-
-	return users
-}
-
-// getUsersWithAdminPrivileges finds users with administrative privileges
-func (db *DatabaseModule) getUsersWithAdminPrivileges(conn *DBConnection) []string {
-	var users []string
-	var query string
-
-	switch strings.ToLower(conn.Type) {
-	case "mysql":
-		query = "SELECT User FROM mysql.user WHERE Super_priv = 'Y'"
-	case "postgres", "postgresql":
-		query = "SELECT rolname FROM pg_roles WHERE rolsuper = true"
-	default:
-		return users
-	}
-
-	rows, err := conn.Connection.Query(query)
-	if err != nil {
-		return users
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var username string
-		if rows.Scan(&username) == nil {
-			users = append(users, username)
-		}
-	}
-
-	return users
-}
-
-// checkDangerousFunctions checks for dangerous functions and procedures
-func (db *DatabaseModule) checkDangerousFunctions(conn *DBConnection) []DBScanResult {
-	var results []DBScanResult
-
-	dangerousFunctions := map[string]string{
-		"xp_cmdshell":       "Command execution function",
-		"LOAD_FILE":         "File reading function",
-		"INTO OUTFILE":      "File writing capability",
-		"xp_regread":        "Registry reading function",
-		"sys_exec":          "System command execution",
-	}
-
-	for funcName, description := range dangerousFunctions {
-		if db.checkFunctionExists(conn, funcName) {
-			results = append(results, DBScanResult{
-				ConnectionID: conn.ID,
-				Type:         "VULN",
-				Severity:     "HIGH",
-				Description:  fmt.Sprintf("Dangerous function available: %s", funcName),
-				Evidence:     description,
-				Timestamp:    time.Now(),
-				Remediation:  fmt.Sprintf("Disable or restrict access to %s", funcName),
-			})
-		}
-	}
-
-	return results
-}
-
-// checkFunctionExists checks if a dangerous function exists
-func (db *DatabaseModule) checkFunctionExists(conn *DBConnection, funcName string) bool {
-	// Simplified check - would need database-specific queries
-	return false // Conservative approach
-}
-
 // checkDatabaseStructure checks database structure for security issues
 func (db *DatabaseModule) checkDatabaseStructure(conn *DBConnection) []DBScanResult {
 	var results []DBScanResult
@@ -709,44 +497,11 @@ func (db *DatabaseModule) ExecuteQuery(connectionID, query string) ([]map[string
 		return nil, fmt.Errorf("connection not found: %s", connectionID)
 	}
 
-	rows, err := conn.Connection.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	// Get column names
-	columns, err := rows.Columns()
+	results, err := conn.Handle.Query(query)
 	if err != nil {
 		return nil, err
 	}
 
-	var results []map[string]interface{}
-	
-	for rows.Next() {
-		// Create slice of interface{} for Scan
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
-			continue
-		}
-
-		// Convert to map
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if b, ok := val.([]byte); ok {
-				val = string(b)
-			}
-			row[col] = val
-		}
-		results = append(results, row)
-	}
-
 	// Update last access time
 	db.mu.Lock()
 	conn.LastAccess = time.Now()
@@ -765,8 +520,8 @@ func (db *DatabaseModule) CloseConnection(connectionID string) error {
 		return fmt.Errorf("connection not found: %s", connectionID)
 	}
 
-	if conn.Connection != nil {
-		err := conn.Connection.Close()
+	if conn.Handle != nil {
+		err := conn.Handle.Close()
 		if err != nil {
 			return err
 		}
@@ -14,76 +14,71 @@ import (
 // DBManager manages database connections for Sentra
 type DBManager struct {
 	connections map[string]*DBConn
+	cursors     map[string]*DBCursor
+	nextCursor  int
 	mu          sync.RWMutex
 }
 
 // DBConn represents an active database connection
 type DBConn struct {
-	ID         string
-	Type       string // sqlite, postgres, mysql
-	DB         *sql.DB
-	DSN        string
-	Created    time.Time
-	LastUsed   time.Time
+	ID       string
+	Type     string  // sqlite, postgres, mysql, mssql, mongodb, redis
+	DB       *sql.DB // nil for MongoDB and Redis
+	Handle   *Handle
+	DSN      string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// DBCursor is a query whose rows are read one at a time
+type DBCursor struct {
+	ID     string
+	ConnID string
+	Rows   *Rows
 }
 
 // NewDBManager creates a new database manager
 func NewDBManager() *DBManager {
 	return &DBManager{
 		connections: make(map[string]*DBConn),
+		cursors:     make(map[string]*DBCursor),
 	}
 }
 
-// Connect creates a new database connection
+// Connect creates a new database connection with the default options
 func (m *DBManager) Connect(id, dbType, dsn string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.ConnectWith(id, dbType, dsn, Options{})
+}
 
-	// Check if connection already exists
-	if _, exists := m.connections[id]; exists {
+// ConnectWith creates a new database connection
+func (m *DBManager) ConnectWith(id, dbType, dsn string, opts Options) error {
+	m.mu.RLock()
+	_, exists := m.connections[id]
+	m.mu.RUnlock()
+	if exists {
 		return fmt.Errorf("connection '%s' already exists", id)
 	}
 
-	// Map to proper driver name
-	var driverName string
-	switch dbType {
-	case "sqlite", "sqlite3":
-		driverName = "sqlite"
-	case "postgres", "postgresql":
-		driverName = "postgres"
-	case "mysql":
-		driverName = "mysql"
-	default:
-		return fmt.Errorf("unsupported database type: %s", dbType)
-	}
-
-	// Open database connection
-	db, err := sql.Open(driverName, dsn)
+	h, err := Open(dbType, dsn, opts)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return err
 	}
 
-	// Test connection
-	if err := db.Ping(); err != nil {
-		db.Close()
-		return fmt.Errorf("failed to ping database: %w", err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.connections[id]; exists {
+		h.Close()
+		return fmt.Errorf("connection '%s' already exists", id)
 	}
-
-	// Configure connection pool
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
-	conn := &DBConn{
+	m.connections[id] = &DBConn{
 		ID:       id,
-		Type:     dbType,
-		DB:       db,
+		Type:     h.Type,
+		DB:       h.SQL,
+		Handle:   h,
 		DSN:      dsn,
 		Created:  time.Now(),
 		LastUsed: time.Now(),
 	}
-
-	m.connections[id] = conn
 	return nil
 }
 
@@ -95,86 +90,130 @@ func (m *DBManager) Execute(connID, query string, args ...interface{}) (int64, e
 	}
 
 	conn.LastUsed = time.Now()
+	return conn.Handle.Execute(query, args...)
+}
 
-	result, err := conn.DB.Exec(query, args...)
+// Query runs a query that returns rows
+func (m *DBManager) Query(connID, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	conn, err := m.getConnection(connID)
 	if err != nil {
-		return 0, fmt.Errorf("execution failed: %w", err)
+		return nil, err
 	}
 
-	affected, err := result.RowsAffected()
+	conn.LastUsed = time.Now()
+	return conn.Handle.Query(query, args...)
+}
+
+// QueryOne runs a query expecting a single row
+func (m *DBManager) QueryOne(connID, query string, args ...interface{}) (map[string]interface{}, error) {
+	results, err := m.Query(connID, query, args...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return affected, nil
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no rows returned")
+	}
+
+	return results[0], nil
 }
 
-// Query runs a query that returns rows
-func (m *DBManager) Query(connID, query string, args ...interface{}) ([]map[string]interface{}, error) {
+// Begin starts a transaction that the connection's statements run in
+// until Commit or Rollback
+func (m *DBManager) Begin(connID string) error {
 	conn, err := m.getConnection(connID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	conn.LastUsed = time.Now()
+	return conn.Handle.Begin()
+}
 
-	rows, err := conn.DB.Query(query, args...)
+// Commit commits the connection's transaction
+func (m *DBManager) Commit(connID string) error {
+	conn, err := m.getConnection(connID)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return err
 	}
-	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
+	conn.LastUsed = time.Now()
+	return conn.Handle.Commit()
+}
+
+// Rollback rolls back the connection's transaction
+func (m *DBManager) Rollback(connID string) error {
+	conn, err := m.getConnection(connID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var results []map[string]interface{}
+	conn.LastUsed = time.Now()
+	return conn.Handle.Rollback()
+}
 
-	// Create slice for scanning
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range columns {
-		valuePtrs[i] = &values[i]
+// OpenCursor runs a query and returns the id of a cursor over its rows
+func (m *DBManager) OpenCursor(connID, query string, args ...interface{}) (string, error) {
+	conn, err := m.getConnection(connID)
+	if err != nil {
+		return "", err
 	}
 
-	// Fetch rows
-	for rows.Next() {
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
-		}
+	conn.LastUsed = time.Now()
+	rows, err := conn.Handle.Rows(query, args...)
+	if err != nil {
+		return "", err
+	}
 
-		// Create map for this row
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			
-			// Handle byte arrays as strings
-			if b, ok := val.([]byte); ok {
-				row[col] = string(b)
-			} else {
-				row[col] = val
-			}
-		}
-		results = append(results, row)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextCursor++
+	id := fmt.Sprintf("%s/cursor-%d", connID, m.nextCursor)
+	m.cursors[id] = &DBCursor{ID: id, ConnID: connID, Rows: rows}
+	return id, nil
+}
+
+// Next returns the next row of a cursor, or nil after the last one. The
+// cursor is closed after the last row or an error.
+func (m *DBManager) Next(cursorID string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	cursor, exists := m.cursors[cursorID]
+	m.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("cursor '%s' not found", cursorID)
+	}
+
+	row, err := cursor.Rows.Next()
+	if row == nil {
+		m.mu.Lock()
+		delete(m.cursors, cursorID)
+		m.mu.Unlock()
 	}
+	return row, err
+}
+
+// CloseCursor closes a cursor before its last row
+func (m *DBManager) CloseCursor(cursorID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return results, rows.Err()
+	cursor, exists := m.cursors[cursorID]
+	if !exists {
+		return fmt.Errorf("cursor '%s' not found", cursorID)
+	}
+	delete(m.cursors, cursorID)
+	return cursor.Rows.Close()
 }
 
-// QueryOne runs a query expecting a single row
-func (m *DBManager) QueryOne(connID, query string, args ...interface{}) (map[string]interface{}, error) {
-	results, err := m.Query(connID, query, args...)
+// Audit runs the security collectors for the connection's engine
+func (m *DBManager) Audit(connID string) (*SecurityReport, error) {
+	conn, err := m.getConnection(connID)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(results) == 0 {
-		return nil, fmt.Errorf("no rows returned")
-	}
-
-	return results[0], nil
+	conn.LastUsed = time.Now()
+	return Audit(conn.Handle), nil
 }
 
 // Transaction runs a function within a database transaction
@@ -184,6 +223,9 @@ func (m *DBManager) Transaction(connID string, fn func(*sql.Tx) error) error {
 		return err
 	}
 
+	if conn.DB == nil {
+		return fmt.Errorf("%s connections have no SQL transactions", conn.Type)
+	}
 	conn.LastUsed = time.Now()
 
 	tx, err := conn.DB.Begin()
@@ -215,7 +257,13 @@ func (m *DBManager) Close(connID string) error {
 		return fmt.Errorf("connection '%s' not found", connID)
 	}
 
-	if err := conn.DB.Close(); err != nil {
+	for id, cursor := range m.cursors {
+		if cursor.ConnID == connID {
+			cursor.Rows.Close()
+			delete(m.cursors, id)
+		}
+	}
+	if err := conn.Handle.Close(); err != nil {
 		return err
 	}
 
@@ -228,8 +276,12 @@ func (m *DBManager) CloseAll() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	for _, cursor := range m.cursors {
+		cursor.Rows.Close()
+	}
+	m.cursors = make(map[string]*DBCursor)
 	for id, conn := range m.connections {
-		if err := conn.DB.Close(); err != nil {
+		if err := conn.Handle.Close(); err != nil {
 			// Log error but continue closing others
 			fmt.Printf("Error closing connection %s: %v\n", id, err)
 		}
@@ -247,10 +299,11 @@ func (m *DBManager) ListConnections() []map[string]interface{} {
 	var list []map[string]interface{}
 	for _, conn := range m.connections {
 		list = append(list, map[string]interface{}{
-			"id":       conn.ID,
-			"type":     conn.Type,
-			"created":  conn.Created,
-			"lastUsed": conn.LastUsed,
+			"id":            conn.ID,
+			"type":          conn.Type,
+			"created":       conn.Created,
+			"lastUsed":      conn.LastUsed,
+			"inTransaction": conn.Handle.InTransaction(),
 		})
 	}
 
@@ -0,0 +1,713 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Options configures how a database is opened. TLS is implied by CA,
+// Cert and Insecure.
+type Options struct {
+	Timeout     time.Duration // Connecting and each query; connecting waits 10 seconds if 0
+	MaxOpen     int           // Pooled connections; 10 if 0
+	MaxIdle     int           // 5 if 0
+	MaxLifetime time.Duration // 5 minutes if 0
+	TLS         bool
+	CA          string
+	Cert        string
+	Key         string
+	Insecure    bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.CA != "" || o.Cert != "" || o.Insecure {
+		o.TLS = true
+	}
+	if o.MaxOpen <= 0 {
+		o.MaxOpen = 10
+	}
+	if o.MaxIdle <= 0 {
+		o.MaxIdle = 5
+	}
+	if o.MaxLifetime <= 0 {
+		o.MaxLifetime = 5 * time.Minute
+	}
+	return o
+}
+
+// Handle is an open database of any supported type. SQL databases are
+// queried with SQL; MongoDB with command documents in extended JSON, such
+// as {"find": "users", "filter": {"name": ?}}; Redis with commands such as
+// GET ?. Arguments are bound to the ? placeholders, so they are never
+// parsed as part of the query.
+type Handle struct {
+	Type    string // sqlite, postgres, mysql, mssql, mongodb or redis
+	SQL     *sql.DB
+	Mongo   *mongo.Client
+	Redis   *redis.Client
+	dbName  string // MongoDB database from the connection string
+	timeout time.Duration
+	tls     bool // MongoDB and Redis connect over TLS
+
+	mu sync.Mutex
+	tx *sql.Tx // The transaction SQL statements run in, from Begin
+}
+
+// normalizeType maps the names a database type goes by to one name
+func normalizeType(dbType string) (string, error) {
+	switch strings.ToLower(dbType) {
+	case "sqlite", "sqlite3":
+		return "sqlite", nil
+	case "postgres", "postgresql":
+		return "postgres", nil
+	case "mysql", "mariadb":
+		return "mysql", nil
+	case "mssql", "sqlserver":
+		return "mssql", nil
+	case "mongodb", "mongo":
+		return "mongodb", nil
+	case "redis":
+		return "redis", nil
+	}
+	return "", fmt.Errorf("unsupported database type: %s", dbType)
+}
+
+// Open connects to a database and checks that it answers
+func Open(dbType, dsn string, opts Options) (*Handle, error) {
+	kind, err := normalizeType(dbType)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	h := &Handle{Type: kind, timeout: opts.Timeout}
+	connectTimeout := opts.Timeout
+	if connectTimeout <= 0 {
+		connectTimeout = 10 * time.Second
+	}
+	var tlsConfig *tls.Config
+	if opts.TLS && kind != "postgres" && kind != "mssql" {
+		if tlsConfig, err = clientTLS(opts); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	switch kind {
+	case "mongodb":
+		o := options.Client().ApplyURI(dsn).SetConnectTimeout(connectTimeout).
+			SetServerSelectionTimeout(connectTimeout).SetMaxPoolSize(uint64(opts.MaxOpen))
+		if tlsConfig != nil {
+			o.SetTLSConfig(tlsConfig)
+		}
+		h.tls = o.TLSConfig != nil
+		if h.Mongo, err = mongo.Connect(o); err != nil {
+			return nil, err
+		}
+		if err := h.Mongo.Ping(ctx, nil); err != nil {
+			h.Mongo.Disconnect(context.Background())
+			return nil, err
+		}
+		h.dbName = "test"
+		if u, err := url.Parse(dsn); err == nil && strings.Trim(u.Path, "/") != "" {
+			h.dbName = strings.Trim(u.Path, "/")
+		}
+		return h, nil
+
+	case "redis":
+		var o *redis.Options
+		if strings.Contains(dsn, "://") {
+			if o, err = redis.ParseURL(dsn); err != nil {
+				return nil, err
+			}
+		} else {
+			o = &redis.Options{Addr: dsn}
+		}
+		o.Protocol = 2
+		o.DialTimeout = connectTimeout
+		o.PoolSize, o.MinIdleConns = opts.MaxOpen, 0
+		o.ConnMaxLifetime = opts.MaxLifetime
+		if tlsConfig != nil {
+			o.TLSConfig = tlsConfig
+		}
+		h.tls = o.TLSConfig != nil
+		h.Redis = redis.NewClient(o)
+		if err := h.Redis.Ping(ctx).Err(); err != nil {
+			h.Redis.Close()
+			return nil, err
+		}
+		return h, nil
+	}
+
+	var db *sql.DB
+	switch kind {
+	case "sqlite":
+		if opts.TLS {
+			return nil, errors.New("sqlite databases are files and have no TLS")
+		}
+		db, err = sql.Open("sqlite", dsn)
+	case "mysql":
+		var cfg *mysql.Config
+		if cfg, err = mysql.ParseDSN(dsn); err != nil {
+			return nil, err
+		}
+		cfg.Timeout = connectTimeout
+		if tlsConfig != nil {
+			cfg.TLS = tlsConfig
+		}
+		var connector driver.Connector
+		if connector, err = mysql.NewConnector(cfg); err == nil {
+			db = sql.OpenDB(connector)
+		}
+	case "postgres":
+		params := map[string]string{"connect_timeout": strconv.Itoa(int((connectTimeout + time.Second - 1) / time.Second))}
+		if opts.TLS {
+			params["sslmode"] = "verify-full"
+			if opts.Insecure {
+				params["sslmode"] = "require"
+			}
+			params["sslrootcert"], params["sslcert"], params["sslkey"] = opts.CA, opts.Cert, opts.Key
+		}
+		db, err = sql.Open("postgres", addParams(dsn, " ", params))
+	case "mssql":
+		params := map[string]string{"dial timeout": strconv.Itoa(int((connectTimeout + time.Second - 1) / time.Second))}
+		if opts.TLS {
+			if opts.Cert != "" {
+				return nil, errors.New("SQL Server does not take client certificates")
+			}
+			params["encrypt"] = "true"
+			params["certificate"] = opts.CA
+			if opts.Insecure {
+				params["TrustServerCertificate"] = "true"
+			}
+		}
+		// The mssql driver, unlike sqlserver, takes ? placeholders
+		db, err = sql.Open("mssql", addParams(dsn, ";", params))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	db.SetMaxOpenConns(opts.MaxOpen)
+	db.SetMaxIdleConns(opts.MaxIdle)
+	db.SetConnMaxLifetime(opts.MaxLifetime)
+	h.SQL = db
+	return h, nil
+}
+
+// clientTLS builds the TLS configuration of a connection
+func clientTLS(opts Options) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CA != "" {
+		pem, err := os.ReadFile(opts.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CA)
+		}
+	}
+	if opts.Cert != "" || opts.Key != "" {
+		if opts.Cert == "" || opts.Key == "" {
+			return nil, errors.New("a client certificate needs both cert and key")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// addParams adds the non-empty params a DSN does not set to a URL DSN, or
+// to a key=value DSN whose pairs are separated by sep
+func addParams(dsn, sep string, params map[string]string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && strings.Contains(dsn, "://") {
+		q := u.Query()
+		for k, v := range params {
+			if v != "" && q.Get(k) == "" {
+				q.Set(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	lower := strings.ToLower(dsn)
+	for k, v := range params {
+		if v == "" || strings.Contains(lower, strings.ToLower(k)+"=") {
+			continue
+		}
+		if sep == " " && strings.ContainsAny(v, " '\\") {
+			v = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+		}
+		dsn = strings.TrimRight(dsn, sep+" ") + sep + k + "=" + v
+	}
+	return dsn
+}
+
+// context returns the context of a query, with the handle's timeout
+func (h *Handle) context() (context.Context, context.CancelFunc) {
+	if h.timeout > 0 {
+		return context.WithTimeout(context.Background(), h.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Close closes the database and its pooled connections, rolling back an
+// open transaction
+func (h *Handle) Close() error {
+	h.Rollback()
+	switch {
+	case h.Mongo != nil:
+		return h.Mongo.Disconnect(context.Background())
+	case h.Redis != nil:
+		return h.Redis.Close()
+	}
+	return h.SQL.Close()
+}
+
+// Query runs a query and returns its rows. MongoDB cursor commands such as
+// find and aggregate return their documents, other commands their reply.
+// Redis replies are rows with a value column, one per element of an array.
+func (h *Handle) Query(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	ctx, cancel := h.context()
+	defer cancel()
+	switch h.Type {
+	case "mongodb":
+		return h.mongoQuery(ctx, query, args)
+	case "redis":
+		reply, err := h.redisDo(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		return redisRows(reply), nil
+	}
+
+	query, err := h.rebind(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := h.runner().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string]interface{}
+	for rows.Next() {
+		row, err := scanRow(rows, columns)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
+
+// scanRow reads the current row into a map by column
+func scanRow(rows *sql.Rows, columns []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range columns {
+		valuePtrs[i] = &values[i]
+	}
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		// Handle byte arrays as strings
+		if b, ok := values[i].([]byte); ok {
+			row[col] = string(b)
+		} else {
+			row[col] = values[i]
+		}
+	}
+	return row, nil
+}
+
+// Execute runs a statement and returns the rows affected: the n of a
+// MongoDB command's reply, and a Redis integer reply or 1 for others
+func (h *Handle) Execute(query string, args ...interface{}) (int64, error) {
+	ctx, cancel := h.context()
+	defer cancel()
+	switch h.Type {
+	case "mongodb":
+		cmd, db, err := mongoCommand(query, args)
+		if err != nil {
+			return 0, err
+		}
+		var reply bson.M
+		if err := h.Mongo.Database(h.database(db)).RunCommand(ctx, cmd).Decode(&reply); err != nil {
+			return 0, err
+		}
+		n, _ := mongoValue(reply["n"]).(int64)
+		return n, nil
+	case "redis":
+		reply, err := h.redisDo(ctx, query, args)
+		if err != nil {
+			return 0, err
+		}
+		switch r := reply.(type) {
+		case int64:
+			return r, nil
+		case nil:
+			return 0, nil
+		}
+		return 1, nil
+	}
+
+	query, err := h.rebind(query)
+	if err != nil {
+		return 0, err
+	}
+	result, err := h.runner().ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("execution failed: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Version returns the server's version, or Unknown
+func (h *Handle) Version() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	switch h.Type {
+	case "mongodb":
+		var info bson.M
+		if h.Mongo.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info) == nil {
+			if v, ok := info["version"].(string); ok {
+				return v
+			}
+		}
+		return "Unknown"
+	case "redis":
+		info, err := h.Redis.Info(ctx, "server").Result()
+		if err == nil {
+			for _, line := range strings.Split(info, "\n") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:"); ok {
+					return v
+				}
+			}
+		}
+		return "Unknown"
+	}
+	query := map[string]string{
+		"mysql":    "SELECT VERSION()",
+		"postgres": "SELECT version()",
+		"sqlite":   "SELECT sqlite_version()",
+		"mssql":    "SELECT @@VERSION",
+	}[h.Type]
+	var version string
+	if err := h.SQL.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return "Unknown"
+	}
+	return version
+}
+
+var pgPlaceholder = regexp.MustCompile(`\$\d`)
+
+// rebind turns the ? placeholders of a PostgreSQL query into $1, $2...,
+// unless it already uses those. The other drivers take ? themselves.
+func (h *Handle) rebind(query string) (string, error) {
+	if h.Type != "postgres" || pgPlaceholder.MatchString(query) {
+		return query, nil
+	}
+	return replacePlaceholders(query, false, func(i int) (string, error) {
+		return "$" + strconv.Itoa(i+1), nil
+	})
+}
+
+// replacePlaceholders replaces each ? outside strings with what bind
+// returns for it. SQL has single and double quoted strings and -- comments;
+// JSON has double quoted strings with backslash escapes.
+func replacePlaceholders(query string, isJSON bool, bind func(i int) (string, error)) (string, error) {
+	var b strings.Builder
+	var quote rune
+	n := 0
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == '\\' && isJSON && i+1 < len(runes) {
+				b.WriteRune(r)
+				i++
+				r = runes[i]
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || (r == '\'' && !isJSON):
+			quote = r
+		case r == '-' && !isJSON && i+1 < len(runes) && runes[i+1] == '-':
+			for ; i < len(runes) && runes[i] != '\n'; i++ {
+				b.WriteRune(runes[i])
+			}
+			if i < len(runes) {
+				b.WriteRune('\n')
+			}
+			continue
+		case r == '?':
+			s, err := bind(n)
+			if err != nil {
+				return "", err
+			}
+			n++
+			b.WriteString(s)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// mongoCommand parses a command document, binding args as JSON to its ?
+// placeholders. A $db key picks the database the command runs in.
+func mongoCommand(query string, args []interface{}) (bson.D, string, error) {
+	used := 0
+	text, err := replacePlaceholders(query, true, func(i int) (string, error) {
+		if i >= len(args) {
+			return "", fmt.Errorf("query has more ? placeholders than the %d arguments", len(args))
+		}
+		used++
+		b, err := json.Marshal(args[i])
+		return string(b), err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if used != len(args) {
+		return nil, "", fmt.Errorf("query has %d ? placeholders for %d arguments", used, len(args))
+	}
+	var cmd bson.D
+	if err := bson.UnmarshalExtJSON([]byte(text), false, &cmd); err != nil {
+		return nil, "", fmt.Errorf("a MongoDB query is a command document in JSON: %v", err)
+	}
+	db := ""
+	for i, e := range cmd {
+		if e.Key == "$db" {
+			db = fmt.Sprint(e.Value)
+			cmd = append(cmd[:i], cmd[i+1:]...)
+			break
+		}
+	}
+	if len(cmd) == 0 {
+		return nil, "", errors.New("empty MongoDB command")
+	}
+	return cmd, db, nil
+}
+
+// database returns the MongoDB database of a command
+func (h *Handle) database(db string) string {
+	if db == "" {
+		return h.dbName
+	}
+	return db
+}
+
+// mongoCursorCommands are the commands whose reply is a cursor
+var mongoCursorCommands = map[string]bool{"find": true, "aggregate": true, "listCollections": true, "listIndexes": true}
+
+func (h *Handle) mongoQuery(ctx context.Context, query string, args []interface{}) ([]map[string]interface{}, error) {
+	cmd, db, err := mongoCommand(query, args)
+	if err != nil {
+		return nil, err
+	}
+	database := h.Mongo.Database(h.database(db))
+	if mongoCursorCommands[cmd[0].Key] {
+		cursor, err := database.RunCommandCursor(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+		var docs []bson.D
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		rows := make([]map[string]interface{}, len(docs))
+		for i, d := range docs {
+			rows[i] = mongoValue(d).(map[string]interface{})
+		}
+		return rows, nil
+	}
+	var reply bson.D
+	if err := database.RunCommand(ctx, cmd).Decode(&reply); err != nil {
+		return nil, err
+	}
+	return []map[string]interface{}{mongoValue(reply).(map[string]interface{})}, nil
+}
+
+// mongoValue converts BSON values to plain Go values
+func mongoValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = mongoValue(e.Value)
+		}
+		return m
+	case bson.M:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = mongoValue(e)
+		}
+		return m
+	case bson.A:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = mongoValue(e)
+		}
+		return list
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	case bson.ObjectID:
+		return v.Hex()
+	case bson.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bson.Decimal128:
+		return v.String()
+	case bson.Binary:
+		return v.Data
+	case bson.Timestamp:
+		return int64(v.T)
+	case bson.Regex:
+		return v.Pattern
+	case nil, bool, int64, float64, string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// redisCommand splits a command into its words, which quotes group, and
+// binds args to the words that are a bare ?
+func redisCommand(query string, args []interface{}) ([]interface{}, error) {
+	var words []interface{}
+	var word strings.Builder
+	var quote rune
+	inWord, quoted := false, false
+	used := 0
+	end := func() error {
+		if !inWord {
+			return nil
+		}
+		if word.String() == "?" && !quoted {
+			if used >= len(args) {
+				return fmt.Errorf("command has more ? placeholders than the %d arguments", len(args))
+			}
+			words = append(words, args[used])
+			used++
+		} else {
+			words = append(words, word.String())
+		}
+		word.Reset()
+		inWord, quoted = false, false
+		return nil
+	}
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord, quoted = r, true, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if err := end(); err != nil {
+				return nil, err
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	if used != len(args) {
+		return nil, fmt.Errorf("command has %d ? placeholders for %d arguments", used, len(args))
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty Redis command")
+	}
+	return words, nil
+}
+
+func (h *Handle) redisDo(ctx context.Context, query string, args []interface{}) (interface{}, error) {
+	words, err := redisCommand(query, args)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := h.Redis.Do(ctx, words...).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return reply, err
+}
+
+// redisRows turns a Redis reply into rows with a value column
+func redisRows(reply interface{}) []map[string]interface{} {
+	switch r := reply.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		rows := make([]map[string]interface{}, len(r))
+		for i, e := range r {
+			rows[i] = map[string]interface{}{"value": redisValue(e)}
+		}
+		return rows
+	}
+	return []map[string]interface{}{{"value": redisValue(reply)}}
+}
+
+func redisValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = redisValue(e)
+		}
+		return list
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = redisValue(e)
+		}
+		return m
+	}
+	return v
+}
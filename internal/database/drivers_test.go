@@ -0,0 +1,350 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSQLiteHandle(t *testing.T) {
+	h, err := Open("sqlite3", filepath.Join(t.TempDir(), "test.db"), Options{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if h.Type != "sqlite" || h.Version() == "Unknown" {
+		t.Errorf("type %s, version %s", h.Type, h.Version())
+	}
+	if _, err := h.Execute("CREATE TABLE users (name TEXT, role TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range [][2]string{{"alice", "admin"}, {"bob", "user"}, {"x' OR '1'='1", "user"}} {
+		if n, err := h.Execute("INSERT INTO users VALUES (?, ?)", u[0], u[1]); err != nil || n != 1 {
+			t.Fatalf("insert: %d, %v", n, err)
+		}
+	}
+	rows, err := h.Query("SELECT name FROM users WHERE name = ? -- a ? in a comment", "x' OR '1'='1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "x' OR '1'='1" {
+		t.Errorf("rows: %v", rows)
+	}
+	if _, err := Open("sqlite", "test.db", Options{TLS: true}); err == nil {
+		t.Error("TLS was accepted for sqlite")
+	}
+	if _, err := Open("oracle", "", Options{}); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("oracle: %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	pg := &Handle{Type: "postgres"}
+	for query, want := range map[string]string{
+		"SELECT * FROM t WHERE a = ? AND b = '?' AND c = ?": "SELECT * FROM t WHERE a = $1 AND b = '?' AND c = $2",
+		`SELECT "odd?" FROM t -- why?` + "\nWHERE a = ?":    `SELECT "odd?" FROM t -- why?` + "\nWHERE a = $1",
+		"SELECT * FROM t WHERE a = $1 AND data ? 'key'":     "SELECT * FROM t WHERE a = $1 AND data ? 'key'",
+		"SELECT 'it''s ?' FROM t WHERE a = ?":               "SELECT 'it''s ?' FROM t WHERE a = $1",
+	} {
+		got, err := pg.rebind(query)
+		if err != nil || got != want {
+			t.Errorf("rebind(%q) = %q, %v; want %q", query, got, err, want)
+		}
+	}
+	my := &Handle{Type: "mysql"}
+	if got, _ := my.rebind("SELECT ?"); got != "SELECT ?" {
+		t.Errorf("mysql query was rebound: %q", got)
+	}
+}
+
+func TestMongoCommand(t *testing.T) {
+	cmd, db, err := mongoCommand(`{"find": "users", "filter": {"name": ?, "note": "what?"}, "$db": "shop", "limit": ?}`,
+		[]interface{}{`x", "$where": "sleep(1000)`, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db != "shop" || cmd[0].Key != "find" || len(cmd) != 3 {
+		t.Fatalf("command %v in %q", cmd, db)
+	}
+	filter := mongoValue(cmd[1].Value).(map[string]interface{})
+	if filter["name"] != `x", "$where": "sleep(1000)` || filter["note"] != "what?" || len(filter) != 2 {
+		t.Errorf("filter: %v", filter)
+	}
+	if _, _, err := mongoCommand(`{"find": "users", "filter": {"name": ?}}`, nil); err == nil {
+		t.Error("missing argument was accepted")
+	}
+	if _, _, err := mongoCommand(`{"ping": 1}`, []interface{}{"extra"}); err == nil {
+		t.Error("extra argument was accepted")
+	}
+	if _, _, err := mongoCommand(`find users`, nil); err == nil {
+		t.Error("a command that is not JSON was accepted")
+	}
+
+	id := bson.NewObjectID()
+	doc := mongoValue(bson.D{{Key: "_id", Value: id}, {Key: "n", Value: int32(3)}, {Key: "tags", Value: bson.A{"a", bson.D{{Key: "b", Value: true}}}}}).(map[string]interface{})
+	if doc["_id"] != id.Hex() || doc["n"] != int64(3) || doc["tags"].([]interface{})[1].(map[string]interface{})["b"] != true {
+		t.Errorf("document: %v", doc)
+	}
+}
+
+func TestRedisCommand(t *testing.T) {
+	words, err := redisCommand(`SET "my key" ? EX 60`, []interface{}{"value with spaces"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(words) != "[SET my key value with spaces EX 60]" || len(words) != 5 {
+		t.Errorf("words: %q", words)
+	}
+	if words, _ := redisCommand(`GET "?"`, nil); words[1] != "?" {
+		t.Errorf("a quoted ? was bound: %q", words)
+	}
+	if _, err := redisCommand(`GET ?`, nil); err == nil {
+		t.Error("missing argument was accepted")
+	}
+	if _, err := redisCommand(`GET "key`, nil); err == nil {
+		t.Error("unterminated quote was accepted")
+	}
+}
+
+// fakeRedis is a RESP2 server for tests with GET, SET, DEL, KEYS, INFO,
+// CONFIG GET and ACL LIST, that needs AUTH with password when it is set
+func fakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authed := password == ""
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line[0] != '*' {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						r.ReadString('\n')
+						arg, _ := r.ReadString('\n')
+						args[i] = strings.TrimSuffix(arg, "\r\n")
+					}
+					cmd := strings.ToUpper(args[0])
+					mu.Lock()
+					var reply string
+					switch {
+					case cmd == "AUTH":
+						if args[len(args)-1] == password {
+							authed, reply = true, "+OK\r\n"
+						} else {
+							reply = "-WRONGPASS invalid username-password pair\r\n"
+						}
+					case !authed:
+						reply = "-NOAUTH Authentication required.\r\n"
+					case cmd == "PING":
+						reply = "+PONG\r\n"
+					case cmd == "SET":
+						data[args[1]], reply = args[2], "+OK\r\n"
+					case cmd == "GET":
+						if v, ok := data[args[1]]; ok {
+							reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+						} else {
+							reply = "$-1\r\n"
+						}
+					case cmd == "DEL":
+						_, ok := data[args[1]]
+						delete(data, args[1])
+						if ok {
+							reply = ":1\r\n"
+						} else {
+							reply = ":0\r\n"
+						}
+					case cmd == "KEYS":
+						reply = fmt.Sprintf("*%d\r\n", len(data))
+						for k := range data {
+							reply += fmt.Sprintf("$%d\r\n%s\r\n", len(k), k)
+						}
+					case cmd == "CONFIG" && len(args) == 3:
+						value := map[string]string{"requirepass": password, "protected-mode": "no", "bind": "* -::*", "tls-port": "0"}[args[2]]
+						reply = fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[2]), args[2], len(value), value)
+					case cmd == "ACL":
+						users := []string{"user default on nopass ~* &* +@all", "user reader on nopass ~cache:* resetchannels -@all +get"}
+						if password != "" {
+							users[0] = "user default on #8d969eef6ecad3c29a3a629280e686cf ~* &* +@all"
+						}
+						reply = fmt.Sprintf("*%d\r\n", len(users))
+						for _, u := range users {
+							reply += fmt.Sprintf("$%d\r\n%s\r\n", len(u), u)
+						}
+					case cmd == "INFO":
+						info := "# Server\r\nredis_version:7.2.4\r\n"
+						reply = fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)
+					default:
+						reply = "-ERR unknown command '" + args[0] + "'\r\n"
+					}
+					mu.Unlock()
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisHandle(t *testing.T) {
+	addr := fakeRedis(t, "s3cret")
+	if _, err := Open("redis", addr, Options{Timeout: 2 * time.Second}); err == nil {
+		t.Fatal("connected without the password")
+	}
+	h, err := Open("redis", "redis://:s3cret@"+addr+"/0", Options{Timeout: 2 * time.Second, MaxOpen: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if v := h.Version(); v != "7.2.4" {
+		t.Errorf("version %q", v)
+	}
+	if n, err := h.Execute("SET ? ?", "session:1", "alice admin"); err != nil || n != 1 {
+		t.Fatalf("SET: %d, %v", n, err)
+	}
+	rows, err := h.Query("GET ?", "session:1")
+	if err != nil || len(rows) != 1 || rows[0]["value"] != "alice admin" {
+		t.Fatalf("GET: %v, %v", rows, err)
+	}
+	if rows, err := h.Query("GET missing"); err != nil || len(rows) != 0 {
+		t.Errorf("GET of a missing key: %v, %v", rows, err)
+	}
+	if rows, err := h.Query("KEYS *"); err != nil || len(rows) != 1 || rows[0]["value"] != "session:1" {
+		t.Errorf("KEYS: %v, %v", rows, err)
+	}
+	if n, err := h.Execute("DEL ?", "session:1"); err != nil || n != 1 {
+		t.Errorf("DEL: %d, %v", n, err)
+	}
+	if _, err := h.Query("FLUSHALL"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("server errors are not returned: %v", err)
+	}
+}
+
+func TestDBManager(t *testing.T) {
+	m := NewDBManager()
+	defer m.CloseAll()
+	if err := m.ConnectWith("cache", "redis", fakeRedis(t, ""), Options{Timeout: 2 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Connect("cache", "sqlite", ":memory:"); err == nil {
+		t.Error("a second connection with the same id was accepted")
+	}
+	if _, err := m.Execute("cache", "SET k ?", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if row, err := m.QueryOne("cache", "GET k"); err != nil || row["value"] != "v" {
+		t.Errorf("QueryOne: %v, %v", row, err)
+	}
+	if err := m.Transaction("cache", nil); err == nil {
+		t.Error("a Redis transaction was started")
+	}
+	if err := m.Close("cache"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionsAndCursors(t *testing.T) {
+	m := NewDBManager()
+	defer m.CloseAll()
+	if err := m.ConnectWith("audit", "sqlite", filepath.Join(t.TempDir(), "audit.db"), Options{Timeout: 5 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Execute("audit", "CREATE TABLE events (n INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Begin("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Begin("audit"); err == nil {
+		t.Error("a second transaction was started")
+	}
+	m.Execute("audit", "INSERT INTO events VALUES (?)", 1)
+	if err := m.Rollback("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Commit("audit"); err == nil {
+		t.Error("committed with no transaction")
+	}
+	m.Begin("audit")
+	for i := 1; i <= 3; i++ {
+		m.Execute("audit", "INSERT INTO events VALUES (?)", i)
+	}
+	if err := m.Commit("audit"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := m.OpenCursor("audit", "SELECT n FROM events WHERE n > ? ORDER BY n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []int64
+	for {
+		row, err := m.Next(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		seen = append(seen, row["n"].(int64))
+	}
+	if fmt.Sprint(seen) != "[1 2 3]" {
+		t.Errorf("rows: %v", seen)
+	}
+	if _, err := m.Next(id); err == nil {
+		t.Error("a finished cursor was still open")
+	}
+
+	id, _ = m.OpenCursor("audit", "SELECT n FROM events")
+	if err := m.CloseCursor(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.OpenCursor("audit", "SELECT nope FROM events"); err == nil {
+		t.Error("a bad query opened a cursor")
+	}
+
+	cache := fakeRedis(t, "")
+	if err := m.ConnectWith("cache", "redis", cache, Options{Timeout: 2 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	m.Execute("cache", "SET a 1")
+	m.Execute("cache", "SET b 2")
+	id, err = m.OpenCursor("cache", "KEYS *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for row, _ := m.Next(id); row != nil; row, _ = m.Next(id) {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("read %d keys", n)
+	}
+	if err := m.Begin("cache"); err == nil {
+		t.Error("a Redis transaction was started")
+	}
+}
@@ -0,0 +1,670 @@
+// internal/debugger/dap.go
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"sentra/internal/vmregister"
+)
+
+// ProgramLoader compiles a program for debugging. The VM must have a coverage
+// table from vmregister.NewDebugCoverage and the code must be compiled with it.
+type ProgramLoader func(program string) (*vmregister.RegisterVM, *vmregister.FunctionObj, error)
+
+// dapRequest is an incoming Debug Adapter Protocol request
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// resumeMode is what a paused program does next
+type resumeMode int
+
+const (
+	resumeContinue resumeMode = iota
+	resumeStepIn
+	resumeStepOver
+	resumeStepOut
+)
+
+// DAPServer implements the Debug Adapter Protocol over a stream (usually
+// stdio) so editors such as VS Code can debug .sn files on the register VM
+type DAPServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	load ProgramLoader
+
+	writeMu sync.Mutex
+	seq     int
+
+	mu          sync.Mutex
+	vm          *vmregister.RegisterVM
+	mainFn      *vmregister.FunctionObj
+	breakpoints map[string]map[int]bool // Absolute path -> lines
+	absPaths    map[string]string       // Probe file -> absolute path
+	stopOnEntry bool
+	mode        resumeMode
+	stepDepth   int
+	paused      bool
+	frames      []vmregister.DebugFrame // Snapshot taken when paused
+	handles     []variableHandle        // variablesReference - 1 -> container
+
+	pauseRequested int32
+	resume         chan resumeMode
+}
+
+// variableHandle is something a client can expand in the variables view
+type variableHandle struct {
+	vars  []vmregister.DebugVar // A scope
+	value vmregister.Value      // Or an array, map, module or instance
+}
+
+// NewDAPServer creates a server reading requests from in and writing
+// responses and events to out
+func NewDAPServer(in io.Reader, out io.Writer, load ProgramLoader) *DAPServer {
+	return &DAPServer{
+		in:          bufio.NewReader(in),
+		out:         out,
+		load:        load,
+		breakpoints: make(map[string]map[int]bool),
+		absPaths:    make(map[string]string),
+		resume:      make(chan resumeMode),
+	}
+}
+
+// Serve handles requests until the client disconnects or the input ends
+func (s *DAPServer) Serve() error {
+	for {
+		req, err := s.readRequest()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if stop := s.handle(req); stop {
+			return nil
+		}
+	}
+}
+
+func (s *DAPServer) readRequest() (*dapRequest, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", value)
+			}
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var req dapRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &req, nil
+}
+
+func (s *DAPServer) write(msg map[string]interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	msg["seq"] = s.seq
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *DAPServer) respond(req *dapRequest, body interface{}) {
+	msg := map[string]interface{}{
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     true,
+	}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.write(msg)
+}
+
+func (s *DAPServer) fail(req *dapRequest, format string, args ...interface{}) {
+	s.write(map[string]interface{}{
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     false,
+		"message":     fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *DAPServer) event(name string, body interface{}) {
+	msg := map[string]interface{}{"type": "event", "event": name}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.write(msg)
+}
+
+// handle dispatches one request. Returns true when the session is over.
+func (s *DAPServer) handle(req *dapRequest) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		})
+		s.event("initialized", nil)
+
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+			Cwd         string `json:"cwd"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.Program == "" {
+			s.fail(req, "launch: missing \"program\"")
+			return false
+		}
+		if args.Cwd != "" {
+			if err := os.Chdir(args.Cwd); err != nil {
+				s.fail(req, "launch: %v", err)
+				return false
+			}
+		}
+		vm, mainFn, err := s.load(args.Program)
+		if err != nil {
+			s.fail(req, "%v", err)
+			return false
+		}
+		vm.SetDebugHook(s)
+		s.mu.Lock()
+		s.vm, s.mainFn, s.stopOnEntry = vm, mainFn, args.StopOnEntry
+		s.mu.Unlock()
+		s.respond(req, nil)
+
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+
+	case "setExceptionBreakpoints":
+		s.respond(req, map[string]interface{}{"breakpoints": []interface{}{}})
+
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.vm != nil {
+			go s.run()
+		}
+
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": 1, "name": "main"}},
+		})
+
+	case "stackTrace":
+		s.stackTrace(req)
+
+	case "scopes":
+		s.scopes(req)
+
+	case "variables":
+		s.variables(req)
+
+	case "evaluate":
+		s.evaluate(req)
+
+	case "continue":
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		s.resumeWith(resumeContinue)
+	case "next":
+		s.respond(req, nil)
+		s.resumeWith(resumeStepOver)
+	case "stepIn":
+		s.respond(req, nil)
+		s.resumeWith(resumeStepIn)
+	case "stepOut":
+		s.respond(req, nil)
+		s.resumeWith(resumeStepOut)
+
+	case "pause":
+		atomic.StoreInt32(&s.pauseRequested, 1)
+		s.respond(req, nil)
+
+	case "disconnect", "terminate":
+		s.respond(req, nil)
+		if s.vm != nil {
+			s.vm.Interrupt()
+		}
+		s.event("terminated", nil)
+		return true
+
+	default:
+		s.fail(req, "unsupported request: %s", req.Command)
+	}
+	return false
+}
+
+func (s *DAPServer) setBreakpoints(req *dapRequest) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	path := absPath(args.Source.Path)
+	lines := make(map[int]bool)
+	result := []interface{}{}
+	for _, bp := range args.Breakpoints {
+		lines[bp.Line] = true
+		result = append(result, map[string]interface{}{"verified": true, "line": bp.Line})
+	}
+
+	s.mu.Lock()
+	s.breakpoints[path] = lines
+	s.mu.Unlock()
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+// run executes the program, forwarding its output as output events
+func (s *DAPServer) run() {
+	restore := s.captureOutput()
+	_, err := s.vm.Execute(s.mainFn, nil)
+	restore()
+
+	exitCode := 0
+	if err != nil && err != vmregister.ErrInterrupted {
+		exitCode = 1
+		s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+	}
+	s.event("exited", map[string]interface{}{"exitCode": exitCode})
+	s.event("terminated", nil)
+}
+
+// captureOutput redirects os.Stdout, which carries the protocol, into
+// output events while the program runs
+func (s *DAPServer) captureOutput() (restore func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return func() {}
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				s.event("output", map[string]interface{}{"category": "stdout", "output": line})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		os.Stdout = stdout
+		w.Close()
+		<-copied
+		r.Close()
+	}
+}
+
+// OnLine implements vmregister.DebugHook. It runs on the VM goroutine and
+// blocks while the program is paused.
+func (s *DAPServer) OnLine(vm *vmregister.RegisterVM, file string, line int) {
+	depth := vm.CallDepth()
+
+	s.mu.Lock()
+	reason := ""
+	switch {
+	case s.stopOnEntry:
+		s.stopOnEntry = false
+		reason = "entry"
+	case atomic.CompareAndSwapInt32(&s.pauseRequested, 1, 0):
+		reason = "pause"
+	case s.mode == resumeStepIn,
+		s.mode == resumeStepOver && depth <= s.stepDepth,
+		s.mode == resumeStepOut && depth < s.stepDepth:
+		reason = "step"
+	case s.breakpoints[s.absPath(file)][line]:
+		reason = "breakpoint"
+	}
+	if reason == "" {
+		s.mu.Unlock()
+		return
+	}
+	s.frames = vm.DebugFrames()
+	s.handles = nil
+	s.paused = true
+	s.mu.Unlock()
+
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+
+	mode := <-s.resume
+	s.mu.Lock()
+	s.mode = mode
+	s.stepDepth = depth
+	s.frames = nil
+	s.mu.Unlock()
+}
+
+// resumeWith wakes the paused VM goroutine; it is ignored while running
+func (s *DAPServer) resumeWith(mode resumeMode) {
+	s.mu.Lock()
+	paused := s.paused
+	s.paused = false
+	s.mu.Unlock()
+	if paused {
+		s.resume <- mode
+	}
+}
+
+func (s *DAPServer) absPath(file string) string {
+	if abs, ok := s.absPaths[file]; ok {
+		return abs
+	}
+	abs := absPath(file)
+	s.absPaths[file] = abs
+	return abs
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (s *DAPServer) stackTrace(req *dapRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	frames := []interface{}{}
+	for i, frame := range s.frames {
+		f := map[string]interface{}{
+			"id":     i + 1,
+			"name":   frame.Function,
+			"line":   frame.Line,
+			"column": 1,
+		}
+		if frame.File != "" {
+			f["source"] = map[string]interface{}{
+				"name": filepath.Base(frame.File),
+				"path": s.absPath(frame.File),
+			}
+		}
+		frames = append(frames, f)
+	}
+	s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+func (s *DAPServer) scopes(req *dapRequest) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if args.FrameID < 1 || args.FrameID > len(s.frames) {
+		s.fail(req, "unknown frame %d", args.FrameID)
+		return
+	}
+	locals := s.addHandle(variableHandle{vars: s.frames[args.FrameID-1].Locals})
+	globals := s.addHandle(variableHandle{vars: s.userGlobals()})
+	s.respond(req, map[string]interface{}{
+		"scopes": []interface{}{
+			map[string]interface{}{"name": "Locals", "variablesReference": locals, "expensive": false},
+			map[string]interface{}{"name": "Globals", "variablesReference": globals, "expensive": false},
+		},
+	})
+}
+
+// userGlobals lists globals other than builtin functions, sorted by name
+func (s *DAPServer) userGlobals() []vmregister.DebugVar {
+	var vars []vmregister.DebugVar
+	for name, value := range s.vm.GetGlobals() {
+		if vmregister.IsPointer(value) && vmregister.AsObject(value).Type == vmregister.OBJ_NATIVE_FN {
+			continue
+		}
+		vars = append(vars, vmregister.DebugVar{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+func (s *DAPServer) variables(req *dapRequest) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+		s.respond(req, map[string]interface{}{"variables": []interface{}{}})
+		return
+	}
+	handle := s.handles[args.VariablesReference-1]
+	vars := handle.vars
+	if handle.vars == nil {
+		vars = children(handle.value)
+	}
+
+	result := make([]interface{}, 0, len(vars))
+	for _, v := range vars {
+		result = append(result, s.variable(v.Name, v.Value))
+	}
+	s.respond(req, map[string]interface{}{"variables": result})
+}
+
+// variable describes a value, giving containers a handle to expand them
+func (s *DAPServer) variable(name string, value vmregister.Value) map[string]interface{} {
+	ref := 0
+	if children(value) != nil {
+		ref = s.addHandle(variableHandle{value: value})
+	}
+	return map[string]interface{}{
+		"name":               name,
+		"value":              vmregister.ToString(value),
+		"type":               vmregister.ValueType(value),
+		"variablesReference": ref,
+	}
+}
+
+func (s *DAPServer) addHandle(h variableHandle) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+// children returns the elements of an array or the fields of a map, module
+// or instance, or nil for other values
+func children(value vmregister.Value) []vmregister.DebugVar {
+	var vars []vmregister.DebugVar
+	switch {
+	case vmregister.IsArray(value):
+		for i, elem := range vmregister.AsArray(value).Elements {
+			vars = append(vars, vmregister.DebugVar{Name: fmt.Sprintf("[%d]", i), Value: elem})
+		}
+		if vars == nil {
+			vars = []vmregister.DebugVar{}
+		}
+		return vars
+	case vmregister.IsMap(value):
+		return sortedFields(vmregister.AsMap(value).Items)
+	case vmregister.IsModule(value):
+		return sortedFields(vmregister.AsModule(value).Exports)
+	case vmregister.IsInstance(value):
+		return sortedFields(vmregister.AsInstance(value).Fields)
+	}
+	return nil
+}
+
+func sortedFields(fields map[string]vmregister.Value) []vmregister.DebugVar {
+	vars := make([]vmregister.DebugVar, 0, len(fields))
+	for name, value := range fields {
+		vars = append(vars, vmregister.DebugVar{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// evaluate looks up a variable path such as config.hosts[0] in the selected
+// frame's locals, then the globals. Arbitrary expressions are not supported.
+func (s *DAPServer) evaluate(req *dapRequest) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vm == nil {
+		s.fail(req, "no program is running")
+		return
+	}
+
+	path, err := parsePath(args.Expression)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+
+	value, found := vmregister.NilValue(), false
+	if args.FrameID >= 1 && args.FrameID <= len(s.frames) {
+		for _, local := range s.frames[args.FrameID-1].Locals {
+			if local.Name == path[0] {
+				value, found = local.Value, true
+				break
+			}
+		}
+	}
+	if !found {
+		value, found = s.vm.GetGlobals()[path[0]]
+	}
+	if !found {
+		s.fail(req, "%s is not defined", path[0])
+		return
+	}
+
+	for _, key := range path[1:] {
+		next, ok := lookupChild(value, key)
+		if !ok {
+			s.fail(req, "%s has no element %s", args.Expression, key)
+			return
+		}
+		value = next
+	}
+
+	v := s.variable(args.Expression, value)
+	s.respond(req, map[string]interface{}{
+		"result":             v["value"],
+		"type":               v["type"],
+		"variablesReference": v["variablesReference"],
+	})
+}
+
+// parsePath splits a.b[0]["c"] into [a b [0] c]
+func parsePath(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	var path []string
+	i := 0
+	readIdent := func() string {
+		start := i
+		for i < len(expr) && (expr[i] == '_' || expr[i] >= 'a' && expr[i] <= 'z' ||
+			expr[i] >= 'A' && expr[i] <= 'Z' || expr[i] >= '0' && expr[i] <= '9') {
+			i++
+		}
+		return expr[start:i]
+	}
+
+	name := readIdent()
+	if name == "" {
+		return nil, fmt.Errorf("cannot evaluate %q: only variable names are supported", expr)
+	}
+	path = append(path, name)
+	for i < len(expr) {
+		switch expr[i] {
+		case '.':
+			i++
+			field := readIdent()
+			if field == "" {
+				return nil, fmt.Errorf("cannot evaluate %q", expr)
+			}
+			path = append(path, field)
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("cannot evaluate %q", expr)
+			}
+			key := strings.TrimSpace(expr[i+1 : i+end])
+			if unquoted, err := strconv.Unquote(key); err == nil {
+				key = unquoted
+			} else if _, err := strconv.Atoi(key); err == nil {
+				key = "[" + key + "]"
+			} else {
+				return nil, fmt.Errorf("cannot evaluate %q: only literal indexes are supported", expr)
+			}
+			path = append(path, key)
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("cannot evaluate %q: only variable names are supported", expr)
+		}
+	}
+	return path, nil
+}
+
+func lookupChild(value vmregister.Value, key string) (vmregister.Value, bool) {
+	for _, child := range children(value) {
+		if child.Name == key {
+			return child.Value, true
+		}
+	}
+	return vmregister.NilValue(), false
+}
@@ -21,6 +21,9 @@ type FileSystemModule struct {
 	Watchers     map[string]*FileWatcher
 	ScanResults  []ScanResult
 	mu           sync.RWMutex
+
+	streams       map[string]*Stream // Files open for streaming, by handle
+	streamCounter uint64             // Counter for generating stream handles (atomic)
 }
 
 // FileBaseline represents a file's security baseline
@@ -81,6 +84,7 @@ func NewFileSystemModule() *FileSystemModule {
 		Baselines:   make(map[string]*FileBaseline),
 		Watchers:    make(map[string]*FileWatcher),
 		ScanResults: make([]ScanResult, 0),
+		streams:     make(map[string]*Stream),
 	}
 }
 
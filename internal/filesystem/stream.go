@@ -0,0 +1,96 @@
+package filesystem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Stream is a file opened to be read a line or chunk at a time, so that
+// files too large to load whole can still be processed
+type Stream struct {
+	Path   string
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// Open opens path for streaming and returns the handle to read it with
+func (fs *FileSystemModule) Open(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	handle := "file_" + strconv.FormatUint(atomic.AddUint64(&fs.streamCounter, 1), 10)
+
+	fs.mu.Lock()
+	fs.streams[handle] = &Stream{Path: path, file: file, reader: bufio.NewReaderSize(file, 64*1024)}
+	fs.mu.Unlock()
+	return handle, nil
+}
+
+// stream returns the open stream for handle
+func (fs *FileSystemModule) stream(handle string) (*Stream, error) {
+	fs.mu.RLock()
+	s, ok := fs.streams[handle]
+	fs.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("file not open: %s", handle)
+	}
+	return s, nil
+}
+
+// ReadLine reads the next line of a stream without its line ending. ok is
+// false once the end of the file has been reached.
+func (fs *FileSystemModule) ReadLine(handle string) (line string, ok bool, err error) {
+	s, err := fs.stream(handle)
+	if err != nil {
+		return "", false, err
+	}
+	line, err = s.reader.ReadString('\n')
+	if err == io.EOF {
+		// A last line without a line ending still counts
+		return line, line != "", nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return line, true, nil
+}
+
+// ReadChunk reads up to n bytes from a stream. It returns nil once the end
+// of the file has been reached.
+func (fs *FileSystemModule) ReadChunk(handle string, n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", n)
+	}
+	s, err := fs.stream(handle)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(s.reader, buf)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// Close closes a stream and forgets its handle
+func (fs *FileSystemModule) Close(handle string) error {
+	fs.mu.Lock()
+	s, ok := fs.streams[handle]
+	delete(fs.streams, handle)
+	fs.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("file not open: %s", handle)
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,438 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File integrity monitoring in watch mode: the files under a path are
+// hashed once, then the OS tells of changes to them as they happen and
+// each is reported with how its hash, size and mode changed. Where there
+// are no file notifications the tree is rescanned on an interval instead.
+
+// WatchEvent is a change to a watched file: created, modified or deleted
+type WatchEvent struct {
+	Type    string
+	Path    string
+	IsDir   bool
+	Changes []string // What a modification changed: content, size, mode
+	OldHash string   // SHA-256 of regular files, before and after
+	NewHash string
+	OldSize int64
+	NewSize int64
+	OldMode os.FileMode
+	NewMode os.FileMode
+	Time    time.Time
+}
+
+// WatchOptions configure Watch
+type WatchOptions struct {
+	Recursive    bool
+	Exclude      []string      // Glob patterns of names or paths not to watch
+	Debounce     time.Duration // How long a burst of writes is waited out, 100ms by default
+	PollInterval time.Duration // Rescans on this interval instead of using file notifications
+	MaxHashSize  int64         // Larger files are compared by size and mode only, 1GB by default
+}
+
+// fileState is what is known of a watched file
+type fileState struct {
+	dir  bool
+	size int64
+	mode os.FileMode
+	mod  time.Time
+	hash string
+}
+
+// notifier tells a Watcher which paths changed. An empty path asks for
+// everything to be rescanned, after notifications were lost.
+type notifier interface {
+	add(dir string) error
+	changes() <-chan string
+	errors() <-chan error
+	close() error
+}
+
+// Watcher watches a file or directory tree for changes
+type Watcher struct {
+	root   string
+	single bool // root is a file, watched through its directory
+	opts   WatchOptions
+	state  map[string]fileState
+	notify notifier
+	events chan WatchEvent
+	done   chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch baselines the files under path and starts watching them
+func Watch(path string, opts WatchOptions) (*Watcher, error) {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = 100 * time.Millisecond
+	}
+	if opts.MaxHashSize <= 0 {
+		opts.MaxHashSize = 1 << 30
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad exclude pattern %q", pattern)
+		}
+	}
+	w := &Watcher{
+		root:   root,
+		single: !info.IsDir(),
+		opts:   opts,
+		state:  map[string]fileState{},
+		events: make(chan WatchEvent, 256),
+		done:   make(chan struct{}),
+	}
+	if opts.PollInterval > 0 {
+		w.notify = newPoller(opts.PollInterval)
+	} else if w.notify, err = newNotifier(); err != nil {
+		return nil, err
+	}
+
+	// The baseline is taken as the directories are added, so that no
+	// change between the two goes unseen
+	if w.single {
+		if err := w.notify.add(filepath.Dir(root)); err != nil {
+			w.notify.close()
+			return nil, err
+		}
+		w.check(root, time.Now(), false)
+	} else if err := w.scan(root, time.Now(), false); err != nil {
+		w.notify.close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Events returns the changes seen, closed once the watcher stops
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Err returns the error that stopped the watcher, if any
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// run collects the paths that changed and checks them once they have
+// been quiet for the debounce interval
+func (w *Watcher) run() {
+	defer close(w.events)
+	defer w.notify.close()
+
+	pending := map[string]bool{}
+	var quiet <-chan time.Time
+	var timer *time.Timer
+	for {
+		select {
+		case <-w.done:
+			return
+		case err := <-w.notify.errors():
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			return
+		case path := <-w.notify.changes():
+			if path == "" {
+				// A rescan covers whatever was pending
+				w.rescan(time.Now())
+				pending, quiet = map[string]bool{}, nil
+				continue
+			}
+			if w.single && path != w.root {
+				continue
+			}
+			pending[path] = true
+			if timer == nil {
+				timer = time.NewTimer(w.opts.Debounce)
+			} else {
+				timer.Reset(w.opts.Debounce)
+			}
+			quiet = timer.C
+		case <-quiet:
+			quiet = nil
+			now := time.Now()
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			// Parents first, so a new directory is watched before its files
+			sort.Strings(paths)
+			for _, path := range paths {
+				w.check(path, now, true)
+			}
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// excluded tells whether path matches an exclude pattern
+func (w *Watcher) excluded(path string) bool {
+	if path == w.root {
+		return false
+	}
+	for _, pattern := range w.opts.Exclude {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scan watches the directory dir and checks everything in it, descending
+// into subdirectories when recursive
+func (w *Watcher) scan(dir string, now time.Time, report bool) error {
+	if err := w.notify.add(dir); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		w.check(filepath.Join(dir, e.Name()), now, report)
+	}
+	return nil
+}
+
+// rescan checks every path, known or not, for when the notifications do
+// not say which changed
+func (w *Watcher) rescan(now time.Time) {
+	if w.single {
+		w.check(w.root, now, true)
+		return
+	}
+	seen := map[string]bool{}
+	filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || w.excluded(path) {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		seen[path] = true
+		if path == w.root {
+			return nil
+		}
+		w.check(path, now, true)
+		if info.IsDir() && !w.opts.Recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	for path := range w.state {
+		if !seen[path] {
+			w.check(path, now, true)
+		}
+	}
+}
+
+// check compares a path with what is known of it and reports the change
+func (w *Watcher) check(path string, now time.Time, report bool) {
+	if w.excluded(path) {
+		return
+	}
+	old, known := w.state[path]
+	info, err := os.Lstat(path)
+	if err != nil {
+		if known {
+			w.forget(path, now, report)
+		}
+		return
+	}
+	if path == w.root && !w.single {
+		return
+	}
+
+	cur := fileState{dir: info.IsDir(), size: info.Size(), mode: info.Mode(), mod: info.ModTime()}
+	if cur.dir {
+		cur.size = 0
+	} else if info.Mode().IsRegular() {
+		if known && !old.dir && old.size == cur.size && old.mod.Equal(cur.mod) {
+			// Untouched content keeps its hash
+			cur.hash = old.hash
+		} else if cur.size <= w.opts.MaxHashSize {
+			cur.hash, _ = hashFile(path)
+		}
+	}
+	if known && old.dir != cur.dir {
+		// Replaced by a file of the other kind
+		w.forget(path, now, report)
+		known = false
+	}
+	w.state[path] = cur
+
+	if !known {
+		if cur.dir && w.opts.Recursive {
+			w.scan(path, now, report)
+		}
+		if report {
+			w.emit(WatchEvent{Type: "created", Path: path, IsDir: cur.dir, NewHash: cur.hash, NewSize: cur.size, NewMode: cur.mode, Time: now})
+		}
+		return
+	}
+	var changes []string
+	if cur.hash != old.hash {
+		changes = append(changes, "content")
+	}
+	if cur.size != old.size {
+		changes = append(changes, "size")
+	}
+	if cur.mode != old.mode {
+		changes = append(changes, "mode")
+	}
+	if len(changes) > 0 && report {
+		w.emit(WatchEvent{
+			Type: "modified", Path: path, IsDir: cur.dir, Changes: changes,
+			OldHash: old.hash, NewHash: cur.hash,
+			OldSize: old.size, NewSize: cur.size,
+			OldMode: old.mode, NewMode: cur.mode,
+			Time: now,
+		})
+	}
+}
+
+// forget reports a path deleted, and with a directory everything in it
+func (w *Watcher) forget(path string, now time.Time, report bool) {
+	var gone []string
+	prefix := path + string(filepath.Separator)
+	for p := range w.state {
+		if p == path || strings.HasPrefix(p, prefix) {
+			gone = append(gone, p)
+		}
+	}
+	// Deepest first, as the files went before their directories
+	sort.Sort(sort.Reverse(sort.StringSlice(gone)))
+	for _, p := range gone {
+		old := w.state[p]
+		delete(w.state, p)
+		if report {
+			w.emit(WatchEvent{Type: "deleted", Path: p, IsDir: old.dir, OldHash: old.hash, OldSize: old.size, OldMode: old.mode, Time: now})
+		}
+	}
+}
+
+// emit hands an event on unless the watcher was closed
+func (w *Watcher) emit(e WatchEvent) {
+	select {
+	case w.events <- e:
+	case <-w.done:
+	}
+}
+
+// hashFile returns the SHA-256 of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// poller asks for a rescan on an interval, for systems without file
+// notifications and file systems that do not send them
+type poller struct {
+	ticks chan string
+	errs  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPoller(interval time.Duration) *poller {
+	p := &poller{ticks: make(chan string), errs: make(chan error), done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case p.ticks <- "":
+				case <-p.done:
+					return
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *poller) add(dir string) error   { return nil }
+func (p *poller) changes() <-chan string { return p.ticks }
+func (p *poller) errors() <-chan error   { return p.errs }
+
+func (p *poller) close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// WatchEventToMap returns an event as a map, with the hash, size and mode
+// before a change as old_sha256, old_size and old_mode
+func WatchEventToMap(e WatchEvent) map[string]interface{} {
+	m := map[string]interface{}{
+		"type":   e.Type,
+		"path":   e.Path,
+		"is_dir": e.IsDir,
+		"time":   e.Time.UTC().Format(time.RFC3339Nano),
+	}
+	if e.Type != "deleted" {
+		m["size"] = e.NewSize
+		m["mode"] = e.NewMode.String()
+		if e.NewHash != "" {
+			m["sha256"] = e.NewHash
+		}
+	}
+	if e.Type != "created" {
+		m["old_size"] = e.OldSize
+		m["old_mode"] = e.OldMode.String()
+		if e.OldHash != "" {
+			m["old_sha256"] = e.OldHash
+		}
+	}
+	changes := make([]interface{}, len(e.Changes))
+	for i, c := range e.Changes {
+		changes[i] = c
+	}
+	m["changes"] = changes
+	return m
+}
@@ -0,0 +1,125 @@
+//go:build linux
+
+// internal/filesystem/watch_linux.go
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask is what inotify is asked to report of a watched directory
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_ATTRIB | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF |
+	unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// inotify watches directories through an inotify instance, whose
+// descriptor is non-blocking so that closing it ends the read
+type inotify struct {
+	fd    int // Kept apart, as File.Fd would make the descriptor blocking
+	file  *os.File
+	paths chan string
+	errs  chan error
+	done  chan struct{}
+	once  sync.Once
+
+	mu   sync.Mutex
+	dirs map[int]string // Watched directories, by watch descriptor
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+	n := &inotify{
+		fd:    fd,
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		paths: make(chan string, 256),
+		errs:  make(chan error, 1),
+		done:  make(chan struct{}),
+		dirs:  map[int]string{},
+	}
+	go n.read()
+	return n, nil
+}
+
+func (n *inotify) add(dir string) error {
+	wd, err := unix.InotifyAddWatch(n.fd, dir, watchMask)
+	if err == unix.ENOSPC {
+		return fmt.Errorf("cannot watch %s: the inotify watch limit was reached, raise fs.inotify.max_user_watches", dir)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %v", dir, err)
+	}
+	n.mu.Lock()
+	n.dirs[wd] = dir
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *inotify) changes() <-chan string { return n.paths }
+func (n *inotify) errors() <-chan error   { return n.errs }
+
+func (n *inotify) close() error {
+	n.once.Do(func() { close(n.done) })
+	return n.file.Close()
+}
+
+// read turns the events of the instance into the paths they are about
+func (n *inotify) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := n.file.Read(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+			default:
+				n.errs <- fmt.Errorf("inotify: %v", err)
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= size; {
+			e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(e.Len)]
+			offset += unix.SizeofInotifyEvent + int(e.Len)
+
+			if e.Mask&unix.IN_Q_OVERFLOW != 0 {
+				// Events were lost, so everything is compared again
+				n.send("")
+				continue
+			}
+			n.mu.Lock()
+			dir, ok := n.dirs[int(e.Wd)]
+			if e.Mask&unix.IN_IGNORED != 0 {
+				delete(n.dirs, int(e.Wd))
+			}
+			n.mu.Unlock()
+			if !ok || e.Mask&unix.IN_IGNORED != 0 {
+				continue
+			}
+			path := dir
+			if e.Len > 0 {
+				// The name is padded with NULs
+				end := 0
+				for end < len(name) && name[end] != 0 {
+					end++
+				}
+				path = filepath.Join(dir, string(name[:end]))
+			}
+			n.send(path)
+		}
+	}
+}
+
+func (n *inotify) send(path string) {
+	select {
+	case n.paths <- path:
+	case <-n.done:
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+// internal/filesystem/watch_other.go
+package filesystem
+
+import "time"
+
+// newNotifier rescans every two seconds where inotify is not available
+func newNotifier() (notifier, error) {
+	return newPoller(2 * time.Second), nil
+}
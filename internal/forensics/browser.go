@@ -0,0 +1,223 @@
+package forensics
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// browserProfiles are where browsers keep their history databases under a
+// home directory, on Linux, macOS and Windows
+var browserProfiles = []struct {
+	Browser string
+	Pattern string
+}{
+	{"chrome", ".config/google-chrome/*/History"},
+	{"chrome", "Library/Application Support/Google/Chrome/*/History"},
+	{"chrome", "AppData/Local/Google/Chrome/User Data/*/History"},
+	{"chromium", ".config/chromium/*/History"},
+	{"edge", ".config/microsoft-edge/*/History"},
+	{"edge", "Library/Application Support/Microsoft Edge/*/History"},
+	{"edge", "AppData/Local/Microsoft/Edge/User Data/*/History"},
+	{"brave", ".config/BraveSoftware/Brave-Browser/*/History"},
+	{"brave", "Library/Application Support/BraveSoftware/Brave-Browser/*/History"},
+	{"brave", "AppData/Local/BraveSoftware/Brave-Browser/User Data/*/History"},
+	{"firefox", ".mozilla/firefox/*/places.sqlite"},
+	{"firefox", "snap/firefox/common/.mozilla/firefox/*/places.sqlite"},
+	{"firefox", "Library/Application Support/Firefox/Profiles/*/places.sqlite"},
+	{"firefox", "AppData/Roaming/Mozilla/Firefox/Profiles/*/places.sqlite"},
+	{"safari", "Library/Safari/History.db"},
+}
+
+// Chromium counts microseconds since 1601, Firefox microseconds since
+// 1970 and Safari seconds since 2001
+const webkitUnixEpoch = 11644473600 * 1000000
+
+var safariEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// BrowserHistory reads the visits and downloads of the Chromium browsers,
+// Firefox and Safari from the profiles of every user under the root, or
+// from the history database at Path. The databases are copied before they
+// are read, so a running browser's lock does not get in the way.
+func BrowserHistory(opts Options) ([]*Record, error) {
+	if opts.Path != "" {
+		return readBrowserHistory(opts.Path, "", "")
+	}
+	var records []*Record
+	for _, h := range homes(opts.root()) {
+		for _, p := range browserProfiles {
+			for _, path := range glob(h.Dir, p.Pattern) {
+				found, err := readBrowserHistory(path, p.Browser, h.User)
+				if err != nil {
+					return records, err
+				}
+				records = append(records, found...)
+			}
+		}
+	}
+	SortRecords(records)
+	return records, nil
+}
+
+// readBrowserHistory reads one history database, telling the browser by
+// its tables when it is not known
+func readBrowserHistory(path, browser, user string) ([]*Record, error) {
+	dir, err := os.MkdirTemp("", "sentra-history")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	copyPath := filepath.Join(dir, "history.db")
+	if err := copyFile(path, copyPath); err != nil {
+		return nil, err
+	}
+	// A write-ahead log holds the most recent visits
+	copyFile(path+"-wal", copyPath+"-wal")
+	db, err := sql.Open("sqlite", copyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	tables := make(map[string]bool)
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables[name] = true
+	}
+	rows.Close()
+	var records []*Record
+	add := func(t time.Time, typ, summary string, data map[string]interface{}) {
+		records = append(records, &Record{Time: t, Source: browser, Type: typ, User: user, Path: path, Summary: summary, Data: data})
+	}
+	switch {
+	case tables["urls"] && tables["visits"]:
+		if browser == "" {
+			browser = "chromium"
+		}
+		err = queryRows(db, `SELECT u.url, COALESCE(u.title, ''), v.visit_time, v.transition
+			FROM visits v JOIN urls u ON u.id = v.url ORDER BY v.visit_time`, func(scan func(...interface{}) error) error {
+			var url, title string
+			var at, transition int64
+			if err := scan(&url, &title, &at, &transition); err != nil {
+				return err
+			}
+			add(webkitTime(at), "visit", url, map[string]interface{}{"url": url, "title": title, "transition": chromeTransition(transition)})
+			return nil
+		})
+		if err == nil && tables["downloads"] {
+			err = queryRows(db, `SELECT d.target_path, d.start_time, d.total_bytes, COALESCE(c.url, '')
+				FROM downloads d LEFT JOIN downloads_url_chains c ON c.id = d.id AND c.chain_index = 0
+				ORDER BY d.start_time`, func(scan func(...interface{}) error) error {
+				var target, url string
+				var at, size int64
+				if err := scan(&target, &at, &size, &url); err != nil {
+					return err
+				}
+				add(webkitTime(at), "download", target, map[string]interface{}{"url": url, "target": target, "bytes": size})
+				return nil
+			})
+		}
+	case tables["moz_places"] && tables["moz_historyvisits"]:
+		if browser == "" {
+			browser = "firefox"
+		}
+		err = queryRows(db, `SELECT p.url, COALESCE(p.title, ''), v.visit_date, v.visit_type
+			FROM moz_historyvisits v JOIN moz_places p ON p.id = v.place_id ORDER BY v.visit_date`, func(scan func(...interface{}) error) error {
+			var url, title string
+			var at, visitType int64
+			if err := scan(&url, &title, &at, &visitType); err != nil {
+				return err
+			}
+			typ := "visit"
+			if visitType == 7 {
+				typ = "download"
+			}
+			add(time.UnixMicro(at).UTC(), typ, url, map[string]interface{}{"url": url, "title": title})
+			return nil
+		})
+	case tables["history_items"] && tables["history_visits"]:
+		if browser == "" {
+			browser = "safari"
+		}
+		err = queryRows(db, `SELECT i.url, COALESCE(v.title, ''), v.visit_time
+			FROM history_visits v JOIN history_items i ON i.id = v.history_item ORDER BY v.visit_time`, func(scan func(...interface{}) error) error {
+			var url, title string
+			var at float64
+			if err := scan(&url, &title, &at); err != nil {
+				return err
+			}
+			add(safariEpoch.Add(time.Duration(at*float64(time.Second))), "visit", url, map[string]interface{}{"url": url, "title": title})
+			return nil
+		})
+	default:
+		return nil, fmt.Errorf("%s is not a browser history database", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, r := range records {
+		r.Source = browser
+	}
+	return records, nil
+}
+
+// queryRows calls fn with the scanner of each row of a query
+func queryRows(db *sql.DB, query string, fn func(scan func(...interface{}) error) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// webkitTime converts a Chromium timestamp
+func webkitTime(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us - webkitUnixEpoch).UTC()
+}
+
+// chromeTransition names how a Chromium visit came about, from the core
+// type in the low byte of its transition
+func chromeTransition(t int64) string {
+	names := []string{"link", "typed", "auto_bookmark", "auto_subframe", "manual_subframe",
+		"generated", "auto_toplevel", "form_submit", "reload", "keyword", "keyword_generated"}
+	if core := int(t & 0xff); core < len(names) {
+		return names[core]
+	}
+	return "other"
+}
+
+// copyFile copies a file
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
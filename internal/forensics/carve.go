@@ -0,0 +1,565 @@
+package forensics
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// carver finds where a file that starts with a magic number ends. length
+// returns the size of the file at off, or false if the bytes there are not
+// one or it does not end within max bytes.
+type carver struct {
+	Type   string
+	Ext    string
+	Magic  []byte
+	length func(r io.ReaderAt, off, max int64) (int64, bool)
+}
+
+// carvers are the file types Carve knows
+var carvers = []carver{
+	{"jpeg", "jpg", []byte{0xff, 0xd8, 0xff}, jpegLength},
+	{"png", "png", []byte("\x89PNG\r\n\x1a\n"), pngLength},
+	{"gif", "gif", []byte("GIF8"), gifLength},
+	{"pdf", "pdf", []byte("%PDF-"), pdfLength},
+	{"zip", "zip", []byte("PK\x03\x04"), zipLength},
+	{"gzip", "gz", []byte{0x1f, 0x8b, 0x08}, gzipLength},
+	{"sqlite", "sqlite", []byte("SQLite format 3\x00"), sqliteLength},
+	{"pe", "exe", []byte("MZ"), peLength},
+	{"elf", "elf", []byte("\x7fELF"), elfLength},
+}
+
+// CarveTypes returns the names of the file types Carve knows
+func CarveTypes() []string {
+	types := make([]string, len(carvers))
+	for i, c := range carvers {
+		types[i] = c.Type
+	}
+	return types
+}
+
+// CarveOptions choose what Carve looks for
+type CarveOptions struct {
+	Types    []string // The types to carve, all by default
+	MaxSize  int64    // The largest file carved, 64MB by default
+	MaxFiles int      // Stop after this many files, 1000 by default
+}
+
+// CarvedFile is a file carved out of a source
+type CarvedFile struct {
+	Type   string
+	Offset int64
+	Size   int64
+	Path   string // Where it was written, empty if it was not
+	SHA256 string
+}
+
+// carveChunk is how much of a source is searched at once
+const carveChunk = 1 << 20
+
+// Carve finds files in a raw disk image, device or any other file by the
+// magic numbers they start with and the structure that tells where they
+// end, and writes each to outDir, named by its offset, when outDir is set.
+// Files that do not end within MaxSize are left out. The search resumes
+// after the end of each file found, so files embedded in it, such as the
+// thumbnail of a photo, are not carved again.
+func Carve(source, outDir string, opts CarveOptions) ([]*CarvedFile, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 64 << 20
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = 1000
+	}
+	active := carvers
+	if len(opts.Types) > 0 {
+		active = nil
+		for _, t := range opts.Types {
+			found := false
+			for _, c := range carvers {
+				if c.Type == t {
+					active = append(active, c)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown file type '%s'", t)
+			}
+		}
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Seeking gives the size of block devices, which Stat reports as 0
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if outDir != "" {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	var first [256]bool
+	for _, c := range active {
+		first[c.Magic[0]] = true
+	}
+	var files []*CarvedFile
+	buf := make([]byte, carveChunk+16)
+	for pos := int64(0); pos < size && len(files) < opts.MaxFiles; {
+		n, err := f.ReadAt(buf, pos)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return files, err
+			}
+			break
+		}
+		chunk := buf[:n]
+		next := pos + carveChunk
+		for i := 0; i < len(chunk) && i < carveChunk; i++ {
+			if !first[chunk[i]] {
+				continue
+			}
+			c, length := matchCarver(active, f, chunk[i:], pos+int64(i), opts.MaxSize, size)
+			if c == nil {
+				continue
+			}
+			file := &CarvedFile{Type: c.Type, Offset: pos + int64(i), Size: length}
+			if err := saveCarved(f, file, outDir, c.Ext); err != nil {
+				return files, err
+			}
+			files = append(files, file)
+			next = file.Offset + length
+			break
+		}
+		if len(files) >= opts.MaxFiles {
+			break
+		}
+		pos = next
+	}
+	return files, nil
+}
+
+// matchCarver returns the carver of the file starting at data, and its
+// length
+func matchCarver(active []carver, r io.ReaderAt, data []byte, off, max, size int64) (*carver, int64) {
+	for i := range active {
+		c := &active[i]
+		if !bytes.HasPrefix(data, c.Magic) {
+			continue
+		}
+		limit := max
+		if size-off < limit {
+			limit = size - off
+		}
+		if n, ok := c.length(r, off, limit); ok && n > 0 && n <= limit {
+			return c, n
+		}
+	}
+	return nil, 0
+}
+
+// saveCarved hashes a carved file and writes it to outDir
+func saveCarved(r io.ReaderAt, file *CarvedFile, outDir, ext string) error {
+	hash := sha256.New()
+	var w io.Writer = hash
+	if outDir != "" {
+		file.Path = filepath.Join(outDir, fmt.Sprintf("%012x.%s", file.Offset, ext))
+		out, err := os.Create(file.Path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = io.MultiWriter(out, hash)
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, file.Offset, file.Size)); err != nil {
+		return err
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// readAt reads n bytes at off, or returns nil
+func readAt(r io.ReaderAt, off int64, n int) []byte {
+	buf := make([]byte, n)
+	if got, _ := r.ReadAt(buf, off); got != n {
+		return nil
+	}
+	return buf
+}
+
+// byteReader reads a section byte by byte, counting what it read, so a
+// decoder that reads ahead only through it stops exactly at the end of
+// what it decodes
+type byteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func newByteReader(r io.ReaderAt, off, max int64) *byteReader {
+	return &byteReader{r: bufio.NewReader(io.NewSectionReader(r, off, max))}
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		b.n++
+	}
+	return c, err
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c, err := b.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = c
+	return 1, nil
+}
+
+// skip discards n bytes
+func (b *byteReader) skip(n int64) bool {
+	got, err := b.r.Discard(int(n))
+	b.n += int64(got)
+	return err == nil
+}
+
+func (b *byteReader) uint16BE() (int64, bool) {
+	hi, err1 := b.ReadByte()
+	lo, err2 := b.ReadByte()
+	return int64(hi)<<8 | int64(lo), err1 == nil && err2 == nil
+}
+
+// jpegLength walks the segments of a JPEG to its start of scan, then the
+// entropy-coded data to the end of image marker, so an embedded thumbnail
+// does not end it early
+func jpegLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	b := newByteReader(r, off+2, max-2)
+	for {
+		c, err := b.ReadByte()
+		if err != nil || c != 0xff {
+			return 0, false
+		}
+		marker, err := b.ReadByte()
+		for err == nil && marker == 0xff {
+			marker, err = b.ReadByte()
+		}
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case marker == 0xd9:
+			return b.n + 2, true
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			continue
+		}
+		n, ok := b.uint16BE()
+		if !ok || n < 2 || !b.skip(n-2) {
+			return 0, false
+		}
+		if marker != 0xda {
+			continue
+		}
+		// Entropy-coded data: 0xff is followed by 0 when it is data, by a
+		// restart marker, or by the next marker
+		for {
+			c, err := b.ReadByte()
+			if err != nil {
+				return 0, false
+			}
+			if c != 0xff {
+				continue
+			}
+			m, err := b.ReadByte()
+			for err == nil && m == 0xff {
+				m, err = b.ReadByte()
+			}
+			if err != nil {
+				return 0, false
+			}
+			if m == 0 || (m >= 0xd0 && m <= 0xd7) {
+				continue
+			}
+			if m == 0xd9 {
+				return b.n + 2, true
+			}
+			// Another segment, as in progressive JPEGs with many scans
+			n, ok := b.uint16BE()
+			if !ok || n < 2 || !b.skip(n-2) {
+				return 0, false
+			}
+			if m != 0xda {
+				break
+			}
+		}
+	}
+}
+
+// pngLength walks the chunks of a PNG to IEND
+func pngLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	pos := int64(8)
+	for pos+12 <= max {
+		header := readAt(r, off+pos, 8)
+		if header == nil {
+			return 0, false
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		for _, c := range header[4:] {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+				return 0, false
+			}
+		}
+		pos += 12 + n
+		if string(header[4:]) == "IEND" {
+			return pos, pos <= max
+		}
+	}
+	return 0, false
+}
+
+// gifLength walks the blocks of a GIF to its trailer
+func gifLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	header := readAt(r, off, 13)
+	if header == nil || (string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a") {
+		return 0, false
+	}
+	b := newByteReader(r, off, max)
+	b.skip(13)
+	if header[10]&0x80 != 0 {
+		b.skip(3 << (header[10]&7 + 1))
+	}
+	subBlocks := func() bool {
+		for {
+			n, err := b.ReadByte()
+			if err != nil {
+				return false
+			}
+			if n == 0 {
+				return true
+			}
+			if !b.skip(int64(n)) {
+				return false
+			}
+		}
+	}
+	for {
+		c, err := b.ReadByte()
+		if err != nil {
+			return 0, false
+		}
+		switch c {
+		case 0x3b:
+			return b.n, true
+		case 0x21:
+			if _, err := b.ReadByte(); err != nil || !subBlocks() {
+				return 0, false
+			}
+		case 0x2c:
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(b, desc); err != nil {
+				return 0, false
+			}
+			if desc[8]&0x80 != 0 {
+				b.skip(3 << (desc[8]&7 + 1))
+			}
+			if _, err := b.ReadByte(); err != nil || !subBlocks() {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+	}
+}
+
+// pdfLength ends a PDF at the last %%EOF before the next PDF starts, as
+// incremental updates append sections that each end with one
+func pdfLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	end := int64(-1)
+	buf := make([]byte, carveChunk+8)
+	for pos := int64(5); pos < max; pos += carveChunk {
+		n, _ := r.ReadAt(buf, off+pos)
+		if int64(n) > max-pos {
+			n = int(max - pos)
+		}
+		chunk := buf[:n]
+		next := bytes.Index(chunk, []byte("%PDF-"))
+		if next >= 0 {
+			chunk = chunk[:next]
+		}
+		if i := bytes.LastIndex(chunk, []byte("%%EOF")); i >= 0 {
+			end = pos + int64(i) + 5
+			// Take the line break after it too
+			for _, c := range chunk[i+5:] {
+				if c != '\r' && c != '\n' {
+					break
+				}
+				end++
+			}
+		}
+		if next >= 0 || n < carveChunk {
+			break
+		}
+	}
+	return end, end > 0
+}
+
+// zipLength finds the end of central directory record of a ZIP
+func zipLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	buf := make([]byte, carveChunk+22)
+	for pos := int64(4); pos < max; pos += carveChunk {
+		n, _ := r.ReadAt(buf, off+pos)
+		chunk := buf[:n]
+		for i := 0; ; {
+			j := bytes.Index(chunk[i:], []byte("PK\x05\x06"))
+			if j < 0 {
+				break
+			}
+			i += j
+			if i+22 <= len(chunk) {
+				comment := int64(binary.LittleEndian.Uint16(chunk[i+20:]))
+				end := pos + int64(i) + 22 + comment
+				return end, end <= max
+			}
+			i++
+		}
+		if n < carveChunk {
+			break
+		}
+	}
+	return 0, false
+}
+
+// gzipLength decompresses a gzip member to find where it ends. Past max
+// decompressed bytes it gives up.
+func gzipLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	b := newByteReader(r, off, max)
+	z, err := gzip.NewReader(b)
+	if err != nil {
+		return 0, false
+	}
+	z.Multistream(false)
+	if n, err := io.CopyN(io.Discard, z, 16*max); err != nil && err != io.EOF || n == 16*max {
+		return 0, false
+	}
+	return b.n, true
+}
+
+// sqliteLength reads the page size and page count of an SQLite header
+func sqliteLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	header := readAt(r, off, 100)
+	if header == nil {
+		return 0, false
+	}
+	pageSize := int64(binary.BigEndian.Uint16(header[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	pages := int64(binary.BigEndian.Uint32(header[28:]))
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 || pages == 0 {
+		return 0, false
+	}
+	return pageSize * pages, true
+}
+
+// peLength ends a PE at the end of its last section, or of its certificate
+// table, which follows the sections
+func peLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	dos := readAt(r, off, 64)
+	if dos == nil {
+		return 0, false
+	}
+	lfanew := int64(binary.LittleEndian.Uint32(dos[0x3c:]))
+	if lfanew < 64 || lfanew > 4096 {
+		return 0, false
+	}
+	nt := readAt(r, off+lfanew, 24)
+	if nt == nil || string(nt[:4]) != "PE\x00\x00" {
+		return 0, false
+	}
+	sections := int64(binary.LittleEndian.Uint16(nt[6:]))
+	optSize := int64(binary.LittleEndian.Uint16(nt[20:]))
+	opt := readAt(r, off+lfanew+24, int(optSize))
+	if sections == 0 || sections > 96 || opt == nil || len(opt) < 2 {
+		return 0, false
+	}
+	var end int64
+	table := off + lfanew + 24 + optSize
+	for i := int64(0); i < sections; i++ {
+		s := readAt(r, table+i*40, 40)
+		if s == nil {
+			return 0, false
+		}
+		if e := int64(binary.LittleEndian.Uint32(s[20:])) + int64(binary.LittleEndian.Uint32(s[16:])); e > end {
+			end = e
+		}
+	}
+	// The security directory is the fifth data directory, its address a
+	// file offset
+	dirs := int64(96)
+	if binary.LittleEndian.Uint16(opt) == 0x20b {
+		dirs = 112
+	}
+	if int64(len(opt)) >= dirs+5*8 {
+		addr := int64(binary.LittleEndian.Uint32(opt[dirs+4*8:]))
+		size := int64(binary.LittleEndian.Uint32(opt[dirs+4*8+4:]))
+		if addr > 0 && addr+size > end {
+			end = addr + size
+		}
+	}
+	if headers := table + sections*40 - off; end < headers {
+		end = headers
+	}
+	return end, end <= max
+}
+
+// elfLength ends an ELF at the end of its section header table or of its
+// last segment, whichever is later
+func elfLength(r io.ReaderAt, off, max int64) (int64, bool) {
+	h := readAt(r, off, 64)
+	if h == nil || (h[4] != 1 && h[4] != 2) || (h[5] != 1 && h[5] != 2) {
+		return 0, false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if h[5] == 2 {
+		order = binary.BigEndian
+	}
+	var phoff, shoff, phentsize, phnum, shentsize, shnum int64
+	if h[4] == 2 {
+		phoff, shoff = int64(order.Uint64(h[0x20:])), int64(order.Uint64(h[0x28:]))
+		phentsize, phnum = int64(order.Uint16(h[0x36:])), int64(order.Uint16(h[0x38:]))
+		shentsize, shnum = int64(order.Uint16(h[0x3a:])), int64(order.Uint16(h[0x3c:]))
+	} else {
+		phoff, shoff = int64(order.Uint32(h[0x1c:])), int64(order.Uint32(h[0x20:]))
+		phentsize, phnum = int64(order.Uint16(h[0x2a:])), int64(order.Uint16(h[0x2c:]))
+		shentsize, shnum = int64(order.Uint16(h[0x2e:])), int64(order.Uint16(h[0x30:]))
+	}
+	if phoff < 0 || shoff < 0 || phoff > max || shoff > max {
+		return 0, false
+	}
+	ends := []int64{shoff + shentsize*shnum, phoff + phentsize*phnum}
+	for i := int64(0); i < phnum && phentsize >= 32; i++ {
+		p := readAt(r, off+phoff+i*phentsize, int(phentsize))
+		if p == nil {
+			return 0, false
+		}
+		if h[4] == 2 {
+			ends = append(ends, int64(order.Uint64(p[8:]))+int64(order.Uint64(p[32:])))
+		} else {
+			ends = append(ends, int64(order.Uint32(p[4:]))+int64(order.Uint32(p[16:])))
+		}
+	}
+	sort.Slice(ends, func(i, j int) bool { return ends[i] > ends[j] })
+	return ends[0], ends[0] > 64 && ends[0] <= max
+}
@@ -0,0 +1,153 @@
+package forensics
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFiles returns a sample of each type Carve knows but pe and elf
+func testFiles(t *testing.T) map[string][]byte {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	files := make(map[string][]byte)
+	var b bytes.Buffer
+	png.Encode(&b, img)
+	files["png"] = append([]byte(nil), b.Bytes()...)
+	b.Reset()
+	jpeg.Encode(&b, img, nil)
+	files["jpeg"] = append([]byte(nil), b.Bytes()...)
+	b.Reset()
+	pal := image.NewPaletted(img.Bounds(), []color.Color{color.Black, color.White})
+	gif.Encode(&b, pal, nil)
+	files["gif"] = append([]byte(nil), b.Bytes()...)
+	b.Reset()
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("notes.txt")
+	w.Write([]byte(strings.Repeat("exfiltrated ", 100)))
+	zw.SetComment("archive")
+	zw.Close()
+	files["zip"] = append([]byte(nil), b.Bytes()...)
+	b.Reset()
+	gw := gzip.NewWriter(&b)
+	gw.Write([]byte(strings.Repeat("log line\n", 500)))
+	gw.Close()
+	files["gzip"] = append([]byte(nil), b.Bytes()...)
+	files["pdf"] = []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+
+	path := filepath.Join(t.TempDir(), "carve.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('evidence')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if files["sqlite"], err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCarve(t *testing.T) {
+	files := testFiles(t)
+	rng := rand.New(rand.NewSource(1))
+	var disk []byte
+	want := make(map[int64]string)
+	for _, typ := range []string{"png", "jpeg", "gif", "zip", "gzip", "pdf", "sqlite"} {
+		// Random bytes never hold a whole file of a known type
+		junk := make([]byte, 3000+rng.Intn(5000))
+		rng.Read(junk)
+		disk = append(disk, junk...)
+		want[int64(len(disk))] = typ
+		disk = append(disk, files[typ]...)
+	}
+	disk = append(disk, make([]byte, 4096)...)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(source, disk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "carved")
+	carved, err := Carve(source, out, CarveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, f := range carved {
+		typ, ok := want[f.Offset]
+		if !ok {
+			// A false positive in the junk would still have to parse
+			continue
+		}
+		found++
+		if f.Type != typ {
+			t.Errorf("file at %d carved as %s, want %s", f.Offset, f.Type, typ)
+			continue
+		}
+		if f.Size != int64(len(files[typ])) {
+			t.Errorf("%s carved with size %d, want %d", typ, f.Size, len(files[typ]))
+		}
+		sum := sha256.Sum256(files[typ])
+		if f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s has the wrong hash", typ)
+		}
+		data, err := os.ReadFile(f.Path)
+		if err != nil || !bytes.Equal(data, files[typ]) {
+			t.Errorf("%s was not written to %s", typ, f.Path)
+		}
+	}
+	if found != len(want) {
+		t.Errorf("carved %d of %d files: %+v", found, len(want), carved)
+	}
+
+	carved, err = Carve(source, "", CarveOptions{Types: []string{"zip", "pdf"}, MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carved) != 1 || carved[0].Type != "zip" || carved[0].Path != "" || carved[0].SHA256 == "" {
+		t.Errorf("carve limited to one zip or pdf = %+v", carved)
+	}
+	if _, err := Carve(source, "", CarveOptions{Types: []string{"docx"}}); err == nil || !strings.Contains(err.Error(), "unknown file type 'docx'") {
+		t.Errorf("unknown type error = %v", err)
+	}
+	if _, err := Carve(filepath.Join(dir, "missing.img"), "", CarveOptions{}); err == nil {
+		t.Error("carving a missing source succeeded")
+	}
+}
+
+func TestCarveTruncated(t *testing.T) {
+	files := testFiles(t)
+	// Files cut short by the end of the source are not carved
+	var disk []byte
+	for _, typ := range []string{"png", "zip", "gzip"} {
+		disk = append(disk, files[typ][:len(files[typ])/2]...)
+		disk = append(disk, make([]byte, 100)...)
+	}
+	source := filepath.Join(t.TempDir(), "cut.img")
+	os.WriteFile(source, disk[:len(disk)-100], 0o644)
+	carved, err := Carve(source, "", CarveOptions{Types: []string{"png", "zip", "gzip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carved) != 0 {
+		t.Errorf("truncated files carved: %+v", carved)
+	}
+}
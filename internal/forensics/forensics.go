@@ -0,0 +1,170 @@
+// Package forensics collects evidence from disks: files carved out of raw
+// images and the artifacts that tell what ran and what users did, such as
+// browser and shell history, scheduled tasks, prefetch files and the
+// shimcache. Artifacts come back as records with a time, so they can be
+// merged into a timeline.
+package forensics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is one artifact: a visited URL, a command, a scheduled job, a
+// program run
+type Record struct {
+	Time    time.Time // Zero when the artifact carries no time
+	Source  string    // Where it came from, such as chrome, bash or prefetch
+	Type    string    // What it is: visit, download, command, scheduled, execution or file
+	User    string
+	Path    string // The file it was read from
+	Summary string
+	Data    map[string]interface{}
+}
+
+// Options choose where artifacts are collected from
+type Options struct {
+	// Root is the root of the file system searched, such as the mount
+	// point of a disk image. It defaults to the root of this host.
+	Root string
+	// Path reads one artifact file in place of searching under Root
+	Path string
+}
+
+// root returns the root artifacts are searched under
+func (o Options) root() string {
+	if o.Root != "" {
+		return o.Root
+	}
+	if runtime.GOOS == "windows" {
+		return os.Getenv("SystemDrive") + `\`
+	}
+	return "/"
+}
+
+// home is a user's home directory
+type home struct {
+	User string
+	Dir  string
+}
+
+// homes returns the home directories under a root: those of Linux, macOS
+// and Windows users, and root's
+func homes(root string) []home {
+	var out []home
+	for _, parent := range []string{"home", "Users"} {
+		entries, err := os.ReadDir(filepath.Join(root, parent))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() && e.Name() != "Public" && e.Name() != "Default" && e.Name() != "All Users" {
+				out = append(out, home{User: e.Name(), Dir: filepath.Join(root, parent, e.Name())})
+			}
+		}
+	}
+	if st, err := os.Stat(filepath.Join(root, "root")); err == nil && st.IsDir() {
+		out = append(out, home{User: "root", Dir: filepath.Join(root, "root")})
+	}
+	return out
+}
+
+// glob returns the files matching patterns relative to a directory
+func glob(dir string, patterns ...string) []string {
+	var out []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(p)))
+		out = append(out, matches...)
+	}
+	return out
+}
+
+// SortRecords orders records by time, those without a time last
+func SortRecords(records []*Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i].Time, records[j].Time
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.Before(b)
+	})
+}
+
+// Collectors are the artifact collectors by name
+var Collectors = map[string]func(Options) ([]*Record, error){
+	"browser_history": BrowserHistory,
+	"shell_history":   ShellHistory,
+	"scheduled_tasks": ScheduledTasks,
+	"prefetch":        Prefetch,
+	"shimcache":       Shimcache,
+}
+
+// Collect runs the named collectors, or all of them, under a root and
+// returns their records sorted by time. A collector that finds nothing to
+// read adds nothing; the errors of the others are returned by name.
+func Collect(root string, names []string) ([]*Record, map[string]error) {
+	if len(names) == 0 {
+		for name := range Collectors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var records []*Record
+	errs := make(map[string]error)
+	for _, name := range names {
+		collect, ok := Collectors[name]
+		if !ok {
+			errs[name] = fmt.Errorf("unknown artifact '%s'", name)
+			continue
+		}
+		found, err := collect(Options{Root: root})
+		if err != nil {
+			errs[name] = err
+		}
+		records = append(records, found...)
+	}
+	SortRecords(records)
+	return records, errs
+}
+
+// fileTime returns the modification time of a file
+func fileTime(path string) time.Time {
+	st, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return st.ModTime().UTC()
+}
+
+// userOf returns the user whose home directory holds a path, if any
+func userOf(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch {
+	case len(parts) > 1 && (parts[0] == "home" || parts[0] == "Users"):
+		return parts[1]
+	case len(parts) > 0 && parts[0] == "root":
+		return "root"
+	}
+	return ""
+}
+
+// filetimeUnixEpoch is the Unix epoch in Windows FILETIME units, 100ns
+// intervals since 1601
+const filetimeUnixEpoch = 116444736000000000
+
+// filetime converts a Windows FILETIME, returning the zero time for 0
+func filetime(ft uint64) time.Time {
+	if ft == 0 || ft < filetimeUnixEpoch/2 {
+		return time.Time{}
+	}
+	return time.Unix(0, 0).Add(time.Duration(int64(ft)-filetimeUnixEpoch) * 100).UTC()
+}
@@ -0,0 +1,248 @@
+package forensics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// hiveBinsStart is where the cells of a registry hive file start, after its
+// base block; cell offsets count from here
+const hiveBinsStart = 4096
+
+// bigDataSegment is the most data a value keeps in one cell; larger values
+// are split over the segments of a db record
+const bigDataSegment = 16344
+
+// hive is an offline Windows registry hive file. Changes still in its
+// transaction logs are not applied.
+type hive struct {
+	path string
+	data []byte
+}
+
+// hiveKey is a key of a hive
+type hiveKey struct {
+	h        *hive
+	cell     []byte
+	Name     string
+	Modified time.Time
+}
+
+// openHive reads a hive file
+func openHive(path string) (*hive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < hiveBinsStart || string(data[:4]) != "regf" {
+		return nil, fmt.Errorf("%s is not a registry hive", path)
+	}
+	return &hive{path: path, data: data}, nil
+}
+
+// cell returns the data of the cell at an offset
+func (h *hive) cell(off uint32) ([]byte, error) {
+	start := hiveBinsStart + int(off)
+	if off == 0xffffffff || start+4 > len(h.data) {
+		return nil, fmt.Errorf("%s: cell offset 0x%x out of range", h.path, off)
+	}
+	size := int32(binary.LittleEndian.Uint32(h.data[start:]))
+	if size < 0 {
+		size = -size
+	}
+	if size < 4 || start+int(size) > len(h.data) {
+		return nil, fmt.Errorf("%s: bad cell at 0x%x", h.path, off)
+	}
+	return h.data[start+4 : start+int(size)], nil
+}
+
+// key reads the key cell at an offset
+func (h *hive) key(off uint32) (*hiveKey, error) {
+	cell, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if len(cell) < 0x4c || string(cell[:2]) != "nk" {
+		return nil, fmt.Errorf("%s: cell 0x%x is not a key", h.path, off)
+	}
+	le := binary.LittleEndian
+	n := int(le.Uint16(cell[0x48:]))
+	if 0x4c+n > len(cell) {
+		return nil, fmt.Errorf("%s: bad key at 0x%x", h.path, off)
+	}
+	return &hiveKey{h: h, cell: cell, Name: hiveName(cell[0x4c:0x4c+n], le.Uint16(cell[2:])&0x20 != 0),
+		Modified: filetime(le.Uint64(cell[4:]))}, nil
+}
+
+// hiveName decodes a key or value name, stored as Latin-1 when compressed
+// and as UTF-16 otherwise
+func hiveName(b []byte, compressed bool) string {
+	if !compressed {
+		return utf16Text(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// open follows a backslash separated path of subkeys from the root key
+func (h *hive) open(path string) (*hiveKey, error) {
+	k, err := h.key(binary.LittleEndian.Uint32(h.data[0x24:]))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(strings.Trim(path, `\`), `\`) {
+		if name == "" {
+			continue
+		}
+		if k, err = k.subkey(name); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// subkeys returns the subkeys of a key
+func (k *hiveKey) subkeys() ([]*hiveKey, error) {
+	le := binary.LittleEndian
+	if le.Uint32(k.cell[0x14:]) == 0 {
+		return nil, nil
+	}
+	var offsets []uint32
+	var walk func(off uint32, depth int) error
+	walk = func(off uint32, depth int) error {
+		list, err := k.h.cell(off)
+		if err != nil {
+			return err
+		}
+		if len(list) < 4 || depth > 2 {
+			return fmt.Errorf("%s: bad subkey list under %s", k.h.path, k.Name)
+		}
+		n, stride := int(le.Uint16(list[2:])), 4
+		switch string(list[:2]) {
+		case "lf", "lh":
+			stride = 8
+		case "li":
+		case "ri":
+			for i := 0; i < n && 4+i*4+4 <= len(list); i++ {
+				if err := walk(le.Uint32(list[4+i*4:]), depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("%s: bad subkey list under %s", k.h.path, k.Name)
+		}
+		for i := 0; i < n && 4+i*stride+4 <= len(list); i++ {
+			offsets = append(offsets, le.Uint32(list[4+i*stride:]))
+		}
+		return nil
+	}
+	if err := walk(le.Uint32(k.cell[0x1c:]), 0); err != nil {
+		return nil, err
+	}
+	keys := make([]*hiveKey, 0, len(offsets))
+	for _, off := range offsets {
+		sub, err := k.h.key(off)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sub)
+	}
+	return keys, nil
+}
+
+// subkey returns the subkey of a key with a name, ignoring case
+func (k *hiveKey) subkey(name string) (*hiveKey, error) {
+	keys, err := k.subkeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range keys {
+		if strings.EqualFold(sub.Name, name) {
+			return sub, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: key %s\\%s not found", k.h.path, k.Name, name)
+}
+
+// value returns the type and data of the value of a key with a name,
+// ignoring case
+func (k *hiveKey) value(name string) (uint32, []byte, error) {
+	le := binary.LittleEndian
+	count := int(le.Uint32(k.cell[0x24:]))
+	if count > 0 {
+		list, err := k.h.cell(le.Uint32(k.cell[0x28:]))
+		if err != nil {
+			return 0, nil, err
+		}
+		for i := 0; i < count && i*4+4 <= len(list); i++ {
+			vk, err := k.h.cell(le.Uint32(list[i*4:]))
+			if err != nil {
+				return 0, nil, err
+			}
+			if len(vk) < 0x14 || string(vk[:2]) != "vk" {
+				continue
+			}
+			n := int(le.Uint16(vk[2:]))
+			if 0x14+n > len(vk) || !strings.EqualFold(hiveName(vk[0x14:0x14+n], le.Uint16(vk[0x10:])&1 != 0), name) {
+				continue
+			}
+			data, err := k.h.valueData(vk)
+			return le.Uint32(vk[0xc:]), data, err
+		}
+	}
+	return 0, nil, fmt.Errorf("%s: value %s\\%s not found", k.h.path, k.Name, name)
+}
+
+// valueData reads the data of a value: inline in the value cell when it is
+// four bytes or less, in one cell, or over the segments of a db record
+func (h *hive) valueData(vk []byte) ([]byte, error) {
+	le := binary.LittleEndian
+	size, off := le.Uint32(vk[4:]), le.Uint32(vk[8:])
+	if size&0x80000000 != 0 {
+		size &^= 0x80000000
+		if size > 4 {
+			size = 4
+		}
+		return vk[8 : 8+size], nil
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	cell, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if int(size) <= len(cell) && !(size > bigDataSegment && bytes.HasPrefix(cell, []byte("db"))) {
+		return cell[:size], nil
+	}
+	if len(cell) < 8 || string(cell[:2]) != "db" {
+		return nil, fmt.Errorf("%s: bad value data at 0x%x", h.path, off)
+	}
+	segments, err := h.cell(le.Uint32(cell[4:]))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, size)
+	for i := 0; i < int(le.Uint16(cell[2:])) && i*4+4 <= len(segments) && len(data) < int(size); i++ {
+		segment, err := h.cell(le.Uint32(segments[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		if len(segment) > bigDataSegment {
+			segment = segment[:bigDataSegment]
+		}
+		data = append(data, segment...)
+	}
+	if len(data) < int(size) {
+		return nil, fmt.Errorf("%s: truncated value data at 0x%x", h.path, off)
+	}
+	return data[:size], nil
+}
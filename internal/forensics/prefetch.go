@@ -0,0 +1,254 @@
+package forensics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Prefetch reads the Windows prefetch files under the root, or the one at
+// Path. Each records the last times an executable ran, up to eight of
+// them, which become one execution record each, along with its run count
+// and the files it loaded.
+func Prefetch(opts Options) ([]*Record, error) {
+	paths := []string{opts.Path}
+	if opts.Path == "" {
+		paths = glob(opts.root(), "Windows/Prefetch/*.pf")
+	}
+	var records []*Record
+	for _, path := range paths {
+		found, err := readPrefetch(path)
+		if err != nil {
+			if opts.Path != "" {
+				return nil, err
+			}
+			// One damaged file does not hide the others
+			continue
+		}
+		records = append(records, found...)
+	}
+	SortRecords(records)
+	return records, nil
+}
+
+// readPrefetch reads one prefetch file
+func readPrefetch(path string) ([]*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Windows 10 and later compress prefetch files with Xpress Huffman
+	if len(data) >= 8 && bytes.HasPrefix(data, []byte("MAM")) {
+		if data[3]&0x0f != 4 {
+			return nil, fmt.Errorf("%s: unsupported compression %d", path, data[3]&0x0f)
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		start := 8
+		if data[3]&0x80 != 0 {
+			// A CRC32 of the file follows the size
+			start = 12
+		}
+		if start > len(data) {
+			return nil, fmt.Errorf("%s: truncated prefetch file", path)
+		}
+		if data, err = xpressHuffmanDecompress(data[start:], size); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if len(data) < 0x100 || string(data[4:8]) != "SCCA" {
+		return nil, fmt.Errorf("%s is not a prefetch file", path)
+	}
+	le := binary.LittleEndian
+	version := le.Uint32(data)
+	var runTimes []uint64
+	var runCount uint32
+	switch version {
+	case 17:
+		runTimes = []uint64{le.Uint64(data[0x78:])}
+		runCount = le.Uint32(data[0x90:])
+	case 23:
+		runTimes = []uint64{le.Uint64(data[0x80:])}
+		runCount = le.Uint32(data[0x98:])
+	case 26, 30, 31:
+		for i := 0; i < 8; i++ {
+			runTimes = append(runTimes, le.Uint64(data[0x80+i*8:]))
+		}
+		runCount = le.Uint32(data[0xd0:])
+		// The second Windows 10 layout has a shorter header, known by where
+		// its file metrics start
+		if le.Uint32(data[0x54:]) == 0x128 {
+			runCount = le.Uint32(data[0xc8:])
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported prefetch version %d", path, version)
+	}
+	executable := utf16String(data[0x10 : 0x10+60])
+	hash := fmt.Sprintf("%08X", le.Uint32(data[0x4c:]))
+	var files []interface{}
+	offset, size := int(le.Uint32(data[0x64:])), int(le.Uint32(data[0x68:]))
+	if offset > 0 && offset+size <= len(data) {
+		for _, name := range strings.Split(utf16Text(data[offset:offset+size]), "\x00") {
+			if name != "" {
+				files = append(files, name)
+			}
+		}
+	}
+	var records []*Record
+	for i, ft := range runTimes {
+		at := filetime(ft)
+		if at.IsZero() {
+			continue
+		}
+		records = append(records, &Record{Time: at, Source: "prefetch", Type: "execution", Path: path, Summary: executable,
+			Data: map[string]interface{}{
+				"executable": executable,
+				"hash":       hash,
+				"run_count":  int(runCount),
+				"run":        i + 1,
+				"version":    int(version),
+				"files":      files,
+			}})
+	}
+	return records, nil
+}
+
+// utf16Text decodes little-endian UTF-16
+func utf16Text(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// utf16String decodes a NUL-terminated little-endian UTF-16 string
+func utf16String(data []byte) string {
+	s := utf16Text(data)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// errXpressCorrupt is returned for compressed data that does not decode
+var errXpressCorrupt = errors.New("corrupt Xpress Huffman data")
+
+// xpressHuffmanDecompress decompresses LZ77+Huffman data as described in
+// MS-XCA. Every 64KB of output has its own Huffman table, 512 four-bit code
+// lengths for the literal bytes and the match symbols, and the bits of the
+// codes come in 16-bit little-endian words, most significant first. The
+// extra length bytes of long matches sit between the words.
+func xpressHuffmanDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	pos := 0
+	word := func() uint32 {
+		if pos+1 >= len(in) {
+			pos += 2
+			return 0
+		}
+		w := uint32(binary.LittleEndian.Uint16(in[pos:]))
+		pos += 2
+		return w
+	}
+	for len(out) < size {
+		if pos+256 > len(in) {
+			return nil, errXpressCorrupt
+		}
+		var lengths [512]uint8
+		for i := 0; i < 256; i++ {
+			lengths[2*i] = in[pos+i] & 0x0f
+			lengths[2*i+1] = in[pos+i] >> 4
+		}
+		pos += 256
+		table, err := huffmanTable(lengths[:])
+		if err != nil {
+			return nil, err
+		}
+		bits := word()<<16 | word()
+		extra := 16
+		consume := func(n int) {
+			bits <<= uint(n)
+			extra -= n
+			if extra < 0 {
+				bits |= word() << uint(-extra)
+				extra += 16
+			}
+		}
+		blockEnd := len(out) + 65536
+		for len(out) < blockEnd && len(out) < size {
+			if pos > len(in)+4 {
+				return nil, errXpressCorrupt
+			}
+			symbol := int(table[bits>>17])
+			consume(int(lengths[symbol]))
+			if symbol < 256 {
+				out = append(out, byte(symbol))
+				continue
+			}
+			symbol -= 256
+			length, offsetBits := symbol&15, symbol>>4
+			if length == 15 {
+				if pos >= len(in) {
+					return nil, errXpressCorrupt
+				}
+				length = int(in[pos])
+				pos++
+				if length == 255 {
+					if pos+1 >= len(in) {
+						return nil, errXpressCorrupt
+					}
+					length = int(binary.LittleEndian.Uint16(in[pos:]))
+					pos += 2
+					if length < 15 {
+						return nil, errXpressCorrupt
+					}
+					length -= 15
+				}
+				length += 15
+			}
+			length += 3
+			offset := 1 << uint(offsetBits)
+			if offsetBits > 0 {
+				offset += int(bits >> uint(32-offsetBits))
+			}
+			consume(offsetBits)
+			if offset > len(out) {
+				return nil, errXpressCorrupt
+			}
+			for i := 0; i < length && len(out) < size; i++ {
+				out = append(out, out[len(out)-offset])
+			}
+		}
+	}
+	return out, nil
+}
+
+// huffmanTable builds the table that maps the next 15 bits of input to the
+// symbol whose canonical code they start with
+func huffmanTable(lengths []uint8) ([]uint16, error) {
+	table := make([]uint16, 1<<15)
+	code := 0
+	for length := 1; length <= 15; length++ {
+		for symbol, l := range lengths {
+			if int(l) != length {
+				continue
+			}
+			span := 1 << uint(15-length)
+			if code+span > len(table) {
+				return nil, errXpressCorrupt
+			}
+			for i := code; i < code+span; i++ {
+				table[i] = uint16(symbol)
+			}
+			code += span
+		}
+	}
+	if code == 0 {
+		return nil, errXpressCorrupt
+	}
+	return table, nil
+}
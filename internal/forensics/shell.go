@@ -0,0 +1,182 @@
+package forensics
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shellHistories are the history files of each shell under a home directory
+var shellHistories = []struct {
+	Shell   string
+	Pattern string
+}{
+	{"bash", ".bash_history"},
+	{"zsh", ".zsh_history"},
+	{"zsh", ".zhistory"},
+	{"sh", ".sh_history"},
+	{"fish", ".local/share/fish/fish_history"},
+	{"powershell", ".local/share/powershell/PSReadLine/ConsoleHost_history.txt"},
+	{"powershell", "AppData/Roaming/Microsoft/Windows/PowerShell/PSReadLine/ConsoleHost_history.txt"},
+}
+
+// ShellHistory reads the commands in the bash, zsh, fish and PowerShell
+// histories of every user under the root, or in the history file at Path.
+// Commands carry a time when the shell recorded one: bash with
+// HISTTIMEFORMAT set, zsh with extended history, and fish.
+func ShellHistory(opts Options) ([]*Record, error) {
+	if opts.Path != "" {
+		return readShellHistory(opts.Path, shellOf(opts.Path), "")
+	}
+	var records []*Record
+	for _, h := range homes(opts.root()) {
+		for _, s := range shellHistories {
+			for _, path := range glob(h.Dir, s.Pattern) {
+				found, err := readShellHistory(path, s.Shell, h.User)
+				if err != nil {
+					return records, err
+				}
+				records = append(records, found...)
+			}
+		}
+	}
+	SortRecords(records)
+	return records, nil
+}
+
+// shellOf tells the shell of a history file by its name
+func shellOf(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.Contains(name, "zsh") || name == ".zhistory":
+		return "zsh"
+	case strings.Contains(name, "fish"):
+		return "fish"
+	case strings.HasPrefix(name, "consolehost"):
+		return "powershell"
+	case strings.Contains(name, "bash"):
+		return "bash"
+	}
+	return "sh"
+}
+
+// readShellHistory reads one history file
+func readShellHistory(path, shell, user string) ([]*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	add := func(t time.Time, command string) {
+		records = append(records, &Record{Time: t, Source: shell, Type: "command", User: user, Path: path, Summary: command,
+			Data: map[string]interface{}{"command": command, "shell": shell}})
+	}
+	switch shell {
+	case "zsh":
+		readZshHistory(unmetafy(data), add)
+	case "fish":
+		readFishHistory(data, add)
+	default:
+		// bash writes "#<epoch>" before each command when HISTTIMEFORMAT is set
+		var at time.Time
+		for _, line := range lines(data) {
+			if strings.HasPrefix(line, "#") {
+				if secs, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+					at = time.Unix(secs, 0).UTC()
+					continue
+				}
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			add(at, line)
+			at = time.Time{}
+		}
+	}
+	return records, nil
+}
+
+// readZshHistory reads zsh history, whose extended entries look like
+// ": <start>:<elapsed>;<command>" and whose multi-line commands end their
+// lines with a backslash
+func readZshHistory(data []byte, add func(time.Time, string)) {
+	all := lines(data)
+	for i := 0; i < len(all); i++ {
+		line := all[i]
+		for strings.HasSuffix(line, `\`) && i+1 < len(all) {
+			i++
+			line = line[:len(line)-1] + "\n" + all[i]
+		}
+		var at time.Time
+		if strings.HasPrefix(line, ": ") {
+			if semi := strings.IndexByte(line, ';'); semi > 0 {
+				stamp := line[2:semi]
+				if colon := strings.IndexByte(stamp, ':'); colon > 0 {
+					stamp = stamp[:colon]
+				}
+				if secs, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+					at = time.Unix(secs, 0).UTC()
+					line = line[semi+1:]
+				}
+			}
+		}
+		if strings.TrimSpace(line) != "" {
+			add(at, line)
+		}
+	}
+}
+
+// unmetafy undoes zsh's escaping of bytes it treats specially in history
+// files: 0x83 followed by the byte XOR 0x20
+func unmetafy(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x83 && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// readFishHistory reads fish history, a YAML-like list of "- cmd:" entries
+// followed by their "when:" times
+func readFishHistory(data []byte, add func(time.Time, string)) {
+	var command string
+	var at time.Time
+	pending := false
+	flush := func() {
+		if pending {
+			add(at, command)
+		}
+		pending, command, at = false, "", time.Time{}
+	}
+	for _, line := range lines(data) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			flush()
+			command = strings.NewReplacer(`\n`, "\n", `\\`, `\`).Replace(strings.TrimPrefix(line, "- cmd: "))
+			pending = true
+		case strings.HasPrefix(trimmed, "when: "):
+			if secs, err := strconv.ParseInt(strings.TrimPrefix(trimmed, "when: "), 10, 64); err == nil {
+				at = time.Unix(secs, 0).UTC()
+			}
+		}
+	}
+	flush()
+}
+
+// lines splits text into lines, dropping carriage returns
+func lines(data []byte) []string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,166 @@
+package forensics
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Shimcache reads the application compatibility cache from the SYSTEM
+// hive under the root, or the hive at Path. Each entry is a program
+// Windows checked for compatibility shims, most recent first, with the
+// modification time of the file rather than a time it ran; on Windows 7
+// and 8 an entry also tells whether the program was executed.
+func Shimcache(opts Options) ([]*Record, error) {
+	path := opts.Path
+	if path == "" {
+		matches := glob(opts.root(), "Windows/System32/config/SYSTEM")
+		if len(matches) == 0 {
+			return nil, nil
+		}
+		path = matches[0]
+	}
+	h, err := openHive(path)
+	if err != nil {
+		return nil, err
+	}
+	current := 1
+	if sel, err := h.open(`Select`); err == nil {
+		if _, data, err := sel.value("Current"); err == nil && len(data) >= 4 {
+			current = int(binary.LittleEndian.Uint32(data))
+		}
+	}
+	key, err := h.open(fmt.Sprintf(`ControlSet%03d\Control\Session Manager\AppCompatCache`, current))
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := key.value("AppCompatCache")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseShimcache(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	records := make([]*Record, 0, len(entries))
+	for i, e := range entries {
+		e.path = strings.TrimPrefix(e.path, `\??\`)
+		e.data["position"] = i + 1
+		e.data["path"] = e.path
+		records = append(records, &Record{Time: filetime(e.modified), Source: "shimcache", Type: "file", Path: path,
+			Summary: e.path, Data: e.data})
+	}
+	return records, nil
+}
+
+// shimcacheEntry is an entry of the cache
+type shimcacheEntry struct {
+	path     string
+	modified uint64
+	data     map[string]interface{}
+}
+
+// parseShimcache reads the entries of an AppCompatCache value in the
+// formats of Windows 7 to 11
+func parseShimcache(data []byte) ([]*shimcacheEntry, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 {
+		return nil, fmt.Errorf("AppCompatCache is too short")
+	}
+	switch header := le.Uint32(data); {
+	case (header == 0x30 || header == 0x34) && int(header) <= len(data):
+		// Windows 10 and 11
+		return parseShimcacheEntries(data[header:], false)
+	case len(data) >= 0x84 && (string(data[0x80:0x84]) == "00ts" || string(data[0x80:0x84]) == "10ts"):
+		// Windows 8 and 8.1
+		return parseShimcacheEntries(data[0x80:], true)
+	case header == 0xbadc0fee:
+		return parseShimcache7(data)
+	}
+	return nil, fmt.Errorf("unsupported AppCompatCache format 0x%x", le.Uint32(data))
+}
+
+// parseShimcacheEntries reads the tagged entries of Windows 8 and later.
+// Windows 8 entries also hold a package name and the flags telling whether
+// a program ran.
+func parseShimcacheEntries(data []byte, windows8 bool) ([]*shimcacheEntry, error) {
+	le := binary.LittleEndian
+	var entries []*shimcacheEntry
+	for len(data) >= 12 {
+		if sig := string(data[:4]); sig != "10ts" && sig != "00ts" {
+			break
+		}
+		size := int(le.Uint32(data[8:]))
+		if 12+size > len(data) || size < 2 {
+			return entries, fmt.Errorf("truncated AppCompatCache entry")
+		}
+		body := data[12 : 12+size]
+		data = data[12+size:]
+		pos := 0
+		field := func(n int) []byte {
+			if pos+n > len(body) {
+				pos = len(body) + 1
+				return make([]byte, n)
+			}
+			pos += n
+			return body[pos-n : pos]
+		}
+		n := int(le.Uint16(field(2)))
+		e := &shimcacheEntry{path: utf16Text(field(n)), data: map[string]interface{}{}}
+		if windows8 {
+			if pkg := utf16Text(field(int(le.Uint16(field(2))))); pkg != "" {
+				e.data["package"] = pkg
+			}
+			e.data["executed"] = le.Uint32(field(4))&2 != 0
+			field(4)
+		}
+		e.modified = le.Uint64(field(8))
+		if pos > len(body) {
+			return entries, fmt.Errorf("truncated AppCompatCache entry")
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// parseShimcache7 reads the Windows 7 cache, a table of fixed size entries
+// after a 128 byte header pointing at their paths. The entries of 64-bit
+// Windows are 48 bytes and those of 32-bit Windows 32, told apart by the
+// padding after the path lengths.
+func parseShimcache7(data []byte) ([]*shimcacheEntry, error) {
+	le := binary.LittleEndian
+	count := int(le.Uint32(data[4:]))
+	if len(data) < 0x80+8 {
+		return nil, fmt.Errorf("truncated AppCompatCache")
+	}
+	x64 := le.Uint32(data[0x84:]) == 0
+	size := 32
+	if x64 {
+		size = 48
+	}
+	var entries []*shimcacheEntry
+	for i := 0; i < count; i++ {
+		at := 0x80 + i*size
+		if at+size > len(data) {
+			return entries, fmt.Errorf("truncated AppCompatCache")
+		}
+		entry := data[at : at+size]
+		n := int(le.Uint16(entry))
+		var pathOff int
+		var rest []byte
+		if x64 {
+			pathOff, rest = int(le.Uint64(entry[8:])), entry[16:]
+		} else {
+			pathOff, rest = int(le.Uint32(entry[4:])), entry[8:]
+		}
+		if pathOff < 0 || pathOff+n > len(data) {
+			return entries, fmt.Errorf("AppCompatCache path out of range")
+		}
+		entries = append(entries, &shimcacheEntry{
+			path:     strings.TrimRight(utf16Text(data[pathOff:pathOff+n]), "\x00"),
+			modified: le.Uint64(rest),
+			data:     map[string]interface{}{"executed": le.Uint32(rest[8:])&2 != 0},
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,200 @@
+package forensics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ScheduledTasks reads the cron jobs and Windows scheduled tasks under the
+// root, or the crontab or task file at Path. Jobs carry the time their file
+// was last changed, and tasks the date they were registered when they have
+// one.
+func ScheduledTasks(opts Options) ([]*Record, error) {
+	if opts.Path != "" {
+		data, err := os.ReadFile(opts.Path)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(decodeText(data), []byte("<Task")) {
+			r, err := readTask(opts.Path, "")
+			if err != nil {
+				return nil, err
+			}
+			return []*Record{r}, nil
+		}
+		return readCrontab(opts.Path, filepath.Base(opts.Path), !strings.Contains(filepath.ToSlash(opts.Path), "/spool/")), nil
+	}
+	root := opts.root()
+	var records []*Record
+	// System crontabs name the user of each job; user crontabs are named
+	// after their owner
+	for _, path := range glob(root, "etc/crontab", "etc/cron.d/*") {
+		records = append(records, readCrontab(path, "", true)...)
+	}
+	for _, path := range glob(root, "var/spool/cron/crontabs/*", "var/spool/cron/*", "usr/lib/cron/tabs/*") {
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			records = append(records, readCrontab(path, filepath.Base(path), false)...)
+		}
+	}
+	for _, dir := range []string{"cron.hourly", "cron.daily", "cron.weekly", "cron.monthly"} {
+		for _, path := range glob(root, "etc/"+dir+"/*") {
+			records = append(records, &Record{Time: fileTime(path), Source: "cron", Type: "scheduled", User: "root", Path: path,
+				Summary: path, Data: map[string]interface{}{"schedule": strings.TrimPrefix(dir, "cron."), "command": path}})
+		}
+	}
+	tasks := filepath.Join(root, "Windows", "System32", "Tasks")
+	filepath.WalkDir(tasks, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if r, err := readTask(path, tasks); err == nil {
+			records = append(records, r)
+		}
+		return nil
+	})
+	SortRecords(records)
+	return records, nil
+}
+
+// readCrontab reads the jobs of a crontab. The lines of system crontabs
+// have a user field between the schedule and the command.
+func readCrontab(path, user string, system bool) []*Record {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	changed := fileTime(path)
+	var records []*Record
+	for _, line := range lines(data) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		n := 5
+		if strings.HasPrefix(fields[0], "@") {
+			n = 1
+		} else if strings.Contains(fields[0], "=") {
+			// An environment setting such as SHELL=/bin/sh or MAILTO=""
+			continue
+		}
+		if system {
+			n++
+		}
+		if len(fields) <= n {
+			continue
+		}
+		schedule := strings.Join(fields[:n], " ")
+		owner := user
+		if system {
+			owner = fields[n-1]
+			schedule = strings.Join(fields[:n-1], " ")
+		}
+		command := strings.Join(fields[n:], " ")
+		records = append(records, &Record{Time: changed, Source: "cron", Type: "scheduled", User: owner, Path: path, Summary: command,
+			Data: map[string]interface{}{"schedule": schedule, "command": command, "line": line}})
+	}
+	return records
+}
+
+// task is the part of a Windows task definition the collector reads
+type task struct {
+	Date        string `xml:"RegistrationInfo>Date"`
+	Author      string `xml:"RegistrationInfo>Author"`
+	Description string `xml:"RegistrationInfo>Description"`
+	URI         string `xml:"RegistrationInfo>URI"`
+	UserID      string `xml:"Principals>Principal>UserId"`
+	RunLevel    string `xml:"Principals>Principal>RunLevel"`
+	Hidden      bool   `xml:"Settings>Hidden"`
+	Enabled     string `xml:"Settings>Enabled"`
+	Triggers    struct {
+		Triggers []struct {
+			XMLName       xml.Name
+			StartBoundary string `xml:"StartBoundary"`
+		} `xml:",any"`
+	} `xml:"Triggers"`
+	Actions []struct {
+		Command   string `xml:"Command"`
+		Arguments string `xml:"Arguments"`
+	} `xml:"Actions>Exec"`
+}
+
+// readTask reads a Windows task definition, named by its path under the
+// Tasks directory
+func readTask(path, dir string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t task
+	decoder := xml.NewDecoder(bytes.NewReader(decodeText(data)))
+	// The text has been decoded to UTF-8 whatever the declaration says
+	decoder.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := decoder.Decode(&t); err != nil {
+		return nil, err
+	}
+	name := t.URI
+	if name == "" {
+		if rel, err := filepath.Rel(dir, path); err == nil && dir != "" {
+			name = `\` + strings.ReplaceAll(filepath.ToSlash(rel), "/", `\`)
+		} else {
+			name = filepath.Base(path)
+		}
+	}
+	var commands []string
+	for _, a := range t.Actions {
+		commands = append(commands, strings.TrimSpace(a.Command+" "+a.Arguments))
+	}
+	var triggers []string
+	for _, tr := range t.Triggers.Triggers {
+		triggers = append(triggers, strings.TrimSpace(tr.XMLName.Local+" "+tr.StartBoundary))
+	}
+	at := fileTime(path)
+	if t.Date != "" {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.9999999", "2006-01-02T15:04:05"} {
+			if d, err := time.Parse(layout, t.Date); err == nil {
+				at = d.UTC()
+				break
+			}
+		}
+	}
+	command := strings.Join(commands, "; ")
+	return &Record{Time: at, Source: "windows_task", Type: "scheduled", User: t.UserID, Path: path, Summary: name + ": " + command,
+		Data: map[string]interface{}{
+			"name":        name,
+			"command":     command,
+			"schedule":    strings.Join(triggers, "; "),
+			"author":      t.Author,
+			"description": t.Description,
+			"run_level":   t.RunLevel,
+			"hidden":      t.Hidden,
+			"enabled":     t.Enabled != "false",
+		}}, nil
+}
+
+// decodeText returns text as UTF-8, converting it from UTF-16 when it
+// starts with a byte order mark, as Windows task files do
+func decodeText(data []byte) []byte {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	default:
+		return bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf})
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+	return []byte(string(utf16.Decode(units)))
+}
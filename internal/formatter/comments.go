@@ -0,0 +1,186 @@
+package formatter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+)
+
+// WithComments makes the formatter keep the comments of the source the
+// statements were parsed from. The parser's position tables decide where
+// each comment goes:
+//
+//   - comments on their own lines stay before the statement that follows
+//     them, or before the closing brace of the block they end
+//   - a comment after code stays at the end of that statement's last line,
+//     or after the opening brace of the block started on that line
+//
+// Comments inside a multi-line expression are moved below its statement.
+// Blank lines next to statements and comments are kept, at most one in a
+// row.
+func (f *Formatter) WithComments(comments []lexer.Token, p *parser.Parser) *Formatter {
+	f.comments = comments
+	f.starts = p.StmtLines()
+	f.ends = p.StmtEnds()
+	f.blocks = p.BlockEnds()
+	f.startLines = f.startLines[:0]
+	for _, line := range f.starts {
+		f.startLines = append(f.startLines, line)
+	}
+	sort.Ints(f.startLines)
+	return f
+}
+
+// simple reports whether no other statement starts inside lines
+// (start, end], so comments in that range belong to no inner block
+func (f *Formatter) simple(start, end int) bool {
+	i := sort.SearchInts(f.startLines, start+1)
+	return i == len(f.startLines) || f.startLines[i] > end
+}
+
+// blockEnd returns the closing brace line of the i-th block of node, or 0
+// when it is not known
+func (f *Formatter) blockEnd(node interface{}, i int) int {
+	if ends := f.blocks[node]; i < len(ends) {
+		return ends[i]
+	}
+	return 0
+}
+
+func (f *Formatter) pending() (lexer.Token, bool) {
+	if len(f.queue) > 0 {
+		return f.queue[0], true
+	}
+	return lexer.Token{}, false
+}
+
+// commentBefore reports whether a comment not written yet comes before line
+func (f *Formatter) commentBefore(line int) bool {
+	c, ok := f.pending()
+	return ok && c.Line < line
+}
+
+// atBlockStart reports whether the output ends with an opening brace or a
+// blank line, where another blank line is never wanted
+func (f *Formatter) atBlockStart() bool {
+	out := f.output.String()
+	return out == "" || strings.HasSuffix(out, "{\n") || strings.HasSuffix(out, "\n\n")
+}
+
+// gap writes a blank line if the source had one before line
+func (f *Formatter) gap(line int) {
+	if f.starts != nil && f.prevLine > 0 && line > f.prevLine+1 && !f.atBlockStart() {
+		f.newline()
+	}
+}
+
+// flushComments writes the comments before line, each on its own line at
+// the current indentation
+func (f *Formatter) flushComments(line int) {
+	for {
+		c, ok := f.pending()
+		if !ok || c.Line >= line {
+			return
+		}
+		if c.Line > f.prevLine {
+			f.gap(c.Line)
+		}
+		f.writeIndent()
+		f.write(c.Lexeme)
+		f.newline()
+		f.prevLine = c.Line
+		f.queue = f.queue[1:]
+	}
+}
+
+// headerComment writes a comment that follows the opening brace of a block
+// on the header line, as in "if err {  // unreachable". The block's
+// content, its first statement or else its closing brace on line end, must
+// start on a later line.
+func (f *Formatter) headerComment(header int, body []parser.Stmt, end int) {
+	if header == 0 {
+		return
+	}
+	first := end
+	if len(body) > 0 {
+		first = f.starts[body[0]]
+	}
+	if c, ok := f.pending(); ok && c.Line == header && first > header {
+		f.write(" " + c.Lexeme)
+		f.queue = f.queue[1:]
+	}
+	f.prevLine = header
+}
+
+// finishLine ends a statement whose last source line is end, keeping a
+// comment on that line at the end of it. Comments from inside the
+// statement that were not written yet stay queued and are written before
+// whatever follows.
+func (f *Formatter) finishLine(end int) {
+	if end > 0 && end != f.closeLine {
+		for i, c := range f.queue {
+			if c.Line > end {
+				break
+			}
+			if c.Line == end {
+				f.write(" " + c.Lexeme)
+				f.queue = append(f.queue[:i:i], f.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	f.newline()
+	if end > 0 {
+		f.prevLine = end
+	}
+}
+
+// FormatSource formats a whole file, keeping its comments. The result is
+// only returned if it parses, formats to itself again and still has every
+// comment, so a formatter bug can never corrupt a file.
+func FormatSource(source, filename string, config Config) (string, error) {
+	parse := func(source string) (stmts []parser.Stmt, p *parser.Parser, comments []lexer.Token, err error) {
+		scanner := lexer.NewScannerWithFile(source, filename)
+		tokens := scanner.ScanTokens()
+		if scanner.HadError() {
+			return nil, nil, nil, fmt.Errorf("cannot format file with syntax errors")
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("cannot format file with syntax errors: %v", r)
+			}
+		}()
+		p = parser.NewParserWithSource(tokens, source, filename)
+		stmts = p.Parse()
+		if len(p.Errors) > 0 {
+			return nil, nil, nil, p.Errors[0]
+		}
+		return stmts, p, scanner.Comments(), nil
+	}
+
+	stmts, p, comments, err := parse(source)
+	if err != nil {
+		return "", err
+	}
+	formatted := NewFormatterWithConfig(config).WithComments(comments, p).Format(stmts)
+
+	stmts, p, after, err := parse(formatted)
+	if err != nil {
+		return "", fmt.Errorf("formatter produced invalid code, file left unchanged: %v", err)
+	}
+	if len(after) != len(comments) {
+		return "", fmt.Errorf("formatter lost comments, file left unchanged")
+	}
+	for i := range after {
+		if after[i].Lexeme != comments[i].Lexeme {
+			return "", fmt.Errorf("formatter moved comment on line %d, file left unchanged", comments[i].Line)
+		}
+	}
+	if NewFormatterWithConfig(config).WithComments(after, p).Format(stmts) != formatted {
+		return "", fmt.Errorf("formatter output is not stable, file left unchanged")
+	}
+	return formatted, nil
+}
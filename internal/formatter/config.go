@@ -0,0 +1,121 @@
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"sentra/internal/packages"
+)
+
+// ConfigFileName is the per-directory formatter configuration file
+const ConfigFileName = ".sentrafmt"
+
+// Brace styles
+const (
+	BraceSameLine = "same_line" // fn main() {
+	BraceNextLine = "next_line" // fn main()\n{
+)
+
+// Config controls the output of the formatter
+type Config struct {
+	IndentWidth    int    // Spaces per indentation level
+	MaxLineLength  int    // Calls, arrays and maps longer than this are wrapped; 0 disables wrapping
+	TrailingCommas bool   // Add a comma after the last element of wrapped arrays and maps
+	BraceStyle     string // BraceSameLine or BraceNextLine
+}
+
+// DefaultConfig returns the standard Sentra style
+func DefaultConfig() Config {
+	return Config{
+		IndentWidth:    4,
+		MaxLineLength:  100,
+		TrailingCommas: true,
+		BraceStyle:     BraceSameLine,
+	}
+}
+
+// LoadConfig finds the configuration that applies to path. It walks up from
+// the file's directory and uses the first .sentrafmt found, or the [fmt]
+// section of sentra.toml at the project root. Defaults are used for
+// anything not set.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	dir := filepath.Dir(abs)
+	root := packages.FindProjectRoot(dir)
+	for {
+		if file := filepath.Join(dir, ConfigFileName); fileExists(file) {
+			return cfg, cfg.apply(file, "", "fmt")
+		}
+		if dir == root {
+			if file := filepath.Join(dir, "sentra.toml"); fileExists(file) {
+				return cfg, cfg.apply(file, "fmt")
+			}
+			return cfg, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return cfg, nil
+		}
+		dir = parent
+	}
+}
+
+// apply reads the given sections of a TOML-style file into cfg
+func (cfg *Config) apply(file string, sections ...string) error {
+	project, err := packages.ParseProjectFile(file)
+	if err != nil {
+		return err
+	}
+	for _, section := range sections {
+		for key, value := range project.Sections[section] {
+			if err := cfg.Set(key, value); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Set assigns one configuration option by its file key
+func (cfg *Config) Set(key, value string) error {
+	switch key {
+	case "indent_width":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 16 {
+			return fmt.Errorf("indent_width must be a number from 1 to 16, got %q", value)
+		}
+		cfg.IndentWidth = n
+	case "max_line_length":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("max_line_length must be a non-negative number, got %q", value)
+		}
+		cfg.MaxLineLength = n
+	case "trailing_commas":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("trailing_commas must be true or false, got %q", value)
+		}
+		cfg.TrailingCommas = b
+	case "brace_style":
+		if value != BraceSameLine && value != BraceNextLine {
+			return fmt.Errorf("brace_style must be %q or %q, got %q", BraceSameLine, BraceNextLine, value)
+		}
+		cfg.BraceStyle = value
+	default:
+		return fmt.Errorf("unknown formatter option %q", key)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
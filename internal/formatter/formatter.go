@@ -1,22 +1,50 @@
 package formatter
 
 import (
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
+
+	"sentra/internal/lexer"
 	"sentra/internal/parser"
 )
 
+// Formatter prints a parsed program back as source in the canonical Sentra
+// style. Formatting is idempotent: formatting the output again yields the
+// same text, because the layout depends only on the syntax tree, its
+// comments and the configuration.
 type Formatter struct {
-	indent      int
-	indentStr   string
-	output      strings.Builder
-	lineBreak   string
+	config    Config
+	indent    int
+	indentStr string
+	output    strings.Builder
+	lineBreak string
+	col       int // Column of the next write on the current line
+
+	// Source positions, set by WithComments
+	comments   []lexer.Token
+	queue      []lexer.Token // Comments not written yet
+	starts     map[parser.Stmt]int
+	ends       map[parser.Stmt]int
+	blocks     map[interface{}][]int
+	startLines []int // Sorted values of starts
+	prevLine   int   // Source line of the last statement, brace or comment written
+	closeLine  int   // Closing brace line of the block being written
+	continued  bool  // An else, catch or finally was just written
 }
 
 func NewFormatter() *Formatter {
+	return NewFormatterWithConfig(DefaultConfig())
+}
+
+func NewFormatterWithConfig(config Config) *Formatter {
+	if config.IndentWidth < 1 {
+		config.IndentWidth = DefaultConfig().IndentWidth
+	}
 	return &Formatter{
-		indent:    0,
-		indentStr: "    ", // 4 spaces
+		config:    config,
+		indentStr: strings.Repeat(" ", config.IndentWidth),
 		lineBreak: "\n",
 	}
 }
@@ -24,41 +52,184 @@ func NewFormatter() *Formatter {
 func (f *Formatter) Format(stmts []parser.Stmt) string {
 	f.output.Reset()
 	f.indent = 0
-	
+	f.col = 0
+	f.queue = append([]lexer.Token(nil), f.comments...)
+	f.prevLine = 0
+
 	for i, stmt := range stmts {
 		f.formatStmt(stmt)
 		if i < len(stmts)-1 {
 			// Add blank line between top-level statements if needed
 			if f.needsBlankLine(stmt, stmts[i+1]) {
-				f.output.WriteString(f.lineBreak)
+				f.newline()
 			}
 		}
 	}
-	
+	f.flushComments(int(^uint(0) >> 1))
+
 	return f.output.String()
 }
 
 func (f *Formatter) needsBlankLine(curr, next parser.Stmt) bool {
 	// Add blank line between function definitions
-	_, currIsFunc := curr.(*parser.FunctionStmt)
-	_, nextIsFunc := next.(*parser.FunctionStmt)
-	if currIsFunc || nextIsFunc {
+	if isFunction(curr) || isFunction(next) {
 		return true
 	}
-	
+
 	// Add blank line between imports and other code
 	_, currIsImport := curr.(*parser.ImportStmt)
 	_, nextIsImport := next.(*parser.ImportStmt)
 	if currIsImport && !nextIsImport {
 		return true
 	}
-	
+
+	return false
+}
+
+func isFunction(stmt parser.Stmt) bool {
+	if exp, ok := stmt.(*parser.ExportStmt); ok {
+		stmt = exp.Stmt
+	}
+	switch stmt.(type) {
+	case *parser.FunctionStmt, *parser.ClassStmt:
+		return true
+	}
 	return false
 }
 
+// write appends s to the output and keeps track of the column
+func (f *Formatter) write(s string) {
+	f.output.WriteString(s)
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		f.col = len(s) - i - 1
+	} else {
+		f.col += len(s)
+	}
+}
+
+func (f *Formatter) newline() {
+	f.write(f.lineBreak)
+}
+
 func (f *Formatter) writeIndent() {
 	for i := 0; i < f.indent; i++ {
-		f.output.WriteString(f.indentStr)
+		f.write(f.indentStr)
+	}
+}
+
+// openBrace starts a statement block according to the brace style. header
+// is the source line the block starts on.
+func (f *Formatter) openBrace(header int, body []parser.Stmt, end int) {
+	if f.config.BraceStyle == BraceNextLine {
+		// Comments stay on the line with the keyword, and after else or
+		// catch, where that line is not known, they move into the block
+		if !f.continued {
+			f.headerComment(header, body, end)
+		}
+		f.continued = false
+		f.newline()
+		f.writeIndent()
+		f.write("{")
+	} else {
+		f.write(" {")
+		f.headerComment(header, body, end)
+	}
+	f.newline()
+}
+
+// continueBlock writes the keyword that follows a closing brace, such as
+// else, catch or finally
+func (f *Formatter) continueBlock(keyword string) {
+	if f.config.BraceStyle == BraceNextLine {
+		f.newline()
+		f.writeIndent()
+		f.write(keyword)
+		f.continued = true
+	} else {
+		f.write(" " + keyword)
+	}
+}
+
+// block writes statements one level deeper followed by the closing brace,
+// which is on line end in the source
+func (f *Formatter) block(stmts []parser.Stmt, end int) {
+	saved := f.closeLine
+	f.closeLine = end
+	f.indent++
+	for _, stmt := range stmts {
+		f.formatStmt(stmt)
+	}
+	if end > 0 {
+		f.flushComments(end)
+	}
+	f.indent--
+	f.closeLine = saved
+	f.writeIndent()
+	f.write("}")
+	if end > 0 {
+		f.prevLine = end
+	}
+}
+
+// params writes a parameter list with its defaults and ...rest
+func (f *Formatter) params(params []string, defaults []parser.Expr, variadic bool) {
+	f.write("(")
+	for i, name := range params {
+		if i > 0 {
+			f.write(", ")
+		}
+		if variadic && i == len(params)-1 {
+			f.write("...")
+		}
+		f.write(name)
+		if defaults != nil && defaults[i] != nil {
+			f.write(" = ")
+			f.formatExpr(defaults[i])
+		}
+	}
+	f.write(")")
+}
+
+// structBody writes the fields of a struct, one per line, and then its
+// methods, each after a blank line, like block does for statements. A
+// comment at the end of a field's line stays with the field.
+func (f *Formatter) structBody(s *parser.ClassStmt, end int) {
+	saved := f.closeLine
+	f.closeLine = end
+	f.indent++
+	for i, field := range s.Fields {
+		line := 0
+		if i < len(s.FieldLines) {
+			line = s.FieldLines[i]
+		}
+		if line > 0 {
+			f.flushComments(line)
+			f.gap(line)
+			f.prevLine = line
+		}
+		f.writeIndent()
+		f.write(field)
+		if s.Defaults[i] != nil {
+			f.write(" = ")
+			f.formatExpr(s.Defaults[i])
+		}
+		f.finishLine(line)
+	}
+	for i, method := range s.Methods {
+		if i > 0 || len(s.Fields) > 0 {
+			f.newline()
+		}
+		f.formatStmt(method)
+	}
+	if end > 0 {
+		f.flushComments(end)
+	}
+	f.indent--
+	f.closeLine = saved
+	f.writeIndent()
+	f.write("}")
+	if end > 0 {
+		f.prevLine = end
 	}
 }
 
@@ -66,351 +237,589 @@ func (f *Formatter) formatStmt(stmt parser.Stmt) {
 	if stmt == nil {
 		return
 	}
-	
+
+	if start := f.starts[stmt]; start > 0 {
+		f.flushComments(start)
+		f.gap(start)
+		// Comments inside a multi-line statement without blocks go above it
+		if end := f.ends[stmt]; end > start && f.blocks[stmt] == nil && f.simple(start, end) {
+			f.prevLine = end
+			f.flushComments(end)
+		}
+		f.prevLine = start
+	}
+	f.writeIndent()
+	f.formatStmtInline(stmt)
+	f.finishLine(f.ends[stmt])
+}
+
+// formatStmtInline writes a statement without leading indentation or the
+// final line break, so it can also be used after "export" and in for
+// loop headers
+func (f *Formatter) formatStmtInline(stmt parser.Stmt) {
 	switch s := stmt.(type) {
 	case *parser.LetStmt:
-		f.writeIndent()
-		f.output.WriteString("let ")
-		f.output.WriteString(s.Name)
-		f.output.WriteString(" = ")
-		f.formatExpr(s.Expr)
-		f.output.WriteString(f.lineBreak)
-		
-	case *parser.FunctionStmt:
-		f.writeIndent()
-		f.output.WriteString("fn ")
-		f.output.WriteString(s.Name)
-		f.output.WriteString("(")
-		for i, param := range s.Params {
-			if i > 0 {
-				f.output.WriteString(", ")
-			}
-			f.output.WriteString(param)
+		f.write("let " + s.Name)
+		if s.Expr != nil {
+			f.write(" = ")
+			f.formatExpr(s.Expr)
 		}
-		f.output.WriteString(") ")
+
+	case *parser.ExportStmt:
+		f.write("export ")
+		f.formatStmtInline(s.Stmt)
+
+	case *parser.FunctionStmt:
+		f.write("fn " + s.Name)
+		f.params(s.Params, s.Defaults, s.Variadic)
 		if s.ReturnType != "" {
-			f.output.WriteString("-> ")
-			f.output.WriteString(s.ReturnType)
-			f.output.WriteString(" ")
+			f.write(": " + s.ReturnType)
 		}
-		f.output.WriteString("{")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, bodyStmt := range s.Body {
-			f.formatStmt(bodyStmt)
-		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		f.output.WriteString(f.lineBreak)
-		
+		end := f.blockEnd(s, 0)
+		f.openBrace(s.Line, s.Body, end)
+		f.block(s.Body, end)
+
 	case *parser.ReturnStmt:
-		f.writeIndent()
-		f.output.WriteString("return")
+		f.write("return")
 		if s.Value != nil {
-			f.output.WriteString(" ")
+			f.write(" ")
 			f.formatExpr(s.Value)
 		}
-		f.output.WriteString(f.lineBreak)
-		
+
 	case *parser.IfStmt:
-		f.writeIndent()
-		f.output.WriteString("if ")
+		f.write("if ")
 		f.formatExpr(s.Condition)
-		f.output.WriteString(" {")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, thenStmt := range s.Then {
-			f.formatStmt(thenStmt)
-		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		
-		if len(s.Else) > 0 {
-			f.output.WriteString(" else {")
-			f.output.WriteString(f.lineBreak)
-			
-			f.indent++
-			for _, elseStmt := range s.Else {
-				f.formatStmt(elseStmt)
+		f.openBrace(f.starts[s], s.Then, f.blockEnd(s, 0))
+		f.block(s.Then, f.blockEnd(s, 0))
+		// An empty else is dropped unless it holds comments
+		for len(s.Else) > 0 || f.commentBefore(f.blockEnd(s, 1)) {
+			header := f.blockEnd(s, 0)
+			// A lone nested if is written as else if
+			if len(s.Else) == 1 {
+				if elseIf, ok := s.Else[0].(*parser.IfStmt); ok {
+					f.continueBlock("else if ")
+					f.formatExpr(elseIf.Condition)
+					f.openBrace(header, elseIf.Then, f.blockEnd(elseIf, 0))
+					f.block(elseIf.Then, f.blockEnd(elseIf, 0))
+					s = elseIf
+					continue
+				}
 			}
-			f.indent--
-			
-			f.writeIndent()
-			f.output.WriteString("}")
+			f.continueBlock("else")
+			f.openBrace(header, s.Else, f.blockEnd(s, 1))
+			f.block(s.Else, f.blockEnd(s, 1))
+			break
 		}
-		f.output.WriteString(f.lineBreak)
-		
+
 	case *parser.WhileStmt:
-		f.writeIndent()
-		f.output.WriteString("while ")
+		f.writeLabel(s.Label)
+		f.write("while ")
 		f.formatExpr(s.Condition)
-		f.output.WriteString(" {")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, bodyStmt := range s.Body {
-			f.formatStmt(bodyStmt)
-		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		f.output.WriteString(f.lineBreak)
-		
+		f.openBrace(f.starts[s], s.Body, f.blockEnd(s, 0))
+		f.block(s.Body, f.blockEnd(s, 0))
+
 	case *parser.ForStmt:
-		f.writeIndent()
-		f.output.WriteString("for ")
+		f.writeLabel(s.Label)
+		f.write("for (")
 		if s.Init != nil {
-			f.formatStmt(s.Init)
-			// Remove trailing newline by truncating
-			str := f.output.String()
-			f.output.Reset()
-			f.output.WriteString(str[:len(str)-1])
-			f.output.WriteString("; ")
-		} else {
-			f.output.WriteString("; ")
+			f.formatStmtInline(s.Init)
 		}
-		
+		f.write(";")
 		if s.Condition != nil {
+			f.write(" ")
 			f.formatExpr(s.Condition)
 		}
-		f.output.WriteString("; ")
-		
+		f.write(";")
 		if s.Update != nil {
+			f.write(" ")
 			f.formatExpr(s.Update)
 		}
-		f.output.WriteString(" {")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, bodyStmt := range s.Body {
-			f.formatStmt(bodyStmt)
-		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		f.output.WriteString(f.lineBreak)
-		
+		f.write(")")
+		f.openBrace(f.starts[s], s.Body, f.blockEnd(s, 0))
+		f.block(s.Body, f.blockEnd(s, 0))
+
 	case *parser.ForInStmt:
-		f.writeIndent()
-		f.output.WriteString("for ")
-		f.output.WriteString(s.Variable)
-		f.output.WriteString(" in ")
-		f.formatExpr(s.Collection)
-		f.output.WriteString(" {")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, bodyStmt := range s.Body {
-			f.formatStmt(bodyStmt)
+		f.writeLabel(s.Label)
+		f.write("for ")
+		if s.Key != "" {
+			f.write(s.Key + ", ")
 		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		f.output.WriteString(f.lineBreak)
-		
+		f.write(s.Variable + " in ")
+		f.formatExpr(s.Collection)
+		f.openBrace(f.starts[s], s.Body, f.blockEnd(s, 0))
+		f.block(s.Body, f.blockEnd(s, 0))
+
 	case *parser.ExpressionStmt:
-		f.writeIndent()
 		f.formatExpr(s.Expr)
-		f.output.WriteString(f.lineBreak)
-		
+
 	case *parser.PrintStmt:
-		f.writeIndent()
-		f.output.WriteString("print(")
+		f.write("log(")
 		f.formatExpr(s.Expr)
-		f.output.WriteString(")")
-		f.output.WriteString(f.lineBreak)
-		
+		f.write(")")
+
 	case *parser.AssignmentStmt:
-		f.writeIndent()
-		f.output.WriteString(s.Name)
-		f.output.WriteString(" = ")
+		f.write(s.Name + " = ")
+		f.formatExpr(s.Value)
+
+	case *parser.IndexAssignmentStmt:
+		f.formatOperand(s.Object)
+		if name, ok := s.Index.(*parser.Literal); ok && s.Property {
+			f.write("." + name.Value.(string) + " = ")
+		} else {
+			f.write("[")
+			f.formatExpr(s.Index)
+			f.write("] = ")
+		}
 		f.formatExpr(s.Value)
-		f.output.WriteString(f.lineBreak)
-		
+
+	case *parser.ClassStmt:
+		f.write("struct " + s.Name)
+		end := f.blockEnd(s, 0)
+		f.openBrace(s.Line, nil, end)
+		f.structBody(s, end)
+
 	case *parser.ImportStmt:
-		f.writeIndent()
-		f.output.WriteString("import ")
+		f.write("import ")
+		if len(s.Names) > 0 {
+			names := make([]string, len(s.Names))
+			for i, n := range s.Names {
+				names[i] = n.Name
+				if n.Alias != "" {
+					names[i] += " as " + n.Alias
+				}
+			}
+			f.write("{" + strings.Join(names, ", ") + "} from ")
+		}
+		f.write(modulePath(s.Path))
 		if s.Alias != "" {
-			f.output.WriteString(s.Alias)
-			f.output.WriteString(" from ")
-		}
-		f.output.WriteString("\"")
-		f.output.WriteString(s.Path)
-		f.output.WriteString("\"")
-		f.output.WriteString(f.lineBreak)
-		
-	case *parser.TryStmt:
-		f.writeIndent()
-		f.output.WriteString("try {")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, tryStmt := range s.TryBlock {
-			f.formatStmt(tryStmt)
+			f.write(" as " + s.Alias)
 		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("} catch ")
-		if s.CatchVar != "" {
-			f.output.WriteString(s.CatchVar)
-			f.output.WriteString(" ")
-		}
-		f.output.WriteString("{")
-		f.output.WriteString(f.lineBreak)
-		
-		f.indent++
-		for _, catchStmt := range s.CatchBlock {
-			f.formatStmt(catchStmt)
+
+	case *parser.TryStmt:
+		tryEnd, catchEnd, finallyEnd := f.blockEnd(s, 0), f.blockEnd(s, 1), f.blockEnd(s, 2)
+		f.write("try")
+		f.openBrace(f.starts[s], s.TryBlock, tryEnd)
+		f.block(s.TryBlock, tryEnd)
+		header := tryEnd
+		if s.CatchVar != "" || len(s.CatchBlock) > 0 || len(s.FinallyBlock) == 0 || f.commentBefore(catchEnd) {
+			if s.CatchVar != "" {
+				f.continueBlock("catch " + s.CatchVar)
+			} else {
+				f.continueBlock("catch")
+			}
+			f.openBrace(header, s.CatchBlock, catchEnd)
+			f.block(s.CatchBlock, catchEnd)
+			if catchEnd > 0 {
+				header = catchEnd
+			}
 		}
-		f.indent--
-		
-		f.writeIndent()
-		f.output.WriteString("}")
-		
 		if len(s.FinallyBlock) > 0 {
-			f.output.WriteString(" finally {")
-			f.output.WriteString(f.lineBreak)
-			
-			f.indent++
-			for _, finallyStmt := range s.FinallyBlock {
-				f.formatStmt(finallyStmt)
+			f.continueBlock("finally")
+			f.openBrace(header, s.FinallyBlock, finallyEnd)
+			f.block(s.FinallyBlock, finallyEnd)
+		}
+
+	case *parser.ThrowStmt:
+		f.write("throw ")
+		f.formatExpr(s.Value)
+
+	case *parser.MatchStmt:
+		end := f.blockEnd(s, len(s.Cases))
+		f.write("match ")
+		f.formatExpr(s.Value)
+		first := end
+		if len(s.Cases) > 0 {
+			first = s.Cases[0].Line
+		}
+		f.openBrace(f.starts[s], nil, first)
+		saved := f.closeLine
+		f.closeLine = end
+		f.indent++
+		for i, c := range s.Cases {
+			if f.starts != nil {
+				f.flushComments(c.Line)
+				f.gap(c.Line)
+				f.prevLine = c.Line
 			}
-			f.indent--
-			
 			f.writeIndent()
-			f.output.WriteString("}")
+			f.formatPatterns(c)
+			f.write(" => ")
+			f.finishLine(f.formatArmBody(c.Body, f.blockEnd(s, i)))
 		}
-		f.output.WriteString(f.lineBreak)
-		
-	case *parser.ThrowStmt:
+		if end > 0 {
+			f.flushComments(end)
+		}
+		f.indent--
+		f.closeLine = saved
 		f.writeIndent()
-		f.output.WriteString("throw ")
-		f.formatExpr(s.Value)
-		f.output.WriteString(f.lineBreak)
-		
+		f.write("}")
+
 	case *parser.BreakStmt:
-		f.writeIndent()
-		f.output.WriteString("break")
-		f.output.WriteString(f.lineBreak)
-		
+		f.write("break")
+		if s.Label != "" {
+			f.write(" " + s.Label)
+		}
+
 	case *parser.ContinueStmt:
-		f.writeIndent()
-		f.output.WriteString("continue")
-		f.output.WriteString(f.lineBreak)
+		f.write("continue")
+		if s.Label != "" {
+			f.write(" " + s.Label)
+		}
+	}
+}
+
+// writeLabel writes the `name: ` in front of a labeled loop
+func (f *Formatter) writeLabel(label string) {
+	if label != "" {
+		f.write(label + ": ")
+	}
+}
+
+func (f *Formatter) formatPatterns(c parser.MatchCase) {
+	if c.IsDefault() {
+		f.write("_")
+		return
+	}
+	for i, pattern := range c.Patterns {
+		if i > 0 {
+			f.write(" | ")
+		}
+		f.formatExpr(pattern)
+	}
+}
+
+// formatArmBody writes a match arm on one line when it is a single simple
+// statement and as a block otherwise. end is the arm's closing brace line
+// if it was a block in the source. It returns the source line the arm ends
+// on.
+func (f *Formatter) formatArmBody(body []parser.Stmt, end int) int {
+	if len(body) == 1 && !f.commentBefore(end) {
+		line := f.measure(func(m *Formatter) { m.formatStmtInline(body[0]) })
+		if !strings.HasPrefix(line, "{") && !strings.Contains(line, "\n") {
+			f.formatStmtInline(body[0])
+			if last := f.ends[body[0]]; last > end {
+				return last
+			}
+			return end
+		}
+	}
+	if end == 0 && len(body) > 0 {
+		end = f.ends[body[len(body)-1]]
 	}
+	f.write("{")
+	f.headerComment(f.prevLine, body, end)
+	f.newline()
+	f.block(body, end)
+	return end
 }
 
 func (f *Formatter) formatExpr(expr parser.Expr) {
 	if expr == nil {
 		return
 	}
-	
+
 	switch e := expr.(type) {
 	case *parser.Binary:
-		f.formatExpr(e.Left)
-		f.output.WriteString(" ")
-		f.output.WriteString(e.Operator)
-		f.output.WriteString(" ")
-		f.formatExpr(e.Right)
-		
+		f.formatBinary(e.Left, e.Operator, e.Right)
+
+	case *parser.LogicalExpr:
+		f.formatBinary(e.Left, e.Operator, e.Right)
+
 	case *parser.Literal:
-		switch v := e.Value.(type) {
-		case string:
-			f.output.WriteString("\"")
-			f.output.WriteString(v)
-			f.output.WriteString("\"")
-		case float64:
-			f.output.WriteString(fmt.Sprintf("%g", v))
-		case bool:
-			f.output.WriteString(fmt.Sprintf("%v", v))
-		case nil:
-			f.output.WriteString("null")
-		default:
-			f.output.WriteString(fmt.Sprintf("%v", v))
+		if e.Raw != "" {
+			// Raw and triple-quoted strings and bytes are kept as written
+			f.write(e.Raw)
+		} else {
+			f.write(formatLiteral(e.Value))
 		}
-		
+
 	case *parser.Variable:
-		f.output.WriteString(e.Name)
-		
+		f.write(e.Name)
+
 	case *parser.Assign:
-		f.output.WriteString(e.Name)
-		f.output.WriteString(" = ")
+		f.write(e.Name + " = ")
 		f.formatExpr(e.Value)
-		
+
+	case *parser.AssignmentExpr:
+		f.write(e.Name + " = ")
+		f.formatExpr(e.Value)
+
+	case *parser.SetIndexExpr:
+		f.formatOperand(e.Object)
+		f.write("[")
+		f.formatExpr(e.Index)
+		f.write("] = ")
+		f.formatExpr(e.Value)
+
 	case *parser.CallExpr:
-		f.formatExpr(e.Callee)
-		f.output.WriteString("(")
-		for i, arg := range e.Args {
-			if i > 0 {
-				f.output.WriteString(", ")
+		f.formatOperand(e.Callee)
+		positional := len(e.Args) - len(e.Names)
+		f.formatList("(", ")", len(e.Args), false, func(g *Formatter, i int) {
+			if i >= positional {
+				g.write(e.Names[i-positional] + " = ")
 			}
-			f.formatExpr(arg)
-		}
-		f.output.WriteString(")")
-		
+			g.formatExpr(e.Args[i])
+		})
+
 	case *parser.ArrayExpr:
-		f.output.WriteString("[")
-		for i, elem := range e.Elements {
-			if i > 0 {
-				f.output.WriteString(", ")
-			}
-			f.formatExpr(elem)
-		}
-		f.output.WriteString("]")
-		
+		f.formatList("[", "]", len(e.Elements), f.config.TrailingCommas, func(g *Formatter, i int) {
+			g.formatExpr(e.Elements[i])
+		})
+
 	case *parser.MapExpr:
-		f.output.WriteString("{")
-		for i := range e.Keys {
-			if i > 0 {
-				f.output.WriteString(", ")
-			}
-			f.formatExpr(e.Keys[i])
-			f.output.WriteString(": ")
-			f.formatExpr(e.Values[i])
-		}
-		f.output.WriteString("}")
-		
+		f.formatList("{", "}", len(e.Keys), f.config.TrailingCommas, func(g *Formatter, i int) {
+			g.formatExpr(e.Keys[i])
+			g.write(": ")
+			g.formatExpr(e.Values[i])
+		})
+
 	case *parser.IndexExpr:
-		f.formatExpr(e.Object)
-		f.output.WriteString("[")
+		f.formatOperand(e.Object)
+		if e.Optional {
+			f.write("?.")
+		}
+		f.write("[")
 		f.formatExpr(e.Index)
-		f.output.WriteString("]")
-		
-	case *parser.UnaryExpr:
-		f.output.WriteString(e.Operator)
-		f.formatExpr(e.Operand)
-		
-	case *parser.LogicalExpr:
-		f.formatExpr(e.Left)
-		f.output.WriteString(" ")
-		f.output.WriteString(e.Operator)
-		f.output.WriteString(" ")
-		f.formatExpr(e.Right)
-		
+		f.write("]")
+
+	case *parser.SliceExpr:
+		f.formatOperand(e.Object)
+		f.write("[")
+		if e.Start != nil {
+			f.formatExpr(e.Start)
+		}
+		f.write(":")
+		if e.End != nil {
+			f.formatExpr(e.End)
+		}
+		f.write("]")
+
 	case *parser.PropertyExpr:
-		f.formatExpr(e.Object)
-		f.output.WriteString(".")
-		f.output.WriteString(e.Property)
-		
+		f.formatOperand(e.Object)
+		if e.Optional {
+			f.write("?")
+		}
+		f.write("." + e.Property)
+
+	case *parser.UnaryExpr:
+		f.write(e.Operator)
+		// Unary operators bind to a primary, so anything else needs parens
+		switch e.Operand.(type) {
+		case *parser.Variable, *parser.Literal, *parser.ArrayExpr, *parser.MapExpr, *parser.UnaryExpr:
+			f.formatExpr(e.Operand)
+		default:
+			f.write("(")
+			f.formatExpr(e.Operand)
+			f.write(")")
+		}
+
 	case *parser.LambdaExpr:
-		f.output.WriteString("fn(")
-		for i, param := range e.Params {
+		f.write("fn")
+		f.params(e.Params, e.Defaults, e.Variadic)
+		if body, ok := e.Body.(*parser.BlockExpr); ok {
+			end := f.blockEnd(body, 0)
+			f.write(" {")
+			f.headerComment(f.prevLine, body.Stmts, end)
+			f.newline()
+			f.block(body.Stmts, end)
+		} else {
+			f.write(" => ")
+			f.formatExpr(e.Body)
+		}
+
+	case *parser.IfExpr:
+		f.write("if ")
+		f.formatExpr(e.Cond)
+		f.write(" ")
+		f.formatExpr(e.ThenBranch)
+		if e.ElseBranch != nil {
+			f.write(" else ")
+			f.formatExpr(e.ElseBranch)
+		}
+
+	case *parser.BlockExpr:
+		end := f.blockEnd(e, 0)
+		f.write("{")
+		f.headerComment(f.prevLine, e.Stmts, end)
+		f.newline()
+		f.block(e.Stmts, end)
+
+	case *parser.InterpolationExpr:
+		for i, part := range e.Parts {
+			if i > 0 {
+				f.write(" + ")
+			}
+			f.formatOperand(part)
+		}
+	}
+}
+
+// formatOperand writes the target of a call, index or property access,
+// adding parens where the expression would otherwise parse differently
+func (f *Formatter) formatOperand(expr parser.Expr) {
+	switch expr.(type) {
+	case *parser.Binary, *parser.LogicalExpr, *parser.LambdaExpr, *parser.IfExpr,
+		*parser.BlockExpr, *parser.Assign, *parser.AssignmentExpr, *parser.SetIndexExpr:
+		f.write("(")
+		f.formatExpr(expr)
+		f.write(")")
+	default:
+		f.formatExpr(expr)
+	}
+}
+
+// Operator precedence, matching the parser
+var precedence = map[string]int{
+	"??": 0,
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, ">": 3, "<=": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+// formatBinary writes a binary expression with the parens its operands need.
+// All operators are left associative, so a right operand of equal
+// precedence is parenthesized.
+func (f *Formatter) formatBinary(left parser.Expr, op string, right parser.Expr) {
+	prec := precedence[op]
+	f.formatBinaryOperand(left, prec, false)
+	f.write(" " + op + " ")
+	f.formatBinaryOperand(right, prec, true)
+}
+
+func (f *Formatter) formatBinaryOperand(expr parser.Expr, prec int, isRight bool) {
+	parens := false
+	switch e := expr.(type) {
+	case *parser.Binary:
+		parens = precedence[e.Operator] < prec || (isRight && precedence[e.Operator] == prec)
+	case *parser.LogicalExpr:
+		parens = precedence[e.Operator] < prec || (isRight && precedence[e.Operator] == prec)
+	case *parser.LambdaExpr, *parser.IfExpr, *parser.BlockExpr:
+		// A trailing expression body would swallow the rest of the expression
+		parens = !isRight
+	case *parser.Assign, *parser.AssignmentExpr, *parser.SetIndexExpr:
+		parens = true
+	}
+	if parens {
+		f.write("(")
+		f.formatExpr(expr)
+		f.write(")")
+	} else {
+		f.formatExpr(expr)
+	}
+}
+
+// formatList writes a bracketed, comma separated list. The list is kept on
+// one line unless that line would exceed the maximum length, in which case
+// every element goes on its own line.
+func (f *Formatter) formatList(open, close string, n int, trailingComma bool, elem func(g *Formatter, i int)) {
+	flat := func(g *Formatter) {
+		g.write(open)
+		for i := 0; i < n; i++ {
 			if i > 0 {
-				f.output.WriteString(", ")
+				g.write(", ")
 			}
-			f.output.WriteString(param)
+			elem(g, i)
+		}
+		g.write(close)
+	}
+
+	if n == 0 || f.config.MaxLineLength == 0 {
+		flat(f)
+		return
+	}
+	line := f.measure(flat)
+	if i := strings.Index(line, "\n"); i >= 0 {
+		line = line[:i]
+	}
+	if f.col+len(line) <= f.config.MaxLineLength {
+		flat(f)
+		return
+	}
+
+	f.write(open)
+	f.newline()
+	f.indent++
+	for i := 0; i < n; i++ {
+		f.writeIndent()
+		elem(f, i)
+		if i < n-1 || trailingComma {
+			f.write(",")
 		}
-		f.output.WriteString(") => ")
-		f.formatExpr(e.Body)
+		f.newline()
 	}
-}
\ No newline at end of file
+	f.indent--
+	f.writeIndent()
+	f.write(close)
+}
+
+// measure renders fn without line wrapping, starting at the current
+// position, and returns the text
+func (f *Formatter) measure(fn func(m *Formatter)) string {
+	m := NewFormatterWithConfig(f.config)
+	m.config.MaxLineLength = 0
+	m.indent = f.indent
+	m.col = f.col
+	fn(m)
+	return m.output.String()
+}
+
+// formatLiteral writes a literal so that it lexes back to the same value
+func formatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return quote(v)
+	case []byte:
+		return `hex"` + hex.EncodeToString(v) + `"`
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// quote writes a double-quoted string using the escapes the lexer understands
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// modulePath writes an import path bare when it is a plain module name
+// (import math) and quoted otherwise
+func modulePath(path string) string {
+	scanner := lexer.NewScanner(path)
+	tokens := scanner.ScanTokens()
+	if !scanner.HadError() && len(tokens) == 2 && tokens[0].Type == lexer.TokenIdent && tokens[0].Lexeme == path {
+		return path
+	}
+	return quote(path)
+}
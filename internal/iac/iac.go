@@ -0,0 +1,468 @@
+// Package iac finds misconfigurations in infrastructure as code before it
+// is deployed: Terraform HCL and JSON, CloudFormation JSON and Azure
+// Resource Manager templates are read into resources, which built-in and
+// user rules are evaluated against.
+package iac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Resource is a resource declared in a template, with its configuration
+// as plain values: Terraform arguments and nested blocks, which are lists
+// of maps, or the properties of a CloudFormation or ARM resource. Values
+// that depend on what is only known once deployed are strings holding the
+// expression, such as "${aws_kms_key.main.arn}".
+type Resource struct {
+	Type       string
+	Name       string
+	Format     string // terraform, cloudformation or arm
+	File       string
+	Line       int
+	Attributes map[string]interface{}
+}
+
+// Address is how Terraform names a resource, type.name
+func (r *Resource) Address() string {
+	return r.Type + "." + r.Name
+}
+
+// Template is the resources found under a path
+type Template struct {
+	Path      string
+	Files     []string
+	Resources []*Resource
+	Errors    []string // Files that could not be read, which are skipped
+}
+
+// skipDirs are directories of downloaded modules and tools, not the
+// configuration being scanned
+var skipDirs = map[string]bool{".terraform": true, ".git": true, "node_modules": true, ".terragrunt-cache": true}
+
+// Parse reads the templates in a file, or every template under a
+// directory. Terraform files are evaluated a directory, which is a module,
+// at a time.
+func Parse(path string) (*Template, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{Path: path}
+	if !info.IsDir() {
+		if strings.HasSuffix(path, ".tf") || strings.HasSuffix(path, ".tf.json") {
+			t.parseModule([]string{path})
+		} else {
+			t.parseJSONTemplate(path)
+		}
+		return t, nil
+	}
+
+	modules := map[string][]string{}
+	var others []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Errors = append(t.Errors, err.Error())
+			return nil
+		}
+		if info.IsDir() {
+			if skipDirs[info.Name()] && file != path {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := info.Name()
+		switch {
+		case strings.HasSuffix(name, ".tf"), strings.HasSuffix(name, ".tf.json"), strings.HasSuffix(name, ".tfvars"):
+			dir := filepath.Dir(file)
+			modules[dir] = append(modules[dir], file)
+		case strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".template"):
+			others = append(others, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(modules))
+	for dir := range modules {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		t.parseModule(modules[dir])
+	}
+	for _, file := range others {
+		t.parseJSONTemplate(file)
+	}
+	return t, nil
+}
+
+func (t *Template) fail(file string, err error) {
+	t.Errors = append(t.Errors, fmt.Sprintf("%s: %v", file, err))
+}
+
+// tfFile is a parsed Terraform file
+type tfFile struct {
+	name string
+	body *hclBody
+	json map[string]interface{}
+}
+
+// parseModule reads the Terraform files of a module: its variables, with
+// the values of terraform.tfvars and *.auto.tfvars, then its locals, then
+// its resources
+func (t *Template) parseModule(files []string) {
+	sort.Strings(files)
+	var parsed []tfFile
+	var tfvars []*hclBody
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.fail(file, err)
+			continue
+		}
+		if strings.HasSuffix(file, ".tf.json") {
+			var m map[string]interface{}
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.fail(file, err)
+				continue
+			}
+			parsed = append(parsed, tfFile{name: file, json: m})
+			t.Files = append(t.Files, file)
+			continue
+		}
+		body, err := parseHCL(string(data))
+		if err != nil {
+			t.fail(file, err)
+			continue
+		}
+		if strings.HasSuffix(file, ".tfvars") {
+			base := filepath.Base(file)
+			if base == "terraform.tfvars" || strings.HasSuffix(base, ".auto.tfvars") {
+				tfvars = append(tfvars, body)
+			}
+			continue
+		}
+		parsed = append(parsed, tfFile{name: file, body: body})
+		t.Files = append(t.Files, file)
+	}
+
+	s := &scope{vars: map[string]interface{}{}, locals: map[string]interface{}{}}
+	var locals []hclAttr
+	jsonLocals := map[string]interface{}{}
+	for _, f := range parsed {
+		if f.json != nil {
+			for name, v := range asMap(f.json["variable"]) {
+				if def, ok := asMap(v)["default"]; ok {
+					s.vars[name] = def
+				}
+			}
+			for name, v := range asMap(f.json["locals"]) {
+				jsonLocals[name] = v
+			}
+			continue
+		}
+		for _, b := range f.body.blocks {
+			switch {
+			case b.typ == "variable" && len(b.labels) == 1:
+				for _, a := range b.body.attrs {
+					if a.name == "default" {
+						s.vars[b.labels[0]], _ = s.eval(a.expr)
+					}
+				}
+			case b.typ == "locals":
+				locals = append(locals, b.body.attrs...)
+			}
+		}
+	}
+	for _, body := range tfvars {
+		for _, a := range body.attrs {
+			s.vars[a.name], _ = s.eval(a.expr)
+		}
+	}
+	// Locals may refer to each other in any order
+	for pass := 0; pass < 4; pass++ {
+		for _, a := range locals {
+			s.locals[a.name], _ = s.eval(a.expr)
+		}
+		for name, v := range jsonLocals {
+			s.locals[name] = s.evalJSON(v)
+		}
+	}
+
+	for _, f := range parsed {
+		if f.json != nil {
+			t.jsonResources(f, s)
+			continue
+		}
+		for _, b := range f.body.blocks {
+			if b.typ != "resource" || len(b.labels) != 2 {
+				continue
+			}
+			t.Resources = append(t.Resources, &Resource{
+				Type:       b.labels[0],
+				Name:       b.labels[1],
+				Format:     "terraform",
+				File:       f.name,
+				Line:       b.line,
+				Attributes: s.bodyValue(b.body),
+			})
+		}
+	}
+}
+
+// jsonResources reads the resources of a Terraform JSON file, whose
+// strings are templates
+func (t *Template) jsonResources(f tfFile, s *scope) {
+	data, _ := os.ReadFile(f.name)
+	resources := asMap(f.json["resource"])
+	for _, typ := range sortedKeys(resources) {
+		byName := asMap(resources[typ])
+		_, at := lineOf(data, `"`+typ+`"`, 0)
+		for _, name := range sortedKeys(byName) {
+			attrs, _ := s.evalJSON(firstMap(byName[name])).(map[string]interface{})
+			line, _ := lineOf(data, `"`+name+`"`, at)
+			t.Resources = append(t.Resources, &Resource{
+				Type:       typ,
+				Name:       name,
+				Format:     "terraform",
+				File:       f.name,
+				Line:       line,
+				Attributes: attrs,
+			})
+		}
+	}
+}
+
+// evalJSON evaluates the templates in the strings of a JSON value
+func (s *scope) evalJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "${") {
+			return v
+		}
+		n, err := parseTemplate(v, false, v)
+		if err != nil {
+			return v
+		}
+		value, _ := s.eval(n)
+		return value
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = s.evalJSON(item)
+		}
+		return list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = s.evalJSON(item)
+		}
+		return m
+	}
+	return v
+}
+
+// parseJSONTemplate reads a CloudFormation or ARM template. Other JSON
+// files are not templates and are skipped.
+func (t *Template) parseJSONTemplate(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.fail(file, err)
+		return
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(data, &doc) != nil {
+		return
+	}
+	switch {
+	case isCloudFormation(doc):
+		t.Files = append(t.Files, file)
+		t.cloudFormation(file, data, doc)
+	case isARM(doc):
+		t.Files = append(t.Files, file)
+		t.arm(file, data, doc)
+	}
+}
+
+func isCloudFormation(doc map[string]interface{}) bool {
+	if _, ok := doc["AWSTemplateFormatVersion"]; ok {
+		return true
+	}
+	for _, r := range asMap(doc["Resources"]) {
+		if typ, _ := asMap(r)["Type"].(string); strings.HasPrefix(typ, "AWS::") {
+			return true
+		}
+	}
+	return false
+}
+
+func isARM(doc map[string]interface{}) bool {
+	schema, _ := doc["$schema"].(string)
+	_, ok := doc["resources"].([]interface{})
+	return ok && strings.Contains(schema, "deploymentTemplate")
+}
+
+// cloudFormation reads the resources of a CloudFormation template,
+// replacing Refs to parameters with their defaults
+func (t *Template) cloudFormation(file string, data []byte, doc map[string]interface{}) {
+	params := map[string]interface{}{}
+	for name, p := range asMap(doc["Parameters"]) {
+		if def, ok := asMap(p)["Default"]; ok {
+			params[name] = def
+		}
+	}
+	var resolve func(v interface{}) interface{}
+	resolve = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["Ref"].(string); ok && len(v) == 1 {
+				if def, ok := params[ref]; ok {
+					return def
+				}
+			}
+			m := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				m[k] = resolve(item)
+			}
+			return m
+		case []interface{}:
+			list := make([]interface{}, len(v))
+			for i, item := range v {
+				list[i] = resolve(item)
+			}
+			return list
+		}
+		return v
+	}
+	resources := asMap(doc["Resources"])
+	start := bytes.Index(data, []byte(`"Resources"`))
+	cursor := max(start, 0)
+	for _, name := range sortedKeys(resources) {
+		r := asMap(resources[name])
+		typ, _ := r["Type"].(string)
+		attrs, _ := resolve(asMap(r["Properties"])).(map[string]interface{})
+		line, _ := lineOf(data, `"`+name+`"`, cursor)
+		t.Resources = append(t.Resources, &Resource{
+			Type:       typ,
+			Name:       name,
+			Format:     "cloudformation",
+			File:       file,
+			Line:       line,
+			Attributes: attrs,
+		})
+	}
+}
+
+// armExpression matches the template expressions of an ARM template
+// that name a parameter or variable
+var armExpression = regexp.MustCompile(`^\[(parameters|variables)\('([^']+)'\)\]$`)
+
+// arm reads the resources of an ARM template and the child resources
+// nested in them, replacing parameters and variables with their values
+func (t *Template) arm(file string, data []byte, doc map[string]interface{}) {
+	params := map[string]interface{}{}
+	for name, p := range asMap(doc["parameters"]) {
+		if def, ok := asMap(p)["defaultValue"]; ok {
+			params[name] = def
+		}
+	}
+	variables := asMap(doc["variables"])
+	var resolve func(v interface{}, depth int) interface{}
+	resolve = func(v interface{}, depth int) interface{} {
+		switch v := v.(type) {
+		case string:
+			m := armExpression.FindStringSubmatch(v)
+			if m == nil || depth > 8 {
+				return v
+			}
+			source := params
+			if m[1] == "variables" {
+				source = variables
+			}
+			if value, ok := source[m[2]]; ok {
+				return resolve(value, depth+1)
+			}
+			return v
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				m[k] = resolve(item, depth)
+			}
+			return m
+		case []interface{}:
+			list := make([]interface{}, len(v))
+			for i, item := range v {
+				list[i] = resolve(item, depth)
+			}
+			return list
+		}
+		return v
+	}
+
+	cursor := 0
+	var walk func(list []interface{}, parentType, parentName string)
+	walk = func(list []interface{}, parentType, parentName string) {
+		for _, item := range list {
+			r := asMap(item)
+			typ, _ := r["type"].(string)
+			var line int
+			line, cursor = lineOf(data, `"`+typ+`"`, cursor)
+			name, _ := resolve(r["name"], 0).(string)
+			if parentType != "" && !strings.Contains(typ, ".") {
+				// Child resources are named relative to their parent
+				typ = parentType + "/" + typ
+				name = parentName + "/" + name
+			}
+			attrs, _ := resolve(asMap(r["properties"]), 0).(map[string]interface{})
+			t.Resources = append(t.Resources, &Resource{
+				Type:       typ,
+				Name:       name,
+				Format:     "arm",
+				File:       file,
+				Line:       line,
+				Attributes: attrs,
+			})
+			if children, ok := r["resources"].([]interface{}); ok {
+				walk(children, typ, name)
+			}
+		}
+	}
+	resources, _ := doc["resources"].([]interface{})
+	walk(resources, "", "")
+}
+
+// lineOf returns the line of the first needle at or after from, and where
+// to search for the next, so resources in order get increasing lines
+func lineOf(data []byte, needle string, from int) (int, int) {
+	if from > len(data) {
+		from = len(data)
+	}
+	i := bytes.Index(data[from:], []byte(needle))
+	if i < 0 {
+		return 0, from
+	}
+	i += from
+	return bytes.Count(data[:i], []byte("\n")) + 1, i + len(needle)
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// firstMap returns a map, or the first map of a list, which is how
+// Terraform JSON may write a block
+func firstMap(v interface{}) map[string]interface{} {
+	if list, ok := v.([]interface{}); ok && len(list) > 0 {
+		return asMap(list[0])
+	}
+	return asMap(v)
+}
@@ -0,0 +1,445 @@
+package iac
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func findResource(t *testing.T, tpl *Template, address string) *Resource {
+	t.Helper()
+	for _, r := range tpl.Resources {
+		if r.Address() == address {
+			return r
+		}
+	}
+	t.Fatalf("no resource %s in %d resources", address, len(tpl.Resources))
+	return nil
+}
+
+// ruleHits returns the sorted "rule resource" pairs of the findings
+func ruleHits(report *Report) []string {
+	var hits []string
+	for _, f := range report.Findings {
+		hits = append(hits, f.RuleID+" "+f.Resource)
+	}
+	sort.Strings(hits)
+	return hits
+}
+
+const mainTF = `
+variable "bucket_acl" {
+  type    = string
+  default = "private"
+}
+
+variable "env" {
+  default = "dev"
+}
+
+locals {
+  name   = "logs-${var.env}"
+  prefix = upper(local.name)
+  ports  = [22, 3389]
+}
+
+resource "aws_s3_bucket" "logs" {
+  bucket = local.name
+  acl    = var.bucket_acl # from tfvars
+  tags = {
+    Name  = local.prefix
+    owner = "ops"
+  }
+}
+
+resource "aws_s3_bucket_policy" "logs" {
+  bucket = aws_s3_bucket.logs.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect    = "Allow"
+      Principal = "*"
+      Action    = ["s3:GetObject"]
+      Resource  = "${aws_s3_bucket.logs.arn}/*"
+    }]
+  })
+}
+
+resource "aws_security_group" "web" {
+  name = "web"
+
+  ingress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  dynamic "ingress" {
+    for_each = local.ports
+    content {
+      from_port   = ingress.value
+      to_port     = ingress.value
+      protocol    = "tcp"
+      cidr_blocks = ["0.0.0.0/0"]
+    }
+  }
+}
+
+resource "aws_ebs_volume" "data" {
+  availability_zone = "eu-west-1a"
+  size              = 40
+}
+
+resource "aws_ebs_volume" "secure" {
+  availability_zone = "eu-west-1a"
+  size              = 40
+  encrypted         = true
+}
+
+resource "aws_db_instance" "db" {
+  engine              = "postgres"
+  publicly_accessible = true
+  storage_encrypted   = false
+}
+
+/* A public access block with one setting off */
+resource "aws_s3_bucket_public_access_block" "logs" {
+  bucket                  = aws_s3_bucket.logs.id
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = false
+}
+
+resource "aws_instance" "app" {
+  ami           = "ami-123"
+  instance_type = "t3.micro"
+  user_data     = <<-EOT
+    #!/bin/sh
+    echo ${var.env}
+  EOT
+
+  root_block_device {
+    encrypted = true
+  }
+}
+`
+
+func TestParseTerraform(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.tf":          mainTF,
+		"terraform.tfvars": `bucket_acl = "public-read"`,
+		".terraform/modules/x/main.tf": `resource "aws_ebs_volume" "vendored" {
+  size = 1
+}`,
+	})
+	tpl, err := Parse(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tpl.Errors) != 0 {
+		t.Fatalf("errors: %v", tpl.Errors)
+	}
+	if len(tpl.Resources) != 8 {
+		t.Fatalf("got %d resources, want 8 without the .terraform one", len(tpl.Resources))
+	}
+
+	bucket := findResource(t, tpl, "aws_s3_bucket.logs")
+	if bucket.Format != "terraform" || bucket.Line != 17 {
+		t.Errorf("bucket format %s line %d", bucket.Format, bucket.Line)
+	}
+	if bucket.Attributes["bucket"] != "logs-dev" {
+		t.Errorf("bucket = %v, want the local resolved", bucket.Attributes["bucket"])
+	}
+	if bucket.Attributes["acl"] != "public-read" {
+		t.Errorf("acl = %v, want the tfvars value", bucket.Attributes["acl"])
+	}
+	if tags := asMap(bucket.Attributes["tags"]); tags["Name"] != "LOGS-DEV" || tags["owner"] != "ops" {
+		t.Errorf("tags = %v", tags)
+	}
+
+	policy := findResource(t, tpl, "aws_s3_bucket_policy.logs")
+	if s, ok := policy.Attributes["policy"].(string); !ok || !strings.Contains(s, `"Principal":"*"`) {
+		t.Errorf("policy = %v, want JSON", policy.Attributes["policy"])
+	}
+	if s, _ := policy.Attributes["bucket"].(string); s != "${aws_s3_bucket.logs.id}" {
+		t.Errorf("bucket = %v, want the reference kept", policy.Attributes["bucket"])
+	}
+
+	sg := findResource(t, tpl, "aws_security_group.web")
+	if ingress := blocks(sg.Attributes["ingress"]); len(ingress) != 3 || ingress[2]["from_port"] != 3389.0 {
+		t.Errorf("got %d ingress blocks, want the static one and one per port", len(ingress))
+	}
+
+	app := findResource(t, tpl, "aws_instance.app")
+	if app.Attributes["user_data"] != "#!/bin/sh\necho dev\n" {
+		t.Errorf("user_data = %q", app.Attributes["user_data"])
+	}
+}
+
+func TestScanTerraform(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.tf":          mainTF,
+		"terraform.tfvars": `bucket_acl = "public-read"`,
+	})
+	report, err := NewScanner().Scan(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"IAC-EBS-UNENCRYPTED aws_ebs_volume.data",
+		"IAC-RDS-PUBLIC aws_db_instance.db",
+		"IAC-RDS-UNENCRYPTED aws_db_instance.db",
+		"IAC-S3-BLOCK-PUBLIC aws_s3_bucket_public_access_block.logs",
+		"IAC-S3-PUBLIC-ACL aws_s3_bucket.logs",
+		"IAC-S3-PUBLIC-POLICY aws_s3_bucket_policy.logs",
+		"IAC-SG-OPEN-INGRESS aws_security_group.web",
+		"IAC-SG-OPEN-INGRESS aws_security_group.web",
+	}
+	if got := ruleHits(report); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if report.Findings[len(report.Findings)-1].Severity != "medium" {
+		t.Errorf("findings not sorted by severity")
+	}
+	var ingress []string
+	for _, f := range report.Findings {
+		if f.RuleID == "IAC-SG-OPEN-INGRESS" {
+			ingress = append(ingress, f.Description)
+		}
+	}
+	// The 443 rule is meant to be public
+	if strings.Join(ingress, ", ") != "ingress from 0.0.0.0/0 to port 22, ingress from 0.0.0.0/0 to port 3389" {
+		t.Errorf("ingress findings %v", ingress)
+	}
+
+	m := ReportToMap(report)
+	if m["passed"] != false || asMap(m["summary"])["high"] != 7 || asMap(m["summary"])["medium"] != 1 {
+		t.Errorf("summary %v passed %v", m["summary"], m["passed"])
+	}
+
+	filtered, err := NewScanner().Scan(dir, Options{Skip: []string{"IAC-RDS-PUBLIC"}, MinSeverity: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered.Findings) != 6 {
+		t.Errorf("got %d findings with skip and severity, want 6", len(filtered.Findings))
+	}
+	if _, err := NewScanner().Scan(dir, Options{MinSeverity: "urgent"}); err == nil {
+		t.Error("unknown severity accepted")
+	}
+}
+
+func TestTerraformJSON(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.tf.json": `{
+  "variable": {"public": {"default": true}},
+  "resource": {
+    "aws_db_instance": {
+      "db": {"publicly_accessible": "${var.public}", "storage_encrypted": true}
+    }
+  }
+}`,
+	})
+	report, err := NewScanner().Scan(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ruleHits(report); len(got) != 1 || got[0] != "IAC-RDS-PUBLIC aws_db_instance.db" {
+		t.Errorf("findings %v", got)
+	}
+}
+
+func TestCloudFormation(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"stack.json": `{
+  "AWSTemplateFormatVersion": "2010-09-09",
+  "Parameters": {"Acl": {"Type": "String", "Default": "PublicRead"}},
+  "Resources": {
+    "Bucket": {
+      "Type": "AWS::S3::Bucket",
+      "Properties": {"AccessControl": {"Ref": "Acl"}}
+    },
+    "Ssh": {
+      "Type": "AWS::EC2::SecurityGroup",
+      "Properties": {
+        "SecurityGroupIngress": [
+          {"IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "CidrIp": "0.0.0.0/0"},
+          {"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443, "CidrIp": "0.0.0.0/0"}
+        ]
+      }
+    },
+    "Volume": {
+      "Type": "AWS::EC2::Volume",
+      "Properties": {"Size": 10, "Encrypted": {"Ref": "Unknown"}}
+    }
+  }
+}`,
+		"package.json": `{"name": "not a template"}`,
+	})
+	tpl, err := Parse(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tpl.Files) != 1 {
+		t.Errorf("files %v, want only the template", tpl.Files)
+	}
+	bucket := findResource(t, tpl, "AWS::S3::Bucket.Bucket")
+	if bucket.Format != "cloudformation" || bucket.Line != 5 {
+		t.Errorf("bucket format %s line %d", bucket.Format, bucket.Line)
+	}
+	report, err := NewScanner().Evaluate(tpl, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "IAC-S3-PUBLIC-ACL AWS::S3::Bucket.Bucket\nIAC-SG-OPEN-INGRESS AWS::EC2::SecurityGroup.Ssh"
+	if got := strings.Join(ruleHits(report), "\n"); got != want {
+		t.Errorf("findings:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestARM(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"azuredeploy.json": `{
+  "$schema": "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#",
+  "contentVersion": "1.0.0.0",
+  "parameters": {"allowPublic": {"type": "bool", "defaultValue": true}},
+  "variables": {"rdp": "3389"},
+  "resources": [
+    {
+      "type": "Microsoft.Storage/storageAccounts",
+      "name": "store",
+      "properties": {
+        "allowBlobPublicAccess": "[parameters('allowPublic')]",
+        "supportsHttpsTrafficOnly": false
+      }
+    },
+    {
+      "type": "Microsoft.Network/networkSecurityGroups",
+      "name": "nsg",
+      "properties": {
+        "securityRules": [{
+          "name": "rdp",
+          "properties": {
+            "direction": "Inbound", "access": "Allow", "protocol": "Tcp",
+            "sourceAddressPrefix": "*", "destinationPortRange": "[variables('rdp')]"
+          }
+        }]
+      },
+      "resources": [{
+        "type": "securityRules",
+        "name": "web",
+        "properties": {
+          "direction": "Inbound", "access": "Allow",
+          "sourceAddressPrefix": "Internet", "destinationPortRange": "443"
+        }
+      }]
+    }
+  ]
+}`,
+	})
+	report, err := NewScanner().Scan(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"IAC-AZURE-STORAGE-HTTP Microsoft.Storage/storageAccounts.store",
+		"IAC-AZURE-STORAGE-PUBLIC Microsoft.Storage/storageAccounts.store",
+		"IAC-SG-OPEN-INGRESS Microsoft.Network/networkSecurityGroups.nsg",
+	}
+	if got := ruleHits(report); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(report.Resources) != 3 {
+		t.Errorf("got %d resources, want the child security rule too", len(report.Resources))
+	}
+	findResource(t, report.Template, "Microsoft.Network/networkSecurityGroups/securityRules.nsg/web")
+}
+
+func TestCustomRule(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.tf": mainTF})
+	s := NewScanner()
+	rule := Rule{
+		ID:    "ORG-OWNER",
+		Types: []string{"aws_s3_bucket", "aws_ebs_volume"},
+		Check: func(r *Resource) ([]string, error) {
+			if asMap(r.Attributes["tags"])["owner"] == nil {
+				return []string{"no owner tag"}, nil
+			}
+			return nil, nil
+		},
+	}
+	if err := s.AddRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRule(Rule{ID: "BAD", Severity: "urgent", Check: rule.Check}); err == nil {
+		t.Error("rule with unknown severity added")
+	}
+	if err := s.AddRule(Rule{ID: "NOCHECK"}); err == nil {
+		t.Error("rule without check added")
+	}
+	report, err := s.Scan(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owners []string
+	for _, f := range report.Findings {
+		if f.RuleID == "ORG-OWNER" {
+			if f.Severity != "medium" || f.Title != "ORG-OWNER" {
+				t.Errorf("rule defaults not applied: %+v", f)
+			}
+			owners = append(owners, f.Resource)
+		}
+	}
+	sort.Strings(owners)
+	if strings.Join(owners, ",") != "aws_ebs_volume.data,aws_ebs_volume.secure" {
+		t.Errorf("custom rule findings %v", owners)
+	}
+
+	// A rule with the same ID replaces the first
+	rule.Severity = "low"
+	if err := s.AddRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Rules()); n != len(builtinRules)+1 {
+		t.Errorf("got %d rules, want the rule replaced", n)
+	}
+}
+
+func TestHCLErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"bad.tf":  "resource \"aws_s3_bucket\" \"x\" {\n  acl = \n",
+		"good.tf": "resource \"aws_ebs_volume\" \"v\" {\n  encrypted = true\n}\n",
+	})
+	tpl, err := Parse(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tpl.Errors) != 1 || !strings.Contains(tpl.Errors[0], "bad.tf") {
+		t.Errorf("errors %v", tpl.Errors)
+	}
+	if len(tpl.Resources) != 1 {
+		t.Errorf("got %d resources, want the good file parsed", len(tpl.Resources))
+	}
+	if _, err := Parse(filepath.Join(dir, "missing.tf")); err == nil {
+		t.Error("missing path parsed")
+	}
+}
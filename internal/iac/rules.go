@@ -0,0 +1,487 @@
+package iac
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is a misconfiguration check on resources of some types. Check
+// returns a description of each problem it finds in a resource.
+type Rule struct {
+	ID          string
+	Title       string
+	Severity    string // critical, high, medium, low or info
+	Remediation string
+	Types       []string // Resource types it applies to, all if empty
+	Check       func(r *Resource) ([]string, error)
+}
+
+// appliesTo reports whether a rule checks a type of resource. ARM types
+// are not case sensitive.
+func (rule *Rule) appliesTo(typ string) bool {
+	if len(rule.Types) == 0 {
+		return true
+	}
+	for _, t := range rule.Types {
+		if t == "*" || strings.EqualFold(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the value at a dot separated path of attributes, going
+// into the first of a list of blocks
+func get(m map[string]interface{}, path string) interface{} {
+	var v interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		if list, ok := v.([]interface{}); ok && len(list) > 0 {
+			v = list[0]
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	return v
+}
+
+// blocks returns the maps of a list of blocks, or of a single block
+func blocks(v interface{}) []map[string]interface{} {
+	var list []map[string]interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		list = append(list, v)
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				list = append(list, m)
+			}
+		}
+	}
+	return list
+}
+
+// stringsOf returns a string, or the strings of a list
+func stringsOf(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// isUnknown reports whether a value is only known once deployed: a
+// Terraform reference, an ARM template expression or a CloudFormation
+// intrinsic function
+func isUnknown(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.Contains(v, "${") || strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]")
+	case map[string]interface{}:
+		for k := range v {
+			if k == "Ref" || strings.HasPrefix(k, "Fn::") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+func isFalse(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return !v
+	case string:
+		return strings.EqualFold(v, "false")
+	}
+	return false
+}
+
+// notEnabled reports whether a setting is known to be off: false, or
+// missing where the default is off
+func notEnabled(v interface{}) bool {
+	return v == nil || isFalse(v)
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// builtinRules are the misconfigurations checked without user rules
+var builtinRules = []Rule{
+	{
+		ID:          "IAC-S3-PUBLIC-ACL",
+		Title:       "S3 bucket ACL grants public access",
+		Severity:    "high",
+		Remediation: "Use the private canned ACL, or disable ACLs with object ownership BucketOwnerEnforced",
+		Types:       []string{"aws_s3_bucket", "aws_s3_bucket_acl", "AWS::S3::Bucket"},
+		Check:       checkS3ACL,
+	},
+	{
+		ID:          "IAC-S3-PUBLIC-POLICY",
+		Title:       "S3 bucket policy allows anyone",
+		Severity:    "high",
+		Remediation: "Name the principals that need access, or add a condition such as aws:SourceVpce",
+		Types:       []string{"aws_s3_bucket_policy", "aws_s3_bucket", "AWS::S3::BucketPolicy"},
+		Check:       checkS3Policy,
+	},
+	{
+		ID:          "IAC-S3-BLOCK-PUBLIC",
+		Title:       "S3 public access block is not fully enabled",
+		Severity:    "medium",
+		Remediation: "Set block_public_acls, block_public_policy, ignore_public_acls and restrict_public_buckets to true",
+		Types:       []string{"aws_s3_bucket_public_access_block", "aws_s3_account_public_access_block", "AWS::S3::Bucket"},
+		Check:       checkS3PublicAccessBlock,
+	},
+	{
+		ID:          "IAC-SG-OPEN-INGRESS",
+		Title:       "Firewall rule allows ingress from the internet",
+		Severity:    "high",
+		Remediation: "Limit the source to the address ranges that need access, or put the service behind a load balancer or VPN",
+		Types: []string{
+			"aws_security_group", "aws_security_group_rule", "aws_vpc_security_group_ingress_rule",
+			"AWS::EC2::SecurityGroup", "AWS::EC2::SecurityGroupIngress",
+			"azurerm_network_security_group", "azurerm_network_security_rule",
+			"Microsoft.Network/networkSecurityGroups", "Microsoft.Network/networkSecurityGroups/securityRules",
+		},
+		Check: checkOpenIngress,
+	},
+	{
+		ID:          "IAC-EBS-UNENCRYPTED",
+		Title:       "EBS volume is not encrypted",
+		Severity:    "high",
+		Remediation: "Set encrypted = true, optionally with a customer managed kms_key_id",
+		Types:       []string{"aws_ebs_volume", "aws_instance", "aws_launch_template", "AWS::EC2::Volume", "AWS::EC2::Instance"},
+		Check:       checkEBSEncryption,
+	},
+	{
+		ID:          "IAC-RDS-UNENCRYPTED",
+		Title:       "Database storage is not encrypted",
+		Severity:    "high",
+		Remediation: "Set storage_encrypted = true; existing databases must be restored from an encrypted snapshot",
+		Types:       []string{"aws_db_instance", "aws_rds_cluster", "AWS::RDS::DBInstance", "AWS::RDS::DBCluster"},
+		Check:       checkRDSEncryption,
+	},
+	{
+		ID:          "IAC-RDS-PUBLIC",
+		Title:       "Database is publicly accessible",
+		Severity:    "high",
+		Remediation: "Set publicly_accessible = false and connect through the VPC",
+		Types:       []string{"aws_db_instance", "aws_rds_cluster_instance", "AWS::RDS::DBInstance"},
+		Check:       checkRDSPublic,
+	},
+	{
+		ID:          "IAC-AZURE-STORAGE-PUBLIC",
+		Title:       "Storage account allows public blob access",
+		Severity:    "high",
+		Remediation: "Set allowBlobPublicAccess, or allow_nested_items_to_be_public in Terraform, to false",
+		Types:       []string{"azurerm_storage_account", "Microsoft.Storage/storageAccounts"},
+		Check:       checkAzureStoragePublic,
+	},
+	{
+		ID:          "IAC-AZURE-STORAGE-HTTP",
+		Title:       "Storage account accepts unencrypted HTTP",
+		Severity:    "medium",
+		Remediation: "Set supportsHttpsTrafficOnly, or https_traffic_only_enabled in Terraform, to true",
+		Types:       []string{"azurerm_storage_account", "Microsoft.Storage/storageAccounts"},
+		Check:       checkAzureStorageHTTPS,
+	},
+}
+
+var publicACLs = map[string]bool{
+	"public-read": true, "public-read-write": true, "authenticated-read": true,
+	"PublicRead": true, "PublicReadWrite": true, "AuthenticatedRead": true,
+}
+
+func checkS3ACL(r *Resource) ([]string, error) {
+	key := "acl"
+	if r.Format == "cloudformation" {
+		key = "AccessControl"
+	}
+	if acl, ok := r.Attributes[key].(string); ok && publicACLs[acl] {
+		return []string{fmt.Sprintf("%s is %s", key, acl)}, nil
+	}
+	return nil, nil
+}
+
+func checkS3Policy(r *Resource) ([]string, error) {
+	policy := r.Attributes["policy"]
+	if r.Format == "cloudformation" {
+		policy = r.Attributes["PolicyDocument"]
+	}
+	if s, ok := policy.(string); ok {
+		// Policies with references to resources are still JSON
+		var doc interface{}
+		if json.Unmarshal([]byte(s), &doc) != nil {
+			return nil, nil
+		}
+		policy = doc
+	}
+	var problems []string
+	for _, st := range blocks(get(asMap(policy), "Statement")) {
+		if st["Effect"] != "Allow" || st["Condition"] != nil || !anyPrincipal(st["Principal"]) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("statement allows %s to any principal", strings.Join(stringsOf(st["Action"]), ", ")))
+	}
+	return problems, nil
+}
+
+// anyPrincipal reports whether a policy principal is everyone
+func anyPrincipal(v interface{}) bool {
+	if s, ok := v.(string); ok {
+		return s == "*"
+	}
+	for _, p := range stringsOf(asMap(v)["AWS"]) {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func checkS3PublicAccessBlock(r *Resource) ([]string, error) {
+	settings := r.Attributes
+	keys := []string{"block_public_acls", "block_public_policy", "ignore_public_acls", "restrict_public_buckets"}
+	if r.Format == "cloudformation" {
+		// Buckets without the configuration get the account's, which AWS
+		// now blocks by default
+		settings = asMap(r.Attributes["PublicAccessBlockConfiguration"])
+		if settings == nil {
+			return nil, nil
+		}
+		keys = []string{"BlockPublicAcls", "BlockPublicPolicy", "IgnorePublicAcls", "RestrictPublicBuckets"}
+	}
+	var off []string
+	for _, key := range keys {
+		if notEnabled(settings[key]) {
+			off = append(off, key)
+		}
+	}
+	if len(off) == 0 {
+		return nil, nil
+	}
+	return []string{strings.Join(off, ", ") + " not enabled"}, nil
+}
+
+// openSources are the sources that mean anywhere on the internet
+var openSources = map[string]bool{"0.0.0.0/0": true, "::/0": true, "*": true, "Internet": true, "Any": true}
+
+// openPorts describes the ports an ingress rule opens to the internet, or
+// returns "" for a rule that only opens HTTP and HTTPS, which are meant to
+// be public
+func openPorts(protocol interface{}, from, to interface{}) string {
+	proto, _ := protocol.(string)
+	if p, ok := toInt(protocol); ok && p == -1 || proto == "-1" || strings.EqualFold(proto, "all") {
+		return "all ports"
+	}
+	start, ok1 := toInt(from)
+	end, ok2 := toInt(to)
+	if !ok1 || !ok2 {
+		return ""
+	}
+	switch {
+	case start <= 0 && end >= 65535 || start == -1:
+		return "all ports"
+	case start == end && (start == 80 || start == 443):
+		return ""
+	case start == end:
+		return fmt.Sprintf("port %d", start)
+	}
+	return fmt.Sprintf("ports %d-%d", start, end)
+}
+
+// azurePorts does as openPorts for an Azure port range such as 22, 80-90
+// or *
+func azurePorts(ranges []string) string {
+	var open []string
+	for _, r := range ranges {
+		if r == "*" {
+			return "all ports"
+		}
+		from, to, found := strings.Cut(r, "-")
+		if !found {
+			to = from
+		}
+		if desc := openPorts("tcp", from, to); desc != "" {
+			open = append(open, strings.TrimPrefix(strings.TrimPrefix(desc, "ports "), "port "))
+		}
+	}
+	if len(open) == 0 {
+		return ""
+	}
+	return "ports " + strings.Join(open, ", ")
+}
+
+func checkOpenIngress(r *Resource) ([]string, error) {
+	var problems []string
+	report := func(sources []string, ports string) {
+		for _, src := range sources {
+			if openSources[src] && ports != "" {
+				problems = append(problems, fmt.Sprintf("ingress from %s to %s", src, ports))
+				return
+			}
+		}
+	}
+	a := r.Attributes
+	switch r.Type {
+	case "aws_security_group":
+		for _, rule := range blocks(a["ingress"]) {
+			report(append(stringsOf(rule["cidr_blocks"]), stringsOf(rule["ipv6_cidr_blocks"])...),
+				openPorts(rule["protocol"], rule["from_port"], rule["to_port"]))
+		}
+	case "aws_security_group_rule":
+		if a["type"] == "ingress" {
+			report(append(stringsOf(a["cidr_blocks"]), stringsOf(a["ipv6_cidr_blocks"])...),
+				openPorts(a["protocol"], a["from_port"], a["to_port"]))
+		}
+	case "aws_vpc_security_group_ingress_rule":
+		report(append(stringsOf(a["cidr_ipv4"]), stringsOf(a["cidr_ipv6"])...),
+			openPorts(a["ip_protocol"], a["from_port"], a["to_port"]))
+	case "AWS::EC2::SecurityGroup", "AWS::EC2::SecurityGroupIngress":
+		rules := []map[string]interface{}{a}
+		if r.Type == "AWS::EC2::SecurityGroup" {
+			rules = blocks(a["SecurityGroupIngress"])
+		}
+		for _, rule := range rules {
+			report(append(stringsOf(rule["CidrIp"]), stringsOf(rule["CidrIpv6"])...),
+				openPorts(rule["IpProtocol"], rule["FromPort"], rule["ToPort"]))
+		}
+	case "azurerm_network_security_group", "azurerm_network_security_rule":
+		rules := []map[string]interface{}{a}
+		if r.Type == "azurerm_network_security_group" {
+			rules = blocks(a["security_rule"])
+		}
+		for _, rule := range rules {
+			if strings.EqualFold(fmt.Sprint(rule["direction"]), "Inbound") && strings.EqualFold(fmt.Sprint(rule["access"]), "Allow") {
+				report(append(stringsOf(rule["source_address_prefix"]), stringsOf(rule["source_address_prefixes"])...),
+					azurePorts(append(stringsOf(rule["destination_port_range"]), stringsOf(rule["destination_port_ranges"])...)))
+			}
+		}
+	default:
+		rules := []map[string]interface{}{a}
+		if strings.EqualFold(r.Type, "Microsoft.Network/networkSecurityGroups") {
+			rules = nil
+			for _, rule := range blocks(a["securityRules"]) {
+				rules = append(rules, asMap(rule["properties"]))
+			}
+		}
+		for _, rule := range rules {
+			if strings.EqualFold(fmt.Sprint(rule["direction"]), "Inbound") && strings.EqualFold(fmt.Sprint(rule["access"]), "Allow") {
+				report(append(stringsOf(rule["sourceAddressPrefix"]), stringsOf(rule["sourceAddressPrefixes"])...),
+					azurePorts(append(stringsOf(rule["destinationPortRange"]), stringsOf(rule["destinationPortRanges"])...)))
+			}
+		}
+	}
+	return problems, nil
+}
+
+func checkEBSEncryption(r *Resource) ([]string, error) {
+	a := r.Attributes
+	unencrypted := func(v interface{}) bool { return notEnabled(v) && !isUnknown(v) }
+	var problems []string
+	switch r.Type {
+	case "aws_ebs_volume":
+		if unencrypted(a["encrypted"]) && a["snapshot_id"] == nil {
+			problems = append(problems, "encrypted is not true")
+		}
+	case "AWS::EC2::Volume":
+		if unencrypted(a["Encrypted"]) && a["SnapshotId"] == nil {
+			problems = append(problems, "Encrypted is not true")
+		}
+	case "aws_instance":
+		for _, kind := range []string{"root_block_device", "ebs_block_device"} {
+			for _, d := range blocks(a[kind]) {
+				if unencrypted(d["encrypted"]) && d["snapshot_id"] == nil {
+					problems = append(problems, kind+" is not encrypted")
+				}
+			}
+		}
+	case "aws_launch_template":
+		for _, d := range blocks(a["block_device_mappings"]) {
+			if ebs := get(d, "ebs"); ebs != nil && unencrypted(get(d, "ebs.encrypted")) {
+				problems = append(problems, fmt.Sprintf("block device %v is not encrypted", d["device_name"]))
+			}
+		}
+	case "AWS::EC2::Instance":
+		for _, d := range blocks(a["BlockDeviceMappings"]) {
+			if ebs := asMap(d["Ebs"]); ebs != nil && unencrypted(ebs["Encrypted"]) && ebs["SnapshotId"] == nil {
+				problems = append(problems, fmt.Sprintf("block device %v is not encrypted", d["DeviceName"]))
+			}
+		}
+	}
+	return problems, nil
+}
+
+func checkRDSEncryption(r *Resource) ([]string, error) {
+	a := r.Attributes
+	if r.Format == "cloudformation" {
+		if notEnabled(a["StorageEncrypted"]) && a["SourceDBInstanceIdentifier"] == nil && a["DBSnapshotIdentifier"] == nil {
+			return []string{"StorageEncrypted is not true"}, nil
+		}
+		return nil, nil
+	}
+	// Replicas and restores take the encryption of their source
+	if notEnabled(a["storage_encrypted"]) && a["replicate_source_db"] == nil && a["snapshot_identifier"] == nil {
+		return []string{"storage_encrypted is not true"}, nil
+	}
+	return nil, nil
+}
+
+func checkRDSPublic(r *Resource) ([]string, error) {
+	key := "publicly_accessible"
+	if r.Format == "cloudformation" {
+		key = "PubliclyAccessible"
+	}
+	if isTrue(r.Attributes[key]) {
+		return []string{key + " is true"}, nil
+	}
+	return nil, nil
+}
+
+func checkAzureStoragePublic(r *Resource) ([]string, error) {
+	for _, key := range []string{"allowBlobPublicAccess", "allow_nested_items_to_be_public", "allow_blob_public_access"} {
+		if isTrue(r.Attributes[key]) {
+			return []string{key + " is true"}, nil
+		}
+	}
+	return nil, nil
+}
+
+func checkAzureStorageHTTPS(r *Resource) ([]string, error) {
+	for _, key := range []string{"supportsHttpsTrafficOnly", "https_traffic_only_enabled", "enable_https_traffic_only"} {
+		if isFalse(r.Attributes[key]) {
+			return []string{key + " is false"}, nil
+		}
+	}
+	return nil, nil
+}
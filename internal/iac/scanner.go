@@ -0,0 +1,231 @@
+package iac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// severityRank orders severities from the least to the most severe
+var severityRank = map[string]int{"info": 0, "low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// Finding is a problem a rule found in a resource
+type Finding struct {
+	RuleID      string
+	Title       string
+	Severity    string
+	Description string
+	Remediation string
+	Resource    string // type.name
+	Type        string
+	File        string
+	Line        int
+}
+
+// Report is the result of a scan
+type Report struct {
+	*Template
+	Findings []Finding
+}
+
+// Options narrows a scan
+type Options struct {
+	Skip        []string // Rule IDs not to evaluate
+	MinSeverity string   // Leave out findings below this severity
+}
+
+// Scanner evaluates the built-in rules and rules added to it
+type Scanner struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewScanner returns a scanner with the built-in rules
+func NewScanner() *Scanner {
+	return &Scanner{rules: append([]Rule(nil), builtinRules...)}
+}
+
+// AddRule adds a rule, replacing any with the same ID
+func (s *Scanner) AddRule(rule Rule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("rule has no id")
+	}
+	if rule.Check == nil {
+		return fmt.Errorf("rule %s has no check", rule.ID)
+	}
+	if rule.Severity == "" {
+		rule.Severity = "medium"
+	}
+	rule.Severity = strings.ToLower(rule.Severity)
+	if _, ok := severityRank[rule.Severity]; !ok {
+		return fmt.Errorf("rule %s: severity must be critical, high, medium, low or info", rule.ID)
+	}
+	if rule.Title == "" {
+		rule.Title = rule.ID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.rules {
+		if r.ID == rule.ID {
+			s.rules[i] = rule
+			return nil
+		}
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// Rules returns the rules of the scanner
+func (s *Scanner) Rules() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Rule(nil), s.rules...)
+}
+
+// Scan parses the templates under a path and evaluates the rules against
+// their resources
+func (s *Scanner) Scan(path string, opts Options) (*Report, error) {
+	t, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Evaluate(t, opts)
+}
+
+// Evaluate evaluates the rules against the resources of parsed templates.
+// A rule whose check fails stops the scan.
+func (s *Scanner) Evaluate(t *Template, opts Options) (*Report, error) {
+	min := 0
+	if opts.MinSeverity != "" {
+		var ok bool
+		if min, ok = severityRank[strings.ToLower(opts.MinSeverity)]; !ok {
+			return nil, fmt.Errorf("unknown severity '%s'", opts.MinSeverity)
+		}
+	}
+	skip := map[string]bool{}
+	for _, id := range opts.Skip {
+		skip[id] = true
+	}
+	report := &Report{Template: t, Findings: []Finding{}}
+	for _, rule := range s.Rules() {
+		if skip[rule.ID] || severityRank[rule.Severity] < min {
+			continue
+		}
+		for _, r := range t.Resources {
+			if !rule.appliesTo(r.Type) {
+				continue
+			}
+			problems, err := rule.Check(r)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s on %s: %w", rule.ID, r.Address(), err)
+			}
+			for _, p := range problems {
+				report.Findings = append(report.Findings, Finding{
+					RuleID:      rule.ID,
+					Title:       rule.Title,
+					Severity:    rule.Severity,
+					Description: p,
+					Remediation: rule.Remediation,
+					Resource:    r.Address(),
+					Type:        r.Type,
+					File:        r.File,
+					Line:        r.Line,
+				})
+			}
+		}
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] > severityRank[b.Severity]
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return report, nil
+}
+
+// ResourceToMap converts a resource to the map iac_parse returns and user
+// rules receive
+func ResourceToMap(r *Resource) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       r.Type,
+		"name":       r.Name,
+		"address":    r.Address(),
+		"format":     r.Format,
+		"file":       r.File,
+		"line":       r.Line,
+		"attributes": r.Attributes,
+	}
+}
+
+// TemplateToMap converts parsed templates to the map iac_parse returns
+func TemplateToMap(t *Template) map[string]interface{} {
+	resources := make([]interface{}, len(t.Resources))
+	for i, r := range t.Resources {
+		resources[i] = ResourceToMap(r)
+	}
+	return map[string]interface{}{
+		"path":      t.Path,
+		"files":     stringsToList(t.Files),
+		"resources": resources,
+		"errors":    stringsToList(t.Errors),
+	}
+}
+
+// FindingToMap converts a finding to a map
+func FindingToMap(f Finding) map[string]interface{} {
+	return map[string]interface{}{
+		"rule_id":     f.RuleID,
+		"title":       f.Title,
+		"severity":    f.Severity,
+		"description": f.Description,
+		"remediation": f.Remediation,
+		"resource":    f.Resource,
+		"type":        f.Type,
+		"file":        f.File,
+		"line":        f.Line,
+	}
+}
+
+// RuleToMap converts a rule to a map, without its check
+func RuleToMap(rule Rule) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             rule.ID,
+		"title":          rule.Title,
+		"severity":       rule.Severity,
+		"remediation":    rule.Remediation,
+		"resource_types": stringsToList(rule.Types),
+	}
+}
+
+// ReportToMap converts a report to the map iac_scan returns. passed is
+// false when there are critical or high findings.
+func ReportToMap(r *Report) map[string]interface{} {
+	findings := make([]interface{}, len(r.Findings))
+	counts := map[string]interface{}{"critical": 0, "high": 0, "medium": 0, "low": 0, "info": 0}
+	for i, f := range r.Findings {
+		findings[i] = FindingToMap(f)
+		counts[f.Severity] = counts[f.Severity].(int) + 1
+	}
+	return map[string]interface{}{
+		"path":      r.Path,
+		"files":     stringsToList(r.Files),
+		"resources": len(r.Resources),
+		"findings":  findings,
+		"summary":   counts,
+		"passed":    counts["critical"] == 0 && counts["high"] == 0,
+		"errors":    stringsToList(r.Errors),
+	}
+}
+
+func stringsToList(items []string) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, s := range items {
+		list[i] = s
+	}
+	return list
+}
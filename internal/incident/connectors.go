@@ -0,0 +1,600 @@
+package incident
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// ExecutorOptions configure the built-in executors. Each kind reads only
+// the options it needs.
+type ExecutorOptions struct {
+	URL          string // Of the firewall or the API, or the Okta org
+	AuthURL      string // Defender's sign-in endpoint, https://login.microsoftonline.com by default
+	APIKey       string // Palo Alto
+	Token        string // Okta API token
+	TenantID     string // Defender
+	ClientID     string // CrowdStrike and Defender
+	ClientSecret string
+	Tag          string // Palo Alto tag of blocked addresses, sentra-blocked by default
+	Table        string // pf table of blocked addresses, sentra_blocked by default
+	Chain        string // iptables chain of both rules, INPUT and OUTPUT by default
+	Host         string // AD domain controller
+	Port         int
+	TLS          string
+	Username     string
+	Password     string
+	Domain       string
+	BaseDN       string
+	Insecure     bool
+	Timeout      time.Duration // Of each request, 30s by default
+}
+
+// ExecutorKinds are the kinds of the built-in executors
+var ExecutorKinds = []string{"iptables", "pf", "paloalto", "crowdstrike", "defender", "ad", "okta"}
+
+// NewExecutor returns a built-in executor: iptables or pf on this host,
+// a Palo Alto firewall through its XML API, CrowdStrike Falcon or
+// Microsoft Defender for Endpoint, Active Directory over LDAP, or Okta
+func NewExecutor(kind string, opts ExecutorOptions) (Executor, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	switch kind {
+	case "iptables", "pf":
+		if opts.Table == "" {
+			opts.Table = "sentra_blocked"
+		}
+		return &firewallExecutor{kind: kind, opts: opts}, nil
+	case "paloalto":
+		if opts.APIKey == "" {
+			return nil, fmt.Errorf("paloalto needs an api key")
+		}
+		if opts.Tag == "" {
+			opts.Tag = "sentra-blocked"
+		}
+		api, err := newAPIClient(kind, opts.URL, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &paloAltoExecutor{api: api, key: opts.APIKey, tag: opts.Tag}, nil
+	case "crowdstrike", "defender":
+		if opts.URL == "" {
+			opts.URL = "https://api.crowdstrike.com"
+			if kind == "defender" {
+				opts.URL = "https://api.securitycenter.microsoft.com"
+			}
+		}
+		if opts.ClientID == "" || opts.ClientSecret == "" {
+			return nil, fmt.Errorf("%s needs a client id and a client secret", kind)
+		}
+		api, err := newAPIClient(kind, opts.URL, opts)
+		if err != nil {
+			return nil, err
+		}
+		e := &edrExecutor{kind: kind, api: api, opts: opts}
+		if kind == "defender" {
+			if opts.TenantID == "" {
+				return nil, fmt.Errorf("defender needs a tenant id")
+			}
+			if e.opts.AuthURL == "" {
+				e.opts.AuthURL = "https://login.microsoftonline.com"
+			}
+		}
+		return e, nil
+	case "ad":
+		if opts.Host == "" {
+			return nil, fmt.Errorf("ad needs the host of a domain controller")
+		}
+		return &adExecutor{opts: opts}, nil
+	case "okta":
+		if opts.Token == "" {
+			return nil, fmt.Errorf("okta needs an api token")
+		}
+		api, err := newAPIClient(kind, opts.URL, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &oktaExecutor{api: api, token: opts.Token}, nil
+	}
+	return nil, fmt.Errorf("unknown executor type '%s', expected %s", kind, strings.Join(ExecutorKinds, ", "))
+}
+
+// blockedAddress returns an address or network to block in its canonical
+// form and whether it is IPv6
+func blockedAddress(target string) (string, bool, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return ip.String(), ip.To4() == nil, nil
+	}
+	if _, ipnet, err := net.ParseCIDR(target); err == nil {
+		return ipnet.String(), ipnet.IP.To4() == nil, nil
+	}
+	return "", false, fmt.Errorf("'%s' is not an IP address or network", target)
+}
+
+// runCommand runs a program and returns what it wrote, replaced in tests
+var runCommand = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return string(out), fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return string(out), fmt.Errorf("%s: %v", name, err)
+	}
+	return string(out), nil
+}
+
+// firewallExecutor blocks addresses on this host with iptables, or
+// ip6tables for IPv6, dropping traffic from and to them, or by adding
+// them to a pf table, which pf.conf must block
+type firewallExecutor struct {
+	kind string
+	opts ExecutorOptions
+}
+
+func (f *firewallExecutor) Kind() string { return f.kind }
+
+func (f *firewallExecutor) Actions() []string { return []string{ActionBlockIP, ActionUnblockIP} }
+
+func (f *firewallExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	addr, v6, err := blockedAddress(target)
+	if err != nil {
+		return "", err
+	}
+	block := action == ActionBlockIP
+
+	var commands [][]string
+	if f.kind == "pf" {
+		if block {
+			// Killing its states ends the connections already open
+			commands = [][]string{{"pfctl", "-t", f.opts.Table, "-T", "add", addr}, {"pfctl", "-k", addr}}
+		} else {
+			commands = [][]string{{"pfctl", "-t", f.opts.Table, "-T", "delete", addr}}
+		}
+	} else {
+		program, op := "iptables", "-I"
+		if v6 {
+			program = "ip6tables"
+		}
+		if !block {
+			op = "-D"
+		}
+		in, out := "INPUT", "OUTPUT"
+		if f.opts.Chain != "" {
+			in, out = f.opts.Chain, f.opts.Chain
+		}
+		commands = [][]string{{program, op, in, "-s", addr, "-j", "DROP"}, {program, op, out, "-d", addr, "-j", "DROP"}}
+	}
+
+	var ran []string
+	for _, command := range commands {
+		if dryRun {
+			ran = append(ran, strings.Join(command, " "))
+			continue
+		}
+		if f.kind == "iptables" {
+			// A rule is inserted once and only deleted when it is there
+			check := append([]string{command[0], "-C"}, command[2:]...)
+			_, err := runCommand(check[0], check[1:]...)
+			if (err == nil) == block {
+				continue
+			}
+		}
+		if _, err := runCommand(command[0], command[1:]...); err != nil {
+			return strings.Join(ran, "; "), err
+		}
+		ran = append(ran, strings.Join(command, " "))
+	}
+	verb := "blocked"
+	if !block {
+		verb = "unblocked"
+	}
+	switch {
+	case dryRun:
+		return fmt.Sprintf("would run %s", strings.Join(ran, "; ")), nil
+	case len(ran) == 0:
+		return fmt.Sprintf("%s was already %s", addr, verb), nil
+	}
+	return fmt.Sprintf("%s %s: %s", verb, addr, strings.Join(ran, "; ")), nil
+}
+
+// apiClient calls the HTTP API of an executor's system
+type apiClient struct {
+	base string
+	http *http.Client
+}
+
+// newAPIClient returns a client of the API at a base URL
+func newAPIClient(kind, rawURL string, opts ExecutorOptions) (*apiClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s needs an http or https url, got '%s'", kind, rawURL)
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	if opts.Insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return &apiClient{base: strings.TrimSuffix(rawURL, "/"), http: client}, nil
+}
+
+// call sends a request to a path of the API, or to a full URL, and returns
+// the body of its answer. Answers other than 2xx are errors that carry
+// what the API said.
+func (c *apiClient) call(method, path string, header map[string]string, body io.Reader) ([]byte, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = c.base + path
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		if msg := apiMessage(data); msg != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, strings.SplitN(path, "?", 2)[0], resp.Status, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, strings.SplitN(path, "?", 2)[0], resp.Status)
+	}
+	return data, nil
+}
+
+// callJSON sends a JSON body, if any, and decodes the answer into out, if
+// given
+func (c *apiClient) callJSON(method, path string, header map[string]string, body, out interface{}) error {
+	var reader io.Reader
+	headers := make(map[string]string)
+	for k, v := range header {
+		headers[k] = v
+	}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		headers["Content-Type"] = "application/json"
+	}
+	data, err := c.call(method, path, headers, reader)
+	if err != nil || out == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: bad answer: %v", method, strings.SplitN(path, "?", 2)[0], err)
+	}
+	return nil
+}
+
+// apiMessage returns the error message of an API's answer, in the forms
+// of Okta, CrowdStrike, Microsoft and OAuth, or its text
+func apiMessage(data []byte) string {
+	var answer struct {
+		ErrorSummary     string          `json:"errorSummary"`
+		ErrorDescription string          `json:"error_description"`
+		Error            json.RawMessage `json:"error"`
+		Errors           []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(data, &answer) == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		switch {
+		case answer.ErrorSummary != "":
+			return answer.ErrorSummary
+		case answer.ErrorDescription != "":
+			return answer.ErrorDescription
+		case len(answer.Errors) > 0 && answer.Errors[0].Message != "":
+			return answer.Errors[0].Message
+		case json.Unmarshal(answer.Error, &nested) == nil && nested.Message != "":
+			return nested.Message
+		}
+	}
+	text := strings.TrimSpace(string(data))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
+}
+
+// paloAltoExecutor blocks addresses on a Palo Alto firewall or Panorama
+// by tagging them through the User-ID API. A dynamic address group that
+// matches the tag must be used in a deny rule.
+type paloAltoExecutor struct {
+	api *apiClient
+	key string
+	tag string
+}
+
+func (p *paloAltoExecutor) Kind() string { return "paloalto" }
+
+func (p *paloAltoExecutor) Actions() []string { return []string{ActionBlockIP, ActionUnblockIP} }
+
+func (p *paloAltoExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	addr, _, err := blockedAddress(target)
+	if err != nil {
+		return "", err
+	}
+	op, verb := "register", "tagged"
+	if action == ActionUnblockIP {
+		op, verb = "unregister", "untagged"
+	}
+	if dryRun {
+		return fmt.Sprintf("would %s %s with the tag %s on %s", op, addr, p.tag, p.api.base), nil
+	}
+	var tag bytes.Buffer
+	xml.EscapeText(&tag, []byte(p.tag))
+	cmd := fmt.Sprintf(`<uid-message><version>2.0</version><type>update</type><payload><%s><entry ip="%s"><tag><member>%s</member></tag></entry></%[1]s></payload></uid-message>`,
+		op, addr, tag.String())
+	form := url.Values{"type": {"user-id"}, "cmd": {cmd}}
+	data, err := p.api.call("POST", "/api/", map[string]string{"X-PAN-KEY": p.key, "Content-Type": "application/x-www-form-urlencoded"},
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	var answer struct {
+		Status string `xml:"status,attr"`
+		Msg    struct {
+			Text  string   `xml:",chardata"`
+			Lines []string `xml:"line"`
+		} `xml:"msg"`
+	}
+	if err := xml.Unmarshal(data, &answer); err != nil {
+		return "", fmt.Errorf("bad answer from the firewall: %v", err)
+	}
+	if answer.Status != "success" {
+		msg := strings.TrimSpace(strings.Join(append(answer.Msg.Lines, answer.Msg.Text), " "))
+		return "", fmt.Errorf("the firewall refused to %s %s: %s", op, addr, msg)
+	}
+	return fmt.Sprintf("%s %s with %s", verb, addr, p.tag), nil
+}
+
+// deviceID matches the ids of CrowdStrike devices and Defender machines,
+// which are taken as they are rather than looked up as host names
+var deviceID = regexp.MustCompile(`^[0-9a-fA-F]{32}([0-9a-fA-F]{8})?$`)
+
+// edrExecutor isolates hosts from the network through CrowdStrike Falcon
+// or Microsoft Defender for Endpoint, with an OAuth client of the API
+type edrExecutor struct {
+	kind    string
+	api     *apiClient
+	opts    ExecutorOptions
+	token   string
+	expires time.Time
+}
+
+func (e *edrExecutor) Kind() string { return e.kind }
+
+func (e *edrExecutor) Actions() []string { return []string{ActionIsolateHost, ActionReleaseHost} }
+
+// bearer returns the authorization header of an access token, asking for
+// a new one when the last is about to expire
+func (e *edrExecutor) bearer() (map[string]string, error) {
+	if e.token == "" || time.Now().After(e.expires) {
+		form := url.Values{"client_id": {e.opts.ClientID}, "client_secret": {e.opts.ClientSecret}}
+		endpoint := "/oauth2/token"
+		if e.kind == "defender" {
+			form.Set("grant_type", "client_credentials")
+			form.Set("scope", "https://api.securitycenter.microsoft.com/.default")
+			endpoint = strings.TrimSuffix(e.opts.AuthURL, "/") + "/" + url.PathEscape(e.opts.TenantID) + "/oauth2/v2.0/token"
+		}
+		data, err := e.api.call("POST", endpoint, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, fmt.Errorf("sign-in failed: %v", err)
+		}
+		var answer struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}
+		if json.Unmarshal(data, &answer) != nil || answer.AccessToken == "" {
+			return nil, fmt.Errorf("sign-in failed: no access token in the answer")
+		}
+		e.token = answer.AccessToken
+		e.expires = time.Now().Add(time.Duration(answer.ExpiresIn)*time.Second - time.Minute)
+	}
+	return map[string]string{"Authorization": "Bearer " + e.token}, nil
+}
+
+// device returns the id of a host, looking up a host name
+func (e *edrExecutor) device(host string, auth map[string]string) (string, error) {
+	if deviceID.MatchString(host) {
+		return host, nil
+	}
+	var ids []string
+	if e.kind == "crowdstrike" {
+		var answer struct {
+			Resources []string `json:"resources"`
+		}
+		query := url.Values{"filter": {fmt.Sprintf("hostname:'%s'", strings.ReplaceAll(host, "'", `\'`))}}
+		if err := e.api.callJSON("GET", "/devices/queries/devices/v1?"+query.Encode(), auth, nil, &answer); err != nil {
+			return "", err
+		}
+		ids = answer.Resources
+	} else {
+		var answer struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+		}
+		query := url.Values{"$filter": {fmt.Sprintf("computerDnsName eq '%s'", strings.ReplaceAll(strings.ToLower(host), "'", "''"))}}
+		if err := e.api.callJSON("GET", "/api/machines?"+query.Encode(), auth, nil, &answer); err != nil {
+			return "", err
+		}
+		for _, m := range answer.Value {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) != 1 {
+		return "", fmt.Errorf("found %d hosts named '%s'", len(ids), host)
+	}
+	return ids[0], nil
+}
+
+func (e *edrExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	isolate := action == ActionIsolateHost
+	verb := "isolate"
+	if !isolate {
+		verb = "release"
+	}
+	if dryRun {
+		return fmt.Sprintf("would %s %s through %s", verb, target, e.kind), nil
+	}
+	auth, err := e.bearer()
+	if err != nil {
+		return "", err
+	}
+	id, err := e.device(target, auth)
+	if err != nil {
+		return "", err
+	}
+	comment, _ := params["comment"].(string)
+	if comment == "" {
+		comment = fmt.Sprintf("Sentra response to incident %v", params["incident_id"])
+	}
+
+	if e.kind == "crowdstrike" {
+		name := "contain"
+		if !isolate {
+			name = "lift_containment"
+		}
+		var answer struct {
+			Resources []struct {
+				ID string `json:"id"`
+			} `json:"resources"`
+		}
+		body := map[string]interface{}{"ids": []string{id}}
+		if err := e.api.callJSON("POST", "/devices/entities/devices-actions/v2?action_name="+name, auth, body, &answer); err != nil {
+			return "", err
+		}
+		if len(answer.Resources) == 0 {
+			return "", fmt.Errorf("crowdstrike did not %s %s", verb, target)
+		}
+		return fmt.Sprintf("asked crowdstrike to %s %s (device %s)", verb, target, id), nil
+	}
+
+	body := map[string]interface{}{"Comment": comment}
+	endpoint := "/api/machines/" + url.PathEscape(id) + "/unisolate"
+	if isolate {
+		isolation, _ := params["isolation_type"].(string)
+		if isolation == "" {
+			isolation = "Full"
+		}
+		body["IsolationType"] = isolation
+		endpoint = "/api/machines/" + url.PathEscape(id) + "/isolate"
+	}
+	var answer struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := e.api.callJSON("POST", endpoint, auth, body, &answer); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("asked defender to %s %s (machine %s, action %s %s)", verb, target, id, answer.ID, answer.Status), nil
+}
+
+// adExecutor disables and enables Active Directory accounts over LDAP
+type adExecutor struct {
+	opts ExecutorOptions
+}
+
+func (a *adExecutor) Kind() string { return "ad" }
+
+func (a *adExecutor) Actions() []string { return []string{ActionDisableAccount, ActionEnableAccount} }
+
+func (a *adExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	enable := action == ActionEnableAccount
+	verb := "disable"
+	if enable {
+		verb = "enable"
+	}
+	if dryRun {
+		return fmt.Sprintf("would %s the AD account %s on %s", verb, target, a.opts.Host), nil
+	}
+	conn, err := network.LDAPConnect(a.opts.Host, network.LDAPOptions{Port: a.opts.Port, TLS: a.opts.TLS, Username: a.opts.Username,
+		Password: a.opts.Password, Domain: a.opts.Domain, BaseDN: a.opts.BaseDN, Timeout: a.opts.Timeout, Insecure: a.opts.Insecure})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	dn, wasEnabled, err := conn.ADSetAccountEnabled(target, enable)
+	if err != nil {
+		return "", err
+	}
+	if wasEnabled == enable {
+		return fmt.Sprintf("%s was already %sd", dn, verb), nil
+	}
+	return fmt.Sprintf("%sd %s", verb, dn), nil
+}
+
+// oktaExecutor suspends Okta users, ending their sessions, and lifts the
+// suspension
+type oktaExecutor struct {
+	api   *apiClient
+	token string
+}
+
+func (o *oktaExecutor) Kind() string { return "okta" }
+
+func (o *oktaExecutor) Actions() []string { return []string{ActionDisableAccount, ActionEnableAccount} }
+
+func (o *oktaExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	enable := action == ActionEnableAccount
+	if dryRun {
+		if enable {
+			return fmt.Sprintf("would unsuspend the Okta user %s", target), nil
+		}
+		return fmt.Sprintf("would suspend the Okta user %s and end their sessions", target), nil
+	}
+	auth := map[string]string{"Authorization": "SSWS " + o.token}
+	var user struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := o.api.callJSON("GET", "/api/v1/users/"+url.PathEscape(target), auth, nil, &user); err != nil {
+		return "", err
+	}
+	userPath := "/api/v1/users/" + url.PathEscape(user.ID)
+	if enable {
+		if user.Status != "SUSPENDED" {
+			return fmt.Sprintf("%s is not suspended but %s", target, strings.ToLower(user.Status)), nil
+		}
+		if _, err := o.api.call("POST", userPath+"/lifecycle/unsuspend", auth, nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("unsuspended %s (%s)", target, user.ID), nil
+	}
+	if user.Status != "SUSPENDED" {
+		if _, err := o.api.call("POST", userPath+"/lifecycle/suspend", auth, nil); err != nil {
+			return "", err
+		}
+	}
+	if _, err := o.api.call("DELETE", userPath+"/sessions", auth, nil); err != nil {
+		return "", fmt.Errorf("suspended %s but could not end their sessions: %v", target, err)
+	}
+	return fmt.Sprintf("suspended %s (%s) and ended their sessions", target, user.ID), nil
+}
@@ -0,0 +1,277 @@
+package incident
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Executor carries out response actions on a system outside Sentra: a
+// firewall, an EDR or an identity provider. The built-in executors are
+// made by NewExecutor; others can be added with RegisterExecutor.
+type Executor interface {
+	// Kind names the system it acts on, like iptables or okta
+	Kind() string
+	// Actions are the action types it carries out, like block_ip
+	Actions() []string
+	// Execute carries out an action on a target, an address, a host or
+	// an account, and describes what it did. With dryRun set it changes
+	// nothing and describes what it would do.
+	Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error)
+}
+
+// The action types of the built-in executors
+const (
+	ActionBlockIP        = "block_ip"
+	ActionUnblockIP      = "unblock_ip"
+	ActionIsolateHost    = "isolate_host"
+	ActionReleaseHost    = "release_host"
+	ActionDisableAccount = "disable_account"
+	ActionEnableAccount  = "enable_account"
+)
+
+// responseActionTypes are the executor actions the types of response
+// actions stand for
+var responseActionTypes = map[string]string{
+	"block":   ActionBlockIP,
+	"isolate": ActionIsolateHost,
+	"disable": ActionDisableAccount,
+}
+
+// registeredExecutor is an executor under its name
+type registeredExecutor struct {
+	executor Executor
+	dryRun   bool // Every action it is given is a dry run
+}
+
+// ExecutorInfo describes a registered executor
+type ExecutorInfo struct {
+	Name    string
+	Kind    string
+	Actions []string
+	DryRun  bool
+}
+
+// AuditEntry records an action given to an executor, whether it was
+// carried out, failed or only tried as a dry run
+type AuditEntry struct {
+	Time       time.Time
+	IncidentID string
+	Executor   string
+	Kind       string
+	Action     string
+	Target     string
+	DryRun     bool
+	Status     string // success, failed or dry_run
+	Result     string
+	Error      string
+	Duration   time.Duration
+}
+
+// RegisterExecutor adds an executor under a name, replacing any of the
+// same name. With dryRun set every action it is given is a dry run.
+func (ir *IncidentModule) RegisterExecutor(name string, e Executor, dryRun bool) {
+	if ir.executors == nil {
+		ir.executors = make(map[string]*registeredExecutor)
+	}
+	ir.executors[name] = &registeredExecutor{executor: e, dryRun: dryRun}
+}
+
+// ListExecutors returns the registered executors ordered by name
+func (ir *IncidentModule) ListExecutors() []ExecutorInfo {
+	list := make([]ExecutorInfo, 0, len(ir.executors))
+	for name, r := range ir.executors {
+		list = append(list, ExecutorInfo{Name: name, Kind: r.executor.Kind(), Actions: r.executor.Actions(), DryRun: r.dryRun})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SetAuditFile appends every later audit entry to a file as a line of
+// JSON. An empty path stops writing them.
+func (ir *IncidentModule) SetAuditFile(path string) error {
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	ir.auditFile = path
+	return nil
+}
+
+// AuditLog returns the actions given to executors in the order they were
+// given, only those of an incident when incidentID is set
+func (ir *IncidentModule) AuditLog(incidentID string) []AuditEntry {
+	var entries []AuditEntry
+	for _, e := range ir.audit {
+		if incidentID == "" || e.IncidentID == incidentID {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// RunAction has an executor carry out an action for an incident. The
+// action is audited and recorded among the incident's actions whether it
+// succeeds, fails or is a dry run.
+func (ir *IncidentModule) RunAction(incidentID, executor, action, target string, params map[string]interface{}, dryRun bool) (*IncidentResponse, error) {
+	incident, exists := ir.Incidents[incidentID]
+	if !exists {
+		return nil, fmt.Errorf("incident not found: %s", incidentID)
+	}
+	r, exists := ir.executors[executor]
+	if !exists {
+		return nil, fmt.Errorf("executor not found: %s", executor)
+	}
+	supported := r.executor.Actions()
+	found := false
+	for _, a := range supported {
+		found = found || a == action
+	}
+	if !found {
+		return nil, fmt.Errorf("executor %s does not carry out %s, only %s", executor, action, strings.Join(supported, ", "))
+	}
+	if target == "" {
+		return nil, fmt.Errorf("%s needs a target", action)
+	}
+	// Executors see the incident, to name it in comments on the systems
+	// they change
+	withIncident := map[string]interface{}{"incident_id": incidentID}
+	for k, v := range params {
+		withIncident[k] = v
+	}
+
+	dryRun = dryRun || r.dryRun
+	started := time.Now()
+	result, err := r.executor.Execute(action, target, withIncident, dryRun)
+	entry := AuditEntry{Time: started, IncidentID: incidentID, Executor: executor, Kind: r.executor.Kind(), Action: action,
+		Target: target, DryRun: dryRun, Status: "success", Result: result, Duration: time.Since(started)}
+	switch {
+	case err != nil:
+		entry.Status, entry.Error = "failed", err.Error()
+	case dryRun:
+		entry.Status = "dry_run"
+	}
+	ir.audit = append(ir.audit, entry)
+	recorded := result
+	if err != nil {
+		recorded = entry.Error
+	}
+	incident.Actions = append(incident.Actions, ActionRecord{
+		ID:          fmt.Sprintf("ACT-%d", time.Now().UnixNano()),
+		ActionType:  action,
+		Description: fmt.Sprintf("%s %s with %s", action, target, executor),
+		ExecutedAt:  started,
+		ExecutedBy:  executor,
+		Status:      entry.Status,
+		Result:      recorded,
+		Duration:    entry.Duration,
+	})
+	auditErr := ir.writeAudit(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", executor, err)
+	}
+	if auditErr != nil {
+		return nil, fmt.Errorf("%s %s: %s, but the audit file was not written: %v", action, target, result, auditErr)
+	}
+
+	nextSteps := []string{"Review action results"}
+	for kind, a := range responseActionTypes {
+		if a == action {
+			nextSteps = ir.generateNextSteps(kind)
+		}
+	}
+	return &IncidentResponse{
+		IncidentID: incidentID,
+		Action:     action,
+		Status:     entry.Status,
+		Message:    result,
+		Evidence:   []string{result},
+		NextSteps:  nextSteps,
+		ExecutedAt: started,
+	}, nil
+}
+
+// responseActionTargets are the parameters that name the target of each
+// type of response action
+var responseActionTargets = map[string]string{
+	"block":   "ip",
+	"isolate": "host",
+	"disable": "user",
+}
+
+// runResponseAction has an executor carry out a response action. The
+// executor action is the action parameter or the one the response
+// action's type stands for, and the target is the target parameter or
+// the ip, host or user the type takes.
+func (ir *IncidentModule) runResponseAction(incidentID, executor string, action *ResponseAction, parameters map[string]interface{}) (*IncidentResponse, error) {
+	name, _ := parameters["action"].(string)
+	if name == "" {
+		name = responseActionTypes[action.Type]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("response action %s has no executor action, give one as action", action.ID)
+	}
+	target, _ := parameters["target"].(string)
+	if v, ok := parameters[responseActionTargets[action.Type]]; target == "" && ok && v != nil {
+		target = fmt.Sprint(v)
+	}
+	params := make(map[string]interface{})
+	for k, v := range parameters {
+		switch k {
+		case "executor", "action", "target", "dry_run":
+		default:
+			params[k] = v
+		}
+	}
+	dryRun, _ := parameters["dry_run"].(bool)
+	response, err := ir.RunAction(incidentID, executor, name, target, params, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	response.Action = action.Name
+	return response, nil
+}
+
+// writeAudit appends an audit entry to the audit file, if there is one
+func (ir *IncidentModule) writeAudit(e AuditEntry) error {
+	if ir.auditFile == "" {
+		return nil
+	}
+	line, err := json.Marshal(AuditEntryToMap(e))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ir.auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditEntryToMap returns an audit entry as a map, as the audit file
+// holds it
+func AuditEntryToMap(e AuditEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"time":        e.Time.UTC().Format(time.RFC3339Nano),
+		"incident_id": e.IncidentID,
+		"executor":    e.Executor,
+		"kind":        e.Kind,
+		"action":      e.Action,
+		"target":      e.Target,
+		"dry_run":     e.DryRun,
+		"status":      e.Status,
+		"result":      e.Result,
+		"error":       e.Error,
+		"duration_ms": e.Duration.Milliseconds(),
+	}
+}
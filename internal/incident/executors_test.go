@@ -0,0 +1,253 @@
+package incident
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeExecutor disables accounts, failing for the account locked
+type fakeExecutor struct {
+	calls []string
+}
+
+func (f *fakeExecutor) Kind() string { return "fake" }
+
+func (f *fakeExecutor) Actions() []string { return []string{ActionDisableAccount} }
+
+func (f *fakeExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	f.calls = append(f.calls, target)
+	if target == "locked" {
+		return "", errors.New("account is locked")
+	}
+	if dryRun {
+		return "would disable " + target, nil
+	}
+	return "disabled " + target + " for " + params["incident_id"].(string), nil
+}
+
+func TestRunAction(t *testing.T) {
+	ir := NewIncidentModule()
+	ir.CreateDefaultResponseActions()
+	inc := ir.CreateIncident("Phish", "Credentials entered", "high", "mail")
+	fake := &fakeExecutor{}
+	ir.RegisterExecutor("idp", fake, false)
+	ir.RegisterExecutor("staging", fake, true)
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := ir.SetAuditFile(auditPath); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ir.RunAction(inc.ID, "idp", ActionDisableAccount, "jdoe", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "success" || response.Message != "disabled jdoe for "+inc.ID {
+		t.Errorf("response = %+v", response)
+	}
+	if response, err = ir.RunAction(inc.ID, "idp", ActionDisableAccount, "jdoe", nil, true); err != nil || response.Status != "dry_run" {
+		t.Errorf("dry run = %+v, %v", response, err)
+	}
+	// An executor registered for dry runs never carries an action out
+	if response, err = ir.RunAction(inc.ID, "staging", ActionDisableAccount, "jdoe", nil, false); err != nil || response.Message != "would disable jdoe" {
+		t.Errorf("staging = %+v, %v", response, err)
+	}
+	if _, err := ir.RunAction(inc.ID, "idp", ActionDisableAccount, "locked", nil, false); err == nil || err.Error() != "idp: account is locked" {
+		t.Errorf("failed action err = %v", err)
+	}
+	// Response actions given an executor are carried out by it
+	response, err = ir.ExecuteResponseAction(inc.ID, "RA-003", map[string]interface{}{"executor": "idp", "user": "asmith"})
+	if err != nil || response.Action != "Disable Account" || response.Message != "disabled asmith for "+inc.ID {
+		t.Errorf("response action = %+v, %v", response, err)
+	}
+
+	for _, c := range []struct {
+		executor, action, target, want string
+	}{
+		{"edr", ActionDisableAccount, "jdoe", "executor not found: edr"},
+		{"idp", ActionBlockIP, "203.0.113.7", "executor idp does not carry out block_ip, only disable_account"},
+		{"idp", ActionDisableAccount, "", "disable_account needs a target"},
+	} {
+		if _, err := ir.RunAction(inc.ID, c.executor, c.action, c.target, nil, false); err == nil || err.Error() != c.want {
+			t.Errorf("RunAction(%s, %s, %q) err = %v, want %s", c.executor, c.action, c.target, err, c.want)
+		}
+	}
+	if _, err := ir.RunAction("INC-0", "idp", ActionDisableAccount, "jdoe", nil, false); err == nil {
+		t.Error("a missing incident was not reported")
+	}
+
+	var statuses []string
+	for _, e := range ir.AuditLog(inc.ID) {
+		statuses = append(statuses, e.Executor+":"+e.Status)
+	}
+	if strings.Join(statuses, " ") != "idp:success idp:dry_run staging:dry_run idp:failed idp:success" {
+		t.Errorf("audit = %v", statuses)
+	}
+	if len(fake.calls) != 5 || len(inc.Actions) != 5 || inc.Actions[3].Status != "failed" || inc.Actions[3].Result != "account is locked" {
+		t.Errorf("calls = %v, actions = %+v", fake.calls, inc.Actions)
+	}
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last map[string]interface{}
+	if len(lines) != 5 || json.Unmarshal([]byte(lines[3]), &last) != nil || last["error"] != "account is locked" || last["target"] != "locked" {
+		t.Errorf("audit file = %s", data)
+	}
+	if list := ir.ListExecutors(); len(list) != 2 || list[0].Name != "idp" || !list[1].DryRun {
+		t.Errorf("executors = %+v", list)
+	}
+}
+
+func TestFirewallExecutors(t *testing.T) {
+	var ran []string
+	existing := map[string]bool{"iptables -C OUTPUT -d 203.0.113.7 -j DROP": true}
+	defer func(saved func(string, ...string) (string, error)) { runCommand = saved }(runCommand)
+	runCommand = func(name string, args ...string) (string, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		if args[0] == "-C" {
+			if existing[command] {
+				return "", nil
+			}
+			return "", errors.New("no such rule")
+		}
+		ran = append(ran, command)
+		return "", nil
+	}
+
+	iptables, _ := NewExecutor("iptables", ExecutorOptions{})
+	result, err := iptables.Execute(ActionBlockIP, "203.0.113.7", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rule already there is not added again
+	if strings.Join(ran, "|") != "iptables -I INPUT -s 203.0.113.7 -j DROP" || result != "blocked 203.0.113.7: iptables -I INPUT -s 203.0.113.7 -j DROP" {
+		t.Errorf("ran %q, result %q", ran, result)
+	}
+	ran = nil
+	if result, _ := iptables.Execute(ActionBlockIP, "2001:db8::/32", nil, true); len(ran) != 0 ||
+		result != "would run ip6tables -I INPUT -s 2001:db8::/32 -j DROP; ip6tables -I OUTPUT -d 2001:db8::/32 -j DROP" {
+		t.Errorf("dry run ran %q, result %q", ran, result)
+	}
+	if _, err := iptables.Execute(ActionBlockIP, "10.0.0.1; reboot", nil, false); err == nil || !strings.Contains(err.Error(), "is not an IP address or network") {
+		t.Errorf("bad address err = %v", err)
+	}
+
+	pf, _ := NewExecutor("pf", ExecutorOptions{Table: "bad_hosts"})
+	if _, err := pf.Execute(ActionUnblockIP, "198.51.100.9", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ran, "|") != "pfctl -t bad_hosts -T delete 198.51.100.9" {
+		t.Errorf("pf ran %q", ran)
+	}
+}
+
+func TestAPIExecutors(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/api/":
+			form, _ := url.ParseQuery(string(body))
+			if r.Header.Get("X-PAN-KEY") != "pan-key" || !strings.Contains(form.Get("cmd"), `<register><entry ip="203.0.113.7"><tag><member>c2</member>`) {
+				w.Write([]byte(`<response status="error"><msg><line>bad request</line></msg></response>`))
+				return
+			}
+			w.Write([]byte(`<response status="success"><result><uid-response><version>2.0</version></uid-response></result></response>`))
+		case r.URL.Path == "/oauth2/token" || strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token"):
+			form, _ := url.ParseQuery(string(body))
+			if form.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "invalid_client", "error_description": "bad client secret"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token": "tok", "expires_in": 1799}`))
+		case r.Header.Get("Authorization") != "Bearer tok" && r.Header.Get("Authorization") != "SSWS okta-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/devices/queries/devices/v1":
+			w.Write([]byte(`{"resources": ["0123456789abcdef0123456789abcdef"]}`))
+		case r.URL.Path == "/devices/entities/devices-actions/v2":
+			if r.URL.Query().Get("action_name") != "contain" || !strings.Contains(string(body), "0123456789abcdef0123456789abcdef") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"resources": [{"id": "0123456789abcdef0123456789abcdef"}], "errors": []}`))
+		case r.URL.Path == "/api/machines":
+			w.Write([]byte(`{"value": []}`))
+		case r.URL.Path == "/api/v1/users/jdoe@example.com":
+			w.Write([]byte(`{"id": "00u1", "status": "ACTIVE"}`))
+		case r.URL.Path == "/api/v1/users/00u1/lifecycle/suspend":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/api/v1/users/00u1/sessions" && r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errorCode": "E0000007", "errorSummary": "Not found: Resource not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	paloalto, err := NewExecutor("paloalto", ExecutorOptions{URL: server.URL, APIKey: "pan-key", Tag: "c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := paloalto.Execute(ActionBlockIP, "203.0.113.7", nil, false); err != nil || result != "tagged 203.0.113.7 with c2" {
+		t.Errorf("paloalto = %q, %v", result, err)
+	}
+	if _, err := paloalto.Execute(ActionUnblockIP, "203.0.113.7", nil, false); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Errorf("paloalto refusal err = %v", err)
+	}
+
+	crowdstrike, _ := NewExecutor("crowdstrike", ExecutorOptions{URL: server.URL, ClientID: "id", ClientSecret: "secret"})
+	if result, err := crowdstrike.Execute(ActionIsolateHost, "web1", map[string]interface{}{"incident_id": "INC-1"}, false); err != nil ||
+		result != "asked crowdstrike to isolate web1 (device 0123456789abcdef0123456789abcdef)" {
+		t.Errorf("crowdstrike = %q, %v", result, err)
+	}
+	defender, _ := NewExecutor("defender", ExecutorOptions{URL: server.URL, AuthURL: server.URL, TenantID: "t1", ClientID: "id", ClientSecret: "wrong"})
+	if _, err := defender.Execute(ActionIsolateHost, "web1", nil, false); err == nil || !strings.Contains(err.Error(), "bad client secret") {
+		t.Errorf("defender sign-in err = %v", err)
+	}
+	defender, _ = NewExecutor("defender", ExecutorOptions{URL: server.URL, AuthURL: server.URL, TenantID: "t1", ClientID: "id", ClientSecret: "secret"})
+	if _, err := defender.Execute(ActionReleaseHost, "web1", nil, false); err == nil || err.Error() != "found 0 hosts named 'web1'" {
+		t.Errorf("defender lookup err = %v", err)
+	}
+
+	okta, _ := NewExecutor("okta", ExecutorOptions{URL: server.URL, Token: "okta-token"})
+	requests = nil
+	if result, err := okta.Execute(ActionDisableAccount, "jdoe@example.com", nil, false); err != nil || result != "suspended jdoe@example.com (00u1) and ended their sessions" {
+		t.Errorf("okta = %q, %v", result, err)
+	}
+	if strings.Join(requests, "|") != "GET /api/v1/users/jdoe@example.com|POST /api/v1/users/00u1/lifecycle/suspend|DELETE /api/v1/users/00u1/sessions" {
+		t.Errorf("okta requests = %q", requests)
+	}
+	if _, err := okta.Execute(ActionDisableAccount, "nobody", nil, false); err == nil || !strings.Contains(err.Error(), "404 Not Found: Not found: Resource not found") {
+		t.Errorf("okta missing user err = %v", err)
+	}
+	// A dry run calls no API
+	requests = nil
+	if result, _ := okta.Execute(ActionEnableAccount, "jdoe@example.com", nil, true); result != "would unsuspend the Okta user jdoe@example.com" || len(requests) != 0 {
+		t.Errorf("okta dry run = %q, requests %q", result, requests)
+	}
+
+	for kind, opts := range map[string]ExecutorOptions{
+		"paloalto":    {URL: server.URL},
+		"crowdstrike": {ClientID: "id"},
+		"defender":    {ClientID: "id", ClientSecret: "secret"},
+		"ad":          {},
+		"okta":        {URL: "ftp://okta", Token: "t"},
+		"checkpoint":  {},
+	} {
+		if _, err := NewExecutor(kind, opts); err == nil {
+			t.Errorf("NewExecutor(%s) took missing options", kind)
+		}
+	}
+}
@@ -14,6 +14,10 @@ type IncidentModule struct {
 	ResponseActions map[string]*ResponseAction
 	AlertRules      []*AlertRule
 	Workflows       map[string]*Workflow
+
+	executors map[string]*registeredExecutor
+	audit     []AuditEntry
+	auditFile string // Audit entries are appended to it when set
 }
 
 // Incident represents a security incident
@@ -325,6 +329,11 @@ func (ir *IncidentModule) ExecuteResponseAction(incidentID, actionID string, par
 		return nil, fmt.Errorf("response action not found: %s", actionID)
 	}
 	
+	// Given an executor, the action is carried out rather than simulated
+	if executor, ok := parameters["executor"].(string); ok && executor != "" {
+		return ir.runResponseAction(incidentID, executor, action, parameters)
+	}
+	
 	// Execute the action
 	result := ir.executeAction(action, parameters)
 	
@@ -678,6 +687,26 @@ func (ir *IncidentModule) CreateDefaultResponseActions() {
 			IsEnabled:   true,
 			CreatedAt:   time.Now(),
 		},
+		{
+			ID:          "RA-002",
+			Name:        "Block IP",
+			Type:        "block",
+			Description: "Block traffic from and to a malicious address",
+			Parameters:  map[string]interface{}{"ip": "required"},
+			Permissions: []string{"network_admin"},
+			IsEnabled:   true,
+			CreatedAt:   time.Now(),
+		},
+		{
+			ID:          "RA-003",
+			Name:        "Disable Account",
+			Type:        "disable",
+			Description: "Disable a compromised user account",
+			Parameters:  map[string]interface{}{"user": "required"},
+			Permissions: []string{"identity_admin"},
+			IsEnabled:   true,
+			CreatedAt:   time.Now(),
+		},
 		// Additional actions can be loaded later for performance
 	}
 	
@@ -0,0 +1,204 @@
+package incident
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxTimelineFiles bounds how many files the walk of a directory adds
+const maxTimelineFiles = 100000
+
+// TimelineEntry is one event of an investigation's timeline: something an
+// incident recorded, a log event, a file change, a process start or an
+// artifact collected from a host
+type TimelineEntry struct {
+	Time    time.Time // Zero when the event carries no time
+	Source  string    // incident, siem, filesystem, memory or an artifact collector
+	Type    string
+	Host    string
+	User    string
+	Summary string
+	Details map[string]interface{}
+}
+
+// TimelineOptions choose what a timeline holds besides the incident's own
+// history
+type TimelineOptions struct {
+	// Paths are files and directories whose modification times are added,
+	// along with those of the files collected as evidence
+	Paths []string
+	// Entries come from other sources, such as SIEM events, memory images
+	// and collected artifacts
+	Entries []*TimelineEntry
+	// Start and End, when set, drop the events outside them
+	Start, End time.Time
+}
+
+// BuildTimeline merges the history of an incident, its response actions,
+// the modification times of its evidence files and the entries of other
+// sources into one timeline ordered by time. Events without a time come
+// last.
+func (ir *IncidentModule) BuildTimeline(incidentID string, opts TimelineOptions) ([]*TimelineEntry, error) {
+	incident, exists := ir.Incidents[incidentID]
+	if !exists {
+		return nil, fmt.Errorf("incident not found: %s", incidentID)
+	}
+	var entries []*TimelineEntry
+	for _, event := range incident.Timeline {
+		details := map[string]interface{}{"incident_id": incident.ID}
+		for k, v := range event.Details {
+			details[k] = v
+		}
+		entries = append(entries, &TimelineEntry{Time: event.Timestamp, Source: "incident", Type: event.Event,
+			User: event.Actor, Summary: event.Description, Details: details})
+	}
+	for _, action := range incident.Actions {
+		entries = append(entries, &TimelineEntry{Time: action.ExecutedAt, Source: "response", Type: action.ActionType,
+			User: action.ExecutedBy, Summary: action.Description,
+			Details: map[string]interface{}{"incident_id": incident.ID, "status": action.Status, "result": action.Result}})
+	}
+	// A file listed twice, or both as evidence and under a path, is added once
+	seen := make(map[string]bool)
+	for _, path := range opts.Paths {
+		found, err := fileTimeline(path, seen)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	// Evidence files that are no longer there are left out
+	for _, artifact := range incident.Artifacts {
+		if artifact.Type == "file" {
+			if found, err := fileTimeline(artifact.Value, seen); err == nil {
+				entries = append(entries, found...)
+			}
+		}
+	}
+	for _, e := range opts.Entries {
+		if e.Details == nil {
+			e.Details = map[string]interface{}{}
+		}
+		entries = append(entries, e)
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if !e.Time.IsZero() && (!opts.Start.IsZero() && e.Time.Before(opts.Start) || !opts.End.IsZero() && e.Time.After(opts.End)) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	SortTimeline(kept)
+	return kept, nil
+}
+
+// SortTimeline orders entries by time, those without a time last
+func SortTimeline(entries []*TimelineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Time, entries[j].Time
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.Before(b)
+	})
+}
+
+// fileTimeline returns an entry for the modification of a file, or of each
+// file under a directory, leaving out those seen already
+func fileTimeline(root string, seen map[string]bool) ([]*TimelineEntry, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+	var entries []*TimelineEntry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory does not end the walk
+			return nil
+		}
+		if len(entries) >= maxTimelineFiles {
+			return filepath.SkipAll
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			if seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		kind := "file"
+		if d.IsDir() {
+			kind = "directory"
+		}
+		entries = append(entries, &TimelineEntry{Time: info.ModTime().UTC(), Source: "filesystem", Type: "modified", Summary: path,
+			Details: map[string]interface{}{"path": path, "kind": kind, "size": info.Size(), "mode": info.Mode().String()}})
+		return nil
+	})
+	return entries, err
+}
+
+// timelineColumns are the columns of a timeline exported as CSV
+var timelineColumns = []string{"time", "source", "type", "host", "user", "summary", "details"}
+
+// ExportTimeline writes a timeline to a file as csv, one row per entry
+// with its details as JSON, or as a json array
+func ExportTimeline(entries []*TimelineEntry, format, path string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown timeline format '%s'; use csv or json", format)
+	}
+	records := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		at := ""
+		if !e.Time.IsZero() {
+			at = e.Time.Format(time.RFC3339Nano)
+		}
+		details := e.Details
+		if details == nil {
+			details = map[string]interface{}{}
+		}
+		records[i] = map[string]interface{}{"time": at, "source": e.Source, "type": e.Type, "host": e.Host,
+			"user": e.User, "summary": e.Summary, "details": details}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	w := csv.NewWriter(f)
+	w.Write(timelineColumns)
+	for _, r := range records {
+		details, err := json.Marshal(r["details"])
+		if err != nil {
+			f.Close()
+			return err
+		}
+		row := make([]string, len(timelineColumns))
+		for i, column := range timelineColumns[:len(timelineColumns)-1] {
+			row[i] = r[column].(string)
+		}
+		row[len(row)-1] = string(details)
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
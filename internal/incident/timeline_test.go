@@ -0,0 +1,111 @@
+package incident
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	ir := NewIncidentModule()
+	inc := ir.CreateIncident("Beacon", "Host calling out", "high", "edr")
+	dir := t.TempDir()
+	dropped := filepath.Join(dir, "dropper.sh")
+	os.WriteFile(dropped, []byte("#!/bin/sh\n"), 0o755)
+	dropTime := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	os.Chtimes(dropped, dropTime, dropTime)
+	if err := ir.CollectEvidence(inc.ID, "file", dropped, "triage"); err != nil {
+		t.Fatal(err)
+	}
+	ir.CollectEvidence(inc.ID, "file", filepath.Join(dir, "gone"), "triage")
+
+	logins := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	entries, err := ir.BuildTimeline(inc.ID, TimelineOptions{Entries: []*TimelineEntry{
+		{Time: logins, Source: "siem", Type: "authentication", Host: "web1", Summary: "Accepted password for root"},
+		{Source: "memory", Type: "handle", Summary: "memfd:payload"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Source+"/"+e.Type)
+	}
+	want := "siem/authentication filesystem/modified incident/incident_created incident/evidence_collected incident/evidence_collected memory/handle"
+	if strings.Join(got, " ") != want {
+		t.Errorf("timeline = %v, want %s", got, want)
+	}
+	if entries[1].Summary != dropped || !entries[1].Time.Equal(dropTime) {
+		t.Errorf("file entry = %+v", entries[1])
+	}
+	if entries[2].Details["incident_id"] != inc.ID {
+		t.Errorf("incident entry details = %v", entries[2].Details)
+	}
+
+	// A window drops timed events outside it but keeps untimed ones
+	entries, err = ir.BuildTimeline(inc.ID, TimelineOptions{Paths: []string{dir}, Start: dropTime.Add(-time.Minute), End: dropTime.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Summary != dropped {
+		t.Errorf("windowed timeline = %+v", entries)
+	}
+
+	if _, err := ir.BuildTimeline("INC-0", TimelineOptions{}); err == nil || !strings.Contains(err.Error(), "incident not found: INC-0") {
+		t.Errorf("missing incident error = %v", err)
+	}
+	if _, err := ir.BuildTimeline(inc.ID, TimelineOptions{Paths: []string{filepath.Join(dir, "none")}}); err == nil {
+		t.Error("a missing path was not reported")
+	}
+}
+
+func TestExportTimeline(t *testing.T) {
+	at := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	entries := []*TimelineEntry{
+		{Time: at, Source: "siem", Type: "authentication", Host: "web1", User: "root", Summary: "Accepted password, from 10.0.0.5",
+			Details: map[string]interface{}{"port": 22}},
+		{Source: "memory", Type: "handle", Summary: "memfd:payload"},
+	}
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "timeline.csv")
+	if err := ExportTimeline(entries, "csv", path); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(path)
+	rows, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != "time,source,type,host,user,summary,details" {
+		t.Fatalf("csv rows = %v", rows)
+	}
+	if strings.Join(rows[1], "|") != `2026-03-04T09:00:00Z|siem|authentication|web1|root|Accepted password, from 10.0.0.5|{"port":22}` {
+		t.Errorf("csv row = %v", rows[1])
+	}
+	if rows[2][0] != "" || rows[2][6] != "{}" {
+		t.Errorf("untimed csv row = %v", rows[2])
+	}
+
+	path = filepath.Join(dir, "timeline.json")
+	if err := ExportTimeline(entries, "json", path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	var records []map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0]["time"] != "2026-03-04T09:00:00Z" || records[0]["details"].(map[string]interface{})["port"] != 22.0 {
+		t.Errorf("json records = %v", records)
+	}
+
+	if err := ExportTimeline(entries, "xml", path); err == nil || !strings.Contains(err.Error(), "unknown timeline format 'xml'") {
+		t.Errorf("unknown format error = %v", err)
+	}
+}
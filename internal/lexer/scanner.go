@@ -1,8 +1,13 @@
 package lexer
 
 import (
+	"encoding/hex"
+	stderrors "errors"
 	"fmt"
+	"strings"
 	"unicode"
+
+	"sentra/internal/errors"
 )
 
 type TokenType string
@@ -30,6 +35,7 @@ const (
 	TokenThrow    TokenType = "THROW"
 	TokenBreak    TokenType = "BREAK"
 	TokenContinue TokenType = "CONTINUE"
+	TokenStruct   TokenType = "STRUCT"
 
 	// Literals & Types
 	TokenTrue     TokenType = "TRUE"
@@ -37,6 +43,7 @@ const (
 	TokenNull     TokenType = "NULL"
 	TokenIdent    TokenType = "IDENT"
 	TokenString   TokenType = "STRING"
+	TokenBytes    TokenType = "BYTES"
 	TokenNumber   TokenType = "NUMBER"
 	TokenInt      TokenType = "INT"
 	TokenFloat    TokenType = "FLOAT"
@@ -68,15 +75,19 @@ const (
 	TokenGE          TokenType = ">="
 	TokenAnd         TokenType = "&&"
 	TokenOr          TokenType = "||"
+	TokenQuestionDot TokenType = "?."
+	TokenNullish     TokenType = "??"
 	TokenNot         TokenType = "!"
 	TokenComma       TokenType = ","
 	TokenDot         TokenType = "."
+	TokenEllipsis    TokenType = "..."
 	TokenSemicolon   TokenType = ";"
 	TokenAs          TokenType = "AS"
 	TokenIn          TokenType = "IN"
 	TokenPipe        TokenType = "|"
 	TokenUnderscore  TokenType = "_"
 	TokenEOF         TokenType = "EOF"
+	TokenComment     TokenType = "COMMENT" // Only in Comments(), never in the token stream
 )
 
 type Token struct {
@@ -85,6 +96,7 @@ type Token struct {
 	Line   int
 	Column int
 	File   string // File path for error reporting
+	Raw    string // Source text of raw and triple-quoted strings and of bytes
 }
 
 func (t Token) String() string {
@@ -101,6 +113,8 @@ type Scanner struct {
 	startCol   int // Column where current token started
 	file       string // File path for error reporting
 	hadError   bool   // Track if any errors occurred during scanning
+	Errors     []error // Errors with a location, such as an invalid hex literal
+	comments   []Token // Comments, kept out of the token stream
 }
 
 func NewScanner(source string) *Scanner {
@@ -170,6 +184,7 @@ func (s *Scanner) scanToken() {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			s.addComment()
 		} else {
 			s.addToken(TokenSlash)
 		}
@@ -178,6 +193,7 @@ func (s *Scanner) scanToken() {
 		for s.peek() != '\n' && !s.isAtEnd() {
 			s.advance()
 		}
+		s.addComment()
 	case '%':
 		s.addToken(TokenPercent)
 	case '=':
@@ -213,13 +229,25 @@ func (s *Scanner) scanToken() {
 			s.addToken(TokenColon)
 		}
 	case '"':
-		s.string()
+		if s.peek() == '"' && s.peekNext() == '"' {
+			s.advance()
+			s.advance()
+			s.tripleString(false)
+		} else {
+			s.string()
+		}
 	case '`':
 		s.templateString()
 	case ',':
 		s.addToken(TokenComma)
 	case '.':
-		s.addToken(TokenDot)
+		if s.peek() == '.' && s.peekNext() == '.' {
+			s.advance()
+			s.advance()
+			s.addToken(TokenEllipsis)
+		} else {
+			s.addToken(TokenDot)
+		}
 	case ';':
 		s.addToken(TokenSemicolon)
 	case '&':
@@ -232,12 +260,32 @@ func (s *Scanner) scanToken() {
 		} else {
 			s.addToken(TokenPipe)
 		}
+	case '?':
+		if s.match('.') {
+			s.addToken(TokenQuestionDot)
+		} else if s.match('?') {
+			s.addToken(TokenNullish)
+		}
 	case '\n':
 		// Line and column already handled in advance()
 	case ' ', '\r', '\t':
 		// Ignore whitespace
 	default:
-		if isDigit(c) {
+		if c == 'r' && s.peek() == '"' {
+			s.advance()
+			if s.peek() == '"' && s.peekNext() == '"' {
+				s.advance()
+				s.advance()
+				s.tripleString(true)
+			} else {
+				s.rawString()
+			}
+		} else if c == 'h' && strings.HasPrefix(s.source[s.current:], "ex\"") {
+			s.advance()
+			s.advance()
+			s.advance()
+			s.hexBytes()
+		} else if isDigit(c) {
 			s.number()
 		} else if isAlpha(c) {
 			s.identifier()
@@ -330,6 +378,8 @@ func (s *Scanner) identifier() {
 		s.addToken(TokenBreak)
 	case "continue":
 		s.addToken(TokenContinue)
+	case "struct":
+		s.addToken(TokenStruct)
 	default:
 		s.addToken(TokenIdent)
 	}
@@ -367,27 +417,9 @@ func (s *Scanner) string() {
 		if s.peek() == '\\' && !s.isAtEnd() {
 			s.advance() // consume backslash
 			if !s.isAtEnd() {
-				next := s.advance()
-				switch next {
-				case 'n':
-					result = append(result, '\n')
-				case 't':
-					result = append(result, '\t')
-				case 'r':
-					result = append(result, '\r')
-				case '\\':
-					result = append(result, '\\')
-				case '"':
-					result = append(result, '"')
-				default:
-					// Unknown escape sequence, keep the backslash and character
-					result = append(result, '\\', next)
-				}
+				result = append(result, unescape(s.advance(), '"')...)
 			}
 		} else {
-			if s.peek() == '\n' {
-				s.line++
-			}
 			result = append(result, s.advance())
 		}
 	}
@@ -416,27 +448,9 @@ func (s *Scanner) templateString() {
 		if s.peek() == '\\' && !s.isAtEnd() {
 			s.advance() // consume backslash
 			if !s.isAtEnd() {
-				next := s.advance()
-				switch next {
-				case 'n':
-					result = append(result, '\n')
-				case 't':
-					result = append(result, '\t')
-				case 'r':
-					result = append(result, '\r')
-				case '\\':
-					result = append(result, '\\')
-				case '`':
-					result = append(result, '`')
-				default:
-					// Unknown escape sequence, keep the backslash and character
-					result = append(result, '\\', next)
-				}
+				result = append(result, unescape(s.advance(), '`')...)
 			}
 		} else {
-			if s.peek() == '\n' {
-				s.line++
-			}
 			result = append(result, s.advance())
 		}
 	}
@@ -458,6 +472,158 @@ func (s *Scanner) templateString() {
 	})
 }
 
+// unescape returns what the escape sequence \c stands for in a string
+// closed by quote. An unknown escape keeps its backslash, so "\d+" means
+// the same in a regex as r"\d+".
+func unescape(c, quote byte) []byte {
+	switch c {
+	case 'n':
+		return []byte{'\n'}
+	case 't':
+		return []byte{'\t'}
+	case 'r':
+		return []byte{'\r'}
+	case '\\', quote:
+		return []byte{c}
+	}
+	return []byte{'\\', c}
+}
+
+// rawString scans r"...", where backslashes are plain characters, as in
+// r"C:\Windows\System32" or r"\d+\.\d+". A raw string cannot contain ".
+func (s *Scanner) rawString() {
+	for s.peek() != '"' && !s.isAtEnd() {
+		s.advance()
+	}
+	if s.isAtEnd() {
+		s.hadError = true
+		return // Unterminated string
+	}
+	s.advance() // consume closing quote
+
+	s.addString(s.source[s.start+2 : s.current-1])
+}
+
+// hexBytes scans hex"...", a bytes literal such as hex"deadbeef". Spaces
+// between the digits are ignored, so hex"de ad be ef" is the same value.
+// The decoded bytes are the token's lexeme.
+func (s *Scanner) hexBytes() {
+	for s.peek() != '"' && !s.isAtEnd() {
+		s.advance()
+	}
+	if s.isAtEnd() {
+		s.hadError = true
+		return // Unterminated literal
+	}
+	s.advance() // consume closing quote
+
+	digits := strings.Join(strings.Fields(s.source[s.start+4:s.current-1]), "")
+	data, err := hex.DecodeString(digits)
+	var invalid hex.InvalidByteError
+	if stderrors.As(err, &invalid) {
+		s.error(fmt.Sprintf("invalid hex literal: %q is not a hex digit", rune(invalid)))
+		return
+	} else if err != nil {
+		s.error("invalid hex literal: odd number of digits")
+		return
+	}
+	s.tokens = append(s.tokens, Token{
+		Type:   TokenBytes,
+		Lexeme: string(data),
+		Raw:    s.source[s.start:s.current],
+		Line:   s.line,
+		Column: s.startCol,
+		File:   s.file,
+	})
+}
+
+// tripleString scans """...""", or r"""...""" when raw, which may span
+// lines and contain unescaped quotes. A line break straight after the
+// opening quotes is dropped, and when the closing quotes sit on a line of
+// their own, their indentation is removed from every line along with the
+// final line break:
+//
+//	let query = """
+//	    SELECT host FROM alerts
+//	      WHERE severity > 3
+//	    """
+//
+// is "SELECT host FROM alerts\n  WHERE severity > 3".
+func (s *Scanner) tripleString(raw bool) {
+	bodyStart := s.current
+	for {
+		if s.isAtEnd() {
+			s.hadError = true
+			return // Unterminated string
+		}
+		if s.peek() == '"' && s.peekNext() == '"' && s.current+2 < len(s.source) && s.source[s.current+2] == '"' {
+			break
+		}
+		if s.advance() == '\\' && !raw && !s.isAtEnd() {
+			s.advance()
+		}
+	}
+	body := dedent(s.source[bodyStart:s.current])
+	s.advance()
+	s.advance()
+	s.advance() // consume closing quotes
+
+	if !raw {
+		var result []byte
+		for i := 0; i < len(body); i++ {
+			if body[i] == '\\' && i+1 < len(body) {
+				i++
+				result = append(result, unescape(body[i], '"')...)
+			} else {
+				result = append(result, body[i])
+			}
+		}
+		body = string(result)
+	}
+	s.addString(body)
+}
+
+// dedent applies the layout rules of tripleString to the text between the
+// quotes. Lines indented less than the closing quotes keep what they have.
+func dedent(body string) string {
+	if strings.HasPrefix(body, "\r\n") {
+		body = body[2:]
+	} else if strings.HasPrefix(body, "\n") {
+		body = body[1:]
+	}
+	last := strings.LastIndex(body, "\n")
+	if last < 0 || strings.Trim(body[last+1:], " \t") != "" {
+		return body
+	}
+	indent := body[last+1:]
+	body = strings.TrimSuffix(body[:last], "\r")
+	if indent == "" {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, indent) {
+			lines[i] = line[len(indent):]
+		} else if strings.Trim(line, " \t\r") == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addString adds a string token whose value is value. Raw and triple-quoted
+// strings also keep their source spelling in Raw.
+func (s *Scanner) addString(value string) {
+	s.tokens = append(s.tokens, Token{
+		Type:   TokenString,
+		Lexeme: value,
+		Raw:    s.source[s.start:s.current],
+		Line:   s.line,
+		Column: s.startCol,
+		File:   s.file,
+	})
+}
+
 func (s *Scanner) addToken(t TokenType) {
 	text := s.source[s.start:s.current]
 	s.tokens = append(s.tokens, Token{
@@ -469,6 +635,24 @@ func (s *Scanner) addToken(t TokenType) {
 	})
 }
 
+// addComment records the comment just scanned. Comments are not tokens the
+// parser sees; tools such as the formatter get them from Comments.
+func (s *Scanner) addComment() {
+	s.comments = append(s.comments, Token{
+		Type:   TokenComment,
+		Lexeme: strings.TrimRight(s.source[s.start:s.current], " \t\r"),
+		Line:   s.line,
+		Column: s.startCol,
+		File:   s.file,
+	})
+}
+
+// Comments returns the comments in the source in order, including a
+// leading #! line
+func (s *Scanner) Comments() []Token {
+	return s.comments
+}
+
 func (s *Scanner) advance() byte {
 	c := s.source[s.current]
 	s.current++
@@ -503,6 +687,21 @@ func (s *Scanner) HadError() bool {
 	return s.hadError
 }
 
+// error records a syntax error at the start of the current token
+func (s *Scanner) error(message string) {
+	s.hadError = true
+	lineStart := strings.LastIndexByte(s.source[:s.start], '\n') + 1
+	lineEnd := strings.IndexByte(s.source[s.start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(s.source)
+	} else {
+		lineEnd += s.start
+	}
+	line := s.line - strings.Count(s.source[s.start:s.current], "\n")
+	err := errors.NewSyntaxError(message, s.file, line, s.startCol)
+	s.Errors = append(s.Errors, err.WithSource(s.source[lineStart:lineEnd]))
+}
+
 func (s *Scanner) sanitize() {
 	for !s.isAtEnd() && unicode.IsSpace(rune(s.peek())) {
 		s.advance() // advance() already handles line counting
@@ -547,12 +746,13 @@ func (s *Scanner) skipUTF8Sequence(firstByte byte) {
 // skipShebang skips over shebang line at the beginning of the file
 func (s *Scanner) skipShebang() {
 	// Skip until end of line
+	s.start, s.startCol = s.current, s.column
 	for !s.isAtEnd() && s.peek() != '\n' {
 		s.advance()
 	}
-	// Skip the newline
+	s.addComment()
+	// Skip the newline (advance counts the line)
 	if !s.isAtEnd() && s.peek() == '\n' {
-		s.line++
 		s.advance()
 	}
 }
@@ -0,0 +1,37 @@
+package lexer
+
+import (
+	"testing"
+
+	"sentra/internal/errors"
+)
+
+func TestInvalidHexLiteral(t *testing.T) {
+	for _, tc := range []struct {
+		source  string
+		message string
+		line    int
+		column  int
+	}{
+		{"let x = hex\"zz\"", "invalid hex literal: 'z' is not a hex digit", 1, 9},
+		{"let a = 1\n  let x = hex\"abc\"", "invalid hex literal: odd number of digits", 2, 11},
+		{"let x = hex\"de ad\n be e\"", "invalid hex literal: odd number of digits", 1, 9},
+	} {
+		scanner := NewScannerWithFile(tc.source, "test.sn")
+		scanner.ScanTokens()
+		if !scanner.HadError() || len(scanner.Errors) != 1 {
+			t.Errorf("%q: got errors %v", tc.source, scanner.Errors)
+			continue
+		}
+		err, ok := scanner.Errors[0].(*errors.SentraError)
+		if !ok {
+			t.Errorf("%q: got %T, want a SentraError", tc.source, scanner.Errors[0])
+			continue
+		}
+		loc := err.Location
+		if err.Type != errors.SyntaxError || err.Message != tc.message || loc.File != "test.sn" || loc.Line != tc.line || loc.Column != tc.column {
+			t.Errorf("%q: got %s %q at %s:%d:%d, want %q at test.sn:%d:%d",
+				tc.source, err.Type, err.Message, loc.File, loc.Line, loc.Column, tc.message, tc.line, tc.column)
+		}
+	}
+}
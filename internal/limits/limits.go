@@ -0,0 +1,277 @@
+// Package limits bounds the instructions, memory and time a Sentra program
+// may use, so that an untrusted or runaway script fails with an error the
+// script can catch instead of hanging or exhausting the host
+package limits
+
+import (
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits configures a run. Zero fields are unlimited.
+type Limits struct {
+	MaxInstructions uint64        // Bytecode instructions executed
+	MaxMemory       uint64        // Bytes of live heap in the process
+	Timeout         time.Duration // Wall clock time
+}
+
+// Resources that can be exhausted
+const (
+	Instructions = "instruction"
+	Memory       = "memory"
+	Time         = "time"
+)
+
+// Error is returned when a script exceeds a limit. The first one can be
+// caught by the script; the resource is then extended by a tenth so the
+// handler can clean up, and exceeding it again is Final.
+type Error struct {
+	Resource string
+	Limit    string
+	Final    bool // Not catchable: the script already had its grace
+}
+
+func (e *Error) Error() string {
+	if e.Resource == Time {
+		return fmt.Sprintf("timeout of %s exceeded", e.Limit)
+	}
+	return fmt.Sprintf("%s limit of %s exceeded", e.Resource, e.Limit)
+}
+
+// DepthError is returned when a call would nest deeper than the VM's call
+// stack allows. A call in tail position, return f(...), reuses the frame of
+// the function it returns from, so only other calls count towards the depth.
+type DepthError struct {
+	Function  string // Function being called
+	Depth     int    // Frames on the call stack
+	Limit     int    // Maximum frames the VM allows
+	Registers bool   // The register VM ran out of registers before Limit
+}
+
+func (e *DepthError) Error() string {
+	if e.Registers {
+		return fmt.Sprintf("out of registers at recursion depth %d calling %s: only a call in tail position, return %s(...), reuses its frame",
+			e.Depth, e.Function, e.Function)
+	}
+	return fmt.Sprintf("maximum recursion depth of %d exceeded calling %s: only a call in tail position, return %s(...), reuses its frame",
+		e.Limit, e.Function, e.Function)
+}
+
+// Catchable reports whether err is an error the script may catch: a
+// resource error it has not had its grace for, or a DepthError, as catching
+// it unwinds the frames that caused it
+func Catchable(err error) bool {
+	switch e := err.(type) {
+	case *Error:
+		return !e.Final
+	case *DepthError:
+		return true
+	}
+	return false
+}
+
+// samplePeriod is how often the time and memory limits are checked
+const samplePeriod = 10 * time.Millisecond
+
+// Tracker enforces Limits on a running VM. Step is called by the VM for
+// every instruction, from one goroutine; the time and memory limits are
+// sampled in the background.
+type Tracker struct {
+	limits   Limits
+	steps    uint64
+	maxSteps uint64
+	deadline atomic.Int64  // Unix nanoseconds, 0 for none
+	maxHeap  atomic.Uint64 // 0 for none
+	heap     atomic.Uint64 // Live heap at the last sample
+	flagged  atomic.Int32  // Set by the sampler: 1 for time, 2 for memory
+	graced   bool
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// Start begins enforcing l. notify is called from another goroutine when
+// the time or memory limit is exceeded, so the VM can cut a sleep short.
+func Start(l Limits, notify func()) *Tracker {
+	t := &Tracker{limits: l, maxSteps: math.MaxUint64, stop: make(chan struct{})}
+	if l.MaxInstructions > 0 {
+		t.maxSteps = l.MaxInstructions
+	}
+	if l.Timeout > 0 {
+		t.deadline.Store(time.Now().Add(l.Timeout).UnixNano())
+	}
+	t.maxHeap.Store(l.MaxMemory)
+	if l.Timeout == 0 && l.MaxMemory == 0 {
+		return t
+	}
+
+	t.sample()
+	t.done.Add(1)
+	go func() {
+		defer t.done.Done()
+		ticker := time.NewTicker(samplePeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if flag := t.sample(); flag != 0 && t.flagged.CompareAndSwap(0, flag) && notify != nil {
+					notify()
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop ends the background sampling. The tracker must not be used after.
+func (t *Tracker) Stop() {
+	close(t.stop)
+	t.done.Wait()
+}
+
+// Limits returns the limits being enforced
+func (t *Tracker) Limits() Limits {
+	return t.limits
+}
+
+// Steps returns the number of instructions counted so far
+func (t *Tracker) Steps() uint64 {
+	return t.steps
+}
+
+// Step counts an instruction and returns an error once a limit is exceeded
+func (t *Tracker) Step() error {
+	t.steps++
+	if t.steps > t.maxSteps {
+		return t.exceed(Instructions)
+	}
+	if flag := t.flagged.Load(); flag != 0 {
+		t.flagged.Store(0)
+		// The grace given for an earlier error may cover it
+		if resource := flaggedResources[flag]; t.over(resource) {
+			return t.exceed(resource)
+		}
+	}
+	return nil
+}
+
+// Reserve returns an error if allocating size more bytes would exceed the
+// memory limit, so that one huge string or array fails before it is made
+func (t *Tracker) Reserve(size uint64) error {
+	max := t.maxHeap.Load()
+	if max == 0 || t.heap.Load()+size <= max {
+		return nil
+	}
+	return t.exceed(Memory)
+}
+
+// flaggedResources maps Tracker.flagged to the resource exceeded
+var flaggedResources = [...]string{1: Time, 2: Memory}
+
+// sample reads the clock and the heap, returning the flag of the resource
+// exceeded if any
+func (t *Tracker) sample() int32 {
+	if t.over(Time) {
+		return 1
+	}
+	if t.maxHeap.Load() > 0 {
+		t.heap.Store(heapInUse())
+		if t.over(Memory) {
+			return 2
+		}
+	}
+	return 0
+}
+
+// over reports whether the time or memory limit is exceeded now
+func (t *Tracker) over(resource string) bool {
+	switch resource {
+	case Time:
+		deadline := t.deadline.Load()
+		return deadline != 0 && time.Now().UnixNano() > deadline
+	case Memory:
+		max := t.maxHeap.Load()
+		return max != 0 && t.heap.Load() > max
+	}
+	return false
+}
+
+// exceed returns the error for resource. The first time, every limit is
+// extended by a tenth for the script's error handler.
+func (t *Tracker) exceed(resource string) error {
+	err := &Error{Resource: resource, Final: t.graced}
+	switch resource {
+	case Instructions:
+		err.Limit = strconv.FormatUint(t.limits.MaxInstructions, 10)
+	case Memory:
+		err.Limit = FormatSize(t.limits.MaxMemory)
+	case Time:
+		err.Limit = t.limits.Timeout.String()
+	}
+	if !t.graced {
+		t.graced = true
+		t.maxSteps = t.steps + t.limits.MaxInstructions/10
+		if t.limits.MaxInstructions == 0 {
+			t.maxSteps = math.MaxUint64
+		}
+		if deadline := t.deadline.Load(); deadline != 0 {
+			t.deadline.Store(time.Now().Add(t.limits.Timeout / 10).UnixNano())
+		}
+		t.maxHeap.Store(t.limits.MaxMemory + t.limits.MaxMemory/10)
+	}
+	return err
+}
+
+// heapInUse returns the bytes of live and not yet collected heap objects
+func heapInUse() uint64 {
+	s := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+var sizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// ParseSize parses a byte count like 512MB, 2G or 65536
+func ParseSize(s string) (uint64, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	unit := uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text, unit = strings.TrimSpace(strings.TrimSuffix(text, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(n * float64(unit)), nil
+}
+
+// FormatSize renders a byte count with the largest unit it is a whole
+// multiple of, like 512MB
+func FormatSize(n uint64) string {
+	for _, u := range sizeUnits[:3] {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatUint(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
@@ -0,0 +1,151 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sentra/internal/lexer"
+	"sentra/internal/packages"
+)
+
+// Config overrides the default severity of rules
+type Config struct {
+	Severities map[string]Severity // Rule ID -> severity
+}
+
+// DefaultConfig runs every rule at its default severity
+func DefaultConfig() Config {
+	return Config{Severities: map[string]Severity{}}
+}
+
+// Severity returns the severity rule runs with
+func (c Config) Severity(rule *Rule) Severity {
+	if s, ok := c.Severities[rule.ID]; ok {
+		return s
+	}
+	return rule.Severity
+}
+
+// Set changes the severity of one rule, as in "empty-catch = error"
+func (c *Config) Set(id, value string) error {
+	if Lookup(id) == nil {
+		return fmt.Errorf("unknown lint rule %q", id)
+	}
+	s, err := ParseSeverity(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	if c.Severities == nil {
+		c.Severities = map[string]Severity{}
+	}
+	c.Severities[id] = s
+	return nil
+}
+
+// LoadConfig reads the [lint] section of the sentra.toml of the project
+// path belongs to. Files outside a project get the defaults.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	root := packages.FindProjectRoot(filepath.Dir(path))
+	if root == "" {
+		return cfg, nil
+	}
+	file := filepath.Join(root, "sentra.toml")
+	if _, err := os.Stat(file); err != nil {
+		// A project marked only by sentra.mod has no lint settings
+		return cfg, nil
+	}
+	project, err := packages.ParseProjectFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	for id, value := range project.Sections["lint"] {
+		if err := cfg.Set(id, value); err != nil {
+			return cfg, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return cfg, nil
+}
+
+// directives holds the rules silenced by "sentra:disable" comments. The
+// empty rule ID stands for every rule.
+type directives struct {
+	file  map[string]bool
+	lines map[int]map[string]bool
+}
+
+func (d directives) covers(line int, rule string) bool {
+	return d.lines[line][rule] || d.lines[line][""]
+}
+
+// parseDirectives finds the comments that disable rules:
+//
+//	// sentra:disable-file unused-variable    whole file
+//	let x = 1  // sentra:disable              this line, every rule
+//	// sentra:disable empty-catch, shadowed-variable
+//	try { ... } catch e {}                    the next line of code
+func parseDirectives(p *Pass) directives {
+	d := directives{file: map[string]bool{}, lines: map[int]map[string]bool{}}
+	for _, c := range p.Comments {
+		text := strings.TrimSpace(strings.TrimLeft(c.Lexeme, "/#"))
+		var rules []string
+		wholeFile := false
+		switch {
+		case strings.HasPrefix(text, "sentra:disable-file"):
+			rules = ruleList(strings.TrimPrefix(text, "sentra:disable-file"))
+			wholeFile = true
+		case strings.HasPrefix(text, "sentra:disable"):
+			rules = ruleList(strings.TrimPrefix(text, "sentra:disable"))
+		default:
+			continue
+		}
+
+		target := d.file
+		if !wholeFile {
+			line := c.Line
+			if !p.hasCodeBefore(c) {
+				line = p.nextCodeLine(c.Line)
+			}
+			if d.lines[line] == nil {
+				d.lines[line] = map[string]bool{}
+			}
+			target = d.lines[line]
+		}
+		if len(rules) == 0 {
+			target[""] = true
+		}
+		for _, r := range rules {
+			target[r] = true
+		}
+	}
+	return d
+}
+
+// ruleList splits "a, b c" into rule IDs
+func ruleList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// hasCodeBefore reports whether a token precedes the comment on its line
+func (p *Pass) hasCodeBefore(c lexer.Token) bool {
+	for _, t := range p.lineTokens[c.Line] {
+		if t.Column < c.Column {
+			return true
+		}
+	}
+	return false
+}
+
+// nextCodeLine returns the first line after line that holds a token
+func (p *Pass) nextCodeLine(line int) int {
+	for l := line + 1; l <= p.lastLine; l++ {
+		if len(p.lineTokens[l]) > 0 {
+			return l
+		}
+	}
+	return line + 1
+}
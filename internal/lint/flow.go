@@ -0,0 +1,86 @@
+package lint
+
+import "sentra/internal/parser"
+
+// CalleeName returns the name of the function a call invokes: "md5" for
+// md5(x), "os.exec" for os.exec(cmd), or "" when it is computed
+func CalleeName(call *parser.CallExpr) string {
+	switch callee := call.Callee.(type) {
+	case *parser.Variable:
+		return callee.Name
+	case *parser.PropertyExpr:
+		if obj, ok := callee.Object.(*parser.Variable); ok {
+			return obj.Name + "." + callee.Property
+		}
+	case *parser.UnaryExpr:
+		// The parser reads !valid(x) as a call of !valid
+		return CalleeName(&parser.CallExpr{Callee: callee.Operand})
+	}
+	return ""
+}
+
+// Arg returns the argument a call passes for the parameter at index i,
+// named name: positionally or as a keyword argument. It is nil when the
+// call leaves the parameter out.
+func Arg(call *parser.CallExpr, i int, name string) parser.Expr {
+	positional := len(call.Args) - len(call.Names)
+	if i < positional {
+		return call.Args[i]
+	}
+	for j, kw := range call.Names {
+		if kw == name {
+			return call.Args[positional+j]
+		}
+	}
+	return nil
+}
+
+// Sources returns the expressions the value of e can come from: e, the
+// expressions inside it and, through variables, every value the file
+// assigns to them. enter is asked before each expression is looked into;
+// returning false keeps the expression in the result but skips what is
+// behind it. Function bodies are never entered.
+//
+// The analysis ignores control flow, so a variable assigned a constant
+// and later a value read from the network yields both.
+func (p *Pass) Sources(e parser.Expr, enter func(parser.Expr) bool) []parser.Expr {
+	var out []parser.Expr
+	seen := map[*Decl]bool{}
+	var visit func(e parser.Expr)
+	visit = func(e parser.Expr) {
+		if e == nil {
+			return
+		}
+		out = append(out, e)
+		if enter != nil && !enter(e) {
+			return
+		}
+		switch e := e.(type) {
+		case *parser.LambdaExpr:
+			return
+		case *parser.Variable:
+			if d := p.Ref(e); d != nil && !seen[d] {
+				seen[d] = true
+				for _, v := range d.Values {
+					visit(v)
+				}
+			}
+			return
+		case *parser.IndexExpr:
+			// The element comes from the collection, not from the key
+			visit(e.Object)
+			return
+		case *parser.SliceExpr:
+			// Likewise a slice comes from the collection, not from its bounds
+			visit(e.Object)
+			return
+		}
+		for _, child := range Children(e) {
+			if expr, ok := child.(parser.Expr); ok {
+				visit(expr)
+			}
+		}
+	}
+	visit(e)
+	return out
+}
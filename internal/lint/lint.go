@@ -0,0 +1,167 @@
+// Package lint implements the rule-based linter behind "sentra lint".
+//
+// Each rule is a Rule registered with an ID such as "unused-variable". A
+// rule inspects one parsed file through a Pass and reports Diagnostics.
+// Rules can be turned off or given another severity in the [lint] section
+// of sentra.toml, and silenced for a line or a whole file with
+// "// sentra:disable" comments.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+)
+
+// Severity is how serious a diagnostic is
+type Severity int
+
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Off:
+		return "off"
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalText writes the severity by name, as in JSON output
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseSeverity parses a severity name as written in sentra.toml
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "off", "false":
+		return Off, nil
+	case "info":
+		return Info, nil
+	case "warning", "warn", "on", "true":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+	return Off, fmt.Errorf("severity must be off, info, warning or error, got %q", s)
+}
+
+// Diagnostic is one problem found by a rule
+type Diagnostic struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Message  string   `json:"message"`
+	Hint     string   `json:"hint,omitempty"` // How to fix it
+}
+
+// String formats the diagnostic as "file:line:col: severity: message [rule]"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s [%s]", d.File, d.Line, d.Column, d.Severity, d.Message, d.Rule)
+}
+
+// Rule is a single lint check
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity // Used unless the configuration says otherwise
+	Check       func(p *Pass)
+}
+
+var registry = map[string]*Rule{}
+
+// Register adds a rule to the set run by Lint. It panics if the ID is
+// already taken.
+func Register(r *Rule) {
+	if _, exists := registry[r.ID]; exists {
+		panic("lint: rule registered twice: " + r.ID)
+	}
+	registry[r.ID] = r
+}
+
+// Rules returns every registered rule, sorted by ID
+func Rules() []*Rule {
+	rules := make([]*Rule, 0, len(registry))
+	for _, r := range registry {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// Lookup returns the rule with the given ID, or nil
+func Lookup(id string) *Rule {
+	return registry[id]
+}
+
+// Lint parses source and runs every enabled rule on it. The diagnostics
+// are sorted by position. An error is returned if the file does not parse.
+func Lint(source, filename string, config Config) (diags []Diagnostic, err error) {
+	scanner := lexer.NewScannerWithFile(source, filename)
+	tokens := scanner.ScanTokens()
+	if scanner.HadError() {
+		return nil, fmt.Errorf("syntax errors found, cannot lint")
+	}
+
+	p := parser.NewParserWithSource(tokens, source, filename)
+	var stmts []parser.Stmt
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(error); ok {
+					err = e
+				} else {
+					err = fmt.Errorf("parse error: %v", r)
+				}
+			}
+		}()
+		stmts = p.Parse()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Errors) > 0 {
+		return nil, p.Errors[0]
+	}
+
+	pass := newPass(filename, stmts, tokens, scanner.Comments(), p)
+	disabled := parseDirectives(pass)
+	for _, rule := range Rules() {
+		severity := config.Severity(rule)
+		if severity == Off || disabled.file[rule.ID] || disabled.file[""] {
+			continue
+		}
+		pass.rule = rule
+		pass.severity = severity
+		rule.Check(pass)
+	}
+
+	for _, d := range pass.diags {
+		if !disabled.covers(d.Line, d.Rule) {
+			diags = append(diags, d)
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Line != diags[j].Line {
+			return diags[i].Line < diags[j].Line
+		}
+		return diags[i].Column < diags[j].Column
+	})
+	return diags, nil
+}
@@ -0,0 +1,166 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"sentra/internal/parser"
+)
+
+func init() {
+	Register(&Rule{
+		ID:          "unused-variable",
+		Description: "a variable is declared but its value is never read",
+		Severity:    Warning,
+		Check:       checkUnusedVariables,
+	})
+	Register(&Rule{
+		ID:          "unused-import",
+		Description: "a module is imported but never used",
+		Severity:    Warning,
+		Check:       checkUnusedImports,
+	})
+	Register(&Rule{
+		ID:          "shadowed-variable",
+		Description: "a declaration hides one of the same name in an enclosing scope",
+		Severity:    Warning,
+		Check:       checkShadowing,
+	})
+	Register(&Rule{
+		ID:          "unreachable-code",
+		Description: "statements follow a return, throw, break or continue and never run",
+		Severity:    Warning,
+		Check:       checkUnreachable,
+	})
+	Register(&Rule{
+		ID:          "empty-catch",
+		Description: "a catch block silently discards the error",
+		Severity:    Warning,
+		Check:       checkEmptyCatch,
+	})
+}
+
+// ignored reports whether a name opts out of the unused and shadowing
+// checks by starting with an underscore
+func ignored(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+func checkUnusedVariables(p *Pass) {
+	for _, d := range p.Decls() {
+		if d.Kind != VarDecl || d.Reads > 0 || d.Exported || ignored(d.Name) {
+			continue
+		}
+		message := fmt.Sprintf("variable '%s' is declared but never used", d.Name)
+		if d.Writes > 0 {
+			message = fmt.Sprintf("variable '%s' is assigned but never used", d.Name)
+		}
+		p.Report(p.Pos(d.Line, d.Name), message, fmt.Sprintf("remove it, or rename it to '_%s' if it is needed", d.Name))
+	}
+}
+
+func checkUnusedImports(p *Pass) {
+	for _, d := range p.Decls() {
+		if d.Kind != ImportDecl || d.Reads > 0 || d.Exported || ignored(d.Name) {
+			continue
+		}
+		p.Report(p.Pos(d.Line, d.Name), fmt.Sprintf("import '%s' is never used", d.Name), "remove the import")
+	}
+}
+
+func checkShadowing(p *Pass) {
+	for _, d := range p.Decls() {
+		outer := d.Shadows
+		if outer == nil || d.Kind == FuncDecl || d.Kind == ImportDecl || ignored(d.Name) {
+			continue
+		}
+		p.Report(p.Pos(d.Line, d.Name),
+			fmt.Sprintf("%s '%s' shadows the %s declared on line %d", d.Kind, d.Name, outer.Kind, outer.Line),
+			fmt.Sprintf("rename one of them; inside this scope '%s' no longer refers to the outer %s", d.Name, outer.Kind))
+	}
+}
+
+func checkUnreachable(p *Pass) {
+	check := func(stmts []parser.Stmt) {
+		for i, stmt := range stmts {
+			exit := exits(stmt)
+			if exit == "" {
+				continue
+			}
+			for _, next := range stmts[i+1:] {
+				// Nested function declarations are not code that runs
+				if _, ok := next.(*parser.FunctionStmt); ok {
+					continue
+				}
+				if line := p.Line(next); line > 0 {
+					hint := "remove it or move it before the " + exit
+					if _, ok := stmt.(*parser.IfStmt); ok {
+						hint = "remove it, or let one branch of the if continue"
+					}
+					p.Report(p.Pos(line, ""), "unreachable code after "+exit, hint)
+				}
+				break
+			}
+			return
+		}
+	}
+
+	check(p.Stmts)
+	p.Walk(func(n Node, c *Cursor) bool {
+		for _, block := range Blocks(n) {
+			check(block)
+		}
+		return true
+	})
+}
+
+// exits returns the statement that makes stmt leave its block, or "" if
+// execution can continue after it
+func exits(stmt parser.Stmt) string {
+	switch s := stmt.(type) {
+	case *parser.ReturnStmt:
+		return "return"
+	case *parser.ThrowStmt:
+		return "throw"
+	case *parser.BreakStmt:
+		return "break"
+	case *parser.ContinueStmt:
+		return "continue"
+	case *parser.IfStmt:
+		if len(s.Then) == 0 || len(s.Else) == 0 {
+			return ""
+		}
+		then := exits(s.Then[len(s.Then)-1])
+		otherwise := exits(s.Else[len(s.Else)-1])
+		if then == "" || otherwise == "" {
+			return ""
+		}
+		if then == otherwise {
+			return "if/else that always ends in " + then
+		}
+		return "if/else that always leaves the block"
+	}
+	return ""
+}
+
+func checkEmptyCatch(p *Pass) {
+	p.Walk(func(n Node, c *Cursor) bool {
+		try, ok := n.(*parser.TryStmt)
+		if !ok || len(try.CatchBlock) > 0 {
+			return true
+		}
+		start, end := p.BlockEnd(try, 0), p.BlockEnd(try, 1)
+		if end == 0 {
+			return true // No catch clause, only finally
+		}
+		// A comment in the block documents why the error is ignored
+		for _, comment := range p.Comments {
+			if comment.Line >= start && comment.Line <= end {
+				return true
+			}
+		}
+		p.Report(p.Pos(start, "catch"), "empty catch block discards the error",
+			"handle or log the error, or add a comment explaining why it is safe to ignore")
+		return true
+	})
+}
@@ -0,0 +1,368 @@
+package lint
+
+import (
+	"strings"
+
+	"sentra/internal/parser"
+)
+
+// DeclKind says how a name was declared
+type DeclKind int
+
+const (
+	VarDecl DeclKind = iota
+	ParamDecl
+	FuncDecl
+	ImportDecl
+	LoopDecl  // for x in ...
+	CatchDecl // catch err
+)
+
+func (k DeclKind) String() string {
+	switch k {
+	case VarDecl:
+		return "variable"
+	case ParamDecl:
+		return "parameter"
+	case FuncDecl:
+		return "function"
+	case ImportDecl:
+		return "import"
+	case LoopDecl:
+		return "loop variable"
+	case CatchDecl:
+		return "catch variable"
+	}
+	return "name"
+}
+
+// Decl is a declared name and what the file does with it
+type Decl struct {
+	Name     string
+	Kind     DeclKind
+	Line     int
+	Scope    *Scope
+	Reads    int   // Times its value is used
+	Writes   int   // Times it is assigned after the declaration
+	Shadows  *Decl // Declaration of the same name in an enclosing scope
+	Exported bool
+
+	// Values holds every expression the name is given: the initializer
+	// and each assignment. A loop variable gets the collection it walks.
+	Values []parser.Expr
+}
+
+// Scope is a block, function or the whole file. As in the compiler every
+// block gets its own scope.
+type Scope struct {
+	Parent *Scope
+	Decls  []*Decl // In declaration order
+	names  map[string]*Decl
+}
+
+func newScope(parent *Scope) *Scope {
+	return &Scope{Parent: parent, names: map[string]*Decl{}}
+}
+
+// Lookup finds the declaration name refers to in s
+func (s *Scope) Lookup(name string) *Decl {
+	for ; s != nil; s = s.Parent {
+		if d := s.names[name]; d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// Scope resolves every name in the file and returns the file scope. The
+// result is computed once and shared by all rules.
+func (p *Pass) Scope() *Scope {
+	if p.scope == nil {
+		p.refs = map[*parser.Variable]*Decl{}
+		p.targets = map[Node]*Decl{}
+		r := &resolver{pass: p, scope: newScope(nil)}
+		r.file = r.scope
+		r.block(p.Stmts)
+		r.finish()
+		p.scope = r.file
+	}
+	return p.scope
+}
+
+// Decls returns every declaration in the file, in the order the resolver
+// met them
+func (p *Pass) Decls() []*Decl {
+	p.Scope()
+	return p.decls
+}
+
+// Ref returns the declaration a variable reference resolves to, or nil for
+// builtins and undefined names
+func (p *Pass) Ref(v *parser.Variable) *Decl {
+	p.Scope()
+	return p.refs[v]
+}
+
+// DeclOf returns the declaration a let statement introduces or an
+// assignment writes to, or nil
+func (p *Pass) DeclOf(n Node) *Decl {
+	p.Scope()
+	return p.targets[n]
+}
+
+type resolver struct {
+	pass  *Pass
+	file  *Scope
+	scope *Scope
+	line  int
+
+	// Uses of names not declared yet. Globals may be referenced by
+	// functions defined before them, so these are settled at the end
+	// against the file scope.
+	unresolved []use
+}
+
+type use struct {
+	name  string
+	ref   *parser.Variable // Set for reads
+	node  Node             // The assignment, for writes
+	value parser.Expr
+}
+
+func (r *resolver) push() { r.scope = newScope(r.scope) }
+func (r *resolver) pop()  { r.scope = r.scope.Parent }
+
+func (r *resolver) declare(name string, kind DeclKind, line int) *Decl {
+	if name == "" {
+		return nil
+	}
+	d := &Decl{Name: name, Kind: kind, Line: line, Scope: r.scope}
+	d.Shadows = r.scope.Parent.Lookup(name)
+	r.scope.names[name] = d
+	r.scope.Decls = append(r.scope.Decls, d)
+	r.pass.decls = append(r.pass.decls, d)
+	return d
+}
+
+func (r *resolver) read(v *parser.Variable) {
+	r.use(use{name: v.Name, ref: v})
+}
+
+func (r *resolver) write(node Node, name string, value parser.Expr) {
+	r.use(use{name: name, node: node, value: value})
+}
+
+func (r *resolver) use(u use) {
+	if u.name == "_" {
+		return
+	}
+	if d := r.scope.Lookup(u.name); d != nil {
+		r.bind(d, u)
+		return
+	}
+	r.unresolved = append(r.unresolved, u)
+}
+
+func (r *resolver) bind(d *Decl, u use) {
+	if u.ref != nil {
+		d.Reads++
+		r.pass.refs[u.ref] = d
+		return
+	}
+	d.Writes++
+	r.pass.targets[u.node] = d
+	if u.value != nil {
+		d.Values = append(d.Values, u.value)
+	}
+}
+
+func (r *resolver) finish() {
+	for _, u := range r.unresolved {
+		if d := r.file.names[u.name]; d != nil {
+			r.bind(d, u)
+		}
+	}
+}
+
+// block resolves a list of statements in the current scope. Functions are
+// declared first so that they can call each other in any order.
+func (r *resolver) block(stmts []parser.Stmt) {
+	for _, stmt := range stmts {
+		if export, ok := stmt.(*parser.ExportStmt); ok {
+			stmt = export.Stmt
+		}
+		if fn, ok := stmt.(*parser.FunctionStmt); ok {
+			r.declare(fn.Name, FuncDecl, fn.Line)
+		}
+	}
+	for _, stmt := range stmts {
+		r.stmt(stmt)
+	}
+}
+
+// nested resolves stmts in a new scope
+func (r *resolver) nested(stmts []parser.Stmt) {
+	r.push()
+	r.block(stmts)
+	r.pop()
+}
+
+func (r *resolver) stmt(stmt parser.Stmt) {
+	if line := r.pass.starts[stmt]; line > 0 {
+		r.line = line
+	}
+	switch s := stmt.(type) {
+	case *parser.LetStmt:
+		r.expr(s.Expr)
+		if d := r.declare(s.Name, VarDecl, r.line); d != nil && s.Expr != nil {
+			d.Values = append(d.Values, s.Expr)
+			r.pass.targets[s] = d
+		}
+	case *parser.AssignmentStmt:
+		r.expr(s.Value)
+		r.write(s, s.Name, s.Value)
+	case *parser.FunctionStmt:
+		if d := r.scope.names[s.Name]; d == nil || d.Line != s.Line {
+			r.declare(s.Name, FuncDecl, s.Line)
+		}
+		r.function(s.Params, s.Defaults, s.Line, func() { r.block(s.Body) })
+	case *parser.ImportStmt:
+		if len(s.Names) > 0 {
+			for _, n := range s.Names {
+				r.declare(n.LocalName(), ImportDecl, r.line)
+			}
+			return
+		}
+		r.declare(importName(s), ImportDecl, r.line)
+	case *parser.ExportStmt:
+		if s.Stmt == nil {
+			r.read(&parser.Variable{Name: s.Name})
+			return
+		}
+		r.stmt(s.Stmt)
+		if d := r.scope.names[s.Name]; d != nil {
+			d.Exported = true
+		}
+	case *parser.ClassStmt:
+		// The struct name is its constructor
+		r.declare(s.Name, FuncDecl, s.Line)
+		for _, def := range s.Defaults {
+			r.expr(def)
+		}
+		for _, m := range s.Methods {
+			r.function(m.Params, m.Defaults, m.Line, func() { r.block(m.Body) })
+		}
+	case *parser.IfStmt:
+		r.expr(s.Condition)
+		r.nested(s.Then)
+		r.nested(s.Else)
+	case *parser.WhileStmt:
+		r.expr(s.Condition)
+		r.nested(s.Body)
+	case *parser.ForStmt:
+		r.push()
+		if s.Init != nil {
+			r.stmt(s.Init)
+		}
+		r.expr(s.Condition)
+		r.expr(s.Update)
+		r.nested(s.Body)
+		r.pop()
+	case *parser.ForInStmt:
+		r.expr(s.Collection)
+		r.push()
+		if s.Key != "" {
+			if d := r.declare(s.Key, LoopDecl, r.line); d != nil {
+				d.Values = append(d.Values, s.Collection)
+			}
+		}
+		if d := r.declare(s.Variable, LoopDecl, r.line); d != nil {
+			d.Values = append(d.Values, s.Collection)
+		}
+		r.nested(s.Body)
+		r.pop()
+	case *parser.TryStmt:
+		r.nested(s.TryBlock)
+		r.push()
+		if line := r.pass.BlockEnd(s, 0); line > 0 {
+			r.line = line
+		}
+		r.declare(s.CatchVar, CatchDecl, r.line)
+		r.nested(s.CatchBlock)
+		r.pop()
+		r.nested(s.FinallyBlock)
+	case *parser.MatchStmt:
+		r.expr(s.Value)
+		for _, mc := range s.Cases {
+			for _, pattern := range mc.Patterns {
+				r.expr(pattern)
+			}
+			r.nested(mc.Body)
+		}
+	default:
+		for _, child := range Children(stmt) {
+			r.node(child)
+		}
+	}
+}
+
+// function resolves a function or lambda body in a scope holding its
+// parameters
+func (r *resolver) function(params []string, defaults []parser.Expr, line int, body func()) {
+	r.push()
+	for _, name := range params {
+		r.declare(name, ParamDecl, line)
+	}
+	// Defaults are evaluated in the function, so they see the parameters
+	for _, def := range defaults {
+		r.expr(def)
+	}
+	r.push()
+	body()
+	r.pop()
+	r.pop()
+}
+
+func (r *resolver) node(n Node) {
+	switch n := n.(type) {
+	case parser.Stmt:
+		r.stmt(n)
+	case parser.Expr:
+		r.expr(n)
+	}
+}
+
+func (r *resolver) expr(expr parser.Expr) {
+	switch e := expr.(type) {
+	case nil:
+	case *parser.Variable:
+		r.read(e)
+	case *parser.Assign:
+		r.expr(e.Value)
+		r.write(e, e.Name, e.Value)
+	case *parser.AssignmentExpr:
+		r.expr(e.Value)
+		r.write(e, e.Name, e.Value)
+	case *parser.LambdaExpr:
+		line := r.line
+		r.function(e.Params, e.Defaults, line, func() { r.expr(e.Body) })
+		r.line = line
+	case *parser.BlockExpr:
+		line := r.line
+		r.nested(e.Stmts)
+		r.line = line
+	default:
+		for _, child := range Children(expr) {
+			r.node(child)
+		}
+	}
+}
+
+// importName is the name a whole-module import is bound to: its alias or
+// the last component of its path
+func importName(s *parser.ImportStmt) string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Path[strings.LastIndex(s.Path, "/")+1:]
+}
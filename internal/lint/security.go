@@ -0,0 +1,456 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sentra/internal/parser"
+)
+
+// Security rules. They follow values through variables with Sources, so
+// a URL or secret stored in a variable first is still found.
+
+func init() {
+	Register(&Rule{
+		ID:          "hardcoded-credential",
+		Description: "a password, token or API key is written in the source",
+		Severity:    Warning,
+		Check:       checkCredentials,
+	})
+	Register(&Rule{
+		ID:          "weak-hash",
+		Description: "an MD5 or SHA-1 digest is compared to decide integrity",
+		Severity:    Warning,
+		Check:       checkWeakHash,
+	})
+	Register(&Rule{
+		ID:          "command-injection",
+		Description: "input from the environment, files or the network reaches a command without validation",
+		Severity:    Warning,
+		Check:       checkCommandInjection,
+	})
+	Register(&Rule{
+		ID:          "plaintext-http",
+		Description: "a request is sent over http:// or ws:// instead of TLS",
+		Severity:    Warning,
+		Check:       checkPlaintextHTTP,
+	})
+	Register(&Rule{
+		ID:          "unchecked-network-error",
+		Description: "a network call is outside try and its result is never checked",
+		Severity:    Warning,
+		Check:       checkNetworkErrors,
+	})
+}
+
+// urlArg maps the builtins that send requests to the position of their
+// URL, a parameter named url
+var urlArg = map[string]int{
+	"http_get":      0,
+	"http_post":     0,
+	"http_request":  1,
+	"http_json":     1,
+	"http_download": 0,
+	"fetch":         0,
+	"web_request":   2,
+	"ws_connect":    0,
+}
+
+// networkCalls fail by throwing or by returning null
+var networkCalls = map[string]bool{
+	"http_get": true, "http_post": true, "http_request": true, "http_json": true,
+	"http_download": true, "fetch": true, "web_request": true,
+	"socket_create": true, "socket_accept": true, "socket_receive": true, "socket_receive_bytes": true,
+	"ws_connect": true, "ws_receive": true,
+}
+
+// inputCalls return data the script does not control
+var inputCalls = map[string]bool{
+	"getenv": true, "os.getenv": true, "env": true, "os.env": true, "os_args": true, "os.args": true,
+	"input": true, "read_line": true,
+	"read_file": true, "file_read": true,
+	"http_get": true, "http_post": true, "http_request": true, "http_json": true, "http_download": true,
+	"fetch": true, "web_request": true, "socket_receive": true, "socket_receive_bytes": true, "ws_receive": true,
+}
+
+// execCalls run an operating system command
+var execCalls = map[string]bool{
+	"os_exec": true, "exec": true, "shell": true, "system": true, "os.exec": true,
+	"process_run": true, "process_spawn": true, "process.run": true, "process.start": true,
+}
+
+// validator reports whether a function name suggests it checks or cleans
+// its argument, such as is_valid_ip, sanitize_host or allowlist_contains
+func validator(name string) bool {
+	name = strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+	if strings.HasPrefix(name, "is_") || name == "contains" || name == "regex_match" {
+		return true
+	}
+	for _, word := range []string{"valid", "saniti", "escape", "quote", "allow", "whitelist", "safe"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretWords end the names of variables and keys that hold credentials
+var secretWords = map[string]bool{
+	"password": true, "passwd": true, "pwd": true, "passphrase": true, "secret": true,
+	"token": true, "apikey": true, "credential": true, "credentials": true,
+}
+
+var camelBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// secretName reports whether a name such as db_password, apiKey or
+// AUTH_TOKEN says its value is a credential. Names like password_prompt
+// or token_count are not.
+func secretName(name string) bool {
+	words := strings.FieldsFunc(strings.ToLower(camelBoundary.ReplaceAllString(name, "${1}_${2}")), func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	})
+	if len(words) == 0 {
+		return false
+	}
+	last := words[len(words)-1]
+	if secretWords[last] {
+		return true
+	}
+	if last == "key" && len(words) > 1 {
+		switch words[len(words)-2] {
+		case "api", "secret", "private", "access", "auth", "encryption", "signing":
+			return true
+		}
+	}
+	return false
+}
+
+// secretPatterns recognise credentials by their format
+var secretPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"AWS access key", regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`)},
+	{"GitHub token", regexp.MustCompile(`^(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{20,})$`)},
+	{"Slack token", regexp.MustCompile(`^xox[abprs]-[A-Za-z0-9-]{10,}$`)},
+	{"Stripe key", regexp.MustCompile(`^(sk|rk)_live_[A-Za-z0-9]{16,}$`)},
+	{"Google API key", regexp.MustCompile(`^AIza[0-9A-Za-z_-]{35}$`)},
+	{"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+}
+
+// secretValue reports whether a string literal looks like a real secret
+// rather than an empty value, a prompt or a message
+func secretValue(e parser.Expr) (string, bool) {
+	lit, ok := e.(*parser.Literal)
+	if !ok {
+		return "", false
+	}
+	s, ok := lit.Value.(string)
+	if !ok || len(s) < 4 || strings.ContainsAny(s, " \t\n") {
+		return "", false
+	}
+	return s, true
+}
+
+func checkCredentials(p *Pass) {
+	const hint = "read it at run time with getenv() or from a secrets store, and rotate the exposed value"
+	reported := map[parser.Expr]bool{}
+	named := func(c *Cursor, name string, value parser.Expr) {
+		if s, ok := secretValue(value); ok && secretName(name) {
+			reported[value] = true
+			p.Report(p.Find(c.Stmt(), s), fmt.Sprintf("hard-coded credential in '%s'", name), hint)
+		}
+	}
+
+	p.Walk(func(n Node, c *Cursor) bool {
+		switch n := n.(type) {
+		case *parser.LetStmt:
+			named(c, n.Name, n.Expr)
+		case *parser.AssignmentStmt:
+			named(c, n.Name, n.Value)
+		case *parser.MapExpr:
+			for i, key := range n.Keys {
+				switch k := key.(type) {
+				case *parser.Literal:
+					if name, ok := k.Value.(string); ok {
+						named(c, name, n.Values[i])
+					}
+				case *parser.Variable:
+					named(c, k.Name, n.Values[i])
+				}
+			}
+		case *parser.Literal:
+			s, ok := n.Value.(string)
+			if !ok || reported[n] {
+				return true
+			}
+			for _, pattern := range secretPatterns {
+				if pattern.re.MatchString(s) {
+					p.Report(p.Find(c.Stmt(), s), "hard-coded "+pattern.kind, hint)
+					break
+				}
+			}
+		}
+		return true
+	})
+}
+
+// weakDigest returns "MD5" or "SHA-1" if e computes one
+func weakDigest(e parser.Expr) string {
+	call, ok := e.(*parser.CallExpr)
+	if !ok {
+		return ""
+	}
+	switch CalleeName(call) {
+	case "md5":
+		return "MD5"
+	case "sha1":
+		return "SHA-1"
+	case "fs_hash":
+		if arg := Arg(call, 1, "algorithm"); arg != nil {
+			if lit, ok := arg.(*parser.Literal); ok {
+				switch strings.ToLower(fmt.Sprint(lit.Value)) {
+				case "md5":
+					return "MD5"
+				case "sha1":
+					return "SHA-1"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkWeakHash reports MD5 and SHA-1 digests that are compared. Computing
+// them is fine, for example to look a file up in threat intelligence; it
+// is relying on them to prove a file or message unchanged that is not.
+func checkWeakHash(p *Pass) {
+	p.Walk(func(n Node, c *Cursor) bool {
+		cmp, ok := n.(*parser.Binary)
+		if !ok || (cmp.Operator != "==" && cmp.Operator != "!=") || isNull(cmp.Left) || isNull(cmp.Right) {
+			return true
+		}
+		for _, side := range []parser.Expr{cmp.Left, cmp.Right} {
+			for _, src := range p.Sources(side, nil) {
+				if algo := weakDigest(src); algo != "" {
+					p.Report(p.Find(c.Stmt(), cmp.Operator),
+						algo+" digest used in an integrity check",
+						fmt.Sprintf("%s collisions can be forged; compare sha256() digests instead", algo))
+					return true
+				}
+			}
+		}
+		return true
+	})
+}
+
+func checkCommandInjection(p *Pass) {
+	// Variables passed to a validator anywhere count as checked, as in
+	// if !is_valid_host(host) { return }
+	checked := map[*Decl]bool{}
+	p.Walk(func(n Node, c *Cursor) bool {
+		if call, ok := n.(*parser.CallExpr); ok && validator(CalleeName(call)) {
+			for _, arg := range call.Args {
+				if v, ok := arg.(*parser.Variable); ok && p.Ref(v) != nil {
+					checked[p.Ref(v)] = true
+				}
+			}
+		}
+		return true
+	})
+	enter := func(e parser.Expr) bool {
+		switch e := e.(type) {
+		case *parser.CallExpr:
+			return !validator(CalleeName(e))
+		case *parser.Variable:
+			return !checked[p.Ref(e)]
+		}
+		return true
+	}
+
+	p.Walk(func(n Node, c *Cursor) bool {
+		call, ok := n.(*parser.CallExpr)
+		if !ok || !execCalls[CalleeName(call)] {
+			return true
+		}
+		exec := CalleeName(call)
+		for _, arg := range call.Args {
+			for _, src := range p.Sources(arg, enter) {
+				var input string
+				switch src := src.(type) {
+				case *parser.CallExpr:
+					if inputCalls[CalleeName(src)] {
+						input = CalleeName(src) + "()"
+					}
+				case *parser.Variable:
+					// The script's command line arguments, unless shadowed
+					if src.Name == "args" && p.Ref(src) == nil {
+						input = "args"
+					}
+				}
+				if input == "" {
+					continue
+				}
+				p.Report(p.Find(c.Stmt(), exec),
+					fmt.Sprintf("%s runs a command built from %s without validation", exec, input),
+					"check the value against an allowlist or a validator such as is_valid_ip() first, and pass arguments as an array rather than one shell string")
+				return true
+			}
+		}
+		return true
+	})
+}
+
+// plaintextURL reports whether s starts an unencrypted URL to a host other
+// than the local machine
+func plaintextURL(s string) bool {
+	lower := strings.ToLower(s)
+	var rest string
+	switch {
+	case strings.HasPrefix(lower, "http://"):
+		rest = lower[len("http://"):]
+	case strings.HasPrefix(lower, "ws://"):
+		rest = lower[len("ws://"):]
+	default:
+		return false
+	}
+	for _, local := range []string{"localhost", "127.", "[::1]", "0.0.0.0"} {
+		if strings.HasPrefix(rest, local) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkPlaintextHTTP(p *Pass) {
+	p.Walk(func(n Node, c *Cursor) bool {
+		call, ok := n.(*parser.CallExpr)
+		if !ok {
+			return true
+		}
+		name := CalleeName(call)
+		i, ok := urlArg[name]
+		if !ok {
+			return true
+		}
+		url := Arg(call, i, "url")
+		if url == nil {
+			return true
+		}
+		for _, src := range p.Sources(url, nil) {
+			lit, ok := src.(*parser.Literal)
+			if !ok {
+				continue
+			}
+			if s, ok := lit.Value.(string); ok && plaintextURL(s) {
+				p.Report(p.Find(c.Stmt(), name),
+					fmt.Sprintf("%s sends traffic unencrypted to %s", name, s),
+					"use https:// or wss:// so the traffic cannot be read or changed in transit")
+				return true
+			}
+		}
+		return true
+	})
+}
+
+// checkNetworkErrors reports network calls whose failure goes unnoticed.
+// A call is handled when it runs inside a try block, when its result is
+// returned to the caller, or when the variable holding the result is
+// tested in a condition or compared with null.
+func checkNetworkErrors(p *Pass) {
+	tested := map[*Decl]bool{}
+	mark := func(e parser.Expr) {
+		for _, src := range p.Sources(e, func(e parser.Expr) bool {
+			_, isVar := e.(*parser.Variable)
+			return !isVar
+		}) {
+			if v, ok := src.(*parser.Variable); ok && p.Ref(v) != nil {
+				tested[p.Ref(v)] = true
+			}
+		}
+	}
+	p.Walk(func(n Node, c *Cursor) bool {
+		switch n := n.(type) {
+		case *parser.IfStmt:
+			mark(n.Condition)
+		case *parser.WhileStmt:
+			mark(n.Condition)
+		case *parser.IfExpr:
+			mark(n.Cond)
+		case *parser.Binary:
+			if isNull(n.Left) || isNull(n.Right) {
+				mark(n.Left)
+				mark(n.Right)
+			}
+		}
+		return true
+	})
+
+	p.Walk(func(n Node, c *Cursor) bool {
+		call, ok := n.(*parser.CallExpr)
+		if !ok || !networkCalls[CalleeName(call)] || inTry(c) || inCondition(c) {
+			return true
+		}
+		switch parent := c.Parent().(type) {
+		case *parser.ReturnStmt:
+			return true
+		case *parser.LetStmt, *parser.AssignmentStmt:
+			if d := p.DeclOf(parent); d != nil && (tested[d] || d.Exported) {
+				return true
+			}
+		}
+		name := CalleeName(call)
+		p.Report(p.Find(c.Stmt(), name),
+			fmt.Sprintf("%s can fail but is not in a try block and its result is never checked", name),
+			"wrap the call in try/catch, or test the result for null before using it")
+		return true
+	})
+}
+
+// inCondition reports whether the node at c is part of the condition of an
+// if or while, or is compared with null
+func inCondition(c *Cursor) bool {
+	for i := len(c.stack) - 2; i >= 0; i-- {
+		child := c.stack[i+1]
+		switch n := c.stack[i].(type) {
+		case *parser.IfStmt:
+			return Node(n.Condition) == child
+		case *parser.WhileStmt:
+			return Node(n.Condition) == child
+		case *parser.IfExpr:
+			if Node(n.Cond) == child {
+				return true
+			}
+		case *parser.Binary:
+			if isNull(n.Left) || isNull(n.Right) {
+				return true
+			}
+		case parser.Stmt:
+			return false
+		}
+	}
+	return false
+}
+
+func isNull(e parser.Expr) bool {
+	lit, ok := e.(*parser.Literal)
+	return ok && lit.Value == nil
+}
+
+// inTry reports whether the node at c runs inside the try block of a try
+// statement, as opposed to its catch or finally block
+func inTry(c *Cursor) bool {
+	for i := len(c.stack) - 2; i >= 0; i-- {
+		try, ok := c.stack[i].(*parser.TryStmt)
+		if !ok {
+			continue
+		}
+		for _, stmt := range try.TryBlock {
+			if Node(stmt) == c.stack[i+1] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,332 @@
+package lint
+
+import (
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+)
+
+// Node is any statement or expression of the syntax tree
+type Node interface{}
+
+// Pass is what a rule sees of the file being linted
+type Pass struct {
+	File     string
+	Stmts    []parser.Stmt
+	Tokens   []lexer.Token
+	Comments []lexer.Token
+
+	starts     map[parser.Stmt]int
+	ends       map[parser.Stmt]int
+	blocks     map[interface{}][]int
+	lineTokens map[int][]lexer.Token
+	lastLine   int
+	scope      *Scope
+	decls      []*Decl
+	refs       map[*parser.Variable]*Decl
+	targets    map[Node]*Decl
+
+	rule     *Rule
+	severity Severity
+	diags    []Diagnostic
+}
+
+func newPass(file string, stmts []parser.Stmt, tokens, comments []lexer.Token, p *parser.Parser) *Pass {
+	pass := &Pass{
+		File:       file,
+		Stmts:      stmts,
+		Tokens:     tokens,
+		Comments:   comments,
+		starts:     p.StmtLines(),
+		ends:       p.StmtEnds(),
+		blocks:     p.BlockEnds(),
+		lineTokens: map[int][]lexer.Token{},
+	}
+	for _, t := range tokens {
+		if t.Type == lexer.TokenEOF {
+			continue
+		}
+		pass.lineTokens[t.Line] = append(pass.lineTokens[t.Line], t)
+		pass.lastLine = max(pass.lastLine, t.Line)
+	}
+	return pass
+}
+
+// Pos is a position in the file
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Pos returns the position of the first token on line spelled name, or
+// of the line's first token if there is none
+func (p *Pass) Pos(line int, name string) Pos {
+	tokens := p.lineTokens[line]
+	for _, t := range tokens {
+		if t.Lexeme == name {
+			return Pos{line, t.Column}
+		}
+	}
+	if len(tokens) > 0 {
+		return Pos{line, tokens[0].Column}
+	}
+	return Pos{line, 1}
+}
+
+// Line returns the line a statement starts on, or 0 if it is not known
+func (p *Pass) Line(stmt parser.Stmt) int {
+	return p.starts[stmt]
+}
+
+// Find returns the position of the first token spelled lexeme among the
+// lines of stmt, or the start of stmt if there is none. String tokens are
+// spelled without their quotes.
+func (p *Pass) Find(stmt parser.Stmt, lexeme string) Pos {
+	start := p.starts[stmt]
+	end := max(p.ends[stmt], start)
+	for line := start; line <= end; line++ {
+		for _, t := range p.lineTokens[line] {
+			if t.Lexeme == lexeme {
+				return Pos{line, t.Column}
+			}
+		}
+	}
+	return p.Pos(start, "")
+}
+
+// BlockEnd returns the line of the closing brace of the i-th block of
+// node, or 0 if it is not known. See parser.BlockEnds for the order.
+func (p *Pass) BlockEnd(node Node, i int) int {
+	if ends := p.blocks[node]; i < len(ends) {
+		return ends[i]
+	}
+	return 0
+}
+
+// Report records a diagnostic of the running rule
+func (p *Pass) Report(pos Pos, message, hint string) {
+	p.diags = append(p.diags, Diagnostic{
+		Rule:     p.rule.ID,
+		Severity: p.severity,
+		File:     p.File,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Message:  message,
+		Hint:     hint,
+	})
+}
+
+// Cursor tells a visitor where the node it is looking at sits
+type Cursor struct {
+	pass  *Pass
+	stack []Node
+	stmts []parser.Stmt // Enclosing statements with known positions
+}
+
+// Node returns the node being visited
+func (c *Cursor) Node() Node {
+	return c.stack[len(c.stack)-1]
+}
+
+// Parent returns the node enclosing the current one, or nil at the top
+func (c *Cursor) Parent() Node {
+	if len(c.stack) < 2 {
+		return nil
+	}
+	return c.stack[len(c.stack)-2]
+}
+
+// Inside reports whether an enclosing node satisfies match
+func (c *Cursor) Inside(match func(Node) bool) bool {
+	for i := len(c.stack) - 2; i >= 0; i-- {
+		if match(c.stack[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Line returns the start line of the innermost statement whose position
+// is known. Expressions have no positions of their own.
+func (c *Cursor) Line() int {
+	if len(c.stmts) == 0 {
+		return 0
+	}
+	return c.pass.starts[c.Stmt()]
+}
+
+// Stmt returns the innermost statement whose position is known, or nil
+func (c *Cursor) Stmt() parser.Stmt {
+	if len(c.stmts) == 0 {
+		return nil
+	}
+	return c.stmts[len(c.stmts)-1]
+}
+
+// Visitor is called for each node in source order. Returning false skips
+// the node's children.
+type Visitor func(n Node, c *Cursor) bool
+
+// Walk visits every node of the file, depth first
+func (p *Pass) Walk(visit Visitor) {
+	c := &Cursor{pass: p}
+	for _, stmt := range p.Stmts {
+		p.walk(stmt, c, visit)
+	}
+}
+
+func (p *Pass) walk(n Node, c *Cursor, visit Visitor) {
+	c.stack = append(c.stack, n)
+	defer func() { c.stack = c.stack[:len(c.stack)-1] }()
+	if stmt, ok := n.(parser.Stmt); ok {
+		if p.starts[stmt] > 0 {
+			c.stmts = append(c.stmts, stmt)
+			defer func() { c.stmts = c.stmts[:len(c.stmts)-1] }()
+		}
+	}
+
+	if !visit(n, c) {
+		return
+	}
+	for _, child := range Children(n) {
+		p.walk(child, c, visit)
+	}
+}
+
+// Children returns the nodes directly inside n, in source order
+func Children(n Node) []Node {
+	var out []Node
+	add := func(nodes ...Node) {
+		for _, node := range nodes {
+			if node != nil {
+				out = append(out, node)
+			}
+		}
+	}
+	stmts := func(list []parser.Stmt) {
+		for _, s := range list {
+			add(s)
+		}
+	}
+	exprs := func(list []parser.Expr) {
+		for _, e := range list {
+			add(e)
+		}
+	}
+
+	switch n := n.(type) {
+	case *parser.PrintStmt:
+		add(n.Expr)
+	case *parser.LetStmt:
+		add(n.Expr)
+	case *parser.AssignmentStmt:
+		add(n.Value)
+	case *parser.IndexAssignmentStmt:
+		add(n.Object, n.Index, n.Value)
+	case *parser.ExpressionStmt:
+		add(n.Expr)
+	case *parser.FunctionStmt:
+		exprs(n.Defaults)
+		stmts(n.Body)
+	case *parser.ReturnStmt:
+		add(n.Value)
+	case *parser.IfStmt:
+		add(n.Condition)
+		stmts(n.Then)
+		stmts(n.Else)
+	case *parser.WhileStmt:
+		add(n.Condition)
+		stmts(n.Body)
+	case *parser.ForStmt:
+		add(n.Init, n.Condition, n.Update)
+		stmts(n.Body)
+	case *parser.ForInStmt:
+		add(n.Collection)
+		stmts(n.Body)
+	case *parser.ExportStmt:
+		add(n.Stmt)
+	case *parser.ClassStmt:
+		exprs(n.Defaults)
+		for _, m := range n.Methods {
+			add(m)
+		}
+	case *parser.TryStmt:
+		stmts(n.TryBlock)
+		stmts(n.CatchBlock)
+		stmts(n.FinallyBlock)
+	case *parser.ThrowStmt:
+		add(n.Value)
+	case *parser.MatchStmt:
+		add(n.Value)
+		for _, mc := range n.Cases {
+			for _, pattern := range mc.Patterns {
+				add(pattern)
+			}
+			stmts(mc.Body)
+		}
+	case *parser.Binary:
+		add(n.Left, n.Right)
+	case *parser.LogicalExpr:
+		add(n.Left, n.Right)
+	case *parser.UnaryExpr:
+		add(n.Operand)
+	case *parser.Assign:
+		add(n.Value)
+	case *parser.AssignmentExpr:
+		add(n.Value)
+	case *parser.CallExpr:
+		add(n.Callee)
+		exprs(n.Args)
+	case *parser.IfExpr:
+		add(n.Cond, n.ThenBranch, n.ElseBranch)
+	case *parser.BlockExpr:
+		stmts(n.Stmts)
+	case *parser.ArrayExpr:
+		exprs(n.Elements)
+	case *parser.MapExpr:
+		for i := range n.Keys {
+			add(n.Keys[i], n.Values[i])
+		}
+	case *parser.IndexExpr:
+		add(n.Object, n.Index)
+	case *parser.SliceExpr:
+		add(n.Object, n.Start, n.End)
+	case *parser.SetIndexExpr:
+		add(n.Object, n.Index, n.Value)
+	case *parser.InterpolationExpr:
+		exprs(n.Parts)
+	case *parser.LambdaExpr:
+		exprs(n.Defaults)
+		add(n.Body)
+	case *parser.PropertyExpr:
+		add(n.Object)
+	}
+	return out
+}
+
+// Blocks returns the statement lists that n owns directly: a function or
+// loop body, both branches of an if, every clause of a try, each match arm
+func Blocks(n Node) [][]parser.Stmt {
+	switch n := n.(type) {
+	case *parser.FunctionStmt:
+		return [][]parser.Stmt{n.Body}
+	case *parser.IfStmt:
+		return [][]parser.Stmt{n.Then, n.Else}
+	case *parser.WhileStmt:
+		return [][]parser.Stmt{n.Body}
+	case *parser.ForStmt:
+		return [][]parser.Stmt{n.Body}
+	case *parser.ForInStmt:
+		return [][]parser.Stmt{n.Body}
+	case *parser.TryStmt:
+		return [][]parser.Stmt{n.TryBlock, n.CatchBlock, n.FinallyBlock}
+	case *parser.MatchStmt:
+		var blocks [][]parser.Stmt
+		for _, mc := range n.Cases {
+			blocks = append(blocks, mc.Body)
+		}
+		return blocks
+	case *parser.BlockExpr:
+		return [][]parser.Stmt{n.Stmts}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sentra/internal/formatter"
+	"sentra/internal/lexer"
+)
+
+// Edit types
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type WorkspaceEdit struct {
+	Changes map[string][]TextEdit `json:"changes"`
+}
+
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type DocumentRangeFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+}
+
+type RenameParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	NewName      string                 `json:"newName"`
+}
+
+func (s *Server) handleFormatting(msg *Message) error {
+	var params DocumentFormattingParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return s.sendError(msg.ID, -32602, "Invalid params")
+	}
+
+	s.mu.Lock()
+	doc, ok := s.docs[params.TextDocument.URI]
+	s.mu.Unlock()
+	if !ok {
+		return s.sendResponse(msg.ID, []TextEdit{})
+	}
+
+	formatted, err := formatSource(doc.Content, uriToPath(params.TextDocument.URI))
+	if err != nil {
+		return s.sendError(msg.ID, -32603, err.Error())
+	}
+	if formatted == doc.Content {
+		return s.sendResponse(msg.ID, []TextEdit{})
+	}
+	return s.sendResponse(msg.ID, []TextEdit{{Range: wholeDocument(doc.Content), NewText: formatted}})
+}
+
+// handleRangeFormatting formats the complete lines covered by the range.
+// The selection has to consist of whole statements.
+func (s *Server) handleRangeFormatting(msg *Message) error {
+	var params DocumentRangeFormattingParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return s.sendError(msg.ID, -32602, "Invalid params")
+	}
+
+	s.mu.Lock()
+	doc, ok := s.docs[params.TextDocument.URI]
+	s.mu.Unlock()
+	if !ok {
+		return s.sendResponse(msg.ID, []TextEdit{})
+	}
+
+	lines := strings.Split(doc.Content, "\n")
+	first, last := params.Range.Start.Line, params.Range.End.Line
+	if last > first && params.Range.End.Character == 0 {
+		last-- // Selection ends at the start of the following line
+	}
+	if first < 0 || first >= len(lines) || last < first {
+		return s.sendResponse(msg.ID, []TextEdit{})
+	}
+	if last >= len(lines) {
+		last = len(lines) - 1
+	}
+
+	selected := strings.Join(lines[first:last+1], "\n")
+	formatted, err := formatSource(selected, uriToPath(params.TextDocument.URI))
+	if err != nil {
+		return s.sendError(msg.ID, -32603, "selection is not a complete set of statements: "+err.Error())
+	}
+
+	// Keep the selection at the indentation of its first line
+	base := lines[first][:len(lines[first])-len(strings.TrimLeft(lines[first], " \t"))]
+	out := strings.Split(strings.TrimRight(formatted, "\n"), "\n")
+	for i, line := range out {
+		if line != "" {
+			out[i] = base + line
+		}
+	}
+
+	edit := TextEdit{
+		Range: Range{
+			Start: Position{Line: first, Character: 0},
+			End:   Position{Line: last, Character: len(lines[last])},
+		},
+		NewText: strings.Join(out, "\n"),
+	}
+	return s.sendResponse(msg.ID, []TextEdit{edit})
+}
+
+func (s *Server) handleRename(msg *Message) error {
+	var params RenameParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return s.sendError(msg.ID, -32602, "Invalid params")
+	}
+	if !isIdentifier(params.NewName) {
+		return s.sendError(msg.ID, -32602, fmt.Sprintf("%q is not a valid identifier", params.NewName))
+	}
+
+	ws, file := s.workspaceFor(params.TextDocument.URI)
+	if file == nil {
+		return s.sendResponse(msg.ID, nil)
+	}
+	i := file.tokenAt(params.Position)
+	if i < 0 {
+		return s.sendError(msg.ID, -32602, "No symbol at this position")
+	}
+	def, ok := ws.definition(file, i)
+	if !ok || def.Name == "" {
+		return s.sendError(msg.ID, -32602, "Cannot rename "+file.Tokens[i].Lexeme+": no definition found")
+	}
+
+	// Renaming the y of import {x as y} only renames the alias in this file
+	name := def.Name
+	if b, ok := file.Imports[file.Tokens[i].Lexeme]; ok && b.Member != file.Tokens[i].Lexeme {
+		name = file.Tokens[i].Lexeme
+	}
+
+	edit := WorkspaceEdit{Changes: make(map[string][]TextEdit)}
+	for _, ref := range ws.references(def, true) {
+		if ref.Name != name || (name != def.Name && ref.File != file.Path) {
+			continue
+		}
+		loc := ref.location()
+		edit.Changes[loc.URI] = append(edit.Changes[loc.URI], TextEdit{Range: loc.Range, NewText: params.NewName})
+	}
+	return s.sendResponse(msg.ID, edit)
+}
+
+// formatSource runs the sentra fmt formatter over source, using the
+// formatter configuration that applies to path
+func formatSource(source, path string) (string, error) {
+	config, err := formatter.LoadConfig(path)
+	if err != nil {
+		return "", err
+	}
+	return formatter.FormatSource(source, path, config)
+}
+
+// wholeDocument returns a range covering all of content
+func wholeDocument(content string) Range {
+	lines := strings.Split(content, "\n")
+	last := len(lines) - 1
+	return Range{
+		Start: Position{Line: 0, Character: 0},
+		End:   Position{Line: last, Character: len(lines[last])},
+	}
+}
+
+// isIdentifier reports whether name lexes as a single identifier, which
+// rules out keywords
+func isIdentifier(name string) bool {
+	scanner := lexer.NewScanner(name)
+	tokens := scanner.ScanTokens()
+	return !scanner.HadError() && len(tokens) == 2 && tokens[0].Type == lexer.TokenIdent && tokens[0].Lexeme == name
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 
+	"sentra/internal/builtins"
 	"sentra/internal/lexer"
 	"sentra/internal/parser"
 )
@@ -26,6 +28,7 @@ type Server struct {
 	out     io.Writer
 	mu      sync.Mutex
 	docs    map[string]*Document
+	root    string // Workspace root directory from initialize
 	running bool
 }
 
@@ -151,6 +154,14 @@ func (s *Server) dispatch(msg *Message) error {
 		return s.handleHover(msg)
 	case "textDocument/definition":
 		return s.handleDefinition(msg)
+	case "textDocument/references":
+		return s.handleReferences(msg)
+	case "textDocument/formatting":
+		return s.handleFormatting(msg)
+	case "textDocument/rangeFormatting":
+		return s.handleRangeFormatting(msg)
+	case "textDocument/rename":
+		return s.handleRename(msg)
 	case "textDocument/documentSymbol":
 		return s.handleDocumentSymbol(msg)
 	default:
@@ -256,6 +267,10 @@ type ServerCapabilities struct {
 	CompletionProvider *CompletionOptions      `json:"completionProvider,omitempty"`
 	HoverProvider      bool                    `json:"hoverProvider"`
 	DefinitionProvider bool                    `json:"definitionProvider"`
+	ReferencesProvider bool                    `json:"referencesProvider"`
+	DocumentFormattingProvider      bool       `json:"documentFormattingProvider"`
+	DocumentRangeFormattingProvider bool       `json:"documentRangeFormattingProvider"`
+	RenameProvider                  bool       `json:"renameProvider"`
 	DocumentSymbolProvider bool                `json:"documentSymbolProvider"`
 }
 
@@ -265,6 +280,11 @@ type CompletionOptions struct {
 }
 
 func (s *Server) handleInitialize(msg *Message) error {
+	var params InitializeParams
+	if err := json.Unmarshal(msg.Params, &params); err == nil && params.RootURI != "" {
+		s.root = uriToPath(params.RootURI)
+	}
+
 	result := InitializeResult{
 		Capabilities: ServerCapabilities{
 			TextDocumentSync: 1, // Full sync
@@ -274,6 +294,10 @@ func (s *Server) handleInitialize(msg *Message) error {
 			},
 			HoverProvider:      true,
 			DefinitionProvider: true,
+			ReferencesProvider: true,
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			RenameProvider:                  true,
 			DocumentSymbolProvider: true,
 		},
 	}
@@ -460,8 +484,12 @@ type CompletionItem struct {
 	Detail        string `json:"detail,omitempty"`
 	Documentation string `json:"documentation,omitempty"`
 	InsertText    string `json:"insertText,omitempty"`
+	InsertTextFormat int `json:"insertTextFormat,omitempty"`
 }
 
+// InsertTextFormatSnippet marks InsertText as containing ${1:placeholders}
+const InsertTextFormatSnippet = 2
+
 // CompletionItemKind constants
 const (
 	CompletionItemKindText          = 1
@@ -501,27 +529,31 @@ var sentraKeywords = []CompletionItem{
 	{Label: "class", Kind: CompletionItemKindKeyword, Detail: "Class declaration"},
 }
 
-var sentraBuiltins = []CompletionItem{
-	{Label: "print", Kind: CompletionItemKindFunction, Detail: "fn print(value)", Documentation: "Prints a value to stdout"},
-	{Label: "len", Kind: CompletionItemKindFunction, Detail: "fn len(value) -> int", Documentation: "Returns length of string, array, or map"},
-	{Label: "push", Kind: CompletionItemKindFunction, Detail: "fn push(array, value)", Documentation: "Appends value to array"},
-	{Label: "pop", Kind: CompletionItemKindFunction, Detail: "fn pop(array) -> value", Documentation: "Removes and returns last element"},
-	{Label: "keys", Kind: CompletionItemKindFunction, Detail: "fn keys(map) -> array", Documentation: "Returns array of map keys"},
-	{Label: "values", Kind: CompletionItemKindFunction, Detail: "fn values(map) -> array", Documentation: "Returns array of map values"},
-	{Label: "typeof", Kind: CompletionItemKindFunction, Detail: "fn typeof(value) -> string", Documentation: "Returns type name of value"},
-	{Label: "str", Kind: CompletionItemKindFunction, Detail: "fn str(value) -> string", Documentation: "Converts value to string"},
-	{Label: "int", Kind: CompletionItemKindFunction, Detail: "fn int(value) -> int", Documentation: "Converts value to integer"},
-	{Label: "float", Kind: CompletionItemKindFunction, Detail: "fn float(value) -> float", Documentation: "Converts value to float"},
-	{Label: "abs", Kind: CompletionItemKindFunction, Detail: "fn abs(n) -> number", Documentation: "Returns absolute value"},
-	{Label: "sqrt", Kind: CompletionItemKindFunction, Detail: "fn sqrt(n) -> float", Documentation: "Returns square root"},
-	{Label: "floor", Kind: CompletionItemKindFunction, Detail: "fn floor(n) -> int", Documentation: "Rounds down to nearest integer"},
-	{Label: "ceil", Kind: CompletionItemKindFunction, Detail: "fn ceil(n) -> int", Documentation: "Rounds up to nearest integer"},
-	{Label: "round", Kind: CompletionItemKindFunction, Detail: "fn round(n) -> int", Documentation: "Rounds to nearest integer"},
-	{Label: "split", Kind: CompletionItemKindFunction, Detail: "fn split(str, sep) -> array", Documentation: "Splits string by separator"},
-	{Label: "join", Kind: CompletionItemKindFunction, Detail: "fn join(array, sep) -> string", Documentation: "Joins array elements with separator"},
-	{Label: "trim", Kind: CompletionItemKindFunction, Detail: "fn trim(str) -> string", Documentation: "Removes leading/trailing whitespace"},
-	{Label: "upper", Kind: CompletionItemKindFunction, Detail: "fn upper(str) -> string", Documentation: "Converts to uppercase"},
-	{Label: "lower", Kind: CompletionItemKindFunction, Detail: "fn lower(str) -> string", Documentation: "Converts to lowercase"},
+var (
+	builtinsOnce    sync.Once
+	sentraBuiltinFn []CompletionItem
+)
+
+// sentraBuiltins returns completion items for every builtin in the shared
+// registry, which both VMs register against
+func sentraBuiltins() []CompletionItem {
+	builtinsOnce.Do(func() {
+		for _, b := range builtins.All() {
+			placeholders := make([]string, len(b.Params))
+			for i, param := range b.Params {
+				placeholders[i] = fmt.Sprintf("${%d:%s}", i+1, param)
+			}
+			sentraBuiltinFn = append(sentraBuiltinFn, CompletionItem{
+				Label:            b.Name,
+				Kind:             CompletionItemKindFunction,
+				Detail:           b.Signature(),
+				Documentation:    b.Doc,
+				InsertText:       b.Name + "(" + strings.Join(placeholders, ", ") + ")",
+				InsertTextFormat: InsertTextFormatSnippet,
+			})
+		}
+	})
+	return sentraBuiltinFn
 }
 
 func (s *Server) handleCompletion(msg *Message) error {
@@ -549,7 +581,7 @@ func (s *Server) handleCompletion(msg *Message) error {
 		}
 
 		// Add matching builtins
-		for _, fn := range sentraBuiltins {
+		for _, fn := range sentraBuiltins() {
 			if strings.HasPrefix(fn.Label, prefix) {
 				items = append(items, fn)
 			}
@@ -635,7 +667,7 @@ func (s *Server) handleHover(msg *Message) error {
 	}
 
 	// Check builtins
-	for _, fn := range sentraBuiltins {
+	for _, fn := range sentraBuiltins() {
 		if fn.Label == word {
 			return s.sendResponse(msg.ID, Hover{
 				Contents: MarkupContent{
@@ -661,9 +693,79 @@ type Location struct {
 }
 
 func (s *Server) handleDefinition(msg *Message) error {
-	// TODO: Implement proper definition lookup
-	// For now, return null (no definition found)
-	return s.sendResponse(msg.ID, nil)
+	var params DefinitionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return s.sendError(msg.ID, -32602, "Invalid params")
+	}
+
+	ws, file := s.workspaceFor(params.TextDocument.URI)
+	if file == nil {
+		return s.sendResponse(msg.ID, nil)
+	}
+	i := file.tokenAt(params.Position)
+	if i < 0 {
+		return s.sendResponse(msg.ID, nil)
+	}
+	def, ok := ws.definition(file, i)
+	if !ok {
+		return s.sendResponse(msg.ID, nil)
+	}
+	return s.sendResponse(msg.ID, def.location())
+}
+
+// References types
+type ReferenceParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      ReferenceContext       `json:"context"`
+}
+
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+func (s *Server) handleReferences(msg *Message) error {
+	var params ReferenceParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return s.sendError(msg.ID, -32602, "Invalid params")
+	}
+
+	locations := []Location{}
+	ws, file := s.workspaceFor(params.TextDocument.URI)
+	if file == nil {
+		return s.sendResponse(msg.ID, locations)
+	}
+	i := file.tokenAt(params.Position)
+	if i < 0 {
+		return s.sendResponse(msg.ID, locations)
+	}
+	def, ok := ws.definition(file, i)
+	if !ok || def.Name == "" {
+		return s.sendResponse(msg.ID, locations)
+	}
+	for _, ref := range ws.references(def, params.Context.IncludeDeclaration) {
+		locations = append(locations, ref.location())
+	}
+	return s.sendResponse(msg.ID, locations)
+}
+
+// workspaceFor snapshots the open documents into a workspace and returns
+// the index of the document at uri
+func (s *Server) workspaceFor(uri string) (*workspace, *fileIndex) {
+	s.mu.Lock()
+	open := make(map[string]string, len(s.docs))
+	for docURI, doc := range s.docs {
+		open[uriToPath(docURI)] = doc.Content
+	}
+	root := s.root
+	s.mu.Unlock()
+
+	path := uriToPath(uri)
+	if root == "" {
+		root = filepath.Dir(path)
+	}
+	ws := newWorkspace(root, open)
+	return ws, ws.file(path)
 }
 
 // Document Symbol types
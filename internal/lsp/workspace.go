@@ -0,0 +1,411 @@
+package lsp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"sentra/internal/lexer"
+	"sentra/internal/packages"
+	"sentra/internal/parser"
+)
+
+// symbolLoc is the position of a name token in a file (1-based, as produced
+// by the lexer)
+type symbolLoc struct {
+	File   string
+	Line   int
+	Column int
+	Name   string
+}
+
+// moduleBinding is a name bound by an import statement. Member is set for
+// selective imports (import {scan} from "./lib/scanner") and empty when the
+// whole module is bound.
+type moduleBinding struct {
+	Module string // Resolved module file, empty if it could not be found
+	Member string
+}
+
+// fileIndex is the lexed and parsed form of one source file
+type fileIndex struct {
+	Path     string
+	Tokens   []lexer.Token
+	Stmts    []parser.Stmt
+	Imports  map[string]moduleBinding
+	TopLevel map[string]symbolLoc // Functions and variables declared at the top level
+	decls    []symbolLoc          // Every declaration site in source order
+	declAt   map[int]bool         // Token indexes that are declaration sites
+	imported map[int]string       // Names listed in selective imports -> import path
+}
+
+// workspace indexes files on demand for cross-file navigation. Open
+// documents take precedence over their contents on disk.
+type workspace struct {
+	root  string
+	open  map[string]string // Path -> content of open documents
+	files map[string]*fileIndex
+}
+
+func newWorkspace(root string, open map[string]string) *workspace {
+	return &workspace{root: root, open: open, files: make(map[string]*fileIndex)}
+}
+
+// file returns the index for path, loading it if needed
+func (w *workspace) file(path string) *fileIndex {
+	if idx, ok := w.files[path]; ok {
+		return idx
+	}
+	content, ok := w.open[path]
+	if !ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			w.files[path] = nil
+			return nil
+		}
+		content = string(data)
+	}
+	idx := indexFile(path, content)
+	w.files[path] = idx
+	idx.resolveImports(w.root)
+	return idx
+}
+
+// indexFile lexes and parses content. A file that fails to parse still
+// yields its tokens so references inside it can be found.
+func indexFile(path, content string) *fileIndex {
+	scanner := lexer.NewScannerWithFile(content, path)
+	idx := &fileIndex{
+		Path:     path,
+		Tokens:   scanner.ScanTokens(),
+		Imports:  make(map[string]moduleBinding),
+		TopLevel: make(map[string]symbolLoc),
+		declAt:   make(map[int]bool),
+		imported: make(map[int]string),
+	}
+	func() {
+		defer func() { recover() }()
+		p := parser.NewParserWithSource(idx.Tokens, content, path)
+		idx.Stmts = p.Parse()
+	}()
+	idx.scanDeclarations()
+
+	for _, stmt := range idx.Stmts {
+		if exp, ok := stmt.(*parser.ExportStmt); ok {
+			stmt = exp.Stmt
+		}
+		switch st := stmt.(type) {
+		case *parser.FunctionStmt:
+			if loc, ok := idx.declaration(st.Name, st.Line); ok {
+				idx.TopLevel[st.Name] = loc
+			}
+		case *parser.ClassStmt:
+			if loc, ok := idx.declaration(st.Name, st.Line); ok {
+				idx.TopLevel[st.Name] = loc
+			}
+		case *parser.LetStmt:
+			if loc, ok := idx.declaration(st.Name, 0); ok {
+				idx.TopLevel[st.Name] = loc
+			}
+		}
+	}
+	return idx
+}
+
+// scanDeclarations records every token that introduces a name: let/var/const
+// bindings, function names and parameters, struct names, for-in variables
+// and catch variables
+func (f *fileIndex) scanDeclarations() {
+	toks := f.Tokens
+	add := func(i int) {
+		if i < len(toks) && toks[i].Type == lexer.TokenIdent {
+			f.declAt[i] = true
+			f.decls = append(f.decls, symbolLoc{File: f.Path, Line: toks[i].Line, Column: toks[i].Column, Name: toks[i].Lexeme})
+		}
+	}
+	for i := 0; i < len(toks); i++ {
+		switch toks[i].Type {
+		case lexer.TokenLet, lexer.TokenVar, lexer.TokenConst, lexer.TokenFor, lexer.TokenStruct:
+			add(i + 1)
+		case lexer.TokenCatch:
+			if i+1 < len(toks) && toks[i+1].Type == lexer.TokenLParen {
+				add(i + 2)
+			} else {
+				add(i + 1)
+			}
+		case lexer.TokenImport:
+			i = f.scanSelectiveImport(i)
+		case lexer.TokenFn:
+			j := i + 1
+			if j < len(toks) && toks[j].Type == lexer.TokenIdent {
+				add(j)
+				j++
+			}
+			if j < len(toks) && toks[j].Type == lexer.TokenLParen {
+				for j++; j < len(toks) && toks[j].Type != lexer.TokenRParen; j++ {
+					add(j)
+				}
+			}
+		}
+	}
+}
+
+// scanSelectiveImport records the exported names in
+// import {a, b as c} from "path" and returns the index of the last token
+func (f *fileIndex) scanSelectiveImport(i int) int {
+	toks := f.Tokens
+	if i+1 >= len(toks) || toks[i+1].Type != lexer.TokenLBrace {
+		return i
+	}
+	var names []int
+	j := i + 2
+	for ; j < len(toks) && toks[j].Type != lexer.TokenRBrace; j++ {
+		if toks[j].Type == lexer.TokenIdent && toks[j-1].Type != lexer.TokenAs {
+			names = append(names, j)
+		}
+	}
+	if j+2 < len(toks) && toks[j+1].Lexeme == "from" {
+		for _, n := range names {
+			f.imported[n] = toks[j+2].Lexeme
+		}
+		return j + 2
+	}
+	return j
+}
+
+// declaration finds the declaration site of name, preferring one on line
+// when line is non-zero
+func (f *fileIndex) declaration(name string, line int) (symbolLoc, bool) {
+	var found symbolLoc
+	ok := false
+	for _, d := range f.decls {
+		if d.Name != name {
+			continue
+		}
+		if line == 0 || d.Line == line {
+			return d, true
+		}
+		if !ok {
+			found, ok = d, true
+		}
+	}
+	return found, ok
+}
+
+// resolveImports records the names bound by the file's import statements
+func (f *fileIndex) resolveImports(root string) {
+	for _, stmt := range f.Stmts {
+		imp, ok := stmt.(*parser.ImportStmt)
+		if !ok {
+			continue
+		}
+		module := resolveModuleFile(imp.Path, f.Path, root)
+		if len(imp.Names) > 0 {
+			for _, n := range imp.Names {
+				f.Imports[n.LocalName()] = moduleBinding{Module: module, Member: n.Name}
+			}
+			continue
+		}
+		// Same binding rule as the compiler: alias or last path component
+		name := imp.Alias
+		if name == "" {
+			name = imp.Path[strings.LastIndex(imp.Path, "/")+1:]
+		}
+		f.Imports[name] = moduleBinding{Module: module}
+	}
+}
+
+// resolveModuleFile mirrors the VM's module search for an import written
+// in file
+func resolveModuleFile(path, file, root string) string {
+	dir := filepath.Dir(file)
+	var candidates []string
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		candidates = append(candidates, filepath.Join(dir, path))
+	} else {
+		if resolved := packages.NewModulePathResolver(dir).Resolve(path); resolved != "" {
+			return resolved
+		}
+		for _, base := range []string{dir, filepath.Join(dir, "lib"), root, filepath.Join(root, "lib")} {
+			if base != "" {
+				candidates = append(candidates, filepath.Join(base, path))
+			}
+		}
+	}
+	for _, c := range candidates {
+		for _, p := range []string{c + ".sn", c, filepath.Join(c, "index.sn")} {
+			if info, err := os.Stat(p); err == nil && !info.IsDir() {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// tokenAt returns the index of the identifier token covering the 0-based
+// LSP position, or -1
+func (f *fileIndex) tokenAt(pos Position) int {
+	for i, tok := range f.Tokens {
+		if tok.Type != lexer.TokenIdent || tok.Line != pos.Line+1 {
+			continue
+		}
+		start := tok.Column - 1
+		if pos.Character >= start && pos.Character <= start+len(tok.Lexeme) {
+			return i
+		}
+	}
+	return -1
+}
+
+// definition resolves the identifier token at index i to its declaration
+func (w *workspace) definition(f *fileIndex, i int) (symbolLoc, bool) {
+	tok := f.Tokens[i]
+	if f.declAt[i] {
+		return symbolLoc{File: f.Path, Line: tok.Line, Column: tok.Column, Name: tok.Lexeme}, true
+	}
+
+	if path, ok := f.imported[i]; ok {
+		return w.exported(resolveModuleFile(path, f.Path, w.root), tok.Lexeme)
+	}
+
+	// module.member
+	if i >= 2 && f.Tokens[i-1].Type == lexer.TokenDot && f.Tokens[i-2].Type == lexer.TokenIdent {
+		if b, ok := f.Imports[f.Tokens[i-2].Lexeme]; ok && b.Member == "" {
+			return w.exported(b.Module, tok.Lexeme)
+		}
+		return symbolLoc{}, false
+	}
+
+	// Nearest earlier declaration, which covers locals and parameters
+	var best symbolLoc
+	found := false
+	for _, d := range f.decls {
+		if d.Name != tok.Lexeme || d.Line > tok.Line || (d.Line == tok.Line && d.Column > tok.Column) {
+			continue
+		}
+		best, found = d, true
+	}
+	if found {
+		return best, true
+	}
+	if loc, ok := f.TopLevel[tok.Lexeme]; ok {
+		return loc, true
+	}
+
+	if b, ok := f.Imports[tok.Lexeme]; ok {
+		if b.Member != "" {
+			return w.exported(b.Module, b.Member)
+		}
+		if b.Module != "" {
+			// The module itself: an empty name marks the start of the file
+			return symbolLoc{File: b.Module, Line: 1, Column: 1}, true
+		}
+	}
+	return symbolLoc{}, false
+}
+
+// exported looks up a top-level name in a module file
+func (w *workspace) exported(module, name string) (symbolLoc, bool) {
+	if module == "" {
+		return symbolLoc{}, false
+	}
+	m := w.file(module)
+	if m == nil {
+		return symbolLoc{}, false
+	}
+	loc, ok := m.TopLevel[name]
+	return loc, ok
+}
+
+// references returns every identifier in the workspace that resolves to def
+func (w *workspace) references(def symbolLoc, includeDecl bool) []symbolLoc {
+	var refs []symbolLoc
+	for _, path := range w.sourceFiles() {
+		f := w.file(path)
+		if f == nil {
+			continue
+		}
+		// Selective imports may bind the definition under another name
+		names := map[string]bool{def.Name: true}
+		for local, b := range f.Imports {
+			if b.Member == def.Name {
+				names[local] = true
+			}
+		}
+		for i, tok := range f.Tokens {
+			if tok.Type != lexer.TokenIdent || !names[tok.Lexeme] {
+				continue
+			}
+			loc, ok := w.definition(f, i)
+			if !ok || loc != def {
+				continue
+			}
+			if !includeDecl && f.declAt[i] && path == def.File {
+				continue
+			}
+			refs = append(refs, symbolLoc{File: path, Line: tok.Line, Column: tok.Column, Name: tok.Lexeme})
+		}
+	}
+	return refs
+}
+
+// sourceFiles lists the .sn files under the workspace root plus any open
+// documents outside it
+func (w *workspace) sourceFiles() []string {
+	seen := make(map[string]bool)
+	var files []string
+	if w.root != "" {
+		filepath.WalkDir(w.root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != w.root && (strings.HasPrefix(name, ".") || name == "node_modules") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".sn") {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	for path := range w.open {
+		if !seen[path] {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func (l symbolLoc) location() Location {
+	start := Position{Line: l.Line - 1, Character: l.Column - 1}
+	end := Position{Line: l.Line - 1, Character: l.Column - 1 + len(l.Name)}
+	return Location{URI: pathToURI(l.File), Range: Range{Start: start, End: end}}
+}
+
+// uriToPath converts a file:// URI to a filesystem path
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CapabilityError reports that acquiring the memory of a process needs a
+// privilege the caller lacks, or that the platform offers no way to do it
+type CapabilityError struct {
+	Op   string // What was attempted, such as "read regions"
+	PID  int
+	Need string // The privilege or support it needs
+	Err  error
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("%s of process %d: %v; needs %s", e.Op, e.PID, e.Err, e.Need)
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnsupported is the cause of the capability errors of platforms that
+// have no memory acquisition backend
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// protectionString names a protection by its R, W and X rights, or NONE
+func protectionString(r, w, x bool) string {
+	s := ""
+	if r {
+		s += "R"
+	}
+	if w {
+		s += "W"
+	}
+	if x {
+		s += "X"
+	}
+	if s == "" {
+		return "NONE"
+	}
+	return s
+}
+
+// Readable reports whether a region is committed and can be read
+func (r *MemoryRegion) Readable() bool {
+	return r.State == "Commit" && len(r.Protection) > 0 && r.Protection[0] == 'R'
+}
+
+// Executable reports whether a region holds code that can run
+func (r *MemoryRegion) Executable() bool {
+	return r.Protection == "RX" || r.Protection == "RWX" || r.Protection == "X"
+}
+
+// ReadMemory reads size bytes of a process's memory at an address. It
+// returns fewer bytes when the read crosses into memory that cannot be
+// read.
+func (ef *EnhancedForensics) ReadMemory(pid int, address uint64, size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	return readMemory(pid, address, size)
+}
+
+// DumpOptions chooses the regions a dump holds
+type DumpOptions struct {
+	ExecutableOnly bool
+	MaxBytes       uint64 // Stop once the dump holds this much, 0 for no limit
+}
+
+// DumpedRegion is a region of a dump and where its bytes are in the file
+type DumpedRegion struct {
+	MemoryRegion
+	FileOffset uint64
+}
+
+// DumpResult describes a memory dump
+type DumpResult struct {
+	Path      string
+	Regions   []DumpedRegion
+	Bytes     uint64
+	Skipped   int  // Readable regions that could not be read
+	Truncated bool // MaxBytes was reached
+	SHA256    string
+}
+
+// dumpChunk is how much of a region is read at once
+const dumpChunk = 1 << 20
+
+// DumpProcess writes the readable regions of a process one after another
+// to a file. The result tells where each region's bytes are.
+func (ef *EnhancedForensics) DumpProcess(pid int, path string, opts DumpOptions) (*DumpResult, error) {
+	regions, err := ef.GetMemoryRegions(pid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	result := &DumpResult{Path: path}
+	for _, r := range regions {
+		if !r.Readable() || (opts.ExecutableOnly && !r.Executable()) {
+			continue
+		}
+		if opts.MaxBytes > 0 && result.Bytes+r.Size > opts.MaxBytes {
+			result.Truncated = true
+			break
+		}
+		// A region is read whole before it is written, so one that fails
+		// partway leaves nothing behind
+		data := make([]byte, 0, r.Size)
+		for off := uint64(0); off < r.Size; off += dumpChunk {
+			n := r.Size - off
+			if n > dumpChunk {
+				n = dumpChunk
+			}
+			chunk, err := readMemory(pid, uint64(r.BaseAddress)+off, int(n))
+			var capErr *CapabilityError
+			if errors.As(err, &capErr) {
+				return nil, err
+			}
+			if err != nil || uint64(len(chunk)) != n {
+				data = nil
+				break
+			}
+			data = append(data, chunk...)
+		}
+		if data == nil {
+			result.Skipped++
+			continue
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		result.Regions = append(result.Regions, DumpedRegion{MemoryRegion: *r, FileOffset: result.Bytes})
+		result.Bytes += r.Size
+	}
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return result, nil
+}
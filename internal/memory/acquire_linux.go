@@ -0,0 +1,172 @@
+//go:build linux
+
+// internal/memory/acquire_linux.go
+package memory
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ptraceNeed is what reading another process's memory takes on Linux
+const ptraceNeed = "root or CAP_SYS_PTRACE, or a process of the same user that kernel.yama.ptrace_scope lets this one trace"
+
+// procError turns the error of a /proc file into one that says why
+func procError(op string, pid int, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("process %d not found", pid)
+	case errors.Is(err, fs.ErrPermission):
+		return &CapabilityError{Op: op, PID: pid, Need: ptraceNeed, Err: err}
+	}
+	return err
+}
+
+// queryRegions reads the mappings of a process from /proc/pid/maps
+func queryRegions(pid int) ([]*MemoryRegion, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, procError("read regions", pid, err)
+	}
+	defer f.Close()
+	var regions []*MemoryRegion
+	executableFiles := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address perms offset dev inode pathname
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 || len(fields[1]) < 4 {
+			continue
+		}
+		start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+		end, err2 := strconv.ParseUint(bounds[1], 16, 64)
+		if err1 != nil || err2 != nil || end < start {
+			continue
+		}
+		perms := fields[1]
+		path := ""
+		if len(fields) > 5 {
+			path = strings.Join(fields[5:], " ")
+		}
+		r := &MemoryRegion{
+			BaseAddress: uintptr(start),
+			Size:        end - start,
+			Protection:  protectionString(perms[0] == 'r', perms[1] == 'w', perms[2] == 'x'),
+			State:       "Commit",
+			Path:        path,
+		}
+		switch {
+		case path == "[heap]":
+			r.Type = "Heap"
+		case strings.HasPrefix(path, "[stack"):
+			r.Type = "Stack"
+		case path == "[vdso]" || path == "[vsyscall]":
+			r.Type = "Image"
+		case path == "" || strings.HasPrefix(path, "["):
+			r.Type = "Private"
+			if perms[3] == 's' {
+				r.Type = "Mapped"
+			}
+		default:
+			r.Type = "Mapped"
+			if r.Executable() {
+				executableFiles[path] = true
+			}
+		}
+		regions = append(regions, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, procError("read regions", pid, err)
+	}
+	// Every mapping of a file that is mapped executable, such as the data
+	// of a library, is part of its image
+	for _, r := range regions {
+		if r.Type == "Mapped" && executableFiles[r.Path] {
+			r.Type = "Image"
+		}
+	}
+	return regions, nil
+}
+
+// readMemory reads a process's memory through /proc/pid/mem
+func readMemory(pid int, address uint64, size int) ([]byte, error) {
+	if address > math.MaxInt64 {
+		return nil, fmt.Errorf("address 0x%x cannot be read through /proc", address)
+	}
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, procError("read memory", pid, err)
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, int64(address))
+	if n > 0 {
+		return buf[:n], nil
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return nil, procError("read memory", pid, err)
+		}
+		return nil, fmt.Errorf("read memory of process %d at 0x%x: %v", pid, address, err)
+	}
+	return buf[:n], nil
+}
+
+// listProcesses reads every process from /proc
+func listProcesses() ([]*ProcessInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+	var processes []*ProcessInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name())
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			// The process exited while the list was read
+			continue
+		}
+		// pid (comm) state ppid ... with comm in parentheses, which may
+		// hold spaces or parentheses itself
+		s := string(stat)
+		lp, rp := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+		if lp < 0 || rp < lp {
+			continue
+		}
+		fields := strings.Fields(s[rp+1:])
+		if len(fields) < 22 {
+			continue
+		}
+		p := &ProcessInfo{PID: pid, Name: s[lp+1 : rp]}
+		p.ParentPID, _ = strconv.Atoi(fields[1])
+		p.Threads, _ = strconv.Atoi(fields[17])
+		p.VirtualSize, _ = strconv.ParseUint(fields[20], 10, 64)
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+		p.WorkingSet = rss * pageSize
+		p.Path, _ = os.Readlink(filepath.Join(dir, "exe"))
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			p.CommandLine = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		}
+		if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+			p.Handles = len(fds)
+		}
+		processes = append(processes, p)
+	}
+	return processes, nil
+}
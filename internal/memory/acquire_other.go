@@ -0,0 +1,53 @@
+//go:build !linux && !windows
+
+// internal/memory/acquire_other.go
+package memory
+
+import (
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// acquireNeed is what memory acquisition would take on this platform
+func acquireNeed() string {
+	if runtime.GOOS == "darwin" {
+		return "task_for_pid, which takes root and the com.apple.security.cs.debugger entitlement; use a Linux or Windows host, or an offline image"
+	}
+	return "a memory acquisition backend, which only Linux and Windows builds have"
+}
+
+func queryRegions(pid int) ([]*MemoryRegion, error) {
+	return nil, &CapabilityError{Op: "read regions", PID: pid, Need: acquireNeed(), Err: ErrUnsupported}
+}
+
+func readMemory(pid int, address uint64, size int) ([]byte, error) {
+	return nil, &CapabilityError{Op: "read memory", PID: pid, Need: acquireNeed(), Err: ErrUnsupported}
+}
+
+// listProcesses reads every process from ps
+func listProcesses() ([]*ProcessInfo, error) {
+	out, err := exec.Command("ps", "-axo", "pid=,ppid=,rss=,vsz=,comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	var processes []*ProcessInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		p := &ProcessInfo{}
+		p.PID, _ = strconv.Atoi(fields[0])
+		p.ParentPID, _ = strconv.Atoi(fields[1])
+		rss, _ := strconv.ParseUint(fields[2], 10, 64)
+		vsz, _ := strconv.ParseUint(fields[3], 10, 64)
+		p.WorkingSet, p.VirtualSize = rss*1024, vsz*1024
+		p.Path = strings.Join(fields[4:], " ")
+		p.Name = p.Path[strings.LastIndex(p.Path, "/")+1:]
+		p.CommandLine = p.Path
+		processes = append(processes, p)
+	}
+	return processes, nil
+}
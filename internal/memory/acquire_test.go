@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func skipWithoutBackend(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skipf("no memory acquisition on %s", runtime.GOOS)
+	}
+}
+
+func TestRegionsOfSelf(t *testing.T) {
+	skipWithoutBackend(t)
+	ef := NewEnhancedForensics()
+	regions, err := ef.GetMemoryRegions(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, _ := os.Executable()
+	code := false
+	for _, r := range regions {
+		if r.Executable() && r.Type == "Image" && (runtime.GOOS != "linux" || r.Path == exe) {
+			code = true
+		}
+	}
+	if !code {
+		t.Errorf("no executable image region of %s in %d regions", exe, len(regions))
+	}
+	if _, err := ef.GetMemoryRegions(1 << 30); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("regions of a missing process: %v", err)
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	skipWithoutBackend(t)
+	marker := []byte("sentra memory marker 0123456789")
+	ef := NewEnhancedForensics()
+	data, err := ef.ReadMemory(os.Getpid(), uint64(uintptr(unsafe.Pointer(&marker[0]))), len(marker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(marker) {
+		t.Errorf("ReadMemory = %q", data)
+	}
+	runtime.KeepAlive(marker)
+	if _, err := ef.ReadMemory(os.Getpid(), 0, 16); err == nil {
+		t.Error("reading address 0 succeeded")
+	}
+}
+
+func TestDumpProcess(t *testing.T) {
+	skipWithoutBackend(t)
+	ef := NewEnhancedForensics()
+	path := filepath.Join(t.TempDir(), "self.dmp")
+	result, err := ef.DumpProcess(os.Getpid(), path, DumpOptions{ExecutableOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if uint64(len(data)) != result.Bytes || hex.EncodeToString(sum[:]) != result.SHA256 || len(result.Regions) == 0 {
+		t.Fatalf("dump of %d bytes, result %d bytes in %d regions", len(data), result.Bytes, len(result.Regions))
+	}
+	var offset uint64
+	for _, r := range result.Regions {
+		if !r.Executable() || r.FileOffset != offset {
+			t.Errorf("region %+v at offset %d, want %d", r.MemoryRegion, r.FileOffset, offset)
+		}
+		offset += r.Size
+	}
+
+	limited, err := ef.DumpProcess(os.Getpid(), path, DumpOptions{MaxBytes: 1})
+	if err != nil || !limited.Truncated || limited.Bytes != 0 {
+		t.Errorf("DumpProcess with MaxBytes = %+v, %v", limited, err)
+	}
+}
+
+func TestEnumerateProcesses(t *testing.T) {
+	ef := NewEnhancedForensics()
+	processes, err := ef.EnumerateProcesses()
+	if err != nil {
+		t.Skipf("cannot list processes: %v", err)
+	}
+	for _, p := range processes {
+		if p.PID == os.Getpid() {
+			if p.ParentPID != os.Getppid() {
+				t.Errorf("parent of self = %d, want %d", p.ParentPID, os.Getppid())
+			}
+			return
+		}
+	}
+	t.Errorf("self not among %d processes", len(processes))
+}
+
+func TestCapabilityError(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() == 0 {
+		t.Skip("needs an unprivileged Linux user")
+	}
+	_, err := NewEnhancedForensics().ReadMemory(1, 0x400000, 16)
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) || !strings.Contains(err.Error(), "CAP_SYS_PTRACE") {
+		t.Errorf("reading pid 1 = %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+//go:build windows
+
+// internal/memory/acquire_windows.go
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// debugNeed is what reading another process's memory takes on Windows
+const debugNeed = "administrator rights with SeDebugPrivilege"
+
+// Memory types and the free state of MEMORY_BASIC_INFORMATION
+const (
+	memFree    = 0x10000
+	memPrivate = 0x20000
+	memMapped  = 0x40000
+	memImage   = 0x1000000
+)
+
+var (
+	kernel32              = windows.NewLazySystemDLL("kernel32.dll")
+	procGetMappedFileName = kernel32.NewProc("K32GetMappedFileNameW")
+	procGetProcessMemInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessHandles = kernel32.NewProc("GetProcessHandleCount")
+	debugPrivilegeOnce    sync.Once
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	Size                       uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// enableDebugPrivilege turns on SeDebugPrivilege, which administrators
+// hold but have disabled, so processes of other users can be opened
+func enableDebugPrivilege() {
+	debugPrivilegeOnce.Do(func() {
+		var token windows.Token
+		if windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &token) != nil {
+			return
+		}
+		defer token.Close()
+		var luid windows.LUID
+		if windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr("SeDebugPrivilege"), &luid) != nil {
+			return
+		}
+		privileges := windows.Tokenprivileges{PrivilegeCount: 1}
+		privileges.Privileges[0] = windows.LUIDAndAttributes{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED}
+		windows.AdjustTokenPrivileges(token, false, &privileges, 0, nil, nil)
+	})
+}
+
+// openProcess opens a process for reading its memory
+func openProcess(op string, pid int) (windows.Handle, error) {
+	enableDebugPrivilege()
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, uint32(pid))
+	switch {
+	case errors.Is(err, windows.ERROR_ACCESS_DENIED):
+		return 0, &CapabilityError{Op: op, PID: pid, Need: debugNeed, Err: err}
+	case errors.Is(err, windows.ERROR_INVALID_PARAMETER):
+		return 0, fmt.Errorf("process %d not found", pid)
+	case err != nil:
+		return 0, err
+	}
+	return h, nil
+}
+
+// mappedFileName returns the file a region maps, as a device path
+func mappedFileName(h windows.Handle, address uintptr) string {
+	buf := make([]uint16, windows.MAX_PATH)
+	n, _, _ := procGetMappedFileName.Call(uintptr(h), address, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if n == 0 {
+		return ""
+	}
+	return windows.UTF16ToString(buf[:n])
+}
+
+// queryRegions walks a process's address space with VirtualQueryEx
+func queryRegions(pid int) ([]*MemoryRegion, error) {
+	h, err := openProcess("read regions", pid)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	var regions []*MemoryRegion
+	var address uintptr
+	for {
+		var mbi windows.MemoryBasicInformation
+		if windows.VirtualQueryEx(h, address, &mbi, unsafe.Sizeof(mbi)) != nil {
+			break
+		}
+		if mbi.State != memFree {
+			r := &MemoryRegion{BaseAddress: mbi.BaseAddress, Size: uint64(mbi.RegionSize), State: "Reserve"}
+			if mbi.State == windows.MEM_COMMIT {
+				r.State = "Commit"
+			}
+			protect := mbi.Protect &^ (windows.PAGE_GUARD | windows.PAGE_NOCACHE | windows.PAGE_WRITECOMBINE)
+			if mbi.Protect&windows.PAGE_GUARD != 0 {
+				// Guard pages fault on first touch, so they are not read
+				protect = windows.PAGE_NOACCESS
+			}
+			switch protect {
+			case windows.PAGE_READONLY:
+				r.Protection = "R"
+			case windows.PAGE_READWRITE, windows.PAGE_WRITECOPY:
+				r.Protection = "RW"
+			case windows.PAGE_EXECUTE:
+				r.Protection = "X"
+			case windows.PAGE_EXECUTE_READ:
+				r.Protection = "RX"
+			case windows.PAGE_EXECUTE_READWRITE, windows.PAGE_EXECUTE_WRITECOPY:
+				r.Protection = "RWX"
+			default:
+				r.Protection = "NONE"
+			}
+			switch mbi.Type {
+			case memImage:
+				r.Type = "Image"
+				r.Path = mappedFileName(h, mbi.BaseAddress)
+			case memMapped:
+				r.Type = "Mapped"
+				r.Path = mappedFileName(h, mbi.BaseAddress)
+			default:
+				r.Type = "Private"
+			}
+			regions = append(regions, r)
+		}
+		next := mbi.BaseAddress + mbi.RegionSize
+		if next <= address {
+			break
+		}
+		address = next
+	}
+	return regions, nil
+}
+
+// readMemory reads a process's memory with ReadProcessMemory
+func readMemory(pid int, address uint64, size int) ([]byte, error) {
+	h, err := openProcess("read memory", pid)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]byte, size)
+	var n uintptr
+	err = windows.ReadProcessMemory(h, uintptr(address), &buf[0], uintptr(size), &n)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read memory of process %d at 0x%x: %v", pid, address, err)
+	}
+	return buf[:n], nil
+}
+
+// listProcesses reads every process from a Toolhelp snapshot
+func listProcesses() ([]*ProcessInfo, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	var processes []*ProcessInfo
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		p := &ProcessInfo{
+			PID:       int(entry.ProcessID),
+			Name:      windows.UTF16ToString(entry.ExeFile[:]),
+			ParentPID: int(entry.ParentProcessID),
+			Threads:   int(entry.Threads),
+		}
+		if h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, entry.ProcessID); err == nil {
+			buf := make([]uint16, windows.MAX_LONG_PATH)
+			size := uint32(len(buf))
+			if windows.QueryFullProcessImageName(h, 0, &buf[0], &size) == nil {
+				p.Path = windows.UTF16ToString(buf[:size])
+				p.CommandLine = p.Path
+			}
+			var counters processMemoryCounters
+			counters.Size = uint32(unsafe.Sizeof(counters))
+			if ok, _, _ := procGetProcessMemInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Size)); ok != 0 {
+				p.WorkingSet = uint64(counters.WorkingSetSize)
+				p.VirtualSize = uint64(counters.PagefileUsage)
+			}
+			var handles uint32
+			if ok, _, _ := procGetProcessHandles.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); ok != 0 {
+				p.Handles = int(handles)
+			}
+			windows.CloseHandle(h)
+		}
+		processes = append(processes, p)
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return nil, err
+	}
+	return processes, nil
+}
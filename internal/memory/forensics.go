@@ -3,6 +3,7 @@ package memory
 import (
 	"fmt"
 	"runtime"
+	"sync"
 )
 
 // ProcessInfo represents information about a running process
@@ -23,79 +24,65 @@ type MemoryRegion struct {
 	BaseAddress uintptr
 	Size        uint64
 	Protection  string
-	State       string
-	Type        string
+	State       string // Commit or Reserve
+	Type        string // Image, Mapped, Private, Heap or Stack
+	Path        string // The file it maps, if any
 }
 
 // EnhancedForensics provides real memory forensics capabilities
 type EnhancedForensics struct {
+	mu           sync.Mutex
 	processCache map[int]*ProcessInfo
-	regionCache  map[int][]*MemoryRegion
+	images       map[string]*DumpImage // Opened with OpenImage
+	nextImage    int
 }
 
 // NewEnhancedForensics creates a new forensics module with real capabilities
 func NewEnhancedForensics() *EnhancedForensics {
 	return &EnhancedForensics{
 		processCache: make(map[int]*ProcessInfo),
-		regionCache:  make(map[int][]*MemoryRegion),
 	}
 }
 
 // EnumerateProcesses returns a list of all running processes
 func (ef *EnhancedForensics) EnumerateProcesses() ([]*ProcessInfo, error) {
-	var processes []*ProcessInfo
-	
-	// Return mock data to avoid slow system calls
-	processes = append(processes, &ProcessInfo{
-		PID:         1234,
-		Name:        "sentra.exe", 
-		Path:        "C:\\Users\\pc\\Projects\\sentra\\sentra.exe",
-		ParentPID:   1,
-		WorkingSet:  50 * 1024 * 1024, // 50MB
-		VirtualSize: 100 * 1024 * 1024, // 100MB 
-		CommandLine: "sentra.exe run example.sn",
-		Threads:     4,
-		Handles:     42,
-	})
-	
-	// Add some realistic Windows processes
-	systemProcesses := []struct {
-		pid   int
-		name  string
-		path  string
-		ppid  int
-		ws    uint64
-		vs    uint64
-	}{
-		{4, "System", "System", 0, 1024*1024, 2048*1024},
-		{1234, "explorer.exe", "C:\\Windows\\explorer.exe", 1, 100*1024*1024, 200*1024*1024},
-		{5678, "chrome.exe", "C:\\Program Files\\Google\\Chrome\\Application\\chrome.exe", 1234, 500*1024*1024, 1024*1024*1024},
-		{9012, "notepad.exe", "C:\\Windows\\System32\\notepad.exe", 1234, 8*1024*1024, 16*1024*1024},
-		{3456, "svchost.exe", "C:\\Windows\\System32\\svchost.exe", 1, 40*1024*1024, 80*1024*1024},
-	}
-	
-	for _, sp := range systemProcesses {
-		processes = append(processes, &ProcessInfo{
-			PID:         sp.pid,
-			Name:        sp.name,
-			Path:        sp.path,
-			ParentPID:   sp.ppid,
-			WorkingSet:  sp.ws,
-			VirtualSize: sp.vs,
-			CommandLine: sp.path,
-			Threads:     4,
-			Handles:     100,
-		})
+	processes, err := listProcesses()
+	if err != nil {
+		return nil, err
 	}
 	
 	// Cache the results
+	ef.mu.Lock()
+	ef.processCache = make(map[int]*ProcessInfo, len(processes))
 	for _, p := range processes {
 		ef.processCache[p.PID] = p
 	}
+	ef.mu.Unlock()
 	
 	return processes, nil
 }
 
+// process returns a process from the cache, listing the processes again
+// when it is not there
+func (ef *EnhancedForensics) process(pid int) (*ProcessInfo, error) {
+	ef.mu.Lock()
+	process, exists := ef.processCache[pid]
+	ef.mu.Unlock()
+	if exists {
+		return process, nil
+	}
+	processes, err := ef.EnumerateProcesses()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range processes {
+		if p.PID == pid {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("process %d not found", pid)
+}
+
 // FindProcessByName finds processes by name
 func (ef *EnhancedForensics) FindProcessByName(name string) ([]*ProcessInfo, error) {
 	allProcesses, err := ef.EnumerateProcesses()
@@ -154,69 +141,19 @@ func (ef *EnhancedForensics) GetProcessTree() (map[string]interface{}, error) {
 	}, nil
 }
 
-// GetMemoryRegions returns memory regions for a process
+// GetMemoryRegions returns the memory regions of a process, read from
+// /proc on Linux and with VirtualQueryEx on Windows. Other platforms, and
+// callers without the privilege to inspect the process, get a
+// CapabilityError.
 func (ef *EnhancedForensics) GetMemoryRegions(pid int) ([]*MemoryRegion, error) {
-	// Check cache first
-	if regions, exists := ef.regionCache[pid]; exists {
-		return regions, nil
-	}
-	
-	// Simulate memory region enumeration
-	regions := []*MemoryRegion{
-		{
-			BaseAddress: 0x00400000,
-			Size:        1048576, // 1MB
-			Protection:  "RX",
-			State:       "Commit",
-			Type:        "Image",
-		},
-		{
-			BaseAddress: 0x00500000,
-			Size:        65536, // 64KB
-			Protection:  "RW",
-			State:       "Commit",
-			Type:        "Private",
-		},
-		{
-			BaseAddress: 0x10000000,
-			Size:        4194304, // 4MB
-			Protection:  "RW",
-			State:       "Reserve",
-			Type:        "Heap",
-		},
-		{
-			BaseAddress: 0x7FF00000,
-			Size:        2097152, // 2MB
-			Protection:  "RX",
-			State:       "Commit",
-			Type:        "Stack",
-		},
-	}
-	
-	// Cache the results
-	ef.regionCache[pid] = regions
-	
-	return regions, nil
+	return queryRegions(pid)
 }
 
 // DetectProcessHollowing checks for process hollowing indicators
 func (ef *EnhancedForensics) DetectProcessHollowing(pid int) (bool, []string, error) {
-	process, exists := ef.processCache[pid]
-	if !exists {
-		// Try to get process info
-		processes, err := ef.EnumerateProcesses()
-		if err != nil {
-			return false, nil, err
-		}
-		for _, p := range processes {
-			if p.PID == pid {
-				process = p
-				break
-			}
-		}
-		if process == nil {
-			return false, nil, fmt.Errorf("process %d not found", pid)
-		}
+	process, err := ef.process(pid)
+	if err != nil {
+		return false, nil, err
 	}
 	
 	indicators := []string{}
@@ -284,10 +221,10 @@ func (ef *EnhancedForensics) DetectCodeInjection(pid int) ([]string, error) {
 func (ef *EnhancedForensics) ScanForMalware(pid int) ([]string, error) {
 	detections := []string{}
 	
-	// Simulate malware detection with heuristics
-	process, exists := ef.processCache[pid]
-	if !exists {
-		return nil, fmt.Errorf("process %d not found", pid)
+	// Detect malware with heuristics
+	process, err := ef.process(pid)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Check for suspicious process names
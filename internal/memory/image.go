@@ -0,0 +1,429 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// limeMagic starts each range header of a LiME image, "EMiL" on disk
+const limeMagic = 0x4C694D45
+
+// physRange is a run of physical memory held by an image
+type physRange struct {
+	Start      uint64
+	End        uint64 // Exclusive
+	FileOffset int64
+}
+
+// DumpImage is a physical memory image, raw or in the LiME format, opened
+// to be analysed offline. The analyses need the symbol table of the
+// kernel the image was taken from.
+type DumpImage struct {
+	ID      string
+	Path    string
+	Format  string // raw or lime
+	Symbols *Symbols
+
+	f      *os.File
+	ranges []physRange
+
+	mu     sync.Mutex
+	linux  *linuxKernel
+	kerErr error
+}
+
+// ImageOptions are the options of OpenImage
+type ImageOptions struct {
+	Symbols string // Path of a Volatility 3 symbol table
+}
+
+// ImageRange is a run of physical memory in an image
+type ImageRange struct {
+	Start uint64
+	End   uint64
+}
+
+// ImageInfo describes an image
+type ImageInfo struct {
+	ID      string
+	Path    string
+	Format  string
+	Size    uint64 // Bytes of physical memory the image holds
+	Ranges  []ImageRange
+	OS      string   // From the symbol table, if one was given
+	Banners []string // Linux version banners found in the image
+}
+
+// OpenImage opens a memory image and reads the symbol table given with it
+func OpenImage(path string, opts ImageOptions) (*DumpImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img := &DumpImage{Path: path, f: f}
+	if err := img.readLayout(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Symbols != "" {
+		if img.Symbols, err = LoadSymbols(opts.Symbols); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// readLayout reads the range headers of a LiME image, or takes the file
+// as raw memory starting at physical address 0
+func (img *DumpImage) readLayout() error {
+	st, err := img.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	var header [32]byte
+	if n, _ := img.f.ReadAt(header[:], 0); n < 4 || binary.LittleEndian.Uint32(header[:]) != limeMagic {
+		if size == 0 {
+			return fmt.Errorf("%s is empty", img.Path)
+		}
+		img.Format = "raw"
+		img.ranges = []physRange{{Start: 0, End: uint64(size)}}
+		return nil
+	}
+	img.Format = "lime"
+	// magic, version, start, inclusive end and 8 reserved bytes, then the
+	// bytes of the range
+	for off := int64(0); off < size; {
+		if _, err := img.f.ReadAt(header[:], off); err != nil {
+			return fmt.Errorf("%s: truncated LiME header at offset %d", img.Path, off)
+		}
+		if binary.LittleEndian.Uint32(header[0:]) != limeMagic {
+			return fmt.Errorf("%s: bad LiME header at offset %d", img.Path, off)
+		}
+		start := binary.LittleEndian.Uint64(header[8:])
+		end := binary.LittleEndian.Uint64(header[16:]) + 1
+		if end <= start || off+32+int64(end-start) > size {
+			return fmt.Errorf("%s: bad LiME range 0x%x-0x%x at offset %d", img.Path, start, end-1, off)
+		}
+		img.ranges = append(img.ranges, physRange{Start: start, End: end, FileOffset: off + 32})
+		off += 32 + int64(end-start)
+	}
+	sort.Slice(img.ranges, func(i, j int) bool { return img.ranges[i].Start < img.ranges[j].Start })
+	return nil
+}
+
+// Close closes the image file
+func (img *DumpImage) Close() error {
+	return img.f.Close()
+}
+
+// Size returns the bytes of physical memory the image holds
+func (img *DumpImage) Size() uint64 {
+	var size uint64
+	for _, r := range img.ranges {
+		size += r.End - r.Start
+	}
+	return size
+}
+
+// Info describes the image and the Linux banners in it, which name the
+// kernel whose symbol table the analyses need
+func (img *DumpImage) Info() *ImageInfo {
+	info := &ImageInfo{ID: img.ID, Path: img.Path, Format: img.Format, Size: img.Size()}
+	for _, r := range img.ranges {
+		info.Ranges = append(info.Ranges, ImageRange{Start: r.Start, End: r.End})
+	}
+	if img.Symbols != nil {
+		info.OS = img.Symbols.OS()
+	}
+	seen := make(map[string]bool)
+	img.scan([]byte("Linux version "), func(addr uint64) bool {
+		buf := make([]byte, 256)
+		n, _ := img.ReadPhysical(addr, buf)
+		banner := buf[:n]
+		if i := bytes.IndexAny(banner, "\n\x00"); i >= 0 {
+			banner = banner[:i]
+		}
+		// The banner is "Linux version <release> (<builder>) ..."
+		if bytes.IndexByte(banner, '(') > 0 && !seen[string(banner)] {
+			seen[string(banner)] = true
+			info.Banners = append(info.Banners, string(banner))
+		}
+		return len(info.Banners) < 8
+	})
+	return info
+}
+
+// ReadPhysical reads physical memory at an address. Reads stop at the end
+// of a range; memory the image does not hold is an error.
+func (img *DumpImage) ReadPhysical(addr uint64, buf []byte) (int, error) {
+	i := sort.Search(len(img.ranges), func(i int) bool { return img.ranges[i].End > addr })
+	if i == len(img.ranges) || img.ranges[i].Start > addr {
+		return 0, fmt.Errorf("physical address 0x%x is not in the image", addr)
+	}
+	r := img.ranges[i]
+	if n := r.End - addr; uint64(len(buf)) > n {
+		buf = buf[:n]
+	}
+	n, err := img.f.ReadAt(buf, r.FileOffset+int64(addr-r.Start))
+	if err == io.EOF && n == len(buf) {
+		err = nil
+	}
+	return n, err
+}
+
+// scanChunk is how much of an image is searched at once
+const scanChunk = 1 << 20
+
+// scan calls fn with the physical address of each match of a pattern
+// until it returns false
+func (img *DumpImage) scan(pattern []byte, fn func(addr uint64) bool) {
+	buf := make([]byte, scanChunk+len(pattern)-1)
+	for _, r := range img.ranges {
+		for start := r.Start; start < r.End; start += scanChunk {
+			n, _ := img.ReadPhysical(start, buf)
+			chunk := buf[:n]
+			for off := 0; ; {
+				i := bytes.Index(chunk[off:], pattern)
+				if i < 0 {
+					break
+				}
+				if !fn(start + uint64(off+i)) {
+					return
+				}
+				off += i + 1
+			}
+		}
+	}
+}
+
+// x86-64 page table entries
+const (
+	ptePresent  = 1
+	pteLarge    = 1 << 7
+	pteAddrMask = 0x000ffffffffff000
+)
+
+// translate turns a virtual address into a physical one by walking the
+// four-level x86-64 page tables at dtb
+func (img *DumpImage) translate(dtb, va uint64) (uint64, error) {
+	table := dtb & pteAddrMask
+	var entry [8]byte
+	for level, shift := 0, uint(39); level < 4; level, shift = level+1, shift-9 {
+		index := (va >> shift) & 0x1ff
+		if n, _ := img.ReadPhysical(table+index*8, entry[:]); n != 8 {
+			return 0, fmt.Errorf("page table of 0x%x is not in the image", va)
+		}
+		e := binary.LittleEndian.Uint64(entry[:])
+		if e&ptePresent == 0 {
+			return 0, fmt.Errorf("virtual address 0x%x is not mapped", va)
+		}
+		// A large page ends the walk at the PDPT (1GB) or page directory
+		// (2MB)
+		if (level == 1 || level == 2) && e&pteLarge != 0 {
+			mask := uint64(1)<<shift - 1
+			return (e & pteAddrMask &^ mask) | (va & mask), nil
+		}
+		table = e & pteAddrMask
+	}
+	return table | (va & 0xfff), nil
+}
+
+// readVirtual reads memory at a virtual address, page by page
+func (img *DumpImage) readVirtual(dtb, va uint64, buf []byte) error {
+	for len(buf) > 0 {
+		pa, err := img.translate(dtb, va)
+		if err != nil {
+			return err
+		}
+		n := 0x1000 - int(va&0xfff)
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if got, err := img.ReadPhysical(pa, buf[:n]); got != n {
+			if err == nil {
+				err = fmt.Errorf("short read at physical address 0x%x", pa)
+			}
+			return err
+		}
+		buf = buf[n:]
+		va += uint64(n)
+	}
+	return nil
+}
+
+// ImageProcess is a process found in an image
+type ImageProcess struct {
+	PID          int
+	ParentPID    int
+	Name         string
+	UID          int
+	Threads      int
+	KernelThread bool
+	CreateTime   time.Time // Windows only
+	Exited       bool      // Windows only: the process has an exit time
+	Offset       uint64    // Address of the process structure, virtual on Linux and physical on Windows
+}
+
+// ImageModule is a kernel module loaded when an image was taken
+type ImageModule struct {
+	Name   string
+	Base   uint64
+	Size   uint64
+	Offset uint64
+}
+
+// ImageConnection is an IPv4 or IPv6 socket a process held open
+type ImageConnection struct {
+	PID        int
+	Process    string
+	FD         int
+	Protocol   string // tcp, udp or raw
+	Family     string // ipv4 or ipv6
+	LocalAddr  string
+	LocalPort  int
+	RemoteAddr string
+	RemotePort int
+	State      string // TCP only
+}
+
+// ImageHandle is a file descriptor a process held open. Suspicious, if
+// set, tells why it stands out.
+type ImageHandle struct {
+	PID        int
+	Process    string
+	FD         int
+	Type       string // file or socket
+	Path       string
+	Suspicious string
+}
+
+// needSymbols returns the symbol table of an image, and an error when the
+// image was opened without one or with one for another operating system
+func (img *DumpImage) needSymbols(os string) error {
+	if img.Symbols == nil {
+		return fmt.Errorf("the image was opened without a symbol table; give the Volatility 3 symbol table of its kernel")
+	}
+	if got := img.Symbols.OS(); got != os {
+		return fmt.Errorf("this analysis needs a %s symbol table, %s is for %s", os, img.Symbols.Path, got)
+	}
+	return nil
+}
+
+// Processes lists the processes in an image: on Linux those on the task
+// list, on Windows those whose process structures a pool scan finds,
+// which includes processes that have exited or were unlinked
+func (img *DumpImage) Processes() ([]*ImageProcess, error) {
+	if img.Symbols != nil && img.Symbols.OS() == "windows" {
+		return img.scanWindowsProcesses()
+	}
+	k, err := img.linuxKernel()
+	if err != nil {
+		return nil, err
+	}
+	return k.processes()
+}
+
+// Modules lists the kernel modules loaded when a Linux image was taken
+func (img *DumpImage) Modules() ([]*ImageModule, error) {
+	k, err := img.linuxKernel()
+	if err != nil {
+		return nil, err
+	}
+	return k.modules()
+}
+
+// Connections lists the sockets the processes of a Linux image held open
+func (img *DumpImage) Connections() ([]*ImageConnection, error) {
+	k, err := img.linuxKernel()
+	if err != nil {
+		return nil, err
+	}
+	var conns []*ImageConnection
+	err = k.eachFile(func(p *ImageProcess, fd int, file uint64) {
+		if c := k.connection(file); c != nil {
+			c.PID, c.Process, c.FD = p.PID, p.Name, fd
+			conns = append(conns, c)
+		}
+	})
+	return conns, err
+}
+
+// Handles lists the files and sockets the processes of a Linux image held
+// open, only those that look suspicious unless all is set
+func (img *DumpImage) Handles(all bool) ([]*ImageHandle, error) {
+	k, err := img.linuxKernel()
+	if err != nil {
+		return nil, err
+	}
+	var handles []*ImageHandle
+	err = k.eachFile(func(p *ImageProcess, fd int, file uint64) {
+		h := k.handle(file)
+		h.PID, h.Process, h.FD = p.PID, p.Name, fd
+		if all || h.Suspicious != "" {
+			handles = append(handles, h)
+		}
+	})
+	return handles, err
+}
+
+// linuxKernel finds the kernel of a Linux image once
+func (img *DumpImage) linuxKernel() (*linuxKernel, error) {
+	if err := img.needSymbols("linux"); err != nil {
+		return nil, err
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if img.linux == nil && img.kerErr == nil {
+		img.linux, img.kerErr = findLinuxKernel(img)
+	}
+	return img.linux, img.kerErr
+}
+
+// OpenImage opens a memory image and keeps it under an ID until
+// CloseImage
+func (ef *EnhancedForensics) OpenImage(path string, opts ImageOptions) (*DumpImage, error) {
+	img, err := OpenImage(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	if ef.images == nil {
+		ef.images = make(map[string]*DumpImage)
+	}
+	ef.nextImage++
+	img.ID = fmt.Sprintf("image_%d", ef.nextImage)
+	ef.images[img.ID] = img
+	return img, nil
+}
+
+// Image returns an open image by ID
+func (ef *EnhancedForensics) Image(id string) (*DumpImage, error) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	img, ok := ef.images[id]
+	if !ok {
+		return nil, fmt.Errorf("image '%s' not found", id)
+	}
+	return img, nil
+}
+
+// CloseImage closes an image and forgets its ID
+func (ef *EnhancedForensics) CloseImage(id string) error {
+	img, err := ef.Image(id)
+	if err != nil {
+		return err
+	}
+	ef.mu.Lock()
+	delete(ef.images, id)
+	ef.mu.Unlock()
+	return img.Close()
+}
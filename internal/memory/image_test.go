@@ -0,0 +1,603 @@
+package memory
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test kernel is linked at kernelLink, loaded at physical kernelPhys
+// and shifted by kernelShift. Its heap is a 2MB direct-mapped page.
+const (
+	kernelLink  = 0xffffffff81000000
+	kernelPhys  = 0x100000
+	kernelShift = 0x600000
+	heapPhys    = 0x400000
+	directMap   = 0xffff888000000000
+)
+
+// Offsets of the test kernel's symbols from kernelLink
+const (
+	symBanner  = 0x100
+	symInit    = 0x2000
+	symModules = 0x3000
+	symSockOps = 0x3100
+	symPGT     = 0x10000
+)
+
+const testBanner = "Linux version 6.1.0-test (builder@example) (gcc 12.2.0) #1 SMP\n"
+
+// testMachine builds physical memory with x86-64 page tables
+type testMachine struct {
+	mem       []byte
+	dtb       uint64
+	nextTable uint64
+	nextHeap  uint64
+	maps      [][3]uint64 // va, pa, size
+}
+
+func newTestMachine(dtb uint64) *testMachine {
+	return &testMachine{mem: make([]byte, 8<<20), dtb: dtb, nextTable: 0x200000, nextHeap: directMap + heapPhys}
+}
+
+func (m *testMachine) put64(pa, v uint64) {
+	binary.LittleEndian.PutUint64(m.mem[pa:], v)
+}
+
+// next returns the table an entry points to, adding it if absent
+func (m *testMachine) next(table, index uint64) uint64 {
+	e := binary.LittleEndian.Uint64(m.mem[table+index*8:])
+	if e&ptePresent == 0 {
+		e = m.nextTable | ptePresent
+		m.nextTable += 0x1000
+		m.put64(table+index*8, e)
+	}
+	return e & pteAddrMask
+}
+
+func (m *testMachine) mapPage(va, pa uint64) {
+	pdpt := m.next(m.dtb, (va>>39)&0x1ff)
+	pd := m.next(pdpt, (va>>30)&0x1ff)
+	pt := m.next(pd, (va>>21)&0x1ff)
+	m.put64(pt+((va>>12)&0x1ff)*8, pa|ptePresent)
+	m.maps = append(m.maps, [3]uint64{va, pa, 0x1000})
+}
+
+func (m *testMachine) mapLarge(va, pa uint64) {
+	pdpt := m.next(m.dtb, (va>>39)&0x1ff)
+	pd := m.next(pdpt, (va>>30)&0x1ff)
+	m.put64(pd+((va>>21)&0x1ff)*8, pa|ptePresent|pteLarge)
+	m.maps = append(m.maps, [3]uint64{va, pa, 2 << 20})
+}
+
+func (m *testMachine) pa(va uint64) uint64 {
+	for _, r := range m.maps {
+		if va >= r[0] && va < r[0]+r[2] {
+			return r[1] + va - r[0]
+		}
+	}
+	panic("unmapped test address")
+}
+
+func (m *testMachine) write(va uint64, data []byte) {
+	copy(m.mem[m.pa(va):], data)
+}
+
+func (m *testMachine) u64(va, v uint64) {
+	m.put64(m.pa(va), v)
+}
+
+func (m *testMachine) u32(va uint64, v uint32) {
+	binary.LittleEndian.PutUint32(m.mem[m.pa(va):], v)
+}
+
+func (m *testMachine) u16(va uint64, v uint16) {
+	binary.LittleEndian.PutUint16(m.mem[m.pa(va):], v)
+}
+
+func (m *testMachine) alloc(size uint64) uint64 {
+	va := m.nextHeap
+	m.nextHeap += (size + 15) &^ 15
+	return va
+}
+
+func (m *testMachine) str(s string) uint64 {
+	va := m.alloc(uint64(len(s)) + 1)
+	m.write(va, []byte(s))
+	return va
+}
+
+// isf types of the test symbol tables
+func base(name string) map[string]interface{} {
+	return map[string]interface{}{"kind": "base", "name": name}
+}
+
+func named(kind, name string) map[string]interface{} {
+	return map[string]interface{}{"kind": kind, "name": name}
+}
+
+func pointer() map[string]interface{} {
+	return map[string]interface{}{"kind": "pointer", "subtype": base("void")}
+}
+
+func array(count int, subtype map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"kind": "array", "count": count, "subtype": subtype}
+}
+
+func fields(size int, kind string, f map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for name, v := range f {
+		pair := v.([]interface{})
+		field := map[string]interface{}{"offset": pair[0], "type": pair[1]}
+		if strings.HasPrefix(name, "unnamed") {
+			field["anonymous"] = true
+		}
+		out[name] = field
+	}
+	return map[string]interface{}{"kind": kind, "size": size, "fields": out}
+}
+
+func f(offset int, t map[string]interface{}) []interface{} {
+	return []interface{}{offset, t}
+}
+
+func writeSymbols(t *testing.T, isf map[string]interface{}) string {
+	data, err := json.Marshal(isf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "symbols.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var testBaseTypes = map[string]interface{}{
+	"pointer":            map[string]interface{}{"kind": "int", "size": 8},
+	"int":                map[string]interface{}{"kind": "int", "size": 4, "signed": true},
+	"unsigned int":       map[string]interface{}{"kind": "int", "size": 4},
+	"unsigned short":     map[string]interface{}{"kind": "int", "size": 2},
+	"unsigned char":      map[string]interface{}{"kind": "char", "size": 1},
+	"char":               map[string]interface{}{"kind": "char", "size": 1},
+	"long unsigned int":  map[string]interface{}{"kind": "int", "size": 8},
+	"long long unsigned": map[string]interface{}{"kind": "int", "size": 8},
+	"void":               map[string]interface{}{"kind": "void", "size": 0},
+}
+
+func linuxSymbols(t *testing.T) string {
+	sym := func(off uint64) map[string]interface{} {
+		return map[string]interface{}{"address": kernelLink + off}
+	}
+	banner := sym(symBanner)
+	banner["constant_data"] = base64.StdEncoding.EncodeToString([]byte(testBanner + "\x00"))
+	return writeSymbols(t, map[string]interface{}{
+		"metadata":   map[string]interface{}{"format": "6.2.0"},
+		"base_types": testBaseTypes,
+		"enums":      map[string]interface{}{},
+		"symbols": map[string]interface{}{
+			"linux_banner": banner, "init_top_pgt": sym(symPGT), "init_task": sym(symInit),
+			"modules": sym(symModules), "socket_file_ops": sym(symSockOps),
+		},
+		"user_types": map[string]interface{}{
+			"list_head": fields(16, "struct", map[string]interface{}{"next": f(0, pointer()), "prev": f(8, pointer())}),
+			"task_struct": fields(0x100, "struct", map[string]interface{}{
+				"tasks": f(0x10, named("struct", "list_head")), "pid": f(0x20, base("int")), "tgid": f(0x24, base("int")),
+				"real_parent": f(0x28, pointer()), "mm": f(0x30, pointer()), "cred": f(0x38, pointer()),
+				"comm": f(0x40, array(16, base("char"))), "files": f(0x50, pointer()), "signal": f(0x58, pointer()),
+			}),
+			"kuid_t":        fields(4, "struct", map[string]interface{}{"val": f(0, base("unsigned int"))}),
+			"cred":          fields(0x20, "struct", map[string]interface{}{"uid": f(4, named("struct", "kuid_t"))}),
+			"signal_struct": fields(0x20, "struct", map[string]interface{}{"nr_threads": f(8, base("int"))}),
+			"module_memory": fields(0x10, "struct", map[string]interface{}{"base": f(0, pointer()), "size": f(8, base("unsigned int"))}),
+			"module": fields(0x100, "struct", map[string]interface{}{
+				"list": f(8, named("struct", "list_head")), "name": f(0x18, array(56, base("char"))),
+				"mem": f(0x50, array(7, named("struct", "module_memory"))),
+			}),
+			"files_struct": fields(0x40, "struct", map[string]interface{}{"fdt": f(0x20, pointer())}),
+			"fdtable":      fields(0x10, "struct", map[string]interface{}{"max_fds": f(0, base("unsigned int")), "fd": f(8, pointer())}),
+			"path":         fields(0x10, "struct", map[string]interface{}{"mnt": f(0, pointer()), "dentry": f(8, pointer())}),
+			"file": fields(0x40, "struct", map[string]interface{}{
+				"f_path": f(0x10, named("struct", "path")), "f_inode": f(0x20, pointer()),
+				"f_op": f(0x28, pointer()), "private_data": f(0x30, pointer()),
+			}),
+			"hlist_bl_node": fields(0x10, "struct", map[string]interface{}{"next": f(0, pointer()), "pprev": f(8, pointer())}),
+			"unnamed_qstr":  fields(8, "union", map[string]interface{}{"hash_len": f(0, base("long long unsigned"))}),
+			"qstr":          fields(0x10, "struct", map[string]interface{}{"unnamed_0": f(0, named("union", "unnamed_qstr")), "name": f(8, pointer())}),
+			"dentry": fields(0x40, "struct", map[string]interface{}{
+				"d_hash": f(8, named("struct", "hlist_bl_node")), "d_parent": f(0x18, pointer()), "d_name": f(0x20, named("struct", "qstr")),
+			}),
+			"vfsmount": fields(0x10, "struct", map[string]interface{}{"mnt_root": f(0, pointer())}),
+			"mount": fields(0x40, "struct", map[string]interface{}{
+				"mnt_parent": f(0x10, pointer()), "mnt_mountpoint": f(0x18, pointer()), "mnt": f(0x20, named("struct", "vfsmount")),
+			}),
+			"inode":           fields(0x50, "struct", map[string]interface{}{"i_ino": f(0x40, base("long unsigned int"))}),
+			"socket":          fields(0x20, "struct", map[string]interface{}{"sk": f(0x18, pointer())}),
+			"unnamed_addrs":   fields(8, "struct", map[string]interface{}{"skc_daddr": f(0, base("unsigned int")), "skc_rcv_saddr": f(4, base("unsigned int"))}),
+			"unnamed_addrset": fields(8, "union", map[string]interface{}{"skc_addrpair": f(0, base("long long unsigned")), "unnamed_1": f(0, named("struct", "unnamed_addrs"))}),
+			"unnamed_ports":   fields(4, "struct", map[string]interface{}{"skc_dport": f(0, base("unsigned short")), "skc_num": f(2, base("unsigned short"))}),
+			"in6_addr":        fields(16, "struct", map[string]interface{}{}),
+			"sock_common": fields(0x60, "struct", map[string]interface{}{
+				"unnamed_2": f(0, named("union", "unnamed_addrset")), "unnamed_3": f(0xc, named("struct", "unnamed_ports")),
+				"skc_family": f(0x10, base("unsigned short")), "skc_state": f(0x12, base("unsigned char")),
+				"skc_v6_daddr": f(0x38, named("struct", "in6_addr")), "skc_v6_rcv_saddr": f(0x48, named("struct", "in6_addr")),
+			}),
+			"sock": fields(0xa0, "struct", map[string]interface{}{
+				"__sk_common": f(0, named("struct", "sock_common")),
+				"sk_protocol": f(0x90, map[string]interface{}{"kind": "bitfield", "bit_position": 8, "bit_length": 8, "type": base("unsigned int")}),
+				"sk_type":     f(0x94, base("unsigned short")),
+			}),
+		},
+	})
+}
+
+// linuxImage builds a Linux machine with four processes on the task
+// list, a tmpfs mounted on /tmp and two modules
+func linuxImage() *testMachine {
+	m := newTestMachine(kernelPhys + symPGT)
+	for off := uint64(0); off < 0x80000; off += 0x1000 {
+		m.mapPage(kernelLink+kernelShift+off, kernelPhys+off)
+	}
+	m.mapLarge(directMap+heapPhys, heapPhys)
+	k := func(off uint64) uint64 { return kernelLink + kernelShift + off }
+	m.write(k(symBanner), []byte(testBanner+"\x00"))
+	// A banner of another kernel left in low memory
+	copy(m.mem[0x1000:], "Linux version 5.10.0-old (someone@example) (gcc 10) #1\n")
+
+	// Mounts: the root file system, with a tmpfs on /tmp
+	dentry := func(name string, parent uint64, hashed bool) uint64 {
+		d := m.alloc(0x40)
+		if parent == 0 {
+			parent = d
+		}
+		m.u64(d+0x18, parent)
+		m.u64(d+0x28, m.str(name))
+		if hashed {
+			m.u64(d+0x10, d+8)
+		}
+		return d
+	}
+	mount := func(root, parent, mountpoint uint64) uint64 {
+		mnt := m.alloc(0x40)
+		if parent == 0 {
+			parent = mnt
+		}
+		m.u64(mnt+0x10, parent)
+		m.u64(mnt+0x18, mountpoint)
+		m.u64(mnt+0x20, root)
+		return mnt
+	}
+	rootD := dentry("/", 0, true)
+	rootM := mount(rootD, 0, 0)
+	devNull := dentry("null", dentry("dev", rootD, true), true)
+	tmpD := dentry("tmp", rootD, true)
+	tmpRoot := dentry("/", 0, true)
+	tmpM := mount(tmpRoot, rootM, tmpD)
+	payload := dentry("payload", dentry(".x", tmpRoot, true), false)
+	shmM := mount(dentry("/", 0, true), 0, 0)
+
+	ino := uint64(1000)
+	file := func(mnt, d, private uint64, ops uint64) uint64 {
+		fl := m.alloc(0x40)
+		inode := m.alloc(0x50)
+		ino++
+		m.u64(inode+0x40, ino)
+		m.u64(fl+0x10, mnt+0x20)
+		m.u64(fl+0x18, d)
+		m.u64(fl+0x20, inode)
+		m.u64(fl+0x28, ops)
+		m.u64(fl+0x30, private)
+		return fl
+	}
+	sock := func(family uint16, proto, typ uint16, state byte, local, remote []byte, lport, rport uint16) uint64 {
+		sk := m.alloc(0xa0)
+		if family == afInet {
+			m.write(sk+4, local)
+			m.write(sk, remote)
+		} else {
+			m.write(sk+0x48, local)
+			m.write(sk+0x38, remote)
+		}
+		m.u16(sk+0xc, rport<<8|rport>>8)
+		m.u16(sk+0xe, lport)
+		m.u16(sk+0x10, family)
+		m.mem[m.pa(sk+0x12)] = state
+		m.u32(sk+0x90, uint32(proto)<<8|0x5)
+		m.u16(sk+0x94, typ)
+		socket := m.alloc(0x20)
+		m.u64(socket+0x18, sk)
+		return file(shmM, dentry("", 0, false), socket, k(symSockOps))
+	}
+	files := func(fds ...uint64) uint64 {
+		table := m.alloc(uint64(len(fds)) * 8)
+		for i, fl := range fds {
+			m.u64(table+uint64(i)*8, fl)
+		}
+		fdt := m.alloc(0x10)
+		m.u32(fdt, uint32(len(fds)))
+		m.u64(fdt+8, table)
+		fs := m.alloc(0x40)
+		m.u64(fs+0x20, fdt)
+		return fs
+	}
+
+	// Tasks, linked on init_task's list
+	initTask := k(symInit)
+	var tasks []uint64
+	task := func(pid int, name string, parent uint64, kernel bool, uid uint32, fs uint64) uint64 {
+		t := m.alloc(0x100)
+		m.u32(t+0x20, uint32(pid))
+		m.u32(t+0x24, uint32(pid))
+		m.u64(t+0x28, parent)
+		if !kernel {
+			m.u64(t+0x30, m.alloc(8))
+		}
+		cred := m.alloc(0x20)
+		m.u32(cred+4, uid)
+		m.u64(t+0x38, cred)
+		m.write(t+0x40, []byte(name))
+		m.u64(t+0x50, fs)
+		signal := m.alloc(0x20)
+		m.u32(signal+8, 2)
+		m.u64(t+0x58, signal)
+		tasks = append(tasks, t)
+		return t
+	}
+	systemd := task(1, "systemd", initTask, false, 0, 0)
+	task(2, "kthreadd", initTask, true, 0, 0)
+	task(812, "sshd", systemd, false, 0, files(
+		file(rootM, devNull, 0, 0),
+		0,
+		0,
+		sock(afInet, 6, 1, 1, []byte{10, 0, 0, 5}, []byte{203, 0, 113, 9}, 22, 51514),
+	))
+	task(4242, "beacon", systemd, false, 1000, files(
+		file(tmpM, payload, 0, 0),
+		file(shmM, dentry("memfd:stage2", 0, false), 0, 0),
+		file(shmM, dentry("", 0, false), 0, 0),
+		0,
+		sock(afInet6, 17, 2, 7, []byte{15: 1}, make([]byte, 16), 53, 0),
+		sock(afPacket, 0, sockRaw, 0, make([]byte, 4), make([]byte, 4), 0, 0),
+	))
+	links := append([]uint64{initTask}, tasks...)
+	for i, t := range links {
+		m.u64(t+0x10, links[(i+1)%len(links)]+0x10)
+	}
+
+	// Modules
+	head := k(symModules)
+	prev := head
+	for i, name := range []string{"nf_tables", "diamorphine"} {
+		mod := m.alloc(0x100)
+		m.write(mod+0x18, []byte(name))
+		m.u64(mod+0x50, 0xffffffffc0000000+uint64(i)<<20)
+		m.u32(mod+0x58, 0x4000)
+		m.u64(prev, mod+8)
+		prev = mod + 8
+	}
+	m.u64(prev, head)
+	return m
+}
+
+// writeLime writes memory as a LiME image of two ranges, leaving a hole
+// where the legacy VGA and BIOS area would be
+func writeLime(t *testing.T, mem []byte) string {
+	var out []byte
+	for _, r := range [][2]uint64{{0, 0xa0000}, {0x100000, uint64(len(mem))}} {
+		header := make([]byte, 32)
+		binary.LittleEndian.PutUint32(header, limeMagic)
+		binary.LittleEndian.PutUint32(header[4:], 1)
+		binary.LittleEndian.PutUint64(header[8:], r[0])
+		binary.LittleEndian.PutUint64(header[16:], r[1]-1)
+		out = append(append(out, header...), mem[r[0]:r[1]]...)
+	}
+	path := filepath.Join(t.TempDir(), "mem.lime")
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLinuxImage(t *testing.T) {
+	m := linuxImage()
+	img, err := OpenImage(writeLime(t, m.mem), ImageOptions{Symbols: linuxSymbols(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+
+	info := img.Info()
+	if info.Format != "lime" || info.OS != "linux" || len(info.Ranges) != 2 || info.Size != uint64(len(m.mem))-0x60000 {
+		t.Errorf("info = %+v", info)
+	}
+	if len(info.Banners) != 2 || info.Banners[1] != strings.TrimSpace(testBanner) {
+		t.Errorf("banners = %q", info.Banners)
+	}
+
+	processes, err := img.Processes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range processes {
+		got = append(got, strings.Join([]string{p.Name, strconv.Itoa(p.PID), strconv.Itoa(p.ParentPID), strconv.Itoa(p.UID), strconv.Itoa(p.Threads)}, " "))
+		if p.Name == "kthreadd" != p.KernelThread {
+			t.Errorf("%s: kernel thread %v", p.Name, p.KernelThread)
+		}
+	}
+	if want := "systemd 1 0 0 2,kthreadd 2 0 0 2,sshd 812 1 0 2,beacon 4242 1 1000 2"; strings.Join(got, ",") != want {
+		t.Errorf("processes = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	modules, err := img.Modules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 2 || modules[1].Name != "diamorphine" || modules[1].Base != 0xffffffffc0100000 || modules[1].Size != 0x4000 {
+		t.Errorf("modules = %+v", modules)
+	}
+
+	conns, err := img.Connections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, c := range conns {
+		got = append(got, strings.Join([]string{c.Process, strconv.Itoa(c.FD), c.Protocol, c.Family, c.LocalAddr, strconv.Itoa(c.LocalPort), c.RemoteAddr, strconv.Itoa(c.RemotePort), c.State}, " "))
+	}
+	if want := "sshd 3 tcp ipv4 10.0.0.5 22 203.0.113.9 51514 ESTABLISHED,beacon 4 udp ipv6 ::1 53 :: 0 "; strings.Join(got, ",") != want {
+		t.Errorf("connections = %q, want %q", strings.Join(got, ","), want)
+	}
+
+	handles, err := img.Handles(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, h := range handles {
+		got = append(got, strconv.Itoa(h.PID)+" "+strconv.Itoa(h.FD)+" "+h.Type+" "+h.Path)
+	}
+	if want := "812 0 file /dev/null,812 3 socket socket:[1002],4242 0 file /tmp/.x/payload (deleted)," +
+		"4242 1 file /memfd:stage2,4242 2 file pipe:[1005],4242 4 socket socket:[1006],4242 5 socket socket:[1007]"; strings.Join(got, ",") != want {
+		t.Errorf("handles = %s\nwant %s", strings.Join(got, ","), want)
+	}
+	suspicious, err := img.Handles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, h := range suspicious {
+		got = append(got, strconv.Itoa(h.FD)+" "+h.Suspicious)
+	}
+	if want := "0 deleted file still open,1 memory-backed file (memfd), which can run code that was never on disk," +
+		"5 raw or packet socket, which can capture or forge traffic"; strings.Join(got, ",") != want {
+		t.Errorf("suspicious handles = %s", strings.Join(got, ","))
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	m := linuxImage()
+	path := filepath.Join(t.TempDir(), "mem.raw")
+	if err := os.WriteFile(path, m.mem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, err := OpenImage(path, ImageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	if info := img.Info(); info.Format != "raw" || info.Size != uint64(len(m.mem)) || info.OS != "" {
+		t.Errorf("info = %+v", info)
+	}
+	if _, err := img.Processes(); err == nil || !strings.Contains(err.Error(), "without a symbol table") {
+		t.Errorf("processes without symbols: %v", err)
+	}
+
+	// Symbols of a kernel whose banner is not in the image
+	isf := linuxSymbols(t)
+	data, _ := os.ReadFile(isf)
+	other := strings.Replace(string(data), base64.StdEncoding.EncodeToString([]byte(testBanner+"\x00")),
+		base64.StdEncoding.EncodeToString([]byte("Linux version 9.9.9 (nobody)\x00")), 1)
+	os.WriteFile(isf, []byte(other), 0o644)
+	img, err = OpenImage(path, ImageOptions{Symbols: isf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	if _, err := img.Modules(); err == nil || !strings.Contains(err.Error(), "symbol table is for another kernel") {
+		t.Errorf("modules with another kernel's symbols: %v", err)
+	}
+
+	if _, err := OpenImage(path, ImageOptions{Symbols: path + ".json.xz"}); err == nil || !strings.Contains(err.Error(), "xz") {
+		t.Errorf("xz symbols: %v", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.lime")
+	os.WriteFile(bad, []byte{0x45, 0x4d, 0x69, 0x4c, 1, 0, 0, 0}, 0o644)
+	if _, err := OpenImage(bad, ImageOptions{}); err == nil || !strings.Contains(err.Error(), "truncated LiME header") {
+		t.Errorf("truncated LiME: %v", err)
+	}
+}
+
+func windowsSymbols(t *testing.T) string {
+	return writeSymbols(t, map[string]interface{}{
+		"metadata":   map[string]interface{}{"format": "6.2.0"},
+		"base_types": testBaseTypes,
+		"enums":      map[string]interface{}{},
+		"symbols":    map[string]interface{}{},
+		"user_types": map[string]interface{}{
+			"_POOL_HEADER": fields(0x10, "struct", map[string]interface{}{
+				"BlockSize": f(2, map[string]interface{}{"kind": "bitfield", "bit_position": 0, "bit_length": 8, "type": base("unsigned short")}),
+				"PoolTag":   f(4, base("unsigned int")),
+			}),
+			"_OBJECT_HEADER":     fields(0x38, "struct", map[string]interface{}{"Body": f(0x30, base("unsigned char"))}),
+			"unnamed_dispatch":   fields(4, "struct", map[string]interface{}{"Type": f(0, base("unsigned char"))}),
+			"_DISPATCHER_HEADER": fields(0x18, "struct", map[string]interface{}{"unnamed_0": f(0, named("struct", "unnamed_dispatch"))}),
+			"_KPROCESS":          fields(0x38, "struct", map[string]interface{}{"Header": f(0, named("struct", "_DISPATCHER_HEADER")), "DirectoryTableBase": f(0x28, base("long long unsigned"))}),
+			"_LARGE_INTEGER":     fields(8, "union", map[string]interface{}{"QuadPart": f(0, base("long long unsigned"))}),
+			"_EPROCESS": fields(0x100, "struct", map[string]interface{}{
+				"Pcb": f(0, named("struct", "_KPROCESS")), "CreateTime": f(0x40, named("union", "_LARGE_INTEGER")),
+				"ExitTime": f(0x48, named("union", "_LARGE_INTEGER")), "UniqueProcessId": f(0x50, pointer()),
+				"InheritedFromUniqueProcessId": f(0x58, pointer()), "ActiveThreads": f(0x60, base("unsigned int")),
+				"ImageFileName": f(0x70, array(15, base("unsigned char"))),
+			}),
+		},
+	})
+}
+
+func TestWindowsProcessScan(t *testing.T) {
+	mem := make([]byte, 1<<20)
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	filetime := func(t time.Time) uint64 { return uint64(t.UnixNano()/100) + filetimeUnixEpoch }
+	process := func(pool uint64, optional uint64, pid, ppid uint64, name string, exited bool) {
+		block := 0x10 + optional + 0x30 + 0x100
+		binary.LittleEndian.PutUint16(mem[pool+2:], uint16((block+15)/16))
+		copy(mem[pool+4:], "Proc")
+		ep := pool + 0x10 + optional + 0x30
+		mem[ep] = processObject
+		binary.LittleEndian.PutUint64(mem[ep+0x28:], 0x1aa000)
+		binary.LittleEndian.PutUint64(mem[ep+0x40:], filetime(created))
+		if exited {
+			binary.LittleEndian.PutUint64(mem[ep+0x48:], filetime(created.Add(time.Hour)))
+		}
+		binary.LittleEndian.PutUint64(mem[ep+0x50:], pid)
+		binary.LittleEndian.PutUint64(mem[ep+0x58:], ppid)
+		binary.LittleEndian.PutUint32(mem[ep+0x60:], 3)
+		copy(mem[ep+0x70:], name)
+	}
+	process(0x5000, 0x20, 4, 0, "System", false)
+	process(0x8010, 0x40, 2108, 4, "powershell.exe", true)
+	// A freed allocation whose name was overwritten, and a tag in data
+	process(0xa000, 0, 3000, 4, "\x01\x02bad", false)
+	copy(mem[0xc003:], "Proc")
+
+	path := filepath.Join(t.TempDir(), "win.raw")
+	if err := os.WriteFile(path, mem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, err := OpenImage(path, ImageOptions{Symbols: windowsSymbols(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	processes, err := img.Processes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 2 {
+		t.Fatalf("processes = %+v", processes)
+	}
+	ps := processes[1]
+	if ps.Name != "powershell.exe" || ps.PID != 2108 || ps.ParentPID != 4 || !ps.Exited || ps.Threads != 3 ||
+		!ps.CreateTime.Equal(created) || ps.Offset != 0x8010+0x10+0x40+0x30 {
+		t.Errorf("process = %+v", ps)
+	}
+	if _, err := img.Modules(); err == nil || !strings.Contains(err.Error(), "needs a linux symbol table") {
+		t.Errorf("modules of a Windows image: %v", err)
+	}
+}
@@ -149,6 +149,7 @@ func (m *IntegratedMemoryModule) GetRegions(pid int) interface{} {
 			"protection":   r.Protection,
 			"state":        r.State,
 			"type":         r.Type,
+			"path":         r.Path,
 		}
 	}
 	
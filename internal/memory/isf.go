@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// isfType is a type reference in a symbol table: a base type, a pointer,
+// an array, a struct, union or enum by name, or a bitfield of a base type
+type isfType struct {
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	Count       int      `json:"count"`
+	Subtype     *isfType `json:"subtype"`
+	Type        *isfType `json:"type"`
+	BitLength   int      `json:"bit_length"`
+	BitPosition int      `json:"bit_position"`
+}
+
+type isfField struct {
+	Offset    uint64  `json:"offset"`
+	Anonymous bool    `json:"anonymous"`
+	Type      isfType `json:"type"`
+}
+
+type isfUserType struct {
+	Kind   string              `json:"kind"`
+	Size   uint64              `json:"size"`
+	Fields map[string]isfField `json:"fields"`
+}
+
+type isfSymbol struct {
+	Address      uint64 `json:"address"`
+	ConstantData string `json:"constant_data"`
+}
+
+type isfSized struct {
+	Size uint64 `json:"size"`
+}
+
+// Symbols is a symbol table in the Intermediate Symbol Format of
+// Volatility 3, which gives the layout of the kernel structures and the
+// addresses of the kernel symbols of one build of an operating system
+type Symbols struct {
+	Path      string
+	BaseTypes map[string]isfSized    `json:"base_types"`
+	UserTypes map[string]isfUserType `json:"user_types"`
+	Enums     map[string]isfSized    `json:"enums"`
+	Symbols   map[string]isfSymbol   `json:"symbols"`
+}
+
+// LoadSymbols reads a symbol table from a .json or .json.gz file
+func LoadSymbols(path string) (*Symbols, error) {
+	if strings.HasSuffix(path, ".xz") {
+		return nil, fmt.Errorf("symbol table %s is xz compressed; decompress it or recompress it with gzip", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("symbol table %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	s := &Symbols{Path: path}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("symbol table %s: %v", path, err)
+	}
+	if len(s.UserTypes) == 0 {
+		return nil, fmt.Errorf("symbol table %s has no types", path)
+	}
+	return s, nil
+}
+
+// OS returns linux or windows by the kernel structures the table describes
+func (s *Symbols) OS() string {
+	switch {
+	case s.hasType("task_struct"):
+		return "linux"
+	case s.hasType("_EPROCESS"):
+		return "windows"
+	}
+	return ""
+}
+
+func (s *Symbols) hasType(name string) bool {
+	_, ok := s.UserTypes[name]
+	return ok
+}
+
+// symbol returns the address of a symbol as linked
+func (s *Symbols) symbol(name string) (uint64, bool) {
+	sym, ok := s.Symbols[name]
+	return sym.Address, ok
+}
+
+// constantData returns the bytes a symbol was linked with, such as the
+// version banner, if the table holds them
+func (s *Symbols) constantData(name string) []byte {
+	data, err := base64.StdEncoding.DecodeString(s.Symbols[name].ConstantData)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// sizeOf returns the size in bytes of a type
+func (s *Symbols) sizeOf(t isfType) uint64 {
+	switch t.Kind {
+	case "base":
+		return s.BaseTypes[t.Name].Size
+	case "pointer", "function":
+		if p, ok := s.BaseTypes["pointer"]; ok {
+			return p.Size
+		}
+		return 8
+	case "array":
+		if t.Subtype == nil {
+			return 0
+		}
+		return uint64(t.Count) * s.sizeOf(*t.Subtype)
+	case "enum":
+		return s.Enums[t.Name].Size
+	case "bitfield":
+		if t.Type == nil {
+			return 0
+		}
+		return s.sizeOf(*t.Type)
+	}
+	return s.UserTypes[t.Name].Size
+}
+
+// structSize returns the size of a struct or union
+func (s *Symbols) structSize(name string) (uint64, error) {
+	t, ok := s.UserTypes[name]
+	if !ok {
+		return 0, fmt.Errorf("symbol table %s has no type %s", s.Path, name)
+	}
+	return t.Size, nil
+}
+
+// field finds a field of a struct by a dotted path such as
+// "__sk_common.skc_family", looking through anonymous members the way C
+// does and into the first element of arrays. It returns the offset of the
+// field from the start of the struct and its type.
+func (s *Symbols) field(structName, path string) (uint64, isfType, error) {
+	name := structName
+	var offset uint64
+	var t isfType
+	for i, part := range strings.Split(path, ".") {
+		if i > 0 {
+			for t.Kind == "array" && t.Subtype != nil {
+				t = *t.Subtype
+			}
+			if t.Kind != "struct" && t.Kind != "union" {
+				return 0, t, fmt.Errorf("symbol table %s: %s.%s is not a struct", s.Path, structName, path)
+			}
+			name = t.Name
+		}
+		off, ft, ok := s.member(name, part)
+		if !ok {
+			return 0, t, fmt.Errorf("symbol table %s has no field %s.%s", s.Path, structName, path)
+		}
+		offset += off
+		t = ft
+	}
+	return offset, t, nil
+}
+
+// member finds a field of a struct, also inside its anonymous members
+func (s *Symbols) member(structName, name string) (uint64, isfType, bool) {
+	st, ok := s.UserTypes[structName]
+	if !ok {
+		return 0, isfType{}, false
+	}
+	if f, ok := st.Fields[name]; ok {
+		return f.Offset, f.Type, true
+	}
+	for _, f := range st.Fields {
+		if f.Anonymous && (f.Type.Kind == "struct" || f.Type.Kind == "union") {
+			if off, t, ok := s.member(f.Type.Name, name); ok {
+				return f.Offset + off, t, true
+			}
+		}
+	}
+	return 0, isfType{}, false
+}
+
+// decode reads an integer of a type from the little-endian bytes at the
+// start of data, extracting bitfields
+func (s *Symbols) decode(t isfType, data []byte) uint64 {
+	size := s.sizeOf(t)
+	if size > 8 {
+		size = 8
+	}
+	var v uint64
+	for i := uint64(0); i < size && i < uint64(len(data)); i++ {
+		v |= uint64(data[i]) << (8 * i)
+	}
+	if t.Kind == "bitfield" && t.BitLength > 0 && t.BitLength < 64 {
+		v = (v >> uint(t.BitPosition)) & (1<<uint(t.BitLength) - 1)
+	}
+	return v
+}
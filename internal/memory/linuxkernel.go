@@ -0,0 +1,514 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// kernelMap is where x86-64 Linux maps the kernel image, __START_KERNEL_map
+const kernelMap = 0xffffffff80000000
+
+// kaslrAlign and kaslrRange bound the virtual offsets KASLR picks for the
+// kernel image
+const (
+	kaslrAlign = 2 << 20
+	kaslrRange = 1 << 30
+)
+
+// maxListEntries stops a walk of a corrupt or looping kernel list
+const maxListEntries = 1 << 16
+
+// tcpStates names the TCP states of the kernel, from include/net/tcp_states.h
+var tcpStates = map[uint64]string{
+	1: "ESTABLISHED", 2: "SYN_SENT", 3: "SYN_RECV", 4: "FIN_WAIT1", 5: "FIN_WAIT2", 6: "TIME_WAIT",
+	7: "CLOSE", 8: "CLOSE_WAIT", 9: "LAST_ACK", 10: "LISTEN", 11: "CLOSING", 12: "NEW_SYN_RECV",
+}
+
+// Address families and socket types of the kernel
+const (
+	afInet   = 2
+	afInet6  = 10
+	afPacket = 17
+	sockRaw  = 3
+)
+
+// linuxKernel reads the kernel structures of a Linux image through the
+// kernel page tables
+type linuxKernel struct {
+	img   *DumpImage
+	sym   *Symbols
+	dtb   uint64 // Physical address of the kernel page tables
+	shift uint64 // KASLR offset of kernel virtual addresses
+}
+
+// findLinuxKernel locates the kernel of an image. The version banner
+// found in physical memory gives where the kernel image was loaded, and so
+// the kernel page tables; the virtual KASLR offset is the one those
+// tables map the banner at.
+func findLinuxKernel(img *DumpImage) (*linuxKernel, error) {
+	s := img.Symbols
+	banner, ok := s.symbol("linux_banner")
+	if !ok {
+		return nil, fmt.Errorf("symbol table %s has no linux_banner", s.Path)
+	}
+	pgt, ok := s.symbol("init_top_pgt")
+	if !ok {
+		if pgt, ok = s.symbol("init_level4_pgt"); !ok {
+			return nil, fmt.Errorf("symbol table %s has no init_top_pgt", s.Path)
+		}
+	}
+	pattern := bytes.TrimRight(s.constantData("linux_banner"), "\x00")
+	if len(pattern) == 0 {
+		pattern = []byte("Linux version ")
+	}
+	var k *linuxKernel
+	hits := 0
+	img.scan(pattern, func(pa uint64) bool {
+		hits++
+		physShift := pa - (banner - kernelMap)
+		dtb := pgt - kernelMap + physShift
+		for shift := uint64(0); shift < kaslrRange; shift += kaslrAlign {
+			if got, err := img.translate(dtb, banner+shift); err == nil && got == pa {
+				k = &linuxKernel{img: img, sym: s, dtb: dtb, shift: shift}
+				return false
+			}
+		}
+		return hits < 32
+	})
+	if k == nil {
+		if hits == 0 {
+			return nil, fmt.Errorf("the kernel banner of %s is not in the image; the symbol table is for another kernel", s.Path)
+		}
+		return nil, fmt.Errorf("the kernel page tables were not found in the image")
+	}
+	return k, nil
+}
+
+// symbol returns the address of a kernel symbol in the image
+func (k *linuxKernel) symbol(name string) (uint64, error) {
+	addr, ok := k.sym.symbol(name)
+	if !ok {
+		return 0, fmt.Errorf("symbol table %s has no symbol %s", k.sym.Path, name)
+	}
+	return addr + k.shift, nil
+}
+
+// read reads kernel virtual memory
+func (k *linuxKernel) read(va uint64, n uint64) ([]byte, error) {
+	buf := make([]byte, n)
+	return buf, k.img.readVirtual(k.dtb, va, buf)
+}
+
+// value reads an integer or pointer field of the struct at va
+func (k *linuxKernel) value(va uint64, structName, path string) (uint64, error) {
+	off, t, err := k.sym.field(structName, path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := k.read(va+off, k.sym.sizeOf(t))
+	if err != nil {
+		return 0, err
+	}
+	return k.sym.decode(t, data), nil
+}
+
+// chars reads a char array field of the struct at va
+func (k *linuxKernel) chars(va uint64, structName, path string) (string, error) {
+	off, t, err := k.sym.field(structName, path)
+	if err != nil {
+		return "", err
+	}
+	data, err := k.read(va+off, k.sym.sizeOf(t))
+	if err != nil {
+		return "", err
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data), nil
+}
+
+// cString reads a NUL terminated string of at most max bytes
+func (k *linuxKernel) cString(va uint64, max int) (string, error) {
+	var s []byte
+	for len(s) < max {
+		n := 0x1000 - int(va&0xfff)
+		if n > max-len(s) {
+			n = max - len(s)
+		}
+		data, err := k.read(va, uint64(n))
+		if err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return string(append(s, data[:i]...)), nil
+		}
+		s = append(s, data...)
+		va += uint64(n)
+	}
+	return string(s), nil
+}
+
+// walkList calls fn with each entry of the list_head at head, given as the
+// address of the struct holding it, member bytes before
+func (k *linuxKernel) walkList(head, member uint64, fn func(entry uint64) error) error {
+	seen := make(map[uint64]bool)
+	next, err := k.value(head, "list_head", "next")
+	for err == nil && next != head && next != 0 {
+		if seen[next] || len(seen) >= maxListEntries {
+			return fmt.Errorf("list at 0x%x loops", head)
+		}
+		seen[next] = true
+		if err = fn(next - member); err != nil {
+			return err
+		}
+		next, err = k.value(next, "list_head", "next")
+	}
+	return err
+}
+
+// processes walks the task list from init_task, which is the idle task
+// and not listed itself
+func (k *linuxKernel) processes() ([]*ImageProcess, error) {
+	initTask, err := k.symbol("init_task")
+	if err != nil {
+		return nil, err
+	}
+	tasks, _, err := k.sym.field("task_struct", "tasks")
+	if err != nil {
+		return nil, err
+	}
+	var processes []*ImageProcess
+	err = k.walkList(initTask+tasks, tasks, func(task uint64) error {
+		p, err := k.process(task)
+		if err != nil {
+			return fmt.Errorf("task at 0x%x: %v", task, err)
+		}
+		processes = append(processes, p)
+		return nil
+	})
+	return processes, err
+}
+
+// process reads a task_struct
+func (k *linuxKernel) process(task uint64) (*ImageProcess, error) {
+	p := &ImageProcess{Offset: task}
+	tgid, err := k.value(task, "task_struct", "tgid")
+	if err != nil {
+		return nil, err
+	}
+	p.PID = int(int32(tgid))
+	if p.Name, err = k.chars(task, "task_struct", "comm"); err != nil {
+		return nil, err
+	}
+	if parent, err := k.value(task, "task_struct", "real_parent"); err == nil && parent != 0 {
+		if ppid, err := k.value(parent, "task_struct", "tgid"); err == nil {
+			p.ParentPID = int(int32(ppid))
+		}
+	}
+	if mm, err := k.value(task, "task_struct", "mm"); err == nil {
+		p.KernelThread = mm == 0
+	}
+	if cred, err := k.value(task, "task_struct", "cred"); err == nil && cred != 0 {
+		uid, err := k.value(cred, "cred", "uid.val")
+		if err != nil {
+			uid, err = k.value(cred, "cred", "uid")
+		}
+		if err == nil {
+			p.UID = int(int32(uid))
+		}
+	}
+	if signal, err := k.value(task, "task_struct", "signal"); err == nil && signal != 0 {
+		if n, err := k.value(signal, "signal_struct", "nr_threads"); err == nil {
+			p.Threads = int(int32(n))
+		}
+	}
+	return p, nil
+}
+
+// moduleLayouts are the fields holding the address and size of a module's
+// code, in kernels from 6.4, from 4.5 and before that
+var moduleLayouts = [][2]string{
+	{"mem.base", "mem.size"},
+	{"core_layout.base", "core_layout.size"},
+	{"module_core", "core_size"},
+}
+
+// modules walks the list of loaded modules
+func (k *linuxKernel) modules() ([]*ImageModule, error) {
+	head, err := k.symbol("modules")
+	if err != nil {
+		return nil, err
+	}
+	list, _, err := k.sym.field("module", "list")
+	if err != nil {
+		return nil, err
+	}
+	var layout [2]string
+	for _, l := range moduleLayouts {
+		_, _, err1 := k.sym.field("module", l[0])
+		_, _, err2 := k.sym.field("module", l[1])
+		if err1 == nil && err2 == nil {
+			layout = l
+			break
+		}
+	}
+	var modules []*ImageModule
+	err = k.walkList(head, list, func(mod uint64) error {
+		m := &ImageModule{Offset: mod}
+		var err error
+		if m.Name, err = k.chars(mod, "module", "name"); err != nil {
+			return fmt.Errorf("module at 0x%x: %v", mod, err)
+		}
+		if layout[0] != "" {
+			m.Base, _ = k.value(mod, "module", layout[0])
+			m.Size, _ = k.value(mod, "module", layout[1])
+		}
+		modules = append(modules, m)
+		return nil
+	})
+	return modules, err
+}
+
+// maxFDs bounds the file descriptors read of one process
+const maxFDs = 1 << 16
+
+// eachFile calls fn with each open file of each user process
+func (k *linuxKernel) eachFile(fn func(p *ImageProcess, fd int, file uint64)) error {
+	processes, err := k.processes()
+	if err != nil {
+		return err
+	}
+	ptr := k.sym.sizeOf(isfType{Kind: "pointer"})
+	for _, p := range processes {
+		if p.KernelThread {
+			continue
+		}
+		// A process that exits while the image is taken may have its
+		// file table half torn down, so read errors skip it
+		files, err := k.value(p.Offset, "task_struct", "files")
+		if err != nil || files == 0 {
+			continue
+		}
+		fdt, err := k.value(files, "files_struct", "fdt")
+		if err != nil {
+			continue
+		}
+		max, err1 := k.value(fdt, "fdtable", "max_fds")
+		fds, err2 := k.value(fdt, "fdtable", "fd")
+		if err1 != nil || err2 != nil || fds == 0 {
+			continue
+		}
+		if max > maxFDs {
+			max = maxFDs
+		}
+		table, err := k.read(fds, max*ptr)
+		if err != nil {
+			continue
+		}
+		for fd := uint64(0); fd < max; fd++ {
+			if file := k.sym.decode(isfType{Kind: "pointer"}, table[fd*ptr:]); file != 0 {
+				fn(p, int(fd), file)
+			}
+		}
+	}
+	return nil
+}
+
+// socket returns the sock of a file that is a socket, or 0
+func (k *linuxKernel) socket(file uint64) uint64 {
+	ops, err := k.symbol("socket_file_ops")
+	if err != nil {
+		return 0
+	}
+	if fop, err := k.value(file, "file", "f_op"); err != nil || fop != ops {
+		return 0
+	}
+	socket, err := k.value(file, "file", "private_data")
+	if err != nil || socket == 0 {
+		return 0
+	}
+	sk, err := k.value(socket, "socket", "sk")
+	if err != nil {
+		return 0
+	}
+	return sk
+}
+
+// connection reads the addresses of an IPv4 or IPv6 socket file
+func (k *linuxKernel) connection(file uint64) *ImageConnection {
+	sk := k.socket(file)
+	if sk == 0 {
+		return nil
+	}
+	family, err := k.value(sk, "sock", "__sk_common.skc_family")
+	if err != nil || (family != afInet && family != afInet6) {
+		return nil
+	}
+	c := &ImageConnection{Family: "ipv4", Protocol: "raw"}
+	switch proto, _ := k.value(sk, "sock", "sk_protocol"); proto {
+	case 6:
+		c.Protocol = "tcp"
+	case 17:
+		c.Protocol = "udp"
+	}
+	local, remote, size := "skc_rcv_saddr", "skc_daddr", uint64(4)
+	if family == afInet6 {
+		c.Family = "ipv6"
+		local, remote, size = "skc_v6_rcv_saddr", "skc_v6_daddr", 16
+	}
+	if c.LocalAddr, err = k.address(sk, local, size); err != nil {
+		return nil
+	}
+	if c.RemoteAddr, err = k.address(sk, remote, size); err != nil {
+		return nil
+	}
+	if port, err := k.value(sk, "sock", "__sk_common.skc_num"); err == nil {
+		c.LocalPort = int(port)
+	}
+	// skc_dport is in network byte order
+	if port, err := k.value(sk, "sock", "__sk_common.skc_dport"); err == nil {
+		c.RemotePort = int(port>>8 | (port&0xff)<<8)
+	}
+	if c.Protocol == "tcp" {
+		state, _ := k.value(sk, "sock", "__sk_common.skc_state")
+		c.State = tcpStates[state]
+	}
+	return c
+}
+
+// address reads an IP address field of sock_common
+func (k *linuxKernel) address(sk uint64, name string, size uint64) (string, error) {
+	off, _, err := k.sym.field("sock", "__sk_common."+name)
+	if err != nil {
+		return "", err
+	}
+	data, err := k.read(sk+off, size)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(data).String(), nil
+}
+
+// handle describes an open file
+func (k *linuxKernel) handle(file uint64) *ImageHandle {
+	inode, _ := k.value(file, "file", "f_inode")
+	ino, _ := k.value(inode, "inode", "i_ino")
+	if sk := k.socket(file); sk != 0 {
+		h := &ImageHandle{Type: "socket", Path: fmt.Sprintf("socket:[%d]", ino)}
+		family, _ := k.value(sk, "sock", "__sk_common.skc_family")
+		typ, _ := k.value(sk, "sock", "sk_type")
+		if family == afPacket || typ == sockRaw {
+			h.Suspicious = "raw or packet socket, which can capture or forge traffic"
+		}
+		return h
+	}
+	h := &ImageHandle{Type: "file"}
+	path, err := k.path(file, ino)
+	if err != nil {
+		h.Path = fmt.Sprintf("inode:[%d]", ino)
+		return h
+	}
+	h.Path = path
+	h.Suspicious = suspiciousPath(path)
+	return h
+}
+
+// path rebuilds the path of an open file from its dentry up through the
+// mounts it crosses, as d_path does
+func (k *linuxKernel) path(file, ino uint64) (string, error) {
+	dentry, err := k.value(file, "file", "f_path.dentry")
+	if err != nil {
+		return "", err
+	}
+	vfsmnt, err := k.value(file, "file", "f_path.mnt")
+	if err != nil {
+		return "", err
+	}
+	mntOff, _, err := k.sym.field("mount", "mnt")
+	if err != nil {
+		return "", err
+	}
+	mount := vfsmnt - mntOff
+	d := dentry
+	var parts []string
+	for i := 0; i < 256; i++ {
+		root, err := k.value(mount+mntOff, "vfsmount", "mnt_root")
+		if err != nil {
+			return "", err
+		}
+		if d == root {
+			parent, err := k.value(mount, "mount", "mnt_parent")
+			if err != nil || parent == mount || parent == 0 {
+				break
+			}
+			if d, err = k.value(mount, "mount", "mnt_mountpoint"); err != nil {
+				return "", err
+			}
+			mount = parent
+			continue
+		}
+		name, err := k.dentryName(d)
+		if err != nil {
+			return "", err
+		}
+		parent, err := k.value(d, "dentry", "d_parent")
+		if err != nil {
+			return "", err
+		}
+		if parent == d {
+			// A dentry of a pseudo file system, named like the kernel
+			// names pipes and anonymous inodes
+			switch {
+			case name == "":
+				return fmt.Sprintf("pipe:[%d]", ino), nil
+			case strings.HasPrefix(name, "["):
+				return "anon_inode:" + name, nil
+			}
+			parts = append(parts, name)
+			break
+		}
+		parts = append(parts, name)
+		d = parent
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	path := "/" + strings.Join(parts, "/")
+	// An unhashed dentry that is not a root has been unlinked
+	if pprev, err := k.value(dentry, "dentry", "d_hash.pprev"); err == nil && pprev == 0 {
+		if parent, err := k.value(dentry, "dentry", "d_parent"); err == nil && parent != dentry {
+			path += " (deleted)"
+		}
+	}
+	return path, nil
+}
+
+// dentryName reads the name of a dentry
+func (k *linuxKernel) dentryName(dentry uint64) (string, error) {
+	name, err := k.value(dentry, "dentry", "d_name.name")
+	if err != nil {
+		return "", err
+	}
+	return k.cString(name, 256)
+}
+
+// suspiciousPath tells why an open file stands out, or returns ""
+func suspiciousPath(path string) string {
+	switch {
+	case strings.Contains(path, "/memfd:"):
+		return "memory-backed file (memfd), which can run code that was never on disk"
+	case strings.HasSuffix(path, " (deleted)"):
+		return "deleted file still open"
+	case path == "/dev/mem" || path == "/dev/kmem" || path == "/dev/port" || path == "/proc/kcore":
+		return "raw access to physical or kernel memory"
+	}
+	for _, dir := range []string{"/dev/shm/", "/tmp/", "/var/tmp/"} {
+		if strings.HasPrefix(path, dir) {
+			return "file in the world-writable " + strings.TrimSuffix(dir, "/")
+		}
+	}
+	return ""
+}
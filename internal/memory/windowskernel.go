@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"bytes"
+	"time"
+)
+
+// processObject is the dispatcher header type of a process object
+const processObject = 3
+
+// FILETIME values, 100ns intervals since 1601, of the Unix epoch and of
+// the years a real process creation time falls between
+const (
+	filetimeUnixEpoch = 116444736000000000
+	filetimeMin       = 119600064000000000 // 1980
+	filetimeMax       = 157469184000000000 // 2100
+)
+
+// poolAlign is the alignment of pool allocations on x64
+const poolAlign = 16
+
+// eprocessFields are the fields of _EPROCESS the scan reads
+var eprocessFields = []string{
+	"Pcb.Header.Type", "Pcb.DirectoryTableBase", "UniqueProcessId",
+	"InheritedFromUniqueProcessId", "ImageFileName", "CreateTime", "ExitTime",
+}
+
+// scanWindowsProcesses finds the process objects of a Windows image by the
+// Proc tag of the pool allocations holding them, as psscan does. An
+// allocation starts with a pool header, then optional object headers, the
+// object header and the _EPROCESS, so each aligned offset a process could
+// start at is tried.
+func (img *DumpImage) scanWindowsProcesses() ([]*ImageProcess, error) {
+	s := img.Symbols
+	poolSize, err := s.structSize("_POOL_HEADER")
+	if err != nil {
+		return nil, err
+	}
+	tagOff, _, err := s.field("_POOL_HEADER", "PoolTag")
+	if err != nil {
+		return nil, err
+	}
+	blockOff, blockType, err := s.field("_POOL_HEADER", "BlockSize")
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := s.field("_OBJECT_HEADER", "Body")
+	if err != nil {
+		return nil, err
+	}
+	size, err := s.structSize("_EPROCESS")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range eprocessFields {
+		if _, _, err := s.field("_EPROCESS", f); err != nil {
+			return nil, err
+		}
+	}
+	var processes []*ImageProcess
+	img.scan([]byte("Proc"), func(addr uint64) bool {
+		pool := addr - tagOff
+		if pool%poolAlign != 0 {
+			return true
+		}
+		header := make([]byte, poolSize)
+		if n, _ := img.ReadPhysical(pool, header); uint64(n) != poolSize {
+			return true
+		}
+		block := s.decode(blockType, header[blockOff:]) * poolAlign
+		first := poolSize + body
+		if block < first+size {
+			return true
+		}
+		data := make([]byte, block)
+		if n, _ := img.ReadPhysical(pool, data); uint64(n) != block {
+			return true
+		}
+		for off := first; off+size <= block; off += poolAlign {
+			if p := s.windowsProcess(data[off : off+size]); p != nil {
+				p.Offset = pool + off
+				processes = append(processes, p)
+				break
+			}
+		}
+		return true
+	})
+	return processes, nil
+}
+
+// windowsProcess reads an _EPROCESS, or returns nil if the bytes do not
+// hold a plausible one
+func (s *Symbols) windowsProcess(data []byte) *ImageProcess {
+	value := func(path string) uint64 {
+		off, t, _ := s.field("_EPROCESS", path)
+		return s.decode(t, data[off:])
+	}
+	if value("Pcb.Header.Type") != processObject || value("Pcb.DirectoryTableBase") == 0 {
+		return nil
+	}
+	pid, ppid := value("UniqueProcessId"), value("InheritedFromUniqueProcessId")
+	if pid%4 != 0 || pid > 0xffffffff || ppid > 0xffffffff {
+		return nil
+	}
+	off, t, _ := s.field("_EPROCESS", "ImageFileName")
+	name := data[off : off+s.sizeOf(t)]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	for _, c := range name {
+		if c < 0x20 || c > 0x7e {
+			return nil
+		}
+	}
+	created, exited := value("CreateTime"), value("ExitTime")
+	if created != 0 && (created < filetimeMin || created > filetimeMax) {
+		return nil
+	}
+	if exited != 0 && (exited < created || exited > filetimeMax) {
+		return nil
+	}
+	p := &ImageProcess{PID: int(pid), ParentPID: int(ppid), Name: string(name), Exited: exited != 0}
+	if created != 0 {
+		p.CreateTime = time.Unix(0, int64(created-filetimeUnixEpoch)*100).UTC()
+	}
+	if off, t, err := s.field("_EPROCESS", "ActiveThreads"); err == nil {
+		p.Threads = int(s.decode(t, data[off:]))
+	}
+	return p
+}
@@ -0,0 +1,351 @@
+// Package metrics keeps counters, gauges and histograms for running scripts
+// and renders them in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind is the type of a metric
+type Kind string
+
+const (
+	Counter   Kind = "counter"
+	Gauge     Kind = "gauge"
+	Histogram Kind = "histogram"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given, the
+// same as the Prometheus client libraries
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Metric is a named family of series, one per combination of label values
+type Metric struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Labels  []string
+	Buckets []float64 // Upper bounds for histograms, ascending, without +Inf
+	series  map[string]*series
+}
+
+// series is one set of label values and what was recorded for it
+type series struct {
+	labels []string
+	value  float64  // Counters and gauges, or the sum of a histogram
+	counts []uint64 // Observations per bucket, the last one for +Inf
+	count  uint64
+}
+
+// Registry holds the metrics of a script. It is safe for concurrent use so
+// it can be scraped while the script records.
+type Registry struct {
+	metrics map[string]*Metric
+	server  *http.Server
+	mu      sync.Mutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*Metric)}
+}
+
+// Register defines a metric. Defining one again with the same kind and
+// labels is allowed and keeps what it recorded.
+func (r *Registry) Register(name, help string, kind Kind, labels []string, buckets []float64) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		if !labelRe.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("invalid label name %q", label)
+		}
+		if label == "le" && kind == Histogram {
+			return fmt.Errorf("histogram %s cannot use the label le", name)
+		}
+		if seen[label] {
+			return fmt.Errorf("duplicate label %q", label)
+		}
+		seen[label] = true
+	}
+	if kind == Histogram {
+		if buckets == nil {
+			buckets = DefaultBuckets
+		}
+		if len(buckets) == 0 {
+			return fmt.Errorf("histogram %s needs at least one bucket", name)
+		}
+		buckets = append([]float64(nil), buckets...)
+		sort.Float64s(buckets)
+		if math.IsInf(buckets[len(buckets)-1], 1) {
+			buckets = buckets[:len(buckets)-1]
+		}
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] == buckets[i-1] {
+				return fmt.Errorf("histogram %s has the bucket %v twice", name, buckets[i])
+			}
+		}
+	} else {
+		buckets = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if m.Kind != kind || strings.Join(m.Labels, ",") != strings.Join(labels, ",") {
+			return fmt.Errorf("metric %s is already defined as a %s with labels [%s]", name, m.Kind, strings.Join(m.Labels, ", "))
+		}
+		return nil
+	}
+	m := &Metric{
+		Name:    name,
+		Help:    help,
+		Kind:    kind,
+		Labels:  append([]string(nil), labels...),
+		Buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.metrics[name] = m
+	// A metric without labels is exported as zero before anything is recorded
+	if len(labels) == 0 {
+		r.lookup(name, nil, kind)
+	}
+	return nil
+}
+
+// Kind returns the kind of a metric, and false if it is not defined
+func (r *Registry) Kind(name string) (Kind, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		return "", false
+	}
+	return m.Kind, true
+}
+
+// lookup returns the series of a metric for the given label values,
+// creating it on first use. r.mu must be held.
+func (r *Registry) lookup(name string, labels map[string]string, kinds ...Kind) (*Metric, *series, error) {
+	m, ok := r.metrics[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown metric %s", name)
+	}
+	allowed := false
+	for _, kind := range kinds {
+		allowed = allowed || m.Kind == kind
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("metric %s is a %s", name, m.Kind)
+	}
+	if len(labels) != len(m.Labels) {
+		return nil, nil, fmt.Errorf("metric %s expects labels [%s], got %d", name, strings.Join(m.Labels, ", "), len(labels))
+	}
+	values := make([]string, len(m.Labels))
+	for i, label := range m.Labels {
+		v, ok := labels[label]
+		if !ok {
+			return nil, nil, fmt.Errorf("metric %s is missing the label %s", name, label)
+		}
+		values[i] = v
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if m.Kind == Histogram {
+			s.counts = make([]uint64, len(m.Buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return m, s, nil
+}
+
+// Add adds delta to a counter or gauge. Counters only go up.
+func (r *Registry) Add(name string, delta float64, labels map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, s, err := r.lookup(name, labels, Counter, Gauge)
+	if err != nil {
+		return err
+	}
+	if m.Kind == Counter && delta < 0 {
+		return fmt.Errorf("counter %s cannot decrease", name)
+	}
+	s.value += delta
+	return nil
+}
+
+// Set sets a gauge
+func (r *Registry) Set(name string, value float64, labels map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, s, err := r.lookup(name, labels, Gauge)
+	if err != nil {
+		return err
+	}
+	s.value = value
+	return nil
+}
+
+// Observe records a value in a histogram
+func (r *Registry) Observe(name string, value float64, labels map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, s, err := r.lookup(name, labels, Histogram)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchFloat64s(m.Buckets, value)
+	s.counts[i]++
+	s.count++
+	s.value += value
+	return nil
+}
+
+// Value returns the value of a counter or gauge, or the count and sum of a
+// histogram
+func (r *Registry) Value(name string, labels map[string]string) (value float64, count uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, s, err := r.lookup(name, labels, Counter, Gauge, Histogram)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.value, s.count, nil
+}
+
+// Write renders every metric in the Prometheus text format, version 0.0.4
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		m := r.metrics[name]
+		if m.Help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(m.Help))
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, m.Kind)
+
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.Kind != Histogram {
+				fmt.Fprintf(&b, "%s%s %s\n", name, labelString(m.Labels, s.labels, ""), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, count := range s.counts {
+				cumulative += count
+				le := math.Inf(1)
+				if i < len(m.Buckets) {
+					le = m.Buckets[i]
+				}
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, labelString(m.Labels, s.labels, formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, labelString(m.Labels, s.labels, ""), formatFloat(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, labelString(m.Labels, s.labels, ""), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Serve starts an HTTP server in the background that serves the metrics at
+// /metrics. It returns the address it listens on, so an address with port 0
+// picks a free port.
+func (r *Registry) Serve(address string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.server != nil {
+		return "", fmt.Errorf("metrics are already served on %s", r.server.Addr)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+	r.server = &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go r.server.Serve(listener)
+	return r.server.Addr, nil
+}
+
+// Shutdown stops the server started by Serve, reporting whether one was
+// running
+func (r *Registry) Shutdown() (bool, error) {
+	r.mu.Lock()
+	server := r.server
+	r.server = nil
+	r.mu.Unlock()
+	if server == nil {
+		return false, nil
+	}
+	return true, server.Close()
+}
+
+// labelString renders {name="value",...}, adding le for histogram buckets
+func labelString(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -412,6 +412,9 @@ func (ml *ModuleLoader) loadAndCompile(name, path string) (*vm.Module, error) {
 	// Compile to bytecode
 	comp := compiler.NewStmtCompiler()
 	chunk := comp.Compile(stmtInterfaces)
+	if len(comp.Errors) > 0 {
+		return nil, fmt.Errorf("compile errors in module %s: %v", name, comp.Errors[0])
+	}
 	
 	// Create module
 	mod := &vm.Module{
@@ -430,9 +433,10 @@ func (ml *ModuleLoader) loadAndCompile(name, path string) (*vm.Module, error) {
 	// Store the compiled chunk for later execution
 	if fn, ok := chunk.Constants[0].(*compiler.Function); ok {
 		mod.Exports["__init__"] = &vm.Function{
-			Name:  "__init__",
-			Arity: 0,
-			Chunk: fn.Chunk,
+			Name:   "__init__",
+			Arity:  0,
+			Locals: fn.Locals,
+			Chunk:  fn.Chunk,
 		}
 	}
 	
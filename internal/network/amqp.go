@@ -0,0 +1,368 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQP 0-9-1 clients and broker audits
+
+// AMQPOptions configures an AMQP connection
+type AMQPOptions struct {
+	Port     int  // 5671 with TLS, 5672 otherwise
+	TLS      bool // Implied by port 5671, CA, Cert and Insecure
+	CA       string
+	Cert     string
+	Key      string
+	Insecure bool
+	Username string // guest if empty
+	Password string // guest if Username is empty
+	VHost    string // / if empty
+	Timeout  time.Duration
+}
+
+func (o AMQPOptions) withDefaults() AMQPOptions {
+	if o.Port == 5671 || o.CA != "" || o.Cert != "" || o.Insecure {
+		o.TLS = true
+	}
+	if o.Port == 0 {
+		o.Port = 5672
+		if o.TLS {
+			o.Port = 5671
+		}
+	}
+	if o.Username == "" {
+		o.Username, o.Password = "guest", "guest"
+	}
+	if o.VHost == "" {
+		o.VHost = "/"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// AMQPConn is a connection to a broker with one channel, reopened after a
+// broker error closes it
+type AMQPConn struct {
+	ID      string
+	Broker  string
+	Server  map[string]interface{} // The broker's properties, such as product and version
+	conn    *amqp.Connection
+	timeout time.Duration
+
+	mu sync.Mutex
+	ch *amqp.Channel
+}
+
+// AMQPQueue is a declared queue
+type AMQPQueue struct {
+	Name      string
+	Messages  int
+	Consumers int
+}
+
+// AMQPMessage is a message read from a queue
+type AMQPMessage struct {
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	Headers     map[string]interface{}
+	Body        []byte
+	Redelivered bool
+	Remaining   int // Messages left in the queue
+}
+
+// amqpConns holds the open connections by id
+var amqpConns = make(map[string]*AMQPConn)
+
+// AMQPConnect connects to an AMQP 0-9-1 broker such as RabbitMQ and
+// opens a channel
+func AMQPConnect(host string, opts AMQPOptions) (*AMQPConn, error) {
+	host, opts.Port = mqttBroker(host, opts.Port)
+	opts = opts.withDefaults()
+	config := amqp.Config{
+		Vhost:      opts.VHost,
+		SASL:       []amqp.Authentication{&amqp.PlainAuth{Username: opts.Username, Password: opts.Password}},
+		Dial:       amqp.DefaultDial(opts.Timeout),
+		Properties: amqp.Table{"product": "sentra"},
+	}
+	scheme := "amqp"
+	if opts.TLS {
+		scheme = "amqps"
+		tlsConfig, err := clientTLSConfig(host, opts.CA, opts.Cert, opts.Key, opts.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		config.TLSClientConfig = tlsConfig
+	}
+	broker := net.JoinHostPort(host, strconv.Itoa(opts.Port))
+	conn, err := amqp.DialConfig(scheme+"://"+broker+"/"+url.PathEscape(opts.VHost), config)
+	if err != nil {
+		return nil, err
+	}
+	c := &AMQPConn{Broker: broker, conn: conn, timeout: opts.Timeout, Server: make(map[string]interface{})}
+	for k, v := range conn.Properties {
+		if s, ok := v.(string); ok {
+			c.Server[k] = s
+		}
+	}
+	if _, err := c.channel(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	registryMutex.Lock()
+	c.ID = generateID("amqp")
+	amqpConns[c.ID] = c
+	registryMutex.Unlock()
+	return c, nil
+}
+
+// LookupAMQP returns an open connection by id
+func LookupAMQP(id string) (*AMQPConn, error) {
+	registryMutex.RLock()
+	c, ok := amqpConns[id]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("AMQP connection '%s' not found", id)
+	}
+	return c, nil
+}
+
+// Close closes and forgets the connection
+func (c *AMQPConn) Close() error {
+	registryMutex.Lock()
+	delete(amqpConns, c.ID)
+	registryMutex.Unlock()
+	return c.conn.Close()
+}
+
+// channel returns the connection's channel, opening a new one if a broker
+// error closed the last
+func (c *AMQPConn) channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch == nil || c.ch.IsClosed() {
+		ch, err := c.conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		c.ch = ch
+	}
+	return c.ch, nil
+}
+
+// DeclareQueue declares a queue, or with passive checks that it exists,
+// and returns its message and consumer counts
+func (c *AMQPConn) DeclareQueue(name string, durable, autoDelete, passive bool) (*AMQPQueue, error) {
+	ch, err := c.channel()
+	if err != nil {
+		return nil, err
+	}
+	declare := ch.QueueDeclare
+	if passive {
+		declare = ch.QueueDeclarePassive
+	}
+	q, err := declare(name, durable, autoDelete, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AMQPQueue{Name: q.Name, Messages: q.Messages, Consumers: q.Consumers}, nil
+}
+
+// Publish sends a message to an exchange, "" for the default exchange
+// that routes to the queue named by the routing key
+func (c *AMQPConn) Publish(exchange, key string, body []byte, contentType string, headers map[string]interface{}, persistent bool) error {
+	ch, err := c.channel()
+	if err != nil {
+		return err
+	}
+	msg := amqp.Publishing{ContentType: contentType, Headers: amqp.Table(headers), Body: body, Timestamp: time.Now()}
+	if persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+// Get reads up to max messages from a queue. With ack they are removed;
+// otherwise they are put back once read.
+func (c *AMQPConn) Get(queue string, max int, ack bool) ([]AMQPMessage, error) {
+	ch, err := c.channel()
+	if err != nil {
+		return nil, err
+	}
+	var msgs []AMQPMessage
+	var last uint64
+	for len(msgs) < max {
+		d, ok, err := ch.Get(queue, ack)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		last = d.DeliveryTag
+		msgs = append(msgs, AMQPMessage{
+			Exchange:    d.Exchange,
+			RoutingKey:  d.RoutingKey,
+			ContentType: d.ContentType,
+			Headers:     map[string]interface{}(d.Headers),
+			Body:        d.Body,
+			Redelivered: d.Redelivered,
+			Remaining:   int(d.MessageCount),
+		})
+	}
+	if !ack && last != 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// AMQPAuditOptions chooses what an AMQP audit tries. Queues are checked
+// for read access without taking their messages.
+type AMQPAuditOptions struct {
+	AMQPOptions
+	Queues []string
+}
+
+// AMQPAudit is what an audit of a broker found
+type AMQPAudit struct {
+	Broker         string
+	TLS            bool
+	DefaultLogin   bool   // guest/guest is accepted
+	Authentication string // The login the audit used: guest, password or none if every login failed
+	Product        string
+	Version        string
+	Queues         map[string]int // Readable queues with their message counts
+	Issues         []ProtocolIssue
+	Score          int
+}
+
+// AuditAMQP logs in to a broker with guest/guest and the credentials
+// given, and reports default logins, plaintext connections, the broker's
+// version and the queues it can read
+func AuditAMQP(host string, opts AMQPAuditOptions) (*AMQPAudit, error) {
+	base := opts.AMQPOptions
+	host, base.Port = mqttBroker(host, base.Port)
+	base = base.withDefaults()
+	audit := &AMQPAudit{Broker: net.JoinHostPort(host, strconv.Itoa(base.Port)), TLS: base.TLS, Queues: map[string]int{}, Authentication: "none"}
+
+	guest := base
+	guest.Username, guest.Password = "guest", "guest"
+	conn, err := AMQPConnect(host, guest)
+	if err == nil {
+		audit.DefaultLogin, audit.Authentication = true, "guest"
+	}
+	if opts.Username != "" && opts.Username != "guest" {
+		if conn != nil {
+			conn.Close()
+		}
+		if conn, err = AMQPConnect(host, base); err != nil {
+			return nil, err
+		}
+		audit.Authentication = "password"
+	}
+	if conn == nil {
+		// Refused with guest/guest and no credentials to go further
+		var refused *amqp.Error
+		if !errors.As(err, &refused) {
+			return nil, err
+		}
+		audit.finish()
+		return audit, nil
+	}
+	defer conn.Close()
+
+	audit.Product, _ = conn.Server["product"].(string)
+	audit.Version, _ = conn.Server["version"].(string)
+	for _, name := range opts.Queues {
+		if q, err := conn.DeclareQueue(name, false, false, true); err == nil {
+			audit.Queues[name] = q.Messages
+		}
+	}
+	audit.finish()
+	return audit, nil
+}
+
+// finish lists the issues found and scores the broker
+func (a *AMQPAudit) finish() {
+	if a.DefaultLogin {
+		a.Issues = append(a.Issues, ProtocolIssue{"Default credentials", "HIGH",
+			"The broker accepts the guest account with the password guest",
+			"Delete the guest user or limit it to localhost connections"})
+	}
+	if !a.TLS {
+		severity := "MEDIUM"
+		if a.Authentication != "none" {
+			severity = "HIGH"
+		}
+		a.Issues = append(a.Issues, ProtocolIssue{"No TLS encryption", severity,
+			"Messages and credentials cross the network in clear text",
+			"Serve AMQP over TLS on port 5671 and close port 5672"})
+	}
+	if a.Version != "" {
+		a.Issues = append(a.Issues, ProtocolIssue{"Broker version disclosed", "LOW",
+			fmt.Sprintf("The broker announces %s %s to every client", a.Product, a.Version),
+			"Keep the broker patched, since its version helps attackers pick exploits"})
+	}
+	if a.DefaultLogin && len(a.Queues) > 0 && a.Authentication == "guest" {
+		a.Issues = append(a.Issues, ProtocolIssue{"Queues readable with default credentials", "HIGH",
+			fmt.Sprintf("The guest account can read %d of the queues tried", len(a.Queues)),
+			"Grant queue permissions only to the applications that need them"})
+	}
+	a.Score = protocolScore(a.Issues)
+}
+
+// AMQPMessageToMap converts a message to a map
+func AMQPMessageToMap(m AMQPMessage) map[string]interface{} {
+	headers := make(map[string]interface{}, len(m.Headers))
+	for k, v := range m.Headers {
+		headers[k] = fmt.Sprint(v)
+	}
+	return map[string]interface{}{
+		"exchange":     m.Exchange,
+		"routing_key":  m.RoutingKey,
+		"content_type": m.ContentType,
+		"headers":      headers,
+		"body":         string(m.Body),
+		"redelivered":  m.Redelivered,
+		"remaining":    m.Remaining,
+	}
+}
+
+// AMQPAuditToMap converts an audit to a map with the keys of
+// iot_protocol_security
+func AMQPAuditToMap(a *AMQPAudit) map[string]interface{} {
+	host, port, _ := net.SplitHostPort(a.Broker)
+	portNum, _ := strconv.Atoi(port)
+	queues := make(map[string]interface{}, len(a.Queues))
+	for name, n := range a.Queues {
+		queues[name] = n
+	}
+	return map[string]interface{}{
+		"broker_address":          host,
+		"broker_port":             portNum,
+		"tls_enabled":             a.TLS,
+		"default_credentials":     a.DefaultLogin,
+		"authentication":          a.Authentication,
+		"product":                 a.Product,
+		"broker_version":          a.Version,
+		"readable_queues":         queues,
+		"security_issues":         ProtocolIssuesToList(a.Issues),
+		"protocol_security_score": a.Score,
+	}
+}
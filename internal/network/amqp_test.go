@@ -0,0 +1,407 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAMQP is an AMQP 0-9-1 broker for tests with just enough of the
+// protocol for AMQPConn: logins, queue declares, publishes to the default
+// exchange, and basic.get with acks and requeues
+type fakeAMQP struct {
+	users map[string]string
+
+	mu     sync.Mutex
+	queues map[string][]amqpStored
+}
+
+type amqpStored struct {
+	key         string
+	flags       uint16
+	props, body []byte
+	redelivered bool
+}
+
+func startAMQP(t *testing.T, b *fakeAMQP) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b.queues = map[string][]amqpStored{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// amqpArgs builds and reads method arguments
+type amqpArgs struct{ bytes.Buffer }
+
+func (a *amqpArgs) short(v uint16)       { binary.Write(a, binary.BigEndian, v) }
+func (a *amqpArgs) long(v uint32)        { binary.Write(a, binary.BigEndian, v) }
+func (a *amqpArgs) longlong(v uint64)    { binary.Write(a, binary.BigEndian, v) }
+func (a *amqpArgs) shortstr(s string)    { a.WriteByte(byte(len(s))); a.WriteString(s) }
+func (a *amqpArgs) longstr(s string)     { a.long(uint32(len(s))); a.WriteString(s) }
+func (a *amqpArgs) readShort() uint16    { return binary.BigEndian.Uint16(a.Next(2)) }
+func (a *amqpArgs) readLong() uint32     { return binary.BigEndian.Uint32(a.Next(4)) }
+func (a *amqpArgs) readLonglong() uint64 { return binary.BigEndian.Uint64(a.Next(8)) }
+func (a *amqpArgs) readShortstr() string {
+	n, _ := a.ReadByte()
+	return string(a.Next(int(n)))
+}
+func (a *amqpArgs) readLongstr() string { return string(a.Next(int(a.readLong()))) }
+
+func (a *amqpArgs) table(m map[string]string) {
+	var t amqpArgs
+	for k, v := range m {
+		t.shortstr(k)
+		t.WriteByte('S')
+		t.longstr(v)
+	}
+	a.long(uint32(t.Len()))
+	a.Write(t.Bytes())
+}
+
+type amqpSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *amqpSession) frame(kind byte, channel uint16, payload []byte) {
+	var f amqpArgs
+	f.WriteByte(kind)
+	f.short(channel)
+	f.long(uint32(len(payload)))
+	f.Write(payload)
+	f.WriteByte(0xCE)
+	s.conn.Write(f.Bytes())
+}
+
+func (s *amqpSession) method(channel, class, id uint16, args *amqpArgs) {
+	var m amqpArgs
+	m.short(class)
+	m.short(id)
+	if args != nil {
+		m.Write(args.Bytes())
+	}
+	s.frame(1, channel, m.Bytes())
+}
+
+func (s *amqpSession) content(channel uint16, msg amqpStored) {
+	var h amqpArgs
+	h.short(60)
+	h.short(0)
+	h.longlong(uint64(len(msg.body)))
+	h.short(msg.flags)
+	h.Write(msg.props)
+	s.frame(2, channel, h.Bytes())
+	s.frame(3, channel, msg.body)
+}
+
+// read returns the next frame other than a heartbeat
+func (s *amqpSession) read() (byte, uint16, *amqpArgs, error) {
+	for {
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(s.r, head); err != nil {
+			return 0, 0, nil, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(head[3:])+1)
+		if _, err := io.ReadFull(s.r, payload); err != nil {
+			return 0, 0, nil, err
+		}
+		if head[0] == 8 {
+			continue
+		}
+		args := &amqpArgs{}
+		args.Write(payload[:len(payload)-1])
+		return head[0], binary.BigEndian.Uint16(head[1:]), args, nil
+	}
+}
+
+func (b *fakeAMQP) serve(conn net.Conn) {
+	defer conn.Close()
+	s := &amqpSession{conn: conn, r: bufio.NewReader(conn)}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(s.r, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+
+	var start amqpArgs
+	start.WriteByte(0)
+	start.WriteByte(9)
+	start.table(map[string]string{"product": "FakeMQ", "version": "3.8.2"})
+	start.longstr("PLAIN")
+	start.longstr("en_US")
+	s.method(0, 10, 10, &start)
+	_, _, args, err := s.read()
+	if err != nil {
+		return
+	}
+	args.Next(4)
+	args.Next(int(args.readLong()))
+	args.readShortstr()
+	login := strings.SplitN(strings.TrimPrefix(args.readLongstr(), "\x00"), "\x00", 2)
+	if len(login) != 2 || b.users[login[0]] != login[1] || b.users[login[0]] == "" {
+		// RabbitMQ drops the socket on a failed login
+		return
+	}
+	var tune amqpArgs
+	tune.short(2047)
+	tune.long(131072)
+	tune.short(0)
+	s.method(0, 10, 30, &tune)
+
+	var tag uint64
+	unacked := map[uint64]amqpStored{}
+	queueOf := map[uint64]string{}
+	for {
+		kind, channel, args, err := s.read()
+		if err != nil || kind != 1 {
+			return
+		}
+		class, id := args.readShort(), args.readShort()
+		switch {
+		case class == 10 && id == 40: // connection.open
+			var ok amqpArgs
+			ok.shortstr("")
+			s.method(0, 10, 41, &ok)
+		case class == 10 && id == 50: // connection.close
+			s.method(0, 10, 51, nil)
+			return
+		case class == 20 && id == 10: // channel.open
+			var ok amqpArgs
+			ok.longstr("")
+			s.method(channel, 20, 11, &ok)
+		case class == 20 && id == 40: // channel.close
+			s.method(channel, 20, 41, nil)
+		case class == 50 && id == 10: // queue.declare
+			args.readShort()
+			name := args.readShortstr()
+			bits, _ := args.ReadByte()
+			b.mu.Lock()
+			msgs, exists := b.queues[name]
+			if !exists && bits&1 == 0 {
+				b.queues[name] = nil
+				exists = true
+			}
+			b.mu.Unlock()
+			if !exists {
+				var closing amqpArgs
+				closing.short(404)
+				closing.shortstr("NOT_FOUND - no queue '" + name + "'")
+				closing.short(50)
+				closing.short(10)
+				s.method(channel, 20, 40, &closing)
+				continue
+			}
+			var ok amqpArgs
+			ok.shortstr(name)
+			ok.long(uint32(len(msgs)))
+			ok.long(0)
+			s.method(channel, 50, 11, &ok)
+		case class == 60 && id == 40: // basic.publish
+			args.readShort()
+			args.readShortstr()
+			key := args.readShortstr()
+			_, _, header, err := s.read()
+			if err != nil {
+				return
+			}
+			header.Next(4)
+			size := header.readLonglong()
+			msg := amqpStored{key: key, flags: header.readShort(), props: append([]byte(nil), header.Bytes()...)}
+			for uint64(len(msg.body)) < size {
+				_, _, body, err := s.read()
+				if err != nil {
+					return
+				}
+				msg.body = append(msg.body, body.Bytes()...)
+			}
+			b.mu.Lock()
+			if _, ok := b.queues[key]; ok {
+				b.queues[key] = append(b.queues[key], msg)
+			}
+			b.mu.Unlock()
+		case class == 60 && id == 70: // basic.get
+			args.readShort()
+			name := args.readShortstr()
+			noAck, _ := args.ReadByte()
+			b.mu.Lock()
+			msgs := b.queues[name]
+			var msg amqpStored
+			if len(msgs) > 0 {
+				msg, b.queues[name] = msgs[0], msgs[1:]
+			}
+			left := len(b.queues[name])
+			b.mu.Unlock()
+			if len(msgs) == 0 {
+				var empty amqpArgs
+				empty.shortstr("")
+				s.method(channel, 60, 72, &empty)
+				continue
+			}
+			tag++
+			if noAck&1 == 0 {
+				unacked[tag], queueOf[tag] = msg, name
+			}
+			var ok amqpArgs
+			ok.longlong(tag)
+			if msg.redelivered {
+				ok.WriteByte(1)
+			} else {
+				ok.WriteByte(0)
+			}
+			ok.shortstr("")
+			ok.shortstr(msg.key)
+			ok.long(uint32(left))
+			s.method(channel, 60, 71, &ok)
+			s.content(channel, msg)
+		case class == 60 && (id == 80 || id == 120): // basic.ack and basic.nack
+			upto := args.readLonglong()
+			bits, _ := args.ReadByte()
+			requeue := id == 120 && bits&2 != 0
+			b.mu.Lock()
+			for t := tag; t >= 1; t-- {
+				msg, ok := unacked[t]
+				if !ok || t > upto || (t < upto && bits&1 == 0) {
+					continue
+				}
+				delete(unacked, t)
+				if requeue {
+					msg.redelivered = true
+					b.queues[queueOf[t]] = append([]amqpStored{msg}, b.queues[queueOf[t]]...)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func TestAMQPClient(t *testing.T) {
+	addr := startAMQP(t, &fakeAMQP{users: map[string]string{"app": "s3cret"}})
+	if _, err := AMQPConnect(addr, AMQPOptions{Timeout: 2 * time.Second}); err == nil {
+		t.Fatal("guest login was accepted")
+	}
+	c, err := AMQPConnect(addr, AMQPOptions{Username: "app", Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Server["product"] != "FakeMQ" {
+		t.Errorf("server properties: %v", c.Server)
+	}
+	if got, err := LookupAMQP(c.ID); err != nil || got != c {
+		t.Fatalf("LookupAMQP: %v", err)
+	}
+
+	if _, err := c.DeclareQueue("orders", false, false, true); err == nil || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Fatalf("passive declare of a missing queue: %v", err)
+	}
+	q, err := c.DeclareQueue("orders", true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Name != "orders" || q.Messages != 0 {
+		t.Errorf("queue: %+v", q)
+	}
+	for _, body := range []string{"one", "two"} {
+		if err := c.Publish("", "orders", []byte(body), "text/plain", map[string]interface{}{"source": "test"}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q, err = c.DeclareQueue("orders", false, false, true); err != nil || q.Messages != 2 {
+		t.Fatalf("queue after publishing: %+v, %v", q, err)
+	}
+
+	// Reading without ack puts the messages back
+	msgs, err := c.Get("orders", 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Body) != "one" || msgs[0].ContentType != "text/plain" || msgs[0].Headers["source"] != "test" || msgs[1].Remaining != 0 {
+		t.Fatalf("peeked messages: %+v", msgs)
+	}
+	msgs, err = c.Get("orders", 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Body) != "one" || !msgs[0].Redelivered || msgs[0].Remaining != 1 {
+		t.Fatalf("taken messages: %+v", msgs)
+	}
+	if m := AMQPMessageToMap(msgs[0]); m["routing_key"] != "orders" || m["body"] != "one" {
+		t.Errorf("map: %v", m)
+	}
+}
+
+func TestAuditAMQP(t *testing.T) {
+	open := startAMQP(t, &fakeAMQP{users: map[string]string{"guest": "guest"}})
+	setup, err := AMQPConnect(open, AMQPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup.DeclareQueue("payments", true, false, false)
+	setup.Close()
+
+	audit, err := AuditAMQP(open, AMQPAuditOptions{Queues: []string{"payments", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !audit.DefaultLogin || audit.Authentication != "guest" || audit.Product != "FakeMQ" || audit.Version != "3.8.2" {
+		t.Fatalf("open broker: %+v", audit)
+	}
+	if _, ok := audit.Queues["payments"]; !ok || len(audit.Queues) != 1 {
+		t.Errorf("readable queues: %v", audit.Queues)
+	}
+	issues := map[string]string{}
+	for _, i := range audit.Issues {
+		issues[i.Issue] = i.Severity
+	}
+	for issue, severity := range map[string]string{
+		"Default credentials":                      "HIGH",
+		"No TLS encryption":                        "HIGH",
+		"Broker version disclosed":                 "LOW",
+		"Queues readable with default credentials": "HIGH",
+	} {
+		if issues[issue] != severity {
+			t.Errorf("issue %q: got %q, want %q", issue, issues[issue], severity)
+		}
+	}
+	if audit.Score != 5 {
+		t.Errorf("score %d, want 5", audit.Score)
+	}
+
+	locked := startAMQP(t, &fakeAMQP{users: map[string]string{"app": "s3cret"}})
+	audit, err = AuditAMQP(locked, AMQPAuditOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.DefaultLogin || audit.Authentication != "none" || len(audit.Issues) != 1 || audit.Score != 85 {
+		t.Fatalf("locked broker without credentials: %+v", audit)
+	}
+	opts := AMQPAuditOptions{}
+	opts.Username, opts.Password = "app", "s3cret"
+	if audit, err = AuditAMQP(locked, opts); err != nil {
+		t.Fatal(err)
+	}
+	if audit.DefaultLogin || audit.Authentication != "password" {
+		t.Fatalf("locked broker: %+v", audit)
+	}
+	if m := AMQPAuditToMap(audit); m["default_credentials"] != false || m["broker_version"] != "3.8.2" {
+		t.Errorf("map: %v", m)
+	}
+}
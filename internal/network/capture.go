@@ -1,75 +1,213 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 )
 
-// Packet capture implementation
+// Packet capture implementation. Frames are read from libpcap when built
+// with the pcap tag, which also filters them in the kernel, or from a
+// packet socket on Linux, where filters are matched by PacketFilter.
+// Layers are decoded with gopacket.
+
+// CaptureOptions controls a live capture
+type CaptureOptions struct {
+	Filter   string        // Filter expression in tcpdump syntax
+	Count    int           // Stop after this many packets, 0 for no limit
+	Duration time.Duration // Stop after this long, 0 for no limit
+	Snaplen  int           // Bytes kept of each packet, 65535 if 0
+	Promisc  bool          // Put the interface in promiscuous mode
+}
+
+func (o CaptureOptions) snaplen() int {
+	if o.Snaplen > 0 {
+		return o.Snaplen
+	}
+	return 65535
+}
+
+// packetSource reads frames from an interface or a file
+type packetSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+	Close()
+}
+
+// errCaptureTimeout is returned by live sources when no packet arrived
+// within capturePoll, so that captures can check when to stop
+var errCaptureTimeout = errors.New("capture read timeout")
+
+// capturePoll is how long a live source waits for a packet before
+// returning errCaptureTimeout
+const capturePoll = 200 * time.Millisecond
+
+// maxCapturedPackets bounds the packets a background capture keeps; the
+// oldest are let go first
+const maxCapturedPackets = 10000
+
+// openCapture opens an interface for capture, compiling the filter for
+// sources that do not filter in the kernel
+func openCapture(iface string, opts CaptureOptions) (packetSource, *PacketFilter, error) {
+	src, filtered, err := openLive(iface, opts.snaplen(), opts.Promisc, opts.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filtered {
+		return src, nil, nil
+	}
+	filter, err := CompileFilter(opts.Filter)
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+	return src, filter, nil
+}
+
+// readPackets decodes the packets of src that match filter and passes
+// them to handle until count packets were handled, the deadline passes,
+// stop is closed, the source ends or handle returns false
+func readPackets(src packetSource, filter *PacketFilter, count int, deadline time.Time, stop <-chan struct{}, handle func(*Packet) bool) error {
+	handled := 0
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil
+		}
+		data, ci, err := src.ReadPacketData()
+		if err == errCaptureTimeout {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		packet := decodePacket(data, ci, src.LinkType())
+		if !filter.Match(packet) {
+			continue
+		}
+		handled++
+		if !handle(packet) || (count > 0 && handled >= count) {
+			return nil
+		}
+	}
+}
+
+// Capture captures packets on an interface and calls handle with each one
+// that matches the filter, until opts.Count packets were handled,
+// opts.Duration passed or handle returns false
+func Capture(iface string, opts CaptureOptions, handle func(*Packet) bool) error {
+	src, filter, err := openCapture(iface, opts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var deadline time.Time
+	if opts.Duration > 0 {
+		deadline = time.Now().Add(opts.Duration)
+	}
+	return readPackets(src, filter, opts.Count, deadline, nil, handle)
+}
 
-// StartCapture starts packet capture on an interface
-func StartCapture(iface, filter string) (*PacketCapture, error) {
+// StartCapture starts packet capture on an interface in the background
+func StartCapture(iface string, opts CaptureOptions) (*PacketCapture, error) {
+	src, filter, err := openCapture(iface, opts)
+	if err != nil {
+		return nil, err
+	}
 	capture := &PacketCapture{
-		ID:        generateID("capture"),
 		Interface: iface,
-		Filter:    filter,
+		Filter:    opts.Filter,
+		LinkType:  src.LinkType(),
 		Packets:   make([]*Packet, 0),
 		Running:   true,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
 	}
 
 	// Store the capture session
 	registryMutex.Lock()
+	capture.ID = generateID("capture")
 	captures[capture.ID] = capture
 	registryMutex.Unlock()
 
-	// Start capture in background
-	go capture.capture()
+	go func() {
+		defer close(capture.done)
+		defer src.Close()
+		var deadline time.Time
+		if opts.Duration > 0 {
+			deadline = time.Now().Add(opts.Duration)
+		}
+		err := readPackets(src, filter, opts.Count, deadline, capture.stop, func(packet *Packet) bool {
+			capture.mu.Lock()
+			if len(capture.Packets) >= maxCapturedPackets {
+				capture.Packets = capture.Packets[1:]
+				capture.Dropped++
+			}
+			capture.Packets = append(capture.Packets, packet)
+			capture.mu.Unlock()
+			return true
+		})
+		capture.mu.Lock()
+		capture.Running = false
+		capture.Err = err
+		capture.mu.Unlock()
+	}()
 
 	return capture, nil
 }
 
-// capture runs the packet capture loop
-func (pc *PacketCapture) capture() {
-	// In a real implementation, this would use gopacket/pcap
-	// For now, it's a placeholder that simulates packet capture
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+// lookupCapture returns a capture session by id
+func lookupCapture(captureID string) (*PacketCapture, error) {
+	registryMutex.RLock()
+	capture, exists := captures[captureID]
+	registryMutex.RUnlock()
 
-	for pc.Running {
-		select {
-		case <-ticker.C:
-			// Simulate packet capture
-			// Real implementation would use pcap library
-		}
+	if !exists {
+		return nil, fmt.Errorf("capture '%s' not found", captureID)
 	}
+	return capture, nil
 }
 
-// StopCapture stops a packet capture session
+// StopCapture stops a packet capture session and waits for it to end. Its
+// packets can still be read and saved.
 func StopCapture(captureID string) error {
-	registryMutex.Lock()
-	defer registryMutex.Unlock()
-
-	capture, exists := captures[captureID]
-	if !exists {
-		return fmt.Errorf("capture '%s' not found", captureID)
+	capture, err := lookupCapture(captureID)
+	if err != nil {
+		return err
 	}
 
 	capture.mu.Lock()
-	capture.Running = false
+	if capture.Running {
+		capture.Running = false
+		close(capture.stop)
+	}
 	capture.mu.Unlock()
 
-	delete(captures, captureID)
+	<-capture.done
 	return nil
 }
 
-// GetPackets returns captured packets
+// GetPackets returns up to count of the oldest packets a capture kept,
+// or all of them if count is 0
 func GetPackets(captureID string, count int) ([]*Packet, error) {
-	registryMutex.RLock()
-	capture, exists := captures[captureID]
-	registryMutex.RUnlock()
-
-	if !exists {
-		return nil, fmt.Errorf("capture '%s' not found", captureID)
+	capture, err := lookupCapture(captureID)
+	if err != nil {
+		return nil, err
 	}
 
 	capture.mu.RLock()
@@ -80,46 +218,220 @@ func GetPackets(captureID string, count int) ([]*Packet, error) {
 		count = len(capture.Packets)
 	}
 
-	return capture.Packets[:count], nil
+	return append([]*Packet{}, capture.Packets[:count]...), nil
 }
 
-// AnalyzePacket analyzes a packet and returns detailed information
-func AnalyzePacket(packet *Packet) map[string]interface{} {
-	analysis := map[string]interface{}{
-		"timestamp": packet.Timestamp.Unix(),
-		"length":    packet.Length,
-		"src_ip":    packet.SrcIP,
-		"dst_ip":    packet.DstIP,
-		"src_port":  packet.SrcPort,
-		"dst_port":  packet.DstPort,
-		"protocol":  packet.Protocol,
+// SavePCAP saves captured packets to a PCAP file
+func SavePCAP(captureID, filename string) error {
+	packets, err := GetPackets(captureID, 0)
+	if err != nil {
+		return err
 	}
+	return WritePCAP(filename, packets)
+}
 
-	// In a real implementation, would include:
-	// - Layer analysis (Ethernet, IP, TCP/UDP)
-	// - Payload inspection
-	// - Protocol-specific details
-	// - Anomaly detection
+// DecodePacket decodes a frame of a link type, a pcap LINKTYPE_ value, as
+// it was captured at timestamp from a packet of length bytes on the wire
+func DecodePacket(data []byte, linkType int, timestamp time.Time, length int) *Packet {
+	ci := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(data), Length: length}
+	return decodePacket(data, ci, layers.LinkType(linkType))
+}
 
-	return analysis
+// decodePacket decodes the layers of a frame
+func decodePacket(data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) *Packet {
+	packet := &Packet{
+		Timestamp: ci.Timestamp,
+		Length:    ci.Length,
+		LinkType:  linkType,
+		Data:      data,
+	}
+	if packet.Length < len(data) {
+		packet.Length = len(data)
+	}
+
+	decoded := gopacket.NewPacket(data, linkType, gopacket.NoCopy)
+	for _, layer := range decoded.Layers() {
+		packet.Layers = append(packet.Layers, layer.LayerType().String())
+		switch l := layer.(type) {
+		case *layers.Ethernet:
+			packet.SrcMAC, packet.DstMAC = l.SrcMAC.String(), l.DstMAC.String()
+		case *layers.ARP:
+			packet.SrcIP, packet.DstIP = net.IP(l.SourceProtAddress).String(), net.IP(l.DstProtAddress).String()
+			packet.Protocol = "ARP"
+		case *layers.IPv4:
+			packet.SrcIP, packet.DstIP, packet.TTL = l.SrcIP.String(), l.DstIP.String(), int(l.TTL)
+			packet.Protocol = "IPv4"
+		case *layers.IPv6:
+			packet.SrcIP, packet.DstIP, packet.TTL = l.SrcIP.String(), l.DstIP.String(), int(l.HopLimit)
+			packet.Protocol = "IPv6"
+		case *layers.TCP:
+			packet.SrcPort, packet.DstPort = int(l.SrcPort), int(l.DstPort)
+			packet.TCPFlags = tcpFlags(l)
+			packet.Protocol = "TCP"
+		case *layers.UDP:
+			packet.SrcPort, packet.DstPort = int(l.SrcPort), int(l.DstPort)
+			packet.Protocol = "UDP"
+		case *layers.SCTP:
+			packet.SrcPort, packet.DstPort = int(l.SrcPort), int(l.DstPort)
+			packet.Protocol = "SCTP"
+		case *layers.ICMPv4:
+			packet.ICMPType, packet.ICMPCode = int(l.TypeCode.Type()), int(l.TypeCode.Code())
+			packet.Protocol = "ICMP"
+		case *layers.ICMPv6:
+			packet.ICMPType, packet.ICMPCode = int(l.TypeCode.Type()), int(l.TypeCode.Code())
+			packet.Protocol = "ICMPv6"
+		}
+	}
+	if transport := decoded.TransportLayer(); transport != nil {
+		packet.Payload = transport.LayerPayload()
+	}
+	if packet.Protocol == "" {
+		packet.Protocol = "Unknown"
+	}
+	return packet
 }
 
-// SavePCAP saves captured packets to a PCAP file
-func SavePCAP(captureID, filename string) error {
-	registryMutex.RLock()
-	capture, exists := captures[captureID]
-	registryMutex.RUnlock()
+// tcpFlags lists the flags set on a TCP segment, like "SYN,ACK"
+func tcpFlags(tcp *layers.TCP) string {
+	flags := []string{}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{tcp.SYN, "SYN"}, {tcp.ACK, "ACK"}, {tcp.FIN, "FIN"}, {tcp.RST, "RST"},
+		{tcp.PSH, "PSH"}, {tcp.URG, "URG"}, {tcp.ECE, "ECE"}, {tcp.CWR, "CWR"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return strings.Join(flags, ",")
+}
 
-	if !exists {
-		return fmt.Errorf("capture '%s' not found", captureID)
+// fileSource reads a pcap or pcapng file
+type fileSource struct {
+	file *os.File
+	packetReader
+}
+
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+func (s *fileSource) Close() {
+	s.file.Close()
+}
+
+// openPCAP opens a pcap or pcapng file, told apart by their magic numbers
+func openPCAP(filename string) (packetSource, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: not a pcap file", filename)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	capture.mu.RLock()
-	defer capture.mu.RUnlock()
+	var reader packetReader
+	if string(magic) == "\x0a\x0d\x0d\x0a" {
+		reader, err = pcapgo.NewNgReader(file, pcapgo.DefaultNgReaderOptions)
+	} else {
+		reader, err = pcapgo.NewReader(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &fileSource{file: file, packetReader: reader}, nil
+}
 
-	// In a real implementation, would write PCAP format
-	// For now, placeholder
-	return nil
+// ReadPCAP reads a pcap or pcapng file and calls handle with each packet
+// that matches the filter, until handle returns false
+func ReadPCAP(filename, filter string, handle func(*Packet) bool) error {
+	compiled, err := CompileFilter(filter)
+	if err != nil {
+		return err
+	}
+	src, err := openPCAP(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return readPackets(src, compiled, 0, time.Time{}, nil, handle)
+}
+
+// WritePCAP writes packets to a pcap file. They must all be of the same
+// link type.
+func WritePCAP(filename string, packets []*Packet) error {
+	linkType := layers.LinkTypeEthernet
+	if len(packets) > 0 {
+		linkType = packets[0].LinkType
+	}
+	snaplen := 65535
+	for _, packet := range packets {
+		if packet.LinkType != linkType {
+			return fmt.Errorf("cannot write packets of link types %s and %s to one pcap file", linkType, packet.LinkType)
+		}
+		if len(packet.Data) > snaplen {
+			snaplen = len(packet.Data)
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := pcapgo.NewWriter(file)
+	if err := w.WriteFileHeader(uint32(snaplen), linkType); err != nil {
+		file.Close()
+		return err
+	}
+	for _, packet := range packets {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     packet.Timestamp,
+			CaptureLength: len(packet.Data),
+			Length:        packet.Length,
+		}
+		if ci.Length < ci.CaptureLength {
+			ci.Length = ci.CaptureLength
+		}
+		if err := w.WritePacket(ci, packet.Data); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// AnalyzePacket analyzes a packet and returns detailed information: the
+// fields of PacketToMap, the header and payload size of each layer and a
+// dump of the decoded layers
+func AnalyzePacket(packet *Packet) map[string]interface{} {
+	analysis := PacketToMap(packet)
+
+	decoded := gopacket.NewPacket(packet.Data, packet.LinkType, gopacket.Default)
+	details := []interface{}{}
+	for _, layer := range decoded.Layers() {
+		details = append(details, map[string]interface{}{
+			"name":           layer.LayerType().String(),
+			"header_length":  len(layer.LayerContents()),
+			"payload_length": len(layer.LayerPayload()),
+		})
+	}
+	analysis["layer_details"] = details
+	analysis["dump"] = decoded.Dump()
+	if err := decoded.ErrorLayer(); err != nil {
+		analysis["error"] = err.Error().Error()
+	}
+
+	return analysis
 }
 
 // CaptureToMap converts a PacketCapture to a map for VM
@@ -127,24 +439,48 @@ func CaptureToMap(capture *PacketCapture) map[string]interface{} {
 	capture.mu.RLock()
 	defer capture.mu.RUnlock()
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"id":            capture.ID,
 		"interface":     capture.Interface,
 		"filter":        capture.Filter,
+		"link_type":     int(capture.LinkType),
 		"running":       capture.Running,
 		"packets_count": len(capture.Packets),
+		"dropped":       capture.Dropped,
 	}
+	if capture.Err != nil {
+		result["error"] = capture.Err.Error()
+	}
+	return result
 }
 
-// PacketToMap converts a Packet to a map for VM
+// PacketToMap converts a Packet to a map for VM. The timestamp is in
+// seconds with microseconds, as pcap files keep it.
 func PacketToMap(packet *Packet) map[string]interface{} {
-	return map[string]interface{}{
-		"timestamp": packet.Timestamp.Unix(),
+	layerNames := make([]interface{}, len(packet.Layers))
+	for i, name := range packet.Layers {
+		layerNames[i] = name
+	}
+	result := map[string]interface{}{
+		"timestamp": float64(packet.Timestamp.UnixMicro()) / 1e6,
 		"length":    packet.Length,
+		"link_type": int(packet.LinkType),
+		"layers":    layerNames,
+		"src_mac":   packet.SrcMAC,
+		"dst_mac":   packet.DstMAC,
 		"src_ip":    packet.SrcIP,
 		"dst_ip":    packet.DstIP,
+		"ttl":       packet.TTL,
 		"src_port":  packet.SrcPort,
 		"dst_port":  packet.DstPort,
 		"protocol":  packet.Protocol,
+		"tcp_flags": packet.TCPFlags,
+		"payload":   packet.Payload,
+		"data":      packet.Data,
+	}
+	if packet.Protocol == "ICMP" || packet.Protocol == "ICMPv6" {
+		result["icmp_type"] = packet.ICMPType
+		result["icmp_code"] = packet.ICMPCode
 	}
+	return result
 }
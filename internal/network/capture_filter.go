@@ -0,0 +1,365 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PacketFilter matches decoded packets against a filter expression in the
+// syntax of tcpdump. It is used where the kernel does not filter: on
+// packet sockets without libpcap and when reading pcap files. It knows the
+// primitives
+//
+//	ether, ip, ip6, arp, vlan, tcp, udp, sctp, icmp, icmp6
+//	[src|dst] host ADDR, [src|dst] net CIDR
+//	[src|dst] port PORT, [src|dst] portrange LOW-HIGH
+//	ether [src|dst] [host] MAC, less LEN, greater LEN
+//
+// joined with and (&&), or (||), not (!) and parentheses. A protocol may
+// qualify the primitive after it, as in "tcp dst port 443".
+type PacketFilter struct {
+	expr  string
+	match func(*Packet) bool
+}
+
+// filterProtocols maps the protocols of filter expressions to the layers
+// that carry them
+var filterProtocols = map[string]string{
+	"ether": "Ethernet",
+	"ip":    "IPv4",
+	"ip6":   "IPv6",
+	"arp":   "ARP",
+	"vlan":  "Dot1Q",
+	"tcp":   "TCP",
+	"udp":   "UDP",
+	"sctp":  "SCTP",
+	"icmp":  "ICMPv4",
+	"icmp6": "ICMPv6",
+}
+
+// CompileFilter compiles a filter expression. An empty expression matches
+// every packet.
+func CompileFilter(expr string) (*PacketFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	p := &filterParser{tokens: tokenizeFilter(expr)}
+	match, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	return &PacketFilter{expr: expr, match: match}, nil
+}
+
+// Match reports whether a packet passes the filter. A nil filter passes
+// every packet.
+func (f *PacketFilter) Match(packet *Packet) bool {
+	return f == nil || f.match(packet)
+}
+
+func (f *PacketFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// tokenizeFilter splits an expression into words, parentheses and the
+// operators !, && and ||
+func tokenizeFilter(expr string) []string {
+	tokens := []string{}
+	word := strings.Builder{}
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '(' || c == ')' || c == '!':
+			flush()
+			tokens = append(tokens, string(c))
+		case (c == '&' || c == '|') && i+1 < len(expr) && expr[i+1] == c:
+			flush()
+			tokens = append(tokens, expr[i:i+2])
+			i++
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// filterParser parses the tokens of an expression into a matcher
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) or() (func(*Packet) bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(packet *Packet) bool { return l(packet) || right(packet) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (func(*Packet) bool, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(packet *Packet) bool { return l(packet) && right(packet) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (func(*Packet) bool, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(packet *Packet) bool { return !inner(packet) }, nil
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.primitive()
+}
+
+func (p *filterParser) primitive() (func(*Packet) bool, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "less", "greater":
+		n, err := strconv.Atoi(p.next())
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s needs a length", tok)
+		}
+		if tok == "less" {
+			return func(packet *Packet) bool { return packet.Length <= n }, nil
+		}
+		return func(packet *Packet) bool { return packet.Length >= n }, nil
+	case "ether":
+		switch p.peek() {
+		case "src", "dst", "host":
+			return p.etherHost()
+		}
+	case "src", "dst", "host", "net", "port", "portrange":
+		p.pos--
+		return p.qualified()
+	}
+
+	layer, ok := filterProtocols[tok]
+	if !ok {
+		return nil, fmt.Errorf("unknown primitive %q", tok)
+	}
+	isProtocol := func(packet *Packet) bool { return hasLayer(packet, layer) }
+	switch p.peek() {
+	case "src", "dst", "host", "net", "port", "portrange":
+		qualified, err := p.qualified()
+		if err != nil {
+			return nil, err
+		}
+		return func(packet *Packet) bool { return isProtocol(packet) && qualified(packet) }, nil
+	}
+	return isProtocol, nil
+}
+
+// etherHost parses the rest of "ether [src|dst] [host] MAC"
+func (p *filterParser) etherHost() (func(*Packet) bool, error) {
+	dir := ""
+	if p.peek() == "src" || p.peek() == "dst" {
+		dir = p.next()
+	}
+	if p.peek() == "host" {
+		p.next()
+	}
+	arg := p.next()
+	mac, err := net.ParseMAC(arg)
+	if err != nil {
+		return nil, fmt.Errorf("ether host needs a MAC address, got %q", arg)
+	}
+	want := mac.String()
+	return func(packet *Packet) bool {
+		return matchDir(dir, packet.SrcMAC == want, packet.DstMAC == want)
+	}, nil
+}
+
+// qualified parses "[src|dst] host|net|port|portrange VALUE", where a
+// direction alone means host
+func (p *filterParser) qualified() (func(*Packet) bool, error) {
+	dir := ""
+	if p.peek() == "src" || p.peek() == "dst" {
+		dir = p.next()
+	}
+	kind := "host"
+	switch p.peek() {
+	case "host", "net", "port", "portrange":
+		kind = p.next()
+	}
+	arg := p.next()
+	if arg == "" || arg == "(" || arg == ")" {
+		return nil, fmt.Errorf("%s needs a value", kind)
+	}
+
+	switch kind {
+	case "host":
+		ips := []net.IP{net.ParseIP(arg)}
+		if ips[0] == nil {
+			var err error
+			if ips, err = net.LookupIP(arg); err != nil {
+				return nil, fmt.Errorf("unknown host %q", arg)
+			}
+		}
+		want := map[string]bool{}
+		for _, ip := range ips {
+			want[ip.String()] = true
+		}
+		return func(packet *Packet) bool {
+			return matchDir(dir, want[packet.SrcIP], want[packet.DstIP])
+		}, nil
+	case "net":
+		_, ipNet, err := net.ParseCIDR(arg)
+		if err != nil {
+			ip := net.ParseIP(arg)
+			if ip == nil {
+				return nil, fmt.Errorf("net needs a CIDR network, got %q", arg)
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		contains := func(addr string) bool {
+			ip := net.ParseIP(addr)
+			return ip != nil && ipNet.Contains(ip)
+		}
+		return func(packet *Packet) bool {
+			return matchDir(dir, contains(packet.SrcIP), contains(packet.DstIP))
+		}, nil
+	case "port":
+		port, err := parseFilterPort(arg)
+		if err != nil {
+			return nil, err
+		}
+		return portMatcher(dir, port, port), nil
+	default:
+		low, high, found := strings.Cut(arg, "-")
+		if !found {
+			return nil, fmt.Errorf("portrange needs LOW-HIGH, got %q", arg)
+		}
+		from, err := parseFilterPort(low)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseFilterPort(high)
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			from, to = to, from
+		}
+		return portMatcher(dir, from, to), nil
+	}
+}
+
+// parseFilterPort reads a port number or service name, such as https
+func parseFilterPort(arg string) (int, error) {
+	port, err := strconv.Atoi(arg)
+	if err != nil {
+		if port, err = net.LookupPort("tcp", arg); err != nil {
+			return 0, fmt.Errorf("unknown port %q", arg)
+		}
+	}
+	if port < 0 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
+
+// portMatcher matches TCP, UDP and SCTP packets with a port in [from, to]
+func portMatcher(dir string, from, to int) func(*Packet) bool {
+	return func(packet *Packet) bool {
+		if !hasLayer(packet, "TCP") && !hasLayer(packet, "UDP") && !hasLayer(packet, "SCTP") {
+			return false
+		}
+		return matchDir(dir,
+			packet.SrcPort >= from && packet.SrcPort <= to,
+			packet.DstPort >= from && packet.DstPort <= to)
+	}
+}
+
+// matchDir combines the source and destination matches of a primitive
+// for its direction: either of them without one
+func matchDir(dir string, src, dst bool) bool {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	}
+	return src || dst
+}
+
+func hasLayer(packet *Packet, name string) bool {
+	for _, layer := range packet.Layers {
+		if layer == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+//go:build pcap
+
+// internal/network/capture_libpcap.go
+package network
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// pcapSource captures with libpcap, which compiles the filter to BPF and
+// runs it in the kernel
+type pcapSource struct {
+	*pcap.Handle
+}
+
+// openLive opens an interface with libpcap and sets its filter
+func openLive(iface string, snaplen int, promisc bool, filter string) (packetSource, bool, error) {
+	if iface == "" {
+		iface = "any"
+	}
+	handle, err := pcap.OpenLive(iface, int32(snaplen), promisc, capturePoll)
+	if err != nil {
+		return nil, false, fmt.Errorf("capture on %s: %v", iface, err)
+	}
+	if filter != "" {
+		if err := handle.SetBPFFilter(filter); err != nil {
+			handle.Close()
+			return nil, false, fmt.Errorf("invalid filter %q: %v", filter, err)
+		}
+	}
+	return pcapSource{handle}, true, nil
+}
+
+func (s pcapSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.Handle.ReadPacketData()
+	if err == pcap.NextErrorTimeoutExpired {
+		return nil, ci, errCaptureTimeout
+	}
+	return data, ci, err
+}
@@ -0,0 +1,138 @@
+//go:build linux && !pcap
+
+// internal/network/capture_linux.go
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+// socketSource captures from a packet socket. Frames of a named interface
+// keep their link header; on "any" the kernel strips it and a Linux
+// cooked header is put in its place, as libpcap does.
+type socketSource struct {
+	fd       int
+	buf      []byte
+	any      bool
+	linkType layers.LinkType
+	loopback map[int]bool // Indexes of loopback interfaces
+}
+
+// openLive opens a packet socket on an interface, which needs root or
+// CAP_NET_RAW. The filter is left to PacketFilter.
+func openLive(iface string, snaplen int, promisc bool, filter string) (packetSource, bool, error) {
+	any := iface == "any" || iface == ""
+	index := 0
+	linkType := layers.LinkTypeLinuxSLL
+	if !any {
+		intf, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, false, fmt.Errorf("capture: %v", err)
+		}
+		index = intf.Index
+		linkType = interfaceLinkType(iface)
+	}
+
+	sockType := unix.SOCK_RAW
+	if any {
+		sockType = unix.SOCK_DGRAM
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, sockType, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, false, fmt.Errorf("capture on %s: %v (packet capture needs root or CAP_NET_RAW)", iface, os.NewSyscallError("socket", err))
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: index}); err != nil {
+		unix.Close(fd)
+		return nil, false, fmt.Errorf("capture on %s: %v", iface, err)
+	}
+	if promisc && !any {
+		mreq := unix.PacketMreq{Ifindex: int32(index), Type: unix.PACKET_MR_PROMISC}
+		if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+			unix.Close(fd)
+			return nil, false, fmt.Errorf("capture on %s: promiscuous mode: %v", iface, err)
+		}
+	}
+	timeout := unix.NsecToTimeval(capturePoll.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, false, err
+	}
+	loopback := map[int]bool{}
+	if intfs, err := net.Interfaces(); err == nil {
+		for _, intf := range intfs {
+			if intf.Flags&net.FlagLoopback != 0 {
+				loopback[intf.Index] = true
+			}
+		}
+	}
+	return &socketSource{fd: fd, buf: make([]byte, snaplen), any: any, linkType: linkType, loopback: loopback}, false, nil
+}
+
+// interfaceLinkType returns the link type of an interface from its ARP
+// hardware type: tunnels carry bare IP packets, the others Ethernet frames
+func interfaceLinkType(iface string) layers.LinkType {
+	data, err := os.ReadFile("/sys/class/net/" + iface + "/type")
+	if err == nil && strings.TrimSpace(string(data)) == "65534" {
+		return layers.LinkTypeRaw
+	}
+	return layers.LinkTypeEthernet
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func (s *socketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	// MSG_TRUNC returns the length on the wire even if buf is shorter
+	n, from, err := unix.Recvfrom(s.fd, s.buf, unix.MSG_TRUNC)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, gopacket.CaptureInfo{}, errCaptureTimeout
+	}
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, os.NewSyscallError("recvfrom", err)
+	}
+	// A packet sent on a loopback interface is seen going out and coming
+	// back in; like libpcap, only the second is kept
+	ll, _ := from.(*unix.SockaddrLinklayer)
+	if ll != nil && ll.Pkttype == unix.PACKET_OUTGOING && s.loopback[ll.Ifindex] {
+		return s.ReadPacketData()
+	}
+	captured := n
+	if captured > len(s.buf) {
+		captured = len(s.buf)
+	}
+	data := append([]byte{}, s.buf[:captured]...)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: captured, Length: n}
+
+	if s.any {
+		header := make([]byte, 16)
+		if ll != nil {
+			binary.BigEndian.PutUint16(header[0:], uint16(ll.Pkttype))
+			binary.BigEndian.PutUint16(header[2:], ll.Hatype)
+			binary.BigEndian.PutUint16(header[4:], uint16(ll.Halen))
+			copy(header[6:14], ll.Addr[:])
+			binary.BigEndian.PutUint16(header[14:], htons(ll.Protocol))
+		}
+		data = append(header, data...)
+		ci.CaptureLength += len(header)
+		ci.Length += len(header)
+	}
+	return data, ci, nil
+}
+
+func (s *socketSource) LinkType() layers.LinkType {
+	return s.linkType
+}
+
+func (s *socketSource) Close() {
+	unix.Close(s.fd)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !pcap
+
+// internal/network/capture_other.go
+package network
+
+import "fmt"
+
+// Live capture needs libpcap outside Linux. pcap files can still be read
+// and written.
+func openLive(iface string, snaplen int, promisc bool, filter string) (packetSource, bool, error) {
+	return nil, false, fmt.Errorf("capture on %s: live capture needs a build with libpcap (go build -tags pcap)", iface)
+}
@@ -0,0 +1,187 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testFrame builds an Ethernet frame carrying an IPv4 TCP segment, or a
+// UDP datagram if tcp is false
+func testFrame(t *testing.T, src, dst string, srcPort, dstPort int, tcp bool, payload string) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	var transport gopacket.SerializableLayer
+	if tcp {
+		ip.Protocol = layers.IPProtocolTCP
+		segment := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), SYN: true, ACK: true, Window: 1024}
+		segment.SetNetworkLayerForChecksum(ip)
+		transport = segment
+	} else {
+		ip.Protocol = layers.IPProtocolUDP
+		datagram := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		datagram.SetNetworkLayerForChecksum(ip)
+		transport = datagram
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodePacket(t *testing.T) {
+	data := testFrame(t, "10.0.0.1", "10.0.0.2", 40000, 443, true, "hello")
+	packet := DecodePacket(data, int(layers.LinkTypeEthernet), time.Unix(100, 0), 0)
+
+	if packet.Protocol != "TCP" || packet.SrcIP != "10.0.0.1" || packet.DstIP != "10.0.0.2" ||
+		packet.SrcPort != 40000 || packet.DstPort != 443 || packet.TTL != 64 {
+		t.Errorf("got %+v", packet)
+	}
+	if packet.TCPFlags != "SYN,ACK" || string(packet.Payload) != "hello" || packet.Length != len(data) {
+		t.Errorf("flags %q, payload %q, length %d", packet.TCPFlags, packet.Payload, packet.Length)
+	}
+	if strings.Join(packet.Layers, " ") != "Ethernet IPv4 TCP Payload" || packet.SrcMAC != "02:00:00:00:00:01" {
+		t.Errorf("layers %v, src mac %s", packet.Layers, packet.SrcMAC)
+	}
+}
+
+func TestPacketFilter(t *testing.T) {
+	web := DecodePacket(testFrame(t, "10.0.0.1", "192.168.1.5", 40000, 443, true, ""), 1, time.Now(), 0)
+	dns := DecodePacket(testFrame(t, "192.168.1.5", "8.8.8.8", 5353, 53, false, "a query longer than the web packet"), 1, time.Now(), 0)
+
+	tests := []struct {
+		filter   string
+		web, dns bool
+	}{
+		{"", true, true},
+		{"tcp", true, false},
+		{"udp port 53", false, true},
+		{"port domain", false, true},
+		{"dst port 443", true, false},
+		{"src port 443", false, false},
+		{"tcp dst port 443", true, false},
+		{"host 192.168.1.5", true, true},
+		{"src host 192.168.1.5", false, true},
+		{"dst 8.8.8.8", false, true},
+		{"net 10.0.0.0/8", true, false},
+		{"src net 192.168.0.0/16 and not tcp", false, true},
+		{"portrange 50-60", false, true},
+		{"tcp or udp", true, true},
+		{"ip and !(port 53 || port 443)", false, false},
+		{"ether src 02:00:00:00:00:01", true, true},
+		{"ip6", false, false},
+		{"greater 61", false, true},
+		{"less 60", true, false},
+	}
+	for _, tt := range tests {
+		filter, err := CompileFilter(tt.filter)
+		if err != nil {
+			t.Errorf("%q: %v", tt.filter, err)
+			continue
+		}
+		if got := filter.Match(web); got != tt.web {
+			t.Errorf("%q matched the web packet: %v, want %v", tt.filter, got, tt.web)
+		}
+		if got := filter.Match(dns); got != tt.dns {
+			t.Errorf("%q matched the dns packet: %v, want %v", tt.filter, got, tt.dns)
+		}
+	}
+
+	for _, bad := range []string{"tcp port", "port 70000", "(tcp", "tcp and", "bogus", "tcp[13] & 2 != 0", "portrange 5"} {
+		if _, err := CompileFilter(bad); err == nil {
+			t.Errorf("%q: compiled", bad)
+		}
+	}
+}
+
+func TestPCAPRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	stamp := time.Unix(1700000000, 123456000)
+	packets := []*Packet{
+		DecodePacket(testFrame(t, "10.0.0.1", "10.0.0.2", 1000, 80, true, "GET /"), 1, stamp, 0),
+		DecodePacket(testFrame(t, "10.0.0.2", "10.0.0.1", 53, 1000, false, "answer"), 1, stamp.Add(time.Second), 0),
+	}
+	if err := WritePCAP(path, packets); err != nil {
+		t.Fatal(err)
+	}
+
+	var read []*Packet
+	err := ReadPCAP(path, "udp", func(packet *Packet) bool {
+		read = append(read, packet)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 1 || read[0].SrcPort != 53 || string(read[0].Payload) != "answer" ||
+		!read[0].Timestamp.Equal(stamp.Add(time.Second)) || read[0].LinkType != layers.LinkTypeEthernet {
+		t.Errorf("got %+v", read)
+	}
+
+	mixed := append(packets, DecodePacket([]byte{0x45}, int(layers.LinkTypeRaw), stamp, 0))
+	if err := WritePCAP(path, mixed); err == nil {
+		t.Error("wrote packets of two link types to one file")
+	}
+	if err := ReadPCAP(filepath.Join(t.TempDir(), "missing.pcap"), "", nil); err == nil {
+		t.Error("read a missing file")
+	}
+}
+
+func TestCaptureLoopback(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	capture, err := StartCapture("lo", CaptureOptions{Filter: fmt.Sprintf("udp and dst port %d", port)})
+	if err != nil {
+		t.Skipf("cannot capture here: %v", err)
+	}
+	sender, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i < 3; i++ {
+		sender.Write([]byte("captured"))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var packets []*Packet
+	for time.Now().Before(deadline) {
+		if packets, _ = GetPackets(capture.ID, 0); len(packets) >= 3 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := StopCapture(capture.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 || packets[0].DstPort != port || string(packets[0].Payload) != "captured" {
+		t.Fatalf("got %d packets: %+v", len(packets), packets)
+	}
+
+	path := filepath.Join(t.TempDir(), "lo.pcap")
+	if err := SavePCAP(capture.ID, path); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	if err := ReadPCAP(path, "", func(*Packet) bool { count++; return true }); err != nil || count != 3 {
+		t.Errorf("read back %d packets: %v", count, err)
+	}
+}
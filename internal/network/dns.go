@@ -0,0 +1,536 @@
+// Package network - DNS resolver implementation
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSOptions selects the server a DNS query goes to. Without a server or
+// DoH URL the system resolver answers.
+type DNSOptions struct {
+	Server  string        // Host or host:port of a DNS server, port 53 by default
+	DoH     string        // URL of a DNS-over-HTTPS server, used instead of Server
+	TCP     bool          // Query Server over TCP rather than UDP
+	Timeout time.Duration // 5 seconds if 0
+}
+
+// custom reports whether the options name a server
+func (o DNSOptions) custom() bool {
+	return o.Server != "" || o.DoH != ""
+}
+
+// Resolver returns where the options send queries: the DoH URL, the
+// host:port of Server, or "" for the system resolver
+func (o DNSOptions) Resolver() string {
+	switch {
+	case o.DoH != "":
+		return o.DoH
+	case o.Server != "":
+		return dnsServer(o.Server)
+	}
+	return ""
+}
+
+func (o DNSOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return 5 * time.Second
+}
+
+// DNSRecord is a record of a DNS answer. Value is the record as
+// DNSLookup returns it; the fields after it are set for the record types
+// they belong to.
+type DNSRecord struct {
+	Name  string
+	Type  string
+	TTL   uint32
+	Value string
+
+	Priority int    // MX preference, SRV priority
+	Weight   int    // SRV
+	Port     int    // SRV
+	Target   string // MX and SRV host
+	Flags    int    // CAA
+	Tag      string // CAA property, such as issue or iodef
+	Data     string // CAA property value
+}
+
+// dnsTypes are the record types that can be queried
+var dnsTypes = map[string]uint16{
+	"A":     1,
+	"NS":    2,
+	"CNAME": 5,
+	"PTR":   12,
+	"MX":    15,
+	"TXT":   16,
+	"AAAA":  28,
+	"SRV":   33,
+	"CAA":   257,
+}
+
+// DNSLookup resolves the records of a type and returns them as strings:
+// addresses, names ending in a dot, "host:preference" for MX,
+// "priority weight port target" for SRV and `flags tag "value"` for CAA.
+// PTR lookups take an IP address as well as a reverse name.
+func (n *NetworkModule) DNSLookup(hostname string, recordType string, opts DNSOptions) ([]string, error) {
+	recordType = strings.ToUpper(recordType)
+	if _, ok := dnsTypes[recordType]; !ok {
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	if !opts.custom() && recordType != "CAA" {
+		return systemLookup(hostname, recordType, opts.timeout())
+	}
+	records, err := n.DNSQuery(hostname, recordType, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := []string{}
+	for _, record := range records {
+		results = append(results, record.Value)
+	}
+	return results, nil
+}
+
+// systemLookup resolves records with the system resolver, which also reads
+// the hosts file
+func systemLookup(hostname string, recordType string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resolver := net.DefaultResolver
+	results := []string{}
+
+	switch recordType {
+	case "A", "AAAA":
+		ips, err := resolver.LookupIP(ctx, "ip", hostname)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (recordType == "A") {
+				results = append(results, ip.String())
+			}
+		}
+	case "MX":
+		mxRecords, err := resolver.LookupMX(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxRecords {
+			results = append(results, fmt.Sprintf("%s:%d", mx.Host, mx.Pref))
+		}
+	case "TXT":
+		txtRecords, err := resolver.LookupTXT(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, txtRecords...)
+	case "NS":
+		nsRecords, err := resolver.LookupNS(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nsRecords {
+			results = append(results, ns.Host)
+		}
+	case "CNAME":
+		cname, err := resolver.LookupCNAME(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, cname)
+	case "PTR":
+		if net.ParseIP(hostname) == nil {
+			return nil, fmt.Errorf("PTR lookups with the system resolver need an IP address, got %s", hostname)
+		}
+		names, err := resolver.LookupAddr(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, names...)
+	case "SRV":
+		_, srvRecords, err := resolver.LookupSRV(ctx, "", "", hostname)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvRecords {
+			results = append(results, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+		}
+	}
+	return results, nil
+}
+
+// DNSQuery asks a DNS server for the records of a type, with their TTLs.
+// Without a server in opts it asks the first nameserver of
+// /etc/resolv.conf. Records of other types in the answer, such as the
+// CNAMEs that led to an address, are left out.
+func (n *NetworkModule) DNSQuery(hostname string, recordType string, opts DNSOptions) ([]DNSRecord, error) {
+	recordType = strings.ToUpper(recordType)
+	qtype, ok := dnsTypes[recordType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	if qtype == dnsTypes["PTR"] && net.ParseIP(hostname) != nil {
+		hostname = ReverseName(hostname)
+	}
+
+	var id uint16
+	if opts.DoH == "" {
+		id = uint16(rand.Intn(1 << 16))
+	}
+	query, err := dnsQueryMessage(id, hostname, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout())
+	defer cancel()
+	var response []byte
+	if opts.DoH != "" {
+		response, err = dohExchange(ctx, opts.DoH, query)
+	} else {
+		response, err = dnsExchange(ctx, dnsServer(opts.Server), query, opts.TCP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", hostname, err)
+	}
+
+	records, err := parseDNSResponse(response, id)
+	if err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", hostname, err)
+	}
+	answers := []DNSRecord{}
+	for _, record := range records {
+		if record.Type == recordType {
+			answers = append(answers, record)
+		}
+	}
+	return answers, nil
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of an IP address,
+// or ip itself if it is not one
+func ReverseName(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ip
+	}
+	var b strings.Builder
+	if v4 := addr.To4(); v4 != nil {
+		for i := 3; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(v4[i])))
+			b.WriteString(".")
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String()
+	}
+	const hex = "0123456789abcdef"
+	for i := len(addr) - 1; i >= 0; i-- {
+		b.WriteByte(hex[addr[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[addr[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// dnsServer returns the host:port of server, or of the system's first
+// nameserver if server is empty
+func dnsServer(server string) string {
+	if server == "" {
+		server = "127.0.0.1"
+		if f, err := os.Open("/etc/resolv.conf"); err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 2 && fields[0] == "nameserver" {
+					server = fields[1]
+					break
+				}
+			}
+			f.Close()
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// dnsExchange sends a query to server over UDP, or TCP if tcp is set or
+// the UDP answer was truncated, and returns the response
+func dnsExchange(ctx context.Context, server string, query []byte, tcp bool) ([]byte, error) {
+	var dialer net.Dialer
+	if !tcp {
+		conn, err := dialer.DialContext(ctx, "udp", server)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		for {
+			size, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// Skip stray datagrams that do not answer this query
+			if size < 12 || !bytes.Equal(buf[:2], query[:2]) {
+				continue
+			}
+			if buf[2]&0x02 == 0 {
+				return buf[:size], nil
+			}
+			break // Truncated, so ask again over TCP
+		}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// dohExchange posts a query to a DNS-over-HTTPS server (RFC 8484) and
+// returns the response
+func dohExchange(ctx context.Context, url string, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+// dnsQueryMessage builds a recursive query for one name and type
+func dnsQueryMessage(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // Recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)      // One question
+
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("invalid DNS name: %s", name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name: %s", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // Class IN
+	return msg, nil
+}
+
+// dnsRcodes are the messages of the response codes of a failed query
+var dnsRcodes = map[byte]string{
+	1: "format error",
+	2: "server failure",
+	3: "no such host",
+	4: "not implemented",
+	5: "refused",
+}
+
+var errShortDNSMessage = errors.New("malformed DNS response")
+
+// parseDNSResponse returns the records of the answer section of a response
+// to the query with the given id
+func parseDNSResponse(msg []byte, id uint16) ([]DNSRecord, error) {
+	if len(msg) < 12 {
+		return nil, errShortDNSMessage
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id || msg[2]&0x80 == 0 {
+		return nil, errors.New("DNS response does not answer the query")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		if text, ok := dnsRcodes[rcode]; ok {
+			return nil, errors.New(text)
+		}
+		return nil, fmt.Errorf("DNS error code %d", rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+
+	records := []DNSRecord{}
+	for i := 0; i < answers; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, errShortDNSMessage
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, errShortDNSMessage
+		}
+		record := DNSRecord{Name: name, TTL: ttl}
+		known, err := decodeRData(&record, rtype, msg, off, length)
+		if err != nil {
+			return nil, err
+		}
+		if known {
+			records = append(records, record)
+		}
+		off += length
+	}
+	return records, nil
+}
+
+// decodeRData fills in the type and value of a record from its data at
+// msg[off:off+length], and reports whether the type is one that is queried
+func decodeRData(record *DNSRecord, rtype uint16, msg []byte, off, length int) (bool, error) {
+	data := msg[off : off+length]
+	for name, t := range dnsTypes {
+		if t == rtype {
+			record.Type = name
+		}
+	}
+
+	var err error
+	switch record.Type {
+	case "A", "AAAA":
+		if len(data) != net.IPv4len && len(data) != net.IPv6len {
+			return false, errShortDNSMessage
+		}
+		record.Value = net.IP(data).String()
+	case "NS", "CNAME", "PTR":
+		record.Value, _, err = readDNSName(msg, off)
+	case "MX":
+		if len(data) < 3 {
+			return false, errShortDNSMessage
+		}
+		record.Priority = int(binary.BigEndian.Uint16(data))
+		record.Target, _, err = readDNSName(msg, off+2)
+		record.Value = fmt.Sprintf("%s:%d", record.Target, record.Priority)
+	case "TXT":
+		var parts []string
+		for i := 0; i < len(data); {
+			size := int(data[i])
+			if i+1+size > len(data) {
+				return false, errShortDNSMessage
+			}
+			parts = append(parts, string(data[i+1:i+1+size]))
+			i += 1 + size
+		}
+		record.Value = strings.Join(parts, "")
+	case "SRV":
+		if len(data) < 7 {
+			return false, errShortDNSMessage
+		}
+		record.Priority = int(binary.BigEndian.Uint16(data))
+		record.Weight = int(binary.BigEndian.Uint16(data[2:]))
+		record.Port = int(binary.BigEndian.Uint16(data[4:]))
+		record.Target, _, err = readDNSName(msg, off+6)
+		record.Value = fmt.Sprintf("%d %d %d %s", record.Priority, record.Weight, record.Port, record.Target)
+	case "CAA":
+		if len(data) < 2 || 2+int(data[1]) > len(data) {
+			return false, errShortDNSMessage
+		}
+		record.Flags = int(data[0])
+		record.Tag = string(data[2 : 2+int(data[1])])
+		record.Data = string(data[2+int(data[1]):])
+		record.Value = fmt.Sprintf("%d %s %q", record.Flags, record.Tag, record.Data)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// readDNSName reads the possibly compressed name at msg[off:] and returns
+// it with a trailing dot, and the offset just past it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errShortDNSMessage
+		}
+		size := int(msg[off])
+		switch {
+		case size == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case size&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 32 {
+				return "", 0, errShortDNSMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case size > 63 || off+1+size > len(msg):
+			return "", 0, errShortDNSMessage
+		default:
+			labels = append(labels, string(msg[off+1:off+1+size]))
+			off += 1 + size
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package network
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testZone answers queries for the fake DNS servers, by name and type
+var testZone = map[string]map[uint16][][]byte{
+	"example.test.": {
+		1:   {{192, 0, 2, 1}},
+		15:  {mxData(10, "mail.example.test.")},
+		16:  {{5, 'h', 'e', 'l', 'l', 'o', 6, ' ', 'w', 'o', 'r', 'l', 'd'}},
+		257: {{128, 5, 'i', 's', 's', 'u', 'e', 'c', 'a', '.', 't', 'e', 's', 't'}},
+	},
+	"_ldap._tcp.example.test.": {
+		33: {append([]byte{0, 1, 0, 5, 1, 133}, encodeName("dc.example.test.")...)},
+	},
+	"1.2.0.192.in-addr.arpa.": {
+		12: {encodeName("host.example.test.")},
+	},
+}
+
+func encodeName(name string) []byte {
+	msg, _ := dnsQueryMessage(0, name, 0)
+	return msg[12 : len(msg)-4]
+}
+
+func mxData(pref uint16, host string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, pref), encodeName(host)...)
+}
+
+// testAnswer answers a query from testZone. The answer's names point back
+// at the question, as servers compress them. If limit is above 0 and the
+// answer is longer, it is truncated.
+func testAnswer(query []byte, limit int) []byte {
+	name, end, err := readDNSName(query, 12)
+	if err != nil {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end:])
+	msg := append([]byte{}, query[:end+4]...)
+	msg[2] |= 0x80
+	records, ok := testZone[name]
+	if !ok {
+		msg[3] |= 3
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records[qtype])))
+	for _, data := range records[qtype] {
+		msg = append(msg, 0xc0, 12)
+		msg = binary.BigEndian.AppendUint16(msg, qtype)
+		msg = binary.BigEndian.AppendUint16(msg, 1)
+		msg = binary.BigEndian.AppendUint32(msg, 300)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+		msg = append(msg, data...)
+	}
+	if limit > 0 && len(msg) > limit {
+		msg = msg[:12]
+		msg[2] |= 0x02
+		binary.BigEndian.PutUint16(msg[4:], 0)
+		binary.BigEndian.PutUint16(msg[6:], 0)
+	}
+	return msg
+}
+
+// startTestDNSServer serves testZone over UDP, with answers longer than
+// udpLimit truncated, and over TCP on the same port
+func startTestDNSServer(t *testing.T, udpLimit int) string {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("cannot listen on TCP port of %s: %v", udp.LocalAddr(), err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			size, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(testAnswer(buf[:size], udpLimit), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var size [2]byte
+			if _, err := io.ReadFull(conn, size[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					answer := testAnswer(query, 0)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return udp.LocalAddr().String()
+}
+
+func TestDNSQuery(t *testing.T) {
+	n := NewNetworkModule()
+	opts := DNSOptions{Server: startTestDNSServer(t, 0), Timeout: 2 * time.Second}
+
+	records, err := n.DNSQuery("example.test", "mx", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DNSRecord{{Name: "example.test.", Type: "MX", TTL: 300, Value: "mail.example.test.:10", Priority: 10, Target: "mail.example.test."}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("MX = %+v, want %+v", records, want)
+	}
+
+	records, err = n.DNSQuery("_ldap._tcp.example.test", "SRV", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []DNSRecord{{Name: "_ldap._tcp.example.test.", Type: "SRV", TTL: 300, Value: "1 5 389 dc.example.test.", Priority: 1, Weight: 5, Port: 389, Target: "dc.example.test."}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("SRV = %+v, want %+v", records, want)
+	}
+
+	records, err = n.DNSQuery("example.test", "CAA", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []DNSRecord{{Name: "example.test.", Type: "CAA", TTL: 300, Value: `128 issue "ca.test"`, Flags: 128, Tag: "issue", Data: "ca.test"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CAA = %+v, want %+v", records, want)
+	}
+
+	for _, tt := range []struct {
+		name, recordType string
+		want             []string
+	}{
+		{"example.test", "A", []string{"192.0.2.1"}},
+		{"example.test", "TXT", []string{"hello world"}},
+		{"example.test", "AAAA", []string{}},
+		{"192.0.2.1", "PTR", []string{"host.example.test."}},
+	} {
+		got, err := n.DNSLookup(tt.name, tt.recordType, opts)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.name, tt.recordType, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s = %q, want %q", tt.name, tt.recordType, got, tt.want)
+		}
+	}
+
+	if _, err := n.DNSQuery("missing.test", "A", opts); err == nil || !strings.Contains(err.Error(), "no such host") {
+		t.Errorf("missing name: err = %v, want no such host", err)
+	}
+	if _, err := n.DNSQuery("example.test", "HINFO", opts); err == nil {
+		t.Error("HINFO: want an unsupported record type error")
+	}
+}
+
+func TestDNSQueryTruncated(t *testing.T) {
+	n := NewNetworkModule()
+	opts := DNSOptions{Server: startTestDNSServer(t, 40), Timeout: 2 * time.Second}
+
+	// The TXT answer does not fit in 40 bytes, so it is asked again over TCP
+	got, err := n.DNSLookup("example.test", "TXT", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"hello world"}) {
+		t.Errorf("TXT = %q, want [hello world]", got)
+	}
+}
+
+func TestDNSOverHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns-query" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		if binary.BigEndian.Uint16(query) != 0 {
+			http.Error(w, "DoH queries have ID 0", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(testAnswer(query, 0))
+	}))
+	defer server.Close()
+
+	n := NewNetworkModule()
+	got, err := n.DNSLookup("192.0.2.1", "PTR", DNSOptions{DoH: server.URL + "/dns-query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"host.example.test."}) {
+		t.Errorf("PTR = %q, want [host.example.test.]", got)
+	}
+
+	if _, err := n.DNSLookup("example.test", "A", DNSOptions{DoH: server.URL + "/missing"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("wrong path: err = %v, want the 404 status", err)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"example.com": "example.com",
+	} {
+		if got := ReverseName(ip); got != want {
+			t.Errorf("ReverseName(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
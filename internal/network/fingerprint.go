@@ -0,0 +1,490 @@
+package network
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Service fingerprinting: probes sent to open ports and the patterns that
+// recognise products and versions in their answers
+
+// ServiceProbe is a probe of the fingerprint database: a payload sent to
+// an open port and the patterns that recognise the answer. A probe without
+// a payload waits for the banner a service sends on connecting; its
+// patterns are also tried on the answers to every other probe.
+type ServiceProbe struct {
+	Name    string
+	Payload []byte
+	Ports   []int // ports the probe is sent to, or every port if empty
+	TLS     bool  // send the payload inside a TLS session
+	Matches []ServiceMatch
+}
+
+// ServiceMatch recognises a service in the answer to a probe. Product,
+// Version, Info and CPE may refer to the groups of Pattern as $1 to $9.
+// CPE names the part, vendor and product of the CPE 2.3 name, as in
+// "a:openbsd:openssh"; the version is filled in from Version.
+type ServiceMatch struct {
+	Service string
+	Pattern *regexp.Regexp
+	Product string
+	Version string
+	Info    string
+	CPE     string
+}
+
+// ServiceFingerprint is what fingerprinting learnt about an open port.
+// Probe is empty when no probe matched and Service is a guess from the
+// port number.
+type ServiceFingerprint struct {
+	Host       string
+	Port       int
+	Service    string
+	Product    string
+	Version    string
+	Info       string
+	CPE        string
+	Banner     string
+	Probe      string
+	TLS        bool
+	TLSVersion string
+	TLSSubject string
+}
+
+// FingerprintOptions configures FingerprintService. Probes are tried
+// before the built-in ones, and Timeout bounds each connection.
+type FingerprintOptions struct {
+	Probes  []*ServiceProbe
+	Timeout time.Duration
+}
+
+const (
+	defaultProbeTimeout = 2 * time.Second
+	// answerLinger is how long to wait for the rest of an answer once it
+	// has started
+	answerLinger = 250 * time.Millisecond
+	maxAnswer    = 16 * 1024
+)
+
+var errNoTLS = errors.New("no TLS handshake")
+
+// CompileServiceMatch compiles a match of a probe, checking its CPE prefix
+func CompileServiceMatch(service, pattern, product, version, info, cpe string) (ServiceMatch, error) {
+	if service == "" {
+		return ServiceMatch{}, fmt.Errorf("match needs a service")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ServiceMatch{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	if cpe != "" {
+		parts := strings.Split(cpe, ":")
+		if len(parts) != 3 || (parts[0] != "a" && parts[0] != "o" && parts[0] != "h") || parts[1] == "" || parts[2] == "" {
+			return ServiceMatch{}, fmt.Errorf("cpe must be part:vendor:product, as in a:openbsd:openssh, got %q", cpe)
+		}
+	}
+	return ServiceMatch{Service: service, Pattern: re, Product: product, Version: version, Info: info, CPE: cpe}, nil
+}
+
+func serviceMatch(service, pattern, product, version, info, cpe string) ServiceMatch {
+	m, err := CompileServiceMatch(service, pattern, product, version, info, cpe)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// httpMatches recognises web servers from their Server header
+func httpMatches(service string) []ServiceMatch {
+	const status = `(?is)^HTTP/1\.[01] \d{3}.*?\r\n`
+	return []ServiceMatch{
+		serviceMatch(service, `(?s)^HTTP/1\.[01] \d{3}.*"number" : "([\d.]+)".*You Know, for Search`, "Elasticsearch", "$1", "", "a:elastic:elasticsearch"),
+		serviceMatch(service, status+`server: nginx(?:/([\d.]+))?`, "nginx", "$1", "", "a:f5:nginx"),
+		serviceMatch(service, status+`server: Apache(?:/([\d.]+))?(?: \(([^)\r\n]+)\))?`, "Apache httpd", "$1", "$2", "a:apache:http_server"),
+		serviceMatch(service, status+`server: Microsoft-IIS/([\d.]+)`, "Microsoft IIS httpd", "$1", "", "a:microsoft:internet_information_services"),
+		serviceMatch(service, status+`server: lighttpd(?:/([\d.]+))?`, "lighttpd", "$1", "", "a:lighttpd:lighttpd"),
+		serviceMatch(service, status+`server: Caddy`, "Caddy httpd", "", "", "a:caddyserver:caddy"),
+		serviceMatch(service, status+`server: ([^\r\n]+)`, "$1", "", "", ""),
+		serviceMatch(service, `^HTTP/1\.[01] \d{3}`, "", "", "", ""),
+	}
+}
+
+// smb2Negotiate is an SMB2 NEGOTIATE request offering the dialects 2.0.2
+// to 3.0.2, in a NetBIOS session message
+func smb2Negotiate() []byte {
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64) // structure size
+	binary.LittleEndian.PutUint16(header[14:], 1) // credits requested
+
+	body := make([]byte, 36)
+	binary.LittleEndian.PutUint16(body[0:], 36) // structure size
+	binary.LittleEndian.PutUint16(body[2:], 4)  // dialect count
+	binary.LittleEndian.PutUint16(body[4:], 1)  // signing enabled
+	copy(body[12:28], "sentra-fingerpri")       // client GUID
+	for _, dialect := range []uint16{0x0202, 0x0210, 0x0300, 0x0302} {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+	}
+
+	msg := append(header, body...)
+	return append([]byte{0, 0, 0, byte(len(msg))}, msg...)
+}
+
+// mongoBuildInfo is an OP_QUERY of the buildinfo command
+func mongoBuildInfo() []byte {
+	doc := []byte{0, 0, 0, 0, 0x10}
+	doc = append(doc, "buildinfo\x00"...)
+	doc = binary.LittleEndian.AppendUint32(doc, 1)
+	doc = append(doc, 0)
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+
+	msg := make([]byte, 16, 64)
+	binary.LittleEndian.PutUint32(msg[4:], 1)     // request id
+	binary.LittleEndian.PutUint32(msg[12:], 2004) // OP_QUERY
+	msg = append(msg, 0, 0, 0, 0)                 // flags
+	msg = append(msg, "admin.$cmd\x00"...)
+	msg = binary.LittleEndian.AppendUint32(msg, 0)          // skip
+	msg = binary.LittleEndian.AppendUint32(msg, 0xffffffff) // return one document
+	msg = append(msg, doc...)
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	return msg
+}
+
+// ServiceProbes is the built-in fingerprint database. Answers are matched
+// with each byte as one character, so patterns can match binary protocols
+// with escapes such as \xfe.
+var ServiceProbes = []*ServiceProbe{
+	{
+		Name: "banner",
+		Matches: []ServiceMatch{
+			serviceMatch("SSH", `^SSH-([\d.]+)-OpenSSH_([\w.]+)(?:[ -]([^\r\n]+))?`, "OpenSSH", "$2", "$3", "a:openbsd:openssh"),
+			serviceMatch("SSH", `^SSH-[\d.]+-dropbear_([\w.]+)`, "Dropbear sshd", "$1", "", "a:dropbear_ssh_project:dropbear_ssh"),
+			serviceMatch("SSH", `^SSH-[\d.]+-([^\s]+)`, "$1", "", "", ""),
+			serviceMatch("FTP", `^220 \(vsFTPd ([\w.]+)\)`, "vsftpd", "$1", "", "a:beasts:vsftpd"),
+			serviceMatch("FTP", `^220[- ][^\r\n]*ProFTPD(?: ([\d.]+\w*))?`, "ProFTPD", "$1", "", "a:proftpd:proftpd"),
+			serviceMatch("FTP", `^220[- ][^\r\n]*Pure-FTPd`, "Pure-FTPd", "", "", "a:pureftpd:pure-ftpd"),
+			serviceMatch("FTP", `^220[- ][^\r\n]*FileZilla Server(?: version)? ([\w.]+)`, "FileZilla ftpd", "$1", "", "a:filezilla-project:filezilla_server"),
+			serviceMatch("FTP", `^220[- ][^\r\n]*FTP`, "", "", "", ""),
+			serviceMatch("SMTP", `^220 ([\w.-]+) ESMTP Postfix`, "Postfix smtpd", "", "$1", "a:postfix:postfix"),
+			serviceMatch("SMTP", `^220 ([\w.-]+) ESMTP Exim ([\w.]+)`, "Exim smtpd", "$2", "$1", "a:exim:exim"),
+			serviceMatch("SMTP", `^220 ([\w.-]+) Microsoft ESMTP MAIL Service`, "Microsoft Exchange smtpd", "", "$1", "a:microsoft:exchange_server"),
+			serviceMatch("SMTP", `^220[- ][^\r\n]*SMTP`, "", "", "", ""),
+			serviceMatch("POP3", `^\+OK[^\r\n]*Dovecot`, "Dovecot pop3d", "", "", "a:dovecot:dovecot"),
+			serviceMatch("POP3", `^\+OK`, "", "", "", ""),
+			serviceMatch("IMAP", `^\* OK[^\r\n]*Dovecot`, "Dovecot imapd", "", "", "a:dovecot:dovecot"),
+			serviceMatch("IMAP", `^\* OK`, "", "", "", ""),
+			serviceMatch("MySQL", `(?s)^.\x00\x00\x00\x0a(?:5\.5\.5-)?([\d.]+)-MariaDB`, "MariaDB", "$1", "", "a:mariadb:mariadb"),
+			serviceMatch("MySQL", `(?s)^.\x00\x00\x00\x0a([\d.]+)[^\x00]*\x00`, "MySQL", "$1", "", "a:oracle:mysql"),
+			serviceMatch("MySQL", `(?s)^.\x00\x00\x00\xff.{2}Host '[^']*' is not allowed`, "MySQL", "", "host not allowed", "a:oracle:mysql"),
+			serviceMatch("VNC", `^RFB 0*(\d+)\.0*(\d+)\n`, "VNC", "", "protocol $1.$2", ""),
+			serviceMatch("Telnet", `^\xff[\xfb-\xfe]`, "", "", "", ""),
+		},
+	},
+	{
+		Name:    "smb-negotiate",
+		Payload: smb2Negotiate(),
+		Ports:   []int{139, 445},
+		Matches: []ServiceMatch{
+			serviceMatch("SMB", `(?s)^\x00.{3}\xfeSMB.{64}\x02\x02`, "SMB", "2.0.2", "", ""),
+			serviceMatch("SMB", `(?s)^\x00.{3}\xfeSMB.{64}\x10\x02`, "SMB", "2.1", "", ""),
+			serviceMatch("SMB", `(?s)^\x00.{3}\xfeSMB.{64}\x00\x03`, "SMB", "3.0", "", ""),
+			serviceMatch("SMB", `(?s)^\x00.{3}\xfeSMB.{64}\x02\x03`, "SMB", "3.0.2", "", ""),
+			serviceMatch("SMB", `(?s)^\x00.{3}\xfeSMB`, "SMB", "", "", ""),
+			serviceMatch("SMB", `(?s)^\x00.{3}\xffSMB`, "SMB", "1", "", ""),
+		},
+	},
+	{
+		Name:    "rdp-connect",
+		Payload: []byte("\x03\x00\x00\x13\x0e\xe0\x00\x00\x00\x00\x00\x01\x00\x08\x00\x03\x00\x00\x00"),
+		Ports:   []int{3389},
+		Matches: []ServiceMatch{
+			serviceMatch("RDP", `(?s)^\x03\x00\x00.\x0e\xd0.{5}\x02.{3}\x02`, "", "", "CredSSP", ""),
+			serviceMatch("RDP", `(?s)^\x03\x00\x00.\x0e\xd0.{5}\x02.{3}\x01`, "", "", "TLS", ""),
+			serviceMatch("RDP", `(?s)^\x03\x00\x00.\x0e\xd0`, "", "", "", ""),
+		},
+	},
+	{
+		Name:    "postgres-startup",
+		Payload: []byte("\x00\x00\x00\x08\x00\x00\x00\x00"),
+		Ports:   []int{5432},
+		Matches: []ServiceMatch{
+			serviceMatch("PostgreSQL", `(?s)^E.{4}.*server supports ([\d.]+) to ([\d.]+)`, "PostgreSQL", "", "protocol $1-$2", "a:postgresql:postgresql"),
+			serviceMatch("PostgreSQL", `(?s)^E.{4}S(?:FATAL|ERROR)\x00`, "PostgreSQL", "", "", "a:postgresql:postgresql"),
+		},
+	},
+	{
+		Name:    "redis-info",
+		Payload: []byte("*1\r\n$4\r\nINFO\r\n"),
+		Ports:   []int{6379},
+		Matches: []ServiceMatch{
+			serviceMatch("Redis", `(?s)^\$\d+\r\n.*redis_version:([\w.]+)`, "Redis key-value store", "$1", "", "a:redis:redis"),
+			serviceMatch("Redis", `^-NOAUTH`, "Redis key-value store", "", "authentication required", "a:redis:redis"),
+			serviceMatch("Redis", `^-DENIED Redis`, "Redis key-value store", "", "protected mode", "a:redis:redis"),
+		},
+	},
+	{
+		Name:    "mongodb-buildinfo",
+		Payload: mongoBuildInfo(),
+		Ports:   []int{27017, 27018},
+		Matches: []ServiceMatch{
+			serviceMatch("MongoDB", `(?s)^.{12}\x01\x00\x00\x00.*version\x00.{4}([\d.]+)\x00`, "MongoDB", "$1", "", "a:mongodb:mongodb"),
+			serviceMatch("MongoDB", `(?s)^.{12}\x01\x00\x00\x00`, "MongoDB", "", "", "a:mongodb:mongodb"),
+		},
+	},
+	{
+		Name:    "mssql-prelogin",
+		Payload: []byte("\x12\x01\x00\x1a\x00\x00\x00\x00\x00\x00\x0b\x00\x06\x01\x00\x11\x00\x01\xff\x00\x00\x00\x00\x00\x00\x02"),
+		Ports:   []int{1433},
+		Matches: []ServiceMatch{
+			serviceMatch("MSSQL", `(?s)^\x04\x01.{2}\x00\x00`, "Microsoft SQL Server", "", "", "a:microsoft:sql_server"),
+		},
+	},
+	{
+		Name:    "tls-get",
+		Payload: []byte("GET / HTTP/1.0\r\n\r\n"),
+		TLS:     true,
+		Matches: httpMatches("HTTPS"),
+	},
+	{
+		Name:    "http-get",
+		Payload: []byte("GET / HTTP/1.0\r\n\r\n"),
+		Matches: httpMatches("HTTP"),
+	},
+}
+
+// FingerprintService identifies the service on an open TCP port. It
+// waits for a banner, then sends the probes for the port and the generic
+// ones until an answer matches, each on a new connection.
+func FingerprintService(host string, port int, opts FingerprintOptions) (*ServiceFingerprint, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	probes := append(append([]*ServiceProbe{}, opts.Probes...), ServiceProbes...)
+
+	// Probes without a payload share one connection, and their patterns
+	// are tried on every answer
+	var banners, specific, generic []*ServiceProbe
+	for _, probe := range probes {
+		switch {
+		case len(probe.Payload) == 0 && !probe.TLS:
+			banners = append(banners, probe)
+		case len(probe.Ports) == 0:
+			generic = append(generic, probe)
+		case containsPort(probe.Ports, port):
+			specific = append(specific, probe)
+		}
+	}
+
+	fp := &ServiceFingerprint{Host: host, Port: port}
+	var first []byte
+	try := func(probe *ServiceProbe, candidates []*ServiceProbe) (bool, error) {
+		answer, state, err := runProbe(host, port, probe, timeout)
+		if state != nil {
+			fp.TLS = true
+			fp.TLSVersion = tls.VersionName(state.Version)
+			if len(state.PeerCertificates) > 0 {
+				fp.TLSSubject = state.PeerCertificates[0].Subject.CommonName
+			}
+		}
+		if err != nil || len(answer) == 0 {
+			return false, err
+		}
+		if first == nil {
+			first = answer
+		}
+		text := latin1(answer)
+		for _, candidate := range candidates {
+			for _, m := range candidate.Matches {
+				if groups := m.Pattern.FindStringSubmatchIndex(text); groups != nil {
+					fp.apply(m, text, groups)
+					fp.Probe = candidate.Name
+					fp.Banner = bannerText(answer)
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	if matched, err := try(&ServiceProbe{Name: "banner"}, banners); matched {
+		return fp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tlsFailed := false
+	for _, probe := range append(specific, generic...) {
+		if probe.TLS && tlsFailed {
+			continue
+		}
+		matched, err := try(probe, append([]*ServiceProbe{probe}, banners...))
+		if matched {
+			return fp, nil
+		}
+		if errors.Is(err, errNoTLS) {
+			tlsFailed = true
+		}
+	}
+
+	fp.Banner = bannerText(first)
+	fp.Service = identifyService(port)
+	if fp.TLS && strings.HasPrefix(fp.Service, "Unknown") {
+		fp.Service = "TLS"
+	}
+	return fp, nil
+}
+
+// runProbe sends a probe on a new connection and reads the answer. The
+// TLS state is returned once a handshake succeeded.
+func runProbe(host string, port int, probe *ServiceProbe, timeout time.Duration) ([]byte, *tls.ConnectionState, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	var state *tls.ConnectionState
+	if probe.TLS {
+		client := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		client.SetDeadline(time.Now().Add(timeout))
+		if err := client.Handshake(); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errNoTLS, err)
+		}
+		cs := client.ConnectionState()
+		state = &cs
+		conn = client
+	}
+
+	if len(probe.Payload) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(probe.Payload); err != nil {
+			return nil, state, err
+		}
+	}
+
+	buf := make([]byte, 4096)
+	var answer []byte
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for len(answer) < maxAnswer {
+		n, err := conn.Read(buf)
+		answer = append(answer, buf[:n]...)
+		if err != nil {
+			break
+		}
+		// The rest of an answer follows quickly
+		conn.SetReadDeadline(time.Now().Add(min(answerLinger, timeout)))
+	}
+	return answer, state, nil
+}
+
+// apply fills in a fingerprint from a match and the submatch indexes of
+// its pattern in text
+func (fp *ServiceFingerprint) apply(m ServiceMatch, text string, groups []int) {
+	expand := func(template string) string {
+		if template == "" {
+			return ""
+		}
+		return strings.TrimSpace(string(m.Pattern.ExpandString(nil, template, text, groups)))
+	}
+	fp.Service = m.Service
+	fp.Product = expand(m.Product)
+	fp.Version = expand(m.Version)
+	fp.Info = expand(m.Info)
+	if m.CPE != "" {
+		fp.CPE = cpeName(m.CPE, fp.Version)
+	}
+}
+
+// cpeName builds a CPE 2.3 name from its part, vendor and product and a
+// version, which may be empty
+func cpeName(prefix, version string) string {
+	v := "*"
+	if version != "" {
+		var b strings.Builder
+		for _, r := range strings.ToLower(version) {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		v = b.String()
+	}
+	return "cpe:2.3:" + prefix + ":" + v + ":*:*:*:*:*:*:*"
+}
+
+// latin1 maps each byte to the character of the same code, so that
+// patterns see binary answers byte by byte
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// bannerText returns the first line of a text answer, or the start of a
+// binary one with its unprintable bytes as dots
+func bannerText(answer []byte) string {
+	line, _, _ := strings.Cut(string(answer), "\n")
+	if line = strings.TrimRight(line, "\r"); isPrintable(line) {
+		if len(line) > 256 {
+			line = line[:256]
+		}
+		return line
+	}
+	if len(answer) > 64 {
+		answer = answer[:64]
+	}
+	text := make([]byte, len(answer))
+	for i, b := range answer {
+		if b < 0x20 || b > 0x7e {
+			b = '.'
+		}
+		text[i] = b
+	}
+	return string(text)
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r == utf8.RuneError || (r < 0x20 && r != '\t') || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceFingerprintToMap converts a ServiceFingerprint to a map for VM
+func ServiceFingerprintToMap(fp *ServiceFingerprint) map[string]interface{} {
+	return map[string]interface{}{
+		"host":        fp.Host,
+		"port":        fp.Port,
+		"service":     fp.Service,
+		"product":     fp.Product,
+		"version":     fp.Version,
+		"info":        fp.Info,
+		"cpe":         fp.CPE,
+		"banner":      fp.Banner,
+		"probe":       fp.Probe,
+		"tls":         fp.TLS,
+		"tls_version": fp.TLSVersion,
+		"tls_subject": fp.TLSSubject,
+	}
+}
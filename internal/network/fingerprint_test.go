@@ -0,0 +1,176 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTCP answers every connection with handle until the test ends
+func serveTCP(t *testing.T, handle func(net.Conn)) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func hostPort(t *testing.T, rawURL string) (string, int) {
+	t.Helper()
+	addr, err := net.ResolveTCPAddr("tcp", strings.TrimPrefix(strings.TrimPrefix(rawURL, "https://"), "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr.IP.String(), addr.Port
+}
+
+var quick = FingerprintOptions{Timeout: 300 * time.Millisecond}
+
+func TestFingerprintBanner(t *testing.T) {
+	host, port := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6\r\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 64))
+	})
+	fp, err := FingerprintService(host, port, quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Service != "SSH" || fp.Product != "OpenSSH" || fp.Version != "8.9p1" || fp.Info != "Ubuntu-3ubuntu0.6" || fp.Probe != "banner" {
+		t.Errorf("got %+v", fp)
+	}
+	if fp.CPE != "cpe:2.3:a:openbsd:openssh:8.9p1:*:*:*:*:*:*:*" || fp.Banner != "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6" {
+		t.Errorf("cpe %q, banner %q", fp.CPE, fp.Banner)
+	}
+}
+
+func TestFingerprintBinaryBanner(t *testing.T) {
+	greeting := "J\x00\x00\x00\x0a8.0.36-0ubuntu0.22.04.1\x00\x08\x00\x00\x00"
+	host, port := serveTCP(t, func(conn net.Conn) { conn.Write([]byte(greeting)) })
+	fp, err := FingerprintService(host, port, quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Service != "MySQL" || fp.Product != "MySQL" || fp.Version != "8.0.36" || !strings.HasPrefix(fp.Banner, "J....8.0.36") {
+		t.Errorf("got %+v", fp)
+	}
+}
+
+func TestFingerprintHTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "Apache/2.4.57 (Debian)")
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewUnstartedServer(handler)
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0) // the banner probe hangs up without a handshake
+	secure.StartTLS()
+	defer secure.Close()
+
+	host, port := hostPort(t, plain.URL)
+	fp, err := FingerprintService(host, port, quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Service != "HTTP" || fp.Product != "Apache httpd" || fp.Version != "2.4.57" || fp.Info != "Debian" || fp.TLS {
+		t.Errorf("got %+v", fp)
+	}
+
+	host, port = hostPort(t, secure.URL)
+	fp, err = FingerprintService(host, port, quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Service != "HTTPS" || fp.Product != "Apache httpd" || !fp.TLS || fp.TLSVersion != "TLS 1.3" || fp.Probe != "tls-get" {
+		t.Errorf("got %+v", fp)
+	}
+}
+
+func TestFingerprintCustomProbe(t *testing.T) {
+	host, port := serveTCP(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "VERSION\n" {
+			conn.Write([]byte("acme-agent 4.2 ready\n"))
+		}
+	})
+	match, err := CompileServiceMatch("acme", `^acme-agent ([\d.]+)`, "Acme Agent", "$1", "", "a:acme:agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &ServiceProbe{Name: "acme-version", Payload: []byte("VERSION\n"), Ports: []int{port}, Matches: []ServiceMatch{match}}
+	opts := quick
+	opts.Probes = []*ServiceProbe{probe}
+
+	fp, err := FingerprintService(host, port, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Service != "acme" || fp.Version != "4.2" || fp.Probe != "acme-version" || fp.CPE != "cpe:2.3:a:acme:agent:4.2:*:*:*:*:*:*:*" {
+		t.Errorf("got %+v", fp)
+	}
+
+	// Without the probe the silent service is only guessed
+	fp, err = FingerprintService(host, port, quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Probe != "" || !strings.HasPrefix(fp.Service, "Unknown") {
+		t.Errorf("got %+v", fp)
+	}
+
+	for _, bad := range [][2]string{{"", "x"}, {"x", "("}} {
+		if _, err := CompileServiceMatch(bad[0], bad[1], "", "", "", ""); err == nil {
+			t.Errorf("compiled service %q pattern %q", bad[0], bad[1])
+		}
+	}
+	if _, err := CompileServiceMatch("x", "x", "", "", "", "acme:agent"); err == nil {
+		t.Error("compiled a cpe without a part")
+	}
+}
+
+func TestFingerprintClosedPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	if _, err := FingerprintService("127.0.0.1", port, quick); err == nil {
+		t.Error("fingerprinted a closed port")
+	}
+}
+
+func TestProbePayloads(t *testing.T) {
+	smb := smb2Negotiate()
+	if len(smb) != 4+64+36+8 || int(smb[3]) != len(smb)-4 || string(smb[4:8]) != "\xfeSMB" {
+		t.Errorf("smb negotiate: % x", smb)
+	}
+	mongo := mongoBuildInfo()
+	if int(mongo[0]) != len(mongo) || !strings.Contains(string(mongo), "admin.$cmd\x00") {
+		t.Errorf("mongo buildinfo: % x", mongo)
+	}
+	if got := cpeName("a:vendor:product", "1.0 beta"); got != `cpe:2.3:a:vendor:product:1.0\ beta:*:*:*:*:*:*:*` {
+		t.Errorf("cpe %q", got)
+	}
+}
@@ -0,0 +1,525 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// gRPC clients for exercising services without generated code
+
+// GRPCOptions configures a gRPC connection. The services are read from
+// Protos, or from the server by reflection if there are none. With
+// ImportPaths the proto file names are relative to them; otherwise each
+// file's directory is its import path.
+type GRPCOptions struct {
+	Protos      []string
+	ImportPaths []string
+	TLS         bool   // Implied by CA, Cert, ServerName and Insecure
+	CA          string // PEM file of the CAs to trust instead of the system's
+	Cert        string // PEM files of a client certificate for mTLS
+	Key         string
+	ServerName  string
+	Insecure    bool              // Skip verifying the server's certificate
+	Metadata    map[string]string // Sent with every call
+	Timeout     time.Duration     // Per call; 10 seconds if 0
+}
+
+// GRPCConn is a connection to a gRPC server with the services it offers
+type GRPCConn struct {
+	ID       string
+	Target   string
+	conn     *grpc.ClientConn
+	services map[string]protoreflect.ServiceDescriptor
+	metadata map[string]string
+	timeout  time.Duration
+}
+
+// GRPCMethod describes a method of a service
+type GRPCMethod struct {
+	Name            string // Full name, such as pkg.Service/Method
+	Input           string
+	Output          string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+// GRPCResult is the outcome of a call. A call that fails with a status
+// still has one: Code is the status code and Message its message.
+type GRPCResult struct {
+	Code      int
+	Status    string
+	Message   string
+	Responses []map[string]interface{}
+	Headers   map[string]string
+	Trailers  map[string]string
+	Duration  time.Duration
+}
+
+// grpcConns holds the open connections by id
+var grpcConns = make(map[string]*GRPCConn)
+
+// GRPCConnect connects to a gRPC server and loads its services
+func GRPCConnect(target string, opts GRPCOptions) (*GRPCConn, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	creds := insecure.NewCredentials()
+	if opts.TLS || opts.CA != "" || opts.Cert != "" || opts.ServerName != "" || opts.Insecure {
+		config, err := clientTLSConfig(opts.ServerName, opts.CA, opts.Cert, opts.Key, opts.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	c := &GRPCConn{Target: target, conn: conn, metadata: opts.Metadata, timeout: opts.Timeout}
+
+	var files []protoreflect.FileDescriptor
+	if len(opts.Protos) > 0 {
+		files, err = compileProtos(opts.Protos, opts.ImportPaths)
+	} else {
+		files, err = c.reflectFiles()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.services = make(map[string]protoreflect.ServiceDescriptor)
+	for _, f := range files {
+		for i := 0; i < f.Services().Len(); i++ {
+			s := f.Services().Get(i)
+			c.services[string(s.FullName())] = s
+		}
+	}
+
+	registryMutex.Lock()
+	c.ID = generateID("grpc")
+	grpcConns[c.ID] = c
+	registryMutex.Unlock()
+	return c, nil
+}
+
+// clientTLSConfig builds the TLS configuration of a client from PEM files
+// of the CAs to trust, if not the system's, and of a client certificate
+func clientTLSConfig(serverName, ca, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", ca)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("a client certificate needs both cert and key")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// compileProtos parses proto files with their imports, including the
+// well-known types
+func compileProtos(protos, importPaths []string) ([]protoreflect.FileDescriptor, error) {
+	names := protos
+	if len(importPaths) == 0 {
+		names = make([]string, len(protos))
+		seen := map[string]bool{}
+		for i, p := range protos {
+			dir := filepath.Dir(p)
+			if !seen[dir] {
+				seen[dir] = true
+				importPaths = append(importPaths, dir)
+			}
+			names[i] = filepath.Base(p)
+		}
+	}
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+	}
+	compiled, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]protoreflect.FileDescriptor, len(compiled))
+	for i, f := range compiled {
+		files[i] = f
+	}
+	return files, nil
+}
+
+// reflectFiles reads the files that define the server's services through
+// server reflection, trying v1 and then v1alpha. Both versions share a
+// wire format, so the v1 messages serve for either.
+func (c *GRPCConn) reflectFiles() ([]protoreflect.FileDescriptor, error) {
+	var err error
+	for _, version := range []string{"v1", "v1alpha"} {
+		var files []protoreflect.FileDescriptor
+		files, err = c.reflectVersion("/grpc.reflection." + version + ".ServerReflection/ServerReflectionInfo")
+		if status.Code(err) != codes.Unimplemented {
+			return files, err
+		}
+	}
+	return nil, fmt.Errorf("server reflection is not available, give the proto files: %v", status.Convert(err).Message())
+}
+
+// reflectVersion asks one version of the reflection service for the files
+// of each service and builds them
+func (c *GRPCConn) reflectVersion(method string) ([]protoreflect.FileDescriptor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	stream, err := c.conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, method)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	ask := func(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+		// On io.EOF the server ended the stream, and RecvMsg returns why
+		if err := stream.SendMsg(req); err != nil && err != io.EOF {
+			return nil, err
+		}
+		res := new(reflectionpb.ServerReflectionResponse)
+		if err := stream.RecvMsg(res); err != nil {
+			return nil, err
+		}
+		if e := res.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection: %s", e.GetErrorMessage())
+		}
+		return res, nil
+	}
+
+	res, err := ask(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+	if err != nil {
+		return nil, err
+	}
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	// add keeps the files of a response and returns their names, the file
+	// asked for first
+	add := func(res *reflectionpb.ServerReflectionResponse) ([]string, error) {
+		var names []string
+		for _, raw := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := new(descriptorpb.FileDescriptorProto)
+			if err := proto.Unmarshal(raw, fd); err != nil {
+				return nil, err
+			}
+			protos[fd.GetName()] = fd
+			names = append(names, fd.GetName())
+		}
+		return names, nil
+	}
+	var roots []string
+	for _, s := range res.GetListServicesResponse().GetService() {
+		if strings.HasPrefix(s.GetName(), "grpc.reflection.") {
+			continue
+		}
+		res, err := ask(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: s.GetName()}})
+		if err != nil {
+			return nil, err
+		}
+		names, err := add(res)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 0 {
+			roots = append(roots, names[0])
+		}
+	}
+
+	// Build the files after their dependencies, asking for those the
+	// server did not send
+	local := new(protoregistry.Files)
+	resolver := fallbackResolver{local}
+	var build func(name string) error
+	build = func(name string) error {
+		if _, err := resolver.FindFileByPath(name); err == nil {
+			return nil
+		}
+		fd, ok := protos[name]
+		if !ok {
+			res, err := ask(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name}})
+			if err != nil {
+				return err
+			}
+			if _, err := add(res); err != nil {
+				return err
+			}
+			if fd, ok = protos[name]; !ok {
+				return fmt.Errorf("reflection: server did not send %s", name)
+			}
+		}
+		for _, dep := range fd.GetDependency() {
+			if err := build(dep); err != nil {
+				return err
+			}
+		}
+		f, err := protodesc.NewFile(fd, resolver)
+		if err != nil {
+			return err
+		}
+		return local.RegisterFile(f)
+	}
+	var files []protoreflect.FileDescriptor
+	for _, name := range roots {
+		if err := build(name); err != nil {
+			return nil, err
+		}
+		f, _ := resolver.FindFileByPath(name)
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// fallbackResolver finds descriptors in files read from a server, then in
+// those compiled into the binary such as the well-known types
+type fallbackResolver struct {
+	local *protoregistry.Files
+}
+
+func (r fallbackResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if f, err := r.local.FindFileByPath(path); err == nil {
+		return f, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r fallbackResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.local.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// LookupGRPC returns an open connection by id
+func LookupGRPC(id string) (*GRPCConn, error) {
+	registryMutex.RLock()
+	c, ok := grpcConns[id]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("gRPC connection '%s' not found", id)
+	}
+	return c, nil
+}
+
+// Close closes and forgets the connection
+func (c *GRPCConn) Close() error {
+	registryMutex.Lock()
+	delete(grpcConns, c.ID)
+	registryMutex.Unlock()
+	return c.conn.Close()
+}
+
+// Methods lists the methods of the connection's services, sorted by name
+func (c *GRPCConn) Methods() []GRPCMethod {
+	var methods []GRPCMethod
+	for name, s := range c.services {
+		for i := 0; i < s.Methods().Len(); i++ {
+			m := s.Methods().Get(i)
+			methods = append(methods, GRPCMethod{
+				Name:            name + "/" + string(m.Name()),
+				Input:           string(m.Input().FullName()),
+				Output:          string(m.Output().FullName()),
+				ClientStreaming: m.IsStreamingClient(),
+				ServerStreaming: m.IsStreamingServer(),
+			})
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+// method finds a method by its name: pkg.Service/Method, pkg.Service.Method
+// or, if the service name is unique, Service/Method
+func (c *GRPCConn) method(name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	service, method, ok := strings.Cut(name, "/")
+	if !ok {
+		dot := strings.LastIndex(name, ".")
+		if dot < 0 {
+			return nil, fmt.Errorf("method '%s' must be Service/Method", name)
+		}
+		service, method = name[:dot], name[dot+1:]
+	}
+	s, ok := c.services[service]
+	if !ok {
+		for full, candidate := range c.services {
+			if strings.HasSuffix(full, "."+service) {
+				if s != nil {
+					return nil, fmt.Errorf("service '%s' is ambiguous, give its package", service)
+				}
+				s = candidate
+			}
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("unknown service '%s'", service)
+	}
+	m := s.Methods().ByName(protoreflect.Name(method))
+	if m == nil {
+		return nil, fmt.Errorf("service '%s' has no method '%s'", s.FullName(), method)
+	}
+	return m, nil
+}
+
+// Call invokes a method. Unary and server streaming methods take one
+// request; client and bidirectional streaming methods take a list, sent
+// in order before the responses are read. Requests and responses are maps
+// in the JSON mapping of their messages. md is added to the connection's
+// metadata, where an empty value drops a key such as authorization, and
+// timeout replaces its timeout if not 0.
+func (c *GRPCConn) Call(name string, requests []map[string]interface{}, md map[string]string, timeout time.Duration) (*GRPCResult, error) {
+	m, err := c.method(name)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsStreamingClient() && len(requests) != 1 {
+		return nil, fmt.Errorf("%s takes one request, got %d", m.FullName(), len(requests))
+	}
+	msgs := make([]proto.Message, len(requests))
+	for i, req := range requests {
+		msg := dynamicpb.NewMessage(m.Input())
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return nil, fmt.Errorf("request for %s: %v", m.FullName(), err)
+		}
+		msgs[i] = msg
+	}
+
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	pairs := metadata.MD{}
+	for k, v := range c.metadata {
+		pairs.Set(k, v)
+	}
+	for k, v := range md {
+		if v == "" {
+			pairs.Delete(k)
+		} else {
+			pairs.Set(k, v)
+		}
+	}
+	ctx = metadata.NewOutgoingContext(ctx, pairs)
+
+	fullMethod := "/" + string(m.Parent().FullName()) + "/" + string(m.Name())
+	result := &GRPCResult{}
+	var header, trailer metadata.MD
+	start := time.Now()
+	if !m.IsStreamingClient() && !m.IsStreamingServer() {
+		res := dynamicpb.NewMessage(m.Output())
+		err = c.conn.Invoke(ctx, fullMethod, msgs[0], res, grpc.Header(&header), grpc.Trailer(&trailer))
+		if err == nil {
+			err = result.add(res)
+		}
+	} else {
+		var stream grpc.ClientStream
+		stream, err = c.conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: m.IsStreamingClient(), ServerStreams: m.IsStreamingServer()}, fullMethod)
+		if err == nil {
+			for _, msg := range msgs {
+				if err = stream.SendMsg(msg); err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				// The server ended the call; its status comes with the
+				// responses
+				err = nil
+			}
+			if err == nil {
+				err = stream.CloseSend()
+			}
+			for err == nil {
+				res := dynamicpb.NewMessage(m.Output())
+				if err = stream.RecvMsg(res); err == nil {
+					err = result.add(res)
+				}
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			header, _ = stream.Header()
+			trailer = stream.Trailer()
+		}
+	}
+	result.Duration = time.Since(start)
+
+	st := status.Convert(err)
+	result.Code, result.Status, result.Message = int(st.Code()), st.Code().String(), st.Message()
+	result.Headers, result.Trailers = flattenMD(header), flattenMD(trailer)
+	return result, nil
+}
+
+// add appends a response in its JSON mapping
+func (r *GRPCResult) add(msg proto.Message) error {
+	data, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	r.Responses = append(r.Responses, m)
+	return nil
+}
+
+// flattenMD joins the values of each metadata key
+func flattenMD(md metadata.MD) map[string]string {
+	flat := make(map[string]string, len(md))
+	for k, v := range md {
+		flat[k] = strings.Join(v, ", ")
+	}
+	return flat
+}
+
+// GRPCMethodToMap converts a method description to a map
+func GRPCMethodToMap(m GRPCMethod) map[string]interface{} {
+	return map[string]interface{}{
+		"name":             m.Name,
+		"input":            m.Input,
+		"output":           m.Output,
+		"client_streaming": m.ClientStreaming,
+		"server_streaming": m.ServerStreaming,
+	}
+}
@@ -0,0 +1,316 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const echoProto = `syntax = "proto3";
+package test.echo;
+
+import "google/protobuf/empty.proto";
+
+message Msg {
+  string text = 1;
+  int32 count = 2;
+  map<string, string> tags = 3;
+}
+
+service Echo {
+  rpc Say(Msg) returns (Msg);
+  rpc Repeat(Msg) returns (stream Msg);
+  rpc Collect(stream Msg) returns (Msg);
+  rpc Chat(stream Msg) returns (stream Msg);
+  rpc Ping(google.protobuf.Empty) returns (Msg);
+}
+`
+
+// fakeEcho serves the Echo service of echoProto without generated code,
+// with server reflection if reflect is set. Say needs an authorization
+// header and answers with its text in upper case.
+func fakeEcho(t *testing.T, reflect bool, opts ...grpc.ServerOption) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "echo.proto")
+	if err := os.WriteFile(path, []byte(echoProto), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := compileProtos([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := files[0].Messages().ByName("Msg")
+	text, count := msg.Fields().ByName("text"), msg.Fields().ByName("count")
+	reply := func(s string) *dynamicpb.Message {
+		m := dynamicpb.NewMessage(msg)
+		m.Set(text, protoreflect.ValueOfString(s))
+		return m
+	}
+
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.echo.Echo",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Say", Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := dynamicpb.NewMessage(msg)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				md, _ := metadata.FromIncomingContext(ctx)
+				if len(md.Get("authorization")) == 0 {
+					return nil, status.Error(codes.PermissionDenied, "no token")
+				}
+				grpc.SetHeader(ctx, metadata.MD{"x-user": md.Get("x-user")})
+				return reply(strings.ToUpper(in.Get(text).String())), nil
+			}},
+			{MethodName: "Ping", Handler: func(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				return reply("pong"), nil
+			}},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Repeat", ServerStreams: true, Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				in := dynamicpb.NewMessage(msg)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				for i := int64(0); i < in.Get(count).Int(); i++ {
+					stream.SendMsg(in)
+				}
+				return nil
+			}},
+			{StreamName: "Collect", ClientStreams: true, Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				var texts []string
+				for {
+					in := dynamicpb.NewMessage(msg)
+					if err := stream.RecvMsg(in); err == io.EOF {
+						break
+					} else if err != nil {
+						return err
+					}
+					texts = append(texts, in.Get(text).String())
+				}
+				return stream.SendMsg(reply(strings.Join(texts, " ")))
+			}},
+			{StreamName: "Chat", ClientStreams: true, ServerStreams: true, Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				for {
+					in := dynamicpb.NewMessage(msg)
+					if err := stream.RecvMsg(in); err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					stream.SendMsg(reply("re: " + in.Get(text).String()))
+				}
+			}},
+		},
+	}, struct{}{})
+	if reflect {
+		registry := new(protoregistry.Files)
+		registry.RegisterFile(files[0])
+		reflectionpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+			Services:           s,
+			DescriptorResolver: fallbackResolver{registry},
+		}))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+	return ln.Addr().String(), path
+}
+
+func TestGRPCCalls(t *testing.T) {
+	addr, _ := fakeEcho(t, true)
+	c, err := GRPCConnect(addr, GRPCOptions{Metadata: map[string]string{"authorization": "Bearer t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var names []string
+	for _, m := range c.Methods() {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, " ") != "test.echo.Echo/Chat test.echo.Echo/Collect test.echo.Echo/Ping test.echo.Echo/Repeat test.echo.Echo/Say" {
+		t.Errorf("methods %v", names)
+	}
+	if m := c.Methods()[0]; !m.ClientStreaming || !m.ServerStreaming || m.Input != "test.echo.Msg" {
+		t.Errorf("chat %+v", m)
+	}
+
+	texts := func(r *GRPCResult) string {
+		var list []string
+		for _, res := range r.Responses {
+			list = append(list, res["text"].(string))
+		}
+		return strings.Join(list, "|")
+	}
+	for _, tc := range []struct {
+		method   string
+		requests []map[string]interface{}
+		want     string
+	}{
+		{"test.echo.Echo/Say", []map[string]interface{}{{"text": "hi", "tags": map[string]interface{}{"a": "b"}}}, "HI"},
+		{"Echo.Say", []map[string]interface{}{{"text": "short name"}}, "SHORT NAME"},
+		{"/test.echo.Echo/Repeat", []map[string]interface{}{{"text": "again", "count": 3}}, "again|again|again"},
+		{"Echo/Collect", []map[string]interface{}{{"text": "a"}, {"text": "b"}, {"text": "c"}}, "a b c"},
+		{"Echo/Chat", []map[string]interface{}{{"text": "one"}, {"text": "two"}}, "re: one|re: two"},
+		{"Echo/Ping", []map[string]interface{}{{}}, "pong"},
+	} {
+		r, err := c.Call(tc.method, tc.requests, nil, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tc.method, err)
+			continue
+		}
+		if r.Status != "OK" || texts(r) != tc.want {
+			t.Errorf("%s: %s %q, want %q", tc.method, r.Status, texts(r), tc.want)
+		}
+	}
+
+	r, err := c.Call("Echo/Say", []map[string]interface{}{{"text": "x"}}, map[string]string{"x-user": "alice"}, time.Second)
+	if err != nil || r.Headers["x-user"] != "alice" || r.Responses[0]["count"] != float64(0) {
+		t.Errorf("headers %+v %v", r, err)
+	}
+	r, err = c.Call("Echo/Say", []map[string]interface{}{{"text": "x"}}, map[string]string{"authorization": ""}, 0)
+	if err != nil || r.Code != int(codes.PermissionDenied) || r.Status != "PermissionDenied" || r.Message != "no token" || len(r.Responses) != 0 {
+		t.Errorf("denied %+v %v", r, err)
+	}
+
+	for _, bad := range []struct {
+		method   string
+		requests []map[string]interface{}
+	}{
+		{"Echo/Say", []map[string]interface{}{{"colour": "red"}}},
+		{"Echo/Say", []map[string]interface{}{{}, {}}},
+		{"Echo/Shout", []map[string]interface{}{{}}},
+		{"Nope/Say", []map[string]interface{}{{}}},
+		{"Say", []map[string]interface{}{{}}},
+	} {
+		if _, err := c.Call(bad.method, bad.requests, nil, 0); err == nil {
+			t.Errorf("%s %v: no error", bad.method, bad.requests)
+		}
+	}
+}
+
+func TestGRPCProtoFiles(t *testing.T) {
+	addr, path := fakeEcho(t, false)
+	if _, err := GRPCConnect(addr, GRPCOptions{}); err == nil || !strings.Contains(err.Error(), "proto files") {
+		t.Errorf("without reflection: %v", err)
+	}
+	c, err := GRPCConnect(addr, GRPCOptions{Protos: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r, err := c.Call("Echo/Repeat", []map[string]interface{}{{"text": "x", "count": 2}}, nil, 0)
+	if err != nil || len(r.Responses) != 2 {
+		t.Errorf("repeat %+v %v", r, err)
+	}
+	if _, err := LookupGRPC(c.ID); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := GRPCConnect(addr, GRPCOptions{Protos: []string{filepath.Join(filepath.Dir(path), "missing.proto")}}); err == nil {
+		t.Error("compiled a missing file")
+	}
+}
+
+// writeCert writes a certificate signed by parent, or self-signed if
+// parent is nil, and its key to dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestGRPCMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	addr, _ := fakeEcho(t, true, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+
+	c, err := GRPCConnect(addr, GRPCOptions{
+		CA:       filepath.Join(dir, "ca.pem"),
+		Cert:     filepath.Join(dir, "client.pem"),
+		Key:      filepath.Join(dir, "client.key"),
+		Metadata: map[string]string{"authorization": "t"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if r, err := c.Call("Echo/Say", []map[string]interface{}{{"text": "tls"}}, nil, 0); err != nil || r.Status != "OK" {
+		t.Errorf("mTLS call %+v %v", r, err)
+	}
+
+	for name, opts := range map[string]GRPCOptions{
+		"no client cert": {CA: filepath.Join(dir, "ca.pem"), Timeout: time.Second},
+		"untrusted CA":   {TLS: true, Cert: filepath.Join(dir, "client.pem"), Key: filepath.Join(dir, "client.key"), Timeout: time.Second},
+		"plaintext":      {Timeout: time.Second},
+		"cert no key":    {Cert: filepath.Join(dir, "client.pem")},
+	} {
+		if _, err := GRPCConnect(addr, opts); err == nil {
+			t.Errorf("%s: connected", name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+// internal/network/hoperr_linux.go
+package network
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sizeofExtendedErr is the size of struct sock_extended_err
+const sizeofExtendedErr = 16
+
+// enableHopErrors asks the kernel to queue the ICMP errors a UDP socket
+// draws, with the address of the router that sent them
+func enableHopErrors(c syscall.Conn, ipv6 bool) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVERR, 1)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVERR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readHopError waits for an ICMP error on the error queue of conn and
+// returns the address that sent it, and whether it ends the trace: any
+// error but time exceeded does
+func readHopError(conn *net.UDPConn, dest net.IP) (net.IP, bool, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	var oobn int
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		_, oobn, _, _, rerr = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE)
+		return rerr != unix.EAGAIN
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if rerr != nil {
+		return nil, false, rerr
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, false, err
+	}
+	for _, msg := range msgs {
+		// struct sock_extended_err is followed by the sockaddr of the
+		// sender; its origin is at byte 4 and the ICMP type at byte 5
+		data := msg.Data
+		if len(data) < sizeofExtendedErr {
+			continue
+		}
+		origin, typ := data[4], data[5]
+		offender := data[sizeofExtendedErr:]
+		switch {
+		case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_RECVERR &&
+			origin == unix.SO_EE_ORIGIN_ICMP && len(offender) >= unix.SizeofSockaddrInet4:
+			return net.IP(append([]byte{}, offender[4:8]...)), typ != 11, nil
+		case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_RECVERR &&
+			origin == unix.SO_EE_ORIGIN_ICMP6 && len(offender) >= unix.SizeofSockaddrInet6:
+			return net.IP(append([]byte{}, offender[8:24]...)), typ != 3, nil
+		}
+	}
+	return nil, false, errors.New("no ICMP error on the socket's error queue")
+}
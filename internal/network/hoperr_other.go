@@ -0,0 +1,26 @@
+//go:build !linux
+
+// internal/network/hoperr_other.go
+package network
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Only Linux reports which router sent an ICMP error to a UDP socket
+func enableHopErrors(c syscall.Conn, ipv6 bool) error {
+	return nil
+}
+
+// readHopError waits for the port unreachable of the destination, which
+// the kernel reports as a refused connection. The routers before it go
+// unnamed.
+func readHopError(conn *net.UDPConn, dest net.IP) (net.IP, bool, error) {
+	_, err := conn.Read(make([]byte, 512))
+	if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+		return dest, true, nil
+	}
+	return nil, false, err
+}
@@ -0,0 +1,520 @@
+// Package network - ICMP echo, ping sweeps and traceroute
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// PingResult is the outcome of pinging a host
+type PingResult struct {
+	Host     string
+	IP       string
+	Method   string // icmp, or udp or tcp where raw sockets are not allowed
+	Sent     int
+	Received int
+	RTTs     []time.Duration // Round trips of the answered probes
+}
+
+// Alive reports whether any probe was answered
+func (r *PingResult) Alive() bool {
+	return r.Received > 0
+}
+
+// Loss returns the percentage of probes that went unanswered
+func (r *PingResult) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) * 100 / float64(r.Sent)
+}
+
+// Stats returns the shortest, average and longest round trip
+func (r *PingResult) Stats() (min, avg, max time.Duration) {
+	if len(r.RTTs) == 0 {
+		return 0, 0, 0
+	}
+	min, max = r.RTTs[0], r.RTTs[0]
+	var total time.Duration
+	for _, rtt := range r.RTTs {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		total += rtt
+	}
+	return min, total / time.Duration(len(r.RTTs)), max
+}
+
+// TracerouteHop is a hop on the path to a host. IP is empty when nothing
+// answered within the timeout.
+type TracerouteHop struct {
+	TTL     int
+	IP      string
+	RTT     time.Duration
+	Reached bool // The destination answered
+}
+
+// icmpFamily holds what differs between ICMP for IPv4 and IPv6
+type icmpFamily struct {
+	network  string
+	address  string
+	ipv6     bool
+	echo     byte // Echo request type
+	reply    byte // Echo reply type
+	exceeded byte // Time exceeded type
+	unreach  byte // Destination unreachable type
+}
+
+var (
+	icmp4 = icmpFamily{"ip4:icmp", "0.0.0.0", false, 8, 0, 11, 3}
+	icmp6 = icmpFamily{"ip6:ipv6-icmp", "::", true, 128, 129, 3, 1}
+)
+
+func familyOf(ip net.IP) icmpFamily {
+	if ip.To4() != nil {
+		return icmp4
+	}
+	return icmp6
+}
+
+// listenICMP opens a raw ICMP socket, which needs root or CAP_NET_RAW.
+// Tests replace it to take the unprivileged path.
+var listenICMP = func(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+// pingInterval is the pause between the probes of a ping, as ping(8) waits
+const pingInterval = time.Second
+
+// sweepWorkers bounds the hosts a ping sweep probes at once
+const sweepWorkers = 64
+
+// maxSweepHosts bounds the size of the subnet a ping sweep accepts, a /16
+const maxSweepHosts = 1 << 16
+
+// tracePort is the first UDP port probed by traceroute, as traceroute(8)
+const tracePort = 33434
+
+// echoID numbers the echo requests of concurrent pings so each only takes
+// its own replies from the raw socket, which sees every ICMP message
+var echoID = uint32(os.Getpid())
+
+func nextEchoID() uint16 {
+	return uint16(atomic.AddUint32(&echoID, 1))
+}
+
+// echoRequest builds an ICMP echo request. The kernel fills in the
+// checksum of ICMPv6 messages, so it is only right for IPv4.
+func echoRequest(typ byte, id, seq uint16) []byte {
+	msg := []byte{typ, 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq)}
+	msg = append(msg, "sentra-ping-payload-0123456789ab"...)
+	sum := icmpChecksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	return msg
+}
+
+// icmpChecksum is the Internet checksum of RFC 1071
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// parse returns the type of an ICMP message and the id and sequence of the
+// echo request it answers: its own for an echo reply, and those of the
+// quoted request for time exceeded and unreachable errors
+func (f icmpFamily) parse(msg []byte) (typ byte, id, seq uint16, ok bool) {
+	if len(msg) < 8 {
+		return 0, 0, 0, false
+	}
+	typ = msg[0]
+	switch typ {
+	case f.reply:
+		return typ, uint16(msg[4])<<8 | uint16(msg[5]), uint16(msg[6])<<8 | uint16(msg[7]), true
+	case f.exceeded, f.unreach:
+		quoted := msg[8:]
+		header := 40
+		if !f.ipv6 {
+			if len(quoted) == 0 {
+				return 0, 0, 0, false
+			}
+			header = int(quoted[0]&0x0f) * 4
+		}
+		if len(quoted) < header+8 || quoted[header] != f.echo {
+			return 0, 0, 0, false
+		}
+		original := quoted[header:]
+		return typ, uint16(original[4])<<8 | uint16(original[5]), uint16(original[6])<<8 | uint16(original[7]), true
+	}
+	return 0, 0, 0, false
+}
+
+// resolveIP returns the address of a host, preferring IPv4
+func resolveIP(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	return ips[0], nil
+}
+
+// Ping sends count echo requests to host, a second apart, and waits up to
+// timeout for each reply. Without permission to open a raw socket it
+// probes with UDP and TCP instead, which also finds hosts that drop ICMP.
+func (n *NetworkModule) Ping(host string, count int, timeout time.Duration) (*PingResult, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("ping count must be at least 1, got %d", count)
+	}
+	ip, err := resolveIP(host)
+	if err != nil {
+		return nil, err
+	}
+	result := &PingResult{Host: host, IP: ip.String(), Method: "icmp"}
+
+	f := familyOf(ip)
+	conn, err := listenICMP(f.network, f.address)
+	if err == nil {
+		defer conn.Close()
+	} else {
+		result.Method = "udp"
+	}
+	id := nextEchoID()
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			time.Sleep(pingInterval)
+		}
+		result.Sent++
+		var rtt time.Duration
+		var ok bool
+		if conn != nil {
+			rtt, ok, err = echo(conn, f, ip, id, uint16(seq), timeout)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			var method string
+			if rtt, method, ok = probeUnprivileged(ip, timeout); ok {
+				result.Method = method
+			}
+		}
+		if ok {
+			result.Received++
+			result.RTTs = append(result.RTTs, rtt)
+		}
+	}
+	return result, nil
+}
+
+// echo sends an echo request over a raw socket and waits for its reply
+func echo(conn net.PacketConn, f icmpFamily, ip net.IP, id, seq uint16, timeout time.Duration) (time.Duration, bool, error) {
+	start := time.Now()
+	if _, err := conn.WriteTo(echoRequest(f.echo, id, seq), &net.IPAddr{IP: ip}); err != nil {
+		return 0, false, err
+	}
+	if err := conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return 0, false, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		size, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		typ, replyID, replySeq, ok := f.parse(buf[:size])
+		if !ok || typ != f.reply || replyID != id || replySeq != seq {
+			continue
+		}
+		if addr, isIP := from.(*net.IPAddr); isIP && addr.IP.Equal(ip) {
+			return time.Since(start), true, nil
+		}
+	}
+}
+
+// probeUnprivileged looks for any answer from ip without a raw socket. A
+// UDP datagram to a closed port draws a port unreachable, which the kernel
+// reports as a refused connection, and open and closed TCP ports both
+// answer a handshake. It returns the round trip of the first answer and
+// the protocol that got it.
+func probeUnprivileged(ip net.IP, timeout time.Duration) (time.Duration, string, bool) {
+	type answer struct {
+		rtt    time.Duration
+		method string
+	}
+	answers := make(chan answer, 4)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.DialTimeout("udp", net.JoinHostPort(ip.String(), fmt.Sprint(tracePort)), timeout)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(start.Add(timeout))
+		if _, err := conn.Write([]byte("sentra")); err != nil {
+			return
+		}
+		_, err = conn.Read(make([]byte, 512))
+		if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			answers <- answer{time.Since(start), "udp"}
+		}
+	}()
+	for _, port := range []string{"80", "443", "22"} {
+		wg.Add(1)
+		go func(port string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), timeout)
+			if err == nil {
+				conn.Close()
+			}
+			if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+				answers <- answer{time.Since(start), "tcp"}
+			}
+		}(port)
+	}
+	go func() {
+		wg.Wait()
+		close(answers)
+	}()
+
+	if a, ok := <-answers; ok {
+		return a.rtt, a.method, true
+	}
+	return 0, "", false
+}
+
+// PingSweep pings every address of a subnet once and returns the hosts
+// that answered, in address order
+func (n *NetworkModule) PingSweep(subnet string, timeout time.Duration) ([]PingResult, error) {
+	hosts, err := subnetHosts(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*PingResult, len(hosts))
+	jobs := make(chan int)
+	errs := make(chan error, sweepWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < sweepWorkers && w < len(hosts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result, err := n.Ping(hosts[i], 1, timeout)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := range hosts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
+	alive := []PingResult{}
+	for _, result := range results {
+		if result != nil && result.Alive() {
+			alive = append(alive, *result)
+		}
+	}
+	return alive, nil
+}
+
+// subnetHosts lists the addresses of a CIDR subnet, leaving out the
+// network and broadcast addresses of IPv4 subnets larger than /31, or the
+// address itself when given a single IP
+func subnetHosts(subnet string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		if ip := net.ParseIP(subnet); ip != nil {
+			return []string{ip.String()}, nil
+		}
+		return nil, fmt.Errorf("invalid subnet or IP: %s", subnet)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("subnet %s has more than %d addresses", subnet, maxSweepHosts)
+	}
+
+	hosts := []string{}
+	for ip := ipNet.IP.Mask(ipNet.Mask); ipNet.Contains(ip); incrementIP(ip) {
+		hosts = append(hosts, ip.String())
+	}
+	if ipNet.IP.To4() != nil && bits-ones > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// Traceroute finds the routers on the path to dest, up to maxHops away,
+// waiting up to timeout for each to answer. It sends ICMP echo requests
+// with a growing TTL over a raw socket, or UDP datagrams where raw sockets
+// are not allowed, which only name the routers on Linux; elsewhere the
+// hops before the destination show no address.
+func (n *NetworkModule) Traceroute(dest string, maxHops int, timeout time.Duration) ([]TracerouteHop, error) {
+	if maxHops < 1 || maxHops > 255 {
+		return nil, fmt.Errorf("traceroute max hops must be between 1 and 255, got %d", maxHops)
+	}
+	ip, err := resolveIP(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	f := familyOf(ip)
+	if conn, err := listenICMP(f.network, f.address); err == nil {
+		hops, err := traceICMP(conn, f, ip, maxHops, timeout)
+		conn.Close()
+		if err == nil {
+			return hops, nil
+		}
+	}
+	return traceUDP(ip, maxHops, timeout)
+}
+
+// traceICMP traces the path to ip with echo requests over a raw socket
+func traceICMP(conn net.PacketConn, f icmpFamily, ip net.IP, maxHops int, timeout time.Duration) ([]TracerouteHop, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("cannot set the TTL of the ICMP socket")
+	}
+	id := nextEchoID()
+	buf := make([]byte, 1500)
+	hops := []TracerouteHop{}
+
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if err := setHopLimit(sc, f.ipv6, ttl); err != nil {
+			return nil, err
+		}
+		hop := TracerouteHop{TTL: ttl}
+		start := time.Now()
+		if _, err := conn.WriteTo(echoRequest(f.echo, id, uint16(ttl)), &net.IPAddr{IP: ip}); err != nil {
+			return nil, err
+		}
+		if err := conn.SetReadDeadline(start.Add(timeout)); err != nil {
+			return nil, err
+		}
+		final := false
+		for {
+			size, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return nil, err
+			}
+			typ, replyID, seq, ok := f.parse(buf[:size])
+			addr, isIP := from.(*net.IPAddr)
+			if !ok || !isIP || replyID != id || seq != uint16(ttl) {
+				continue
+			}
+			if typ == f.reply && !addr.IP.Equal(ip) {
+				continue
+			}
+			hop.IP = addr.IP.String()
+			hop.RTT = time.Since(start)
+			hop.Reached = addr.IP.Equal(ip)
+			final = typ != f.exceeded
+			break
+		}
+		hops = append(hops, hop)
+		if final {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// traceUDP traces the path to ip with UDP datagrams to unused ports, which
+// the destination answers with a port unreachable
+func traceUDP(ip net.IP, maxHops int, timeout time.Duration) ([]TracerouteHop, error) {
+	ipv6 := ip.To4() == nil
+	hops := []TracerouteHop{}
+
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: tracePort + ttl})
+		if err != nil {
+			return nil, err
+		}
+		hop, final, err := udpHop(conn, ip, ipv6, ttl, timeout)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+		if final {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// udpHop sends one traceroute datagram and reads the ICMP error it draws
+func udpHop(conn *net.UDPConn, ip net.IP, ipv6 bool, ttl int, timeout time.Duration) (TracerouteHop, bool, error) {
+	hop := TracerouteHop{TTL: ttl}
+	if err := setHopLimit(conn, ipv6, ttl); err != nil {
+		return hop, false, fmt.Errorf("traceroute needs a raw socket here: %w", err)
+	}
+	if err := enableHopErrors(conn, ipv6); err != nil {
+		return hop, false, err
+	}
+	start := time.Now()
+	if err := conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return hop, false, err
+	}
+	if _, err := conn.Write([]byte("sentra")); err != nil {
+		return hop, false, err
+	}
+	from, final, err := readHopError(conn, ip)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return hop, false, nil
+		}
+		return hop, false, err
+	}
+	hop.IP = from.String()
+	hop.RTT = time.Since(start)
+	hop.Reached = from.Equal(ip)
+	return hop, final, nil
+}
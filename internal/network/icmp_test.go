@@ -0,0 +1,134 @@
+package network
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withoutRawSockets makes the test take the unprivileged path, as a user
+// without CAP_NET_RAW would
+func withoutRawSockets(t *testing.T) {
+	t.Helper()
+	listen := listenICMP
+	listenICMP = func(network, address string) (net.PacketConn, error) {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: os.ErrPermission}
+	}
+	t.Cleanup(func() { listenICMP = listen })
+}
+
+func TestEchoRequest(t *testing.T) {
+	msg := echoRequest(8, 0x1234, 7)
+	if msg[0] != 8 || msg[4] != 0x12 || msg[5] != 0x34 || msg[7] != 7 {
+		t.Fatalf("bad header: % x", msg[:8])
+	}
+	if sum := icmpChecksum(msg); sum != 0 {
+		t.Errorf("checksum over the message = %#x, want 0", sum)
+	}
+}
+
+func TestParseICMP(t *testing.T) {
+	reply := echoRequest(0, 42, 3)
+	if typ, id, seq, ok := icmp4.parse(reply); !ok || typ != 0 || id != 42 || seq != 3 {
+		t.Errorf("echo reply: got %d %d %d %v", typ, id, seq, ok)
+	}
+
+	// A time exceeded quotes the IP header and the start of the request
+	header := make([]byte, 20)
+	header[0] = 0x45
+	exceeded := append([]byte{11, 0, 0, 0, 0, 0, 0, 0}, header...)
+	exceeded = append(exceeded, echoRequest(8, 42, 5)[:8]...)
+	if typ, id, seq, ok := icmp4.parse(exceeded); !ok || typ != 11 || id != 42 || seq != 5 {
+		t.Errorf("time exceeded: got %d %d %d %v", typ, id, seq, ok)
+	}
+
+	if _, _, _, ok := icmp4.parse(exceeded[:20]); ok {
+		t.Error("parsed a truncated error")
+	}
+	if _, _, _, ok := icmp4.parse(echoRequest(8, 42, 1)); ok {
+		t.Error("parsed an echo request as an answer")
+	}
+}
+
+func TestSubnetHosts(t *testing.T) {
+	hosts, err := subnetHosts("192.0.2.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got %v, want %v", hosts, want)
+	}
+	if hosts, _ := subnetHosts("192.0.2.0/31"); len(hosts) != 2 {
+		t.Errorf("/31 has 2 hosts, got %v", hosts)
+	}
+	if hosts, _ := subnetHosts("192.0.2.9"); !reflect.DeepEqual(hosts, []string{"192.0.2.9"}) {
+		t.Errorf("single IP: got %v", hosts)
+	}
+	if _, err := subnetHosts("10.0.0.0/8"); err == nil {
+		t.Error("accepted a /8")
+	}
+	if _, err := subnetHosts("not-a-subnet"); err == nil {
+		t.Error("accepted an invalid subnet")
+	}
+}
+
+func TestPingLoopback(t *testing.T) {
+	n := NewNetworkModule()
+	result, err := n.Ping("127.0.0.1", 1, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Alive() || result.Sent != 1 || result.Loss() != 0 || len(result.RTTs) != 1 {
+		t.Errorf("got %+v", result)
+	}
+
+	if _, err := n.Ping("127.0.0.1", 0, time.Second); err == nil {
+		t.Error("accepted a count of 0")
+	}
+}
+
+func TestPingUnprivileged(t *testing.T) {
+	withoutRawSockets(t)
+	n := NewNetworkModule()
+	result, err := n.Ping("127.0.0.1", 1, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Alive() || (result.Method != "udp" && result.Method != "tcp") {
+		t.Errorf("got %+v", result)
+	}
+
+}
+
+func TestPingSweep(t *testing.T) {
+	withoutRawSockets(t)
+	n := NewNetworkModule()
+	results, err := n.PingSweep("127.0.0.0/30", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].IP != "127.0.0.1" || results[1].IP != "127.0.0.2" {
+		t.Errorf("got %+v", results)
+	}
+}
+
+func TestTracerouteLoopback(t *testing.T) {
+	for _, raw := range []bool{true, false} {
+		if !raw {
+			withoutRawSockets(t)
+		}
+		hops, err := NewNetworkModule().Traceroute("127.0.0.1", 5, time.Second)
+		if err != nil {
+			t.Fatalf("raw %v: %v", raw, err)
+		}
+		if len(hops) != 1 || hops[0].TTL != 1 || hops[0].IP != "127.0.0.1" || !hops[0].Reached {
+			t.Errorf("raw %v: got %+v", raw, hops)
+		}
+	}
+
+	if _, err := NewNetworkModule().Traceroute("127.0.0.1", 0, time.Second); err == nil {
+		t.Error("accepted 0 max hops")
+	}
+}
@@ -0,0 +1,585 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An IMAP client for fetching delivered and quarantined test messages
+
+// IMAPOptions configures an IMAP connection and the messages to fetch.
+// TLS is "implicit" for TLS from the start, "starttls" or "none". The
+// search criteria are ANDed; Search is raw IMAP SEARCH criteria.
+type IMAPOptions struct {
+	Port     int    // 993 by default, 143 for starttls and none
+	TLS      string // "implicit" by default, "starttls" on port 143
+	Username string
+	Password string
+	Mailbox  string // INBOX by default
+	Search   string
+	From     string
+	Subject  string
+	Since    time.Time
+	Unseen   bool
+	Limit    int           // Newest messages to fetch, 50 by default
+	Timeout  time.Duration // 10 seconds if 0
+	Insecure bool          // Skip verifying the server's certificate
+}
+
+func (o IMAPOptions) withDefaults() IMAPOptions {
+	if o.TLS == "" {
+		o.TLS = "implicit"
+		if o.Port == 143 {
+			o.TLS = "starttls"
+		}
+	}
+	o.TLS = strings.ToLower(o.TLS)
+	if o.Port == 0 {
+		o.Port = 993
+		if o.TLS != "implicit" {
+			o.Port = 143
+		}
+	}
+	if o.Mailbox == "" {
+		o.Mailbox = "INBOX"
+	}
+	if o.Limit <= 0 {
+		o.Limit = 50
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// IMAPMessage is a fetched message. Headers holds the first value of each
+// header; AuthResults the Authentication-Results headers the receiving
+// servers added.
+type IMAPMessage struct {
+	UID         int
+	Flags       []string
+	Size        int
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	Date        string
+	MessageID   string
+	Headers     map[string]string
+	AuthResults []string
+	Text        string
+	HTML        string
+	Attachments []MailAttachment
+}
+
+// imapConn is a logged in IMAP connection
+type imapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	tag     int
+	timeout time.Duration
+}
+
+// dialIMAP connects and logs in to an IMAP server
+func dialIMAP(host string, opts IMAPOptions) (*imapConn, error) {
+	switch opts.TLS {
+	case "implicit", "starttls", "none":
+	default:
+		return nil, fmt.Errorf("unknown TLS mode '%s', want implicit, starttls or none", opts.TLS)
+	}
+	if opts.TLS == "none" && !isLoopback(host) {
+		return nil, errors.New("refusing to send credentials without TLS")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(opts.Port)), opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: opts.Insecure}
+	if opts.TLS == "implicit" {
+		conn = tls.Client(conn, config)
+	}
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn), timeout: opts.Timeout}
+
+	conn.SetDeadline(time.Now().Add(opts.Timeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(greeting) < 2 || greeting[0] != "*" || greeting[1] == "BYE" {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", imapText(greeting))
+	}
+
+	if opts.TLS == "starttls" {
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %v", err)
+		}
+		c.conn = tls.Client(conn, config)
+		c.r = bufio.NewReader(c.conn)
+	}
+	if _, err := c.command("LOGIN %s %s", imapQuote(opts.Username), imapQuote(opts.Password)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("login failed: %v", err)
+	}
+	return c, nil
+}
+
+// command sends a tagged command and returns the untagged responses that
+// came before its completion
+func (c *imapConn) command(format string, args ...interface{}) ([][]interface{}, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var untagged [][]interface{}
+	for {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		response, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if len(response) == 0 {
+			continue
+		}
+		switch response[0] {
+		case "*":
+			untagged = append(untagged, response[1:])
+		case tag:
+			if len(response) < 2 || !strings.EqualFold(imapString(response[1]), "OK") {
+				return untagged, errors.New(imapText(response[1:]))
+			}
+			return untagged, nil
+		}
+	}
+}
+
+// Close logs out and closes the connection
+func (c *imapConn) Close() error {
+	c.timeout = time.Second
+	c.command("LOGOUT")
+	return c.conn.Close()
+}
+
+// readResponse reads one response line and the literals in it. Atoms and
+// strings are returned as strings, parenthesized lists as slices and NIL
+// as nil. The text after a status such as OK is one string.
+func (c *imapConn) readResponse() ([]interface{}, error) {
+	var items []interface{}
+	for {
+		item, end, err := c.readItem()
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return items, nil
+		}
+		items = append(items, item)
+		if len(items) == 2 {
+			switch strings.ToUpper(imapString(item)) {
+			case "OK", "NO", "BAD", "BYE", "PREAUTH":
+				text, err := c.r.ReadString('\n')
+				if err != nil {
+					return nil, err
+				}
+				if text = strings.TrimSpace(text); text != "" {
+					items = append(items, text)
+				}
+				return items, nil
+			}
+		}
+	}
+}
+
+// readItem reads the next item of a response, or reports the end of the
+// line or list
+func (c *imapConn) readItem() (interface{}, bool, error) {
+	b, err := c.r.ReadByte()
+	for err == nil && b == ' ' {
+		b, err = c.r.ReadByte()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch b {
+	case '\r':
+		_, err := c.r.ReadByte()
+		return nil, true, err
+	case '\n', ')':
+		return nil, true, nil
+	case '(':
+		list := []interface{}{}
+		for {
+			item, end, err := c.readItem()
+			if err != nil {
+				return nil, false, err
+			}
+			if end {
+				return list, false, nil
+			}
+			list = append(list, item)
+		}
+	case '"':
+		var s []byte
+		for {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return nil, false, err
+			}
+			if b == '"' {
+				return string(s), false, nil
+			}
+			if b == '\\' {
+				if b, err = c.r.ReadByte(); err != nil {
+					return nil, false, err
+				}
+			}
+			s = append(s, b)
+		}
+	case '{':
+		header, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, false, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(header), "}"))
+		if err != nil || size < 0 {
+			return nil, false, fmt.Errorf("bad literal size {%s", header)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return nil, false, err
+		}
+		return string(literal), false, nil
+	}
+
+	// An atom, which may hold a bracketed section such as BODY[]
+	atom := []byte{b}
+	depth := 0
+	if b == '[' {
+		depth++
+	}
+	for {
+		next, err := c.r.Peek(1)
+		if err != nil {
+			return nil, false, err
+		}
+		switch b := next[0]; {
+		case b == '[':
+			depth++
+		case b == ']':
+			depth--
+		case depth == 0 && (b == ' ' || b == '(' || b == ')' || b == '\r' || b == '\n'):
+			if string(atom) == "NIL" {
+				return nil, false, nil
+			}
+			return string(atom), false, nil
+		}
+		c.r.ReadByte()
+		atom = append(atom, next[0])
+	}
+}
+
+// imapQuote returns s as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", "").Replace(s) + `"`
+}
+
+func imapString(item interface{}) string {
+	s, _ := item.(string)
+	return s
+}
+
+// imapText joins the items of a response for an error message
+func imapText(items []interface{}) string {
+	var parts []string
+	for _, item := range items {
+		parts = append(parts, fmt.Sprint(item))
+	}
+	return strings.Join(parts, " ")
+}
+
+// IMAPMailboxes lists the mailboxes of an account, to find the one a
+// filter quarantines messages to
+func IMAPMailboxes(host string, opts IMAPOptions) ([]string, error) {
+	opts = opts.withDefaults()
+	c, err := dialIMAP(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	responses, err := c.command(`LIST "" "*"`)
+	if err != nil {
+		return nil, err
+	}
+	mailboxes := []string{}
+	for _, r := range responses {
+		if len(r) >= 4 && strings.EqualFold(imapString(r[0]), "LIST") {
+			mailboxes = append(mailboxes, imapString(r[3]))
+		}
+	}
+	sort.Strings(mailboxes)
+	return mailboxes, nil
+}
+
+// searchCriteria returns the IMAP SEARCH criteria of the options
+func (o IMAPOptions) searchCriteria() string {
+	var criteria []string
+	if o.Search != "" {
+		criteria = append(criteria, o.Search)
+	}
+	if o.From != "" {
+		criteria = append(criteria, "FROM "+imapQuote(o.From))
+	}
+	if o.Subject != "" {
+		criteria = append(criteria, "SUBJECT "+imapQuote(o.Subject))
+	}
+	if !o.Since.IsZero() {
+		criteria = append(criteria, "SINCE "+o.Since.Format("2-Jan-2006"))
+	}
+	if o.Unseen {
+		criteria = append(criteria, "UNSEEN")
+	}
+	if len(criteria) == 0 {
+		return "ALL"
+	}
+	return strings.Join(criteria, " ")
+}
+
+// FetchMail fetches the newest messages of a mailbox that match the search
+// criteria, oldest first, without marking them seen
+func FetchMail(host string, opts IMAPOptions) ([]*IMAPMessage, error) {
+	opts = opts.withDefaults()
+	c, err := dialIMAP(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := c.command("EXAMINE %s", imapQuote(opts.Mailbox)); err != nil {
+		return nil, fmt.Errorf("cannot open mailbox %s: %v", opts.Mailbox, err)
+	}
+	responses, err := c.command("UID SEARCH %s", opts.searchCriteria())
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+	var uids []int
+	for _, r := range responses {
+		if len(r) > 0 && strings.EqualFold(imapString(r[0]), "SEARCH") {
+			for _, item := range r[1:] {
+				if uid, err := strconv.Atoi(imapString(item)); err == nil {
+					uids = append(uids, uid)
+				}
+			}
+		}
+	}
+	sort.Ints(uids)
+	if len(uids) > opts.Limit {
+		uids = uids[len(uids)-opts.Limit:]
+	}
+	messages := []*IMAPMessage{}
+	if len(uids) == 0 {
+		return messages, nil
+	}
+
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.Itoa(uid)
+	}
+	responses, err = c.command("UID FETCH %s (UID FLAGS RFC822.SIZE BODY.PEEK[])", strings.Join(set, ","))
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	for _, r := range responses {
+		if len(r) < 3 || !strings.EqualFold(imapString(r[1]), "FETCH") {
+			continue
+		}
+		attrs, _ := r[2].([]interface{})
+		msg := &IMAPMessage{}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			switch strings.ToUpper(imapString(attrs[i])) {
+			case "UID":
+				msg.UID, _ = strconv.Atoi(imapString(attrs[i+1]))
+			case "RFC822.SIZE":
+				msg.Size, _ = strconv.Atoi(imapString(attrs[i+1]))
+			case "FLAGS":
+				flags, _ := attrs[i+1].([]interface{})
+				for _, flag := range flags {
+					msg.Flags = append(msg.Flags, imapString(flag))
+				}
+			case "BODY[]":
+				if err := msg.parse([]byte(imapString(attrs[i+1]))); err != nil {
+					return nil, fmt.Errorf("message %d: %v", msg.UID, err)
+				}
+			}
+		}
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].UID < messages[j].UID })
+	return messages, nil
+}
+
+// parse fills in the headers, bodies and attachments of a message from
+// its RFC 5322 form
+func (m *IMAPMessage) parse(raw []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	decoder := new(mime.WordDecoder)
+	decode := func(s string) string {
+		if decoded, err := decoder.DecodeHeader(s); err == nil {
+			return decoded
+		}
+		return s
+	}
+	addresses := func(key string) []string {
+		list, err := msg.Header.AddressList(key)
+		if err != nil {
+			if value := msg.Header.Get(key); value != "" {
+				return []string{decode(value)}
+			}
+			return nil
+		}
+		out := make([]string, len(list))
+		for i, addr := range list {
+			out[i] = addr.Address
+		}
+		return out
+	}
+
+	m.Headers = map[string]string{}
+	for key, values := range msg.Header {
+		m.Headers[key] = decode(values[0])
+	}
+	if from := addresses("From"); len(from) > 0 {
+		m.From = from[0]
+	}
+	m.To, m.Cc = addresses("To"), addresses("Cc")
+	m.Subject = decode(msg.Header.Get("Subject"))
+	m.Date = msg.Header.Get("Date")
+	m.MessageID = msg.Header.Get("Message-Id")
+	m.AuthResults = msg.Header["Authentication-Results"]
+	return m.parsePart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body)
+}
+
+// parsePart reads a MIME part into the text or HTML body, or the
+// attachments, descending into multipart parts
+func (m *IMAPMessage) parsePart(contentType, encoding, disposition string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = m.parsePart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.Header.Get("Content-Disposition"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &lineStripper{r: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	kind, dispParams, _ := mime.ParseMediaType(disposition)
+	name := dispParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	switch {
+	case kind != "attachment" && name == "" && mediaType == "text/plain" && m.Text == "":
+		m.Text = string(data)
+	case kind != "attachment" && name == "" && mediaType == "text/html" && m.HTML == "":
+		m.HTML = string(data)
+	default:
+		m.Attachments = append(m.Attachments, MailAttachment{Name: name, ContentType: mediaType, Data: data})
+	}
+	return nil
+}
+
+// lineStripper drops the line breaks of base64 content
+type lineStripper struct {
+	r io.Reader
+}
+
+func (l *lineStripper) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// IMAPMessageToMap returns a fetched message as a map. Attachments are
+// maps of their name, content type, size and data.
+func IMAPMessageToMap(m *IMAPMessage) map[string]interface{} {
+	headers := map[string]interface{}{}
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	attachments := make([]interface{}, len(m.Attachments))
+	for i, a := range m.Attachments {
+		attachments[i] = map[string]interface{}{
+			"name":         a.Name,
+			"content_type": a.ContentType,
+			"size":         len(a.Data),
+			"data":         a.Data,
+		}
+	}
+	return map[string]interface{}{
+		"uid":          m.UID,
+		"flags":        stringsToInterfaces(m.Flags),
+		"size":         m.Size,
+		"from":         m.From,
+		"to":           stringsToInterfaces(m.To),
+		"cc":           stringsToInterfaces(m.Cc),
+		"subject":      m.Subject,
+		"date":         m.Date,
+		"message_id":   m.MessageID,
+		"headers":      headers,
+		"auth_results": stringsToInterfaces(m.AuthResults),
+		"text":         m.Text,
+		"html":         m.HTML,
+		"attachments":  attachments,
+	}
+}
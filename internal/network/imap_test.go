@@ -0,0 +1,138 @@
+package network
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const quarantined = "Authentication-Results: mx.example.test; spf=fail smtp.mailfrom=evil.test; dmarc=fail header.from=bank.test\r\n" +
+	"From: \"Bank\" <attacker@evil.test>\r\n" +
+	"To: victim@example.test\r\n" +
+	"Subject: =?utf-8?q?Konto_gesperrt?=\r\n" +
+	"Message-ID: <1@evil.test>\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Open the attachment.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream; name=\"login.html\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PGZvcm0+PC9m\r\n" +
+	"b3JtPg==\r\n" +
+	"--b1--\r\n"
+
+// fakeIMAP serves a mailbox Junk with the messages of uids 7, 9 and 12,
+// and sends the SEARCH criteria it gets to searches
+func fakeIMAP(t *testing.T, config *tls.Config, searches chan<- string) (string, int) {
+	messages := map[string]string{
+		"7":  "From: a@example.test\r\nSubject: old\r\n\r\nold\r\n",
+		"9":  "From: attacker@evil.test\r\nSubject: =?utf-8?b?UmVjaG51bmc=?=\r\n\r\nfirst\r\n",
+		"12": quarantined,
+	}
+	return serveTCP(t, func(conn net.Conn) {
+		if config != nil {
+			conn = tls.Server(conn, config)
+		}
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			switch {
+			case strings.HasPrefix(command, "LOGIN "):
+				if command == `LOGIN "user" "pa\"ss"` {
+					fmt.Fprintf(conn, "%s OK Logged in\r\n", tag)
+				} else {
+					fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] Authentication failed.\r\n", tag)
+				}
+			case command == `LIST "" "*"`:
+				fmt.Fprint(conn, "* LIST (\\HasNoChildren) \"/\" INBOX\r\n* LIST (\\HasNoChildren \\Junk) \"/\" \"Junk\"\r\n* LIST () \"/\" {15}\r\nQuarantine/Held\r\n")
+				fmt.Fprintf(conn, "%s OK List completed\r\n", tag)
+			case command == `EXAMINE "Junk"`:
+				fmt.Fprintf(conn, "* FLAGS (\\Answered \\Seen)\r\n* 3 EXISTS\r\n* OK [UIDVALIDITY 1700000000] UIDs valid\r\n%s OK [READ-ONLY] Examine completed\r\n", tag)
+			case strings.HasPrefix(command, "EXAMINE "):
+				fmt.Fprintf(conn, "%s NO Mailbox doesn't exist\r\n", tag)
+			case strings.HasPrefix(command, "UID SEARCH "):
+				searches <- strings.TrimPrefix(command, "UID SEARCH ")
+				fmt.Fprintf(conn, "* SEARCH 7 12 9\r\n%s OK Search completed\r\n", tag)
+			case strings.HasPrefix(command, "UID FETCH "):
+				set, _, _ := strings.Cut(strings.TrimPrefix(command, "UID FETCH "), " ")
+				for i, uid := range strings.Split(set, ",") {
+					msg := messages[uid]
+					fmt.Fprintf(conn, "* %d FETCH (UID %s FLAGS (\\Recent) RFC822.SIZE %d BODY[] {%d}\r\n%s)\r\n", i+1, uid, len(msg), len(msg), msg)
+				}
+				fmt.Fprintf(conn, "%s OK Fetch completed\r\n", tag)
+			case command == "LOGOUT":
+				fmt.Fprintf(conn, "* BYE Logging out\r\n%s OK Logout completed\r\n", tag)
+				return
+			default:
+				fmt.Fprintf(conn, "%s BAD Error in IMAP command\r\n", tag)
+			}
+		}
+	})
+}
+
+func TestFetchMail(t *testing.T) {
+	searches := make(chan string, 1)
+	host, port := fakeIMAP(t, testTLSConfig(t), searches)
+	opts := IMAPOptions{
+		Port: port, Username: "user", Password: `pa"ss`, Insecure: true, Timeout: 2 * time.Second,
+		Mailbox: "Junk", From: "evil.test", Since: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Unseen: true, Limit: 2,
+	}
+	messages, err := FetchMail(host, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search := <-searches; search != `FROM "evil.test" SINCE 5-Mar-2024 UNSEEN` {
+		t.Errorf("search %q", search)
+	}
+	if len(messages) != 2 || messages[0].UID != 9 || messages[0].Subject != "Rechnung" || messages[0].Text != "first\r\n" {
+		t.Fatalf("got %+v", messages)
+	}
+
+	m := messages[1]
+	if m.UID != 12 || m.From != "attacker@evil.test" || m.Subject != "Konto gesperrt" || !reflect.DeepEqual(m.To, []string{"victim@example.test"}) || !reflect.DeepEqual(m.Flags, []string{`\Recent`}) {
+		t.Errorf("got %+v", m)
+	}
+	if len(m.AuthResults) != 1 || !strings.Contains(m.AuthResults[0], "dmarc=fail") || m.MessageID != "<1@evil.test>" || m.Size != len(quarantined) {
+		t.Errorf("auth results %q, message id %q, size %d", m.AuthResults, m.MessageID, m.Size)
+	}
+	if m.Text != "Open the attachment." || len(m.Attachments) != 1 || m.Attachments[0].Name != "login.html" || string(m.Attachments[0].Data) != "<form></form>" {
+		t.Errorf("text %q, attachments %+v", m.Text, m.Attachments)
+	}
+
+	opts.Mailbox = "Spam"
+	if _, err := FetchMail(host, opts); err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Errorf("missing mailbox: %v", err)
+	}
+	opts.Password = "wrong"
+	if _, err := FetchMail(host, opts); err == nil || !strings.Contains(err.Error(), "AUTHENTICATIONFAILED") {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+func TestIMAPMailboxes(t *testing.T) {
+	host, port := fakeIMAP(t, nil, nil)
+	mailboxes, err := IMAPMailboxes(host, IMAPOptions{Port: port, TLS: "none", Username: "user", Password: `pa"ss`, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mailboxes, []string{"INBOX", "Junk", "Quarantine/Held"}) {
+		t.Errorf("got %q", mailboxes)
+	}
+	if _, err := IMAPMailboxes("192.0.2.1", IMAPOptions{TLS: "none"}); err == nil {
+		t.Error("sent credentials in the clear to a remote server")
+	}
+}
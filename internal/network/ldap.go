@@ -0,0 +1,616 @@
+package network
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAP searches and Active Directory security queries
+
+// LDAPOptions configures an LDAP connection. TLS is "ldaps" for TLS from
+// the start, "starttls" or "none". With Domain set the bind is NTLM, with
+// Password or Hash, the NT hash in hex; otherwise it is a simple bind of
+// Username, which may be a DN or user@domain. Without a username the
+// connection stays anonymous.
+type LDAPOptions struct {
+	Port     int    // 636 for ldaps, 389 otherwise
+	TLS      string // "ldaps" on port 636 and 3269, "starttls" otherwise
+	Username string
+	Password string
+	Domain   string
+	Hash     string
+	BaseDN   string        // The defaultNamingContext of the server if empty
+	Timeout  time.Duration // 10 seconds if 0
+	Insecure bool          // Skip verifying the server's certificate
+}
+
+// LDAPConn is an open LDAP connection
+type LDAPConn struct {
+	ID     string
+	Host   string
+	BaseDN string
+	conn   *ldap.Conn
+}
+
+// LDAPSearchOptions narrows a search. Scope is "sub", "one" or "base".
+type LDAPSearchOptions struct {
+	Base       string // The connection's base DN if empty
+	Scope      string // "sub" by default
+	Attributes []string
+	SizeLimit  int
+	PageSize   int // 500 by default
+}
+
+// LDAPEntry is an entry found by a search. Values are strings, SIDs and
+// GUIDs in their text forms, or []byte for other binary values.
+type LDAPEntry struct {
+	DN         string
+	Attributes map[string][]interface{}
+}
+
+// ldapConns holds the open connections by id
+var ldapConns = make(map[string]*LDAPConn)
+
+// LDAPConnect connects and binds to an LDAP server and finds its base DN
+func LDAPConnect(host string, opts LDAPOptions) (*LDAPConn, error) {
+	if opts.TLS == "" {
+		opts.TLS = "starttls"
+		if opts.Port == 636 || opts.Port == 3269 {
+			opts.TLS = "ldaps"
+		}
+	}
+	opts.TLS = strings.ToLower(opts.TLS)
+	scheme := "ldap"
+	switch opts.TLS {
+	case "ldaps":
+		scheme = "ldaps"
+		if opts.Port == 0 {
+			opts.Port = 636
+		}
+	case "starttls", "none":
+		if opts.Port == 0 {
+			opts.Port = 389
+		}
+	default:
+		return nil, fmt.Errorf("unknown TLS mode '%s', want ldaps, starttls or none", opts.TLS)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.TLS == "none" && opts.Domain == "" && opts.Username != "" && !isLoopback(host) {
+		return nil, errors.New("refusing a simple bind without TLS, use ldaps, starttls or an NTLM bind with a domain")
+	}
+
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: opts.Insecure}
+	url := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(opts.Port)))
+	conn, err := ldap.DialURL(url, ldap.DialWithDialer(&net.Dialer{Timeout: opts.Timeout}), ldap.DialWithTLSConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(opts.Timeout)
+	if opts.TLS == "starttls" {
+		if err := conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %v", err)
+		}
+	}
+
+	switch {
+	case opts.Domain != "" && opts.Hash != "":
+		err = conn.NTLMBindWithHash(opts.Domain, opts.Username, opts.Hash)
+	case opts.Domain != "":
+		err = conn.NTLMBind(opts.Domain, opts.Username, opts.Password)
+	case opts.Username != "":
+		err = conn.Bind(opts.Username, opts.Password)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("bind failed: %v", err)
+	}
+
+	c := &LDAPConn{Host: host, BaseDN: opts.BaseDN, conn: conn}
+	if c.BaseDN == "" {
+		root, err := c.Search("(objectClass=*)", LDAPSearchOptions{Base: "", Scope: "base", Attributes: []string{"defaultNamingContext"}})
+		if err == nil && len(root) == 1 {
+			if values := root[0].Attributes["defaultNamingContext"]; len(values) > 0 {
+				c.BaseDN, _ = values[0].(string)
+			}
+		}
+	}
+
+	registryMutex.Lock()
+	c.ID = generateID("ldap")
+	ldapConns[c.ID] = c
+	registryMutex.Unlock()
+	return c, nil
+}
+
+// LookupLDAP returns an open connection by id
+func LookupLDAP(id string) (*LDAPConn, error) {
+	registryMutex.RLock()
+	c, ok := ldapConns[id]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("LDAP connection '%s' not found", id)
+	}
+	return c, nil
+}
+
+// Close unbinds and forgets the connection
+func (c *LDAPConn) Close() error {
+	registryMutex.Lock()
+	delete(ldapConns, c.ID)
+	registryMutex.Unlock()
+	return c.conn.Close()
+}
+
+// Search returns the entries under a base that match an LDAP filter,
+// reading them in pages
+func (c *LDAPConn) Search(filter string, opts LDAPSearchOptions) ([]LDAPEntry, error) {
+	base := opts.Base
+	if base == "" && opts.Scope != "base" {
+		base = c.BaseDN
+	}
+	scope := ldap.ScopeWholeSubtree
+	switch strings.ToLower(opts.Scope) {
+	case "", "sub":
+	case "one":
+		scope = ldap.ScopeSingleLevel
+	case "base":
+		scope = ldap.ScopeBaseObject
+	default:
+		return nil, fmt.Errorf("unknown scope '%s', want sub, one or base", opts.Scope)
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = 500
+	}
+
+	request := ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, opts.SizeLimit, 0, false, filter, opts.Attributes, nil)
+	var result *ldap.SearchResult
+	var err error
+	if scope == ldap.ScopeBaseObject {
+		result, err = c.conn.Search(request)
+	} else {
+		result, err = c.conn.SearchWithPaging(request, uint32(opts.PageSize))
+	}
+	if err != nil && !(ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) && result != nil) {
+		return nil, err
+	}
+
+	entries := make([]LDAPEntry, len(result.Entries))
+	for i, e := range result.Entries {
+		entries[i] = LDAPEntry{DN: e.DN, Attributes: map[string][]interface{}{}}
+		for _, attr := range e.Attributes {
+			values := make([]interface{}, len(attr.ByteValues))
+			for j, raw := range attr.ByteValues {
+				values[j] = ldapValue(attr.Name, raw)
+			}
+			entries[i].Attributes[attr.Name] = values
+		}
+	}
+	return entries, nil
+}
+
+// ldapValue returns an attribute value as text where it has one
+func ldapValue(name string, raw []byte) interface{} {
+	switch strings.ToLower(name) {
+	case "objectsid", "sidhistory", "securityidentifier":
+		if sid, ok := decodeSID(raw); ok {
+			return sid
+		}
+	case "objectguid":
+		if len(raw) == 16 {
+			return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(raw), binary.LittleEndian.Uint16(raw[4:]),
+				binary.LittleEndian.Uint16(raw[6:]), raw[8:10], raw[10:])
+		}
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return raw
+}
+
+// decodeSID returns the S-1-5-... form of a binary security identifier
+func decodeSID(raw []byte) (string, bool) {
+	if len(raw) < 8 || len(raw) != 8+4*int(raw[1]) {
+		return "", false
+	}
+	var authority uint64
+	for _, b := range raw[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	sid := fmt.Sprintf("S-%d-%d", raw[0], authority)
+	for i := 8; i < len(raw); i += 4 {
+		sid += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(raw[i:])), 10)
+	}
+	return sid, true
+}
+
+// Active Directory userAccountControl flags and sAMAccountType values
+const (
+	uacDisabled           = 0x2
+	uacDontExpire         = 0x10000
+	uacDontRequirePreauth = 0x400000
+	samUserAccount        = "805306368"
+	samMachineAccount     = "805306369"
+	ldapMatchingRuleAnd   = "1.2.840.113556.1.4.803"
+	ldapMatchingInChain   = "1.2.840.113556.1.4.1941"
+)
+
+// adAccountAttributes are read for every account an AD query returns
+var adAccountAttributes = []string{
+	"sAMAccountName", "distinguishedName", "userAccountControl", "servicePrincipalName",
+	"adminCount", "pwdLastSet", "lastLogonTimestamp", "whenCreated", "description", "memberOf",
+}
+
+// ADAccount is a user or computer account found by an AD query
+type ADAccount struct {
+	SAMAccountName       string
+	DN                   string
+	Enabled              bool
+	PasswordNeverExpires bool
+	SPNs                 []string
+	AdminCount           bool
+	PasswordLastSet      time.Time
+	LastLogon            time.Time
+	Created              time.Time
+	Description          string
+	MemberOf             []string
+}
+
+// ADGroup is a privileged group and the accounts in it, directly or
+// through nested groups
+type ADGroup struct {
+	Name    string
+	DN      string
+	Members []ADAccount
+}
+
+// ADPasswordPolicy is the domain password policy or a fine-grained
+// password settings object. Ages and durations of 0 mean never.
+type ADPasswordPolicy struct {
+	Name                 string
+	MinLength            int
+	History              int
+	MaxAgeDays           int
+	MinAgeDays           int
+	LockoutThreshold     int
+	LockoutMinutes       int
+	LockoutWindowMinutes int
+	Complexity           bool
+	ReversibleEncryption bool
+	Precedence           int      // Fine-grained policies only
+	AppliesTo            []string // Fine-grained policies only
+	Problems             []string
+}
+
+// PrivilegedGroups are the groups ADPrivilegedMembers looks at by default
+var PrivilegedGroups = []string{
+	"Domain Admins", "Enterprise Admins", "Schema Admins", "Administrators",
+	"Account Operators", "Backup Operators", "Server Operators", "Print Operators",
+	"DnsAdmins", "Group Policy Creator Owners", "Key Admins", "Enterprise Key Admins",
+}
+
+// accounts runs an AD query for accounts
+func (c *LDAPConn) accounts(filter string) ([]ADAccount, error) {
+	entries, err := c.Search(filter, LDAPSearchOptions{Attributes: adAccountAttributes})
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]ADAccount, len(entries))
+	for i, e := range entries {
+		get := func(name string) string { return firstString(e, name) }
+		strs := func(name string) []string {
+			var list []string
+			for _, v := range e.Attributes[name] {
+				if s, ok := v.(string); ok {
+					list = append(list, s)
+				}
+			}
+			return list
+		}
+		uac, _ := strconv.ParseInt(get("userAccountControl"), 10, 64)
+		accounts[i] = ADAccount{
+			SAMAccountName:       get("sAMAccountName"),
+			DN:                   e.DN,
+			Enabled:              uac&uacDisabled == 0,
+			PasswordNeverExpires: uac&uacDontExpire != 0,
+			SPNs:                 strs("servicePrincipalName"),
+			AdminCount:           get("adminCount") == "1",
+			PasswordLastSet:      fileTime(get("pwdLastSet")),
+			LastLogon:            fileTime(get("lastLogonTimestamp")),
+			Created:              generalizedTime(get("whenCreated")),
+			Description:          get("description"),
+			MemberOf:             strs("memberOf"),
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].SAMAccountName < accounts[j].SAMAccountName })
+	return accounts, nil
+}
+
+// enabledUsers returns a filter for enabled user accounts and more terms
+func enabledUsers(terms ...string) string {
+	return fmt.Sprintf("(&(sAMAccountType=%s)(!(userAccountControl:%s:=%d))%s)", samUserAccount, ldapMatchingRuleAnd, uacDisabled, strings.Join(terms, ""))
+}
+
+// ADKerberoastable returns the enabled user accounts with a service
+// principal name, whose service tickets can be cracked offline
+func (c *LDAPConn) ADKerberoastable() ([]ADAccount, error) {
+	return c.accounts(enabledUsers("(servicePrincipalName=*)", "(!(sAMAccountName=krbtgt))"))
+}
+
+// ADASREPRoastable returns the enabled user accounts that do not require
+// Kerberos pre-authentication
+func (c *LDAPConn) ADASREPRoastable() ([]ADAccount, error) {
+	return c.accounts(enabledUsers(fmt.Sprintf("(userAccountControl:%s:=%d)", ldapMatchingRuleAnd, uacDontRequirePreauth)))
+}
+
+// ADStaleAccounts returns the enabled user accounts that have not logged
+// on for days, including those created before then that never did.
+// lastLogonTimestamp replicates lazily, so it can lag by up to 14 days.
+func (c *LDAPConn) ADStaleAccounts(days int) ([]ADAccount, error) {
+	cutoff := time.Now().AddDate(0, 0, -days)
+	ticks := cutoff.Unix()*10000000 + fileTimeEpoch
+	return c.accounts(enabledUsers(fmt.Sprintf("(|(lastLogonTimestamp<=%d)(&(!(lastLogonTimestamp=*))(whenCreated<=%s)))",
+		ticks, cutoff.UTC().Format("20060102150405.0Z"))))
+}
+
+// ADPrivilegedMembers returns the groups of names, PrivilegedGroups if
+// none, with their members including those of nested groups. Groups that
+// do not exist in the domain are left out.
+func (c *LDAPConn) ADPrivilegedMembers(names []string) ([]ADGroup, error) {
+	if len(names) == 0 {
+		names = PrivilegedGroups
+	}
+	var groups []ADGroup
+	for _, name := range names {
+		found, err := c.Search(fmt.Sprintf("(&(objectClass=group)(|(sAMAccountName=%s)(cn=%[1]s)))", ldap.EscapeFilter(name)),
+			LDAPSearchOptions{Attributes: []string{"sAMAccountName"}})
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			continue
+		}
+		group := ADGroup{Name: name, DN: found[0].DN}
+		group.Members, err = c.accounts(fmt.Sprintf("(&(|(sAMAccountType=%s)(sAMAccountType=%s))(memberOf:%s:=%s))",
+			samUserAccount, samMachineAccount, ldapMatchingInChain, ldap.EscapeFilter(group.DN)))
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// ADSetAccountEnabled enables or disables the account of a
+// sAMAccountName or a DN through its userAccountControl flag. It returns
+// the account's DN and whether it was enabled before.
+func (c *LDAPConn) ADSetAccountEnabled(name string, enabled bool) (string, bool, error) {
+	match := fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(name))
+	if strings.Contains(name, "=") {
+		match = fmt.Sprintf("(distinguishedName=%s)", ldap.EscapeFilter(name))
+	}
+	found, err := c.Search(fmt.Sprintf("(&(|(sAMAccountType=%s)(sAMAccountType=%s))%s)", samUserAccount, samMachineAccount, match),
+		LDAPSearchOptions{Attributes: []string{"sAMAccountName", "userAccountControl"}})
+	if err != nil {
+		return "", false, err
+	}
+	if len(found) != 1 {
+		return "", false, fmt.Errorf("found %d accounts named '%s'", len(found), name)
+	}
+	uac, err := strconv.ParseInt(firstString(found[0], "userAccountControl"), 10, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("%s has no userAccountControl", found[0].DN)
+	}
+	was := uac&uacDisabled == 0
+	if enabled {
+		uac &^= uacDisabled
+	} else {
+		uac |= uacDisabled
+	}
+	modify := ldap.NewModifyRequest(found[0].DN, nil)
+	modify.Replace("userAccountControl", []string{strconv.FormatInt(uac, 10)})
+	if err := c.conn.Modify(modify); err != nil {
+		return "", false, err
+	}
+	return found[0].DN, was, nil
+}
+
+// ADPasswordPolicy returns the domain password policy and, where the
+// account may read them, the fine-grained policies, with their problems
+func (c *LDAPConn) ADPasswordPolicy() (*ADPasswordPolicy, []ADPasswordPolicy, error) {
+	domain, err := c.Search("(objectClass=*)", LDAPSearchOptions{Base: c.BaseDN, Scope: "base", Attributes: []string{
+		"minPwdLength", "pwdHistoryLength", "maxPwdAge", "minPwdAge", "lockoutThreshold",
+		"lockoutDuration", "lockOutObservationWindow", "pwdProperties",
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(domain) == 0 {
+		return nil, nil, fmt.Errorf("base DN %s not found", c.BaseDN)
+	}
+	get := func(e LDAPEntry, name string) int64 {
+		if values := e.Attributes[name]; len(values) > 0 {
+			s, _ := values[0].(string)
+			n, _ := strconv.ParseInt(s, 10, 64)
+			return n
+		}
+		return 0
+	}
+	d := domain[0]
+	properties := get(d, "pwdProperties")
+	policy := &ADPasswordPolicy{
+		Name:                 "Default Domain Policy",
+		MinLength:            int(get(d, "minPwdLength")),
+		History:              int(get(d, "pwdHistoryLength")),
+		MaxAgeDays:           int(adInterval(get(d, "maxPwdAge")) / (24 * time.Hour)),
+		MinAgeDays:           int(adInterval(get(d, "minPwdAge")) / (24 * time.Hour)),
+		LockoutThreshold:     int(get(d, "lockoutThreshold")),
+		LockoutMinutes:       int(adInterval(get(d, "lockoutDuration")) / time.Minute),
+		LockoutWindowMinutes: int(adInterval(get(d, "lockOutObservationWindow")) / time.Minute),
+		Complexity:           properties&1 != 0,
+		ReversibleEncryption: properties&16 != 0,
+	}
+	policy.check()
+
+	entries, err := c.Search("(objectClass=msDS-PasswordSettings)", LDAPSearchOptions{
+		Base:  "CN=Password Settings Container,CN=System," + c.BaseDN,
+		Scope: "one",
+	})
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) && !ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights) {
+		return nil, nil, err
+	}
+	var fineGrained []ADPasswordPolicy
+	for _, e := range entries {
+		pso := ADPasswordPolicy{
+			Name:                 e.DN,
+			MinLength:            int(get(e, "msDS-MinimumPasswordLength")),
+			History:              int(get(e, "msDS-PasswordHistoryLength")),
+			MaxAgeDays:           int(adInterval(get(e, "msDS-MaximumPasswordAge")) / (24 * time.Hour)),
+			MinAgeDays:           int(adInterval(get(e, "msDS-MinimumPasswordAge")) / (24 * time.Hour)),
+			LockoutThreshold:     int(get(e, "msDS-LockoutThreshold")),
+			LockoutMinutes:       int(adInterval(get(e, "msDS-LockoutDuration")) / time.Minute),
+			LockoutWindowMinutes: int(adInterval(get(e, "msDS-LockoutObservationWindow")) / time.Minute),
+			Precedence:           int(get(e, "msDS-PasswordSettingsPrecedence")),
+		}
+		if values := e.Attributes["cn"]; len(values) > 0 {
+			pso.Name, _ = values[0].(string)
+		}
+		for _, v := range e.Attributes["msDS-PSOAppliesTo"] {
+			if s, ok := v.(string); ok {
+				pso.AppliesTo = append(pso.AppliesTo, s)
+			}
+		}
+		pso.Complexity = strings.EqualFold(firstString(e, "msDS-PasswordComplexityEnabled"), "TRUE")
+		pso.ReversibleEncryption = strings.EqualFold(firstString(e, "msDS-PasswordReversibleEncryptionEnabled"), "TRUE")
+		pso.check()
+		fineGrained = append(fineGrained, pso)
+	}
+	sort.Slice(fineGrained, func(i, j int) bool { return fineGrained[i].Precedence < fineGrained[j].Precedence })
+	return policy, fineGrained, nil
+}
+
+// firstString returns the first value of an attribute as a string
+func firstString(e LDAPEntry, name string) string {
+	if values := e.Attributes[name]; len(values) > 0 {
+		s, _ := values[0].(string)
+		return s
+	}
+	return ""
+}
+
+// check lists the weaknesses of a password policy
+func (p *ADPasswordPolicy) check() {
+	if p.MinLength < 14 {
+		p.Problems = append(p.Problems, fmt.Sprintf("minimum password length %d is under 14", p.MinLength))
+	}
+	if !p.Complexity {
+		p.Problems = append(p.Problems, "password complexity is off")
+	}
+	if p.ReversibleEncryption {
+		p.Problems = append(p.Problems, "passwords are stored with reversible encryption")
+	}
+	if p.History < 24 {
+		p.Problems = append(p.Problems, fmt.Sprintf("password history of %d is under 24", p.History))
+	}
+	if p.LockoutThreshold == 0 {
+		p.Problems = append(p.Problems, "accounts never lock out")
+	}
+}
+
+// fileTimeEpoch is the Unix epoch in 100ns ticks since 1601
+const fileTimeEpoch = 116444736000000000
+
+// fileTime converts an AD timestamp, in 100ns ticks since 1601, to a
+// time; 0 and the largest value mean never
+func fileTime(s string) time.Time {
+	ticks, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ticks <= 0 || ticks == 1<<63-1 {
+		return time.Time{}
+	}
+	ticks -= fileTimeEpoch
+	return time.Unix(ticks/10000000, ticks%10000000*100).UTC()
+}
+
+// generalizedTime parses an LDAP GeneralizedTime such as
+// 20240105120000.0Z
+func generalizedTime(s string) time.Time {
+	t, err := time.Parse("20060102150405.0Z", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// adInterval converts an AD interval, a negative count of 100ns ticks,
+// to a duration; the smallest value means never and is 0
+func adInterval(ticks int64) time.Duration {
+	if ticks == -1<<63 || ticks >= 0 {
+		return 0
+	}
+	return time.Duration(-ticks) * 100
+}
+
+// LDAPEntryToMap returns an entry as a map of its dn and its attributes'
+// values as arrays
+func LDAPEntryToMap(e LDAPEntry) map[string]interface{} {
+	m := map[string]interface{}{"dn": e.DN}
+	for name, values := range e.Attributes {
+		m[name] = values
+	}
+	return m
+}
+
+// ADAccountToMap returns an account as a map. Times are RFC 3339, empty
+// when never set.
+func ADAccountToMap(a ADAccount) map[string]interface{} {
+	timeString := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"sam_account_name":       a.SAMAccountName,
+		"dn":                     a.DN,
+		"enabled":                a.Enabled,
+		"password_never_expires": a.PasswordNeverExpires,
+		"spns":                   stringsToInterfaces(a.SPNs),
+		"admin_count":            a.AdminCount,
+		"password_last_set":      timeString(a.PasswordLastSet),
+		"last_logon":             timeString(a.LastLogon),
+		"created":                timeString(a.Created),
+		"description":            a.Description,
+		"member_of":              stringsToInterfaces(a.MemberOf),
+	}
+}
+
+// ADPasswordPolicyToMap returns a password policy as a map
+func ADPasswordPolicyToMap(p ADPasswordPolicy) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                   p.Name,
+		"min_length":             p.MinLength,
+		"history":                p.History,
+		"max_age_days":           p.MaxAgeDays,
+		"min_age_days":           p.MinAgeDays,
+		"lockout_threshold":      p.LockoutThreshold,
+		"lockout_minutes":        p.LockoutMinutes,
+		"lockout_window_minutes": p.LockoutWindowMinutes,
+		"complexity":             p.Complexity,
+		"reversible_encryption":  p.ReversibleEncryption,
+		"precedence":             p.Precedence,
+		"applies_to":             stringsToInterfaces(p.AppliesTo),
+		"problems":               stringsToInterfaces(p.Problems),
+	}
+}
@@ -0,0 +1,313 @@
+package network
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const testBaseDN = "DC=corp,DC=test"
+
+// ldapFixture is an entry the fake LDAP server returns
+type ldapFixture struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// fakeLDAP serves an LDAP server that takes svc@corp.test with the
+// password secret and answers searches with search, which gets the base
+// and the filter and returns the entries and a result code
+func fakeLDAP(t *testing.T, search func(base, filter string) ([]ldapFixture, int)) (string, int) {
+	return serveTCP(t, func(conn net.Conn) {
+		reply := func(id interface{}, op *ber.Packet) bool {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			envelope.AppendChild(op)
+			_, err := conn.Write(envelope.Bytes())
+			return err == nil
+		}
+		result := func(tag ber.Tag, code int) *ber.Packet {
+			op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+			op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			return op
+		}
+		for {
+			packet, err := ber.ReadPacket(conn)
+			if err != nil || len(packet.Children) < 2 {
+				return
+			}
+			id, op := packet.Children[0].Value, packet.Children[1]
+			switch op.Tag {
+			case ldap.ApplicationBindRequest:
+				code := ldap.LDAPResultInvalidCredentials
+				if op.Children[1].Value == "svc@corp.test" && op.Children[2].Data.String() == "secret" {
+					code = ldap.LDAPResultSuccess
+				}
+				if !reply(id, result(ldap.ApplicationBindResponse, code)) {
+					return
+				}
+			case ldap.ApplicationSearchRequest:
+				filter, err := ldap.DecompileFilter(op.Children[6])
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				entries, code := search(op.Children[0].Value.(string), filter)
+				for _, e := range entries {
+					entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+					entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+					attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					for name, values := range e.attrs {
+						attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+						attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+						set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+						for _, v := range values {
+							set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+						}
+						attr.AppendChild(set)
+						attrs.AppendChild(attr)
+					}
+					entry.AppendChild(attrs)
+					if !reply(id, entry) {
+						return
+					}
+				}
+				if !reply(id, result(ldap.ApplicationSearchResultDone, code)) {
+					return
+				}
+			case ldap.ApplicationModifyRequest:
+				// A modification is passed to search as the DN and the
+				// filter "modify attribute=values"
+				var changes []string
+				for _, change := range op.Children[1].Children {
+					attr := change.Children[1]
+					var values []string
+					for _, v := range attr.Children[1].Children {
+						values = append(values, v.Data.String())
+					}
+					changes = append(changes, attr.Children[0].Data.String()+"="+strings.Join(values, ","))
+				}
+				_, code := search(op.Children[0].Data.String(), "modify "+strings.Join(changes, " "))
+				if !reply(id, result(ldap.ApplicationModifyResponse, code)) {
+					return
+				}
+			case ldap.ApplicationUnbindRequest:
+				return
+			}
+		}
+	})
+}
+
+// rootDSE answers the search for the base DN
+func rootDSE(base, filter string) ([]ldapFixture, int, bool) {
+	if base != "" {
+		return nil, 0, false
+	}
+	return []ldapFixture{{attrs: map[string][]string{"defaultNamingContext": {testBaseDN}}}}, ldap.LDAPResultSuccess, true
+}
+
+func TestLDAPConnect(t *testing.T) {
+	sid := string([]byte{1, 5, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 0xf4, 1, 0, 0})
+	host, port := fakeLDAP(t, func(base, filter string) ([]ldapFixture, int) {
+		if entries, code, ok := rootDSE(base, filter); ok {
+			return entries, code
+		}
+		if base != testBaseDN || filter != "(sAMAccountName=Administrator)" {
+			return nil, ldap.LDAPResultNoSuchObject
+		}
+		return []ldapFixture{{
+			dn:    "CN=Administrator,CN=Users," + testBaseDN,
+			attrs: map[string][]string{"objectSid": {sid}, "mail": {"admin@corp.test"}},
+		}}, ldap.LDAPResultSuccess
+	})
+
+	opts := LDAPOptions{Port: port, TLS: "none", Username: "svc@corp.test", Password: "wrong", Timeout: 2 * time.Second}
+	if _, err := LDAPConnect(host, opts); err == nil || !strings.Contains(err.Error(), "bind failed") {
+		t.Errorf("wrong password: %v", err)
+	}
+	opts.Password = "secret"
+	c, err := LDAPConnect(host, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseDN != testBaseDN {
+		t.Errorf("base DN %q", c.BaseDN)
+	}
+
+	entries, err := c.Search("(sAMAccountName=Administrator)", LDAPSearchOptions{Attributes: []string{"objectSid", "mail"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LDAPEntry{{
+		DN:         "CN=Administrator,CN=Users," + testBaseDN,
+		Attributes: map[string][]interface{}{"objectSid": {"S-1-5-21-1-2-3-500"}, "mail": {"admin@corp.test"}},
+	}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v", entries)
+	}
+	if _, err := c.Search("(cn=*)", LDAPSearchOptions{Base: "OU=Missing," + testBaseDN}); !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("missing base: %v", err)
+	}
+	if _, err := c.Search("(cn=*)", LDAPSearchOptions{Scope: "tree"}); err == nil {
+		t.Error("accepted an unknown scope")
+	}
+
+	if found, err := LookupLDAP(c.ID); err != nil || found != c {
+		t.Errorf("lookup: %v", err)
+	}
+	c.Close()
+	if _, err := LookupLDAP(c.ID); err == nil {
+		t.Error("closed connection is still registered")
+	}
+	if _, err := LDAPConnect("192.0.2.1", LDAPOptions{TLS: "none", Username: "svc", Password: "secret"}); err == nil {
+		t.Error("sent a simple bind in the clear to a remote server")
+	}
+}
+
+func TestADQueries(t *testing.T) {
+	filters := make(chan string, 16)
+	svc := ldapFixture{
+		dn: "CN=svc_sql,OU=Service," + testBaseDN,
+		attrs: map[string][]string{
+			"sAMAccountName":       {"svc_sql"},
+			"userAccountControl":   {"66048"},
+			"servicePrincipalName": {"MSSQLSvc/db1.corp.test:1433", "MSSQLSvc/db1.corp.test"},
+			"adminCount":           {"1"},
+			"pwdLastSet":           {"133500000000000000"},
+			"lastLogonTimestamp":   {"0"},
+			"whenCreated":          {"20230105120000.0Z"},
+			"memberOf":             {"CN=Domain Admins,CN=Users," + testBaseDN},
+		},
+	}
+	host, port := fakeLDAP(t, func(base, filter string) ([]ldapFixture, int) {
+		if entries, code, ok := rootDSE(base, filter); ok {
+			return entries, code
+		}
+		switch {
+		case base == testBaseDN && filter == "(objectClass=*)":
+			return []ldapFixture{{dn: testBaseDN, attrs: map[string][]string{
+				"minPwdLength": {"7"}, "pwdHistoryLength": {"24"}, "maxPwdAge": {"-36288000000000"}, "minPwdAge": {"-864000000000"},
+				"lockoutThreshold": {"0"}, "lockoutDuration": {"-18000000000"}, "lockOutObservationWindow": {"-18000000000"}, "pwdProperties": {"1"},
+			}}}, ldap.LDAPResultSuccess
+		case strings.HasPrefix(base, "CN=Password Settings Container"):
+			return []ldapFixture{
+				{dn: "CN=Admins," + base, attrs: map[string][]string{
+					"cn": {"Admins"}, "msDS-MinimumPasswordLength": {"16"}, "msDS-PasswordHistoryLength": {"24"},
+					"msDS-PasswordComplexityEnabled": {"TRUE"}, "msDS-PasswordReversibleEncryptionEnabled": {"FALSE"},
+					"msDS-LockoutThreshold": {"5"}, "msDS-PasswordSettingsPrecedence": {"10"},
+					"msDS-PSOAppliesTo": {"CN=Domain Admins,CN=Users," + testBaseDN},
+				}},
+				{dn: "CN=Service," + base, attrs: map[string][]string{
+					"cn": {"Service"}, "msDS-MinimumPasswordLength": {"8"}, "msDS-PasswordComplexityEnabled": {"FALSE"},
+					"msDS-PasswordReversibleEncryptionEnabled": {"TRUE"}, "msDS-PasswordSettingsPrecedence": {"1"},
+				}},
+			}, ldap.LDAPResultSuccess
+		case strings.Contains(filter, "(objectClass=group)"):
+			if strings.Contains(filter, "Domain Admins") {
+				return []ldapFixture{{dn: "CN=Domain Admins,CN=Users," + testBaseDN}}, ldap.LDAPResultSuccess
+			}
+			return nil, ldap.LDAPResultSuccess
+		}
+		filters <- filter
+		return []ldapFixture{svc}, ldap.LDAPResultSuccess
+	})
+	c, err := LDAPConnect(host, LDAPOptions{Port: port, TLS: "none", Username: "svc@corp.test", Password: "secret", Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	accounts, err := c.ADKerberoastable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter := <-filters; !strings.Contains(filter, "(servicePrincipalName=*)") || !strings.Contains(filter, "(!(userAccountControl:1.2.840.113556.1.4.803:=2))") {
+		t.Errorf("kerberoastable filter %q", filter)
+	}
+	want := ADAccount{
+		SAMAccountName: "svc_sql", DN: svc.dn, Enabled: true, PasswordNeverExpires: true,
+		SPNs: svc.attrs["servicePrincipalName"], AdminCount: true,
+		PasswordLastSet: time.Date(2024, 1, 17, 21, 20, 0, 0, time.UTC),
+		Created:         time.Date(2023, 1, 5, 12, 0, 0, 0, time.UTC),
+		MemberOf:        svc.attrs["memberOf"],
+	}
+	if len(accounts) != 1 || !reflect.DeepEqual(accounts[0], want) {
+		t.Errorf("got %+v", accounts)
+	}
+
+	if _, err := c.ADASREPRoastable(); err != nil {
+		t.Fatal(err)
+	}
+	if filter := <-filters; !strings.Contains(filter, "(userAccountControl:1.2.840.113556.1.4.803:=4194304)") {
+		t.Errorf("AS-REP roastable filter %q", filter)
+	}
+	if _, err := c.ADStaleAccounts(90); err != nil {
+		t.Fatal(err)
+	}
+	if filter := <-filters; !strings.Contains(filter, "(lastLogonTimestamp<=") || !strings.Contains(filter, "(whenCreated<=") {
+		t.Errorf("stale accounts filter %q", filter)
+	}
+
+	groups, err := c.ADPrivilegedMembers([]string{"Domain Admins", "Key Admins"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "Domain Admins" || len(groups[0].Members) != 1 {
+		t.Errorf("got %+v", groups)
+	}
+	if filter := <-filters; !strings.Contains(filter, "memberOf:1.2.840.113556.1.4.1941:=CN=Domain Admins,CN=Users,"+testBaseDN) {
+		t.Errorf("members filter %q", filter)
+	}
+
+	policy, fineGrained, err := c.ADPasswordPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.MinLength != 7 || policy.MaxAgeDays != 42 || policy.MinAgeDays != 1 || policy.LockoutMinutes != 30 || !policy.Complexity {
+		t.Errorf("got %+v", policy)
+	}
+	if !reflect.DeepEqual(policy.Problems, []string{"minimum password length 7 is under 14", "accounts never lock out"}) {
+		t.Errorf("problems %q", policy.Problems)
+	}
+	if len(fineGrained) != 2 || fineGrained[0].Name != "Service" || fineGrained[1].Name != "Admins" {
+		t.Fatalf("fine-grained %+v", fineGrained)
+	}
+	if len(fineGrained[0].Problems) != 5 || len(fineGrained[1].Problems) != 0 || len(fineGrained[1].AppliesTo) != 1 {
+		t.Errorf("fine-grained problems %q and %q", fineGrained[0].Problems, fineGrained[1].Problems)
+	}
+
+	dn, wasEnabled, err := c.ADSetAccountEnabled("svc_sql", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dn != svc.dn || !wasEnabled {
+		t.Errorf("disabled %q, was enabled %v", dn, wasEnabled)
+	}
+	if filter := <-filters; !strings.Contains(filter, "(sAMAccountName=svc_sql)") {
+		t.Errorf("account filter %q", filter)
+	}
+	if change := <-filters; change != "modify userAccountControl=66050" {
+		t.Errorf("modification %q", change)
+	}
+}
+
+func TestFileTime(t *testing.T) {
+	if got := fileTime("116444736000000000"); !got.Equal(time.Unix(0, 0)) {
+		t.Errorf("epoch %v", got)
+	}
+	for _, never := range []string{"0", "9223372036854775807", ""} {
+		if got := fileTime(never); !got.IsZero() {
+			t.Errorf("fileTime(%q) = %v", never, got)
+		}
+	}
+	if got := adInterval(-9223372036854775808); got != 0 {
+		t.Errorf("never is %v", got)
+	}
+}
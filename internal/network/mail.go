@@ -0,0 +1,575 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP sending and STARTTLS checks for testing mail filters and gateways
+
+// SMTPOptions configures an SMTP connection. TLS is "starttls" to upgrade
+// when the server offers it, "require" to fail when it does not, "implicit"
+// for TLS from the start, as on port 465, or "none".
+type SMTPOptions struct {
+	Port     int    // 25 by default
+	TLS      string // "implicit" on port 465, "starttls" otherwise
+	Username string // Authenticates with AUTH PLAIN or LOGIN when set
+	Password string
+	Helo     string        // Name sent with EHLO, "localhost" by default
+	Timeout  time.Duration // 10 seconds if 0
+	Insecure bool          // Skip verifying the server's certificate
+}
+
+func (o SMTPOptions) withDefaults() SMTPOptions {
+	if o.Port == 0 {
+		o.Port = 25
+	}
+	if o.TLS == "" {
+		o.TLS = "starttls"
+		if o.Port == 465 {
+			o.TLS = "implicit"
+		}
+	}
+	o.TLS = strings.ToLower(o.TLS)
+	if o.Helo == "" {
+		o.Helo = "localhost"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// MailMessage is a message to send. Bcc recipients get the message but
+// are left out of its headers.
+type MailMessage struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Text        string
+	HTML        string
+	Headers     map[string]string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to a message. ContentType is guessed
+// from the name if empty.
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// SMTPSendResult is the outcome of sending a message
+type SMTPSendResult struct {
+	MessageID  string
+	Response   string   // The server's answer to the message, often with a queue id
+	Accepted   []string // Recipients the server took
+	Rejected   []string // Recipients it refused, with its answer
+	TLS        bool
+	TLSVersion string
+}
+
+// SMTPReport is what an SMTP server offers, before and after STARTTLS
+type SMTPReport struct {
+	Host           string
+	Port           int
+	Banner         string
+	Extensions     []string // EHLO keywords, from the TLS session if there was one
+	StartTLS       bool     // Whether STARTTLS was offered
+	TLS            bool     // Whether a TLS session was set up
+	TLSVersion     string
+	TLSCipher      string
+	CertSubject    string
+	CertIssuer     string
+	CertNames      []string
+	CertExpires    time.Time
+	CertValid      bool
+	CertError      string
+	AuthMechanisms []string
+	AuthBeforeTLS  []string // Mechanisms offered on the plain connection
+	Problems       []string
+}
+
+// smtpConn is a connection to an SMTP server
+type smtpConn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	host    string
+	timeout time.Duration
+	tls     *tls.ConnectionState
+}
+
+// dialSMTP connects to an SMTP server and returns the connection and its
+// greeting. With implicit set the connection is TLS from the start.
+func dialSMTP(host string, port int, implicit bool, config *tls.Config, timeout time.Duration) (*smtpConn, string, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	c := &smtpConn{conn: conn, text: textproto.NewConn(conn), host: host, timeout: timeout}
+	if implicit {
+		if err := c.handshake(config); err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, banner, err := c.text.ReadResponse(220)
+	if err != nil {
+		c.Close()
+		return nil, "", err
+	}
+	return c, banner, nil
+}
+
+// cmd sends a command and reads the answer, which must have the code
+// expect or, if expect is below 10, start with that digit
+func (c *smtpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	return c.text.ReadResponse(expect)
+}
+
+// ehlo greets the server and returns its extensions by keyword, falling
+// back to HELO for servers without ESMTP
+func (c *smtpConn) ehlo(name string) (map[string]string, []string, error) {
+	_, msg, err := c.cmd(250, "EHLO %s", name)
+	if err != nil {
+		if _, _, err := c.cmd(250, "HELO %s", name); err != nil {
+			return nil, nil, err
+		}
+		return map[string]string{}, nil, nil
+	}
+	extensions := map[string]string{}
+	var lines []string
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		keyword, params, _ := strings.Cut(line, " ")
+		extensions[strings.ToUpper(keyword)] = params
+		lines = append(lines, line)
+	}
+	return extensions, lines, nil
+}
+
+// startTLS upgrades the connection with STARTTLS
+func (c *smtpConn) startTLS(config *tls.Config) error {
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+	return c.handshake(config)
+}
+
+func (c *smtpConn) handshake(config *tls.Config) error {
+	client := tls.Client(c.conn, config)
+	client.SetDeadline(time.Now().Add(c.timeout))
+	if err := client.Handshake(); err != nil {
+		return err
+	}
+	state := client.ConnectionState()
+	c.conn, c.text, c.tls = client, textproto.NewConn(client), &state
+	return nil
+}
+
+// auth logs in with PLAIN, or LOGIN if the server only offers that.
+// Credentials only go over TLS or to the local host.
+func (c *smtpConn) auth(mechanisms, username, password string) error {
+	if c.tls == nil && !isLoopback(c.host) {
+		return errors.New("refusing to send credentials without TLS")
+	}
+	offered := strings.Fields(strings.ToUpper(mechanisms))
+	encode := base64.StdEncoding.EncodeToString
+	switch {
+	case containsString(offered, "PLAIN"):
+		_, _, err := c.cmd(235, "AUTH PLAIN %s", encode([]byte("\x00"+username+"\x00"+password)))
+		return err
+	case containsString(offered, "LOGIN"):
+		if _, _, err := c.cmd(334, "AUTH LOGIN"); err != nil {
+			return err
+		}
+		if _, _, err := c.cmd(334, "%s", encode([]byte(username))); err != nil {
+			return err
+		}
+		_, _, err := c.cmd(235, "%s", encode([]byte(password)))
+		return err
+	}
+	return fmt.Errorf("server offers no PLAIN or LOGIN authentication (%s)", mechanisms)
+}
+
+// Close says goodbye and closes the connection
+func (c *smtpConn) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.text.Cmd("QUIT")
+	return c.text.Close()
+}
+
+// SendMail sends a message through an SMTP server
+func SendMail(host string, msg *MailMessage, opts SMTPOptions) (*SMTPSendResult, error) {
+	opts = opts.withDefaults()
+	switch opts.TLS {
+	case "starttls", "require", "implicit", "none":
+	default:
+		return nil, fmt.Errorf("unknown TLS mode '%s', want starttls, require, implicit or none", opts.TLS)
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %v", msg.From, err)
+	}
+	var recipients []string
+	for _, list := range [][]string{msg.To, msg.Cc, msg.Bcc} {
+		for _, to := range list {
+			addr, err := mail.ParseAddress(to)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %v", to, err)
+			}
+			recipients = append(recipients, addr.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("message has no recipients")
+	}
+	result := &SMTPSendResult{MessageID: newMessageID(from.Address)}
+	data, err := msg.Bytes(result.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: opts.Insecure}
+	c, _, err := dialSMTP(host, opts.Port, opts.TLS == "implicit", config, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	extensions, _, err := c.ehlo(opts.Helo)
+	if err != nil {
+		return nil, err
+	}
+	if c.tls == nil && opts.TLS != "none" {
+		if _, ok := extensions["STARTTLS"]; ok {
+			if err := c.startTLS(config); err != nil {
+				return nil, fmt.Errorf("STARTTLS failed: %v", err)
+			}
+			if extensions, _, err = c.ehlo(opts.Helo); err != nil {
+				return nil, err
+			}
+		} else if opts.TLS == "require" {
+			return nil, errors.New("server does not offer STARTTLS")
+		}
+	}
+	if c.tls != nil {
+		result.TLS, result.TLSVersion = true, tls.VersionName(c.tls.Version)
+	}
+
+	if opts.Username != "" {
+		if err := c.auth(extensions["AUTH"], opts.Username, opts.Password); err != nil {
+			return nil, fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+	if _, _, err := c.cmd(250, "MAIL FROM:<%s>", from.Address); err != nil {
+		return nil, err
+	}
+	for _, to := range recipients {
+		if _, _, err := c.cmd(2, "RCPT TO:<%s>", to); err != nil {
+			result.Rejected = append(result.Rejected, to+": "+err.Error())
+			continue
+		}
+		result.Accepted = append(result.Accepted, to)
+	}
+	if len(result.Accepted) == 0 {
+		return nil, fmt.Errorf("every recipient was rejected: %s", strings.Join(result.Rejected, "; "))
+	}
+	if _, _, err := c.cmd(354, "DATA"); err != nil {
+		return nil, err
+	}
+	w := c.text.DotWriter()
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if _, result.Response, err = c.text.ReadResponse(250); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CheckSMTP connects to an SMTP server and reports its banner and
+// extensions, whether STARTTLS is offered and works, the certificate it
+// presents and where it offers authentication
+func CheckSMTP(host string, opts SMTPOptions) (*SMTPReport, error) {
+	opts = opts.withDefaults()
+	implicit := opts.TLS == "implicit"
+	c, banner, err := dialSMTP(host, opts.Port, implicit, &tls.Config{ServerName: host, InsecureSkipVerify: true}, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	report := &SMTPReport{Host: host, Port: opts.Port, Banner: banner}
+	extensions, lines, err := c.ehlo(opts.Helo)
+	if err != nil {
+		return nil, err
+	}
+	if !implicit {
+		_, report.StartTLS = extensions["STARTTLS"]
+		report.AuthBeforeTLS = strings.Fields(extensions["AUTH"])
+		if !report.StartTLS {
+			report.Problems = append(report.Problems, "STARTTLS is not offered")
+		} else if err := c.startTLS(&tls.Config{ServerName: host, InsecureSkipVerify: true}); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("STARTTLS failed: %v", err))
+		} else if extensions, lines, err = c.ehlo(opts.Helo); err != nil {
+			return nil, err
+		}
+		if len(report.AuthBeforeTLS) > 0 {
+			report.Problems = append(report.Problems, "authentication is offered before STARTTLS")
+		}
+	}
+	report.Extensions = lines
+	report.AuthMechanisms = strings.Fields(extensions["AUTH"])
+
+	if c.tls != nil {
+		report.TLS = true
+		report.TLSVersion = tls.VersionName(c.tls.Version)
+		report.TLSCipher = tls.CipherSuiteName(c.tls.CipherSuite)
+		if c.tls.Version < tls.VersionTLS12 {
+			report.Problems = append(report.Problems, report.TLSVersion+" is deprecated")
+		}
+		if certs := c.tls.PeerCertificates; len(certs) > 0 {
+			cert := certs[0]
+			report.CertSubject = cert.Subject.String()
+			report.CertIssuer = cert.Issuer.String()
+			report.CertNames = cert.DNSNames
+			report.CertExpires = cert.NotAfter
+			intermediates := x509.NewCertPool()
+			for _, ca := range certs[1:] {
+				intermediates.AddCert(ca)
+			}
+			_, err := cert.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+			report.CertValid = err == nil
+			if err != nil {
+				report.CertError = err.Error()
+				report.Problems = append(report.Problems, "certificate: "+err.Error())
+			}
+		}
+	}
+	return report, nil
+}
+
+// Bytes returns the message with the given Message-ID, ready to send
+func (m *MailMessage) Bytes(messageID string) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) error {
+		if strings.ContainsAny(key+value, "\r\n") || strings.ContainsAny(key, " :") {
+			return fmt.Errorf("invalid header %q", key)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+		return nil
+	}
+	header("From", m.From)
+	if len(m.To) > 0 {
+		header("To", strings.Join(m.To, ", "))
+	}
+	if len(m.Cc) > 0 {
+		header("Cc", strings.Join(m.Cc, ", "))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+	for key, value := range m.Headers {
+		if err := header(textproto.CanonicalMIMEHeaderKey(key), value); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.Attachments) == 0 {
+		if err := writeMailBody(&buf, m.Text, m.HTML); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	mixed := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+	var body bytes.Buffer
+	if err := writeMailBody(&body, m.Text, m.HTML); err != nil {
+		return nil, err
+	}
+	head, content, _ := bytes.Cut(body.Bytes(), []byte("\r\n\r\n"))
+	part, err := mixed.CreatePart(mimeHeader(string(head)))
+	if err != nil {
+		return nil, err
+	}
+	part.Write(content)
+
+	for _, a := range m.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			if dot := strings.LastIndex(a.Name, "."); dot >= 0 {
+				contentType = mime.TypeByExtension(a.Name[dot:])
+			}
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		part, err := mixed.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMailBody writes the Content-Type header and body of a text or HTML
+// message, or of both as multipart/alternative
+func writeMailBody(buf *bytes.Buffer, text, html string) error {
+	single := func(w *bytes.Buffer, contentType, content string) error {
+		fmt.Fprintf(w, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", contentType)
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(content)); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+	if html == "" {
+		return single(buf, "text/plain", text)
+	}
+	if text == "" {
+		return single(buf, "text/html", html)
+	}
+
+	alternative := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", alternative.Boundary())
+	for _, p := range []struct{ contentType, content string }{{"text/plain", text}, {"text/html", html}} {
+		var part bytes.Buffer
+		if err := single(&part, p.contentType, p.content); err != nil {
+			return err
+		}
+		head, content, _ := bytes.Cut(part.Bytes(), []byte("\r\n\r\n"))
+		w, err := alternative.CreatePart(mimeHeader(string(head)))
+		if err != nil {
+			return err
+		}
+		w.Write(content)
+	}
+	return alternative.Close()
+}
+
+// mimeHeader parses header lines joined by CRLF
+func mimeHeader(lines string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	for _, line := range strings.Split(lines, "\r\n") {
+		if key, value, ok := strings.Cut(line, ": "); ok {
+			h.Set(key, value)
+		}
+	}
+	return h
+}
+
+// newMessageID returns a unique Message-ID in the domain of an address
+func newMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 8)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), random, domain)
+}
+
+// isLoopback reports whether host is localhost or a loopback address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SMTPSendResultToMap returns the result of sending a message as a map
+func SMTPSendResultToMap(r *SMTPSendResult) map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":  r.MessageID,
+		"response":    r.Response,
+		"accepted":    stringsToInterfaces(r.Accepted),
+		"rejected":    stringsToInterfaces(r.Rejected),
+		"tls":         r.TLS,
+		"tls_version": r.TLSVersion,
+	}
+}
+
+// SMTPReportToMap returns an SMTP server report as a map
+func SMTPReportToMap(r *SMTPReport) map[string]interface{} {
+	m := map[string]interface{}{
+		"host":            r.Host,
+		"port":            r.Port,
+		"banner":          r.Banner,
+		"extensions":      stringsToInterfaces(r.Extensions),
+		"starttls":        r.StartTLS,
+		"tls":             r.TLS,
+		"tls_version":     r.TLSVersion,
+		"tls_cipher":      r.TLSCipher,
+		"cert_subject":    r.CertSubject,
+		"cert_issuer":     r.CertIssuer,
+		"cert_names":      stringsToInterfaces(r.CertNames),
+		"cert_valid":      r.CertValid,
+		"cert_error":      r.CertError,
+		"auth_mechanisms": stringsToInterfaces(r.AuthMechanisms),
+		"auth_before_tls": stringsToInterfaces(r.AuthBeforeTLS),
+		"problems":        stringsToInterfaces(r.Problems),
+	}
+	if !r.CertExpires.IsZero() {
+		m["cert_expires"] = r.CertExpires.UTC().Format(time.RFC3339)
+	}
+	return m
+}
+
+func stringsToInterfaces(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,461 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SPF, DKIM and DMARC checks of a domain's DNS records
+
+// DKIMSelectors are the selectors tried when a check names none: the
+// defaults of common mail providers and signing software
+var DKIMSelectors = []string{
+	"default", "dkim", "mail", "selector1", "selector2", "google", "k1", "k2",
+	"s1", "s2", "smtp", "mx", "key1", "mandrill", "mxvault",
+}
+
+// spfLookupLimit is the most DNS lookups an SPF check may take (RFC 7208)
+const spfLookupLimit = 10
+
+// MailDomainOptions configures a check of a domain's mail records
+type MailDomainOptions struct {
+	Selectors []string // DKIM selectors, DKIMSelectors if empty
+	DNS       DNSOptions
+}
+
+// SPFRecord is a domain's SPF policy. All is its all mechanism with its
+// qualifier, such as "-all", and Lookups the DNS lookups it takes,
+// counting those of the records it includes.
+type SPFRecord struct {
+	Record   string
+	All      string
+	Includes []string
+	Lookups  int
+}
+
+// DMARCRecord is a domain's DMARC policy
+type DMARCRecord struct {
+	Record          string
+	Policy          string
+	SubdomainPolicy string
+	Percent         int
+	RUA             []string
+	RUF             []string
+	ADKIM           string // Alignment, "r" for relaxed or "s" for strict
+	ASPF            string
+}
+
+// DKIMKey is a public key published under a DKIM selector
+type DKIMKey struct {
+	Selector string
+	Record   string
+	KeyType  string
+	Bits     int
+	Testing  bool
+	Revoked  bool
+}
+
+// MailDomainReport is what a domain publishes to authenticate its mail,
+// and the problems found with it
+type MailDomainReport struct {
+	Domain   string
+	MX       []string // Mail servers in order of preference
+	SPF      *SPFRecord
+	DMARC    *DMARCRecord
+	DKIM     []DKIMKey
+	Problems []string
+}
+
+// mailChecker looks up the records of one domain check
+type mailChecker struct {
+	n      *NetworkModule
+	opts   DNSOptions
+	report *MailDomainReport
+}
+
+func (c *mailChecker) problem(format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, fmt.Sprintf(format, args...))
+}
+
+// txt returns the TXT records of a name, none if it does not exist
+func (c *mailChecker) txt(name string) ([]string, error) {
+	records, err := c.n.DNSLookup(name, "TXT", c.opts)
+	if err != nil && isNotFound(err) {
+		return nil, nil
+	}
+	return records, err
+}
+
+// isNotFound reports whether a lookup failed because the name does not
+// exist or has no records
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return strings.Contains(err.Error(), "no such host")
+}
+
+// CheckMailDomain looks up the MX, SPF, DMARC and DKIM records of a domain
+// and reports what is missing or weak in them
+func (n *NetworkModule) CheckMailDomain(domain string, opts MailDomainOptions) (*MailDomainReport, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return nil, errors.New("empty domain")
+	}
+	c := &mailChecker{n: n, opts: opts.DNS, report: &MailDomainReport{Domain: domain}}
+
+	mx, err := n.DNSLookup(domain, "MX", opts.DNS)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	c.report.MX = sortMX(mx)
+
+	if err := c.checkSPF(domain); err != nil {
+		return nil, err
+	}
+	if err := c.checkDMARC(domain); err != nil {
+		return nil, err
+	}
+	selectors := opts.Selectors
+	if len(selectors) == 0 {
+		selectors = DKIMSelectors
+	}
+	for _, selector := range selectors {
+		c.checkDKIM(domain, selector)
+	}
+	if len(c.report.DKIM) == 0 {
+		c.problem("no DKIM key found under selectors %s", strings.Join(selectors, ", "))
+	}
+	return c.report, nil
+}
+
+// sortMX returns the hosts of "host:preference" MX records, most
+// preferred first
+func sortMX(records []string) []string {
+	type mx struct {
+		host string
+		pref int
+	}
+	var list []mx
+	for _, record := range records {
+		host, pref := record, 0
+		if i := strings.LastIndex(record, ":"); i >= 0 {
+			host = record[:i]
+			pref, _ = strconv.Atoi(record[i+1:])
+		}
+		list = append(list, mx{strings.TrimSuffix(host, "."), pref})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].pref < list[j].pref })
+	hosts := make([]string, len(list))
+	for i, m := range list {
+		hosts[i] = m.host
+	}
+	return hosts
+}
+
+// spfRecords returns the SPF records among the TXT records of a domain
+func (c *mailChecker) spfRecords(domain string) ([]string, error) {
+	txt, err := c.txt(domain)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, record := range txt {
+		if lower := strings.ToLower(record); lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (c *mailChecker) checkSPF(domain string) error {
+	records, err := c.spfRecords(domain)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		c.problem("no SPF record")
+		return nil
+	}
+	if len(records) > 1 {
+		c.problem("%d SPF records, so SPF checks fail with a permanent error", len(records))
+	}
+	spf := &SPFRecord{Record: records[0]}
+	c.report.SPF = spf
+	seen := map[string]bool{domain: true}
+	redirect, err := c.countSPF(spf, records[0], seen, true)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case spf.All == "" && !redirect:
+		c.problem("SPF has no all mechanism, so mail from other servers passes as neutral")
+	case spf.All == "+all" || spf.All == "all":
+		c.problem("SPF %s lets any server send mail for the domain", spf.All)
+	case spf.All == "?all":
+		c.problem("SPF ?all treats mail from other servers as neutral")
+	}
+	if spf.Lookups > spfLookupLimit {
+		c.problem("SPF takes %d DNS lookups, more than the limit of %d", spf.Lookups, spfLookupLimit)
+	}
+	return nil
+}
+
+// countSPF adds the DNS lookups of an SPF record and of the records it
+// includes or redirects to. The top record sets the all mechanism and the
+// includes; it returns whether that record redirects.
+func (c *mailChecker) countSPF(spf *SPFRecord, record string, seen map[string]bool, top bool) (bool, error) {
+	redirect := false
+	for _, term := range strings.Fields(record)[1:] {
+		qualifier := ""
+		if strings.ContainsRune("+-~?", rune(term[0])) {
+			qualifier, term = term[:1], term[1:]
+		}
+		name, target := term, ""
+		if i := strings.IndexAny(term, ":=/"); i >= 0 {
+			name = term[:i]
+			if term[i] != '/' {
+				target = term[i+1:]
+			}
+		}
+		switch strings.ToLower(name) {
+		case "all":
+			if top {
+				spf.All = qualifier + "all"
+			}
+		case "a", "mx", "exists":
+			spf.Lookups++
+		case "ptr":
+			spf.Lookups++
+			if top {
+				c.problem("SPF uses the deprecated ptr mechanism")
+			}
+		case "include", "redirect":
+			spf.Lookups++
+			if strings.EqualFold(name, "redirect") {
+				redirect = true
+			} else if top {
+				spf.Includes = append(spf.Includes, target)
+			}
+			// Macros expand per message and loops are errors of their own
+			target = strings.ToLower(strings.TrimSuffix(target, "."))
+			if strings.Contains(target, "%") || seen[target] || spf.Lookups > spfLookupLimit {
+				continue
+			}
+			seen[target] = true
+			included, err := c.spfRecords(target)
+			if err != nil {
+				return false, err
+			}
+			if len(included) == 0 {
+				c.problem("SPF %s of %s finds no SPF record", strings.ToLower(name), target)
+				continue
+			}
+			if _, err := c.countSPF(spf, included[0], seen, false); err != nil {
+				return false, err
+			}
+		}
+	}
+	return redirect, nil
+}
+
+// tagList parses the tag=value; pairs of DMARC and DKIM records
+func tagList(record string) map[string]string {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(record, ";") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			tags[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return tags
+}
+
+func (c *mailChecker) checkDMARC(domain string) error {
+	txt, err := c.txt("_dmarc." + domain)
+	if err != nil {
+		return err
+	}
+	var record string
+	for _, r := range txt {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(r)), "V=DMARC1") {
+			record = r
+			break
+		}
+	}
+	if record == "" {
+		c.problem("no DMARC record")
+		return nil
+	}
+
+	tags := tagList(record)
+	dmarc := &DMARCRecord{
+		Record:          record,
+		Policy:          strings.ToLower(tags["p"]),
+		SubdomainPolicy: strings.ToLower(tags["sp"]),
+		Percent:         100,
+		ADKIM:           "r",
+		ASPF:            "r",
+	}
+	if dmarc.SubdomainPolicy == "" {
+		dmarc.SubdomainPolicy = dmarc.Policy
+	}
+	if pct, err := strconv.Atoi(tags["pct"]); err == nil {
+		dmarc.Percent = pct
+	}
+	for _, tag := range []struct {
+		key  string
+		list *[]string
+	}{{"rua", &dmarc.RUA}, {"ruf", &dmarc.RUF}} {
+		for _, uri := range strings.Split(tags[tag.key], ",") {
+			if uri = strings.TrimSpace(uri); uri != "" {
+				*tag.list = append(*tag.list, uri)
+			}
+		}
+	}
+	if v := strings.ToLower(tags["adkim"]); v != "" {
+		dmarc.ADKIM = v
+	}
+	if v := strings.ToLower(tags["aspf"]); v != "" {
+		dmarc.ASPF = v
+	}
+	c.report.DMARC = dmarc
+
+	switch dmarc.Policy {
+	case "reject", "quarantine":
+	case "none":
+		c.problem("DMARC policy none only monitors, spoofed mail is delivered")
+	case "":
+		c.problem("DMARC record has no policy")
+	default:
+		c.problem("DMARC policy %q is not none, quarantine or reject", dmarc.Policy)
+	}
+	if dmarc.SubdomainPolicy == "none" && dmarc.Policy != "none" {
+		c.problem("DMARC subdomain policy none lets subdomains be spoofed")
+	}
+	if dmarc.Percent < 100 {
+		c.problem("DMARC policy applies to only %d%% of mail", dmarc.Percent)
+	}
+	if len(dmarc.RUA) == 0 {
+		c.problem("DMARC has no rua address for aggregate reports")
+	}
+	return nil
+}
+
+// checkDKIM looks for a key under a selector. Selectors are guesses, so a
+// failed lookup counts as no key.
+func (c *mailChecker) checkDKIM(domain, selector string) {
+	txt, err := c.txt(selector + "._domainkey." + domain)
+	if err != nil {
+		return
+	}
+	record := strings.Join(txt, "")
+	tags := tagList(record)
+	p, ok := tags["p"]
+	if !ok {
+		return
+	}
+
+	key := DKIMKey{Selector: selector, Record: record, KeyType: strings.ToLower(tags["k"])}
+	if key.KeyType == "" {
+		key.KeyType = "rsa"
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			key.Testing = true
+		}
+	}
+	p = strings.Join(strings.Fields(p), "")
+	if p == "" {
+		key.Revoked = true
+		c.problem("DKIM key %s is revoked", selector)
+	} else if der, err := base64.StdEncoding.DecodeString(p); err != nil {
+		c.problem("DKIM key %s is not valid base64", selector)
+	} else {
+		key.Bits = dkimKeyBits(key.KeyType, der)
+		switch {
+		case key.Bits == 0:
+			c.problem("DKIM key %s cannot be parsed as %s", selector, key.KeyType)
+		case key.KeyType == "rsa" && key.Bits < 1024:
+			c.problem("DKIM key %s has only %d bits", selector, key.Bits)
+		}
+	}
+	if key.Testing {
+		c.problem("DKIM key %s is in testing mode", selector)
+	}
+	c.report.DKIM = append(c.report.DKIM, key)
+}
+
+// dkimKeyBits returns the size of a DKIM public key, 0 if it does not
+// parse
+func dkimKeyBits(keyType string, der []byte) int {
+	if keyType == "ed25519" {
+		if len(der) == ed25519.PublicKeySize {
+			return 256
+		}
+		return 0
+	}
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+			return rsaKey.N.BitLen()
+		}
+		return 0
+	}
+	if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return rsaKey.N.BitLen()
+	}
+	return 0
+}
+
+// MailDomainReportToMap returns a domain report as a map
+func MailDomainReportToMap(r *MailDomainReport) map[string]interface{} {
+	m := map[string]interface{}{
+		"domain":   r.Domain,
+		"mx":       stringsToInterfaces(r.MX),
+		"spf":      nil,
+		"dmarc":    nil,
+		"problems": stringsToInterfaces(r.Problems),
+	}
+	if r.SPF != nil {
+		m["spf"] = map[string]interface{}{
+			"record":   r.SPF.Record,
+			"all":      r.SPF.All,
+			"includes": stringsToInterfaces(r.SPF.Includes),
+			"lookups":  r.SPF.Lookups,
+		}
+	}
+	if r.DMARC != nil {
+		m["dmarc"] = map[string]interface{}{
+			"record":           r.DMARC.Record,
+			"policy":           r.DMARC.Policy,
+			"subdomain_policy": r.DMARC.SubdomainPolicy,
+			"pct":              r.DMARC.Percent,
+			"rua":              stringsToInterfaces(r.DMARC.RUA),
+			"ruf":              stringsToInterfaces(r.DMARC.RUF),
+			"adkim":            r.DMARC.ADKIM,
+			"aspf":             r.DMARC.ASPF,
+		}
+	}
+	dkim := make([]interface{}, len(r.DKIM))
+	for i, key := range r.DKIM {
+		dkim[i] = map[string]interface{}{
+			"selector": key.Selector,
+			"record":   key.Record,
+			"key_type": key.KeyType,
+			"bits":     key.Bits,
+			"testing":  key.Testing,
+			"revoked":  key.Revoked,
+		}
+	}
+	m["dkim"] = dkim
+	return m
+}
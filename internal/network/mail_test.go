@@ -0,0 +1,274 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns a server config with the self-signed certificate
+// of httptest, valid for 127.0.0.1
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.TLS.Clone()
+}
+
+// fakeSMTP serves a Postfix-like SMTP server that offers STARTTLS if
+// config is set, takes user:secret with AUTH PLAIN, rejects
+// nobody@example.test and sends the messages it accepts to received
+func fakeSMTP(t *testing.T, config *tls.Config, received chan<- string) (string, int) {
+	return serveTCP(t, func(conn net.Conn) {
+		text := textproto.NewConn(conn)
+		secure := false
+		text.PrintfLine("220 mx.example.test ESMTP Postfix (Debian/GNU)")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				if config != nil && !secure {
+					text.PrintfLine("250-mx.example.test\r\n250-PIPELINING\r\n250-STARTTLS\r\n250 AUTH PLAIN LOGIN")
+				} else {
+					text.PrintfLine("250-mx.example.test\r\n250-SIZE 10240000\r\n250 AUTH PLAIN")
+				}
+			case "STARTTLS":
+				text.PrintfLine("220 2.0.0 Ready to start TLS")
+				server := tls.Server(conn, config)
+				if server.Handshake() != nil {
+					return
+				}
+				text, secure = textproto.NewConn(server), true
+			case "AUTH":
+				if arg == "PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret")) {
+					text.PrintfLine("235 2.7.0 Authentication successful")
+				} else {
+					text.PrintfLine("535 5.7.8 Error: authentication failed")
+				}
+			case "MAIL":
+				text.PrintfLine("250 2.1.0 Ok")
+			case "RCPT":
+				if strings.Contains(arg, "nobody@") {
+					text.PrintfLine("550 5.1.1 <nobody@example.test>: Recipient address rejected")
+				} else {
+					text.PrintfLine("250 2.1.5 Ok")
+				}
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- string(data)
+				text.PrintfLine("250 2.0.0 Ok: queued as 4F2A1C3B")
+			case "QUIT":
+				text.PrintfLine("221 2.0.0 Bye")
+				return
+			default:
+				text.PrintfLine("502 5.5.2 Error: command not recognized")
+			}
+		}
+	})
+}
+
+func TestSendMail(t *testing.T) {
+	received := make(chan string, 1)
+	host, port := fakeSMTP(t, testTLSConfig(t), received)
+
+	msg := &MailMessage{
+		From:        "Payroll <payroll@example.test>",
+		To:          []string{"victim@example.test", "nobody@example.test"},
+		Bcc:         []string{"audit@example.test"},
+		Subject:     "Überweisung ausstehend",
+		Text:        "Please open the attached invoice.",
+		HTML:        "<p>Please open the <b>attached</b> invoice.</p>",
+		Headers:     map[string]string{"x-campaign": "q3-phish"},
+		Attachments: []MailAttachment{{Name: "invoice.txt", Data: []byte(strings.Repeat("EICAR ", 30))}},
+	}
+	opts := SMTPOptions{Port: port, Username: "user", Password: "secret", TLS: "require", Insecure: true, Timeout: 2 * time.Second}
+	result, err := SendMail(host, msg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TLS || result.TLSVersion != "TLS 1.3" || !strings.Contains(result.Response, "queued as 4F2A1C3B") {
+		t.Errorf("got %+v", result)
+	}
+	if !reflect.DeepEqual(result.Accepted, []string{"victim@example.test", "audit@example.test"}) || len(result.Rejected) != 1 {
+		t.Errorf("accepted %q, rejected %q", result.Accepted, result.Rejected)
+	}
+
+	// The message reads back as it was written
+	var parsed IMAPMessage
+	if err := parsed.parse([]byte(<-received)); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Subject != msg.Subject || parsed.From != "payroll@example.test" || parsed.MessageID != result.MessageID || parsed.Headers["X-Campaign"] != "q3-phish" {
+		t.Errorf("headers %+v", parsed)
+	}
+	if _, ok := parsed.Headers["Bcc"]; ok {
+		t.Error("message has a Bcc header")
+	}
+	if parsed.Text != msg.Text || parsed.HTML != msg.HTML || len(parsed.Attachments) != 1 || string(parsed.Attachments[0].Data) != string(msg.Attachments[0].Data) {
+		t.Errorf("body %q %q %+v", parsed.Text, parsed.HTML, parsed.Attachments)
+	}
+
+	opts.Password = "wrong"
+	if _, err := SendMail(host, msg, opts); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+func TestSendMailRequireTLS(t *testing.T) {
+	received := make(chan string, 1)
+	host, port := fakeSMTP(t, nil, received)
+	msg := &MailMessage{From: "a@example.test", To: []string{"b@example.test"}, Subject: "plain", Text: "hello"}
+
+	if _, err := SendMail(host, msg, SMTPOptions{Port: port, TLS: "require", Timeout: 2 * time.Second}); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("require: %v", err)
+	}
+	result, err := SendMail(host, msg, SMTPOptions{Port: port, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLS || !strings.Contains(<-received, "\n\nhello") {
+		t.Errorf("got %+v", result)
+	}
+	if _, err := SendMail(host, &MailMessage{From: "a@example.test", To: []string{"nobody@example.test"}}, SMTPOptions{Port: port}); err == nil {
+		t.Error("sent a message every recipient rejected")
+	}
+}
+
+func TestCheckSMTP(t *testing.T) {
+	host, port := fakeSMTP(t, testTLSConfig(t), nil)
+	report, err := CheckSMTP(host, SMTPOptions{Port: port, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Banner != "mx.example.test ESMTP Postfix (Debian/GNU)" || !report.StartTLS || !report.TLS || report.TLSVersion != "TLS 1.3" {
+		t.Errorf("got %+v", report)
+	}
+	if !reflect.DeepEqual(report.AuthBeforeTLS, []string{"PLAIN", "LOGIN"}) || !reflect.DeepEqual(report.AuthMechanisms, []string{"PLAIN"}) {
+		t.Errorf("auth before TLS %q, after %q", report.AuthBeforeTLS, report.AuthMechanisms)
+	}
+	if report.CertValid || report.CertExpires.IsZero() || len(report.Problems) != 2 || report.Problems[0] != "authentication is offered before STARTTLS" {
+		t.Errorf("certificate valid %v, problems %q", report.CertValid, report.Problems)
+	}
+
+	host, port = fakeSMTP(t, nil, nil)
+	report, err = CheckSMTP(host, SMTPOptions{Port: port, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.StartTLS || report.TLS || !reflect.DeepEqual(report.Problems, []string{"STARTTLS is not offered", "authentication is offered before STARTTLS"}) {
+		t.Errorf("got %+v", report)
+	}
+}
+
+// txtData encodes a TXT record, split into strings of at most size bytes
+func txtData(record string, size int) []byte {
+	var data []byte
+	for len(record) > size {
+		data = append(append(data, byte(size)), record[:size]...)
+		record = record[size:]
+	}
+	return append(append(data, byte(len(record))), record...)
+}
+
+// addZone adds names to the zone of the fake DNS servers for one test
+func addZone(t *testing.T, zone map[string]map[uint16][][]byte) {
+	for name, records := range zone {
+		testZone[name] = records
+	}
+	t.Cleanup(func() {
+		for name := range zone {
+			delete(testZone, name)
+		}
+	})
+}
+
+func TestCheckMailDomain(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	addZone(t, map[string]map[uint16][][]byte{
+		"mail.test.": {
+			15: {mxData(20, "mx2.mail.test."), mxData(10, "mx1.mail.test.")},
+			16: {txtData("google-site-verification=abc", 255), txtData("v=spf1 include:_spf.mail.test mx ptr ~all", 255)},
+		},
+		"_spf.mail.test.": {
+			16: {txtData("v=spf1 ip4:192.0.2.0/24 a include:mail.test include:missing.test -all", 255)},
+		},
+		"_dmarc.mail.test.": {
+			16: {txtData("v=DMARC1; p=quarantine; sp=none; pct=50; rua=mailto:dmarc@mail.test,mailto:ops@mail.test; adkim=s", 255)},
+		},
+		"s1._domainkey.mail.test.": {
+			16: {txtData("v=DKIM1; k=rsa; t=y; p="+base64.StdEncoding.EncodeToString(der), 100)},
+		},
+		"old._domainkey.mail.test.": {
+			16: {txtData("v=DKIM1; p=", 255)},
+		},
+		"open.test.": {
+			16: {txtData("v=spf1 +all", 255)},
+		},
+	})
+	n := NewNetworkModule()
+	dns := DNSOptions{Server: startTestDNSServer(t, 0), Timeout: 2 * time.Second}
+
+	report, err := n.CheckMailDomain("Mail.Test", MailDomainOptions{Selectors: []string{"s1", "old", "none"}, DNS: dns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.MX, []string{"mx1.mail.test", "mx2.mail.test"}) {
+		t.Errorf("MX %q", report.MX)
+	}
+	if spf := report.SPF; spf == nil || spf.All != "~all" || spf.Lookups != 6 || !reflect.DeepEqual(spf.Includes, []string{"_spf.mail.test"}) {
+		t.Errorf("SPF %+v", spf)
+	}
+	if report.DMARC == nil {
+		t.Fatal("no DMARC record")
+	}
+	want := DMARCRecord{Record: report.DMARC.Record, Policy: "quarantine", SubdomainPolicy: "none", Percent: 50, RUA: []string{"mailto:dmarc@mail.test", "mailto:ops@mail.test"}, ADKIM: "s", ASPF: "r"}
+	if !reflect.DeepEqual(*report.DMARC, want) {
+		t.Errorf("DMARC %+v", report.DMARC)
+	}
+	if len(report.DKIM) != 2 || report.DKIM[0].Bits != 1024 || !report.DKIM[0].Testing || !report.DKIM[1].Revoked {
+		t.Errorf("DKIM %+v", report.DKIM)
+	}
+	wantProblems := []string{
+		"SPF include of missing.test finds no SPF record",
+		"SPF uses the deprecated ptr mechanism",
+		"DMARC subdomain policy none lets subdomains be spoofed",
+		"DMARC policy applies to only 50% of mail",
+		"DKIM key s1 is in testing mode",
+		"DKIM key old is revoked",
+	}
+	if !reflect.DeepEqual(report.Problems, wantProblems) {
+		t.Errorf("problems %q", report.Problems)
+	}
+
+	report, err = n.CheckMailDomain("open.test", MailDomainOptions{Selectors: []string{"s1"}, DNS: dns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantProblems = []string{"SPF +all lets any server send mail for the domain", "no DMARC record", "no DKIM key found under selectors s1"}
+	if report.SPF == nil || report.DMARC != nil || len(report.MX) != 0 || !reflect.DeepEqual(report.Problems, wantProblems) {
+		t.Errorf("got %+v", report)
+	}
+}
@@ -0,0 +1,513 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// MQTT clients and broker audits
+
+// MQTTOptions configures an MQTT connection. Without a username the
+// connection is anonymous.
+type MQTTOptions struct {
+	Port     int  // 8883 with TLS, 1883 otherwise
+	TLS      bool // Implied by port 8883, CA, Cert and Insecure
+	CA       string
+	Cert     string
+	Key      string
+	Insecure bool
+	Username string
+	Password string
+	ClientID string        // A random sentra- ID if empty
+	Timeout  time.Duration // 10 seconds if 0
+}
+
+func (o MQTTOptions) withDefaults() MQTTOptions {
+	if o.Port == 8883 || o.CA != "" || o.Cert != "" || o.Insecure {
+		o.TLS = true
+	}
+	if o.Port == 0 {
+		o.Port = 1883
+		if o.TLS {
+			o.Port = 8883
+		}
+	}
+	if o.ClientID == "" {
+		b := make([]byte, 6)
+		rand.Read(b)
+		o.ClientID = "sentra-" + hex.EncodeToString(b)
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// MQTTConn is a connection to a broker that keeps the messages of its
+// subscriptions until they are read
+type MQTTConn struct {
+	ID      string
+	Broker  string
+	client  mqtt.Client
+	timeout time.Duration
+
+	mu       sync.Mutex
+	messages []MQTTMessage
+	arrived  chan struct{}
+}
+
+// MQTTMessage is a message received on a subscription
+type MQTTMessage struct {
+	Topic    string
+	Payload  []byte
+	QoS      int
+	Retained bool
+	Time     time.Time
+}
+
+// MQTTSubscription is the broker's answer to a subscription: the QoS it
+// granted, or 128 if it refused the topic
+type MQTTSubscription struct {
+	Topic   string
+	QoS     int
+	Granted bool
+}
+
+// mqttMaxMessages bounds the messages a connection keeps unread
+const mqttMaxMessages = 10000
+
+// mqttConns holds the open connections by id
+var mqttConns = make(map[string]*MQTTConn)
+
+// mqttBroker splits host into the broker's address and port
+func mqttBroker(host string, port int) (string, int) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			return h, n
+		}
+	}
+	return host, port
+}
+
+// MQTTConnect connects to an MQTT broker with MQTT 3.1.1. A refused
+// connection returns the broker's reason, such as "not authorized".
+func MQTTConnect(host string, opts MQTTOptions) (*MQTTConn, error) {
+	host, opts.Port = mqttBroker(host, opts.Port)
+	opts = opts.withDefaults()
+	scheme := "tcp"
+	o := mqtt.NewClientOptions().
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetProtocolVersion(4).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(opts.Timeout).
+		SetWriteTimeout(opts.Timeout)
+	if opts.TLS {
+		scheme = "ssl"
+		config, err := clientTLSConfig(host, opts.CA, opts.Cert, opts.Key, opts.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		o.SetTLSConfig(config)
+	}
+	c := &MQTTConn{Broker: net.JoinHostPort(host, strconv.Itoa(opts.Port)), timeout: opts.Timeout, arrived: make(chan struct{}, 1)}
+	o.AddBroker(scheme + "://" + c.Broker)
+	o.SetDefaultPublishHandler(c.receive)
+
+	c.client = mqtt.NewClient(o)
+	token := c.client.Connect()
+	if !token.WaitTimeout(opts.Timeout) {
+		c.client.Disconnect(0)
+		return nil, fmt.Errorf("no answer from %s", c.Broker)
+	}
+	if err := token.Error(); err != nil {
+		if code := token.(*mqtt.ConnectToken).ReturnCode(); code != packets.Accepted {
+			return nil, &MQTTRefusedError{Code: int(code)}
+		}
+		return nil, err
+	}
+
+	registryMutex.Lock()
+	c.ID = generateID("mqtt")
+	mqttConns[c.ID] = c
+	registryMutex.Unlock()
+	return c, nil
+}
+
+// MQTTRefusedError is a connection the broker refused with a CONNACK code
+type MQTTRefusedError struct {
+	Code int
+}
+
+func (e *MQTTRefusedError) Error() string {
+	if reason, ok := packets.ConnackReturnCodes[uint8(e.Code)]; ok {
+		return "broker refused the connection: " + reason
+	}
+	return fmt.Sprintf("broker refused the connection with code %d", e.Code)
+}
+
+// receive keeps a message for Messages
+func (c *MQTTConn) receive(_ mqtt.Client, m mqtt.Message) {
+	c.mu.Lock()
+	if len(c.messages) < mqttMaxMessages {
+		c.messages = append(c.messages, MQTTMessage{
+			Topic:    m.Topic(),
+			Payload:  append([]byte(nil), m.Payload()...),
+			QoS:      int(m.Qos()),
+			Retained: m.Retained(),
+			Time:     time.Now(),
+		})
+	}
+	c.mu.Unlock()
+	select {
+	case c.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// LookupMQTT returns an open connection by id
+func LookupMQTT(id string) (*MQTTConn, error) {
+	registryMutex.RLock()
+	c, ok := mqttConns[id]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("MQTT connection '%s' not found", id)
+	}
+	return c, nil
+}
+
+// Close disconnects and forgets the connection
+func (c *MQTTConn) Close() {
+	registryMutex.Lock()
+	delete(mqttConns, c.ID)
+	registryMutex.Unlock()
+	c.client.Disconnect(250)
+}
+
+// Subscribe subscribes to topic filters and returns what the broker
+// granted for each
+func (c *MQTTConn) Subscribe(topics []string, qos int) ([]MQTTSubscription, error) {
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("QoS must be 0, 1 or 2, got %d", qos)
+	}
+	filters := make(map[string]byte, len(topics))
+	for _, t := range topics {
+		filters[t] = byte(qos)
+	}
+	token := c.client.SubscribeMultiple(filters, nil)
+	if !token.WaitTimeout(c.timeout) {
+		return nil, errors.New("no SUBACK from the broker")
+	}
+	if err := token.Error(); err != nil && len(token.(*mqtt.SubscribeToken).Result()) == 0 {
+		return nil, err
+	}
+	result := token.(*mqtt.SubscribeToken).Result()
+	subs := make([]MQTTSubscription, len(topics))
+	for i, t := range topics {
+		code, ok := result[t]
+		if !ok {
+			code = 0x80
+		}
+		subs[i] = MQTTSubscription{Topic: t, QoS: int(code), Granted: code < 0x80}
+	}
+	return subs, nil
+}
+
+// Unsubscribe ends subscriptions
+func (c *MQTTConn) Unsubscribe(topics []string) error {
+	token := c.client.Unsubscribe(topics...)
+	if !token.WaitTimeout(c.timeout) {
+		return errors.New("no UNSUBACK from the broker")
+	}
+	return token.Error()
+}
+
+// Publish sends a message. With QoS 1 and 2 it waits for the broker's
+// acknowledgement; MQTT 3.1.1 brokers drop messages they do not allow
+// without telling the client.
+func (c *MQTTConn) Publish(topic string, payload []byte, qos int, retain bool) error {
+	if qos < 0 || qos > 2 {
+		return fmt.Errorf("QoS must be 0, 1 or 2, got %d", qos)
+	}
+	token := c.client.Publish(topic, byte(qos), retain, payload)
+	if !token.WaitTimeout(c.timeout) {
+		return errors.New("no acknowledgement from the broker")
+	}
+	return token.Error()
+}
+
+// Messages returns and forgets the messages received so far, up to max
+// if not 0, waiting up to wait for the first if there are none
+func (c *MQTTConn) Messages(wait time.Duration, max int) []MQTTMessage {
+	deadline := time.Now().Add(wait)
+	for {
+		c.mu.Lock()
+		if n := len(c.messages); n > 0 || !time.Now().Before(deadline) {
+			if max > 0 && n > max {
+				n = max
+			}
+			msgs := c.messages[:n:n]
+			c.messages = c.messages[n:]
+			c.mu.Unlock()
+			return msgs
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.arrived:
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+}
+
+// ProtocolIssue is a weakness found in a broker or device protocol
+type ProtocolIssue struct {
+	Issue          string
+	Severity       string
+	Description    string
+	Recommendation string
+}
+
+// MQTTAuditOptions chooses what an MQTT audit tries. Topics are the
+// filters tried on subscribe, after # and $SYS/#. Publishing is only
+// tried on PublishTopics, with a probe message that is read back, since
+// writing to a device's topics can change what it does.
+type MQTTAuditOptions struct {
+	MQTTOptions
+	Topics        []string
+	PublishTopics []string
+	Wait          time.Duration // To collect messages; 2 seconds if 0
+}
+
+// MQTTAudit is what an audit of a broker found
+type MQTTAudit struct {
+	Broker         string
+	TLS            bool
+	Anonymous      bool   // The broker accepts clients without credentials
+	Authentication string // How the audit connected: none, password or certificate
+	Version        string // From $SYS/broker/version, if readable
+	Subscriptions  []MQTTSubscription
+	Published      map[string]bool // Probe topics whose message came back
+	Topics         []string        // Topics messages arrived on, up to 100
+	Issues         []ProtocolIssue
+	Score          int
+}
+
+// AuditMQTT connects to a broker anonymously and with the credentials
+// given, and reports anonymous access, plaintext connections, the topic
+// filters the broker lets it read, and the probe topics it can write
+func AuditMQTT(host string, opts MQTTAuditOptions) (*MQTTAudit, error) {
+	base := opts.MQTTOptions
+	host, base.Port = mqttBroker(host, base.Port)
+	base = base.withDefaults()
+	if opts.Wait <= 0 {
+		opts.Wait = 2 * time.Second
+	}
+	audit := &MQTTAudit{TLS: base.TLS, Published: map[string]bool{}, Authentication: "none"}
+
+	anon := base
+	anon.Username, anon.Password, anon.Cert, anon.Key = "", "", "", ""
+	conn, err := MQTTConnect(host, anon)
+	var refused *MQTTRefusedError
+	switch {
+	case err == nil:
+		audit.Anonymous = true
+	case !errors.As(err, &refused):
+		return nil, err
+	}
+	if base.Username != "" || base.Cert != "" {
+		if conn != nil {
+			conn.Close()
+		}
+		if conn, err = MQTTConnect(host, base); err != nil {
+			return nil, err
+		}
+		audit.Authentication = "password"
+		if base.Cert != "" {
+			audit.Authentication = "certificate"
+		}
+	}
+	if conn == nil {
+		// Refused anonymously and no credentials to go further
+		audit.Broker = net.JoinHostPort(host, strconv.Itoa(base.Port))
+		audit.finish()
+		return audit, nil
+	}
+	defer conn.Close()
+	audit.Broker = conn.Broker
+
+	topics := append([]string{"#", "$SYS/#"}, opts.Topics...)
+	if audit.Subscriptions, err = conn.Subscribe(topics, 0); err != nil {
+		return nil, err
+	}
+	probes := make(map[string]string, len(opts.PublishTopics))
+	if len(opts.PublishTopics) > 0 {
+		if _, err := conn.Subscribe(opts.PublishTopics, 0); err != nil {
+			return nil, err
+		}
+		for _, t := range opts.PublishTopics {
+			b := make([]byte, 8)
+			rand.Read(b)
+			probes[t] = "sentra-probe-" + hex.EncodeToString(b)
+			conn.Publish(t, []byte(probes[t]), 0, false)
+			audit.Published[t] = false
+		}
+	}
+
+	seen := map[string]bool{}
+	deadline := time.Now().Add(opts.Wait)
+	for time.Now().Before(deadline) {
+		for _, m := range conn.Messages(time.Until(deadline), 0) {
+			if probe, ok := probes[m.Topic]; ok && string(m.Payload) == probe {
+				audit.Published[m.Topic] = true
+				continue
+			}
+			if m.Topic == "$SYS/broker/version" {
+				audit.Version = string(m.Payload)
+			}
+			if !seen[m.Topic] && len(audit.Topics) < 100 {
+				seen[m.Topic] = true
+				audit.Topics = append(audit.Topics, m.Topic)
+			}
+		}
+	}
+	audit.finish()
+	return audit, nil
+}
+
+// finish lists the issues found and scores the broker
+func (a *MQTTAudit) finish() {
+	if a.Anonymous {
+		a.Issues = append(a.Issues, ProtocolIssue{"Anonymous access", "HIGH",
+			"The broker accepts clients without credentials",
+			"Set allow_anonymous false and require passwords or client certificates"})
+	}
+	if !a.TLS {
+		severity := "MEDIUM"
+		if a.Authentication == "password" {
+			severity = "HIGH"
+		}
+		a.Issues = append(a.Issues, ProtocolIssue{"No TLS encryption", severity,
+			"Messages and credentials cross the network in clear text",
+			"Serve MQTT over TLS on port 8883 and close port 1883"})
+	}
+	for _, s := range a.Subscriptions {
+		if !s.Granted {
+			continue
+		}
+		switch s.Topic {
+		case "#":
+			a.Issues = append(a.Issues, ProtocolIssue{"No topic access control", "HIGH",
+				fmt.Sprintf("The broker lets %s clients read every topic with #", a.Authentication),
+				"Restrict each client to its own topics with ACLs"})
+		case "$SYS/#":
+			a.Issues = append(a.Issues, ProtocolIssue{"Broker information exposed", "LOW",
+				"$SYS topics reveal the broker's version, clients and load",
+				"Deny $SYS/# to clients that do not monitor the broker"})
+		}
+	}
+	for topic, ok := range a.Published {
+		if ok {
+			a.Issues = append(a.Issues, ProtocolIssue{"Unrestricted publishing", "MEDIUM",
+				fmt.Sprintf("%s clients can publish to %s", a.Authentication, topic),
+				"Limit the topics each client may write with ACLs"})
+		}
+	}
+	a.Score = protocolScore(a.Issues)
+}
+
+// protocolScore scores a protocol from 100 down by the severity of its
+// issues
+func protocolScore(issues []ProtocolIssue) int {
+	score := 100
+	for _, i := range issues {
+		switch i.Severity {
+		case "HIGH":
+			score -= 30
+		case "MEDIUM":
+			score -= 15
+		case "LOW":
+			score -= 5
+		}
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
+// MQTTMessageToMap converts a message to a map
+func MQTTMessageToMap(m MQTTMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"topic":    m.Topic,
+		"payload":  string(m.Payload),
+		"qos":      m.QoS,
+		"retained": m.Retained,
+		"time":     m.Time.Unix(),
+	}
+}
+
+// MQTTSubscriptionToMap converts a subscription result to a map
+func MQTTSubscriptionToMap(s MQTTSubscription) map[string]interface{} {
+	return map[string]interface{}{"topic": s.Topic, "qos": s.QoS, "granted": s.Granted}
+}
+
+// ProtocolIssuesToList converts issues to maps
+func ProtocolIssuesToList(issues []ProtocolIssue) []interface{} {
+	list := make([]interface{}, len(issues))
+	for i, issue := range issues {
+		list[i] = map[string]interface{}{
+			"issue":          issue.Issue,
+			"severity":       issue.Severity,
+			"description":    issue.Description,
+			"recommendation": issue.Recommendation,
+		}
+	}
+	return list
+}
+
+// MQTTAuditToMap converts an audit to a map with the keys of
+// iot_protocol_security
+func MQTTAuditToMap(a *MQTTAudit) map[string]interface{} {
+	host, port, _ := net.SplitHostPort(a.Broker)
+	if host == "" {
+		host = a.Broker
+	}
+	portNum, _ := strconv.Atoi(port)
+	subs := make([]interface{}, len(a.Subscriptions))
+	access := true
+	for i, s := range a.Subscriptions {
+		subs[i] = MQTTSubscriptionToMap(s)
+		if s.Topic == "#" && s.Granted {
+			access = false
+		}
+	}
+	published := make(map[string]interface{}, len(a.Published))
+	for t, ok := range a.Published {
+		published[t] = ok
+	}
+	return map[string]interface{}{
+		"broker_address":          host,
+		"broker_port":             portNum,
+		"tls_enabled":             a.TLS,
+		"anonymous_access":        a.Anonymous,
+		"authentication":          a.Authentication,
+		"broker_version":          a.Version,
+		"topic_access_control":    access,
+		"subscriptions":           subs,
+		"published":               published,
+		"topics_seen":             stringsToInterfaces(a.Topics),
+		"security_issues":         ProtocolIssuesToList(a.Issues),
+		"protocol_security_score": a.Score,
+	}
+}
@@ -0,0 +1,268 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// fakeBroker is an MQTT 3.1.1 broker for tests. Without users it accepts
+// anonymous clients and lets them read and write every topic; with users
+// it needs a password and limits each client to the topics under allow.
+type fakeBroker struct {
+	users map[string]string
+	allow string
+
+	mu   sync.Mutex
+	subs map[net.Conn][]string
+}
+
+func startBroker(t *testing.T, b *fakeBroker) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b.subs = map[net.Conn][]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// permits reports whether a client may use a topic or filter
+func (b *fakeBroker) permits(topic string) bool {
+	return b.users == nil || strings.HasPrefix(topic, b.allow+"/")
+}
+
+func matchTopic(filter, topic string) bool {
+	if filter == "#" {
+		return !strings.HasPrefix(topic, "$")
+	}
+	if strings.HasSuffix(filter, "/#") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(filter, "#"))
+	}
+	return filter == topic
+}
+
+func (b *fakeBroker) send(conn net.Conn, p packets.ControlPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p.Write(conn)
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	first, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := first.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	if b.users != nil {
+		password, known := b.users[connect.Username]
+		switch {
+		case !connect.UsernameFlag:
+			ack.ReturnCode = packets.ErrRefusedNotAuthorised
+		case !known || password != string(connect.Password):
+			ack.ReturnCode = packets.ErrRefusedBadUsernameOrPassword
+		}
+	}
+	b.send(conn, ack)
+	if ack.ReturnCode != packets.Accepted {
+		return
+	}
+
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			var sys bool
+			for _, filter := range p.Topics {
+				if !b.permits(filter) {
+					suback.ReturnCodes = append(suback.ReturnCodes, 0x80)
+					continue
+				}
+				suback.ReturnCodes = append(suback.ReturnCodes, 0)
+				b.mu.Lock()
+				b.subs[conn] = append(b.subs[conn], filter)
+				b.mu.Unlock()
+				sys = sys || filter == "$SYS/#"
+			}
+			b.send(conn, suback)
+			if sys {
+				version := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				version.TopicName, version.Payload, version.Retain = "$SYS/broker/version", []byte("fake 1.0"), true
+				b.send(conn, version)
+			}
+		case *packets.PublishPacket:
+			if p.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				b.send(conn, puback)
+			}
+			if !b.permits(p.TopicName) {
+				continue
+			}
+			b.mu.Lock()
+			var to []net.Conn
+			for c, filters := range b.subs {
+				for _, f := range filters {
+					if matchTopic(f, p.TopicName) {
+						to = append(to, c)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			for _, c := range to {
+				out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				out.TopicName, out.Payload = p.TopicName, p.Payload
+				b.send(c, out)
+			}
+		case *packets.UnsubscribePacket:
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			b.send(conn, unsuback)
+		case *packets.PingreqPacket:
+			b.send(conn, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func TestMQTTClient(t *testing.T) {
+	addr := startBroker(t, &fakeBroker{users: map[string]string{"sensor": "s3cret"}, allow: "sensors"})
+	host, port, _ := net.SplitHostPort(addr)
+
+	_, err := MQTTConnect(addr, MQTTOptions{Timeout: 2 * time.Second})
+	var refused *MQTTRefusedError
+	if !errors.As(err, &refused) || refused.Code != int(packets.ErrRefusedNotAuthorised) {
+		t.Fatalf("anonymous connect: got %v, want not authorised", err)
+	}
+	if _, err := MQTTConnect(host+":"+port, MQTTOptions{Username: "sensor", Password: "wrong"}); !errors.As(err, &refused) || refused.Code != int(packets.ErrRefusedBadUsernameOrPassword) {
+		t.Fatalf("bad password: got %v", err)
+	}
+
+	c, err := MQTTConnect(host, MQTTOptions{Port: mustAtoi(t, port), Username: "sensor", Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got, err := LookupMQTT(c.ID); err != nil || got != c {
+		t.Fatalf("LookupMQTT: %v", err)
+	}
+	subs, err := c.Subscribe([]string{"sensors/#", "#"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !subs[0].Granted || subs[1].Granted {
+		t.Errorf("subscriptions: %+v", subs)
+	}
+	if err := c.Publish("sensors/temp", []byte("21.5"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	msgs := c.Messages(2*time.Second, 0)
+	if len(msgs) != 1 || msgs[0].Topic != "sensors/temp" || string(msgs[0].Payload) != "21.5" {
+		t.Fatalf("messages: %+v", msgs)
+	}
+	if msgs := c.Messages(0, 0); len(msgs) != 0 {
+		t.Errorf("messages were not forgotten: %+v", msgs)
+	}
+	if err := c.Unsubscribe([]string{"sensors/#"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Subscribe([]string{"x"}, 3); err == nil {
+		t.Error("QoS 3 was accepted")
+	}
+}
+
+func TestAuditMQTT(t *testing.T) {
+	open := startBroker(t, &fakeBroker{})
+	audit, err := AuditMQTT(open, MQTTAuditOptions{PublishTopics: []string{"devices/1/cmd"}, Wait: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !audit.Anonymous || audit.Authentication != "none" || audit.Version != "fake 1.0" || !audit.Published["devices/1/cmd"] {
+		t.Fatalf("open broker: %+v", audit)
+	}
+	issues := map[string]string{}
+	for _, i := range audit.Issues {
+		issues[i.Issue] = i.Severity
+	}
+	want := map[string]string{
+		"Anonymous access":           "HIGH",
+		"No TLS encryption":          "MEDIUM",
+		"No topic access control":    "HIGH",
+		"Broker information exposed": "LOW",
+		"Unrestricted publishing":    "MEDIUM",
+	}
+	for issue, severity := range want {
+		if issues[issue] != severity {
+			t.Errorf("issue %q: got %q, want %q", issue, issues[issue], severity)
+		}
+	}
+	if audit.Score != 5 {
+		t.Errorf("score %d, want 5", audit.Score)
+	}
+
+	locked := startBroker(t, &fakeBroker{users: map[string]string{"sensor": "s3cret"}, allow: "sensors"})
+	opts := MQTTAuditOptions{Topics: []string{"sensors/#"}, PublishTopics: []string{"actuators/valve"}, Wait: 300 * time.Millisecond}
+	opts.Username, opts.Password = "sensor", "s3cret"
+	audit, err = AuditMQTT(locked, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.Anonymous || audit.Authentication != "password" || audit.Published["actuators/valve"] {
+		t.Fatalf("locked broker: %+v", audit)
+	}
+	if len(audit.Issues) != 1 || audit.Issues[0].Issue != "No TLS encryption" || audit.Issues[0].Severity != "HIGH" || audit.Score != 70 {
+		t.Errorf("locked broker issues: %+v score %d", audit.Issues, audit.Score)
+	}
+	m := MQTTAuditToMap(audit)
+	if m["topic_access_control"] != true || m["anonymous_access"] != false {
+		t.Errorf("map: %v", m)
+	}
+
+	// Refused anonymously with no credentials is still a result
+	audit, err = AuditMQTT(locked, MQTTAuditOptions{Wait: time.Millisecond})
+	if err != nil || audit.Anonymous || len(audit.Subscriptions) != 0 {
+		t.Fatalf("refused audit: %+v, %v", audit, err)
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -75,6 +75,10 @@ type ScanResult struct {
 	State   string // open, closed, filtered
 	Service string
 	Banner  string
+	Product string
+	Version string
+	Info    string
+	CPE     string
 }
 
 // NetworkInfo represents network scan results
@@ -304,44 +308,21 @@ func (n *NetworkModule) CloseSocket(socketID string) error {
 	return err
 }
 
-// PortScan performs a comprehensive port scan
-func (n *NetworkModule) PortScan(host string, startPort, endPort int, scanType string) []ScanResult {
-	results := []ScanResult{}
-	
-	for port := startPort; port <= endPort; port++ {
-		result := ScanResult{
-			Host:  host,
-			Port:  port,
-			State: "closed",
-		}
-
-		switch strings.ToUpper(scanType) {
-		case "TCP", "CONNECT":
-			result = n.tcpScan(host, port)
-		case "SYN":
-			result = n.synScan(host, port)
-		case "UDP":
-			result = n.udpScan(host, port)
-		default:
-			result = n.tcpScan(host, port)
-		}
-
-		results = append(results, result)
-	}
-
-	return results
-}
-
-// tcpScan performs a TCP connect scan
-func (n *NetworkModule) tcpScan(host string, port int) ScanResult {
+// tcpScan performs a TCP connect scan, retrying connections that time
+// out, and fingerprints the service of an open port with fp, or guesses it
+// from the banner if fp is nil
+func (n *NetworkModule) tcpScan(host string, port int, timing ScanTiming, fp *FingerprintOptions) ScanResult {
 	result := ScanResult{
 		Host:  host,
 		Port:  port,
 		State: "closed",
 	}
 
-	address := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.DialTimeout("tcp", address, 1*time.Second)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, timing.Timeout)
+	for retry := 0; retry < timing.Retries && isTimeout(err); retry++ {
+		conn, err = net.DialTimeout("tcp", address, timing.Timeout)
+	}
 	
 	if err != nil {
 		if strings.Contains(err.Error(), "refused") {
@@ -351,9 +332,24 @@ func (n *NetworkModule) tcpScan(host string, port int) ScanResult {
 		}
 		return result
 	}
-	defer conn.Close()
 
 	result.State = "open"
+
+	if fp != nil {
+		conn.Close()
+		if fingerprint, err := FingerprintService(host, port, *fp); err == nil {
+			result.Service = fingerprint.Service
+			result.Banner = fingerprint.Banner
+			result.Product = fingerprint.Product
+			result.Version = fingerprint.Version
+			result.Info = fingerprint.Info
+			result.CPE = fingerprint.CPE
+			return result
+		}
+		result.Service = n.identifyService(port, "")
+		return result
+	}
+	defer conn.Close()
 	
 	// Try to grab banner
 	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
@@ -369,46 +365,10 @@ func (n *NetworkModule) tcpScan(host string, port int) ScanResult {
 }
 
 // synScan performs a SYN scan (requires raw socket privileges)
-func (n *NetworkModule) synScan(host string, port int) ScanResult {
+func (n *NetworkModule) synScan(host string, port int, timing ScanTiming, fp *FingerprintOptions) ScanResult {
 	// SYN scan implementation would require raw sockets
 	// For now, fall back to TCP scan
-	return n.tcpScan(host, port)
-}
-
-// udpScan performs a UDP scan
-func (n *NetworkModule) udpScan(host string, port int) ScanResult {
-	result := ScanResult{
-		Host:  host,
-		Port:  port,
-		State: "open|filtered", // UDP default state
-	}
-
-	address := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.Dial("udp", address)
-	if err != nil {
-		result.State = "closed"
-		return result
-	}
-	defer conn.Close()
-
-	// Send probe packet
-	conn.Write([]byte("probe"))
-	
-	// Wait for response
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-	buffer := make([]byte, 1024)
-	_, err = conn.Read(buffer)
-	
-	if err != nil {
-		if strings.Contains(err.Error(), "refused") {
-			result.State = "closed"
-		}
-	} else {
-		result.State = "open"
-	}
-
-	result.Service = n.identifyService(port, "")
-	return result
+	return n.tcpScan(host, port, timing, fp)
 }
 
 // NetworkScan performs network discovery
@@ -464,7 +424,7 @@ func (n *NetworkModule) scanHost(ip string) *NetworkInfo {
 	// Scan common ports
 	commonPorts := []int{21, 22, 23, 25, 80, 110, 443, 445, 3306, 3389, 5432, 8080, 8443}
 	for _, port := range commonPorts {
-		result := n.tcpScan(ip, port)
+		result := n.tcpScan(ip, port, ScanTiming{Parallelism: 1, Timeout: time.Second}, &FingerprintOptions{})
 		if result.State == "open" {
 			info.Ports = append(info.Ports, port)
 			info.Services[port] = result.Service
@@ -523,35 +483,34 @@ func (n *NetworkModule) getMACAddress(ip string) string {
 	return ""
 }
 
-// PacketCapture simulates packet capture (full implementation would require pcap)
+// PacketCapture captures count packets matching a filter on an interface
 func (n *NetworkModule) PacketCapture(iface string, filter string, count int) ([]PacketInfo, error) {
-	// Simplified packet capture simulation
-	// Real implementation would require gopacket/pcap libraries
 	packets := []PacketInfo{}
-	
-	// For demo purposes, capture some TCP connections
-	for i := 0; i < count && i < 10; i++ {
-		packet := PacketInfo{
-			Timestamp: time.Now(),
-			Protocol:  "TCP",
-			SrcIP:     fmt.Sprintf("192.168.1.%d", 100+i),
-			DstIP:     fmt.Sprintf("10.0.0.%d", i+1),
-			SrcPort:   rand.Intn(65535-1024) + 1024,
-			DstPort:   []int{80, 443, 22, 3389}[i%4],
-			Length:    rand.Intn(1500) + 60,
-			Flags:     "SYN,ACK",
-		}
-		packets = append(packets, packet)
-		
-		n.mu.Lock()
-		n.PacketBuffer = append(n.PacketBuffer, packet)
-		n.mu.Unlock()
+	err := Capture(iface, CaptureOptions{Filter: filter, Count: count}, func(packet *Packet) bool {
+		packets = append(packets, PacketInfo{
+			Timestamp: packet.Timestamp,
+			Protocol:  packet.Protocol,
+			SrcIP:     packet.SrcIP,
+			DstIP:     packet.DstIP,
+			SrcPort:   packet.SrcPort,
+			DstPort:   packet.DstPort,
+			Length:    packet.Length,
+			Payload:   packet.Payload,
+			Flags:     packet.TCPFlags,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-	
+
+	n.mu.Lock()
+	n.PacketBuffer = append(n.PacketBuffer, packets...)
+	n.mu.Unlock()
+
 	return packets, nil
 }
 
-
 // SendRawPacket simulates sending a raw packet
 func (n *NetworkModule) SendRawPacket(dstIP string, dstPort int, payload []byte) error {
 	// Simplified implementation - would require raw sockets
@@ -623,96 +582,6 @@ func incrementIP(ip net.IP) {
 	}
 }
 
-// DNSLookup performs DNS resolution
-func (n *NetworkModule) DNSLookup(hostname string, recordType string) ([]string, error) {
-	results := []string{}
-
-	switch strings.ToUpper(recordType) {
-	case "A":
-		ips, err := net.LookupIP(hostname)
-		if err != nil {
-			return nil, err
-		}
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				results = append(results, ip.String())
-			}
-		}
-	case "AAAA":
-		ips, err := net.LookupIP(hostname)
-		if err != nil {
-			return nil, err
-		}
-		for _, ip := range ips {
-			if ip.To4() == nil {
-				results = append(results, ip.String())
-			}
-		}
-	case "MX":
-		mxRecords, err := net.LookupMX(hostname)
-		if err != nil {
-			return nil, err
-		}
-		for _, mx := range mxRecords {
-			results = append(results, fmt.Sprintf("%s:%d", mx.Host, mx.Pref))
-		}
-	case "TXT":
-		txtRecords, err := net.LookupTXT(hostname)
-		if err != nil {
-			return nil, err
-		}
-		results = txtRecords
-	case "NS":
-		nsRecords, err := net.LookupNS(hostname)
-		if err != nil {
-			return nil, err
-		}
-		for _, ns := range nsRecords {
-			results = append(results, ns.Host)
-		}
-	case "CNAME":
-		cname, err := net.LookupCNAME(hostname)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, cname)
-	default:
-		return nil, fmt.Errorf("unsupported record type: %s", recordType)
-	}
-
-	return results, nil
-}
-
-// Traceroute performs a traceroute to destination
-func (n *NetworkModule) Traceroute(dest string, maxHops int) ([]string, error) {
-	hops := []string{}
-	
-	// Resolve destination
-	destIP := net.ParseIP(dest)
-	if destIP == nil {
-		ips, err := net.LookupIP(dest)
-		if err != nil || len(ips) == 0 {
-			return nil, fmt.Errorf("cannot resolve %s", dest)
-		}
-		destIP = ips[0]
-	}
-
-	// Perform traceroute (simplified version)
-	for ttl := 1; ttl <= maxHops; ttl++ {
-		// This would require raw sockets for proper implementation
-		// Simulating with regular connection attempts
-		conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:80", destIP), 1*time.Second)
-		if err == nil {
-			conn.Close()
-			hops = append(hops, fmt.Sprintf("%d: %s (reached)", ttl, destIP))
-			break
-		}
-		hops = append(hops, fmt.Sprintf("%d: * * *", ttl))
-	}
-
-	return hops, nil
-}
-
 // GetNetworkInterfaces returns all network interfaces
 func (n *NetworkModule) GetNetworkInterfaces() ([]map[string]interface{}, error) {
 	interfaces := []map[string]interface{}{}
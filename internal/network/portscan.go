@@ -0,0 +1,245 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ScanTiming controls how fast a port scan goes: how many ports are
+// probed at once, how long a probe waits for an answer, how often a probe
+// without one is repeated and the delay between probes
+type ScanTiming struct {
+	Parallelism int
+	Timeout     time.Duration
+	Retries     int
+	Delay       time.Duration
+}
+
+// ScanTimings are the timing templates T0 to T5, from the slow and quiet
+// to the fast and noisy, after those of nmap
+var ScanTimings = []ScanTiming{
+	{Parallelism: 1, Timeout: 5 * time.Second, Retries: 2, Delay: 5 * time.Minute},        // T0 paranoid
+	{Parallelism: 1, Timeout: 5 * time.Second, Retries: 2, Delay: 15 * time.Second},       // T1 sneaky
+	{Parallelism: 1, Timeout: 2 * time.Second, Retries: 2, Delay: 400 * time.Millisecond}, // T2 polite
+	{Parallelism: 32, Timeout: time.Second, Retries: 1},                                   // T3 normal
+	{Parallelism: 128, Timeout: 750 * time.Millisecond, Retries: 1},                       // T4 aggressive
+	{Parallelism: 512, Timeout: 300 * time.Millisecond},                                   // T5 insane
+}
+
+var timingNames = []string{"paranoid", "sneaky", "polite", "normal", "aggressive", "insane"}
+
+// ScanTimingTemplate returns a timing template by its name, such as
+// "polite", or its number, as in "T2" or "2"
+func ScanTimingTemplate(name string) (ScanTiming, error) {
+	key := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "t")
+	for i, timingName := range timingNames {
+		if key == strconv.Itoa(i) || strings.ToLower(name) == timingName {
+			return ScanTimings[i], nil
+		}
+	}
+	return ScanTiming{}, fmt.Errorf("unknown timing template %q, want T0 to T5 or one of %s", name, strings.Join(timingNames, ", "))
+}
+
+// PortScanOptions configures PortScanWith. A nil Timing scans with the
+// normal template and a nil Fingerprint only grabs banners. Progress is
+// called on the scanning goroutine as ports finish, about every percent
+// of the range and at the end, and stops the scan by returning false.
+type PortScanOptions struct {
+	Type        string
+	Timing      *ScanTiming
+	Fingerprint *FingerprintOptions
+	Progress    func(done, total, open int) bool
+}
+
+// PortScan performs a comprehensive port scan, fingerprinting the
+// services on open ports
+func (n *NetworkModule) PortScan(host string, startPort, endPort int, scanType string) []ScanResult {
+	return n.PortScanWith(host, startPort, endPort, PortScanOptions{Type: scanType, Fingerprint: &FingerprintOptions{}})
+}
+
+// PortScanWith scans a port range with tcp, syn or udp probes, in
+// parallel as the timing allows, and returns the results in port order
+func (n *NetworkModule) PortScanWith(host string, startPort, endPort int, opts PortScanOptions) []ScanResult {
+	timing := ScanTimings[3]
+	if opts.Timing != nil {
+		timing = *opts.Timing
+	}
+	if timing.Parallelism < 1 {
+		timing.Parallelism = 1
+	}
+	if timing.Timeout <= 0 {
+		timing.Timeout = time.Second
+	}
+	fp := opts.Fingerprint
+	if fp != nil && fp.Timeout <= 0 {
+		withTimeout := *fp
+		withTimeout.Timeout = max(timing.Timeout, time.Second)
+		fp = &withTimeout
+	}
+
+	scan := func(port int) ScanResult {
+		switch strings.ToUpper(opts.Type) {
+		case "SYN":
+			return n.synScan(host, port, timing, fp)
+		case "UDP":
+			return n.udpScan(host, port, timing)
+		default:
+			return n.tcpScan(host, port, timing, fp)
+		}
+	}
+
+	total := endPort - startPort + 1
+	if total < 1 {
+		return []ScanResult{}
+	}
+	ports := make(chan int)
+	found := make(chan ScanResult)
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	for i := 0; i < min(timing.Parallelism, total); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for port := range ports {
+				found <- scan(port)
+			}
+		}()
+	}
+	go func() {
+		defer close(ports)
+		for port := startPort; port <= endPort; port++ {
+			if port > startPort && timing.Delay > 0 {
+				select {
+				case <-time.After(timing.Delay):
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case ports <- port:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(found)
+	}()
+
+	results := []ScanResult{}
+	step := max(1, total/100)
+	open := 0
+	stopped := false
+	for result := range found {
+		results = append(results, result)
+		if result.State == "open" {
+			open++
+		}
+		if opts.Progress != nil && !stopped && (len(results)%step == 0 || len(results) == total) {
+			if !opts.Progress(len(results), total, open) {
+				stopped = true
+				close(stop)
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Port < results[j].Port })
+	return results
+}
+
+// udpPayloads are datagrams that services answer, for the ports where an
+// empty datagram goes unanswered
+var udpPayloads = map[int][]byte{
+	// DNS query for the root nameservers
+	53:   []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x01"),
+	5353: []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x01"),
+	// TFTP read request
+	69: []byte("\x00\x01sentra\x00octet\x00"),
+	// NTP version 3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// NetBIOS name service node status request
+	137: []byte("\x80\xf0\x00\x10\x00\x01\x00\x00\x00\x00\x00\x00\x20CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\x00\x00\x21\x00\x01"),
+	// SNMPv1 get of sysDescr with the public community
+	161: []byte("\x30\x29\x02\x01\x00\x04\x06public\xa0\x1c\x02\x04\x00\x00\x00\x01\x02\x01\x00\x02\x01\x00\x30\x0e\x30\x0c\x06\x08\x2b\x06\x01\x02\x01\x01\x01\x00\x05\x00"),
+	// SSDP discovery
+	1900: []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"),
+}
+
+// udpServices names the common UDP services
+var udpServices = map[int]string{
+	53:   "DNS",
+	67:   "DHCP",
+	69:   "TFTP",
+	123:  "NTP",
+	137:  "NetBIOS-NS",
+	161:  "SNMP",
+	162:  "SNMP-Trap",
+	500:  "IKE",
+	514:  "Syslog",
+	1900: "SSDP",
+	4500: "IPsec-NAT-T",
+	5353: "mDNS",
+}
+
+// udpScan performs a UDP scan. A port that answers is open, one that
+// sends back an ICMP port unreachable is closed, and one that stays
+// silent through the retries is open|filtered.
+func (n *NetworkModule) udpScan(host string, port int, timing ScanTiming) ScanResult {
+	result := ScanResult{
+		Host:  host,
+		Port:  port,
+		State: "open|filtered", // UDP default state
+	}
+	if service, ok := udpServices[port]; ok {
+		result.Service = service
+	} else {
+		result.Service = n.identifyService(port, "")
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		result.State = "closed"
+		return result
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, 2048)
+	for attempt := 0; attempt <= timing.Retries; attempt++ {
+		if _, err := conn.Write(udpPayloads[port]); err != nil {
+			if isRefused(err) {
+				result.State = "closed"
+			}
+			return result
+		}
+		conn.SetReadDeadline(time.Now().Add(timing.Timeout))
+		count, err := conn.Read(buffer)
+		if err == nil {
+			result.State = "open"
+			result.Banner = bannerText(buffer[:count])
+			return result
+		}
+		if isRefused(err) {
+			result.State = "closed"
+			return result
+		}
+	}
+	return result
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || (err != nil && strings.Contains(err.Error(), "refused"))
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScanTimingTemplate(t *testing.T) {
+	for name, want := range map[string]int{"T0": 0, "t4": 4, "2": 2, "Normal": 3, "insane": 5} {
+		timing, err := ScanTimingTemplate(name)
+		if err != nil || timing != ScanTimings[want] {
+			t.Errorf("%q: got %+v, %v", name, timing, err)
+		}
+	}
+	for _, bad := range []string{"T6", "fast", ""} {
+		if _, err := ScanTimingTemplate(bad); err == nil {
+			t.Errorf("%q: found a template", bad)
+		}
+	}
+}
+
+func TestPortScanProgress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	type status struct{ done, total, open int }
+	var calls []status
+	timing := ScanTiming{Parallelism: 16, Timeout: 500 * time.Millisecond}
+	results := NewNetworkModule().PortScanWith("127.0.0.1", port-99, port, PortScanOptions{
+		Timing: &timing,
+		Progress: func(done, total, open int) bool {
+			calls = append(calls, status{done, total, open})
+			return true
+		},
+	})
+
+	if len(results) != 100 || results[0].Port != port-99 || results[99].Port != port || results[99].State != "open" {
+		t.Fatalf("got %d results, last %+v", len(results), results[len(results)-1])
+	}
+	if len(calls) != 100 || calls[99] != (status{100, 100, 1}) {
+		t.Errorf("progress calls %v", calls)
+	}
+
+	stopped := NewNetworkModule().PortScanWith("127.0.0.1", port-99, port, PortScanOptions{
+		Timing:   &ScanTiming{Parallelism: 1, Timeout: 500 * time.Millisecond},
+		Progress: func(done, total, open int) bool { return done < 10 },
+	})
+	if len(stopped) >= 100 || len(stopped) < 10 {
+		t.Errorf("stopped scan returned %d results", len(stopped))
+	}
+}
+
+func TestUDPScan(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+		}
+	}()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	// A port nobody listens on answers with ICMP port unreachable
+	closed, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.LocalAddr().(*net.UDPAddr).Port
+	closed.Close()
+
+	timing := ScanTiming{Parallelism: 1, Timeout: 300 * time.Millisecond, Retries: 1}
+	scan := func(port int) ScanResult {
+		return NewNetworkModule().PortScanWith("127.0.0.1", port, port, PortScanOptions{Type: "udp", Timing: &timing})[0]
+	}
+	if open := scan(port); open.State != "open" || open.Banner != "echo " {
+		t.Errorf("listening port: %+v", open)
+	}
+	if result := scan(closedPort); result.State != "closed" {
+		t.Errorf("closed port: %+v", result)
+	}
+}
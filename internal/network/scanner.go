@@ -70,18 +70,20 @@ func ScanNetwork(networkCIDR string) ([]*HostInfo, error) {
 	return hosts, nil
 }
 
-// ScanServiceVersion attempts to identify service version on a port
+// ScanServiceVersion identifies the service and version on a port, such
+// as "SSH (OpenSSH 8.9p1)"
 func ScanServiceVersion(target string, port int) (string, error) {
-	if !isPortOpen(target, port) {
+	fp, err := FingerprintService(target, port, FingerprintOptions{})
+	if err != nil {
 		return "", fmt.Errorf("port %d is closed", port)
 	}
 
-	// In a real implementation, would send service-specific probes
-	// and parse banners to identify version
-	service := identifyService(port)
-	version := "unknown"
+	version := strings.TrimSpace(fp.Product + " " + fp.Version)
+	if version == "" {
+		version = "unknown"
+	}
 
-	return fmt.Sprintf("%s (%s)", service, version), nil
+	return fmt.Sprintf("%s (%s)", fp.Service, version), nil
 }
 
 // ScanOSFingerprint attempts to identify the operating system
@@ -0,0 +1,346 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// SNMP queries for auditing switches, routers and printers
+
+// SNMPOptions configures an SNMP query. Version is "1", "2c" or "3". Version
+// 3 authenticates as User when AuthPassword is set, and encrypts when
+// PrivPassword is set too; the protocols default to SHA and AES.
+type SNMPOptions struct {
+	Version      string
+	Community    string
+	Port         int
+	Timeout      time.Duration
+	Retries      int
+	User         string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+	Context      string
+}
+
+// SNMPVariable is a variable returned by an agent. Name is the OID with a
+// known prefix replaced by its name, as in "ifDescr.2", and Value is an
+// int64, float64, string, []byte or nil.
+type SNMPVariable struct {
+	OID   string
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+// snmpNames are the names of common OIDs, accepted in queries in place of
+// their numbers and used to name the variables returned
+var snmpNames = map[string]string{
+	"mib-2":                  "1.3.6.1.2.1",
+	"enterprises":            "1.3.6.1.4.1",
+	"system":                 "1.3.6.1.2.1.1",
+	"sysDescr":               "1.3.6.1.2.1.1.1",
+	"sysObjectID":            "1.3.6.1.2.1.1.2",
+	"sysUpTime":              "1.3.6.1.2.1.1.3",
+	"sysContact":             "1.3.6.1.2.1.1.4",
+	"sysName":                "1.3.6.1.2.1.1.5",
+	"sysLocation":            "1.3.6.1.2.1.1.6",
+	"sysServices":            "1.3.6.1.2.1.1.7",
+	"interfaces":             "1.3.6.1.2.1.2",
+	"ifNumber":               "1.3.6.1.2.1.2.1",
+	"ifTable":                "1.3.6.1.2.1.2.2",
+	"ifIndex":                "1.3.6.1.2.1.2.2.1.1",
+	"ifDescr":                "1.3.6.1.2.1.2.2.1.2",
+	"ifType":                 "1.3.6.1.2.1.2.2.1.3",
+	"ifMtu":                  "1.3.6.1.2.1.2.2.1.4",
+	"ifSpeed":                "1.3.6.1.2.1.2.2.1.5",
+	"ifPhysAddress":          "1.3.6.1.2.1.2.2.1.6",
+	"ifAdminStatus":          "1.3.6.1.2.1.2.2.1.7",
+	"ifOperStatus":           "1.3.6.1.2.1.2.2.1.8",
+	"ifInOctets":             "1.3.6.1.2.1.2.2.1.10",
+	"ifOutOctets":            "1.3.6.1.2.1.2.2.1.16",
+	"ipAddrTable":            "1.3.6.1.2.1.4.20",
+	"ipAdEntAddr":            "1.3.6.1.2.1.4.20.1.1",
+	"ipAdEntIfIndex":         "1.3.6.1.2.1.4.20.1.2",
+	"ipAdEntNetMask":         "1.3.6.1.2.1.4.20.1.3",
+	"hrSystem":               "1.3.6.1.2.1.25.1",
+	"hrDeviceDescr":          "1.3.6.1.2.1.25.3.2.1.3",
+	"hrSWRunName":            "1.3.6.1.2.1.25.4.2.1.2",
+	"hrSWInstalledName":      "1.3.6.1.2.1.25.6.3.1.2",
+	"ifXTable":               "1.3.6.1.2.1.31.1.1",
+	"ifName":                 "1.3.6.1.2.1.31.1.1.1.1",
+	"ifHCInOctets":           "1.3.6.1.2.1.31.1.1.1.6",
+	"ifHCOutOctets":          "1.3.6.1.2.1.31.1.1.1.10",
+	"ifAlias":                "1.3.6.1.2.1.31.1.1.1.18",
+	"prtGeneralSerialNumber": "1.3.6.1.2.1.43.5.1.1.17",
+	"entPhysicalDescr":       "1.3.6.1.2.1.47.1.1.1.1.2",
+	"entPhysicalSerialNum":   "1.3.6.1.2.1.47.1.1.1.1.11",
+	"entPhysicalModelName":   "1.3.6.1.2.1.47.1.1.1.1.13",
+}
+
+// snmpOIDNames maps the OIDs of snmpNames back to their names
+var snmpOIDNames = func() map[string]string {
+	names := make(map[string]string, len(snmpNames))
+	for name, oid := range snmpNames {
+		names[oid] = name
+	}
+	return names
+}()
+
+// ResolveOID turns a name such as "sysName.0" into its numeric OID with a
+// leading dot, and checks a numeric OID
+func ResolveOID(oid string) (string, error) {
+	oid = strings.TrimPrefix(strings.TrimSpace(oid), ".")
+	head, rest, _ := strings.Cut(oid, ".")
+	if number, ok := snmpNames[head]; ok {
+		oid = number
+		if rest != "" {
+			oid += "." + rest
+		}
+	}
+	if oid == "" {
+		return "", fmt.Errorf("empty OID")
+	}
+	for _, arc := range strings.Split(oid, ".") {
+		if arc == "" || strings.Trim(arc, "0123456789") != "" {
+			return "", fmt.Errorf("invalid OID %q", oid)
+		}
+	}
+	return "." + oid, nil
+}
+
+// oidName names an OID after the longest known prefix, or returns it
+// unchanged
+func oidName(oid string) string {
+	numeric := strings.TrimPrefix(oid, ".")
+	for prefix := numeric; prefix != ""; {
+		if name, ok := snmpOIDNames[prefix]; ok {
+			return name + strings.TrimPrefix(numeric, prefix)
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return numeric
+}
+
+// snmpClient connects to an agent with the version and credentials of opts
+func snmpClient(host string, opts SNMPOptions) (*gosnmp.GoSNMP, error) {
+	client := &gosnmp.GoSNMP{
+		Target:         host,
+		Port:           161,
+		Community:      opts.Community,
+		Timeout:        opts.Timeout,
+		Retries:        opts.Retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: 25,
+		ContextName:    opts.Context,
+	}
+	if opts.Port > 0 {
+		if opts.Port > 65535 {
+			return nil, fmt.Errorf("port %d out of range", opts.Port)
+		}
+		client.Port = uint16(opts.Port)
+	}
+	if client.Community == "" {
+		client.Community = "public"
+	}
+	if client.Timeout <= 0 {
+		client.Timeout = 2 * time.Second
+	}
+
+	switch strings.ToLower(opts.Version) {
+	case "1", "v1":
+		client.Version = gosnmp.Version1
+	case "", "2", "2c", "v2c":
+		client.Version = gosnmp.Version2c
+	case "3", "v3":
+		usm, flags, err := snmpUSM(opts)
+		if err != nil {
+			return nil, err
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = flags
+		client.SecurityParameters = usm
+	default:
+		return nil, fmt.Errorf("unknown SNMP version %q, want 1, 2c or 3", opts.Version)
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// snmpUSM builds the user-based security parameters of a version 3 query
+func snmpUSM(opts SNMPOptions) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags, error) {
+	if opts.User == "" {
+		return nil, 0, fmt.Errorf("SNMPv3 needs a user")
+	}
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:               opts.User,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	if opts.AuthPassword == "" {
+		if opts.PrivPassword != "" {
+			return nil, 0, fmt.Errorf("SNMPv3 privacy needs authentication too")
+		}
+		return usm, gosnmp.NoAuthNoPriv, nil
+	}
+
+	authProtocols := map[string]gosnmp.SnmpV3AuthProtocol{
+		"": gosnmp.SHA, "md5": gosnmp.MD5, "sha": gosnmp.SHA, "sha1": gosnmp.SHA,
+		"sha224": gosnmp.SHA224, "sha256": gosnmp.SHA256, "sha384": gosnmp.SHA384, "sha512": gosnmp.SHA512,
+	}
+	auth, ok := authProtocols[strings.ToLower(opts.AuthProtocol)]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown SNMPv3 auth protocol %q, want md5, sha, sha224, sha256, sha384 or sha512", opts.AuthProtocol)
+	}
+	usm.AuthenticationProtocol = auth
+	usm.AuthenticationPassphrase = opts.AuthPassword
+	if opts.PrivPassword == "" {
+		return usm, gosnmp.AuthNoPriv, nil
+	}
+
+	privProtocols := map[string]gosnmp.SnmpV3PrivProtocol{
+		"": gosnmp.AES, "des": gosnmp.DES, "aes": gosnmp.AES, "aes128": gosnmp.AES,
+		"aes192": gosnmp.AES192, "aes256": gosnmp.AES256, "aes192c": gosnmp.AES192C, "aes256c": gosnmp.AES256C,
+	}
+	priv, ok := privProtocols[strings.ToLower(opts.PrivProtocol)]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown SNMPv3 privacy protocol %q, want des, aes, aes192, aes256, aes192c or aes256c", opts.PrivProtocol)
+	}
+	usm.PrivacyProtocol = priv
+	usm.PrivacyPassphrase = opts.PrivPassword
+	return usm, gosnmp.AuthPriv, nil
+}
+
+// snmpVariable converts a variable of gosnmp
+func snmpVariable(pdu gosnmp.SnmpPDU) SNMPVariable {
+	v := SNMPVariable{OID: strings.TrimPrefix(pdu.Name, "."), Name: oidName(pdu.Name), Type: pdu.Type.String()}
+	switch value := pdu.Value.(type) {
+	case []byte:
+		if utf8.Valid(value) && isPrintable(strings.TrimRight(string(value), "\r\n\x00")) {
+			v.Value = strings.TrimRight(string(value), "\r\n\x00")
+		} else {
+			v.Value = value
+		}
+	case string:
+		v.Value = strings.TrimPrefix(value, ".")
+	case int:
+		v.Value = int64(value)
+	case uint:
+		v.Value = int64(value)
+	case uint32:
+		v.Value = int64(value)
+	case uint64:
+		if value > math.MaxInt64 {
+			v.Value = float64(value)
+		} else {
+			v.Value = int64(value)
+		}
+	case float32:
+		v.Value = float64(value)
+	case float64:
+		v.Value = value
+	}
+	return v
+}
+
+// SNMPGet reads variables from an agent
+func SNMPGet(host string, oids []string, opts SNMPOptions) ([]SNMPVariable, error) {
+	if len(oids) == 0 {
+		return nil, fmt.Errorf("no OIDs to get")
+	}
+	resolved := make([]string, len(oids))
+	for i, oid := range oids {
+		var err error
+		if resolved[i], err = ResolveOID(oid); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := snmpClient(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	packet, err := client.Get(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("agent answered %s for %s", packet.Error, oids[max(int(packet.ErrorIndex)-1, 0)])
+	}
+	variables := make([]SNMPVariable, len(packet.Variables))
+	for i, pdu := range packet.Variables {
+		variables[i] = snmpVariable(pdu)
+	}
+	return variables, nil
+}
+
+// SNMPWalk reads the subtree of an OID from an agent, with GETBULK
+// requests except in version 1
+func SNMPWalk(host, root string, opts SNMPOptions) ([]SNMPVariable, error) {
+	resolved, err := ResolveOID(root)
+	if err != nil {
+		return nil, err
+	}
+	client, err := snmpClient(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	var pdus []gosnmp.SnmpPDU
+	if client.Version == gosnmp.Version1 {
+		pdus, err = client.WalkAll(resolved)
+	} else {
+		pdus, err = client.BulkWalkAll(resolved)
+	}
+	if err != nil {
+		return nil, err
+	}
+	variables := make([]SNMPVariable, len(pdus))
+	for i, pdu := range pdus {
+		variables[i] = snmpVariable(pdu)
+	}
+	sort.SliceStable(variables, func(i, j int) bool { return compareOIDs(variables[i].OID, variables[j].OID) < 0 })
+	return variables, nil
+}
+
+// compareOIDs orders numeric OIDs arc by arc
+func compareOIDs(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if len(as[i]) != len(bs[i]) {
+			return len(as[i]) - len(bs[i])
+		}
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// SNMPVariableToMap converts an SNMPVariable to a map for VM
+func SNMPVariableToMap(v SNMPVariable) map[string]interface{} {
+	return map[string]interface{}{
+		"oid":   v.OID,
+		"name":  v.Name,
+		"type":  v.Type,
+		"value": v.Value,
+	}
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// fakeAgent answers GET, GETNEXT and GETBULK requests of one community
+// from a table of variables in OID order
+func fakeAgent(t *testing.T, table []gosnmp.SnmpPDU) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	next := func(oid string) (gosnmp.SnmpPDU, bool) {
+		for _, pdu := range table {
+			if compareOIDs(pdu.Name[1:], oid[1:]) > 0 {
+				return pdu, true
+			}
+		}
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}, false
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		decoder := &gosnmp.GoSNMP{}
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, err := decoder.SnmpDecodePacket(buf[:n])
+			if err != nil || request.Community != "secret" {
+				continue
+			}
+			var vars []gosnmp.SnmpPDU
+			for _, v := range request.Variables {
+				switch request.PDUType {
+				case gosnmp.GetRequest:
+					found := gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+					for _, pdu := range table {
+						if pdu.Name == v.Name {
+							found = pdu
+						}
+					}
+					vars = append(vars, found)
+				case gosnmp.GetNextRequest:
+					pdu, _ := next(v.Name)
+					vars = append(vars, pdu)
+				case gosnmp.GetBulkRequest:
+					oid := v.Name
+					for i := 0; i < int(request.MaxRepetitions); i++ {
+						pdu, ok := next(oid)
+						vars = append(vars, pdu)
+						if !ok {
+							break
+						}
+						oid = pdu.Name
+					}
+				}
+			}
+			response := &gosnmp.SnmpPacket{
+				Version:   request.Version,
+				Community: request.Community,
+				PDUType:   gosnmp.GetResponse,
+				RequestID: request.RequestID,
+				Variables: vars,
+			}
+			if request.Version == gosnmp.Version1 && len(vars) > 0 && vars[0].Type == gosnmp.EndOfMibView {
+				response.Error, response.ErrorIndex = gosnmp.NoSuchName, 1
+				response.Variables = request.Variables
+			}
+			out, err := response.MarshalMsg()
+			if err == nil {
+				conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+var agentTable = []gosnmp.SnmpPDU{
+	{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Cisco IOS Software, C2960")},
+	{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(123456)},
+	{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("core-sw1")},
+	{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("Gi0/1")},
+	{Name: ".1.3.6.1.2.1.2.2.1.2.10", Type: gosnmp.OctetString, Value: []byte("Gi0/10")},
+	{Name: ".1.3.6.1.2.1.2.2.1.6.1", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1b, 0x54, 0xaa, 0x01, 0x02}},
+	{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(4242)},
+}
+
+func TestSNMPGet(t *testing.T) {
+	port := fakeAgent(t, agentTable)
+	opts := SNMPOptions{Community: "secret", Port: port, Timeout: time.Second}
+
+	vars, err := SNMPGet("127.0.0.1", []string{"sysDescr.0", ".1.3.6.1.2.1.1.3.0", "sysLocation.0"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 3 || vars[0].Value != "Cisco IOS Software, C2960" || vars[0].Name != "sysDescr.0" || vars[0].OID != "1.3.6.1.2.1.1.1.0" {
+		t.Fatalf("got %+v", vars)
+	}
+	if vars[1].Value != int64(123456) || vars[1].Type != "TimeTicks" || vars[2].Value != nil || vars[2].Type != "NoSuchObject" {
+		t.Errorf("got %+v", vars[1:])
+	}
+
+	for _, oid := range []string{"", "1.3.x", "sysDescr..0"} {
+		if _, err := SNMPGet("127.0.0.1", []string{oid}, opts); err == nil {
+			t.Errorf("%q: got no error", oid)
+		}
+	}
+	opts.Community = "wrong"
+	opts.Timeout = 200 * time.Millisecond
+	if _, err := SNMPGet("127.0.0.1", []string{"sysName.0"}, opts); err == nil {
+		t.Error("got an answer with the wrong community")
+	}
+}
+
+func TestSNMPWalk(t *testing.T) {
+	port := fakeAgent(t, agentTable)
+	for _, version := range []string{"2c", "1"} {
+		vars, err := SNMPWalk("127.0.0.1", "interfaces", SNMPOptions{Version: version, Community: "secret", Port: port, Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("v%s: %v", version, err)
+		}
+		if len(vars) != 4 || vars[0].Name != "ifDescr.1" || vars[1].Name != "ifDescr.10" || vars[3].Value != int64(4242) {
+			t.Fatalf("v%s: got %+v", version, vars)
+		}
+		if mac, ok := vars[2].Value.([]byte); !ok || len(mac) != 6 {
+			t.Errorf("v%s: ifPhysAddress %#v", version, vars[2].Value)
+		}
+	}
+}
+
+func TestSNMPv3Options(t *testing.T) {
+	tests := []struct {
+		opts  SNMPOptions
+		flags gosnmp.SnmpV3MsgFlags
+		ok    bool
+	}{
+		{SNMPOptions{User: "audit"}, gosnmp.NoAuthNoPriv, true},
+		{SNMPOptions{User: "audit", AuthPassword: "authpass"}, gosnmp.AuthNoPriv, true},
+		{SNMPOptions{User: "audit", AuthProtocol: "SHA256", AuthPassword: "authpass", PrivProtocol: "aes256", PrivPassword: "privpass"}, gosnmp.AuthPriv, true},
+		{SNMPOptions{User: "audit", PrivPassword: "privpass"}, 0, false},
+		{SNMPOptions{User: "audit", AuthProtocol: "crc32", AuthPassword: "authpass"}, 0, false},
+		{SNMPOptions{AuthPassword: "authpass"}, 0, false},
+	}
+	for _, tt := range tests {
+		usm, flags, err := snmpUSM(tt.opts)
+		if (err == nil) != tt.ok || (tt.ok && (flags != tt.flags || usm.UserName != "audit")) {
+			t.Errorf("%+v: flags %v, %v", tt.opts, flags, err)
+		}
+	}
+	if usm, _, _ := snmpUSM(tests[2].opts); usm.AuthenticationProtocol != gosnmp.SHA256 || usm.PrivacyProtocol != gosnmp.AES256 {
+		t.Errorf("protocols %v %v", usm.AuthenticationProtocol, usm.PrivacyProtocol)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+// internal/network/sockopt_other.go
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Setting the TTL of a socket is not supported here
+func setHopLimit(c syscall.Conn, ipv6 bool, hops int) error {
+	return errors.New("setting the TTL of a socket is not supported on this platform")
+}
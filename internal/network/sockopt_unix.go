@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+// internal/network/sockopt_unix.go
+package network
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setHopLimit sets the TTL, or the hop limit for IPv6, of the packets a
+// socket sends
+func setHopLimit(c syscall.Conn, ipv6 bool, hops int) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, hops)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, hops)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
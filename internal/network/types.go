@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/google/gopacket/layers"
 )
 
 // Common types for network operations
@@ -161,26 +163,41 @@ type Flow struct {
 	Started  time.Time
 }
 
-// PacketCapture represents a packet capture session
+// PacketCapture represents a packet capture session. It keeps the last
+// maxCapturedPackets packets.
 type PacketCapture struct {
 	ID        string
 	Interface string
 	Filter    string
+	LinkType  layers.LinkType
 	Packets   []*Packet
+	Dropped   int   // Packets let go to stay within maxCapturedPackets
 	Running   bool
+	Err       error // Why the capture stopped early, if it did
+	stop      chan struct{} // Closed by StopCapture
+	done      chan struct{} // Closed when the capture has stopped
 	mu        sync.RWMutex
 }
 
 // Packet represents a captured network packet
 type Packet struct {
 	Timestamp time.Time
-	Length    int
+	Length    int // On the wire; Data may be shorter if the snaplen cut it
+	LinkType  layers.LinkType
+	Layers    []string // Layer names, outermost first, such as Ethernet, IPv4, TCP
+	SrcMAC    string
+	DstMAC    string
 	SrcIP     string
 	DstIP     string
+	TTL       int // TTL or hop limit
 	SrcPort   int
 	DstPort   int
 	Protocol  string
-	Data      []byte
+	TCPFlags  string // Such as "SYN,ACK"
+	ICMPType  int
+	ICMPCode  int
+	Payload   []byte // Application payload
+	Data      []byte // The whole frame as captured
 }
 
 // HostInfo represents discovered host information
@@ -0,0 +1,389 @@
+// Package notify sends alerts to people: messages to Slack and Microsoft
+// Teams channels, PagerDuty events and JSON to any webhook. Text can be
+// templated with the fields of a finding or an incident.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PagerDutyURL is the endpoint of PagerDuty's Events API v2
+const PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Message is an alert for people. Severity is critical, high, medium, low
+// or info.
+type Message struct {
+	Title    string
+	Text     string
+	Severity string
+	URL      string // A link to more about it
+	Fields   map[string]interface{}
+}
+
+// Options configure how a notification is delivered
+type Options struct {
+	Method   string            // POST when empty
+	Headers  map[string]string // Added to the request
+	Secret   string            // Signs the body in X-Sentra-Signature with HMAC-SHA256
+	Retries  int               // Of a request that failed for a reason that may pass, 3 when 0
+	Backoff  time.Duration     // Before the first retry, doubled for each retry after it, 1s when 0
+	Timeout  time.Duration     // Of each request, 10s when 0
+	Insecure bool              // Skip TLS verification
+	Client   *http.Client
+}
+
+// Result is how a notification was delivered
+type Result struct {
+	Status   int
+	Attempts int
+	Body     string
+}
+
+// placeholder matches {{ name }} and {{ name.key.0 }} in templates
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.\-]+)\s*\}\}`)
+
+// Render fills the {{ name }} placeholders of a template with the values
+// of data. A dotted name reads into nested maps and arrays, so
+// {{ details.host }} is the host of a finding's details. Placeholders
+// without a value are left empty.
+func Render(template string, data map[string]interface{}) string {
+	return placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		var v interface{} = data
+		for _, key := range strings.Split(placeholder.FindStringSubmatch(match)[1], ".") {
+			switch c := v.(type) {
+			case map[string]interface{}:
+				v = c[key]
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(c) {
+					return ""
+				}
+				v = c[i]
+			default:
+				return ""
+			}
+		}
+		return text(v)
+	})
+}
+
+// RenderMessage fills the placeholders of a message's title, text,
+// severity, link and string fields
+func RenderMessage(m Message, data map[string]interface{}) Message {
+	rendered := Message{Title: Render(m.Title, data), Text: Render(m.Text, data), Severity: Render(m.Severity, data),
+		URL: Render(m.URL, data), Fields: make(map[string]interface{}, len(m.Fields))}
+	for k, v := range m.Fields {
+		if s, ok := v.(string); ok {
+			v = Render(s, data)
+		}
+		rendered.Fields[k] = v
+	}
+	return rendered
+}
+
+// text returns a value as message text
+func text(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(t)
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// fieldNames returns the names of a message's fields in order
+func (m Message) fieldNames() []string {
+	names := make([]string, 0, len(m.Fields))
+	for name := range m.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// severityColors are the colors of Slack attachments by severity
+var severityColors = map[string]string{
+	"critical": "#7b0000",
+	"high":     "#d00000",
+	"medium":   "#f2a900",
+	"low":      "#2f80ed",
+	"info":     "#9e9e9e",
+}
+
+// SlackPayload returns the body of a message to a Slack incoming webhook:
+// the title and text with the fields in an attachment colored by
+// severity. Channel, username and icon override the webhook's, where the
+// webhook allows it.
+func SlackPayload(m Message, channel, username, icon string) map[string]interface{} {
+	fallback := m.Text
+	if m.Title != "" {
+		fallback = strings.TrimSpace(m.Title + ": " + m.Text)
+	}
+	attachment := map[string]interface{}{"fallback": fallback, "title": m.Title, "text": m.Text}
+	if m.URL != "" {
+		attachment["title_link"] = m.URL
+	}
+	if color, ok := severityColors[strings.ToLower(m.Severity)]; ok {
+		attachment["color"] = color
+	}
+	var fields []interface{}
+	if m.Severity != "" {
+		fields = append(fields, map[string]interface{}{"title": "Severity", "value": m.Severity, "short": true})
+	}
+	for _, name := range m.fieldNames() {
+		value := text(m.Fields[name])
+		fields = append(fields, map[string]interface{}{"title": name, "value": value, "short": len(value) < 40})
+	}
+	if len(fields) > 0 {
+		attachment["fields"] = fields
+	}
+	payload := map[string]interface{}{"text": fallback, "attachments": []interface{}{attachment}}
+	if channel != "" {
+		payload["channel"] = channel
+	}
+	if username != "" {
+		payload["username"] = username
+	}
+	if strings.HasPrefix(icon, ":") {
+		payload["icon_emoji"] = icon
+	} else if icon != "" {
+		payload["icon_url"] = icon
+	}
+	return payload
+}
+
+// TeamsPayload returns the body of a message to a Microsoft Teams
+// webhook, an Adaptive Card with the fields as facts, which both Workflows
+// and the older connectors take
+func TeamsPayload(m Message) map[string]interface{} {
+	var body []interface{}
+	if m.Title != "" {
+		title := map[string]interface{}{"type": "TextBlock", "text": m.Title, "weight": "Bolder", "size": "Medium", "wrap": true}
+		switch strings.ToLower(m.Severity) {
+		case "critical", "high":
+			title["color"] = "Attention"
+		case "medium":
+			title["color"] = "Warning"
+		}
+		body = append(body, title)
+	}
+	if m.Text != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": m.Text, "wrap": true})
+	}
+	var facts []interface{}
+	if m.Severity != "" {
+		facts = append(facts, map[string]interface{}{"title": "Severity", "value": m.Severity})
+	}
+	for _, name := range m.fieldNames() {
+		facts = append(facts, map[string]interface{}{"title": name, "value": text(m.Fields[name])})
+	}
+	if len(facts) > 0 {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if m.URL != "" {
+		card["actions"] = []interface{}{map[string]interface{}{"type": "Action.OpenUrl", "title": "Open", "url": m.URL}}
+	}
+	return map[string]interface{}{
+		"type":        "message",
+		"attachments": []interface{}{map[string]interface{}{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}
+}
+
+// pagerDutySeverities are PagerDuty's severities for ours
+var pagerDutySeverities = map[string]string{
+	"critical": "critical",
+	"high":     "error",
+	"medium":   "warning",
+	"low":      "info",
+	"info":     "info",
+}
+
+// PagerDutyEvent returns an event of the Events API v2. Action is
+// trigger, acknowledge or resolve; events with the same dedup key are
+// one alert, so a later resolve with the key of a trigger closes it.
+func PagerDutyEvent(routingKey, action, dedupKey, source string, m Message) (map[string]interface{}, error) {
+	if routingKey == "" {
+		return nil, fmt.Errorf("pagerduty needs a routing key")
+	}
+	if action == "" {
+		action = "trigger"
+	}
+	event := map[string]interface{}{"routing_key": routingKey, "event_action": action}
+	if dedupKey != "" {
+		event["dedup_key"] = dedupKey
+	}
+	switch action {
+	case "acknowledge", "resolve":
+		if dedupKey == "" {
+			return nil, fmt.Errorf("pagerduty needs the dedup key of the alert to %s", action)
+		}
+		return event, nil
+	case "trigger":
+	default:
+		return nil, fmt.Errorf("unknown pagerduty action '%s', expected trigger, acknowledge or resolve", action)
+	}
+
+	summary := m.Title
+	if summary == "" {
+		summary = m.Text
+	}
+	if summary == "" {
+		return nil, fmt.Errorf("pagerduty needs a title or text to trigger an alert")
+	}
+	// PagerDuty cuts summaries at 1024 characters
+	if len(summary) > 1024 {
+		summary = summary[:1021] + "..."
+	}
+	severity := "error"
+	if m.Severity != "" {
+		var ok bool
+		if severity, ok = pagerDutySeverities[strings.ToLower(m.Severity)]; !ok {
+			return nil, fmt.Errorf("unknown severity '%s', expected critical, high, medium, low or info", m.Severity)
+		}
+	}
+	if source == "" {
+		source = "sentra"
+	}
+	payload := map[string]interface{}{"summary": summary, "source": source, "severity": severity}
+	details := make(map[string]interface{}, len(m.Fields)+1)
+	for k, v := range m.Fields {
+		details[k] = v
+	}
+	if m.Title != "" && m.Text != "" {
+		details["text"] = m.Text
+	}
+	if len(details) > 0 {
+		payload["custom_details"] = details
+	}
+	event["payload"] = payload
+	if m.URL != "" {
+		event["links"] = []interface{}{map[string]interface{}{"href": m.URL, "text": "Details"}}
+	}
+	return event, nil
+}
+
+// retryable reports whether a request that got a status may succeed later
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Send delivers a payload to a URL as JSON, retrying when the request
+// fails or the answer is 429 or 5xx. A Retry-After header of an answer
+// sets the wait before the next attempt.
+func Send(rawURL string, payload interface{}, opts Options) (*Result, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("needs an http or https url, got '%s'", rawURL)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Method == "" {
+		opts.Method = "POST"
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.Insecure}},
+		}
+	}
+
+	result := &Result{}
+	wait := opts.Backoff
+	for {
+		result.Attempts++
+		status, answer, retryAfter, err := send(rawURL, body, opts)
+		result.Status, result.Body = status, answer
+		if err == nil && status/100 == 2 {
+			return result, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+			if msg := strings.TrimSpace(answer); msg != "" {
+				if len(msg) > 200 {
+					msg = msg[:200] + "..."
+				}
+				err = fmt.Errorf("%d %s: %s", status, http.StatusText(status), msg)
+			}
+			if !retryable(status) {
+				return result, err
+			}
+		}
+		if result.Attempts > opts.Retries {
+			return result, fmt.Errorf("gave up after %d attempts: %v", result.Attempts, err)
+		}
+		if retryAfter > 0 {
+			time.Sleep(retryAfter)
+		} else {
+			time.Sleep(wait)
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt at a request and returns the status, body and
+// Retry-After of the answer
+func send(rawURL string, body []byte, opts Options) (int, string, time.Duration, error) {
+	req, err := http.NewRequest(opts.Method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sentra-notify")
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if opts.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(opts.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Sentra-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var retryAfter time.Duration
+	if seconds, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && seconds > 0 {
+		// A server asking for long waits is not waited on past a minute
+		retryAfter = time.Duration(min(seconds, 60)) * time.Second
+	}
+	return resp.StatusCode, string(answer), retryAfter, err
+}
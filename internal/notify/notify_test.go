@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	finding := map[string]interface{}{
+		"title":    "SSH open",
+		"severity": "high",
+		"cvss":     7.5,
+		"details":  map[string]interface{}{"host": "web1", "ports": []interface{}{22.0, 2222.0}},
+	}
+	for template, want := range map[string]string{
+		"{{title}} on {{ details.host }}":  "SSH open on web1",
+		"[{{severity}}] {{cvss}}":          "[high] 7.5",
+		"port {{details.ports.1}}":         "port 2222",
+		"{{missing}}|{{details.ports.9}}|": "||",
+		"{{details.ports}}":                "[22,2222]",
+		"{ {title} } {{title":              "{ {title} } {{title",
+	} {
+		if got := Render(template, finding); got != want {
+			t.Errorf("Render(%q) = %q, want %q", template, got, want)
+		}
+	}
+	m := RenderMessage(Message{Title: "{{title}}", Severity: "{{severity}}", Fields: map[string]interface{}{"host": "{{details.host}}", "count": 3}}, finding)
+	if m.Title != "SSH open" || m.Severity != "high" || m.Fields["host"] != "web1" || m.Fields["count"] != 3 {
+		t.Errorf("RenderMessage = %+v", m)
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	m := Message{Title: "SSH open", Text: "Port 22 answers on web1", Severity: "high", URL: "https://scans/1",
+		Fields: map[string]interface{}{"host": "web1", "port": 22}}
+
+	slack, _ := json.Marshal(SlackPayload(m, "#alerts", "", ":rotating_light:"))
+	for _, want := range []string{`"text":"SSH open: Port 22 answers on web1"`, `"color":"#d00000"`, `"channel":"#alerts"`,
+		`"icon_emoji":":rotating_light:"`, `"title_link":"https://scans/1"`, `{"short":true,"title":"host","value":"web1"}`} {
+		if !strings.Contains(string(slack), want) {
+			t.Errorf("slack payload %s lacks %s", slack, want)
+		}
+	}
+	teams, _ := json.Marshal(TeamsPayload(m))
+	for _, want := range []string{`"contentType":"application/vnd.microsoft.card.adaptive"`, `"color":"Attention"`,
+		`{"title":"port","value":"22"}`, `"url":"https://scans/1"`} {
+		if !strings.Contains(string(teams), want) {
+			t.Errorf("teams payload %s lacks %s", teams, want)
+		}
+	}
+
+	event, err := PagerDutyEvent("key", "", "scan-1", "", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := event["payload"].(map[string]interface{})
+	if event["event_action"] != "trigger" || payload["severity"] != "error" || payload["source"] != "sentra" || payload["summary"] != "SSH open" {
+		t.Errorf("pagerduty event = %v", event)
+	}
+	if event, err := PagerDutyEvent("key", "resolve", "scan-1", "", Message{}); err != nil || event["payload"] != nil {
+		t.Errorf("resolve event = %v, %v", event, err)
+	}
+	for _, c := range []struct {
+		key, action, dedup string
+		m                  Message
+		want               string
+	}{
+		{"", "", "", m, "pagerduty needs a routing key"},
+		{"key", "resolve", "", m, "pagerduty needs the dedup key of the alert to resolve"},
+		{"key", "snooze", "", m, "unknown pagerduty action 'snooze'"},
+		{"key", "", "", Message{Severity: "high"}, "pagerduty needs a title or text"},
+		{"key", "", "", Message{Title: "x", Severity: "urgent"}, "unknown severity 'urgent'"},
+	} {
+		if _, err := PagerDutyEvent(c.key, c.action, c.dedup, "", c.m); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("PagerDutyEvent(%q, %q) err = %v, want %s", c.key, c.action, err, c.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	attempts := 0
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch r.URL.Path {
+		case "/flaky":
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid_payload"))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		body, signature = string(data), r.Header.Get("X-Sentra-Signature")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	result, err := Send(server.URL+"/flaky", map[string]interface{}{"text": "hi"}, Options{Backoff: time.Millisecond, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	if result.Attempts != 3 || result.Status != 200 || result.Body != "ok" || signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("result = %+v, body %s, signature %s", result, body, signature)
+	}
+
+	// Answers that cannot change are not retried
+	attempts = 0
+	if result, err := Send(server.URL+"/bad", "x", Options{Backoff: time.Millisecond}); err == nil || err.Error() != "400 Bad Request: invalid_payload" || result.Attempts != 1 {
+		t.Errorf("bad request = %+v, %v", result, err)
+	}
+	attempts = 0
+	if _, err := Send(server.URL+"/flaky", "x", Options{Backoff: time.Millisecond, Retries: 1}); err == nil || !strings.Contains(err.Error(), "gave up after 2 attempts: 503") {
+		t.Errorf("exhausted retries err = %v", err)
+	}
+	if _, err := Send("mailto:soc@example.com", "x", Options{}); err == nil {
+		t.Error("a url that is not http was taken")
+	}
+}
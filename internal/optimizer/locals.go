@@ -0,0 +1,206 @@
+// internal/optimizer/locals.go
+package optimizer
+
+import "sentra/internal/parser"
+
+// function optimizes a function body and drops the locals it declares but
+// never uses. A name counts as used if it appears anywhere in the body,
+// nested functions included, so shadowing only ever keeps a local. An
+// initializer that may have side effects is kept as a statement.
+func (o *optimizer) function(body []parser.Stmt) []parser.Stmt {
+	body = o.block(body)
+	used := make(map[string]bool)
+	for _, stmt := range body {
+		usesInStmt(stmt, used)
+	}
+	return o.dropUnused(body, used)
+}
+
+func (o *optimizer) dropUnused(stmts []parser.Stmt, used map[string]bool) []parser.Stmt {
+	result := stmts[:0]
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *parser.LetStmt:
+			if !used[s.Name] {
+				if s.Expr != nil && !isPure(s.Expr) {
+					result = append(result, o.replace(s, &parser.ExpressionStmt{Expr: s.Expr}))
+				}
+				continue
+			}
+		case *parser.IfStmt:
+			s.Then = o.dropUnused(s.Then, used)
+			if s.Else != nil {
+				s.Else = o.dropUnused(s.Else, used)
+			}
+		case *parser.WhileStmt:
+			s.Body = o.dropUnused(s.Body, used)
+		case *parser.ForStmt:
+			s.Body = o.dropUnused(s.Body, used)
+		case *parser.ForInStmt:
+			s.Body = o.dropUnused(s.Body, used)
+		case *parser.TryStmt:
+			s.TryBlock = o.dropUnused(s.TryBlock, used)
+			s.CatchBlock = o.dropUnused(s.CatchBlock, used)
+			if s.FinallyBlock != nil {
+				s.FinallyBlock = o.dropUnused(s.FinallyBlock, used)
+			}
+		case *parser.MatchStmt:
+			for i := range s.Cases {
+				s.Cases[i].Body = o.dropUnused(s.Cases[i].Body, used)
+			}
+		}
+		result = append(result, stmt)
+	}
+	return result
+}
+
+// isPure reports whether evaluating expr can have no effect and cannot
+// fail. Variables are not pure, as reading an undefined one is an error.
+func isPure(expr parser.Expr) bool {
+	switch e := expr.(type) {
+	case *parser.Literal, *parser.LambdaExpr:
+		return true
+	case *parser.ArrayExpr:
+		for _, elem := range e.Elements {
+			if !isPure(elem) {
+				return false
+			}
+		}
+		return true
+	case *parser.MapExpr:
+		for i := range e.Keys {
+			if !isPure(e.Keys[i]) || !isPure(e.Values[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// usesInStmt adds every name a statement reads or assigns to used
+func usesInStmt(stmt parser.Stmt, used map[string]bool) {
+	switch s := stmt.(type) {
+	case *parser.PrintStmt:
+		usesInExpr(s.Expr, used)
+	case *parser.LetStmt:
+		usesInExpr(s.Expr, used)
+	case *parser.AssignmentStmt:
+		used[s.Name] = true
+		usesInExpr(s.Value, used)
+	case *parser.IndexAssignmentStmt:
+		usesInExpr(s.Object, used)
+		usesInExpr(s.Index, used)
+		usesInExpr(s.Value, used)
+	case *parser.ExpressionStmt:
+		usesInExpr(s.Expr, used)
+	case *parser.FunctionStmt:
+		usesInExprs(s.Defaults, used)
+		usesInStmts(s.Body, used)
+	case *parser.ReturnStmt:
+		usesInExpr(s.Value, used)
+	case *parser.ThrowStmt:
+		usesInExpr(s.Value, used)
+	case *parser.IfStmt:
+		usesInExpr(s.Condition, used)
+		usesInStmts(s.Then, used)
+		usesInStmts(s.Else, used)
+	case *parser.WhileStmt:
+		usesInExpr(s.Condition, used)
+		usesInStmts(s.Body, used)
+	case *parser.ForStmt:
+		if s.Init != nil {
+			usesInStmt(s.Init, used)
+		}
+		usesInExpr(s.Condition, used)
+		usesInExpr(s.Update, used)
+		usesInStmts(s.Body, used)
+	case *parser.ForInStmt:
+		usesInExpr(s.Collection, used)
+		usesInStmts(s.Body, used)
+	case *parser.ExportStmt:
+		used[s.Name] = true
+		usesInStmt(s.Stmt, used)
+	case *parser.ClassStmt:
+		usesInExprs(s.Defaults, used)
+		for _, method := range s.Methods {
+			usesInStmt(method, used)
+		}
+	case *parser.TryStmt:
+		usesInStmts(s.TryBlock, used)
+		usesInStmts(s.CatchBlock, used)
+		usesInStmts(s.FinallyBlock, used)
+	case *parser.MatchStmt:
+		usesInExpr(s.Value, used)
+		for _, c := range s.Cases {
+			usesInExprs(c.Patterns, used)
+			usesInStmts(c.Body, used)
+		}
+	}
+}
+
+func usesInStmts(stmts []parser.Stmt, used map[string]bool) {
+	for _, stmt := range stmts {
+		usesInStmt(stmt, used)
+	}
+}
+
+func usesInExprs(exprs []parser.Expr, used map[string]bool) {
+	for _, e := range exprs {
+		usesInExpr(e, used)
+	}
+}
+
+// usesInExpr adds every name an expression reads or assigns to used
+func usesInExpr(expr parser.Expr, used map[string]bool) {
+	switch e := expr.(type) {
+	case *parser.Variable:
+		used[e.Name] = true
+	case *parser.Assign:
+		used[e.Name] = true
+		usesInExpr(e.Value, used)
+	case *parser.AssignmentExpr:
+		used[e.Name] = true
+		usesInExpr(e.Value, used)
+	case *parser.Binary:
+		usesInExpr(e.Left, used)
+		usesInExpr(e.Right, used)
+	case *parser.LogicalExpr:
+		usesInExpr(e.Left, used)
+		usesInExpr(e.Right, used)
+	case *parser.UnaryExpr:
+		usesInExpr(e.Operand, used)
+	case *parser.CallExpr:
+		usesInExpr(e.Callee, used)
+		usesInExprs(e.Args, used)
+	case *parser.IfExpr:
+		usesInExpr(e.Cond, used)
+		usesInExpr(e.ThenBranch, used)
+		usesInExpr(e.ElseBranch, used)
+	case *parser.BlockExpr:
+		usesInStmts(e.Stmts, used)
+	case *parser.ArrayExpr:
+		usesInExprs(e.Elements, used)
+	case *parser.MapExpr:
+		usesInExprs(e.Keys, used)
+		usesInExprs(e.Values, used)
+	case *parser.IndexExpr:
+		usesInExpr(e.Object, used)
+		usesInExpr(e.Index, used)
+	case *parser.SliceExpr:
+		usesInExpr(e.Object, used)
+		usesInExpr(e.Start, used)
+		usesInExpr(e.End, used)
+	case *parser.SetIndexExpr:
+		usesInExpr(e.Object, used)
+		usesInExpr(e.Index, used)
+		usesInExpr(e.Value, used)
+	case *parser.InterpolationExpr:
+		usesInExprs(e.Parts, used)
+	case *parser.LambdaExpr:
+		usesInExprs(e.Defaults, used)
+		usesInExpr(e.Body, used)
+	case *parser.PropertyExpr:
+		usesInExpr(e.Object, used)
+	}
+}
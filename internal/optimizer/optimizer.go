@@ -0,0 +1,498 @@
+// internal/optimizer/optimizer.go
+
+// Package optimizer rewrites a parsed program before it is compiled. It
+// folds constant arithmetic, comparisons and string concatenation, removes
+// branches and loops whose condition is a constant and statements after a
+// return, break, continue or throw, and drops locals a function never
+// uses. Both compilers run it, so it only makes rewrites that give the same
+// result on either VM.
+package optimizer
+
+import (
+	"math"
+
+	"sentra/internal/parser"
+)
+
+// Ints larger than this become floats in the register VM
+const maxInt = 1 << 47
+
+type optimizer struct {
+	lines map[parser.Stmt]int
+}
+
+// Optimize rewrites stmts in place and returns the optimized program.
+// Statements that replace others take over their line in lines, which may
+// be nil.
+func Optimize(stmts []parser.Stmt, lines map[parser.Stmt]int) []parser.Stmt {
+	o := &optimizer{lines: lines}
+	return o.block(stmts)
+}
+
+// replace records that to stands in for from
+func (o *optimizer) replace(from, to parser.Stmt) parser.Stmt {
+	if line, ok := o.lines[from]; ok {
+		o.lines[to] = line
+	}
+	return to
+}
+
+// block optimizes a list of statements, splicing in the taken branch of a
+// constant if and dropping unreachable statements
+func (o *optimizer) block(stmts []parser.Stmt) []parser.Stmt {
+	result := make([]parser.Stmt, 0, len(stmts))
+	terminated := false
+	for _, stmt := range stmts {
+		if terminated {
+			// Functions and structs are still declared, as the stack
+			// compiler hoists them
+			if declaresFunction([]parser.Stmt{stmt}) {
+				result = append(result, stmt)
+			}
+			continue
+		}
+		o.stmt(stmt)
+		switch s := stmt.(type) {
+		case *parser.IfStmt:
+			if taken, ok := o.constantIf(s); ok {
+				result = append(result, taken...)
+				terminated = endsBlock(taken)
+				continue
+			}
+		case *parser.WhileStmt:
+			if isFalsy(s.Condition) && !declaresFunction(s.Body) {
+				continue
+			}
+		case *parser.ForStmt:
+			if s.Init == nil && s.Condition != nil && isFalsy(s.Condition) && !declaresFunction(s.Body) {
+				continue
+			}
+		case *parser.ReturnStmt, *parser.BreakStmt, *parser.ContinueStmt, *parser.ThrowStmt:
+			terminated = true
+		}
+		result = append(result, stmt)
+	}
+	return result
+}
+
+// constantIf returns the statements that replace an if whose condition is
+// a constant. The taken branch is spliced into the enclosing block unless
+// it declares names, which would then leak out of it.
+func (o *optimizer) constantIf(s *parser.IfStmt) ([]parser.Stmt, bool) {
+	lit, ok := s.Condition.(*parser.Literal)
+	if !ok || !isConstant(lit) {
+		return nil, false
+	}
+	taken, dropped := s.Then, s.Else
+	if !truthy(lit) {
+		taken, dropped = s.Else, s.Then
+	}
+	if declaresFunction(dropped) {
+		return nil, false
+	}
+	if declaresNames(taken) {
+		return []parser.Stmt{o.replace(s, &parser.IfStmt{Condition: &parser.Literal{Value: true}, Then: taken})}, true
+	}
+	return taken, true
+}
+
+// stmt optimizes the expressions and nested blocks of a statement
+func (o *optimizer) stmt(stmt parser.Stmt) {
+	switch s := stmt.(type) {
+	case *parser.PrintStmt:
+		s.Expr = o.expr(s.Expr)
+	case *parser.LetStmt:
+		s.Expr = o.expr(s.Expr)
+	case *parser.AssignmentStmt:
+		s.Value = o.expr(s.Value)
+	case *parser.IndexAssignmentStmt:
+		s.Object = o.expr(s.Object)
+		s.Index = o.expr(s.Index)
+		s.Value = o.expr(s.Value)
+	case *parser.ExpressionStmt:
+		s.Expr = o.expr(s.Expr)
+	case *parser.FunctionStmt:
+		o.exprs(s.Defaults)
+		s.Body = o.function(s.Body)
+	case *parser.ReturnStmt:
+		s.Value = o.expr(s.Value)
+	case *parser.ThrowStmt:
+		s.Value = o.expr(s.Value)
+	case *parser.IfStmt:
+		s.Condition = o.expr(s.Condition)
+		s.Then = o.block(s.Then)
+		if s.Else != nil {
+			s.Else = o.block(s.Else)
+		}
+	case *parser.WhileStmt:
+		s.Condition = o.expr(s.Condition)
+		s.Body = o.block(s.Body)
+	case *parser.ForStmt:
+		if s.Init != nil {
+			o.stmt(s.Init)
+		}
+		s.Condition = o.expr(s.Condition)
+		s.Update = o.expr(s.Update)
+		s.Body = o.block(s.Body)
+	case *parser.ForInStmt:
+		s.Collection = o.expr(s.Collection)
+		s.Body = o.block(s.Body)
+	case *parser.ExportStmt:
+		o.stmt(s.Stmt)
+	case *parser.ClassStmt:
+		o.exprs(s.Defaults)
+		for _, method := range s.Methods {
+			o.stmt(method)
+		}
+	case *parser.TryStmt:
+		s.TryBlock = o.block(s.TryBlock)
+		s.CatchBlock = o.block(s.CatchBlock)
+		if s.FinallyBlock != nil {
+			s.FinallyBlock = o.block(s.FinallyBlock)
+		}
+	case *parser.MatchStmt:
+		s.Value = o.expr(s.Value)
+		for i := range s.Cases {
+			o.exprs(s.Cases[i].Patterns)
+			s.Cases[i].Body = o.block(s.Cases[i].Body)
+		}
+	}
+}
+
+func (o *optimizer) exprs(exprs []parser.Expr) {
+	for i, e := range exprs {
+		exprs[i] = o.expr(e)
+	}
+}
+
+// expr optimizes an expression, returning its replacement
+func (o *optimizer) expr(expr parser.Expr) parser.Expr {
+	switch e := expr.(type) {
+	case *parser.Binary:
+		e.Left = o.expr(e.Left)
+		e.Right = o.expr(e.Right)
+		switch e.Operator {
+		case "&&", "||", "??":
+			return foldLogical(e.Left, e.Operator, e.Right, expr)
+		}
+		if folded, ok := foldBinary(e); ok {
+			return folded
+		}
+	case *parser.LogicalExpr:
+		e.Left = o.expr(e.Left)
+		e.Right = o.expr(e.Right)
+		return foldLogical(e.Left, e.Operator, e.Right, expr)
+	case *parser.UnaryExpr:
+		e.Operand = o.expr(e.Operand)
+		if folded, ok := foldUnary(e); ok {
+			return folded
+		}
+	case *parser.IfExpr:
+		e.Cond = o.expr(e.Cond)
+		e.ThenBranch = o.expr(e.ThenBranch)
+		e.ElseBranch = o.expr(e.ElseBranch)
+		if lit, ok := e.Cond.(*parser.Literal); ok && isConstant(lit) {
+			if truthy(lit) {
+				return e.ThenBranch
+			}
+			if e.ElseBranch == nil {
+				return &parser.Literal{Value: nil}
+			}
+			return e.ElseBranch
+		}
+	case *parser.BlockExpr:
+		e.Stmts = o.block(e.Stmts)
+	case *parser.CallExpr:
+		e.Callee = o.expr(e.Callee)
+		o.exprs(e.Args)
+	case *parser.ArrayExpr:
+		o.exprs(e.Elements)
+	case *parser.MapExpr:
+		o.exprs(e.Keys)
+		o.exprs(e.Values)
+	case *parser.IndexExpr:
+		e.Object = o.expr(e.Object)
+		e.Index = o.expr(e.Index)
+	case *parser.SliceExpr:
+		e.Object = o.expr(e.Object)
+		e.Start = o.expr(e.Start)
+		e.End = o.expr(e.End)
+	case *parser.SetIndexExpr:
+		e.Object = o.expr(e.Object)
+		e.Index = o.expr(e.Index)
+		e.Value = o.expr(e.Value)
+	case *parser.InterpolationExpr:
+		o.exprs(e.Parts)
+	case *parser.LambdaExpr:
+		o.exprs(e.Defaults)
+		if block, ok := e.Body.(*parser.BlockExpr); ok {
+			block.Stmts = o.function(block.Stmts)
+		} else {
+			e.Body = o.expr(e.Body)
+		}
+	case *parser.PropertyExpr:
+		e.Object = o.expr(e.Object)
+	case *parser.Assign:
+		e.Value = o.expr(e.Value)
+	case *parser.AssignmentExpr:
+		e.Value = o.expr(e.Value)
+	}
+	return expr
+}
+
+// isConstant reports whether a literal is a value both VMs treat alike
+func isConstant(lit *parser.Literal) bool {
+	switch lit.Value.(type) {
+	case nil, bool, int, int64, float64, string:
+		return true
+	}
+	return false
+}
+
+// truthy reports whether a constant literal counts as true in a condition
+func truthy(lit *parser.Literal) bool {
+	switch v := lit.Value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	n, _ := number(lit.Value)
+	return n != 0
+}
+
+// isFalsy reports whether expr is a constant that counts as false
+func isFalsy(expr parser.Expr) bool {
+	lit, ok := expr.(*parser.Literal)
+	return ok && isConstant(lit) && !truthy(lit)
+}
+
+// number returns a numeric constant as a float
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// integer returns an integer constant
+func integer(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// foldLogical folds &&, || and ?? when the left side is a constant. Like
+// the VM, the result is the deciding operand rather than a bool.
+func foldLogical(left parser.Expr, operator string, right parser.Expr, expr parser.Expr) parser.Expr {
+	lit, ok := left.(*parser.Literal)
+	if !ok || !isConstant(lit) {
+		return expr
+	}
+	switch operator {
+	case "&&":
+		if truthy(lit) {
+			return right
+		}
+		return left
+	case "||":
+		if truthy(lit) {
+			return left
+		}
+		return right
+	case "??":
+		if lit.Value == nil {
+			return right
+		}
+		return left
+	}
+	return expr
+}
+
+// foldUnary folds - and ! applied to a constant
+func foldUnary(e *parser.UnaryExpr) (parser.Expr, bool) {
+	lit, ok := e.Operand.(*parser.Literal)
+	if !ok || !isConstant(lit) {
+		return nil, false
+	}
+	switch e.Operator {
+	case "-":
+		if i, ok := integer(lit.Value); ok {
+			return &parser.Literal{Value: -i}, true
+		}
+		if f, ok := lit.Value.(float64); ok {
+			return &parser.Literal{Value: -f}, true
+		}
+	case "!":
+		return &parser.Literal{Value: !truthy(lit)}, true
+	}
+	return nil, false
+}
+
+// foldBinary folds an arithmetic, comparison or concatenation of two
+// constants. Ints stay ints where the register VM keeps them so; anything
+// that would fail at runtime, like division by zero, is left alone.
+func foldBinary(e *parser.Binary) (parser.Expr, bool) {
+	left, lok := e.Left.(*parser.Literal)
+	right, rok := e.Right.(*parser.Literal)
+	if !lok || !rok || !isConstant(left) || !isConstant(right) {
+		return nil, false
+	}
+	if ls, ok := left.Value.(string); ok {
+		rs, ok := right.Value.(string)
+		if !ok {
+			return nil, false
+		}
+		switch e.Operator {
+		case "+":
+			return &parser.Literal{Value: ls + rs}, true
+		case "==":
+			return &parser.Literal{Value: ls == rs}, true
+		case "!=":
+			return &parser.Literal{Value: ls != rs}, true
+		}
+		return nil, false
+	}
+
+	lf, lnum := number(left.Value)
+	rf, rnum := number(right.Value)
+	if !lnum || !rnum {
+		// Booleans and nil only compare for equality with their own kind
+		sameKind := (left.Value == nil) == (right.Value == nil) && !lnum && !rnum
+		if !sameKind {
+			return nil, false
+		}
+		switch e.Operator {
+		case "==":
+			return &parser.Literal{Value: left.Value == right.Value}, true
+		case "!=":
+			return &parser.Literal{Value: left.Value != right.Value}, true
+		}
+		return nil, false
+	}
+
+	li, lint := integer(left.Value)
+	ri, rint := integer(right.Value)
+	ints := lint && rint
+	switch e.Operator {
+	case "+", "-", "*":
+		result := lf + rf
+		if e.Operator == "-" {
+			result = lf - rf
+		} else if e.Operator == "*" {
+			result = lf * rf
+		}
+		if !ints {
+			return &parser.Literal{Value: result}, true
+		}
+		if math.Abs(result) >= maxInt {
+			return nil, false
+		}
+		switch e.Operator {
+		case "+":
+			return &parser.Literal{Value: li + ri}, true
+		case "-":
+			return &parser.Literal{Value: li - ri}, true
+		}
+		return &parser.Literal{Value: li * ri}, true
+	case "/":
+		if rf == 0 {
+			return nil, false
+		}
+		return &parser.Literal{Value: lf / rf}, true
+	case "%":
+		if rf == 0 {
+			return nil, false
+		}
+		if ints {
+			return &parser.Literal{Value: li % ri}, true
+		}
+		return &parser.Literal{Value: math.Mod(lf, rf)}, true
+	case "==":
+		return &parser.Literal{Value: lf == rf}, true
+	case "!=":
+		return &parser.Literal{Value: lf != rf}, true
+	case "<":
+		return &parser.Literal{Value: lf < rf}, true
+	case "<=":
+		return &parser.Literal{Value: lf <= rf}, true
+	case ">":
+		return &parser.Literal{Value: lf > rf}, true
+	case ">=":
+		return &parser.Literal{Value: lf >= rf}, true
+	}
+	return nil, false
+}
+
+// endsBlock reports whether a block always leaves by return, break,
+// continue or throw
+func endsBlock(stmts []parser.Stmt) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	switch stmts[len(stmts)-1].(type) {
+	case *parser.ReturnStmt, *parser.BreakStmt, *parser.ContinueStmt, *parser.ThrowStmt:
+		return true
+	}
+	return false
+}
+
+// declaresNames reports whether a block declares names at its top level
+func declaresNames(stmts []parser.Stmt) bool {
+	for _, stmt := range stmts {
+		switch stmt.(type) {
+		case *parser.LetStmt, *parser.FunctionStmt, *parser.ClassStmt, *parser.ImportStmt, *parser.ExportStmt:
+			return true
+		}
+	}
+	return false
+}
+
+// declaresFunction reports whether a block declares a function or struct
+// anywhere, which the stack compiler hoists even out of dead code
+func declaresFunction(stmts []parser.Stmt) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *parser.FunctionStmt, *parser.ClassStmt, *parser.ExportStmt:
+			return true
+		case *parser.IfStmt:
+			if declaresFunction(s.Then) || declaresFunction(s.Else) {
+				return true
+			}
+		case *parser.WhileStmt:
+			if declaresFunction(s.Body) {
+				return true
+			}
+		case *parser.ForStmt:
+			if declaresFunction(s.Body) {
+				return true
+			}
+		case *parser.ForInStmt:
+			if declaresFunction(s.Body) {
+				return true
+			}
+		case *parser.TryStmt:
+			if declaresFunction(s.TryBlock) || declaresFunction(s.CatchBlock) || declaresFunction(s.FinallyBlock) {
+				return true
+			}
+		case *parser.MatchStmt:
+			for _, c := range s.Cases {
+				if declaresFunction(c.Body) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"sentra/internal/formatter"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+)
+
+// optimize parses source, optimizes it and formats the result
+func optimize(t *testing.T, source string) string {
+	t.Helper()
+	p := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test")
+	stmts := p.Parse()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse failed: %v", p.Errors[0])
+	}
+	return strings.TrimSpace(formatter.NewFormatter().Format(Optimize(stmts, p.StmtLines())))
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{`let a = 2 * 3 + 1`, `let a = 7`},
+		{`let a = 7 / 2`, `let a = 3.5`},
+		{`let a = 7 % 3`, `let a = 1`},
+		{`let a = -(2 + 3)`, `let a = -5`},
+		{`let a = "a" + "b" + "c"`, `let a = "abc"`},
+		{`let a = 1 < 2`, `let a = true`},
+		{`let a = 1 == 1.0`, `let a = true`},
+		{`let a = "x" != "y"`, `let a = true`},
+		{`let a = !nil`, `let a = true`},
+		{`let a = false || x`, `let a = x`},
+		{`let a = true && x`, `let a = x`},
+		{`let a = nil ?? x`, `let a = x`},
+		{`let a = f(1 + 1)`, `let a = f(2)`},
+		// Left for the runtime, which reports the error or overflows to a float
+		{`let a = 1 / 0`, `let a = 1 / 0`},
+		{`let a = 1 % 0`, `let a = 1 % 0`},
+		{`let a = 100000000 * 100000000`, `let a = 100000000 * 100000000`},
+		{`let a = "n" + 1`, `let a = "n" + 1`},
+		{`let a = x + 1`, `let a = x + 1`},
+	}
+	for _, tt := range tests {
+		if got := optimize(t, tt.source); got != tt.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestDeadCode(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"if true", "if 1 < 2 {\n    log(\"a\")\n} else {\n    log(\"b\")\n}", `log("a")`},
+		{"if false", "if false {\n    log(\"a\")\n}\nlog(\"b\")", `log("b")`},
+		{"else taken", "if false {\n    log(\"a\")\n} else {\n    log(\"b\")\n}", `log("b")`},
+		{"while false", "while false {\n    log(\"a\")\n}\nlog(\"b\")", `log("b")`},
+		{"after return", "fn f() {\n    return 1\n    log(\"a\")\n}", "fn f() {\n    return 1\n}"},
+		{"after throw", "fn f() {\n    throw \"x\"\n    log(\"a\")\n}", "fn f() {\n    throw \"x\"\n}"},
+		{"after break", "while x {\n    break\n    log(\"a\")\n}", "while x {\n    break\n}"},
+		// A taken branch that declares names keeps its scope
+		{"scoped", "if true {\n    let a = 1\n    log(a)\n}", "if true {\n    let a = 1\n    log(a)\n}"},
+		// The stack compiler hoists functions even out of dead branches
+		{"function", "if false {\n    fn f() {\n    }\n}", "if false {\n    fn f() {\n    }\n}"},
+		{"not constant", "if x {\n    log(\"a\")\n}", "if x {\n    log(\"a\")\n}"},
+	}
+	for _, tt := range tests {
+		if got := optimize(t, tt.source); got != tt.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnusedLocals(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"unused", "fn f() {\n    let a = 1\n    return 2\n}", "fn f() {\n    return 2\n}"},
+		{"side effects kept", "fn f() {\n    let a = g()\n    return 2\n}", "fn f() {\n    g()\n    return 2\n}"},
+		{"used", "fn f() {\n    let a = 1\n    return a\n}", "fn f() {\n    let a = 1\n    return a\n}"},
+		{"assigned", "fn f() {\n    let a = 1\n    a = 2\n}", "fn f() {\n    let a = 1\n    a = 2\n}"},
+		{"closure", "fn f() {\n    let a = 1\n    return fn() => a\n}", "fn f() {\n    let a = 1\n    return fn() => a\n}"},
+		{"nested block", "fn f(x) {\n    if x {\n        let a = [1, 2]\n    }\n}", "fn f(x) {\n    if x {\n    }\n}"},
+		// Globals may be read by other modules or later REPL entries
+		{"global", `let a = 1`, `let a = 1`},
+	}
+	for _, tt := range tests {
+		if got := optimize(t, tt.source); got != tt.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Statements that replace others keep their line for error messages and
+// the debugger
+func TestLinesKept(t *testing.T) {
+	source := "fn f() {\n    let a = g()\n    return 1\n}"
+	p := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test")
+	stmts := p.Parse()
+	lines := p.StmtLines()
+	fn := Optimize(stmts, lines)[0].(*parser.FunctionStmt)
+	if line, ok := lines[fn.Body[0]]; !ok || line != 2 {
+		t.Errorf("replacement of let a is on line %d, want 2", line)
+	}
+}
@@ -0,0 +1,564 @@
+package ossec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Windows hardening audits: the registry, the configuration of services,
+// what runs at startup and the local security policy, read through the
+// Windows APIs rather than by running and parsing commands. What is
+// decided from them, like which paths are unquoted, which ACEs are weak
+// and which policy settings fall short, is worked out here, on any OS.
+
+// RegistryValue is a value of a registry key, with its data as a string,
+// an int64, a []string or a []byte depending on its type
+type RegistryValue struct {
+	Name string
+	Type string // REG_SZ, REG_EXPAND_SZ, REG_DWORD, REG_QWORD, REG_MULTI_SZ or REG_BINARY
+	Data interface{}
+}
+
+// RegistryKey is what a registry key holds: its subkeys and values
+type RegistryKey struct {
+	Path   string
+	Keys   []string
+	Values []RegistryValue
+}
+
+// registryRoots are the names of the root keys, long and short
+var registryRoots = map[string]string{
+	"HKLM": "HKLM", "HKEY_LOCAL_MACHINE": "HKLM",
+	"HKCU": "HKCU", "HKEY_CURRENT_USER": "HKCU",
+	"HKCR": "HKCR", "HKEY_CLASSES_ROOT": "HKCR",
+	"HKU": "HKU", "HKEY_USERS": "HKU",
+	"HKCC": "HKCC", "HKEY_CURRENT_CONFIG": "HKCC",
+}
+
+// ParseRegistryPath splits a key path like HKLM\SOFTWARE\Microsoft or
+// HKEY_LOCAL_MACHINE/SOFTWARE/Microsoft into its root, in short form, and
+// subkey
+func ParseRegistryPath(path string) (root, subkey string, err error) {
+	path = strings.Trim(strings.ReplaceAll(path, "/", `\`), `\`)
+	root, subkey, _ = strings.Cut(path, `\`)
+	short, ok := registryRoots[strings.ToUpper(strings.TrimSuffix(root, ":"))]
+	if !ok {
+		return "", "", fmt.Errorf("registry path %q does not start with a root key like HKLM", path)
+	}
+	return short, strings.Trim(subkey, `\`), nil
+}
+
+// ServiceConfig is the configuration of a Windows service
+type ServiceConfig struct {
+	Name        string
+	DisplayName string
+	BinaryPath  string // The command line, binPath as sc calls it
+	Executable  string // The program BinaryPath runs
+	StartType   string // boot, system, auto, demand or disabled
+	Type        string // own_process, share_process, kernel_driver or file_system_driver
+	Account     string
+	State       string // running, stopped and so on
+	ServiceSDDL string // The DACL of the service object, in SDDL
+}
+
+// ServiceExecutable returns the program a service command line runs,
+// with environment variables and the kernel's path forms expanded
+func ServiceExecutable(binPath string) string {
+	exe := strings.TrimSpace(binPath)
+	if strings.HasPrefix(exe, `"`) {
+		if end := strings.Index(exe[1:], `"`); end >= 0 {
+			exe = exe[1 : end+1]
+		}
+	} else if i := strings.Index(strings.ToLower(exe), ".exe"); i >= 0 {
+		exe = exe[:i+4]
+	} else if fields := strings.Fields(exe); len(fields) > 0 {
+		exe = fields[0]
+	}
+	exe = expandWindowsEnv(exe)
+	windir := windowsDir()
+	lower := strings.ToLower(exe)
+	switch {
+	case strings.HasPrefix(exe, `\??\`):
+		exe = exe[4:]
+	case strings.HasPrefix(lower, `\systemroot\`):
+		exe = windir + exe[len(`\systemroot`):]
+	case strings.HasPrefix(lower, `system32\`):
+		// How drivers are often given, relative to the Windows directory
+		exe = windir + `\` + exe
+	}
+	return exe
+}
+
+// UnquotedServicePath returns, when the command line of a service runs a
+// program by an unquoted path with spaces in it, the programs Windows
+// tries before it. Whoever can create one gets it run as the service's
+// account.
+func UnquotedServicePath(binPath string) []string {
+	binPath = strings.TrimSpace(binPath)
+	if strings.HasPrefix(binPath, `"`) {
+		return nil
+	}
+	exe := binPath
+	if i := strings.Index(strings.ToLower(exe), ".exe"); i >= 0 {
+		exe = exe[:i+4]
+	} else {
+		return nil
+	}
+	var candidates []string
+	for i, r := range exe {
+		if r == ' ' && strings.Contains(exe[:i], `\`) {
+			candidates = append(candidates, exe[:i]+".exe")
+		}
+	}
+	return candidates
+}
+
+var windowsEnvRe = regexp.MustCompile(`%([^%]+)%`)
+
+// expandWindowsEnv expands %VARIABLE%s, leaving those that are not set
+func expandWindowsEnv(s string) string {
+	return windowsEnvRe.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := os.LookupEnv(m[1 : len(m)-1]); ok {
+			return v
+		}
+		if strings.EqualFold(m, "%SystemRoot%") || strings.EqualFold(m, "%windir%") {
+			return windowsDir()
+		}
+		return m
+	})
+}
+
+// windowsDir is the Windows directory, C:\Windows unless it says otherwise
+func windowsDir() string {
+	if dir := os.Getenv("SystemRoot"); dir != "" {
+		return dir
+	}
+	return `C:\Windows`
+}
+
+// ACE is an access-allowed entry of a DACL that grants a right an
+// unprivileged principal should not have
+type ACE struct {
+	Principal string   // The SID
+	Name      string   // Its well-known name, if it has one
+	Rights    []string // The rights that make it weak
+}
+
+// unprivileged are the SIDs, in SDDL's short form and in full, that any
+// user of a machine is in
+var unprivileged = map[string]string{
+	"WD": "Everyone", "S-1-1-0": "Everyone",
+	"AU": "Authenticated Users", "S-1-5-11": "Authenticated Users",
+	"BU": "Users", "S-1-5-32-545": "Users",
+	"IU": "INTERACTIVE", "S-1-5-4": "INTERACTIVE",
+	"BG": "Guests", "S-1-5-32-546": "Guests",
+	"AN": "ANONYMOUS LOGON", "S-1-5-7": "ANONYMOUS LOGON",
+}
+
+// sddlRights are the access rights of SDDL's two-letter codes. The
+// directory service codes stand for the service rights of the same bits.
+var sddlRights = map[string]uint32{
+	"GA": 0x10000000, "GX": 0x20000000, "GW": 0x40000000, "GR": 0x80000000,
+	"SD": 0x00010000, "RC": 0x00020000, "WD": 0x00040000, "WO": 0x00080000,
+	"FA": 0x001f01ff, "FR": 0x00120089, "FW": 0x00120116, "FX": 0x001200a0,
+	"KA": 0x000f003f, "KR": 0x00020019, "KW": 0x00020006, "KX": 0x00020019,
+	"CC": 0x0001, "DC": 0x0002, "LC": 0x0004, "SW": 0x0008, "RP": 0x0010,
+	"WP": 0x0020, "DT": 0x0040, "LO": 0x0080, "CR": 0x0100,
+}
+
+// weakRights are the rights that let an unprivileged principal take over
+// a file or service, by kind of object
+var weakRights = map[string][]struct {
+	mask uint32
+	name string
+}{
+	"file": {
+		{0x10000000, "GENERIC_ALL"}, {0x40000000, "GENERIC_WRITE"},
+		{0x0002, "WRITE_DATA"}, {0x0004, "APPEND_DATA"},
+		{0x00010000, "DELETE"}, {0x00040000, "WRITE_DAC"}, {0x00080000, "WRITE_OWNER"},
+	},
+	"service": {
+		{0x10000000, "GENERIC_ALL"}, {0x40000000, "GENERIC_WRITE"},
+		{0x0002, "SERVICE_CHANGE_CONFIG"},
+		{0x00010000, "DELETE"}, {0x00040000, "WRITE_DAC"}, {0x00080000, "WRITE_OWNER"},
+	},
+}
+
+// WeakACEs returns the entries of a DACL, in SDDL, that grant rights to
+// write or take over a file or a service, as kind says, to principals
+// any user is in. Entries only inherited by children are left out.
+func WeakACEs(sddl, kind string) ([]ACE, error) {
+	checks, ok := weakRights[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind of object %q", kind)
+	}
+	start := strings.Index(sddl, "D:")
+	if start < 0 {
+		return nil, nil
+	}
+	dacl := sddl[start+2:]
+	if end := strings.Index(dacl, "S:"); end >= 0 {
+		dacl = dacl[:end]
+	}
+	if strings.Contains(dacl, "NO_ACCESS_CONTROL") && !strings.Contains(dacl, "(") {
+		// A null DACL lets everyone do anything
+		dacl = "(A;;GA;;;WD)"
+	}
+
+	byPrincipal := map[string]*ACE{}
+	var order []string
+	for _, entry := range strings.Split(dacl, "(")[1:] {
+		entry = strings.TrimSuffix(strings.TrimSpace(entry), ")")
+		fields := strings.Split(entry, ";")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("bad ACE %q", entry)
+		}
+		if fields[0] != "A" || inheritOnly(fields[1]) {
+			continue
+		}
+		sid := strings.ToUpper(fields[5])
+		name, ok := unprivileged[sid]
+		if !ok {
+			continue
+		}
+		mask, err := parseSDDLRights(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range checks {
+			if mask&c.mask == 0 {
+				continue
+			}
+			ace := byPrincipal[sid]
+			if ace == nil {
+				ace = &ACE{Principal: sid, Name: name}
+				byPrincipal[sid] = ace
+				order = append(order, sid)
+			}
+			if !containsString(ace.Rights, c.name) {
+				ace.Rights = append(ace.Rights, c.name)
+			}
+		}
+	}
+	aces := make([]ACE, len(order))
+	for i, sid := range order {
+		aces[i] = *byPrincipal[sid]
+	}
+	return aces, nil
+}
+
+// parseSDDLRights reads the rights of an ACE, a hex mask or a run of
+// two-letter codes
+func parseSDDLRights(s string) (uint32, error) {
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		n, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad access mask %q", s)
+		}
+		return uint32(n), nil
+	}
+	var mask uint32
+	for i := 0; i+2 <= len(s); i += 2 {
+		right, ok := sddlRights[s[i:i+2]]
+		if !ok {
+			return 0, fmt.Errorf("unknown access right %q", s[i:i+2])
+		}
+		mask |= right
+	}
+	return mask, nil
+}
+
+// inheritOnly tells whether the flags of an ACE, two letters each, say it
+// applies to children only
+func inheritOnly(flags string) bool {
+	for i := 0; i+2 <= len(flags); i += 2 {
+		if flags[i:i+2] == "IO" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceFinding is a weakness in the configuration of a service:
+// unquoted_path, weak_service_acl, weak_binary_acl or weak_directory_acl
+type ServiceFinding struct {
+	Type     string
+	Severity string
+	Service  string
+	Path     string
+	Detail   string
+}
+
+// AuditService returns the weaknesses of a service, given the DACLs of
+// its program and the program's directory in SDDL, empty when unknown
+func AuditService(s ServiceConfig, binarySDDL, dirSDDL string) []ServiceFinding {
+	var findings []ServiceFinding
+	severity := "medium"
+	if s.StartType == "auto" || s.StartType == "boot" || s.StartType == "system" {
+		// It runs without anyone starting it
+		severity = "high"
+	}
+	if candidates := UnquotedServicePath(s.BinaryPath); len(candidates) > 0 {
+		findings = append(findings, ServiceFinding{
+			Type: "unquoted_path", Severity: severity, Service: s.Name, Path: s.Executable,
+			Detail: fmt.Sprintf("service %s has an unquoted path with spaces; Windows tries %s first", s.Name, strings.Join(candidates, ", ")),
+		})
+	}
+	for _, check := range []struct {
+		kind, sddl, finding, object, path string
+	}{
+		{"service", s.ServiceSDDL, "weak_service_acl", "service " + s.Name, ""},
+		{"file", binarySDDL, "weak_binary_acl", "the program of service " + s.Name, s.Executable},
+		{"file", dirSDDL, "weak_directory_acl", "the directory of the program of service " + s.Name, dirOf(s.Executable)},
+	} {
+		if check.sddl == "" {
+			continue
+		}
+		aces, _ := WeakACEs(check.sddl, check.kind)
+		for _, ace := range aces {
+			f := ServiceFinding{
+				Type: check.finding, Severity: "high", Service: s.Name, Path: check.path,
+				Detail: fmt.Sprintf("%s grants %s %s", check.object, ace.Name, strings.Join(ace.Rights, ", ")),
+			}
+			if check.finding == "weak_directory_acl" && onlyCreates(ace.Rights) {
+				// Files can be added beside the program, DLLs it loads, but
+				// it cannot be replaced
+				f.Severity = "medium"
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// onlyCreates tells whether directory rights only allow adding entries,
+// which WRITE_DATA and APPEND_DATA mean for a directory
+func onlyCreates(rights []string) bool {
+	for _, r := range rights {
+		if r != "WRITE_DATA" && r != "APPEND_DATA" {
+			return false
+		}
+	}
+	return true
+}
+
+// dirOf is the directory of a Windows path
+func dirOf(path string) string {
+	if i := strings.LastIndex(path, `\`); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// StartupItem is a program Windows runs at boot or logon
+type StartupItem struct {
+	Name       string
+	Command    string
+	Location   string // The registry key or folder it is in
+	Scope      string // machine or user
+	Executable string
+}
+
+// StartupKeys are the registry keys whose values are run at startup or
+// logon, with the scope they apply to
+var StartupKeys = []struct{ Path, Scope string }{
+	{`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Run`, "machine"},
+	{`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\RunOnce`, "machine"},
+	{`HKLM\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Run`, "machine"},
+	{`HKLM\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\RunOnce`, "machine"},
+	{`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Policies\Explorer\Run`, "machine"},
+	{`HKCU\SOFTWARE\Microsoft\Windows\CurrentVersion\Run`, "user"},
+	{`HKCU\SOFTWARE\Microsoft\Windows\CurrentVersion\RunOnce`, "user"},
+	{`HKCU\SOFTWARE\Microsoft\Windows\CurrentVersion\Policies\Explorer\Run`, "user"},
+}
+
+// winlogonDefaults are the values of the Winlogon key Windows ships with,
+// which anything else added to is run at logon
+var winlogonDefaults = map[string]string{
+	"Shell":    "explorer.exe",
+	"Userinit": `C:\Windows\system32\userinit.exe,`,
+}
+
+// SecurityPolicy is the local security policy as secedit exports it, by
+// section and setting
+type SecurityPolicy map[string]map[string]string
+
+// ParseSecurityPolicy reads the INF file secedit /export writes, which is
+// UTF-16 with a byte order mark
+func ParseSecurityPolicy(data []byte) (SecurityPolicy, error) {
+	if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+		units := make([]uint16, (len(data)-2)/2)
+		for i := range units {
+			units[i] = uint16(data[2+2*i]) | uint16(data[3+2*i])<<8
+		}
+		data = []byte(string(utf16.Decode(units)))
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	policy := SecurityPolicy{}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+			if policy[section] == nil {
+				policy[section] = map[string]string{}
+			}
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok || section == "" {
+				return nil, fmt.Errorf("bad security policy line %q", line)
+			}
+			policy[section][strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("empty security policy")
+	}
+	return policy, nil
+}
+
+// Setting returns a setting and whether it is set. Registry values,
+// exported as type,data, give their data.
+func (p SecurityPolicy) Setting(section, key string) (string, bool) {
+	v, ok := p[section][key]
+	if ok && section == "Registry Values" {
+		if _, data, found := strings.Cut(v, ","); found {
+			v = data
+		}
+	}
+	return v, ok
+}
+
+// PolicyFinding is a setting of the local security policy that falls
+// short of a hardening baseline
+type PolicyFinding struct {
+	Setting  string
+	Value    string // What it is, empty if it is not set
+	Expected string
+	Severity string
+	Detail   string
+}
+
+// policyCheck is a setting of the baseline: the values it may have, a
+// minimum or maximum of a number, or the principals a right may go to
+type policyCheck struct {
+	section, key string
+	severity     string
+	detail       string
+	expected     string
+	ok           func(v string, set bool) bool
+}
+
+// atLeast, between and equals make the tests of numeric settings
+func atLeast(min int) func(string, bool) bool {
+	return func(v string, set bool) bool { n, err := strconv.Atoi(v); return set && err == nil && n >= min }
+}
+
+func between(min, max int) func(string, bool) bool {
+	return func(v string, set bool) bool {
+		n, err := strconv.Atoi(v)
+		return set && err == nil && n >= min && n <= max
+	}
+}
+
+func equals(want string) func(string, bool) bool {
+	return func(v string, set bool) bool { return set && v == want }
+}
+
+// auditsFailures tests an audit setting, which is 0 for none, 1 for
+// successes, 2 for failures and 3 for both
+func auditsFailures(v string, set bool) bool { return set && (v == "2" || v == "3") }
+
+// grantedOnlyTo tests that a user right goes to no principal but those
+// given, as secedit exports them, *SID
+func grantedOnlyTo(sids ...string) func(string, bool) bool {
+	return func(v string, set bool) bool {
+		if !set {
+			return true
+		}
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" && !containsString(sids, strings.TrimPrefix(p, "*")) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+const (
+	lsaKey    = `MACHINE\System\CurrentControlSet\Control\Lsa\`
+	systemKey = `MACHINE\Software\Microsoft\Windows\CurrentVersion\Policies\System\`
+	smbKey    = `MACHINE\System\CurrentControlSet\Services\LanManServer\Parameters\`
+)
+
+// policyBaseline are the checks CheckSecurityPolicy makes, a subset of
+// the CIS benchmarks for Windows
+var policyBaseline = []policyCheck{
+	{"System Access", "MinimumPasswordLength", "medium", "passwords may be shorter than 14 characters", ">= 14", atLeast(14)},
+	{"System Access", "PasswordComplexity", "medium", "passwords need not be complex", "1", equals("1")},
+	{"System Access", "PasswordHistorySize", "low", "passwords may be reused within 24 changes", ">= 24", atLeast(24)},
+	{"System Access", "MaximumPasswordAge", "low", "passwords never expire or last over a year", "1-365", between(1, 365)},
+	{"System Access", "LockoutBadCount", "medium", "accounts are not locked out after 10 failed logons", "1-10", between(1, 10)},
+	{"System Access", "ClearTextPassword", "high", "passwords are stored with reversible encryption", "0", func(v string, set bool) bool { return !set || v == "0" }},
+	{"System Access", "EnableGuestAccount", "high", "the guest account is enabled", "0", func(v string, set bool) bool { return !set || v == "0" }},
+	{"System Access", "LSAAnonymousNameLookup", "medium", "anonymous users can translate SIDs to names", "0", func(v string, set bool) bool { return !set || v == "0" }},
+	{"Event Audit", "AuditLogonEvents", "low", "failed logons are not audited", "2 or 3", auditsFailures},
+	{"Event Audit", "AuditAccountLogon", "low", "failed account logons are not audited", "2 or 3", auditsFailures},
+	{"Event Audit", "AuditAccountManage", "low", "failed account management is not audited", "2 or 3", auditsFailures},
+	{"Event Audit", "AuditPolicyChange", "low", "failed policy changes are not audited", "2 or 3", auditsFailures},
+	{"Registry Values", lsaKey + "LimitBlankPasswordUse", "high", "accounts with blank passwords can log on over the network", "1", equals("1")},
+	{"Registry Values", lsaKey + "NoLMHash", "high", "LAN Manager hashes of passwords are stored", "1", equals("1")},
+	{"Registry Values", lsaKey + "LmCompatibilityLevel", "high", "LM and NTLMv1 authentication are accepted", "5", atLeast(5)},
+	{"Registry Values", lsaKey + "RestrictAnonymous", "medium", "anonymous users can enumerate shares", "1", atLeast(1)},
+	{"Registry Values", lsaKey + "RestrictAnonymousSAM", "medium", "anonymous users can enumerate accounts", "1", equals("1")},
+	{"Registry Values", systemKey + "EnableLUA", "high", "User Account Control is disabled", "1", func(v string, set bool) bool { return !set || v == "1" }},
+	{"Registry Values", smbKey + "RequireSecuritySignature", "medium", "the SMB server does not require signing", "1", equals("1")},
+	{"Privilege Rights", "SeDebugPrivilege", "high", "principals other than Administrators can debug programs", "*S-1-5-32-544", grantedOnlyTo("S-1-5-32-544")},
+	{"Privilege Rights", "SeTcbPrivilege", "high", "principals can act as part of the operating system", "no one", grantedOnlyTo()},
+	{"Privilege Rights", "SeBackupPrivilege", "medium", "principals other than Administrators and Backup Operators can back up files", "*S-1-5-32-544,*S-1-5-32-551", grantedOnlyTo("S-1-5-32-544", "S-1-5-32-551")},
+	{"Privilege Rights", "SeRemoteInteractiveLogonRight", "medium", "principals other than Administrators and Remote Desktop Users can log on through RDP", "*S-1-5-32-544,*S-1-5-32-555", grantedOnlyTo("S-1-5-32-544", "S-1-5-32-555")},
+}
+
+// CheckSecurityPolicy returns the settings of a policy that fall short of
+// the baseline, the most severe first
+func CheckSecurityPolicy(p SecurityPolicy) []PolicyFinding {
+	findings := []PolicyFinding{}
+	for _, c := range policyBaseline {
+		v, set := p.Setting(c.section, c.key)
+		if c.ok(v, set) {
+			continue
+		}
+		name := c.key
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:]
+		}
+		findings = append(findings, PolicyFinding{Setting: name, Value: v, Expected: c.expected, Severity: c.severity, Detail: c.detail})
+	}
+	rank := map[string]int{"high": 0, "medium": 1, "low": 2}
+	sort.SliceStable(findings, func(i, j int) bool { return rank[findings[i].Severity] < rank[findings[j].Severity] })
+	return findings
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+// internal/ossec/winaudit_other.go
+package ossec
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// windowsOnly is the error of the Windows audits elsewhere
+func windowsOnly(what string) error {
+	return fmt.Errorf("%s are only available on Windows, not %s", what, runtime.GOOS)
+}
+
+// RegistryRead reads a value of a registry key, on Windows only
+func RegistryRead(path, name string) (RegistryValue, error) {
+	return RegistryValue{}, windowsOnly("registry reads")
+}
+
+// RegistryEnum lists the subkeys and values of a registry key, on Windows
+// only
+func RegistryEnum(path string) (RegistryKey, error) {
+	return RegistryKey{}, windowsOnly("registry reads")
+}
+
+// Services returns the configuration of every service, on Windows only
+func Services() ([]ServiceConfig, error) {
+	return nil, windowsOnly("service audits")
+}
+
+// AuditServices checks the configuration of services, on Windows only
+func AuditServices() ([]ServiceFinding, error) {
+	return nil, windowsOnly("service audits")
+}
+
+// StartupItems returns what runs at boot or logon, on Windows only
+func StartupItems() ([]StartupItem, error) {
+	return nil, windowsOnly("startup item audits")
+}
+
+// LocalSecurityPolicy exports the local security policy, on Windows only
+func LocalSecurityPolicy() (SecurityPolicy, error) {
+	return nil, windowsOnly("security policy audits")
+}
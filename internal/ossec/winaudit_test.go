@@ -0,0 +1,150 @@
+package ossec
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseRegistryPath(t *testing.T) {
+	for path, want := range map[string]string{
+		`HKLM\SOFTWARE\Microsoft`:                 `HKLM SOFTWARE\Microsoft`,
+		`HKEY_CURRENT_USER/Software/Run/`:         `HKCU Software\Run`,
+		`hklm:\System\CurrentControlSet\Services`: `HKLM System\CurrentControlSet\Services`,
+		`HKU`: `HKU `,
+	} {
+		root, subkey, err := ParseRegistryPath(path)
+		if got := root + " " + subkey; err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+		}
+	}
+	if _, _, err := ParseRegistryPath(`SOFTWARE\Microsoft`); err == nil {
+		t.Error("no error without a root key")
+	}
+}
+
+func TestServicePaths(t *testing.T) {
+	t.Setenv("SystemRoot", `C:\Windows`)
+	for binPath, want := range map[string]string{
+		`C:\Windows\system32\svchost.exe -k netsvcs -p`:   `C:\Windows\system32\svchost.exe`,
+		`"C:\Program Files\Vendor\agent.exe" --service`:   `C:\Program Files\Vendor\agent.exe`,
+		`%SystemRoot%\System32\spoolsv.exe`:               `C:\Windows\System32\spoolsv.exe`,
+		`\SystemRoot\System32\drivers\tcpip.sys`:          `C:\Windows\System32\drivers\tcpip.sys`,
+		`System32\drivers\afd.sys`:                        `C:\Windows\System32\drivers\afd.sys`,
+		`\??\C:\Windows\system32\drivers\wd\WdBoot.sys`:   `C:\Windows\system32\drivers\wd\WdBoot.sys`,
+		`C:\Program Files\Vendor Tools\svc.EXE /run fast`: `C:\Program Files\Vendor Tools\svc.EXE`,
+	} {
+		if got := ServiceExecutable(binPath); got != want {
+			t.Errorf("ServiceExecutable(%s) = %s, want %s", binPath, got, want)
+		}
+	}
+
+	got := strings.Join(UnquotedServicePath(`C:\Program Files\Vendor Tools\svc.exe /run fast`), " | ")
+	if want := `C:\Program.exe | C:\Program Files\Vendor.exe`; got != want {
+		t.Errorf("unquoted candidates = %s, want %s", got, want)
+	}
+	for _, safe := range []string{`"C:\Program Files\Vendor\agent.exe"`, `C:\Windows\system32\svchost.exe -k netsvcs`, `System32\drivers\afd.sys`} {
+		if c := UnquotedServicePath(safe); c != nil {
+			t.Errorf("%s: got candidates %v", safe, c)
+		}
+	}
+}
+
+func TestWeakACEs(t *testing.T) {
+	for _, tc := range []struct {
+		sddl, kind, want string
+	}{
+		// The DACL a default service has
+		{"D:(A;;CCLCSWRPWPDTLOCRRC;;;SY)(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;BA)(A;;CCLCSWLOCRRC;;;IU)(A;;CCLCSWLOCRRC;;;SU)", "service", ""},
+		{"D:(A;;CCLCSWRPWPDTLOCRRC;;;SY)(A;;CCDCLCSWRPWPDTLOCRRC;;;AU)", "service", "AU Authenticated Users SERVICE_CHANGE_CONFIG"},
+		{"O:BAG:SYD:PAI(A;;FA;;;SY)(A;;FA;;;BA)(A;;0x1200a9;;;BU)", "file", ""},
+		{"D:AI(A;OICI;0x1301bf;;;BU)(A;OICIIO;GA;;;CO)(A;ID;FA;;;SY)", "file", "BU Users WRITE_DATA APPEND_DATA DELETE"},
+		{"D:(A;;FA;;;S-1-1-0)S:AI(AU;SA;FA;;;WD)", "file", "S-1-1-0 Everyone WRITE_DATA APPEND_DATA DELETE WRITE_DAC WRITE_OWNER"},
+		{"D:NO_ACCESS_CONTROL", "service", "WD Everyone GENERIC_ALL"},
+		{"D:(D;;FA;;;WD)(A;;FR;;;WD)", "file", ""},
+	} {
+		aces, err := WeakACEs(tc.sddl, tc.kind)
+		if err != nil {
+			t.Errorf("%s: %v", tc.sddl, err)
+			continue
+		}
+		var got []string
+		for _, ace := range aces {
+			got = append(got, ace.Principal+" "+ace.Name+" "+strings.Join(ace.Rights, " "))
+		}
+		if strings.Join(got, "; ") != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.sddl, got, tc.want)
+		}
+	}
+	if _, err := WeakACEs("D:(A;;XX;;;WD)", "file"); err == nil {
+		t.Error("no error for an unknown right")
+	}
+}
+
+func TestAuditService(t *testing.T) {
+	s := ServiceConfig{
+		Name:        "VendorAgent",
+		BinaryPath:  `C:\Program Files\Vendor\agent.exe`,
+		Executable:  `C:\Program Files\Vendor\agent.exe`,
+		StartType:   "auto",
+		ServiceSDDL: "D:(A;;CCLCSWRPWPDTLOCRRC;;;SY)(A;;RPWPDC;;;WD)",
+	}
+	findings := AuditService(s, "D:(A;;FA;;;SY)(A;;0x1200a9;;;BU)", "D:(A;;FA;;;SY)(A;;0x1200af;;;BU)")
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Type+" "+f.Severity)
+	}
+	if want := "unquoted_path high, weak_service_acl high, weak_directory_acl medium"; strings.Join(got, ", ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if !strings.Contains(findings[0].Detail, `C:\Program.exe`) || findings[1].Detail != "service VendorAgent grants Everyone SERVICE_CHANGE_CONFIG" {
+		t.Errorf("details: %q, %q", findings[0].Detail, findings[1].Detail)
+	}
+}
+
+func TestSecurityPolicy(t *testing.T) {
+	inf := "[Unicode]\r\nUnicode=yes\r\n[System Access]\r\nMinimumPasswordAge = 0\r\nMaximumPasswordAge = 42\r\n" +
+		"MinimumPasswordLength = 8\r\nPasswordComplexity = 1\r\nPasswordHistorySize = 24\r\nLockoutBadCount = 0\r\n" +
+		"NewGuestName = \"Guest\"\r\nEnableGuestAccount = 0\r\nClearTextPassword = 0\r\nLSAAnonymousNameLookup = 0\r\n" +
+		"[Event Audit]\r\nAuditLogonEvents = 3\r\nAuditAccountLogon = 3\r\nAuditAccountManage = 1\r\nAuditPolicyChange = 3\r\n" +
+		"[Registry Values]\r\n" +
+		`MACHINE\System\CurrentControlSet\Control\Lsa\LimitBlankPasswordUse=4,1` + "\r\n" +
+		`MACHINE\System\CurrentControlSet\Control\Lsa\NoLMHash=4,1` + "\r\n" +
+		`MACHINE\System\CurrentControlSet\Control\Lsa\LmCompatibilityLevel=4,3` + "\r\n" +
+		`MACHINE\System\CurrentControlSet\Control\Lsa\RestrictAnonymous=4,0` + "\r\n" +
+		`MACHINE\System\CurrentControlSet\Control\Lsa\RestrictAnonymousSAM=4,1` + "\r\n" +
+		`MACHINE\Software\Microsoft\Windows\CurrentVersion\Policies\System\EnableLUA=4,1` + "\r\n" +
+		`MACHINE\System\CurrentControlSet\Services\LanManServer\Parameters\RequireSecuritySignature=4,1` + "\r\n" +
+		"[Privilege Rights]\r\nSeDebugPrivilege = *S-1-5-32-544,*S-1-5-32-545\r\nSeBackupPrivilege = *S-1-5-32-544,*S-1-5-32-551\r\n" +
+		"[Version]\r\nsignature=\"$CHICAGO$\"\r\nRevision=1\r\n"
+	// As secedit writes it, UTF-16 with a byte order mark
+	units := utf16.Encode([]rune(inf))
+	data := []byte{0xff, 0xfe}
+	for _, u := range units {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	policy, err := ParseSecurityPolicy(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := policy.Setting("System Access", "NewGuestName"); v != "Guest" {
+		t.Errorf("NewGuestName = %q", v)
+	}
+	if v, _ := policy.Setting("Registry Values", `MACHINE\System\CurrentControlSet\Control\Lsa\LmCompatibilityLevel`); v != "3" {
+		t.Errorf("LmCompatibilityLevel = %q", v)
+	}
+
+	var got []string
+	for _, f := range CheckSecurityPolicy(policy) {
+		got = append(got, f.Setting+"="+f.Value+" "+f.Severity)
+	}
+	want := "LmCompatibilityLevel=3 high, SeDebugPrivilege=*S-1-5-32-544,*S-1-5-32-545 high, MinimumPasswordLength=8 medium, " +
+		"LockoutBadCount=0 medium, RestrictAnonymous=0 medium, AuditAccountManage=1 low"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("findings:\n got %s\nwant %s", strings.Join(got, ", "), want)
+	}
+
+	if _, err := ParseSecurityPolicy([]byte("MinimumPasswordLength = 8")); err == nil {
+		t.Error("no error for a setting outside a section")
+	}
+}
@@ -0,0 +1,284 @@
+//go:build windows
+
+// internal/ossec/winaudit_windows.go
+package ossec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// rootKeys are the handles of the root keys, by their short names
+var rootKeys = map[string]registry.Key{
+	"HKLM": registry.LOCAL_MACHINE,
+	"HKCU": registry.CURRENT_USER,
+	"HKCR": registry.CLASSES_ROOT,
+	"HKU":  registry.USERS,
+	"HKCC": registry.CURRENT_CONFIG,
+}
+
+// openKey opens a registry key to read, in its 64-bit view
+func openKey(path string) (registry.Key, error) {
+	root, subkey, err := ParseRegistryPath(path)
+	if err != nil {
+		return 0, err
+	}
+	k, err := registry.OpenKey(rootKeys[root], subkey, registry.QUERY_VALUE|registry.ENUMERATE_SUB_KEYS|registry.WOW64_64KEY)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return k, nil
+}
+
+// readValue reads a value of an open key
+func readValue(k registry.Key, name string) (RegistryValue, error) {
+	_, kind, err := k.GetValue(name, nil)
+	if err != nil {
+		return RegistryValue{}, err
+	}
+	v := RegistryValue{Name: name}
+	switch kind {
+	case registry.SZ, registry.EXPAND_SZ:
+		v.Type = map[uint32]string{registry.SZ: "REG_SZ", registry.EXPAND_SZ: "REG_EXPAND_SZ"}[kind]
+		v.Data, _, err = k.GetStringValue(name)
+	case registry.DWORD, registry.QWORD:
+		v.Type = map[uint32]string{registry.DWORD: "REG_DWORD", registry.QWORD: "REG_QWORD"}[kind]
+		var n uint64
+		n, _, err = k.GetIntegerValue(name)
+		v.Data = int64(n)
+	case registry.MULTI_SZ:
+		v.Type = "REG_MULTI_SZ"
+		v.Data, _, err = k.GetStringsValue(name)
+	default:
+		v.Type = "REG_BINARY"
+		v.Data, _, err = k.GetBinaryValue(name)
+	}
+	return v, err
+}
+
+// RegistryRead reads a value of a registry key; an empty name reads the
+// key's default value
+func RegistryRead(path, name string) (RegistryValue, error) {
+	k, err := openKey(path)
+	if err != nil {
+		return RegistryValue{}, err
+	}
+	defer k.Close()
+	v, err := readValue(k, name)
+	if err != nil {
+		return v, fmt.Errorf(`%s\%s: %v`, path, name, err)
+	}
+	return v, nil
+}
+
+// RegistryEnum lists the subkeys and values of a registry key
+func RegistryEnum(path string) (RegistryKey, error) {
+	k, err := openKey(path)
+	if err != nil {
+		return RegistryKey{}, err
+	}
+	defer k.Close()
+	key := RegistryKey{Path: path}
+	if key.Keys, err = k.ReadSubKeyNames(-1); err != nil {
+		return key, fmt.Errorf("%s: %v", path, err)
+	}
+	names, err := k.ReadValueNames(-1)
+	if err != nil {
+		return key, fmt.Errorf("%s: %v", path, err)
+	}
+	sort.Strings(key.Keys)
+	sort.Strings(names)
+	for _, name := range names {
+		if v, err := readValue(k, name); err == nil {
+			key.Values = append(key.Values, v)
+		}
+	}
+	return key, nil
+}
+
+// serviceStartTypes and serviceTypes name the start types and types of
+// services
+var serviceStartTypes = map[uint32]string{
+	windows.SERVICE_BOOT_START:   "boot",
+	windows.SERVICE_SYSTEM_START: "system",
+	windows.SERVICE_AUTO_START:   "auto",
+	windows.SERVICE_DEMAND_START: "demand",
+	windows.SERVICE_DISABLED:     "disabled",
+}
+
+var serviceTypes = map[uint32]string{
+	windows.SERVICE_KERNEL_DRIVER:       "kernel_driver",
+	windows.SERVICE_FILE_SYSTEM_DRIVER:  "file_system_driver",
+	windows.SERVICE_WIN32_OWN_PROCESS:   "own_process",
+	windows.SERVICE_WIN32_SHARE_PROCESS: "share_process",
+}
+
+var serviceStates = map[svc.State]string{
+	svc.Stopped:         "stopped",
+	svc.StartPending:    "start_pending",
+	svc.StopPending:     "stop_pending",
+	svc.Running:         "running",
+	svc.ContinuePending: "continue_pending",
+	svc.PausePending:    "pause_pending",
+	svc.Paused:          "paused",
+}
+
+// Services returns the configuration of every service. The service
+// manager is opened with only the rights to read it, which any user has.
+func Services() ([]ServiceConfig, error) {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT|windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the service manager: %v", err)
+	}
+	m := &mgr.Mgr{Handle: h}
+	defer m.Disconnect()
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	services := []ServiceConfig{}
+	for _, name := range names {
+		name16, err := windows.UTF16PtrFromString(name)
+		if err != nil {
+			continue
+		}
+		sh, err := windows.OpenService(h, name16, windows.SERVICE_QUERY_CONFIG|windows.SERVICE_QUERY_STATUS|windows.READ_CONTROL)
+		if err != nil {
+			continue
+		}
+		s := &mgr.Service{Name: name, Handle: sh}
+		config, err := s.Config()
+		if err != nil {
+			s.Close()
+			continue
+		}
+		sc := ServiceConfig{
+			Name:        name,
+			DisplayName: config.DisplayName,
+			BinaryPath:  config.BinaryPathName,
+			Executable:  ServiceExecutable(config.BinaryPathName),
+			StartType:   serviceStartTypes[config.StartType],
+			Type:        serviceTypes[config.ServiceType&^windows.SERVICE_INTERACTIVE_PROCESS],
+			Account:     config.ServiceStartName,
+		}
+		if sc.Type == "" {
+			sc.Type = fmt.Sprintf("0x%x", config.ServiceType)
+		}
+		if status, err := s.Query(); err == nil {
+			sc.State = serviceStates[status.State]
+		}
+		if sd, err := windows.GetSecurityInfo(sh, windows.SE_SERVICE, windows.DACL_SECURITY_INFORMATION); err == nil {
+			sc.ServiceSDDL = sd.String()
+		}
+		s.Close()
+		services = append(services, sc)
+	}
+	return services, nil
+}
+
+// fileSDDL returns the DACL of a file or directory in SDDL, empty if it
+// cannot be read
+func fileSDDL(path string) string {
+	if path == "" {
+		return ""
+	}
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return ""
+	}
+	return sd.String()
+}
+
+// AuditServices checks every service for an unquoted path and for a
+// service object, program or program directory that unprivileged users
+// can change
+func AuditServices() ([]ServiceFinding, error) {
+	services, err := Services()
+	if err != nil {
+		return nil, err
+	}
+	findings := []ServiceFinding{}
+	for _, s := range services {
+		findings = append(findings, AuditService(s, fileSDDL(s.Executable), fileSDDL(dirOf(s.Executable)))...)
+	}
+	return findings, nil
+}
+
+// StartupItems returns what runs at boot or logon from the Run keys, the
+// Winlogon key and the startup folders
+func StartupItems() ([]StartupItem, error) {
+	items := []StartupItem{}
+	for _, loc := range StartupKeys {
+		key, err := RegistryEnum(loc.Path)
+		if err != nil {
+			// Most of the keys need not exist
+			continue
+		}
+		for _, v := range key.Values {
+			command, ok := v.Data.(string)
+			if !ok {
+				continue
+			}
+			items = append(items, StartupItem{Name: v.Name, Command: command, Location: loc.Path, Scope: loc.Scope, Executable: ServiceExecutable(command)})
+		}
+	}
+
+	winlogon := `HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Winlogon`
+	for name, def := range winlogonDefaults {
+		v, err := RegistryRead(winlogon, name)
+		command, ok := v.Data.(string)
+		if err != nil || !ok || strings.EqualFold(strings.TrimSpace(command), def) {
+			continue
+		}
+		items = append(items, StartupItem{Name: name, Command: command, Location: winlogon, Scope: "machine", Executable: ServiceExecutable(command)})
+	}
+
+	for _, folder := range []struct{ dir, scope string }{
+		{filepath.Join(os.Getenv("ProgramData"), `Microsoft\Windows\Start Menu\Programs\StartUp`), "machine"},
+		{filepath.Join(os.Getenv("APPDATA"), `Microsoft\Windows\Start Menu\Programs\Startup`), "user"},
+	} {
+		entries, err := os.ReadDir(folder.dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.EqualFold(e.Name(), "desktop.ini") {
+				continue
+			}
+			path := filepath.Join(folder.dir, e.Name())
+			items = append(items, StartupItem{Name: e.Name(), Command: path, Location: folder.dir, Scope: folder.scope, Executable: path})
+		}
+	}
+	return items, nil
+}
+
+// LocalSecurityPolicy exports the local security policy and user rights
+// with secedit, which needs an elevated process
+func LocalSecurityPolicy() (SecurityPolicy, error) {
+	dir, err := os.MkdirTemp("", "sentra-secpol")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	cfg := filepath.Join(dir, "secpol.inf")
+	out, err := exec.Command("secedit", "/export", "/cfg", cfg, "/areas", "SECURITYPOLICY", "USER_RIGHTS", "/quiet").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("secedit: %v: %s (it needs an elevated process)", err, strings.TrimSpace(string(out)))
+	}
+	data, err := os.ReadFile(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("secedit wrote no policy: %v", err)
+	}
+	return ParseSecurityPolicy(data)
+}
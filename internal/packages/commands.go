@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"compress/gzip"
 	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -15,7 +16,9 @@ import (
 type PackageManager struct {
 	cache    *ModuleCache
 	resolver *ImportResolver
+	registry *RegistryClient
 	workDir  string
+	locked   bool
 }
 
 // NewPackageManager creates a new package manager
@@ -27,13 +30,24 @@ func NewPackageManager(workDir string) *PackageManager {
 		workDir, _ = os.Getwd()
 	}
 	
+	registry := LoadRegistryClient(workDir)
+	cache.SetRegistryClient(registry)
+	
 	return &PackageManager{
 		cache:    cache,
 		resolver: resolver,
+		registry: registry,
 		workDir:  workDir,
+		locked:   os.Getenv("CI") != "" && os.Getenv("CI") != "false",
 	}
 }
 
+// SetLocked makes DownloadDependencies fail on any module sentra.lock does
+// not already pin, instead of recording it. It is on by default in CI.
+func (pm *PackageManager) SetLocked(locked bool) {
+	pm.locked = locked
+}
+
 // InitModule initializes a new Sentra module
 func (pm *PackageManager) InitModule(modulePath string) error {
 	if modulePath == "" {
@@ -97,20 +111,36 @@ func (pm *PackageManager) GetPackage(packagePath string, version string) error {
 		return fmt.Errorf("failed to fetch package: %w", err)
 	}
 	
+	// Resolve dependencies
+	deps, err := pm.cache.ResolveDependencies(cached.Module)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+	
+	// Verify and record checksums before touching sentra.mod
+	lock, err := pm.loadLockFile()
+	if err != nil {
+		return err
+	}
+	for _, m := range append([]*CachedModule{cached}, deps...) {
+		hash, _, err := lock.VerifyModule(m)
+		if err != nil {
+			return err
+		}
+		lock.Set(LockedPackage{Path: m.Path, Version: m.Version, Hash: hash})
+	}
+	
 	// Update module file
 	if err := WriteModFile(modFile, mod); err != nil {
 		return fmt.Errorf("failed to update sentra.mod: %w", err)
 	}
+	if err := WriteLockFile(pm.lockPath(), lock); err != nil {
+		return fmt.Errorf("failed to write %s: %w", LockFileName, err)
+	}
 	
 	fmt.Printf("Added %s %s\n", packagePath, version)
 	fmt.Printf("Downloaded to: %s\n", cached.SourceDir)
 	
-	// Resolve dependencies
-	deps, err := pm.cache.ResolveDependencies(cached.Module)
-	if err != nil {
-		return fmt.Errorf("failed to resolve dependencies: %w", err)
-	}
-	
 	if len(deps) > 0 {
 		fmt.Printf("Downloaded %d dependencies\n", len(deps))
 	}
@@ -118,6 +148,24 @@ func (pm *PackageManager) GetPackage(packagePath string, version string) error {
 	return nil
 }
 
+// lockPath returns the path of the project's sentra.lock
+func (pm *PackageManager) lockPath() string {
+	return filepath.Join(pm.workDir, LockFileName)
+}
+
+// loadLockFile reads sentra.lock, returning an empty lockfile if none
+// exists. A lockfile that cannot be read stops the install.
+func (pm *PackageManager) loadLockFile() (*LockFile, error) {
+	lock, err := ReadLockFile(pm.lockPath())
+	if os.IsNotExist(err) {
+		return &LockFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", LockFileName, err)
+	}
+	return lock, nil
+}
+
 // UpdatePackages updates all or specified packages
 func (pm *PackageManager) UpdatePackages(packages []string) error {
 	// Load current module
@@ -145,6 +193,10 @@ func (pm *PackageManager) UpdatePackages(packages []string) error {
 	
 	// Update each package
 	updated := 0
+	lock, err := pm.loadLockFile()
+	if err != nil {
+		return err
+	}
 	for _, req := range toUpdate {
 		fmt.Printf("Updating %s...\n", req.Path)
 		
@@ -155,6 +207,14 @@ func (pm *PackageManager) UpdatePackages(packages []string) error {
 			continue
 		}
 		
+		// An update intentionally changes content, so record the new hash
+		hash, err := HashDir(cached.SourceDir)
+		if err != nil {
+			fmt.Printf("  Failed: %v\n", err)
+			continue
+		}
+		lock.Set(LockedPackage{Path: cached.Path, Version: cached.Version, Hash: hash})
+		
 		// Update version in requirements
 		for i, r := range mod.Require {
 			if r.Path == req.Path {
@@ -171,6 +231,9 @@ func (pm *PackageManager) UpdatePackages(packages []string) error {
 		if err := WriteModFile(modFile, mod); err != nil {
 			return fmt.Errorf("failed to update sentra.mod: %w", err)
 		}
+		if err := WriteLockFile(pm.lockPath(), lock); err != nil {
+			return fmt.Errorf("failed to write %s: %w", LockFileName, err)
+		}
 		fmt.Printf("Updated %d packages\n", updated)
 	} else {
 		fmt.Println("All packages are up to date")
@@ -194,10 +257,42 @@ func (pm *PackageManager) DownloadDependencies() error {
 		return fmt.Errorf("failed to resolve dependencies: %w", err)
 	}
 	
+	// Every download must match sentra.lock; new entries are recorded
+	// unless the install is locked
+	lock, err := pm.loadLockFile()
+	if err != nil {
+		return err
+	}
+	added := 0
+	for _, dep := range deps {
+		if pm.locked {
+			if _, err := lock.VerifyLocked(dep); err != nil {
+				return err
+			}
+			continue
+		}
+		hash, locked, err := lock.VerifyModule(dep)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			lock.Set(LockedPackage{Path: dep.Path, Version: dep.Version, Hash: hash})
+			added++
+		}
+	}
+	if added > 0 {
+		if err := WriteLockFile(pm.lockPath(), lock); err != nil {
+			return fmt.Errorf("failed to write %s: %w", LockFileName, err)
+		}
+	}
+	
 	fmt.Printf("Downloaded %d modules\n", len(deps))
 	for _, dep := range deps {
 		fmt.Printf("  %s@%s\n", dep.Path, dep.Version)
 	}
+	if added > 0 {
+		fmt.Printf("Recorded %d new checksums in %s\n", added, LockFileName)
+	}
 	
 	return nil
 }
@@ -262,10 +357,33 @@ func (pm *PackageManager) TidyModules() error {
 	// Update module
 	mod.Require = newRequirements
 	
+	// Rebuild the lockfile from the tidied requirement graph
+	deps, err := pm.cache.ResolveDependencies(mod)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+	lock, err := pm.loadLockFile()
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, dep := range deps {
+		hash, _, err := lock.VerifyModule(dep)
+		if err != nil {
+			return err
+		}
+		lock.Set(LockedPackage{Path: dep.Path, Version: dep.Version, Hash: hash})
+		keep[dep.Path] = true
+	}
+	lock.Retain(keep)
+	
 	// Write updated module file
 	if err := WriteModFile(modFile, mod); err != nil {
 		return fmt.Errorf("failed to update sentra.mod: %w", err)
 	}
+	if err := WriteLockFile(pm.lockPath(), lock); err != nil {
+		return fmt.Errorf("failed to write %s: %w", LockFileName, err)
+	}
 	
 	fmt.Println("Module dependencies tidied")
 	return nil
@@ -349,6 +467,183 @@ func (pm *PackageManager) ListPackages() error {
 	return nil
 }
 
+// SearchPackages searches all configured registries
+func (pm *PackageManager) SearchPackages(query string) error {
+	results, err := pm.registry.Search(query)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Printf("No packages found matching '%s'\n", query)
+		return nil
+	}
+	for _, pkg := range results {
+		fmt.Printf("%-45s %-10s [%s]\n", pkg.Path, pkg.Version, pkg.Registry)
+		if pkg.Description != "" {
+			fmt.Printf("    %s\n", pkg.Description)
+		}
+	}
+	return nil
+}
+
+// ShowPackageInfo prints registry metadata for a package
+func (pm *PackageManager) ShowPackageInfo(pkgPath string) error {
+	pkg, err := pm.registry.Info(pkgPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Package:     %s\n", pkg.Path)
+	fmt.Printf("Version:     %s\n", pkg.Version)
+	fmt.Printf("Registry:    %s\n", pkg.Registry)
+	if pkg.Description != "" {
+		fmt.Printf("Description: %s\n", pkg.Description)
+	}
+	if pkg.Author != "" {
+		fmt.Printf("Author:      %s\n", pkg.Author)
+	}
+	if pkg.License != "" {
+		fmt.Printf("License:     %s\n", pkg.License)
+	}
+	if len(pkg.Versions) > 0 {
+		fmt.Printf("Versions:    %s\n", strings.Join(pkg.Versions, ", "))
+	}
+	return nil
+}
+
+// PublishPackage archives the current module and uploads it to a registry.
+// An empty registry name publishes to the highest-priority registry.
+func (pm *PackageManager) PublishPackage(registryName string) error {
+	mod, err := ParseModFile(filepath.Join(pm.workDir, "sentra.mod"))
+	if err != nil {
+		return fmt.Errorf("failed to parse sentra.mod: %w", err)
+	}
+	
+	version := ""
+	description := ""
+	if cfg, err := ParseProjectFile(filepath.Join(pm.workDir, "sentra.toml")); err == nil {
+		version = cfg.Version
+		description = cfg.Description
+	}
+	if version == "" {
+		return fmt.Errorf("sentra.toml must set [project] version before publishing")
+	}
+	
+	// The archive includes sentra.toml, so it must not carry secrets
+	for _, reg := range registriesFromFile(filepath.Join(pm.workDir, "sentra.toml"), "project") {
+		if reg.Token != "" {
+			return fmt.Errorf("sentra.toml contains an inline token for registry %s; use ~/.sentra/registries.toml or 'sentra pkg login' instead", reg.Name)
+		}
+	}
+	
+	var reg *Registry
+	if registryName != "" {
+		reg = pm.registry.Find(registryName)
+		if reg == nil {
+			return fmt.Errorf("unknown registry: %s", registryName)
+		}
+	} else if len(pm.registry.Registries) > 0 {
+		reg = pm.registry.Registries[0]
+	} else {
+		return fmt.Errorf("no registry configured")
+	}
+	
+	archive, err := archiveDir(pm.workDir)
+	if err != nil {
+		return fmt.Errorf("failed to archive package: %w", err)
+	}
+	
+	pkg := RegistryPackage{
+		Path:        mod.Module,
+		Version:     version,
+		Description: description,
+	}
+	if err := pm.registry.Publish(reg, pkg, archive); err != nil {
+		return err
+	}
+	
+	fmt.Printf("Published %s@%s to %s (%s)\n", pkg.Path, pkg.Version, reg.Name, reg.URL)
+	return nil
+}
+
+// Login stores a registry token in the user's credentials file
+func (pm *PackageManager) Login(registryName, token string) error {
+	registryURL := registryName
+	if reg := pm.registry.Find(registryName); reg != nil {
+		registryURL = reg.URL
+	} else if !strings.HasPrefix(registryName, "http://") && !strings.HasPrefix(registryName, "https://") {
+		return fmt.Errorf("unknown registry: %s (use its name from sentra.toml or a URL)", registryName)
+	}
+	if err := SaveCredential(registryURL, token); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	fmt.Printf("Saved token for %s\n", registryURL)
+	return nil
+}
+
+// ListRegistries prints configured registries in resolution order
+func (pm *PackageManager) ListRegistries() error {
+	for i, reg := range pm.registry.Registries {
+		auth := "anonymous"
+		if reg.Token != "" {
+			auth = "token"
+		}
+		fmt.Printf("%d. %-12s %-45s [%s, %s]\n", i+1, reg.Name, reg.URL, reg.Source, auth)
+	}
+	return nil
+}
+
+// archiveDir packs a package directory as tar.gz, skipping vendor/ and
+// hidden directories
+func archiveDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "vendor" || strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // scanImports scans source files for import statements
 func (pm *PackageManager) scanImports(dir string) (map[string]bool, error) {
 	imports := make(map[string]bool)
@@ -466,7 +761,16 @@ func copyFile(src, dst string) error {
 
 // Implement the actual extraction functions that were placeholders
 
-// extractZip extracts a ZIP archive
+// archivePath returns where an archive entry extracts to under dest,
+// refusing names such as ../evil or /etc/passwd that lead out of it
+func archivePath(dest, name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("archive entry %q is outside the package directory", name)
+	}
+	return filepath.Join(dest, name), nil
+}
+
+// extractZip extracts a ZIP archive. Symlinks are skipped.
 func extractZip(src, dest string) error {
 	reader, err := zip.OpenReader(src)
 	if err != nil {
@@ -475,7 +779,13 @@ func extractZip(src, dest string) error {
 	defer reader.Close()
 	
 	for _, file := range reader.File {
-		path := filepath.Join(dest, file.Name)
+		path, err := archivePath(dest, file.Name)
+		if err != nil {
+			return err
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
 		
 		if file.FileInfo().IsDir() {
 			os.MkdirAll(path, file.Mode())
@@ -507,7 +817,8 @@ func extractZip(src, dest string) error {
 	return nil
 }
 
-// extractTarGz extracts a TAR.GZ archive
+// extractTarGz extracts a TAR.GZ archive. Only directories and regular
+// files are extracted, so links cannot point outside dest.
 func extractTarGz(src, dest string) error {
 	file, err := os.Open(src)
 	if err != nil {
@@ -532,7 +843,10 @@ func extractTarGz(src, dest string) error {
 			return err
 		}
 		
-		path := filepath.Join(dest, header.Name)
+		path, err := archivePath(dest, header.Name)
+		if err != nil {
+			return err
+		}
 		
 		switch header.Typeflag {
 		case tar.TypeDir:
@@ -0,0 +1,71 @@
+package packages
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTarGz writes a gzipped tarball of the given headers, with a body
+// for regular files
+func writeTarGz(t *testing.T, path string, headers ...*tar.Header) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, h := range headers {
+		body := ""
+		if h.Typeflag == tar.TypeReg {
+			body = "let x = 1\n"
+			h.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	tw.Close()
+	gz.Close()
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "pkg")
+	os.Mkdir(dest, 0755)
+
+	archive := filepath.Join(dir, "evil.tar.gz")
+	writeTarGz(t, archive,
+		&tar.Header{Name: "main.sn", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	err := extractTarGz(archive, dest)
+	if err == nil || !strings.Contains(err.Error(), `"../evil"`) {
+		t.Errorf("escaping entry: got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("../evil was written: %v", err)
+	}
+
+	archive = filepath.Join(dir, "links.tar.gz")
+	writeTarGz(t, archive,
+		&tar.Header{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "lib/passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		&tar.Header{Name: "lib/util.sn", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	if err := extractTarGz(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "lib", "passwd")); !os.IsNotExist(err) {
+		t.Errorf("symlink was extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "lib", "util.sn")); err != nil {
+		t.Errorf("regular file: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package packages
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LockFileName is the name of the lockfile written next to sentra.mod
+const LockFileName = "sentra.lock"
+
+// LockedPackage pins a dependency to an exact version and content hash
+type LockedPackage struct {
+	Path    string
+	Version string
+	Hash    string // "h1:" + base64(sha256) over the package file tree
+}
+
+// LockFile represents a sentra.lock file
+type LockFile struct {
+	Packages []LockedPackage
+}
+
+// ChecksumMismatchError reports a downloaded package whose content does not
+// match the hash recorded in sentra.lock
+type ChecksumMismatchError struct {
+	Path     string
+	Version  string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s@%s\n\tsentra.lock: %s\n\tdownloaded:  %s",
+		e.Path, e.Version, e.Expected, e.Actual)
+}
+
+// NotLockedError reports a module that sentra.lock does not pin at the
+// version being installed, which a locked install refuses
+type NotLockedError struct {
+	Path    string
+	Version string
+	Locked  string // The version sentra.lock pins, empty if none
+}
+
+func (e *NotLockedError) Error() string {
+	if e.Locked == "" {
+		return fmt.Sprintf("%s@%s is not in %s", e.Path, e.Version, LockFileName)
+	}
+	return fmt.Sprintf("%s@%s is locked at %s in %s", e.Path, e.Version, e.Locked, LockFileName)
+}
+
+// ReadLockFile parses a sentra.lock file.
+// Each non-comment line has the form: <path> <version> <hash>
+func ReadLockFile(path string) (*LockFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lock := &LockFile{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed lock entry", path, lineNum)
+		}
+		lock.Packages = append(lock.Packages, LockedPackage{
+			Path:    parts[0],
+			Version: parts[1],
+			Hash:    parts[2],
+		})
+	}
+	return lock, scanner.Err()
+}
+
+// WriteLockFile writes a lockfile with entries sorted by path and version
+func WriteLockFile(path string, lock *LockFile) error {
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		if lock.Packages[i].Path != lock.Packages[j].Path {
+			return lock.Packages[i].Path < lock.Packages[j].Path
+		}
+		return lock.Packages[i].Version < lock.Packages[j].Version
+	})
+
+	var b strings.Builder
+	b.WriteString("# sentra.lock - generated by sentra, do not edit\n")
+	for _, pkg := range lock.Packages {
+		fmt.Fprintf(&b, "%s %s %s\n", pkg.Path, pkg.Version, pkg.Hash)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// Lookup returns the locked entry for a package path
+func (l *LockFile) Lookup(path string) (LockedPackage, bool) {
+	for _, pkg := range l.Packages {
+		if pkg.Path == path {
+			return pkg, true
+		}
+	}
+	return LockedPackage{}, false
+}
+
+// Set adds or replaces the entry for a package path
+func (l *LockFile) Set(pkg LockedPackage) {
+	for i := range l.Packages {
+		if l.Packages[i].Path == pkg.Path {
+			l.Packages[i] = pkg
+			return
+		}
+	}
+	l.Packages = append(l.Packages, pkg)
+}
+
+// Retain drops entries whose path is not in keep
+func (l *LockFile) Retain(keep map[string]bool) {
+	kept := l.Packages[:0]
+	for _, pkg := range l.Packages {
+		if keep[pkg.Path] {
+			kept = append(kept, pkg)
+		}
+	}
+	l.Packages = kept
+}
+
+// HashDir computes a deterministic content hash of a package directory.
+// Every regular file contributes "sha256(content)  relative/path" and the
+// sorted list is hashed again, so renames and edits both change the result.
+// Symlinks are an error: extraction never creates them, and one would let
+// a package's content change without changing its hash.
+func HashDir(dir string) (string, error) {
+	var lines []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: symlinks are not allowed in a package", filepath.ToSlash(rel))
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		// The archive left behind by downloadAndExtract is not package content
+		if info.Name() == "download.tmp" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	summary := sha256.New()
+	for _, line := range lines {
+		summary.Write([]byte(line))
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// VerifyModule checks a fetched module against the lockfile.
+// Returns the computed hash and whether the module was already locked.
+func (l *LockFile) VerifyModule(cached *CachedModule) (string, bool, error) {
+	hash, err := HashDir(cached.SourceDir)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to hash %s: %w", cached.Path, err)
+	}
+	locked, ok := l.Lookup(cached.Path)
+	if !ok || locked.Version != cached.Version {
+		return hash, false, nil
+	}
+	if locked.Hash != hash {
+		return hash, true, &ChecksumMismatchError{
+			Path:     cached.Path,
+			Version:  cached.Version,
+			Expected: locked.Hash,
+			Actual:   hash,
+		}
+	}
+	return hash, true, nil
+}
+
+// VerifyLocked checks a fetched module against the lockfile like
+// VerifyModule, but also fails if the lockfile does not pin it at the
+// fetched version
+func (l *LockFile) VerifyLocked(cached *CachedModule) (string, error) {
+	hash, locked, err := l.VerifyModule(cached)
+	if err != nil {
+		return hash, err
+	}
+	if !locked {
+		pkg, _ := l.Lookup(cached.Path)
+		return hash, &NotLockedError{Path: cached.Path, Version: cached.Version, Locked: pkg.Version}
+	}
+	return hash, nil
+}
@@ -0,0 +1,44 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyLocked(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.sn"), []byte("let x = 1\n"), 0644)
+	hash, err := HashDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := &LockFile{Packages: []LockedPackage{{Path: "example.com/lib", Version: "v1.0.0", Hash: hash}}}
+
+	if _, err := lock.VerifyLocked(&CachedModule{Path: "example.com/lib", Version: "v1.0.0", SourceDir: dir}); err != nil {
+		t.Errorf("locked module: %v", err)
+	}
+	var notLocked *NotLockedError
+	_, err = lock.VerifyLocked(&CachedModule{Path: "example.com/lib", Version: "v1.1.0", SourceDir: dir})
+	if !errors.As(err, &notLocked) || err.Error() != "example.com/lib@v1.1.0 is locked at v1.0.0 in sentra.lock" {
+		t.Errorf("other version: got %v", err)
+	}
+	_, err = lock.VerifyLocked(&CachedModule{Path: "example.com/other", Version: "v1.0.0", SourceDir: dir})
+	if !errors.As(err, &notLocked) || err.Error() != "example.com/other@v1.0.0 is not in sentra.lock" {
+		t.Errorf("missing module: got %v", err)
+	}
+}
+
+func TestHashDirSymlink(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.sn"), []byte("let x = 1\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "lib"), 0755)
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "lib", "passwd")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	_, err := HashDir(dir)
+	if err == nil || err.Error() != "lib/passwd: symlinks are not allowed in a package" {
+		t.Errorf("got %v", err)
+	}
+}
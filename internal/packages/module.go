@@ -46,8 +46,9 @@ type ModuleMetadata struct {
 
 // ModuleCache manages downloaded modules
 type ModuleCache struct {
-	BaseDir string
-	modules map[string]*CachedModule
+	BaseDir  string
+	modules  map[string]*CachedModule
+	registry *RegistryClient // Optional: serves non-GitHub package paths
 }
 
 // CachedModule represents a cached module
@@ -72,6 +73,11 @@ func NewModuleCache(baseDir string) *ModuleCache {
 	}
 }
 
+// SetRegistryClient enables fetching registry-hosted packages
+func (mc *ModuleCache) SetRegistryClient(client *RegistryClient) {
+	mc.registry = client
+}
+
 // ParseModFile parses a sentra.mod file
 func ParseModFile(path string) (*Module, error) {
 	file, err := os.Open(path)
@@ -215,7 +221,7 @@ func WriteModFile(path string, mod *Module) error {
 			for _, req := range mod.Require {
 				fmt.Fprintf(writer, "\t%s %s\n", req.Path, req.Version)
 			}
-			fmt.Fprintln(writer, ")\n")
+			fmt.Fprint(writer, ")\n\n")
 		}
 	}
 	
@@ -229,7 +235,7 @@ func WriteModFile(path string, mod *Module) error {
 				fmt.Fprintf(writer, "\t%s => %s\n", old, repl.New)
 			}
 		}
-		fmt.Fprintln(writer, ")\n")
+		fmt.Fprint(writer, ")\n\n")
 	}
 	
 	// Write excludes
@@ -268,6 +274,8 @@ func (mc *ModuleCache) FetchModule(path, version string) (*CachedModule, error)
 		}
 	} else if strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://") {
 		sourceURL = path
+	} else if mc.registry != nil && !isLocalPath(path) {
+		return mc.fetchFromRegistry(path, version)
 	} else {
 		// Local path
 		return mc.loadLocalModule(path, version)
@@ -307,6 +315,57 @@ func (mc *ModuleCache) FetchModule(path, version string) (*CachedModule, error)
 	return cached, nil
 }
 
+// fetchFromRegistry downloads a package archive from the configured registries
+func (mc *ModuleCache) fetchFromRegistry(path, version string) (*CachedModule, error) {
+	destDir := filepath.Join(mc.BaseDir, strings.ReplaceAll(path, "/", "_"), version)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+	
+	tempFile := filepath.Join(destDir, "download.tmp")
+	out, err := os.Create(tempFile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = mc.registry.Download(path, version, out)
+	out.Close()
+	if err != nil {
+		os.Remove(tempFile)
+		return nil, err
+	}
+	if err := extractTarGz(tempFile, destDir); err != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	os.Remove(tempFile)
+	
+	mod, err := ParseModFile(filepath.Join(destDir, "sentra.mod"))
+	if err != nil {
+		mod = &Module{
+			Module: path,
+			Sentra: "1.0",
+		}
+	}
+	
+	cached := &CachedModule{
+		Path:      path,
+		Version:   version,
+		Module:    mod,
+		LoadTime:  time.Now(),
+		SourceDir: destDir,
+	}
+	mc.modules[fmt.Sprintf("%s@%s", path, version)] = cached
+	return cached, nil
+}
+
+// isLocalPath reports whether a module path refers to the local filesystem
+func isLocalPath(path string) bool {
+	if strings.HasPrefix(path, ".") || filepath.IsAbs(path) {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // downloadAndExtract downloads and extracts a module archive
 func (mc *ModuleCache) downloadAndExtract(url, destDir string) error {
 	// Create destination directory
@@ -0,0 +1,359 @@
+package packages
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProjectConfig represents a sentra.toml project file
+type ProjectConfig struct {
+	Name         string
+	Version      string
+	Description  string
+	Main         string
+	Output       string
+	Dependencies map[string]string // package path -> version
+	Sections     map[string]map[string]string
+	SectionOrder []string // Section names in file order
+}
+
+// ParseProjectFile parses a sentra.toml file.
+// Only the subset of TOML used by Sentra projects is supported:
+// [section] headers and key = "value" pairs.
+func ParseProjectFile(path string) (*ProjectConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open project file: %w", err)
+	}
+	defer file.Close()
+
+	cfg := &ProjectConfig{
+		Dependencies: make(map[string]string),
+		Sections:     make(map[string]map[string]string),
+	}
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Section header
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if cfg.Sections[section] == nil {
+				cfg.Sections[section] = make(map[string]string)
+				cfg.SectionOrder = append(cfg.SectionOrder, section)
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		key := unquoteTOML(strings.TrimSpace(line[:eq]))
+		value := strings.TrimSpace(line[eq+1:])
+		// Strip trailing comments outside of quotes
+		if !strings.HasPrefix(value, "\"") {
+			if idx := strings.Index(value, "#"); idx != -1 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		} else if end := strings.Index(value[1:], "\""); end != -1 {
+			value = value[:end+2]
+		}
+		value = unquoteTOML(value)
+
+		if cfg.Sections[section] == nil {
+			cfg.Sections[section] = make(map[string]string)
+		}
+		cfg.Sections[section][key] = value
+
+		switch section {
+		case "project", "package":
+			switch key {
+			case "name":
+				cfg.Name = value
+			case "version":
+				cfg.Version = value
+			case "description":
+				cfg.Description = value
+			}
+		case "build":
+			switch key {
+			case "main":
+				cfg.Main = value
+			case "output":
+				cfg.Output = value
+			}
+		case "dependencies":
+			cfg.Dependencies[key] = value
+		}
+	}
+
+	return cfg, scanner.Err()
+}
+
+// unquoteTOML removes surrounding quotes from a TOML string value
+func unquoteTOML(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// FindProjectRoot walks up from dir looking for sentra.toml or sentra.mod.
+// Returns an empty string if no project file is found.
+func FindProjectRoot(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range []string{"sentra.toml", "sentra.mod"} {
+			if _, err := os.Stat(filepath.Join(absDir, name)); err == nil {
+				return absDir
+			}
+		}
+		parent := filepath.Dir(absDir)
+		if parent == absDir {
+			return ""
+		}
+		absDir = parent
+	}
+}
+
+// PackageRoot maps an installed package to the directory holding its sources
+type PackageRoot struct {
+	Path    string // Package path, e.g. github.com/user/pkg
+	Version string
+	Dir     string // Directory containing the package sources
+}
+
+// ModulePathResolver maps import paths onto installed package sources
+// using the project's sentra.toml/sentra.mod requirements
+type ModulePathResolver struct {
+	ProjectRoot string
+	cache       *ModuleCache
+	roots       []PackageRoot // Sorted by descending path length
+	err         error
+}
+
+// NewModulePathResolver creates a resolver for the project containing dir.
+// If no project file is found, dir is treated as the project root.
+func NewModulePathResolver(dir string) *ModulePathResolver {
+	root := FindProjectRoot(dir)
+	if root == "" {
+		root, _ = filepath.Abs(dir)
+	}
+	r := &ModulePathResolver{
+		ProjectRoot: root,
+		cache:       NewModuleCache(""),
+	}
+	r.loadRoots()
+	return r
+}
+
+// Roots returns the resolved package roots
+func (r *ModulePathResolver) Roots() []PackageRoot {
+	return r.roots
+}
+
+// Err returns why sentra.lock could not be read or a package on disk does
+// not match its locked hash. Such packages are left out of Roots.
+func (r *ModulePathResolver) Err() error {
+	return r.err
+}
+
+// loadRoots collects requirements and locates each package on disk,
+// checking the content of every locked package against sentra.lock
+func (r *ModulePathResolver) loadRoots() {
+	requirements := make(map[string]string)
+	replacements := make(map[string]Replacement)
+
+	if mod, err := ParseModFile(filepath.Join(r.ProjectRoot, "sentra.mod")); err == nil {
+		for _, req := range mod.Require {
+			requirements[req.Path] = req.Version
+		}
+		for old, repl := range mod.Replace {
+			replacements[old] = repl
+		}
+	}
+	if cfg, err := ParseProjectFile(filepath.Join(r.ProjectRoot, "sentra.toml")); err == nil {
+		for path, version := range cfg.Dependencies {
+			if _, ok := requirements[path]; !ok {
+				requirements[path] = version
+			}
+		}
+	}
+
+	// Locked versions win over the ranges written in project files
+	lock, err := ReadLockFile(filepath.Join(r.ProjectRoot, LockFileName))
+	if err != nil && !os.IsNotExist(err) {
+		r.err = err
+		return
+	}
+	locked := make(map[string]LockedPackage)
+	if lock != nil {
+		for _, pkg := range lock.Packages {
+			if _, ok := requirements[pkg.Path]; ok {
+				requirements[pkg.Path] = pkg.Version
+				locked[pkg.Path] = pkg
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(requirements))
+	for path := range requirements {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		version := requirements[path]
+		dir, content := r.locate(path, version, replacements)
+		if dir == "" {
+			continue
+		}
+		// A vendored or cached copy edited since it was installed is not used
+		if pkg, ok := locked[path]; ok && content != "" {
+			hash, err := HashDir(content)
+			if err == nil && hash != pkg.Hash {
+				err = &ChecksumMismatchError{Path: path, Version: version, Expected: pkg.Hash, Actual: hash}
+			}
+			if err != nil {
+				if r.err == nil {
+					r.err = err
+				}
+				continue
+			}
+		}
+		r.roots = append(r.roots, PackageRoot{Path: path, Version: version, Dir: dir})
+	}
+
+	// Longest package path first so nested packages win over their parents
+	sort.Slice(r.roots, func(i, j int) bool {
+		return len(r.roots[i].Path) > len(r.roots[j].Path)
+	})
+}
+
+// locate finds the source directory for a required package and the
+// directory sentra.lock hashes, which is empty for replaced packages.
+// Vendored copies take precedence over the global module cache.
+func (r *ModulePathResolver) locate(path, version string, replacements map[string]Replacement) (string, string) {
+	replaced := false
+	if repl, ok := replacements[path]; ok {
+		// Local replacement directories are used directly
+		replDir := repl.New
+		if !filepath.IsAbs(replDir) {
+			replDir = filepath.Join(r.ProjectRoot, replDir)
+		}
+		if isDir(replDir) {
+			return replDir, ""
+		}
+		path = repl.New
+		if repl.Version != "" {
+			version = repl.Version
+		}
+		replaced = true
+	}
+
+	candidates := []string{
+		filepath.Join(r.ProjectRoot, "vendor", filepath.FromSlash(path)),
+		filepath.Join(r.ProjectRoot, "sentra_modules", filepath.FromSlash(path)),
+		filepath.Join(r.cache.BaseDir, strings.ReplaceAll(path, "/", "_"), version),
+	}
+	for _, dir := range candidates {
+		if isDir(dir) {
+			if replaced {
+				return unwrapArchiveDir(dir), ""
+			}
+			return unwrapArchiveDir(dir), dir
+		}
+	}
+	return "", ""
+}
+
+// Resolve maps an import path such as "github.com/user/pkg/scanner" to a
+// source file. Returns an empty string if the path is not provided by any
+// installed package.
+func (r *ModulePathResolver) Resolve(importPath string) string {
+	for _, root := range r.roots {
+		var sub string
+		if importPath == root.Path {
+			sub = ""
+		} else if strings.HasPrefix(importPath, root.Path+"/") {
+			sub = strings.TrimPrefix(importPath, root.Path+"/")
+		} else {
+			continue
+		}
+
+		if sub == "" {
+			if main := findMainFile(root.Dir); main != "" {
+				return main
+			}
+			continue
+		}
+
+		base := filepath.Join(root.Dir, filepath.FromSlash(sub))
+		for _, candidate := range []string{
+			base,
+			base + ".sn",
+			filepath.Join(base, "index.sn"),
+			filepath.Join(root.Dir, "src", filepath.FromSlash(sub)+".sn"),
+		} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// SearchPaths returns extra module search directories for the project
+func (r *ModulePathResolver) SearchPaths() []string {
+	var paths []string
+	for _, dir := range []string{r.ProjectRoot, filepath.Join(r.ProjectRoot, "lib")} {
+		if isDir(dir) {
+			paths = append(paths, dir)
+		}
+	}
+	return paths
+}
+
+// unwrapArchiveDir descends into the single top-level directory created
+// when extracting GitHub archives (e.g. repo-main/)
+func unwrapArchiveDir(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return dir
+	}
+	var subdirs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			subdirs = append(subdirs, e.Name())
+		} else if e.Name() != "download.tmp" {
+			return dir
+		}
+	}
+	if len(subdirs) == 1 {
+		return filepath.Join(dir, subdirs[0])
+	}
+	return dir
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,49 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolverVerifiesLock(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "sentra.mod"), []byte("module example.com/app\n\nrequire example.com/lib v1.0.0\n"), 0644)
+	vendored := filepath.Join(root, "vendor", "example.com", "lib")
+	os.MkdirAll(vendored, 0755)
+	main := filepath.Join(vendored, "main.sn")
+	os.WriteFile(main, []byte("fn hello() { return 1 }\n"), 0644)
+	hash, err := HashDir(vendored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := &LockFile{Packages: []LockedPackage{{Path: "example.com/lib", Version: "v1.0.0", Hash: hash}}}
+	if err := WriteLockFile(filepath.Join(root, LockFileName), lock); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewModulePathResolver(root)
+	if err := r.Err(); err != nil {
+		t.Fatalf("matching package: %v", err)
+	}
+	if got := r.Resolve("example.com/lib"); got != main {
+		t.Errorf("resolve: got %q, want %q", got, main)
+	}
+
+	// A vendored copy edited after it was locked is refused
+	os.WriteFile(main, []byte("fn hello() { return exec(\"sh\") }\n"), 0644)
+	r = NewModulePathResolver(root)
+	var mismatch *ChecksumMismatchError
+	if !errors.As(r.Err(), &mismatch) || mismatch.Expected != hash {
+		t.Errorf("edited package: got %v", r.Err())
+	}
+	if got := r.Resolve("example.com/lib"); got != "" {
+		t.Errorf("edited package resolved to %q", got)
+	}
+
+	os.WriteFile(filepath.Join(root, LockFileName), []byte("example.com/lib v1.0.0\n"), 0644)
+	if r := NewModulePathResolver(root); r.Err() == nil {
+		t.Error("malformed lock: expected an error")
+	}
+}
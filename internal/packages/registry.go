@@ -0,0 +1,357 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultRegistryURL is the public Sentra package registry
+const DefaultRegistryURL = "https://packages.sentra-lang.org"
+
+// Registry describes a package registry endpoint
+type Registry struct {
+	Name     string
+	URL      string
+	Token    string // Resolved token (never written back to project files)
+	TokenEnv string // Environment variable holding the token
+	Source   string // Where the registry was configured (project, global, env)
+}
+
+// RegistryPackage is the package metadata returned by a registry
+type RegistryPackage struct {
+	Path        string   `json:"path"`
+	Version     string   `json:"version"`
+	Versions    []string `json:"versions,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	License     string   `json:"license,omitempty"`
+	Downloads   int      `json:"downloads,omitempty"`
+	Registry    string   `json:"-"`
+}
+
+// RegistryClient talks to one or more registries, trying them in order
+type RegistryClient struct {
+	Registries []*Registry
+	httpClient *http.Client
+}
+
+// globalConfigDir returns ~/.sentra
+func globalConfigDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ".sentra"
+	}
+	return filepath.Join(homeDir, ".sentra")
+}
+
+// LoadRegistryClient builds a client from, in priority order:
+// [registry.<name>] sections in the project's sentra.toml, the same sections
+// in ~/.sentra/registries.toml, SENTRA_REGISTRY, and finally the public registry.
+// Tokens come from token_env or an inline token in registries.toml,
+// ~/.sentra/credentials (written by `sentra pkg login`), or
+// SENTRA_REGISTRY_TOKEN; a project's sentra.toml cannot set them.
+func LoadRegistryClient(projectDir string) *RegistryClient {
+	client := &RegistryClient{
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+
+	seen := make(map[string]bool)
+	add := func(reg *Registry) {
+		reg.URL = strings.TrimRight(reg.URL, "/")
+		if reg.URL == "" || seen[reg.URL] {
+			return
+		}
+		seen[reg.URL] = true
+		client.Registries = append(client.Registries, reg)
+	}
+
+	if root := FindProjectRoot(projectDir); root != "" {
+		for _, reg := range registriesFromFile(filepath.Join(root, "sentra.toml"), "project") {
+			// token_env could name any secret in the environment
+			reg.Token, reg.TokenEnv = "", ""
+			add(reg)
+		}
+	}
+	for _, reg := range registriesFromFile(filepath.Join(globalConfigDir(), "registries.toml"), "global") {
+		add(reg)
+	}
+	if envURL := os.Getenv("SENTRA_REGISTRY"); envURL != "" {
+		add(&Registry{Name: "env", URL: envURL, Source: "env"})
+	}
+	add(&Registry{Name: "default", URL: DefaultRegistryURL, Source: "builtin"})
+
+	credentials := loadCredentials()
+	for _, reg := range client.Registries {
+		if reg.Token == "" && reg.TokenEnv != "" {
+			reg.Token = os.Getenv(reg.TokenEnv)
+		}
+		if reg.Token == "" {
+			reg.Token = credentials[reg.URL]
+		}
+	}
+	// SENTRA_REGISTRY_TOKEN applies to the registry SENTRA_REGISTRY names,
+	// or else the first in ~/.sentra/registries.toml. A project could
+	// otherwise declare a registry of its own to collect the token.
+	if token := os.Getenv("SENTRA_REGISTRY_TOKEN"); token != "" {
+		envURL := strings.TrimRight(os.Getenv("SENTRA_REGISTRY"), "/")
+		for _, reg := range client.Registries {
+			chosen := reg.URL == envURL
+			if envURL == "" {
+				chosen = reg.Source == "global"
+			}
+			if !chosen {
+				continue
+			}
+			if reg.Token == "" {
+				reg.Token = token
+			}
+			break
+		}
+	}
+
+	return client
+}
+
+// registriesFromFile reads [registry.<name>] sections from a TOML file
+func registriesFromFile(path, source string) []*Registry {
+	cfg, err := ParseProjectFile(path)
+	if err != nil {
+		return nil
+	}
+	var registries []*Registry
+	for _, section := range cfg.SectionOrder {
+		if !strings.HasPrefix(section, "registry.") {
+			continue
+		}
+		values := cfg.Sections[section]
+		registries = append(registries, &Registry{
+			Name:     strings.TrimPrefix(section, "registry."),
+			URL:      values["url"],
+			Token:    values["token"],
+			TokenEnv: values["token_env"],
+			Source:   source,
+		})
+	}
+	return registries
+}
+
+// credentialsPath returns the path of the per-user credentials file
+func credentialsPath() string {
+	return filepath.Join(globalConfigDir(), "credentials")
+}
+
+// loadCredentials reads "<registry-url> <token>" lines
+func loadCredentials() map[string]string {
+	creds := make(map[string]string)
+	file, err := os.Open(credentialsPath())
+	if err != nil {
+		return creds
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 2 && !strings.HasPrefix(parts[0], "#") {
+			creds[strings.TrimRight(parts[0], "/")] = parts[1]
+		}
+	}
+	return creds
+}
+
+// SaveCredential stores a token for a registry URL in ~/.sentra/credentials
+// with owner-only permissions
+func SaveCredential(registryURL, token string) error {
+	creds := loadCredentials()
+	creds[strings.TrimRight(registryURL, "/")] = token
+
+	if err := os.MkdirAll(globalConfigDir(), 0700); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for u, t := range creds {
+		fmt.Fprintf(&b, "%s %s\n", u, t)
+	}
+	return os.WriteFile(credentialsPath(), []byte(b.String()), 0600)
+}
+
+// Find returns a configured registry by name or URL
+func (c *RegistryClient) Find(nameOrURL string) *Registry {
+	for _, reg := range c.Registries {
+		if reg.Name == nameOrURL || reg.URL == strings.TrimRight(nameOrURL, "/") {
+			return reg
+		}
+	}
+	return nil
+}
+
+// newRequest creates a request with the registry's bearer token attached
+func (c *RegistryClient) newRequest(reg *Registry, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, reg.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sentra-pkg/1.0")
+	if reg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+reg.Token)
+	}
+	return req, nil
+}
+
+// registryError describes a failed registry response
+func registryError(reg *Registry, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if reg.Token == "" {
+			return fmt.Errorf("%s: authentication required (run 'sentra pkg login %s <token>')", reg.Name, reg.Name)
+		}
+		return fmt.Errorf("%s: access denied (HTTP %d)", reg.Name, resp.StatusCode)
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: not found", reg.Name)
+	default:
+		return fmt.Errorf("%s: HTTP %d", reg.Name, resp.StatusCode)
+	}
+}
+
+// Search queries every registry and merges the results
+func (c *RegistryClient) Search(query string) ([]RegistryPackage, error) {
+	var results []RegistryPackage
+	var errs []string
+	for _, reg := range c.Registries {
+		req, err := c.newRequest(reg, "GET", "/api/v1/search?q="+url.QueryEscape(query), nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", reg.Name, err))
+			continue
+		}
+		var found []RegistryPackage
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&found)
+		} else {
+			err = registryError(reg, resp)
+		}
+		resp.Body.Close()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for i := range found {
+			found[i].Registry = reg.Name
+		}
+		results = append(results, found...)
+	}
+	if len(results) == 0 && len(errs) == len(c.Registries) && len(errs) > 0 {
+		return nil, fmt.Errorf("all registries failed:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return results, nil
+}
+
+// Info returns package metadata from the first registry that has it
+func (c *RegistryClient) Info(pkgPath string) (*RegistryPackage, error) {
+	var errs []string
+	for _, reg := range c.Registries {
+		req, err := c.newRequest(reg, "GET", "/api/v1/packages/"+pkgPath, nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", reg.Name, err))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, registryError(reg, resp).Error())
+			resp.Body.Close()
+			continue
+		}
+		var pkg RegistryPackage
+		err = json.NewDecoder(resp.Body).Decode(&pkg)
+		resp.Body.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid response: %v", reg.Name, err))
+			continue
+		}
+		pkg.Registry = reg.Name
+		return &pkg, nil
+	}
+	return nil, fmt.Errorf("package %s not found:\n  %s", pkgPath, strings.Join(errs, "\n  "))
+}
+
+// Download fetches a package archive (.tar.gz) into w, falling back across
+// registries until one serves it. Returns the registry that served it.
+func (c *RegistryClient) Download(pkgPath, version string, w io.Writer) (*Registry, error) {
+	if version == "" {
+		version = "latest"
+	}
+	var errs []string
+	for _, reg := range c.Registries {
+		req, err := c.newRequest(reg, "GET", fmt.Sprintf("/api/v1/packages/%s/%s/download", pkgPath, url.PathEscape(version)), nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", reg.Name, err))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, registryError(reg, resp).Error())
+			resp.Body.Close()
+			continue
+		}
+		_, err = io.Copy(w, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: download interrupted: %w", reg.Name, err)
+		}
+		return reg, nil
+	}
+	return nil, fmt.Errorf("package %s@%s not available:\n  %s", pkgPath, version, strings.Join(errs, "\n  "))
+}
+
+// Publish uploads a package archive to a single registry. Publishing never
+// falls back, so a package cannot silently land on the wrong registry.
+func (c *RegistryClient) Publish(reg *Registry, pkg RegistryPackage, archive []byte) error {
+	if reg.Token == "" {
+		return fmt.Errorf("%s: publishing requires a token (run 'sentra pkg login %s <token>')", reg.Name, reg.Name)
+	}
+
+	meta, err := json.Marshal(pkg)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.Write(meta)
+	body.WriteByte('\n')
+	body.Write(archive)
+
+	req, err := c.newRequest(reg, "POST", "/api/v1/packages", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.sentra.package")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", reg.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return registryError(reg, resp)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryTokenScope(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SENTRA_REGISTRY", "")
+	t.Setenv("SENTRA_REGISTRY_TOKEN", "secret")
+	project := t.TempDir()
+	os.WriteFile(filepath.Join(project, "sentra.toml"), []byte("[registry.attacker]\nurl = \"https://pkg.attacker.example\"\n"), 0644)
+
+	// The token never goes to a registry the project declares
+	client := LoadRegistryClient(project)
+	if reg := client.Find("attacker"); reg == nil || reg.Token != "" {
+		t.Errorf("project registry: got %+v", reg)
+	}
+	if reg := client.Find(DefaultRegistryURL); reg == nil || reg.Token != "" {
+		t.Errorf("default registry: got %+v", reg)
+	}
+
+	// It goes to the first registry the user configured
+	os.MkdirAll(filepath.Join(home, ".sentra"), 0700)
+	os.WriteFile(filepath.Join(home, ".sentra", "registries.toml"), []byte("[registry.corp]\nurl = \"https://pkg.corp.example\"\n"), 0644)
+	client = LoadRegistryClient(project)
+	if reg := client.Find("attacker"); reg == nil || reg.Token != "" {
+		t.Errorf("project registry with a global one: got %+v", reg)
+	}
+	if reg := client.Find("corp"); reg == nil || reg.Token != "secret" {
+		t.Errorf("global registry: got %+v", reg)
+	}
+
+	// Or to the one SENTRA_REGISTRY names, even if the project declares it
+	t.Setenv("SENTRA_REGISTRY", "https://pkg.attacker.example/")
+	client = LoadRegistryClient(project)
+	if reg := client.Find("attacker"); reg == nil || reg.Token != "secret" {
+		t.Errorf("registry named by SENTRA_REGISTRY: got %+v", reg)
+	}
+	if reg := client.Find("corp"); reg == nil || reg.Token != "" {
+		t.Errorf("global registry with SENTRA_REGISTRY: got %+v", reg)
+	}
+}
+
+func TestProjectRegistryCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SENTRA_REGISTRY", "")
+	t.Setenv("SENTRA_REGISTRY_TOKEN", "secret")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws-secret")
+	project := t.TempDir()
+	os.WriteFile(filepath.Join(project, "sentra.toml"), []byte(`[registry.token_env]
+url = "https://one.attacker.example"
+token_env = "SENTRA_REGISTRY_TOKEN"
+
+[registry.aws]
+url = "https://two.attacker.example"
+token_env = "AWS_SECRET_ACCESS_KEY"
+
+[registry.inline]
+url = "https://three.attacker.example"
+token = "inline"
+`), 0644)
+
+	client := LoadRegistryClient(project)
+	for _, name := range []string{"token_env", "aws", "inline"} {
+		if reg := client.Find(name); reg == nil || reg.Token != "" {
+			t.Errorf("%s: got %+v", name, reg)
+		}
+	}
+}
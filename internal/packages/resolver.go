@@ -220,6 +220,11 @@ func (r *ImportResolver) resolveStdlibImport(importPath string, alias string) (*
 
 // findMainFile finds the main source file in a module directory
 func (r *ImportResolver) findMainFile(dir string) string {
+	return findMainFile(dir)
+}
+
+// findMainFile finds the main source file in a package directory
+func findMainFile(dir string) string {
 	candidates := []string{
 		"main.sn",
 		"index.sn",
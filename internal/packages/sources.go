@@ -0,0 +1,218 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SourceFiles expands command line paths into the .sn files they name.
+// A path may be a file, which is always included, a directory, which is
+// searched recursively, or a glob pattern such as "src/**/*.sn" where **
+// matches any number of directories. Searches skip hidden directories,
+// node_modules and whatever the exclude list of the project's sentra.toml
+// names:
+//
+//	[project]
+//	exclude = ["vendor", "tests/fixtures/**"]
+//
+// Files are returned once each, in the order they were found.
+func SourceFiles(paths []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		if !strings.ContainsAny(path, "*?[") {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			if err := walkSources(path, nil, add); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		pattern := filepath.ToSlash(filepath.Clean(path))
+		if _, err := filepath.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", path, err)
+		}
+		before := len(files)
+		err := walkSources(globBase(pattern), func(file string) bool {
+			return MatchPath(pattern, filepath.ToSlash(file))
+		}, add)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == before {
+			return nil, fmt.Errorf("no .sn files match %s", path)
+		}
+	}
+	return files, nil
+}
+
+// walkSources calls add for each .sn file under dir that match accepts
+func walkSources(dir string, match func(string) bool, add func(string)) error {
+	var root string
+	var excludes []string
+	if abs, err := filepath.Abs(dir); err == nil {
+		if root = FindProjectRoot(abs); root != "" {
+			excludes = ProjectExcludes(root)
+		}
+	}
+	excluded := func(path string) bool {
+		if root == "" || len(excludes) == 0 {
+			return false
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return false
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return false
+		}
+		return Excluded(excludes, filepath.ToSlash(rel))
+	}
+
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules" || excluded(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".sn") && (match == nil || match(path)) && !excluded(path) {
+			add(path)
+		}
+		return nil
+	})
+}
+
+// globBase returns the directory part of a pattern before its first
+// wildcard, where a search for matches starts
+func globBase(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			if i == 0 {
+				return "."
+			}
+			base := strings.Join(parts[:i], "/")
+			if base == "" {
+				return "/"
+			}
+			return filepath.FromSlash(base)
+		}
+	}
+	return filepath.FromSlash(pattern)
+}
+
+// ProjectExcludes returns the exclude patterns of the sentra.toml in root
+func ProjectExcludes(root string) []string {
+	cfg, err := ParseProjectFile(filepath.Join(root, "sentra.toml"))
+	if err != nil {
+		return nil
+	}
+	for _, section := range []string{"project", ""} {
+		if value, ok := cfg.Sections[section]["exclude"]; ok {
+			return ParseTOMLList(value)
+		}
+	}
+	return nil
+}
+
+// Excluded reports whether a slash-separated path relative to the project
+// root matches one of the patterns. As in .gitignore, a pattern without a
+// slash matches a file or directory name at any depth, one with a slash
+// is matched from the root, and excluding a directory excludes everything
+// in it.
+func Excluded(patterns []string, rel string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/"), "/")
+		if pattern == "" {
+			continue
+		}
+		for i := range parts {
+			if !strings.Contains(pattern, "/") {
+				if ok, _ := filepath.Match(pattern, parts[i]); ok {
+					return true
+				}
+			} else if MatchPath(pattern, strings.Join(parts[:i+1], "/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchPath reports whether a slash-separated path matches pattern. Each
+// segment is matched with filepath.Match, except that a ** segment
+// matches any number of directories, including none.
+func MatchPath(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// ParseTOMLList parses a TOML array of strings such as ["a", "b"]. A plain
+// string is read as a list of one.
+func ParseTOMLList(value string) []string {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") {
+		if value == "" {
+			return nil
+		}
+		return []string{unquoteTOML(value)}
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if s, err := strconv.Unquote(item); err == nil {
+			item = s
+		} else {
+			item = unquoteTOML(item)
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -18,6 +18,7 @@ func (b *Binary) Accept(visitor ExprVisitor) interface{} {
 // Literal expression: string or number
 type Literal struct {
 	Value interface{}
+	Raw   string // Source spelling of raw and triple-quoted strings and of bytes
 }
 
 func (l *Literal) Accept(visitor ExprVisitor) interface{} {
@@ -43,10 +44,12 @@ func (a *Assign) Accept(visitor ExprVisitor) interface{} {
 	return visitor.VisitAssignExpr(a)
 }
 
-// Call expression: callee(args...)
+// Call expression: callee(args...). The last len(Names) arguments are
+// keyword arguments, name = value.
 type CallExpr struct {
 	Callee Expr
 	Args   []Expr
+	Names  []string
 }
 
 func (c *CallExpr) Accept(visitor ExprVisitor) interface{} {
@@ -92,16 +95,30 @@ func (m *MapExpr) Accept(visitor ExprVisitor) interface{} {
 	return visitor.VisitMapExpr(m)
 }
 
-// Index expression: array[index] or map[key]
+// Index expression: array[index] or map[key], or object?.[key], which is
+// null when object is null
 type IndexExpr struct {
-	Object Expr
-	Index  Expr
+	Object   Expr
+	Index    Expr
+	Optional bool
 }
 
 func (i *IndexExpr) Accept(visitor ExprVisitor) interface{} {
 	return visitor.VisitIndexExpr(i)
 }
 
+// Slice expression: value[start:end], where a nil Start or End is an open
+// bound
+type SliceExpr struct {
+	Object Expr
+	Start  Expr
+	End    Expr
+}
+
+func (s *SliceExpr) Accept(visitor ExprVisitor) interface{} {
+	return visitor.VisitSliceExpr(s)
+}
+
 // Set index expression: array[index] = value
 type SetIndexExpr struct {
 	Object Expr
@@ -145,18 +162,22 @@ func (i *InterpolationExpr) Accept(visitor ExprVisitor) interface{} {
 
 // Lambda expression: fn(x) => x * 2
 type LambdaExpr struct {
-	Params []string
-	Body   Expr
+	Params   []string
+	Defaults []Expr // As in FunctionStmt
+	Variadic bool
+	Body     Expr
 }
 
 func (l *LambdaExpr) Accept(visitor ExprVisitor) interface{} {
 	return visitor.VisitLambdaExpr(l)
 }
 
-// Property access: object.property
+// Property access: object.property, or object?.property, which is null
+// when object is null
 type PropertyExpr struct {
 	Object   Expr
 	Property string
+	Optional bool
 }
 
 func (p *PropertyExpr) Accept(visitor ExprVisitor) interface{} {
@@ -184,6 +205,7 @@ type ExprVisitor interface {
 	VisitArrayExpr(expr *ArrayExpr) interface{}
 	VisitMapExpr(expr *MapExpr) interface{}
 	VisitIndexExpr(expr *IndexExpr) interface{}
+	VisitSliceExpr(expr *SliceExpr) interface{}
 	VisitSetIndexExpr(expr *SetIndexExpr) interface{}
 	VisitUnaryExpr(expr *UnaryExpr) interface{}
 	VisitLogicalExpr(expr *LogicalExpr) interface{}
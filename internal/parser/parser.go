@@ -12,6 +12,7 @@ import (
 // Add operator precedence (optional for debug)
 var precedence = map[lexer.TokenType]int{
 	// Logical operators (lowest precedence)
+	lexer.TokenNullish:     0,  // ??
 	lexer.TokenOr:          1,  // ||
 	lexer.TokenAnd:         2,  // &&
 	// Comparison operators
@@ -35,6 +36,9 @@ type Parser struct {
 	Errors     []error
 	file       string
 	sourceLines []string // Source lines for error reporting
+	stmtLines  map[Stmt]int // Line where each parsed statement starts
+	stmtEnds   map[Stmt]int // Line of each parsed statement's last token
+	blockEnds  map[interface{}][]int // Closing brace lines of a node's blocks
 }
 
 func NewParser(tokens []lexer.Token) *Parser {
@@ -59,7 +63,8 @@ func (p *Parser) Parse() []Stmt {
 	var stmts []Stmt
 	for !p.isAtEnd() {
 		if p.match(lexer.TokenFn) {
-			stmts = append(stmts, p.function())
+			line := p.previous().Line
+			stmts = append(stmts, p.recordLine(p.function(), line))
 		} else {
 			stmt := p.statement()
 			stmts = append(stmts, stmt)
@@ -68,7 +73,59 @@ func (p *Parser) Parse() []Stmt {
 	return stmts
 }
 
+// StmtLines returns the starting source line of every parsed statement,
+// including nested ones
+func (p *Parser) StmtLines() map[Stmt]int {
+	return p.stmtLines
+}
+
+// StmtEnds returns the line of the last token of every statement recorded
+// in StmtLines
+func (p *Parser) StmtEnds() map[Stmt]int {
+	return p.stmtEnds
+}
+
+// BlockEnds returns the lines of the closing braces of the blocks of a
+// statement, lambda body or block expression, in source order. Optional
+// blocks have a slot that is 0 when absent: if [then, else], try [try,
+// catch, finally] and match [one per arm, match body].
+func (p *Parser) BlockEnds() map[interface{}][]int {
+	return p.blockEnds
+}
+
+// recordLine remembers where stmt starts; it is called right after stmt is
+// parsed, so the previous token is where it ends
+func (p *Parser) recordLine(stmt Stmt, line int) Stmt {
+	if stmt != nil {
+		if p.stmtLines == nil {
+			p.stmtLines = make(map[Stmt]int)
+			p.stmtEnds = make(map[Stmt]int)
+		}
+		p.stmtLines[stmt] = line
+		p.stmtEnds[stmt] = p.previous().Line
+	}
+	return stmt
+}
+
+// closeBlock consumes the '}' ending a block and returns its line
+func (p *Parser) closeBlock(msg string) int {
+	return p.consume(lexer.TokenRBrace, msg).Line
+}
+
+// recordBlocks remembers where the blocks of node end
+func (p *Parser) recordBlocks(node interface{}, lines ...int) {
+	if p.blockEnds == nil {
+		p.blockEnds = make(map[interface{}][]int)
+	}
+	p.blockEnds[node] = lines
+}
+
 func (p *Parser) statement() Stmt {
+	line := p.peek().Line
+	return p.recordLine(p.parseStatement(), line)
+}
+
+func (p *Parser) parseStatement() Stmt {
 	// Import statement
 	if p.match(lexer.TokenImport) {
 		return p.importStatement()
@@ -94,6 +151,13 @@ func (p *Parser) statement() Stmt {
 		return p.forStatement()
 	}
 	
+	// Labeled loop: outer: for ... or outer: while ...
+	if p.check(lexer.TokenIdent) && p.checkNext(lexer.TokenColon) && p.current+2 < len(p.tokens) {
+		if next := p.tokens[p.current+2].Type; next == lexer.TokenFor || next == lexer.TokenWhile {
+			return p.labeledLoop()
+		}
+	}
+	
 	// Log/print statement
 	if p.match(lexer.TokenLog) {
 		p.consume(lexer.TokenLParen, "Expect '(' after log")
@@ -141,14 +205,19 @@ func (p *Parser) statement() Stmt {
 		return p.matchStatement()
 	}
 	
+	// Struct declaration
+	if p.match(lexer.TokenStruct) {
+		return p.structDeclaration()
+	}
+	
 	// Break statement
 	if p.match(lexer.TokenBreak) {
-		return &BreakStmt{}
+		return &BreakStmt{Label: p.loopLabel()}
 	}
 	
 	// Continue statement
 	if p.match(lexer.TokenContinue) {
-		return &ContinueStmt{}
+		return &ContinueStmt{Label: p.loopLabel()}
 	}
 	
 	// Try to parse as assignment or expression
@@ -160,6 +229,13 @@ func (p *Parser) statement() Stmt {
 		// This is an assignment
 		value := p.expression()
 		
+		if isOptionalAccess(expr) {
+			panic(p.error("Cannot assign to a null-safe access"))
+		}
+		if _, ok := expr.(*SliceExpr); ok {
+			panic(p.error("Cannot assign to a slice"))
+		}
+		
 		// Determine the type of assignment based on the left-hand expression
 		switch lhs := expr.(type) {
 		case *Variable:
@@ -173,6 +249,14 @@ func (p *Parser) statement() Stmt {
 				Index:  lhs.Index,
 				Value:  value,
 			}
+		case *PropertyExpr:
+			// Property assignment: self.severity = value
+			return &IndexAssignmentStmt{
+				Object:   lhs.Object,
+				Index:    &Literal{Value: lhs.Property},
+				Value:    value,
+				Property: true,
+			}
 		default:
 			// Invalid left-hand side for assignment - treat the whole thing as expression
 			// This shouldn't normally happen
@@ -184,13 +268,25 @@ func (p *Parser) statement() Stmt {
 	return &ExpressionStmt{Expr: expr}
 }
 
+// isOptionalAccess reports whether expr is obj?.name or obj?.[key]
+func isOptionalAccess(expr Expr) bool {
+	switch e := expr.(type) {
+	case *PropertyExpr:
+		return e.Optional
+	case *IndexExpr:
+		return e.Optional
+	}
+	return false
+}
+
 func (p *Parser) ifStatement() Stmt {
 	condition := p.expression()
 	p.consume(lexer.TokenLBrace, "Expect '{' before if body")
 	thenBranch := p.blockStatements()
-	p.consume(lexer.TokenRBrace, "Expect '}' after if body")
+	thenEnd := p.closeBlock("Expect '}' after if body")
 	
 	var elseBranch []Stmt
+	elseEnd := 0
 	if p.match(lexer.TokenElse) {
 		if p.match(lexer.TokenIf) {
 			// else if - parse as nested if statement
@@ -199,17 +295,24 @@ func (p *Parser) ifStatement() Stmt {
 			// else block
 			p.consume(lexer.TokenLBrace, "Expect '{' before else body")
 			elseBranch = p.blockStatements()
-			p.consume(lexer.TokenRBrace, "Expect '}' after else body")
+			elseEnd = p.closeBlock("Expect '}' after else body")
 		}
 	}
 	
-	return &IfStmt{Condition: condition, Then: thenBranch, Else: elseBranch}
+	stmt := &IfStmt{Condition: condition, Then: thenBranch, Else: elseBranch}
+	p.recordBlocks(stmt, thenEnd, elseEnd)
+	return stmt
 }
 
 func (p *Parser) importStatement() Stmt {
 	var path string
 	var alias string
 	
+	// Selective import: import {scan, report as rep} from "path"
+	if p.match(lexer.TokenLBrace) {
+		return p.selectiveImport()
+	}
+	
 	if p.check(lexer.TokenString) {
 		// import "path/to/module"
 		pathTok := p.advance()
@@ -232,6 +335,40 @@ func (p *Parser) importStatement() Stmt {
 	return &ImportStmt{Path: path, Alias: alias}
 }
 
+func (p *Parser) selectiveImport() Stmt {
+	var names []ImportName
+	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
+		nameTok := p.consume(lexer.TokenIdent, "Expect imported name")
+		name := ImportName{Name: nameTok.Lexeme}
+		if p.match(lexer.TokenAs) {
+			name.Alias = p.consume(lexer.TokenIdent, "Expect alias name after 'as'").Lexeme
+		}
+		names = append(names, name)
+		if !p.match(lexer.TokenComma) {
+			break
+		}
+	}
+	p.consume(lexer.TokenRBrace, "Expect '}' after imported names")
+	if len(names) == 0 {
+		panic(p.error("Expect at least one name in selective import"))
+	}
+	
+	// 'from' is contextual, not a reserved word
+	if !p.check(lexer.TokenIdent) || p.peek().Lexeme != "from" {
+		panic(p.error("Expect 'from' after imported names"))
+	}
+	p.advance()
+	
+	var path string
+	if p.check(lexer.TokenString) || p.check(lexer.TokenIdent) {
+		path = p.advance().Lexeme
+	} else {
+		panic(p.error("Expect module name or path after 'from'"))
+	}
+	
+	return &ImportStmt{Path: path, Names: names}
+}
+
 func (p *Parser) exportStatement() Stmt {
 	// Export can be followed by:
 	// - fn name() { ... }  -> export function
@@ -244,23 +381,15 @@ func (p *Parser) exportStatement() Stmt {
 		name := nameTok.Lexeme
 		
 		p.consume(lexer.TokenLParen, "Expect '(' after function name")
-		params := []string{}
-		if !p.check(lexer.TokenRParen) {
-			for {
-				paramTok := p.consume(lexer.TokenIdent, "Expect parameter name")
-				params = append(params, paramTok.Lexeme)
-				if !p.match(lexer.TokenComma) {
-					break
-				}
-			}
-		}
+		params, defaults, variadic := p.parameters()
 		p.consume(lexer.TokenRParen, "Expect ')' after parameters")
 		
 		p.consume(lexer.TokenLBrace, "Expect '{' before function body")
 		body := p.blockStatements()
-		p.consume(lexer.TokenRBrace, "Expect '}' after function body")
+		bodyEnd := p.closeBlock("Expect '}' after function body")
 		
-		fnStmt := &FunctionStmt{Name: name, Params: params, Body: body}
+		fnStmt := &FunctionStmt{Name: name, Params: params, Defaults: defaults, Variadic: variadic, Body: body, Line: nameTok.Line}
+		p.recordBlocks(fnStmt, bodyEnd)
 		return &ExportStmt{Name: name, Stmt: fnStmt}
 	}
 	
@@ -276,27 +405,120 @@ func (p *Parser) exportStatement() Stmt {
 		return &ExportStmt{Name: name, Stmt: letStmt}
 	}
 	
-	panic(p.error("Expect 'fn', 'let', 'var', or 'const' after 'export'"))
+	if p.match(lexer.TokenStruct) {
+		structStmt := p.structDeclaration().(*ClassStmt)
+		return &ExportStmt{Name: structStmt.Name, Stmt: structStmt}
+	}
+	
+	panic(p.error("Expect 'fn', 'let', 'var', 'const' or 'struct' after 'export'"))
+}
+
+// structDeclaration parses a struct: its fields, each with an optional
+// default, separated by commas or newlines, and its methods
+//
+//	struct Finding {
+//	    host, port
+//	    severity = "low"
+//	    fn describe() { return self.host + ":" + str(self.port) }
+//	}
+func (p *Parser) structDeclaration() Stmt {
+	nameTok := p.consume(lexer.TokenIdent, "Expect struct name")
+	p.consume(lexer.TokenLBrace, "Expect '{' after struct name")
+	
+	stmt := &ClassStmt{Name: nameTok.Lexeme, Line: nameTok.Line}
+	seen := make(map[string]bool)
+	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
+		if p.match(lexer.TokenFn) {
+			line := p.previous().Line
+			method := p.recordLine(p.function(), line).(*FunctionStmt)
+			if seen[method.Name] {
+				panic(p.error(fmt.Sprintf("Duplicate member '%s' in struct %s", method.Name, stmt.Name)))
+			}
+			seen[method.Name] = true
+			stmt.Methods = append(stmt.Methods, method)
+			continue
+		}
+		
+		fieldTok := p.consume(lexer.TokenIdent, "Expect field name or 'fn' in struct body")
+		field := fieldTok.Lexeme
+		if seen[field] {
+			panic(p.error(fmt.Sprintf("Duplicate member '%s' in struct %s", field, stmt.Name)))
+		}
+		seen[field] = true
+		var def Expr
+		if p.match(lexer.TokenEqual) {
+			def = p.expression()
+		}
+		stmt.Fields = append(stmt.Fields, field)
+		stmt.Defaults = append(stmt.Defaults, def)
+		stmt.FieldLines = append(stmt.FieldLines, fieldTok.Line)
+		p.match(lexer.TokenComma)
+	}
+	bodyEnd := p.closeBlock("Expect '}' after struct body")
+	p.recordBlocks(stmt, bodyEnd)
+	return stmt
 }
 
 func (p *Parser) whileStatement() Stmt {
 	condition := p.expression()
 	p.consume(lexer.TokenLBrace, "Expect '{' before while body")
 	body := p.blockStatements()
-	p.consume(lexer.TokenRBrace, "Expect '}' after while body")
-	return &WhileStmt{Condition: condition, Body: body}
+	bodyEnd := p.closeBlock("Expect '}' after while body")
+	stmt := &WhileStmt{Condition: condition, Body: body}
+	p.recordBlocks(stmt, bodyEnd)
+	return stmt
+}
+
+// labeledLoop parses `name: for ...` or `name: while ...`, so that break
+// and continue in nested loops can name the loop they mean
+func (p *Parser) labeledLoop() Stmt {
+	label := p.advance().Lexeme
+	p.advance() // consume ':'
+	
+	var loop Stmt
+	if p.match(lexer.TokenWhile) {
+		loop = p.whileStatement()
+	} else {
+		p.advance() // consume 'for'
+		loop = p.forStatement()
+	}
+	switch l := loop.(type) {
+	case *WhileStmt:
+		l.Label = label
+	case *ForStmt:
+		l.Label = label
+	case *ForInStmt:
+		l.Label = label
+	}
+	return loop
+}
+
+// loopLabel reads the label after break or continue. It has to be on the
+// same line, as the next line starts a new statement.
+func (p *Parser) loopLabel() string {
+	if p.check(lexer.TokenIdent) && p.peek().Line == p.previous().Line {
+		return p.advance().Lexeme
+	}
+	return ""
 }
 
 func (p *Parser) forStatement() Stmt {
-	// Check for for-in loop: for i in collection
-	if p.checkNext(lexer.TokenIn) {
+	// Check for for-in loop: for v in collection, or for k, v in collection
+	if p.checkNext(lexer.TokenIn) || (p.check(lexer.TokenIdent) && p.checkNext(lexer.TokenComma)) {
+		key := ""
 		variable := p.consume(lexer.TokenIdent, "Expect variable name").Lexeme
+		if p.match(lexer.TokenComma) {
+			key = variable
+			variable = p.consume(lexer.TokenIdent, "Expect second variable name after ','").Lexeme
+		}
 		p.consume(lexer.TokenIn, "Expect 'in'")
 		collection := p.expression()
 		p.consume(lexer.TokenLBrace, "Expect '{' before for body")
 		body := p.blockStatements()
-		p.consume(lexer.TokenRBrace, "Expect '}' after for body")
-		return &ForInStmt{Variable: variable, Collection: collection, Body: body}
+		bodyEnd := p.closeBlock("Expect '}' after for body")
+		stmt := &ForInStmt{Key: key, Variable: variable, Collection: collection, Body: body}
+		p.recordBlocks(stmt, bodyEnd)
+		return stmt
 	}
 	
 	// Traditional for loop
@@ -361,16 +583,19 @@ func (p *Parser) forStatement() Stmt {
 	
 	p.consume(lexer.TokenLBrace, "Expect '{' before for body")
 	body := p.blockStatements()
-	p.consume(lexer.TokenRBrace, "Expect '}' after for body")
+	bodyEnd := p.closeBlock("Expect '}' after for body")
 	
-	return &ForStmt{Init: init, Condition: condition, Update: update, Body: body}
+	stmt := &ForStmt{Init: init, Condition: condition, Update: update, Body: body}
+	p.recordBlocks(stmt, bodyEnd)
+	return stmt
 }
 
 func (p *Parser) blockStatements() []Stmt {
 	var stmts []Stmt
 	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
 		if p.match(lexer.TokenFn) {
-			stmts = append(stmts, p.function())
+			line := p.previous().Line
+			stmts = append(stmts, p.recordLine(p.function(), line))
 		} else {
 			stmts = append(stmts, p.statement())
 		}
@@ -381,14 +606,7 @@ func (p *Parser) blockStatements() []Stmt {
 func (p *Parser) function() Stmt {
 	nameTok := p.consume(lexer.TokenIdent, "Expect function name")
 	p.consume(lexer.TokenLParen, "Expect '(' after function name")
-
-	params := []string{}
-	if !p.check(lexer.TokenRParen) {
-		params = append(params, p.consume(lexer.TokenIdent, "Expect parameter name").Lexeme)
-		for p.match(lexer.TokenComma) {
-			params = append(params, p.consume(lexer.TokenIdent, "Expect parameter name").Lexeme)
-		}
-	}
+	params, defaults, variadic := p.parameters()
 	p.consume(lexer.TokenRParen, "Expect ')' after parameters")
 
 	var returnType string
@@ -402,8 +620,11 @@ func (p *Parser) function() Stmt {
 		return &FunctionStmt{
 			Name:       nameTok.Lexeme,
 			Params:     params,
+			Defaults:   defaults,
+			Variadic:   variadic,
 			ReturnType: returnType,
 			Body:       body,
+			Line:       nameTok.Line,
 		}
 	}
 
@@ -412,14 +633,56 @@ func (p *Parser) function() Stmt {
 	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
 		body = append(body, p.statement())
 	}
-	p.consume(lexer.TokenRBrace, "Expect '}' after function body")
+	bodyEnd := p.closeBlock("Expect '}' after function body")
 
-	return &FunctionStmt{
+	stmt := &FunctionStmt{
 		Name:       nameTok.Lexeme,
 		Params:     params,
+		Defaults:   defaults,
+		Variadic:   variadic,
 		ReturnType: returnType,
 		Body:       body,
+		Line:       nameTok.Line,
 	}
+	p.recordBlocks(stmt, bodyEnd)
+	return stmt
+}
+
+// parameters parses a parameter list up to the closing ')'. A parameter
+// may have a default, used when the argument is left out or null, and the
+// last may be ...rest, which collects the remaining arguments into an
+// array. defaults is nil when no parameter has one.
+func (p *Parser) parameters() (params []string, defaults []Expr, variadic bool) {
+	params = []string{}
+	if p.check(lexer.TokenRParen) {
+		return params, nil, false
+	}
+	hasDefault := false
+	for {
+		if p.match(lexer.TokenEllipsis) {
+			params = append(params, p.consume(lexer.TokenIdent, "Expect parameter name after '...'").Lexeme)
+			defaults = append(defaults, nil)
+			variadic = true
+			if !p.check(lexer.TokenRParen) {
+				panic(p.error("A '...' parameter must be the last one"))
+			}
+			break
+		}
+		params = append(params, p.consume(lexer.TokenIdent, "Expect parameter name").Lexeme)
+		var def Expr
+		if p.match(lexer.TokenEqual) {
+			def = p.expression()
+			hasDefault = true
+		}
+		defaults = append(defaults, def)
+		if !p.match(lexer.TokenComma) {
+			break
+		}
+	}
+	if !hasDefault {
+		defaults = nil
+	}
+	return params, defaults, variadic
 }
 
 // --- Expression Parsing with Precedence ---
@@ -437,6 +700,11 @@ func (p *Parser) parseBinary(minPrec int) Expr {
 		}
 		p.advance()
 		right := p.parseBinary(prec + 1)
+		if tok.Type == lexer.TokenNullish {
+			// Short-circuits: the right side only runs when left is null
+			left = &LogicalExpr{Left: left, Operator: tok.Lexeme, Right: right}
+			continue
+		}
 		left = &Binary{
 			Left:     left,
 			Operator: tok.Lexeme,
@@ -457,14 +725,36 @@ func (p *Parser) parseCall() Expr {
 		if p.match(lexer.TokenLParen) {
 			expr = p.finishCall(expr)
 		} else if p.match(lexer.TokenLBracket) {
-			// Array/map indexing
-			index := p.expression()
+			// Array/map indexing, or slicing: a[start:end] with either bound optional
+			var index Expr
+			if !p.check(lexer.TokenColon) {
+				index = p.expression()
+			}
+			if p.match(lexer.TokenColon) {
+				var end Expr
+				if !p.check(lexer.TokenRBracket) {
+					end = p.expression()
+				}
+				p.consume(lexer.TokenRBracket, "Expect ']' after slice")
+				expr = &SliceExpr{Object: expr, Start: index, End: end}
+				continue
+			}
 			p.consume(lexer.TokenRBracket, "Expect ']' after index")
 			expr = &IndexExpr{Object: expr, Index: index}
 		} else if p.match(lexer.TokenDot) {
 			// Property access
 			name := p.consume(lexer.TokenIdent, "Expect property name after '.'")
 			expr = &PropertyExpr{Object: expr, Property: name.Lexeme}
+		} else if p.match(lexer.TokenQuestionDot) {
+			// Null-safe access: obj?.name or obj?.[key]
+			if p.match(lexer.TokenLBracket) {
+				index := p.expression()
+				p.consume(lexer.TokenRBracket, "Expect ']' after index")
+				expr = &IndexExpr{Object: expr, Index: index, Optional: true}
+			} else {
+				name := p.consume(lexer.TokenIdent, "Expect property name after '?.'")
+				expr = &PropertyExpr{Object: expr, Property: name.Lexeme, Optional: true}
+			}
 		} else {
 			break
 		}
@@ -472,10 +762,25 @@ func (p *Parser) parseCall() Expr {
 	return expr
 }
 
+// finishCall parses the arguments of a call. Keyword arguments, written
+// name = value, come after the positional ones.
 func (p *Parser) finishCall(callee Expr) Expr {
 	args := []Expr{}
+	var names []string
 	if !p.check(lexer.TokenRParen) {
 		for {
+			if p.check(lexer.TokenIdent) && p.checkNext(lexer.TokenEqual) {
+				name := p.advance().Lexeme
+				p.advance()
+				for _, seen := range names {
+					if seen == name {
+						panic(p.error(fmt.Sprintf("Duplicate keyword argument '%s'", name)))
+					}
+				}
+				names = append(names, name)
+			} else if len(names) > 0 {
+				panic(p.error("Positional argument after keyword arguments"))
+			}
 			args = append(args, p.expression())
 			if !p.match(lexer.TokenComma) {
 				break
@@ -483,7 +788,7 @@ func (p *Parser) finishCall(callee Expr) Expr {
 		}
 	}
 	p.consume(lexer.TokenRParen, "Expect ')' after arguments")
-	return &CallExpr{Callee: callee, Args: args}
+	return &CallExpr{Callee: callee, Args: args, Names: names}
 }
 
 func (p *Parser) primary() Expr {
@@ -493,7 +798,9 @@ func (p *Parser) primary() Expr {
 	switch tok.Type {
 	case lexer.TokenString:
 		// Scanner already removes quotes and processes escape sequences
-		return &Literal{Value: tok.Lexeme}
+		return &Literal{Value: tok.Lexeme, Raw: tok.Raw}
+	case lexer.TokenBytes:
+		return &Literal{Value: []byte(tok.Lexeme), Raw: tok.Raw}
 	case lexer.TokenNumber:
 		// Parse as integer if no decimal point, otherwise as float
 		if strings.Contains(tok.Lexeme, ".") {
@@ -585,16 +892,7 @@ func (p *Parser) parseAnonymousFunction() Expr {
 	p.consume(lexer.TokenLParen, "Expect '(' after 'fn'")
 	
 	// Parse parameters
-	params := []string{}
-	if !p.check(lexer.TokenRParen) {
-		for {
-			param := p.consume(lexer.TokenIdent, "Expect parameter name")
-			params = append(params, param.Lexeme)
-			if !p.match(lexer.TokenComma) {
-				break
-			}
-		}
-	}
+	params, defaults, variadic := p.parameters()
 	p.consume(lexer.TokenRParen, "Expect ')' after parameters")
 	
 	// Check for arrow function: fn(x) => expr
@@ -602,20 +900,26 @@ func (p *Parser) parseAnonymousFunction() Expr {
 		// Single expression body
 		expr := p.expression()
 		return &LambdaExpr{
-			Params: params,
-			Body:   expr,
+			Params:   params,
+			Defaults: defaults,
+			Variadic: variadic,
+			Body:     expr,
 		}
 	}
 	
 	// Otherwise expect block: fn(x) { statements }
 	p.consume(lexer.TokenLBrace, "Expect '{' or '=>' after function parameters")
 	body := p.blockStatements()
-	p.consume(lexer.TokenRBrace, "Expect '}' after function body")
+	bodyEnd := p.closeBlock("Expect '}' after function body")
 	
 	// Convert to lambda expression with block body
+	block := &BlockExpr{Stmts: body}
+	p.recordBlocks(block, bodyEnd)
 	return &LambdaExpr{
-		Params: params,
-		Body:   &BlockExpr{Stmts: body},
+		Params:   params,
+		Defaults: defaults,
+		Variadic: variadic,
+		Body:     block,
 	}
 }
 
@@ -688,7 +992,8 @@ func (p *Parser) unary() Expr {
 		operand := p.unary()
 		return &UnaryExpr{Operator: operator, Operand: operand}
 	}
-	return p.primary()
+	// Indexing, calls and property access bind tighter: !a[0] is !(a[0])
+	return p.parseCall()
 }
 
 func (p *Parser) previous() lexer.Token {
@@ -706,8 +1011,10 @@ func (p *Parser) parseBlockExpr() Expr {
 	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
 		stmts = append(stmts, p.statement())
 	}
-	p.consume(lexer.TokenRBrace, "Expect '}' after block")
-	return &BlockExpr{Stmts: stmts}
+	end := p.closeBlock("Expect '}' after block")
+	block := &BlockExpr{Stmts: stmts}
+	p.recordBlocks(block, end)
+	return block
 }
 
 // --- Utility methods ---
@@ -790,32 +1097,36 @@ func (p *Parser) error(msg string) error {
 func (p *Parser) tryStatement() Stmt {
 	p.consume(lexer.TokenLBrace, "Expect '{' after 'try'")
 	tryBlock := p.blockStatements()
-	p.consume(lexer.TokenRBrace, "Expect '}' after try block")
+	tryEnd := p.closeBlock("Expect '}' after try block")
 	
 	var catchVar string
 	var catchBlock []Stmt
+	catchEnd := 0
 	if p.match(lexer.TokenCatch) {
 		if p.check(lexer.TokenIdent) {
 			catchVar = p.advance().Lexeme
 		}
 		p.consume(lexer.TokenLBrace, "Expect '{' after catch")
 		catchBlock = p.blockStatements()
-		p.consume(lexer.TokenRBrace, "Expect '}' after catch block")
+		catchEnd = p.closeBlock("Expect '}' after catch block")
 	}
 	
 	var finallyBlock []Stmt
+	finallyEnd := 0
 	if p.match(lexer.TokenFinally) {
 		p.consume(lexer.TokenLBrace, "Expect '{' after 'finally'")
 		finallyBlock = p.blockStatements()
-		p.consume(lexer.TokenRBrace, "Expect '}' after finally block")
+		finallyEnd = p.closeBlock("Expect '}' after finally block")
 	}
 	
-	return &TryStmt{
+	stmt := &TryStmt{
 		TryBlock:     tryBlock,
 		CatchVar:     catchVar,
 		CatchBlock:   catchBlock,
 		FinallyBlock: finallyBlock,
 	}
+	p.recordBlocks(stmt, tryEnd, catchEnd, finallyEnd)
+	return stmt
 }
 
 func (p *Parser) matchStatement() Stmt {
@@ -824,40 +1135,27 @@ func (p *Parser) matchStatement() Stmt {
 	p.consume(lexer.TokenLBrace, "Expect '{' after match expression")
 	
 	var cases []MatchCase
+	var ends []int
 	
 	// Parse match arms
 	for !p.check(lexer.TokenRBrace) && !p.isAtEnd() {
-		// Parse pattern(s)
-		var pattern Expr
-		
-		// Check for underscore (wildcard/default case)
-		if p.match(lexer.TokenUnderscore) {
-			pattern = &Literal{Value: "_"}
-		} else {
-			// Parse first pattern
-			pattern = p.expression()
-			
-			// Check for multiple patterns with |
-			if p.match(lexer.TokenPipe) {
-				// Create an OR pattern (we'll handle this specially in the compiler)
-				patterns := []Expr{pattern}
-				for {
-					if p.match(lexer.TokenUnderscore) {
-						patterns = append(patterns, &Literal{Value: "_"})
-					} else {
-						patterns = append(patterns, p.expression())
-					}
-					if !p.match(lexer.TokenPipe) {
-						break
-					}
-				}
-				// Wrap multiple patterns in a special expression
-				pattern = &Binary{
-					Left:     pattern,
-					Operator: "|",
-					Right:    patterns[1], // Simplified for now
-				}
+		// Parse pattern(s): values separated by |, or _ for the default arm
+		var patterns []Expr
+		line := p.peek().Line
+		wildcard := false
+		for {
+			if p.match(lexer.TokenUnderscore) {
+				wildcard = true
+			} else {
+				patterns = append(patterns, p.expression())
 			}
+			if !p.match(lexer.TokenPipe) {
+				break
+			}
+		}
+		if wildcard {
+			// _ matches anything, so the other values make no difference
+			patterns = nil
 		}
 		
 		// Expect => after pattern
@@ -865,28 +1163,34 @@ func (p *Parser) matchStatement() Stmt {
 		
 		// Parse the body - can be a single expression or a block
 		var body []Stmt
+		armEnd := 0
 		if p.check(lexer.TokenLBrace) {
 			p.advance() // consume '{'
 			body = p.blockStatements()
+			armEnd = p.closeBlock("Expect '}' after match arm body")
 		} else {
 			// Single statement
 			stmt := p.statement()
 			body = []Stmt{stmt}
 		}
+		ends = append(ends, armEnd)
 		
 		cases = append(cases, MatchCase{
-			Pattern: pattern,
-			Body:    body,
+			Patterns: patterns,
+			Body:     body,
+			Line:     line,
 		})
 		
 		// Check for comma separator (optional)
 		p.match(lexer.TokenComma)
 	}
 	
-	p.consume(lexer.TokenRBrace, "Expect '}' after match cases")
+	ends = append(ends, p.closeBlock("Expect '}' after match cases"))
 	
-	return &MatchStmt{
+	stmt := &MatchStmt{
 		Value: value,
 		Cases: cases,
 	}
+	p.recordBlocks(stmt, ends...)
+	return stmt
 }
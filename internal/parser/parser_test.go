@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"sentra/internal/lexer"
+	"strings"
 	"testing"
 )
 
@@ -97,6 +98,10 @@ func TestStringLiterals(t *testing.T) {
 		{"emoji in string", `let x = "🚀 rocket"`, true},
 		{"unterminated string", `let x = "hello`, false},
 		{"mixed quotes", `let x = "hello'`, false},
+		{"raw string", `let x = r"C:\Windows\System32"`, true},
+		{"triple-quoted string", "let x = \"\"\"\n  SELECT *\n  \"\"\"", true},
+		{"unterminated raw string", `let x = r"abc`, false},
+		{"unterminated triple-quoted string", `let x = """abc""`, false},
 	}
 
 	for _, test := range tests {
@@ -110,6 +115,82 @@ func TestStringLiterals(t *testing.T) {
 	}
 }
 
+func TestStringLiteralValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty string", `""`, ""},
+		{"raw backslashes", `r"\d+\.\d+\n"`, `\d+\.\d+\n`},
+		{"raw windows path", `r"C:\Users\Public"`, `C:\Users\Public`},
+		{"raw identifier prefix", `r""`, ""},
+		{"triple on one line", `"""say "hi" twice"""`, `say "hi" twice`},
+		{"triple with escapes", "\"\"\"a\\tb\\n\"\"\"", "a\tb\n"},
+		{"triple dedented", "\"\"\"\n    SELECT host\n      WHERE id = 1\n\n    \"\"\"", "SELECT host\n  WHERE id = 1\n"},
+		{"triple kept when closed inline", "\"\"\"\n  a\n  b\"\"\"", "  a\n  b"},
+		{"raw triple", "r\"\"\"\n  ^\\w+\\s\"(.*)\"$\n  \"\"\"", `^\w+\s"(.*)"$`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmts := assertParseSuccess(t, "let x = "+test.input, test.name)
+			if stmts == nil {
+				return
+			}
+			lit, ok := stmts[0].(*LetStmt).Expr.(*Literal)
+			if !ok {
+				t.Fatalf("expected a literal, got %T", stmts[0].(*LetStmt).Expr)
+			}
+			if lit.Value != test.want {
+				t.Errorf("got %q, want %q", lit.Value, test.want)
+			}
+		})
+	}
+}
+
+func TestBytesLiterals(t *testing.T) {
+	stmts := assertParseSuccess(t, `let a = hex"deadBEEF"
+let b = hex"de ad
+            be ef"
+let c = hex""
+let hex = 1
+let d = hex + 1`, "bytes literals")
+	if stmts == nil {
+		return
+	}
+	want := []string{"\xde\xad\xbe\xef", "\xde\xad\xbe\xef", ""}
+	for i, w := range want {
+		lit, ok := stmts[i].(*LetStmt).Expr.(*Literal)
+		if !ok {
+			t.Fatalf("%d: expected a literal, got %T", i, stmts[i].(*LetStmt).Expr)
+		}
+		if data, ok := lit.Value.([]byte); !ok || string(data) != w {
+			t.Errorf("%d: got %#v, want %q", i, lit.Value, w)
+		}
+	}
+	if _, ok := stmts[4].(*LetStmt).Expr.(*Binary); !ok {
+		t.Errorf("hex without a quote should stay an identifier")
+	}
+
+	for _, bad := range []string{`hex"abc"`, `hex"zz"`, `hex"00`} {
+		scanner := lexer.NewScanner("let x = " + bad)
+		scanner.ScanTokens()
+		if !scanner.HadError() {
+			t.Errorf("%s: expected a scan error", bad)
+		}
+	}
+}
+
+func TestMultilineStringLines(t *testing.T) {
+	scanner := lexer.NewScanner("let a = \"one\ntwo\"\nlet b = \"\"\"\n  x\n  \"\"\"\nlet c = 1")
+	for _, tok := range scanner.ScanTokens() {
+		if tok.Lexeme == "c" && tok.Line != 6 {
+			t.Errorf("c is on line 6, scanner says %d", tok.Line)
+		}
+	}
+}
+
 // ===== Map Literal Tests =====
 
 func TestMapLiterals(t *testing.T) {
@@ -185,6 +266,9 @@ func TestForLoops(t *testing.T) {
 		{"c-style for loop", `for (let i = 0; i < 10; i = i + 1) { log(i) }`, true},
 		{"for-in loop", `for x in [1, 2, 3] { log(x) }`, true},
 		{"for-in with let", `for let x in [1, 2, 3] { log(x) }`, true},
+		{"for-in index and value", `for i, x in [1, 2, 3] { log(i + x) }`, true},
+		{"for-in key and value", `for k, v in {"a": 1} { log(k) }`, true},
+		{"for-in missing second variable", `for k, in {"a": 1} { log(k) }`, false},
 		{"nested for loops", `for (let i = 0; i < 5; i = i + 1) { for (let j = 0; j < 5; j = j + 1) { log(i + j) } }`, true},
 		{"for with break", `for (let i = 0; i < 10; i = i + 1) { if i == 5 { break } }`, true},
 		{"for with continue", `for (let i = 0; i < 10; i = i + 1) { if i == 5 { continue } }`, true},
@@ -203,6 +287,220 @@ func TestForLoops(t *testing.T) {
 	}
 }
 
+func TestForInVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		key      string
+		variable string
+	}{
+		{`for x in items { log(x) }`, "", "x"},
+		{`for i, x in items { log(x) }`, "i", "x"},
+		{`for name, ip in hosts { log(ip) }`, "name", "ip"},
+	}
+
+	for _, test := range tests {
+		stmts := assertParseSuccess(t, test.input, test.input)
+		if stmts == nil {
+			continue
+		}
+		loop, ok := stmts[0].(*ForInStmt)
+		if !ok {
+			t.Fatalf("%s: expected a for-in loop, got %T", test.input, stmts[0])
+		}
+		if loop.Key != test.key || loop.Variable != test.variable {
+			t.Errorf("%s: got key %q and variable %q", test.input, loop.Key, loop.Variable)
+		}
+	}
+}
+
+func TestLoopLabels(t *testing.T) {
+	stmts := assertParseSuccess(t, `outer: for row in rows {
+    for x in row {
+        if x == 0 { continue outer }
+        if x < 0 { break outer }
+        break
+    }
+}
+scan: while true { break scan }
+count: for (let i = 0; i < 3; i = i + 1) { continue }`, "labeled loops")
+	if stmts == nil {
+		return
+	}
+	outer, ok := stmts[0].(*ForInStmt)
+	if !ok || outer.Label != "outer" {
+		t.Fatalf("expected a for-in labeled outer, got %#v", stmts[0])
+	}
+	body := outer.Body[0].(*ForInStmt).Body
+	if cont := body[0].(*IfStmt).Then[0].(*ContinueStmt); cont.Label != "outer" {
+		t.Errorf("continue: got label %q", cont.Label)
+	}
+	if brk := body[1].(*IfStmt).Then[0].(*BreakStmt); brk.Label != "outer" {
+		t.Errorf("break: got label %q", brk.Label)
+	}
+	if brk := body[2].(*BreakStmt); brk.Label != "" {
+		t.Errorf("plain break: got label %q", brk.Label)
+	}
+	if loop, ok := stmts[1].(*WhileStmt); !ok || loop.Label != "scan" {
+		t.Errorf("expected a while labeled scan, got %#v", stmts[1])
+	}
+	if loop, ok := stmts[2].(*ForStmt); !ok || loop.Label != "count" {
+		t.Errorf("expected a for labeled count, got %#v", stmts[2])
+	}
+
+	// A label is only read from the same line as the keyword
+	stmts = assertParseSuccess(t, "while true {\n    break\n    done = true\n}", "break before statement")
+	if stmts != nil {
+		body := stmts[0].(*WhileStmt).Body
+		if len(body) != 2 || body[0].(*BreakStmt).Label != "" {
+			t.Errorf("expected break and an assignment, got %#v", body)
+		}
+	}
+}
+
+func TestStructDeclaration(t *testing.T) {
+	stmts := assertParseSuccess(t, `struct Finding {
+    host, port
+    severity = "low"
+    fn describe() { return self.host }
+    fn escalate(level) { self.severity = level }
+}
+export struct Host { ip }`, "struct declaration")
+	if stmts == nil {
+		return
+	}
+	s, ok := stmts[0].(*ClassStmt)
+	if !ok {
+		t.Fatalf("expected a struct, got %T", stmts[0])
+	}
+	if s.Name != "Finding" || strings.Join(s.Fields, ",") != "host,port,severity" {
+		t.Errorf("got struct %s with fields %v", s.Name, s.Fields)
+	}
+	if s.Defaults[0] != nil || s.Defaults[1] != nil || s.Defaults[2] == nil {
+		t.Errorf("only severity has a default, got %v", s.Defaults)
+	}
+	if len(s.Methods) != 2 || s.Methods[1].Name != "escalate" {
+		t.Fatalf("expected methods describe and escalate, got %v", s.Methods)
+	}
+	assign, ok := s.Methods[1].Body[0].(*IndexAssignmentStmt)
+	if !ok || !assign.Property {
+		t.Errorf("expected a property assignment, got %#v", s.Methods[1].Body[0])
+	}
+	if exp, ok := stmts[1].(*ExportStmt); !ok || exp.Name != "Host" {
+		t.Errorf("expected an exported struct, got %#v", stmts[1])
+	}
+
+	assertParseError(t, "struct Dup { a, a }", "duplicate field")
+	assertParseError(t, "struct Dup { run\n fn run() {} }", "field and method with one name")
+	assertParseError(t, "struct Bad { 42 }", "field that is not a name")
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	stmts := assertParseSuccess(t, `let n = result?.items?.[0] ?? 0 || fallback`, "null-safe access")
+	if stmts == nil {
+		return
+	}
+	// ?? binds looser than ||
+	nullish, ok := stmts[0].(*LetStmt).Expr.(*LogicalExpr)
+	if !ok || nullish.Operator != "??" {
+		t.Fatalf("expected ?? at the top, got %#v", stmts[0].(*LetStmt).Expr)
+	}
+	if or, ok := nullish.Right.(*Binary); !ok || or.Operator != "||" {
+		t.Errorf("expected || on the right of ??, got %#v", nullish.Right)
+	}
+	index, ok := nullish.Left.(*IndexExpr)
+	if !ok || !index.Optional {
+		t.Fatalf("expected an optional index, got %#v", nullish.Left)
+	}
+	if prop, ok := index.Object.(*PropertyExpr); !ok || !prop.Optional || prop.Property != "items" {
+		t.Errorf("expected result?.items, got %#v", index.Object)
+	}
+
+	assertParseError(t, "a?.b = 1", "assignment to a null-safe property")
+	assertParseError(t, "a?.[0] = 1", "assignment to a null-safe index")
+}
+
+func TestParameterDefaults(t *testing.T) {
+	stmts := assertParseSuccess(t, `fn scan(host, port = 443, proto = "tcp") { return host }
+fn log_all(prefix, ...items) { log(items) }
+let f = fn(a, b = a * 2, ...rest) => rest
+fn none() {}`, "defaults and variadic parameters")
+	if stmts == nil {
+		return
+	}
+	scan := stmts[0].(*FunctionStmt)
+	if len(scan.Defaults) != 3 || scan.Defaults[0] != nil || scan.Defaults[1] == nil || scan.Variadic {
+		t.Errorf("scan: got defaults %#v, variadic %v", scan.Defaults, scan.Variadic)
+	}
+	logAll := stmts[1].(*FunctionStmt)
+	if logAll.Defaults != nil || !logAll.Variadic || strings.Join(logAll.ParamNames(), ", ") != "prefix, ...items" {
+		t.Errorf("log_all: got params %v, defaults %#v, variadic %v", logAll.Params, logAll.Defaults, logAll.Variadic)
+	}
+	lambda := stmts[2].(*LetStmt).Expr.(*LambdaExpr)
+	if len(lambda.Params) != 3 || lambda.Defaults[1] == nil || !lambda.Variadic {
+		t.Errorf("lambda: got params %v, defaults %#v, variadic %v", lambda.Params, lambda.Defaults, lambda.Variadic)
+	}
+	if none := stmts[3].(*FunctionStmt); len(none.Params) != 0 || none.Defaults != nil {
+		t.Errorf("none: got params %v", none.Params)
+	}
+
+	assertParseError(t, "fn f(...items, last) {}", "variadic parameter before another")
+	assertParseError(t, "fn f(a, ...) {}", "variadic parameter without a name")
+	assertParseError(t, "fn f(a,) {}", "trailing comma in parameters")
+}
+
+func TestKeywordArguments(t *testing.T) {
+	stmts := assertParseSuccess(t, `db_connect("mysql", host = "10.0.0.1", port = 3306)
+scan(port = 22, host = target)
+let eq = f(a == b)`, "keyword arguments")
+	if stmts == nil {
+		return
+	}
+	call := stmts[0].(*ExpressionStmt).Expr.(*CallExpr)
+	if len(call.Args) != 3 || strings.Join(call.Names, ",") != "host,port" {
+		t.Errorf("db_connect: got %d args, names %v", len(call.Args), call.Names)
+	}
+	if call := stmts[1].(*ExpressionStmt).Expr.(*CallExpr); strings.Join(call.Names, ",") != "port,host" {
+		t.Errorf("scan: got names %v", call.Names)
+	}
+	if call := stmts[2].(*LetStmt).Expr.(*CallExpr); call.Names != nil {
+		t.Errorf("comparison parsed as keyword argument %v", call.Names)
+	}
+
+	assertParseError(t, `scan(host = "a", "b")`, "positional argument after keyword")
+	assertParseError(t, `scan(host = "a", host = "b")`, "duplicate keyword argument")
+}
+
+func TestSlices(t *testing.T) {
+	stmts := assertParseSuccess(t, `let a = items[1:n + 1]
+let b = items[:2]
+let c = name[2:]
+let d = items[:]
+let e = rows[0][1:][0]`, "slices")
+	if stmts == nil {
+		return
+	}
+	bounds := []struct{ start, end bool }{{true, true}, {false, true}, {true, false}, {false, false}}
+	for i, want := range bounds {
+		slice, ok := stmts[i].(*LetStmt).Expr.(*SliceExpr)
+		if !ok {
+			t.Fatalf("statement %d: expected a slice, got %#v", i, stmts[i].(*LetStmt).Expr)
+		}
+		if (slice.Start != nil) != want.start || (slice.End != nil) != want.end {
+			t.Errorf("statement %d: got start %#v, end %#v", i, slice.Start, slice.End)
+		}
+	}
+	index, ok := stmts[4].(*LetStmt).Expr.(*IndexExpr)
+	if !ok {
+		t.Fatalf("expected an index of a slice, got %#v", stmts[4].(*LetStmt).Expr)
+	}
+	if _, ok := index.Object.(*SliceExpr); !ok {
+		t.Errorf("expected rows[0][1:], got %#v", index.Object)
+	}
+
+	assertParseError(t, "items[1:2] = x", "assignment to a slice")
+	assertParseError(t, "items[1:2:3]", "three-part slice")
+}
+
 // ===== Variable Scoping Tests =====
 
 func TestVariableScoping(t *testing.T) {
@@ -349,6 +647,30 @@ func TestPatternMatching(t *testing.T) {
 	}
 }
 
+func TestMatchPatterns(t *testing.T) {
+	stmts := assertParseSuccess(t, `match level {
+    "critical" | "high" | "severe" => page()
+    "low" => log("low")
+    "_" => log("underscore")
+    _ => log("other")
+}`, "match patterns")
+	if stmts == nil {
+		return
+	}
+	cases := stmts[0].(*MatchStmt).Cases
+	if len(cases) != 4 {
+		t.Fatalf("expected 4 arms, got %d", len(cases))
+	}
+	for i, want := range []int{3, 1, 1, 0} {
+		if got := len(cases[i].Patterns); got != want {
+			t.Errorf("arm %d: expected %d patterns, got %d", i, want, got)
+		}
+	}
+	if cases[2].IsDefault() || !cases[3].IsDefault() {
+		t.Errorf("only the _ arm should be the default")
+	}
+}
+
 // ===== Import/Export Tests =====
 
 func TestImportExport(t *testing.T) {
@@ -364,6 +686,11 @@ func TestImportExport(t *testing.T) {
 		{"export function", `export fn test() { return 1 }`, true},
 		{"export variable", `export let x = 5`, true},
 		{"invalid import", `import`, false},
+		{"selective import", `import {scan, report} from "lib/net.sn"`, true},
+		{"selective import with alias", `import {scan as net_scan, report} from "lib/net.sn"`, true},
+		{"selective import builtin", `import {sqrt} from math`, true},
+		{"selective import without from", `import {scan} "lib/net.sn"`, false},
+		{"empty selective import", `import {} from "lib/net.sn"`, false},
 	}
 
 	for _, test := range tests {
@@ -377,6 +704,26 @@ func TestImportExport(t *testing.T) {
 	}
 }
 
+func TestSelectiveImportNames(t *testing.T) {
+	stmts := assertParseSuccess(t, `import {scan, report as rep} from "lib/net.sn"`, "selective import")
+	if len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(stmts))
+	}
+	imp, ok := stmts[0].(*ImportStmt)
+	if !ok {
+		t.Fatalf("expected *ImportStmt, got %T", stmts[0])
+	}
+	if imp.Path != "lib/net.sn" {
+		t.Errorf("expected path lib/net.sn, got %q", imp.Path)
+	}
+	if len(imp.Names) != 2 {
+		t.Fatalf("expected 2 imported names, got %d", len(imp.Names))
+	}
+	if imp.Names[0].LocalName() != "scan" || imp.Names[1].Name != "report" || imp.Names[1].LocalName() != "rep" {
+		t.Errorf("unexpected imported names: %+v", imp.Names)
+	}
+}
+
 // ===== Benchmark Tests =====
 
 func BenchmarkParseSimpleProgram(b *testing.B) {
@@ -35,11 +35,14 @@ func (a *AssignmentStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitAssignmentStmt(a)
 }
 
-// IndexAssignmentStmt represents an index assignment: array[index] = expr
+// IndexAssignmentStmt represents an index assignment: array[index] = expr.
+// A property assignment, object.name = expr, has the name as a string
+// literal Index and Property set.
 type IndexAssignmentStmt struct {
-	Object Expr
-	Index  Expr
-	Value  Expr
+	Object   Expr
+	Index    Expr
+	Value    Expr
+	Property bool
 }
 
 func (i *IndexAssignmentStmt) Accept(visitor StmtVisitor) interface{} {
@@ -59,14 +62,27 @@ func (e *ExpressionStmt) Accept(visitor StmtVisitor) interface{} {
 type FunctionStmt struct {
 	Name       string
 	Params     []string
+	Defaults   []Expr // Default of each parameter, nil where it has none; nil if none has one
+	Variadic   bool   // The last parameter collects the remaining arguments
 	ReturnType string
 	Body       []Stmt
+	Line       int // Line of the function name
 }
 
 func (f *FunctionStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitFunctionStmt(f)
 }
 
+// ParamNames returns the parameter names for display, with "..." before a
+// variadic one
+func (f *FunctionStmt) ParamNames() []string {
+	names := append([]string(nil), f.Params...)
+	if f.Variadic && len(names) > 0 {
+		names[len(names)-1] = "..." + names[len(names)-1]
+	}
+	return names
+}
+
 // ReturnStmt represents a return statement.
 type ReturnStmt struct {
 	Value Expr
@@ -91,6 +107,7 @@ func (i *IfStmt) Accept(visitor StmtVisitor) interface{} {
 type WhileStmt struct {
 	Condition Expr
 	Body      []Stmt
+	Label     string // Set by `name: while ...` for labeled break/continue
 }
 
 func (w *WhileStmt) Accept(visitor StmtVisitor) interface{} {
@@ -103,17 +120,22 @@ type ForStmt struct {
 	Condition Expr  // Loop condition
 	Update    Expr  // Optional update expression
 	Body      []Stmt
+	Label     string // Set by `name: for ...` for labeled break/continue
 }
 
 func (f *ForStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitForStmt(f)
 }
 
-// ForInStmt represents a for-in loop for iterating collections.
+// ForInStmt represents a for-in loop for iterating collections. With one
+// variable it binds each array element or map key; `for k, v in` binds
+// Key to the index or map key and Variable to the element or map value.
 type ForInStmt struct {
+	Key        string // Empty unless two variables are given
 	Variable   string
 	Collection Expr
 	Body       []Stmt
+	Label      string // Set by `name: for ...` for labeled break/continue
 }
 
 func (f *ForInStmt) Accept(visitor StmtVisitor) interface{} {
@@ -121,14 +143,18 @@ func (f *ForInStmt) Accept(visitor StmtVisitor) interface{} {
 }
 
 // BreakStmt represents a break statement.
-type BreakStmt struct{}
+type BreakStmt struct {
+	Label string // Loop to leave; empty for the innermost
+}
 
 func (b *BreakStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitBreakStmt(b)
 }
 
 // ContinueStmt represents a continue statement.
-type ContinueStmt struct{}
+type ContinueStmt struct {
+	Label string // Loop to continue; empty for the innermost
+}
 
 func (c *ContinueStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitContinueStmt(c)
@@ -137,7 +163,22 @@ func (c *ContinueStmt) Accept(visitor StmtVisitor) interface{} {
 // ImportStmt represents an import statement.
 type ImportStmt struct {
 	Path  string
-	Alias string // Optional alias for the import
+	Alias string       // Optional alias for the import
+	Names []ImportName // Selective imports: import {a, b as c} from "path"
+}
+
+// ImportName is a single binding in a selective import.
+type ImportName struct {
+	Name  string // Exported name in the module
+	Alias string // Local name (empty means same as Name)
+}
+
+// LocalName returns the name the import is bound to in the importing scope.
+func (n ImportName) LocalName() string {
+	if n.Alias != "" {
+		return n.Alias
+	}
+	return n.Name
 }
 
 func (i *ImportStmt) Accept(visitor StmtVisitor) interface{} {
@@ -154,12 +195,16 @@ func (e *ExportStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitExportStmt(e)
 }
 
-// ClassStmt represents a class declaration.
+// ClassStmt represents a struct declaration. Calling Name with the field
+// values, in order, creates an instance; methods see it as self.
 type ClassStmt struct {
 	Name       string
 	Superclass string // Optional parent class
 	Methods    []*FunctionStmt
 	Fields     []string
+	Defaults   []Expr // Default of each field, nil where it has none
+	FieldLines []int  // Line of each field
+	Line       int    // Line of the struct name
 }
 
 func (c *ClassStmt) Accept(visitor StmtVisitor) interface{} {
@@ -187,15 +232,23 @@ func (t *ThrowStmt) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitThrowStmt(t)
 }
 
-// MatchStmt represents a pattern matching statement.
+// MatchStmt represents a pattern matching statement. The first case with
+// a pattern equal to Value runs; there is no fallthrough.
 type MatchStmt struct {
 	Value Expr
 	Cases []MatchCase
 }
 
+// MatchCase is one arm of a match, such as `"high" | "critical" => alert()`
 type MatchCase struct {
-	Pattern Expr
-	Body    []Stmt
+	Patterns []Expr // Values the arm matches; none for the _ arm
+	Body     []Stmt
+	Line     int // Line of the pattern
+}
+
+// IsDefault reports whether the arm is the _ arm, which matches anything
+func (m MatchCase) IsDefault() bool {
+	return len(m.Patterns) == 0
 }
 
 func (m *MatchStmt) Accept(visitor StmtVisitor) interface{} {
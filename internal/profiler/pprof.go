@@ -0,0 +1,339 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The pprof format is a gzipped protocol buffer, described in
+// https://github.com/google/pprof/blob/main/proto/profile.proto. Only the
+// fields below are written and read.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+// protoBuffer appends protocol buffer fields
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(field int, v uint64) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|2)
+	*b = binary.AppendUvarint(*b, uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var data protoBuffer
+	for _, v := range values {
+		data = binary.AppendUvarint(data, v)
+	}
+	b.bytes(field, data)
+}
+
+// WritePprof writes the profile in the gzipped pprof format read by
+// go tool pprof and by sentra profile. Each sample has two values, the
+// number of samples and the wall time they stand for.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strings := []string{""}
+	stringIndex := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		if i, ok := stringIndex[s]; ok {
+			return i
+		}
+		stringIndex[s] = uint64(len(strings))
+		strings = append(strings, s)
+		return stringIndex[s]
+	}
+	valueType := func(typ, unit string) []byte {
+		var vt protoBuffer
+		vt.varint(valueTypeType, str(typ))
+		vt.varint(valueTypeUnit, str(unit))
+		return vt
+	}
+
+	var out protoBuffer
+	out.bytes(profileSampleType, valueType("samples", "count"))
+	out.bytes(profileSampleType, valueType("wall", "nanoseconds"))
+
+	type function struct{ name, file string }
+	functions := make(map[function]uint64)
+	locations := make(map[Frame]uint64)
+	var functionData, locationData []protoBuffer
+
+	for _, s := range p.Samples {
+		ids := make([]uint64, len(s.Stack))
+		for i, frame := range s.Stack {
+			id, ok := locations[frame]
+			if !ok {
+				fn := function{frame.Function, frame.File}
+				fnID, ok := functions[fn]
+				if !ok {
+					fnID = uint64(len(functions) + 1)
+					functions[fn] = fnID
+					var f protoBuffer
+					f.varint(functionID, fnID)
+					f.varint(functionName, str(frame.Function))
+					f.varint(functionSystemName, str(frame.Function))
+					f.varint(functionFilename, str(frame.File))
+					functionData = append(functionData, f)
+				}
+				id = uint64(len(locations) + 1)
+				locations[frame] = id
+				var line, loc protoBuffer
+				line.varint(lineFunctionID, fnID)
+				line.varint(lineLine, uint64(frame.Line))
+				loc.varint(locationID, id)
+				loc.bytes(locationLine, line)
+				locationData = append(locationData, loc)
+			}
+			ids[i] = id
+		}
+		var sample protoBuffer
+		sample.packed(sampleLocationID, ids)
+		sample.packed(sampleValue, []uint64{uint64(s.Count), uint64(s.Count * int64(p.Period))})
+		out.bytes(profileSample, sample)
+	}
+	for _, loc := range locationData {
+		out.bytes(profileLocation, loc)
+	}
+	for _, f := range functionData {
+		out.bytes(profileFunction, f)
+	}
+
+	periodType := valueType("wall", "nanoseconds")
+	for _, s := range strings {
+		out.bytes(profileStringTable, []byte(s))
+	}
+	if !p.Start.IsZero() {
+		out.varint(profileTimeNanos, uint64(p.Start.UnixNano()))
+	}
+	out.varint(profileDurationNanos, uint64(p.Duration))
+	out.bytes(profilePeriodType, periodType)
+	out.varint(profilePeriod, uint64(p.Period))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoField is one field of a decoded message
+type protoField struct {
+	num  int
+	wire int
+	v    uint64 // Varint fields
+	data []byte // Length delimited fields
+}
+
+// protoFields splits a message into its fields
+func protoFields(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed pprof profile")
+		}
+		data = data[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			if f.v, n = binary.Uvarint(data); n <= 0 {
+				return nil, fmt.Errorf("malformed pprof profile")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("malformed pprof profile")
+			}
+			f.v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, fmt.Errorf("malformed pprof profile")
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("malformed pprof profile")
+			}
+			f.v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return nil, fmt.Errorf("malformed pprof profile")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// uints returns the values of a repeated integer field, packed or not
+func (f protoField) uints() ([]uint64, error) {
+	if f.wire != 2 {
+		return []uint64{f.v}, nil
+	}
+	var values []uint64
+	for data := f.data; len(data) > 0; {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed pprof profile")
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// decodePprof decodes an uncompressed pprof profile. The first sample value
+// is used as the count, as pprof does by default.
+func decodePprof(data []byte) (*Profile, error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	type line struct {
+		function uint64
+		line     int
+	}
+	type function struct{ name, file uint64 }
+	var strings []string
+	locations := make(map[uint64][]line)
+	functions := make(map[uint64]function)
+	type rawSample struct{ ids, values []uint64 }
+	var samples []rawSample
+	p := &Profile{}
+
+	for _, f := range fields {
+		switch f.num {
+		case profileSample:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return nil, err
+			}
+			var s rawSample
+			for _, sf := range sub {
+				values, err := sf.uints()
+				if err != nil {
+					return nil, err
+				}
+				switch sf.num {
+				case sampleLocationID:
+					s.ids = append(s.ids, values...)
+				case sampleValue:
+					s.values = append(s.values, values...)
+				}
+			}
+			samples = append(samples, s)
+		case profileLocation:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return nil, err
+			}
+			var id uint64
+			var lines []line
+			for _, lf := range sub {
+				switch lf.num {
+				case locationID:
+					id = lf.v
+				case locationLine:
+					lineFields, err := protoFields(lf.data)
+					if err != nil {
+						return nil, err
+					}
+					var l line
+					for _, x := range lineFields {
+						switch x.num {
+						case lineFunctionID:
+							l.function = x.v
+						case lineLine:
+							l.line = int(int64(x.v))
+						}
+					}
+					lines = append(lines, l)
+				}
+			}
+			locations[id] = lines
+		case profileFunction:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return nil, err
+			}
+			var id uint64
+			var fn function
+			for _, x := range sub {
+				switch x.num {
+				case functionID:
+					id = x.v
+				case functionName:
+					fn.name = x.v
+				case functionFilename:
+					fn.file = x.v
+				}
+			}
+			functions[id] = fn
+		case profileStringTable:
+			strings = append(strings, string(f.data))
+		case profileTimeNanos:
+			p.Start = time.Unix(0, int64(f.v))
+		case profileDurationNanos:
+			p.Duration = time.Duration(f.v)
+		case profilePeriod:
+			p.Period = time.Duration(f.v)
+		}
+	}
+
+	str := func(i uint64) string {
+		if i < uint64(len(strings)) {
+			return strings[i]
+		}
+		return ""
+	}
+	for _, s := range samples {
+		if len(s.values) == 0 {
+			continue
+		}
+		sample := Sample{Count: int64(s.values[0])}
+		for _, id := range s.ids {
+			// A location lists inlined functions before the function
+			// they were inlined into
+			for _, l := range locations[id] {
+				fn := functions[l.function]
+				sample.Stack = append(sample.Stack, Frame{Function: str(fn.name), File: str(fn.file), Line: l.line})
+			}
+		}
+		p.Samples = append(p.Samples, sample)
+	}
+	return p, nil
+}
@@ -0,0 +1,178 @@
+// Package profiler holds sampled call stacks of a Sentra program and reads
+// and writes them as pprof profiles and folded stacks for flame graphs
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frame is one function on a sampled call stack
+type Frame struct {
+	Function string
+	File     string // Empty if unknown
+	Line     int    // Zero if unknown
+}
+
+// String formats the frame as function (file:line)
+func (f Frame) String() string {
+	switch {
+	case f.File == "":
+		return f.Function
+	case f.Line == 0:
+		return fmt.Sprintf("%s (%s)", f.Function, filepath.Base(f.File))
+	}
+	return fmt.Sprintf("%s (%s:%d)", f.Function, filepath.Base(f.File), f.Line)
+}
+
+// Sample is a call stack, innermost frame first, and how many times it was
+// seen
+type Sample struct {
+	Stack []Frame
+	Count int64
+}
+
+// Profile is a set of samples taken every Period
+type Profile struct {
+	Samples  []Sample
+	Period   time.Duration
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Total returns the number of samples
+func (p *Profile) Total() int64 {
+	var total int64
+	for _, s := range p.Samples {
+		total += s.Count
+	}
+	return total
+}
+
+// WriteFolded writes one line per stack, outermost function first and
+// separated by semicolons, followed by its count. This is the input of
+// flamegraph.pl, speedscope and most other flame graph tools.
+func (p *Profile) WriteFolded(w io.Writer) error {
+	counts := make(map[string]int64)
+	for _, s := range p.Samples {
+		names := make([]string, len(s.Stack))
+		for i, frame := range s.Stack {
+			names[len(names)-1-i] = strings.ReplaceAll(frame.Function, ";", ":")
+		}
+		counts[strings.Join(names, ";")] += s.Count
+	}
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(bw, "%s %d\n", stack, counts[stack])
+	}
+	return bw.Flush()
+}
+
+// FunctionStat is how often a function was running (Flat) or on the stack
+// (Cum) in a profile
+type FunctionStat struct {
+	Function string
+	File     string
+	Flat     int64
+	Cum      int64
+}
+
+// Top returns the functions of the profile, most samples first. With lines
+// set, each line of a function is counted separately.
+func (p *Profile) Top(lines bool) []FunctionStat {
+	stats := make(map[Frame]*FunctionStat)
+	for _, s := range p.Samples {
+		seen := make(map[Frame]bool)
+		for i, frame := range s.Stack {
+			key := Frame{Function: frame.Function, File: frame.File}
+			if lines {
+				key.Line = frame.Line
+			}
+			stat, ok := stats[key]
+			if !ok {
+				stat = &FunctionStat{Function: key.String(), File: frame.File}
+				stats[key] = stat
+			}
+			if i == 0 {
+				stat.Flat += s.Count
+			}
+			// Count recursive functions once per stack
+			if !seen[key] {
+				seen[key] = true
+				stat.Cum += s.Count
+			}
+		}
+	}
+
+	top := make([]FunctionStat, 0, len(stats))
+	for _, stat := range stats {
+		top = append(top, *stat)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Flat != top[j].Flat {
+			return top[i].Flat > top[j].Flat
+		}
+		if top[i].Cum != top[j].Cum {
+			return top[i].Cum > top[j].Cum
+		}
+		return top[i].Function < top[j].Function
+	})
+	return top
+}
+
+// Read reads a profile written by WritePprof, or by any other pprof
+// producer, or folded stacks
+func Read(r io.Reader) (*Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+		return decodePprof(data)
+	}
+	return readFolded(data)
+}
+
+// readFolded parses the lines written by WriteFolded
+func readFolded(data []byte) (*Profile, error) {
+	p := &Profile{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		count, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if i <= 0 || err != nil {
+			return nil, fmt.Errorf("line %d: expected a pprof profile or folded stacks", n+1)
+		}
+		names := strings.Split(line[:i], ";")
+		stack := make([]Frame, len(names))
+		for j, name := range names {
+			stack[len(names)-1-j] = Frame{Function: name}
+		}
+		p.Samples = append(p.Samples, Sample{Stack: stack, Count: count})
+	}
+	return p, nil
+}
@@ -0,0 +1,180 @@
+// internal/repl/commands.go
+package repl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"sentra/internal/builtins"
+	"sentra/internal/vmregister"
+)
+
+// command is a REPL magic command such as :vars
+type command struct {
+	usage string
+	help  string
+	run   func(s *Session, arg string, out io.Writer) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":  {":help", "Show this list", runHelp},
+		"doc":   {":doc <name>", "Show the signature and documentation of a function", runDoc},
+		"vars":  {":vars", "List variables defined in this session", runVars},
+		"funcs": {":funcs", "List functions defined in this session", runFuncs},
+		"type":  {":type <expr>", "Show the type of an expression", runType},
+		"time":  {":time <expr>", "Evaluate an expression and show how long it took", runTime},
+		"load":  {":load <file.sn>", "Run a script in this session", runLoad},
+		"reset": {":reset", "Discard all definitions and start over", runReset},
+	}
+}
+
+// runCommand executes a line starting with ':'
+func runCommand(s *Session, line string, out io.Writer) error {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), ":"), " ")
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command :%s (type :help for a list)", name)
+	}
+	return cmd.run(s, strings.TrimSpace(arg), out)
+}
+
+func runHelp(s *Session, arg string, out io.Writer) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-18s %s\n", commands[name].usage, commands[name].help)
+	}
+	return nil
+}
+
+func runVars(s *Session, arg string, out io.Writer) error {
+	globals := s.userGlobals()
+	names := make([]string, 0, len(globals))
+	for name, value := range globals {
+		if vmregister.ValueType(value) != "function" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(out, "no variables defined")
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := globals[name]
+		fmt.Fprintf(out, "  %s: %s = %s\n", name, vmregister.ValueType(value), preview(vmregister.ToString(value)))
+	}
+	return nil
+}
+
+func runFuncs(s *Session, arg string, out io.Writer) error {
+	globals := s.userGlobals()
+	names := make([]string, 0, len(globals))
+	for name, value := range globals {
+		if vmregister.ValueType(value) == "function" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(out, "no functions defined")
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  fn %s(%s)\n", name, strings.Join(s.params[name], ", "))
+	}
+	return nil
+}
+
+func runDoc(s *Session, arg string, out io.Writer) error {
+	if arg == "" {
+		return fmt.Errorf("usage: :doc <name>")
+	}
+	if params, ok := s.params[arg]; ok && s.defined[arg] {
+		fmt.Fprintf(out, "fn %s(%s)\n", arg, strings.Join(params, ", "))
+		return nil
+	}
+	b := builtins.Lookup(arg)
+	if b == nil {
+		return fmt.Errorf("no function named %s", arg)
+	}
+	fmt.Fprintln(out, b.Signature())
+	fmt.Fprintf(out, "  %s\n", b.Doc)
+	return nil
+}
+
+func runType(s *Session, arg string, out io.Writer) error {
+	if arg == "" {
+		return fmt.Errorf("usage: :type <expr>")
+	}
+	result, _, err := s.Eval(arg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, vmregister.ValueType(result))
+	return nil
+}
+
+func runTime(s *Session, arg string, out io.Writer) error {
+	if arg == "" {
+		return fmt.Errorf("usage: :time <expr>")
+	}
+	start := time.Now()
+	result, ok, err := s.Eval(arg)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+	if ok && !vmregister.IsNil(result) {
+		fmt.Fprintln(out, vmregister.ToString(result))
+	}
+	fmt.Fprintf(out, "time: %v\n", elapsed)
+	return nil
+}
+
+func runLoad(s *Session, arg string, out io.Writer) error {
+	if arg == "" {
+		return fmt.Errorf("usage: :load <file.sn>")
+	}
+	path := strings.Trim(arg, `"'`)
+	if err := s.Load(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "loaded %s\n", path)
+	return nil
+}
+
+func runReset(s *Session, arg string, out io.Writer) error {
+	s.Reset()
+	fmt.Fprintln(out, "session reset")
+	return nil
+}
+
+// userGlobals returns the globals declared in this session
+func (s *Session) userGlobals() map[string]vmregister.Value {
+	result := make(map[string]vmregister.Value)
+	for name, value := range s.vm.GetGlobals() {
+		if s.defined[name] {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// preview shortens long values to one line
+func preview(s string) string {
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if len(s) > 60 {
+		return s[:57] + "..."
+	}
+	return s
+}
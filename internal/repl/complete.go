@@ -0,0 +1,142 @@
+// internal/repl/complete.go
+package repl
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"sentra/internal/builtins"
+	"sentra/internal/vmregister"
+)
+
+// keywords are offered as completions alongside globals
+var keywords = []string{
+	"as", "break", "catch", "const", "continue", "else", "export", "false",
+	"finally", "fn", "for", "if", "import", "in", "let", "match", "nil",
+	"null", "return", "spawn", "throw", "true", "try", "var", "while",
+}
+
+// Complete returns completions for the word ending at pos in line and the
+// index where that word starts. After a dot, the exports of a module or the
+// keys of a map are offered; otherwise globals, builtins and keywords.
+func (s *Session) Complete(line string, pos int) (int, []string) {
+	runes := []rune(line)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+	if trimmed := strings.TrimLeft(string(runes[:pos]), " "); strings.HasPrefix(trimmed, ":") && !strings.Contains(trimmed, " ") {
+		return s.completeCommand(pos-len([]rune(trimmed)), trimmed)
+	}
+	if trimmed := strings.TrimLeft(string(runes[:pos]), " "); strings.HasPrefix(trimmed, ":doc ") {
+		return s.completeDoc(pos, strings.TrimLeft(strings.TrimPrefix(trimmed, ":doc "), " "))
+	}
+	start := pos
+	for start > 0 && (isIdentRune(runes[start-1]) || runes[start-1] == '.') {
+		start--
+	}
+	word := string(runes[start:pos])
+
+	var names []string
+	prefix := word
+	if dot := strings.LastIndex(word, "."); dot >= 0 {
+		prefix = word[dot+1:]
+		start += len([]rune(word[:dot+1]))
+		names = s.members(strings.Split(word[:dot], "."))
+	} else {
+		for name := range s.vm.GetGlobals() {
+			names = append(names, name)
+		}
+		names = append(names, keywords...)
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return start, matches
+}
+
+// completeCommand completes a magic command name such as :vars
+func (s *Session) completeCommand(start int, typed string) (int, []string) {
+	var matches []string
+	for name := range commands {
+		if strings.HasPrefix(":"+name, typed) {
+			matches = append(matches, ":"+name)
+		}
+	}
+	sort.Strings(matches)
+	return start, matches
+}
+
+// completeDoc completes the function name after :doc from the builtins
+// registry and the functions defined in this session
+func (s *Session) completeDoc(pos int, typed string) (int, []string) {
+	var matches []string
+	for _, b := range builtins.All() {
+		if strings.HasPrefix(b.Name, typed) {
+			matches = append(matches, b.Name)
+		}
+	}
+	for name := range s.params {
+		if s.defined[name] && strings.HasPrefix(name, typed) && builtins.Lookup(name) == nil {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return pos - len([]rune(typed)), matches
+}
+
+// members resolves a dotted path such as http or config.server and returns
+// the names reachable with one more dot
+func (s *Session) members(path []string) []string {
+	value, ok := s.vm.GetGlobals()[path[0]]
+	if !ok {
+		return nil
+	}
+	for _, key := range path[1:] {
+		fields := fieldsOf(value)
+		if value, ok = fields[key]; !ok {
+			return nil
+		}
+	}
+
+	var names []string
+	for name := range fieldsOf(value) {
+		names = append(names, name)
+	}
+	return names
+}
+
+func fieldsOf(v vmregister.Value) map[string]vmregister.Value {
+	switch {
+	case vmregister.IsModule(v):
+		return vmregister.AsModule(v).Exports
+	case vmregister.IsMap(v):
+		return vmregister.AsMap(v).Items
+	}
+	return nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// commonPrefix returns the longest prefix shared by all candidates
+func commonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,277 @@
+// internal/repl/editor.go
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// errInterrupt is returned by readLine when Ctrl-C is pressed
+var errInterrupt = errors.New("interrupt")
+
+// completer returns completions for the word ending at pos and its start
+type completer func(line string, pos int) (int, []string)
+
+// editor reads lines with readline-style editing when stdin is a terminal
+// and falls back to plain line reading otherwise (e.g. piped input)
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	terminal bool
+	history  *history
+	complete completer
+}
+
+func newEditor(h *history, complete completer) *editor {
+	fd := int(os.Stdin.Fd())
+	return &editor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		fd:       fd,
+		terminal: isTerminal(fd),
+		history:  h,
+		complete: complete,
+	}
+}
+
+// readLine shows prompt and returns the entered line. It returns io.EOF on
+// Ctrl-D at an empty line and errInterrupt on Ctrl-C.
+func (e *editor) readLine(prompt string) (string, error) {
+	if !e.terminal {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		e.terminal = false
+		return e.readLine(prompt)
+	}
+	defer restore(e.fd, state)
+
+	l := &lineState{editor: e, prompt: prompt, historyPos: len(e.history.entries)}
+	l.refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(l.buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(l.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.deleteAt(l.pos)
+		case 1: // Ctrl-A
+			l.pos = 0
+		case 5: // Ctrl-E
+			l.pos = len(l.buf)
+		case 2: // Ctrl-B
+			l.move(-1)
+		case 6: // Ctrl-F
+			l.move(1)
+		case 127, 8: // Backspace
+			if l.pos > 0 {
+				l.pos--
+				l.deleteAt(l.pos)
+			}
+		case 11: // Ctrl-K
+			l.buf = l.buf[:l.pos]
+		case 21: // Ctrl-U
+			l.buf = l.buf[l.pos:]
+			l.pos = 0
+		case 23: // Ctrl-W
+			l.deleteWord()
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			l.recall(-1)
+		case 14: // Ctrl-N
+			l.recall(1)
+		case '\t':
+			l.completeWord()
+		case 27:
+			l.escape()
+		default:
+			if unicode.IsPrint(r) {
+				l.insert(r)
+			}
+		}
+		l.refresh()
+	}
+}
+
+// lineState is the line being edited
+type lineState struct {
+	*editor
+	prompt     string
+	buf        []rune
+	pos        int
+	historyPos int
+	saved      []rune // Line being typed before browsing history
+}
+
+func (l *lineState) refresh() {
+	fmt.Fprintf(l.out, "\r%s%s\x1b[K", l.prompt, string(l.buf))
+	if back := len(l.buf) - l.pos; back > 0 {
+		fmt.Fprintf(l.out, "\x1b[%dD", back)
+	}
+}
+
+func (l *lineState) insert(r rune) {
+	l.buf = append(l.buf[:l.pos], append([]rune{r}, l.buf[l.pos:]...)...)
+	l.pos++
+}
+
+func (l *lineState) insertString(s string) {
+	for _, r := range s {
+		l.insert(r)
+	}
+}
+
+func (l *lineState) deleteAt(i int) {
+	if i < len(l.buf) {
+		l.buf = append(l.buf[:i], l.buf[i+1:]...)
+	}
+}
+
+func (l *lineState) move(delta int) {
+	l.pos += delta
+	if l.pos < 0 {
+		l.pos = 0
+	}
+	if l.pos > len(l.buf) {
+		l.pos = len(l.buf)
+	}
+}
+
+func (l *lineState) deleteWord() {
+	end := l.pos
+	for l.pos > 0 && l.buf[l.pos-1] == ' ' {
+		l.pos--
+	}
+	for l.pos > 0 && l.buf[l.pos-1] != ' ' {
+		l.pos--
+	}
+	l.buf = append(l.buf[:l.pos], l.buf[end:]...)
+}
+
+// recall moves through history; delta -1 is older, 1 is newer
+func (l *lineState) recall(delta int) {
+	entries := l.history.entries
+	next := l.historyPos + delta
+	if next < 0 || next > len(entries) {
+		return
+	}
+	if l.historyPos == len(entries) {
+		l.saved = append([]rune(nil), l.buf...)
+	}
+	l.historyPos = next
+	if next == len(entries) {
+		l.buf = append([]rune(nil), l.saved...)
+	} else {
+		l.buf = []rune(entries[next])
+	}
+	l.pos = len(l.buf)
+}
+
+// escape handles arrow, home, end and delete key sequences
+func (l *lineState) escape() {
+	r, _, err := l.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	r, _, err = l.in.ReadRune()
+	if err != nil {
+		return
+	}
+	switch r {
+	case 'A':
+		l.recall(-1)
+	case 'B':
+		l.recall(1)
+	case 'C':
+		l.move(1)
+	case 'D':
+		l.move(-1)
+	case 'H':
+		l.pos = 0
+	case 'F':
+		l.pos = len(l.buf)
+	case '1', '3', '4', '7', '8':
+		// ESC [ n ~
+		if tilde, _, err := l.in.ReadRune(); err != nil || tilde != '~' {
+			return
+		}
+		switch r {
+		case '1', '7':
+			l.pos = 0
+		case '4', '8':
+			l.pos = len(l.buf)
+		case '3':
+			l.deleteAt(l.pos)
+		}
+	}
+}
+
+// completeWord extends the word before the cursor to the longest common
+// prefix of the candidates, listing them when there is more than one
+func (l *lineState) completeWord() {
+	if l.complete == nil {
+		return
+	}
+	start, candidates := l.complete(string(l.buf), l.pos)
+	if len(candidates) == 0 {
+		return
+	}
+	typed := string(l.buf[start:l.pos])
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix = candidates[0]
+	}
+	if len(prefix) > len(typed) {
+		l.insertString(prefix[len(typed):])
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Fprint(l.out, "\r\n")
+		l.printColumns(candidates)
+	}
+}
+
+func (l *lineState) printColumns(items []string) {
+	width := 0
+	for _, item := range items {
+		if len(item) > width {
+			width = len(item)
+		}
+	}
+	width += 2
+	cols := terminalWidth(l.fd) / width
+	if cols < 1 {
+		cols = 1
+	}
+	for i, item := range items {
+		fmt.Fprintf(l.out, "%-*s", width, item)
+		if (i+1)%cols == 0 || i == len(items)-1 {
+			fmt.Fprint(l.out, "\r\n")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// internal/repl/history.go
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxHistory is the number of entries kept in the history file
+const maxHistory = 1000
+
+// history holds entered lines and persists them to a file
+type history struct {
+	path    string
+	entries []string
+}
+
+// historyPath returns $SENTRA_HISTORY or ~/.sentra_history
+func historyPath() string {
+	if path := os.Getenv("SENTRA_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sentra_history")
+}
+
+// loadHistory reads the history file. A missing file is not an error.
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+		h.rewrite()
+	}
+	return h
+}
+
+// add records a line, skipping blanks and immediate repeats
+func (h *history) add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if h.path == "" {
+		return
+	}
+	// Append as we go so history survives a crash or kill
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	f.WriteString(line + "\n")
+	f.Close()
+}
+
+// rewrite replaces the history file with the in-memory entries
+func (h *history) rewrite() {
+	var b strings.Builder
+	for _, line := range h.entries {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	os.WriteFile(h.path, []byte(b.String()), 0600)
+}
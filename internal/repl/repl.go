@@ -2,47 +2,115 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
 
-	"sentra/internal/compiler"
-	"sentra/internal/lexer"
-	"sentra/internal/parser"
-	"sentra/internal/vm"
+	"sentra/internal/vmregister"
 )
 
-func Start() {
-	fmt.Println("Sentra REPL | type 'exit' to quit")
-	scanner := bufio.NewScanner(os.Stdin)
+const (
+	prompt         = ">>> "
+	continuePrompt = "... "
+)
+
+// Start runs the interactive REPL on the register VM. configure, if non-nil,
+// is called on the VM before the first input (e.g. to install a module loader).
+func Start(configure func(*vmregister.RegisterVM)) {
+	fmt.Println("Sentra REPL | type :help for commands, 'exit' to quit")
 
-	sentraVM := vm.NewVM(nil)
+	session := NewSession(configure)
+	ed := newEditor(loadHistory(historyPath()), session.Complete)
 
+	var pending []string
 	for {
-		fmt.Print(">>> ")
-		if !scanner.Scan() {
-			break
+		p := prompt
+		if len(pending) > 0 {
+			p = continuePrompt
 		}
-		line := scanner.Text()
-		if line == "exit" {
+		line, err := ed.readLine(p)
+		if err == errInterrupt {
+			// Ctrl-C abandons the current (possibly multi-line) entry
+			pending = nil
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
 			break
 		}
+		ed.history.add(line)
 
-		lex := lexer.NewScanner(line)
-		tokens := lex.ScanTokens()
-		p := parser.NewParser(tokens)
-		stmts := p.Parse()
-
-		c := compiler.NewStmtCompiler()         // ⚠️ new compiler
-		// Convert to interface{} slice
-		var stmtInterfaces []interface{}
-		for _, s := range stmts {
-			stmtInterfaces = append(stmtInterfaces, s)
+		if len(pending) == 0 && strings.TrimSpace(line) == "exit" {
+			break
+		}
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			interruptible(session, func() {
+				if err := runCommand(session, line, os.Stdout); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
+			})
+			continue
+		}
+		pending = append(pending, line)
+		source := strings.Join(pending, "\n")
+		if incomplete(source) {
+			continue
+		}
+		pending = nil
+		if strings.TrimSpace(source) == "" {
+			continue
 		}
-		chunk := c.Compile(stmtInterfaces)               // fresh chunk
-		sentraVM.Reset(chunk)          // swap chunk
 
-		sentraVM.Run()
+		evaluate(session, source)
 	}
+	runExitHandlers(session)
 }
 
+// runExitHandlers runs the on_exit handlers of the session before quitting
+func runExitHandlers(session *Session) {
+	if err := session.VM().RunExitHandlers(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// evaluate runs one entry, echoing the value of a trailing expression
+func evaluate(session *Session, source string) {
+	interruptible(session, func() {
+		result, ok, err := session.Eval(source)
+		if exitErr, isExit := err.(*vmregister.ExitError); isExit {
+			runExitHandlers(session)
+			os.Exit(exitErr.Code)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		if ok && !vmregister.IsNil(result) {
+			fmt.Println(vmregister.ToString(result))
+		}
+	})
+}
+
+// interruptible runs fn so that Ctrl-C interrupts the running script
+// instead of exiting the REPL
+func interruptible(session *Session, fn func()) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupts:
+			session.VM().Interrupt()
+		case <-done:
+		}
+	}()
+	defer func() {
+		signal.Stop(interrupts)
+		close(done)
+	}()
+	fn()
+}
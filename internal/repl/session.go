@@ -0,0 +1,181 @@
+// internal/repl/session.go
+package repl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sentra/internal/compregister"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	"sentra/internal/vmregister"
+)
+
+// Session evaluates REPL input on a single register VM so that variables,
+// functions and imports persist between entries
+type Session struct {
+	vm        *vmregister.RegisterVM
+	configure func(*vmregister.RegisterVM)
+	defined   map[string]bool     // Globals declared by top-level statements
+	params    map[string][]string // Parameter names of functions defined in the session
+}
+
+// NewSession creates a session. configure, if non-nil, is called on the VM
+// before any input runs (e.g. to install a module loader).
+func NewSession(configure func(*vmregister.RegisterVM)) *Session {
+	s := &Session{configure: configure}
+	s.Reset()
+	return s
+}
+
+// Reset discards all definitions by starting over with a fresh VM
+func (s *Session) Reset() {
+	vm := vmregister.NewRegisterVM()
+	if s.configure != nil {
+		s.configure(vm)
+	}
+	s.vm = vm
+	s.defined = make(map[string]bool)
+	s.params = make(map[string][]string)
+}
+
+// VM returns the session's VM
+func (s *Session) VM() *vmregister.RegisterVM {
+	return s.vm
+}
+
+// Eval runs source and returns the value of a trailing expression statement.
+// ok is false when the input did not end with an expression.
+func (s *Session) Eval(source string) (vmregister.Value, bool, error) {
+	return s.eval(source, "<repl>")
+}
+
+// Load runs a script file in the session, keeping its definitions
+func (s *Session) Load(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Relative imports in the script resolve against its own directory
+	s.vm.SetCurrentFile(path)
+	defer s.vm.SetCurrentFile("<repl>")
+	_, _, err = s.eval(string(source), path)
+	return err
+}
+
+func (s *Session) eval(source, file string) (result vmregister.Value, ok bool, err error) {
+	stmts, err := parse(source, file)
+	if err != nil {
+		return vmregister.NilValue(), false, err
+	}
+	if len(stmts) == 0 {
+		return vmregister.NilValue(), false, nil
+	}
+	for _, stmt := range stmts {
+		s.recordDefinition(stmt)
+	}
+
+	// Return the last expression so its value can be echoed
+	if expr, isExpr := stmts[len(stmts)-1].(*parser.ExpressionStmt); isExpr {
+		stmts[len(stmts)-1] = &parser.ReturnStmt{Value: expr.Expr}
+		ok = true
+	}
+
+	globalNames, nextID := s.vm.GetGlobalNames()
+	c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+	mainFn, err := c.Compile(stmts)
+	if err != nil {
+		return vmregister.NilValue(), false, fmt.Errorf("compilation error: %w", err)
+	}
+
+	result, err = s.vm.Execute(mainFn, nil)
+	if err != nil {
+		return vmregister.NilValue(), false, err
+	}
+	return result, ok, nil
+}
+
+// recordDefinition notes the global names a top-level statement declares
+func (s *Session) recordDefinition(stmt parser.Stmt) {
+	switch st := stmt.(type) {
+	case *parser.LetStmt:
+		s.defined[st.Name] = true
+	case *parser.AssignmentStmt:
+		s.defined[st.Name] = true
+	case *parser.FunctionStmt:
+		s.defined[st.Name] = true
+		s.params[st.Name] = st.ParamNames()
+	case *parser.ClassStmt:
+		s.defined[st.Name] = true
+	case *parser.ExportStmt:
+		s.recordDefinition(st.Stmt)
+	case *parser.ImportStmt:
+		if len(st.Names) > 0 {
+			for _, n := range st.Names {
+				s.defined[n.LocalName()] = true
+			}
+		} else if st.Alias != "" {
+			s.defined[st.Alias] = true
+		} else {
+			s.defined[filepath.Base(st.Path)] = true
+		}
+	}
+}
+
+// parse converts parser panics into errors
+func parse(source, file string) (stmts []parser.Stmt, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	scanner := lexer.NewScannerWithFile(source, file)
+	tokens := scanner.ScanTokens()
+	p := parser.NewParserWithSource(tokens, source, file)
+	return p.Parse(), nil
+}
+
+// incomplete reports whether source has unclosed brackets or an unterminated
+// template string, meaning the REPL should read a continuation line
+func incomplete(source string) bool {
+	depth := 0
+	var quote rune
+	escaped := false
+	comment := false
+
+	runes := []rune(source)
+	for i, r := range runes {
+		switch {
+		case comment:
+			if r == '\n' {
+				comment = false
+			}
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+		case r == '"' || r == '`':
+			quote = r
+		case r == '#':
+			comment = true
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			comment = true
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+		}
+	}
+	return depth > 0 || quote == '`'
+}
@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+// internal/repl/term_bsd.go
+package repl
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
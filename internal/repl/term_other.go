@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+// internal/repl/term_other.go
+package repl
+
+import "errors"
+
+// terminalState is unused where raw mode is unsupported
+type terminalState struct{}
+
+// Line editing is not supported here, so input is read a line at a time
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
+
+func restore(fd int, state *terminalState) error {
+	return nil
+}
+
+func terminalWidth(fd int) int {
+	return 80
+}
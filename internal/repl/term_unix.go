@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+// internal/repl/term_unix.go
+package repl
+
+import "golang.org/x/sys/unix"
+
+// terminalState is the saved terminal mode restored after reading a line
+type terminalState struct {
+	termios unix.Termios
+}
+
+// isTerminal reports whether fd is a terminal
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	return err == nil
+}
+
+// makeRaw switches fd to raw mode so keys are read one at a time without
+// echo. Output processing is left on so "\n" still starts a new line.
+func makeRaw(fd int) (*terminalState, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	state := &terminalState{termios: *termios}
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restore puts the terminal back into the saved mode
+func restore(fd int, state *terminalState) error {
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, &state.termios)
+}
+
+// terminalWidth returns the number of columns, or 80 if unknown
+func terminalWidth(fd int) int {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
@@ -0,0 +1,398 @@
+// Package replay records what a Sentra run gets from the outside world,
+// such as HTTP responses, DNS answers, command output, the clock and random
+// numbers, so that the run can be repeated exactly from the recording. The
+// VMs route the builtins listed here through a Session.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the format of the files written by Recorder.Save
+const Version = 1
+
+// Session is a Recorder or a Player. The VMs call it instead of a recorded
+// builtin, passing the builtin's arguments and a function that runs it.
+// Values are nil, bool, int64, float64, string, []byte, []any,
+// map[string]any or Opaque.
+type Session interface {
+	Call(builtin string, args []any, call func() (any, error)) (any, error)
+}
+
+// Opaque stands for a value that cannot be recorded, such as a function
+// passed as a callback, by the name of its type
+type Opaque string
+
+// Log is the content of a replay file
+type Log struct {
+	Version  int       `json:"version"`
+	Script   string    `json:"script"`
+	SHA256   string    `json:"sha256"` // Of the script source
+	Args     []string  `json:"args,omitempty"`
+	Recorded time.Time `json:"recorded"`
+	Calls    []Call    `json:"calls"`
+}
+
+// Call is one recorded builtin call, with its arguments and its result or
+// error in the encoding of encodeValue
+type Call struct {
+	Builtin string `json:"builtin"`
+	Args    []any  `json:"args,omitempty"`
+	Result  any    `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Recorder runs builtins and records their results
+type Recorder struct {
+	mu    sync.Mutex
+	log   Log
+	depth int // Recorded calls in progress, see Call
+}
+
+// NewRecorder starts a recording of the script at path with the given
+// source and arguments
+func NewRecorder(path string, source []byte, args []string) *Recorder {
+	return &Recorder{log: Log{
+		Version:  Version,
+		Script:   path,
+		SHA256:   hash(source),
+		Args:     args,
+		Recorded: time.Now().UTC(),
+	}}
+}
+
+// Call runs a builtin and records its result. Builtins called back from
+// inside another recorded builtin, such as by an output callback of
+// process_run, run without being recorded, as the outer call is replayed
+// as a whole.
+func (r *Recorder) Call(builtin string, args []any, call func() (any, error)) (any, error) {
+	r.mu.Lock()
+	nested := r.depth > 0
+	r.depth++
+	r.mu.Unlock()
+	result, callErr := call()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.depth--
+	if nested {
+		return result, callErr
+	}
+
+	c := Call{Builtin: builtin}
+	for _, arg := range args {
+		encoded, _ := encodeValue(arg, true)
+		c.Args = append(c.Args, encoded)
+	}
+	if callErr != nil {
+		c.Error = callErr.Error()
+	} else {
+		encoded, err := encodeValue(result, false)
+		if err != nil {
+			return nil, fmt.Errorf("replay: cannot record the result of %s: %v", builtin, err)
+		}
+		c.Result = encoded
+	}
+	r.log.Calls = append(r.log.Calls, c)
+	return result, callErr
+}
+
+// Calls returns the number of calls recorded so far
+func (r *Recorder) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.log.Calls)
+}
+
+// Save writes the recording to path
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(&r.log, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Player replays a recording, returning the recorded results without
+// running the builtins
+type Player struct {
+	mu   sync.Mutex
+	log  Log
+	next int
+}
+
+// Load reads a file written by Recorder.Save
+func Load(path string) (*Player, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	p := &Player{}
+	if err := dec.Decode(&p.log); err != nil {
+		return nil, fmt.Errorf("%s: invalid replay file: %v", path, err)
+	}
+	if p.log.Version != Version {
+		return nil, fmt.Errorf("%s: replay file version %d is not supported", path, p.log.Version)
+	}
+	return p, nil
+}
+
+// Log returns the recording being replayed
+func (p *Player) Log() *Log {
+	return &p.log
+}
+
+// Matches reports whether source is the script that was recorded
+func (p *Player) Matches(source []byte) bool {
+	return p.log.SHA256 == hash(source)
+}
+
+// Remaining returns the number of recorded calls not replayed yet
+func (p *Player) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.log.Calls) - p.next
+}
+
+// Call returns the result of the next recorded call, or a
+// *DivergenceError if the script no longer makes that call
+func (p *Player) Call(builtin string, args []any, _ func() (any, error)) (any, error) {
+	encoded := make([]any, len(args))
+	for i, arg := range args {
+		encoded[i], _ = encodeValue(arg, true)
+	}
+	got := describe(builtin, encoded)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next >= len(p.log.Calls) {
+		return nil, &DivergenceError{Index: p.next, Got: got}
+	}
+	c := p.log.Calls[p.next]
+	if want := describe(c.Builtin, c.Args); want != got {
+		return nil, &DivergenceError{Index: p.next, Want: want, Got: got}
+	}
+	p.next++
+	if c.Error != "" {
+		return nil, errors.New(c.Error)
+	}
+	return decodeValue(c.Result), nil
+}
+
+// DivergenceError is returned when a replayed script makes a call other
+// than the one recorded, because the script, its arguments or the files
+// it reads changed
+type DivergenceError struct {
+	Index int    // Of the call in the recording
+	Want  string // The recorded call, empty past the end of the recording
+	Got   string
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Want == "" {
+		return fmt.Sprintf("replay diverged: call %d %s was not recorded", e.Index+1, e.Got)
+	}
+	return fmt.Sprintf("replay diverged at call %d: recorded %s, script called %s", e.Index+1, e.Want, e.Got)
+}
+
+// describe renders a call with encoded arguments for comparing it with a
+// recorded one
+func describe(builtin string, args []any) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		data, _ := json.Marshal(arg)
+		parts[i] = string(data)
+	}
+	return builtin + "(" + strings.Join(parts, ", ") + ")"
+}
+
+// hash returns the hex SHA-256 of data
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeValue converts a value to one encoding/json writes without losing
+// its type. Whole floats keep a decimal point, and bytes, floats JSON
+// cannot hold and opaque values become single key objects with a $ key;
+// map keys starting with $ get another. Opaque values are an error unless
+// allowed.
+func encodeValue(v any, allowOpaque bool) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, int64, string:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]any{"$float": strconv.FormatFloat(v, 'g', -1, 64)}, nil
+		}
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eE") {
+			text += ".0"
+		}
+		return json.Number(text), nil
+	case []byte:
+		return map[string]any{"$bytes": base64.StdEncoding.EncodeToString(v)}, nil
+	case Opaque:
+		if !allowOpaque {
+			return nil, fmt.Errorf("a %s is not a value that can be recorded", string(v))
+		}
+		return map[string]any{"$opaque": string(v)}, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			encoded, err := encodeValue(elem, allowOpaque)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = encoded
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, elem := range v {
+			encoded, err := encodeValue(elem, allowOpaque)
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(key, "$") {
+				key = "$" + key
+			}
+			out[key] = encoded
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// decodeValue reverses encodeValue on a value read with json.Decoder's
+// UseNumber
+func decodeValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		text := string(v)
+		if !strings.ContainsAny(text, ".eE") {
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				return n
+			}
+		}
+		f, _ := strconv.ParseFloat(text, 64)
+		return f
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = decodeValue(elem)
+		}
+		return out
+	case map[string]any:
+		if len(v) == 1 {
+			for key, elem := range v {
+				text, _ := elem.(string)
+				switch key {
+				case "$float":
+					f, _ := strconv.ParseFloat(text, 64)
+					return f
+				case "$bytes":
+					data, _ := base64.StdEncoding.DecodeString(text)
+					return data
+				case "$opaque":
+					return Opaque(text)
+				}
+			}
+		}
+		out := make(map[string]any, len(v))
+		for key, elem := range v {
+			out[strings.TrimPrefix(key, "$")] = decodeValue(elem)
+		}
+		return out
+	}
+	return v
+}
+
+// Builtins returns the names of the recorded builtins, sorted
+func Builtins() []string {
+	names := make([]string, 0, len(recorded))
+	for name := range recorded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recorded lists the builtins whose results depend on the outside world.
+// Servers and listeners are left out: they are driven by their clients,
+// which a recording cannot replay. Files are read as usual.
+var recorded = map[string]bool{
+	// The clock and random numbers
+	"time": true, "time_ms": true, "time_now": true, "timestamp": true, "now": true,
+	"date": true, "datetime": true, "sleep": true,
+	"random": true, "randint": true, "random_int": true, "generate_random": true,
+	"generate_random_hex": true, "generate_id": true, "generate_password": true,
+	"generate_api_key": true, "crypto_generate_key": true,
+
+	// HTTP and web clients
+	"http_get": true, "http_post": true, "http_put": true, "http_delete": true,
+	"http_request": true, "http_json": true, "http_download": true, "fetch": true,
+	"web_request": true, "web_post_json": true, "web_login": true, "web_oauth2_token": true,
+	"web_crawl": true, "web_scan_vulnerabilities": true,
+	"web_graphql": true, "web_graphql_schema": true, "web_test_graphql": true,
+	"web_test_injection": true, "web_test_cors": true, "web_test_headers": true,
+	"web_test_rate_limit": true, "web_api_scan": true, "web_test_auth": true,
+	"web_fuzz_api": true, "test_injection": true, "test_rate_limiting": true,
+	"test_cors": true, "test_headers": true, "test_authentication": true,
+	"test_authorization": true, "api_scan": true, "fuzz_api": true, "scan_openapi": true,
+
+	// Sockets, WebSocket clients and databases, with the calls on their handles
+	"socket_create": true, "socket_send": true, "socket_send_bytes": true,
+	"socket_receive": true, "socket_receive_bytes": true, "socket_close": true,
+	"tcp_connect": true, "ws_connect": true, "ws_send": true, "ws_send_binary": true,
+	"ws_receive": true, "ws_ping": true, "ws_close": true,
+	"db_connect": true, "sql_connect": true, "db_query": true, "db_execute": true,
+	"sql_execute": true, "db_close": true, "db_exec": true, "db_begin": true,
+	"db_commit": true, "db_rollback": true, "db_cursor": true, "db_next": true,
+	"db_cursor_close": true,
+
+	// DNS, scans and threat intelligence
+	"dns_lookup": true, "dns_query": true, "dns_reverse": true, "ping": true, "ping_sweep": true, "traceroute": true, "tcp_scan": true, "port_scan": true,
+	"snmp_get": true, "snmp_walk": true,
+	"mail_send": true, "mail_check_smtp": true, "mail_check_domain": true, "mail_fetch": true, "mail_mailboxes": true,
+	"ldap_connect": true, "ldap_search": true, "ldap_close": true, "ad_kerberoastable": true,
+	"ad_asrep_roastable": true, "ad_stale_accounts": true, "ad_privileged_members": true, "ad_password_policy": true,
+	"grpc_connect": true, "grpc_methods": true, "grpc_call": true, "grpc_close": true,
+	"mqtt_connect": true, "mqtt_subscribe": true, "mqtt_publish": true, "mqtt_messages": true, "mqtt_close": true,
+	"amqp_connect": true, "amqp_declare_queue": true, "amqp_publish": true, "amqp_get": true, "amqp_close": true,
+	"iot_protocol_security": true, "advanced_port_scan": true, "scan_ports": true, "scan_network": true,
+	"network_scan": true, "discover_network_topology": true, "scan_service_version": true,
+	"scan_os_fingerprint": true, "scan_vulnerabilities": true, "analyze_ssl": true,
+	"crypto_analyze_tls": true, "db_scan_services": true, "db_test_credentials": true,
+	"threat_lookup_ip": true, "threat_lookup_domain": true, "threat_lookup_hash": true,
+	"threat_get_reputation": true, "threat_bulk_lookup": true,
+
+	// Packet capture, with the calls on capture handles
+	"capture_start": true, "capture_stop": true, "capture_get_packets": true,
+	"capture_save_pcap": true, "capture_stream": true, "packet_capture": true,
+
+	// Programs, the environment and the host
+	"process_run": true, "process_spawn": true, "process_write": true,
+	"process_close_stdin": true, "process_wait": true, "process_kill": true,
+	"os_exec": true, "env": true,
+	"os_processes": true, "os_ports": true, "os_info": true, "os_privileges": true,
+	"os_users": true, "os_services": true,
+	"mem_enum_processes": true, "mem_find_process": true, "mem_get_process_tree": true,
+	"mem_get_process_info": true, "mem_get_children": true,
+}
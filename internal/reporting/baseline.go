@@ -0,0 +1,406 @@
+package reporting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fingerprintFields are the fields of a finding, as FindingToMap names
+// them, that identify it across scans. They are those tickets are told
+// apart by, so a finding's fingerprint is also the one of its ticket.
+var fingerprintFields = []string{"title", "type", "host", "target", "port", "path", "url", "parameter", "cve", "cwe", "rule", "location"}
+
+// Fingerprint identifies a finding by where it is and what it is, leaving
+// out what changes between scans: its id, severity, description, evidence
+// and times
+func Fingerprint(f SecurityFinding) string {
+	item := FindingToMap(f)
+	h := sha256.New()
+	for _, field := range fingerprintFields {
+		v, ok := item[field]
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		value, _ := json.Marshal(v)
+		fmt.Fprintf(h, "%s=%s\n", field, value)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// mergeFinding updates a reported finding with the same finding seen
+// again. It keeps its id and when it was first found, and gathers the
+// evidence and tags of both.
+func mergeFinding(reported *SecurityFinding, seen SecurityFinding) {
+	id, firstFound := reported.ID, reported.FirstFound
+	evidence, tags := reported.Evidence, reported.Tags
+	if seen.CVSS.Score == 0 {
+		seen.CVSS = reported.CVSS
+	}
+	*reported = seen
+	reported.ID = id
+	if !firstFound.IsZero() && (seen.FirstFound.IsZero() || firstFound.Before(seen.FirstFound)) {
+		reported.FirstFound = firstFound
+	}
+	reported.LastSeen = time.Now()
+
+	reported.Evidence = evidence
+	for _, e := range seen.Evidence {
+		duplicate := false
+		for _, have := range evidence {
+			if have.Type == e.Type && have.Data == e.Data {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			reported.Evidence = append(reported.Evidence, e)
+		}
+	}
+	reported.Tags = tags
+	for _, tag := range seen.Tags {
+		duplicate := false
+		for _, have := range tags {
+			if have == tag {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			reported.Tags = append(reported.Tags, tag)
+		}
+	}
+}
+
+// Suppression accepts the findings it matches, by their fingerprint or by
+// title, target and weakness, for a reason and until it expires. Title
+// and target are patterns of path.Match.
+type Suppression struct {
+	Fingerprint   string `json:"fingerprint,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Target        string `json:"target,omitempty"`
+	CWE           string `json:"cwe,omitempty"`
+	CVE           string `json:"cve,omitempty"`
+	Justification string `json:"justification"`
+	Owner         string `json:"owner,omitempty"`
+	Expires       string `json:"expires,omitempty"` // A date or an RFC 3339 time, never when empty
+
+	expires time.Time
+}
+
+// validate checks a suppression and parses when it expires
+func (s *Suppression) validate() error {
+	if s.Fingerprint == "" && s.Title == "" && s.CWE == "" && s.CVE == "" {
+		return fmt.Errorf("a suppression needs a fingerprint, title, cwe or cve")
+	}
+	if strings.TrimSpace(s.Justification) == "" {
+		return fmt.Errorf("the suppression of %s has no justification", s.name())
+	}
+	for _, pattern := range []string{s.Title, s.Target} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("the suppression of %s: bad pattern %q", s.name(), pattern)
+		}
+	}
+	if s.Expires == "" {
+		return nil
+	}
+	var err error
+	if s.expires, err = time.Parse("2006-01-02", s.Expires); err == nil {
+		// A date suppresses until the end of it
+		s.expires = s.expires.AddDate(0, 0, 1)
+	} else if s.expires, err = time.Parse(time.RFC3339, s.Expires); err != nil {
+		return fmt.Errorf("the suppression of %s expires %q, not a date", s.name(), s.Expires)
+	}
+	return nil
+}
+
+// name names a suppression in errors
+func (s *Suppression) name() string {
+	for _, name := range []string{s.Fingerprint, s.Title, s.CWE, s.CVE} {
+		if name != "" {
+			return name
+		}
+	}
+	return "nothing"
+}
+
+// Matches reports whether a suppression applies to a finding, expired or
+// not
+func (s *Suppression) Matches(f SecurityFinding) bool {
+	if s.Fingerprint != "" && s.Fingerprint != f.Fingerprint {
+		return false
+	}
+	if s.Title != "" {
+		if ok, _ := path.Match(strings.ToLower(s.Title), strings.ToLower(f.Title)); !ok {
+			return false
+		}
+	}
+	if s.Target != "" {
+		if ok, _ := path.Match(s.Target, f.Location.Target); !ok {
+			return false
+		}
+	}
+	if s.CWE != "" && !strings.EqualFold(s.CWE, f.CWE) {
+		return false
+	}
+	return s.CVE == "" || strings.EqualFold(s.CVE, f.CVE)
+}
+
+// Expired reports whether a suppression has expired at a time
+func (s *Suppression) Expired(at time.Time) bool {
+	return !s.expires.IsZero() && !at.Before(s.expires)
+}
+
+// NewSuppressions checks suppressions, as they are written in a
+// suppression file
+func NewSuppressions(suppressions []Suppression) ([]Suppression, error) {
+	checked := make([]Suppression, len(suppressions))
+	for i, s := range suppressions {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		checked[i] = s
+	}
+	return checked, nil
+}
+
+// LoadSuppressions reads a suppression file, a JSON array of suppressions
+// or an object with them under "suppressions"
+func LoadSuppressions(filename string) ([]Suppression, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var suppressions []Suppression
+	if err := json.Unmarshal(data, &suppressions); err != nil {
+		var file struct {
+			Suppressions []Suppression `json:"suppressions"`
+		}
+		if json.Unmarshal(data, &file) != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		suppressions = file.Suppressions
+	}
+	suppressions, err = NewSuppressions(suppressions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return suppressions, nil
+}
+
+// Regression is a finding of a baseline that came back or got more severe
+type Regression struct {
+	Finding  SecurityFinding
+	Previous SecurityFinding
+	Reason   string
+}
+
+// SuppressedFinding is a finding left out of a diff by a suppression
+type SuppressedFinding struct {
+	Finding     SecurityFinding
+	Suppression Suppression
+}
+
+// ReportDiff is how a report differs from a baseline: what is new, what
+// regressed and what was fixed. Findings that are suppressed are neither
+// new nor regressed, and the suppressions that expired are listed so
+// they can be reviewed.
+type ReportDiff struct {
+	New        []SecurityFinding
+	Regressed  []Regression
+	Fixed      []SecurityFinding
+	Suppressed []SuppressedFinding
+	Expired    []Suppression
+	Unchanged  int
+}
+
+// closedStatuses are the statuses of findings that are no longer open
+var closedStatuses = map[string]bool{"FIXED": true, "CLOSED": true, "RESOLVED": true, "FALSE_POSITIVE": true}
+
+// LoadReport reads a report exported as JSON. The fingerprints of findings
+// exported without them are worked out.
+func LoadReport(filename string) (*SecurityReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var report SecurityReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for i := range report.Findings {
+		if report.Findings[i].Fingerprint == "" {
+			report.Findings[i].Fingerprint = Fingerprint(report.Findings[i])
+		}
+	}
+	return &report, nil
+}
+
+// findReport returns a report by its id or, when there is no such report,
+// reads it from a JSON export. A relative filename is looked for in the
+// output directory as well.
+func (rm *ReportingModule) findReport(ref string) (*SecurityReport, error) {
+	rm.mu.RLock()
+	report, exists := rm.Reports[ref]
+	rm.mu.RUnlock()
+	if exists {
+		return report, nil
+	}
+	if inOutput := filepath.Join(rm.Config.OutputDirectory, ref); !fileExists(ref) && !filepath.IsAbs(ref) && fileExists(inOutput) {
+		ref = inOutput
+	}
+	if !fileExists(ref) {
+		return nil, fmt.Errorf("report not found: %s", ref)
+	}
+	return LoadReport(ref)
+}
+
+// fileExists reports whether a file exists
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && !info.IsDir()
+}
+
+// DiffReports compares a report with a baseline, both report ids or JSON
+// exports. The suppressions that have not expired at a time leave out the
+// findings they match.
+func (rm *ReportingModule) DiffReports(baselineRef, reportRef string, suppressions []Suppression, at time.Time) (*ReportDiff, error) {
+	baseline, err := rm.findReport(baselineRef)
+	if err != nil {
+		return nil, err
+	}
+	report, err := rm.findReport(reportRef)
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	previous := make(map[string]SecurityFinding, len(baseline.Findings))
+	for _, f := range baseline.Findings {
+		previous[fingerprintOf(f)] = f
+	}
+	diff := &ReportDiff{}
+	expired := make(map[int]bool)
+	seen := make(map[string]bool, len(report.Findings))
+	for _, f := range report.Findings {
+		fp := fingerprintOf(f)
+		seen[fp] = true
+		if closedStatuses[strings.ToUpper(f.Status)] {
+			continue
+		}
+
+		suppressed := false
+		for i := range suppressions {
+			s := &suppressions[i]
+			if !s.Matches(f) {
+				continue
+			}
+			if s.Expired(at) {
+				expired[i] = true
+				continue
+			}
+			diff.Suppressed = append(diff.Suppressed, SuppressedFinding{Finding: f, Suppression: *s})
+			suppressed = true
+			break
+		}
+		if suppressed {
+			continue
+		}
+
+		old, found := previous[fp]
+		switch {
+		case !found:
+			diff.New = append(diff.New, f)
+		case closedStatuses[strings.ToUpper(old.Status)]:
+			diff.Regressed = append(diff.Regressed, Regression{Finding: f, Previous: old, Reason: "reopened, it was " + strings.ToLower(old.Status)})
+		case severityRank(f.Severity) < severityRank(old.Severity):
+			diff.Regressed = append(diff.Regressed, Regression{Finding: f, Previous: old,
+				Reason: fmt.Sprintf("severity rose from %s to %s", strings.ToUpper(old.Severity), strings.ToUpper(f.Severity))})
+		default:
+			diff.Unchanged++
+		}
+	}
+	for _, f := range baseline.Findings {
+		if !seen[fingerprintOf(f)] && !closedStatuses[strings.ToUpper(f.Status)] {
+			diff.Fixed = append(diff.Fixed, f)
+		}
+	}
+	for i := range suppressions {
+		if expired[i] {
+			diff.Expired = append(diff.Expired, suppressions[i])
+		}
+	}
+	return diff, nil
+}
+
+// fingerprintOf returns the fingerprint of a finding, working it out for
+// findings added before they had one
+func fingerprintOf(f SecurityFinding) string {
+	if f.Fingerprint != "" {
+		return f.Fingerprint
+	}
+	return Fingerprint(f)
+}
+
+// SuppressionToMap returns a suppression as a map
+func SuppressionToMap(s Suppression) map[string]interface{} {
+	return map[string]interface{}{
+		"fingerprint":   s.Fingerprint,
+		"title":         s.Title,
+		"target":        s.Target,
+		"cwe":           s.CWE,
+		"cve":           s.CVE,
+		"justification": s.Justification,
+		"owner":         s.Owner,
+		"expires":       s.Expires,
+	}
+}
+
+// DiffToMap returns a diff as a map of new, regressed, fixed, suppressed
+// and expired, lists of findings as FindingToMap returns them with their
+// fingerprint, and unchanged, how many findings are in both
+func DiffToMap(d *ReportDiff) map[string]interface{} {
+	finding := func(f SecurityFinding) map[string]interface{} {
+		m := FindingToMap(f)
+		m["fingerprint"] = fingerprintOf(f)
+		return m
+	}
+	m := map[string]interface{}{
+		"new":        []interface{}{},
+		"regressed":  []interface{}{},
+		"fixed":      []interface{}{},
+		"suppressed": []interface{}{},
+		"expired":    []interface{}{},
+		"unchanged":  d.Unchanged,
+	}
+	for _, f := range d.New {
+		m["new"] = append(m["new"].([]interface{}), finding(f))
+	}
+	for _, r := range d.Regressed {
+		f := finding(r.Finding)
+		f["reason"] = r.Reason
+		f["previous_severity"] = r.Previous.Severity
+		m["regressed"] = append(m["regressed"].([]interface{}), f)
+	}
+	for _, f := range d.Fixed {
+		m["fixed"] = append(m["fixed"].([]interface{}), finding(f))
+	}
+	for _, s := range d.Suppressed {
+		f := finding(s.Finding)
+		f["justification"] = s.Suppression.Justification
+		f["expires"] = s.Suppression.Expires
+		m["suppressed"] = append(m["suppressed"].([]interface{}), f)
+	}
+	for _, s := range d.Expired {
+		m["expired"] = append(m["expired"].([]interface{}), SuppressionToMap(s))
+	}
+	return m
+}
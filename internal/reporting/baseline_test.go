@@ -0,0 +1,142 @@
+package reporting
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprintDedup(t *testing.T) {
+	rm := NewReportingModule()
+	rm.CreateReport("r1", "Scan", "", TargetInfo{})
+	ssh := SecurityFinding{Title: "SSH open", Severity: "low", Location: FindingLocation{Target: "web1"}, Custom: map[string]interface{}{"port": 22},
+		Evidence: []Evidence{{Type: "BANNER", Data: "SSH-2.0-OpenSSH_7.4"}}, Tags: []string{"ssh"}}
+	for _, f := range []SecurityFinding{ssh, {Title: "SSH open", Severity: "low", Location: FindingLocation{Target: "web2"}, Custom: map[string]interface{}{"port": 22}}} {
+		if err := rm.AddFinding("r1", f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	again := ssh
+	again.Severity, again.Description = "high", "Password logins are allowed."
+	again.Evidence = []Evidence{{Type: "BANNER", Data: "SSH-2.0-OpenSSH_7.4"}, {Type: "LOG", Data: "password accepted"}}
+	again.Tags = []string{"ssh", "auth"}
+	if err := rm.AddFinding("r1", again); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, _ := rm.ReportFindings("r1")
+	if len(findings) != 2 {
+		t.Fatalf("%d findings, want 2", len(findings))
+	}
+	merged := findings[0]
+	if merged.ID != "FIND-1" || merged.Severity != "HIGH" || merged.Description != "Password logins are allowed." || len(merged.Evidence) != 2 || strings.Join(merged.Tags, ",") != "ssh,auth" {
+		t.Errorf("merged finding %+v", merged)
+	}
+	if merged.Fingerprint == findings[1].Fingerprint || len(merged.Fingerprint) != 32 {
+		t.Errorf("fingerprints %s and %s", merged.Fingerprint, findings[1].Fingerprint)
+	}
+	if rm.Reports["r1"].Metrics.TotalFindings != 2 {
+		t.Errorf("metrics count %d findings", rm.Reports["r1"].Metrics.TotalFindings)
+	}
+	// Severity, description and evidence are not part of the fingerprint
+	if Fingerprint(SecurityFinding{Title: "SSH open", Location: FindingLocation{Target: "web1"}, Custom: map[string]interface{}{"port": 22}}) != merged.Fingerprint {
+		t.Errorf("fingerprint depends on more than where and what a finding is")
+	}
+}
+
+func TestDiffReports(t *testing.T) {
+	rm := NewReportingModule()
+	rm.Config.OutputDirectory = t.TempDir()
+	rm.CreateReport("old", "Baseline", "", TargetInfo{})
+	rm.CreateReport("new", "Scan", "", TargetInfo{})
+	for _, f := range []SecurityFinding{
+		{Title: "SSH open", Severity: "low", Location: FindingLocation{Target: "web1"}},
+		{Title: "Weak TLS", Severity: "medium", Location: FindingLocation{Target: "web1"}},
+		{Title: "Default password", Severity: "high", Location: FindingLocation{Target: "db1"}, Status: "FIXED"},
+		{Title: "Telnet open", Severity: "high", Location: FindingLocation{Target: "web3"}},
+	} {
+		rm.AddFinding("old", f)
+	}
+	for _, f := range []SecurityFinding{
+		{Title: "SSH open", Severity: "critical", Location: FindingLocation{Target: "web1"}},
+		{Title: "Weak TLS", Severity: "low", Location: FindingLocation{Target: "web1"}},
+		{Title: "Default password", Severity: "high", Location: FindingLocation{Target: "db1"}},
+		{Title: "SQL injection", Severity: "critical", Location: FindingLocation{Target: "app"}, CWE: "CWE-89"},
+		{Title: "Directory listing", Severity: "low", Location: FindingLocation{Target: "static1"}},
+		{Title: "Directory listing", Severity: "low", Location: FindingLocation{Target: "static2"}},
+	} {
+		rm.AddFinding("new", f)
+	}
+	// The baseline is read back from its export
+	if err := rm.ExportReport("old", "json", "baseline.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	suppressions, err := NewSuppressions([]Suppression{
+		{Title: "directory listing", Target: "static*", Justification: "Public mirrors", Expires: "2030-01-01"},
+		{CWE: "cwe-89", Justification: "WAF rule 942100 blocks it", Expires: "2024-06-30"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := rm.DiffReports("baseline.json", "new", suppressions, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := func(findings []SecurityFinding) string {
+		var names []string
+		for _, f := range findings {
+			names = append(names, f.Title)
+		}
+		return strings.Join(names, ", ")
+	}
+	if got := titles(diff.New); got != "SQL injection" {
+		t.Errorf("new: %s", got)
+	}
+	if got := titles(diff.Fixed); got != "Telnet open" {
+		t.Errorf("fixed: %s", got)
+	}
+	if len(diff.Regressed) != 2 || diff.Regressed[0].Reason != "severity rose from LOW to CRITICAL" || diff.Regressed[1].Reason != "reopened, it was fixed" {
+		t.Errorf("regressed: %+v", diff.Regressed)
+	}
+	if len(diff.Suppressed) != 2 || diff.Suppressed[0].Suppression.Justification != "Public mirrors" {
+		t.Errorf("suppressed: %+v", diff.Suppressed)
+	}
+	if len(diff.Expired) != 1 || diff.Expired[0].CWE != "cwe-89" || diff.Unchanged != 1 {
+		t.Errorf("expired: %+v, unchanged %d", diff.Expired, diff.Unchanged)
+	}
+
+	// A date suppresses until its end
+	s := suppressions[1]
+	if s.Expired(time.Date(2024, 6, 30, 23, 0, 0, 0, time.UTC)) || !s.Expired(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("suppression expiring 2024-06-30 expired at the wrong time")
+	}
+	if _, err := rm.DiffReports("old", "missing.json", nil, time.Now()); err == nil || err.Error() != "report not found: missing.json" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoadSuppressions(t *testing.T) {
+	dir := t.TempDir()
+	for content, want := range map[string]string{
+		`{"suppressions": [{"fingerprint": "ab12", "justification": "Accepted risk", "owner": "sec"}]}`: "",
+		`[{"title": "SSH open", "justification": "Bastion", "expires": "2026-03-01T12:00:00Z"}]`:        "",
+		`[{"title": "SSH open"}]`:  "the suppression of SSH open has no justification",
+		`[{"justification": "x"}]`: "a suppression needs a fingerprint, title, cwe or cve",
+		`[{"cve": "CVE-2024-1", "justification": "x", "expires": "soon"}]`: `the suppression of CVE-2024-1 expires "soon", not a date`,
+		`[{"title": "[", "justification": "x"}]`:                           `the suppression of [: bad pattern "["`,
+		`"suppressions"`:                                                   "cannot unmarshal",
+	} {
+		filename := filepath.Join(dir, "suppressions.json")
+		os.WriteFile(filename, []byte(content), 0644)
+		suppressions, err := LoadSuppressions(filename)
+		switch {
+		case want == "" && (err != nil || len(suppressions) != 1):
+			t.Errorf("%s: %v", content, err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("%s: err = %v, want %s", content, err, want)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package reporting
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The page is A4 in points, with the margins of the text
+const (
+	pdfWidth  = 595.0
+	pdfHeight = 842.0
+	pdfMargin = 50.0
+)
+
+// helveticaWidths are the widths of the printable ASCII characters of
+// Helvetica in thousandths of the font size, from its metrics
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfWriter lays out text and shapes on pages and writes them as a PDF
+// with the standard Helvetica fonts, so that it needs no font files
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // Of the next line from the bottom of the page
+	title string
+}
+
+func newPDFWriter(title string) *pdfWriter {
+	w := &pdfWriter{title: title}
+	w.newPage()
+	return w
+}
+
+func (w *pdfWriter) newPage() {
+	w.page = &bytes.Buffer{}
+	w.pages = append(w.pages, w.page)
+	w.y = pdfHeight - pdfMargin
+}
+
+// need starts a new page unless there is room for a height
+func (w *pdfWriter) need(height float64) {
+	if w.y-height < pdfMargin {
+		w.newPage()
+	}
+}
+
+// pdfText returns text as a PDF string in WinAnsiEncoding, with the
+// characters it lacks replaced
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '’' || r == '‘':
+			b.WriteByte('\'')
+		case r == '“' || r == '”':
+			b.WriteByte('"')
+		case r == '–' || r == '—':
+			b.WriteByte('-')
+		case r == '•':
+			b.WriteString("\\225")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// textWidth returns the width of text in points, a little wider for bold
+func textWidth(s string, size float64, bold bool) float64 {
+	units := 0
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	width := float64(units) * size / 1000
+	if bold {
+		width *= 1.08
+	}
+	return width
+}
+
+// wrap breaks text into lines no wider than a width, breaking words that
+// are wider than a line
+func wrap(s string, width, size float64, bold bool) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r", ""), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for textWidth(word, size, bold) > width {
+				runes := []rune(word)
+				cut := len(runes) - 1
+				for cut > 1 && textWidth(string(runes[:cut]), size, bold) > width {
+					cut--
+				}
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, string(runes[:cut]))
+				word = string(runes[cut:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case textWidth(line+" "+word, size, bold) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// color returns the fill color operator of a #rrggbb color
+func color(hex string) string {
+	v, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil {
+		return "0 g"
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f rg", float64(v>>16&0xff)/255, float64(v>>8&0xff)/255, float64(v&0xff)/255)
+}
+
+// textAt draws a line of text at a position
+func (w *pdfWriter) textAt(x, y float64, s string, size float64, bold bool, fill string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(w.page, "%s BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", color(fill), font, size, x, y, pdfText(s))
+}
+
+// rect fills a rectangle whose bottom left corner is at a position
+func (w *pdfWriter) rect(x, y, width, height float64, fill string) {
+	fmt.Fprintf(w.page, "%s %.2f %.2f %.2f %.2f re f\n", color(fill), x, y, width, height)
+}
+
+// paragraph writes wrapped text from the left margin, indented by indent
+func (w *pdfWriter) paragraph(s string, size float64, bold bool, fill string, indent float64) {
+	leading := size * 1.35
+	for _, line := range wrap(s, pdfWidth-2*pdfMargin-indent, size, bold) {
+		w.need(leading)
+		w.y -= leading
+		w.textAt(pdfMargin+indent, w.y+size*0.3, line, size, bold, fill)
+	}
+}
+
+// heading writes a heading with some room above it, keeping it on the
+// page of what follows it
+func (w *pdfWriter) heading(s string, size float64) {
+	w.need(size*3 + 40)
+	w.y -= size * 0.8
+	w.paragraph(s, size, true, "#2c3e50", 0)
+	w.y -= size * 0.3
+}
+
+// field writes a labelled value, if there is one
+func (w *pdfWriter) field(label, value string, indent float64) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	w.paragraph(label+": "+value, 10, false, "#222222", indent)
+}
+
+// banner writes the title block of a report
+func (w *pdfWriter) banner(report *SecurityReport, subtitle string) {
+	w.rect(0, pdfHeight-110, pdfWidth, 110, "#2c3e50")
+	w.textAt(pdfMargin, pdfHeight-55, report.Title, 20, true, "#ffffff")
+	target := report.Target.Name
+	if report.Target.Description != "" {
+		target += ": " + report.Target.Description
+	}
+	w.textAt(pdfMargin, pdfHeight-75, target, 11, false, "#ffffff")
+	w.textAt(pdfMargin, pdfHeight-92, subtitle+" · generated on "+report.GeneratedDate.Format("2006-01-02 15:04"), 10, false, "#dddddd")
+	w.y = pdfHeight - 130
+}
+
+// chart draws the severity distribution as a bar chart
+func (w *pdfWriter) chart(view *ReportView) {
+	const rowHeight, barWidth = 22.0, 300.0
+	w.need(rowHeight*float64(len(view.Severities)) + 10)
+	for _, c := range view.Severities {
+		w.y -= rowHeight
+		width := float64(c.Count) * barWidth / float64(view.maxCount())
+		w.textAt(pdfMargin, w.y+5, c.Severity, 10, true, "#222222")
+		if width > 0 {
+			w.rect(pdfMargin+80, w.y+1, width, 16, c.Color)
+		}
+		w.textAt(pdfMargin+86+width, w.y+5, strconv.Itoa(c.Count), 10, false, "#222222")
+	}
+	w.y -= 10
+}
+
+// executive lays out the summary of a report for managers
+func (w *pdfWriter) executive(view *ReportView) {
+	exec := view.Executive
+	w.heading("Executive Summary", 16)
+	w.need(24)
+	w.y -= 22
+	w.textAt(pdfMargin, w.y+6, "Overall risk:", 11, true, "#222222")
+	label := exec.RiskLevel
+	if label == "" {
+		label = "UNKNOWN"
+	}
+	w.rect(pdfMargin+80, w.y+1, textWidth(label, 11, true)+16, 18, severityColors[label])
+	w.textAt(pdfMargin+88, w.y+6, label, 11, true, "#ffffff")
+	w.y -= 6
+	w.paragraph(exec.Overview, 11, false, "#222222", 0)
+	if exec.BusinessImpact != "" {
+		w.paragraph("Business impact: "+exec.BusinessImpact, 11, false, "#222222", 0)
+	}
+	if exec.Timeline != "" {
+		w.paragraph("Timeline: "+exec.Timeline, 11, false, "#222222", 0)
+	}
+
+	w.heading("Findings by Severity", 14)
+	w.chart(view)
+	if len(exec.TopRisks) > 0 {
+		w.heading("Top Risks", 14)
+		for i, risk := range exec.TopRisks {
+			w.paragraph(fmt.Sprintf("%d. %s", i+1, risk), 11, false, "#222222", 10)
+		}
+	}
+	if len(view.Recommendations) > 0 {
+		w.heading("Recommendations", 14)
+		for _, r := range view.Recommendations {
+			title := r.Title
+			if r.Priority != "" {
+				title += " (" + r.Priority + ")"
+			}
+			w.paragraph(title, 11, true, "#222222", 0)
+			w.paragraph(r.Description, 10, false, "#222222", 10)
+			for i, step := range r.Steps {
+				w.paragraph(fmt.Sprintf("%d. %s", i+1, step), 10, false, "#222222", 20)
+			}
+		}
+	}
+}
+
+// technical lays out the details of every finding of a report
+func (w *pdfWriter) technical(view *ReportView) {
+	w.heading("Summary", 16)
+	if view.Description != "" {
+		w.paragraph(view.Description, 11, false, "#222222", 0)
+	}
+	w.paragraph(fmt.Sprintf("Risk level: %s · findings: %d", view.Executive.RiskLevel, len(view.Findings)), 11, true, "#222222", 0)
+	w.y -= 6
+	w.chart(view)
+
+	w.heading("Findings", 16)
+	if len(view.Sorted) == 0 {
+		w.paragraph("No findings.", 11, false, "#222222", 0)
+	}
+	for _, f := range view.Sorted {
+		severity := strings.ToUpper(f.Severity)
+		w.need(60)
+		top, page := w.y, len(w.pages)
+		w.y -= 4
+		w.paragraph(fmt.Sprintf("%s: %s (%s)", f.ID, f.Title, severity), 12, true, "#222222", 12)
+		w.field("Description", f.Description, 12)
+		location := f.Location.Target
+		if f.Location.Parameter != "" {
+			location += " parameter " + f.Location.Parameter
+		}
+		if f.Location.Method != "" {
+			location += " (" + f.Location.Method + ")"
+		}
+		w.field("Location", location, 12)
+		if f.CVSS.Score > 0 {
+			w.field("CVSS", fmt.Sprintf("%.1f %s", f.CVSS.Score, f.CVSS.Vector), 12)
+		}
+		w.field("CWE", f.CWE, 12)
+		w.field("CVE", f.CVE, 12)
+		w.field("Impact", f.Impact, 12)
+		w.field("Solution", f.Solution, 12)
+		for _, e := range f.Evidence {
+			w.field(e.Type, e.Description, 12)
+			w.paragraph(e.Data, 9, false, "#555555", 24)
+		}
+		w.field("References", strings.Join(f.References, ", "), 12)
+		// The severity bar runs down the part of the finding on the last
+		// page it is on
+		if len(w.pages) != page {
+			top = pdfHeight - pdfMargin
+		}
+		w.rect(pdfMargin, w.y, 4, top-w.y, severityColors[severity])
+		w.y -= 10
+	}
+}
+
+// footers numbers the pages
+func (w *pdfWriter) footers() {
+	for i, page := range w.pages {
+		w.page = page
+		w.textAt(pdfMargin, 25, w.title, 8, false, "#888888")
+		label := fmt.Sprintf("Page %d of %d", i+1, len(w.pages))
+		w.textAt(pdfWidth-pdfMargin-textWidth(label, 8, false), 25, label, 8, false, "#888888")
+	}
+}
+
+// writeTo writes the pages as a PDF document with compressed content
+func (w *pdfWriter) writeTo(out io.Writer) error {
+	var doc bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, doc.Len())
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	doc.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 5 are the catalog, the page tree, the two fonts and
+	// the document information; each page is then followed by its content
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Producer (Sentra) /CreationDate (D:%s) >>", pdfText(w.title), time.Now().UTC().Format("20060102150405Z")))
+	for i, page := range w.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfWidth, pdfHeight, 7+2*i))
+		var content bytes.Buffer
+		z := zlib.NewWriter(&content)
+		z.Write(page.Bytes())
+		z.Close()
+		offsets = append(offsets, doc.Len())
+		fmt.Fprintf(&doc, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", len(offsets), content.Len())
+		doc.Write(content.Bytes())
+		doc.WriteString("\nendstream\nendobj\n")
+	}
+
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := out.Write(doc.Bytes())
+	return err
+}
+
+// writePDF lays out a report with the built-in executive or technical
+// layout and writes it as a PDF
+func writePDF(out io.Writer, view *ReportView, layout string) error {
+	w := newPDFWriter(view.Title)
+	switch layout {
+	case "executive":
+		w.banner(view.SecurityReport, "Executive summary")
+		w.executive(view)
+	case "technical":
+		w.banner(view.SecurityReport, "Security assessment report")
+		w.technical(view)
+	default:
+		return fmt.Errorf("no PDF layout %s", layout)
+	}
+	w.footers()
+	return w.writeTo(out)
+}
+
+// htmlToPDF converts an HTML file to a PDF with wkhtmltopdf or a headless
+// Chromium, whichever is installed, replaced in tests
+var htmlToPDF = func(htmlFile, pdfFile string) error {
+	if path, err := exec.LookPath("wkhtmltopdf"); err == nil {
+		if out, err := exec.Command(path, "--quiet", "--enable-local-file-access", htmlFile, pdfFile).CombinedOutput(); err != nil {
+			return fmt.Errorf("wkhtmltopdf: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	for _, name := range []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable"} {
+		if path, err := exec.LookPath(name); err == nil {
+			out, err := exec.Command(path, "--headless", "--disable-gpu", "--no-pdf-header-footer",
+				"--print-to-pdf="+pdfFile, "file://"+htmlFile).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("PDFs of custom templates need wkhtmltopdf or Chromium, the executive and technical templates need neither")
+}
+
+// exportPDF exports report as PDF: with the layout of a built-in template,
+// or with a custom HTML template converted by a browser engine
+func (rm *ReportingModule) exportPDF(report *SecurityReport, tmpl *ReportTemplate, filename string) error {
+	if !tmpl.Custom && (tmpl.ID == "executive" || tmpl.ID == "technical") {
+		rm.mu.RLock()
+		defer rm.mu.RUnlock()
+
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		if err := writePDF(file, newReportView(report, tmpl), tmpl.ID); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	if !strings.EqualFold(tmpl.Format, "HTML") {
+		return fmt.Errorf("PDFs are made from HTML templates, %s is %s", tmpl.ID, tmpl.Format)
+	}
+
+	dir, err := os.MkdirTemp("", "sentra-report-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	htmlFile := filepath.Join(dir, "report.html")
+	if err := rm.exportTemplate(report, tmpl, htmlFile); err != nil {
+		return err
+	}
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	return htmlToPDF(htmlFile, absolute)
+}
@@ -2,11 +2,11 @@
 package reporting
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"html/template"
 	"io"
 	"os"
 	"path/filepath"
@@ -14,6 +14,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"sentra/internal/cvss"
 )
 
 // ReportingModule provides security reporting capabilities
@@ -73,6 +75,7 @@ type ExecutiveSummary struct {
 type SecurityFinding struct {
 	ID             string                 `json:"id" xml:"id"`
 	Title          string                 `json:"title" xml:"title"`
+	Fingerprint    string                 `json:"fingerprint" xml:"fingerprint"`  // Identifies the finding across scans
 	Description    string                 `json:"description" xml:"description"`
 	Severity       string                 `json:"severity" xml:"severity"`       // CRITICAL, HIGH, MEDIUM, LOW, INFO
 	CVSS           CVSSScore             `json:"cvss" xml:"cvss"`
@@ -256,59 +259,31 @@ func (rm *ReportingModule) initializeDefaultTemplates() {
 		Custom:      false,
 	}
 
-	// HTML Template
-	htmlTemplate := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>{{.Title}} - Security Assessment Report</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; }
-        .header { background: #2c3e50; color: white; padding: 20px; }
-        .summary { background: #ecf0f1; padding: 15px; margin: 20px 0; }
-        .finding { border-left: 4px solid #e74c3c; margin: 10px 0; padding: 10px; }
-        .critical { border-color: #c0392b; background: #fdf2f2; }
-        .high { border-color: #e74c3c; background: #fef5f5; }
-        .medium { border-color: #f39c12; background: #fef9e7; }
-        .low { border-color: #27ae60; background: #eafaf1; }
-        .info { border-color: #3498db; background: #eaf7ff; }
-    </style>
-</head>
-<body>
-    <div class="header">
-        <h1>{{.Title}}</h1>
-        <p>Generated on {{.GeneratedDate.Format "2006-01-02 15:04:05"}}</p>
-    </div>
-    
-    <div class="summary">
-        <h2>Executive Summary</h2>
-        <p><strong>Risk Level:</strong> {{.Executive.RiskLevel}}</p>
-        <p><strong>Critical Issues:</strong> {{.Executive.CriticalIssues}}</p>
-        <p><strong>High Issues:</strong> {{.Executive.HighIssues}}</p>
-        <p><strong>Medium Issues:</strong> {{.Executive.MediumIssues}}</p>
-        <p><strong>Low Issues:</strong> {{.Executive.LowIssues}}</p>
-    </div>
-    
-    <h2>Security Findings</h2>
-    {{range .Findings}}
-    <div class="finding {{.Severity | lower}}">
-        <h3>{{.Title}} ({{.Severity}})</h3>
-        <p><strong>Description:</strong> {{.Description}}</p>
-        <p><strong>Location:</strong> {{.Location.Target}}</p>
-        <p><strong>Impact:</strong> {{.Impact}}</p>
-        <p><strong>Solution:</strong> {{.Solution}}</p>
-    </div>
-    {{end}}
-</body>
-</html>
-`
-
-	rm.Templates["html"] = &ReportTemplate{
-		ID:          "html",
-		Name:        "HTML Report",
-		Description: "Web-based HTML report with styling",
+	// HTML, Markdown and PDF Templates
+	rm.Templates["executive"] = &ReportTemplate{
+		ID:          "executive",
+		Name:        "Executive Summary",
+		Description: "Overall risk, severity chart, top risks and recommendations for managers",
+		Format:      "HTML",
+		Template:    executiveTemplate,
+		Custom:      false,
+	}
+
+	rm.Templates["technical"] = &ReportTemplate{
+		ID:          "technical",
+		Name:        "Technical Report",
+		Description: "Severity chart and the details of every finding for engineers",
 		Format:      "HTML",
-		Template:    htmlTemplate,
+		Template:    technicalTemplate,
+		Custom:      false,
+	}
+
+	rm.Templates["markdown"] = &ReportTemplate{
+		ID:          "markdown",
+		Name:        "Markdown Report",
+		Description: "The technical report in Markdown",
+		Format:      "MARKDOWN",
+		Template:    markdownTemplate,
 		Custom:      false,
 	}
 }
@@ -349,6 +324,9 @@ func (rm *ReportingModule) AddFinding(reportID string, finding SecurityFinding)
 		return fmt.Errorf("report not found: %s", reportID)
 	}
 
+	// Severities are counted in upper case
+	finding.Severity = strings.ToUpper(finding.Severity)
+
 	// Set finding ID if not provided
 	if finding.ID == "" {
 		finding.ID = fmt.Sprintf("FIND-%d", len(report.Findings)+1)
@@ -360,11 +338,32 @@ func (rm *ReportingModule) AddFinding(reportID string, finding SecurityFinding)
 	}
 	finding.LastSeen = time.Now()
 
-	// Calculate CVSS if not provided
-	if finding.CVSS.Score == 0 {
+	// Score the CVSS vector if there is one, or estimate a score
+	if finding.CVSS.Vector != "" {
+		score, err := cvss.Calculate(finding.CVSS.Vector)
+		if err != nil {
+			return fmt.Errorf("finding %s: %v", finding.Title, err)
+		}
+		finding.CVSS = CVSSScore{Version: score.Version, Vector: score.Vector, Score: score.Score, Severity: strings.ToUpper(score.Severity)}
+		if finding.Severity == "" {
+			finding.Severity = finding.CVSS.Severity
+		}
+	} else if finding.CVSS.Score == 0 {
 		finding.CVSS = rm.calculateCVSS(finding)
 	}
 
+	// A finding seen again is merged into the one already reported
+	if finding.Fingerprint == "" {
+		finding.Fingerprint = Fingerprint(finding)
+	}
+	for i := range report.Findings {
+		if report.Findings[i].Fingerprint == finding.Fingerprint {
+			mergeFinding(&report.Findings[i], finding)
+			rm.updateMetrics(report)
+			return nil
+		}
+	}
+
 	// Add to report
 	report.Findings = append(report.Findings, finding)
 
@@ -377,7 +376,8 @@ func (rm *ReportingModule) AddFinding(reportID string, finding SecurityFinding)
 	return nil
 }
 
-// calculateCVSS calculates CVSS score based on finding characteristics
+// calculateCVSS estimates the CVSS score of a finding without a vector
+// from its severity
 func (rm *ReportingModule) calculateCVSS(finding SecurityFinding) CVSSScore {
 	// Simplified CVSS calculation
 	var score float64
@@ -407,9 +407,8 @@ func (rm *ReportingModule) calculateCVSS(finding SecurityFinding) CVSSScore {
 		score = 0.0
 	}
 
+	// An estimate has no vector
 	return CVSSScore{
-		Version:  "3.1",
-		Vector:   "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", // Simplified
 		Score:    score,
 		Severity: finding.Severity,
 	}
@@ -531,6 +530,14 @@ func (rm *ReportingModule) AddRecommendation(reportID string, recommendation Rec
 
 // ExportReport exports a report in the specified format
 func (rm *ReportingModule) ExportReport(reportID, format, filename string) error {
+	return rm.ExportReportWith(reportID, format, filename, "")
+}
+
+// ExportReportWith exports a report in the specified format, rendering
+// HTML, Markdown and PDF with a template. HTML and PDF use the technical
+// template and Markdown the markdown one unless another is given. A
+// relative filename is in the output directory.
+func (rm *ReportingModule) ExportReportWith(reportID, format, filename, templateID string) error {
 	rm.mu.RLock()
 	report, exists := rm.Reports[reportID]
 	rm.mu.RUnlock()
@@ -539,25 +546,62 @@ func (rm *ReportingModule) ExportReport(reportID, format, filename string) error
 		return fmt.Errorf("report not found: %s", reportID)
 	}
 
-	// Ensure output directory exists
-	if err := os.MkdirAll(rm.Config.OutputDirectory, 0755); err != nil {
-		return err
+	format = strings.ToUpper(format)
+	if format == "MD" {
+		format = "MARKDOWN"
+	}
+	var tmpl *ReportTemplate
+	switch format {
+	case "HTML", "PDF", "MARKDOWN":
+		if templateID == "" {
+			templateID = "technical"
+			if format == "MARKDOWN" {
+				templateID = "markdown"
+			}
+		}
+		rm.mu.RLock()
+		tmpl, exists = rm.Templates[templateID]
+		rm.mu.RUnlock()
+		if !exists {
+			return fmt.Errorf("template not found: %s", templateID)
+		}
+		if format != "PDF" && !strings.EqualFold(tmpl.Format, format) {
+			return fmt.Errorf("template %s is %s, not %s", templateID, tmpl.Format, format)
+		}
+		if report.Executive.Overview == "" {
+			if err := rm.GenerateExecutiveSummary(reportID); err != nil {
+				return err
+			}
+		}
+	case "JSON", "XML", "CSV":
+		if templateID != "" {
+			return fmt.Errorf("%s reports take no template", format)
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
 	}
 
 	// Full path for output file
-	fullPath := filepath.Join(rm.Config.OutputDirectory, filename)
+	fullPath := filename
+	if !filepath.IsAbs(filename) {
+		// Ensure output directory exists
+		if err := os.MkdirAll(rm.Config.OutputDirectory, 0755); err != nil {
+			return err
+		}
+		fullPath = filepath.Join(rm.Config.OutputDirectory, filename)
+	}
 
-	switch strings.ToUpper(format) {
+	switch format {
 	case "JSON":
 		return rm.exportJSON(report, fullPath)
 	case "XML":
 		return rm.exportXML(report, fullPath)
 	case "CSV":
 		return rm.exportCSV(report, fullPath)
-	case "HTML":
-		return rm.exportHTML(report, fullPath)
+	case "PDF":
+		return rm.exportPDF(report, tmpl, fullPath)
 	default:
-		return fmt.Errorf("unsupported format: %s", format)
+		return rm.exportTemplate(report, tmpl, fullPath)
 	}
 }
 
@@ -631,27 +675,16 @@ func (rm *ReportingModule) exportCSV(report *SecurityReport, filename string) er
 	return nil
 }
 
-// exportHTML exports report as HTML
-func (rm *ReportingModule) exportHTML(report *SecurityReport, filename string) error {
-	htmlTemplate, exists := rm.Templates["html"]
-	if !exists {
-		return fmt.Errorf("HTML template not found")
-	}
-
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// exportTemplate exports report as HTML or Markdown rendered with a template
+func (rm *ReportingModule) exportTemplate(report *SecurityReport, tmpl *ReportTemplate, filename string) error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
 
-	tmpl, err := template.New("report").Funcs(template.FuncMap{
-		"lower": strings.ToLower,
-	}).Parse(htmlTemplate.Template)
-	if err != nil {
+	var out bytes.Buffer
+	if err := tmpl.execute(&out, newReportView(report, tmpl)); err != nil {
 		return err
 	}
-
-	return tmpl.Execute(file, report)
+	return os.WriteFile(filename, out.Bytes(), 0644)
 }
 
 // AnalyzeTrends analyzes security trends over time
@@ -894,6 +927,58 @@ func (rm *ReportingModule) DeleteReport(reportID string) error {
 	return nil
 }
 
+// ReportFindings returns a copy of the findings of a report
+func (rm *ReportingModule) ReportFindings(reportID string) ([]SecurityFinding, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	report, exists := rm.Reports[reportID]
+	if !exists {
+		return nil, fmt.Errorf("report not found: %s", reportID)
+	}
+	return append([]SecurityFinding(nil), report.Findings...), nil
+}
+
+// FindingToMap returns a finding as a map, with the target of its
+// location as its host. Its custom fields are added unless they clash.
+func FindingToMap(f SecurityFinding) map[string]interface{} {
+	m := map[string]interface{}{
+		"id":          f.ID,
+		"title":       f.Title,
+		"description": f.Description,
+		"severity":    f.Severity,
+		"status":      f.Status,
+		"category":    f.Category,
+		"cwe":         f.CWE,
+		"cve":         f.CVE,
+		"cvss":        f.CVSS.Score,
+		"cvss_vector": f.CVSS.Vector,
+		"host":        f.Location.Target,
+		"parameter":   f.Location.Parameter,
+		"impact":      f.Impact,
+		"solution":    f.Solution,
+		"references":  stringsToList(f.References),
+		"tags":        stringsToList(f.Tags),
+		"first_found": f.FirstFound.Format(time.RFC3339),
+		"last_seen":   f.LastSeen.Format(time.RFC3339),
+	}
+	for k, v := range f.Custom {
+		if _, exists := m[k]; !exists {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// stringsToList returns strings as a list of values
+func stringsToList(strs []string) []interface{} {
+	list := make([]interface{}, len(strs))
+	for i, s := range strs {
+		list[i] = s
+	}
+	return list
+}
+
 // UpdateConfig updates reporting configuration
 func (rm *ReportingModule) UpdateConfig(config ReportConfig) {
 	rm.mu.Lock()
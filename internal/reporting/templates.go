@@ -0,0 +1,360 @@
+package reporting
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Severities are the severities of findings, most severe first
+var Severities = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "INFO"}
+
+// severityColors are the colors of severities in charts and reports
+var severityColors = map[string]string{
+	"CRITICAL": "#c0392b",
+	"HIGH":     "#e74c3c",
+	"MEDIUM":   "#f39c12",
+	"LOW":      "#27ae60",
+	"INFO":     "#3498db",
+}
+
+// severityRank returns the index of a severity in Severities, after them
+// for unknown ones
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return len(Severities)
+}
+
+// SeverityCount is how many findings of a report have a severity
+type SeverityCount struct {
+	Severity string
+	Count    int
+	Percent  float64
+	Color    string
+}
+
+// ReportView is what report templates are executed with: the report,
+// which its fields can be read from as they are, its findings ordered
+// from the most severe and how many there are of each severity
+type ReportView struct {
+	*SecurityReport
+	Sorted     []SecurityFinding
+	Severities []SeverityCount
+	Stylesheet template.CSS // Of the template, added to the built-in styles
+}
+
+// newReportView returns the view of a report for a template
+func newReportView(report *SecurityReport, t *ReportTemplate) *ReportView {
+	view := &ReportView{SecurityReport: report, Sorted: append([]SecurityFinding(nil), report.Findings...), Stylesheet: template.CSS(t.Stylesheet)}
+	sort.SliceStable(view.Sorted, func(i, j int) bool { return severityRank(view.Sorted[i].Severity) < severityRank(view.Sorted[j].Severity) })
+
+	counts := make(map[string]int)
+	for _, f := range report.Findings {
+		counts[strings.ToUpper(f.Severity)]++
+	}
+	for _, s := range Severities {
+		c := SeverityCount{Severity: s, Count: counts[s], Color: severityColors[s]}
+		if len(report.Findings) > 0 {
+			c.Percent = float64(c.Count) * 100 / float64(len(report.Findings))
+		}
+		view.Severities = append(view.Severities, c)
+	}
+	return view
+}
+
+// maxCount returns the count of the most common severity, at least 1
+func (v *ReportView) maxCount() int {
+	most := 1
+	for _, c := range v.Severities {
+		if c.Count > most {
+			most = c.Count
+		}
+	}
+	return most
+}
+
+// severityChart draws the severity distribution of a report as an SVG
+// bar chart
+func severityChart(v *ReportView) template.HTML {
+	const rowHeight, barWidth = 28, 320
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="Findings by severity">`,
+		barWidth+150, rowHeight*len(v.Severities)+4)
+	for i, c := range v.Severities {
+		y := i*rowHeight + 2
+		width := c.Count * barWidth / v.maxCount()
+		fmt.Fprintf(&b, `<text x="0" y="%d" font-size="12" font-family="sans-serif">%s</text>`, y+17, c.Severity)
+		fmt.Fprintf(&b, `<rect x="80" y="%d" width="%d" height="20" fill="%s"/>`, y+2, width, c.Color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="12" font-family="sans-serif">%d</text>`, 86+width, y+17, c.Count)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// textChart draws the severity distribution of a report as bars of text
+func textChart(v *ReportView) string {
+	const barWidth = 30
+	var b strings.Builder
+	for _, c := range v.Severities {
+		fmt.Fprintf(&b, "%-8s %s %d\n", c.Severity, strings.Repeat("█", c.Count*barWidth/v.maxCount()), c.Count)
+	}
+	return b.String()
+}
+
+// templateFuncs are the functions report templates can call besides the
+// built-in ones
+func templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"lower":         strings.ToLower,
+		"upper":         strings.ToUpper,
+		"join":          strings.Join,
+		"date":          func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+		"severityColor": func(s string) string { return severityColors[strings.ToUpper(s)] },
+	}
+}
+
+// executor is a parsed template of either kind
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// parse parses a template, an HTML one or a Markdown one. Charts are SVG
+// in HTML and bars of text in Markdown.
+func (t *ReportTemplate) parse() (executor, error) {
+	funcs := templateFuncs()
+	switch strings.ToUpper(t.Format) {
+	case "HTML":
+		funcs["chart"] = severityChart
+		return template.New(t.ID).Funcs(funcs).Parse(t.Template)
+	case "MARKDOWN":
+		funcs["chart"] = textChart
+		return texttemplate.New(t.ID).Funcs(funcs).Parse(t.Template)
+	}
+	return nil, fmt.Errorf("template %s is %s, not HTML or MARKDOWN", t.ID, t.Format)
+}
+
+// execute renders a report with a template
+func (t *ReportTemplate) execute(w io.Writer, view *ReportView) error {
+	tmpl, err := t.parse()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, view)
+}
+
+// AddTemplate adds a custom report template, HTML or Markdown, replacing
+// any custom one of the same id. Templates are Go templates executed
+// with a ReportView; chart draws the severity distribution.
+func (rm *ReportingModule) AddTemplate(t ReportTemplate) error {
+	if t.ID == "" {
+		return fmt.Errorf("a template needs an id")
+	}
+	t.Format = strings.ToUpper(t.Format)
+	if t.Format == "" {
+		t.Format = "HTML"
+	}
+	if t.Format != "HTML" && t.Format != "MARKDOWN" {
+		return fmt.Errorf("templates are HTML or MARKDOWN, not %s", t.Format)
+	}
+	if _, err := t.parse(); err != nil {
+		return err
+	}
+	t.Custom = true
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if existing, exists := rm.Templates[t.ID]; exists && !existing.Custom {
+		return fmt.Errorf("%s is a built-in template", t.ID)
+	}
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+	rm.Templates[t.ID] = &t
+	return nil
+}
+
+// ListTemplates returns the report templates ordered by id
+func (rm *ReportingModule) ListTemplates() []ReportTemplate {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	list := make([]ReportTemplate, 0, len(rm.Templates))
+	for _, t := range rm.Templates {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Render writes a report rendered with an HTML or Markdown template
+func (rm *ReportingModule) Render(reportID, templateID string, w io.Writer) error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	report, exists := rm.Reports[reportID]
+	if !exists {
+		return fmt.Errorf("report not found: %s", reportID)
+	}
+	t, exists := rm.Templates[templateID]
+	if !exists {
+		return fmt.Errorf("template not found: %s", templateID)
+	}
+	return t.execute(w, newReportView(report, t))
+}
+
+// reportStyles are the styles of the built-in HTML templates
+const reportStyles = `
+        body { font-family: Arial, Helvetica, sans-serif; margin: 40px; color: #222; }
+        .header { background: #2c3e50; color: white; padding: 20px; }
+        .header p { margin: 4px 0; }
+        .summary { background: #ecf0f1; padding: 15px; margin: 20px 0; }
+        .risk { display: inline-block; padding: 2px 10px; color: white; border-radius: 3px; }
+        .counts td { padding: 4px 16px 4px 0; }
+        table.findings { border-collapse: collapse; width: 100%; }
+        table.findings th, table.findings td { border-bottom: 1px solid #ddd; padding: 6px; text-align: left; }
+        .finding { border-left: 4px solid #e74c3c; margin: 16px 0; padding: 10px; page-break-inside: avoid; }
+        .critical { border-color: #c0392b; background: #fdf2f2; }
+        .high { border-color: #e74c3c; background: #fef5f5; }
+        .medium { border-color: #f39c12; background: #fef9e7; }
+        .low { border-color: #27ae60; background: #eafaf1; }
+        .info { border-color: #3498db; background: #eaf7ff; }
+        pre { background: #f6f8fa; padding: 8px; white-space: pre-wrap; word-break: break-all; }`
+
+// executiveTemplate is the built-in template of a summary for managers:
+// the overall risk, the severity distribution, the top risks and what to
+// do about them
+const executiveTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>{{.Title}} - Executive Summary</title>
+    <style>` + reportStyles + `
+{{.Stylesheet}}
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>{{.Title}}</h1>
+        <p>{{.Target.Name}}{{if .Target.Description}}: {{.Target.Description}}{{end}}</p>
+        <p>Generated on {{date .GeneratedDate}}</p>
+    </div>
+
+    <div class="summary">
+        <h2>Executive Summary</h2>
+        <p><strong>Overall risk:</strong> <span class="risk" style="background: {{severityColor .Executive.RiskLevel}}">{{.Executive.RiskLevel}}</span></p>
+        {{if .Executive.Overview}}<p>{{.Executive.Overview}}</p>{{end}}
+        {{if .Executive.BusinessImpact}}<p><strong>Business impact:</strong> {{.Executive.BusinessImpact}}</p>{{end}}
+        {{if .Executive.Timeline}}<p><strong>Timeline:</strong> {{.Executive.Timeline}}</p>{{end}}
+    </div>
+
+    <h2>Findings by Severity</h2>
+    {{chart .}}
+    <table class="counts">
+        <tr>{{range .Severities}}<td><strong>{{.Severity}}</strong> {{.Count}}</td>{{end}}</tr>
+    </table>
+
+    {{if .Executive.TopRisks}}
+    <h2>Top Risks</h2>
+    <ol>{{range .Executive.TopRisks}}<li>{{.}}</li>{{end}}</ol>
+    {{end}}
+
+    {{if .Recommendations}}
+    <h2>Recommendations</h2>
+    {{range .Recommendations}}
+    <h3>{{.Title}}{{if .Priority}} ({{.Priority}}){{end}}</h3>
+    <p>{{.Description}}</p>
+    {{if .Steps}}<ol>{{range .Steps}}<li>{{.}}</li>{{end}}</ol>{{end}}
+    {{end}}
+    {{end}}
+</body>
+</html>
+`
+
+// technicalTemplate is the built-in template of the details engineers
+// need to reproduce and fix every finding
+const technicalTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>{{.Title}} - Security Assessment Report</title>
+    <style>` + reportStyles + `
+{{.Stylesheet}}
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>{{.Title}}</h1>
+        <p>{{.Target.Name}}{{if .Target.Description}}: {{.Target.Description}}{{end}}</p>
+        <p>Generated on {{date .GeneratedDate}} by {{.Scanner}} {{.Version}}</p>
+    </div>
+
+    <div class="summary">
+        <h2>Summary</h2>
+        {{if .Description}}<p>{{.Description}}</p>{{end}}
+        <p><strong>Risk level:</strong> {{.Executive.RiskLevel}} &middot; <strong>Findings:</strong> {{len .Findings}}</p>
+        {{chart .}}
+    </div>
+
+    <h2>Findings</h2>
+    <table class="findings">
+        <tr><th>ID</th><th>Severity</th><th>Title</th><th>Location</th></tr>
+        {{range .Sorted}}<tr><td>{{.ID}}</td><td style="color: {{severityColor .Severity}}">{{upper .Severity}}</td><td>{{.Title}}</td><td>{{.Location.Target}}</td></tr>
+        {{end}}
+    </table>
+
+    {{range .Sorted}}
+    <div class="finding {{lower .Severity}}">
+        <h3>{{.ID}}: {{.Title}} ({{upper .Severity}})</h3>
+        {{if .Description}}<p>{{.Description}}</p>{{end}}
+        {{if .Location.Target}}<p><strong>Location:</strong> {{.Location.Target}}{{if .Location.Parameter}} parameter {{.Location.Parameter}}{{end}}{{if .Location.Method}} ({{.Location.Method}}){{end}}</p>{{end}}
+        {{if .CVSS.Score}}<p><strong>CVSS:</strong> {{printf "%.1f" .CVSS.Score}} {{.CVSS.Vector}}</p>{{end}}
+        {{if .CWE}}<p><strong>CWE:</strong> {{.CWE}}</p>{{end}}
+        {{if .CVE}}<p><strong>CVE:</strong> {{.CVE}}</p>{{end}}
+        {{if .Impact}}<p><strong>Impact:</strong> {{.Impact}}</p>{{end}}
+        {{if .Solution}}<p><strong>Solution:</strong> {{.Solution}}</p>{{end}}
+        {{range .Evidence}}<p><strong>{{.Type}}</strong> {{.Description}}</p><pre>{{.Data}}</pre>{{end}}
+        {{if .References}}<p><strong>References:</strong></p><ul>{{range .References}}<li>{{.}}</li>{{end}}</ul>{{end}}
+    </div>
+    {{end}}
+</body>
+</html>
+`
+
+// markdownTemplate is the built-in template of the technical report in
+// Markdown
+const markdownTemplate = `# {{.Title}}
+
+{{.Target.Name}} · generated on {{date .GeneratedDate}}
+{{if .Description}}
+{{.Description}}
+{{end}}
+## Summary
+
+Risk level: **{{.Executive.RiskLevel}}** · findings: **{{len .Findings}}**
+
+` + "```" + `
+{{chart .}}` + "```" + `
+
+## Findings
+
+| ID | Severity | Title | Location |
+|----|----------|-------|----------|
+{{range .Sorted}}| {{.ID}} | {{upper .Severity}} | {{.Title}} | {{.Location.Target}} |
+{{end}}
+{{range .Sorted}}
+### {{.ID}}: {{.Title}} ({{upper .Severity}})
+{{if .Description}}
+{{.Description}}
+{{end}}{{if .Location.Target}}
+- **Location:** {{.Location.Target}}{{end}}{{if .CWE}}
+- **CWE:** {{.CWE}}{{end}}{{if .CVE}}
+- **CVE:** {{.CVE}}{{end}}{{if .Impact}}
+- **Impact:** {{.Impact}}{{end}}{{if .Solution}}
+- **Solution:** {{.Solution}}{{end}}
+{{end}}`
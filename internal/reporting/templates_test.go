@@ -0,0 +1,218 @@
+package reporting
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleReport returns a report with findings of three severities
+func sampleReport(t *testing.T) *ReportingModule {
+	rm := NewReportingModule()
+	rm.Config.OutputDirectory = t.TempDir()
+	rm.CreateReport("r1", "Q3 <Assessment>", "External perimeter", TargetInfo{Name: "corp.example"})
+	for _, f := range []SecurityFinding{
+		{Title: "Outdated TLS", Severity: "medium", Location: FindingLocation{Target: "mail.corp.example:443"}},
+		{Title: "SQL injection", Severity: "critical", Description: "The id parameter reaches a query unescaped.",
+			Location: FindingLocation{Target: "https://app.corp.example/item", Parameter: "id"}, CWE: "CWE-89",
+			Evidence: []Evidence{{Type: "REQUEST", Data: "GET /item?id=1' OR '1'='1"}}},
+		{Title: "Directory listing", Severity: "low"},
+		{Title: "Admin panel exposed", Severity: "critical"},
+	} {
+		if err := rm.AddFinding("r1", f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rm
+}
+
+func TestRenderTemplates(t *testing.T) {
+	rm := sampleReport(t)
+	if err := rm.GenerateExecutiveSummary("r1"); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := rm.Render("r1", "technical", &out); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+	for _, want := range []string{"<title>Q3 &lt;Assessment&gt; - Security Assessment Report</title>", `<rect x="80" y="4" width="320" height="20" fill="#c0392b"/>`,
+		`<td style="color: #c0392b">CRITICAL</td><td>SQL injection</td>`, "parameter id", "GET /item?id=1&#39; OR &#39;1&#39;=&#39;1", "CWE-89"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("technical report lacks %s", want)
+		}
+	}
+	// Findings are listed from the most severe
+	if strings.Index(html, "SQL injection") > strings.Index(html, "Outdated TLS") || strings.Index(html, "Outdated TLS") > strings.Index(html, "Directory listing") {
+		t.Errorf("findings are not ordered by severity")
+	}
+
+	out.Reset()
+	if err := rm.Render("r1", "executive", &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Executive Summary", `style="background: #c0392b">CRITICAL</span>`, "<li>SQL injection</li><li>Admin panel exposed</li>",
+		"Address 2 critical/high issues within 30 days"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("executive summary lacks %s", want)
+		}
+	}
+
+	out.Reset()
+	if err := rm.Render("r1", "markdown", &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Q3 <Assessment>", "CRITICAL ██████████████████████████████ 2\nHIGH      0\nMEDIUM   ███████████████ 1",
+		"| FIND-2 | CRITICAL | SQL injection | https://app.corp.example/item |", "- **CWE:** CWE-89"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("markdown report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCustomTemplates(t *testing.T) {
+	rm := sampleReport(t)
+	err := rm.AddTemplate(ReportTemplate{ID: "brief", Format: "markdown", Template: "{{.Title}}: {{range .Severities}}{{if .Count}}{{.Count}} {{lower .Severity}} {{end}}{{end}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.ExportReportWith("r1", "md", "brief.md", "brief"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(rm.Config.OutputDirectory, "brief.md"))
+	if string(data) != "Q3 <Assessment>: 2 critical 1 medium 1 low " {
+		t.Errorf("brief = %q", data)
+	}
+
+	for _, c := range []struct {
+		t    ReportTemplate
+		want string
+	}{
+		{ReportTemplate{ID: "technical", Template: "x"}, "technical is a built-in template"},
+		{ReportTemplate{ID: "bad", Template: "{{.Title"}, "template: bad:1: unclosed action"},
+		{ReportTemplate{ID: "fn", Template: "{{shout .Title}}"}, `template: fn:1: function "shout" not defined`},
+		{ReportTemplate{ID: "doc", Format: "docx"}, "templates are HTML or MARKDOWN, not DOCX"},
+	} {
+		if err := rm.AddTemplate(c.t); err == nil || err.Error() != c.want {
+			t.Errorf("AddTemplate(%s) err = %v, want %s", c.t.ID, err, c.want)
+		}
+	}
+	if err := rm.ExportReportWith("r1", "html", "brief.html", "brief"); err == nil || err.Error() != "template brief is MARKDOWN, not HTML" {
+		t.Errorf("err = %v", err)
+	}
+	if err := rm.ExportReportWith("r1", "json", "r.json", "brief"); err == nil || err.Error() != "JSON reports take no template" {
+		t.Errorf("err = %v", err)
+	}
+
+	// Custom HTML templates are converted by a browser engine
+	if err := rm.AddTemplate(ReportTemplate{ID: "plain", Template: "<h1>{{.Title}}</h1>", Stylesheet: "h1 { color: red; }"}); err != nil {
+		t.Fatal(err)
+	}
+	var converted string
+	defer func(convert func(string, string) error) { htmlToPDF = convert }(htmlToPDF)
+	htmlToPDF = func(htmlFile, pdfFile string) error {
+		data, err := os.ReadFile(htmlFile)
+		converted = string(data)
+		if err == nil {
+			err = os.WriteFile(pdfFile, []byte("%PDF-1.4"), 0644)
+		}
+		return err
+	}
+	if err := rm.ExportReportWith("r1", "pdf", "plain.pdf", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if converted != "<h1>Q3 &lt;Assessment&gt;</h1>" {
+		t.Errorf("converted %q", converted)
+	}
+}
+
+func TestExportPDF(t *testing.T) {
+	rm := sampleReport(t)
+	for i := 0; i < 40; i++ {
+		rm.AddFinding("r1", SecurityFinding{Title: "Weak cipher " + strconv.Itoa(i), Severity: "low", Description: strings.Repeat("RC4 is offered. ", 20)})
+	}
+	for _, layout := range []string{"technical", "executive"} {
+		filename := filepath.Join(t.TempDir(), layout+".pdf")
+		if err := rm.ExportReportWith("r1", "pdf", filename, layout); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+			t.Fatalf("%s is not a PDF", layout)
+		}
+
+		// Every object is where the cross-reference table says
+		xref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+		start, _ := strconv.Atoi(string(xref[1]))
+		if !bytes.HasPrefix(data[start:], []byte("xref\n")) {
+			t.Fatalf("%s: startxref points at %q", layout, data[start:start+10])
+		}
+		entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[start:], -1)
+		for i, e := range entries {
+			offset, _ := strconv.Atoi(string(e[1]))
+			if !bytes.HasPrefix(data[offset:], []byte(strconv.Itoa(i+1)+" 0 obj")) {
+				t.Errorf("%s: object %d is not at %d", layout, i+1, offset)
+			}
+		}
+
+		var text strings.Builder
+		for _, stream := range regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindAllSubmatch(data, -1) {
+			z, err := zlib.NewReader(bytes.NewReader(stream[1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(z)
+			text.Write(content)
+		}
+		pages := len(regexp.MustCompile(`/Type /Page `).FindAll(data, -1))
+		switch layout {
+		case "technical":
+			for _, want := range []string{"(Q3 <Assessment>) Tj", "(FIND-2: SQL injection \\(CRITICAL\\)) Tj", "(Findings) Tj", "(CRITICAL) Tj",
+				"(FIND-44: Weak cipher 39 \\(LOW\\)) Tj", "(GET /item?id=1' OR '1'='1) Tj", "(Location: https://app.corp.example/item parameter id) Tj",
+				"0.753 0.224 0.169 rg 130.00"} {
+				if !strings.Contains(text.String(), want) {
+					t.Errorf("technical PDF lacks %s", want)
+				}
+			}
+			if pages < 3 || !strings.Contains(text.String(), "(Page 1 of "+strconv.Itoa(pages)+") Tj") {
+				t.Errorf("technical PDF has %d pages", pages)
+			}
+		case "executive":
+			for _, want := range []string{"(Executive Summary) Tj", "(Overall risk:) Tj", "(1. SQL injection) Tj", "(Findings by Severity) Tj"} {
+				if !strings.Contains(text.String(), want) {
+					t.Errorf("executive PDF lacks %s", want)
+				}
+			}
+			if pages != 1 {
+				t.Errorf("executive PDF has %d pages", pages)
+			}
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	lines := wrap("The quick brown fox jumps over the lazy dog", 100, 10, false)
+	for _, line := range lines {
+		if textWidth(line, 10, false) > 100 {
+			t.Errorf("%q is wider than 100", line)
+		}
+	}
+	if strings.Join(lines, " ") != "The quick brown fox jumps over the lazy dog" {
+		t.Errorf("wrap lost words: %q", lines)
+	}
+	if long := wrap(strings.Repeat("x", 100), 100, 10, false); len(long) != 5 || long[0] != strings.Repeat("x", 20) {
+		t.Errorf("long word wrapped as %q", long)
+	}
+	if got := pdfText("a(b)\\c é ✓"); got != `(a\(b\)\\c \351 ?)` {
+		t.Errorf("pdfText = %s", got)
+	}
+}
@@ -0,0 +1,533 @@
+// Package sandbox decides which files, hosts, programs and environment
+// variables an untrusted Sentra script may use. The VMs check a Policy
+// before running any builtin listed in the rules below.
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Capability is a kind of access a builtin needs
+type Capability string
+
+const (
+	Net   Capability = "net"   // Connect, listen, scan or capture traffic
+	Read  Capability = "read"  // Read files and directories
+	Write Capability = "write" // Create and change files
+	Exec  Capability = "exec"  // Run programs
+	Env   Capability = "env"   // Read and set environment variables
+	Sys   Capability = "sys"   // Inspect or change processes, users and the firewall
+)
+
+// Capabilities lists every capability, in the order flags are documented
+var Capabilities = []Capability{Net, Read, Write, Exec, Env, Sys}
+
+// PermissionError is returned by a builtin the policy does not allow
+type PermissionError struct {
+	Capability Capability
+	Resource   string // The path, host, program or variable, if any
+	Denied     bool   // Refused by a --deny flag rather than not allowed
+}
+
+func (e *PermissionError) Error() string {
+	msg := fmt.Sprintf("permission denied: %s access", e.Capability)
+	if e.Resource != "" {
+		msg += " to " + e.Resource
+	}
+	switch {
+	case e.Denied:
+		return msg + fmt.Sprintf(" (denied by --deny-%s)", e.Capability)
+	case e.Resource != "" && e.Capability != Sys:
+		return msg + fmt.Sprintf(" (use --allow-%s=%s)", e.Capability, e.Resource)
+	}
+	return msg + fmt.Sprintf(" (use --allow-%s)", e.Capability)
+}
+
+// grant is the part of a capability a flag allows or denies
+type grant struct {
+	all     bool     // Every resource
+	entries []string // Paths, hosts, programs or variable names
+}
+
+// Policy is the set of permissions of a run. The zero value and
+// NewPolicy allow everything. Once anything is allowed explicitly the
+// script is sandboxed and may use only what was allowed. Denials always
+// win, so a policy can also allow everything but a few resources.
+type Policy struct {
+	sandboxed bool
+	allow     map[Capability]*grant
+	deny      map[Capability]*grant
+}
+
+// NewPolicy returns a policy that allows everything
+func NewPolicy() *Policy {
+	return &Policy{allow: make(map[Capability]*grant), deny: make(map[Capability]*grant)}
+}
+
+// Restricted reports whether the policy refuses anything at all
+func (p *Policy) Restricted() bool {
+	return p.sandboxed || len(p.deny) > 0
+}
+
+// Allow permits c for the given resources, or for every resource if none
+// are given, and sandboxes the policy
+func (p *Policy) Allow(c Capability, resources ...string) {
+	p.sandboxed = true
+	p.allow[c] = addGrant(p.allow[c], c, resources)
+}
+
+// Deny refuses c for the given resources, or for every resource if none
+// are given, whatever else is allowed
+func (p *Policy) Deny(c Capability, resources ...string) {
+	p.deny[c] = addGrant(p.deny[c], c, resources)
+}
+
+func addGrant(g *grant, c Capability, resources []string) *grant {
+	if g == nil {
+		g = &grant{}
+	}
+	if len(resources) == 0 {
+		g.all = true
+	}
+	for _, r := range resources {
+		if c == Read || c == Write {
+			r = canonicalPath(r)
+		}
+		g.entries = append(g.entries, r)
+	}
+	return g
+}
+
+// ParseFlag applies a command line flag such as --allow-net,
+// --allow-read=/var/log,/tmp, --deny-exec or --allow-all. It reports
+// false for flags that are not permission flags.
+func (p *Policy) ParseFlag(flag string) (bool, error) {
+	name, value, hasValue := strings.Cut(flag, "=")
+	if name == "--allow-all" {
+		for _, c := range Capabilities {
+			p.Allow(c)
+		}
+		return true, nil
+	}
+
+	var allow bool
+	var c Capability
+	switch {
+	case strings.HasPrefix(name, "--allow-"):
+		allow, c = true, Capability(strings.TrimPrefix(name, "--allow-"))
+	case strings.HasPrefix(name, "--deny-"):
+		c = Capability(strings.TrimPrefix(name, "--deny-"))
+	default:
+		return false, nil
+	}
+	known := false
+	for _, k := range Capabilities {
+		known = known || c == k
+	}
+	if !known {
+		return true, fmt.Errorf("%s: unknown capability %q, expected net, read, write, exec, env or sys", name, c)
+	}
+
+	var resources []string
+	if hasValue {
+		for _, r := range strings.Split(value, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				resources = append(resources, r)
+			}
+		}
+		if len(resources) == 0 {
+			return true, fmt.Errorf("%s: expected a comma separated list", flag)
+		}
+	}
+	if allow {
+		p.Allow(c, resources...)
+	} else {
+		p.Deny(c, resources...)
+	}
+	return true, nil
+}
+
+// Check returns a *PermissionError unless the policy allows c for
+// resource. An empty resource stands for any, so it needs the whole
+// capability.
+func (p *Policy) Check(c Capability, resource string) error {
+	if p == nil {
+		return nil
+	}
+	if c == Read || c == Write {
+		if resource != "" {
+			resource = canonicalPath(resource)
+		}
+	}
+	if g := p.deny[c]; g != nil && (g.all || resource != "" && g.matches(c, resource)) {
+		return &PermissionError{Capability: c, Resource: resource, Denied: true}
+	}
+	if !p.sandboxed {
+		return nil
+	}
+	if g := p.allow[c]; g != nil && (g.all || resource != "" && g.matches(c, resource)) {
+		return nil
+	}
+	return &PermissionError{Capability: c, Resource: resource}
+}
+
+// matches reports whether an entry of g covers resource
+func (g *grant) matches(c Capability, resource string) bool {
+	for _, entry := range g.entries {
+		var ok bool
+		switch c {
+		case Read, Write:
+			ok = resource == entry || strings.HasPrefix(resource, strings.TrimSuffix(entry, string(filepath.Separator))+string(filepath.Separator))
+		case Net:
+			ok = matchHost(entry, resource)
+		case Exec:
+			ok = resource == entry || filepath.Base(resource) == entry
+		default:
+			ok = resource == entry
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalPath makes path absolute and resolves symbolic links in the
+// part of it that exists, so that a link cannot lead out of an allowed
+// directory
+func canonicalPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(abs)
+	if parent == abs {
+		return abs
+	}
+	return filepath.Join(canonicalPath(parent), filepath.Base(abs))
+}
+
+// matchHost reports whether the net entry allows resource. Entries are a
+// host with an optional port, *.domain for its subdomains, or an IP range
+// in CIDR notation. Resources are a URL, host[:port] or a CIDR range to scan.
+func matchHost(entry, resource string) bool {
+	host, port := splitHost(resource)
+	if _, scan, err := net.ParseCIDR(host); err == nil {
+		_, allowed, err := net.ParseCIDR(entry)
+		if err != nil {
+			return false
+		}
+		ones, _ := scan.Mask.Size()
+		allowedOnes, _ := allowed.Mask.Size()
+		return allowed.Contains(scan.IP) && allowedOnes <= ones
+	}
+	if _, allowed, err := net.ParseCIDR(entry); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && allowed.Contains(ip)
+	}
+
+	entryHost, entryPort := splitHost(entry)
+	if entryPort != "" && entryPort != port {
+		return false
+	}
+	host, entryHost = strings.ToLower(host), strings.ToLower(entryHost)
+	if strings.HasPrefix(entryHost, "*.") {
+		return strings.HasSuffix(host, entryHost[1:])
+	}
+	return host == entryHost
+}
+
+// splitHost returns the host and port of a URL, host:port or bare host
+func splitHost(resource string) (string, string) {
+	if strings.Contains(resource, "://") {
+		if u, err := url.Parse(resource); err == nil {
+			return u.Hostname(), u.Port()
+		}
+	}
+	if host, port, err := net.SplitHostPort(resource); err == nil {
+		return host, port
+	}
+	return strings.Trim(resource, "[]"), ""
+}
+
+// Need is a capability a builtin needs and which of its arguments names
+// the resource, -1 if none does and the builtin needs all of it
+type Need struct {
+	Capability Capability
+	Arg        int
+}
+
+// Rule is every capability a builtin needs, such as Write to both the
+// source and destination of rename_file
+type Rule []Need
+
+// Check checks each need of a builtin called with n arguments, arg
+// returning the one at an index. An argument the call left out names no
+// resource: a builtin's only need then needs all of the capability, and
+// any other need is not checked.
+func (r Rule) Check(p *Policy, n int, arg func(int) string) error {
+	for _, need := range r {
+		resource := ""
+		if need.Arg >= n && len(r) > 1 {
+			continue
+		}
+		if need.Arg >= 0 && need.Arg < n {
+			resource = arg(need.Arg)
+		}
+		if err := p.Check(need.Capability, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuiltinRule returns the rule of a builtin of either VM. Builtins that
+// work on a handle returned by another builtin, such as file_read_line or
+// socket_send, need no rule of their own.
+func BuiltinRule(name string) (Rule, bool) {
+	rule, ok := builtinRules[name]
+	return rule, ok
+}
+
+// Builtins returns the names of every builtin with a rule
+func Builtins() []string {
+	names := make([]string, 0, len(builtinRules))
+	for name := range builtinRules {
+		names = append(names, name)
+	}
+	return names
+}
+
+var builtinRules = map[string]Rule{
+	// Files
+	"read_file":                 {{Read, 0}},
+	"file_read":                 {{Read, 0}},
+	"file_exists":               {{Read, 0}},
+	"list_dir":                  {{Read, 0}},
+	"file_stat":                 {{Read, 0}},
+	"file_open":                 {{Read, 0}},
+	"file_lines":                {{Read, 0}},
+	"fs_hash":                   {{Read, 0}},
+	"fs_verify_checksum":        {{Read, 0}},
+	"fs_info":                   {{Read, 0}},
+	"fs_calculate_hash":         {{Read, 0}},
+	"fs_create_baseline":        {{Read, 0}},
+	"fs_verify_integrity":       {{Read, 0}},
+	"fs_watch":                  {{Read, 0}},
+	"fs_scan_directory":         {{Read, 0}},
+	"csv_rows":                  {{Read, 0}},
+	"df_read_csv":               {{Read, 0}},
+	"siem_parse_log":            {{Read, 0}},
+	"siem_load_sigma":           {{Read, 0}},
+	"container_scan_dockerfile": {{Read, 0}},
+	"pcap_read":                 {{Read, 0}},
+	"pcap_each":                 {{Read, 0}},
+	"bin_analyze":               {{Read, 0}},
+	"bin_strings":               {{Read, 0}},
+	"forensics_carve":           {{Read, 0}},
+	"iac_scan":                  {{Read, 0}},
+	"iac_parse":                 {{Read, 0}},
+	"benchmark_load":            {{Read, 0}},
+	"benchmark_run":             {{Read, -1}}, // A host's configuration
+	"forensics_browser_history": {{Read, -1}}, // The file system under a root
+	"forensics_shell_history":   {{Read, -1}},
+	"forensics_scheduled_tasks": {{Read, -1}},
+	"forensics_prefetch":        {{Read, -1}},
+	"forensics_shimcache":       {{Read, -1}},
+	"forensics_collect":         {{Read, -1}},
+	"ir_build_timeline":         {{Read, -1}}, // Times of files under paths
+	"http_server_static":        {{Read, 2}},
+	"report_diff":               {{Read, -1}}, // Baselines exported as JSON and suppression files
+	"report_load_suppressions":  {{Read, 0}},
+	"vulndb_import":             {{Read, 0}},
+	"hash_crack":                {{Read, 1}},
+	"write_file":                {{Write, 0}},
+	"append_file":               {{Write, 0}},
+	"mkdir":                     {{Write, 0}},
+	"remove_file":               {{Write, 0}},
+	"rename_file":               {{Write, 0}, {Write, 1}},
+	"csv_write":                 {{Write, 0}},
+	"df_to_csv":                 {{Write, 1}},
+	"report_export":             {{Write, 2}},
+	"siem_export_events":        {{Write, 2}},
+	"ir_export_timeline":        {{Write, 2}},
+	"ir_set_audit_file":         {{Write, 0}},
+	"monitor_export_pcap":       {{Write, 1}},
+	"capture_save_pcap":         {{Write, 1}},
+	"pcap_write":                {{Write, 0}},
+
+	// Network clients, servers and scanners
+	"http_get":                  {{Net, 0}},
+	"http_post":                 {{Net, 0}},
+	"http_put":                  {{Net, 0}},
+	"http_delete":               {{Net, 0}},
+	"http_request":              {{Net, 1}},
+	"http_json":                 {{Net, 1}},
+	"http_download":             {{Net, 0}},
+	"fetch":                     {{Net, 0}},
+	"http_server_create":        {{Net, 0}},
+	"socket_create":             {{Net, 1}},
+	"socket_listen":             {{Net, 1}},
+	"ws_connect":                {{Net, 0}},
+	"ws_listen":                 {{Net, 0}},
+	"ws_server_listen":          {{Net, 0}},
+	"metrics_serve":             {{Net, 0}},
+	"tcp_scan":                  {{Net, 0}},
+	"tcp_connect":               {{Net, 0}},
+	"port_scan":                 {{Net, 0}},
+	"advanced_port_scan":        {{Net, 0}},
+	"ping":                      {{Net, 0}},
+	"ping_sweep":                {{Net, 0}},
+	"traceroute":                {{Net, 0}},
+	"dns_lookup":                {{Net, 0}},
+	"dns_query":                 {{Net, 0}},
+	"dns_reverse":               {{Net, 0}},
+	"snmp_get":                  {{Net, 0}},
+	"snmp_walk":                 {{Net, 0}},
+	"mail_send":                 {{Net, 0}},
+	"mail_check_smtp":           {{Net, 0}},
+	"mail_check_domain":         {{Net, 0}},
+	"mail_fetch":                {{Net, 0}},
+	"mail_mailboxes":            {{Net, 0}},
+	"ldap_connect":              {{Net, 0}},
+	"grpc_connect":              {{Net, 0}},
+	"mqtt_connect":              {{Net, 0}},
+	"amqp_connect":              {{Net, 0}},
+	"iot_protocol_security":     {{Net, 0}},
+	"scan_ports":                {{Net, 0}},
+	"scan_network":              {{Net, 0}},
+	"network_scan":              {{Net, 0}},
+	"discover_network_topology": {{Net, 0}},
+	"scan_service_version":      {{Net, 0}},
+	"scan_os_fingerprint":       {{Net, 0}},
+	"scan_vulnerabilities":      {{Net, 0}},
+	"analyze_ssl":               {{Net, 0}},
+	"crypto_analyze_tls":        {{Net, 0}},
+	"ct_search":                 {{Net, -1}}, // crt.sh
+	"cert_expiry_monitor":       {{Net, -1}},
+	"db_scan_services":          {{Net, 0}},
+	"db_test_credentials":       {{Net, 0}},
+	"db_connect":                {{Net, -1}},
+	"container_scan_image":      {{Net, -1}}, // Registries, the daemon and OSV
+	"cloud_scan":                {{Net, -1}}, // Provider APIs
+	"cloud_benchmark_run":       {{Net, -1}},
+	"sql_connect":               {{Net, -1}},
+	"siem_send_syslog":          {{Net, 0}},
+	"siem_syslog_listen":        {{Net, -1}},
+	"siem_forwarder":            {{Net, -1}},
+	"proxy_start":               {{Net, -1}},
+	"proxy_set_upstream":        {{Net, 1}},
+	"reverse_proxy_create":      {{Net, -1}},
+	"reverse_proxy_add_backend": {{Net, 1}},
+	"ids_start":                 {{Net, -1}},
+	"monitor_start":             {{Net, -1}},
+	"capture_start":             {{Net, -1}},
+	"packet_capture":            {{Net, -1}},
+	"capture_stream":            {{Net, -1}},
+	"analyze_traffic":           {{Net, -1}},
+	"detect_intrusions":         {{Net, -1}},
+	"threat_lookup_ip":          {{Net, -1}},
+	"threat_lookup_domain":      {{Net, -1}},
+	"threat_lookup_hash":        {{Net, -1}},
+	"threat_get_reputation":     {{Net, -1}},
+	"threat_bulk_lookup":        {{Net, -1}},
+	"threat_taxii_collections":  {{Net, 0}},
+	"threat_taxii_poll":         {{Net, 0}},
+	"threat_prefetch":           {{Net, -1}},
+	"threat_cache_open":         {{Write, 0}},
+	"misp_configure":            {{Net, 0}},
+	"misp_search":               {{Net, -1}},
+	"notify_slack":              {{Net, 0}},
+	"notify_teams":              {{Net, 0}},
+	"notify_webhook":            {{Net, 0}},
+	"notify_pagerduty":          {{Net, -1}}, // Events API, or the url option
+	"epss_lookup":               {{Net, -1}}, // FIRST's EPSS API
+	"kev_lookup":                {{Net, -1}}, // CISA's KEV feed
+	"vuln_enrich":               {{Net, -1}},
+	"vulndb_update":             {{Net, -1}}, // The NVD feeds and OSV exports
+	"ticket_connect":            {{Net, 1}},
+	"ticket_sync":               {{Net, -1}}, // The tracker's API
+	"ticket_sync_report":        {{Net, -1}},
+	"ticket_sync_incident":      {{Net, -1}},
+	"ticket_find":               {{Net, -1}},
+	"ticket_get":                {{Net, -1}},
+	"misp_add_event":            {{Net, -1}},
+	"misp_add_sighting":         {{Net, -1}},
+	"web_request":               {{Net, 2}},
+	"web_post_json":             {{Net, 1}},
+	"web_login":                 {{Net, 1}},
+	"web_crawl":                 {{Net, 1}},
+	"web_oauth2_token":          {{Net, -1}},
+	"web_graphql":               {{Net, 1}},
+	"web_graphql_schema":        {{Net, 1}},
+	"web_test_graphql":          {{Net, 1}},
+	"web_scan_vulnerabilities":  {{Net, 1}},
+	"web_test_injection":        {{Net, 0}},
+	"web_test_cors":             {{Net, 0}},
+	"web_test_headers":          {{Net, 0}},
+	"web_test_rate_limit":       {{Net, 0}},
+	"web_api_scan":              {{Net, 0}},
+	"web_test_auth":             {{Net, 0}},
+	"web_fuzz_api":              {{Net, 0}},
+	"test_injection":            {{Net, 0}},
+	"test_rate_limiting":        {{Net, 0}},
+	"test_cors":                 {{Net, 0}},
+	"test_headers":              {{Net, 0}},
+	"test_authentication":       {{Net, 0}},
+	"test_authorization":        {{Net, 0}},
+	"api_scan":                  {{Net, 0}},
+	"fuzz_api":                  {{Net, 0}},
+	"scan_openapi":              {{Net, 0}},
+
+	// Programs and the environment
+	"process_run":   {{Exec, 0}},
+	"process_spawn": {{Exec, 0}},
+	"os_exec":       {{Exec, 0}},
+	"env":           {{Env, 0}},
+	"set_env":       {{Env, 0}},
+
+	// Processes, users, services and the host firewall
+	"os_processes":          {{Sys, -1}},
+	"os_ports":              {{Sys, -1}},
+	"os_info":               {{Sys, -1}},
+	"os_privileges":         {{Sys, -1}},
+	"os_users":              {{Sys, -1}},
+	"os_services":           {{Sys, -1}},
+	"os_kill":               {{Sys, -1}},
+	"reg_read":              {{Sys, -1}},
+	"reg_enum":              {{Sys, -1}},
+	"win_services":          {{Sys, -1}},
+	"win_service_audit":     {{Sys, -1}},
+	"win_startup_items":     {{Sys, -1}},
+	"win_security_policy":   {{Sys, -1}, {Read, 0}},
+	"mem_enum_processes":    {{Sys, -1}},
+	"mem_find_process":      {{Sys, -1}},
+	"mem_get_process_tree":  {{Sys, -1}},
+	"mem_get_process_info":  {{Sys, -1}},
+	"mem_dump_process":      {{Sys, -1}, {Write, 1}},
+	"mem_get_regions":       {{Sys, -1}},
+	"mem_read":              {{Sys, -1}},
+	"mem_scan_malware":      {{Sys, -1}},
+	"mem_detect_hollowing":  {{Sys, -1}},
+	"mem_detect_injection":  {{Sys, -1}},
+	"mem_get_children":      {{Sys, -1}},
+	"mem_analyze_injection": {{Sys, -1}},
+	"mem_image_open":        {{Read, 0}},
+	"firewall_create_rule":  {{Sys, -1}},
+	"firewall_delete_rule":  {{Sys, -1}},
+	"firewall_list_rules":   {{Sys, -1}},
+	"firewall_block_ip":     {{Sys, -1}},
+	"firewall_allow_ip":     {{Sys, -1}},
+	"firewall_get_stats":    {{Sys, -1}},
+	"firewall_enable":       {{Sys, -1}},
+	"firewall_disable":      {{Sys, -1}},
+	"ir_execute":            {{Sys, -1}},
+	"ir_execute_action":     {{Sys, -1}}, // Executors change firewalls, hosts and accounts
+}
@@ -0,0 +1,413 @@
+package siem
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ForwarderOptions configure a forwarder to Splunk's HTTP Event Collector
+// or to Elasticsearch's bulk API
+type ForwarderOptions struct {
+	URL           string // Base URL of the collector or cluster
+	Token         string // HEC token, or an Elasticsearch API key
+	Username      string // Elasticsearch basic authentication
+	Password      string
+	Index         string
+	SourceType    string        // HEC sourcetype, _json when empty
+	Source        string        // HEC source
+	BatchSize     int           // Events sent per request, 100 when 0
+	FlushInterval time.Duration // Pending events are sent this often, 5s when 0
+	Timeout       time.Duration // Of each request, 10s when 0
+	Retries       int           // Of a request that failed for a reason that may pass, 3 when 0
+	Insecure      bool          // Skip TLS verification
+	Client        *http.Client
+}
+
+// ForwarderStats counts what a forwarder sent
+type ForwarderStats struct {
+	Sent      int64
+	Failed    int64
+	Batches   int64
+	Pending   int
+	LastError string
+}
+
+// Forwarder sends events to a log platform in batches. A batch is sent
+// when it is full, when the flush interval passes and on Flush and Close.
+type Forwarder struct {
+	Kind string // splunk or elasticsearch
+	opts ForwarderOptions
+
+	mu      sync.Mutex
+	pending []map[string]interface{}
+	stats   ForwarderStats
+	sendMu  sync.Mutex // One batch is sent at a time, in order
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// forwardBackoff is the wait before the first retry of a failed request,
+// doubled for each retry after it
+var forwardBackoff = 500 * time.Millisecond
+
+// NewForwarder returns a forwarder of a kind: splunk (or hec) or
+// elasticsearch (or elastic)
+func NewForwarder(kind string, opts ForwarderOptions) (*Forwarder, error) {
+	switch strings.ToLower(kind) {
+	case "splunk", "hec":
+		kind = "splunk"
+		if opts.Token == "" {
+			return nil, fmt.Errorf("splunk needs a token")
+		}
+	case "elasticsearch", "elastic":
+		kind = "elasticsearch"
+		if opts.Index == "" {
+			return nil, fmt.Errorf("elasticsearch needs an index")
+		}
+	default:
+		return nil, fmt.Errorf("unknown forwarder type '%s', expected splunk or elasticsearch", kind)
+	}
+	if !strings.HasPrefix(opts.URL, "http://") && !strings.HasPrefix(opts.URL, "https://") {
+		return nil, fmt.Errorf("%s needs an http or https url, got '%s'", kind, opts.URL)
+	}
+	opts.URL = strings.TrimRight(opts.URL, "/")
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.Insecure}},
+		}
+	}
+	f := &Forwarder{Kind: kind, opts: opts, stop: make(chan struct{}), done: make(chan struct{})}
+	go f.flushLoop()
+	return f, nil
+}
+
+func (f *Forwarder) flushLoop() {
+	defer close(f.done)
+	ticker := time.NewTicker(f.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Send queues events and sends the full batches among them
+func (f *Forwarder) Send(events ...map[string]interface{}) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return fmt.Errorf("forwarder is closed")
+	}
+	f.pending = append(f.pending, events...)
+	full := len(f.pending) >= f.opts.BatchSize
+	f.mu.Unlock()
+	if full {
+		return f.send(false)
+	}
+	return nil
+}
+
+// Flush sends every pending event
+func (f *Forwarder) Flush() error {
+	return f.send(true)
+}
+
+// Close sends the pending events and stops the forwarder
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	f.mu.Unlock()
+	close(f.stop)
+	<-f.done
+	return f.send(true)
+}
+
+// Stats returns the counts of the forwarder
+func (f *Forwarder) Stats() ForwarderStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.stats
+	stats.Pending = len(f.pending)
+	return stats
+}
+
+// send sends the pending events in batches, all of them or only full
+// batches. Events of a batch that cannot be delivered are dropped and
+// counted as failed.
+func (f *Forwarder) send(all bool) error {
+	f.sendMu.Lock()
+	defer f.sendMu.Unlock()
+	var firstErr error
+	for {
+		f.mu.Lock()
+		n := len(f.pending)
+		if n == 0 || !all && n < f.opts.BatchSize {
+			f.mu.Unlock()
+			return firstErr
+		}
+		if n > f.opts.BatchSize {
+			n = f.opts.BatchSize
+		}
+		batch := append([]map[string]interface{}(nil), f.pending[:n]...)
+		f.pending = f.pending[n:]
+		f.mu.Unlock()
+
+		failed, err := f.post(batch)
+		f.mu.Lock()
+		f.stats.Batches++
+		f.stats.Sent += int64(len(batch) - failed)
+		f.stats.Failed += int64(failed)
+		if err != nil {
+			f.stats.LastError = err.Error()
+		}
+		f.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+}
+
+// retryable reports whether a request that got a status may succeed later
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// post sends a batch, retrying failures that may pass, and returns the
+// number of events that were not delivered. The error is that of the
+// attempt that lost events, or of the last attempt.
+func (f *Forwarder) post(batch []map[string]interface{}) (int, error) {
+	failed := 0
+	var lostErr error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(forwardBackoff << (attempt - 1))
+		}
+		var retry []map[string]interface{}
+		var lost int
+		var err error
+		if f.Kind == "splunk" {
+			retry, lost, err = f.postHEC(batch)
+		} else {
+			retry, lost, err = f.postBulk(batch)
+		}
+		failed += lost
+		if lost > 0 && lostErr == nil {
+			lostErr = err
+		}
+		if len(retry) == 0 || attempt == f.opts.Retries {
+			if err == nil {
+				err = lostErr
+			}
+			return failed + len(retry), err
+		}
+		batch = retry
+	}
+}
+
+// do sends a request and returns the status and body of the response
+func (f *Forwarder) do(path, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest("POST", f.opts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case f.Kind == "splunk":
+		req.Header.Set("Authorization", "Splunk "+f.opts.Token)
+	case f.opts.Token != "":
+		req.Header.Set("Authorization", "ApiKey "+f.opts.Token)
+	case f.opts.Username != "":
+		req.SetBasicAuth(f.opts.Username, f.opts.Password)
+	}
+	resp, err := f.opts.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, data, err
+}
+
+// eventTime reads the time of an event from its timestamp or @timestamp,
+// an RFC 3339 string or Unix seconds
+func eventTime(event map[string]interface{}) (time.Time, bool) {
+	for _, key := range []string{"timestamp", "@timestamp", "time"} {
+		switch v := event[key].(type) {
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t, true
+			}
+		case float64:
+			return time.Unix(0, int64(v*1e9)), true
+		case int64:
+			return time.Unix(v, 0), true
+		case int:
+			return time.Unix(int64(v), 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// postHEC sends a batch to the HTTP Event Collector, which takes the
+// events one after another in one body. It returns the events to retry,
+// the whole batch when the collector is busy, and the number lost.
+func (f *Forwarder) postHEC(batch []map[string]interface{}) ([]map[string]interface{}, int, error) {
+	var body bytes.Buffer
+	for _, event := range batch {
+		wrapped := map[string]interface{}{"event": event, "sourcetype": "_json"}
+		if t, ok := eventTime(event); ok {
+			wrapped["time"] = strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+		}
+		if host, ok := event["hostname"].(string); ok && host != "" {
+			wrapped["host"] = host
+		}
+		if f.opts.SourceType != "" {
+			wrapped["sourcetype"] = f.opts.SourceType
+		}
+		if f.opts.Source != "" {
+			wrapped["source"] = f.opts.Source
+		}
+		if f.opts.Index != "" {
+			wrapped["index"] = f.opts.Index
+		}
+		line, err := json.Marshal(wrapped)
+		if err != nil {
+			return nil, len(batch), err
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	status, data, err := f.do("/services/collector/event", "application/json", body.Bytes())
+	if err != nil {
+		return batch, 0, err
+	}
+	if status == http.StatusOK {
+		return nil, 0, nil
+	}
+	var reply struct {
+		Text string `json:"text"`
+		Code int    `json:"code"`
+	}
+	json.Unmarshal(data, &reply)
+	err = fmt.Errorf("splunk returned %d: %s", status, firstNonEmpty(reply.Text, strings.TrimSpace(string(data))))
+	if retryable(status) {
+		return batch, 0, err
+	}
+	return nil, len(batch), err
+}
+
+// postBulk sends a batch with the bulk API. Documents it rejects for
+// reasons that may pass, such as a full queue, are returned to be retried
+// on their own; the others are lost.
+func (f *Forwarder) postBulk(batch []map[string]interface{}) ([]map[string]interface{}, int, error) {
+	var body bytes.Buffer
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": f.opts.Index}})
+	for _, event := range batch {
+		doc := event
+		if _, ok := event["@timestamp"]; !ok {
+			if t, ok := eventTime(event); ok {
+				doc = make(map[string]interface{}, len(event)+1)
+				for k, v := range event {
+					doc[k] = v
+				}
+				doc["@timestamp"] = t.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return nil, len(batch), err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	status, data, err := f.do("/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return batch, 0, err
+	}
+	if status != http.StatusOK {
+		err := fmt.Errorf("elasticsearch returned %d: %s", status, strings.TrimSpace(string(data)))
+		if retryable(status) {
+			return batch, 0, err
+		}
+		return nil, len(batch), err
+	}
+	var reply struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil, 0, fmt.Errorf("elasticsearch: %v", err)
+	}
+	if !reply.Errors {
+		return nil, 0, nil
+	}
+	var retry []map[string]interface{}
+	failed := 0
+	var reason string
+	for i, item := range reply.Items {
+		for _, result := range item {
+			if result.Status < 300 || i >= len(batch) {
+				continue
+			}
+			if retryable(result.Status) {
+				retry = append(retry, batch[i])
+			} else {
+				failed++
+			}
+			if reason == "" {
+				reason = result.Error.Type + ": " + result.Error.Reason
+			}
+		}
+	}
+	if len(retry) == 0 && failed == 0 {
+		return nil, 0, nil
+	}
+	return retry, failed, fmt.Errorf("elasticsearch rejected %d of %d documents (%s)", len(retry)+failed, len(batch), reason)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -244,6 +244,12 @@ func (sm *SIEMModule) GetCorrelationRules() Value {
 		}
 		ruleMap.Items["conditions"] = NewArrayFromSlice(conditions)
 		
+		metadata := NewMap()
+		for key, value := range rule.Metadata {
+			metadata.Items[key] = value
+		}
+		ruleMap.Items["metadata"] = metadata
+		
 		rules = append(rules, ruleMap)
 	}
 	
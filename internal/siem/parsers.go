@@ -103,6 +103,8 @@ func (p *SyslogParser) severityToLevel(severity int) string {
 // ApacheParser parses Apache access logs
 type ApacheParser struct{}
 
+var apacheCombinedRegex = regexp.MustCompile(`^\s+"([^"]*)"\s+"([^"]*)"`)
+
 func (p *ApacheParser) Parse(line string) (*LogEntry, error) {
 	// Common Log Format: IP - - [timestamp] "method URI protocol" status size
 	apacheRegex := regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "([^"]*)" (\d+) (\S+)(.*)$`)
@@ -123,6 +125,12 @@ func (p *ApacheParser) Parse(line string) (*LogEntry, error) {
 		size = "0"
 	}
 	
+	// Combined Log Format adds "referer" "user-agent"
+	referer, userAgent := "", strings.Trim(matches[8], `" `)
+	if combined := apacheCombinedRegex.FindStringSubmatch(matches[8]); combined != nil {
+		referer, userAgent = combined[1], combined[2]
+	}
+	
 	requestParts := strings.Fields(matches[5])
 	method, uri, protocol := "", "", ""
 	if len(requestParts) >= 3 {
@@ -154,7 +162,8 @@ func (p *ApacheParser) Parse(line string) (*LogEntry, error) {
 			"protocol":     protocol,
 			"status":       matches[6],
 			"size":         size,
-			"user_agent":   strings.Trim(matches[8], `" `),
+			"referer":      referer,
+			"user_agent":   userAgent,
 		},
 		EventType: "web_access",
 		Severity:  p.statusToSeverity(status),
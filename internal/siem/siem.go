@@ -57,6 +57,8 @@ type CorrelationRule struct {
 	Category    string            `json:"category"`
 	Enabled     bool              `json:"enabled"`
 	Metadata    map[string]string `json:"metadata"`
+	Sigma       *SigmaRule        `json:"-"`
+	Taxonomy    *Taxonomy         `json:"-"`
 }
 
 // RuleCondition represents a condition in a correlation rule
@@ -422,6 +424,10 @@ func (s *SIEMIntegration) CorrelateEvents(entries []*LogEntry) ([]*Alert, error)
 		if !rule.Enabled {
 			continue
 		}
+		if rule.Sigma != nil {
+			alerts = append(alerts, s.sigmaAlerts(entries, rule)...)
+			continue
+		}
 		
 		matchingEvents := s.findMatchingEvents(entries, rule)
 		if len(matchingEvents) >= rule.Threshold {
@@ -476,6 +482,9 @@ func (s *SIEMIntegration) findMatchingEvents(entries []*LogEntry, rule Correlati
 
 // eventMatchesRule checks if an event matches a correlation rule
 func (s *SIEMIntegration) eventMatchesRule(entry *LogEntry, rule CorrelationRule) bool {
+	if rule.Sigma != nil {
+		return rule.Sigma.Match(entry, rule.Taxonomy)
+	}
 	for _, condition := range rule.Conditions {
 		if !s.evaluateCondition(entry, condition) {
 			return false
@@ -0,0 +1,1315 @@
+package siem
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// SigmaRule is a Sigma detection (https://sigmahq.io) compiled to run
+// against log entries. Field names and log sources are resolved through a
+// Taxonomy when the rule is matched.
+type SigmaRule struct {
+	ID             string
+	Title          string
+	Description    string
+	Status         string
+	Level          string
+	Author         string
+	Date           string
+	Tags           []string
+	References     []string
+	FalsePositives []string
+	LogSource      map[string]string // category, product and service
+	Condition      string
+	Timeframe      time.Duration
+	Path           string
+
+	searches  map[string]*sigmaSearch
+	condition sigmaCond
+	agg       *sigmaAggregation
+}
+
+// SigmaMatch is a group of entries that met a rule. Rules without an
+// aggregation have a single match holding every entry that met them.
+type SigmaMatch struct {
+	Group  map[string]string // Values of the fields the rule counts by
+	Count  int
+	Events []*LogEntry
+}
+
+// Taxonomy maps the field names and log sources of Sigma rules to those of
+// log entries. Fields maps a lowercased Sigma field name to the entry
+// fields tried in turn; the name itself is tried last. Sources maps a
+// lowercased logsource category, product or service to the entry sources
+// it covers, where an empty list covers any source. Entries from sources
+// no list names are matched by every rule.
+type Taxonomy struct {
+	Name    string
+	Fields  map[string][]string
+	Sources map[string][]string
+}
+
+// taxonomies are the built-in taxonomies. sentra maps the field names of
+// the Sigma taxonomy to those of the siem parsers; none matches field
+// names as they are and ignores log sources.
+var taxonomies = map[string]*Taxonomy{
+	"sentra": {
+		Name: "sentra",
+		Fields: map[string][]string{
+			"c-ip":            {"client_ip"},
+			"clientip":        {"client_ip"},
+			"src_ip":          {"client_ip", "src"},
+			"sourceip":        {"src_ip", "client_ip", "src"},
+			"ipaddress":       {"src_ip", "client_ip", "src"},
+			"dst_ip":          {"dst"},
+			"destinationip":   {"dst_ip", "dst"},
+			"src_port":        {"spt", "srcPort"},
+			"sourceport":      {"src_port", "spt", "srcPort"},
+			"dst_port":        {"dpt", "dstPort"},
+			"destinationport": {"dst_port", "dpt", "dstPort"},
+			"cs-method":       {"method"},
+			"c-uri":           {"uri"},
+			"cs-uri":          {"uri"},
+			"c-uri-query":     {"uri"},
+			"cs-uri-query":    {"uri"},
+			"c-uri-stem":      {"uri"},
+			"cs-uri-stem":     {"uri"},
+			"sc-status":       {"status"},
+			"c-useragent":     {"user_agent"},
+			"cs-user-agent":   {"user_agent"},
+			"useragent":       {"user_agent"},
+			"cs-referrer":     {"referer"},
+			"cs-username":     {"user"},
+			"cs-version":      {"protocol"},
+			"sc-bytes":        {"size"},
+			"cs-host":         {"host"},
+			"eventid":         {"event_id"},
+			"provider_name":   {"source_name"},
+			"computer":        {"host"},
+			"computername":    {"host"},
+			"hostname":        {"host"},
+			"user":            {"user", "suser", "duser"},
+			"username":        {"user", "suser"},
+			"targetusername":  {"user", "duser"},
+			"subjectusername": {"user", "suser"},
+			"program":         {"tag"},
+		},
+		Sources: map[string][]string{
+			"webserver": {"apache", "nginx"},
+			"apache":    {"apache"},
+			"nginx":     {"nginx"},
+			"windows":   {"windows"},
+			"linux":     {"syslog"},
+			"syslog":    {"syslog"},
+			"sshd":      {"syslog"},
+			"auth":      {"syslog"},
+			"sudo":      {"syslog"},
+			"cron":      {"syslog"},
+			"firewall":  {"cef", "leef"},
+		},
+	},
+	"none": {Name: "none"},
+}
+
+// GetTaxonomy returns a built-in taxonomy by name
+func GetTaxonomy(name string) (*Taxonomy, error) {
+	t, ok := taxonomies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown taxonomy '%s'", name)
+	}
+	return t, nil
+}
+
+// Extend returns a copy of the taxonomy with more field and source
+// mappings, which replace those of the same names
+func (t *Taxonomy) Extend(name string, fields, sources map[string][]string) *Taxonomy {
+	ext := &Taxonomy{Name: name, Fields: map[string][]string{}, Sources: map[string][]string{}}
+	for k, v := range t.Fields {
+		ext.Fields[k] = v
+	}
+	for k, v := range fields {
+		ext.Fields[strings.ToLower(k)] = v
+	}
+	for k, v := range t.Sources {
+		ext.Sources[k] = v
+	}
+	for k, v := range sources {
+		ext.Sources[strings.ToLower(k)] = v
+	}
+	return ext
+}
+
+// entryField returns a field of an entry: one of its own or of Fields,
+// whose names are matched exactly first
+func entryField(e *LogEntry, name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "message":
+		return e.Message, true
+	case "host":
+		if e.Host != "" {
+			return e.Host, true
+		}
+	case "source":
+		return e.Source, true
+	case "level":
+		return e.Level, true
+	case "event_type":
+		return e.EventType, true
+	case "category":
+		return e.Category, true
+	case "severity":
+		return strconv.Itoa(e.Severity), true
+	}
+	if v, ok := e.Fields[name]; ok {
+		return v, true
+	}
+	for k, v := range e.Fields {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// field returns the value of a Sigma field of an entry
+func (t *Taxonomy) field(e *LogEntry, name string) (string, bool) {
+	if t != nil {
+		for _, target := range t.Fields[strings.ToLower(name)] {
+			if v, ok := entryField(e, target); ok {
+				return v, true
+			}
+		}
+	}
+	return entryField(e, name)
+}
+
+// covers reports whether a rule's log source takes in an entry
+func (t *Taxonomy) covers(logsource map[string]string, e *LogEntry) bool {
+	if t == nil || len(t.Sources) == 0 {
+		return true
+	}
+	source := strings.ToLower(e.Source)
+	known := false
+	for _, sources := range t.Sources {
+		for _, s := range sources {
+			if s == source {
+				known = true
+			}
+		}
+	}
+	if !known {
+		return true
+	}
+	for _, key := range []string{"category", "product", "service"} {
+		sources, ok := t.Sources[logsource[key]]
+		if !ok || len(sources) == 0 {
+			continue
+		}
+		found := false
+		for _, s := range sources {
+			if s == source {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sigmaEvent is an entry being matched, with the results of the searches
+// evaluated so far
+type sigmaEvent struct {
+	entry    *LogEntry
+	taxonomy *Taxonomy
+	results  map[*sigmaSearch]bool
+}
+
+func (ev *sigmaEvent) field(name string) (string, bool) {
+	return ev.taxonomy.field(ev.entry, name)
+}
+
+type sigmaCond func(ev *sigmaEvent) bool
+
+// sigmaMatcher matches one value of a field. ok is false when the entry
+// has no such field.
+type sigmaMatcher func(ev *sigmaEvent, value string, ok bool) bool
+
+// sigmaFieldMatch matches a field against values, any or all of them.
+// Keywords have no field and are looked for in the message.
+type sigmaFieldMatch struct {
+	field    string
+	all      bool
+	matchers []sigmaMatcher
+}
+
+// sigmaSearch is a search identifier of a detection: groups of field
+// matches where every match of a group must hold for any group
+type sigmaSearch struct {
+	alts [][]*sigmaFieldMatch
+}
+
+type sigmaAggregation struct {
+	field string // Counted by its distinct values; empty counts entries
+	by    []string
+	op    string
+	value int
+}
+
+func (f *sigmaFieldMatch) match(ev *sigmaEvent) bool {
+	check := func(m sigmaMatcher) bool {
+		if f.field != "" {
+			value, ok := ev.field(f.field)
+			return m(ev, value, ok)
+		}
+		return m(ev, ev.entry.Message, true) || ev.entry.Raw != "" && m(ev, ev.entry.Raw, true)
+	}
+	for _, m := range f.matchers {
+		if check(m) != f.all {
+			return !f.all
+		}
+	}
+	return f.all
+}
+
+func (s *sigmaSearch) match(ev *sigmaEvent) bool {
+	if result, ok := ev.results[s]; ok {
+		return result
+	}
+	result := false
+	for _, alt := range s.alts {
+		all := true
+		for _, f := range alt {
+			if !f.match(ev) {
+				all = false
+				break
+			}
+		}
+		if all {
+			result = true
+			break
+		}
+	}
+	ev.results[s] = result
+	return result
+}
+
+// Match reports whether an entry meets the detection of the rule, before
+// any aggregation
+func (r *SigmaRule) Match(e *LogEntry, t *Taxonomy) bool {
+	if !t.covers(r.LogSource, e) {
+		return false
+	}
+	return r.condition(&sigmaEvent{entry: e, taxonomy: t, results: map[*sigmaSearch]bool{}})
+}
+
+// Detect returns the groups of entries that meet the rule. An aggregation
+// such as count() by src > 5 is met by a group with enough entries within
+// the rule's timeframe.
+func (r *SigmaRule) Detect(entries []*LogEntry, t *Taxonomy) []SigmaMatch {
+	var matched []*LogEntry
+	for _, e := range entries {
+		if r.Match(e, t) {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	if r.agg == nil {
+		return []SigmaMatch{{Count: len(matched), Events: matched}}
+	}
+
+	groups := map[string][]*LogEntry{}
+	values := map[string]map[string]string{}
+	var order []string
+	for _, e := range matched {
+		group := map[string]string{}
+		var key []string
+		for _, by := range r.agg.by {
+			v, _ := t.field(e, by)
+			group[by] = v
+			key = append(key, v)
+		}
+		k := strings.Join(key, "\x00")
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+			values[k] = group
+		}
+		groups[k] = append(groups[k], e)
+	}
+
+	var matches []SigmaMatch
+	for _, k := range order {
+		events := groups[k]
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+		for start, end := 0, 0; start < len(events); start++ {
+			if r.Timeframe <= 0 {
+				end = len(events)
+			}
+			for end < len(events) && events[end].Timestamp.Sub(events[start].Timestamp) <= r.Timeframe {
+				end++
+			}
+			window := events[start:end]
+			if n := r.agg.count(window, t); r.agg.holds(n) {
+				matches = append(matches, SigmaMatch{Group: values[k], Count: n, Events: window})
+				break
+			}
+			if r.Timeframe <= 0 {
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func (a *sigmaAggregation) count(events []*LogEntry, t *Taxonomy) int {
+	if a.field == "" {
+		return len(events)
+	}
+	distinct := map[string]bool{}
+	for _, e := range events {
+		if v, ok := t.field(e, a.field); ok && v != "" {
+			distinct[v] = true
+		}
+	}
+	return len(distinct)
+}
+
+func (a *sigmaAggregation) holds(n int) bool {
+	switch a.op {
+	case ">":
+		return n > a.value
+	case ">=":
+		return n >= a.value
+	case "<":
+		return n < a.value
+	case "<=":
+		return n <= a.value
+	}
+	return n == a.value
+}
+
+// sigmaLevels orders the levels of rules
+var sigmaLevels = map[string]int{"informational": 0, "low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// ParseSigma compiles the rules of a Sigma YAML file. A file may hold
+// several documents, with action: global documents merged into the rules
+// that follow them.
+func ParseSigma(data []byte, path string) ([]*SigmaRule, error) {
+	docs, err := parseYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var rules []*SigmaRule
+	var global, previous map[string]interface{}
+	for i, d := range docs {
+		doc, ok := d.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not a mapping", i+1)
+		}
+		switch doc["action"] {
+		case "global":
+			global = mergeYAML(global, doc)
+			continue
+		case "reset":
+			global = nil
+			continue
+		case "repeat":
+			if previous == nil {
+				return nil, fmt.Errorf("document %d repeats no rule", i+1)
+			}
+			doc = mergeYAML(previous, doc)
+		default:
+			doc = mergeYAML(global, doc)
+		}
+		delete(doc, "action")
+		previous = doc
+		rule, err := compileSigma(doc, path)
+		if err != nil {
+			if len(docs) > 1 {
+				return nil, fmt.Errorf("document %d: %v", i+1, err)
+			}
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules")
+	}
+	return rules, nil
+}
+
+// mergeYAML returns a copy of base with the keys of over, merging maps
+func mergeYAML(base, over map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		bm, ok1 := merged[k].(map[string]interface{})
+		om, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			merged[k] = mergeYAML(bm, om)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// LoadSigma compiles the Sigma rules of a file, or of the .yml and .yaml
+// files under a directory. The files of a directory that fail to compile
+// are returned with their errors instead of stopping the load.
+func LoadSigma(path string) ([]*SigmaRule, map[string]error, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules, err := ParseSigma(data, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return rules, nil, nil
+	}
+	var rules []*SigmaRule
+	failed := map[string]error{}
+	err = filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		if fi.IsDir() || ext != ".yml" && ext != ".yaml" {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			failed[file] = err
+			return nil
+		}
+		loaded, err := ParseSigma(data, file)
+		if err != nil {
+			failed[file] = err
+			return nil
+		}
+		rules = append(rules, loaded...)
+		return nil
+	})
+	return rules, failed, err
+}
+
+func yamlString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func yamlStrings(v interface{}) []string {
+	list, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return nil
+		}
+		return []string{yamlString(v)}
+	}
+	var out []string
+	for _, item := range list {
+		out = append(out, yamlString(item))
+	}
+	return out
+}
+
+var sigmaSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// compileSigma compiles a rule document
+func compileSigma(doc map[string]interface{}, path string) (*SigmaRule, error) {
+	if _, ok := doc["correlation"]; ok {
+		return nil, fmt.Errorf("correlation rules are not supported")
+	}
+	r := &SigmaRule{
+		ID:             yamlString(doc["id"]),
+		Title:          yamlString(doc["title"]),
+		Description:    strings.TrimSpace(yamlString(doc["description"])),
+		Status:         yamlString(doc["status"]),
+		Level:          strings.ToLower(yamlString(doc["level"])),
+		Author:         yamlString(doc["author"]),
+		Date:           yamlString(doc["date"]),
+		Tags:           yamlStrings(doc["tags"]),
+		References:     yamlStrings(doc["references"]),
+		FalsePositives: yamlStrings(doc["falsepositives"]),
+		LogSource:      map[string]string{},
+		Path:           path,
+	}
+	if r.Title == "" {
+		return nil, fmt.Errorf("rule has no title")
+	}
+	if r.ID == "" {
+		r.ID = strings.Trim(sigmaSlug.ReplaceAllString(strings.ToLower(r.Title), "_"), "_")
+	}
+	if r.Level != "" {
+		if _, ok := sigmaLevels[r.Level]; !ok {
+			return nil, fmt.Errorf("unknown level '%s'", r.Level)
+		}
+	}
+	if ls, ok := doc["logsource"].(map[string]interface{}); ok {
+		for _, key := range []string{"category", "product", "service"} {
+			if v := yamlString(ls[key]); v != "" {
+				r.LogSource[key] = strings.ToLower(v)
+			}
+		}
+	}
+	detection, ok := doc["detection"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rule has no detection")
+	}
+	timeframe := detection["timeframe"]
+	if timeframe == nil {
+		timeframe = doc["timeframe"]
+	}
+	if timeframe != nil {
+		d, err := parseSigmaDuration(yamlString(timeframe))
+		if err != nil {
+			return nil, err
+		}
+		r.Timeframe = d
+	}
+
+	r.searches = map[string]*sigmaSearch{}
+	for name, v := range detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		s, err := compileSearch(v)
+		if err != nil {
+			return nil, fmt.Errorf("detection %s: %v", name, err)
+		}
+		r.searches[name] = s
+	}
+	conditions := yamlStrings(detection["condition"])
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("detection has no condition")
+	}
+	r.Condition = strings.Join(conditions, " or ")
+	var conds []sigmaCond
+	for _, condition := range conditions {
+		expr, agg := condition, ""
+		if i := strings.Index(condition, "|"); i >= 0 {
+			expr, agg = condition[:i], strings.TrimSpace(condition[i+1:])
+		}
+		cond, err := parseSigmaCondition(expr, r.searches)
+		if err != nil {
+			return nil, fmt.Errorf("condition '%s': %v", condition, err)
+		}
+		if agg != "" {
+			if len(conditions) > 1 {
+				return nil, fmt.Errorf("condition '%s': an aggregation needs a single condition", condition)
+			}
+			if r.agg, err = parseSigmaAggregation(agg); err != nil {
+				return nil, fmt.Errorf("condition '%s': %v", condition, err)
+			}
+		}
+		conds = append(conds, cond)
+	}
+	r.condition = func(ev *sigmaEvent) bool {
+		for _, c := range conds {
+			if c(ev) {
+				return true
+			}
+		}
+		return false
+	}
+	return r, nil
+}
+
+// parseSigmaDuration reads a timeframe such as 30s, 5m, 1h, 1d or 1M
+func parseSigmaDuration(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("bad timeframe '%s'", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad timeframe '%s'", s)
+	}
+	unit := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'M': 30 * 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, fmt.Errorf("bad timeframe '%s'", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+var sigmaAggregationPattern = regexp.MustCompile(`^count\(\s*([\w.\-]*)\s*\)(?:\s+by\s+([\w.\-]+(?:\s*,\s*[\w.\-]+)*))?\s*(<=|>=|<|>|==|=)\s*(\d+)$`)
+
+// parseSigmaAggregation reads the count() aggregation that may follow a
+// condition after a pipe
+func parseSigmaAggregation(s string) (*sigmaAggregation, error) {
+	m := sigmaAggregationPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported aggregation '%s', expected count() [by field] op number", s)
+	}
+	a := &sigmaAggregation{field: m[1], op: m[3]}
+	if m[2] != "" {
+		for _, by := range strings.Split(m[2], ",") {
+			a.by = append(a.by, strings.TrimSpace(by))
+		}
+	}
+	a.value, _ = strconv.Atoi(m[4])
+	return a, nil
+}
+
+// compileSearch compiles a search identifier: a map of fields, a list of
+// such maps, or keywords
+func compileSearch(v interface{}) (*sigmaSearch, error) {
+	s := &sigmaSearch{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		alt, err := compileFieldMap(v)
+		if err != nil {
+			return nil, err
+		}
+		s.alts = append(s.alts, alt)
+	case []interface{}:
+		var keywords []interface{}
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				alt, err := compileFieldMap(m)
+				if err != nil {
+					return nil, err
+				}
+				s.alts = append(s.alts, alt)
+			} else {
+				keywords = append(keywords, item)
+			}
+		}
+		if len(keywords) > 0 {
+			f, err := compileField("|contains", keywords)
+			if err != nil {
+				return nil, err
+			}
+			s.alts = append(s.alts, []*sigmaFieldMatch{f})
+		}
+	case nil:
+		return nil, fmt.Errorf("search is empty")
+	default:
+		f, err := compileField("|contains", v)
+		if err != nil {
+			return nil, err
+		}
+		s.alts = append(s.alts, []*sigmaFieldMatch{f})
+	}
+	return s, nil
+}
+
+func compileFieldMap(m map[string]interface{}) ([]*sigmaFieldMatch, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var alt []*sigmaFieldMatch
+	for _, k := range keys {
+		f, err := compileField(k, m[k])
+		if err != nil {
+			return nil, err
+		}
+		alt = append(alt, f)
+	}
+	return alt, nil
+}
+
+// sigmaModifiers are the modifiers of a field, such as
+// CommandLine|windash|contains|all
+type sigmaModifiers struct {
+	position string // contains, startswith or endswith
+	re       bool
+	reFlags  string
+	cidr     bool
+	compare  string // lt, lte, gt or gte
+	exists   bool
+	fieldref bool
+	cased    bool
+	windash  bool
+	encoding string // wide (utf16le), utf16be or utf16
+	base64   string // base64 or base64offset
+}
+
+// compileField compiles the values of a field key with its modifiers.
+// A keyword key is empty before its modifiers.
+func compileField(key string, v interface{}) (*sigmaFieldMatch, error) {
+	parts := strings.Split(key, "|")
+	f := &sigmaFieldMatch{field: parts[0]}
+	var mods sigmaModifiers
+	for _, mod := range parts[1:] {
+		switch mod = strings.ToLower(mod); mod {
+		case "contains", "startswith", "endswith":
+			mods.position = mod
+		case "all":
+			f.all = true
+		case "re":
+			mods.re = true
+		case "i", "m", "s":
+			if !mods.re {
+				return nil, fmt.Errorf("modifier '%s' needs re", mod)
+			}
+			mods.reFlags += mod
+		case "cidr":
+			mods.cidr = true
+		case "lt", "lte", "gt", "gte":
+			mods.compare = mod
+		case "exists":
+			mods.exists = true
+		case "fieldref":
+			mods.fieldref = true
+		case "cased":
+			mods.cased = true
+		case "windash":
+			mods.windash = true
+		case "wide", "utf16le":
+			mods.encoding = "utf16le"
+		case "utf16be", "utf16":
+			mods.encoding = mod
+		case "base64", "base64offset":
+			mods.base64 = mod
+			mods.cased = true
+		default:
+			return nil, fmt.Errorf("unsupported modifier '%s'", mod)
+		}
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s has no values", key)
+	}
+	for _, value := range values {
+		m, err := compileValue(value, mods)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		f.matchers = append(f.matchers, m)
+	}
+	return f, nil
+}
+
+// compileValue compiles one value of a field
+func compileValue(v interface{}, mods sigmaModifiers) (sigmaMatcher, error) {
+	switch {
+	case mods.exists:
+		want, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("exists takes true or false")
+		}
+		return func(_ *sigmaEvent, _ string, ok bool) bool { return ok == want }, nil
+	case v == nil:
+		return func(_ *sigmaEvent, value string, ok bool) bool { return !ok || value == "" }, nil
+	}
+	s := yamlString(v)
+	switch {
+	case mods.re:
+		re, err := regexp.Compile(regexFlags(mods.reFlags) + s)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ *sigmaEvent, value string, ok bool) bool { return ok && re.MatchString(value) }, nil
+	case mods.cidr:
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ *sigmaEvent, value string, ok bool) bool {
+			ip := net.ParseIP(value)
+			return ok && ip != nil && network.Contains(ip)
+		}, nil
+	case mods.compare != "":
+		want, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s takes a number, got '%s'", mods.compare, s)
+		}
+		return func(_ *sigmaEvent, value string, ok bool) bool {
+			n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if !ok || err != nil {
+				return false
+			}
+			switch mods.compare {
+			case "lt":
+				return n < want
+			case "lte":
+				return n <= want
+			case "gt":
+				return n > want
+			}
+			return n >= want
+		}, nil
+	case mods.fieldref:
+		return func(ev *sigmaEvent, value string, ok bool) bool {
+			other, found := ev.field(s)
+			if !ok || !found {
+				return false
+			}
+			if mods.cased {
+				return value == other
+			}
+			return strings.EqualFold(value, other)
+		}, nil
+	}
+
+	variants := []string{s}
+	if mods.windash {
+		variants = nil
+		for _, dash := range []string{"-", "/", "–", "—", "―"} {
+			variants = append(variants, strings.ReplaceAll(s, "-", dash))
+		}
+	}
+	if mods.base64 != "" {
+		var encoded []string
+		for _, variant := range variants {
+			encoded = append(encoded, encodeBase64(encodeSigma(unescapeSigma(variant), mods.encoding), mods.base64 == "base64offset")...)
+		}
+		variants = encoded
+	} else if mods.encoding != "" {
+		return nil, fmt.Errorf("%s needs base64 or base64offset", mods.encoding)
+	}
+	var patterns []func(string) bool
+	for _, variant := range variants {
+		switch mods.position {
+		case "contains":
+			variant = "*" + variant + "*"
+		case "startswith":
+			variant += "*"
+		case "endswith":
+			variant = "*" + variant
+		}
+		patterns = append(patterns, compileSigmaPattern(variant, mods.cased))
+	}
+	return func(_ *sigmaEvent, value string, ok bool) bool {
+		if !ok {
+			return false
+		}
+		for _, p := range patterns {
+			if p(value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func regexFlags(flags string) string {
+	if flags == "" {
+		return ""
+	}
+	return "(?" + flags + ")"
+}
+
+// unescapeSigma removes the escapes of wildcards and backslashes
+func unescapeSigma(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`*?\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// encodeSigma encodes a value as UTF-16 for the wide and utf16 modifiers
+func encodeSigma(s, encoding string) []byte {
+	if encoding == "" {
+		return []byte(s)
+	}
+	var out []byte
+	if encoding == "utf16" {
+		out = append(out, 0xff, 0xfe)
+		encoding = "utf16le"
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		if encoding == "utf16le" {
+			out = append(out, byte(u), byte(u>>8))
+		} else {
+			out = append(out, byte(u>>8), byte(u))
+		}
+	}
+	return out
+}
+
+// encodeBase64 returns the base64 encoding of data, or with offset the
+// three encodings of it at each offset within a longer encoded text,
+// trimmed of the characters that depend on the bytes around it
+func encodeBase64(data []byte, offset bool) []string {
+	if !offset {
+		return []string{base64.StdEncoding.EncodeToString(data)}
+	}
+	var out []string
+	starts := []int{0, 2, 3}
+	ends := []int{0, 3, 2}
+	for i := 0; i < 3; i++ {
+		padded := append(make([]byte, i), data...)
+		for j := 0; j < i; j++ {
+			padded[j] = ' '
+		}
+		enc := base64.StdEncoding.EncodeToString(padded)
+		end := len(enc) - ends[(len(data)+i)%3]
+		if starts[i] < end {
+			out = append(out, enc[starts[i]:end])
+		}
+	}
+	return out
+}
+
+// compileSigmaPattern compiles a value with * and ? wildcards, escaped by
+// a backslash, to a matcher that is case-insensitive unless cased
+func compileSigmaPattern(s string, cased bool) func(string) bool {
+	var re strings.Builder
+	var literal strings.Builder
+	wild := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(`*?\`, s[i+1]) >= 0:
+			i++
+			re.WriteString(regexp.QuoteMeta(string(s[i])))
+			literal.WriteByte(s[i])
+		case c == '*':
+			re.WriteString(".*")
+			wild = true
+		case c == '?':
+			re.WriteString(".")
+			wild = true
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+			literal.WriteByte(c)
+		}
+	}
+	if !wild {
+		lit := literal.String()
+		if cased {
+			return func(v string) bool { return v == lit }
+		}
+		return func(v string) bool { return strings.EqualFold(v, lit) }
+	}
+	flags := "(?s)"
+	if !cased {
+		flags = "(?is)"
+	}
+	compiled := regexp.MustCompile(flags + "^" + re.String() + "$")
+	return compiled.MatchString
+}
+
+// sigmaConditionParser parses conditions such as
+// selection and not 1 of filter_*
+type sigmaConditionParser struct {
+	tokens   []string
+	pos      int
+	searches map[string]*sigmaSearch
+}
+
+var sigmaToken = regexp.MustCompile(`\(|\)|[^\s()]+`)
+
+func parseSigmaCondition(s string, searches map[string]*sigmaSearch) (sigmaCond, error) {
+	p := &sigmaConditionParser{tokens: sigmaToken.FindAllString(s, -1), searches: searches}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("condition is empty")
+	}
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+	return cond, nil
+}
+
+func (p *sigmaConditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *sigmaConditionParser) or() (sigmaCond, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ev *sigmaEvent) bool { return l(ev) || right(ev) }
+	}
+	return left, nil
+}
+
+func (p *sigmaConditionParser) and() (sigmaCond, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ev *sigmaEvent) bool { return l(ev) && right(ev) }
+	}
+	return left, nil
+}
+
+func (p *sigmaConditionParser) not() (sigmaCond, error) {
+	if p.peek() == "not" {
+		p.pos++
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(ev *sigmaEvent) bool { return !inner(ev) }, nil
+	}
+	return p.primary()
+}
+
+func (p *sigmaConditionParser) primary() (sigmaCond, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	p.pos++
+	switch tok {
+	case "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	case ")", "and", "or", "of":
+		return nil, fmt.Errorf("unexpected '%s'", tok)
+	case "1", "any", "all":
+		if p.peek() != "of" {
+			break
+		}
+		p.pos++
+		target := p.peek()
+		if target == "" {
+			return nil, fmt.Errorf("'%s of' needs a search pattern or them", tok)
+		}
+		p.pos++
+		searches, err := p.matching(p.tokens[p.pos-1])
+		if err != nil {
+			return nil, err
+		}
+		if tok == "all" {
+			return func(ev *sigmaEvent) bool {
+				for _, s := range searches {
+					if !s.match(ev) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(ev *sigmaEvent) bool {
+			for _, s := range searches {
+				if s.match(ev) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	s, ok := p.searches[p.tokens[p.pos-1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown search '%s'", p.tokens[p.pos-1])
+	}
+	return s.match, nil
+}
+
+// matching returns the searches a pattern such as selection_* names, or
+// with them every search whose name does not start with an underscore
+func (p *sigmaConditionParser) matching(pattern string) ([]*sigmaSearch, error) {
+	var names []string
+	for name := range p.searches {
+		if strings.EqualFold(pattern, "them") {
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+		} else if ok, _ := filepath.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no search matches '%s'", pattern)
+	}
+	sort.Strings(names)
+	searches := make([]*sigmaSearch, len(names))
+	for i, name := range names {
+		searches[i] = p.searches[name]
+	}
+	return searches, nil
+}
+
+// SigmaRuleToMap returns the description of a rule as a map
+func SigmaRuleToMap(r *SigmaRule) map[string]interface{} {
+	logsource := map[string]interface{}{}
+	for k, v := range r.LogSource {
+		logsource[k] = v
+	}
+	m := map[string]interface{}{
+		"id":             r.ID,
+		"title":          r.Title,
+		"description":    r.Description,
+		"status":         r.Status,
+		"level":          r.Level,
+		"author":         r.Author,
+		"date":           r.Date,
+		"tags":           stringsToInterfaces(r.Tags),
+		"references":     stringsToInterfaces(r.References),
+		"falsepositives": stringsToInterfaces(r.FalsePositives),
+		"logsource":      logsource,
+		"condition":      r.Condition,
+		"timeframe":      "",
+		"path":           r.Path,
+	}
+	if r.Timeframe > 0 {
+		m["timeframe"] = r.Timeframe.String()
+	}
+	return m
+}
+
+func stringsToInterfaces(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
+
+// AddSigmaRule compiles a Sigma rule into the correlation engine,
+// replacing the rule of the same id. Its alerts carry the rule's level as
+// their severity.
+func (s *SIEMIntegration) AddSigmaRule(rule *SigmaRule, t *Taxonomy) {
+	category := rule.LogSource["category"]
+	if category == "" {
+		category = rule.LogSource["product"]
+	}
+	threshold := 1
+	if rule.agg != nil {
+		threshold = rule.agg.value
+		if rule.agg.op == ">" {
+			threshold++
+		}
+	}
+	cr := CorrelationRule{
+		ID:          rule.ID,
+		Name:        rule.Title,
+		Description: rule.Description,
+		Timeframe:   rule.Timeframe,
+		Threshold:   threshold,
+		Severity:    strings.ToUpper(rule.Level),
+		Category:    category,
+		Enabled:     true,
+		Metadata: map[string]string{
+			"source":    "sigma",
+			"level":     rule.Level,
+			"status":    rule.Status,
+			"condition": rule.Condition,
+			"tags":      strings.Join(rule.Tags, ","),
+			"taxonomy":  t.Name,
+			"path":      rule.Path,
+		},
+		Sigma:    rule,
+		Taxonomy: t,
+	}
+	for i, existing := range s.correlations {
+		if existing.ID == rule.ID {
+			s.correlations[i] = cr
+			return
+		}
+	}
+	s.correlations = append(s.correlations, cr)
+}
+
+// sigmaAlerts returns the alerts of a Sigma rule, one per matched group
+func (s *SIEMIntegration) sigmaAlerts(entries []*LogEntry, rule CorrelationRule) []*Alert {
+	var alerts []*Alert
+	for i, match := range rule.Sigma.Detect(entries, rule.Taxonomy) {
+		alert := &Alert{
+			ID:          fmt.Sprintf("alert_%d_%s_%d", time.Now().Unix(), rule.ID, i+1),
+			RuleID:      rule.ID,
+			Timestamp:   time.Now(),
+			Severity:    rule.Severity,
+			Title:       rule.Name,
+			Description: rule.Description,
+			Events:      match.Events,
+			Source:      "sigma",
+			Category:    rule.Category,
+			Status:      "open",
+			Metadata: map[string]string{
+				"count": strconv.Itoa(match.Count),
+				"tags":  rule.Metadata["tags"],
+				"level": rule.Metadata["level"],
+			},
+		}
+		for field, value := range match.Group {
+			alert.Metadata["group."+field] = value
+		}
+		for _, event := range match.Events {
+			for _, ti := range s.extractThreatIndicators(event) {
+				alert.Indicators = append(alert.Indicators, fmt.Sprintf("%s: %s", ti.Type, ti.Value))
+			}
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// LoadSigma loads the Sigma rules of a file or directory into the
+// correlation engine, resolving their fields through a taxonomy. Rules
+// below minLevel are left out.
+func (sm *SIEMModule) LoadSigma(path string, t *Taxonomy, minLevel string) ([]*SigmaRule, map[string]error, error) {
+	min := 0
+	if minLevel != "" {
+		var ok bool
+		if min, ok = sigmaLevels[strings.ToLower(minLevel)]; !ok {
+			return nil, nil, fmt.Errorf("unknown level '%s'", minLevel)
+		}
+	}
+	rules, failed, err := LoadSigma(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var loaded []*SigmaRule
+	for _, rule := range rules {
+		if sigmaLevels[rule.Level] < min {
+			continue
+		}
+		sm.siem.AddSigmaRule(rule, t)
+		loaded = append(loaded, rule)
+	}
+	return loaded, failed, nil
+}
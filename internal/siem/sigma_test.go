@@ -0,0 +1,332 @@
+package siem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	docs, err := parseYAML(`# comment
+title: 'It''s a test'
+tags:
+    - attack.t1190   # trailing comment
+    - "attack.initial_access"
+detection:
+    selection:
+        - c-uri|contains: [ '../', "%2e%2e" ]
+          sc-status: 200
+        - cs-method: PUT
+    empty:
+    condition: selection
+description: >
+    Folded
+    text
+query: |
+    line one
+    line two
+ratio: 1.5
+enabled: true
+---
+title: second
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		map[string]interface{}{
+			"title": "It's a test",
+			"tags":  []interface{}{"attack.t1190", "attack.initial_access"},
+			"detection": map[string]interface{}{
+				"selection": []interface{}{
+					map[string]interface{}{"c-uri|contains": []interface{}{"../", "%2e%2e"}, "sc-status": 200},
+					map[string]interface{}{"cs-method": "PUT"},
+				},
+				"empty":     nil,
+				"condition": "selection",
+			},
+			"description": "Folded text\n",
+			"query":       "line one\nline two\n",
+			"ratio":       1.5,
+			"enabled":     true,
+		},
+		map[string]interface{}{"title": "second"},
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("parseYAML = %#v\nwant %#v", docs, want)
+	}
+
+	for _, bad := range []string{"key: [unclosed", "key: 'unclosed", "- item\nkey: value", "a: 1\na: 2"} {
+		if _, err := parseYAML(bad); err == nil {
+			t.Errorf("parseYAML(%q) succeeded", bad)
+		}
+	}
+}
+
+// apacheEntry parses a line of an Apache access log
+func apacheEntry(t *testing.T, line string) *LogEntry {
+	t.Helper()
+	e, err := (&ApacheParser{}).Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestSigmaMatch(t *testing.T) {
+	traversal := apacheEntry(t, `203.0.113.7 - - [05/Jan/2024:10:00:00 +0000] "GET /cgi-bin/../../etc/passwd HTTP/1.1" 200 512 "-" "curl/8.0"`)
+	normal := apacheEntry(t, `198.51.100.2 - - [05/Jan/2024:10:00:01 +0000] "GET /index.html HTTP/1.1" 200 1024 "-" "Mozilla/5.0"`)
+	sshd := &LogEntry{Source: "syslog", Host: "web01", Message: "Failed password for root from 203.0.113.7 port 22 ssh2",
+		Fields: map[string]string{"tag": "sshd", "CommandLine": "cmd.exe /c whoami", "Encoded": "powershell -enc " + "d2hvYW1p"}}
+	sentra, _ := GetTaxonomy("sentra")
+	none, _ := GetTaxonomy("none")
+
+	for _, tc := range []struct {
+		name      string
+		detection string
+		logsource string
+		taxonomy  *Taxonomy
+		entry     *LogEntry
+		want      bool
+	}{
+		{"contains", "sel:\n  c-uri|contains: '/../'\ncondition: sel", "category: webserver", sentra, traversal, true},
+		{"contains miss", "sel:\n  c-uri|contains: '/../'\ncondition: sel", "category: webserver", sentra, normal, false},
+		{"wildcard", "sel:\n  c-uri: '/cgi-bin/*passwd'\ncondition: sel", "", sentra, traversal, true},
+		{"case-insensitive", "sel:\n  c-useragent|startswith: 'CURL/'\ncondition: sel", "", sentra, traversal, true},
+		{"cased", "sel:\n  c-useragent|cased|startswith: 'CURL/'\ncondition: sel", "", sentra, traversal, false},
+		{"number", "sel:\n  sc-status: 200\n  cs-method: GET\ncondition: sel", "", sentra, normal, true},
+		{"list is or", "sel:\n  cs-method:\n    - POST\n    - GET\ncondition: sel", "", sentra, normal, true},
+		{"all", "sel:\n  c-uri|contains|all:\n    - cgi-bin\n    - passwd\ncondition: sel", "", sentra, traversal, true},
+		{"all miss", "sel:\n  c-uri|contains|all:\n    - cgi-bin\n    - shadow\ncondition: sel", "", sentra, traversal, false},
+		{"no taxonomy", "sel:\n  c-uri|contains: '/../'\ncondition: sel", "", none, traversal, false},
+		{"parser field", "sel:\n  uri|contains: '/../'\ncondition: sel", "", none, traversal, true},
+		{"logsource", "sel:\n  c-uri|contains: '/../'\ncondition: sel", "product: windows", sentra, traversal, false},
+		{"logsource ignored", "sel:\n  uri|contains: '/../'\ncondition: sel", "product: windows", none, traversal, true},
+		{"unknown source", "sel:\n  Message|contains: root\ncondition: sel", "product: windows", sentra, &LogEntry{Source: "json", Message: "root"}, true},
+		{"keywords", "keywords:\n  - 'Failed password'\n  - 'Invalid user'\ncondition: keywords", "service: sshd", sentra, sshd, true},
+		{"keywords source", "keywords:\n  - 'Failed password'\ncondition: keywords", "category: webserver", sentra, sshd, false},
+		{"not filter", "sel:\n  program: sshd\nfilter:\n  Hostname: web01\ncondition: sel and not filter", "", sentra, sshd, false},
+		{"of them", "sel_a:\n  cs-method: PUT\nsel_b:\n  sc-status: 200\n_helper:\n  cs-method: GET\ncondition: 1 of sel_*", "", sentra, normal, true},
+		{"all of them", "sel_a:\n  cs-method: GET\nsel_b:\n  sc-status: 404\ncondition: all of them", "", sentra, normal, false},
+		{"them skips underscore", "sel_a:\n  cs-method: GET\n_b:\n  sc-status: 404\ncondition: all of them", "", sentra, normal, true},
+		{"parentheses", "a:\n  cs-method: PUT\nb:\n  sc-status: 200\nc:\n  c-uri: '/index.html'\ncondition: (a or b) and c", "", sentra, normal, true},
+		{"condition list", "a:\n  cs-method: PUT\nb:\n  cs-method: GET\ncondition:\n  - a\n  - b", "", sentra, normal, true},
+		{"or of maps", "sel:\n  - cs-method: PUT\n  - sc-status: 200\ncondition: sel", "", sentra, normal, true},
+		{"re", "sel:\n  c-uri|re: '^/cgi-bin/(\\.\\./)+'\ncondition: sel", "", sentra, traversal, true},
+		{"re flags", "sel:\n  c-useragent|re|i: '^CURL'\ncondition: sel", "", sentra, traversal, true},
+		{"cidr", "sel:\n  c-ip|cidr: 203.0.113.0/24\ncondition: sel", "", sentra, traversal, true},
+		{"cidr miss", "sel:\n  c-ip|cidr: 203.0.113.0/24\ncondition: sel", "", sentra, normal, false},
+		{"gt", "sel:\n  sc-bytes|gt: 1000\ncondition: sel", "", sentra, normal, true},
+		{"lte", "sel:\n  sc-bytes|lte: 512\ncondition: sel", "", sentra, normal, false},
+		{"null value", "sel:\n  cs-username: null\ncondition: sel", "", sentra, normal, false},
+		{"null missing", "sel:\n  TargetObject: null\ncondition: sel", "", none, normal, true},
+		{"exists", "sel:\n  CommandLine|exists: true\ncondition: sel", "", sentra, sshd, true},
+		{"exists false", "sel:\n  CommandLine|exists: false\ncondition: sel", "", sentra, normal, true},
+		{"fieldref", "sel:\n  c-ip|fieldref: Host\ncondition: sel", "", sentra, normal, true},
+		{"windash", "sel:\n  CommandLine|windash|contains: ' -c '\ncondition: sel", "", sentra, sshd, true},
+		{"base64", "sel:\n  Encoded|base64|endswith: whoami\ncondition: sel", "", sentra, sshd, true},
+		{"base64offset", "sel:\n  Encoded|base64offset|contains: hoam\ncondition: sel", "", sentra, sshd, true},
+		{"escaped wildcard", "sel:\n  c-uri: '/index\\*'\ncondition: sel", "", sentra, normal, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := "title: " + tc.name + "\n"
+			if tc.logsource != "" {
+				doc += "logsource:\n  " + tc.logsource + "\n"
+			}
+			doc += "detection:\n  " + strings.ReplaceAll(tc.detection, "\n", "\n  ") + "\n"
+			rules, err := ParseSigma([]byte(doc), "")
+			if err != nil {
+				t.Fatalf("ParseSigma: %v\n%s", err, doc)
+			}
+			if got := rules[0].Match(tc.entry, tc.taxonomy); got != tc.want {
+				t.Errorf("Match = %v, want %v\n%s", got, tc.want, doc)
+			}
+		})
+	}
+}
+
+func TestSigmaBase64Offset(t *testing.T) {
+	// pySigma's encodings of "/c " at each offset
+	if got, want := encodeBase64([]byte("/c "), true), []string{"L2Mg", "9jI", "vYy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("encodeBase64 = %v, want %v", got, want)
+	}
+}
+
+func TestSigmaAggregation(t *testing.T) {
+	rules, err := ParseSigma([]byte(`
+title: Brute force
+id: 5c1e2f3a-0000-4000-8000-000000000001
+level: high
+tags: [attack.t1110]
+logsource:
+    product: linux
+    service: sshd
+detection:
+    selection:
+        Message|startswith: 'Failed password'
+    timeframe: 1m
+    condition: selection | count() by Hostname > 2
+`), "brute.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := rules[0]
+	if rule.Timeframe != time.Minute || rule.Condition != "selection | count() by Hostname > 2" {
+		t.Errorf("rule = %+v", rule)
+	}
+
+	base := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC)
+	entry := func(host string, offset time.Duration) *LogEntry {
+		return &LogEntry{Timestamp: base.Add(offset), Source: "syslog", Host: host, Message: "Failed password for root"}
+	}
+	entries := []*LogEntry{
+		// a: three failures within a minute
+		entry("a", 0), entry("a", 20*time.Second), entry("a", 50*time.Second),
+		// b: three failures, but spread over more than a minute
+		entry("b", 0), entry("b", 40*time.Second), entry("b", 90*time.Second),
+		{Timestamp: base, Source: "syslog", Host: "a", Message: "Accepted password for root"},
+	}
+	sentra, _ := GetTaxonomy("sentra")
+	matches := rule.Detect(entries, sentra)
+	if len(matches) != 1 || matches[0].Group["Hostname"] != "a" || matches[0].Count != 3 || len(matches[0].Events) != 3 {
+		t.Fatalf("Detect = %+v", matches)
+	}
+
+	distinct, err := ParseSigma([]byte(`
+title: Spray
+detection:
+    selection:
+        Message|startswith: 'Failed password'
+    condition: selection | count(Hostname) >= 2
+`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches := distinct[0].Detect(entries, sentra); len(matches) != 1 || matches[0].Count != 2 {
+		t.Errorf("Detect distinct = %+v", matches)
+	}
+
+	// In the correlation engine the rule raises one alert per group
+	s := NewSIEMIntegration()
+	s.correlations = nil
+	s.AddSigmaRule(rule, sentra)
+	s.AddSigmaRule(rule, sentra)
+	alerts, err := s.CorrelateEvents(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.correlations) != 1 || len(alerts) != 1 {
+		t.Fatalf("correlations = %d, alerts = %d", len(s.correlations), len(alerts))
+	}
+	a := alerts[0]
+	if a.RuleID != rule.ID || a.Severity != "HIGH" || a.Source != "sigma" || a.Metadata["group.Hostname"] != "a" || a.Metadata["tags"] != "attack.t1110" {
+		t.Errorf("alert = %+v", a)
+	}
+}
+
+func TestParseSigmaErrors(t *testing.T) {
+	for _, tc := range []struct {
+		doc  string
+		want string
+	}{
+		{"detection:\n  sel:\n    a: 1\n  condition: sel", "rule has no title"},
+		{"title: x", "rule has no detection"},
+		{"title: x\ndetection:\n  sel:\n    a: 1", "detection has no condition"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  condition: other", "unknown search 'other'"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  condition: sel and", "unexpected end of condition"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  condition: (sel", "missing ')'"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  condition: 1 of filter_*", "no search matches 'filter_*'"},
+		{"title: x\ndetection:\n  sel:\n    a|expand: '%x%'\n  condition: sel", "unsupported modifier 'expand'"},
+		{"title: x\ndetection:\n  sel:\n    a|re: '('\n  condition: sel", "missing closing )"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  condition: sel | near other", "unsupported aggregation"},
+		{"title: x\ndetection:\n  sel:\n    a: 1\n  timeframe: 5x\n  condition: sel", "bad timeframe '5x'"},
+		{"title: x\nlevel: severe\ndetection:\n  sel:\n    a: 1\n  condition: sel", "unknown level 'severe'"},
+		{"title: x\ncorrelation:\n  type: event_count", "correlation rules are not supported"},
+	} {
+		_, err := ParseSigma([]byte(tc.doc), "")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseSigma(%q) error = %v, want %q", tc.doc, err, tc.want)
+		}
+	}
+}
+
+func TestLoadSigma(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"web/traversal.yml": `title: Path traversal
+id: web-traversal
+level: medium
+logsource:
+    category: webserver
+detection:
+    selection:
+        c-uri|contains: '/../'
+    condition: selection
+`,
+		// A collection: the global document is merged into each rule
+		"web/collection.yaml": `action: global
+title: Scanner
+level: low
+logsource:
+    category: webserver
+detection:
+    condition: selection
+---
+id: scanner-nikto
+detection:
+    selection:
+        c-useragent|contains: nikto
+---
+id: scanner-sqlmap
+level: high
+detection:
+    selection:
+        c-useragent|contains: sqlmap
+`,
+		"broken.yml": "title: Broken\ndetection:\n    condition: missing\n",
+		"notes.txt":  "not a rule",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, failed, err := LoadSigma(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range rules {
+		ids = append(ids, r.ID+"/"+r.Level)
+	}
+	if want := []string{"scanner-nikto/low", "scanner-sqlmap/high", "web-traversal/medium"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("rules = %v, want %v", ids, want)
+	}
+	if len(failed) != 1 || failed[filepath.Join(dir, "broken.yml")] == nil {
+		t.Errorf("failed = %v", failed)
+	}
+	if _, _, err := LoadSigma(filepath.Join(dir, "broken.yml")); err == nil || !strings.Contains(err.Error(), "broken.yml") {
+		t.Errorf("LoadSigma(broken.yml) error = %v", err)
+	}
+
+	sm := NewSIEMModule()
+	sentra, _ := GetTaxonomy("sentra")
+	loaded, _, err := sm.LoadSigma(dir, sentra, "medium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("loaded %d rules above medium, want 2", len(loaded))
+	}
+	if _, _, err := sm.LoadSigma(dir, sentra, "urgent"); err == nil {
+		t.Error("LoadSigma with an unknown level succeeded")
+	}
+}
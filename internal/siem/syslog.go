@@ -0,0 +1,540 @@
+package siem
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyslogMessage is a syslog message received or parsed, in RFC 5424 or
+// RFC 3164 format
+type SyslogMessage struct {
+	Priority       int
+	Facility       int
+	Severity       int
+	Version        int // 1 for RFC 5424, 0 for RFC 3164
+	Format         string
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+	Raw            string
+	Remote         string // Address of the sender
+	Protocol       string // udp, tcp or tls
+	Received       time.Time
+}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// FacilityName returns the name of a syslog facility, such as auth
+func FacilityName(facility int) string {
+	if facility < 0 || facility >= len(facilityNames) {
+		return strconv.Itoa(facility)
+	}
+	return facilityNames[facility]
+}
+
+// SeverityName returns the name of a syslog severity, such as warning
+func SeverityName(severity int) string {
+	if severity < 0 || severity >= len(severityNames) {
+		return strconv.Itoa(severity)
+	}
+	return severityNames[severity]
+}
+
+// ParseSyslog parses a syslog message. RFC 5424 messages are recognised
+// by their version; anything else is read as RFC 3164, leniently, since
+// devices write it in many ways: without a priority (user.notice is
+// assumed), without a hostname or with an RFC 3339 timestamp.
+func ParseSyslog(raw string, received time.Time) (*SyslogMessage, error) {
+	line := strings.TrimRight(raw, "\r\n\x00")
+	if strings.TrimSpace(line) == "" {
+		return nil, fmt.Errorf("empty syslog message")
+	}
+	m := &SyslogMessage{Priority: 13, Raw: line, Received: received}
+	rest := line
+	if pri, after, ok := parsePriority(line); ok {
+		m.Priority, rest = pri, after
+	}
+	m.Facility, m.Severity = m.Priority/8, m.Priority%8
+
+	if strings.HasPrefix(rest, "1 ") {
+		if err := parse5424(m, rest[2:]); err == nil {
+			return m, nil
+		}
+		// Not RFC 5424 after all
+		m.AppName, m.ProcID, m.MsgID, m.StructuredData = "", "", "", nil
+	}
+	parse3164(m, rest, received)
+	return m, nil
+}
+
+// parsePriority reads the <PRI> at the start of a message
+func parsePriority(s string) (int, string, bool) {
+	if !strings.HasPrefix(s, "<") {
+		return 0, s, false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, s, false
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri > 191 {
+		return 0, s, false
+	}
+	return pri, s[end+1:], true
+}
+
+// nextField splits the next space separated field off a string
+func nextField(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// nilValue reads an RFC 5424 field, where - means no value
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parse5424 reads what follows the version of an RFC 5424 message:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parse5424(m *SyslogMessage, s string) error {
+	var ts, host, app, proc, msgID string
+	ts, s = nextField(s)
+	host, s = nextField(s)
+	app, s = nextField(s)
+	proc, s = nextField(s)
+	msgID, s = nextField(s)
+	if msgID == "" {
+		return fmt.Errorf("truncated RFC 5424 header")
+	}
+	if ts != "-" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return err
+		}
+		m.Timestamp = t
+	} else {
+		m.Timestamp = m.Received
+	}
+	sd, rest, err := parseStructuredData(s)
+	if err != nil {
+		return err
+	}
+	m.Version, m.Format = 1, "rfc5424"
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = nilValue(host), nilValue(app), nilValue(proc), nilValue(msgID)
+	m.StructuredData = sd
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return nil
+}
+
+// parseStructuredData reads the SD-ELEMENTs of an RFC 5424 message, such
+// as [exampleSDID@32473 iut="3" eventSource="Application"]
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := map[string]map[string]string{}
+	if strings.HasPrefix(s, "-") {
+		return sd, s[1:], nil
+	}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", fmt.Errorf("bad structured data")
+		}
+		id := s[:end]
+		params := map[string]string{}
+		s = s[end:]
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 {
+				return nil, "", fmt.Errorf("bad structured data parameter")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s, closed = s[i+1:], true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", fmt.Errorf("unterminated structured data value")
+			}
+			params[name] = value.String()
+		}
+		sd[id] = params
+	}
+	if len(sd) == 0 {
+		return nil, "", fmt.Errorf("missing structured data")
+	}
+	return sd, s, nil
+}
+
+// parse3164 reads what follows the priority of a BSD syslog message:
+// TIMESTAMP HOSTNAME TAG[PID]: MSG, any of which may be missing
+func parse3164(m *SyslogMessage, s string, received time.Time) {
+	m.Version, m.Format = 0, "rfc3164"
+	m.Timestamp = received
+	stamped := false
+	if len(s) >= 15 {
+		if t, err := time.Parse(time.Stamp, s[:15]); err == nil {
+			// The year is not sent: it is this year unless that puts the
+			// message in the future
+			t = time.Date(received.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			if t.After(received.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Timestamp, s, stamped = t, strings.TrimPrefix(s[15:], " "), true
+		}
+	}
+	if !stamped {
+		if ts, rest := nextField(s); len(ts) > 10 && ts[4] == '-' {
+			if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				m.Timestamp, s, stamped = t, rest, true
+			}
+		}
+	}
+	// The hostname follows the timestamp, unless the next word is the tag
+	if host, rest := nextField(s); stamped && rest != "" && !strings.HasSuffix(host, ":") && !strings.Contains(host, "[") {
+		m.Hostname, s = host, rest
+	}
+	if tag, rest, ok := strings.Cut(s, ":"); ok && len(tag) <= 48 && !strings.Contains(tag, " ") {
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.AppName, m.ProcID = tag[:open], tag[open+1:len(tag)-1]
+		} else {
+			m.AppName = tag
+		}
+		s = strings.TrimPrefix(rest, " ")
+	}
+	m.Message = s
+}
+
+// SyslogToMap converts a syslog message to the map a callback gets
+func SyslogToMap(m *SyslogMessage) map[string]interface{} {
+	sd := map[string]interface{}{}
+	for id, params := range m.StructuredData {
+		p := map[string]interface{}{}
+		for k, v := range params {
+			p[k] = v
+		}
+		sd[id] = p
+	}
+	return map[string]interface{}{
+		"priority":        m.Priority,
+		"facility":        m.Facility,
+		"severity":        m.Severity,
+		"facility_name":   FacilityName(m.Facility),
+		"severity_name":   SeverityName(m.Severity),
+		"version":         m.Version,
+		"format":          m.Format,
+		"timestamp":       m.Timestamp.Format(time.RFC3339Nano),
+		"hostname":        m.Hostname,
+		"app_name":        m.AppName,
+		"proc_id":         m.ProcID,
+		"msg_id":          m.MsgID,
+		"structured_data": sd,
+		"message":         m.Message,
+		"raw":             m.Raw,
+		"remote":          m.Remote,
+		"protocol":        m.Protocol,
+		"received":        m.Received.Format(time.RFC3339Nano),
+	}
+}
+
+// SyslogServerOptions are the addresses a syslog server listens on, such
+// as ":514"; a protocol whose address is empty is not served
+type SyslogServerOptions struct {
+	UDP            string
+	TCP            string
+	TLS            string
+	CertFile       string // Certificate and key of the TLS listener
+	KeyFile        string
+	MaxMessageSize int // 64 KiB when 0
+	Buffer         int // Messages queued for the reader, 1024 when 0
+}
+
+// SyslogStats counts what a server received
+type SyslogStats struct {
+	Received    int64
+	Dropped     int64 // UDP messages dropped because the reader fell behind
+	Malformed   int64
+	Connections int64
+}
+
+// SyslogServer receives syslog messages over UDP, TCP and TLS. TCP
+// streams are split by octet counting (RFC 6587) or by newlines, whichever
+// each frame uses.
+type SyslogServer struct {
+	opts      SyslogServerOptions
+	messages  chan *SyslogMessage
+	packet    net.PacketConn
+	listeners map[string]net.Listener
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	conns     sync.Map // net.Conn -> struct{}
+
+	received, dropped, malformed, connections int64
+}
+
+// ListenSyslog starts a syslog server
+func ListenSyslog(opts SyslogServerOptions) (*SyslogServer, error) {
+	if opts.UDP == "" && opts.TCP == "" && opts.TLS == "" {
+		return nil, fmt.Errorf("no udp, tcp or tls address to listen on")
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = 64 * 1024
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1024
+	}
+	s := &SyslogServer{
+		opts:      opts,
+		messages:  make(chan *SyslogMessage, opts.Buffer),
+		listeners: map[string]net.Listener{},
+		closed:    make(chan struct{}),
+	}
+	if opts.TLS != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("tls needs a cert and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		l, err := tls.Listen("tcp", opts.TLS, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return nil, err
+		}
+		s.listeners["tls"] = l
+	}
+	if opts.TCP != "" {
+		l, err := net.Listen("tcp", opts.TCP)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.listeners["tcp"] = l
+	}
+	if opts.UDP != "" {
+		pc, err := net.ListenPacket("udp", opts.UDP)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.packet = pc
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+	for proto, l := range s.listeners {
+		s.wg.Add(1)
+		go s.accept(proto, l)
+	}
+	return s, nil
+}
+
+// Messages returns the channel messages arrive on. It is closed when the
+// server is closed.
+func (s *SyslogServer) Messages() <-chan *SyslogMessage {
+	return s.messages
+}
+
+// Addrs returns the addresses the server listens on by protocol
+func (s *SyslogServer) Addrs() map[string]string {
+	addrs := map[string]string{}
+	if s.packet != nil {
+		addrs["udp"] = s.packet.LocalAddr().String()
+	}
+	for proto, l := range s.listeners {
+		addrs[proto] = l.Addr().String()
+	}
+	return addrs
+}
+
+// Stats returns the counts of the server
+func (s *SyslogServer) Stats() SyslogStats {
+	return SyslogStats{
+		Received:    atomic.LoadInt64(&s.received),
+		Dropped:     atomic.LoadInt64(&s.dropped),
+		Malformed:   atomic.LoadInt64(&s.malformed),
+		Connections: atomic.LoadInt64(&s.connections),
+	}
+}
+
+// Close stops the listeners, closes open connections and then the
+// message channel
+func (s *SyslogServer) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.packet != nil {
+			s.packet.Close()
+		}
+		for _, l := range s.listeners {
+			l.Close()
+		}
+		s.conns.Range(func(c, _ interface{}) bool {
+			c.(net.Conn).Close()
+			return true
+		})
+		s.wg.Wait()
+		close(s.messages)
+	})
+	return nil
+}
+
+// deliver parses a message and queues it. UDP senders cannot be slowed
+// down, so their messages are dropped when the queue is full; stream
+// senders wait.
+func (s *SyslogServer) deliver(raw, remote, proto string) {
+	m, err := ParseSyslog(raw, time.Now())
+	if err != nil {
+		atomic.AddInt64(&s.malformed, 1)
+		return
+	}
+	m.Remote, m.Protocol = remote, proto
+	atomic.AddInt64(&s.received, 1)
+	if proto == "udp" {
+		select {
+		case s.messages <- m:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+		return
+	}
+	select {
+	case s.messages <- m:
+	case <-s.closed:
+	}
+}
+
+func (s *SyslogServer) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, s.opts.MaxMessageSize)
+	for {
+		n, addr, err := s.packet.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// Some senders put several newline separated messages in a datagram
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if strings.TrimSpace(line) != "" {
+				s.deliver(line, addr.String(), "udp")
+			}
+		}
+	}
+}
+
+func (s *SyslogServer) accept(proto string, l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.connections, 1)
+		s.conns.Store(conn, struct{}{})
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.conns.Delete(conn)
+			defer conn.Close()
+			s.serveStream(conn, proto)
+		}()
+	}
+}
+
+// serveStream reads the frames of a TCP or TLS connection
+func (s *SyslogServer) serveStream(conn net.Conn, proto string) {
+	r := bufio.NewReaderSize(conn, 16*1024)
+	remote := conn.RemoteAddr().String()
+	for {
+		frame, err := readFrame(r, s.opts.MaxMessageSize)
+		if frame != "" {
+			s.deliver(frame, remote, proto)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				atomic.AddInt64(&s.malformed, 1)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads a message of a stream: "LEN MSG" with octet counting,
+// else a line. Lines longer than max are cut.
+func readFrame(r *bufio.Reader, max int) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		head, _ := r.Peek(8)
+		if sp := strings.IndexByte(string(head), ' '); sp > 0 {
+			if n, convErr := strconv.Atoi(string(head[:sp])); convErr == nil {
+				if n > max {
+					return "", fmt.Errorf("frame of %d bytes is over the %d byte limit", n, max)
+				}
+				r.Discard(sp + 1)
+				buf := make([]byte, n)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return "", err
+				}
+				return string(buf), nil
+			}
+		}
+	}
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line) < max {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(line) > max {
+			line = line[:max]
+		}
+		if err != nil {
+			return strings.TrimRight(string(line), "\r\n"), err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
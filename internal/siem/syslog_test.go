@@ -0,0 +1,285 @@
+package siem
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	received := time.Date(2024, 1, 5, 12, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		raw  string
+		want SyslogMessage
+	}{
+		{
+			// RFC 5424 section 6.5 example 3
+			`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] An application event log entry...`,
+			SyslogMessage{Priority: 165, Facility: 20, Severity: 5, Version: 1, Format: "rfc5424",
+				Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC), Hostname: "mymachine.example.com",
+				AppName: "evntslog", MsgID: "ID47", Message: "An application event log entry...",
+				StructuredData: map[string]map[string]string{"exampleSDID@32473": {"iut": "3", "eventSource": "Application", "eventID": "1011"}}},
+		},
+		{
+			`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - ` + "\ufeff" + `'su root' failed for lonvick on /dev/pts/8`,
+			SyslogMessage{Priority: 34, Facility: 4, Severity: 2, Version: 1, Format: "rfc5424",
+				Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC), Hostname: "mymachine.example.com",
+				AppName: "su", MsgID: "ID47", Message: "'su root' failed for lonvick on /dev/pts/8",
+				StructuredData: map[string]map[string]string{}},
+		},
+		{
+			`<13>1 - - - - - [a@1 k="x \"quoted\" \]"][b@2]`,
+			SyslogMessage{Priority: 13, Facility: 1, Severity: 5, Version: 1, Format: "rfc5424", Timestamp: received,
+				StructuredData: map[string]map[string]string{"a@1": {"k": `x "quoted" ]`}, "b@2": {}}},
+		},
+		{
+			// RFC 3164 section 5.4 example 1
+			`<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`,
+			SyslogMessage{Priority: 34, Facility: 4, Severity: 2, Format: "rfc3164",
+				Timestamp: time.Date(2023, 10, 11, 22, 14, 15, 0, time.Local), Hostname: "mymachine",
+				AppName: "su", Message: "'su root' failed for lonvick on /dev/pts/8"},
+		},
+		{
+			`<86>Jan  5 11:59:01 web01 sshd[4242]: Accepted publickey for deploy`,
+			SyslogMessage{Priority: 86, Facility: 10, Severity: 6, Format: "rfc3164",
+				Timestamp: time.Date(2024, 1, 5, 11, 59, 1, 0, time.Local), Hostname: "web01",
+				AppName: "sshd", ProcID: "4242", Message: "Accepted publickey for deploy"},
+		},
+		{
+			`<30>2024-01-05T11:00:00+01:00 fw01 kernel: DROP IN=eth0`,
+			SyslogMessage{Priority: 30, Facility: 3, Severity: 6, Format: "rfc3164",
+				Timestamp: time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC), Hostname: "fw01", AppName: "kernel", Message: "DROP IN=eth0"},
+		},
+		{
+			`nginx: upstream timed out`,
+			SyslogMessage{Priority: 13, Facility: 1, Severity: 5, Format: "rfc3164", Timestamp: received,
+				AppName: "nginx", Message: "upstream timed out"},
+		},
+		{
+			`<999>not a priority`,
+			SyslogMessage{Priority: 13, Facility: 1, Severity: 5, Format: "rfc3164", Timestamp: received, Message: "<999>not a priority"},
+		},
+	} {
+		m, err := ParseSyslog(tc.raw+"\n", received)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		tc.want.Raw, tc.want.Received = tc.raw, received
+		if !m.Timestamp.Equal(tc.want.Timestamp) {
+			t.Errorf("%s: timestamp %v, want %v", tc.raw, m.Timestamp, tc.want.Timestamp)
+		}
+		m.Timestamp, tc.want.Timestamp = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(*m, tc.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tc.raw, *m, tc.want)
+		}
+	}
+	if _, err := ParseSyslog(" \r\n", received); err == nil {
+		t.Error("empty message parsed")
+	}
+	if got := SyslogToMap(&SyslogMessage{Facility: 4, Severity: 2})["facility_name"]; got != "auth" {
+		t.Errorf("facility_name = %v", got)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <13>one two<13>line\r\n5 hello2024-01-01 no count\n" + strings.Repeat("x", 40)))
+	var frames []string
+	for {
+		frame, err := readFrame(r, 32)
+		if frame != "" {
+			frames = append(frames, frame)
+		}
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	want := []string{"<13>one two", "<13>line", "hello", "2024-01-01 no count", strings.Repeat("x", 32)}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames = %q", frames)
+	}
+	if _, err := readFrame(bufio.NewReader(strings.NewReader("99 <13>x")), 32); err == nil {
+		t.Error("frame over the limit was read")
+	}
+}
+
+func TestSyslogServer(t *testing.T) {
+	s, err := ListenSyslog(SyslogServerOptions{UDP: "127.0.0.1:0", TCP: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := s.Addrs()
+
+	udp, err := net.Dial("udp", addrs["udp"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(udp, "<14>Jan  5 10:00:00 host1 app: over udp")
+	udp.Close()
+	tcp, err := net.Dial("tcp", addrs["tcp"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(tcp, "<14>Jan  5 10:00:00 host2 app: framed by a newline\n26 <14>1 - host3 app - - - hi")
+	tcp.Close()
+
+	got := map[string]string{}
+	timeout := time.After(5 * time.Second)
+	for len(got) < 3 {
+		select {
+		case m := <-s.Messages():
+			got[m.Hostname] = m.Protocol + " " + m.Message
+		case <-timeout:
+			t.Fatalf("received %v", got)
+		}
+	}
+	want := map[string]string{"host1": "udp over udp", "host2": "tcp framed by a newline", "host3": "tcp hi"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v", got)
+	}
+	if st := s.Stats(); st.Received != 3 || st.Connections != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	s.Close()
+	if _, ok := <-s.Messages(); ok {
+		t.Error("messages still open after Close")
+	}
+	if _, err := ListenSyslog(SyslogServerOptions{}); err == nil {
+		t.Error("server without addresses started")
+	}
+}
+
+func TestForwardHEC(t *testing.T) {
+	forwardBackoff = time.Millisecond
+	var mu sync.Mutex
+	var bodies []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if r.URL.Path != "/services/collector/event" || r.Header.Get("Authorization") != "Splunk tok" {
+			t.Errorf("%s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"text":"Server is busy","code":9}`)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer srv.Close()
+
+	f, err := NewForwarder("hec", ForwarderOptions{URL: srv.URL + "/", Token: "tok", Index: "main", BatchSize: 2, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Send(map[string]interface{}{"message": "a", "hostname": "h1", "timestamp": "2024-01-05T10:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 0 {
+		t.Error("a batch that is not full was sent")
+	}
+	f.Send(map[string]interface{}{"message": "b"}, map[string]interface{}{"message": "c"})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("%d batches sent: %q", len(bodies), bodies)
+	}
+	first := strings.Split(strings.TrimSpace(bodies[0]), "\n")
+	var event map[string]interface{}
+	json.Unmarshal([]byte(first[0]), &event)
+	if len(first) != 2 || event["time"] != "1704448800.000" || event["host"] != "h1" || event["index"] != "main" ||
+		event["event"].(map[string]interface{})["message"] != "a" {
+		t.Errorf("first batch = %q", bodies[0])
+	}
+	if st := f.Stats(); st.Sent != 3 || st.Failed != 0 || st.Batches != 2 || st.Pending != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+	if err := f.Send(map[string]interface{}{}); err == nil {
+		t.Error("closed forwarder accepted an event")
+	}
+}
+
+func TestForwardElasticsearch(t *testing.T) {
+	forwardBackoff = time.Millisecond
+	var mu sync.Mutex
+	var docs []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if user, pass, _ := r.BasicAuth(); r.URL.Path != "/_bulk" || user != "elastic" || pass != "pw" {
+			t.Errorf("%s as %s", r.URL.Path, user)
+		}
+		lines := strings.Split(strings.TrimSpace(readAll(r.Body)), "\n")
+		var items []string
+		for i := 0; i+1 < len(lines); i += 2 {
+			if lines[i] != `{"index":{"_index":"logs"}}` {
+				t.Errorf("action %s", lines[i])
+			}
+			switch {
+			case strings.Contains(lines[i+1], "bad"):
+				items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+			case strings.Contains(lines[i+1], "busy") && calls == 1:
+				items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}`)
+			default:
+				docs = append(docs, lines[i+1])
+				items = append(items, `{"index":{"status":201}}`)
+			}
+		}
+		fmt.Fprintf(w, `{"errors":%v,"items":[%s]}`, len(items) != len(docs) || calls == 1, strings.Join(items, ","))
+	}))
+	defer srv.Close()
+
+	f, err := NewForwarder("elasticsearch", ForwarderOptions{URL: srv.URL, Index: "logs", Username: "elastic", Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Send(
+		map[string]interface{}{"message": "ok", "timestamp": "2024-01-05T10:00:00+01:00"},
+		map[string]interface{}{"message": "busy"},
+		map[string]interface{}{"message": "bad"},
+	)
+	err = f.Close()
+	if err == nil || !strings.Contains(err.Error(), "elasticsearch rejected 2 of 3 documents") {
+		t.Errorf("err = %v", err)
+	}
+	if len(docs) != 2 || !strings.Contains(docs[0], `"@timestamp":"2024-01-05T09:00:00Z"`) || !strings.Contains(docs[1], "busy") {
+		t.Errorf("docs = %q", docs)
+	}
+	if st := f.Stats(); st.Sent != 2 || st.Failed != 1 || st.Batches != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	for kind, opts := range map[string]ForwarderOptions{
+		"splunk":        {URL: srv.URL},
+		"elasticsearch": {URL: srv.URL},
+		"kafka":         {URL: srv.URL},
+		"elastic":       {URL: "localhost:9200", Index: "x"},
+	} {
+		if _, err := NewForwarder(kind, opts); err == nil {
+			t.Errorf("%s %+v was accepted", kind, opts)
+		}
+	}
+}
+
+func readAll(r io.Reader) string {
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
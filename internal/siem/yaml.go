@@ -0,0 +1,563 @@
+package siem
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This is a reader for the subset of YAML that Sigma rules are written
+// in: block mappings and sequences, plain, quoted and block scalars, flow
+// sequences and mappings, comments and documents separated by ---. Anchors,
+// aliases, tags and complex keys are not supported.
+
+type yamlLine struct {
+	num    int // 1-based line number
+	indent int
+	text   string // Without the indentation
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML returns the documents of a YAML stream as maps, slices,
+// strings, ints, float64s, bools and nils
+func parseYAML(data string) ([]interface{}, error) {
+	var docs []interface{}
+	var current []yamlLine
+	flush := func() error {
+		if len(current) == 0 {
+			return nil
+		}
+		p := &yamlParser{lines: current}
+		doc, err := p.parseNode(-1)
+		if err != nil {
+			return err
+		}
+		if p.pos < len(p.lines) {
+			l := p.lines[p.pos]
+			return fmt.Errorf("line %d: unexpected '%s'", l.num, l.text)
+		}
+		docs = append(docs, doc)
+		current = nil
+		return nil
+	}
+	for i, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if i == 0 {
+			raw = strings.TrimPrefix(raw, "\ufeff")
+		}
+		if raw == "---" || strings.HasPrefix(raw, "--- ") || raw == "..." {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if strings.HasPrefix(raw, "%") {
+			continue // Directive
+		}
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		current = append(current, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// blank reports whether a line holds nothing but a comment
+func (l yamlLine) blank() bool {
+	return l.text == "" || l.text[0] == '#'
+}
+
+// skipBlank moves past blank and comment lines
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].blank() {
+		p.pos++
+	}
+}
+
+// parseNode parses the node at the next line, which must be indented
+// more than parent
+func (p *yamlParser) parseNode(parent int) (interface{}, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= parent {
+		return nil, nil
+	}
+	l := p.lines[p.pos]
+	if isSeqItem(l.text) {
+		return p.parseSequence(l.indent)
+	}
+	if _, _, ok := splitKey(l.text); ok {
+		return p.parseMapping(l.indent)
+	}
+	p.pos++
+	return p.scalarValue(stripComment(l.text), l, parent)
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits "key: value" at its colon. The key may be quoted.
+func splitKey(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' || text[0] == '#' {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := quoteEnd(text)
+		if end < 0 {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[end+1:], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		key, err := unquote(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimRight(text[:i], " "), strings.TrimSpace(text[i+1:]), true
+		}
+		if text[i] == '#' && i > 0 && text[i-1] == ' ' {
+			break
+		}
+	}
+	return "", "", false
+}
+
+// quoteEnd returns the index of the quote that closes the string text
+// starts with, or -1
+func quoteEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a trailing comment from a value that is not quoted
+func stripComment(text string) string {
+	if text == "" || text[0] == '"' || text[0] == '\'' {
+		return text
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && (i == 0 || text[i-1] == ' ') {
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return text
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) || p.lines[p.pos].indent != indent {
+			break
+		}
+		l := p.lines[p.pos]
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a key, got '%s'", l.num, l.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key '%s'", l.num, key)
+		}
+		p.pos++
+		rest = stripComment(rest)
+		var value interface{}
+		var err error
+		if rest == "" {
+			// A sequence may sit at the indentation of its key
+			p.skipBlank()
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text) {
+				value, err = p.parseSequence(indent)
+			} else {
+				value, err = p.parseNode(indent)
+			}
+		} else {
+			value, err = p.scalarValue(rest, l, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) || p.lines[p.pos].indent != indent || !isSeqItem(p.lines[p.pos].text) {
+			break
+		}
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		var item interface{}
+		var err error
+		if rest == "" || rest[0] == '#' {
+			p.pos++
+			item, err = p.parseNode(indent)
+		} else if _, _, ok := splitKey(rest); ok || isSeqItem(rest) {
+			// The item's node starts on this line: parse it as if it
+			// began on its own line at the column of its content
+			p.lines[p.pos] = yamlLine{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest}
+			item, err = p.parseNode(indent)
+		} else {
+			p.pos++
+			item, err = p.scalarValue(stripComment(rest), l, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+	return seq, nil
+}
+
+// scalarValue parses the value that follows a key or a dash: a block
+// scalar, a flow collection or a scalar that may go on over the lines
+// indented more than parent
+func (p *yamlParser) scalarValue(text string, l yamlLine, parent int) (interface{}, error) {
+	switch text[0] {
+	case '|', '>':
+		return p.blockScalar(text, l, parent)
+	case '[', '{':
+		for !flowClosed(text) {
+			if p.pos >= len(p.lines) {
+				return nil, fmt.Errorf("line %d: unclosed '%c'", l.num, text[0])
+			}
+			text += " " + stripComment(p.lines[p.pos].text)
+			p.pos++
+		}
+		f := &flowParser{s: text}
+		v, err := f.parse()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", l.num, err)
+		}
+		return v, nil
+	case '"', '\'':
+		for quoteEnd(text) < 0 {
+			if p.pos >= len(p.lines) {
+				return nil, fmt.Errorf("line %d: unclosed quote", l.num)
+			}
+			next := p.lines[p.pos].text
+			if next == "" {
+				text += "\n"
+			} else {
+				text = strings.TrimSuffix(text, " ") + " " + next
+			}
+			p.pos++
+		}
+		end := quoteEnd(text)
+		if rest := stripComment(strings.TrimSpace(text[end+1:])); rest != "" {
+			return nil, fmt.Errorf("line %d: unexpected '%s' after a quoted string", l.num, rest)
+		}
+		return unquote(text[:end+1])
+	}
+	// A plain scalar goes on over more indented lines that are not keys
+	for p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.text == "" || next.indent <= parent || next.text[0] == '#' {
+			break
+		}
+		if _, _, ok := splitKey(next.text); ok || isSeqItem(next.text) {
+			break
+		}
+		text += " " + stripComment(next.text)
+		p.pos++
+	}
+	return resolvePlain(text), nil
+}
+
+// blockScalar reads a literal (|) or folded (>) scalar
+func (p *yamlParser) blockScalar(header string, l yamlLine, parent int) (interface{}, error) {
+	style, chomp := header[0], byte(0)
+	for _, c := range stripComment(header[1:]) {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+		default:
+			return nil, fmt.Errorf("line %d: bad block scalar header '%s'", l.num, header)
+		}
+	}
+	var lines []string
+	indent := -1
+	for p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.text == "" {
+			lines = append(lines, "")
+			p.pos++
+			continue
+		}
+		if next.indent <= parent || indent >= 0 && next.indent < indent {
+			break
+		}
+		if indent < 0 {
+			indent = next.indent
+		}
+		lines = append(lines, strings.Repeat(" ", next.indent-indent)+next.text)
+		p.pos++
+	}
+	// Trailing blank lines belong to the scalar only with keep chomping
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			switch {
+			case style == '|', line == "":
+				b.WriteByte('\n')
+			case prev == "":
+				// The blank line before gave the line break
+			case strings.HasPrefix(line, " ") || strings.HasPrefix(prev, " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	s := b.String()
+	switch chomp {
+	case '-':
+	case '+':
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		if s != "" {
+			s += "\n"
+		}
+	}
+	return s, nil
+}
+
+// flowClosed reports whether the brackets of a flow collection are closed
+func flowClosed(s string) bool {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			end := quoteEnd(s[i:])
+			if end < 0 {
+				return false
+			}
+			i += end
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unquote returns the value of a single or double quoted scalar
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	var b strings.Builder
+	body := s[1 : len(s)-1]
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(body) {
+			return "", fmt.Errorf("bad escape at the end of %s", s)
+		}
+		switch body[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '"', '\\', '/', ' ':
+			b.WriteByte(body[i])
+		case 'x', 'u', 'U':
+			n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[body[i]]
+			if i+1+n > len(body) {
+				return "", fmt.Errorf("bad escape in %s", s)
+			}
+			code, err := strconv.ParseUint(body[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("bad escape in %s", s)
+			}
+			b.WriteRune(rune(code))
+			i += n
+		default:
+			return "", fmt.Errorf("unknown escape '\\%c' in %s", body[i], s)
+		}
+	}
+	return b.String(), nil
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolvePlain gives a plain scalar its type
+func resolvePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlInt.MatchString(s) {
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// flowParser parses a flow sequence or mapping such as [a, 'b'] or {k: v}
+type flowParser struct {
+	s   string
+	pos int
+}
+
+func (f *flowParser) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *flowParser) parse() (interface{}, error) {
+	v, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	f.skipSpace()
+	if f.pos < len(f.s) {
+		return nil, fmt.Errorf("unexpected '%s' after a flow collection", f.s[f.pos:])
+	}
+	return v, nil
+}
+
+func (f *flowParser) value() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.s) {
+		return nil, fmt.Errorf("unexpected end of a flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		seq := []interface{}{}
+		for {
+			f.skipSpace()
+			if f.pos < len(f.s) && f.s[f.pos] == ']' {
+				f.pos++
+				return seq, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		m := map[string]interface{}{}
+		for {
+			f.skipSpace()
+			if f.pos < len(f.s) && f.s[f.pos] == '}' {
+				f.pos++
+				return m, nil
+			}
+			k, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			f.skipSpace()
+			var v interface{}
+			if f.pos < len(f.s) && f.s[f.pos] == ':' {
+				f.pos++
+				if v, err = f.value(); err != nil {
+					return nil, err
+				}
+			}
+			m[fmt.Sprint(k)] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := quoteEnd(f.s[f.pos:])
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed quote")
+		}
+		s, err := unquote(f.s[f.pos : f.pos+end+1])
+		f.pos += end + 1
+		return s, err
+	}
+	start := f.pos
+	for f.pos < len(f.s) && !strings.ContainsRune(",]}", rune(f.s[f.pos])) {
+		if f.s[f.pos] == ':' && (f.pos+1 == len(f.s) || f.s[f.pos+1] == ' ') {
+			break
+		}
+		f.pos++
+	}
+	return resolvePlain(strings.TrimSpace(f.s[start:f.pos])), nil
+}
+
+// separator reads the comma between items or the closing bracket, which
+// it leaves for the caller
+func (f *flowParser) separator(closing byte) error {
+	f.skipSpace()
+	if f.pos >= len(f.s) {
+		return fmt.Errorf("unclosed flow collection")
+	}
+	switch f.s[f.pos] {
+	case ',':
+		f.pos++
+		return nil
+	case closing:
+		return nil
+	}
+	return fmt.Errorf("expected ',' or '%c', got '%c'", closing, f.s[f.pos])
+}
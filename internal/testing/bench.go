@@ -0,0 +1,234 @@
+// internal/testing/bench.go
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sentra/internal/parser"
+	"sentra/internal/vmregister"
+)
+
+// Benchmark functions are top-level functions named bench_*
+const benchPrefix = "bench_"
+
+// BenchConfig controls how benchmarks are measured
+type BenchConfig struct {
+	BenchTime time.Duration // Target duration of each sample
+	Count     int           // Number of samples per benchmark
+	Warmup    time.Duration // Time spent running before measuring
+	Filter    string        // Only run benchmarks whose name contains Filter
+	Threshold float64       // Percent slowdown vs baseline reported as a regression
+}
+
+// Benchmark is a bench_* function ready to run
+type Benchmark struct {
+	Name string // file:bench_name, the key used in baselines
+	File string
+	Line int
+	fn   func() error
+}
+
+// BenchResult holds the measurements for one benchmark
+type BenchResult struct {
+	Name       string  `json:"name"`
+	Iterations int     `json:"iterations"`
+	NsPerOp    float64 `json:"ns_per_op"`
+	StdDev     float64 `json:"stddev"`
+	Samples    int     `json:"samples"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Variance returns the coefficient of variation in percent
+func (r BenchResult) Variance() float64 {
+	if r.NsPerOp == 0 {
+		return 0
+	}
+	return r.StdDev / r.NsPerOp * 100
+}
+
+// LoadBenchmarks runs a file's top-level code and collects its bench_*
+// functions in declaration order. The returned close function shuts down
+// fixture servers started by the file.
+func LoadBenchmarks(path string, configure func(*vmregister.RegisterVM)) ([]Benchmark, func(), error) {
+	file, err := loadFile(path, configure)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	globals := file.vm.GetGlobals()
+	var benchmarks []Benchmark
+	for _, stmt := range file.stmts {
+		fnStmt, ok := stmt.(*parser.FunctionStmt)
+		if !ok || !strings.HasPrefix(fnStmt.Name, benchPrefix) {
+			continue
+		}
+		vm, fn := file.vm, globals[fnStmt.Name]
+		benchmarks = append(benchmarks, Benchmark{
+			Name: fmt.Sprintf("%s:%s", path, fnStmt.Name),
+			File: path,
+			Line: fnStmt.Line,
+			fn: func() error {
+				_, err := vm.Call(fn, nil)
+				return err
+			},
+		})
+	}
+	return benchmarks, file.testModule.Close, nil
+}
+
+// RunBenchmark warms a benchmark up, picks an iteration count that fills
+// BenchTime, then takes Count samples of that many iterations
+func RunBenchmark(b Benchmark, config *BenchConfig) BenchResult {
+	result := BenchResult{Name: b.Name}
+
+	// Warm caches and the allocator before measuring
+	warmupEnd := time.Now().Add(config.Warmup)
+	for time.Now().Before(warmupEnd) {
+		if err := b.fn(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	// Grow n until one run takes at least BenchTime (as in Go's testing.B)
+	n := 1
+	for {
+		elapsed, err := timeRuns(b, n)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if elapsed >= config.BenchTime || n >= 1e9 {
+			break
+		}
+		next := n * 100
+		if elapsed > 0 {
+			next = int(float64(n) * 1.2 * float64(config.BenchTime) / float64(elapsed))
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+
+	samples := make([]float64, 0, config.Count)
+	for i := 0; i < config.Count; i++ {
+		elapsed, err := timeRuns(b, n)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		samples = append(samples, float64(elapsed.Nanoseconds())/float64(n))
+	}
+
+	result.Iterations = n
+	result.Samples = len(samples)
+	result.NsPerOp, result.StdDev = meanStdDev(samples)
+	return result
+}
+
+func timeRuns(b Benchmark, n int) (time.Duration, error) {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := b.fn(); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+func meanStdDev(samples []float64) (float64, float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s
+	}
+	mean := sum / float64(len(samples))
+	if len(samples) == 1 {
+		return mean, 0
+	}
+	sq := 0.0
+	for _, s := range samples {
+		sq += (s - mean) * (s - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(samples)-1))
+}
+
+// BenchBaseline maps benchmark names to stored results
+type BenchBaseline map[string]BenchResult
+
+// ReadBenchBaseline loads a baseline written by WriteBenchBaseline
+func ReadBenchBaseline(path string) (BenchBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline BenchBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	return baseline, nil
+}
+
+// WriteBenchBaseline stores results so later runs can be compared to them
+func WriteBenchBaseline(path string, results []BenchResult) error {
+	baseline := make(BenchBaseline)
+	for _, r := range results {
+		if r.Error == "" {
+			baseline[r.Name] = r
+		}
+	}
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// PrintBenchResults prints one line per benchmark, with the change against
+// baseline when available. Returns the names of regressed benchmarks.
+func PrintBenchResults(w io.Writer, results []BenchResult, baseline BenchBaseline, threshold float64) []string {
+	width := 0
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+
+	var regressions []string
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%-*s  \033[31mFAIL\033[0m %s\n", width, r.Name, r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%-*s  %10d  %14.1f ns/op  ±%4.1f%%", width, r.Name, r.Iterations, r.NsPerOp, r.Variance())
+
+		if old, ok := baseline[r.Name]; ok && old.NsPerOp > 0 {
+			delta := (r.NsPerOp - old.NsPerOp) / old.NsPerOp * 100
+			// Changes within the measurement noise are not reported
+			noise := math.Max(threshold, r.Variance()+old.Variance())
+			switch {
+			case delta > noise:
+				fmt.Fprintf(w, "  \033[31m%+6.1f%% REGRESSION\033[0m", delta)
+				regressions = append(regressions, r.Name)
+			case delta < -noise:
+				fmt.Fprintf(w, "  \033[32m%+6.1f%% faster\033[0m", delta)
+			default:
+				fmt.Fprintf(w, "  %+6.1f%% ~", delta)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	sort.Strings(regressions)
+	return regressions
+}
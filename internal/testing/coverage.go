@@ -0,0 +1,137 @@
+// internal/testing/coverage.go
+package testing
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"sentra/internal/vmregister"
+)
+
+// FileCoverage summarises line coverage for one source file
+type FileCoverage struct {
+	File    string
+	Lines   map[int]uint64 // Instrumented line -> hit count
+	Covered int
+	Total   int
+}
+
+// Percent returns the share of instrumented lines that ran
+func (fc FileCoverage) Percent() float64 {
+	if fc.Total == 0 {
+		return 100
+	}
+	return float64(fc.Covered) * 100 / float64(fc.Total)
+}
+
+// SummarizeCoverage computes per-file line coverage
+func SummarizeCoverage(cov *vmregister.Coverage) []FileCoverage {
+	var files []FileCoverage
+	for _, file := range cov.Files() {
+		fc := FileCoverage{File: file, Lines: cov.LineHits(file)}
+		for _, hits := range fc.Lines {
+			fc.Total++
+			if hits > 0 {
+				fc.Covered++
+			}
+		}
+		files = append(files, fc)
+	}
+	return files
+}
+
+// PrintCoverageSummary writes one line per file plus a total
+func PrintCoverageSummary(w io.Writer, files []FileCoverage) {
+	if len(files) == 0 {
+		fmt.Fprintln(w, "coverage: no instrumented modules (only files imported by tests are measured)")
+		return
+	}
+	covered, total := 0, 0
+	for _, fc := range files {
+		fmt.Fprintf(w, "coverage: %5.1f%% of lines  %s\n", fc.Percent(), fc.File)
+		covered += fc.Covered
+		total += fc.Total
+	}
+	fmt.Fprintf(w, "coverage: %5.1f%% of lines  total\n", FileCoverage{Covered: covered, Total: total}.Percent())
+}
+
+// WriteLCOV writes coverage in LCOV tracefile format
+func WriteLCOV(w io.Writer, files []FileCoverage) error {
+	for _, fc := range files {
+		if _, err := fmt.Fprintf(w, "TN:\nSF:%s\n", fc.File); err != nil {
+			return err
+		}
+		for _, line := range sortedLines(fc.Lines) {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, fc.Lines[line])
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", fc.Total, fc.Covered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCoverageHTML writes a self-contained HTML page showing each source
+// file with covered lines in green and missed lines in red
+func WriteCoverageHTML(w io.Writer, files []FileCoverage) error {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Sentra coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #fafafa; border: 1px solid #ddd; padding: 0.5em; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.ln { color: #999; display: inline-block; width: 4em; }
+.cnt { color: #666; display: inline-block; width: 4em; }
+</style></head><body>
+<h1>Coverage report</h1>
+<table>
+`)
+	for i, fc := range files {
+		fmt.Fprintf(&b, "<tr><td><a href=\"#f%d\">%s</a></td><td>%.1f%% (%d/%d lines)</td></tr>\n",
+			i, html.EscapeString(fc.File), fc.Percent(), fc.Covered, fc.Total)
+	}
+	b.WriteString("</table>\n")
+
+	for i, fc := range files {
+		fmt.Fprintf(&b, "<h2 id=\"f%d\">%s</h2>\n<pre>", i, html.EscapeString(fc.File))
+		source, err := os.ReadFile(fc.File)
+		if err != nil {
+			fmt.Fprintf(&b, "source unavailable: %s", html.EscapeString(err.Error()))
+		} else {
+			for n, text := range strings.Split(string(source), "\n") {
+				line := n + 1
+				hits, instrumented := fc.Lines[line]
+				class, count := "", ""
+				if instrumented {
+					class = "miss"
+					if hits > 0 {
+						class = "hit"
+					}
+					count = fmt.Sprintf("%d", hits)
+				}
+				fmt.Fprintf(&b, "<span class=\"%s\"><span class=\"ln\">%d</span><span class=\"cnt\">%s</span>%s</span>\n",
+					class, line, count, html.EscapeString(text))
+			}
+		}
+		b.WriteString("</pre>\n")
+	}
+	b.WriteString("</body></html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedLines(lines map[int]uint64) []int {
+	keys := make([]int, 0, len(lines))
+	for line := range lines {
+		keys = append(keys, line)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,213 @@
+// internal/testing/fixtures.go
+package testing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"sentra/internal/vmregister"
+)
+
+// Fixture is a canned HTTP response served by a FixtureServer
+type Fixture struct {
+	Status  int
+	Body    string
+	Headers map[string]string
+}
+
+// RecordedRequest is a request received by a FixtureServer
+type RecordedRequest struct {
+	Method  string
+	Path    string
+	Query   string
+	Body    string
+	Headers map[string]string
+}
+
+// FixtureServer is a local HTTP server returning canned responses so that
+// tests for HTTP-based scanners run without network access
+type FixtureServer struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	routes   map[string]Fixture // "METHOD /path" or "* /path" -> response
+	requests []RecordedRequest
+}
+
+// NewFixtureServer starts a fixture server on a random local port
+func NewFixtureServer() *FixtureServer {
+	fs := &FixtureServer{
+		routes: make(map[string]Fixture),
+	}
+	fs.server = httptest.NewServer(http.HandlerFunc(fs.serveHTTP))
+	return fs
+}
+
+// URL returns the base URL of the server
+func (fs *FixtureServer) URL() string {
+	return fs.server.URL
+}
+
+// Handle registers a response for method and path. Use "*" to match any method.
+func (fs *FixtureServer) Handle(method, path string, fixture Fixture) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.routes[method+" "+path] = fixture
+}
+
+// Requests returns the requests received so far
+func (fs *FixtureServer) Requests() []RecordedRequest {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]RecordedRequest(nil), fs.requests...)
+}
+
+// Close shuts the server down
+func (fs *FixtureServer) Close() {
+	fs.server.Close()
+}
+
+func (fs *FixtureServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	headers := make(map[string]string)
+	for name := range r.Header {
+		headers[name] = r.Header.Get(name)
+	}
+
+	fs.mu.Lock()
+	fs.requests = append(fs.requests, RecordedRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Body:    string(body),
+		Headers: headers,
+	})
+	fixture, ok := fs.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		fixture, ok = fs.routes["* "+r.URL.Path]
+	}
+	fs.mu.Unlock()
+
+	if !ok {
+		http.Error(w, fmt.Sprintf("no fixture for %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+		return
+	}
+	for name, value := range fixture.Headers {
+		w.Header().Set(name, value)
+	}
+	status := fixture.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, fixture.Body)
+}
+
+// TestModule is the `test` module available to test files via `import test`
+type TestModule struct {
+	vm      *vmregister.RegisterVM
+	servers []*FixtureServer
+}
+
+// InstallTestModule registers the `test` module on a VM:
+//
+//	test.mock(name, fn_or_value)  replace a builtin (restored after each test)
+//	test.restore(name)            restore one builtin
+//	test.restore_all()            restore every mocked builtin
+//	test.calls(name)              arguments of each call to a mocked builtin
+//	test.fixture_server()         start an HTTP fixture server
+func InstallTestModule(vm *vmregister.RegisterVM) *TestModule {
+	tm := &TestModule{vm: vm}
+
+	vm.RegisterModule("test", map[string]vmregister.Value{
+		"mock": vmregister.NewNativeFn("mock", 2, func(args []vmregister.Value) (vmregister.Value, error) {
+			if len(args) < 2 {
+				return vmregister.NilValue(), fmt.Errorf("test.mock expects (name, replacement)")
+			}
+			return vmregister.NilValue(), vm.Mock(vmregister.ToString(args[0]), args[1])
+		}),
+		"restore": vmregister.NewNativeFn("restore", 1, func(args []vmregister.Value) (vmregister.Value, error) {
+			if len(args) < 1 {
+				return vmregister.NilValue(), fmt.Errorf("test.restore expects (name)")
+			}
+			vm.RestoreMock(vmregister.ToString(args[0]))
+			return vmregister.NilValue(), nil
+		}),
+		"restore_all": vmregister.NewNativeFn("restore_all", 0, func(args []vmregister.Value) (vmregister.Value, error) {
+			vm.RestoreMocks()
+			return vmregister.NilValue(), nil
+		}),
+		"calls": vmregister.NewNativeFn("calls", 1, func(args []vmregister.Value) (vmregister.Value, error) {
+			if len(args) < 1 {
+				return vmregister.NilValue(), fmt.Errorf("test.calls expects (name)")
+			}
+			calls := vm.MockCalls(vmregister.ToString(args[0]))
+			return vmregister.BoxArray(append([]vmregister.Value{}, calls...)), nil
+		}),
+		"fixture_server": vmregister.NewNativeFn("fixture_server", 0, func(args []vmregister.Value) (vmregister.Value, error) {
+			return tm.newServerValue(), nil
+		}),
+	})
+
+	return tm
+}
+
+// Close shuts down fixture servers started by the test file
+func (tm *TestModule) Close() {
+	for _, server := range tm.servers {
+		server.Close()
+	}
+	tm.servers = nil
+}
+
+// newServerValue starts a fixture server and exposes it as a map:
+// server.url, server.route(method, path, status, body[, headers]),
+// server.requests() and server.close()
+func (tm *TestModule) newServerValue() vmregister.Value {
+	fs := NewFixtureServer()
+	tm.servers = append(tm.servers, fs)
+
+	return vmregister.BoxMap(map[string]vmregister.Value{
+		"url": vmregister.BoxString(fs.URL()),
+		"route": vmregister.NewNativeFn("route", -1, func(args []vmregister.Value) (vmregister.Value, error) {
+			if len(args) < 4 {
+				return vmregister.NilValue(), fmt.Errorf("route expects (method, path, status, body[, headers])")
+			}
+			fixture := Fixture{
+				Status: int(vmregister.ToNumber(args[2])),
+				Body:   vmregister.ToString(args[3]),
+			}
+			if len(args) > 4 && vmregister.IsMap(args[4]) {
+				fixture.Headers = make(map[string]string)
+				for name, value := range vmregister.AsMap(args[4]).Items {
+					fixture.Headers[name] = vmregister.ToString(value)
+				}
+			}
+			fs.Handle(vmregister.ToString(args[0]), vmregister.ToString(args[1]), fixture)
+			return vmregister.NilValue(), nil
+		}),
+		"requests": vmregister.NewNativeFn("requests", 0, func(args []vmregister.Value) (vmregister.Value, error) {
+			var requests []vmregister.Value
+			for _, req := range fs.Requests() {
+				headers := make(map[string]vmregister.Value)
+				for name, value := range req.Headers {
+					headers[name] = vmregister.BoxString(value)
+				}
+				requests = append(requests, vmregister.BoxMap(map[string]vmregister.Value{
+					"method":  vmregister.BoxString(req.Method),
+					"path":    vmregister.BoxString(req.Path),
+					"query":   vmregister.BoxString(req.Query),
+					"body":    vmregister.BoxString(req.Body),
+					"headers": vmregister.BoxMap(headers),
+				}))
+			}
+			return vmregister.BoxArray(requests), nil
+		}),
+		"close": vmregister.NewNativeFn("close", 0, func(args []vmregister.Value) (vmregister.Value, error) {
+			fs.Close()
+			return vmregister.NilValue(), nil
+		}),
+	})
+}
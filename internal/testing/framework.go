@@ -3,6 +3,8 @@ package testing
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -12,6 +14,7 @@ import (
 type TestResult struct {
 	Name     string
 	File     string
+	Line     int
 	Passed   bool
 	Failed   bool
 	Skipped  bool
@@ -38,10 +41,12 @@ type TestSuite struct {
 type TestCase struct {
 	Name        string
 	Description string
+	Line        int // Source line of the test definition
 	Function    func(*TestContext) error
 	Skip        bool
 	Only        bool // For focused testing
 	Timeout     time.Duration
+	Interrupt   func() // Stops a running test on timeout (optional)
 }
 
 // TestContext provides testing utilities to test functions
@@ -71,7 +76,8 @@ type TestConfig struct {
 	Timeout      time.Duration
 	FailFast     bool
 	Coverage     bool
-	OutputFormat string // "text", "json", "junit"
+	OutputFormat string    // "text", "json", "junit", "tap"
+	Output       io.Writer // Report destination (defaults to stdout)
 }
 
 // TestStats tracks overall test statistics
@@ -105,14 +111,21 @@ func NewTestRunner(config *TestConfig) *TestRunner {
 		}
 	}
 	
+	out := config.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	
 	var reporter TestReporter
 	switch config.OutputFormat {
 	case "json":
-		reporter = NewJSONReporter()
+		reporter = NewJSONReporter(out)
 	case "junit":
-		reporter = NewJUnitReporter()
+		reporter = NewJUnitReporter(out)
+	case "tap":
+		reporter = NewTAPReporter(out)
 	default:
-		reporter = NewTextReporter(config.Verbose)
+		reporter = NewTextReporter(out, config.Verbose)
 	}
 	
 	return &TestRunner{
@@ -162,12 +175,16 @@ func (r *TestRunner) runSuite(suite *TestSuite) {
 				result := TestResult{
 					Name:    test.Name,
 					File:    suite.File,
+					Line:    test.Line,
 					Failed:  true,
 					Error:   fmt.Errorf("BeforeAll failed: %v", err),
 				}
 				suite.Results = append(suite.Results, result)
 				r.reporter.TestFailed(result)
 			}
+			suite.EndTime = time.Now()
+			r.reporter.EndSuite(suite)
+			r.updateStats(suite)
 			return
 		}
 	}
@@ -199,6 +216,7 @@ func (r *TestRunner) runTest(suite *TestSuite, test *TestCase) {
 		result := TestResult{
 			Name:    test.Name,
 			File:    suite.File,
+			Line:    test.Line,
 			Skipped: true,
 		}
 		suite.Results = append(suite.Results, result)
@@ -222,6 +240,7 @@ func (r *TestRunner) runTest(suite *TestSuite, test *TestCase) {
 			result := TestResult{
 				Name:   test.Name,
 				File:   suite.File,
+				Line:   test.Line,
 				Failed: true,
 				Error:  fmt.Errorf("BeforeEach failed: %v", err),
 			}
@@ -245,10 +264,16 @@ func (r *TestRunner) runTest(suite *TestSuite, test *TestCase) {
 	result := TestResult{
 		Name:     test.Name,
 		File:     suite.File,
+		Line:     test.Line,
 		Duration: duration,
 	}
 	
-	if err != nil || len(ctx.failures) > 0 {
+	if test.Skip {
+		// Skipped from inside the test
+		result.Skipped = true
+		result.Message = strings.Join(ctx.logs, "\n")
+		r.reporter.TestSkipped(result)
+	} else if err != nil || len(ctx.failures) > 0 {
 		result.Failed = true
 		result.Error = err
 		if len(ctx.failures) > 0 {
@@ -269,16 +294,27 @@ func (r *TestRunner) executeTest(test *TestCase, ctx *TestContext) error {
 	if timeout == 0 {
 		timeout = r.config.Timeout
 	}
+	if timeout <= 0 {
+		return test.Function(ctx)
+	}
 	
 	done := make(chan error, 1)
 	go func() {
 		done <- test.Function(ctx)
 	}()
 	
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	
 	select {
 	case err := <-done:
 		return err
-	case <-time.After(timeout):
+	case <-timer.C:
+		if test.Interrupt != nil {
+			// Wait for the test to stop so hooks don't run concurrently with it
+			test.Interrupt()
+			<-done
+		}
 		return fmt.Errorf("test timed out after %v", timeout)
 	}
 }
@@ -293,9 +329,6 @@ func (r *TestRunner) shouldRunSuite(suite *TestSuite) bool {
 }
 
 func (r *TestRunner) shouldRunTest(test *TestCase) bool {
-	if test.Skip {
-		return false
-	}
 	if r.config.Filter == "" {
 		return true
 	}
@@ -406,23 +439,35 @@ func (ctx *TestContext) Skip(reason string) {
 	ctx.Log(fmt.Sprintf("Test skipped: %s", reason))
 }
 
-// DiscoverTests finds all test files in a directory
+// DiscoverTests finds all test files in a directory tree, skipping hidden
+// directories and installed dependencies
 func DiscoverTests(dir string, pattern string) ([]string, error) {
 	if pattern == "" {
 		pattern = "*_test.sn"
 	}
 	
-	matches, err := filepath.Glob(filepath.Join(dir, pattern))
-	if err != nil {
-		return nil, err
-	}
-	
-	// Also search subdirectories
-	subPattern := filepath.Join(dir, "**", pattern)
-	subMatches, err := filepath.Glob(subPattern)
-	if err == nil {
-		matches = append(matches, subMatches...)
-	}
+	var matches []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "sentra_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, info.Name()); ok {
+			matches = append(matches, path)
+		}
+		return nil
+	})
 	
-	return matches, nil
+	return matches, err
+}
+
+// Stats returns the statistics collected so far
+func (r *TestRunner) Stats() *TestStats {
+	return r.stats
 }
\ No newline at end of file
@@ -5,34 +5,65 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 )
 
+// location formats a result's file:line for reports
+func location(result TestResult) string {
+	if result.Line > 0 {
+		return fmt.Sprintf("%s:%d", result.File, result.Line)
+	}
+	return result.File
+}
+
+// errorText returns the failure text of a result (error first, then messages)
+func errorText(result TestResult) string {
+	var parts []string
+	if result.Error != nil {
+		parts = append(parts, result.Error.Error())
+	}
+	if result.Message != "" {
+		parts = append(parts, result.Message)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	if idx := strings.Index(s, "\n"); idx != -1 {
+		return s[:idx]
+	}
+	return s
+}
+
 // TextReporter outputs human-readable test results
 type TextReporter struct {
+	out     io.Writer
 	verbose bool
 	indent  int
 }
 
-func NewTextReporter(verbose bool) *TextReporter {
+func NewTextReporter(out io.Writer, verbose bool) *TextReporter {
 	return &TextReporter{
+		out:     out,
 		verbose: verbose,
 		indent:  0,
 	}
 }
 
 func (r *TextReporter) StartSuite(suite *TestSuite) {
-	fmt.Printf("\n📦 Running test suite: %s\n", suite.Name)
+	fmt.Fprintf(r.out, "\n📦 Running test suite: %s\n", suite.Name)
 	if suite.File != "" {
-		fmt.Printf("   File: %s\n", suite.File)
+		fmt.Fprintf(r.out, "   File: %s\n", suite.File)
 	}
 	r.indent = 2
 }
 
 func (r *TextReporter) EndSuite(suite *TestSuite) {
 	duration := suite.EndTime.Sub(suite.StartTime)
-	fmt.Printf("   Suite completed in %v\n", duration)
+	fmt.Fprintf(r.out, "   Suite completed in %v\n", duration)
 	r.indent = 0
 }
 
@@ -40,13 +71,13 @@ func (r *TextReporter) TestPassed(result TestResult) {
 	symbol := "✓"
 	color := "\033[32m" // Green
 	reset := "\033[0m"
-	
-	fmt.Printf("%s%s%s %s%s (%v)\n", 
+
+	fmt.Fprintf(r.out, "%s%s%s %s%s (%v)\n",
 		strings.Repeat(" ", r.indent),
 		color, symbol, result.Name, reset, result.Duration)
-	
+
 	if r.verbose && result.Message != "" {
-		fmt.Printf("%s  %s\n", strings.Repeat(" ", r.indent+2), result.Message)
+		fmt.Fprintf(r.out, "%s  %s\n", strings.Repeat(" ", r.indent+2), result.Message)
 	}
 }
 
@@ -54,75 +85,85 @@ func (r *TextReporter) TestFailed(result TestResult) {
 	symbol := "✗"
 	color := "\033[31m" // Red
 	reset := "\033[0m"
-	
-	fmt.Printf("%s%s%s %s%s (%v)\n", 
+
+	fmt.Fprintf(r.out, "%s%s%s %s%s (%v)\n",
 		strings.Repeat(" ", r.indent),
 		color, symbol, result.Name, reset, result.Duration)
-	
+
 	if result.Error != nil {
-		fmt.Printf("%s  Error: %v\n", strings.Repeat(" ", r.indent+2), result.Error)
+		lines := strings.Split(result.Error.Error(), "\n")
+		fmt.Fprintf(r.out, "%s  Error: %s\n", strings.Repeat(" ", r.indent+2), lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(r.out, "%s    %s\n", strings.Repeat(" ", r.indent+2), line)
+		}
 	}
 	if result.Message != "" {
 		lines := strings.Split(result.Message, "\n")
 		for _, line := range lines {
-			fmt.Printf("%s  %s\n", strings.Repeat(" ", r.indent+2), line)
+			fmt.Fprintf(r.out, "%s  %s\n", strings.Repeat(" ", r.indent+2), line)
 		}
 	}
+	if result.Line > 0 {
+		fmt.Fprintf(r.out, "%s  at %s\n", strings.Repeat(" ", r.indent+2), location(result))
+	}
 }
 
 func (r *TextReporter) TestSkipped(result TestResult) {
 	symbol := "⊘"
 	color := "\033[33m" // Yellow
 	reset := "\033[0m"
-	
-	fmt.Printf("%s%s%s %s (skipped)%s\n", 
+
+	fmt.Fprintf(r.out, "%s%s%s %s (skipped)%s\n",
 		strings.Repeat(" ", r.indent),
 		color, symbol, result.Name, reset)
 }
 
 func (r *TextReporter) Summary(stats *TestStats) {
-	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
-	fmt.Printf("📊 Test Results Summary\n")
-	fmt.Printf(strings.Repeat("=", 60) + "\n")
-	
-	fmt.Printf("Total Tests:    %d\n", stats.TotalTests)
-	
+	fmt.Fprint(r.out, "\n"+strings.Repeat("=", 60)+"\n")
+	fmt.Fprintf(r.out, "📊 Test Results Summary\n")
+	fmt.Fprint(r.out, strings.Repeat("=", 60)+"\n")
+
+	fmt.Fprintf(r.out, "Total Tests:    %d\n", stats.TotalTests)
+
 	if stats.PassedTests > 0 {
-		fmt.Printf("\033[32m✓ Passed:       %d\033[0m\n", stats.PassedTests)
+		fmt.Fprintf(r.out, "\033[32m✓ Passed:       %d\033[0m\n", stats.PassedTests)
 	}
-	
+
 	if stats.FailedTests > 0 {
-		fmt.Printf("\033[31m✗ Failed:       %d\033[0m\n", stats.FailedTests)
+		fmt.Fprintf(r.out, "\033[31m✗ Failed:       %d\033[0m\n", stats.FailedTests)
 	}
-	
+
 	if stats.SkippedTests > 0 {
-		fmt.Printf("\033[33m⊘ Skipped:      %d\033[0m\n", stats.SkippedTests)
+		fmt.Fprintf(r.out, "\033[33m⊘ Skipped:      %d\033[0m\n", stats.SkippedTests)
 	}
-	
-	fmt.Printf("Test Suites:    %d\n", stats.Suites)
-	fmt.Printf("Total Time:     %v\n", stats.TotalTime)
-	
+
+	fmt.Fprintf(r.out, "Test Suites:    %d\n", stats.Suites)
+	fmt.Fprintf(r.out, "Total Time:     %v\n", stats.TotalTime)
+
 	if stats.FailedTests == 0 {
-		fmt.Printf("\n\033[32m🎉 All tests passed!\033[0m\n")
+		fmt.Fprintf(r.out, "\n\033[32m🎉 All tests passed!\033[0m\n")
 	} else {
-		fmt.Printf("\n\033[31m❌ Some tests failed.\033[0m\n")
+		fmt.Fprintf(r.out, "\n\033[31m❌ Some tests failed.\033[0m\n")
 	}
 }
 
 // JSONReporter outputs test results in JSON format
 type JSONReporter struct {
+	out     io.Writer
 	results []JSONTestResult
 }
 
 type JSONTestResult struct {
-	Suite    string        `json:"suite"`
-	Test     string        `json:"test"`
-	Passed   bool          `json:"passed"`
-	Failed   bool          `json:"failed"`
-	Skipped  bool          `json:"skipped"`
-	Duration time.Duration `json:"duration"`
-	Error    string        `json:"error,omitempty"`
-	Message  string        `json:"message,omitempty"`
+	Suite    string  `json:"suite"`
+	Test     string  `json:"test"`
+	File     string  `json:"file"`
+	Line     int     `json:"line,omitempty"`
+	Passed   bool    `json:"passed"`
+	Failed   bool    `json:"failed"`
+	Skipped  bool    `json:"skipped"`
+	Duration float64 `json:"duration"` // Seconds
+	Error    string  `json:"error,omitempty"`
+	Message  string  `json:"message,omitempty"`
 }
 
 type JSONSummary struct {
@@ -131,11 +172,13 @@ type JSONSummary struct {
 	PassedTests  int              `json:"passed_tests"`
 	FailedTests  int              `json:"failed_tests"`
 	SkippedTests int              `json:"skipped_tests"`
-	TotalTime    time.Duration    `json:"total_time"`
+	Suites       int              `json:"suites"`
+	TotalTime    float64          `json:"total_time"` // Seconds
 }
 
-func NewJSONReporter() *JSONReporter {
+func NewJSONReporter(out io.Writer) *JSONReporter {
 	return &JSONReporter{
+		out:     out,
 		results: make([]JSONTestResult, 0),
 	}
 }
@@ -152,8 +195,10 @@ func (r *JSONReporter) TestPassed(result TestResult) {
 	r.results = append(r.results, JSONTestResult{
 		Test:     result.Name,
 		Suite:    result.File,
+		File:     result.File,
+		Line:     result.Line,
 		Passed:   true,
-		Duration: result.Duration,
+		Duration: result.Duration.Seconds(),
 		Message:  result.Message,
 	})
 }
@@ -163,12 +208,14 @@ func (r *JSONReporter) TestFailed(result TestResult) {
 	if result.Error != nil {
 		errorMsg = result.Error.Error()
 	}
-	
+
 	r.results = append(r.results, JSONTestResult{
 		Test:     result.Name,
 		Suite:    result.File,
+		File:     result.File,
+		Line:     result.Line,
 		Failed:   true,
-		Duration: result.Duration,
+		Duration: result.Duration.Seconds(),
 		Error:    errorMsg,
 		Message:  result.Message,
 	})
@@ -178,6 +225,8 @@ func (r *JSONReporter) TestSkipped(result TestResult) {
 	r.results = append(r.results, JSONTestResult{
 		Test:    result.Name,
 		Suite:   result.File,
+		File:    result.File,
+		Line:    result.Line,
 		Skipped: true,
 		Message: result.Message,
 	})
@@ -190,35 +239,43 @@ func (r *JSONReporter) Summary(stats *TestStats) {
 		PassedTests:  stats.PassedTests,
 		FailedTests:  stats.FailedTests,
 		SkippedTests: stats.SkippedTests,
-		TotalTime:    stats.TotalTime,
+		Suites:       stats.Suites,
+		TotalTime:    stats.TotalTime.Seconds(),
 	}
-	
+
 	output, err := json.MarshalIndent(summary, "", "  ")
 	if err != nil {
-		fmt.Printf("Error generating JSON output: %v\n", err)
+		fmt.Fprintf(r.out, "Error generating JSON output: %v\n", err)
 		return
 	}
-	
-	fmt.Println(string(output))
+
+	fmt.Fprintln(r.out, string(output))
 }
 
 // JUnitReporter outputs test results in JUnit XML format
 type JUnitReporter struct {
+	out        io.Writer
 	testSuites []JUnitTestSuite
 }
 
 type JUnitTestSuites struct {
 	XMLName    xml.Name         `xml:"testsuites"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
 	TestSuites []JUnitTestSuite `xml:"testsuite"`
 }
 
 type JUnitTestSuite struct {
 	XMLName   xml.Name        `xml:"testsuite"`
 	Name      string          `xml:"name,attr"`
+	File      string          `xml:"file,attr,omitempty"`
 	Tests     int             `xml:"tests,attr"`
 	Failures  int             `xml:"failures,attr"`
 	Skipped   int             `xml:"skipped,attr"`
 	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
 	TestCases []JUnitTestCase `xml:"testcase"`
 }
 
@@ -226,6 +283,8 @@ type JUnitTestCase struct {
 	XMLName   xml.Name      `xml:"testcase"`
 	Name      string        `xml:"name,attr"`
 	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
 	Time      float64       `xml:"time,attr"`
 	Failure   *JUnitFailure `xml:"failure,omitempty"`
 	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
@@ -241,8 +300,9 @@ type JUnitSkipped struct {
 	Message string `xml:"message,attr,omitempty"`
 }
 
-func NewJUnitReporter() *JUnitReporter {
+func NewJUnitReporter(out io.Writer) *JUnitReporter {
 	return &JUnitReporter{
+		out:        out,
 		testSuites: make([]JUnitTestSuite, 0),
 	}
 }
@@ -254,26 +314,29 @@ func (r *JUnitReporter) StartSuite(suite *TestSuite) {
 func (r *JUnitReporter) EndSuite(suite *TestSuite) {
 	junitSuite := JUnitTestSuite{
 		Name:      suite.Name,
+		File:      suite.File,
 		Tests:     len(suite.Results),
 		Time:      suite.EndTime.Sub(suite.StartTime).Seconds(),
+		Timestamp: suite.StartTime.Format(time.RFC3339),
 		TestCases: make([]JUnitTestCase, 0),
 	}
-	
+
 	for _, result := range suite.Results {
 		testCase := JUnitTestCase{
 			Name:      result.Name,
 			ClassName: suite.Name,
+			File:      result.File,
+			Line:      result.Line,
 			Time:      result.Duration.Seconds(),
 		}
-		
+
 		if result.Failed {
 			junitSuite.Failures++
+			text := errorText(result)
 			testCase.Failure = &JUnitFailure{
 				Type:    "AssertionError",
-				Message: result.Message,
-			}
-			if result.Error != nil {
-				testCase.Failure.Content = result.Error.Error()
+				Message: firstLine(text),
+				Content: text + "\n\nat " + location(result),
 			}
 		} else if result.Skipped {
 			junitSuite.Skipped++
@@ -281,10 +344,10 @@ func (r *JUnitReporter) EndSuite(suite *TestSuite) {
 				Message: result.Message,
 			}
 		}
-		
+
 		junitSuite.TestCases = append(junitSuite.TestCases, testCase)
 	}
-	
+
 	r.testSuites = append(r.testSuites, junitSuite)
 }
 
@@ -302,15 +365,71 @@ func (r *JUnitReporter) TestSkipped(result TestResult) {
 
 func (r *JUnitReporter) Summary(stats *TestStats) {
 	suites := JUnitTestSuites{
+		Tests:      stats.TotalTests,
+		Failures:   stats.FailedTests,
+		Skipped:    stats.SkippedTests,
+		Time:       stats.TotalTime.Seconds(),
 		TestSuites: r.testSuites,
 	}
-	
+
 	output, err := xml.MarshalIndent(suites, "", "  ")
 	if err != nil {
-		fmt.Printf("Error generating JUnit XML output: %v\n", err)
+		fmt.Fprintf(r.out, "Error generating JUnit XML output: %v\n", err)
 		return
 	}
-	
-	fmt.Println(xml.Header)
-	fmt.Println(string(output))
-}
\ No newline at end of file
+
+	fmt.Fprint(r.out, xml.Header)
+	fmt.Fprintln(r.out, string(output))
+}
+
+// TAPReporter outputs test results in TAP version 13 format.
+// The plan line is written at the end since tests are discovered per suite.
+type TAPReporter struct {
+	out   io.Writer
+	count int
+}
+
+func NewTAPReporter(out io.Writer) *TAPReporter {
+	fmt.Fprintln(out, "TAP version 13")
+	return &TAPReporter{out: out}
+}
+
+func (r *TAPReporter) StartSuite(suite *TestSuite) {
+	fmt.Fprintf(r.out, "# %s\n", suite.Name)
+}
+
+func (r *TAPReporter) EndSuite(suite *TestSuite) {
+}
+
+func (r *TAPReporter) TestPassed(result TestResult) {
+	r.count++
+	fmt.Fprintf(r.out, "ok %d - %s\n", r.count, result.Name)
+}
+
+func (r *TAPReporter) TestFailed(result TestResult) {
+	r.count++
+	fmt.Fprintf(r.out, "not ok %d - %s\n", r.count, result.Name)
+
+	// YAML diagnostic block
+	fmt.Fprintln(r.out, "  ---")
+	fmt.Fprintln(r.out, "  message: |")
+	for _, line := range strings.Split(errorText(result), "\n") {
+		fmt.Fprintf(r.out, "    %s\n", line)
+	}
+	fmt.Fprintf(r.out, "  at: %q\n", location(result))
+	fmt.Fprintf(r.out, "  duration_ms: %.3f\n", float64(result.Duration.Microseconds())/1000)
+	fmt.Fprintln(r.out, "  ...")
+}
+
+func (r *TAPReporter) TestSkipped(result TestResult) {
+	r.count++
+	reason := strings.TrimPrefix(result.Message, "Test skipped: ")
+	fmt.Fprintf(r.out, "ok %d - %s # SKIP %s\n", r.count, result.Name, reason)
+}
+
+func (r *TAPReporter) Summary(stats *TestStats) {
+	fmt.Fprintf(r.out, "1..%d\n", r.count)
+	fmt.Fprintf(r.out, "# pass %d\n", stats.PassedTests)
+	fmt.Fprintf(r.out, "# fail %d\n", stats.FailedTests)
+	fmt.Fprintf(r.out, "# skip %d\n", stats.SkippedTests)
+}
@@ -0,0 +1,170 @@
+// internal/testing/runner.go
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"sentra/internal/compregister"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	"sentra/internal/vmregister"
+)
+
+// Test functions are top-level functions named test_*. A file may also
+// define before_all, after_all, before_each and after_each hooks.
+const testPrefix = "test_"
+
+// LoadSuite runs a test file's top-level code in a fresh register VM and
+// collects its test functions in declaration order. configure, if non-nil,
+// is called on the VM before the file runs (e.g. to install a module loader).
+func LoadSuite(path string, configure func(*vmregister.RegisterVM)) (*TestSuite, error) {
+	file, err := loadFile(path, configure)
+	if err != nil {
+		return nil, err
+	}
+	vm, stmts, testModule := file.vm, file.stmts, file.testModule
+
+	suite := &TestSuite{
+		Name: path,
+		File: path,
+	}
+	globals := vm.GetGlobals()
+
+	for _, stmt := range stmts {
+		fnStmt, ok := stmt.(*parser.FunctionStmt)
+		if !ok {
+			continue
+		}
+		fn := globals[fnStmt.Name]
+
+		switch fnStmt.Name {
+		case "before_all":
+			suite.BeforeAll = hook(vm, fn)
+		case "after_all":
+			suite.AfterAll = hook(vm, fn)
+		case "before_each":
+			suite.BeforeEach = hook(vm, fn)
+		case "after_each":
+			suite.AfterEach = hook(vm, fn)
+		default:
+			if strings.HasPrefix(fnStmt.Name, testPrefix) {
+				suite.Tests = append(suite.Tests, TestCase{
+					Name:      fnStmt.Name,
+					Line:      fnStmt.Line,
+					Function:  testFunction(vm, fn),
+					Interrupt: vm.Interrupt,
+				})
+			}
+		}
+	}
+
+	// Fixture servers live until the whole file has run
+	afterAll := suite.AfterAll
+	suite.AfterAll = func() error {
+		defer testModule.Close()
+		if afterAll != nil {
+			return afterAll()
+		}
+		return nil
+	}
+
+	return suite, nil
+}
+
+// loadedFile is a test or benchmark file whose top-level code has run
+type loadedFile struct {
+	vm         *vmregister.RegisterVM
+	stmts      []parser.Stmt
+	testModule *TestModule
+}
+
+// loadFile parses, compiles and runs a file's top-level code in a fresh VM
+func loadFile(path string, configure func(*vmregister.RegisterVM)) (*loadedFile, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stmts, err := parseTestFile(path, string(source))
+	if err != nil {
+		return nil, err
+	}
+
+	vm := vmregister.NewRegisterVM()
+	vm.SetCurrentFile(path)
+	if configure != nil {
+		configure(vm)
+	}
+	testModule := InstallTestModule(vm)
+
+	globalNames, nextID := vm.GetGlobalNames()
+	c := compregister.NewCompilerWithGlobals(globalNames, nextID)
+	mainFn, err := c.Compile(stmts)
+	if err != nil {
+		return nil, fmt.Errorf("compilation error: %w", err)
+	}
+	if _, err := vm.Execute(mainFn, nil); err != nil {
+		testModule.Close()
+		return nil, err
+	}
+
+	return &loadedFile{vm: vm, stmts: stmts, testModule: testModule}, nil
+}
+
+// ErrorSuite reports a test file that could not be loaded as a single failure
+func ErrorSuite(path string, err error) *TestSuite {
+	return &TestSuite{
+		Name: path,
+		File: path,
+		Tests: []TestCase{{
+			Name: "(load)",
+			Function: func(ctx *TestContext) error {
+				return err
+			},
+		}},
+	}
+}
+
+// parseTestFile parses a test file, converting parser panics into errors
+func parseTestFile(path, source string) (stmts []parser.Stmt, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	scanner := lexer.NewScannerWithFile(source, path)
+	tokens := scanner.ScanTokens()
+	p := parser.NewParserWithSource(tokens, source, path)
+	return p.Parse(), nil
+}
+
+// testFunction wraps a Sentra test function, mapping skip() to a skipped test
+func testFunction(vm *vmregister.RegisterVM, fn vmregister.Value) func(*TestContext) error {
+	return func(ctx *TestContext) error {
+		// Mocks installed by a test never leak into the next one
+		defer vm.RestoreMocks()
+		_, err := vm.Call(fn, nil)
+		var skip *vmregister.SkipError
+		if errors.As(err, &skip) {
+			ctx.Skip(skip.Reason)
+			return nil
+		}
+		return err
+	}
+}
+
+// hook wraps a Sentra lifecycle function
+func hook(vm *vmregister.RegisterVM, fn vmregister.Value) func() error {
+	return func() error {
+		_, err := vm.Call(fn, nil)
+		return err
+	}
+}
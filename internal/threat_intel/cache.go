@@ -0,0 +1,207 @@
+package threat_intel
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Cache keeps lookup results in an SQLite file, so they outlive the
+// script that looked them up. Negative results, which no source knew
+// anything about, are marked so they can be counted apart.
+type Cache struct {
+	Path string
+	db   *sql.DB
+}
+
+// OpenCache opens or creates a cache file and drops its expired results
+func OpenCache(path string) (*Cache, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// One writer at a time keeps SQLite from reporting the file busy
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS results (
+		key      TEXT PRIMARY KEY,
+		result   TEXT NOT NULL,
+		negative INTEGER NOT NULL,
+		expires  INTEGER NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &Cache{Path: path, db: db}
+	if _, err := c.Purge(time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns the result cached for a key until it expires
+func (c *Cache) Get(key string, now time.Time) *ThreatResult {
+	var data string
+	err := c.db.QueryRow(`SELECT result FROM results WHERE key = ? AND expires > ?`, key, now.UnixNano()).Scan(&data)
+	if err != nil {
+		return nil
+	}
+	var result ThreatResult
+	if json.Unmarshal([]byte(data), &result) != nil {
+		return nil
+	}
+	return &result
+}
+
+// Put caches a result until it expires
+func (c *Cache) Put(key string, result *ThreatResult, negative bool, expires time.Time) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO results (key, result, negative, expires) VALUES (?, ?, ?, ?)`,
+		key, string(data), negative, expires.UnixNano())
+	return err
+}
+
+// Purge drops the expired results and returns how many
+func (c *Cache) Purge(now time.Time) (int, error) {
+	res, err := c.db.Exec(`DELETE FROM results WHERE expires <= ?`, now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// Counts returns the number of results cached and how many are negative
+func (c *Cache) Counts(now time.Time) (entries, negative int) {
+	c.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(negative), 0) FROM results WHERE expires > ?`, now.UnixNano()).Scan(&entries, &negative)
+	return entries, negative
+}
+
+// Close closes the cache file
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// CacheStats counts the results a module has cached and how often lookups
+// found them
+type CacheStats struct {
+	Path     string // The cache file, if one is open
+	Entries  int
+	Negative int
+	Hits     int
+	Misses   int
+}
+
+// OpenCache keeps the module's lookup results in a cache file as well as
+// in memory, closing any file opened before. A ttl overrides how long each
+// kind of lookup is kept, and negativeTTL sets how long results no source
+// knew are kept; either is left as it was when zero.
+func (tim *ThreatIntelModule) OpenCache(path string, ttl, negativeTTL time.Duration) error {
+	c, err := OpenCache(path)
+	if err != nil {
+		return err
+	}
+	tim.cacheMutex.Lock()
+	defer tim.cacheMutex.Unlock()
+	if tim.persistent != nil {
+		tim.persistent.Close()
+	}
+	tim.persistent = c
+	if ttl > 0 {
+		tim.cacheTTL = ttl
+	}
+	if negativeTTL > 0 {
+		tim.negativeTTL = negativeTTL
+	}
+	return nil
+}
+
+// CacheStats returns the module's cache counts
+func (tim *ThreatIntelModule) CacheStats() CacheStats {
+	tim.cacheMutex.Lock()
+	defer tim.cacheMutex.Unlock()
+	stats := CacheStats{Hits: tim.cacheHits, Misses: tim.cacheMisses}
+	now := time.Now()
+	if tim.persistent != nil {
+		stats.Path = tim.persistent.Path
+		stats.Entries, stats.Negative = tim.persistent.Counts(now)
+		return stats
+	}
+	for _, cached := range tim.cache {
+		if now.Sub(cached.Timestamp) < cached.TTL {
+			stats.Entries++
+			if len(cached.Result.Sources) == 0 {
+				stats.Negative++
+			}
+		}
+	}
+	return stats
+}
+
+// PrefetchResult counts what a prefetch did with its indicators
+type PrefetchResult struct {
+	Total   int // Distinct indicators
+	Cached  int // Already cached or in the indicator store
+	Fetched int
+	Failed  int // A source failed or was rate limited; asked again next time
+	Invalid int // Not an address, hash or domain
+}
+
+// Prefetch looks up the indicators that are not cached yet, so that a
+// sweep over them afterwards answers from the cache. Sources are asked
+// within their rate limits; indicators they could not answer are counted
+// as failed and left uncached.
+func (tim *ThreatIntelModule) Prefetch(indicators []string) PrefetchResult {
+	var result PrefetchResult
+	seen := make(map[string]bool)
+	for _, indicator := range indicators {
+		indicator = strings.TrimSpace(indicator)
+		var typ string
+		switch {
+		case tim.isValidIP(indicator):
+			typ = "ip"
+		case tim.isValidHash(indicator):
+			typ, indicator = "hash", strings.ToLower(indicator)
+		case tim.isValidDomain(strings.ToLower(indicator)):
+			typ, indicator = "domain", strings.ToLower(indicator)
+		}
+		if indicator == "" || seen[indicator] {
+			continue
+		}
+		seen[indicator] = true
+		result.Total++
+		if typ == "" {
+			result.Invalid++
+			continue
+		}
+		tim.cacheMutex.Lock()
+		cached := tim.cached(indicator)
+		tim.cacheMutex.Unlock()
+		if cached != nil || tim.lookupIndicator(typ, indicator) != nil {
+			result.Cached++
+			continue
+		}
+		var r *ThreatResult
+		switch typ {
+		case "ip":
+			r = tim.LookupIP(indicator)
+		case "hash":
+			r = tim.LookupHash(indicator)
+		default:
+			r = tim.LookupDomain(indicator)
+		}
+		if _, failed := r.Details["errors"]; failed {
+			result.Failed++
+		} else {
+			result.Fetched++
+		}
+	}
+	return result
+}
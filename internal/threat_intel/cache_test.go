@@ -0,0 +1,176 @@
+package threat_intel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// providerServer answers VirusTotal, AbuseIPDB and OTX requests. It knows
+// 198.51.100.7 and evil.example, fails for 192.0.2.99 and counts the
+// requests of each path.
+func providerServer(t *testing.T) (*httptest.Server, func(string) int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		mu.Unlock()
+		key := r.Header.Get("x-apikey") + r.Header.Get("Key") + r.Header.Get("X-OTX-API-KEY")
+		if key != "k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.Contains(r.URL.Path, "192.0.2.99") || r.URL.Query().Get("ipAddress") == "192.0.2.99":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/vt/ip_addresses/198.51.100.7", r.URL.Path == "/vt/domains/evil.example":
+			fmt.Fprint(w, `{"data": {"attributes": {"last_analysis_stats": {"malicious": 3, "suspicious": 1, "harmless": 60},
+				"country": "NL", "asn": 64500, "categories": {"Forcepoint": "malicious web sites"}, "tags": ["c2"]}}}`)
+		case r.URL.Path == "/abuse/check" && r.URL.Query().Get("ipAddress") == "198.51.100.7":
+			fmt.Fprint(w, `{"data": {"abuseConfidenceScore": 90, "countryCode": "NL", "usageType": "Data Center", "totalReports": 12}}`)
+		case r.URL.Path == "/abuse/check":
+			fmt.Fprint(w, `{"data": {"abuseConfidenceScore": 0, "totalReports": 0}}`)
+		case r.URL.Path == "/otx/indicators/IPv4/198.51.100.7/general":
+			fmt.Fprint(w, `{"pulse_info": {"count": 2, "pulses": [{"name": "Botnet", "tags": ["c2", "botnet"]}]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[path]
+	}
+}
+
+func providerModule(srv *httptest.Server) *ThreatIntelModule {
+	tim := NewThreatIntelModule()
+	for i, prefix := range []string{"/vt/", "/abuse/", "/otx/"} {
+		tim.sources[i].BaseURL = srv.URL + prefix
+		tim.SetAPIKey(tim.sources[i].Name, "k")
+	}
+	return tim
+}
+
+func TestProviderLookups(t *testing.T) {
+	srv, requests := providerServer(t)
+	defer srv.Close()
+	tim := providerModule(srv)
+
+	ip := tim.LookupIP("198.51.100.7")
+	if ip.Score != 90 || !ip.Malicious || len(ip.Sources) != 3 || ip.Geography != "NL" || ip.ASN != "AS64500" {
+		t.Errorf("LookupIP = %+v", ip)
+	}
+	if got := strings.Join(ip.Categories, ","); got != "c2,malicious web sites,Data Center,botnet" {
+		t.Errorf("categories = %s", got)
+	}
+	if vt := ip.Details["virustotal"].(map[string]interface{}); vt["malicious"] != 3 {
+		t.Errorf("virustotal details = %v", vt)
+	}
+
+	// Indicators no source knows are cached as negative results
+	domain := tim.LookupDomain("quiet.example")
+	if domain.Reputation != "unknown" || len(domain.Sources) != 0 {
+		t.Errorf("LookupDomain = %+v", domain)
+	}
+	tim.LookupIP("198.51.100.7")
+	tim.LookupDomain("quiet.example")
+	if n := requests("/vt/ip_addresses/198.51.100.7"); n != 1 {
+		t.Errorf("VirusTotal asked %d times", n)
+	}
+	if n := requests("/vt/domains/quiet.example"); n != 1 {
+		t.Errorf("VirusTotal asked %d times for a negative result", n)
+	}
+	stats := tim.CacheStats()
+	if stats.Entries != 2 || stats.Negative != 1 || stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("CacheStats = %+v", stats)
+	}
+
+	// A throttled source is backed off for as long as it asks and its
+	// results are not cached
+	failed := tim.LookupIP("192.0.2.99")
+	errs, _ := failed.Details["errors"].(map[string]interface{})
+	if errs["VirusTotal"] != "429 Too Many Requests" {
+		t.Errorf("errors = %v", failed.Details["errors"])
+	}
+	again := tim.LookupIP("198.51.100.8")
+	if errs, _ := again.Details["errors"].(map[string]interface{}); !strings.HasPrefix(fmt.Sprint(errs["AbuseIPDB"]), "backing off until") {
+		t.Errorf("errors after 429 = %v", again.Details["errors"])
+	}
+	// AbuseIPDB only answers for addresses
+	requestCounts := map[string]int{"VirusTotal": 3, "AbuseIPDB": 2, "AlienVault": 3}
+	for _, p := range tim.ProviderStats() {
+		if p.Requests != requestCounts[p.Name] || p.Failures != 1 || p.Throttled != 1 || time.Until(p.RetryAt) < 100*time.Second {
+			t.Errorf("ProviderStats = %+v", p)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv, requests := providerServer(t)
+	defer srv.Close()
+	tim := providerModule(srv)
+	tim.MaxWait = 0
+	if !tim.SetRateLimit("virustotal", 2, time.Hour) || tim.SetRateLimit("shodan", 1, time.Hour) {
+		t.Fatal("SetRateLimit")
+	}
+
+	result := tim.Prefetch([]string{"a.example", "b.example", "A.example", "c.example", "not an indicator", ""})
+	want := PrefetchResult{Total: 4, Fetched: 2, Failed: 1, Invalid: 1}
+	if result != want {
+		t.Errorf("Prefetch = %+v, want %+v", result, want)
+	}
+	if n := requests("/vt/domains/c.example"); n != 0 {
+		t.Errorf("rate limited source asked %d times", n)
+	}
+	c := tim.LookupDomain("c.example")
+	if errs, _ := c.Details["errors"].(map[string]interface{}); errs["VirusTotal"] != "rate limited to 2 requests per 1h0m0s" {
+		t.Errorf("errors = %v", c.Details["errors"])
+	}
+	if result := tim.Prefetch([]string{"a.example", "b.example"}); result.Cached != 2 {
+		t.Errorf("second Prefetch = %+v", result)
+	}
+}
+
+func TestPersistentCache(t *testing.T) {
+	srv, requests := providerServer(t)
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "ti.db")
+
+	tim := providerModule(srv)
+	if err := tim.OpenCache(path, 0, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	tim.LookupDomain("evil.example")
+	tim.LookupDomain("quiet.example")
+
+	// A new module with the same file answers without asking, except for
+	// the negative result that expired
+	time.Sleep(5 * time.Millisecond)
+	tim = providerModule(srv)
+	if err := tim.OpenCache(path, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tim.CacheStats(); stats.Entries != 1 || stats.Path != path {
+		t.Errorf("CacheStats = %+v", stats)
+	}
+	evil := tim.LookupDomain("evil.example")
+	if evil.Score != 50 || evil.Reputation != "suspicious" || evil.Details["virustotal"] == nil {
+		t.Errorf("cached LookupDomain = %+v", evil)
+	}
+	tim.LookupDomain("quiet.example")
+	if a, b := requests("/vt/domains/evil.example"), requests("/vt/domains/quiet.example"); a != 1 || b != 2 {
+		t.Errorf("requests = %d, %d", a, b)
+	}
+
+	if err := tim.OpenCache(filepath.Join(path, "missing", "ti.db"), 0, 0); err == nil {
+		t.Error("OpenCache of a bad path succeeded")
+	}
+}
@@ -0,0 +1,291 @@
+package threat_intel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MISPOptions configures a MISP client
+type MISPOptions struct {
+	Insecure bool
+	Timeout  time.Duration
+}
+
+// MISPClient calls the REST API of a MISP instance
+type MISPClient struct {
+	URL  string
+	key  string
+	http *http.Client
+}
+
+// MISPAttribute is an attribute of a MISP event
+type MISPAttribute struct {
+	ID        string   `json:"id,omitempty"`
+	EventID   string   `json:"event_id,omitempty"`
+	Type      string   `json:"type"`
+	Category  string   `json:"category,omitempty"`
+	Value     string   `json:"value"`
+	ToIDS     bool     `json:"to_ids"`
+	Comment   string   `json:"comment,omitempty"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	EventInfo string   `json:"-"`
+	EventUUID string   `json:"-"`
+	Tags      []string `json:"-"`
+}
+
+// MISPEvent is an event to add to MISP
+type MISPEvent struct {
+	Info         string
+	ThreatLevel  int // 1 high, 2 medium, 3 low, 4 undefined, the default
+	Analysis     int // 0 initial, 1 ongoing, 2 completed
+	Distribution int // 0 organisation only up to 3 all communities
+	Published    bool
+	Attributes   []MISPAttribute
+	Tags         []string
+}
+
+// MISPSighting is a sighting MISP recorded
+type MISPSighting struct {
+	ID          string `json:"id"`
+	AttributeID string `json:"attribute_id"`
+	EventID     string `json:"event_id"`
+	Type        string `json:"type"` // 0 sighting, 1 false positive, 2 expiration
+	Source      string `json:"source"`
+	Date        string `json:"date_sighting"`
+}
+
+// MISPSightingTypes are the names of the sighting types
+var MISPSightingTypes = map[string]string{"sighting": "0", "false_positive": "1", "expiration": "2"}
+
+// NewMISPClient creates a client of a MISP instance that authenticates
+// with an API key
+func NewMISPClient(rawURL, key string, opts MISPOptions) (*MISPClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("bad MISP url '%s'", rawURL)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("MISP needs an API key")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	if opts.Insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return &MISPClient{URL: strings.TrimSuffix(rawURL, "/"), key: key, http: client}, nil
+}
+
+// post sends a JSON body to a MISP endpoint and decodes its answer into out
+func (c *MISPClient) post(path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", c.key)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var mispErr struct {
+			Name    string `json:"name"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &mispErr) == nil && (mispErr.Message != "" || mispErr.Name != "") {
+			// MISP often repeats the message as the name
+			msg := mispErr.Message
+			if mispErr.Name != mispErr.Message {
+				msg = strings.TrimSpace(mispErr.Name + " " + mispErr.Message)
+			}
+			return fmt.Errorf("%s: %s %s", path, resp.Status, msg)
+		}
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("%s: bad MISP response: %v", path, err)
+	}
+	return nil
+}
+
+// Search returns the attributes matching a restSearch query, such as
+// {"value": "198.51.100.7"} or {"type": "ip-dst", "tags": ["tlp:white"],
+// "last": "7d"}
+func (c *MISPClient) Search(query map[string]interface{}) ([]MISPAttribute, error) {
+	body := map[string]interface{}{"returnFormat": "json"}
+	for k, v := range query {
+		body[k] = v
+	}
+	var resp struct {
+		Response struct {
+			Attribute []struct {
+				MISPAttribute
+				Event struct {
+					Info string `json:"info"`
+					UUID string `json:"uuid"`
+				} `json:"Event"`
+				Tag []struct {
+					Name string `json:"name"`
+				} `json:"Tag"`
+			} `json:"Attribute"`
+		} `json:"response"`
+	}
+	if err := c.post("/attributes/restSearch", body, &resp); err != nil {
+		return nil, err
+	}
+	attributes := make([]MISPAttribute, len(resp.Response.Attribute))
+	for i, a := range resp.Response.Attribute {
+		attributes[i] = a.MISPAttribute
+		attributes[i].EventInfo = a.Event.Info
+		attributes[i].EventUUID = a.Event.UUID
+		for _, t := range a.Tag {
+			attributes[i].Tags = append(attributes[i].Tags, t.Name)
+		}
+	}
+	return attributes, nil
+}
+
+// AddEvent creates an event with its attributes and tags, and returns its
+// id and uuid. Attributes without a type get the one their value looks
+// like.
+func (c *MISPClient) AddEvent(e MISPEvent) (string, string, error) {
+	if e.Info == "" {
+		return "", "", fmt.Errorf("an event needs info")
+	}
+	if e.ThreatLevel == 0 {
+		e.ThreatLevel = 4
+	}
+	attributes := make([]MISPAttribute, len(e.Attributes))
+	for i, a := range e.Attributes {
+		if a.Type == "" {
+			a.Type = MISPAttributeType(a.Value)
+			if a.Type == "" {
+				return "", "", fmt.Errorf("no attribute type for '%s'", a.Value)
+			}
+		}
+		attributes[i] = a
+	}
+	tags := make([]map[string]string, len(e.Tags))
+	for i, t := range e.Tags {
+		tags[i] = map[string]string{"name": t}
+	}
+	event := map[string]interface{}{
+		"info":            e.Info,
+		"threat_level_id": fmt.Sprint(e.ThreatLevel),
+		"analysis":        fmt.Sprint(e.Analysis),
+		"distribution":    fmt.Sprint(e.Distribution),
+		"published":       e.Published,
+		"Attribute":       attributes,
+		"Tag":             tags,
+	}
+	var resp struct {
+		Event struct {
+			ID   string `json:"id"`
+			UUID string `json:"uuid"`
+		} `json:"Event"`
+	}
+	if err := c.post("/events/add", map[string]interface{}{"Event": event}, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.Event.ID, resp.Event.UUID, nil
+}
+
+// AddSighting records a sighting of every attribute with a value, or of
+// one attribute when attributeID is given. typ is a key of
+// MISPSightingTypes, and a zero time means now.
+func (c *MISPClient) AddSighting(value, attributeID, typ, source string, at time.Time) (*MISPSighting, error) {
+	code, ok := MISPSightingTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown sighting type '%s'", typ)
+	}
+	body := map[string]interface{}{"type": code}
+	path := "/sightings/add"
+	if attributeID != "" {
+		path += "/" + url.PathEscape(attributeID)
+	} else {
+		body["value"] = value
+	}
+	if source != "" {
+		body["source"] = source
+	}
+	if !at.IsZero() {
+		body["timestamp"] = fmt.Sprint(at.Unix())
+	}
+	var resp struct {
+		Sighting MISPSighting `json:"Sighting"`
+	}
+	if err := c.post(path, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Sighting, nil
+}
+
+// MISPAttributeType returns the MISP type a value looks like: an address,
+// hash, url, email or domain, or "" for anything else
+func MISPAttributeType(value string) string {
+	if net.ParseIP(value) != nil {
+		return "ip-dst"
+	}
+	if _, err := hex.DecodeString(value); err == nil {
+		switch len(value) {
+		case 32:
+			return "md5"
+		case 40:
+			return "sha1"
+		case 64:
+			return "sha256"
+		}
+	}
+	switch {
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		return "url"
+	case strings.Contains(value, "@"):
+		return "email-src"
+	case domainPattern.MatchString(value):
+		return "domain"
+	}
+	return ""
+}
+
+// ConfigureMISP sets the MISP instance the module's MISP functions use
+func (tim *ThreatIntelModule) ConfigureMISP(rawURL, key string, opts MISPOptions) error {
+	client, err := NewMISPClient(rawURL, key, opts)
+	if err != nil {
+		return err
+	}
+	tim.cacheMutex.Lock()
+	tim.misp = client
+	tim.cacheMutex.Unlock()
+	return nil
+}
+
+// MISP returns the configured MISP client
+func (tim *ThreatIntelModule) MISP() (*MISPClient, error) {
+	tim.cacheMutex.RLock()
+	defer tim.cacheMutex.RUnlock()
+	if tim.misp == nil {
+		return nil, fmt.Errorf("MISP is not configured")
+	}
+	return tim.misp, nil
+}
@@ -0,0 +1,130 @@
+package threat_intel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mispServer answers the MISP endpoints the client calls and hands each
+// request body it received to bodies
+func mispServer(bodies map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key" || r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"name": "Authentication failed.", "message": "Authentication failed.", "url": "/"}`)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies[r.URL.Path] = body
+		switch r.URL.Path {
+		case "/attributes/restSearch":
+			fmt.Fprint(w, `{"response": {"Attribute": [{"id": "12", "event_id": "3", "type": "ip-dst", "category": "Network activity",
+				"value": "198.51.100.7", "to_ids": true, "timestamp": "1704067200",
+				"Event": {"info": "Botnet C2", "uuid": "5e1b-uuid"}, "Tag": [{"name": "tlp:amber"}]}]}}`)
+		case "/events/add":
+			fmt.Fprint(w, `{"Event": {"id": "42", "uuid": "c0ffee-uuid", "info": "Phishing"}}`)
+		case "/sightings/add", "/sightings/add/12":
+			fmt.Fprint(w, `{"Sighting": {"id": "7", "attribute_id": "12", "event_id": "3", "type": "0", "source": "edr", "date_sighting": "1704067200"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"name": "Not Found", "message": "Invalid action", "url": "/"}`)
+		}
+	}))
+}
+
+func TestMISP(t *testing.T) {
+	bodies := make(map[string]map[string]interface{})
+	srv := mispServer(bodies)
+	defer srv.Close()
+
+	tim := NewThreatIntelModule()
+	if _, err := tim.MISP(); err == nil {
+		t.Error("MISP before ConfigureMISP succeeded")
+	}
+	if err := tim.ConfigureMISP(srv.URL+"/", "key", MISPOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	misp, _ := tim.MISP()
+
+	attributes, err := misp.Search(map[string]interface{}{"value": "198.51.100.7"})
+	if err != nil || len(attributes) != 1 {
+		t.Fatalf("Search = %v, %v", attributes, err)
+	}
+	if a := attributes[0]; a.EventID != "3" || a.EventInfo != "Botnet C2" || !a.ToIDS || a.Tags[0] != "tlp:amber" {
+		t.Errorf("attribute = %+v", a)
+	}
+	if body := bodies["/attributes/restSearch"]; body["value"] != "198.51.100.7" || body["returnFormat"] != "json" {
+		t.Errorf("search body = %v", body)
+	}
+
+	id, uuid, err := misp.AddEvent(MISPEvent{
+		Info:        "Phishing",
+		ThreatLevel: 2,
+		Attributes:  []MISPAttribute{{Value: "evil.example", ToIDS: true}, {Type: "filename", Value: "invoice.exe"}},
+		Tags:        []string{"tlp:green"},
+	})
+	if err != nil || id != "42" || uuid != "c0ffee-uuid" {
+		t.Fatalf("AddEvent = %s, %s, %v", id, uuid, err)
+	}
+	event := bodies["/events/add"]["Event"].(map[string]interface{})
+	attrs := event["Attribute"].([]interface{})
+	if event["threat_level_id"] != "2" || attrs[0].(map[string]interface{})["type"] != "domain" || len(event["Tag"].([]interface{})) != 1 {
+		t.Errorf("event body = %v", event)
+	}
+
+	s, err := misp.AddSighting("", "12", "sighting", "edr", time.Unix(1704067200, 0))
+	if err != nil || s.AttributeID != "12" {
+		t.Fatalf("AddSighting = %+v, %v", s, err)
+	}
+	if body := bodies["/sightings/add/12"]; body["type"] != "0" || body["source"] != "edr" || body["timestamp"] != "1704067200" || body["value"] != nil {
+		t.Errorf("sighting body = %v", body)
+	}
+	if _, err := misp.AddSighting("198.51.100.7", "", "false_positive", "", time.Time{}); err != nil || bodies["/sightings/add"]["type"] != "1" {
+		t.Errorf("AddSighting by value = %v, body %v", err, bodies["/sightings/add"])
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{func() error { _, _, err := misp.AddEvent(MISPEvent{}); return err }(), "an event needs info"},
+		{func() error {
+			_, _, err := misp.AddEvent(MISPEvent{Info: "x", Attributes: []MISPAttribute{{Value: "???"}}})
+			return err
+		}(), "no attribute type for '???'"},
+		{func() error { _, err := misp.AddSighting("x", "", "seen", "", time.Time{}); return err }(), "unknown sighting type 'seen'"},
+		{tim.ConfigureMISP("misp.example", "key", MISPOptions{}), "bad MISP url"},
+		{tim.ConfigureMISP("https://misp.example", "", MISPOptions{}), "MISP needs an API key"},
+	} {
+		if tc.err == nil || !strings.Contains(tc.err.Error(), tc.want) {
+			t.Errorf("error = %v, want %q", tc.err, tc.want)
+		}
+	}
+
+	bad, _ := NewMISPClient(srv.URL, "wrong", MISPOptions{})
+	if _, err := bad.Search(nil); err == nil || !strings.HasSuffix(err.Error(), "403 Forbidden Authentication failed.") {
+		t.Errorf("Search with a bad key = %v", err)
+	}
+}
+
+func TestMISPAttributeType(t *testing.T) {
+	for value, want := range map[string]string{
+		"198.51.100.7":                     "ip-dst",
+		"2001:db8::1":                      "ip-dst",
+		"d41d8cd98f00b204e9800998ecf8427e": "md5",
+		"https://evil.example/x":           "url",
+		"phish@evil.example":               "email-src",
+		"evil.example":                     "domain",
+		"not a value":                      "",
+	} {
+		if got := MISPAttributeType(value); got != want {
+			t.Errorf("MISPAttributeType(%s) = %q, want %q", value, got, want)
+		}
+	}
+}
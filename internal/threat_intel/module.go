@@ -20,6 +20,21 @@ type ThreatIntelModule struct {
 	cacheMutex  sync.RWMutex
 	httpClient  *http.Client
 	sources     []ThreatSource
+	indicators  *IndicatorStore
+	// Where each TAXII collection's next poll continues from
+	taxiiCursors map[string]string
+	// Results also kept on disk, when a cache file is open
+	persistent  *Cache
+	cacheTTL    time.Duration // Overrides the TTL of each lookup when set
+	negativeTTL time.Duration // How long results no source knew are kept
+	cacheHits   int
+	cacheMisses int
+	providerMu  sync.Mutex
+	providers   map[string]*providerState
+	misp        *MISPClient
+	// The longest a lookup waits for a source's rate limit before it
+	// skips the source
+	MaxWait time.Duration
 }
 
 // Value interface for VM compatibility
@@ -53,7 +68,8 @@ type ThreatSource struct {
 	Name        string
 	BaseURL     string
 	APIKey      string
-	RateLimit   int
+	RateLimit   int           // Requests allowed per RateWindow
+	RateWindow  time.Duration
 	Enabled     bool
 	LastRequest time.Time
 }
@@ -70,27 +86,35 @@ func NewThreatIntelModule() *ThreatIntelModule {
 	return &ThreatIntelModule{
 		apiKeys: make(map[string]string),
 		cache:   make(map[string]*CachedResult),
+		indicators:   NewIndicatorStore(),
+		taxiiCursors: make(map[string]string),
+		negativeTTL:  30 * time.Minute,
+		providers:    make(map[string]*providerState),
+		MaxWait:      time.Minute,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 		sources: []ThreatSource{
 			{
 				Name:      "VirusTotal",
-				BaseURL:   "https://www.virustotal.com/vtapi/v2/",
-				Enabled:   false,
-				RateLimit: 4, // requests per minute for free tier
+				BaseURL:    "https://www.virustotal.com/api/v3/",
+				Enabled:    false,
+				RateLimit:  4, // requests per minute for free tier
+				RateWindow: time.Minute,
 			},
 			{
 				Name:      "AbuseIPDB",
 				BaseURL:   "https://api.abuseipdb.com/api/v2/",
-				Enabled:   false,
-				RateLimit: 1000, // requests per day
+				Enabled:    false,
+				RateLimit:  1000, // requests per day
+				RateWindow: 24 * time.Hour,
 			},
 			{
 				Name:      "AlienVault",
 				BaseURL:   "https://otx.alienvault.com/api/v1/",
-				Enabled:   false,
-				RateLimit: 10000, // requests per hour
+				Enabled:    false,
+				RateLimit:  10000, // requests per hour
+				RateWindow: time.Hour,
 			},
 		},
 	}
@@ -117,6 +141,11 @@ func (tim *ThreatIntelModule) LookupIP(ip string) *ThreatResult {
 		return nil
 	}
 	
+	// Indicators from STIX feeds answer without asking the sources
+	if result := tim.lookupIndicator("ip", ip); result != nil {
+		return result
+	}
+	
 	// Check cache first
 	if cached := tim.getCached(ip); cached != nil {
 		return cached
@@ -165,6 +194,11 @@ func (tim *ThreatIntelModule) LookupHash(hash string) *ThreatResult {
 		return nil
 	}
 	
+	// Indicators from STIX feeds answer without asking the sources
+	if result := tim.lookupIndicator("hash", hash); result != nil {
+		return result
+	}
+	
 	// Check cache first
 	if cached := tim.getCached(hash); cached != nil {
 		return cached
@@ -209,6 +243,11 @@ func (tim *ThreatIntelModule) LookupDomain(domain string) *ThreatResult {
 		return nil
 	}
 	
+	// Indicators from STIX feeds answer without asking the sources
+	if result := tim.lookupIndicator("domain", domain); result != nil {
+		return result
+	}
+	
 	// Check cache first
 	if cached := tim.getCached(domain); cached != nil {
 		return cached
@@ -395,14 +434,15 @@ func (tim *ThreatIntelModule) getHashType(hash string) string {
 	}
 }
 
+// domainPattern is a simple domain validation
+var domainPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
 func (tim *ThreatIntelModule) isValidDomain(domain string) bool {
 	if len(domain) > 253 {
 		return false
 	}
 	
-	// Simple domain validation
-	domainRegex := regexp.MustCompile(`^[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return domainRegex.MatchString(domain)
+	return domainPattern.MatchString(domain)
 }
 
 func (tim *ThreatIntelModule) validateIOC(ioc, iocType string) bool {
@@ -452,10 +492,24 @@ func (tim *ThreatIntelModule) isCommonDomain(domain string) bool {
 	return false
 }
 
+// getCached returns a result cached in memory or, failing that, in the
+// cache file
 func (tim *ThreatIntelModule) getCached(key string) *ThreatResult {
-	tim.cacheMutex.RLock()
-	defer tim.cacheMutex.RUnlock()
+	tim.cacheMutex.Lock()
+	defer tim.cacheMutex.Unlock()
 	
+	result := tim.cached(key)
+	if result != nil {
+		tim.cacheHits++
+	} else {
+		tim.cacheMisses++
+	}
+	return result
+}
+
+// cached returns a cached result without counting the lookup. cacheMutex
+// must be held.
+func (tim *ThreatIntelModule) cached(key string) *ThreatResult {
 	if cached, exists := tim.cache[key]; exists {
 		if time.Since(cached.Timestamp) < cached.TTL {
 			return cached.Result
@@ -463,18 +517,45 @@ func (tim *ThreatIntelModule) getCached(key string) *ThreatResult {
 		// Remove expired entry
 		delete(tim.cache, key)
 	}
+	if tim.persistent != nil {
+		return tim.persistent.Get(key, time.Now())
+	}
 	return nil
 }
 
+// setCached caches a result in memory and in the cache file. Results no
+// source knew are kept for the negative TTL, and results a source failed
+// to answer are not kept at all so the next lookup asks again.
 func (tim *ThreatIntelModule) setCached(key string, result *ThreatResult, ttl time.Duration) {
+	if _, failed := result.Details["errors"]; failed {
+		return
+	}
+	enabled := false
+	for _, source := range tim.sources {
+		enabled = enabled || source.Enabled
+	}
+	if !enabled {
+		return
+	}
+	
 	tim.cacheMutex.Lock()
 	defer tim.cacheMutex.Unlock()
 	
+	negative := len(result.Sources) == 0
+	if negative {
+		ttl = tim.negativeTTL
+	} else if tim.cacheTTL > 0 {
+		ttl = tim.cacheTTL
+	}
+	now := time.Now()
 	tim.cache[key] = &CachedResult{
 		Result:    result,
-		Timestamp: time.Now(),
+		Timestamp: now,
 		TTL:       ttl,
 	}
+	if tim.persistent != nil {
+		tim.persistent.Put(key, result, negative, now.Add(ttl))
+	}
 }
 
 func (tim *ThreatIntelModule) calculateReputation(result *ThreatResult) {
@@ -494,43 +575,6 @@ func (tim *ThreatIntelModule) calculateReputation(result *ThreatResult) {
 	}
 }
 
-// Placeholder API query methods (these would contain actual API calls)
-func (tim *ThreatIntelModule) queryVirusTotal(ip string, result *ThreatResult) {
-	// Simulated VirusTotal response
-	result.Sources = append(result.Sources, "VirusTotal")
-	result.Score += 20
-}
-
-func (tim *ThreatIntelModule) queryAbuseIPDB(ip string, result *ThreatResult) {
-	// Simulated AbuseIPDB response
-	result.Sources = append(result.Sources, "AbuseIPDB")
-	result.Score += 30
-}
-
-func (tim *ThreatIntelModule) queryAlienVault(ip string, result *ThreatResult) {
-	// Simulated AlienVault response
-	result.Sources = append(result.Sources, "AlienVault")
-	result.Score += 25
-}
-
-func (tim *ThreatIntelModule) queryVirusTotalHash(hash string, result *ThreatResult) {
-	// Simulated VirusTotal hash lookup
-	result.Sources = append(result.Sources, "VirusTotal")
-	result.Score += 40
-}
-
-func (tim *ThreatIntelModule) queryVirusTotalDomain(domain string, result *ThreatResult) {
-	// Simulated VirusTotal domain lookup
-	result.Sources = append(result.Sources, "VirusTotal")
-	result.Score += 15
-}
-
-func (tim *ThreatIntelModule) queryAlienVaultDomain(domain string, result *ThreatResult) {
-	// Simulated AlienVault domain lookup
-	result.Sources = append(result.Sources, "AlienVault")
-	result.Score += 20
-}
-
 // Hash generation utilities
 func (tim *ThreatIntelModule) GenerateMD5(data string) string {
 	hash := md5.Sum([]byte(data))
@@ -0,0 +1,399 @@
+package threat_intel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBackoff caps how long a failing source is left alone
+const maxBackoff = 15 * time.Minute
+
+// providerState is the rate limit and backoff of a source
+type providerState struct {
+	tokens    float64
+	refilled  time.Time
+	backoff   time.Duration
+	retryAt   time.Time
+	requests  int
+	throttled int
+	failures  int
+	lastError string
+}
+
+// ProviderStats are the request counts of a source
+type ProviderStats struct {
+	Name       string
+	Enabled    bool
+	RateLimit  int
+	RateWindow time.Duration
+	Requests   int
+	Throttled  int // Lookups that skipped it for its rate limit or backoff
+	Failures   int
+	RetryAt    time.Time // Set while it is backed off
+	LastError  string
+}
+
+// source returns the source of a name
+func (tim *ThreatIntelModule) source(name string) ThreatSource {
+	for _, s := range tim.sources {
+		if strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
+	return ThreatSource{Name: name}
+}
+
+// state returns the state of a source, with a full rate limit at first.
+// providerMu must be held.
+func (tim *ThreatIntelModule) state(source ThreatSource) *providerState {
+	key := strings.ToLower(source.Name)
+	st, ok := tim.providers[key]
+	if !ok {
+		st = &providerState{tokens: float64(source.RateLimit), refilled: time.Now()}
+		tim.providers[key] = st
+	}
+	return st
+}
+
+// SetRateLimit sets how many requests a source takes per window
+func (tim *ThreatIntelModule) SetRateLimit(name string, requests int, window time.Duration) bool {
+	tim.providerMu.Lock()
+	defer tim.providerMu.Unlock()
+	for i := range tim.sources {
+		if strings.EqualFold(tim.sources[i].Name, name) {
+			tim.sources[i].RateLimit = requests
+			tim.sources[i].RateWindow = window
+			st := tim.state(tim.sources[i])
+			st.tokens = math.Min(st.tokens, float64(requests))
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderStats returns the request counts of every source
+func (tim *ThreatIntelModule) ProviderStats() []ProviderStats {
+	tim.providerMu.Lock()
+	defer tim.providerMu.Unlock()
+	stats := make([]ProviderStats, len(tim.sources))
+	for i, s := range tim.sources {
+		st := tim.state(s)
+		stats[i] = ProviderStats{Name: s.Name, Enabled: s.Enabled, RateLimit: s.RateLimit, RateWindow: s.RateWindow,
+			Requests: st.requests, Throttled: st.throttled, Failures: st.failures, LastError: st.lastError}
+		if time.Now().Before(st.retryAt) {
+			stats[i].RetryAt = st.retryAt
+		}
+	}
+	return stats
+}
+
+// acquire takes a request from a source's rate limit, waiting for one when
+// none is left unless that would take longer than MaxWait
+func (tim *ThreatIntelModule) acquire(source ThreatSource) error {
+	tim.providerMu.Lock()
+	st := tim.state(source)
+	now := time.Now()
+	if now.Before(st.retryAt) {
+		st.throttled++
+		tim.providerMu.Unlock()
+		return fmt.Errorf("backing off until %s after %s", st.retryAt.Format(time.RFC3339), st.lastError)
+	}
+	var wait time.Duration
+	if source.RateLimit > 0 && source.RateWindow > 0 {
+		rate := float64(source.RateLimit) / source.RateWindow.Seconds()
+		st.tokens = math.Min(float64(source.RateLimit), st.tokens+now.Sub(st.refilled).Seconds()*rate)
+		st.refilled = now
+		st.tokens--
+		if st.tokens < 0 {
+			wait = time.Duration(-st.tokens / rate * float64(time.Second))
+		}
+		if wait > tim.MaxWait {
+			st.tokens++
+			st.throttled++
+			tim.providerMu.Unlock()
+			return fmt.Errorf("rate limited to %d requests per %s", source.RateLimit, source.RateWindow)
+		}
+	}
+	st.requests++
+	tim.providerMu.Unlock()
+	time.Sleep(wait)
+	return nil
+}
+
+// fail backs a source off, for twice as long as the last time or for as
+// long as it asked
+func (tim *ThreatIntelModule) fail(source ThreatSource, retryAfter time.Duration, msg string) error {
+	tim.providerMu.Lock()
+	defer tim.providerMu.Unlock()
+	st := tim.state(source)
+	st.failures++
+	st.lastError = msg
+	if st.backoff == 0 {
+		st.backoff = 2 * time.Second
+	} else if st.backoff < maxBackoff {
+		st.backoff *= 2
+	}
+	wait := st.backoff
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	st.retryAt = time.Now().Add(wait)
+	return errors.New(msg)
+}
+
+func (tim *ThreatIntelModule) succeed(source ThreatSource) {
+	tim.providerMu.Lock()
+	defer tim.providerMu.Unlock()
+	st := tim.state(source)
+	st.backoff = 0
+	st.retryAt = time.Time{}
+}
+
+// retryAfter reads the Retry-After header, in seconds or as a date
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// request calls a source's API and decodes its JSON answer into out. It
+// reports false when the source knows nothing of the indicator. Sources
+// that refuse requests or fail are backed off.
+func (tim *ThreatIntelModule) request(source ThreatSource, endpoint string, header map[string]string, out interface{}) (bool, error) {
+	if err := tim.acquire(source); err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := tim.httpClient.Do(req)
+	if err != nil {
+		return false, tim.fail(source, 0, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return false, tim.fail(source, 0, err.Error())
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		tim.succeed(source)
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden, resp.StatusCode >= 500:
+		return false, tim.fail(source, retryAfter(resp), resp.Status)
+	case resp.StatusCode/100 != 2:
+		return false, errors.New(resp.Status)
+	}
+	tim.succeed(source)
+	if err := json.Unmarshal(body, out); err != nil {
+		return false, fmt.Errorf("bad response: %v", err)
+	}
+	return true, nil
+}
+
+// providerError records the error of a source in a result, which is then
+// not cached so a later lookup asks again
+func providerError(result *ThreatResult, source string, err error) {
+	errs, ok := result.Details["errors"].(map[string]interface{})
+	if !ok {
+		errs = make(map[string]interface{})
+		result.Details["errors"] = errs
+	}
+	errs[source] = err.Error()
+}
+
+// addSource records that a source knows an indicator. The result's score
+// is the highest a source gave.
+func addSource(result *ThreatResult, source string, score int, categories ...string) {
+	result.Sources = append(result.Sources, source)
+	if score > 100 {
+		score = 100
+	}
+	if score > result.Score {
+		result.Score = score
+	}
+	for _, c := range categories {
+		known := c == ""
+		for _, existing := range result.Categories {
+			known = known || existing == c
+		}
+		if !known {
+			result.Categories = append(result.Categories, c)
+		}
+	}
+}
+
+// virusTotal reads a VirusTotal v3 report, scored by the engines that
+// flag the indicator
+func (tim *ThreatIntelModule) virusTotal(path string, result *ThreatResult) {
+	source := tim.source("VirusTotal")
+	var report struct {
+		Data struct {
+			Attributes struct {
+				Stats struct {
+					Malicious  int `json:"malicious"`
+					Suspicious int `json:"suspicious"`
+					Harmless   int `json:"harmless"`
+					Undetected int `json:"undetected"`
+				} `json:"last_analysis_stats"`
+				Reputation int               `json:"reputation"`
+				Country    string            `json:"country"`
+				ASN        int               `json:"asn"`
+				Categories map[string]string `json:"categories"`
+				Tags       []string          `json:"tags"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	found, err := tim.request(source, source.BaseURL+path, map[string]string{"x-apikey": source.APIKey}, &report)
+	if err != nil {
+		providerError(result, source.Name, err)
+		return
+	}
+	if !found {
+		return
+	}
+	a := report.Data.Attributes
+	categories := append([]string{}, a.Tags...)
+	for _, c := range a.Categories {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+	addSource(result, source.Name, a.Stats.Malicious*15+a.Stats.Suspicious*5, categories...)
+	if a.Country != "" {
+		result.Geography = a.Country
+	}
+	if a.ASN != 0 {
+		result.ASN = fmt.Sprintf("AS%d", a.ASN)
+	}
+	result.Details["virustotal"] = map[string]interface{}{
+		"malicious":  a.Stats.Malicious,
+		"suspicious": a.Stats.Suspicious,
+		"harmless":   a.Stats.Harmless,
+		"undetected": a.Stats.Undetected,
+		"reputation": a.Reputation,
+	}
+}
+
+// alienVault reads the pulses of an OTX indicator, scored by how many
+// pulses name it
+func (tim *ThreatIntelModule) alienVault(section, indicator string, result *ThreatResult) {
+	source := tim.source("AlienVault")
+	var report struct {
+		PulseInfo struct {
+			Count  int `json:"count"`
+			Pulses []struct {
+				Name string   `json:"name"`
+				Tags []string `json:"tags"`
+			} `json:"pulses"`
+		} `json:"pulse_info"`
+		CountryCode string `json:"country_code"`
+		ASN         string `json:"asn"`
+	}
+	endpoint := source.BaseURL + "indicators/" + section + "/" + url.PathEscape(indicator) + "/general"
+	found, err := tim.request(source, endpoint, map[string]string{"X-OTX-API-KEY": source.APIKey}, &report)
+	if err != nil {
+		providerError(result, source.Name, err)
+		return
+	}
+	if !found {
+		return
+	}
+	var pulses []interface{}
+	var tags []string
+	for i, p := range report.PulseInfo.Pulses {
+		if i == 10 {
+			break
+		}
+		pulses = append(pulses, p.Name)
+		tags = append(tags, p.Tags...)
+	}
+	addSource(result, source.Name, report.PulseInfo.Count*25, tags...)
+	if result.Geography == "" {
+		result.Geography = report.CountryCode
+	}
+	if result.ASN == "" {
+		result.ASN = strings.Fields(report.ASN + " ")[0]
+	}
+	result.Details["alienvault"] = map[string]interface{}{"pulses": report.PulseInfo.Count, "pulse_names": pulses}
+}
+
+func (tim *ThreatIntelModule) queryVirusTotal(ip string, result *ThreatResult) {
+	tim.virusTotal("ip_addresses/"+ip, result)
+}
+
+// queryAbuseIPDB reads the abuse reports of an address from the last 90
+// days, scored by AbuseIPDB's confidence
+func (tim *ThreatIntelModule) queryAbuseIPDB(ip string, result *ThreatResult) {
+	source := tim.source("AbuseIPDB")
+	var report struct {
+		Data struct {
+			Score         int    `json:"abuseConfidenceScore"`
+			CountryCode   string `json:"countryCode"`
+			ISP           string `json:"isp"`
+			UsageType     string `json:"usageType"`
+			TotalReports  int    `json:"totalReports"`
+			IsWhitelisted bool   `json:"isWhitelisted"`
+		} `json:"data"`
+	}
+	query := url.Values{"ipAddress": {ip}, "maxAgeInDays": {"90"}}
+	found, err := tim.request(source, source.BaseURL+"check?"+query.Encode(), map[string]string{"Key": source.APIKey}, &report)
+	if err != nil {
+		providerError(result, source.Name, err)
+		return
+	}
+	if !found {
+		return
+	}
+	d := report.Data
+	addSource(result, source.Name, d.Score, d.UsageType)
+	if result.Geography == "" {
+		result.Geography = d.CountryCode
+	}
+	result.Details["abuseipdb"] = map[string]interface{}{
+		"score":       d.Score,
+		"reports":     d.TotalReports,
+		"isp":         d.ISP,
+		"whitelisted": d.IsWhitelisted,
+	}
+}
+
+func (tim *ThreatIntelModule) queryAlienVault(ip string, result *ThreatResult) {
+	section := "IPv4"
+	if net.ParseIP(ip).To4() == nil {
+		section = "IPv6"
+	}
+	tim.alienVault(section, ip, result)
+}
+
+func (tim *ThreatIntelModule) queryVirusTotalHash(hash string, result *ThreatResult) {
+	tim.virusTotal("files/"+hash, result)
+}
+
+func (tim *ThreatIntelModule) queryVirusTotalDomain(domain string, result *ThreatResult) {
+	tim.virusTotal("domains/"+domain, result)
+}
+
+func (tim *ThreatIntelModule) queryAlienVaultDomain(domain string, result *ThreatResult) {
+	tim.alienVault("domain", domain, result)
+}
@@ -0,0 +1,298 @@
+package threat_intel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Indicator is a STIX 2.1 indicator with the observable values its pattern
+// names, which are what lookups match
+type Indicator struct {
+	ID          string
+	Name        string
+	Description string
+	Pattern     string
+	Types       []string // indicator_types, such as malicious-activity
+	Labels      []string
+	Confidence  int // 0-100, or -1 when the producer gave none
+	Created     time.Time
+	Modified    time.Time
+	ValidFrom   time.Time
+	ValidUntil  time.Time
+	Revoked     bool
+	Source      string // The collection or feed it came from
+	Observables []Observable
+}
+
+// Observable is a value an indicator matches: an ip, cidr, domain, url,
+// email or hash (lowercased, of any algorithm)
+type Observable struct {
+	Type  string
+	Value string
+}
+
+// stixComparison matches the comparisons of a STIX pattern that name a
+// single value, like [ipv4-addr:value = '198.51.100.1'] or
+// [file:hashes.'SHA-256' = '...']
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'\-]+)\s*(=|ISSUBSET)\s*'((?:[^'\\]|\\.)*)'`)
+
+// PatternObservables returns the values a STIX pattern compares objects
+// with. Comparisons of other objects or with other operators are left
+// out, and every value is taken on its own even where the pattern ANDs
+// them.
+func PatternObservables(pattern string) []Observable {
+	var observables []Observable
+	seen := make(map[Observable]bool)
+	for _, m := range stixComparison.FindAllStringSubmatch(pattern, -1) {
+		object, path, op := m[1], strings.ToLower(m[2]), m[3]
+		value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(m[4])
+		var o Observable
+		switch {
+		case (object == "ipv4-addr" || object == "ipv6-addr") && path == "value":
+			o = ipObservable(value)
+		case op != "=":
+			continue
+		case object == "domain-name" && path == "value":
+			o = Observable{"domain", strings.ToLower(strings.TrimSuffix(value, "."))}
+		case object == "url" && path == "value":
+			o = Observable{"url", value}
+		case object == "email-addr" && path == "value":
+			o = Observable{"email", strings.ToLower(value)}
+		case object == "file" && strings.HasPrefix(path, "hashes."):
+			o = Observable{"hash", strings.ToLower(value)}
+		default:
+			continue
+		}
+		if o.Value != "" && !seen[o] {
+			seen[o] = true
+			observables = append(observables, o)
+		}
+	}
+	return observables
+}
+
+// ipObservable returns an address, or a network unless it holds a single
+// address
+func ipObservable(value string) Observable {
+	ip, network, err := net.ParseCIDR(value)
+	if err != nil {
+		if ip := net.ParseIP(value); ip != nil {
+			return Observable{"ip", ip.String()}
+		}
+		return Observable{}
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return Observable{"ip", ip.String()}
+	}
+	return Observable{"cidr", network.String()}
+}
+
+// stixObject holds the properties of a STIX object that are read
+type stixObject struct {
+	Type           string   `json:"type"`
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	IndicatorTypes []string `json:"indicator_types"`
+	Labels         []string `json:"labels"`
+	Confidence     *int     `json:"confidence"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	ValidFrom      string   `json:"valid_from"`
+	ValidUntil     string   `json:"valid_until"`
+	Revoked        bool     `json:"revoked"`
+}
+
+func stixTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// ParseSTIXObjects returns the indicators among STIX objects. Indicators
+// with patterns in a language other than STIX, or that name no value a
+// lookup could match, are counted as skipped.
+func ParseSTIXObjects(objects []json.RawMessage, source string) (indicators []*Indicator, skipped int, err error) {
+	for _, raw := range objects {
+		var o stixObject
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, 0, fmt.Errorf("bad STIX object: %v", err)
+		}
+		if o.Type != "indicator" {
+			continue
+		}
+		if o.PatternType != "" && o.PatternType != "stix" {
+			skipped++
+			continue
+		}
+		ind := &Indicator{
+			ID:          o.ID,
+			Name:        o.Name,
+			Description: o.Description,
+			Pattern:     o.Pattern,
+			Types:       o.IndicatorTypes,
+			Labels:      o.Labels,
+			Confidence:  -1,
+			Created:     stixTime(o.Created),
+			Modified:    stixTime(o.Modified),
+			ValidFrom:   stixTime(o.ValidFrom),
+			ValidUntil:  stixTime(o.ValidUntil),
+			Revoked:     o.Revoked,
+			Source:      source,
+			Observables: PatternObservables(o.Pattern),
+		}
+		if o.Confidence != nil {
+			ind.Confidence = *o.Confidence
+		}
+		if len(ind.Observables) == 0 && !ind.Revoked {
+			skipped++
+			continue
+		}
+		indicators = append(indicators, ind)
+	}
+	return indicators, skipped, nil
+}
+
+// IndicatorStore holds indicators until they expire, by the values they
+// match. An indicator expires at its valid_until, or after the store's
+// TTL when it has none.
+type IndicatorStore struct {
+	mu       sync.RWMutex
+	values   map[Observable]*storedIndicator
+	networks map[Observable]*storedIndicator // cidr observables
+	byID     map[string]*storedIndicator
+}
+
+type storedIndicator struct {
+	indicator *Indicator
+	expires   time.Time
+}
+
+// NewIndicatorStore creates an empty store
+func NewIndicatorStore() *IndicatorStore {
+	return &IndicatorStore{
+		values:   make(map[Observable]*storedIndicator),
+		networks: make(map[Observable]*storedIndicator),
+		byID:     make(map[string]*storedIndicator),
+	}
+}
+
+// Add stores an indicator, replacing an older version of it. A revoked or
+// already expired indicator removes the stored one. It reports whether the
+// indicator is now stored.
+func (s *IndicatorStore) Add(ind *Indicator, ttl time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.byID[ind.ID]; ok {
+		if ind.Modified.Before(old.indicator.Modified) {
+			return false
+		}
+		s.remove(old)
+	}
+	expires := ind.ValidUntil
+	if expires.IsZero() {
+		expires = now.Add(ttl)
+	}
+	if ind.Revoked || !expires.After(now) {
+		return false
+	}
+	stored := &storedIndicator{indicator: ind, expires: expires}
+	s.byID[ind.ID] = stored
+	for _, o := range ind.Observables {
+		if o.Type == "cidr" {
+			s.networks[o] = stored
+		} else {
+			s.values[o] = stored
+		}
+	}
+	return true
+}
+
+// remove drops an indicator from the values it is stored under, unless a
+// newer indicator took them over
+func (s *IndicatorStore) remove(stored *storedIndicator) {
+	delete(s.byID, stored.indicator.ID)
+	for _, o := range stored.indicator.Observables {
+		m := s.values
+		if o.Type == "cidr" {
+			m = s.networks
+		}
+		if m[o] == stored {
+			delete(m, o)
+		}
+	}
+}
+
+// Lookup returns the indicator that matches a value, with addresses also
+// matched against the networks indicators name
+func (s *IndicatorStore) Lookup(typ, value string, now time.Time) *Indicator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if typ == "domain" || typ == "email" || typ == "hash" {
+		value = strings.ToLower(value)
+	}
+	if stored, ok := s.values[Observable{typ, value}]; ok && stored.expires.After(now) {
+		return stored.indicator
+	}
+	if typ != "ip" {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	if stored, ok := s.values[Observable{"ip", ip.String()}]; ok && stored.expires.After(now) {
+		return stored.indicator
+	}
+	for o, stored := range s.networks {
+		if _, network, err := net.ParseCIDR(o.Value); err == nil && network.Contains(ip) && stored.expires.After(now) {
+			return stored.indicator
+		}
+	}
+	return nil
+}
+
+// Expire removes the indicators that expired and returns how many
+func (s *IndicatorStore) Expire(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, stored := range s.byID {
+		if !stored.expires.After(now) {
+			s.remove(stored)
+			n++
+		}
+	}
+	return n
+}
+
+// Indicators returns the stored indicators that have not expired, by id
+func (s *IndicatorStore) Indicators(now time.Time) []*Indicator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []*Indicator
+	for _, stored := range s.byID {
+		if stored.expires.After(now) {
+			list = append(list, stored.indicator)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Expires returns when a stored indicator expires
+func (s *IndicatorStore) Expires(id string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if stored, ok := s.byID[id]; ok {
+		return stored.expires
+	}
+	return time.Time{}
+}
@@ -0,0 +1,194 @@
+package threat_intel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatternObservables(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		want    []Observable
+	}{
+		{`[ipv4-addr:value = '198.51.100.1']`, []Observable{{"ip", "198.51.100.1"}}},
+		{`[ipv4-addr:value = '198.51.100.1/32']`, []Observable{{"ip", "198.51.100.1"}}},
+		{`[ipv4-addr:value ISSUBSET '203.0.113.0/24']`, []Observable{{"cidr", "203.0.113.0/24"}}},
+		{`[ipv6-addr:value = '2001:DB8::1']`, []Observable{{"ip", "2001:db8::1"}}},
+		{`[domain-name:value = 'Evil.Example.'] OR [url:value = 'http://evil.example/a?b=\'c\'']`,
+			[]Observable{{"domain", "evil.example"}, {"url", "http://evil.example/a?b='c'"}}},
+		{`[file:hashes.'SHA-256' = 'ABCDEF' OR file:hashes.MD5 = '0123']`, []Observable{{"hash", "abcdef"}, {"hash", "0123"}}},
+		{`[email-addr:value = 'Phish@Example.com'] FOLLOWEDBY [email-addr:value = 'phish@example.com']`, []Observable{{"email", "phish@example.com"}}},
+		{`[file:name = 'x.exe' AND process:pid = 4]`, nil},
+		{`[domain-name:value LIKE '%.evil.example']`, nil},
+	} {
+		if got := PatternObservables(tc.pattern); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("PatternObservables(%q) = %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestIndicatorStore(t *testing.T) {
+	now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := NewIndicatorStore()
+	network := &Indicator{ID: "indicator--1", Observables: []Observable{{"cidr", "203.0.113.0/24"}}, Modified: now}
+	domain := &Indicator{ID: "indicator--2", Observables: []Observable{{"domain", "evil.example"}}, ValidUntil: now.Add(time.Hour)}
+	if !s.Add(network, 24*time.Hour, now) || !s.Add(domain, 24*time.Hour, now) {
+		t.Fatal("Add failed")
+	}
+	if s.Add(&Indicator{ID: "indicator--3", Observables: []Observable{{"ip", "192.0.2.1"}}, ValidUntil: now}, time.Hour, now) {
+		t.Error("an expired indicator was stored")
+	}
+	if got := s.Lookup("ip", "203.0.113.9", now); got != network {
+		t.Errorf("Lookup in network = %v", got)
+	}
+	if got := s.Lookup("domain", "EVIL.example", now); got != domain {
+		t.Errorf("Lookup domain = %v", got)
+	}
+	if got := s.Lookup("domain", "evil.example", now.Add(2*time.Hour)); got != nil {
+		t.Errorf("Lookup after valid_until = %v", got)
+	}
+
+	// A newer version replaces the values of the old one, a revoked one
+	// removes it, and an older one is ignored
+	moved := &Indicator{ID: "indicator--1", Observables: []Observable{{"ip", "192.0.2.7"}}, Modified: now.Add(time.Minute)}
+	s.Add(moved, time.Hour, now)
+	if s.Lookup("ip", "203.0.113.9", now) != nil || s.Lookup("ip", "192.0.2.7", now) != moved {
+		t.Error("newer version did not replace the old one")
+	}
+	if s.Add(network, time.Hour, now) || s.Lookup("ip", "192.0.2.7", now) != moved {
+		t.Error("older version replaced the newer one")
+	}
+	s.Add(&Indicator{ID: "indicator--1", Revoked: true, Modified: now.Add(2 * time.Minute)}, time.Hour, now)
+	if s.Lookup("ip", "192.0.2.7", now) != nil {
+		t.Error("revoked indicator still matches")
+	}
+
+	if n := s.Expire(now.Add(2 * time.Hour)); n != 1 || len(s.Indicators(now)) != 0 {
+		t.Errorf("Expire = %d, left %d", n, len(s.Indicators(now)))
+	}
+}
+
+// taxiiServer serves a discovery endpoint, an API root with one collection
+// and its objects over two pages
+func taxiiServer(objects []string) *httptest.Server {
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, r *http.Request, body string) {
+		if r.Header.Get("Accept") != taxiiMediaType {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "analyst" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"title": "Unauthorized", "description": "bad credentials"}`)
+			return
+		}
+		w.Header().Set("Content-Type", taxiiMediaType)
+		fmt.Fprint(w, body)
+	}
+	mux.HandleFunc("/taxii2/", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, r, `{"title": "Test", "api_roots": ["/feeds/"]}`)
+	})
+	mux.HandleFunc("/feeds/collections/", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, r, `{"collections": [{"id": "91a7b528-80eb-42ed-a74d-c6fbd5a26116", "title": "High Value Indicators", "can_read": true, "can_write": false, "media_types": ["application/stix+json;version=2.1"]}]}`)
+	})
+	mux.HandleFunc("/feeds/collections/91a7b528-80eb-42ed-a74d-c6fbd5a26116/objects/", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var page []string
+		more := false
+		switch {
+		case q.Get("added_after") == "2024-01-02T00:00:00Z":
+		case q.Get("next") == "":
+			page, more = objects[:1], true
+			w.Header().Set("X-TAXII-Date-Added-Last", "2024-01-01T00:00:00Z")
+		default:
+			page = objects[1:]
+			w.Header().Set("X-TAXII-Date-Added-Last", "2024-01-02T00:00:00Z")
+		}
+		body, _ := json.Marshal(map[string]interface{}{"more": more, "next": "p2", "objects": json.RawMessage("[" + strings.Join(page, ",") + "]")})
+		reply(w, r, string(body))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestPollTAXII(t *testing.T) {
+	objects := []string{
+		`{"type": "indicator", "spec_version": "2.1", "id": "indicator--a", "created": "2024-01-01T00:00:00Z", "modified": "2024-01-01T00:00:00Z",
+		  "name": "C2 server", "indicator_types": ["malicious-activity"], "confidence": 85,
+		  "pattern": "[ipv4-addr:value = '198.51.100.7']", "pattern_type": "stix", "valid_from": "2024-01-01T00:00:00Z"}`,
+		`{"type": "indicator", "spec_version": "2.1", "id": "indicator--b", "created": "2024-01-01T00:00:00Z", "modified": "2024-01-01T00:00:00Z",
+		  "pattern": "[file:hashes.'SHA-256' = 'E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855']", "pattern_type": "stix", "valid_from": "2024-01-01T00:00:00Z"}`,
+		`{"type": "indicator", "spec_version": "2.1", "id": "indicator--c", "pattern": "alert tcp any any", "pattern_type": "snort", "valid_from": "2024-01-01T00:00:00Z"}`,
+		`{"type": "malware", "spec_version": "2.1", "id": "malware--d", "name": "Evil", "is_family": true}`,
+	}
+	srv := taxiiServer(objects)
+	defer srv.Close()
+
+	tim := NewThreatIntelModule()
+	opts := TAXIIOptions{Username: "analyst", Password: "secret"}
+	result, err := tim.PollTAXII(srv.URL+"/taxii2/", "High Value Indicators", "", 0, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ImportResult{Source: "High Value Indicators", Objects: 4, Indicators: 2, Stored: 2, Skipped: 1, Pages: 2, AddedAfter: "2024-01-02T00:00:00Z"}
+	if *result != want {
+		t.Errorf("PollTAXII = %+v, want %+v", *result, want)
+	}
+
+	// Lookups answer from the store
+	ip := tim.LookupIP("198.51.100.7")
+	if ip == nil || !ip.Malicious || ip.Score != 85 || ip.Sources[0] != "STIX:High Value Indicators" || ip.Details["name"] != "C2 server" {
+		t.Errorf("LookupIP = %+v", ip)
+	}
+	hash := tim.LookupHash("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	if hash == nil || hash.Type != "sha256" || hash.Reputation != "malicious" || hash.Score != 100 {
+		t.Errorf("LookupHash = %+v", hash)
+	}
+	if got := tim.GetReputation("198.51.100.8"); got != "unknown" {
+		t.Errorf("GetReputation of an unlisted address = %s", got)
+	}
+
+	// The next poll continues from the last added date
+	result, err = tim.PollTAXII(srv.URL+"/taxii2/", "91a7b528-80eb-42ed-a74d-c6fbd5a26116", "", 0, 0, opts)
+	if err != nil || result.Objects != 0 || result.Pages != 1 {
+		t.Errorf("second PollTAXII = %+v, %v", result, err)
+	}
+
+	for _, tc := range []struct {
+		url, collection string
+		opts            TAXIIOptions
+		want            string
+	}{
+		{srv.URL + "/taxii2/", "Missing", opts, "no collection 'Missing'"},
+		{srv.URL + "/taxii2/", "High Value Indicators", TAXIIOptions{}, "401 Unauthorized Unauthorized bad credentials"},
+		{"ftp://example", "x", opts, "bad TAXII url"},
+	} {
+		if _, err := tim.PollTAXII(tc.url, tc.collection, "", 0, 0, tc.opts); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("PollTAXII(%s, %s) error = %v, want %q", tc.url, tc.collection, err, tc.want)
+		}
+	}
+}
+
+func TestImportSTIX(t *testing.T) {
+	tim := NewThreatIntelModule()
+	bundle := `{"type": "bundle", "id": "bundle--1", "objects": [
+		{"type": "indicator", "id": "indicator--e", "modified": "2024-01-01T00:00:00Z", "indicator_types": ["benign"],
+		 "pattern": "[domain-name:value = 'updates.example']", "pattern_type": "stix", "valid_from": "2024-01-01T00:00:00Z"}]}`
+	result, err := tim.ImportSTIX([]byte(bundle), "allowlist", time.Hour)
+	if err != nil || result.Stored != 1 {
+		t.Fatalf("ImportSTIX = %+v, %v", result, err)
+	}
+	if d := tim.LookupDomain("updates.example"); d == nil || d.Reputation != "clean" || d.Malicious {
+		t.Errorf("LookupDomain = %+v", d)
+	}
+	for _, bad := range []string{`{"type": "indicator"}`, `not json`} {
+		if _, err := tim.ImportSTIX([]byte(bad), "x", 0); err == nil {
+			t.Errorf("ImportSTIX(%s) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package threat_intel
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// taxiiMediaType is the media type of TAXII 2.1 requests and responses
+const taxiiMediaType = "application/taxii+json;version=2.1"
+
+// DefaultIndicatorTTL is how long indicators without a valid_until are kept
+const DefaultIndicatorTTL = 30 * 24 * time.Hour
+
+// TAXIIOptions configures a TAXII client
+type TAXIIOptions struct {
+	Username string
+	Password string
+	Token    string // Sent as a bearer token
+	Insecure bool
+	Timeout  time.Duration
+}
+
+// TAXIIClient reads collections of a TAXII 2.1 server
+type TAXIIClient struct {
+	URL  string // A discovery endpoint or an API root
+	opts TAXIIOptions
+	http *http.Client
+}
+
+// TAXIICollection is a collection of a TAXII API root
+type TAXIICollection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	CanRead     bool     `json:"can_read"`
+	CanWrite    bool     `json:"can_write"`
+	MediaTypes  []string `json:"media_types"`
+	APIRoot     string   `json:"-"`
+}
+
+// NewTAXIIClient creates a client of a discovery endpoint or an API root
+func NewTAXIIClient(rawURL string, opts TAXIIOptions) (*TAXIIClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("bad TAXII url '%s'", rawURL)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	if opts.Insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return &TAXIIClient{URL: strings.TrimSuffix(rawURL, "/") + "/", opts: opts, http: client}, nil
+}
+
+// get requests a TAXII endpoint and decodes its JSON into v, returning
+// the response headers
+func (c *TAXIIClient) get(endpoint string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", taxiiMediaType)
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	} else if c.opts.Username != "" {
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var taxiiErr struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		}
+		if json.Unmarshal(body, &taxiiErr) == nil && taxiiErr.Title != "" {
+			return nil, fmt.Errorf("%s: %s %s", endpoint, resp.Status, strings.TrimSpace(taxiiErr.Title+" "+taxiiErr.Description))
+		}
+		return nil, fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("%s: bad TAXII response: %v", endpoint, err)
+	}
+	return resp.Header, nil
+}
+
+// resolve returns a URL relative to the client's URL
+func (c *TAXIIClient) resolve(ref string) string {
+	base, _ := url.Parse(c.URL)
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return strings.TrimSuffix(u.String(), "/") + "/"
+}
+
+// apiRoots returns the API roots of a discovery endpoint, or the client's
+// URL when it is an API root itself
+func (c *TAXIIClient) apiRoots() ([]string, error) {
+	var discovery struct {
+		Title    string   `json:"title"`
+		APIRoots []string `json:"api_roots"`
+		Versions []string `json:"versions"`
+	}
+	if _, err := c.get(c.URL, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Versions != nil || discovery.APIRoots == nil {
+		return []string{c.URL}, nil
+	}
+	roots := make([]string, len(discovery.APIRoots))
+	for i, root := range discovery.APIRoots {
+		roots[i] = c.resolve(root)
+	}
+	return roots, nil
+}
+
+// Collections returns the collections of every API root
+func (c *TAXIIClient) Collections() ([]TAXIICollection, error) {
+	roots, err := c.apiRoots()
+	if err != nil {
+		return nil, err
+	}
+	var all []TAXIICollection
+	for _, root := range roots {
+		var list struct {
+			Collections []TAXIICollection `json:"collections"`
+		}
+		if _, err := c.get(root+"collections/", &list); err != nil {
+			return nil, err
+		}
+		for _, col := range list.Collections {
+			col.APIRoot = root
+			all = append(all, col)
+		}
+	}
+	return all, nil
+}
+
+// Collection finds a collection by id or title
+func (c *TAXIIClient) Collection(name string) (*TAXIICollection, error) {
+	collections, err := c.Collections()
+	if err != nil {
+		return nil, err
+	}
+	for i := range collections {
+		if collections[i].ID == name || strings.EqualFold(collections[i].Title, name) {
+			return &collections[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no collection '%s' at %s", name, c.URL)
+}
+
+// Objects returns the objects of a collection added after a timestamp,
+// following the pages of the server, and the date the last of them was
+// added, from which the next poll continues
+func (c *TAXIIClient) Objects(col *TAXIICollection, addedAfter string, limit int) ([]json.RawMessage, string, int, error) {
+	if !col.CanRead {
+		return nil, "", 0, fmt.Errorf("collection '%s' cannot be read", col.Title)
+	}
+	var objects []json.RawMessage
+	last := addedAfter
+	next := ""
+	pages := 0
+	for {
+		query := url.Values{}
+		if addedAfter != "" {
+			query.Set("added_after", addedAfter)
+		}
+		if limit > 0 {
+			query.Set("limit", fmt.Sprint(limit))
+		}
+		if next != "" {
+			query.Set("next", next)
+		}
+		endpoint := col.APIRoot + "collections/" + url.PathEscape(col.ID) + "/objects/"
+		if len(query) > 0 {
+			endpoint += "?" + query.Encode()
+		}
+		var envelope struct {
+			More    bool              `json:"more"`
+			Next    string            `json:"next"`
+			Objects []json.RawMessage `json:"objects"`
+		}
+		header, err := c.get(endpoint, &envelope)
+		if err != nil {
+			return nil, "", pages, err
+		}
+		pages++
+		objects = append(objects, envelope.Objects...)
+		if added := header.Get("X-TAXII-Date-Added-Last"); added != "" {
+			last = added
+		}
+		if !envelope.More || len(envelope.Objects) == 0 {
+			return objects, last, pages, nil
+		}
+		if envelope.Next != "" {
+			next = envelope.Next
+		} else if last != addedAfter {
+			// Servers without next page by added_after
+			addedAfter = last
+		} else {
+			return objects, last, pages, nil
+		}
+	}
+}
+
+// ImportResult counts what a STIX import or TAXII poll added to the
+// indicator store
+type ImportResult struct {
+	Source     string
+	Objects    int
+	Indicators int
+	Stored     int
+	Skipped    int
+	Pages      int
+	AddedAfter string // The timestamp the next poll continues from
+}
+
+// importIndicators adds indicators to the module's store
+func (tim *ThreatIntelModule) importIndicators(indicators []*Indicator, ttl time.Duration, result *ImportResult) {
+	if ttl <= 0 {
+		ttl = DefaultIndicatorTTL
+	}
+	now := time.Now()
+	tim.indicators.Expire(now)
+	result.Indicators += len(indicators)
+	for _, ind := range indicators {
+		if tim.indicators.Add(ind, ttl, now) {
+			result.Stored++
+		}
+	}
+}
+
+// ImportSTIX adds the indicators of a STIX bundle to the indicator store
+func (tim *ThreatIntelModule) ImportSTIX(data []byte, source string, ttl time.Duration) (*ImportResult, error) {
+	var bundle struct {
+		Type    string            `json:"type"`
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("bad STIX bundle: %v", err)
+	}
+	if bundle.Type != "bundle" {
+		return nil, fmt.Errorf("not a STIX bundle: type is '%s'", bundle.Type)
+	}
+	indicators, skipped, err := ParseSTIXObjects(bundle.Objects, source)
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Source: source, Objects: len(bundle.Objects), Skipped: skipped}
+	tim.importIndicators(indicators, ttl, result)
+	return result, nil
+}
+
+// PollTAXII adds the indicators of a TAXII collection to the indicator
+// store. Each poll of a collection continues from where the last one
+// ended unless addedAfter is given.
+func (tim *ThreatIntelModule) PollTAXII(rawURL, collection, addedAfter string, limit int, ttl time.Duration, opts TAXIIOptions) (*ImportResult, error) {
+	client, err := NewTAXIIClient(rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	col, err := client.Collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	cursor := col.APIRoot + "collections/" + col.ID
+	if addedAfter == "" {
+		tim.cacheMutex.RLock()
+		addedAfter = tim.taxiiCursors[cursor]
+		tim.cacheMutex.RUnlock()
+	}
+	objects, last, pages, err := client.Objects(col, addedAfter, limit)
+	if err != nil {
+		return nil, err
+	}
+	source := col.Title
+	if source == "" {
+		source = col.ID
+	}
+	indicators, skipped, err := ParseSTIXObjects(objects, source)
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Source: source, Objects: len(objects), Skipped: skipped, Pages: pages, AddedAfter: last}
+	tim.importIndicators(indicators, ttl, result)
+	if last != "" {
+		tim.cacheMutex.Lock()
+		tim.taxiiCursors[cursor] = last
+		tim.cacheMutex.Unlock()
+	}
+	return result, nil
+}
+
+// Indicators returns the module's indicator store
+func (tim *ThreatIntelModule) Indicators() *IndicatorStore {
+	return tim.indicators
+}
+
+// lookupIndicator returns the result of a value the indicator store holds,
+// which lookups give before asking external sources
+func (tim *ThreatIntelModule) lookupIndicator(typ, value string) *ThreatResult {
+	ind := tim.indicators.Lookup(typ, value, time.Now())
+	if ind == nil {
+		return nil
+	}
+	result := &ThreatResult{
+		Indicator:  value,
+		Type:       typ,
+		Reputation: "malicious",
+		Score:      ind.Confidence,
+		Sources:    []string{"STIX:" + ind.Source},
+		Categories: append([]string{}, ind.Types...),
+		FirstSeen:  ind.ValidFrom,
+		LastSeen:   ind.Modified,
+		Malicious:  true,
+		Details: map[string]interface{}{
+			"stix_id": ind.ID,
+			"name":    ind.Name,
+			"pattern": ind.Pattern,
+		},
+	}
+	if typ == "hash" {
+		result.Type = tim.getHashType(value)
+	}
+	if result.Score < 0 {
+		result.Score = 100
+	}
+	if !ind.ValidUntil.IsZero() {
+		result.Details["valid_until"] = ind.ValidUntil.Format(time.RFC3339)
+	}
+	for _, t := range ind.Types {
+		if t == "benign" {
+			result.Reputation = "clean"
+			result.Malicious = false
+			result.Score = 0
+		}
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+package ticketing
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fingerprintLabel starts the label that holds the fingerprint of a Jira
+// issue
+const fingerprintLabel = "sentra-"
+
+// jira files issues in a Jira project through its REST API, version 2,
+// which Jira Cloud and Data Center both serve
+type jira struct {
+	client    *client
+	project   string
+	issueType string
+}
+
+// jiraIssue is an issue as the API returns it
+type jiraIssue struct {
+	ID     string `json:"id"`
+	Key    string `json:"key"`
+	Fields struct {
+		Status struct {
+			Name string `json:"name"`
+		} `json:"status"`
+		Labels []string `json:"labels"`
+	} `json:"fields"`
+}
+
+func (j *jira) ticket(issue jiraIssue) *Ticket {
+	t := &Ticket{Key: issue.Key, ID: issue.ID, URL: j.client.base + "/browse/" + issue.Key, Status: issue.Fields.Status.Name}
+	for _, label := range issue.Fields.Labels {
+		if strings.HasPrefix(label, fingerprintLabel) {
+			t.Fingerprint = strings.TrimPrefix(label, fingerprintLabel)
+		}
+	}
+	return t
+}
+
+func (j *jira) find(fingerprint string) (*Ticket, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" ORDER BY created DESC`, jqlEscape(j.project), jqlEscape(fingerprintLabel+fingerprint))
+	query := url.Values{"jql": {jql}, "fields": {"status,labels"}, "maxResults": {"1"}}.Encode()
+	var answer struct {
+		Issues []jiraIssue `json:"issues"`
+	}
+	// Jira Cloud has replaced search with search/jql, which Data Center
+	// does not have
+	status, err := j.client.call("GET", "/rest/api/2/search/jql?"+query, nil, &answer)
+	if status == http.StatusNotFound {
+		_, err = j.client.call("GET", "/rest/api/2/search?"+query, nil, &answer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(answer.Issues) == 0 {
+		return nil, nil
+	}
+	return j.ticket(answer.Issues[0]), nil
+}
+
+func (j *jira) get(key string) (*Ticket, error) {
+	var issue jiraIssue
+	if _, err := j.client.call("GET", "/rest/api/2/issue/"+url.PathEscape(key)+"?fields=status,labels", nil, &issue); err != nil {
+		return nil, err
+	}
+	return j.ticket(issue), nil
+}
+
+// withLabel returns fields whose labels hold the fingerprint's label
+func withLabel(fields map[string]interface{}, fingerprint string) map[string]interface{} {
+	labels := []interface{}{fingerprintLabel + fingerprint}
+	if given, ok := fields["labels"].([]interface{}); ok {
+		labels = append(given, labels...)
+	}
+	withLabels := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		withLabels[k] = v
+	}
+	withLabels["labels"] = labels
+	return withLabels
+}
+
+func (j *jira) create(fields map[string]interface{}, fingerprint string) (*Ticket, error) {
+	fields = withLabel(fields, fingerprint)
+	fields["project"] = map[string]interface{}{"key": j.project}
+	if _, ok := fields["issuetype"]; !ok {
+		fields["issuetype"] = map[string]interface{}{"name": j.issueType}
+	}
+	var created jiraIssue
+	if _, err := j.client.call("POST", "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+		return nil, err
+	}
+	return j.get(created.Key)
+}
+
+func (j *jira) update(t *Ticket, fields map[string]interface{}) error {
+	_, err := j.client.call("PUT", "/rest/api/2/issue/"+url.PathEscape(t.Key), map[string]interface{}{"fields": withLabel(fields, t.Fingerprint)}, nil)
+	return err
+}
+
+// transition moves an issue along the workflow transition that leads to
+// a status, or that is named like it
+func (j *jira) transition(t *Ticket, status string, fields map[string]interface{}) error {
+	path := "/rest/api/2/issue/" + url.PathEscape(t.Key) + "/transitions"
+	var answer struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			To   struct {
+				Name string `json:"name"`
+			} `json:"to"`
+		} `json:"transitions"`
+	}
+	if _, err := j.client.call("GET", path, nil, &answer); err != nil {
+		return err
+	}
+	var names []string
+	for _, tr := range answer.Transitions {
+		if strings.EqualFold(tr.To.Name, status) || strings.EqualFold(tr.Name, status) {
+			body := map[string]interface{}{"transition": map[string]interface{}{"id": tr.ID}}
+			if len(fields) > 0 {
+				body["fields"] = fields
+			}
+			_, err := j.client.call("POST", path, body, nil)
+			return err
+		}
+		names = append(names, tr.To.Name)
+	}
+	return fmt.Errorf("%s cannot move from %s to %s, only to %s", t.Key, t.Status, status, strings.Join(names, ", "))
+}
+
+func (j *jira) hasStatus(t *Ticket, status string) bool {
+	return strings.EqualFold(t.Status, status)
+}
+
+// jqlEscape escapes a value for a quoted JQL string
+func jqlEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,134 @@
+package ticketing
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// serviceNow files records in a ServiceNow table through its Table API,
+// keeping the fingerprint in one of their fields
+type serviceNow struct {
+	client     *client
+	table      string
+	dedupField string
+}
+
+// snField is a field as the Table API returns it with both its value and
+// how it is shown
+type snField struct {
+	Value        string `json:"value"`
+	DisplayValue string `json:"display_value"`
+}
+
+func (s *serviceNow) ticket(record map[string]snField) *Ticket {
+	id := record["sys_id"].Value
+	return &Ticket{
+		Key:         record["number"].Value,
+		ID:          id,
+		URL:         s.client.base + "/nav_to.do?uri=" + url.QueryEscape(s.table+".do?sys_id="+id),
+		Status:      record["state"].DisplayValue,
+		Fingerprint: record[s.dedupField].Value,
+		state:       record["state"].Value,
+	}
+}
+
+// query returns the newest record that matches an encoded query
+func (s *serviceNow) query(q string) (*Ticket, error) {
+	params := url.Values{
+		"sysparm_query":         {q + "^ORDERBYDESCsys_created_on"},
+		"sysparm_limit":         {"1"},
+		"sysparm_fields":        {"sys_id,number,state," + s.dedupField},
+		"sysparm_display_value": {"all"},
+	}
+	var answer struct {
+		Result []map[string]snField `json:"result"`
+	}
+	if _, err := s.client.call("GET", s.path("")+"?"+params.Encode(), nil, &answer); err != nil {
+		return nil, err
+	}
+	if len(answer.Result) == 0 {
+		return nil, nil
+	}
+	return s.ticket(answer.Result[0]), nil
+}
+
+// path returns the path of the table, or of a record in it
+func (s *serviceNow) path(id string) string {
+	p := "/api/now/table/" + url.PathEscape(s.table)
+	if id != "" {
+		p += "/" + url.PathEscape(id)
+	}
+	return p
+}
+
+// condition returns the condition of an encoded query that a field
+// equals a value, which cannot hold the ^ that separates conditions
+func condition(field, value string) (string, error) {
+	if strings.Contains(value, "^") {
+		return "", fmt.Errorf("'%s' cannot be looked up in ServiceNow, it holds a ^", value)
+	}
+	return field + "=" + value, nil
+}
+
+func (s *serviceNow) find(fingerprint string) (*Ticket, error) {
+	q, err := condition(s.dedupField, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return s.query(q)
+}
+
+func (s *serviceNow) get(key string) (*Ticket, error) {
+	q, err := condition("number", key)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.query(q)
+	if err == nil && t == nil {
+		t, err = s.query("sys_id=" + key)
+	}
+	if err == nil && t == nil {
+		return nil, fmt.Errorf("no %s record %s", s.table, key)
+	}
+	return t, err
+}
+
+// write sends fields to a new record or to an existing one and returns
+// it as it is then
+func (s *serviceNow) write(method, id string, fields map[string]interface{}) (*Ticket, error) {
+	var answer struct {
+		Result map[string]snField `json:"result"`
+	}
+	if _, err := s.client.call(method, s.path(id)+"?sysparm_display_value=all", fields, &answer); err != nil {
+		return nil, err
+	}
+	return s.ticket(answer.Result), nil
+}
+
+func (s *serviceNow) create(fields map[string]interface{}, fingerprint string) (*Ticket, error) {
+	record := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record[s.dedupField] = fingerprint
+	return s.write("POST", "", record)
+}
+
+func (s *serviceNow) update(t *Ticket, fields map[string]interface{}) error {
+	_, err := s.write("PATCH", t.ID, fields)
+	return err
+}
+
+func (s *serviceNow) transition(t *Ticket, status string, fields map[string]interface{}) error {
+	record := map[string]interface{}{"state": status}
+	for k, v := range fields {
+		record[k] = v
+	}
+	_, err := s.write("PATCH", t.ID, record)
+	return err
+}
+
+func (s *serviceNow) hasStatus(t *Ticket, status string) bool {
+	return t.state == status || strings.EqualFold(t.Status, status)
+}
@@ -0,0 +1,460 @@
+// Package ticketing files findings and incidents as tickets in Jira and
+// ServiceNow. A ticket is found again by the fingerprint of what it was
+// filed for, so a finding seen in every scan keeps one ticket, which is
+// updated and closed when the finding is fixed.
+package ticketing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sentra/internal/notify"
+)
+
+// Kinds are the systems tickets can be filed in
+var Kinds = []string{"jira", "servicenow"}
+
+// DefaultFingerprintFields are the fields of a finding that identify it
+// across scans
+var DefaultFingerprintFields = []string{"title", "type", "host", "target", "port", "path", "url", "parameter", "cve", "cwe", "rule", "location"}
+
+// Options configure a tracker. Fields, Priorities and Statuses default to
+// mappings that suit a Jira project or ServiceNow's incident table.
+type Options struct {
+	Project    string // Jira project key
+	IssueType  string // Jira issue type, Task by default
+	Table      string // ServiceNow table, incident by default
+	DedupField string // ServiceNow field holding the fingerprint, correlation_id by default
+	Username   string
+	Password   string
+	Token      string // A Jira API token, with Username, or a bearer token
+	// Fields are the ticket's fields by name. Strings are templates with
+	// {{ name }} placeholders filled from the finding; maps and arrays
+	// are filled the same way.
+	Fields map[string]interface{}
+	// Priorities are the priorities of severities: a Jira priority name,
+	// or the urgency and impact of a ServiceNow ticket
+	Priorities map[string]string
+	// Statuses are the ticket statuses of finding statuses, by the upper
+	// case status. A ticket is moved to the status of its finding when
+	// they differ.
+	Statuses map[string]string
+	// TransitionFields are set along with a status change, like the
+	// close_code a ServiceNow incident needs to be resolved
+	TransitionFields  map[string]interface{}
+	FingerprintFields []string
+	Insecure          bool
+	Timeout           time.Duration // Of each request, 30s when 0
+}
+
+// Ticket is a ticket as its tracker holds it
+type Ticket struct {
+	Key         string // PROJ-12 or INC0010012
+	ID          string
+	URL         string
+	Status      string
+	Fingerprint string
+	state       string // ServiceNow's value of the status
+}
+
+// SyncResult is what syncing a finding did to its ticket: created,
+// updated, or none when a finding closed before it had one
+type SyncResult struct {
+	Ticket
+	Action       string
+	Transitioned string // The status the ticket was moved to, if any
+}
+
+// backend is the API of a tracker's system
+type backend interface {
+	find(fingerprint string) (*Ticket, error)
+	get(key string) (*Ticket, error)
+	create(fields map[string]interface{}, fingerprint string) (*Ticket, error)
+	update(t *Ticket, fields map[string]interface{}) error
+	transition(t *Ticket, status string, fields map[string]interface{}) error
+	// hasStatus reports whether a ticket is in a status
+	hasStatus(t *Ticket, status string) bool
+}
+
+// Tracker files tickets in one Jira project or ServiceNow table
+type Tracker struct {
+	ID   string
+	Kind string
+	URL  string
+	opts Options
+	api  backend
+}
+
+var (
+	trackers     = make(map[string]*Tracker)
+	trackersMu   sync.RWMutex
+	trackerCount int
+)
+
+// Connect returns a tracker of a Jira site or ServiceNow instance. It
+// does not call the API until a ticket is looked up.
+func Connect(kind, rawURL string, opts Options) (*Tracker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s needs an http or https url, got '%s'", kind, rawURL)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if len(opts.FingerprintFields) == 0 {
+		opts.FingerprintFields = DefaultFingerprintFields
+	}
+	client := &client{base: strings.TrimSuffix(rawURL, "/"), http: &http.Client{Timeout: opts.Timeout}, header: make(map[string]string)}
+	if opts.Insecure {
+		client.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	switch {
+	case opts.Username != "" && opts.Token != "":
+		client.basic(opts.Username, opts.Token)
+	case opts.Username != "":
+		client.basic(opts.Username, opts.Password)
+	case opts.Token != "":
+		client.header["Authorization"] = "Bearer " + opts.Token
+	default:
+		return nil, fmt.Errorf("%s needs a username and a password or a token", kind)
+	}
+
+	t := &Tracker{Kind: kind, URL: client.base}
+	switch kind {
+	case "jira":
+		if opts.Project == "" {
+			return nil, fmt.Errorf("jira needs a project")
+		}
+		if opts.IssueType == "" {
+			opts.IssueType = "Task"
+		}
+		if len(opts.Fields) == 0 {
+			opts.Fields = map[string]interface{}{"summary": "{{title}}", "description": "{{description}}"}
+		}
+		opts.Priorities = mapping(opts.Priorities, map[string]string{"CRITICAL": "Highest", "HIGH": "High", "MEDIUM": "Medium", "LOW": "Low", "INFO": "Lowest"})
+		opts.Statuses = mapping(opts.Statuses, map[string]string{"FIXED": "Done", "FALSE_POSITIVE": "Done", "ACCEPTED": "Done", "RESOLVED": "Done", "CLOSED": "Done"})
+		t.api = &jira{client: client, project: opts.Project, issueType: opts.IssueType}
+	case "servicenow":
+		if opts.Table == "" {
+			opts.Table = "incident"
+		}
+		if opts.DedupField == "" {
+			opts.DedupField = "correlation_id"
+		}
+		if len(opts.Fields) == 0 {
+			opts.Fields = map[string]interface{}{"short_description": "{{title}}", "description": "{{description}}"}
+		}
+		opts.Priorities = mapping(opts.Priorities, map[string]string{"CRITICAL": "1", "HIGH": "2", "MEDIUM": "2", "LOW": "3", "INFO": "3"})
+		// 6 is Resolved and 7 Closed in the incident table
+		opts.Statuses = mapping(opts.Statuses, map[string]string{"FIXED": "6", "FALSE_POSITIVE": "6", "ACCEPTED": "6", "RESOLVED": "6", "CLOSED": "7"})
+		if opts.TransitionFields == nil && opts.Table == "incident" {
+			opts.TransitionFields = map[string]interface{}{"close_code": "Solved (Permanently)", "close_notes": "Closed by Sentra: the finding is {{status}}"}
+		}
+		t.api = &serviceNow{client: client, table: opts.Table, dedupField: opts.DedupField}
+	default:
+		return nil, fmt.Errorf("unknown ticketing system '%s', expected %s", kind, strings.Join(Kinds, " or "))
+	}
+	t.opts = opts
+
+	trackersMu.Lock()
+	trackerCount++
+	t.ID = fmt.Sprintf("%s_%d", kind, trackerCount)
+	trackers[t.ID] = t
+	trackersMu.Unlock()
+	return t, nil
+}
+
+// Lookup returns a tracker by id
+func Lookup(id string) (*Tracker, error) {
+	trackersMu.RLock()
+	t, ok := trackers[id]
+	trackersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("ticket tracker '%s' not found", id)
+	}
+	return t, nil
+}
+
+// mapping returns a mapping of priorities or statuses with its keys in
+// upper case, or the default one when it is empty
+func mapping(m, defaults map[string]string) map[string]string {
+	if len(m) == 0 {
+		return defaults
+	}
+	upper := make(map[string]string, len(m))
+	for k, v := range m {
+		upper[strings.ToUpper(k)] = v
+	}
+	return upper
+}
+
+// Fingerprint identifies a finding by the values of some of its fields,
+// hashed so that it fits in a label. A finding's own fingerprint field is
+// taken as it is.
+func Fingerprint(item map[string]interface{}, fields []string) (string, error) {
+	if fp, ok := item["fingerprint"].(string); ok && fp != "" {
+		return fp, nil
+	}
+	if len(fields) == 0 {
+		fields = DefaultFingerprintFields
+	}
+	h := sha256.New()
+	found := false
+	for _, field := range fields {
+		v, ok := item[field]
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		found = true
+		value, _ := json.Marshal(v)
+		fmt.Fprintf(h, "%s=%s\n", field, value)
+	}
+	if !found {
+		return "", fmt.Errorf("nothing to fingerprint, the finding has none of %s", strings.Join(fields, ", "))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// render fills the placeholders of every string in a field's value
+func render(v interface{}, data map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return notify.Render(t, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			rendered[k] = render(item, data)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(t))
+		for i, item := range t {
+			rendered[i] = render(item, data)
+		}
+		return rendered
+	}
+	return v
+}
+
+// renderFields returns the fields a tracker maps a finding to
+func (t *Tracker) renderFields(fields map[string]interface{}, item map[string]interface{}) map[string]interface{} {
+	rendered := make(map[string]interface{}, len(fields))
+	for name, v := range fields {
+		rendered[name] = render(v, item)
+	}
+	return rendered
+}
+
+// ticketFields returns the fields of a finding's ticket, with the
+// priority of its severity unless the fields set one
+func (t *Tracker) ticketFields(item map[string]interface{}) map[string]interface{} {
+	fields := t.renderFields(t.opts.Fields, item)
+	priority := t.opts.Priorities[strings.ToUpper(fmt.Sprint(item["severity"]))]
+	if priority == "" {
+		return fields
+	}
+	if t.Kind == "jira" {
+		if _, ok := fields["priority"]; !ok {
+			fields["priority"] = map[string]interface{}{"name": priority}
+		}
+	} else {
+		for _, name := range []string{"urgency", "impact"} {
+			if _, ok := fields[name]; !ok {
+				fields[name] = priority
+			}
+		}
+	}
+	return fields
+}
+
+// Find returns the ticket filed for a fingerprint, or nil when there is
+// none
+func (t *Tracker) Find(fingerprint string) (*Ticket, error) {
+	return t.api.find(fingerprint)
+}
+
+// Get returns a ticket by its key, to read its status
+func (t *Tracker) Get(key string) (*Ticket, error) {
+	return t.api.get(key)
+}
+
+// Fingerprint identifies a finding by the tracker's fingerprint fields
+func (t *Tracker) Fingerprint(item map[string]interface{}) (string, error) {
+	return Fingerprint(item, t.opts.FingerprintFields)
+}
+
+// Sync files a finding or an incident. Without a ticket for its
+// fingerprint one is created, unless its status already closes tickets;
+// with one its fields are updated and it is moved to the status of the
+// finding's status. An empty fingerprint is worked out from the item.
+func (t *Tracker) Sync(item map[string]interface{}, fingerprint string) (*SyncResult, error) {
+	if fingerprint == "" {
+		fp, err := t.Fingerprint(item)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint = fp
+	}
+	status := t.opts.Statuses[strings.ToUpper(fmt.Sprint(item["status"]))]
+	ticket, err := t.api.find(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	fields := t.ticketFields(item)
+	if ticket == nil {
+		if status != "" {
+			return &SyncResult{Ticket: Ticket{Fingerprint: fingerprint}, Action: "none"}, nil
+		}
+		ticket, err = t.api.create(fields, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		return &SyncResult{Ticket: *ticket, Action: "created"}, nil
+	}
+	if err := t.api.update(ticket, fields); err != nil {
+		return nil, err
+	}
+	result := &SyncResult{Action: "updated"}
+	if status != "" && !t.api.hasStatus(ticket, status) {
+		if err := t.api.transition(ticket, status, t.renderFields(t.opts.TransitionFields, item)); err != nil {
+			return nil, err
+		}
+		if ticket, err = t.api.get(ticket.Key); err != nil {
+			return nil, err
+		}
+		ticket.Fingerprint = fingerprint
+		result.Transitioned = status
+	}
+	result.Ticket = *ticket
+	return result, nil
+}
+
+// client calls the REST API of a tracker
+type client struct {
+	base   string
+	http   *http.Client
+	header map[string]string
+}
+
+func (c *client) basic(username, password string) {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(username, password)
+	c.header["Authorization"] = req.Header.Get("Authorization")
+}
+
+// call sends a request with a JSON body, if any, and decodes the answer
+// into out, if given. Answers other than 2xx are errors that carry what
+// the API said.
+func (c *client) call(method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range c.header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	endpoint := strings.SplitN(path, "?", 2)[0]
+	if resp.StatusCode/100 != 2 {
+		if msg := apiMessage(data); msg != "" {
+			return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, msg)
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, endpoint, resp.Status)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: bad answer: %v", method, endpoint, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// apiMessage returns the error message of an answer, in the forms of
+// Jira and ServiceNow, or its text
+func apiMessage(data []byte) string {
+	var answer struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+		Error         struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &answer) == nil {
+		var msgs []string
+		msgs = append(msgs, answer.ErrorMessages...)
+		names := make([]string, 0, len(answer.Errors))
+		for name := range answer.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			msgs = append(msgs, name+": "+answer.Errors[name])
+		}
+		if answer.Error.Message != "" {
+			msgs = append(msgs, answer.Error.Message)
+			if answer.Error.Detail != "" {
+				msgs = append(msgs, answer.Error.Detail)
+			}
+		}
+		if len(msgs) > 0 {
+			return strings.Join(msgs, "; ")
+		}
+	}
+	text := strings.TrimSpace(string(data))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
+}
+
+// TicketToMap returns a ticket as a map
+func TicketToMap(t Ticket) map[string]interface{} {
+	return map[string]interface{}{
+		"key":         t.Key,
+		"id":          t.ID,
+		"url":         t.URL,
+		"status":      t.Status,
+		"fingerprint": t.Fingerprint,
+	}
+}
+
+// SyncResultToMap returns what syncing did as a map of the ticket, its
+// action and the status it was moved to
+func SyncResultToMap(r *SyncResult) map[string]interface{} {
+	m := TicketToMap(r.Ticket)
+	m["action"] = r.Action
+	m["transitioned"] = r.Transitioned
+	return m
+}
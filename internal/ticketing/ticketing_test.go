@@ -0,0 +1,293 @@
+package ticketing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	a, err := Fingerprint(map[string]interface{}{"title": "SSH open", "host": "web1", "port": 22.0, "severity": "high"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Fingerprint(map[string]interface{}{"title": "SSH open", "host": "web1", "port": 22.0, "severity": "low", "id": "finding-9"}, nil)
+	c, _ := Fingerprint(map[string]interface{}{"title": "SSH open", "host": "web2", "port": 22.0}, nil)
+	if a != b || a == c || len(a) != 32 {
+		t.Errorf("fingerprints %s, %s and %s", a, b, c)
+	}
+	if fp, _ := Fingerprint(map[string]interface{}{"fingerprint": "mine", "title": "x"}, nil); fp != "mine" {
+		t.Errorf("own fingerprint = %s", fp)
+	}
+	if _, err := Fingerprint(map[string]interface{}{"severity": "high"}, []string{"title", "host"}); err == nil ||
+		err.Error() != "nothing to fingerprint, the finding has none of title, host" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// fakeJira serves issues of one project from memory
+func fakeJira(t *testing.T) (*httptest.Server, map[string]map[string]interface{}) {
+	issues := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "me@corp.example" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		issue := func(key string) map[string]interface{} {
+			fields := issues[key]
+			return map[string]interface{}{"id": "100" + strings.TrimPrefix(key, "SEC-"), "key": key,
+				"fields": map[string]interface{}{"status": map[string]interface{}{"name": fields["status"]}, "labels": fields["labels"]}}
+		}
+		switch {
+		case r.URL.Path == "/rest/api/2/search/jql":
+			// Data Center has no search/jql
+			http.NotFound(w, r)
+		case r.URL.Path == "/rest/api/2/search":
+			found := []interface{}{}
+			for key, fields := range issues {
+				for _, label := range fields["labels"].([]interface{}) {
+					if strings.Contains(r.URL.Query().Get("jql"), `labels = "`+label.(string)+`"`) {
+						found = append(found, issue(key))
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"issues": found})
+		case r.Method == "POST" && r.URL.Path == "/rest/api/2/issue":
+			fields := body["fields"].(map[string]interface{})
+			if strings.TrimSpace(fields["summary"].(string)) != fields["summary"] {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errorMessages":[],"errors":{"summary":"The summary cannot start or end with spaces."}}`))
+				return
+			}
+			key := "SEC-" + string(rune('1'+len(issues)))
+			fields["status"] = "To Do"
+			issues[key] = fields
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": "1001", "key": key})
+		case strings.HasSuffix(r.URL.Path, "/transitions"):
+			key := strings.Split(r.URL.Path, "/")[5]
+			if r.Method == "GET" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"transitions": []interface{}{
+					map[string]interface{}{"id": "21", "name": "Start", "to": map[string]interface{}{"name": "In Progress"}},
+					map[string]interface{}{"id": "31", "name": "Close", "to": map[string]interface{}{"name": "Done"}},
+				}})
+				return
+			}
+			if body["transition"].(map[string]interface{})["id"] == "31" {
+				issues[key]["status"] = "Done"
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/rest/api/2/issue/"):
+			key := strings.TrimPrefix(r.URL.Path, "/rest/api/2/issue/")
+			if issues[key] == nil {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errorMessages":["Issue does not exist or you do not have permission to see it."],"errors":{}}`))
+				return
+			}
+			if r.Method == "PUT" {
+				for k, v := range body["fields"].(map[string]interface{}) {
+					issues[key][k] = v
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			json.NewEncoder(w).Encode(issue(key))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+	}))
+	return server, issues
+}
+
+func TestJira(t *testing.T) {
+	server, issues := fakeJira(t)
+	defer server.Close()
+	tracker, err := Connect("jira", server.URL, Options{Project: "SEC", Username: "me@corp.example", Token: "token",
+		Fields: map[string]interface{}{"summary": "{{title}} on {{host}}", "description": "{{description}}", "labels": []interface{}{"scan"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	finding := map[string]interface{}{"title": "SSH open", "host": "web1", "description": "Port 22 answers", "severity": "high", "status": "OPEN"}
+
+	created, err := tracker.Sync(finding, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, _ := tracker.Fingerprint(finding)
+	if created.Action != "created" || created.Key != "SEC-1" || created.URL != server.URL+"/browse/SEC-1" || created.Status != "To Do" || created.Fingerprint != fp {
+		t.Errorf("created %+v", created)
+	}
+	fields := issues["SEC-1"]
+	if fields["summary"] != "SSH open on web1" || fields["issuetype"].(map[string]interface{})["name"] != "Task" ||
+		fields["priority"].(map[string]interface{})["name"] != "High" || fields["project"].(map[string]interface{})["key"] != "SEC" {
+		t.Errorf("created fields %v", fields)
+	}
+	if labels := fields["labels"].([]interface{}); len(labels) != 2 || labels[0] != "scan" || labels[1] != "sentra-"+fp {
+		t.Errorf("labels %v", labels)
+	}
+
+	finding["description"] = "Port 22 answers with OpenSSH 7.2"
+	updated, err := tracker.Sync(finding, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Action != "updated" || updated.Key != "SEC-1" || updated.Transitioned != "" || len(issues) != 1 ||
+		issues["SEC-1"]["description"] != "Port 22 answers with OpenSSH 7.2" {
+		t.Errorf("updated %+v, issues %v", updated, issues)
+	}
+
+	finding["status"] = "fixed"
+	closed, err := tracker.Sync(finding, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Transitioned != "Done" || closed.Status != "Done" {
+		t.Errorf("closed %+v", closed)
+	}
+	ticket, err := tracker.Get("SEC-1")
+	if err != nil || ticket.Status != "Done" || ticket.Fingerprint != fp {
+		t.Errorf("Get = %+v, %v", ticket, err)
+	}
+
+	fixed, err := tracker.Sync(map[string]interface{}{"title": "Telnet open", "host": "web1", "status": "FIXED"}, "")
+	if err != nil || fixed.Action != "none" || len(issues) != 1 {
+		t.Errorf("a fixed finding without a ticket: %+v, %v", fixed, err)
+	}
+	if ticket, err := tracker.Find("unknown"); ticket != nil || err != nil {
+		t.Errorf("Find(unknown) = %+v, %v", ticket, err)
+	}
+	if _, err := tracker.Sync(map[string]interface{}{"title": "", "host": "web2"}, ""); err == nil ||
+		err.Error() != "POST /rest/api/2/issue: 400 Bad Request: summary: The summary cannot start or end with spaces." {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := tracker.Get("SEC-9"); err == nil || !strings.Contains(err.Error(), "404 Not Found: Issue does not exist") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestServiceNow(t *testing.T) {
+	records := make(map[string]map[string]interface{})
+	states := map[string]string{"1": "New", "2": "In Progress", "6": "Resolved"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"User Not Authenticated","detail":"Required to provide Auth information"},"status":"failure"}`))
+			return
+		}
+		record := func(id string) map[string]interface{} {
+			result := make(map[string]interface{})
+			for k, v := range records[id] {
+				result[k] = map[string]interface{}{"value": v, "display_value": v}
+			}
+			state := records[id]["state"].(string)
+			result["state"] = map[string]interface{}{"value": state, "display_value": states[state]}
+			return result
+		}
+		if r.URL.Query().Get("sysparm_display_value") != "all" {
+			t.Errorf("%s %s without display values", r.Method, r.URL)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/now/table/incident":
+			found := []interface{}{}
+			for id, fields := range records {
+				for _, field := range []string{"correlation_id", "number"} {
+					if strings.HasPrefix(r.URL.Query().Get("sysparm_query"), field+"="+fields[field].(string)+"^") {
+						found = append(found, record(id))
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": found})
+		case r.Method == "POST" && r.URL.Path == "/api/now/table/incident":
+			id := "a1b2"
+			body["sys_id"], body["number"], body["state"] = id, "INC0010001", "1"
+			records[id] = body
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": record(id)})
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/api/now/table/incident/"):
+			id := strings.TrimPrefix(r.URL.Path, "/api/now/table/incident/")
+			for k, v := range body {
+				records[id][k] = v
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": record(id)})
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	tracker, err := Connect("servicenow", server.URL, Options{Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incident := map[string]interface{}{"id": "INC-7", "title": "Beacon", "description": "web1 calling out", "severity": "critical", "status": "open"}
+	created, err := tracker.Sync(incident, "incident-INC-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Action != "created" || created.Key != "INC0010001" || created.Status != "New" ||
+		created.URL != server.URL+"/nav_to.do?uri=incident.do%3Fsys_id%3Da1b2" {
+		t.Errorf("created %+v", created)
+	}
+	fields := records["a1b2"]
+	if fields["short_description"] != "Beacon" || fields["correlation_id"] != "incident-INC-7" || fields["urgency"] != "1" || fields["impact"] != "1" {
+		t.Errorf("created fields %v", fields)
+	}
+
+	incident["status"] = "resolved"
+	resolved, err := tracker.Sync(incident, "incident-INC-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Action != "updated" || resolved.Transitioned != "6" || resolved.Status != "Resolved" ||
+		records["a1b2"]["close_notes"] != "Closed by Sentra: the finding is resolved" || records["a1b2"]["close_code"] != "Solved (Permanently)" {
+		t.Errorf("resolved %+v, record %v", resolved, records["a1b2"])
+	}
+	again, err := tracker.Sync(incident, "incident-INC-7")
+	if err != nil || again.Transitioned != "" {
+		t.Errorf("resolved again: %+v, %v", again, err)
+	}
+
+	if _, err := tracker.Get("INC0010009"); err == nil || err.Error() != "no incident record INC0010009" {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := tracker.Find("a^b"); err == nil || err.Error() != "'a^b' cannot be looked up in ServiceNow, it holds a ^" {
+		t.Errorf("err = %v", err)
+	}
+	denied, _ := Connect("servicenow", server.URL, Options{Username: "admin", Password: "wrong"})
+	if _, err := denied.Find("x"); err == nil ||
+		err.Error() != "GET /api/now/table/incident: 401 Unauthorized: User Not Authenticated; Required to provide Auth information" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	for _, c := range []struct {
+		kind, url string
+		opts      Options
+		want      string
+	}{
+		{"jira", "jira.corp.example", Options{Token: "t", Project: "SEC"}, "jira needs an http or https url, got 'jira.corp.example'"},
+		{"jira", "https://jira.corp.example", Options{Token: "t"}, "jira needs a project"},
+		{"servicenow", "https://corp.service-now.com", Options{}, "servicenow needs a username and a password or a token"},
+		{"redmine", "https://redmine.corp.example", Options{Token: "t"}, "unknown ticketing system 'redmine', expected jira or servicenow"},
+	} {
+		if _, err := Connect(c.kind, c.url, c.opts); err == nil || err.Error() != c.want {
+			t.Errorf("Connect(%s, %s) err = %v, want %s", c.kind, c.url, err, c.want)
+		}
+	}
+	tracker, err := Connect("jira", "https://jira.corp.example/", Options{Token: "t", Project: "SEC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, err := Lookup(tracker.ID); err != nil || found != tracker || tracker.URL != "https://jira.corp.example" {
+		t.Errorf("Lookup(%s) = %+v, %v", tracker.ID, found, err)
+	}
+	if _, err := Lookup("jira_0"); err == nil || err.Error() != "ticket tracker 'jira_0' not found" {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// internal/vm/builtins.go
+package vm
+
+import (
+	"sentra/internal/builtins"
+)
+
+// registerSharedBuiltins registers the builtins whose implementation lives
+// in the shared registry, converting values on the way in and out
+func (vm *EnhancedVM) registerSharedBuiltins() {
+	for _, b := range builtins.Shared() {
+		b := b
+		vm.AddBuiltinFunction(b.Name, &NativeFunction{
+			Name:  b.Name,
+			Arity: b.Arity,
+			Function: func(args []Value) (Value, error) {
+				shared := make([]builtins.Value, len(args))
+				for i, arg := range args {
+					shared[i] = toShared(arg)
+				}
+				result, err := b.Call(shared)
+				if err != nil {
+					return nil, err
+				}
+				return fromShared(result), nil
+			},
+		})
+	}
+}
+
+// toShared converts a value for a shared builtin. Functions and other
+// values that have no counterpart become their string form.
+func toShared(v Value) builtins.Value {
+	switch v := v.(type) {
+	case nil, bool, float64, string, *Channel:
+		return v
+	case *String:
+		return v.Value
+	case *Array:
+		elements := make([]builtins.Value, len(v.Elements))
+		for i, elem := range v.Elements {
+			elements[i] = toShared(elem)
+		}
+		return elements
+	case *Map:
+		items := make(map[string]builtins.Value, len(v.Items))
+		for key, item := range v.Items {
+			items[key] = toShared(item)
+		}
+		return items
+	}
+	return ToString(v)
+}
+
+// fromShared converts a value returned by a shared builtin, where all
+// numbers become floats
+func fromShared(v builtins.Value) Value {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case []builtins.Value:
+		arr := NewArray(len(v))
+		for _, elem := range v {
+			arr.Elements = append(arr.Elements, fromShared(elem))
+		}
+		return arr
+	case map[string]builtins.Value:
+		m := NewMap()
+		for key, item := range v {
+			m.Items[key] = fromShared(item)
+		}
+		return m
+	}
+	return v
+}
@@ -0,0 +1,88 @@
+package vm
+
+import "maps"
+
+// Arrays and maps are references: assigning one or storing it in another
+// shares it. clone and deep_clone make copies. A clone shares the elements
+// of the original until either is changed, so cloning a large array or
+// map costs nothing until one of them is written. Every change to Elements
+// or Items must call own first.
+
+// Clone returns a shallow copy of a
+func (a *Array) Clone() *Array {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.shared = true
+	return &Array{Elements: a.Elements, shared: true}
+}
+
+// own gives a its own elements before a change, copying them if a clone
+// may share them
+func (a *Array) own() {
+	if a.shared {
+		a.Elements = append(make([]Value, 0, len(a.Elements)), a.Elements...)
+		a.shared = false
+	}
+}
+
+// Clone returns a shallow copy of m
+func (m *Map) Clone() *Map {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shared = true
+	return &Map{Items: m.Items, shared: true}
+}
+
+// own gives m its own items before a change, copying them if a clone may
+// share them
+func (m *Map) own() {
+	if m.shared {
+		m.Items = maps.Clone(m.Items)
+		m.shared = false
+	}
+}
+
+// CloneValue returns a shallow copy of an array or map, and any other value
+// as is
+func CloneValue(v Value) Value {
+	switch v := v.(type) {
+	case *Array:
+		return v.Clone()
+	case *Map:
+		return v.Clone()
+	}
+	return v
+}
+
+// DeepClone copies an array or map and every array and map inside it. An
+// array or map reached twice is copied once, so cycles and sharing within
+// v are kept in the copy.
+func DeepClone(v Value) Value {
+	return deepClone(v, make(map[Value]Value))
+}
+
+func deepClone(v Value, seen map[Value]Value) Value {
+	switch v := v.(type) {
+	case *Array:
+		if c, ok := seen[v]; ok {
+			return c
+		}
+		c := &Array{Elements: make([]Value, len(v.Elements))}
+		seen[v] = c
+		for i, elem := range v.Elements {
+			c.Elements[i] = deepClone(elem, seen)
+		}
+		return c
+	case *Map:
+		if c, ok := seen[v]; ok {
+			return c
+		}
+		c := &Map{Items: make(map[string]Value, len(v.Items))}
+		seen[v] = c
+		for key, item := range v.Items {
+			c.Items[key] = deepClone(item, seen)
+		}
+		return c
+	}
+	return v
+}
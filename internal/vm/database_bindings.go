@@ -187,42 +187,6 @@ func RegisterDatabaseBindings(vm *EnhancedVM) {
 			return rowMap, nil
 		},
 	})
-	
-	// Utility functions
-	vm.AddBuiltinFunction("sql_escape", &NativeFunction{
-		Name:  "sql_escape",
-		Arity: 1,
-		Function: func(args []Value) (Value, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("sql_escape expects 1 argument: string")
-			}
-			
-			str := ToString(args[0])
-			
-			// Basic SQL escaping
-			escaped := ""
-			for _, ch := range str {
-				switch ch {
-				case '\'':
-					escaped += "''"
-				case '"':
-					escaped += "\"\""
-				case '\\':
-					escaped += "\\\\"
-				case '\n':
-					escaped += "\\n"
-				case '\r':
-					escaped += "\\r"
-				case '\t':
-					escaped += "\\t"
-				default:
-					escaped += string(ch)
-				}
-			}
-			
-			return &String{Value: escaped}, nil
-		},
-	})
 }
 
 // Helper function to convert VM Value to Go value
@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -44,29 +45,13 @@ func TestDatabaseSecurityFunctions(t *testing.T) {
 			t.Fatal("db_security_scan function not found")
 		}
 		
+		// Findings come from the database, so there are none to make up
+		// for a connection that does not exist
 		builtin := vm.globals[fn].(*NativeFunction)
-		result, err := builtin.Function([]Value{"conn_123"})
-		
-		if err != nil {
-			t.Fatalf("db_security_scan failed: %v", err)
-		}
-		
-		resultMap, ok := result.(*Map)
-		if !ok {
-			t.Fatal("db_security_scan should return a map")
-		}
-		
-		if resultMap.Items["connection_id"] != "conn_123" {
-			t.Errorf("Expected connection_id 'conn_123', got %v", resultMap.Items["connection_id"])
-		}
-		
-		findings, ok := resultMap.Items["findings"].(*Array)
-		if !ok || len(findings.Elements) == 0 {
-			t.Error("Expected findings array with elements")
-		}
+		_, err := builtin.Function([]Value{"conn_123"})
 		
-		if resultMap.Items["risk_score"] != 75.5 {
-			t.Errorf("Expected risk_score 75.5, got %v", resultMap.Items["risk_score"])
+		if err == nil || !strings.Contains(err.Error(), "connection not found") {
+			t.Errorf("Expected a connection not found error, got %v", err)
 		}
 	})
 	
@@ -119,29 +104,13 @@ func TestDatabaseSecurityFunctions(t *testing.T) {
 			t.Fatal("db_audit_privileges function not found")
 		}
 		
+		// Findings come from the database, so there are none to make up
+		// for a connection that does not exist
 		builtin := vm.globals[fn].(*NativeFunction)
-		result, err := builtin.Function([]Value{"conn_123"})
-		
-		if err != nil {
-			t.Fatalf("db_audit_privileges failed: %v", err)
-		}
-		
-		resultMap, ok := result.(*Map)
-		if !ok {
-			t.Fatal("db_audit_privileges should return a map")
-		}
+		_, err := builtin.Function([]Value{"conn_123"})
 		
-		users, ok := resultMap.Items["users"].(*Array)
-		if !ok || len(users.Elements) == 0 {
-			t.Error("Expected users array with elements")
-		}
-		
-		if resultMap.Items["total_users"] != 2 {
-			t.Errorf("Expected total_users 2, got %v", resultMap.Items["total_users"])
-		}
-		
-		if resultMap.Items["high_risk_users"] != 1 {
-			t.Errorf("Expected high_risk_users 1, got %v", resultMap.Items["high_risk_users"])
+		if err == nil || !strings.Contains(err.Error(), "connection not found") {
+			t.Errorf("Expected a connection not found error, got %v", err)
 		}
 	})
 	
@@ -151,28 +120,13 @@ func TestDatabaseSecurityFunctions(t *testing.T) {
 			t.Fatal("db_check_encryption function not found")
 		}
 		
+		// Findings come from the database, so there are none to make up
+		// for a connection that does not exist
 		builtin := vm.globals[fn].(*NativeFunction)
-		result, err := builtin.Function([]Value{"conn_123"})
-		
-		if err != nil {
-			t.Fatalf("db_check_encryption failed: %v", err)
-		}
-		
-		resultMap, ok := result.(*Map)
-		if !ok {
-			t.Fatal("db_check_encryption should return a map")
-		}
-		
-		if !ToBool(resultMap.Items["ssl_connection"]) {
-			t.Error("Expected ssl_connection to be true")
-		}
-		
-		if resultMap.Items["tls_version"] != "TLSv1.2" {
-			t.Errorf("Expected tls_version 'TLSv1.2', got %v", resultMap.Items["tls_version"])
-		}
+		_, err := builtin.Function([]Value{"conn_123"})
 		
-		if resultMap.Items["compliance_score"] != 60.0 {
-			t.Errorf("Expected compliance_score 60.0, got %v", resultMap.Items["compliance_score"])
+		if err == nil || !strings.Contains(err.Error(), "connection not found") {
+			t.Errorf("Expected a connection not found error, got %v", err)
 		}
 	})
 	
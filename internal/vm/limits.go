@@ -0,0 +1,51 @@
+package vm
+
+import "sentra/internal/limits"
+
+// defaultMaxInstructions is the runaway guard used without an instruction
+// limit
+const defaultMaxInstructions = 100000000
+
+// StartLimits enforces l until StopLimits. A script that exceeds a limit
+// gets a *limits.Error it can catch once; see limits.Error. Without an
+// instruction limit the default runaway guard still applies.
+func (vm *EnhancedVM) StartLimits(l limits.Limits) {
+	if l.MaxInstructions == 0 {
+		l.MaxInstructions = defaultMaxInstructions
+	}
+	vm.limits = limits.Start(l, nil)
+}
+
+// StopLimits stops enforcing the limits set by StartLimits
+func (vm *EnhancedVM) StopLimits() {
+	if vm.limits != nil {
+		vm.limits.Stop()
+		vm.limits = nil
+	}
+}
+
+// catchLimit jumps to the innermost catch block for a catchable resource
+// error and reports whether it did
+func (vm *EnhancedVM) catchLimit(err error) bool {
+	if !limits.Catchable(err) || len(vm.tryStack) == 0 {
+		return false
+	}
+	tryFrame := vm.tryStack[len(vm.tryStack)-1]
+	vm.tryStack = vm.tryStack[:len(vm.tryStack)-1]
+	vm.lastError = NewError(err.Error())
+	vm.frameCount = tryFrame.frameDepth
+	vm.frames[vm.frameCount-1].ip = tryFrame.catchIP
+	vm.stackTop = tryFrame.stackDepth
+	vm.push(vm.lastError)
+	return true
+}
+
+// callTooDeep returns the error for a call to name that would go past
+// maxFrames, or nil if a catch block in the script has taken it
+func (vm *EnhancedVM) callTooDeep(name string) error {
+	err := &limits.DepthError{Function: name, Depth: vm.frameCount, Limit: vm.maxFrames}
+	if vm.catchLimit(err) {
+		return nil
+	}
+	return err
+}
@@ -87,6 +87,10 @@ func (ml *ModuleLoader) LoadFileModule(path string) (*Module, error) {
 	scanner := lexer.NewScannerWithFile(string(source), resolvedPath)
 	tokens := scanner.ScanTokens()
 	
+	if len(scanner.Errors) > 0 {
+		ml.mu.Unlock()
+		return nil, scanner.Errors[0]
+	}
 	if scanner.HadError() {
 		ml.mu.Unlock()
 		return nil, fmt.Errorf("syntax errors in module %s", path)
@@ -112,6 +116,10 @@ func (ml *ModuleLoader) LoadFileModule(path string) (*Module, error) {
 	// Compile the module with function hoisting
 	c := compiler.NewHoistingCompilerWithDebug(resolvedPath)
 	chunk := c.CompileWithHoisting(stmts)
+	if len(c.Errors) > 0 {
+		ml.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", resolvedPath, c.Errors[0])
+	}
 	
 	// Create a new VM instance for the module (isolated context)
 	moduleVM := NewVM(chunk)
@@ -0,0 +1,33 @@
+package vm
+
+import (
+	"fmt"
+
+	"sentra/internal/sandbox"
+)
+
+// SetPermissions makes every builtin that reads or writes files, uses the
+// network, runs programs, reads the environment or inspects the system
+// check p first (see sandbox.BuiltinRule). A refused call fails with a
+// *sandbox.PermissionError. Call it once, before running the script.
+func (vm *EnhancedVM) SetPermissions(p *sandbox.Policy) {
+	for _, name := range sandbox.Builtins() {
+		index, exists := vm.globalMap[name]
+		if !exists {
+			continue
+		}
+		fn, ok := vm.globals[index].(*NativeFunction)
+		if !ok {
+			continue
+		}
+		rule, _ := sandbox.BuiltinRule(name)
+		call := fn.Function
+		fn.Function = func(args []Value) (Value, error) {
+			err := rule.Check(p, len(args), func(i int) string { return ToString(args[i]) })
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return call(args)
+		}
+	}
+}
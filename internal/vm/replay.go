@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"sentra/internal/replay"
+)
+
+// SetReplay routes the builtins that depend on the outside world through
+// s, which records their results or plays back a recording (see
+// replay.Builtins). Call it once, before running the script.
+func (vm *EnhancedVM) SetReplay(s replay.Session) {
+	for _, name := range replay.Builtins() {
+		index, exists := vm.globalMap[name]
+		if !exists {
+			continue
+		}
+		fn, ok := vm.globals[index].(*NativeFunction)
+		if !ok {
+			continue
+		}
+		call := fn.Function
+		fn.Function = func(args []Value) (Value, error) {
+			recordArgs := make([]any, len(args))
+			for i, arg := range args {
+				recordArgs[i] = toReplay(arg)
+			}
+			ran := false
+			var live Value
+			result, err := s.Call(name, recordArgs, func() (any, error) {
+				var err error
+				ran = true
+				live, err = call(args)
+				return toReplay(live), err
+			})
+			if ran {
+				// Recording: keep the very value the builtin returned
+				return live, err
+			}
+			if err != nil {
+				return nil, err
+			}
+			return fromReplay(result), nil
+		}
+	}
+}
+
+// toReplay converts a value for a replay.Session. Numbers are all floats.
+func toReplay(v Value) any {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case *String:
+		return v.Value
+	case *Bytes:
+		return append([]byte(nil), v.Data...)
+	case *Array:
+		out := make([]any, len(v.Elements))
+		for i, elem := range v.Elements {
+			out[i] = toReplay(elem)
+		}
+		return out
+	case *Map:
+		v.mu.RLock()
+		defer v.mu.RUnlock()
+		out := make(map[string]any, len(v.Items))
+		for key, item := range v.Items {
+			out[key] = toReplay(item)
+		}
+		return out
+	}
+	return replay.Opaque(ValueType(v))
+}
+
+// fromReplay converts a value played back by a replay.Session
+func fromReplay(v any) Value {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case []byte:
+		return &Bytes{Data: v}
+	case []any:
+		arr := NewArray(len(v))
+		for _, elem := range v {
+			arr.Elements = append(arr.Elements, fromReplay(elem))
+		}
+		return arr
+	case map[string]any:
+		m := NewMap()
+		for key, item := range v {
+			m.Items[key] = fromReplay(item)
+		}
+		return m
+	}
+	return v
+}
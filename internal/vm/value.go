@@ -1,10 +1,14 @@
 package vm
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"sync"
 	"sentra/internal/bytecode"
+	"sentra/internal/concurrency"
 )
 
 type Value interface{}
@@ -13,6 +17,9 @@ type Value interface{}
 type Function struct {
 	Name       string
 	Arity      int
+	Optional   int // Trailing parameters that may be left out
+	Params     []string
+	Locals     int // Local slots a call needs, parameters included
 	Chunk      *bytecode.Chunk
 	Upvalues   []*Upvalue
 	IsVariadic bool
@@ -32,16 +39,23 @@ type Upvalue struct {
 	Next     *Upvalue
 }
 
-// Array represents a dynamic array
+// Array represents a dynamic array. Arrays and maps belong to the
+// goroutine running the script and must not be touched from another one;
+// the locks taken by some operations do not make them safe to share.
+// Values that goroutines share go through the concurrent maps and queues
+// of the concurrency package, which copy them.
 type Array struct {
 	Elements []Value
-	mu       sync.RWMutex // Thread-safe access
+	mu       sync.RWMutex
+	shared   bool // Elements may be shared with a clone, see Clone
 }
 
-// Map represents a hash map
+// Map represents a hash map. Like an Array, it belongs to the goroutine
+// running the script.
 type Map struct {
-	Items map[string]Value
-	mu    sync.RWMutex // Thread-safe access
+	Items  map[string]Value
+	mu     sync.RWMutex
+	shared bool // Items may be shared with a clone, see Clone
 }
 
 // BoundMethod represents a method bound to an object
@@ -50,6 +64,11 @@ type BoundMethod struct {
 	Method string
 }
 
+// Bytes represents immutable binary data
+type Bytes struct {
+	Data []byte
+}
+
 // String represents an immutable string
 type String struct {
 	Value  string
@@ -89,17 +108,15 @@ type StackFrame struct {
 	Column   int
 }
 
-// Channel represents a communication channel for concurrency
-type Channel struct {
-	ch     chan Value
-	closed bool
-	mu     sync.Mutex
-}
+// Channel represents a communication channel for concurrency. It carries
+// values converted by toShared, so goroutines never share an array or map.
+type Channel = concurrency.Channel
 
 // NativeFunction represents a built-in function
 type NativeFunction struct {
 	Name     string
 	Arity    int
+	Params   []string // Parameter names for keyword arguments, nil if it takes none
 	Function func(args []Value) (Value, error)
 }
 
@@ -107,7 +124,9 @@ type NativeFunction struct {
 type Iterator struct {
 	Collection Value
 	Index      int
-	Keys       []string // For maps
+	Keys       []string                   // For maps
+	Next       func() (Value, bool, error) // Produces the values of a lazy iterator, such as file_lines
+	Close      func()                      // Releases a lazy iterator left before its end
 }
 
 // ValueType returns the type of a value as a string
@@ -135,6 +154,10 @@ func ValueType(val Value) string {
 		return "channel"
 	case *Error:
 		return "error"
+	case *Bytes:
+		return "bytes"
+	case *Iterator:
+		return "iterator"
 	default:
 		return "unknown"
 	}
@@ -161,6 +184,8 @@ func IsTruthy(val Value) bool {
 		return len(v.Elements) > 0
 	case *Map:
 		return len(v.Items) > 0
+	case *Bytes:
+		return len(v.Data) > 0
 	default:
 		return true
 	}
@@ -173,6 +198,8 @@ func ToNumber(val Value) float64 {
 		return v
 	case int:
 		return float64(v)
+	case int64:
+		return float64(v)
 	case bool:
 		if v {
 			return 1
@@ -249,6 +276,10 @@ func ValuesEqual(a, b Value) bool {
 			}
 			return true
 		}
+	case *Bytes:
+		if bv, ok := b.(*Bytes); ok {
+			return bytes.Equal(av.Data, bv.Data)
+		}
 	}
 	
 	// Default comparison
@@ -299,6 +330,9 @@ func ToString(val Value) string {
 		return "<channel>"
 	case *Error:
 		return fmt.Sprintf("Error: %s", v.Message)
+	case *Bytes:
+		// Bytes read as text wherever a string is wanted
+		return string(v.Data)
 	default:
 		return fmt.Sprintf("%v", v)
 	}
@@ -366,6 +400,9 @@ func valuesEqual(a, b Value) bool {
 			}
 		}
 		return true
+	case *Bytes:
+		bv, ok := b.(*Bytes)
+		return ok && bytes.Equal(av.Data, bv.Data)
 	default:
 		return a == b
 	}
@@ -411,12 +448,100 @@ func NewString(s string) *String {
 	}
 }
 
+// Slice returns a copy of the part of an array, string or bytes from start
+// up to but not including end. A nil bound is open, a negative one counts
+// from the end, and bounds past either end are clamped.
+func Slice(val, start, end Value) (Value, error) {
+	for _, bound := range []Value{start, end} {
+		if t := ValueType(bound); t != "nil" && t != "number" {
+			return nil, fmt.Errorf("slice bounds must be numbers, got %s", t)
+		}
+	}
+	switch v := val.(type) {
+	case *Array:
+		lo, hi := sliceBounds(len(v.Elements), start, end)
+		return &Array{Elements: append([]Value(nil), v.Elements[lo:hi]...)}, nil
+	case string:
+		lo, hi := sliceBounds(len(v), start, end)
+		return v[lo:hi], nil
+	case *String:
+		lo, hi := sliceBounds(len(v.Value), start, end)
+		return v.Value[lo:hi], nil
+	case *Bytes:
+		lo, hi := sliceBounds(len(v.Data), start, end)
+		return &Bytes{Data: v.Data[lo:hi:hi]}, nil
+	}
+	return nil, fmt.Errorf("cannot slice %s", ValueType(val))
+}
+
+// sliceBounds resolves the bounds of a slice of a value of length n
+func sliceBounds(n int, start, end Value) (int, int) {
+	bound := func(b Value, open int) int {
+		if b == nil {
+			return open
+		}
+		i := int(ToNumber(b))
+		if i < 0 {
+			i += n
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > n {
+			return n
+		}
+		return i
+	}
+	lo, hi := bound(start, 0), bound(end, n)
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// ToBytes converts a string, array of byte values or bytes to bytes. A
+// string is read in encoding: "utf8" (the default for ""), "hex" or
+// "base64".
+func ToBytes(val Value, encoding string) (*Bytes, error) {
+	switch v := val.(type) {
+	case *Bytes:
+		return v, nil
+	case string, *String:
+		s := ToString(v)
+		switch encoding {
+		case "", "utf8":
+			return &Bytes{Data: []byte(s)}, nil
+		case "hex":
+			data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex: %v", err)
+			}
+			return &Bytes{Data: data}, nil
+		case "base64":
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64: %v", err)
+			}
+			return &Bytes{Data: data}, nil
+		}
+		return nil, fmt.Errorf("unknown encoding %q, expected utf8, hex or base64", encoding)
+	case *Array:
+		data := make([]byte, len(v.Elements))
+		for i, elem := range v.Elements {
+			n := ToNumber(elem)
+			if ValueType(elem) != "number" || n < 0 || n > 255 || n != float64(int(n)) {
+				return nil, fmt.Errorf("element %d is not a byte: %s", i, ToString(elem))
+			}
+			data[i] = byte(n)
+		}
+		return &Bytes{Data: data}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to bytes", ValueType(val))
+}
+
 // NewChannel creates a new channel
 func NewChannel(buffer int) *Channel {
-	return &Channel{
-		ch:     make(chan Value, buffer),
-		closed: false,
-	}
+	return concurrency.NewChannel(buffer)
 }
 
 // NewError creates a new error
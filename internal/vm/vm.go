@@ -1,9 +1,12 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -13,6 +16,7 @@ import (
 	"sentra/internal/bytecode"
 	"sentra/internal/compiler"
 	"sentra/internal/errors"
+	"sentra/internal/limits"
 	"sentra/internal/security"
 	"sentra/internal/network"
 	"sentra/internal/ossec"
@@ -76,6 +80,8 @@ type EnhancedVM struct {
 	stackTop   int // Track stack top for optimization
 	debug      bool // Debug flag
 	debugHook  DebugHook // Debug callback interface
+	trace      io.Writer // Instruction log set by SetTrace
+	limits     *limits.Tracker // Resource limits set by StartLimits
 	
 	// Memory management
 	globals    []Value                // Array-based globals for faster access
@@ -196,12 +202,18 @@ func (vm *EnhancedVM) precacheConstants() {
 		switch v := c.(type) {
 		case string:
 			vm.constCache[i] = NewString(v)
+		case []byte:
+			vm.constCache[i] = &Bytes{Data: v}
 		case *compiler.Function:
 			// Convert compiler.Function to vm.Function
 			vm.constCache[i] = &Function{
-				Name:  v.Name,
-				Arity: v.Arity,
-				Chunk: v.Chunk,
+				Name:       v.Name,
+				Arity:      v.Arity,
+				Optional:   v.Optional,
+				IsVariadic: v.IsVariadic,
+				Params:     v.Params,
+				Locals:     v.Locals,
+				Chunk:      v.Chunk,
 			}
 		default:
 			vm.constCache[i] = v
@@ -291,7 +303,14 @@ func (vm *EnhancedVM) Run() (Value, error) {
 		
 		// Check for runaway execution
 		instrCount++
-		if instrCount > 100000000 {
+		if vm.limits != nil {
+			if err := vm.limits.Step(); err != nil {
+				if !vm.catchLimit(err) {
+					return nil, err
+				}
+				continue
+			}
+		} else if instrCount > defaultMaxInstructions {
 			return nil, fmt.Errorf("execution limit exceeded")
 		}
 		
@@ -305,6 +324,10 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			return nil, fmt.Errorf("program counter out of bounds")
 		}
 		
+		if vm.trace != nil {
+			vm.traceInstruction(frame)
+		}
+		
 		// Fetch and execute instruction
 		instruction := bytecode.OpCode(frame.chunk.Code[frame.ip])
 		frame.ip++
@@ -330,11 +353,17 @@ func (vm *EnhancedVM) Run() (Value, error) {
 				if compilerFn, ok := constVal.(*compiler.Function); ok {
 					// Convert compiler.Function to vm.Function
 					vmFn := &Function{
-						Name:  compilerFn.Name,
-						Arity: compilerFn.Arity,
-						Chunk: compilerFn.Chunk,
+						Name:       compilerFn.Name,
+						Arity:      compilerFn.Arity,
+						Optional:   compilerFn.Optional,
+						IsVariadic: compilerFn.IsVariadic,
+						Params:     compilerFn.Params,
+						Locals:     compilerFn.Locals,
+						Chunk:      compilerFn.Chunk,
 					}
 					vm.push(vmFn)
+				} else if data, ok := constVal.([]byte); ok {
+					vm.push(&Bytes{Data: data})
 				} else {
 					vm.push(constVal)
 				}
@@ -596,6 +625,15 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			}
 			vm.push(array)
 			
+		case bytecode.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			result, err := Slice(vm.pop(), start, end)
+			if err != nil {
+				return nil, vm.runtimeError(err.Error())
+			}
+			vm.push(result)
+			
 		case bytecode.OpIndex:
 			index := vm.pop()
 			collection := vm.pop()
@@ -657,6 +695,15 @@ func (vm *EnhancedVM) Run() (Value, error) {
 				} else {
 					vm.push(nil)
 				}
+			case *Bytes:
+				// Indexing bytes gives the byte as a number, like an array
+				if ValueType(index) != "number" {
+					vm.push(nil)
+				} else if idx := int(ToNumber(index)); idx >= 0 && idx < len(coll.Data) {
+					vm.push(float64(coll.Data[idx]))
+				} else {
+					vm.push(nil)
+				}
 			case float64, int, bool:
 				// Primitive types - property access returns nil
 				vm.push(nil)
@@ -743,6 +790,7 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			key := ToString(vm.pop())
 			m := vm.pop().(*Map)
 			m.mu.Lock()
+			m.own()
 			m.Items[key] = value
 			m.mu.Unlock()
 			vm.push(value)
@@ -751,6 +799,7 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			key := ToString(vm.pop())
 			m := vm.pop().(*Map)
 			m.mu.Lock()
+			m.own()
 			delete(m.Items, key)
 			m.mu.Unlock()
 			vm.push(nil)
@@ -824,12 +873,45 @@ func (vm *EnhancedVM) Run() (Value, error) {
 					collection: &Array{Elements: chars},
 				})
 				
+			case *Channel:
+				// Receives until the channel is closed and drained
+				vm.iterStack = append(vm.iterStack, &iterState{
+					index: 0,
+					collection: &Iterator{Next: func() (Value, bool, error) {
+						val, ok := v.Recv(concurrency.Forever)
+						return fromShared(val), ok, nil
+					}},
+				})
+				
+			case *Iterator:
+				if v.Next == nil {
+					return nil, fmt.Errorf("cannot iterate over type %T", v)
+				}
+				vm.iterStack = append(vm.iterStack, &iterState{
+					index:      0,
+					collection: v,
+				})
+				
+			case *Bytes:
+				// For bytes: iterate over the byte values
+				values := make([]Value, len(v.Data))
+				for i, b := range v.Data {
+					values[i] = float64(b)
+				}
+				vm.iterStack = append(vm.iterStack, &iterState{
+					index:      0,
+					collection: &Array{Elements: values},
+				})
+				
 			default:
 				return nil, fmt.Errorf("cannot iterate over type %T", v)
 			}
 			
 		case bytecode.OpIterNext:
-			// Get next iteration value from separate iteration stack
+			// Get next iteration value from separate iteration stack. The
+			// operand says what to push before the continue flag: 0 for the
+			// element (or map key), 1 for the index (or map key) and value.
+			pair := vm.readByte() == 1
 			if len(vm.iterStack) == 0 {
 				return nil, fmt.Errorf("no active iteration")
 			}
@@ -843,11 +925,17 @@ func (vm *EnhancedVM) Run() (Value, error) {
 				// Array iteration
 				if state.index < len(coll.Elements) {
 					// Push value first, then boolean for OpJumpIfFalse
+					if pair {
+						vm.push(float64(state.index))
+					}
 					vm.push(coll.Elements[state.index]) // Current element
 					state.index++
 					vm.push(true) // Continue iteration
 				} else {
 					// End iteration - push nil element and false to maintain stack consistency
+					if pair {
+						vm.push(nil)
+					}
 					vm.push(nil) // Dummy element (will be popped)
 					vm.push(false) // End iteration
 				}
@@ -858,10 +946,37 @@ func (vm *EnhancedVM) Run() (Value, error) {
 					key := state.keys[state.index]
 					// Push key first (not value), then boolean
 					vm.push(key)
+					if pair {
+						vm.push(coll.Items[key])
+					}
 					state.index++
 					vm.push(true) // Continue iteration
 				} else {
 					// End iteration - push nil element and false to maintain stack consistency
+					if pair {
+						vm.push(nil)
+					}
+					vm.push(nil) // Dummy element (will be popped)
+					vm.push(false) // End iteration
+				}
+				
+			case *Iterator:
+				// Lazy iteration - ask for the next value
+				next, ok, err := coll.Next()
+				if err != nil {
+					return nil, vm.runtimeError(err.Error())
+				}
+				if ok {
+					if pair {
+						vm.push(float64(state.index))
+					}
+					vm.push(next)
+					state.index++
+					vm.push(true) // Continue iteration
+				} else {
+					if pair {
+						vm.push(nil)
+					}
 					vm.push(nil) // Dummy element (will be popped)
 					vm.push(false) // End iteration
 				}
@@ -873,6 +988,10 @@ func (vm *EnhancedVM) Run() (Value, error) {
 		case bytecode.OpIterEnd:
 			// Clean up iteration state
 			if len(vm.iterStack) > 0 {
+				state := vm.iterStack[len(vm.iterStack)-1].(*iterState)
+				if lazy, ok := state.collection.(*Iterator); ok && lazy.Close != nil {
+					lazy.Close()
+				}
 				vm.iterStack = vm.iterStack[:len(vm.iterStack)-1]
 			}
 			
@@ -925,7 +1044,46 @@ func (vm *EnhancedVM) Run() (Value, error) {
 		// Function calls
 		case bytecode.OpCall:
 			argCount := int(vm.readByte())
-			vm.performCall(argCount)
+			if err := vm.performCall(argCount); err != nil {
+				return nil, err
+			}
+			
+		case bytecode.OpTailCall:
+			// Runs a Sentra function in place of the frame returning its
+			// result, so tail recursion does not grow the frame stack. Other
+			// callees, and functions returning to module globals, are called
+			// as by OpCall for the OpReturn that follows.
+			argCount := int(vm.readByte())
+			switch vm.stack[vm.stackTop-1].(type) {
+			case *Function, *compiler.Function:
+				if vm.frameCount > 1 && frame.restoreGlobals == nil {
+					// Move the arguments and callee down to the frame's base
+					// and call from the caller's depth, reusing its slot
+					n := copy(vm.stack[frame.slotBase:], vm.stack[vm.stackTop-argCount-1:vm.stackTop])
+					vm.stackTop = frame.slotBase + n
+					vm.frameCount--
+				}
+			}
+			if err := vm.performCall(argCount); err != nil {
+				return nil, err
+			}
+			
+		case bytecode.OpCallKw:
+			argCount := int(vm.readByte())
+			names := strings.Split(ToString(frame.chunk.Constants[vm.readByte()]), ",")
+			fn := vm.stack[vm.stackTop-1]
+			args, err := bindKeywords(fn, vm.stack[vm.stackTop-argCount-1:vm.stackTop-1], names)
+			if err != nil {
+				return nil, vm.runtimeError(err.Error())
+			}
+			vm.stackTop -= argCount + 1
+			for _, arg := range args {
+				vm.push(arg)
+			}
+			vm.push(fn)
+			if err := vm.performCall(len(args)); err != nil {
+				return nil, err
+			}
 			
 		case bytecode.OpReturn:
 			var result Value = nil
@@ -940,6 +1098,10 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			}
 			
 			vm.frameCount--
+			// Drop the handlers of try blocks the function returned from
+			for len(vm.tryStack) > 0 && vm.tryStack[len(vm.tryStack)-1].frameDepth > vm.frameCount {
+				vm.tryStack = vm.tryStack[:len(vm.tryStack)-1]
+			}
 			if vm.frameCount == 0 {
 				return result, nil
 			}
@@ -966,6 +1128,10 @@ func (vm *EnhancedVM) Run() (Value, error) {
 				frameDepth: vm.frameCount,
 			})
 			
+		case bytecode.OpCatch:
+			// The try block finished without an error
+			vm.tryStack = vm.tryStack[:len(vm.tryStack)-1]
+			
 		case bytecode.OpThrow:
 			err := vm.pop()
 			if e, ok := err.(*Error); ok {
@@ -1008,6 +1174,18 @@ func (vm *EnhancedVM) Run() (Value, error) {
 			module := vm.loadModule(moduleName)
 			vm.push(module)
 			
+		case bytecode.OpImportName:
+			moduleName := frame.chunk.Constants[vm.readByte()].(string)
+			exportName := frame.chunk.Constants[vm.readByte()].(string)
+			module := vm.peek(0).(*Map)
+			module.mu.RLock()
+			value, ok := module.Items[exportName]
+			module.mu.RUnlock()
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf("module %s has no export '%s'", moduleName, exportName))
+			}
+			vm.push(value)
+			
 		case bytecode.OpExport:
 			nameIndex := vm.readByte()
 			exportName := frame.chunk.Constants[nameIndex].(string)
@@ -1042,21 +1220,15 @@ func (vm *EnhancedVM) Run() (Value, error) {
 		case bytecode.OpChannelSend:
 			value := vm.pop()
 			ch := vm.pop().(*Channel)
-			ch.mu.Lock()
-			if !ch.closed {
-				ch.ch <- value
+			if _, err := ch.Send(toShared(value), concurrency.Forever); err != nil {
+				return nil, err
 			}
-			ch.mu.Unlock()
 			vm.push(nil)
 			
 		case bytecode.OpChannelRecv:
 			ch := vm.pop().(*Channel)
-			val, ok := <-ch.ch
-			if !ok {
-				vm.push(nil)
-			} else {
-				vm.push(val)
-			}
+			val, _ := ch.Recv(concurrency.Forever)
+			vm.push(fromShared(val))
 			
 		default:
 			return nil, fmt.Errorf("unknown opcode: %d", instruction)
@@ -1106,6 +1278,10 @@ func (vm *EnhancedVM) performAdd(a, b Value) Value {
 			newElements = append(newElements, barr.Elements...)
 			return &Array{Elements: newElements}
 		}
+	case *Bytes:
+		if bb, ok := b.(*Bytes); ok {
+			return &Bytes{Data: append(a.Data[:len(a.Data):len(a.Data)], bb.Data...)}
+		}
 	}
 	// Default: try string concatenation if either operand is a string
 	if _, ok := a.(string); ok {
@@ -1234,6 +1410,14 @@ func (vm *EnhancedVM) valuesEqual(a, b Value) bool {
 	if a == nil || b == nil {
 		return false
 	}
+	// Strings are *String from the main chunk's constants but plain strings
+	// from a function's, so a parameter may be either
+	if s, ok := a.(*String); ok {
+		a = s.Value
+	}
+	if s, ok := b.(*String); ok {
+		b = s.Value
+	}
 	
 	switch a := a.(type) {
 	case bool:
@@ -1252,10 +1436,6 @@ func (vm *EnhancedVM) valuesEqual(a, b Value) bool {
 		if bs, ok := b.(string); ok {
 			return a == bs
 		}
-	case *String:
-		if bs, ok := b.(*String); ok {
-			return a.Value == bs.Value
-		}
 	case *Array:
 		if barr, ok := b.(*Array); ok {
 			if len(a.Elements) != len(barr.Elements) {
@@ -1268,6 +1448,10 @@ func (vm *EnhancedVM) valuesEqual(a, b Value) bool {
 			}
 			return true
 		}
+	case *Bytes:
+		if bb, ok := b.(*Bytes); ok {
+			return bytes.Equal(a.Data, bb.Data)
+		}
 	}
 	return false
 }
@@ -1312,9 +1496,8 @@ func (vm *EnhancedVM) performSetIndex(collection, index, value Value) {
 	case *Array:
 		idx := int(vm.toNumber(index))
 		if idx >= 0 && idx < len(c.Elements) {
-			// Create a defensive copy of the value to avoid reference issues
-			// This fixes the array corruption in nested loops
-			c.Elements[idx] = vm.copyValue(value)
+			c.own()
+			c.Elements[idx] = value
 		} else {
 			// Handle out of bounds more gracefully
 			vm.runtimeError(fmt.Sprintf("Array index out of bounds: %d (array length: %d)", idx, len(c.Elements)))
@@ -1322,29 +1505,15 @@ func (vm *EnhancedVM) performSetIndex(collection, index, value Value) {
 	case *Map:
 		key := ToString(index)
 		c.mu.Lock()
-		c.Items[key] = vm.copyValue(value)
+		c.own()
+		c.Items[key] = value
 		c.mu.Unlock()
 	}
 }
 
-// copyValue creates a defensive copy of a value to avoid reference issues
-func (vm *EnhancedVM) copyValue(value Value) Value {
-	// For primitive types, return as-is
-	switch v := value.(type) {
-	case float64, int, bool, string, nil:
-		return value
-	case *String:
-		// Strings are immutable, safe to return
-		return value
-	default:
-		// For other types, return as-is for now
-		// Could implement deep copy if needed
-		return v
-	}
-}
-
-// Function call handling
-func (vm *EnhancedVM) performCall(argCount int) {
+// Function call handling. The error is a call nested too deep for the
+// frame stack that the script did not catch.
+func (vm *EnhancedVM) performCall(argCount int) error {
 	// The compiler pushes args first, then the function
 	// So the function is at stackTop-1, and args are at stackTop-argCount-1 to stackTop-2
 	callee := vm.stack[vm.stackTop-1]
@@ -1381,8 +1550,16 @@ func (vm *EnhancedVM) performCall(argCount int) {
 		}
 		
 	case *Function:
-		if fn.Arity != argCount && !fn.IsVariadic {
+		switch {
+		case fn.Optional == 0 && !fn.IsVariadic && argCount != fn.Arity:
 			panic(fmt.Sprintf("expected %d arguments but got %d", fn.Arity, argCount))
+		case argCount < fn.Arity-fn.Optional:
+			panic(fmt.Sprintf("expected at least %d arguments but got %d", fn.Arity-fn.Optional, argCount))
+		case argCount > fn.Arity && !fn.IsVariadic:
+			panic(fmt.Sprintf("expected at most %d arguments but got %d", fn.Arity, argCount))
+		}
+		if vm.frameCount >= vm.maxFrames {
+			return vm.callTooDeep(fn.Name)
 		}
 		
 		// Remove the function from stack
@@ -1406,17 +1583,21 @@ func (vm *EnhancedVM) performCall(argCount int) {
 			}
 		}
 		
-		// Set up new frame - args are already on the stack
-		if vm.frameCount >= vm.maxFrames {
-			panic("call stack overflow")
-		}
-		
-		// Create new frame with local storage
-		newLocals := make([]Value, 256) // Pre-allocate locals
+		// Create new frame with local storage - args are already on the stack
+		newLocals := vm.frameLocals(max(fn.Locals, argCount))
 		// Copy arguments from stack to locals
 		for i := 0; i < argCount; i++ {
 			newLocals[i] = vm.stack[vm.stackTop - argCount + i]
 		}
+		if fn.IsVariadic {
+			// The last parameter collects the remaining arguments
+			rest := &Array{Elements: []Value{}}
+			for i := fn.Arity - 1; i < argCount; i++ {
+				rest.Elements = append(rest.Elements, newLocals[i])
+				newLocals[i] = nil
+			}
+			newLocals[fn.Arity-1] = rest
+		}
 		
 		vm.frames[vm.frameCount] = EnhancedCallFrame{
 			ip:            0,
@@ -1447,14 +1628,14 @@ func (vm *EnhancedVM) performCall(argCount int) {
 	case *compiler.Function:
 		// Legacy function support
 		if vm.frameCount >= vm.maxFrames {
-			panic("call stack overflow")
+			return vm.callTooDeep(fn.Name)
 		}
 		
 		// Remove the function from stack
 		vm.stackTop--
 		
 		// Create new frame with local storage
-		newLocals := make([]Value, 256) // Pre-allocate locals
+		newLocals := vm.frameLocals(max(fn.Locals, argCount))
 		// Copy arguments from stack to locals
 		for i := 0; i < argCount; i++ {
 			newLocals[i] = vm.stack[vm.stackTop - argCount + i]
@@ -1473,6 +1654,25 @@ func (vm *EnhancedVM) performCall(argCount int) {
 	default:
 		panic("attempt to call non-function")
 	}
+	return nil
+}
+
+// frameLocals returns size cleared locals for the frame about to be pushed,
+// growing the frame stack if it is full. A frame slot keeps the locals of
+// the last call made at its depth, so repeated and recursive calls reuse
+// them instead of allocating for every call.
+func (vm *EnhancedVM) frameLocals(size int) []Value {
+	if vm.frameCount == len(vm.frames) {
+		vm.frames = append(vm.frames, EnhancedCallFrame{})
+	}
+	locals := vm.frames[vm.frameCount].locals
+	if cap(locals) < size {
+		return make([]Value, size)
+	}
+	// Clear past size too, so values of an earlier call are not kept alive
+	locals = locals[:cap(locals)]
+	clear(locals)
+	return locals[:size]
 }
 
 // Module loading
@@ -1630,6 +1830,7 @@ func (vm *EnhancedVM) loadModule(name string) Value {
 					return nil, fmt.Errorf("sort expects an array")
 				}
 				// Sort in place
+				arr.own()
 				sort.Slice(arr.Elements, func(i, j int) bool {
 					return ToNumber(arr.Elements[i]) < ToNumber(arr.Elements[j])
 				})
@@ -1648,6 +1849,7 @@ func (vm *EnhancedVM) loadModule(name string) Value {
 					return nil, fmt.Errorf("reverse expects an array")
 				}
 				// Reverse in place
+				arr.own()
 				for i, j := 0, len(arr.Elements)-1; i < j; i, j = i+1, j-1 {
 					arr.Elements[i], arr.Elements[j] = arr.Elements[j], arr.Elements[i]
 				}
@@ -1820,6 +2022,50 @@ func (vm *EnhancedVM) spawnGoroutine(fn Value) {
 	}()
 }
 
+// bindKeywords orders the arguments of a call whose last len(names)
+// arguments are keyword arguments: each goes to the position of the
+// callee's parameter of that name, after the positional ones. Parameters
+// left out in between are null.
+func bindKeywords(fn Value, args []Value, names []string) ([]Value, error) {
+	var name string
+	var params []string
+	variadic := false
+	switch f := fn.(type) {
+	case *Function:
+		name, params, variadic = f.Name, f.Params, f.IsVariadic
+	case *NativeFunction:
+		if f.Params == nil {
+			return nil, fmt.Errorf("%s does not take keyword arguments", f.Name)
+		}
+		name, params = f.Name, f.Params
+	default:
+		return nil, fmt.Errorf("cannot call %s with keyword arguments", ValueType(fn))
+	}
+
+	positional := len(args) - len(names)
+	bound := append([]Value(nil), args[:positional]...)
+	for i, kw := range names {
+		pos := -1
+		for j, param := range params {
+			if param == kw {
+				pos = j
+				break
+			}
+		}
+		if pos < 0 || variadic && pos == len(params)-1 {
+			return nil, fmt.Errorf("%s has no parameter named '%s'", name, kw)
+		}
+		if pos < positional {
+			return nil, fmt.Errorf("%s got argument '%s' twice", name, kw)
+		}
+		for len(bound) <= pos {
+			bound = append(bound, nil)
+		}
+		bound[pos] = args[positional+i]
+	}
+	return bound, nil
+}
+
 // Type conversion helpers
 func (vm *EnhancedVM) toNumber(val Value) float64 {
 	switch v := val.(type) {
@@ -1891,6 +2137,20 @@ func convertToVMValue(v interface{}) Value {
 	}
 }
 
+// Builtins returns the native functions of a new stack VM by name. None
+// of them depend on the VM itself, which lets the register VM reuse the
+// ones it has no implementation of.
+func Builtins() map[string]*NativeFunction {
+	vm := NewVM(bytecode.NewChunk())
+	builtins := make(map[string]*NativeFunction, len(vm.globalMap))
+	for name, index := range vm.globalMap {
+		if fn, ok := vm.globals[index].(*NativeFunction); ok {
+			builtins[name] = fn
+		}
+	}
+	return builtins
+}
+
 // registerBuiltins registers all built-in functions
 func (vm *EnhancedVM) registerBuiltins() {
 	secMod := security.NewSecurityModule()
@@ -1961,6 +2221,8 @@ func (vm *EnhancedVM) registerBuiltins() {
 					return float64(len(v)), nil
 				case *String:
 					return float64(len(v.Value)), nil
+				case *Bytes:
+					return float64(len(v.Data)), nil
 				case *siem.Array:
 					return float64(len(v.Elements)), nil
 				case *siem.Map:
@@ -2033,33 +2295,32 @@ func (vm *EnhancedVM) registerBuiltins() {
 		},
 		"slice": {
 			Name:  "slice",
-			Arity: 2,
+			Arity: -1,
 			Function: func(args []Value) (Value, error) {
-				if len(args) != 2 {
-					return nil, fmt.Errorf("slice expects 2 arguments")
+				if len(args) < 2 || len(args) > 3 {
+					return nil, fmt.Errorf("slice expects 2 or 3 arguments")
 				}
 				
-				var str string
-				switch v := args[0].(type) {
-				case string:
-					str = v
-				case *String:
-					str = v.Value
-				default:
-					return nil, fmt.Errorf("slice expects string as first argument")
+				var end Value
+				if len(args) == 3 {
+					end = args[2]
 				}
-				
-				start, ok := args[1].(float64)
-				if !ok {
-					return nil, fmt.Errorf("slice expects number as second argument")
+				return Slice(args[0], args[1], end)
+			},
+		},
+		"bytes": {
+			Name:   "bytes",
+			Arity:  -1,
+			Params: []string{"value", "encoding"},
+			Function: func(args []Value) (Value, error) {
+				if len(args) < 1 || len(args) > 2 {
+					return nil, fmt.Errorf("bytes expects 1 or 2 arguments")
 				}
-				
-				idx := int(start)
-				if idx < 0 || idx >= len(str) {
-					return "", nil
+				encoding := ""
+				if len(args) == 2 && args[1] != nil {
+					encoding = ToString(args[1])
 				}
-				
-				return str[idx:], nil
+				return ToBytes(args[0], encoding)
 			},
 		},
 		"contains": {
@@ -2134,31 +2395,6 @@ func (vm *EnhancedVM) registerBuiltins() {
 				}
 			},
 		},
-		"char_code": {
-			Name:  "char_code",
-			Arity: 1,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 1 {
-					return nil, fmt.Errorf("char_code expects 1 argument")
-				}
-				
-				var str string
-				switch v := args[0].(type) {
-				case string:
-					str = v
-				case *String:
-					str = v.Value
-				default:
-					return nil, fmt.Errorf("char_code expects string as argument")
-				}
-				
-				if len(str) == 0 {
-					return float64(0), nil
-				}
-				
-				return float64(str[0]), nil
-			},
-		},
 		// DateTime functions
 		"now": {
 			Name:  "now",
@@ -2184,139 +2420,6 @@ func (vm *EnhancedVM) registerBuiltins() {
 				return t.Format("2006-01-02 15:04:05"), nil
 			},
 		},
-		"date_format": {
-			Name:  "date_format",
-			Arity: 2,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 2 {
-					return nil, fmt.Errorf("date_format expects 2 arguments")
-				}
-				
-				timestamp, ok := args[0].(float64)
-				if !ok {
-					return nil, fmt.Errorf("date_format expects number as first argument")
-				}
-				
-				format, ok := args[1].(string)
-				if !ok {
-					return nil, fmt.Errorf("date_format expects string as second argument")
-				}
-				
-				t := time.Unix(int64(timestamp), 0)
-				return t.Format(format), nil
-			},
-		},
-		"parse_date": {
-			Name:  "parse_date",
-			Arity: 2,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 2 {
-					return nil, fmt.Errorf("parse_date expects 2 arguments")
-				}
-				
-				dateStr, ok := args[0].(string)
-				if !ok {
-					return nil, fmt.Errorf("parse_date expects string as first argument")
-				}
-				
-				format, ok := args[1].(string)
-				if !ok {
-					return nil, fmt.Errorf("parse_date expects string as second argument")
-				}
-				
-				t, err := time.Parse(format, dateStr)
-				if err != nil {
-					return nil, fmt.Errorf("parse_date error: %v", err)
-				}
-				
-				return float64(t.Unix()), nil
-			},
-		},
-		"date_add": {
-			Name:  "date_add",
-			Arity: 3,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 3 {
-					return nil, fmt.Errorf("date_add expects 3 arguments")
-				}
-				
-				timestamp, ok := args[0].(float64)
-				if !ok {
-					return nil, fmt.Errorf("date_add expects number as first argument")
-				}
-				
-				amount, ok := args[1].(float64)
-				if !ok {
-					return nil, fmt.Errorf("date_add expects number as second argument")
-				}
-				
-				unit, ok := args[2].(string)
-				if !ok {
-					return nil, fmt.Errorf("date_add expects string as third argument")
-				}
-				
-				t := time.Unix(int64(timestamp), 0)
-				switch unit {
-				case "seconds":
-					t = t.Add(time.Duration(amount) * time.Second)
-				case "minutes":
-					t = t.Add(time.Duration(amount) * time.Minute)
-				case "hours":
-					t = t.Add(time.Duration(amount) * time.Hour)
-				case "days":
-					t = t.AddDate(0, 0, int(amount))
-				case "months":
-					t = t.AddDate(0, int(amount), 0)
-				case "years":
-					t = t.AddDate(int(amount), 0, 0)
-				default:
-					return nil, fmt.Errorf("date_add: unknown unit '%s'", unit)
-				}
-				
-				return float64(t.Unix()), nil
-			},
-		},
-		"date_diff": {
-			Name:  "date_diff",
-			Arity: 3,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 3 {
-					return nil, fmt.Errorf("date_diff expects 3 arguments")
-				}
-				
-				timestamp1, ok := args[0].(float64)
-				if !ok {
-					return nil, fmt.Errorf("date_diff expects number as first argument")
-				}
-				
-				timestamp2, ok := args[1].(float64)
-				if !ok {
-					return nil, fmt.Errorf("date_diff expects number as second argument")
-				}
-				
-				unit, ok := args[2].(string)
-				if !ok {
-					return nil, fmt.Errorf("date_diff expects string as third argument")
-				}
-				
-				t1 := time.Unix(int64(timestamp1), 0)
-				t2 := time.Unix(int64(timestamp2), 0)
-				diff := t2.Sub(t1)
-				
-				switch unit {
-				case "seconds":
-					return diff.Seconds(), nil
-				case "minutes":
-					return diff.Minutes(), nil
-				case "hours":
-					return diff.Hours(), nil
-				case "days":
-					return diff.Hours() / 24, nil
-				default:
-					return nil, fmt.Errorf("date_diff: unknown unit '%s'", unit)
-				}
-			},
-		},
 		// Security functions
 		"sha256": {
 			Name:  "sha256", 
@@ -2377,30 +2480,6 @@ func (vm *EnhancedVM) registerBuiltins() {
 				return decoded, nil
 			},
 		},
-		"starts_with": {
-			Name:  "starts_with",
-			Arity: 2,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 2 {
-					return nil, fmt.Errorf("starts_with expects 2 arguments")
-				}
-				text := ToString(args[0])
-				prefix := ToString(args[1])
-				return strings.HasPrefix(text, prefix), nil
-			},
-		},
-		"ends_with": {
-			Name:  "ends_with",
-			Arity: 2,
-			Function: func(args []Value) (Value, error) {
-				if len(args) != 2 {
-					return nil, fmt.Errorf("ends_with expects 2 arguments")
-				}
-				text := ToString(args[0])
-				suffix := ToString(args[1])
-				return strings.HasSuffix(text, suffix), nil
-			},
-		},
 		"match": {
 			Name:  "match",
 			Arity: 2,
@@ -2647,6 +2726,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				if !ok {
 					return nil, fmt.Errorf("push expects an array")
 				}
+				arr.own()
 				arr.Elements = append(arr.Elements, args[1])
 				return arr, nil
 			},
@@ -2663,6 +2743,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 					return nil, nil
 				}
 				val := arr.Elements[len(arr.Elements)-1]
+				arr.own()
 				arr.Elements = arr.Elements[:len(arr.Elements)-1]
 				return val, nil
 			},
@@ -2675,6 +2756,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				if !ok {
 					return nil, fmt.Errorf("reverse expects an array")
 				}
+				arr.own()
 				for i, j := 0, len(arr.Elements)-1; i < j; i, j = i+1, j-1 {
 					arr.Elements[i], arr.Elements[j] = arr.Elements[j], arr.Elements[i]
 				}
@@ -2693,6 +2775,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 					return nil, nil
 				}
 				val := arr.Elements[0]
+				arr.own()
 				arr.Elements = arr.Elements[1:]
 				return val, nil
 			},
@@ -2705,6 +2788,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				if !ok {
 					return nil, fmt.Errorf("unshift expects an array")
 				}
+				arr.own()
 				arr.Elements = append([]Value{args[1]}, arr.Elements...)
 				return arr, nil
 			},
@@ -2863,6 +2947,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				}
 				
 				val := arr.Elements[index]
+				arr.own()
 				arr.Elements = append(arr.Elements[:index], arr.Elements[index+1:]...)
 				return val, nil
 			},
@@ -2885,6 +2970,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				}
 				
 				// Insert value at index
+				arr.own()
 				arr.Elements = append(arr.Elements[:index], 
 					append([]Value{args[2]}, arr.Elements[index:]...)...)
 				return arr, nil
@@ -2899,25 +2985,22 @@ func (vm *EnhancedVM) registerBuiltins() {
 					return nil, fmt.Errorf("clear expects an array")
 				}
 				arr.Elements = []Value{}
+				arr.shared = false
 				return arr, nil
 			},
 		},
-		"array_contains": {
-			Name:  "array_contains",
-			Arity: 2,
+		"clone": {
+			Name:  "clone",
+			Arity: 1,
 			Function: func(args []Value) (Value, error) {
-				arr, ok := args[0].(*Array)
-				if !ok {
-					return nil, fmt.Errorf("array_contains expects an array")
-				}
-				
-				searchVal := args[1]
-				for _, elem := range arr.Elements {
-					if valuesEqual(elem, searchVal) {
-						return true, nil
-					}
-				}
-				return false, nil
+				return CloneValue(args[0]), nil
+			},
+		},
+		"deep_clone": {
+			Name:  "deep_clone",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				return DeepClone(args[0]), nil
 			},
 		},
 		"index_of": {
@@ -3134,7 +3217,8 @@ func (vm *EnhancedVM) registerBuiltins() {
 				if err != nil {
 					return nil, err
 				}
-				return string(data), nil
+				// data is the module's shared receive buffer, so copy it
+				return &Bytes{Data: append([]byte(nil), data...)}, nil
 			},
 		},
 		"socket_close": {
@@ -3209,7 +3293,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				hostname := ToString(args[0])
 				recordType := ToString(args[1])
 				
-				results, err := netMod.DNSLookup(hostname, recordType)
+				results, err := netMod.DNSLookup(hostname, recordType, network.DNSOptions{})
 				if err != nil {
 					return nil, err
 				}
@@ -3475,6 +3559,45 @@ func (vm *EnhancedVM) registerBuiltins() {
 			},
 		},
 		
+		// Environment
+		"env": {
+			Name:   "env",
+			Arity:  -1,
+			Params: []string{"name", "default"},
+			Function: func(args []Value) (Value, error) {
+				if len(args) > 2 {
+					return nil, fmt.Errorf("env expects 0 to 2 arguments")
+				}
+				if len(args) == 0 {
+					m := NewMap()
+					for _, kv := range os.Environ() {
+						if name, value, ok := strings.Cut(kv, "="); ok {
+							m.Items[name] = NewString(value)
+						}
+					}
+					return m, nil
+				}
+				if value, ok := os.LookupEnv(ToString(args[0])); ok {
+					return NewString(value), nil
+				}
+				if len(args) == 2 {
+					return args[1], nil
+				}
+				return nil, nil
+			},
+		},
+		"set_env": {
+			Name:  "set_env",
+			Arity: 2,
+			Function: func(args []Value) (Value, error) {
+				name := ToString(args[0])
+				if args[1] == nil {
+					return nil, os.Unsetenv(name)
+				}
+				return nil, os.Setenv(name, ToString(args[1]))
+			},
+		},
+
 		// OS Security functions
 		"os_processes": {
 			Name:  "os_processes",
@@ -3663,6 +3786,74 @@ func (vm *EnhancedVM) registerBuiltins() {
 				return resultMap, nil
 			},
 		},
+		"file_open": {
+			Name:  "file_open",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				return fsMod.Open(ToString(args[0]))
+			},
+		},
+		"file_read_line": {
+			Name:  "file_read_line",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				line, ok, err := fsMod.ReadLine(ToString(args[0]))
+				if err != nil || !ok {
+					return nil, err
+				}
+				return NewString(line), nil
+			},
+		},
+		"file_read_chunk": {
+			Name:  "file_read_chunk",
+			Arity: 2,
+			Function: func(args []Value) (Value, error) {
+				data, err := fsMod.ReadChunk(ToString(args[0]), int(ToNumber(args[1])))
+				if err != nil || data == nil {
+					return nil, err
+				}
+				return &Bytes{Data: data}, nil
+			},
+		},
+		"file_close": {
+			Name:  "file_close",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				err := fsMod.Close(ToString(args[0]))
+				return err == nil, err
+			},
+		},
+		"file_lines": {
+			Name:  "file_lines",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				handle, err := fsMod.Open(ToString(args[0]))
+				if err != nil {
+					return nil, err
+				}
+				// The file is closed when the loop reads its last line or is
+				// left early with break
+				open := true
+				closeFile := func() {
+					if open {
+						open = false
+						fsMod.Close(handle)
+					}
+				}
+				next := func() (Value, bool, error) {
+					if !open {
+						return nil, false, nil
+					}
+					line, ok, err := fsMod.ReadLine(handle)
+					if err != nil || !ok {
+						closeFile()
+						return nil, false, err
+					}
+					return NewString(line), true, nil
+				}
+				return &Iterator{Next: next, Close: closeFile}, nil
+			},
+		},
 		"fs_calculate_hash": {
 			Name:  "fs_calculate_hash",
 			Arity: 2,
@@ -3835,8 +4026,9 @@ func (vm *EnhancedVM) registerBuiltins() {
 			},
 		},
 		"db_connect": {
-			Name:  "db_connect",
-			Arity: 6,
+			Name:   "db_connect",
+			Arity:  6,
+			Params: []string{"id", "type", "host", "port", "database", "username"},
 			Function: func(args []Value) (Value, error) {
 				if len(args) != 6 {
 					return nil, fmt.Errorf("db_connect expects 6 arguments")
@@ -4077,6 +4269,10 @@ func (vm *EnhancedVM) registerBuiltins() {
 				}
 				poolID := ToString(args[0])
 				jobID := ToString(args[1])
+				switch args[2].(type) {
+				case *Function, *Closure:
+					return nil, fmt.Errorf("conc_submit_job: function handlers need the register VM")
+				}
 				jobType := ToString(args[2])
 				data := args[3]
 				
@@ -4092,6 +4288,24 @@ func (vm *EnhancedVM) registerBuiltins() {
 				return err == nil, err
 			},
 		},
+		"conc_job_result": {
+			Name:  "conc_job_result",
+			Arity: -1,
+			Function: func(args []Value) (Value, error) {
+				if len(args) < 1 || len(args) > 2 {
+					return nil, fmt.Errorf("conc_job_result expects 1 or 2 arguments")
+				}
+				var wait time.Duration
+				if len(args) == 2 {
+					wait = time.Duration(ToNumber(args[1]) * float64(time.Millisecond))
+				}
+				result, ok, err := concMod.NextResult(ToString(args[0]), wait)
+				if err != nil || !ok {
+					return nil, err
+				}
+				return convertToVMValue(result.Map()), nil
+			},
+		},
 		"conc_create_rate_limiter": {
 			Name:  "conc_create_rate_limiter",
 			Arity: 3,
@@ -4836,75 +5050,7 @@ func (vm *EnhancedVM) registerBuiltins() {
 				if err != nil {
 					return nil, err
 				}
-				
-				// Convert to VM-compatible map
-				resultMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-				resultMap.Items["image_id"] = result.ImageID
-				resultMap.Items["image_name"] = result.ImageName
-				resultMap.Items["scan_time"] = result.ScanTime.Format(time.RFC3339)
-				resultMap.Items["risk_score"] = float64(result.RiskScore)
-				
-				// Convert vulnerabilities
-				vulns := make([]Value, len(result.Vulnerabilities))
-				for i, vuln := range result.Vulnerabilities {
-					vulnMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-					vulnMap.Items["id"] = vuln.ID
-					vulnMap.Items["package"] = vuln.Package
-					vulnMap.Items["version"] = vuln.Version
-					vulnMap.Items["severity"] = vuln.Severity
-					vulnMap.Items["description"] = vuln.Description
-					vulnMap.Items["cvss_score"] = vuln.CVSSScore
-					vulns[i] = vulnMap
-				}
-				resultMap.Items["vulnerabilities"] = &Array{Elements: vulns}
-				
-				// Convert compliance issues
-				compliance := make([]Value, len(result.ComplianceIssues))
-				for i, issue := range result.ComplianceIssues {
-					issueMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-					issueMap.Items["rule_id"] = issue.RuleID
-					issueMap.Items["category"] = issue.Category
-					issueMap.Items["severity"] = issue.Severity
-					issueMap.Items["description"] = issue.Description
-					issueMap.Items["remediation"] = issue.Remediation
-					compliance[i] = issueMap
-				}
-				resultMap.Items["compliance_issues"] = &Array{Elements: compliance}
-				
-				// Convert secrets
-				secrets := make([]Value, len(result.Secrets))
-				for i, secret := range result.Secrets {
-					secretMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-					secretMap.Items["type"] = secret.Type
-					secretMap.Items["file"] = secret.File
-					secretMap.Items["line"] = float64(secret.Line)
-					secretMap.Items["severity"] = secret.Severity
-					secrets[i] = secretMap
-				}
-				resultMap.Items["secrets"] = &Array{Elements: secrets}
-				
-				// Convert malware
-				malware := make([]Value, len(result.Malware))
-				for i, mal := range result.Malware {
-					malMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-					malMap.Items["name"] = mal.Name
-					malMap.Items["type"] = mal.Type
-					malMap.Items["file"] = mal.File
-					malMap.Items["severity"] = mal.Severity
-					malware[i] = malMap
-				}
-				resultMap.Items["malware"] = &Array{Elements: malware}
-				
-				// Add summary
-				summaryMap := &Map{Items: make(map[string]Value), mu: sync.RWMutex{}}
-				summaryMap.Items["total_vulnerabilities"] = float64(result.Summary.TotalVulnerabilities)
-				summaryMap.Items["total_secrets"] = float64(result.Summary.TotalSecrets)
-				summaryMap.Items["total_malware"] = float64(result.Summary.TotalMalware)
-				summaryMap.Items["compliance_score"] = result.Summary.ComplianceScore
-				summaryMap.Items["passed"] = result.Summary.Passed
-				resultMap.Items["summary"] = summaryMap
-				
-				return resultMap, nil
+				return convertToVMValue(container.ScanResultToMap(result)), nil
 			},
 		},
 		"container_scan_dockerfile": {
@@ -5102,6 +5248,22 @@ func (vm *EnhancedVM) registerBuiltins() {
 				return result, nil
 			},
 		},
+		"cloud_resources": {
+			Name:  "cloud_resources",
+			Arity: 1,
+			Function: func(args []Value) (Value, error) {
+				resources, err := cloud.CloudResources(cloudMod, ToString(args[0]))
+				if err != nil {
+					return nil, err
+				}
+				
+				result := &Array{Elements: []Value{}}
+				for _, r := range resources {
+					result.Elements = append(result.Elements, convertToVMValue(r))
+				}
+				return result, nil
+			},
+		},
 		"cloud_resolve_finding": {
 			Name:  "cloud_resolve_finding",
 			Arity: 1,
@@ -5163,10 +5325,10 @@ func (vm *EnhancedVM) registerBuiltins() {
 			Name:  "cloud_benchmark_run",
 			Arity: 2,
 			Function: func(args []Value) (Value, error) {
-				provider := ToString(args[0])
-				benchmark := ToString(args[1])
-				
-				benchmarkResult := cloud.CloudBenchmarkRun(provider, benchmark)
+				benchmarkResult, err := cloud.CloudBenchmarkRun(cloudMod, ToString(args[0]), ToString(args[1]))
+				if err != nil {
+					return nil, err
+				}
 				
 				// Convert to VM map
 				result := &Map{Items: make(map[string]Value)}
@@ -6023,8 +6185,9 @@ func (vm *EnhancedVM) registerBuiltins() {
 	// Database Security functions
 	dbBuiltins := map[string]*NativeFunction{
 		"db_connect": {
-			Name:  "db_connect",
-			Arity: 3,
+			Name:   "db_connect",
+			Arity:  3,
+			Params: []string{"type", "host", "conn_string"},
 			Function: func(args []Value) (Value, error) {
 				dbType := ToString(args[0])
 				host := ToString(args[1])
@@ -6049,33 +6212,11 @@ func (vm *EnhancedVM) registerBuiltins() {
 			Function: func(args []Value) (Value, error) {
 				connId := ToString(args[0])
 				
-				result := NewMap()
-				result.Items["connection_id"] = connId
-				result.Items["scan_time"] = time.Now().Format("2006-01-02 15:04:05")
-				
-				// Security findings
-				findings := NewArray(0)
-				
-				finding1 := NewMap()
-				finding1.Items["id"] = "DB-001" 
-				finding1.Items["severity"] = "HIGH"
-				finding1.Items["category"] = "authentication"
-				finding1.Items["description"] = "Weak password policy detected"
-				finding1.Items["remediation"] = "Implement strong password requirements"
-				findings.Elements = append(findings.Elements, finding1)
-				
-				finding2 := NewMap()
-				finding2.Items["id"] = "DB-002"
-				finding2.Items["severity"] = "MEDIUM" 
-				finding2.Items["category"] = "privileges"
-				finding2.Items["description"] = "Excessive privileges for application user"
-				finding2.Items["remediation"] = "Apply principle of least privilege"
-				findings.Elements = append(findings.Elements, finding2)
-				
-				result.Items["findings"] = findings
-				result.Items["risk_score"] = 75.5
-				
-				return result, nil
+				report, err := dbMod.AuditConnection(connId)
+				if err != nil {
+					return nil, err
+				}
+				return convertToVMValue(database.SecurityScanToMap(connId, report)), nil
 			},
 		},
 		"db_test_injection": {
@@ -6116,38 +6257,11 @@ func (vm *EnhancedVM) registerBuiltins() {
 			Function: func(args []Value) (Value, error) {
 				connId := ToString(args[0])
 				
-				result := NewMap()
-				result.Items["connection_id"] = connId
-				result.Items["audit_time"] = time.Now().Format("2006-01-02 15:04:05")
-				
-				// Mock privilege audit results
-				users := NewArray(0)
-				
-				user1 := NewMap()
-				user1.Items["username"] = "app_user"
-				user1.Items["host"] = "localhost" 
-				user1.Items["privileges"] = NewArray(0)
-				privs1 := user1.Items["privileges"].(*Array)
-				privs1.Elements = append(privs1.Elements, "SELECT")
-				privs1.Elements = append(privs1.Elements, "INSERT")
-				privs1.Elements = append(privs1.Elements, "UPDATE")
-				user1.Items["risk_level"] = "LOW"
-				users.Elements = append(users.Elements, user1)
-				
-				user2 := NewMap()
-				user2.Items["username"] = "admin_user"
-				user2.Items["host"] = "%"
-				user2.Items["privileges"] = NewArray(0)
-				privs2 := user2.Items["privileges"].(*Array)
-				privs2.Elements = append(privs2.Elements, "ALL PRIVILEGES")
-				user2.Items["risk_level"] = "HIGH"
-				users.Elements = append(users.Elements, user2)
-				
-				result.Items["users"] = users
-				result.Items["total_users"] = len(users.Elements)
-				result.Items["high_risk_users"] = 1
-				
-				return result, nil
+				report, err := dbMod.AuditConnection(connId)
+				if err != nil {
+					return nil, err
+				}
+				return convertToVMValue(database.PrivilegeAuditToMap(connId, report)), nil
 			},
 		},
 		"db_check_encryption": {
@@ -6156,31 +6270,11 @@ func (vm *EnhancedVM) registerBuiltins() {
 			Function: func(args []Value) (Value, error) {
 				connId := ToString(args[0])
 				
-				result := NewMap()
-				result.Items["connection_id"] = connId
-				result.Items["check_time"] = time.Now().Format("2006-01-02 15:04:05")
-				
-				// Mock encryption status
-				result.Items["ssl_connection"] = true
-				result.Items["tls_version"] = "TLSv1.2"
-				result.Items["data_at_rest_encrypted"] = false
-				result.Items["transparent_encryption"] = false
-				
-				// Encryption findings
-				issues := NewArray(0)
-				
-				if !ToBool(result.Items["data_at_rest_encrypted"]) {
-					issue := NewMap()
-					issue.Items["type"] = "encryption"
-					issue.Items["severity"] = "HIGH"
-					issue.Items["message"] = "Data at rest encryption not enabled"
-					issues.Elements = append(issues.Elements, issue)
+				report, err := dbMod.AuditConnection(connId)
+				if err != nil {
+					return nil, err
 				}
-				
-				result.Items["issues"] = issues
-				result.Items["compliance_score"] = 60.0
-				
-				return result, nil
+				return convertToVMValue(database.EncryptionCheckToMap(connId, report)), nil
 			},
 		},
 		"db_backup_security": {
@@ -7911,6 +8005,10 @@ func (vm *EnhancedVM) registerBuiltins() {
 		}
 		vm.globals[idx] = fn
 	}
+	vm.registerSharedBuiltins()
+
+	// The command line arguments after the script name, set by SetArgs
+	vm.SetArgs(nil)
 }
 
 // Reset VM state for REPL
@@ -7934,6 +8032,31 @@ func (vm *EnhancedVM) SetDebugHook(hook DebugHook) {
 	vm.debug = hook != nil
 }
 
+// SetTrace logs every instruction to w before it runs, with the function
+// and source line it belongs to and the call and stack depth, or stops
+// logging if w is nil. Instructions are shown as by compiler.Disassemble.
+func (vm *EnhancedVM) SetTrace(w io.Writer) {
+	vm.trace = w
+}
+
+// traceInstruction logs the next instruction of frame
+func (vm *EnhancedVM) traceInstruction(frame *EnhancedCallFrame) {
+	name := "<script>"
+	switch fn := frame.function.(type) {
+	case *Function:
+		name = fn.Name
+	case *compiler.Function:
+		name = fn.Name
+	}
+	line := ""
+	if l := frame.chunk.GetDebugInfo(frame.ip).Line; l != 0 {
+		line = strconv.Itoa(l)
+	}
+	text, _ := frame.chunk.Instruction(frame.ip)
+	fmt.Fprintf(vm.trace, "%-16s %5s  %04d  %-48s frames=%d stack=%d\n",
+		name, line, frame.ip, text, vm.frameCount, vm.stackTop)
+}
+
 // GetCallStack returns the current call stack for debugging
 func (vm *EnhancedVM) GetCallStack() []map[string]interface{} {
 	stack := make([]map[string]interface{}, 0, vm.frameCount)
@@ -7972,6 +8095,26 @@ func (vm *EnhancedVM) GetGlobalVariable(name string) (Value, bool) {
 	return nil, false
 }
 
+// SetArgs sets the args global to the command line arguments passed to the
+// script after its file name
+func (vm *EnhancedVM) SetArgs(args []string) {
+	arr := NewArray(len(args))
+	for _, arg := range args {
+		arr.Elements = append(arr.Elements, NewString(arg))
+	}
+	idx, exists := vm.globalMap["args"]
+	if !exists {
+		idx = len(vm.globalMap)
+		vm.globalMap["args"] = idx
+	}
+	if idx >= len(vm.globals) {
+		newGlobals := make([]Value, idx+1)
+		copy(newGlobals, vm.globals)
+		vm.globals = newGlobals
+	}
+	vm.globals[idx] = arr
+}
+
 // AddBuiltinFunction adds a builtin function to the VM
 func (vm *EnhancedVM) AddBuiltinFunction(name string, fn *NativeFunction) {
 	idx := len(vm.globalMap)
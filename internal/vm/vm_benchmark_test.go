@@ -136,4 +136,52 @@ func BenchmarkIfStatement(b *testing.B) {
 		vm := NewVM(chunk)
 		vm.Run()
 	}
-}
\ No newline at end of file
+}
+
+// BenchmarkDeepRecursion measures calls that run deep into the frame stack,
+// where every call needs locals of its own
+func BenchmarkDeepRecursion(b *testing.B) {
+	programs := []struct {
+		name, source string
+	}{
+		{"sum", `
+			fn sum(n) {
+				if n == 0 {
+					return 0
+				}
+				let rest = sum(n - 1)
+				return n + rest
+			}
+			let i = 0
+			while i < 20 {
+				sum(900)
+				i = i + 1
+			}
+		`},
+		{"fib", `
+			fn fib(n) {
+				if n < 2 {
+					return n
+				}
+				let a = fib(n - 1)
+				let b = fib(n - 2)
+				return a + b
+			}
+			let result = fib(18)
+		`},
+	}
+	for _, program := range programs {
+		chunk := compileSource(program.source)
+		b.Run(program.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				vm := NewVM(chunk)
+				b.StartTimer()
+				if _, err := vm.Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
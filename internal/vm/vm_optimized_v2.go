@@ -182,7 +182,9 @@ func (vm *OptimizedVM) optimizedPerformCall(argCount int) {
 		}
 		
 		// Use the original performCall logic for user functions
-		vm.EnhancedVM.performCall(argCount)
+		if err := vm.EnhancedVM.performCall(argCount); err != nil {
+			panic(err)
+		}
 		
 	case *NativeFunction:
 		// Direct builtin function call (already resolved)
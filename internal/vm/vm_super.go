@@ -598,7 +598,9 @@ func (vm *SuperVM) superOptimizedCall(argCount int) {
 		
 	default:
 		// Fall back for other types
-		vm.EnhancedVM.performCall(argCount)
+		if err := vm.EnhancedVM.performCall(argCount); err != nil {
+			panic(err)
+		}
 	}
 }
 
@@ -93,6 +93,7 @@ func (vm *SuperVM) optimizedArrayPush(arr Value, item Value) {
 		a.Elements = append(a.Elements, item)
 		
 	case *Array:
+		a.own()
 		a.Elements = append(a.Elements, item)
 		
 	default:
@@ -1,9 +1,21 @@
 package vm
 
 import (
+	"errors"
 	"math"
+	"os"
+	"path/filepath"
+	"sentra/internal/builtins"
 	"sentra/internal/bytecode"
+	"sentra/internal/compiler"
+	"sentra/internal/lexer"
+	"sentra/internal/limits"
+	"sentra/internal/parser"
+	"sentra/internal/replay"
+	"sentra/internal/sandbox"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Test basic arithmetic operations
@@ -524,6 +536,39 @@ func TestErrorHandling(t *testing.T) {
 			t.Errorf("expected 20 (from catch block), got %v", result)
 		}
 	})
+
+	t.Run("compiled", func(t *testing.T) {
+		// A try block that returns or finishes leaves its handler behind
+		vm := NewVM(compileSource(`
+fn guarded() {
+    try {
+        return "returned"
+    } catch e {
+        return "stale handler"
+    }
+}
+let first = guarded()
+let caught = ""
+try {
+    let x = 1
+} catch e {
+    caught = "stale handler"
+}
+try {
+    throw "boom"
+} catch e {
+    caught = e
+}
+`))
+		if _, err := vm.Run(); err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		for name, want := range map[string]string{"first": "returned", "caught": "Error: boom"} {
+			if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+				t.Errorf("%s: got %q, want %q", name, ToString(v), want)
+			}
+		}
+	})
 }
 
 // Test type operations
@@ -633,4 +678,673 @@ func BenchmarkMapOperations(b *testing.B) {
 		vm := NewVM(chunk)
 		vm.Run()
 	}
-}
\ No newline at end of file
+}
+
+func TestMatchStatement(t *testing.T) {
+	source := `
+let results = []
+for sev in ["critical", "high", "medium", "low", "debug", "other"] {
+    let label = "unset"
+    match sev {
+        "critical" | "high" => label = "page"
+        "medium" => label = "ticket"
+        "low" | "info" | "debug" => label = "log"
+        _ => label = "ignore"
+    }
+    push(results, label)
+}
+let unmatched = "kept"
+match 5 {
+    1 => unmatched = "changed"
+}
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	results, _ := vm.GetGlobalVariable("results")
+	arr, ok := results.(*Array)
+	if !ok {
+		t.Fatalf("expected an array, got %T", results)
+	}
+	want := []string{"page", "page", "ticket", "log", "log", "ignore"}
+	if len(arr.Elements) != len(want) {
+		t.Fatalf("got %v, want %v", arr.Elements, want)
+	}
+	for i, w := range want {
+		if ToString(arr.Elements[i]) != w {
+			t.Errorf("case %d: got %v, want %s", i, arr.Elements[i], w)
+		}
+	}
+
+	if v, _ := vm.GetGlobalVariable("unmatched"); ToString(v) != "kept" {
+		t.Errorf("no arm should run when nothing matches, got %v", v)
+	}
+}
+
+func TestForInKeyValue(t *testing.T) {
+	source := `
+let last_index = null
+let last = null
+for i, x in [5, 6, 7] {
+    last_index = i
+    last = x
+}
+let pair = ""
+for k, v in {"ssh": 22} {
+    pair = k + "=" + str(v)
+}
+let last_char = ""
+for i, c in "abc" {
+    last_char = str(i) + c
+}
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"last_index": "2",
+		"last":       "7",
+		"pair":       "ssh=22",
+		"last_char":  "2c",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	source := `
+let first = null
+for x in [1, 2, 3] {
+    first = x
+    break
+}
+let skipped = "untouched"
+for x in [1, 2, 3] {
+    continue
+    skipped = x
+}
+let inner = null
+let outer_done = "no"
+outer: for x in [1, 2] {
+    for y in [3, 4] {
+        inner = y
+        break outer
+    }
+    outer_done = "yes"
+}
+fn find(items) {
+    for i, item in items {
+        return item
+    }
+    return null
+}
+let found = find(["a", "b"])
+let spins = "none"
+while true {
+    spins = "once"
+    break
+}
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"first":      "1",
+		"skipped":    "untouched",
+		"inner":      "3",
+		"outer_done": "no",
+		"found":      "a",
+		"spins":      "once",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+	for _, source := range []string{"break", "fn f() { continue }", "for x in [1] { break nowhere }", "while true { fn g() { break } }"} {
+		tokens := lexer.NewScanner(source).ScanTokens()
+		stmts := parser.NewParserWithSource(tokens, source, "test").Parse()
+		var program []interface{}
+		for _, s := range stmts {
+			program = append(program, s)
+		}
+		c := compiler.NewStmtCompiler()
+		c.Compile(program)
+		if len(c.Errors) == 0 {
+			t.Errorf("%q: expected a compile error", source)
+		}
+	}
+}
+
+func TestStructsNeedRegisterVM(t *testing.T) {
+	source := `
+let before = 1
+struct Point {
+    x = 0
+    fn norm() { return self.x }
+}
+`
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	hc := compiler.NewHoistingCompiler()
+	hc.CompileWithHoisting(stmts)
+	if len(hc.Errors) != 1 || hc.Errors[0].Error() != "line 3: struct Point: structs require the register VM, not --oldvm" {
+		t.Errorf("got %v", hc.Errors)
+	}
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	source := `
+let result = {"user": {"name": "ann"}}
+let missing = null
+let name = result?.user?.name
+let no_name = missing?.user?.name ?? "anonymous"
+let first = missing?.[0] ?? "empty"
+let kept = false ?? "unused"
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"name":    "ann",
+		"no_name": "anonymous",
+		"first":   "empty",
+		"kept":    "false",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestSlices(t *testing.T) {
+	source := `
+let arr = [1, 2, 3, 4, 5, 6]
+let s = "hello world"
+let middle = arr[1:4]
+let head = arr[:2]
+let word = s[6:]
+let clamped = s[:100]
+let copy = arr[:]
+copy[0] = 99
+let original = arr[0]
+let builtin = slice(s, 0, 4)
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"middle":   "[2, 3, 4]",
+		"head":     "[1, 2]",
+		"word":     "world",
+		"clamped":  "hello world",
+		"original": "1",
+		"builtin":  "hell",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	source := `
+let magic = hex"de ad be ef"
+let kind = type(magic)
+let size = len(magic)
+let first = magic[0]
+let middle = magic[1:3] == hex"adbe"
+let joined = base64_encode(magic + hex"00ff")
+let from_hex = bytes("deadbeef", "hex") == magic
+let from_array = bytes([104, 105]) == bytes("hi")
+let text = "got " + bytes("hi")
+let total = ""
+for b in hex"0102" { total = total + str(b) }
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"kind":       "bytes",
+		"size":       "4",
+		"first":      "222",
+		"middle":     "true",
+		"joined":     "3q2+7wD/",
+		"from_hex":   "true",
+		"from_array": "true",
+		"text":       "got hi",
+		"total":      "12",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestStreamingFileReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	if err := os.WriteFile(path, []byte("one\r\ntwo\nthree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := `
+let path = r"` + path + `"
+let f = file_open(path)
+let lines = ""
+let line = file_read_line(f)
+while line != null {
+    lines = lines + line + ","
+    line = file_read_line(f)
+}
+file_close(f)
+let g = file_open(path)
+let first_chunk = file_read_chunk(g, 6) == bytes("one\r\nt")
+file_close(g)
+let iterated = ""
+for l in file_lines(path) {
+    if l == "three" { break }
+    iterated = iterated + l + ","
+}
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"lines":       "one,two,three,",
+		"first_chunk": "true",
+		"iterated":    "one,two,",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestKeywordArguments(t *testing.T) {
+	source := `
+fn scan(host, port = "443", proto = "tcp") {
+    return host + ":" + port + "/" + proto
+}
+let pair = fn(a, b) => [a, b]
+let skipped = scan("10.0.0.5", proto = "udp")
+let reordered = scan(port = "22", host = "10.0.0.6")
+let lambda = pair(b = 2, a = 1)
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"skipped":   "10.0.0.5:443/udp",
+		"reordered": "10.0.0.6:22/tcp",
+		"lambda":    "[1, 2]",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+
+	vm = NewVM(compileSource(`fn f(a) { return a }
+f(b = 1)`))
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "no parameter named 'b'") {
+		t.Errorf("unknown keyword: got %v", err)
+	}
+}
+
+func TestParameterDefaults(t *testing.T) {
+	source := `
+fn scan(host, port = "443", proto = "tcp") {
+    return host + ":" + port + "/" + proto
+}
+fn log_all(prefix, ...items) {
+    return items
+}
+let defaults = scan("10.0.0.5")
+let some = scan("10.0.0.5", "22")
+let explicit_null = scan("10.0.0.5", null, "udp")
+let no_items = log_all("x")
+let items = log_all("x", 1, 2, 3)
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"defaults":      "10.0.0.5:443/tcp",
+		"some":          "10.0.0.5:22/tcp",
+		"explicit_null": "10.0.0.5:443/udp",
+		"no_items":      "[]",
+		"items":         "[1, 2, 3]",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestEnvAndArgs(t *testing.T) {
+	t.Setenv("SENTRA_TEST_TARGET", "10.0.0.0/24")
+	source := `
+let target = env("SENTRA_TEST_TARGET")
+let fallback = env("SENTRA_TEST_MISSING", "none")
+set_env("SENTRA_TEST_SET", "yes")
+let set = env("SENTRA_TEST_SET")
+let first = args[0]
+let count = len(args)
+`
+	vm := NewVM(compileSource(source))
+	vm.SetArgs([]string{"scanme.example", "--fast"})
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	t.Cleanup(func() { os.Unsetenv("SENTRA_TEST_SET") })
+
+	tests := map[string]string{
+		"target":   "10.0.0.0/24",
+		"fallback": "none",
+		"set":      "yes",
+		"first":    "scanme.example",
+		"count":    "2",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestDisassembleAndTrace(t *testing.T) {
+	source := `fn add(a, b) {
+    return a + b
+}
+let total = add(1, 2)
+if total > 5 {
+    total = "big"
+}
+`
+	p := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "trace.sn")
+	stmts := p.Parse()
+	hc := compiler.NewHoistingCompilerWithDebug("trace.sn")
+	hc.SetLines(p.StmtLines())
+	chunk := hc.CompileWithHoisting(stmts)
+
+	var listing strings.Builder
+	if err := compiler.Disassemble(&listing, chunk); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"function <script> (0 params",
+		"      4  0004  CONSTANT      K2            ; 1",
+		"JUMP_IF_FALSE 5             ; to 0027",
+		`      6  0022  CONSTANT      K8            ; "big"`,
+		">0027  RETURN",
+		"  K8    \"big\"",
+		"function add (2 params, 2 locals",
+		"      2  0000  GET_LOCAL     L0",
+	} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing is missing %q:\n%s", want, listing.String())
+		}
+	}
+
+	var trace strings.Builder
+	vm := NewVM(chunk)
+	vm.SetTrace(&trace)
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if !strings.HasPrefix(lines[0], "<script>") || !strings.HasSuffix(lines[0], "frames=1 stack=0") {
+		t.Errorf("first trace line: %q", lines[0])
+	}
+	inAdd := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "add ") && strings.Contains(line, "  2  0004  ADD") && strings.Contains(line, "frames=2") {
+			inAdd = true
+		}
+	}
+	if !inAdd {
+		t.Errorf("trace does not show line 2 of add in a second frame:\n%s", trace.String())
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	source := `
+let n = 0
+while true {
+    n = n + 1
+}
+`
+	chunk := compiler.NewHoistingCompilerWithDebug("limits.sn").CompileWithHoisting(
+		parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "limits.sn").Parse())
+
+	vm := NewVM(chunk)
+	vm.StartLimits(limits.Limits{MaxInstructions: 1000})
+	_, err := vm.Run()
+	vm.StopLimits()
+	if e, ok := err.(*limits.Error); !ok || e.Resource != limits.Instructions {
+		t.Errorf("instruction limit: got %v", err)
+	}
+
+	vm = NewVM(chunk)
+	vm.StartLimits(limits.Limits{Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	_, err = vm.Run()
+	vm.StopLimits()
+	if err == nil || err.Error() != "timeout of 50ms exceeded" {
+		t.Errorf("timeout: got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ran for %v", elapsed)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	run := func(p *sandbox.Policy, source string) (err error) {
+		t.Helper()
+		// Native errors reach the caller as panics, as in the CLI
+		defer func() {
+			if r := recover(); r != nil {
+				err, _ = r.(error)
+			}
+		}()
+		chunk := compiler.NewHoistingCompilerWithDebug("permissions.sn").CompileWithHoisting(
+			parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "permissions.sn").Parse())
+		vm := NewVM(chunk)
+		vm.SetPermissions(p)
+		_, err = vm.Run()
+		return err
+	}
+
+	p := sandbox.NewPolicy()
+	p.Allow(sandbox.Env, "PATH")
+	if err := run(p, `let path = env("PATH")`); err != nil {
+		t.Errorf("allowed variable: %v", err)
+	}
+	err := run(p, `let home = env("HOME")`)
+	var perr *sandbox.PermissionError
+	if !errors.As(err, &perr) || err.Error() != "env: permission denied: env access to HOME (use --allow-env=HOME)" {
+		t.Errorf("denied variable: got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	run := func(s replay.Session, source string) (err error) {
+		t.Helper()
+		// Native errors reach the caller as panics, as in the CLI
+		defer func() {
+			if r := recover(); r != nil {
+				err, _ = r.(error)
+			}
+		}()
+		chunk := compiler.NewHoistingCompilerWithDebug("replay.sn").CompileWithHoisting(
+			parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "replay.sn").Parse())
+		vm := NewVM(chunk)
+		vm.SetReplay(s)
+		_, err = vm.Run()
+		return err
+	}
+
+	source := `
+let r = random()
+let n = randint(1, 100)
+let now = time()
+`
+	path := filepath.Join(t.TempDir(), "run.json")
+	recorder := replay.NewRecorder("replay.sn", []byte(source), nil)
+	if err := run(recorder, source); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if n := recorder.Calls(); n != 3 {
+		t.Fatalf("recorded %d calls, want 3", n)
+	}
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := replay.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(player, source); err != nil {
+		t.Errorf("replaying: %v", err)
+	}
+	if n := player.Remaining(); n != 0 {
+		t.Errorf("%d calls not replayed", n)
+	}
+
+	player, _ = replay.Load(path)
+	var diverged *replay.DivergenceError
+	if err := run(player, `let n = randint(1, 100)`); !errors.As(err, &diverged) || diverged.Index != 0 {
+		t.Errorf("got %v, want a divergence at the first call", err)
+	}
+}
+
+// Every stack VM builtin must be in the shared registry, and the shared
+// implementations must run on stack VM values.
+func TestBuiltinsInRegistry(t *testing.T) {
+	for name := range Builtins() {
+		if builtins.Lookup(name) == nil {
+			t.Errorf("%s: missing entry in the builtins registry", name)
+		}
+	}
+
+	vm := NewVM(compileSource(`
+let a = starts_with("sentra", "sen")
+let b = array_contains([1, 2, 3], 2)
+let c = char_code("A")
+let d = date_diff(0, date_add(0, 2, "days"), "hours")
+let e = sql_escape("it's")
+`))
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Value{"a": true, "b": true, "c": float64(65), "d": float64(48), "e": "it''s"}
+	for name, expected := range want {
+		got, _ := vm.GetGlobalVariable(name)
+		if got != expected {
+			t.Errorf("%s = %v (%T), want %v", name, got, got, expected)
+		}
+	}
+}
+
+func TestDeepRecursion(t *testing.T) {
+	source := `
+fn sum(n) {
+    if n == 0 {
+        return 0
+    }
+    let rest = sum(n - 1)
+    return n + rest
+}
+fn pair(a, ...rest) {
+    let total = a
+    for x in rest {
+        total = total + x
+    }
+    return total
+}
+let deep = sum(500)
+let again = sum(100)
+let variadic = pair(1, 2, 3, 4)
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"deep":     "125250",
+		"again":    "5050",
+		"variadic": "10",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+}
+
+func TestSelectiveImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.Mkdir("lib", 0755)
+	lib := filepath.Join("lib", "net.sn")
+	os.WriteFile(lib, []byte("export fn scan(host) { return \"scanned \" + host }\nexport let PORT = 22\n"), 0644)
+	source := `
+import {sqrt, PI as pi} from math
+import {scan, PORT} from "` + lib + `"
+let root = sqrt(16)
+let circle = pi > 3
+let result = scan("10.0.0.1") + ":" + PORT
+`
+	vm := NewVM(compileSource(source))
+	if _, err := vm.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	tests := map[string]string{
+		"root":   "4",
+		"circle": "true",
+		"result": "scanned 10.0.0.1:22",
+	}
+	for name, want := range tests {
+		if v, _ := vm.GetGlobalVariable(name); ToString(v) != want {
+			t.Errorf("%s: got %v, want %s", name, ToString(v), want)
+		}
+	}
+
+	// A name the module does not export is an error, not nil
+	for source, want := range map[string]string{
+		"import {nope} from math":                   "module math has no export 'nope'",
+		`import {scan, missing} from "` + lib + `"`: "module " + lib + " has no export 'missing'",
+	} {
+		if _, err := NewVM(compileSource(source)).Run(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", source, err, want)
+		}
+	}
+}
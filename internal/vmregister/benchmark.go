@@ -0,0 +1,254 @@
+package vmregister
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sentra/internal/benchmark"
+	"sentra/internal/cloud"
+)
+
+// registerBenchmarkFunctions registers the compliance benchmark engine.
+// It is the engine of the cloud module, so benchmarks loaded or defined
+// here are also run by cloud_benchmark_run. Controls defined with Sentra
+// check and collect functions belong to this VM.
+func (vm *RegisterVM) registerBenchmarkFunctions() {
+	engine := vm.cloudModule.(*cloud.CSPMModule).Benchmarks()
+
+	// benchmark_run(id, target, options?)
+	vm.registerGlobal("benchmark_run", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "benchmark_run",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("benchmark_run expects 2-3 arguments (id, target, options), got %d", len(args))
+			}
+			var opts benchmark.Options
+			var name, platform string
+			if len(args) > 2 && !IsNil(args[2]) {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("benchmark_run: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "level":
+						opts.Level = int(ToInt(v))
+					case "controls":
+						opts.Controls = stringList(v)
+					case "skip":
+						opts.Skip = stringList(v)
+					case "name":
+						name = ToString(v)
+					case "platform":
+						platform = ToString(v)
+					default:
+						return NilValue(), fmt.Errorf("benchmark_run: unknown option '%s'", key)
+					}
+				}
+			}
+			id := ToString(args[0])
+			target, err := benchmarkTarget(vm, args[1], name, platform)
+			if err != nil {
+				return NilValue(), fmt.Errorf("benchmark_run: %v", err)
+			}
+			if strings.EqualFold(id, "cis") && target.Platform() != "" {
+				id = "cis-" + target.Platform()
+				if target.Platform() != "linux" {
+					id += "-foundations"
+				}
+			}
+			report, err := engine.Run(id, target, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("benchmark_run: %v", err)
+			}
+			return goToValue(benchmark.ReportToMap(report)), nil
+		},
+	})
+
+	// benchmark_load(path)
+	vm.registerGlobal("benchmark_load", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "benchmark_load",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			loaded, err := engine.Load(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("benchmark_load: %v", err)
+			}
+			arr := make([]Value, len(loaded))
+			for i, b := range loaded {
+				arr[i] = goToValue(benchmark.BenchmarkToMap(b))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// benchmark_define(benchmark)
+	vm.registerGlobal("benchmark_define", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "benchmark_define",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			b, err := defineBenchmark(vm, args[0])
+			if err != nil {
+				return NilValue(), fmt.Errorf("benchmark_define: %v", err)
+			}
+			if err := engine.Add(b); err != nil {
+				return NilValue(), fmt.Errorf("benchmark_define: %v", err)
+			}
+			return BoxString(b.ID), nil
+		},
+	})
+
+	// benchmark_list()
+	vm.registerGlobal("benchmark_list", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "benchmark_list",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			list := engine.List()
+			arr := make([]Value, len(list))
+			for i, b := range list {
+				arr[i] = goToValue(benchmark.BenchmarkToMap(b))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+}
+
+// benchmarkTarget reads the target of benchmark_run: "local" for this
+// host, the path of a mounted Linux file system, the name of a cloud
+// provider, or a map of data source names to arrays of items collected
+// by the script
+func benchmarkTarget(vm *RegisterVM, v Value, name, platform string) (benchmark.Target, error) {
+	if IsMap(v) {
+		data := map[string][]benchmark.Item{}
+		for source, items := range AsMap(v).Items {
+			if !IsArray(items) {
+				return nil, fmt.Errorf("data of %s must be an array, got %s", source, ValueType(items))
+			}
+			list, err := benchmarkItems(items)
+			if err != nil {
+				return nil, fmt.Errorf("data of %s: %v", source, err)
+			}
+			data[source] = list
+		}
+		if name == "" {
+			name = "data"
+		}
+		return benchmark.NewDataTarget(name, platform, data), nil
+	}
+	if !IsString(v) {
+		return nil, fmt.Errorf("target must be a string or a map, got %s", ValueType(v))
+	}
+	target := ToString(v)
+	switch {
+	case target == "local":
+		return benchmark.NewHostTarget("/")
+	case strings.Contains(target, "/"):
+		return benchmark.NewHostTarget(target)
+	}
+	return vm.cloudModule.(*cloud.CSPMModule).BenchmarkTarget(target)
+}
+
+// benchmarkItems converts an array of maps to items
+func benchmarkItems(v Value) ([]benchmark.Item, error) {
+	var items []benchmark.Item
+	for _, elem := range AsArray(v).Elements {
+		item, ok := valueToGo(elem).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("items must be maps, got %s", ValueType(elem))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// defineBenchmark builds a benchmark from a Sentra map. Controls take the
+// keys of the JSON files, and a check function in place of expect or a
+// collect function in place of the query's source. A check returns
+// problems as the checks of iac_add_rule do: nil or false when the item
+// passes.
+func defineBenchmark(vm *RegisterVM, v Value) (*benchmark.Benchmark, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("benchmark must be a map, got %s", ValueType(v))
+	}
+	plain := map[string]interface{}{}
+	var checks, collects []Value
+	for key, field := range AsMap(v).Items {
+		if key != "controls" {
+			plain[key] = valueToGo(field)
+			continue
+		}
+		if !IsArray(field) {
+			return nil, fmt.Errorf("controls must be an array, got %s", ValueType(field))
+		}
+		var controls []interface{}
+		for i, cv := range AsArray(field).Elements {
+			if !IsMap(cv) {
+				return nil, fmt.Errorf("control %d must be a map, got %s", i+1, ValueType(cv))
+			}
+			control := map[string]interface{}{}
+			var check, collect Value = NilValue(), NilValue()
+			for ck, cf := range AsMap(cv).Items {
+				switch ck {
+				case "check":
+					check = cf
+				case "collect":
+					collect = cf
+				default:
+					control[ck] = valueToGo(cf)
+				}
+			}
+			for _, fn := range []Value{check, collect} {
+				if !IsNil(fn) && (!IsPointer(fn) || !isCallableType(AsObject(fn).Type)) {
+					return nil, fmt.Errorf("control %d: check and collect must be functions, got %s", i+1, ValueType(fn))
+				}
+			}
+			checks = append(checks, check)
+			collects = append(collects, collect)
+			controls = append(controls, control)
+		}
+		plain["controls"] = controls
+	}
+	data, err := json.Marshal(plain)
+	if err != nil {
+		return nil, err
+	}
+	b, err := benchmark.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	b.Source = "script"
+	for i, c := range b.Controls {
+		if fn := checks[i]; !IsNil(fn) {
+			title := c.Title
+			if title == "" {
+				title = c.ID
+			}
+			c.Check = func(item benchmark.Item) (bool, string, error) {
+				result, err := vm.callValue(fn, []Value{goToValue(item)})
+				if err != nil {
+					return false, "", err
+				}
+				problems := ruleProblems(result, title)
+				return len(problems) == 0, strings.Join(problems, "; "), nil
+			}
+		}
+		if fn := collects[i]; !IsNil(fn) {
+			c.Query.Collect = func() ([]benchmark.Item, error) {
+				result, err := vm.callValue(fn, nil)
+				if err != nil {
+					return nil, err
+				}
+				if !IsArray(result) {
+					return nil, fmt.Errorf("collect must return an array, got %s", ValueType(result))
+				}
+				return benchmarkItems(result)
+			}
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,79 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestBenchmarkBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{"id": "org-base", "platform": "linux", "controls": [
+  {"id": "1", "title": "No login shell for service accounts", "query": {"source": "user", "where": {"field": "uid", "op": "lt", "value": 1000}},
+   "mode": "none", "expect": {"field": "shell", "op": "eq", "value": "/bin/bash"}}
+]}`
+	os.MkdirAll(filepath.Join(dir, "etc"), 0755)
+	os.WriteFile(filepath.Join(dir, "custom.json"), []byte(custom), 0644)
+	os.WriteFile(filepath.Join(dir, "etc", "passwd"), []byte("root:x:0:0::/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n"), 0644)
+
+	globals := run(t, `
+let id = benchmark_define({
+    "id": "org-storage",
+    "title": "Storage baseline",
+    "controls": [
+        {"id": "1.1", "section": "Buckets", "query": {"source": "bucket"},
+         "expect": {"field": "encrypted", "op": "eq", "value": true}, "remediation": "Turn on encryption"},
+        {"id": "1.2", "section": "Buckets", "query": {"source": "bucket"},
+         "check": fn(b) {
+             if b["public"] { return b["name"] + " is public" }
+             return false
+         }},
+        {"id": "2.1", "section": "Keys",
+         "collect": fn() { return [{"name": "k1", "age": 30}, {"name": "k2", "age": 400}] },
+         "check": fn(k) { return k["age"] > 365 }}
+    ]
+})
+let report = benchmark_run("org-storage", {"bucket": [
+    {"name": "logs", "encrypted": true, "public": false},
+    {"name": "site", "encrypted": false, "public": true}
+]})
+let results = []
+for c in report["controls"] {
+    let ev = ""
+    if len(c["evidence"]) > 0 { ev = c["evidence"][0]["item"] + ": " + c["evidence"][0]["reason"] }
+    push(results, c["id"] + " " + c["status"] + " " + ev)
+}
+let score = report["score"]
+let only = benchmark_run("org-storage", {"bucket": []}, {"controls": ["1"]})["skipped"]
+let loaded = benchmark_load(r"`+filepath.Join(dir, "custom.json")+`")[0]["id"]
+let host = benchmark_run("org-base", r"`+dir+`")
+let root = host["controls"][0]["evidence"][0]["item"]
+let linux = benchmark_run("cis", r"`+dir+`")["benchmark"]
+let listed = len(benchmark_list())
+`)
+	for name, want := range map[string]string{
+		"id":      "org-storage",
+		"results": "[1.1 fail site: encrypted is false, expected eq true, 1.2 fail site: site is public, 2.1 fail k2: 2.1]",
+		"score":   "0",
+		"only":    "1",
+		"loaded":  "org-base",
+		"root":    "root",
+		"linux":   "cis-linux",
+		"listed":  "5",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	expectErrors(t, map[string]string{
+		`benchmark_run("nope", {})`:                                            "benchmark_run: unknown benchmark 'nope'",
+		`benchmark_run("cis", "prod")`:                                         "benchmark_run: provider prod not found",
+		`benchmark_run("cis-linux", {}, {"levels": 1})`:                        "benchmark_run: unknown option 'levels'",
+		`benchmark_define({"id": "x", "controls": [{"id": "1"}]})`:             "benchmark_define: control 1: query has no source",
+		`benchmark_define({"id": "x", "controls": [{"id": "1", "check": 3}]})`: "check and collect must be functions",
+		`cloud_benchmark_run("staging", "cis")`:                                "cloud_benchmark_run: provider staging not found",
+	})
+}
@@ -0,0 +1,68 @@
+package vmregister
+
+import (
+	"fmt"
+
+	"sentra/internal/binaryanalysis"
+)
+
+// registerBinaryFunctions registers static analysis of PE, ELF and Mach-O
+// executables, for malware triage and the binaries in container images
+func (vm *RegisterVM) registerBinaryFunctions() {
+	// bin_analyze(path)
+	vm.registerGlobal("bin_analyze", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "bin_analyze",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			a, err := binaryanalysis.Analyze(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("bin_analyze: %v", err)
+			}
+			return goToValue(binaryanalysis.AnalysisToMap(a)), nil
+		},
+	})
+
+	// bin_strings(path, options?)
+	vm.registerGlobal("bin_strings", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "bin_strings",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("bin_strings expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			minLength, max := 4, 10000
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("bin_strings: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "min_length":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+							return NilValue(), fmt.Errorf("bin_strings: min_length must be a positive number")
+						}
+						minLength = int(ToNumber(v))
+					case "max":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+							return NilValue(), fmt.Errorf("bin_strings: max must be a number, 0 for no limit")
+						}
+						max = int(ToNumber(v))
+					default:
+						return NilValue(), fmt.Errorf("bin_strings: unknown option '%s'", key)
+					}
+				}
+			}
+			found, err := binaryanalysis.Strings(ToString(args[0]), minLength, max)
+			if err != nil {
+				return NilValue(), fmt.Errorf("bin_strings: %v", err)
+			}
+			arr := make([]Value, len(found))
+			for i, s := range found {
+				arr[i] = goToValue(binaryanalysis.StringToMap(s))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+}
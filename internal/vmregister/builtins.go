@@ -0,0 +1,43 @@
+// internal/vmregister/builtins.go
+package vmregister
+
+import (
+	"sentra/internal/builtins"
+)
+
+// registerSharedBuiltins registers the builtins whose implementation lives
+// in the shared registry, converting values on the way in and out
+func (vm *RegisterVM) registerSharedBuiltins() {
+	for _, b := range builtins.Shared() {
+		if _, ok := vm.globalNames[b.Name]; ok {
+			continue
+		}
+		b := b
+		vm.registerGlobal(b.Name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   b.Name,
+			Arity:  b.Arity,
+			Function: func(args []Value) (Value, error) {
+				shared := make([]builtins.Value, len(args))
+				for i, arg := range args {
+					shared[i] = valueToGo(arg)
+				}
+				result, err := b.Call(shared)
+				if err != nil {
+					return NilValue(), err
+				}
+				return goToValue(result), nil
+			},
+		})
+	}
+}
+
+// nativeParams returns the documented parameter names of a builtin, or nil
+// if it is not in the registry
+func nativeParams(name string) []string {
+	b := builtins.Lookup(name)
+	if b == nil {
+		return nil
+	}
+	return b.ParamNames()
+}
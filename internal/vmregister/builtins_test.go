@@ -0,0 +1,34 @@
+package vmregister
+
+import (
+	"testing"
+
+	"sentra/internal/builtins"
+)
+
+// Every builtin the VM registers must be in the shared registry with the
+// same arity, and every registry entry must be registered, so editor
+// completions and docs never drift from the runtime.
+func TestBuiltinsDocumented(t *testing.T) {
+	vm := NewRegisterVM()
+	registered := make(map[string]bool)
+	for name, value := range vm.GetGlobals() {
+		if !IsNativeFn(value) {
+			continue
+		}
+		registered[name] = true
+		b := builtins.Lookup(name)
+		if b == nil {
+			t.Errorf("%s: missing entry in the builtins registry", name)
+			continue
+		}
+		if arity := AsNativeFn(value).Arity; arity != b.Arity {
+			t.Errorf("%s: arity %d but documented as %s", name, arity, b.Signature())
+		}
+	}
+	for _, b := range builtins.All() {
+		if !registered[b.Name] {
+			t.Errorf("%s: documented but not registered", b.Name)
+		}
+	}
+}
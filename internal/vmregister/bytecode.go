@@ -94,6 +94,7 @@ const (
 	OP_GETTABLEK // GETTABLEK R(A) R(B) K(C)  R(A) = R(B)[K(C)] (constant key)
 	OP_SETTABLEK // SETTABLEK R(A) R(B) K(C)  R(A)[K(B)] = R(C) (constant key)
 	OP_SELF      // SELF R(A) R(B) R(C)       R(A+1) = R(B); R(A) = R(B)[R(C)]
+	OP_SLICE     // SLICE R(A) R(B) R(C)      R(A) = R(B)[R(C):R(C+1)] (copy, nil bound = open)
 
 	// ========================================================================
 	// Array Operations (optimized)
@@ -193,7 +194,8 @@ const (
 
 	// Generic iterator for-in loops
 	OP_ITERINIT // ITERINIT R(A) R(B)        Setup iterator for R(B) into R(A)
-	OP_ITERNEXT // ITERNEXT R(A) sBx         Advance iterator R(A), jump sBx if done
+	OP_ITERNEXT // ITERNEXT R(A) sBx         Advance iterator R(A) into R(A+1..A+3), jump sBx if done
+	OP_ITEREND  // ITEREND R(A)              Release iterator R(A) when a loop is left early
 
 	// ========================================================================
 	// Function Operations
@@ -201,7 +203,8 @@ const (
 
 	OP_CLOSURE  // CLOSURE R(A) Bx           R(A) = closure(PROTO[Bx])
 	OP_CALL     // CALL R(A) B C             R(A)...R(A+C-2) = R(A)(R(A+1)...R(A+B-1))
-	OP_TAILCALL // TAILCALL R(A) B           return R(A)(R(A+1)...R(A+B-1))
+	OP_CALLKW   // CALLKW R(A) B C           CALL, naming the last arguments with the comma separated names in R(A+B)
+	OP_TAILCALL // TAILCALL R(A) B C         return R(A)(R(A+1)...R(A+B-1)), reusing the frame for a Sentra function
 	OP_RETURN   // RETURN R(A) B             return R(A)...R(A+B-2)
 
 	// ========================================================================
@@ -290,6 +293,7 @@ const (
 
 	OP_PRINT // PRINT R(A)                print(R(A))
 	OP_NOP   // NOP                       No operation
+	OP_COVER // COVER Bx                  Record a hit for coverage probe Bx
 )
 
 // Instruction encoding/decoding helpers
@@ -417,6 +421,7 @@ var opNames = [...]string{
 	OP_GETTABLEK: "GETTABLEK",
 	OP_SETTABLEK: "SETTABLEK",
 	OP_SELF:      "SELF",
+	OP_SLICE:     "SLICE",
 	OP_LEN:       "LEN",
 	OP_APPEND:    "APPEND",
 	OP_POP:       "POP",
@@ -449,6 +454,7 @@ var opNames = [...]string{
 	OP_PARSEINT:    "PARSEINT",
 	OP_PARSEFLT:    "PARSEFLT",
 	OP_JMP:         "JMP",
+	OP_JMP_HOT:     "JMP_HOT",
 	OP_JMP_INTLOOP: "JMP_INTLOOP",
 	OP_TEST:      "TEST",
 	OP_TESTSET:   "TESTSET",
@@ -468,8 +474,10 @@ var opNames = [...]string{
 	OP_FORLOOP:   "FORLOOP",
 	OP_ITERINIT:  "ITERINIT",
 	OP_ITERNEXT:  "ITERNEXT",
+	OP_ITEREND:   "ITEREND",
 	OP_CLOSURE:   "CLOSURE",
 	OP_CALL:      "CALL",
+	OP_CALLKW:    "CALLKW",
 	OP_TAILCALL:  "TAILCALL",
 	OP_RETURN:    "RETURN",
 	OP_TYPEOF:    "TYPEOF",
@@ -498,6 +506,7 @@ var opNames = [...]string{
 	OP_FUNCENTY:   "FUNCENTY",
 	OP_PRINT:      "PRINT",
 	OP_NOP:        "NOP",
+	OP_COVER:      "COVER",
 }
 
 func (op OpCode) String() string {
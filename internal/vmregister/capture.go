@@ -0,0 +1,287 @@
+package vmregister
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// parseCaptureOptions reads the optional options map at args[i] of
+// capture_stream: count, duration_ms, snaplen and promisc
+func parseCaptureOptions(name string, args []Value, i int, opts *network.CaptureOptions) error {
+	if len(args) <= i || IsNil(args[i]) {
+		return nil
+	}
+	if !IsMap(args[i]) {
+		return fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "count", "snaplen":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+				return fmt.Errorf("%s: %s must be a positive number", name, key)
+			}
+			if key == "count" {
+				opts.Count = int(ToNumber(v))
+			} else {
+				opts.Snaplen = int(ToNumber(v))
+			}
+		case "duration_ms":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return fmt.Errorf("%s: duration_ms must be a positive number of milliseconds", name)
+			}
+			opts.Duration = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		case "promisc":
+			opts.Promisc = IsTruthy(v)
+		default:
+			return fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+	}
+	return nil
+}
+
+// packetFromValue rebuilds a packet from a map of capture_get_packets,
+// pcap_read or a script, decoding its data again
+func packetFromValue(name string, v Value) (*network.Packet, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("%s: packet must be a map, got %s", name, ValueType(v))
+	}
+	items := AsMap(v).Items
+	data, ok := items["data"]
+	if !ok || !IsBytes(data) {
+		return nil, fmt.Errorf("%s: packet needs its data as bytes", name)
+	}
+	linkType := 1 // Ethernet
+	if lt, ok := items["link_type"]; ok && !IsNil(lt) {
+		linkType = int(ToNumber(lt))
+	}
+	timestamp := time.Now()
+	if ts, ok := items["timestamp"]; ok && !IsNil(ts) {
+		sec, frac := math.Modf(ToNumber(ts))
+		timestamp = time.Unix(int64(sec), int64(math.Round(frac*1e6))*1000)
+	}
+	length := 0
+	if l, ok := items["length"]; ok && !IsNil(l) {
+		length = int(ToNumber(l))
+	}
+	return network.DecodePacket(AsBytes(data).Data, linkType, timestamp, length), nil
+}
+
+// packetValue returns a packet as a map
+func packetValue(packet *network.Packet) Value {
+	return goToValue(network.PacketToMap(packet))
+}
+
+// registerCaptureFunctions registers live packet capture, in the
+// background or streamed to a callback, and pcap file reading and writing
+func (vm *RegisterVM) registerCaptureFunctions() {
+	// streamPackets calls callback with each packet until it returns false
+	streamPackets := func(callback Value, read func(func(*network.Packet) bool) error) (Value, error) {
+		handled := 0
+		var callErr error
+		err := read(func(packet *network.Packet) bool {
+			handled++
+			result, err := vm.callValue(callback, []Value{packetValue(packet)})
+			if err != nil {
+				callErr = err
+				return false
+			}
+			return !(IsBool(result) && !AsBool(result))
+		})
+		if callErr != nil {
+			return NilValue(), callErr
+		}
+		if err != nil {
+			return NilValue(), err
+		}
+		return BoxInt(int64(handled)), nil
+	}
+
+	checkCallback := func(name string, v Value) error {
+		if !IsPointer(v) || !isCallableType(AsObject(v).Type) {
+			return fmt.Errorf("%s: callback must be a function", name)
+		}
+		return nil
+	}
+
+	// capture_start(interface, filter)
+	vm.registerGlobal("capture_start", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_start",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			iface := ToString(args[0])
+			filter := ToString(args[1])
+
+			capture, err := network.StartCapture(iface, network.CaptureOptions{Filter: filter})
+			if err != nil {
+				return NilValue(), err
+			}
+
+			return goToValue(network.CaptureToMap(capture)), nil
+		},
+	})
+
+	// capture_stop(capture_id)
+	vm.registerGlobal("capture_stop", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_stop",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			captureID := ToString(args[0])
+			err := network.StopCapture(captureID)
+			if err != nil {
+				return NilValue(), err
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// capture_get_packets(capture_id, count)
+	vm.registerGlobal("capture_get_packets", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_get_packets",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			captureID := ToString(args[0])
+			count := int(ToNumber(args[1]))
+
+			packets, err := network.GetPackets(captureID, count)
+			if err != nil {
+				return NilValue(), err
+			}
+
+			arr := make([]Value, len(packets))
+			for i, packet := range packets {
+				arr[i] = packetValue(packet)
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// capture_analyze_packet(packet)
+	vm.registerGlobal("capture_analyze_packet", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_analyze_packet",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			packet, err := packetFromValue("capture_analyze_packet", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			return goToValue(network.AnalyzePacket(packet)), nil
+		},
+	})
+
+	// capture_save_pcap(capture_id, filename)
+	vm.registerGlobal("capture_save_pcap", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_save_pcap",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			captureID := ToString(args[0])
+			filename := ToString(args[1])
+			err := network.SavePCAP(captureID, filename)
+			if err != nil {
+				return NilValue(), err
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// capture_stream(interface, filter, callback, options?)
+	vm.registerGlobal("capture_stream", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "capture_stream",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 3 || len(args) > 4 {
+				return NilValue(), fmt.Errorf("capture_stream expects 3-4 arguments (interface, filter, callback, options), got %d", len(args))
+			}
+			if err := checkCallback("capture_stream", args[2]); err != nil {
+				return NilValue(), err
+			}
+			opts := network.CaptureOptions{Filter: ToString(args[1])}
+			if err := parseCaptureOptions("capture_stream", args, 3, &opts); err != nil {
+				return NilValue(), err
+			}
+			return streamPackets(args[2], func(handle func(*network.Packet) bool) error {
+				return network.Capture(ToString(args[0]), opts, handle)
+			})
+		},
+	})
+
+	// pcap_read(filename, filter?)
+	vm.registerGlobal("pcap_read", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "pcap_read",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("pcap_read expects 1-2 arguments (filename, filter), got %d", len(args))
+			}
+			filter := ""
+			if len(args) > 1 && !IsNil(args[1]) {
+				filter = ToString(args[1])
+			}
+			arr := []Value{}
+			err := network.ReadPCAP(ToString(args[0]), filter, func(packet *network.Packet) bool {
+				arr = append(arr, packetValue(packet))
+				return true
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// pcap_each(filename, callback, filter?)
+	vm.registerGlobal("pcap_each", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "pcap_each",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("pcap_each expects 2-3 arguments (filename, callback, filter), got %d", len(args))
+			}
+			if err := checkCallback("pcap_each", args[1]); err != nil {
+				return NilValue(), err
+			}
+			filter := ""
+			if len(args) > 2 && !IsNil(args[2]) {
+				filter = ToString(args[2])
+			}
+			return streamPackets(args[1], func(handle func(*network.Packet) bool) error {
+				return network.ReadPCAP(ToString(args[0]), filter, handle)
+			})
+		},
+	})
+
+	// pcap_write(filename, packets)
+	vm.registerGlobal("pcap_write", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "pcap_write",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if !IsArray(args[1]) {
+				return NilValue(), fmt.Errorf("pcap_write: packets must be an array, got %s", ValueType(args[1]))
+			}
+			elements := AsArray(args[1]).Elements
+			packets := make([]*network.Packet, len(elements))
+			for i, v := range elements {
+				packet, err := packetFromValue("pcap_write", v)
+				if err != nil {
+					return NilValue(), err
+				}
+				packets[i] = packet
+			}
+			if err := network.WritePCAP(ToString(args[0]), packets); err != nil {
+				return NilValue(), err
+			}
+			return BoxInt(int64(len(packets))), nil
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package vmregister_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestPacketFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns.pcap")
+	globals := run(t, `
+let frame = hex"020000000002 020000000001 0800 4500002100000000401100000a0000010a000002 1234270f000d0000 68656c6c6f"
+let written = pcap_write(r"`+path+`", [{"data": frame, "link_type": 1, "timestamp": 1700000000.5}])
+let packets = pcap_read(r"`+path+`", "udp dst port 9999")
+let none = len(pcap_read(r"`+path+`", "tcp"))
+let first = packets[0]
+let summary = first["src_ip"] + ":" + str(first["src_port"]) + " > " + first["dst_ip"] + ":" + str(first["dst_port"])
+let payload = bytes_to_string(first["payload"])
+let stamp = first["timestamp"] == 1700000000.5
+let layers = first["layers"]
+
+let seen = 0
+fn stop_early(packet) {
+    seen = seen + 1
+    return false
+}
+let handled = pcap_each(r"`+path+`", stop_early)
+let details = capture_analyze_packet(first)["layer_details"]
+let udp = details[2]["name"] + " " + str(details[2]["header_length"])
+`)
+
+	tests := map[string]string{
+		"written": "1",
+		"none":    "0",
+		"summary": "10.0.0.1:4660 > 10.0.0.2:9999",
+		"payload": "hello",
+		"stamp":   "true",
+		"layers":  "[Ethernet, IPv4, UDP, Payload]",
+		"seen":    "1",
+		"handled": "1",
+		"udp":     "UDP 8",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
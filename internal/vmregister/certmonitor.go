@@ -0,0 +1,162 @@
+package vmregister
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"sentra/internal/cryptoanalysis"
+)
+
+// certEndpoint reads an endpoint to monitor: host, host:port or a URL, or
+// a map of host, port and server_name
+func certEndpoint(v Value) (cryptoanalysis.CertEndpoint, error) {
+	if !IsMap(v) {
+		return cryptoanalysis.ParseCertEndpoint(ToString(v))
+	}
+	items := AsMap(v).Items
+	host, ok := items["host"]
+	if !ok {
+		return cryptoanalysis.CertEndpoint{}, fmt.Errorf("an endpoint needs a host")
+	}
+	e, err := cryptoanalysis.ParseCertEndpoint(ToString(host))
+	if err != nil {
+		return e, err
+	}
+	if port, ok := items["port"]; ok {
+		e.Port = int(ToInt(port))
+	}
+	if name, ok := items["server_name"]; ok {
+		e.ServerName = ToString(name)
+	}
+	return e, nil
+}
+
+// registerCertMonitorFunctions registers certificate transparency
+// searches and the expiry monitoring of TLS endpoints
+func (vm *RegisterVM) registerCertMonitorFunctions() {
+	// ct_search(domain, options?) returns the certificates the CT logs
+	// recorded for a domain and its subdomains
+	vm.registerGlobal("ct_search", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ct_search",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ct_search expects 1-2 arguments (domain, options), got %d", len(args))
+			}
+			var opts cryptoanalysis.CTSearchOptions
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("ct_search: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					var err error
+					switch key {
+					case "url":
+						opts.URL = ToString(v)
+					case "exact":
+						opts.ExactDomain = IsTruthy(v)
+					case "include_expired":
+						opts.IncludeExpired = IsTruthy(v)
+					case "timeout_ms":
+						opts.Timeout, err = durationOption("ct_search", key, v, time.Millisecond)
+					default:
+						err = fmt.Errorf("ct_search: unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			certs, err := cryptoanalysis.CTSearch(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ct_search: %v", err)
+			}
+			found := make([]interface{}, len(certs))
+			for i, c := range certs {
+				found[i] = cryptoanalysis.CTCertificateToMap(c)
+			}
+			return goToValue(found), nil
+		},
+	})
+
+	// cert_expiry_monitor(hosts, days?, options?) checks the certificates of
+	// endpoints and returns those that expire within days, 30 by default,
+	// or are not valid for their name or not trusted, as findings
+	vm.registerGlobal("cert_expiry_monitor", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "cert_expiry_monitor",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("cert_expiry_monitor expects 1-3 arguments (hosts, days, options), got %d", len(args))
+			}
+			if len(args) == 2 && IsMap(args[1]) {
+				args = []Value{args[0], NilValue(), args[1]}
+			}
+			var opts cryptoanalysis.CertMonitorOptions
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !(IsNumber(args[1]) || IsInt(args[1])) || ToNumber(args[1]) <= 0 {
+					return NilValue(), fmt.Errorf("cert_expiry_monitor: days must be a positive number")
+				}
+				opts.Days = int(ToInt(args[1]))
+			}
+			all := false
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("cert_expiry_monitor: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "all":
+						all = IsTruthy(v)
+					case "timeout_ms":
+						opts.Timeout, err = durationOption("cert_expiry_monitor", key, v, time.Millisecond)
+					case "concurrency":
+						opts.Concurrency = int(ToInt(v))
+					case "ca_file":
+						// The CAs of the file are trusted besides the system's
+						var pem []byte
+						if pem, err = os.ReadFile(ToString(v)); err != nil {
+							break
+						}
+						if opts.Roots, err = x509.SystemCertPool(); err != nil {
+							opts.Roots = x509.NewCertPool()
+						}
+						if !opts.Roots.AppendCertsFromPEM(pem) {
+							err = fmt.Errorf("%s holds no PEM certificates", ToString(v))
+						}
+					default:
+						err = fmt.Errorf("unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), fmt.Errorf("cert_expiry_monitor: %v", err)
+					}
+				}
+			}
+
+			hosts := []Value{args[0]}
+			if IsArray(args[0]) {
+				hosts = AsArray(args[0]).Elements
+			}
+			endpoints := make([]cryptoanalysis.CertEndpoint, len(hosts))
+			for i, h := range hosts {
+				e, err := certEndpoint(h)
+				if err != nil {
+					return NilValue(), fmt.Errorf("cert_expiry_monitor: %v", err)
+				}
+				endpoints[i] = e
+			}
+			found := []interface{}{}
+			for _, s := range cryptoanalysis.MonitorCertificates(endpoints, opts) {
+				if all || len(s.Problems) > 0 {
+					found = append(found, cryptoanalysis.CertStatusToMap(s))
+				}
+			}
+			return goToValue(found), nil
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package vmregister_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestCertMonitoring(t *testing.T) {
+	ct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 7, "issuer_name": "CN=R3", "common_name": "vpn.example.com", "name_value": "vpn.example.com", "serial_number": "01", "not_before": "2024-01-01T00:00:00", "not_after": "2099-01-01T00:00:00", "entry_timestamp": "2024-01-01T00:00:00"}]`))
+	}))
+	defer ct.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	dir := t.TempDir()
+	os.WriteFile(dir+"/ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0644)
+	host := strings.TrimPrefix(tlsServer.URL, "https://")
+
+	globals := run(t, `
+let certs = ct_search("example.com", {"url": "`+ct.URL+`"})
+let found = certs[0]["common_name"] + " " + len(certs)
+let monitored = cert_expiry_monitor(["`+host+`", {"host": "`+tlsServer.URL+`", "server_name": "other.test"}], {"ca_file": "`+dir+`/ca.pem"})
+let mismatch = len(monitored) + " " + monitored[0]["status"] + " " + monitored[0]["severity"] + " " + monitored[0]["server_name"]
+let untrusted = cert_expiry_monitor("`+host+`", 100000)[0]
+let statuses = untrusted["problems"][0]["type"] + " " + untrusted["problems"][1]["type"] + " " + untrusted["names"][0]
+let everything = len(cert_expiry_monitor(["`+host+`"], 30, {"all": true, "ca_file": "`+dir+`/ca.pem"}))
+`)
+	if got, want := vmregister.ToString(globals["found"]), "vpn.example.com 1"; got != want {
+		t.Errorf("found = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["mismatch"]), "1 mismatch high other.test"; got != want {
+		t.Errorf("mismatch = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["statuses"]), "expiring untrusted example.com"; got != want {
+		t.Errorf("statuses = %q, want %q", got, want)
+	}
+	if got := vmregister.ToString(globals["everything"]); got != "1" {
+		t.Errorf("everything = %q", got)
+	}
+
+	expectErrors(t, map[string]string{
+		`ct_search("example.com/admin")`:                                     `ct_search: not a domain: "example.com/admin"`,
+		`ct_search("example.com", {"source": "censys"})`:                     "ct_search: unknown option 'source'",
+		`cert_expiry_monitor("example.com:99999")`:                           `cert_expiry_monitor: invalid port in "example.com:99999"`,
+		`cert_expiry_monitor([{"port": 443}])`:                               "cert_expiry_monitor: an endpoint needs a host",
+		`cert_expiry_monitor("example.com", -5)`:                             "cert_expiry_monitor: days must be a positive number",
+		`cert_expiry_monitor("example.com", 30, {"ca_file": "` + dir + `"})`: "cert_expiry_monitor: read",
+	})
+}
@@ -0,0 +1,78 @@
+package vmregister
+
+import "maps"
+
+// Arrays and maps are references: assigning one or storing it in another
+// shares it. clone and deep_clone make copies. A clone shares the elements
+// of the original until either is changed, so cloning a large array or
+// map costs nothing until one of them is written. Every change to Elements
+// or Items must call own first.
+
+// own gives arr its own elements before a change, copying them if a clone
+// may share them
+func (arr *ArrayObj) own() {
+	if arr.shared {
+		arr.Elements = append(make([]Value, 0, len(arr.Elements)), arr.Elements...)
+		arr.shared = false
+	}
+}
+
+// own gives m its own items before a change, copying them if a clone may
+// share them
+func (m *MapObj) own() {
+	if m.shared {
+		m.Items = maps.Clone(m.Items)
+		m.shared = false
+	}
+}
+
+// cloneValue returns a shallow copy of an array or map, and any other value
+// as is. Struct methods stay with the original.
+func cloneValue(v Value) Value {
+	switch {
+	case IsArray(v):
+		arr := AsArray(v)
+		arr.shared = true
+		clone := BoxArray(arr.Elements)
+		AsArray(clone).shared = true
+		return clone
+	case IsMap(v):
+		m := AsMap(v)
+		m.shared = true
+		clone := BoxMap(m.Items)
+		AsMap(clone).shared = true
+		return clone
+	}
+	return v
+}
+
+// deepClone copies an array or map and every array and map inside it. An
+// array or map reached twice is copied once, so cycles and sharing within
+// v are kept in the copy.
+func deepClone(v Value, seen map[Value]Value) Value {
+	switch {
+	case IsArray(v):
+		if clone, ok := seen[v]; ok {
+			return clone
+		}
+		elements := AsArray(v).Elements
+		clone := BoxArray(make([]Value, len(elements)))
+		seen[v] = clone
+		for i, elem := range elements {
+			AsArray(clone).Elements[i] = deepClone(elem, seen)
+		}
+		return clone
+	case IsMap(v):
+		if clone, ok := seen[v]; ok {
+			return clone
+		}
+		items := AsMap(v).Items
+		clone := BoxMap(make(map[string]Value, len(items)))
+		seen[v] = clone
+		for key, item := range items {
+			AsMap(clone).Items[key] = deepClone(item, seen)
+		}
+		return clone
+	}
+	return v
+}
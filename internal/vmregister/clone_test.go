@@ -0,0 +1,66 @@
+package vmregister_test
+
+import (
+	"testing"
+
+	"sentra/internal/compiler"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	stackvm "sentra/internal/vm"
+	"sentra/internal/vmregister"
+)
+
+func TestClone(t *testing.T) {
+	source := `
+let items = [1, 2, [3, 4]]
+let shallow = clone(items)
+shallow[0] = 9
+push(shallow, 5)
+shallow[2][0] = 7
+let deep = deep_clone(items)
+deep[2][1] = 8
+let big = [0, 1, 2, 3]
+let before = clone(big)
+pop(big)
+push(big, 100)
+let config = {"port": 80, "hosts": ["a"]}
+let copied = clone(config)
+copied["port"] = 8080
+let isolated = deep_clone(config)
+push(isolated["hosts"], "b")
+let ports = [config["port"], copied["port"]]
+let hosts = [config["hosts"], isolated["hosts"]]
+let cyclic = {"n": 1}
+cyclic["self"] = cyclic
+let cyclic_copy = deep_clone(cyclic)
+cyclic_copy["n"] = 2
+let loops = cyclic["self"]["n"] + cyclic_copy["self"]["n"]
+let number = clone(5)
+`
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	globals := run(t, source)
+
+	want := map[string]string{
+		"items":   "[1, 2, [7, 4]]",
+		"shallow": "[9, 2, [7, 4], 5]",
+		"deep":    "[1, 2, [7, 8]]",
+		"big":     "[0, 1, 2, 100]",
+		"before":  "[0, 1, 2, 3]",
+		"ports":   "[80, 8080]",
+		"hosts":   "[[a], [a, b]]",
+		"loops":   "3",
+		"number":  "5",
+	}
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package vmregister_test
+
+import (
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestCloudBuiltins(t *testing.T) {
+	globals := run(t, `
+let added = cloud_provider_add("prod", "aws", {"access_key": "AKID", "secret_key": "secret", "regions": "us-east-1"})
+let resources = len(cloud_resources("prod"))
+let findings = len(cloud_findings(""))
+`)
+	for name, want := range map[string]string{"added": "true", "resources": "0", "findings": "0"} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	expectErrors(t, map[string]string{
+		`cloud_provider_add("prod", "AWS", {"access_kye": "AKID"})`: "cloud_provider_add: AWS: unknown credential 'access_kye'",
+		`cloud_provider_add("prod", "Oracle", {})`:                  "unknown provider type 'Oracle'",
+		`cloud_resources("staging")`:                                "cloud_resources: provider staging not found",
+	})
+}
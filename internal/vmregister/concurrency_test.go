@@ -0,0 +1,275 @@
+package vmregister_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sentra/internal/compiler"
+	"sentra/internal/concurrency"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	stackvm "sentra/internal/vm"
+	"sentra/internal/vmregister"
+)
+
+func TestConcurrentCollections(t *testing.T) {
+	// Producers and consumers on separate goroutines, sharing a map
+	queue := concurrency.NewQueue(4)
+	seen := concurrency.NewMap()
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for !queue.Closed() {
+				if host, ok := queue.Pop(10 * time.Millisecond); ok {
+					seen.Update("count", func(value interface{}, ok bool) interface{} {
+						n, _ := value.(int)
+						return n + 1
+					})
+					seen.Set(host.(string), true)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if ok, err := queue.Push("10.0.0."+strconv.Itoa(i), time.Second); !ok || err != nil {
+			t.Fatalf("push %d: %v %v", i, ok, err)
+		}
+	}
+	queue.Close()
+	for w := 0; w < 4; w++ {
+		<-done
+	}
+	if count, _ := seen.Get("count"); count != 100 || seen.Len() != 101 {
+		t.Errorf("consumers saw %v values and %d keys, want 100 and 101", count, seen.Len())
+	}
+	if _, err := queue.Push("late", 0); !errors.Is(err, concurrency.ErrQueueClosed) {
+		t.Errorf("push after close: got %v", err)
+	}
+
+	source := `
+conc_map_create("results")
+let again = conc_map_create("results")
+let config = {"ports": [22]}
+conc_map_set("results", "config", config)
+push(config["ports"], 80)
+let stored = conc_map_get("results", "config")
+conc_map_incr("results", "scanned")
+let scanned = conc_map_incr("results", "scanned", 2)
+let keys = conc_map_keys("results")
+let removed = conc_map_delete("results", "config")
+let size = conc_map_len("results")
+conc_queue_create("hosts", 2)
+let first = conc_queue_push("hosts", "a")
+conc_queue_push("hosts", "b")
+let full = conc_queue_push("hosts", "c")
+let queued = conc_queue_len("hosts")
+conc_queue_close("hosts")
+let popped = [conc_queue_pop("hosts"), conc_queue_pop("hosts", 10), conc_queue_pop("hosts", 10)]
+let done = conc_queue_done("hosts")
+`
+	want := map[string]string{
+		"again":   "false",
+		"stored":  "{ports: [22]}",
+		"scanned": "3",
+		"keys":    "[config, scanned]",
+		"removed": "true",
+		"size":    "1",
+		"first":   "true",
+		"full":    "false",
+		"queued":  "2",
+		"popped":  "[a, b, nil]",
+		"done":    "true",
+	}
+	globals := run(t, source)
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+	}
+
+	// The stack VM reaches the same maps and queues under other names
+	stackSource := strings.NewReplacer(`"results"`, `"stack_results"`, `"hosts"`, `"stack_hosts"`).Replace(source)
+	stmts := parser.NewParserWithSource(lexer.NewScanner(stackSource).ScanTokens(), stackSource, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	for name, value := range want {
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	// Fan-in: two producers, one consumer selecting until both close
+	producers := []*concurrency.Channel{concurrency.NewChannel(0), concurrency.NewChannel(2)}
+	for p, ch := range producers {
+		go func() {
+			for i := 0; i < 50; i++ {
+				ch.Send(p, concurrency.Forever)
+			}
+			ch.Close()
+		}()
+	}
+	counts := make([]int, 2)
+	for open := 2; open > 0; {
+		cases := []concurrency.SelectCase{{Channel: producers[0]}, {Channel: producers[1]}}
+		index, value, ok, err := concurrency.Select(cases, time.Second)
+		if err != nil || index < 0 {
+			t.Fatalf("select: %d %v", index, err)
+		}
+		if !ok {
+			producers[index] = concurrency.NewChannel(0) // never ready again
+			open--
+			continue
+		}
+		counts[value.(int)]++
+	}
+	if counts[0] != 50 || counts[1] != 50 {
+		t.Errorf("received %v, want 50 from each producer", counts)
+	}
+
+	source := `
+let jobs = chan_new(3)
+chan_send(jobs, "a")
+chan_send(jobs, {"host": "b"})
+let sizes = [chan_len(jobs), chan_cap(jobs)]
+chan_close(jobs)
+let seen = []
+for job in jobs {
+    push(seen, job)
+}
+let drained = [chan_done(jobs), chan_recv(jobs, 10)]
+
+let fast = chan_new(1)
+let slow = chan_new(1)
+chan_send(slow, 7)
+let picked = chan_select([fast, slow])
+let received = [picked["index"], picked["value"], picked["ok"]]
+let idle = chan_select([fast], 0)["index"]
+let timed = chan_select([fast], 5)["index"]
+let sent = chan_select([[fast, "x"], slow], 0)["index"]
+let full = chan_send(fast, "y", 5)
+let got = chan_recv(fast, 0)
+chan_close(fast)
+let closed = chan_select([fast], 0)
+let closed_case = [closed["index"], closed["ok"]]
+`
+	want := map[string]string{
+		"sizes":       "[2, 3]",
+		"seen":        "[a, {host: b}]",
+		"drained":     "[true, nil]",
+		"received":    "[1, 7, true]",
+		"idle":        "-1",
+		"timed":       "-1",
+		"sent":        "0",
+		"full":        "false",
+		"got":         "x",
+		"closed_case": "[0, false]",
+	}
+	globals := run(t, source)
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+	}
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	for name, value := range want {
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+
+	// Native errors end the run rather than reaching a catch block
+	expectErrors(t, map[string]string{
+		"let ch = chan_new(1)\nchan_close(ch)\nchan_send(ch, 1)": "send on closed channel",
+	})
+}
+
+func TestSyncPrimitives(t *testing.T) {
+	globals := run(t, `
+mutex_new("totals_lock")
+atomic_new("hits")
+waitgroup_new("scan")
+conc_map_create("totals")
+atomic_set("hits", 0)
+conc_map_delete("totals", "sum")
+conc_create_worker_pool("sync_workers", 4, 50)
+conc_start_worker_pool("sync_workers")
+
+fn work(n) {
+    atomic_add("hits")
+    mutex_lock("totals_lock")
+    let total = conc_map_get("totals", "sum")
+    if total == nil {
+        total = 0
+    }
+    conc_map_set("totals", "sum", total + n)
+    mutex_unlock("totals_lock")
+    waitgroup_done("scan")
+}
+for i in range(1, 21) {
+    waitgroup_add("scan")
+    conc_submit_job("sync_workers", str(i), work, i)
+}
+let finished = waitgroup_wait("scan", 5000)
+let hits = atomic_get("hits")
+let sum = conc_map_get("totals", "sum")
+`)
+	want := map[string]string{"finished": "true", "hits": "20", "sum": "210"}
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	source := `
+let ids = [mutex_new("m"), atomic_new("c", 5), waitgroup_new("wg")]
+mutex_lock("m")
+let held = [mutex_locked("m"), mutex_lock("m", 10)]
+mutex_unlock("m")
+let swapped = [atomic_cas("c", 5, 9), atomic_cas("c", 5, 1), atomic_add("c", -4)]
+waitgroup_add("wg", 2)
+waitgroup_done("wg")
+let waiting = [waitgroup_count("wg"), waitgroup_wait("wg", 10)]
+waitgroup_done("wg")
+let done = waitgroup_wait("wg", 0)
+`
+	want = map[string]string{
+		"held":    "[true, false]",
+		"swapped": "[true, false, 5]",
+		"waiting": "[1, false]",
+		"done":    "true",
+	}
+	globals = run(t, source)
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s: register VM gave %q, want %q", name, got, value)
+		}
+	}
+
+	// The stack VM reaches the same primitives, which the first run left as
+	// it found them
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test").Parse()
+	stack := stackvm.NewVM(compiler.NewHoistingCompiler().CompileWithHoisting(stmts))
+	if _, err := stack.Run(); err != nil {
+		t.Fatalf("stack VM run failed: %v", err)
+	}
+	for name, value := range want {
+		if got, _ := stack.GetGlobalVariable(name); stackvm.ToString(got) != value {
+			t.Errorf("%s: stack VM gave %q, want %q", name, stackvm.ToString(got), value)
+		}
+	}
+	if got, _ := stack.GetGlobalVariable("ids"); stackvm.ToString(got) != "[false, false, false]" {
+		t.Errorf("stack VM ids = %q, want [false, false, false]", stackvm.ToString(got))
+	}
+}
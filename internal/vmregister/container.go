@@ -0,0 +1,56 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/container"
+)
+
+// parseContainerScanOptions reads the optional options map of
+// container_scan_image: source, platform, username, password, insecure,
+// timeout (milliseconds), vuln_db, cache_dir, secrets and malware
+func parseContainerScanOptions(args []Value, i int) (container.ScanOptions, error) {
+	var opts container.ScanOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("container_scan_image: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "source":
+			opts.Source = ToString(v)
+			switch opts.Source {
+			case "auto", "file", "docker", "registry":
+			default:
+				return opts, fmt.Errorf("container_scan_image: source must be auto, file, docker or registry")
+			}
+		case "platform":
+			opts.Platform = ToString(v)
+		case "username":
+			opts.Username = ToString(v)
+		case "password":
+			opts.Password = ToString(v)
+		case "insecure":
+			opts.Insecure = IsTruthy(v)
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("container_scan_image: timeout must be a positive number of milliseconds")
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		case "vuln_db":
+			opts.VulnDB = ToString(v)
+		case "cache_dir":
+			opts.CacheDir = ToString(v)
+		case "secrets":
+			opts.NoSecrets = !IsTruthy(v)
+		case "malware":
+			opts.NoMalware = !IsTruthy(v)
+		default:
+			return opts, fmt.Errorf("container_scan_image: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
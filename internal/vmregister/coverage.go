@@ -0,0 +1,114 @@
+package vmregister
+
+import "sort"
+
+// CoverageProbe identifies a source line instrumented with OP_COVER
+type CoverageProbe struct {
+	File string
+	Line int
+}
+
+// LocalVar is a local variable and the register holding it
+type LocalVar struct {
+	Name string
+	Reg  int
+}
+
+// Coverage holds the probes emitted by the compiler and their hit counts.
+// One Coverage may be shared by several VMs to merge their results.
+type Coverage struct {
+	Probes []CoverageProbe
+	Hits   []uint64
+	Debug  bool         // One probe per statement, recording visible locals
+	locals [][]LocalVar // Per probe, only when Debug is set
+	index  map[CoverageProbe]uint16
+}
+
+// NewCoverage creates an empty coverage table
+func NewCoverage() *Coverage {
+	return &Coverage{index: make(map[CoverageProbe]uint16)}
+}
+
+// NewDebugCoverage creates a probe table for debuggers. Each statement gets
+// its own probe so the locals in scope at that point can be looked up.
+func NewDebugCoverage() *Coverage {
+	cov := NewCoverage()
+	cov.Debug = true
+	return cov
+}
+
+// StatementProbe adds a probe for one statement along with the locals
+// visible there. Returns false once the table is full.
+func (c *Coverage) StatementProbe(file string, line int, locals []LocalVar) (uint16, bool) {
+	if len(c.Probes) > MAXARG_Bx {
+		return 0, false
+	}
+	id := uint16(len(c.Probes))
+	c.Probes = append(c.Probes, CoverageProbe{File: file, Line: line})
+	c.Hits = append(c.Hits, 0)
+	for len(c.locals) < len(c.Probes)-1 {
+		c.locals = append(c.locals, nil)
+	}
+	c.locals = append(c.locals, locals)
+	return id, true
+}
+
+// ProbeLocals returns the locals recorded for a statement probe
+func (c *Coverage) ProbeLocals(id uint16) []LocalVar {
+	if int(id) < len(c.locals) {
+		return c.locals[id]
+	}
+	return nil
+}
+
+// Probe returns the probe ID for file:line, creating it if needed.
+// Returns false once the table is full.
+func (c *Coverage) Probe(file string, line int) (uint16, bool) {
+	key := CoverageProbe{File: file, Line: line}
+	if id, ok := c.index[key]; ok {
+		return id, true
+	}
+	if len(c.Probes) > MAXARG_Bx {
+		return 0, false
+	}
+	id := uint16(len(c.Probes))
+	c.index[key] = id
+	c.Probes = append(c.Probes, key)
+	c.Hits = append(c.Hits, 0)
+	return id, true
+}
+
+// Files returns the instrumented files in sorted order
+func (c *Coverage) Files() []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, probe := range c.Probes {
+		if !seen[probe.File] {
+			seen[probe.File] = true
+			files = append(files, probe.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// LineHits returns line -> hit count for every instrumented line of file
+func (c *Coverage) LineHits(file string) map[int]uint64 {
+	hits := make(map[int]uint64)
+	for id, probe := range c.Probes {
+		if probe.File == file {
+			hits[probe.Line] += c.Hits[id]
+		}
+	}
+	return hits
+}
+
+// SetCoverage enables recording of OP_COVER probes
+func (vm *RegisterVM) SetCoverage(cov *Coverage) {
+	vm.coverage = cov
+}
+
+// Coverage returns the active coverage table, or nil
+func (vm *RegisterVM) Coverage() *Coverage {
+	return vm.coverage
+}
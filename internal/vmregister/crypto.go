@@ -0,0 +1,386 @@
+package vmregister
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"sentra/internal/cryptoanalysis"
+)
+
+// keyPairMap returns a generated key pair as a map
+func keyPairMap(k *cryptoanalysis.KeyPair) map[string]interface{} {
+	m := map[string]interface{}{
+		"type":        k.Type,
+		"bits":        k.Bits,
+		"private_key": k.PrivateKey,
+		"public_key":  k.PublicKey,
+	}
+	if k.Curve != "" {
+		m["curve"] = k.Curve
+	}
+	return m
+}
+
+// parseSignOptions reads the options of crypto_sign and crypto_verify:
+// hash and padding
+func parseSignOptions(name string, args []Value, i int) (cryptoanalysis.SignOptions, error) {
+	var opts cryptoanalysis.SignOptions
+	if len(args) <= i {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "hash":
+			opts.Hash = ToString(v)
+		case "padding":
+			switch ToString(v) {
+			case "pkcs1":
+			case "pss":
+				opts.PSS = true
+			default:
+				return opts, fmt.Errorf("%s: padding must be pkcs1 or pss", name)
+			}
+		default:
+			return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+	}
+	return opts, nil
+}
+
+// aesKey decodes an AES key given in hex, as random_bytes returns it
+func aesKey(name string, v Value) ([]byte, error) {
+	key, err := hex.DecodeString(ToString(v))
+	if err != nil {
+		return nil, fmt.Errorf("%s: the key must be hex encoded", name)
+	}
+	return key, nil
+}
+
+// durationOption reads an option that is a positive number of units,
+// seconds or milliseconds
+func durationOption(name, option string, v Value, unit time.Duration) (time.Duration, error) {
+	if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+		return 0, fmt.Errorf("%s: %s must be a positive number", name, option)
+	}
+	return time.Duration(ToNumber(v) * float64(unit)), nil
+}
+
+// registerCryptoFunctions registers keyed hashes, AES-GCM, RSA and ECDSA
+// keys and signatures, secure random bytes and JWTs. Binary values are
+// hex, keys PEM, and ciphertexts and signatures base64.
+func (vm *RegisterVM) registerCryptoFunctions() {
+	vm.registerGlobal("hmac_sha256", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "hmac_sha256",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			mac, err := cryptoanalysis.HMAC("sha256", []byte(ToString(args[0])), []byte(ToString(args[1])))
+			if err != nil {
+				return NilValue(), fmt.Errorf("hmac_sha256: %v", err)
+			}
+			return BoxString(hex.EncodeToString(mac)), nil
+		},
+	})
+
+	// hmac(hash, key, data) computes an HMAC with sha1, sha256, sha384 or
+	// sha512
+	vm.registerGlobal("hmac", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "hmac",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			mac, err := cryptoanalysis.HMAC(ToString(args[0]), []byte(ToString(args[1])), []byte(ToString(args[2])))
+			if err != nil {
+				return NilValue(), fmt.Errorf("hmac: %v", err)
+			}
+			return BoxString(hex.EncodeToString(mac)), nil
+		},
+	})
+
+	// random_bytes(n, encoding?) returns n secure random bytes as hex,
+	// base64 or base64url
+	vm.registerGlobal("random_bytes", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "random_bytes",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("random_bytes expects 1-2 arguments (n, encoding), got %d", len(args))
+			}
+			b, err := cryptoanalysis.RandomBytes(int(ToInt(args[0])))
+			if err != nil {
+				return NilValue(), fmt.Errorf("random_bytes: %v", err)
+			}
+			encoding := "hex"
+			if len(args) == 2 {
+				encoding = ToString(args[1])
+			}
+			switch encoding {
+			case "hex":
+				return BoxString(hex.EncodeToString(b)), nil
+			case "base64":
+				return BoxString(base64.StdEncoding.EncodeToString(b)), nil
+			case "base64url":
+				return BoxString(base64.RawURLEncoding.EncodeToString(b)), nil
+			}
+			return NilValue(), fmt.Errorf("random_bytes: encoding must be hex, base64 or base64url")
+		},
+	})
+
+	// aes_gcm_encrypt(key, plaintext, aad?) encrypts with AES-GCM under a
+	// hex key of 16, 24 or 32 bytes
+	vm.registerGlobal("aes_gcm_encrypt", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "aes_gcm_encrypt",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("aes_gcm_encrypt expects 2-3 arguments (key, plaintext, aad), got %d", len(args))
+			}
+			key, err := aesKey("aes_gcm_encrypt", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			var aad []byte
+			if len(args) == 3 {
+				aad = []byte(ToString(args[2]))
+			}
+			sealed, err := cryptoanalysis.SealGCM(key, []byte(ToString(args[1])), aad)
+			if err != nil {
+				return NilValue(), fmt.Errorf("aes_gcm_encrypt: %v", err)
+			}
+			return BoxString(base64.StdEncoding.EncodeToString(sealed)), nil
+		},
+	})
+
+	vm.registerGlobal("aes_gcm_decrypt", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "aes_gcm_decrypt",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("aes_gcm_decrypt expects 2-3 arguments (key, ciphertext, aad), got %d", len(args))
+			}
+			key, err := aesKey("aes_gcm_decrypt", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			sealed, err := base64.StdEncoding.DecodeString(ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("aes_gcm_decrypt: the ciphertext must be base64 encoded")
+			}
+			var aad []byte
+			if len(args) == 3 {
+				aad = []byte(ToString(args[2]))
+			}
+			plaintext, err := cryptoanalysis.OpenGCM(key, sealed, aad)
+			if err != nil {
+				return NilValue(), fmt.Errorf("aes_gcm_decrypt: %v", err)
+			}
+			return BoxString(string(plaintext)), nil
+		},
+	})
+
+	vm.registerGlobal("rsa_generate_key", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "rsa_generate_key",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("rsa_generate_key expects 0-1 arguments (bits), got %d", len(args))
+			}
+			bits := 2048
+			if len(args) == 1 {
+				bits = int(ToInt(args[0]))
+			}
+			key, err := cryptoanalysis.GenerateRSAKey(bits)
+			if err != nil {
+				return NilValue(), fmt.Errorf("rsa_generate_key: %v", err)
+			}
+			return goToValue(keyPairMap(key)), nil
+		},
+	})
+
+	vm.registerGlobal("ecdsa_generate_key", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ecdsa_generate_key",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("ecdsa_generate_key expects 0-1 arguments (curve), got %d", len(args))
+			}
+			curve := "P-256"
+			if len(args) == 1 {
+				curve = ToString(args[0])
+			}
+			key, err := cryptoanalysis.GenerateECDSAKey(curve)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ecdsa_generate_key: %v", err)
+			}
+			return goToValue(keyPairMap(key)), nil
+		},
+	})
+
+	// crypto_sign(private_key, data, options?) signs data with a PEM RSA or
+	// ECDSA key and returns the signature in base64
+	vm.registerGlobal("crypto_sign", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "crypto_sign",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("crypto_sign expects 2-3 arguments (private_key, data, options), got %d", len(args))
+			}
+			opts, err := parseSignOptions("crypto_sign", args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			signature, err := cryptoanalysis.Sign(ToString(args[0]), []byte(ToString(args[1])), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("crypto_sign: %v", err)
+			}
+			return BoxString(base64.StdEncoding.EncodeToString(signature)), nil
+		},
+	})
+
+	vm.registerGlobal("crypto_verify", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "crypto_verify",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 3 || len(args) > 4 {
+				return NilValue(), fmt.Errorf("crypto_verify expects 3-4 arguments (public_key, data, signature, options), got %d", len(args))
+			}
+			opts, err := parseSignOptions("crypto_verify", args, 3)
+			if err != nil {
+				return NilValue(), err
+			}
+			signature, err := base64.StdEncoding.DecodeString(ToString(args[2]))
+			if err != nil {
+				return BoxBool(false), nil
+			}
+			ok, err := cryptoanalysis.Verify(ToString(args[0]), []byte(ToString(args[1])), signature, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("crypto_verify: %v", err)
+			}
+			return BoxBool(ok), nil
+		},
+	})
+
+	// jwt_create(claims, key, options?) signs claims with a secret or PEM
+	// private key
+	vm.registerGlobal("jwt_create", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "jwt_create",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("jwt_create expects 2-3 arguments (claims, key, options), got %d", len(args))
+			}
+			if !IsMap(args[0]) {
+				return NilValue(), fmt.Errorf("jwt_create: claims must be a map, got %s", ValueType(args[0]))
+			}
+			var opts cryptoanalysis.JWTOptions
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("jwt_create: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "alg":
+						opts.Algorithm = ToString(v)
+					case "kid":
+						opts.KeyID = ToString(v)
+					case "header":
+						header, ok := valueToGo(v).(map[string]interface{})
+						if !ok {
+							return NilValue(), fmt.Errorf("jwt_create: header must be a map")
+						}
+						opts.Header = header
+					case "expires_in":
+						opts.ExpiresIn, err = durationOption("jwt_create", key, v, time.Second)
+					default:
+						err = fmt.Errorf("jwt_create: unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			token, err := cryptoanalysis.CreateJWT(valueToGo(args[0]).(map[string]interface{}), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("jwt_create: %v", err)
+			}
+			return BoxString(token), nil
+		},
+	})
+
+	// jwt_verify(token, key, options?) returns the claims of a token whose
+	// signature, algorithm, expiry, issuer and audience check out
+	vm.registerGlobal("jwt_verify", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "jwt_verify",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("jwt_verify expects 2-3 arguments (token, key, options), got %d", len(args))
+			}
+			var opts cryptoanalysis.JWTVerifyOptions
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("jwt_verify: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "algorithms":
+						opts.Algorithms = stringList(v)
+					case "issuer":
+						opts.Issuer = ToString(v)
+					case "audience":
+						opts.Audience = ToString(v)
+					case "leeway":
+						opts.Leeway, err = durationOption("jwt_verify", key, v, time.Second)
+					default:
+						err = fmt.Errorf("jwt_verify: unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			jwt, err := cryptoanalysis.VerifyJWT(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("jwt_verify: %v", err)
+			}
+			return goToValue(jwt.Claims), nil
+		},
+	})
+
+	// jwt_decode(token) returns the header and claims of a token without
+	// verifying it
+	vm.registerGlobal("jwt_decode", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "jwt_decode",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			jwt, err := cryptoanalysis.DecodeJWT(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("jwt_decode: %v", err)
+			}
+			return goToValue(map[string]interface{}{
+				"header":    jwt.Header,
+				"claims":    jwt.Claims,
+				"signature": base64.RawURLEncoding.EncodeToString(jwt.Signature),
+				"algorithm": strings.ToUpper(fmt.Sprint(jwt.Header["alg"])),
+			}), nil
+		},
+	})
+}
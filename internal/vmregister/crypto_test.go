@@ -0,0 +1,48 @@
+package vmregister_test
+
+import (
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestCrypto(t *testing.T) {
+	globals := run(t, `
+let mac = hmac_sha256("Jefe", "what do ya want for nothing?")
+let key = random_bytes(32)
+let sealed = aes_gcm_encrypt(key, "attack at dawn", "v1")
+let opened = aes_gcm_decrypt(key, sealed, "v1")
+let rsa = rsa_generate_key()
+let ec = ecdsa_generate_key("P-384")
+let signature = crypto_sign(rsa["private_key"], "message", {"padding": "pss", "hash": "sha384"})
+let verified = crypto_verify(rsa["public_key"], "message", signature, {"padding": "pss", "hash": "sha384"}) + " " + crypto_verify(rsa["public_key"], "massage", signature, {"padding": "pss", "hash": "sha384"}) + " " + crypto_verify(ec["public_key"], "message", crypto_sign(ec["private_key"], "message"))
+let token = jwt_create({"sub": "alice", "aud": "api"}, ec["private_key"], {"kid": "k1", "expires_in": 3600})
+let claims = jwt_verify(token, ec["public_key"], {"audience": "api"})
+let decoded = jwt_decode(token)
+let summary = len(key) + " " + opened + " " + ec["curve"] + " " + claims["sub"] + " " + decoded["algorithm"] + " " + decoded["header"]["kid"]
+`)
+	if got, want := vmregister.ToString(globals["mac"]), "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; got != want {
+		t.Errorf("mac = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["verified"]), "true false true"; got != want {
+		t.Errorf("verified = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["summary"]), "64 attack at dawn P-384 alice ES384 k1"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	expectErrors(t, map[string]string{
+		`hmac("md5", "k", "d")`:          `hmac: unsupported hash "md5", expected sha1, sha256, sha384 or sha512`,
+		`random_bytes(16, "base32")`:     "random_bytes: encoding must be hex, base64 or base64url",
+		`aes_gcm_encrypt("secret", "x")`: "aes_gcm_encrypt: the key must be hex encoded",
+		`aes_gcm_decrypt(random_bytes(16), aes_gcm_encrypt(random_bytes(16), "x"))`: "aes_gcm_decrypt: message authentication failed",
+		`rsa_generate_key(1024)`:                                  "rsa_generate_key: RSA keys must be from 2048 to 8192 bits in whole bytes, got 1024",
+		`crypto_sign("key", "x", {"padding": "oaep"})`:            "crypto_sign: padding must be pkcs1 or pss",
+		`jwt_create({"sub": "a"}, "secret", {"ttl": 60})`:         "jwt_create: unknown option 'ttl'",
+		`jwt_verify(jwt_create({"sub": "a"}, "secret"), "other")`: "jwt_verify: invalid signature",
+		`let k = rsa_generate_key()
+jwt_verify(jwt_create({"sub": "a"}, "secret"), k["public_key"])`: `jwt_verify: algorithm "HS256" is not allowed, expected RS256`,
+		`jwt_verify(jwt_create({"sub": "a"}, "secret", {"expires_in": 0.001}), "secret", {"leeway": -1})`: "jwt_verify: leeway must be a positive number",
+		`jwt_decode("a.b")`: "jwt_decode: a JWT has 3 parts, got 2",
+	})
+}
@@ -0,0 +1,336 @@
+package vmregister
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvOptions holds the options map accepted by the csv functions
+type csvOptions struct {
+	headers    bool     // First row names the columns and rows become maps
+	delimiter  rune     // Field separator, ',' by default
+	comment    rune     // Lines starting with it are skipped, 0 for none
+	lazyQuotes bool     // Accept quotes in unquoted fields and stray quotes
+	trimSpace  bool     // Ignore leading white space in a field
+	columns    []string // Column order when writing maps
+	crlf       bool     // Write \r\n line endings
+}
+
+// parseCSVOptions reads the optional options map at args[i]
+func parseCSVOptions(name string, args []Value, i int) (csvOptions, error) {
+	opts := csvOptions{headers: true, delimiter: ','}
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+
+	char := func(key string, v Value) (rune, error) {
+		s := ToString(v)
+		r, size := utf8.DecodeRuneInString(s)
+		if size == 0 || size != len(s) || r == '"' || r == '\r' || r == '\n' {
+			return 0, fmt.Errorf("%s: %s must be a single character other than a quote or newline", name, key)
+		}
+		return r, nil
+	}
+
+	var err error
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "headers":
+			opts.headers = IsTruthy(v)
+		case "delimiter":
+			opts.delimiter, err = char(key, v)
+		case "comment":
+			opts.comment, err = char(key, v)
+		case "lazy_quotes":
+			opts.lazyQuotes = IsTruthy(v)
+		case "trim_space":
+			opts.trimSpace = IsTruthy(v)
+		case "crlf":
+			opts.crlf = IsTruthy(v)
+		case "columns":
+			if !IsArray(v) {
+				return opts, fmt.Errorf("%s: columns must be an array", name)
+			}
+			for _, col := range AsArray(v).Elements {
+				opts.columns = append(opts.columns, ToString(col))
+			}
+		default:
+			return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// csvReader reads records from r and turns them into Sentra rows
+type csvReader struct {
+	reader  *csv.Reader
+	opts    csvOptions
+	columns []string
+}
+
+func newCSVReader(r io.Reader, opts csvOptions) *csvReader {
+	reader := csv.NewReader(r)
+	reader.Comma = opts.delimiter
+	reader.Comment = opts.comment
+	reader.LazyQuotes = opts.lazyQuotes
+	reader.TrimLeadingSpace = opts.trimSpace
+	// Exports are often ragged, so rows may have any number of fields
+	reader.FieldsPerRecord = -1
+	return &csvReader{reader: reader, opts: opts}
+}
+
+// next returns the next row. With headers it is a map from column name to
+// field, leaving out columns the row has no field for; otherwise it is an
+// array of fields. ok is false at the end of the input.
+func (r *csvReader) next() (row Value, ok bool, err error) {
+	record, err := r.reader.Read()
+	if err == nil && r.opts.headers && r.columns == nil {
+		r.columns = record
+		record, err = r.reader.Read()
+	}
+	if err == io.EOF {
+		return NilValue(), false, nil
+	}
+	if err != nil {
+		return NilValue(), false, err
+	}
+
+	if !r.opts.headers {
+		fields := make([]Value, len(record))
+		for i, field := range record {
+			fields[i] = BoxString(field)
+		}
+		return BoxArray(fields), true, nil
+	}
+	items := make(map[string]Value, len(r.columns))
+	for i, col := range r.columns {
+		if i < len(record) {
+			items[col] = BoxString(record[i])
+		}
+	}
+	return BoxMap(items), true, nil
+}
+
+// encodeCSV writes rows, an array of maps or of arrays, as CSV to w
+func encodeCSV(name string, w io.Writer, rows Value, opts csvOptions) error {
+	if !IsArray(rows) {
+		return fmt.Errorf("%s: rows must be an array, got %s", name, ValueType(rows))
+	}
+	elements := AsArray(rows).Elements
+
+	writer := csv.NewWriter(w)
+	writer.Comma = opts.delimiter
+	writer.UseCRLF = opts.crlf
+
+	cell := func(v Value) string {
+		if IsNil(v) {
+			return ""
+		}
+		return ToString(v)
+	}
+
+	// Maps are written under a header row. Without explicit columns they are
+	// the sorted keys of every row, since maps do not keep insertion order.
+	columns := opts.columns
+	if columns == nil {
+		seen := make(map[string]bool)
+		for _, row := range elements {
+			if !IsMap(row) {
+				continue
+			}
+			for key := range AsMap(row).Items {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+		sort.Strings(columns)
+	}
+	if opts.headers && len(columns) > 0 {
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+	}
+
+	for i, row := range elements {
+		var record []string
+		switch {
+		case IsMap(row):
+			items := AsMap(row).Items
+			record = make([]string, len(columns))
+			for j, col := range columns {
+				record[j] = cell(items[col])
+			}
+		case IsArray(row):
+			fields := AsArray(row).Elements
+			record = make([]string, len(fields))
+			for j, field := range fields {
+				record[j] = cell(field)
+			}
+		default:
+			return fmt.Errorf("%s: row %d must be a map or an array, got %s", name, i, ValueType(row))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// registerCSVFunctions registers the csv_* builtins
+func (vm *RegisterVM) registerCSVFunctions() {
+	vm.registerGlobal("csv_parse", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "csv_parse",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("csv_parse expects 1-2 arguments (text, options), got %d", len(args))
+			}
+			opts, err := parseCSVOptions("csv_parse", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			reader := newCSVReader(strings.NewReader(ToString(args[0])), opts)
+			rows := []Value{}
+			for {
+				row, ok, err := reader.next()
+				if err != nil {
+					return NilValue(), fmt.Errorf("csv_parse: %v", err)
+				}
+				if !ok {
+					return BoxArray(rows), nil
+				}
+				rows = append(rows, row)
+			}
+		},
+	})
+
+	vm.registerGlobal("csv_rows", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "csv_rows",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("csv_rows expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			opts, err := parseCSVOptions("csv_rows", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			file, err := os.Open(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("csv_rows: %v", err)
+			}
+			reader := newCSVReader(file, opts)
+
+			// Like file_lines, the file is closed after the last row or when
+			// the loop is left early
+			open := true
+			closeFile := func() {
+				if open {
+					open = false
+					file.Close()
+				}
+			}
+			next := func() (Value, bool, error) {
+				if !open {
+					return NilValue(), false, nil
+				}
+				row, ok, err := reader.next()
+				if err != nil || !ok {
+					closeFile()
+					if err != nil {
+						err = fmt.Errorf("csv_rows: %v", err)
+					}
+					return NilValue(), false, err
+				}
+				return row, true, nil
+			}
+			return NewLazyIterator(next, closeFile), nil
+		},
+	})
+
+	vm.registerGlobal("csv_encode", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "csv_encode",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("csv_encode expects 1-2 arguments (rows, options), got %d", len(args))
+			}
+			opts, err := parseCSVOptions("csv_encode", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			var buf bytes.Buffer
+			if err := encodeCSV("csv_encode", &buf, args[0], opts); err != nil {
+				return NilValue(), err
+			}
+			return BoxString(buf.String()), nil
+		},
+	})
+
+	vm.registerGlobal("csv_write", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "csv_write",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("csv_write expects 2-3 arguments (path, rows, options), got %d", len(args))
+			}
+			opts, err := parseCSVOptions("csv_write", args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			file, err := os.Create(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("csv_write: %v", err)
+			}
+			if err := encodeCSV("csv_write", file, args[1], opts); err != nil {
+				file.Close()
+				return NilValue(), err
+			}
+			if err := file.Close(); err != nil {
+				return NilValue(), fmt.Errorf("csv_write: %v", err)
+			}
+			return NilValue(), nil
+		},
+	})
+}
+
+// createCSVModule creates the csv built-in module
+func (vm *RegisterVM) createCSVModule() *ModuleObj {
+	exports := make(map[string]Value)
+
+	exports["parse"] = vm.getGlobalByName("csv_parse")
+	exports["rows"] = vm.getGlobalByName("csv_rows")
+	exports["encode"] = vm.getGlobalByName("csv_encode")
+	exports["stringify"] = vm.getGlobalByName("csv_encode")
+	exports["write"] = vm.getGlobalByName("csv_write")
+
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    "csv",
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+
+	keepAlive(module)
+	return module
+}
@@ -0,0 +1,58 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestCSV(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "fw.csv")
+	if err := os.WriteFile(in, []byte("src,dst,action\n10.0.0.1,10.0.0.2,DROP\n10.0.0.3,\"10.0.0.4\",ACCEPT\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.csv")
+	globals := run(t, `
+import csv
+let parsed = csv.parse("name,note\nweb,\"a, b\"\ndb,\"say \"\"hi\"\"\"")
+let first_note = parsed[0]["note"]
+let quoted = parsed[1]["note"]
+let raw = csv_parse("a;b\n1;2", {"headers": false, "delimiter": ";"})
+
+let dropped = []
+for row in csv.rows(r"`+in+`") {
+    if row["action"] == "DROP" { push(dropped, row["src"]) }
+}
+let first_only = []
+for row in csv_rows(r"`+in+`", {"headers": false}) {
+    push(first_only, row[0])
+    break
+}
+
+let encoded = csv_encode([{"port": 22, "service": "ssh"}, {"port": 443, "service": "https, tls"}])
+let ordered = csv.encode([{"port": 22, "service": "ssh"}], {"columns": ["service", "port"]})
+let arrays = csv_encode([["a", null], [1, true]])
+csv.write(r"`+out+`", [{"host": "h1"}, {"host": "h2"}])
+let written = read_file(r"`+out+`")
+`)
+
+	tests := map[string]string{
+		"first_note": "a, b",
+		"quoted":     `say "hi"`,
+		"raw":        "[[a, b], [1, 2]]",
+		"dropped":    "[10.0.0.1]",
+		"first_only": "[src]",
+		"encoded":    "port,service\n22,ssh\n443,\"https, tls\"\n",
+		"ordered":    "service,port\nssh,22\n",
+		"arrays":     "a,\n1,true\n",
+		"written":    "host\nh1\nh2\n",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
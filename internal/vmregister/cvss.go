@@ -0,0 +1,262 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sentra/internal/cvss"
+)
+
+// parseEnrichOptions reads the options of the EPSS and KEV lookups:
+// epss_file, kev_file, cache_dir, offline and timeout_ms
+func parseEnrichOptions(name string, args []Value, i int) (cvss.Options, error) {
+	var opts cvss.Options
+	if len(args) <= i {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "epss_file":
+			opts.EPSSFile = ToString(v)
+		case "kev_file":
+			opts.KEVFile = ToString(v)
+		case "cache_dir":
+			opts.CacheDir = ToString(v)
+		case "offline":
+			opts.Offline = IsTruthy(v)
+		case "timeout_ms":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout_ms must be a positive number", name)
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+	}
+	return opts, nil
+}
+
+// cvssScoreMap returns the scores of a vector as a map
+func cvssScoreMap(s *cvss.Score) map[string]interface{} {
+	metrics := make(map[string]interface{}, len(s.Metrics))
+	for k, v := range s.Metrics {
+		metrics[k] = v
+	}
+	m := map[string]interface{}{
+		"version":      s.Version,
+		"vector":       s.Vector,
+		"base_score":   s.Base,
+		"score":        s.Score,
+		"severity":     s.Severity,
+		"nomenclature": s.Nomenclature,
+		"metrics":      metrics,
+	}
+	if strings.HasPrefix(s.Version, "3") {
+		m["temporal_score"] = s.Temporal
+		m["environmental_score"] = s.Environmental
+	}
+	return m
+}
+
+// epssMap returns an EPSS score as a map
+func epssMap(s cvss.EPSS) map[string]interface{} {
+	return map[string]interface{}{"cve": s.CVE, "epss": s.Score, "percentile": s.Percentile, "date": s.Date}
+}
+
+// kevMap returns a KEV entry as a map
+func kevMap(k *cvss.KEV) map[string]interface{} {
+	cwes := make([]interface{}, len(k.CWEs))
+	for i, cwe := range k.CWEs {
+		cwes[i] = cwe
+	}
+	return map[string]interface{}{
+		"cve":             k.CVE,
+		"vendor":          k.Vendor,
+		"product":         k.Product,
+		"name":            k.Name,
+		"date_added":      k.DateAdded,
+		"description":     k.Description,
+		"required_action": k.RequiredAction,
+		"due_date":        k.DueDate,
+		"ransomware":      strings.EqualFold(k.Ransomware, "Known"),
+		"notes":           k.Notes,
+		"cwes":            cwes,
+	}
+}
+
+// enrichFinding adds the scores of a finding's CVSS vector, taken from
+// cvss_vector, and the EPSS score and KEV entry of its cve to a copy of it
+func enrichFinding(e *cvss.Enricher, finding map[string]interface{}) (map[string]interface{}, error) {
+	enriched := make(map[string]interface{}, len(finding)+8)
+	for k, v := range finding {
+		enriched[k] = v
+	}
+	if vector, _ := finding["cvss_vector"].(string); vector != "" {
+		score, err := cvss.Calculate(vector)
+		if err != nil {
+			return nil, err
+		}
+		enriched["cvss_score"] = score.Score
+		enriched["cvss_version"] = score.Version
+		enriched["severity"] = score.Severity
+	}
+	id, _ := finding["cve"].(string)
+	if id == "" {
+		return enriched, nil
+	}
+	cve, err := cvss.NormalizeCVE(id)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := e.EPSS([]string{cve})
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := scores[cve]; ok {
+		enriched["epss"] = s.Score
+		enriched["epss_percentile"] = s.Percentile
+	}
+	entry, err := e.KEV(cve)
+	if err != nil {
+		return nil, err
+	}
+	enriched["kev"] = entry != nil
+	if entry != nil {
+		enriched["kev_due_date"] = entry.DueDate
+		enriched["kev_ransomware"] = strings.EqualFold(entry.Ransomware, "Known")
+	}
+	return enriched, nil
+}
+
+// registerCVSSFunctions registers the functions that score CVSS vectors
+// and look up how likely vulnerabilities are to be exploited, in FIRST's
+// EPSS, and whether they are, in CISA's KEV catalog
+func (vm *RegisterVM) registerCVSSFunctions() {
+	// cvss_score(vector) scores a CVSS 3.0, 3.1 or 4.0 vector
+	vm.registerGlobal("cvss_score", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "cvss_score",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			score, err := cvss.Calculate(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("cvss_score: %v", err)
+			}
+			return goToValue(cvssScoreMap(score)), nil
+		},
+	})
+
+	vm.registerGlobal("cvss_severity", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "cvss_severity",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !(IsNumber(args[0]) || IsInt(args[0])) || ToNumber(args[0]) < 0 || ToNumber(args[0]) > 10 {
+				return NilValue(), fmt.Errorf("cvss_severity: the score must be a number from 0 to 10")
+			}
+			return BoxString(cvss.Severity(ToNumber(args[0]))), nil
+		},
+	})
+
+	// epss_lookup(cve, options?) returns the EPSS score of a CVE, nil when
+	// it has none, or of an array of CVEs, a map of those that have one
+	vm.registerGlobal("epss_lookup", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "epss_lookup",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("epss_lookup expects 1-2 arguments (cve, options), got %d", len(args))
+			}
+			opts, err := parseEnrichOptions("epss_lookup", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			cves := []string{ToString(args[0])}
+			if IsArray(args[0]) {
+				cves = stringList(args[0])
+			}
+			scores, err := cvss.NewEnricher(opts).EPSS(cves)
+			if err != nil {
+				return NilValue(), fmt.Errorf("epss_lookup: %v", err)
+			}
+			if IsArray(args[0]) {
+				result := make(map[string]interface{}, len(scores))
+				for cve, s := range scores {
+					result[cve] = epssMap(s)
+				}
+				return goToValue(result), nil
+			}
+			for _, s := range scores {
+				return goToValue(epssMap(s)), nil
+			}
+			return NilValue(), nil
+		},
+	})
+
+	// kev_lookup(cve, options?) returns the KEV entry of a CVE, or nil
+	// when it is not known to be exploited
+	vm.registerGlobal("kev_lookup", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "kev_lookup",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("kev_lookup expects 1-2 arguments (cve, options), got %d", len(args))
+			}
+			opts, err := parseEnrichOptions("kev_lookup", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			entry, err := cvss.NewEnricher(opts).KEV(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("kev_lookup: %v", err)
+			}
+			if entry == nil {
+				return NilValue(), nil
+			}
+			return goToValue(kevMap(entry)), nil
+		},
+	})
+
+	// vuln_enrich(findings, options?) returns a finding, or an array of
+	// them, with the scores of its CVSS vector, EPSS score and KEV status
+	vm.registerGlobal("vuln_enrich", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "vuln_enrich",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("vuln_enrich expects 1-2 arguments (findings, options), got %d", len(args))
+			}
+			opts, err := parseEnrichOptions("vuln_enrich", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			e := cvss.NewEnricher(opts)
+			items := []Value{args[0]}
+			if IsArray(args[0]) {
+				items = AsArray(args[0]).Elements
+			}
+			enriched := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				if !IsMap(item) {
+					return NilValue(), fmt.Errorf("vuln_enrich: findings must be maps, got %s", ValueType(item))
+				}
+				finding, err := enrichFinding(e, valueToGo(item).(map[string]interface{}))
+				if err != nil {
+					return NilValue(), fmt.Errorf("vuln_enrich: %v", err)
+				}
+				enriched = append(enriched, finding)
+			}
+			if !IsArray(args[0]) {
+				return goToValue(enriched[0]), nil
+			}
+			return goToValue(enriched), nil
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package vmregister_test
+
+import (
+	"os"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestVulnScoring(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/epss.csv", []byte("#model_version:v2023.03.01,score_date:2024-05-01T00:00:00+0000\ncve,epss,percentile\nCVE-2021-44228,0.94458,0.99999\n"), 0644)
+	os.WriteFile(dir+"/kev.json", []byte(`{"vulnerabilities": [{"cveID": "CVE-2021-44228", "product": "Log4j2", "dueDate": "2021-12-24", "knownRansomwareCampaignUse": "Known"}]}`), 0644)
+	globals := run(t, `
+let v3 = cvss_score("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C")
+let v4 = cvss_score("CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N")
+let opts = {"epss_file": "`+dir+`/epss.csv", "kev_file": "`+dir+`/kev.json", "cache_dir": "`+dir+`/cache", "offline": true}
+let epss = epss_lookup("CVE-2021-44228", opts)
+let unscored = epss_lookup("CVE-2000-0001", opts)
+let kev = kev_lookup("CVE-2021-44228", opts)
+let findings = vuln_enrich([{"title": "Log4Shell", "cve": "CVE-2021-44228", "cvss_vector": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H"}, {"title": "Banner"}], opts)
+report_create("r3", "Scan", "", "corp")
+report_add_finding("r3", {"title": "Log4Shell", "cvss_vector": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H"})
+let reported = report_findings("r3")[0]
+let summary = v3["base_score"] + " " + v3["temporal_score"] + " " + v3["nomenclature"] + " " + v4["score"] + " " + v4["severity"] + " " + cvss_severity(5.3)
+let enriched = findings[0]["cvss_score"] + " " + findings[0]["severity"] + " " + findings[0]["epss"] + " " + findings[0]["kev"] + " " + findings[0]["kev_ransomware"] + " " + findings[1]["title"]
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "9.8 8.8 CVSS-BT 9.3 critical medium"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["enriched"]), "10 critical 0.94458 true true Banner"; got != want {
+		t.Errorf("enriched = %q, want %q", got, want)
+	}
+	if !vmregister.IsNil(globals["unscored"]) || vmregister.ToString(vmregister.AsMap(globals["epss"]).Items["percentile"]) != "0.99999" ||
+		vmregister.ToString(vmregister.AsMap(globals["kev"]).Items["due_date"]) != "2021-12-24" {
+		t.Errorf("epss = %v, unscored = %v, kev = %v", globals["epss"], globals["unscored"], globals["kev"])
+	}
+	if reported := vmregister.AsMap(globals["reported"]).Items; vmregister.ToNumber(reported["cvss"]) != 10 || vmregister.ToString(reported["severity"]) != "CRITICAL" {
+		t.Errorf("reported finding %v", reported)
+	}
+
+	expectErrors(t, map[string]string{
+		`cvss_score("AV:N/AC:L")`:                                "cvss_score: not a CVSS 3 or 4 vector",
+		`cvss_score("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H")`: "cvss_score: missing base metric A",
+		`cvss_severity(11)`:                                      "cvss_severity: the score must be a number from 0 to 10",
+		`epss_lookup("log4shell", {"offline": true})`:            `epss_lookup: not a CVE id: "log4shell"`,
+		`kev_lookup("CVE-2021-44228", {"feed": "x"})`:            "kev_lookup: unknown option 'feed'",
+		`vuln_enrich("x")`:                                       "vuln_enrich: findings must be maps, got string",
+		`report_create("r", "t", "", "c")
+report_add_finding("r", {"title": "x", "cvss_vector": "CVSS:3.1/AV:Q"})`: "finding x: invalid value Q for AV",
+	})
+}
@@ -0,0 +1,205 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/database"
+)
+
+// dbQueryArgs returns the arguments bound to the ? placeholders of a
+// query, given after it or as one array
+func dbQueryArgs(args []Value) []interface{} {
+	if len(args) == 1 && IsArray(args[0]) {
+		args = AsArray(args[0]).Elements
+	}
+	var queryArgs []interface{}
+	for _, arg := range args {
+		queryArgs = append(queryArgs, valueToGo(arg))
+	}
+	return queryArgs
+}
+
+// parseDBOptions reads the optional options map of db_connect: timeout,
+// max_open, max_idle, max_lifetime, tls, ca, cert, key and insecure.
+// Times are in milliseconds.
+func parseDBOptions(args []Value, i int) (database.Options, error) {
+	var opts database.Options
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("db_connect: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "timeout", "max_lifetime":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("db_connect: %s must be a positive number of milliseconds", key)
+			}
+			d := time.Duration(ToNumber(v) * float64(time.Millisecond))
+			if key == "timeout" {
+				opts.Timeout = d
+			} else {
+				opts.MaxLifetime = d
+			}
+		case "max_open", "max_idle":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+				return opts, fmt.Errorf("db_connect: %s must be a positive number", key)
+			}
+			if key == "max_open" {
+				opts.MaxOpen = int(ToNumber(v))
+			} else {
+				opts.MaxIdle = int(ToNumber(v))
+			}
+		case "tls":
+			opts.TLS = IsTruthy(v)
+		case "ca":
+			opts.CA = ToString(v)
+		case "cert":
+			opts.Cert = ToString(v)
+		case "key":
+			opts.Key = ToString(v)
+		case "insecure":
+			opts.Insecure = IsTruthy(v)
+		default:
+			return opts, fmt.Errorf("db_connect: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// registerDatabaseFunctions registers transactions and cursors on the
+// connections of db_connect, and db_exec
+func (vm *RegisterVM) registerDatabaseFunctions() {
+	// db_exec -> db_execute alias
+	vm.globalNames["db_exec"] = vm.globalNames["db_execute"]
+
+	// db_begin, db_commit and db_rollback(conn_id)
+	for _, name := range []string{"db_begin", "db_commit", "db_rollback"} {
+		name := name
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  1,
+			Function: func(args []Value) (Value, error) {
+				if vm.dbManager == nil {
+					return NilValue(), fmt.Errorf("database module not initialized")
+				}
+				dbMgr := vm.dbManager.(*database.DBManager)
+
+				connID := ToString(args[0])
+				var err error
+				switch name {
+				case "db_begin":
+					err = dbMgr.Begin(connID)
+				case "db_commit":
+					err = dbMgr.Commit(connID)
+				default:
+					err = dbMgr.Rollback(connID)
+				}
+				if err != nil {
+					return NilValue(), fmt.Errorf("%s: %v", name, err)
+				}
+				return BoxBool(true), nil
+			},
+		})
+	}
+
+	// db_cursor(conn_id, query, args...)
+	vm.registerGlobal("db_cursor", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "db_cursor",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 {
+				return NilValue(), fmt.Errorf("db_cursor expects at least 2 arguments (conn_id, query, args...), got %d", len(args))
+			}
+			if vm.dbManager == nil {
+				return NilValue(), fmt.Errorf("database module not initialized")
+			}
+			dbMgr := vm.dbManager.(*database.DBManager)
+
+			id, err := dbMgr.OpenCursor(ToString(args[0]), ToString(args[1]), dbQueryArgs(args[2:])...)
+			if err != nil {
+				return NilValue(), err
+			}
+			return BoxString(id), nil
+		},
+	})
+
+	// db_next(cursor_id)
+	vm.registerGlobal("db_next", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "db_next",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if vm.dbManager == nil {
+				return NilValue(), fmt.Errorf("database module not initialized")
+			}
+			dbMgr := vm.dbManager.(*database.DBManager)
+
+			row, err := dbMgr.Next(ToString(args[0]))
+			if err != nil {
+				return NilValue(), err
+			}
+			if row == nil {
+				return NilValue(), nil
+			}
+			items := make(map[string]Value, len(row))
+			for key, val := range row {
+				items[key] = goToValue(val)
+			}
+			return BoxMap(items), nil
+		},
+	})
+
+	// db_cursor_close(cursor_id)
+	vm.registerGlobal("db_cursor_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "db_cursor_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if vm.dbManager == nil {
+				return NilValue(), fmt.Errorf("database module not initialized")
+			}
+			dbMgr := vm.dbManager.(*database.DBManager)
+
+			if err := dbMgr.CloseCursor(ToString(args[0])); err != nil {
+				return NilValue(), err
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// db_security_scan, db_audit_privileges and db_check_encryption(conn_id)
+	audits := []struct {
+		name  string
+		toMap func(string, *database.SecurityReport) map[string]interface{}
+	}{
+		{"db_security_scan", database.SecurityScanToMap},
+		{"db_audit_privileges", database.PrivilegeAuditToMap},
+		{"db_check_encryption", database.EncryptionCheckToMap},
+	}
+	for _, audit := range audits {
+		name, toMap := audit.name, audit.toMap
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  1,
+			Function: func(args []Value) (Value, error) {
+				if vm.dbManager == nil {
+					return NilValue(), fmt.Errorf("database module not initialized")
+				}
+				dbMgr := vm.dbManager.(*database.DBManager)
+
+				connID := ToString(args[0])
+				report, err := dbMgr.Audit(connID)
+				if err != nil {
+					return NilValue(), fmt.Errorf("%s: %v", name, err)
+				}
+				return goToValue(toMap(connID, report)), nil
+			},
+		})
+	}
+}
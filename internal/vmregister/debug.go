@@ -0,0 +1,68 @@
+package vmregister
+
+// DebugHook is called before each statement of code compiled with debug
+// probes (see NewDebugCoverage). The VM is paused until OnLine returns, so
+// a debugger may inspect it with DebugFrames and Globals meanwhile.
+type DebugHook interface {
+	OnLine(vm *RegisterVM, file string, line int)
+}
+
+// DebugVar is a named value shown by a debugger
+type DebugVar struct {
+	Name  string
+	Value Value
+}
+
+// DebugFrame describes one active call for a debugger
+type DebugFrame struct {
+	Function string
+	File     string
+	Line     int
+	Locals   []DebugVar
+}
+
+// SetDebugHook installs a debugger. Code must be compiled with a coverage
+// table from NewDebugCoverage, which is also installed here if none is set.
+func (vm *RegisterVM) SetDebugHook(hook DebugHook) {
+	vm.debugHook = hook
+	if vm.coverage == nil {
+		vm.coverage = NewDebugCoverage()
+	}
+}
+
+// CallDepth returns the number of active call frames
+func (vm *RegisterVM) CallDepth() int {
+	return vm.frameTop
+}
+
+// DebugFrames returns the active calls, innermost first
+func (vm *RegisterVM) DebugFrames() []DebugFrame {
+	var frames []DebugFrame
+	for i := vm.frameTop - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		df := DebugFrame{Function: "<main>"}
+		if frame.function != nil && frame.function.Name != "" {
+			df.Function = frame.function.Name
+		}
+		if i < len(vm.debugProbes) && vm.debugProbes[i] >= 0 && vm.coverage != nil {
+			id := uint16(vm.debugProbes[i])
+			probe := vm.coverage.Probes[id]
+			df.File, df.Line = probe.File, probe.Line
+			for _, local := range vm.coverage.ProbeLocals(id) {
+				if reg := frame.regBase + local.Reg; reg < len(vm.registers) {
+					df.Locals = append(df.Locals, DebugVar{Name: local.Name, Value: vm.registers[reg]})
+				}
+			}
+		}
+		frames = append(frames, df)
+	}
+	return frames
+}
+
+// debugStatement records the statement reached by the current frame and
+// calls the debug hook
+func (vm *RegisterVM) debugStatement(id uint16) {
+	vm.trackStatement(id)
+	probe := vm.coverage.Probes[id]
+	vm.debugHook.OnLine(vm, probe.File, probe.Line)
+}
@@ -0,0 +1,221 @@
+package vmregister
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Disassemble writes a listing of fn and of every function it defines:
+// each instruction with its source line (see FunctionObj.Lines), operands
+// and jump target, followed by the constant table
+func (vm *RegisterVM) Disassemble(w io.Writer, fn *FunctionObj) error {
+	bw := bufio.NewWriter(w)
+	globals := vm.globalNamesByID()
+	seen := make(map[*FunctionObj]bool)
+
+	var disassemble func(fn *FunctionObj)
+	disassemble = func(fn *FunctionObj) {
+		if seen[fn] {
+			return
+		}
+		seen[fn] = true
+
+		fmt.Fprintf(bw, "function %s (%d params, %d instructions, %d constants)\n",
+			fn.Name, fn.Arity, len(fn.Code), len(fn.Constants))
+
+		targets := make(map[int]bool)
+		for pc, instr := range fn.Code {
+			if target, ok := jumpTarget(pc, instr); ok {
+				targets[target] = true
+			}
+		}
+
+		lastLine := 0
+		for pc, instr := range fn.Code {
+			line := ""
+			if l := fn.line(pc); l != 0 && l != lastLine {
+				line = strconv.Itoa(l)
+				lastLine = l
+			}
+			mark := " "
+			if targets[pc] {
+				mark = ">"
+			}
+			fmt.Fprintf(bw, "  %5s %s%04d  %s\n", line, mark, pc, formatInstruction(pc, instr, fn.Constants, globals))
+		}
+
+		if len(fn.Constants) > 0 {
+			fmt.Fprintf(bw, "constants:\n")
+			for i, k := range fn.Constants {
+				fmt.Fprintf(bw, "  K%-4d %s\n", i, formatConstant(k))
+			}
+		}
+
+		for _, k := range fn.Constants {
+			if IsFunction(k) {
+				fmt.Fprintln(bw)
+				disassemble(AsFunction(k))
+			}
+		}
+	}
+
+	disassemble(fn)
+	return bw.Flush()
+}
+
+// line returns the source line of the instruction at pc, or 0 if unknown
+func (fn *FunctionObj) line(pc int) int {
+	// The JIT may replace the code, leaving the line table behind
+	if len(fn.Lines) != len(fn.Code) {
+		return 0
+	}
+	return int(fn.Lines[pc])
+}
+
+// globalNamesByID maps global IDs back to their names
+func (vm *RegisterVM) globalNamesByID() []string {
+	names := make([]string, vm.nextGlobalID)
+	for name, id := range vm.globalNames {
+		for int(id) >= len(names) {
+			names = append(names, "")
+		}
+		names[id] = name
+	}
+	return names
+}
+
+// jumpTarget returns the pc an instruction at pc may jump to
+func jumpTarget(pc int, instr Instruction) (int, bool) {
+	switch instr.OpCode() {
+	case OP_JMP, OP_JMP_HOT, OP_JMP_INTLOOP, OP_EQJ, OP_NEJ, OP_LTJ, OP_LEJ,
+		OP_FORPREP, OP_FORLOOP, OP_ITERNEXT, OP_TRY:
+		return pc + 1 + int(instr.sBx()), true
+	case OP_EQJK, OP_NEJK, OP_LTJK, OP_LEJK, OP_GTJK, OP_GEJK:
+		return pc + 1 + int(int8(instr.C())), true
+	case OP_LOADBOOL:
+		if instr.C() != 0 {
+			return pc + 2, true
+		}
+	}
+	return 0, false
+}
+
+// formatInstruction renders an instruction as its opcode, operands and a
+// comment with the constants, globals and jump target it refers to
+func formatInstruction(pc int, instr Instruction, consts []Value, globals []string) string {
+	op := instr.OpCode()
+	a, b, c, bx, sbx := instr.A(), instr.B(), instr.C(), instr.Bx(), instr.sBx()
+
+	constant := func(i int) string {
+		if i < len(consts) {
+			return formatConstant(consts[i])
+		}
+		return "?"
+	}
+	global := func(id uint16) string {
+		if int(id) < len(globals) && globals[id] != "" {
+			return globals[id]
+		}
+		return "?"
+	}
+
+	var operands, comment string
+	switch op {
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW,
+		OP_EQ, OP_LT, OP_LE, OP_NEQ, OP_GT, OP_GE, OP_AND, OP_OR,
+		OP_GETTABLE, OP_SETTABLE, OP_SELF, OP_SLICE, OP_CONCAT,
+		OP_CONTAINS, OP_STARTSWITH, OP_ENDSWITH, OP_INDEXOF, OP_SPLIT, OP_JOIN,
+		OP_REPLACE, OP_SLICE_STR, OP_GETARRAYI, OP_SETARRAYI, OP_SWAPARR,
+		OP_HASKEY, OP_STRCAT, OP_GETARRAY_I, OP_SETARRAY_I:
+		operands = fmt.Sprintf("R%d R%d R%d", a, b, c)
+	case OP_UNM, OP_NOT, OP_MOVE, OP_LEN, OP_APPEND, OP_POP, OP_SHIFT, OP_UNSHIFT,
+		OP_UPPER, OP_LOWER, OP_TRIM, OP_KEYS, OP_TYPEOF_FAST,
+		OP_ABS, OP_SQRT, OP_FLOOR, OP_CEIL, OP_ROUND, OP_STR, OP_PARSEINT, OP_PARSEFLT,
+		OP_ITERINIT, OP_TYPEOF, OP_STRLEN, OP_INSTANCE, OP_INHERIT, OP_FIBER, OP_RESUME, OP_ARRLEN:
+		operands = fmt.Sprintf("R%d R%d", a, b)
+	case OP_ADDK, OP_SUBK, OP_MULK, OP_DIVK, OP_GETTABLEK, OP_GETMETHOD, OP_GETPROP, OP_SUPER:
+		operands = fmt.Sprintf("R%d R%d K%d", a, b, c)
+		comment = constant(int(c))
+	case OP_SETTABLEK, OP_SETMETHOD, OP_SETPROP:
+		operands = fmt.Sprintf("R%d K%d R%d", a, b, c)
+		comment = constant(int(b))
+	case OP_LOADK, OP_IMPORT, OP_CLASS:
+		operands = fmt.Sprintf("R%d K%d", a, bx)
+		comment = constant(int(bx))
+	case OP_CLOSURE:
+		operands = fmt.Sprintf("R%d K%d", a, bx)
+		if int(bx) < len(consts) && IsFunction(consts[bx]) {
+			comment = AsFunction(consts[bx]).Name
+		}
+	case OP_GETGLOBAL, OP_SETGLOBAL:
+		operands = fmt.Sprintf("R%d G%d", a, bx)
+		comment = global(bx)
+	case OP_INCRG, OP_DECRG:
+		operands = fmt.Sprintf("G%d", bx)
+		comment = global(bx)
+	case OP_ADDG, OP_SUBG:
+		operands = fmt.Sprintf("G%d R%d", bx, a)
+		comment = global(bx)
+	case OP_EXPORT:
+		operands = fmt.Sprintf("K%d R%d", a, b)
+		comment = constant(int(a))
+	case OP_GETUPVAL, OP_SETUPVAL:
+		operands = fmt.Sprintf("R%d U%d", a, b)
+	case OP_LOADBOOL, OP_NEWTABLE, OP_CALL, OP_CALLKW, OP_TAILCALL:
+		operands = fmt.Sprintf("R%d %d %d", a, b, c)
+	case OP_LOADNIL, OP_NEWARRAY, OP_RETURN:
+		operands = fmt.Sprintf("R%d %d", a, b)
+	case OP_TEST:
+		operands = fmt.Sprintf("R%d %d", a, c)
+	case OP_TESTSET, OP_ISTYPE, OP_SUBSTR:
+		operands = fmt.Sprintf("R%d R%d %d", a, b, c)
+	case OP_ADDI, OP_SUBI:
+		operands = fmt.Sprintf("R%d R%d %d", a, b, c)
+	case OP_THROW, OP_GETERROR, OP_YIELD, OP_PRINT, OP_ITEREND, OP_INCR, OP_DECR:
+		operands = fmt.Sprintf("R%d", a)
+	case OP_COVER:
+		operands = fmt.Sprintf("P%d", bx)
+	case OP_JMP, OP_JMP_HOT, OP_TRY:
+		operands = fmt.Sprintf("%+d", sbx)
+	case OP_JMP_INTLOOP, OP_FORPREP, OP_FORLOOP, OP_ITERNEXT:
+		operands = fmt.Sprintf("R%d %+d", a, sbx)
+	case OP_EQJ, OP_NEJ, OP_LTJ, OP_LEJ:
+		operands = fmt.Sprintf("R%d R%d %+d", a, b, sbx)
+	case OP_EQJK, OP_NEJK, OP_LTJK, OP_LEJK, OP_GTJK, OP_GEJK:
+		operands = fmt.Sprintf("R%d K%d %+d", a, b, int8(c))
+		comment = constant(int(b))
+	}
+
+	if target, ok := jumpTarget(pc, instr); ok {
+		if comment != "" {
+			comment += ", "
+		}
+		comment += fmt.Sprintf("to %04d", target)
+	}
+
+	name := op.String()
+	if comment == "" {
+		if operands == "" {
+			return name
+		}
+		return fmt.Sprintf("%-11s %s", name, operands)
+	}
+	return fmt.Sprintf("%-11s %-14s ; %s", name, operands, comment)
+}
+
+// formatConstant renders a constant as it would be written in source
+func formatConstant(v Value) string {
+	switch {
+	case IsString(v):
+		s := strconv.Quote(AsString(v).Value)
+		if len(s) > 40 {
+			s = s[:36] + `..."`
+		}
+		return s
+	case IsFunction(v):
+		return "function " + AsFunction(v).Name
+	}
+	return ToString(v)
+}
@@ -0,0 +1,160 @@
+package vmregister
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"sentra/internal/network"
+	"sentra/internal/sandbox"
+)
+
+// parseDNSOptions reads the optional options map at args[i]: server, doh,
+// tcp and timeout in milliseconds. A server or doh needs the net
+// permission of policy, which may be nil.
+func parseDNSOptions(name string, args []Value, i int, policy *sandbox.Policy) (network.DNSOptions, error) {
+	var opts network.DNSOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "server":
+			opts.Server = ToString(v)
+		case "doh":
+			opts.DoH = ToString(v)
+		case "tcp":
+			opts.TCP = IsTruthy(v)
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout must be a positive number of milliseconds", name)
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+	}
+	if opts.Server != "" && opts.DoH != "" {
+		return opts, fmt.Errorf("%s: give either server or doh, not both", name)
+	}
+	if resolver := opts.Resolver(); resolver != "" {
+		if err := policy.Check(sandbox.Net, resolver); err != nil {
+			return opts, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return opts, nil
+}
+
+// dnsRecordMap returns a record of dns_query as a map, with the fields of
+// its type
+func dnsRecordMap(record network.DNSRecord) Value {
+	m := map[string]Value{
+		"name":  BoxString(record.Name),
+		"type":  BoxString(record.Type),
+		"ttl":   BoxInt(int64(record.TTL)),
+		"value": BoxString(record.Value),
+	}
+	switch record.Type {
+	case "MX":
+		m["priority"] = BoxInt(int64(record.Priority))
+		m["target"] = BoxString(record.Target)
+	case "SRV":
+		m["priority"] = BoxInt(int64(record.Priority))
+		m["weight"] = BoxInt(int64(record.Weight))
+		m["port"] = BoxInt(int64(record.Port))
+		m["target"] = BoxString(record.Target)
+	case "CAA":
+		m["flags"] = BoxInt(int64(record.Flags))
+		m["tag"] = BoxString(record.Tag)
+		m["data"] = BoxString(record.Data)
+	}
+	return BoxMap(m)
+}
+
+// registerDNSFunctions registers DNS lookups, which can go to a server of
+// the script's choosing or over HTTPS where plain DNS is filtered
+func (vm *RegisterVM) registerDNSFunctions() {
+	netMod := vm.networkModule.(*network.NetworkModule)
+
+	stringArray := func(results []string) Value {
+		arr := make([]Value, len(results))
+		for i, result := range results {
+			arr[i] = BoxString(result)
+		}
+		return BoxArray(arr)
+	}
+
+	// dns_lookup(hostname, record_type, options?)
+	vm.registerGlobal("dns_lookup", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "dns_lookup",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("dns_lookup expects 2-3 arguments (hostname, record_type, options), got %d", len(args))
+			}
+			opts, err := parseDNSOptions("dns_lookup", args, 2, vm.permissions)
+			if err != nil {
+				return NilValue(), err
+			}
+			results, err := netMod.DNSLookup(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), err
+			}
+			return stringArray(results), nil
+		},
+	})
+
+	// dns_query(hostname, record_type, options?)
+	vm.registerGlobal("dns_query", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "dns_query",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("dns_query expects 2-3 arguments (hostname, record_type, options), got %d", len(args))
+			}
+			opts, err := parseDNSOptions("dns_query", args, 2, vm.permissions)
+			if err != nil {
+				return NilValue(), err
+			}
+			records, err := netMod.DNSQuery(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), err
+			}
+			arr := make([]Value, len(records))
+			for i, record := range records {
+				arr[i] = dnsRecordMap(record)
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// dns_reverse(ip, options?)
+	vm.registerGlobal("dns_reverse", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "dns_reverse",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("dns_reverse expects 1-2 arguments (ip, options), got %d", len(args))
+			}
+			ip := ToString(args[0])
+			if net.ParseIP(ip) == nil {
+				return NilValue(), fmt.Errorf("dns_reverse: not an IP address: %s", ip)
+			}
+			opts, err := parseDNSOptions("dns_reverse", args, 1, vm.permissions)
+			if err != nil {
+				return NilValue(), err
+			}
+			results, err := netMod.DNSLookup(ip, "PTR", opts)
+			if err != nil {
+				return NilValue(), err
+			}
+			return stringArray(results), nil
+		},
+	})
+}
@@ -0,0 +1,51 @@
+package vmregister_test
+
+import (
+	"os"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestEnvAndArgs(t *testing.T) {
+	t.Setenv("SENTRA_TEST_TARGET", "10.0.0.0/24")
+	t.Setenv("SENTRA_TEST_UNSET", "x")
+	source := `
+import os
+let target = env("SENTRA_TEST_TARGET")
+let missing = env("SENTRA_TEST_MISSING")
+let fallback = env("SENTRA_TEST_MISSING", "none")
+let listed = env()["SENTRA_TEST_TARGET"]
+set_env("SENTRA_TEST_SET", "yes")
+let set = os.getenv("SENTRA_TEST_SET")
+set_env("SENTRA_TEST_UNSET", null)
+let unset = env("SENTRA_TEST_UNSET")
+let count = len(args)
+let first = args[0]
+let module_args = os.args
+`
+	vm := vmregister.NewRegisterVM()
+	vm.SetArgs([]string{"scanme.example", "--fast"})
+	globals, err := execute(t, vm, source)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	t.Cleanup(func() { os.Unsetenv("SENTRA_TEST_SET") })
+
+	tests := map[string]string{
+		"target":      "10.0.0.0/24",
+		"missing":     "nil",
+		"fallback":    "none",
+		"listed":      "10.0.0.0/24",
+		"set":         "yes",
+		"unset":       "nil",
+		"count":       "2",
+		"first":       "scanme.example",
+		"module_args": "[scanme.example, --fast]",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
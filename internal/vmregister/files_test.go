@@ -0,0 +1,60 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestStreamingFileReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	if err := os.WriteFile(path, []byte("one\r\ntwo\nthree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	globals := run(t, `
+let path = r"`+path+`"
+let f = file_open(path)
+let lines = []
+let line = file_read_line(f)
+while line != null {
+    push(lines, line)
+    line = file_read_line(f)
+}
+let closed = file_close(f)
+
+let g = file_open(path)
+let chunks = []
+let chunk = file_read_chunk(g, 6)
+while chunk != null {
+    push(chunks, len(chunk))
+    chunk = file_read_chunk(g, 6)
+}
+file_close(g)
+
+let iterated = []
+for l in file_lines(path) { push(iterated, l) }
+let until_break = []
+import io
+let module_line = io.read_line(io.open(path))
+for i, l in io.lines(path) {
+    if l == "two" { break }
+    push(until_break, str(i) + ":" + l)
+}
+`)
+
+	tests := map[string]string{
+		"lines":       "[one, two, three]",
+		"closed":      "true",
+		"chunks":      "[6, 6, 2]",
+		"iterated":    "[one, two, three]",
+		"until_break": "[0:one]",
+		"module_line": "one",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// parseServiceProbe reads a probe definition: its name, payload, ports,
+// tls flag and matches, each with a pattern, service, product, version,
+// info and cpe
+func parseServiceProbe(name string, v Value) (*network.ServiceProbe, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("%s: probe must be a map, got %s", name, ValueType(v))
+	}
+	probe := &network.ServiceProbe{}
+	for key, field := range AsMap(v).Items {
+		switch key {
+		case "name":
+			probe.Name = ToString(field)
+		case "payload":
+			if IsBytes(field) {
+				probe.Payload = append([]byte{}, AsBytes(field).Data...)
+			} else if !IsNil(field) {
+				probe.Payload = []byte(ToString(field))
+			}
+		case "ports":
+			if !IsArray(field) {
+				return nil, fmt.Errorf("%s: probe ports must be an array", name)
+			}
+			for _, port := range AsArray(field).Elements {
+				if !(IsNumber(port) || IsInt(port)) || ToNumber(port) < 1 || ToNumber(port) > 65535 {
+					return nil, fmt.Errorf("%s: probe ports must be numbers from 1 to 65535", name)
+				}
+				probe.Ports = append(probe.Ports, int(ToNumber(port)))
+			}
+		case "tls":
+			probe.TLS = IsTruthy(field)
+		case "matches":
+			if !IsArray(field) {
+				return nil, fmt.Errorf("%s: probe matches must be an array", name)
+			}
+			for _, m := range AsArray(field).Elements {
+				if !IsMap(m) {
+					return nil, fmt.Errorf("%s: match must be a map, got %s", name, ValueType(m))
+				}
+				text := map[string]string{}
+				for k, f := range AsMap(m).Items {
+					switch k {
+					case "pattern", "service", "product", "version", "info", "cpe":
+						text[k] = ToString(f)
+					default:
+						return nil, fmt.Errorf("%s: unknown match field '%s'", name, k)
+					}
+				}
+				match, err := network.CompileServiceMatch(text["service"], text["pattern"], text["product"], text["version"], text["info"], text["cpe"])
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				probe.Matches = append(probe.Matches, match)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown probe field '%s'", name, key)
+		}
+	}
+	if probe.Name == "" {
+		return nil, fmt.Errorf("%s: probe needs a name", name)
+	}
+	if len(probe.Matches) == 0 {
+		return nil, fmt.Errorf("%s: probe '%s' needs matches", name, probe.Name)
+	}
+	return probe, nil
+}
+
+// parseFingerprintOptions reads the probes and timeout of an options map.
+// Other keys are passed to other, or rejected if it is nil.
+func parseFingerprintOptions(name string, options Value, other func(key string, v Value) error) (network.FingerprintOptions, error) {
+	var opts network.FingerprintOptions
+	if IsNil(options) {
+		return opts, nil
+	}
+	if !IsMap(options) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(options))
+	}
+	for key, v := range AsMap(options).Items {
+		switch key {
+		case "probes":
+			if !IsArray(v) {
+				return opts, fmt.Errorf("%s: probes must be an array of probe maps", name)
+			}
+			for _, p := range AsArray(v).Elements {
+				probe, err := parseServiceProbe(name, p)
+				if err != nil {
+					return opts, err
+				}
+				opts.Probes = append(opts.Probes, probe)
+			}
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout must be a positive number of milliseconds", name)
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			if other == nil {
+				return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+			if err := other(key, v); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,58 @@
+package vmregister_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestServiceFingerprint(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 16)
+			if n, _ := conn.Read(buf); string(buf[:n]) == "HELLO\r\n" {
+				conn.Write([]byte("+ACME 3.1.4 ready\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	globals := run(t, `
+let probe = {
+    "name": "acme",
+    "payload": "HELLO\r\n",
+    "ports": [`+port+`],
+    "matches": [{"pattern": "^\\+ACME ([\\d.]+)", "service": "acme", "product": "Acme Server", "version": "$1", "cpe": "a:acme:server"}]
+}
+let open = port_scan("127.0.0.1", `+port+`, `+port+`, {"timeout": 300, "probes": [probe]})
+let found = open[0]["service"] + " " + open[0]["product"] + " " + open[0]["version"]
+let cpe = open[0]["cpe"]
+let fp = scan_service_version("127.0.0.1", `+port+`, {"timeout": 300, "probes": [probe]})
+let via = fp["probe"] + " " + fp["banner"]
+let plain = port_scan("127.0.0.1", `+port+`, `+port+`, {"fingerprint": false})[0]["version"]
+`)
+
+	tests := map[string]string{
+		"found": "acme Acme Server 3.1.4",
+		"cpe":   "cpe:2.3:a:acme:server:3.1.4:*:*:*:*:*:*:*",
+		"via":   "acme +ACME 3.1.4 ready",
+		"plain": "",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
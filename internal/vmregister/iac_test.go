@@ -0,0 +1,65 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestIaCRules(t *testing.T) {
+	dir := t.TempDir()
+	tf := `resource "aws_s3_bucket" "logs" {
+  bucket = "logs"
+  acl    = "public-read"
+}
+
+resource "aws_s3_bucket" "owned" {
+  bucket = "owned"
+  tags = {
+    owner = "ops"
+  }
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(tf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	globals := run(t, `
+let id = iac_add_rule({
+    "id": "ORG-OWNER",
+    "title": "Resource has no owner tag",
+    "severity": "low",
+    "resource_types": ["aws_s3_bucket"],
+    "check": fn(r) {
+        let tags = r["attributes"]["tags"]
+        if tags == null { return "no tags on " + r["address"] }
+        return false
+    }
+})
+let report = iac_scan(r"`+dir+`")
+let found = []
+for f in report["findings"] {
+    push(found, f["rule_id"] + " " + f["resource"] + " " + f["description"])
+}
+let passed = report["passed"]
+let high = iac_scan(r"`+dir+`", {"severity": "high"})["summary"]["low"]
+let rules = len(iac_rules())
+let parsed = iac_parse(r"`+dir+`")["resources"][1]["attributes"]["tags"]["owner"]
+`)
+
+	tests := map[string]string{
+		"id":     "ORG-OWNER",
+		"found":  "[IAC-S3-PUBLIC-ACL aws_s3_bucket.logs acl is public-read, ORG-OWNER aws_s3_bucket.logs no tags on aws_s3_bucket.logs]",
+		"passed": "false",
+		"high":   "0",
+		"rules":  "10",
+		"parsed": "ops",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
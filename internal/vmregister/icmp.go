@@ -0,0 +1,147 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// milliseconds returns a duration as a number of milliseconds
+func milliseconds(d time.Duration) Value {
+	return BoxNumber(float64(d) / float64(time.Millisecond))
+}
+
+// positiveArg reads an optional positive number at args[i], or returns def
+func positiveArg(name, what string, args []Value, i int, def float64) (float64, error) {
+	if len(args) <= i || IsNil(args[i]) {
+		return def, nil
+	}
+	if !(IsNumber(args[i]) || IsInt(args[i])) || ToNumber(args[i]) <= 0 {
+		return 0, fmt.Errorf("%s: %s must be a positive number", name, what)
+	}
+	return ToNumber(args[i]), nil
+}
+
+// pingResultMap returns the outcome of a ping as a map
+func pingResultMap(result *network.PingResult) Value {
+	rtts := make([]Value, len(result.RTTs))
+	for i, rtt := range result.RTTs {
+		rtts[i] = milliseconds(rtt)
+	}
+	min, avg, max := result.Stats()
+	return BoxMap(map[string]Value{
+		"host":     BoxString(result.Host),
+		"ip":       BoxString(result.IP),
+		"alive":    BoxBool(result.Alive()),
+		"method":   BoxString(result.Method),
+		"sent":     BoxInt(int64(result.Sent)),
+		"received": BoxInt(int64(result.Received)),
+		"loss":     BoxNumber(result.Loss()),
+		"rtts":     BoxArray(rtts),
+		"min_ms":   milliseconds(min),
+		"avg_ms":   milliseconds(avg),
+		"max_ms":   milliseconds(max),
+	})
+}
+
+// registerICMPFunctions registers ping, ping sweeps and traceroute, which
+// send ICMP where the script may open raw sockets and fall back to UDP and
+// TCP probes where it may not
+func (vm *RegisterVM) registerICMPFunctions() {
+	netMod := vm.networkModule.(*network.NetworkModule)
+
+	// ping(host, count?, timeout_ms?)
+	vm.registerGlobal("ping", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ping",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("ping expects 1-3 arguments (host, count, timeout_ms), got %d", len(args))
+			}
+			host := ToString(args[0])
+			// With only a host, ping answers whether it is up, as it always has
+			if len(args) == 1 {
+				result, err := netMod.Ping(host, 1, 2*time.Second)
+				return BoxBool(err == nil && result.Alive()), nil
+			}
+			count, err := positiveArg("ping", "count", args, 1, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			timeoutMs, err := positiveArg("ping", "timeout", args, 2, 2000)
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := netMod.Ping(host, int(count), time.Duration(timeoutMs*float64(time.Millisecond)))
+			if err != nil {
+				return NilValue(), err
+			}
+			return pingResultMap(result), nil
+		},
+	})
+
+	// ping_sweep(subnet, timeout_ms?)
+	vm.registerGlobal("ping_sweep", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ping_sweep",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ping_sweep expects 1-2 arguments (subnet, timeout_ms), got %d", len(args))
+			}
+			timeoutMs, err := positiveArg("ping_sweep", "timeout", args, 1, 1000)
+			if err != nil {
+				return NilValue(), err
+			}
+			results, err := netMod.PingSweep(ToString(args[0]), time.Duration(timeoutMs*float64(time.Millisecond)))
+			if err != nil {
+				return NilValue(), err
+			}
+			arr := make([]Value, len(results))
+			for i := range results {
+				arr[i] = pingResultMap(&results[i])
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// traceroute(host, max_hops?, timeout_ms?)
+	vm.registerGlobal("traceroute", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "traceroute",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("traceroute expects 1-3 arguments (host, max_hops, timeout_ms), got %d", len(args))
+			}
+			maxHops, err := positiveArg("traceroute", "max_hops", args, 1, 30)
+			if err != nil {
+				return NilValue(), err
+			}
+			timeoutMs, err := positiveArg("traceroute", "timeout", args, 2, 2000)
+			if err != nil {
+				return NilValue(), err
+			}
+			hops, err := netMod.Traceroute(ToString(args[0]), int(maxHops), time.Duration(timeoutMs*float64(time.Millisecond)))
+			if err != nil {
+				return NilValue(), err
+			}
+			arr := make([]Value, len(hops))
+			for i, hop := range hops {
+				ip := NilValue()
+				if hop.IP != "" {
+					ip = BoxString(hop.IP)
+				}
+				arr[i] = BoxMap(map[string]Value{
+					"ttl":     BoxInt(int64(hop.TTL)),
+					"ip":      ip,
+					"rtt_ms":  milliseconds(hop.RTT),
+					"reached": BoxBool(hop.Reached),
+				})
+			}
+			return BoxArray(arr), nil
+		},
+	})
+}
@@ -0,0 +1,64 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sentra/internal/compregister"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	"sentra/internal/vmregister"
+)
+
+// importing returns a VM that loads file modules as sentra run does
+func importing() *vmregister.RegisterVM {
+	vm := vmregister.NewRegisterVM()
+	vm.SetModuleLoader(func(vm *vmregister.RegisterVM, path string) (*vmregister.FunctionObj, error) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		stmts := parser.NewParserWithSource(lexer.NewScanner(string(source)).ScanTokens(), string(source), path).Parse()
+		globalNames, nextID := vm.GetGlobalNames()
+		return compregister.NewCompilerWithGlobals(globalNames, nextID).Compile(stmts)
+	})
+	return vm
+}
+
+func TestSelectiveImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.Mkdir("lib", 0755)
+	lib := filepath.Join("lib", "net.sn")
+	os.WriteFile(lib, []byte("export fn scan(host) { return \"scanned \" + host }\nexport let PORT = 22\n"), 0644)
+	globals, err := execute(t, importing(), `
+import {sqrt, PI as pi} from math
+import {scan, PORT} from "`+lib+`"
+let root = sqrt(16)
+let circle = pi > 3
+let result = scan("10.0.0.1") + ":" + PORT
+`)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	for name, want := range map[string]string{
+		"root":   "4",
+		"circle": "true",
+		"result": "scanned 10.0.0.1:22",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	// A name the module does not export is an error, not nil
+	for source, want := range map[string]string{
+		"import {nope} from math":                   "module math has no export 'nope'",
+		`import {scan, missing} from "` + lib + `"`: "module " + lib + " has no export 'missing'",
+	} {
+		if _, err := execute(t, importing(), source); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", source, err, want)
+		}
+	}
+}
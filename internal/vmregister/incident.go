@@ -0,0 +1,743 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/incident"
+	"sentra/internal/memory"
+)
+
+// incidentTimeFormat is how the ir_ functions show times
+const incidentTimeFormat = "2006-01-02 15:04:05"
+
+// incidentSummaryValue returns the fields of an incident that lists show
+func incidentSummaryValue(inc *incident.Incident) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          inc.ID,
+		"title":       inc.Title,
+		"description": inc.Description,
+		"severity":    inc.Severity,
+		"status":      inc.Status,
+		"source":      inc.Source,
+		"category":    inc.Category,
+		"created_at":  inc.CreatedAt.Format(incidentTimeFormat),
+	}
+}
+
+// incidentValue returns an incident with its evidence, history and actions
+func incidentValue(inc *incident.Incident) Value {
+	result := incidentSummaryValue(inc)
+	result["updated_at"] = inc.UpdatedAt.Format(incidentTimeFormat)
+	result["assigned_to"] = inc.AssignedTo
+	artifacts := make([]interface{}, len(inc.Artifacts))
+	for i, a := range inc.Artifacts {
+		artifacts[i] = map[string]interface{}{
+			"id":           a.ID,
+			"type":         a.Type,
+			"value":        a.Value,
+			"description":  a.Description,
+			"source":       a.Source,
+			"collected_at": a.CollectedAt.Format(incidentTimeFormat),
+		}
+	}
+	result["artifacts"] = artifacts
+	timeline := make([]interface{}, len(inc.Timeline))
+	for i, e := range inc.Timeline {
+		timeline[i] = map[string]interface{}{
+			"id":          e.ID,
+			"timestamp":   e.Timestamp.Format(incidentTimeFormat),
+			"event":       e.Event,
+			"description": e.Description,
+			"actor":       e.Actor,
+			"source":      e.Source,
+		}
+	}
+	result["timeline"] = timeline
+	actions := make([]interface{}, len(inc.Actions))
+	for i, a := range inc.Actions {
+		actions[i] = map[string]interface{}{
+			"id":          a.ID,
+			"action_type": a.ActionType,
+			"description": a.Description,
+			"executed_at": a.ExecutedAt.Format(incidentTimeFormat),
+			"executed_by": a.ExecutedBy,
+			"status":      a.Status,
+			"result":      a.Result,
+		}
+	}
+	result["actions"] = actions
+	return goToValue(result)
+}
+
+// incidentResponseValue returns the result of a playbook or action
+func incidentResponseValue(r *incident.IncidentResponse) map[string]interface{} {
+	return map[string]interface{}{
+		"incident_id": r.IncidentID,
+		"action":      r.Action,
+		"status":      r.Status,
+		"message":     r.Message,
+		"executed_at": r.ExecutedAt.Format(incidentTimeFormat),
+	}
+}
+
+// playbookValue returns a playbook as a map
+func playbookValue(p *incident.Playbook) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          p.ID,
+		"name":        p.Name,
+		"description": p.Description,
+		"category":    p.Category,
+		"is_active":   p.IsActive,
+		"created_at":  p.CreatedAt.Format(incidentTimeFormat),
+	}
+}
+
+// mapText returns a string field of a map, empty when it is missing or nil
+func mapText(m map[string]Value, key string) string {
+	v, ok := m[key]
+	if !ok || IsNil(v) {
+		return ""
+	}
+	return ToString(v)
+}
+
+// stringsValue returns strings as an array for goToValue
+func stringsValue(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
+
+// timelineTime reads a time given as Unix seconds, RFC 3339, the
+// "2006-01-02 15:04:05" of the ir_ functions or a date
+func timelineTime(v Value) (time.Time, bool) {
+	if IsNumber(v) || IsInt(v) {
+		secs := ToNumber(v)
+		if secs == 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(secs*1e9)).UTC(), true
+	}
+	s := ToString(v)
+	for _, layout := range []string{time.RFC3339Nano, incidentTimeFormat, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timelineEntryValue returns a timeline entry as a map
+func timelineEntryValue(e *incident.TimelineEntry) map[string]interface{} {
+	at, stamp := "", int64(0)
+	if !e.Time.IsZero() {
+		at, stamp = e.Time.Format(time.RFC3339Nano), e.Time.Unix()
+	}
+	return map[string]interface{}{
+		"time":      at,
+		"timestamp": stamp,
+		"source":    e.Source,
+		"type":      e.Type,
+		"host":      e.Host,
+		"user":      e.User,
+		"summary":   e.Summary,
+		"details":   e.Details,
+	}
+}
+
+// timelineEntry reads a timeline entry back from its map, as returned by
+// ir_build_timeline
+func timelineEntry(v Value) *incident.TimelineEntry {
+	m := AsMap(v).Items
+	e := &incident.TimelineEntry{
+		Source:  mapText(m, "source"),
+		Type:    mapText(m, "type"),
+		Host:    mapText(m, "host"),
+		User:    mapText(m, "user"),
+		Summary: mapText(m, "summary"),
+		Details: map[string]interface{}{},
+	}
+	e.Time, _ = timelineTime(m["time"])
+	if d, ok := m["details"]; ok && IsMap(d) {
+		for k, v := range AsMap(d).Items {
+			e.Details[k] = valueToGo(v)
+		}
+	}
+	return e
+}
+
+// siemTimelineEntry turns an event of siem_parse_log into a timeline entry
+func siemTimelineEntry(v Value) *incident.TimelineEntry {
+	m := AsMap(v).Items
+	e := &incident.TimelineEntry{Source: "siem", Type: mapText(m, "event_type"), Host: mapText(m, "host"),
+		Summary: mapText(m, "message"), Details: map[string]interface{}{}}
+	if e.Type == "" {
+		e.Type = "event"
+	}
+	e.Time, _ = timelineTime(m["timestamp"])
+	for _, key := range []string{"source", "level", "severity", "category", "fields"} {
+		if f, ok := m[key]; ok {
+			name := key
+			if key == "source" {
+				name = "log_source"
+			}
+			e.Details[name] = valueToGo(f)
+		}
+	}
+	if fields, ok := m["fields"]; ok && IsMap(fields) {
+		if user, ok := AsMap(fields).Items["user"]; ok {
+			e.User = ToString(user)
+		}
+	}
+	return e
+}
+
+// artifactTimelineEntry turns a record of the forensics_ functions into a
+// timeline entry
+func artifactTimelineEntry(v Value) *incident.TimelineEntry {
+	m := AsMap(v).Items
+	e := &incident.TimelineEntry{Source: mapText(m, "source"), Type: mapText(m, "type"), User: mapText(m, "user"),
+		Summary: mapText(m, "summary"), Details: map[string]interface{}{"path": mapText(m, "path")}}
+	e.Time, _ = timelineTime(m["time"])
+	if d, ok := m["data"]; ok && IsMap(d) {
+		for k, v := range AsMap(d).Items {
+			e.Details[k] = valueToGo(v)
+		}
+	}
+	return e
+}
+
+// imageTimeline returns the process starts recorded in a memory image
+func imageTimeline(img *memory.DumpImage) ([]*incident.TimelineEntry, error) {
+	processes, err := img.Processes()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*incident.TimelineEntry
+	for _, p := range processes {
+		if p.CreateTime.IsZero() {
+			continue
+		}
+		entries = append(entries, &incident.TimelineEntry{Time: p.CreateTime, Source: "memory", Type: "process_start",
+			Summary: fmt.Sprintf("%s (%d)", p.Name, p.PID),
+			Details: map[string]interface{}{"image": img.ID, "pid": p.PID, "ppid": p.ParentPID, "name": p.Name, "exited": p.Exited}})
+	}
+	return entries, nil
+}
+
+// scriptExecutor is an executor a script registers: a handler called
+// with the action, the target, the parameters and whether it is a dry
+// run, which returns what it did
+type scriptExecutor struct {
+	vm      *RegisterVM
+	actions []string
+	handler Value
+}
+
+func (s *scriptExecutor) Kind() string { return "script" }
+
+func (s *scriptExecutor) Actions() []string { return s.actions }
+
+func (s *scriptExecutor) Execute(action, target string, params map[string]interface{}, dryRun bool) (string, error) {
+	result, err := s.vm.callValue(s.handler, []Value{BoxString(action), BoxString(target), goToValue(params), BoxBool(dryRun)})
+	if err != nil {
+		return "", err
+	}
+	return ToString(result), nil
+}
+
+// executorInfoValue returns a registered executor as a map
+func executorInfoValue(info incident.ExecutorInfo) map[string]interface{} {
+	actions := make([]interface{}, len(info.Actions))
+	for i, a := range info.Actions {
+		actions[i] = a
+	}
+	return map[string]interface{}{
+		"name":    info.Name,
+		"type":    info.Kind,
+		"actions": actions,
+		"dry_run": info.DryRun,
+	}
+}
+
+// registerIncidentFunctions registers the ir_ functions, which share the
+// incidents of incident_create, and the timeline builder that merges an
+// incident's history with the other evidence of an investigation
+func (vm *RegisterVM) registerIncidentFunctions() {
+	incidents := func() *incident.IncidentModule {
+		return vm.incidentModule.(*incident.IncidentModule)
+	}
+	incidents().CreateDefaultPlaybooks()
+	incidents().CreateDefaultResponseActions()
+
+	vm.registerGlobal("ir_create_incident", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_create_incident",
+		Arity:  4,
+		Function: func(args []Value) (Value, error) {
+			inc := incidents().CreateIncident(ToString(args[0]), ToString(args[1]), ToString(args[2]), ToString(args[3]))
+			return goToValue(incidentSummaryValue(inc)), nil
+		},
+	})
+
+	vm.registerGlobal("ir_get_incident", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_get_incident",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			inc, err := incidents().GetIncident(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_get_incident: %v", err)
+			}
+			return incidentValue(inc), nil
+		},
+	})
+
+	vm.registerGlobal("ir_update_incident", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_update_incident",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[1]) {
+				return NilValue(), fmt.Errorf("ir_update_incident: updates must be a map, got %s", ValueType(args[1]))
+			}
+			updates := make(map[string]interface{})
+			for k, v := range AsMap(args[1]).Items {
+				updates[k] = ToString(v)
+			}
+			if err := incidents().UpdateIncident(ToString(args[0]), updates); err != nil {
+				return NilValue(), fmt.Errorf("ir_update_incident: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("ir_close_incident", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_close_incident",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if err := incidents().CloseIncident(ToString(args[0]), ToString(args[1])); err != nil {
+				return NilValue(), fmt.Errorf("ir_close_incident: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("ir_list_incidents", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_list_incidents",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			filters := make(map[string]string)
+			if IsMap(args[0]) {
+				for k, v := range AsMap(args[0]).Items {
+					filters[k] = ToString(v)
+				}
+			}
+			list := incidents().ListIncidents(filters)
+			result := make([]interface{}, len(list))
+			for i, inc := range list {
+				result[i] = incidentSummaryValue(inc)
+			}
+			return goToValue(result), nil
+		},
+	})
+
+	vm.registerGlobal("ir_collect_evidence", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_collect_evidence",
+		Arity:  4,
+		Function: func(args []Value) (Value, error) {
+			err := incidents().CollectEvidence(ToString(args[0]), ToString(args[1]), ToString(args[2]), ToString(args[3]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_collect_evidence: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("ir_create_playbook", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_create_playbook",
+		Arity:  4,
+		Function: func(args []Value) (Value, error) {
+			var steps []map[string]interface{}
+			if IsArray(args[3]) {
+				for _, element := range AsArray(args[3]).Elements {
+					if !IsMap(element) {
+						return NilValue(), fmt.Errorf("ir_create_playbook: steps must be maps, got %s", ValueType(element))
+					}
+					step := map[string]interface{}{"name": "", "description": "", "action": ""}
+					for k, v := range AsMap(element).Items {
+						if k == "parameters" {
+							step[k] = valueToGo(v)
+						} else {
+							step[k] = ToString(v)
+						}
+					}
+					steps = append(steps, step)
+				}
+			}
+			p := incidents().CreatePlaybook(ToString(args[0]), ToString(args[1]), ToString(args[2]), steps)
+			return goToValue(playbookValue(p)), nil
+		},
+	})
+
+	vm.registerGlobal("ir_list_playbooks", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_list_playbooks",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			playbooks := incidents().ListPlaybooks()
+			result := make([]interface{}, len(playbooks))
+			for i, p := range playbooks {
+				result[i] = playbookValue(p)
+			}
+			return goToValue(result), nil
+		},
+	})
+
+	vm.registerGlobal("ir_execute_playbook", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_execute_playbook",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			response, err := incidents().ExecutePlaybook(ToString(args[0]), ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_execute_playbook: %v", err)
+			}
+			result := incidentResponseValue(response)
+			result["evidence"] = stringsValue(response.Evidence)
+			result["next_steps"] = stringsValue(response.NextSteps)
+			return goToValue(result), nil
+		},
+	})
+
+	vm.registerGlobal("ir_execute_action", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_execute_action",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			parameters := make(map[string]interface{})
+			if IsMap(args[2]) {
+				for k, v := range AsMap(args[2]).Items {
+					parameters[k] = valueToGo(v)
+				}
+			}
+			response, err := incidents().ExecuteResponseAction(ToString(args[0]), ToString(args[1]), parameters)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_execute_action: %v", err)
+			}
+			return goToValue(incidentResponseValue(response)), nil
+		},
+	})
+
+	vm.registerGlobal("ir_get_metrics", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_get_metrics",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			return goToValue(incidents().GetIncidentMetrics()), nil
+		},
+	})
+
+	// ir_build_timeline(incident_id, options?) merges the incident's history
+	// with SIEM events, file times, memory images and collected artifacts
+	vm.registerGlobal("ir_build_timeline", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_build_timeline",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ir_build_timeline expects 1-2 arguments (incident_id, options), got %d", len(args))
+			}
+			var opts incident.TimelineOptions
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("ir_build_timeline: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "events", "artifacts", "entries":
+						if !IsArray(v) {
+							return NilValue(), fmt.Errorf("ir_build_timeline: %s must be an array, got %s", key, ValueType(v))
+						}
+						for _, item := range AsArray(v).Elements {
+							if !IsMap(item) {
+								return NilValue(), fmt.Errorf("ir_build_timeline: %s must hold maps, got %s", key, ValueType(item))
+							}
+							switch key {
+							case "events":
+								opts.Entries = append(opts.Entries, siemTimelineEntry(item))
+							case "artifacts":
+								opts.Entries = append(opts.Entries, artifactTimelineEntry(item))
+							default:
+								opts.Entries = append(opts.Entries, timelineEntry(item))
+							}
+						}
+					case "paths":
+						opts.Paths = stringList(v)
+					case "images":
+						ef := vm.memoryModule.(*memory.IntegratedMemoryModule).EnhancedForensics
+						for _, id := range stringList(v) {
+							img, err := ef.Image(id)
+							if err != nil {
+								return NilValue(), fmt.Errorf("ir_build_timeline: %v", err)
+							}
+							entries, err := imageTimeline(img)
+							if err != nil {
+								return NilValue(), fmt.Errorf("ir_build_timeline: %s: %v", id, err)
+							}
+							opts.Entries = append(opts.Entries, entries...)
+						}
+					case "start", "end":
+						t, ok := timelineTime(v)
+						if !ok {
+							return NilValue(), fmt.Errorf("ir_build_timeline: %s must be Unix seconds or an RFC 3339 time, got '%s'", key, ToString(v))
+						}
+						if key == "start" {
+							opts.Start = t
+						} else {
+							opts.End = t
+						}
+					default:
+						return NilValue(), fmt.Errorf("ir_build_timeline: unknown option '%s'", key)
+					}
+				}
+			}
+			entries, err := incidents().BuildTimeline(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_build_timeline: %v", err)
+			}
+			list := make([]interface{}, len(entries))
+			for i, e := range entries {
+				list[i] = timelineEntryValue(e)
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// ir_export_timeline(timeline, format, path) writes the entries of
+	// ir_build_timeline as csv or json
+	vm.registerGlobal("ir_export_timeline", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_export_timeline",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			if !IsArray(args[0]) {
+				return NilValue(), fmt.Errorf("ir_export_timeline: timeline must be an array, got %s", ValueType(args[0]))
+			}
+			var entries []*incident.TimelineEntry
+			for _, item := range AsArray(args[0]).Elements {
+				if !IsMap(item) {
+					return NilValue(), fmt.Errorf("ir_export_timeline: timeline must hold maps, got %s", ValueType(item))
+				}
+				entries = append(entries, timelineEntry(item))
+			}
+			if err := incident.ExportTimeline(entries, ToString(args[1]), ToString(args[2])); err != nil {
+				return NilValue(), fmt.Errorf("ir_export_timeline: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// ir_register_executor(name, type, options?) sets up an executor of
+	// response actions: one of incident.ExecutorKinds, or script with a
+	// handler function. With dry_run set it never changes anything.
+	vm.registerGlobal("ir_register_executor", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_register_executor",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("ir_register_executor expects 2-3 arguments (name, type, options), got %d", len(args))
+			}
+			name, kind := ToString(args[0]), ToString(args[1])
+			if name == "" {
+				return NilValue(), fmt.Errorf("ir_register_executor: name must not be empty")
+			}
+			var opts incident.ExecutorOptions
+			var actions []string
+			var handler Value
+			hasHandler, dryRun := false, false
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("ir_register_executor: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "url":
+						opts.URL = ToString(v)
+					case "auth_url":
+						opts.AuthURL = ToString(v)
+					case "api_key":
+						opts.APIKey = ToString(v)
+					case "token":
+						opts.Token = ToString(v)
+					case "tenant_id":
+						opts.TenantID = ToString(v)
+					case "client_id":
+						opts.ClientID = ToString(v)
+					case "client_secret":
+						opts.ClientSecret = ToString(v)
+					case "tag":
+						opts.Tag = ToString(v)
+					case "table":
+						opts.Table = ToString(v)
+					case "chain":
+						opts.Chain = ToString(v)
+					case "host":
+						opts.Host = ToString(v)
+					case "tls":
+						opts.TLS = ToString(v)
+					case "username":
+						opts.Username = ToString(v)
+					case "password":
+						opts.Password = ToString(v)
+					case "domain":
+						opts.Domain = ToString(v)
+					case "base_dn":
+						opts.BaseDN = ToString(v)
+					case "insecure":
+						opts.Insecure = IsTruthy(v)
+					case "dry_run":
+						dryRun = IsTruthy(v)
+					case "port", "timeout_ms":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("ir_register_executor: %s must be a positive number", key)
+						}
+						if key == "port" {
+							opts.Port = int(ToNumber(v))
+						} else {
+							opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+						}
+					case "actions":
+						actions = stringList(v)
+					case "handler":
+						handler, hasHandler = v, true
+					default:
+						return NilValue(), fmt.Errorf("ir_register_executor: unknown option '%s'", key)
+					}
+				}
+			}
+			var executor incident.Executor
+			if kind == "script" {
+				if !hasHandler || !IsPointer(handler) || !isCallableType(AsObject(handler).Type) {
+					return NilValue(), fmt.Errorf("ir_register_executor: a script executor needs a handler function")
+				}
+				if len(actions) == 0 {
+					return NilValue(), fmt.Errorf("ir_register_executor: a script executor needs the actions it carries out")
+				}
+				executor = &scriptExecutor{vm: vm, actions: actions, handler: handler}
+			} else {
+				if hasHandler || actions != nil {
+					return NilValue(), fmt.Errorf("ir_register_executor: only script executors take a handler and actions")
+				}
+				var err error
+				if executor, err = incident.NewExecutor(kind, opts); err != nil {
+					return NilValue(), fmt.Errorf("ir_register_executor: %v", err)
+				}
+			}
+			incidents().RegisterExecutor(name, executor, dryRun)
+			return goToValue(executorInfoValue(incident.ExecutorInfo{Name: name, Kind: executor.Kind(), Actions: executor.Actions(), DryRun: dryRun})), nil
+		},
+	})
+
+	vm.registerGlobal("ir_list_executors", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_list_executors",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			executors := incidents().ListExecutors()
+			list := make([]interface{}, len(executors))
+			for i, info := range executors {
+				list[i] = executorInfoValue(info)
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// ir_execute(incident_id, executor, action, target, options?) has an
+	// executor carry out an action for an incident, or with dry_run say
+	// what it would do. Every attempt is audited.
+	vm.registerGlobal("ir_execute", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_execute",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 4 || len(args) > 5 {
+				return NilValue(), fmt.Errorf("ir_execute expects 4-5 arguments (incident_id, executor, action, target, options), got %d", len(args))
+			}
+			params := make(map[string]interface{})
+			dryRun := false
+			if len(args) == 5 {
+				if !IsMap(args[4]) {
+					return NilValue(), fmt.Errorf("ir_execute: options must be a map, got %s", ValueType(args[4]))
+				}
+				for key, v := range AsMap(args[4]).Items {
+					switch key {
+					case "dry_run":
+						dryRun = IsTruthy(v)
+					case "comment", "isolation_type":
+						params[key] = ToString(v)
+					case "params":
+						if !IsMap(v) {
+							return NilValue(), fmt.Errorf("ir_execute: params must be a map, got %s", ValueType(v))
+						}
+						for k, p := range AsMap(v).Items {
+							params[k] = valueToGo(p)
+						}
+					default:
+						return NilValue(), fmt.Errorf("ir_execute: unknown option '%s'", key)
+					}
+				}
+			}
+			response, err := incidents().RunAction(ToString(args[0]), ToString(args[1]), ToString(args[2]), ToString(args[3]), params, dryRun)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ir_execute: %v", err)
+			}
+			return goToValue(incidentResponseValue(response)), nil
+		},
+	})
+
+	// ir_audit_log(incident_id?) returns the actions given to executors,
+	// those of one incident if it is given
+	vm.registerGlobal("ir_audit_log", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_audit_log",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("ir_audit_log expects 0-1 arguments (incident_id), got %d", len(args))
+			}
+			id := ""
+			if len(args) == 1 {
+				id = ToString(args[0])
+			}
+			entries := incidents().AuditLog(id)
+			list := make([]interface{}, len(entries))
+			for i, e := range entries {
+				list[i] = incident.AuditEntryToMap(e)
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// ir_set_audit_file(path) also appends each audit entry to a file as a
+	// line of JSON; an empty path stops it
+	vm.registerGlobal("ir_set_audit_file", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ir_set_audit_file",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if err := incidents().SetAuditFile(ToString(args[0])); err != nil {
+				return NilValue(), fmt.Errorf("ir_set_audit_file: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+}
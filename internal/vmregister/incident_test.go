@@ -0,0 +1,98 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sentra/internal/vmregister"
+)
+
+func TestIncidentTimeline(t *testing.T) {
+	dir := t.TempDir()
+	dropped := filepath.Join(dir, "dropper.sh")
+	if err := os.WriteFile(dropped, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dropTime := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	os.Chtimes(dropped, dropTime, dropTime)
+	os.Chtimes(dir, dropTime.Add(-30*time.Minute), dropTime.Add(-30*time.Minute))
+	out := filepath.Join(t.TempDir(), "timeline.csv")
+	globals := run(t, `
+let inc = ir_create_incident("Beacon", "Host calling out", "high", "edr")
+ir_collect_evidence(inc["id"], "file", "`+dropped+`", "triage")
+let events = [{"timestamp": "2026-03-04T09:00:00Z", "host": "web1", "event_type": "authentication", "message": "Accepted password for root", "fields": {"user": "root"}}]
+let timeline = ir_build_timeline(inc["id"], {"events": events, "paths": ["`+dir+`"]})
+let order = timeline[0]["source"] + " " + timeline[0]["user"] + " " + timeline[1]["summary"] + " " + timeline[2]["time"] + " " + timeline[3]["source"]
+let windowed = len(ir_build_timeline(inc["id"], {"events": events, "start": "2026-03-04", "end": "2026-03-04T09:30:00Z"}))
+let exported = ir_export_timeline(timeline, "csv", "`+out+`")
+`)
+	if got, want := vmregister.ToString(globals["order"]), "siem root "+dir+" 2026-03-04T10:00:00Z incident"; got != want {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+	if got := vmregister.ToNumber(globals["windowed"]); got != 1 {
+		t.Errorf("windowed = %v, want 1", got)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "time,source,type,host,user,summary,details\n2026-03-04T09:00:00Z,siem,authentication,web1,root,") {
+		t.Errorf("exported csv = %q", data)
+	}
+
+	expectErrors(t, map[string]string{
+		`ir_build_timeline("INC-0")`: "ir_build_timeline: incident not found: INC-0",
+		`ir_build_timeline(ir_create_incident("a", "b", "low", "c")["id"], {"hosts": []})`:          "ir_build_timeline: unknown option 'hosts'",
+		`ir_build_timeline(ir_create_incident("a", "b", "low", "c")["id"], {"events": "x"})`:        "ir_build_timeline: events must be an array, got string",
+		`ir_build_timeline(ir_create_incident("a", "b", "low", "c")["id"], {"start": "yesterday"})`: "ir_build_timeline: start must be Unix seconds or an RFC 3339 time, got 'yesterday'",
+		`ir_export_timeline([], "xml", "` + out + `")`:                                              "ir_export_timeline: unknown timeline format 'xml'",
+	})
+}
+
+func TestResponseExecutors(t *testing.T) {
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	globals := run(t, `
+ir_set_audit_file("`+audit+`")
+let inc = ir_create_incident("Phish", "Credentials entered", "high", "mail")
+let disabled = []
+fn disable(action, target, params, dry_run) {
+    if !dry_run {
+        push(disabled, target)
+    }
+    return action + " " + target + " " + params["incident_id"]
+}
+ir_register_executor("idp", "script", {"actions": ["disable_account"], "handler": disable})
+let fw = ir_register_executor("fw", "iptables", {"dry_run": true})
+let done = ir_execute(inc["id"], "idp", "disable_account", "jdoe")
+let rehearsed = ir_execute(inc["id"], "fw", "block_ip", "203.0.113.7")
+let routed = ir_execute_action(inc["id"], "RA-003", {"executor": "idp", "user": "asmith", "dry_run": true})
+let entries = ir_audit_log(inc["id"])
+let summary = done["status"] + " " + rehearsed["status"] + " " + routed["status"] + " " + str(len(disabled)) + " " + str(len(entries)) + " " + fw["actions"][0]
+let result = done["message"]
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "success dry_run dry_run 1 3 block_ip"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got := vmregister.ToString(globals["result"]); !strings.HasPrefix(got, "disable_account jdoe INC-") {
+		t.Errorf("result = %q", got)
+	}
+	if data, err := os.ReadFile(audit); err != nil || strings.Count(string(data), "\n") != 3 {
+		t.Errorf("audit file = %q, %v", data, err)
+	}
+
+	expectErrors(t, map[string]string{
+		`ir_register_executor("x", "checkpoint")`:                                                   "ir_register_executor: unknown executor type 'checkpoint'",
+		`ir_register_executor("x", "okta", {"url": "https://o", "key": 1})`:                         "ir_register_executor: unknown option 'key'",
+		`ir_register_executor("x", "script", {"actions": ["block_ip"]})`:                            "ir_register_executor: a script executor needs a handler function",
+		`ir_register_executor("x", "pf", {"actions": ["block_ip"]})`:                                "ir_register_executor: only script executors take a handler and actions",
+		`ir_execute(ir_create_incident("a", "b", "low", "c")["id"], "edr", "isolate_host", "web1")`: "ir_execute: executor not found: edr",
+		`ir_register_executor("fw", "iptables")
+ir_execute(ir_create_incident("a", "b", "low", "c")["id"], "fw", "isolate_host", "web1")`: "ir_execute: executor fw does not carry out isolate_host, only block_ip, unblock_ip",
+		`ir_register_executor("fw", "iptables")
+ir_execute(ir_create_incident("a", "b", "low", "c")["id"], "fw", "block_ip", "evil.example", {"dry_run": true})`: "ir_execute: fw: 'evil.example' is not an IP address or network",
+		`ir_execute("INC-0", "fw", "block_ip", "1.2.3.4", {"force": true})`: "ir_execute: unknown option 'force'",
+	})
+}
@@ -0,0 +1,504 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// brokerOptions holds the connection options shared by the MQTT and AMQP
+// functions
+type brokerOptions struct {
+	port     int
+	tls      bool
+	ca       string
+	cert     string
+	key      string
+	insecure bool
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// parseBrokerOptions reads the optional options map at args[i]: port,
+// tls, ca, cert, key, insecure, username, password and timeout in
+// milliseconds. Other keys go to other, which returns an error for those
+// it does not know.
+func parseBrokerOptions(name string, args []Value, i int, other func(key string, v Value) error) (brokerOptions, error) {
+	var opts brokerOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "port":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 || ToNumber(v) > 65535 {
+				return opts, fmt.Errorf("%s: port must be a number from 1 to 65535", name)
+			}
+			opts.port = int(ToNumber(v))
+		case "tls":
+			opts.tls = IsTruthy(v)
+		case "ca":
+			opts.ca = ToString(v)
+		case "cert":
+			opts.cert = ToString(v)
+		case "key":
+			opts.key = ToString(v)
+		case "insecure":
+			opts.insecure = IsTruthy(v)
+		case "username":
+			opts.username = ToString(v)
+		case "password":
+			opts.password = ToString(v)
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout must be a positive number of milliseconds", name)
+			}
+			opts.timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			if other == nil {
+				return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+			if err := other(key, v); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// mqttOptions reads the options of mqtt_connect, and with audit those of
+// an MQTT iot_protocol_security audit: topics, publish_topics and wait
+func mqttOptions(name string, args []Value, i int, audit bool) (network.MQTTAuditOptions, error) {
+	var opts network.MQTTAuditOptions
+	conn, err := parseBrokerOptions(name, args, i, func(key string, v Value) error {
+		switch {
+		case key == "client_id":
+			opts.ClientID = ToString(v)
+		case audit && key == "topics":
+			opts.Topics = stringList(v)
+		case audit && key == "publish_topics":
+			opts.PublishTopics = stringList(v)
+		case audit && key == "wait":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return fmt.Errorf("%s: wait must be a positive number of milliseconds", name)
+			}
+			opts.Wait = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return opts, err
+	}
+	opts.Port, opts.TLS, opts.CA, opts.Cert, opts.Key, opts.Insecure = conn.port, conn.tls, conn.ca, conn.cert, conn.key, conn.insecure
+	opts.Username, opts.Password, opts.Timeout = conn.username, conn.password, conn.timeout
+	return opts, nil
+}
+
+// amqpOptions reads the options of amqp_connect, and with audit those of
+// an AMQP iot_protocol_security audit: queues
+func amqpOptions(name string, args []Value, i int, audit bool) (network.AMQPAuditOptions, error) {
+	var opts network.AMQPAuditOptions
+	conn, err := parseBrokerOptions(name, args, i, func(key string, v Value) error {
+		switch {
+		case key == "vhost":
+			opts.VHost = ToString(v)
+		case audit && key == "queues":
+			opts.Queues = stringList(v)
+		default:
+			return fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return opts, err
+	}
+	opts.Port, opts.TLS, opts.CA, opts.Cert, opts.Key, opts.Insecure = conn.port, conn.tls, conn.ca, conn.cert, conn.key, conn.insecure
+	opts.Username, opts.Password, opts.Timeout = conn.username, conn.password, conn.timeout
+	return opts, nil
+}
+
+// qosArg reads an optional MQTT QoS level
+func qosArg(name string, v Value) (int, error) {
+	if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 || ToNumber(v) > 2 {
+		return 0, fmt.Errorf("%s: qos must be 0, 1 or 2", name)
+	}
+	return int(ToNumber(v)), nil
+}
+
+// registerIoTFunctions registers the MQTT and AMQP clients, and the live
+// broker audits behind iot_protocol_security, for testing anonymous
+// access, default credentials and topic ACLs of IoT brokers
+func (vm *RegisterVM) registerIoTFunctions() {
+	// mqtt_connect(host, options?)
+	vm.registerGlobal("mqtt_connect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mqtt_connect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mqtt_connect expects 1-2 arguments (host, options), got %d", len(args))
+			}
+			opts, err := mqttOptions("mqtt_connect", args, 1, false)
+			if err != nil {
+				return NilValue(), err
+			}
+			c, err := network.MQTTConnect(ToString(args[0]), opts.MQTTOptions)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_connect: %v", err)
+			}
+			return BoxString(c.ID), nil
+		},
+	})
+
+	// mqtt_subscribe(conn, topics, qos?)
+	vm.registerGlobal("mqtt_subscribe", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mqtt_subscribe",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("mqtt_subscribe expects 2-3 arguments (conn, topics, qos), got %d", len(args))
+			}
+			c, err := network.LookupMQTT(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_subscribe: %v", err)
+			}
+			qos := 0
+			if len(args) > 2 && !IsNil(args[2]) {
+				if qos, err = qosArg("mqtt_subscribe", args[2]); err != nil {
+					return NilValue(), err
+				}
+			}
+			subs, err := c.Subscribe(stringList(args[1]), qos)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_subscribe: %v", err)
+			}
+			arr := make([]Value, len(subs))
+			for i, s := range subs {
+				arr[i] = goToValue(network.MQTTSubscriptionToMap(s))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// mqtt_publish(conn, topic, payload, options?)
+	vm.registerGlobal("mqtt_publish", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mqtt_publish",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 3 || len(args) > 4 {
+				return NilValue(), fmt.Errorf("mqtt_publish expects 3-4 arguments (conn, topic, payload, options), got %d", len(args))
+			}
+			c, err := network.LookupMQTT(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_publish: %v", err)
+			}
+			qos, retain := 0, false
+			if len(args) > 3 && !IsNil(args[3]) {
+				if !IsMap(args[3]) {
+					return NilValue(), fmt.Errorf("mqtt_publish: options must be a map, got %s", ValueType(args[3]))
+				}
+				for key, v := range AsMap(args[3]).Items {
+					switch key {
+					case "qos":
+						if qos, err = qosArg("mqtt_publish", v); err != nil {
+							return NilValue(), err
+						}
+					case "retain":
+						retain = IsTruthy(v)
+					default:
+						return NilValue(), fmt.Errorf("mqtt_publish: unknown option '%s'", key)
+					}
+				}
+			}
+			if err := c.Publish(ToString(args[1]), []byte(ToString(args[2])), qos, retain); err != nil {
+				return NilValue(), fmt.Errorf("mqtt_publish: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// mqtt_messages(conn, options?)
+	vm.registerGlobal("mqtt_messages", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mqtt_messages",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mqtt_messages expects 1-2 arguments (conn, options), got %d", len(args))
+			}
+			c, err := network.LookupMQTT(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_messages: %v", err)
+			}
+			var wait time.Duration
+			max := 0
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("mqtt_messages: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "wait":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+							return NilValue(), fmt.Errorf("mqtt_messages: wait must be a number of milliseconds")
+						}
+						wait = time.Duration(ToNumber(v) * float64(time.Millisecond))
+					case "max":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+							return NilValue(), fmt.Errorf("mqtt_messages: max must be a positive number")
+						}
+						max = int(ToNumber(v))
+					default:
+						return NilValue(), fmt.Errorf("mqtt_messages: unknown option '%s'", key)
+					}
+				}
+			}
+			msgs := c.Messages(wait, max)
+			arr := make([]Value, len(msgs))
+			for i, m := range msgs {
+				arr[i] = goToValue(network.MQTTMessageToMap(m))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// mqtt_close(conn)
+	vm.registerGlobal("mqtt_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mqtt_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupMQTT(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mqtt_close: %v", err)
+			}
+			c.Close()
+			return BoxBool(true), nil
+		},
+	})
+
+	// amqp_connect(host, options?)
+	vm.registerGlobal("amqp_connect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "amqp_connect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("amqp_connect expects 1-2 arguments (host, options), got %d", len(args))
+			}
+			opts, err := amqpOptions("amqp_connect", args, 1, false)
+			if err != nil {
+				return NilValue(), err
+			}
+			c, err := network.AMQPConnect(ToString(args[0]), opts.AMQPOptions)
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_connect: %v", err)
+			}
+			return BoxString(c.ID), nil
+		},
+	})
+
+	// amqp_declare_queue(conn, queue, options?)
+	vm.registerGlobal("amqp_declare_queue", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "amqp_declare_queue",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("amqp_declare_queue expects 2-3 arguments (conn, queue, options), got %d", len(args))
+			}
+			c, err := network.LookupAMQP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_declare_queue: %v", err)
+			}
+			var durable, autoDelete, passive bool
+			if len(args) > 2 && !IsNil(args[2]) {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("amqp_declare_queue: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "durable":
+						durable = IsTruthy(v)
+					case "auto_delete":
+						autoDelete = IsTruthy(v)
+					case "passive":
+						passive = IsTruthy(v)
+					default:
+						return NilValue(), fmt.Errorf("amqp_declare_queue: unknown option '%s'", key)
+					}
+				}
+			}
+			q, err := c.DeclareQueue(ToString(args[1]), durable, autoDelete, passive)
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_declare_queue: %v", err)
+			}
+			return BoxMap(map[string]Value{
+				"name":      BoxString(q.Name),
+				"messages":  BoxInt(int64(q.Messages)),
+				"consumers": BoxInt(int64(q.Consumers)),
+			}), nil
+		},
+	})
+
+	// amqp_publish(conn, exchange, routing_key, body, options?)
+	vm.registerGlobal("amqp_publish", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "amqp_publish",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 4 || len(args) > 5 {
+				return NilValue(), fmt.Errorf("amqp_publish expects 4-5 arguments (conn, exchange, routing_key, body, options), got %d", len(args))
+			}
+			c, err := network.LookupAMQP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_publish: %v", err)
+			}
+			contentType := "text/plain"
+			var headers map[string]interface{}
+			persistent := false
+			if len(args) > 4 && !IsNil(args[4]) {
+				if !IsMap(args[4]) {
+					return NilValue(), fmt.Errorf("amqp_publish: options must be a map, got %s", ValueType(args[4]))
+				}
+				for key, v := range AsMap(args[4]).Items {
+					switch key {
+					case "content_type":
+						contentType = ToString(v)
+					case "headers":
+						m, err := stringMap("amqp_publish", key, v)
+						if err != nil {
+							return NilValue(), err
+						}
+						headers = make(map[string]interface{}, len(m))
+						for k, s := range m {
+							headers[k] = s
+						}
+					case "persistent":
+						persistent = IsTruthy(v)
+					default:
+						return NilValue(), fmt.Errorf("amqp_publish: unknown option '%s'", key)
+					}
+				}
+			}
+			if err := c.Publish(ToString(args[1]), ToString(args[2]), []byte(ToString(args[3])), contentType, headers, persistent); err != nil {
+				return NilValue(), fmt.Errorf("amqp_publish: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// amqp_get(conn, queue, options?)
+	vm.registerGlobal("amqp_get", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "amqp_get",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("amqp_get expects 2-3 arguments (conn, queue, options), got %d", len(args))
+			}
+			c, err := network.LookupAMQP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_get: %v", err)
+			}
+			max, ack := 1, false
+			if len(args) > 2 && !IsNil(args[2]) {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("amqp_get: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "max":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+							return NilValue(), fmt.Errorf("amqp_get: max must be a positive number")
+						}
+						max = int(ToNumber(v))
+					case "ack":
+						ack = IsTruthy(v)
+					default:
+						return NilValue(), fmt.Errorf("amqp_get: unknown option '%s'", key)
+					}
+				}
+			}
+			msgs, err := c.Get(ToString(args[1]), max, ack)
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_get: %v", err)
+			}
+			arr := make([]Value, len(msgs))
+			for i, m := range msgs {
+				arr[i] = goToValue(network.AMQPMessageToMap(m))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// amqp_close(conn)
+	vm.registerGlobal("amqp_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "amqp_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupAMQP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("amqp_close: %v", err)
+			}
+			c.Close()
+			return BoxBool(true), nil
+		},
+	})
+
+	// iot_protocol_security(host, protocol, options?) replaces the canned
+	// stack VM version with a live audit of an MQTT or AMQP broker
+	vm.registerGlobal("iot_protocol_security", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "iot_protocol_security",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("iot_protocol_security expects 2-3 arguments (host, protocol, options), got %d", len(args))
+			}
+			host, protocol := ToString(args[0]), strings.ToLower(ToString(args[1]))
+			var result map[string]interface{}
+			switch protocol {
+			case "mqtt", "mqtts":
+				opts, err := mqttOptions("iot_protocol_security", args, 2, true)
+				if err != nil {
+					return NilValue(), err
+				}
+				opts.TLS = opts.TLS || protocol == "mqtts"
+				audit, err := network.AuditMQTT(host, opts)
+				if err != nil {
+					return NilValue(), fmt.Errorf("iot_protocol_security: %v", err)
+				}
+				result = network.MQTTAuditToMap(audit)
+			case "amqp", "amqps":
+				opts, err := amqpOptions("iot_protocol_security", args, 2, true)
+				if err != nil {
+					return NilValue(), err
+				}
+				opts.TLS = opts.TLS || protocol == "amqps"
+				audit, err := network.AuditAMQP(host, opts)
+				if err != nil {
+					return NilValue(), fmt.Errorf("iot_protocol_security: %v", err)
+				}
+				result = network.AMQPAuditToMap(audit)
+			default:
+				return NilValue(), fmt.Errorf("iot_protocol_security: unsupported protocol '%s', expected mqtt, mqtts, amqp or amqps", protocol)
+			}
+			result["device_id"] = host
+			result["protocol"] = protocol
+			result["analysis_time"] = time.Now().Format("2006-01-02 15:04:05")
+			return goToValue(result), nil
+		},
+	})
+}
@@ -0,0 +1,274 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// parseLDAPOptions reads the optional options map of ldap_connect: port,
+// tls, username, password, domain, hash, base_dn, timeout in milliseconds
+// and insecure
+func parseLDAPOptions(args []Value, i int) (network.LDAPOptions, error) {
+	var opts network.LDAPOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("ldap_connect: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "port":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 || ToNumber(v) > 65535 {
+				return opts, fmt.Errorf("ldap_connect: port must be a number from 1 to 65535")
+			}
+			opts.Port = int(ToNumber(v))
+		case "tls":
+			opts.TLS = ToString(v)
+		case "username":
+			opts.Username = ToString(v)
+		case "password":
+			opts.Password = ToString(v)
+		case "domain":
+			opts.Domain = ToString(v)
+		case "hash":
+			opts.Hash = ToString(v)
+		case "base_dn":
+			opts.BaseDN = ToString(v)
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("ldap_connect: timeout must be a positive number of milliseconds")
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		case "insecure":
+			opts.Insecure = IsTruthy(v)
+		default:
+			return opts, fmt.Errorf("ldap_connect: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// parseLDAPSearchOptions reads the optional options map of ldap_search:
+// base, scope, attributes, size_limit and page_size
+func parseLDAPSearchOptions(args []Value, i int) (network.LDAPSearchOptions, error) {
+	var opts network.LDAPSearchOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("ldap_search: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "base":
+			opts.Base = ToString(v)
+		case "scope":
+			opts.Scope = ToString(v)
+		case "attributes":
+			opts.Attributes = stringList(v)
+		case "size_limit", "page_size":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+				return opts, fmt.Errorf("ldap_search: %s must be a positive number", key)
+			}
+			if key == "size_limit" {
+				opts.SizeLimit = int(ToNumber(v))
+			} else {
+				opts.PageSize = int(ToNumber(v))
+			}
+		default:
+			return opts, fmt.Errorf("ldap_search: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// adAccountsValue returns accounts as an array of maps
+func adAccountsValue(accounts []network.ADAccount) Value {
+	arr := make([]Value, len(accounts))
+	for i, a := range accounts {
+		arr[i] = goToValue(network.ADAccountToMap(a))
+	}
+	return BoxArray(arr)
+}
+
+// registerLDAPFunctions registers LDAP searches and the Active Directory
+// queries of internal assessments: kerberoastable and AS-REP roastable
+// accounts, stale accounts, privileged group members and password policy
+func (vm *RegisterVM) registerLDAPFunctions() {
+	// adQuery registers a query on a connection with no other arguments
+	adQuery := func(name string, query func(c *network.LDAPConn) ([]network.ADAccount, error)) {
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  1,
+			Function: func(args []Value) (Value, error) {
+				c, err := network.LookupLDAP(ToString(args[0]))
+				if err != nil {
+					return NilValue(), fmt.Errorf("%s: %v", name, err)
+				}
+				accounts, err := query(c)
+				if err != nil {
+					return NilValue(), fmt.Errorf("%s: %v", name, err)
+				}
+				return adAccountsValue(accounts), nil
+			},
+		})
+	}
+
+	// ldap_connect(host, options?)
+	vm.registerGlobal("ldap_connect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ldap_connect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ldap_connect expects 1-2 arguments (host, options), got %d", len(args))
+			}
+			opts, err := parseLDAPOptions(args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			c, err := network.LDAPConnect(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ldap_connect: %v", err)
+			}
+			return BoxString(c.ID), nil
+		},
+	})
+
+	// ldap_search(conn, filter, options?)
+	vm.registerGlobal("ldap_search", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ldap_search",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("ldap_search expects 2-3 arguments (conn, filter, options), got %d", len(args))
+			}
+			opts, err := parseLDAPSearchOptions(args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			c, err := network.LookupLDAP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ldap_search: %v", err)
+			}
+			entries, err := c.Search(ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ldap_search: %v", err)
+			}
+			arr := make([]Value, len(entries))
+			for i, e := range entries {
+				arr[i] = goToValue(network.LDAPEntryToMap(e))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// ldap_close(conn)
+	vm.registerGlobal("ldap_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ldap_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupLDAP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ldap_close: %v", err)
+			}
+			c.Close()
+			return BoxBool(true), nil
+		},
+	})
+
+	// ad_kerberoastable(conn) and ad_asrep_roastable(conn)
+	adQuery("ad_kerberoastable", (*network.LDAPConn).ADKerberoastable)
+	adQuery("ad_asrep_roastable", (*network.LDAPConn).ADASREPRoastable)
+
+	// ad_stale_accounts(conn, days?)
+	vm.registerGlobal("ad_stale_accounts", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ad_stale_accounts",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ad_stale_accounts expects 1-2 arguments (conn, days), got %d", len(args))
+			}
+			days := 90
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !(IsNumber(args[1]) || IsInt(args[1])) || ToNumber(args[1]) < 1 {
+					return NilValue(), fmt.Errorf("ad_stale_accounts: days must be a positive number")
+				}
+				days = int(ToNumber(args[1]))
+			}
+			c, err := network.LookupLDAP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_stale_accounts: %v", err)
+			}
+			accounts, err := c.ADStaleAccounts(days)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_stale_accounts: %v", err)
+			}
+			return adAccountsValue(accounts), nil
+		},
+	})
+
+	// ad_privileged_members(conn, groups?)
+	vm.registerGlobal("ad_privileged_members", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ad_privileged_members",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ad_privileged_members expects 1-2 arguments (conn, groups), got %d", len(args))
+			}
+			var names []string
+			if len(args) > 1 && !IsNil(args[1]) {
+				names = stringList(args[1])
+			}
+			c, err := network.LookupLDAP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_privileged_members: %v", err)
+			}
+			groups, err := c.ADPrivilegedMembers(names)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_privileged_members: %v", err)
+			}
+			arr := make([]Value, len(groups))
+			for i, g := range groups {
+				arr[i] = BoxMap(map[string]Value{
+					"name":    BoxString(g.Name),
+					"dn":      BoxString(g.DN),
+					"members": adAccountsValue(g.Members),
+				})
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// ad_password_policy(conn)
+	vm.registerGlobal("ad_password_policy", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ad_password_policy",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			c, err := network.LookupLDAP(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_password_policy: %v", err)
+			}
+			policy, fineGrained, err := c.ADPasswordPolicy()
+			if err != nil {
+				return NilValue(), fmt.Errorf("ad_password_policy: %v", err)
+			}
+			m := network.ADPasswordPolicyToMap(*policy)
+			psos := make([]interface{}, len(fineGrained))
+			for i, p := range fineGrained {
+				psos[i] = network.ADPasswordPolicyToMap(p)
+			}
+			m["fine_grained"] = psos
+			return goToValue(m), nil
+		},
+	})
+}
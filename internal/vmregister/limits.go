@@ -0,0 +1,115 @@
+package vmregister
+
+import (
+	"sync/atomic"
+
+	"sentra/internal/limits"
+)
+
+// StartLimits enforces l until StopLimits. A script that exceeds a limit
+// gets a *limits.Error it can catch once; see limits.Error. The JIT is
+// turned off, as compiled loops and functions run without counting
+// instructions.
+func (vm *RegisterVM) StartLimits(l limits.Limits) {
+	vm.jitEnabled = false
+	vm.functionJIT = nil
+	vm.limits = limits.Start(l, func() {
+		if !atomic.CompareAndSwapInt32(&vm.interrupted, 0, limitExceeded) {
+			atomic.CompareAndSwapInt32(&vm.interrupted, profileDue, limitExceeded)
+		}
+		vm.wake()
+	})
+	vm.hooked = true
+}
+
+// StopLimits stops enforcing the limits set by StartLimits
+func (vm *RegisterVM) StopLimits() {
+	if vm.limits != nil {
+		vm.limits.Stop()
+		vm.limits = nil
+	}
+	vm.hooked = vm.trace != nil
+}
+
+// beforeInstruction is called before every instruction while tracing or
+// limits are on. An error is a resource limit exceeded.
+func (vm *RegisterVM) beforeInstruction(pc int, instr Instruction, consts []Value) error {
+	if vm.limits != nil {
+		if err := vm.limitErr; err != nil {
+			vm.limitErr = nil
+			return err
+		}
+		if err := vm.limits.Step(); err != nil {
+			return err
+		}
+	}
+	if vm.trace != nil {
+		vm.traceInstruction(pc, instr, consts)
+	}
+	return nil
+}
+
+// reserve returns an error if allocating size bytes would exceed the memory
+// limit. In the run loop the error is kept in vm.limitErr and the
+// instruction retried, so that beforeInstruction raises it.
+func (vm *RegisterVM) reserve(size int) error {
+	if vm.limits == nil {
+		return nil
+	}
+	return vm.limits.Reserve(uint64(size))
+}
+
+// catchLimit jumps to the innermost catch block for a catchable resource
+// error, unless that would unwind a call made from Go, and reports whether
+// it did. The run loop must then reload its state from vm.
+func (vm *RegisterVM) catchLimit(err error) bool {
+	if !limits.Catchable(err) || len(vm.tryStack) == 0 {
+		return false
+	}
+	tryFrame := vm.tryStack[len(vm.tryStack)-1]
+	for i := vm.frameTop - 1; i >= tryFrame.frameDepth; i-- {
+		if vm.frames[i].stopOnReturn {
+			return false
+		}
+	}
+	vm.tryStack = vm.tryStack[:len(vm.tryStack)-1]
+	vm.lastError = BoxString(err.Error())
+	if vm.frameTop > tryFrame.frameDepth {
+		vm.frameTop = tryFrame.frameDepth
+	}
+	vm.regTop = tryFrame.regTop
+	vm.code = tryFrame.code
+	vm.consts = tryFrame.consts
+	vm.pc = tryFrame.catchPC
+	return true
+}
+
+// callTooDeep returns the error for a call to name that would go past the
+// frame or register stack, or nil if a catch block in the script has taken
+// it. The run loop must then reload its state from vm.
+func (vm *RegisterVM) callTooDeep(name string) error {
+	err := vm.depthError(name)
+	if vm.catchLimit(err) {
+		return nil
+	}
+	return err
+}
+
+// depthError describes why a call to name cannot get a frame: either
+// maxCallDepth is reached or the register stack is full before it
+func (vm *RegisterVM) depthError(name string) *limits.DepthError {
+	return &limits.DepthError{
+		Function:  name,
+		Depth:     vm.frameTop,
+		Limit:     vm.maxCallDepth,
+		Registers: vm.frameTop < vm.maxCallDepth,
+	}
+}
+
+// stringSize returns the length of v if it is a string, for reserve
+func stringSize(v Value) int {
+	if IsString(v) {
+		return len(AsString(v).Value)
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package vmregister_test
+
+import (
+	"testing"
+	"time"
+
+	"sentra/internal/limits"
+	"sentra/internal/vmregister"
+)
+
+func TestResourceLimits(t *testing.T) {
+	// The first error can be caught; the handler gets a tenth more
+	vm := vmregister.NewRegisterVM()
+	vm.StartLimits(limits.Limits{MaxInstructions: 10000})
+	globals, err := execute(t, vm, `
+let caught = ""
+let n = 0
+try {
+    while true { n = n + 1 }
+} catch e {
+    caught = e
+}
+let cleanup = 0
+while cleanup < 10 { cleanup = cleanup + 1 }
+`)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := vmregister.ToString(globals["caught"]); got != "instruction limit of 10000 exceeded" {
+		t.Errorf("caught: got %q", got)
+	}
+	if got := vmregister.ToString(globals["cleanup"]); got != "10" {
+		t.Errorf("cleanup: got %s, want 10", got)
+	}
+	_, err = execute(t, vm, `
+try {
+    while true {}
+} catch e {
+    log("caught twice")
+}
+`)
+	vm.StopLimits()
+	if e, ok := err.(*limits.Error); !ok || !e.Final || e.Resource != limits.Instructions {
+		t.Errorf("exceeding the grace: got %#v", err)
+	}
+
+	// A timeout cuts a sleep short
+	vm = vmregister.NewRegisterVM()
+	vm.StartLimits(limits.Limits{Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	globals, err = execute(t, vm, `
+let caught = ""
+try {
+    sleep(10000)
+} catch e {
+    caught = e
+}
+`)
+	vm.StopLimits()
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := vmregister.ToString(globals["caught"]); got != "timeout of 50ms exceeded" {
+		t.Errorf("caught: got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sleep ran for %v", elapsed)
+	}
+
+	// A string that would not fit is never made
+	vm = vmregister.NewRegisterVM()
+	vm.StartLimits(limits.Limits{MaxMemory: 64 << 20})
+	globals, err = execute(t, vm, `
+let caught = ""
+try {
+    let huge = "x" * 1000000000
+} catch e {
+    caught = e
+}
+let items = fill(1000000000, 0)
+`)
+	vm.StopLimits()
+	if got := vmregister.ToString(globals["caught"]); got != "memory limit of 64MB exceeded" {
+		t.Errorf("caught: got %q", got)
+	}
+	if e, ok := err.(*limits.Error); !ok || e.Resource != limits.Memory {
+		t.Errorf("fill: got %v", err)
+	}
+
+	// A limit exceeded in a function called by a builtin unwinds to the
+	// script's try block
+	vm = vmregister.NewRegisterVM()
+	vm.StartLimits(limits.Limits{MaxInstructions: 10000})
+	globals, err = execute(t, vm, `fn spin() { while true {} }`)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if err := vm.Mock("upper", globals["spin"]); err != nil {
+		t.Fatal(err)
+	}
+	globals, err = execute(t, vm, `
+let caught = ""
+try {
+    upper("x")
+} catch e {
+    caught = e
+}
+`)
+	vm.StopLimits()
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := vmregister.ToString(globals["caught"]); got != "instruction limit of 10000 exceeded" {
+		t.Errorf("caught: got %q", got)
+	}
+}
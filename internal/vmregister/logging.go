@@ -0,0 +1,455 @@
+package vmregister
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sentra/internal/sandbox"
+)
+
+// logLevels maps level names to slog levels
+var logLevels = map[string]slog.Level{
+	"debug":   slog.LevelDebug,
+	"info":    slog.LevelInfo,
+	"warn":    slog.LevelWarn,
+	"warning": slog.LevelWarn,
+	"error":   slog.LevelError,
+}
+
+func parseLogLevel(name string) (slog.Level, error) {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+func logLevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// logSink is one destination for log records
+type logSink struct {
+	id      string
+	level   slog.Level // Records below it are dropped by this sink
+	handler slog.Handler
+	closer  io.Closer // Nil for stdout and stderr
+}
+
+// logger fans records out to the configured sinks. Until a sink is added,
+// records go to stderr as text.
+type logger struct {
+	mu         sync.Mutex
+	level      slog.Level
+	fields     []slog.Attr // Attached to every record
+	sinks      []*logSink
+	configured bool // A sink was added, so the default one is gone
+	counter    int
+}
+
+func newLogger() *logger {
+	return &logger{
+		level: slog.LevelInfo,
+		sinks: []*logSink{{id: "stderr", level: slog.LevelDebug, handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})}},
+	}
+}
+
+// log writes a record to every sink whose level allows it
+func (l *logger) log(level slog.Level, msg string, fields []slog.Attr) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return nil
+	}
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(l.fields...)
+	r.AddAttrs(fields...)
+	var first error
+	for _, sink := range l.sinks {
+		if level < sink.level {
+			continue
+		}
+		if err := sink.handler.Handle(context.Background(), r.Clone()); err != nil && first == nil {
+			first = fmt.Errorf("log sink %s: %v", sink.id, err)
+		}
+	}
+	return first
+}
+
+// add installs a sink, replacing the default stderr sink the first time
+func (l *logger) add(sink *logSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.configured {
+		l.configured = true
+		l.sinks = nil
+	}
+	l.counter++
+	sink.id = "sink_" + strconv.Itoa(l.counter)
+	l.sinks = append(l.sinks, sink)
+}
+
+// remove closes and removes a sink, reporting whether it existed
+func (l *logger) remove(id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, sink := range l.sinks {
+		if sink.id != id {
+			continue
+		}
+		l.sinks = append(l.sinks[:i], l.sinks[i+1:]...)
+		if sink.closer != nil {
+			return true, sink.closer.Close()
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// logFields converts a map of fields to attributes sorted by key
+func logFields(name string, v Value) ([]slog.Attr, error) {
+	if IsNil(v) {
+		return nil, nil
+	}
+	if !IsMap(v) {
+		return nil, fmt.Errorf("%s: fields must be a map, got %s", name, ValueType(v))
+	}
+	items := AsMap(v).Items
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.Any(key, valueToGo(items[key]))
+	}
+	return attrs, nil
+}
+
+// newLogSink builds a sink of the given kind from an options map. Files
+// and network syslog need the permission of policy, which may be nil.
+func newLogSink(kind string, options Value, policy *sandbox.Policy) (*logSink, error) {
+	format, level := "text", slog.LevelDebug
+	path, network, address, tag := "", "", "", "sentra"
+	var maxSize int64
+	maxFiles := 5
+
+	if !IsNil(options) {
+		if !IsMap(options) {
+			return nil, fmt.Errorf("options must be a map, got %s", ValueType(options))
+		}
+		for key, v := range AsMap(options).Items {
+			var err error
+			switch key {
+			case "format":
+				format = ToString(v)
+			case "level":
+				level, err = parseLogLevel(ToString(v))
+			case "path":
+				path = ToString(v)
+			case "max_size":
+				maxSize = ToInt(v)
+			case "max_files":
+				maxFiles = int(ToInt(v))
+			case "network":
+				network = ToString(v)
+			case "address":
+				address = ToString(v)
+			case "tag":
+				tag = ToString(v)
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q, expected text or json", format)
+	}
+
+	sink := &logSink{level: level}
+	var w io.Writer
+	switch kind {
+	case "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file sink needs a path")
+		}
+		if maxFiles < 1 {
+			return nil, fmt.Errorf("max_files must be at least 1")
+		}
+		if err := policy.Check(sandbox.Write, path); err != nil {
+			return nil, err
+		}
+		f, err := openRotatingFile(path, maxSize, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		w, sink.closer = f, f
+	case "syslog":
+		if network != "" {
+			if err := policy.Check(sandbox.Net, address); err != nil {
+				return nil, err
+			}
+		}
+		sw, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_USER, tag)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %v", err)
+		}
+		sink.handler, sink.closer = newSyslogHandler(sw), sw
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q, expected stderr, stdout, file or syslog", kind)
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "json" {
+		sink.handler = slog.NewJSONHandler(w, opts)
+	} else {
+		sink.handler = slog.NewTextHandler(w, opts)
+	}
+	return sink, nil
+}
+
+// rotatingFile appends to a log file, moving it aside to path.1, path.2 and
+// so on once it would grow past maxSize bytes. Zero maxSize never rotates.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int // Rotated files kept besides the current one
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// syslogHandler sends records to syslog at their level. Syslog adds its own
+// timestamp, so the message is the text format without time and level.
+type syslogHandler struct {
+	w    *syslog.Writer
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	text slog.Handler
+}
+
+func newSyslogHandler(w *syslog.Writer) *syslogHandler {
+	h := &syslogHandler{w: w}
+	h.text = slog.NewTextHandler(&h.buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return h
+}
+
+func (h *syslogHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *syslogHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *syslogHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.text.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+// registerLoggingFunctions registers the log_* builtins
+func (vm *RegisterVM) registerLoggingFunctions() {
+	l := newLogger()
+
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		name, level := "log_"+name, logLevels[name]
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  -1,
+			Function: func(args []Value) (Value, error) {
+				if len(args) < 1 || len(args) > 2 {
+					return NilValue(), fmt.Errorf("%s expects 1-2 arguments (message, fields), got %d", name, len(args))
+				}
+				var fields []slog.Attr
+				if len(args) == 2 {
+					var err error
+					if fields, err = logFields(name, args[1]); err != nil {
+						return NilValue(), err
+					}
+				}
+				return NilValue(), l.log(level, ToString(args[0]), fields)
+			},
+		})
+	}
+
+	vm.registerGlobal("log_set_level", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "log_set_level",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			level, err := parseLogLevel(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("log_set_level: %v", err)
+			}
+			l.mu.Lock()
+			previous := l.level
+			l.level = level
+			l.mu.Unlock()
+			return BoxString(logLevelName(previous)), nil
+		},
+	})
+
+	vm.registerGlobal("log_set_fields", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "log_set_fields",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			fields, err := logFields("log_set_fields", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			l.mu.Lock()
+			l.fields = fields
+			l.mu.Unlock()
+			return NilValue(), nil
+		},
+	})
+
+	vm.registerGlobal("log_add_sink", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "log_add_sink",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("log_add_sink expects 1-2 arguments (kind, options), got %d", len(args))
+			}
+			options := NilValue()
+			if len(args) == 2 {
+				options = args[1]
+			}
+			sink, err := newLogSink(ToString(args[0]), options, vm.permissions)
+			if err != nil {
+				return NilValue(), fmt.Errorf("log_add_sink: %w", err)
+			}
+			l.add(sink)
+			return BoxString(sink.id), nil
+		},
+	})
+
+	vm.registerGlobal("log_remove_sink", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "log_remove_sink",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			ok, err := l.remove(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("log_remove_sink: %v", err)
+			}
+			return BoxBool(ok), nil
+		},
+	})
+}
+
+// createLoggingModule creates the logging built-in module
+func (vm *RegisterVM) createLoggingModule() *ModuleObj {
+	exports := make(map[string]Value)
+
+	exports["debug"] = vm.getGlobalByName("log_debug")
+	exports["info"] = vm.getGlobalByName("log_info")
+	exports["warn"] = vm.getGlobalByName("log_warn")
+	exports["error"] = vm.getGlobalByName("log_error")
+	exports["set_level"] = vm.getGlobalByName("log_set_level")
+	exports["set_fields"] = vm.getGlobalByName("log_set_fields")
+	exports["add_sink"] = vm.getGlobalByName("log_add_sink")
+	exports["remove_sink"] = vm.getGlobalByName("log_remove_sink")
+
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    "logging",
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+
+	keepAlive(module)
+	return module
+}
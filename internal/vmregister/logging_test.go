@@ -0,0 +1,91 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestLogging(t *testing.T) {
+	dir := t.TempDir()
+	textPath, jsonPath, rotatedPath := filepath.Join(dir, "agent.log"), filepath.Join(dir, "agent.jsonl"), filepath.Join(dir, "small.log")
+	globals := run(t, `
+import logging
+let text = log_add_sink("file", {"path": r"`+textPath+`"})
+let json = logging.add_sink("file", {"path": r"`+jsonPath+`", "format": "json", "level": "warn"})
+log_set_fields({"agent": "scanner-1"})
+log_debug("hidden")
+let previous = log_set_level("debug")
+log_debug("shown")
+log_info("scan started", {"targets": 3, "ports": [22, 443]})
+logging.warn("slow host", {"host": "10.0.0.5", "ms": 1500.5})
+log_error("scan failed")
+let removed = log_remove_sink(text)
+let removed_twice = logging.remove_sink(text)
+log_error("json only")
+
+log_add_sink("file", {"path": r"`+rotatedPath+`", "max_size": 60, "max_files": 2})
+let i = 0
+while i < 6 {
+    log_info("line " + str(i))
+    i = i + 1
+}
+`)
+
+	tests := map[string]string{
+		"previous":      "info",
+		"removed":       "true",
+		"removed_twice": "false",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	text, err := os.ReadFile(textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	wantText := []string{
+		`level=DEBUG msg=shown agent=scanner-1`,
+		`level=INFO msg="scan started" agent=scanner-1 ports="[22 443]" targets=3`,
+		`level=WARN msg="slow host" agent=scanner-1 host=10.0.0.5 ms=1500.5`,
+		`level=ERROR msg="scan failed" agent=scanner-1`,
+	}
+	if len(lines) != len(wantText) {
+		t.Fatalf("text log: got %q", text)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, wantText[i]) {
+			t.Errorf("text log line %d: got %q, want suffix %q", i, line, wantText[i])
+		}
+	}
+
+	// The json sink only takes warnings and errors
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"level":"WARN","msg":"slow host","agent":"scanner-1","host":"10.0.0.5","ms":1500.5}`) ||
+		!strings.Contains(lines[2], `"msg":"json only"`) {
+		t.Errorf("json log: got %q", data)
+	}
+
+	// Each line is about 70 bytes, so every line after the first rotates and
+	// only the current file and two old ones are kept
+	for suffix, want := range map[string]string{"": "line 5", ".1": "line 4", ".2": "line 3"} {
+		data, err := os.ReadFile(rotatedPath + suffix)
+		if err != nil || !strings.Contains(string(data), want) || strings.Count(string(data), "\n") != 1 {
+			t.Errorf("small.log%s: got %q (%v), want %q", suffix, data, err, want)
+		}
+	}
+	if _, err := os.Stat(rotatedPath + ".3"); err == nil {
+		t.Errorf("small.log.3 should have been removed")
+	}
+}
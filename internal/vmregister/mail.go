@@ -0,0 +1,344 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// mailOptions holds the connection options shared by the SMTP and IMAP
+// functions
+type mailOptions struct {
+	port     int
+	tls      string
+	username string
+	password string
+	timeout  time.Duration
+	insecure bool
+}
+
+// parseMailOptions reads the optional options map at args[i]: port, tls,
+// username, password, timeout in milliseconds and insecure. Other keys go
+// to other, which returns an error for those it does not know.
+func parseMailOptions(name string, args []Value, i int, other func(key string, v Value) error) (mailOptions, error) {
+	var opts mailOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "port":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 || ToNumber(v) > 65535 {
+				return opts, fmt.Errorf("%s: port must be a number from 1 to 65535", name)
+			}
+			opts.port = int(ToNumber(v))
+		case "tls":
+			opts.tls = ToString(v)
+		case "username":
+			opts.username = ToString(v)
+		case "password":
+			opts.password = ToString(v)
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout must be a positive number of milliseconds", name)
+			}
+			opts.timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		case "insecure":
+			opts.insecure = IsTruthy(v)
+		default:
+			if other == nil {
+				return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+			if err := other(key, v); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// stringList reads a string or an array of strings
+func stringList(v Value) []string {
+	if !IsArray(v) {
+		return []string{ToString(v)}
+	}
+	var list []string
+	for _, item := range AsArray(v).Elements {
+		list = append(list, ToString(item))
+	}
+	return list
+}
+
+// parseMailMessage reads the message map of mail_send: from, to, cc, bcc,
+// subject, text, html, headers and attachments
+func parseMailMessage(v Value) (*network.MailMessage, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("mail_send: message must be a map, got %s", ValueType(v))
+	}
+	msg := &network.MailMessage{}
+	for key, v := range AsMap(v).Items {
+		switch key {
+		case "from":
+			msg.From = ToString(v)
+		case "to":
+			msg.To = stringList(v)
+		case "cc":
+			msg.Cc = stringList(v)
+		case "bcc":
+			msg.Bcc = stringList(v)
+		case "subject":
+			msg.Subject = ToString(v)
+		case "text":
+			msg.Text = ToString(v)
+		case "html":
+			msg.HTML = ToString(v)
+		case "headers":
+			if !IsMap(v) {
+				return nil, fmt.Errorf("mail_send: headers must be a map")
+			}
+			msg.Headers = map[string]string{}
+			for name, value := range AsMap(v).Items {
+				msg.Headers[name] = ToString(value)
+			}
+		case "attachments":
+			if !IsArray(v) {
+				return nil, fmt.Errorf("mail_send: attachments must be an array of maps")
+			}
+			for _, a := range AsArray(v).Elements {
+				if !IsMap(a) {
+					return nil, fmt.Errorf("mail_send: attachments must be an array of maps")
+				}
+				items := AsMap(a).Items
+				attachment := network.MailAttachment{Name: ToString(items["name"])}
+				if ct, ok := items["content_type"]; ok {
+					attachment.ContentType = ToString(ct)
+				}
+				if data, ok := items["data"]; ok && IsBytes(data) {
+					attachment.Data = AsBytes(data).Data
+				} else if ok {
+					attachment.Data = []byte(ToString(data))
+				}
+				msg.Attachments = append(msg.Attachments, attachment)
+			}
+		default:
+			return nil, fmt.Errorf("mail_send: unknown message field '%s'", key)
+		}
+	}
+	if msg.From == "" {
+		return nil, fmt.Errorf("mail_send: message has no from address")
+	}
+	return msg, nil
+}
+
+// parseSince reads a date as Unix seconds, YYYY-MM-DD or RFC 3339
+func parseSince(v Value) (time.Time, error) {
+	if IsNumber(v) || IsInt(v) {
+		return time.Unix(int64(ToNumber(v)), 0), nil
+	}
+	s := ToString(v)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("mail_fetch: since must be Unix seconds, YYYY-MM-DD or RFC 3339, got %q", s)
+	}
+	return t, nil
+}
+
+// imapOptions reads the options of mail_fetch and mail_mailboxes
+func imapOptions(name string, args []Value, search bool) (network.IMAPOptions, error) {
+	var opts network.IMAPOptions
+	var other func(key string, v Value) error
+	if search {
+		other = func(key string, v Value) error {
+			var err error
+			switch key {
+			case "mailbox":
+				opts.Mailbox = ToString(v)
+			case "search":
+				opts.Search = ToString(v)
+			case "from":
+				opts.From = ToString(v)
+			case "subject":
+				opts.Subject = ToString(v)
+			case "since":
+				opts.Since, err = parseSince(v)
+			case "unseen":
+				opts.Unseen = IsTruthy(v)
+			case "limit":
+				if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+					return fmt.Errorf("%s: limit must be a positive number", name)
+				}
+				opts.Limit = int(ToNumber(v))
+			default:
+				return fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+			return err
+		}
+	}
+	conn, err := parseMailOptions(name, args, 1, other)
+	if err != nil {
+		return opts, err
+	}
+	opts.Port, opts.TLS, opts.Timeout, opts.Insecure = conn.port, conn.tls, conn.timeout, conn.insecure
+	opts.Username, opts.Password = conn.username, conn.password
+	return opts, nil
+}
+
+// registerMailFunctions registers sending test messages over SMTP,
+// checking mail servers and the SPF, DKIM and DMARC records of domains,
+// and fetching delivered or quarantined messages over IMAP, for testing
+// mail filters and phishing defences end to end
+func (vm *RegisterVM) registerMailFunctions() {
+	// mail_send(host, message, options?)
+	vm.registerGlobal("mail_send", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mail_send",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("mail_send expects 2-3 arguments (host, message, options), got %d", len(args))
+			}
+			msg, err := parseMailMessage(args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			var helo string
+			conn, err := parseMailOptions("mail_send", args, 2, func(key string, v Value) error {
+				if key != "helo" {
+					return fmt.Errorf("mail_send: unknown option '%s'", key)
+				}
+				helo = ToString(v)
+				return nil
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+
+			result, err := network.SendMail(ToString(args[0]), msg, network.SMTPOptions{
+				Port: conn.port, TLS: conn.tls, Username: conn.username, Password: conn.password,
+				Helo: helo, Timeout: conn.timeout, Insecure: conn.insecure,
+			})
+			if err != nil {
+				return NilValue(), fmt.Errorf("mail_send: %v", err)
+			}
+			return goToValue(network.SMTPSendResultToMap(result)), nil
+		},
+	})
+
+	// mail_check_smtp(host, options?)
+	vm.registerGlobal("mail_check_smtp", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mail_check_smtp",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mail_check_smtp expects 1-2 arguments (host, options), got %d", len(args))
+			}
+			var helo string
+			conn, err := parseMailOptions("mail_check_smtp", args, 1, func(key string, v Value) error {
+				if key != "helo" {
+					return fmt.Errorf("mail_check_smtp: unknown option '%s'", key)
+				}
+				helo = ToString(v)
+				return nil
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+
+			report, err := network.CheckSMTP(ToString(args[0]), network.SMTPOptions{Port: conn.port, TLS: conn.tls, Helo: helo, Timeout: conn.timeout})
+			if err != nil {
+				return NilValue(), fmt.Errorf("mail_check_smtp: %v", err)
+			}
+			return goToValue(network.SMTPReportToMap(report)), nil
+		},
+	})
+
+	// mail_check_domain(domain, options?)
+	vm.registerGlobal("mail_check_domain", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mail_check_domain",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mail_check_domain expects 1-2 arguments (domain, options), got %d", len(args))
+			}
+			var opts network.MailDomainOptions
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("mail_check_domain: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "selectors":
+						opts.Selectors = stringList(v)
+					case "dns":
+						dns, err := parseDNSOptions("mail_check_domain", []Value{v}, 0, vm.permissions)
+						if err != nil {
+							return NilValue(), err
+						}
+						opts.DNS = dns
+					default:
+						return NilValue(), fmt.Errorf("mail_check_domain: unknown option '%s'", key)
+					}
+				}
+			}
+
+			report, err := network.NewNetworkModule().CheckMailDomain(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mail_check_domain: %v", err)
+			}
+			return goToValue(network.MailDomainReportToMap(report)), nil
+		},
+	})
+
+	// mail_fetch(host, options)
+	vm.registerGlobal("mail_fetch", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mail_fetch",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			opts, err := imapOptions("mail_fetch", args, true)
+			if err != nil {
+				return NilValue(), err
+			}
+			messages, err := network.FetchMail(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mail_fetch: %v", err)
+			}
+			arr := make([]Value, len(messages))
+			for i, m := range messages {
+				arr[i] = goToValue(network.IMAPMessageToMap(m))
+			}
+			return BoxArray(arr), nil
+		},
+	})
+
+	// mail_mailboxes(host, options)
+	vm.registerGlobal("mail_mailboxes", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mail_mailboxes",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			opts, err := imapOptions("mail_mailboxes", args, false)
+			if err != nil {
+				return NilValue(), err
+			}
+			mailboxes, err := network.IMAPMailboxes(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mail_mailboxes: %v", err)
+			}
+			arr := make([]Value, len(mailboxes))
+			for i, name := range mailboxes {
+				arr[i] = BoxString(name)
+			}
+			return BoxArray(arr), nil
+		},
+	})
+}
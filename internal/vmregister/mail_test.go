@@ -0,0 +1,80 @@
+package vmregister_test
+
+import (
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestMailSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 mx.test ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				text.PrintfLine("250-mx.test\r\n250 8BITMIME")
+			case strings.HasPrefix(line, "RCPT") && strings.Contains(line, "nobody@"):
+				text.PrintfLine("550 5.1.1 User unknown")
+			case line == "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, _ := text.ReadDotBytes()
+				received <- string(data)
+				text.PrintfLine("250 2.0.0 Ok: queued as 9A1B")
+			case line == "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("250 Ok")
+			}
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	globals := run(t, `
+let message = {
+    "from": "it@example.test",
+    "to": ["user@example.test", "nobody@example.test"],
+    "subject": "Password expiry",
+    "text": "Reset your password",
+    "attachments": [{"name": "eicar.com", "data": bytes("X5O!P%@AP")}]
+}
+let result = mail_send("127.0.0.1", message, {"port": `+port+`, "tls": "none", "timeout": 2000})
+let accepted = join(result["accepted"], ",")
+let rejected = len(result["rejected"])
+let response = result["response"]
+`)
+
+	tests := map[string]string{
+		"accepted": "user@example.test",
+		"rejected": "1",
+		"response": "2.0.0 Ok: queued as 9A1B",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if data := <-received; !strings.Contains(data, "Subject: Password expiry") || !strings.Contains(data, `filename=eicar.com`) {
+		t.Errorf("server got %q", data)
+	}
+}
@@ -0,0 +1,371 @@
+package vmregister
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sentra/internal/memory"
+)
+
+// memoryRegionValue returns a region as a map
+func memoryRegionValue(r *memory.MemoryRegion) map[string]interface{} {
+	return map[string]interface{}{
+		"base_address": int64(r.BaseAddress),
+		"address":      fmt.Sprintf("0x%x", uint64(r.BaseAddress)),
+		"size":         int64(r.Size),
+		"protection":   r.Protection,
+		"state":        r.State,
+		"type":         r.Type,
+		"path":         r.Path,
+	}
+}
+
+// memoryAddress reads an address given as a number or a hex string
+func memoryAddress(name string, v Value) (uint64, error) {
+	if IsInt(v) || IsNumber(v) {
+		if ToNumber(v) < 0 {
+			return 0, fmt.Errorf("%s: address must not be negative", name)
+		}
+		return uint64(ToInt(v)), nil
+	}
+	s := strings.TrimPrefix(strings.ToLower(ToString(v)), "0x")
+	address, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: bad address '%s'", name, ToString(v))
+	}
+	return address, nil
+}
+
+// imageInfoValue returns the description of a memory image as a map
+func imageInfoValue(info *memory.ImageInfo) Value {
+	ranges := make([]interface{}, len(info.Ranges))
+	for i, r := range info.Ranges {
+		ranges[i] = map[string]interface{}{"start": int64(r.Start), "end": int64(r.End)}
+	}
+	banners := make([]interface{}, len(info.Banners))
+	for i, b := range info.Banners {
+		banners[i] = b
+	}
+	return goToValue(map[string]interface{}{
+		"id":      info.ID,
+		"path":    info.Path,
+		"format":  info.Format,
+		"size":    int64(info.Size),
+		"ranges":  ranges,
+		"os":      info.OS,
+		"banners": banners,
+	})
+}
+
+// registerMemoryFunctions registers the functions that list the regions
+// of a live process and read or dump its memory, and those that analyse
+// memory images taken earlier. Where the platform or the caller's
+// privileges do not allow it they raise an error that says what is
+// needed.
+func (vm *RegisterVM) registerMemoryFunctions() {
+	forensics := func() *memory.EnhancedForensics {
+		return vm.memoryModule.(*memory.IntegratedMemoryModule).EnhancedForensics
+	}
+
+	// mem_get_regions(pid)
+	vm.registerGlobal("mem_get_regions", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_get_regions",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			regions, err := forensics().GetMemoryRegions(int(ToInt(args[0])))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_get_regions: %v", err)
+			}
+			list := make([]interface{}, len(regions))
+			for i, r := range regions {
+				list[i] = memoryRegionValue(r)
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// mem_read(pid, address, size) returns fewer bytes than asked when the
+	// read runs into memory that cannot be read
+	vm.registerGlobal("mem_read", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_read",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			address, err := memoryAddress("mem_read", args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			size := ToInt(args[2])
+			if size <= 0 || size > 256<<20 {
+				return NilValue(), fmt.Errorf("mem_read: size must be between 1 and 256MB, got %d", size)
+			}
+			data, err := forensics().ReadMemory(int(ToInt(args[0])), address, int(size))
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_read: %v", err)
+			}
+			return BoxBytes(data), nil
+		},
+	})
+
+	// mem_dump_process(pid, output_path, options?) writes the readable
+	// regions of a process to a file
+	vm.registerGlobal("mem_dump_process", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_dump_process",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("mem_dump_process expects 2-3 arguments (pid, output_path, options), got %d", len(args))
+			}
+			var opts memory.DumpOptions
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("mem_dump_process: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "executable_only":
+						opts.ExecutableOnly = IsTruthy(v)
+					case "max_bytes":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("mem_dump_process: max_bytes must be a positive number")
+						}
+						opts.MaxBytes = uint64(ToNumber(v))
+					default:
+						return NilValue(), fmt.Errorf("mem_dump_process: unknown option '%s'", key)
+					}
+				}
+			}
+			result, err := forensics().DumpProcess(int(ToInt(args[0])), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_dump_process: %v", err)
+			}
+			regions := make([]interface{}, len(result.Regions))
+			for i, r := range result.Regions {
+				region := memoryRegionValue(&r.MemoryRegion)
+				region["file_offset"] = int64(r.FileOffset)
+				regions[i] = region
+			}
+			return goToValue(map[string]interface{}{
+				"path":      result.Path,
+				"bytes":     int64(result.Bytes),
+				"regions":   regions,
+				"skipped":   result.Skipped,
+				"truncated": result.Truncated,
+				"sha256":    result.SHA256,
+			}), nil
+		},
+	})
+
+	// image looks up the image an analysis names
+	image := func(name string, v Value) (*memory.DumpImage, error) {
+		img, err := forensics().Image(ToString(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return img, nil
+	}
+
+	// mem_image_open(path, options?) opens a raw or LiME memory image
+	vm.registerGlobal("mem_image_open", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_open",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mem_image_open expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			var opts memory.ImageOptions
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("mem_image_open: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "symbols":
+						opts.Symbols = ToString(v)
+					default:
+						return NilValue(), fmt.Errorf("mem_image_open: unknown option '%s'", key)
+					}
+				}
+			}
+			img, err := forensics().OpenImage(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_image_open: %v", err)
+			}
+			return imageInfoValue(img.Info()), nil
+		},
+	})
+
+	// mem_image_info(image)
+	vm.registerGlobal("mem_image_info", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_info",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			img, err := image("mem_image_info", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			return imageInfoValue(img.Info()), nil
+		},
+	})
+
+	// mem_image_close(image)
+	vm.registerGlobal("mem_image_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if err := forensics().CloseImage(ToString(args[0])); err != nil {
+				return NilValue(), fmt.Errorf("mem_image_close: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// mem_image_processes(image)
+	vm.registerGlobal("mem_image_processes", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_processes",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			img, err := image("mem_image_processes", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			processes, err := img.Processes()
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_image_processes: %v", err)
+			}
+			list := make([]interface{}, len(processes))
+			for i, p := range processes {
+				process := map[string]interface{}{
+					"pid":           p.PID,
+					"ppid":          p.ParentPID,
+					"name":          p.Name,
+					"uid":           p.UID,
+					"threads":       p.Threads,
+					"kernel_thread": p.KernelThread,
+					"exited":        p.Exited,
+					"offset":        fmt.Sprintf("0x%x", p.Offset),
+					"create_time":   "",
+				}
+				if !p.CreateTime.IsZero() {
+					process["create_time"] = p.CreateTime.Format(time.RFC3339)
+				}
+				list[i] = process
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// mem_image_modules(image)
+	vm.registerGlobal("mem_image_modules", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_modules",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			img, err := image("mem_image_modules", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			modules, err := img.Modules()
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_image_modules: %v", err)
+			}
+			list := make([]interface{}, len(modules))
+			for i, m := range modules {
+				list[i] = map[string]interface{}{
+					"name":   m.Name,
+					"base":   fmt.Sprintf("0x%x", m.Base),
+					"size":   int64(m.Size),
+					"offset": fmt.Sprintf("0x%x", m.Offset),
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// mem_image_connections(image)
+	vm.registerGlobal("mem_image_connections", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_connections",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			img, err := image("mem_image_connections", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			conns, err := img.Connections()
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_image_connections: %v", err)
+			}
+			list := make([]interface{}, len(conns))
+			for i, c := range conns {
+				list[i] = map[string]interface{}{
+					"pid":         c.PID,
+					"process":     c.Process,
+					"fd":          c.FD,
+					"protocol":    c.Protocol,
+					"family":      c.Family,
+					"local_addr":  c.LocalAddr,
+					"local_port":  c.LocalPort,
+					"remote_addr": c.RemoteAddr,
+					"remote_port": c.RemotePort,
+					"state":       c.State,
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// mem_image_handles(image, options?)
+	vm.registerGlobal("mem_image_handles", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "mem_image_handles",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("mem_image_handles expects 1-2 arguments (image, options), got %d", len(args))
+			}
+			all := false
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("mem_image_handles: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "all":
+						all = IsTruthy(v)
+					default:
+						return NilValue(), fmt.Errorf("mem_image_handles: unknown option '%s'", key)
+					}
+				}
+			}
+			img, err := image("mem_image_handles", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			handles, err := img.Handles(all)
+			if err != nil {
+				return NilValue(), fmt.Errorf("mem_image_handles: %v", err)
+			}
+			list := make([]interface{}, len(handles))
+			for i, h := range handles {
+				list[i] = map[string]interface{}{
+					"pid":        h.PID,
+					"process":    h.Process,
+					"fd":         h.FD,
+					"type":       h.Type,
+					"path":       h.Path,
+					"suspicious": h.Suspicious,
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestMemoryAcquisition(t *testing.T) {
+	if _, err := os.Stat("/proc/self/maps"); err != nil {
+		t.Skip("needs /proc")
+	}
+	path := filepath.Join(t.TempDir(), "self.dmp")
+	pid := strconv.Itoa(os.Getpid())
+	globals := run(t, `
+let regions = mem_get_regions(`+pid+`)
+let code = 0
+for r in regions {
+    if r["type"] == "Image" && r["protection"] == "RX" {
+        code = code + 1
+    }
+}
+let header = mem_read(`+pid+`, regions[0]["address"], 4)
+let dump = mem_dump_process(`+pid+`, "`+path+`", {"executable_only": true, "max_bytes": 1048576})
+`)
+	if vmregister.ToInt(globals["code"]) == 0 {
+		t.Error("no executable image regions")
+	}
+	if got := vmregister.ToString(globals["header"]); got != "\x7fELF" {
+		t.Errorf("header = %q", got)
+	}
+	dump := vmregister.AsMap(globals["dump"]).Items
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != vmregister.ToInt(dump["bytes"]) || info.Size() > 1048576 {
+		t.Errorf("dump = %v, file %v %v", vmregister.ToString(globals["dump"]), info, err)
+	}
+
+	expectErrors(t, map[string]string{
+		`mem_read(1, "zz", 4)`:                             "mem_read: bad address 'zz'",
+		`mem_read(1, 4096, 0)`:                             "mem_read: size must be between 1 and 256MB, got 0",
+		`mem_get_regions(1073741824)`:                      "mem_get_regions: process 1073741824 not found",
+		`mem_dump_process(1, "x.dmp", {"compress": true})`: "mem_dump_process: unknown option 'compress'",
+		`mem_dump_process(1, "x.dmp", {"max_bytes": 0})`:   "mem_dump_process: max_bytes must be a positive number",
+	})
+}
+
+func TestMemoryImage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mem.raw")
+	mem := make([]byte, 1<<20)
+	copy(mem[0x8000:], "Linux version 6.1.0-13-amd64 (debian-kernel@lists.debian.org) (gcc-12) #1 SMP\n")
+	if err := os.WriteFile(path, mem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	globals := run(t, `
+let img = mem_image_open("`+path+`")
+let info = mem_image_info(img["id"])
+let summary = info["format"] + " " + str(info["size"]) + " " + info["banners"][0]
+let closed = mem_image_close(img["id"])
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "raw 1048576 Linux version 6.1.0-13-amd64 (debian-kernel@lists.debian.org) (gcc-12) #1 SMP"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	expectErrors(t, map[string]string{
+		`mem_image_processes("image_9")`:                                       "mem_image_processes: image 'image_9' not found",
+		`mem_image_open("` + path + `", {"profile": "x"})`:                     "mem_image_open: unknown option 'profile'",
+		`mem_image_open("` + path + `", {"symbols": "` + dir + `/k.json.xz"})`: "mem_image_open: symbol table " + dir + "/k.json.xz is xz compressed",
+		`mem_image_modules(mem_image_open("` + path + `")["id"])`:              "mem_image_modules: the image was opened without a symbol table",
+		`mem_image_handles(mem_image_open("` + path + `")["id"], {"x": 1})`:    "mem_image_handles: unknown option 'x'",
+	})
+}
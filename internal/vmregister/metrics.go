@@ -0,0 +1,254 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+
+	"sentra/internal/metrics"
+)
+
+// registerMetricsFunctions registers the metrics_* builtins
+func (vm *RegisterVM) registerMetricsFunctions() {
+	registry := metrics.NewRegistry()
+
+	// labels reads an optional map of label values at args[i]
+	labels := func(name string, args []Value, i int) (map[string]string, error) {
+		if len(args) <= i || IsNil(args[i]) {
+			return nil, nil
+		}
+		if !IsMap(args[i]) {
+			return nil, fmt.Errorf("%s: labels must be a map, got %s", name, ValueType(args[i]))
+		}
+		values := make(map[string]string)
+		for key, v := range AsMap(args[i]).Items {
+			values[key] = ToString(v)
+		}
+		return values, nil
+	}
+
+	number := func(name string, v Value) (float64, error) {
+		if !IsNumber(v) && !IsInt(v) {
+			return 0, fmt.Errorf("%s: value must be a number, got %s", name, ValueType(v))
+		}
+		return ToNumber(v), nil
+	}
+
+	define := func(kind metrics.Kind) func(args []Value) (Value, error) {
+		name := "metrics_" + string(kind)
+		return func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("%s expects 2-3 arguments (name, help, options), got %d", name, len(args))
+			}
+			var labelNames []string
+			var buckets []float64
+			if len(args) == 3 && !IsNil(args[2]) {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch {
+					case key == "labels" && IsArray(v):
+						for _, label := range AsArray(v).Elements {
+							labelNames = append(labelNames, ToString(label))
+						}
+					case key == "buckets" && kind == metrics.Histogram && IsArray(v):
+						buckets = []float64{}
+						for _, bound := range AsArray(v).Elements {
+							f, err := number(name, bound)
+							if err != nil {
+								return NilValue(), err
+							}
+							buckets = append(buckets, f)
+						}
+					case key == "labels" || (key == "buckets" && kind == metrics.Histogram):
+						return NilValue(), fmt.Errorf("%s: %s must be an array, got %s", name, key, ValueType(v))
+					default:
+						return NilValue(), fmt.Errorf("%s: unknown option %q", name, key)
+					}
+				}
+			}
+			metricName := ToString(args[0])
+			if err := registry.Register(metricName, ToString(args[1]), kind, labelNames, buckets); err != nil {
+				return NilValue(), fmt.Errorf("%s: %v", name, err)
+			}
+			return BoxString(metricName), nil
+		}
+	}
+
+	vm.registerGlobal("metrics_counter", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_counter",
+		Arity:    -1,
+		Function: define(metrics.Counter),
+	})
+
+	vm.registerGlobal("metrics_gauge", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_gauge",
+		Arity:    -1,
+		Function: define(metrics.Gauge),
+	})
+
+	vm.registerGlobal("metrics_histogram", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_histogram",
+		Arity:    -1,
+		Function: define(metrics.Histogram),
+	})
+
+	// inc and dec take an optional amount, an optional labels map, or both
+	add := func(name string, sign float64) func(args []Value) (Value, error) {
+		return func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("%s expects 1-3 arguments (name, amount, labels), got %d", name, len(args))
+			}
+			amount, rest := 1.0, 1
+			if len(args) > 1 && (IsNumber(args[1]) || IsInt(args[1])) {
+				amount, rest = ToNumber(args[1]), 2
+			}
+			if len(args) > rest+1 {
+				return NilValue(), fmt.Errorf("%s: amount must be a number, got %s", name, ValueType(args[1]))
+			}
+			values, err := labels(name, args, rest)
+			if err != nil {
+				return NilValue(), err
+			}
+			if err := registry.Add(ToString(args[0]), sign*amount, values); err != nil {
+				return NilValue(), fmt.Errorf("%s: %v", name, err)
+			}
+			return NilValue(), nil
+		}
+	}
+
+	vm.registerGlobal("metrics_inc", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_inc",
+		Arity:    -1,
+		Function: add("metrics_inc", 1),
+	})
+
+	vm.registerGlobal("metrics_dec", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_dec",
+		Arity:    -1,
+		Function: add("metrics_dec", -1),
+	})
+
+	record := func(name string, fn func(string, float64, map[string]string) error) func(args []Value) (Value, error) {
+		return func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("%s expects 2-3 arguments (name, value, labels), got %d", name, len(args))
+			}
+			value, err := number(name, args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			values, err := labels(name, args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			if err := fn(ToString(args[0]), value, values); err != nil {
+				return NilValue(), fmt.Errorf("%s: %v", name, err)
+			}
+			return NilValue(), nil
+		}
+	}
+
+	vm.registerGlobal("metrics_set", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_set",
+		Arity:    -1,
+		Function: record("metrics_set", registry.Set),
+	})
+
+	vm.registerGlobal("metrics_observe", &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     "metrics_observe",
+		Arity:    -1,
+		Function: record("metrics_observe", registry.Observe),
+	})
+
+	vm.registerGlobal("metrics_get", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "metrics_get",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("metrics_get expects 1-2 arguments (name, labels), got %d", len(args))
+			}
+			values, err := labels("metrics_get", args, 1)
+			if err != nil {
+				return NilValue(), err
+			}
+			name := ToString(args[0])
+			value, count, err := registry.Value(name, values)
+			if err != nil {
+				return NilValue(), fmt.Errorf("metrics_get: %v", err)
+			}
+			if kind, _ := registry.Kind(name); kind == metrics.Histogram {
+				return BoxMap(map[string]Value{
+					"count": BoxInt(int64(count)),
+					"sum":   BoxNumber(value),
+				}), nil
+			}
+			return BoxNumber(value), nil
+		},
+	})
+
+	vm.registerGlobal("metrics_expose", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "metrics_expose",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			var b strings.Builder
+			registry.Write(&b)
+			return BoxString(b.String()), nil
+		},
+	})
+
+	vm.registerGlobal("metrics_serve", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "metrics_serve",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			address, err := registry.Serve(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("metrics_serve: %v", err)
+			}
+			return BoxString(address), nil
+		},
+	})
+
+	vm.registerGlobal("metrics_shutdown", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "metrics_shutdown",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			ok, err := registry.Shutdown()
+			if err != nil {
+				return NilValue(), fmt.Errorf("metrics_shutdown: %v", err)
+			}
+			return BoxBool(ok), nil
+		},
+	})
+}
+
+// createMetricsModule creates the metrics built-in module
+func (vm *RegisterVM) createMetricsModule() *ModuleObj {
+	exports := make(map[string]Value)
+
+	for _, name := range []string{"counter", "gauge", "histogram", "inc", "dec", "set", "observe", "get", "expose", "serve", "shutdown"} {
+		exports[name] = vm.getGlobalByName("metrics_" + name)
+	}
+
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    "metrics",
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+
+	keepAlive(module)
+	return module
+}
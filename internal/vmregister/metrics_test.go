@@ -0,0 +1,78 @@
+package vmregister_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestMetrics(t *testing.T) {
+	globals := run(t, `
+import metrics
+metrics_counter("scans_total", "Scans run.", {"labels": ["status"]})
+metrics.gauge("queue_depth", "Hosts waiting to be scanned.")
+metrics_histogram("scan_seconds", "Scan duration.", {"buckets": [0.5, 1, 5]})
+metrics_inc("scans_total", {"status": "ok"})
+metrics.inc("scans_total", 2, {"status": "ok"})
+metrics_inc("scans_total", {"status": "fail \"quoted\""})
+metrics_set("queue_depth", 10)
+metrics_dec("queue_depth", 3)
+metrics.observe("scan_seconds", 0.2)
+metrics_observe("scan_seconds", 1)
+metrics_observe("scan_seconds", 7.5)
+let ok = metrics_get("scans_total", {"status": "ok"})
+let depth = metrics.get("queue_depth")
+let durations = metrics_get("scan_seconds")
+let exposed = metrics_expose()
+let address = metrics_serve("127.0.0.1:0")
+`)
+
+	tests := map[string]string{
+		"ok":                 "3",
+		"depth":              "7",
+		`durations["count"]`: "3",
+		`durations["sum"]`:   "8.7",
+	}
+	for expr, want := range tests {
+		value := globals[expr]
+		if name, key, ok := strings.Cut(strings.TrimSuffix(expr, `"]`), `["`); ok {
+			value = vmregister.AsMap(globals[name]).Items[key]
+		}
+		if got := vmregister.ToString(value); got != want {
+			t.Errorf("%s: got %q, want %q", expr, got, want)
+		}
+	}
+
+	want := `# HELP queue_depth Hosts waiting to be scanned.
+# TYPE queue_depth gauge
+queue_depth 7
+# HELP scan_seconds Scan duration.
+# TYPE scan_seconds histogram
+scan_seconds_bucket{le="0.5"} 1
+scan_seconds_bucket{le="1"} 2
+scan_seconds_bucket{le="5"} 2
+scan_seconds_bucket{le="+Inf"} 3
+scan_seconds_sum 8.7
+scan_seconds_count 3
+# HELP scans_total Scans run.
+# TYPE scans_total counter
+scans_total{status="fail \"quoted\""} 1
+scans_total{status="ok"} 3
+`
+	if got := vmregister.ToString(globals["exposed"]); got != want {
+		t.Errorf("exposed:\n%s\nwant:\n%s", got, want)
+	}
+
+	resp, err := http.Get("http://" + vmregister.ToString(globals["address"]) + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != want || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("/metrics: got %q (%s)", body, resp.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,272 @@
+package vmregister
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sentra/internal/threat_intel"
+)
+
+// mispLevels name the threat levels, analysis states and distributions of
+// MISP events
+var mispLevels = map[string]map[string]int{
+	"threat_level": {"high": 1, "medium": 2, "low": 3, "undefined": 4},
+	"analysis":     {"initial": 0, "ongoing": 1, "completed": 2},
+	"distribution": {"organisation": 0, "community": 1, "connected": 2, "all": 3},
+}
+
+// mispLevel reads a threat level, analysis state or distribution, by name
+// or number
+func mispLevel(key string, v Value) (int, error) {
+	names := mispLevels[key]
+	if IsNumber(v) || IsInt(v) {
+		n := int(ToNumber(v))
+		for _, known := range names {
+			if known == n {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("misp_add_event: bad %s %d", key, n)
+	}
+	if n, ok := names[strings.ToLower(ToString(v))]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("misp_add_event: bad %s '%s'", key, ToString(v))
+}
+
+// mispAttribute reads an attribute of misp_add_event: a map, or a value
+// whose type is guessed
+func mispAttribute(v Value) (threat_intel.MISPAttribute, error) {
+	if !IsMap(v) {
+		return threat_intel.MISPAttribute{Value: ToString(v), ToIDS: true}, nil
+	}
+	a := threat_intel.MISPAttribute{ToIDS: true}
+	for key, item := range AsMap(v).Items {
+		switch key {
+		case "type":
+			a.Type = ToString(item)
+		case "value":
+			a.Value = ToString(item)
+		case "category":
+			a.Category = ToString(item)
+		case "comment":
+			a.Comment = ToString(item)
+		case "to_ids":
+			a.ToIDS = IsTruthy(item)
+		default:
+			return a, fmt.Errorf("misp_add_event: unknown attribute key '%s'", key)
+		}
+	}
+	if a.Value == "" {
+		return a, fmt.Errorf("misp_add_event: an attribute needs a value")
+	}
+	return a, nil
+}
+
+// registerMISPFunctions registers the functions that search a MISP
+// instance and add events and sightings to it
+func (vm *RegisterVM) registerMISPFunctions() {
+	misp := func(name string) (*threat_intel.MISPClient, error) {
+		client, err := vm.threatIntelModule.(*threat_intel.ThreatIntelModule).MISP()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v; call misp_configure first", name, err)
+		}
+		return client, nil
+	}
+
+	// misp_configure(url, api_key, options?)
+	vm.registerGlobal("misp_configure", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "misp_configure",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("misp_configure expects 2-3 arguments (url, api_key, options), got %d", len(args))
+			}
+			var opts threat_intel.MISPOptions
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("misp_configure: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "insecure":
+						opts.Insecure = IsTruthy(v)
+					case "timeout_ms":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return NilValue(), fmt.Errorf("misp_configure: timeout_ms must be a positive number")
+						}
+						opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+					default:
+						return NilValue(), fmt.Errorf("misp_configure: unknown option '%s'", key)
+					}
+				}
+			}
+			tim := vm.threatIntelModule.(*threat_intel.ThreatIntelModule)
+			if err := tim.ConfigureMISP(ToString(args[0]), ToString(args[1]), opts); err != nil {
+				return NilValue(), fmt.Errorf("misp_configure: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// misp_search(query) takes a value, or a map of restSearch parameters
+	// such as type, tags, last, to_ids and limit
+	vm.registerGlobal("misp_search", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "misp_search",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			client, err := misp("misp_search")
+			if err != nil {
+				return NilValue(), err
+			}
+			query := map[string]interface{}{"value": ToString(args[0])}
+			if IsMap(args[0]) {
+				query = valueToGo(args[0]).(map[string]interface{})
+			}
+			attributes, err := client.Search(query)
+			if err != nil {
+				return NilValue(), fmt.Errorf("misp_search: %v", err)
+			}
+			list := make([]interface{}, len(attributes))
+			for i, a := range attributes {
+				tags := make([]interface{}, len(a.Tags))
+				for j, t := range a.Tags {
+					tags[j] = t
+				}
+				attribute := map[string]interface{}{
+					"id":         a.ID,
+					"event_id":   a.EventID,
+					"event_info": a.EventInfo,
+					"event_uuid": a.EventUUID,
+					"type":       a.Type,
+					"category":   a.Category,
+					"value":      a.Value,
+					"to_ids":     a.ToIDS,
+					"comment":    a.Comment,
+					"tags":       tags,
+				}
+				if ts, err := strconv.ParseInt(a.Timestamp, 10, 64); err == nil {
+					attribute["timestamp"] = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+				}
+				list[i] = attribute
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// misp_add_event(event) creates an event from a map of info,
+	// threat_level, analysis, distribution, published, tags and attributes
+	vm.registerGlobal("misp_add_event", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "misp_add_event",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[0]) {
+				return NilValue(), fmt.Errorf("misp_add_event: event must be a map, got %s", ValueType(args[0]))
+			}
+			var event threat_intel.MISPEvent
+			for key, v := range AsMap(args[0]).Items {
+				var err error
+				switch key {
+				case "info":
+					event.Info = ToString(v)
+				case "threat_level":
+					event.ThreatLevel, err = mispLevel(key, v)
+				case "analysis":
+					event.Analysis, err = mispLevel(key, v)
+				case "distribution":
+					event.Distribution, err = mispLevel(key, v)
+				case "published":
+					event.Published = IsTruthy(v)
+				case "tags":
+					event.Tags = stringList(v)
+				case "attributes":
+					if !IsArray(v) {
+						return NilValue(), fmt.Errorf("misp_add_event: attributes must be an array, got %s", ValueType(v))
+					}
+					for _, elem := range AsArray(v).Elements {
+						a, err := mispAttribute(elem)
+						if err != nil {
+							return NilValue(), err
+						}
+						event.Attributes = append(event.Attributes, a)
+					}
+				default:
+					return NilValue(), fmt.Errorf("misp_add_event: unknown key '%s'", key)
+				}
+				if err != nil {
+					return NilValue(), err
+				}
+			}
+			client, err := misp("misp_add_event")
+			if err != nil {
+				return NilValue(), err
+			}
+			id, uuid, err := client.AddEvent(event)
+			if err != nil {
+				return NilValue(), fmt.Errorf("misp_add_event: %v", err)
+			}
+			return goToValue(map[string]interface{}{
+				"id":         id,
+				"uuid":       uuid,
+				"attributes": len(event.Attributes),
+			}), nil
+		},
+	})
+
+	// misp_add_sighting(value, options?) records a sighting of the
+	// attributes with a value, or of the one named by attribute_id
+	vm.registerGlobal("misp_add_sighting", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "misp_add_sighting",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("misp_add_sighting expects 1-2 arguments (value, options), got %d", len(args))
+			}
+			attributeID, typ, source := "", "sighting", ""
+			var at time.Time
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("misp_add_sighting: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "attribute_id":
+						attributeID = ToString(v)
+					case "type":
+						typ = ToString(v)
+					case "source":
+						source = ToString(v)
+					case "timestamp":
+						if !(IsNumber(v) || IsInt(v)) {
+							return NilValue(), fmt.Errorf("misp_add_sighting: timestamp must be unix seconds")
+						}
+						at = time.Unix(int64(ToNumber(v)), 0)
+					default:
+						return NilValue(), fmt.Errorf("misp_add_sighting: unknown option '%s'", key)
+					}
+				}
+			}
+			client, err := misp("misp_add_sighting")
+			if err != nil {
+				return NilValue(), err
+			}
+			s, err := client.AddSighting(ToString(args[0]), attributeID, typ, source, at)
+			if err != nil {
+				return NilValue(), fmt.Errorf("misp_add_sighting: %v", err)
+			}
+			return goToValue(map[string]interface{}{
+				"id":           s.ID,
+				"attribute_id": s.AttributeID,
+				"event_id":     s.EventID,
+				"type":         s.Type,
+				"source":       s.Source,
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,67 @@
+package vmregister_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestMISP(t *testing.T) {
+	var events []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/attributes/restSearch":
+			io.WriteString(w, `{"response": {"Attribute": [{"id": "12", "event_id": "3", "type": "domain", "value": "evil.example",
+				"to_ids": true, "timestamp": "1704067200", "Event": {"info": "Phishing wave"}, "Tag": [{"name": "tlp:amber"}]}]}}`)
+		case "/events/add":
+			events = append(events, string(body))
+			io.WriteString(w, `{"Event": {"id": "42", "uuid": "c0ffee"}}`)
+		case "/sightings/add/12":
+			io.WriteString(w, `{"Sighting": {"id": "7", "attribute_id": "12", "event_id": "3", "type": "1", "source": "edr"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	globals := run(t, `
+misp_configure("`+srv.URL+`", "key", {"timeout_ms": 5000})
+let found = misp_search("evil.example")
+let attr = found[0]["event_info"] + " " + found[0]["tags"][0] + " " + found[0]["timestamp"]
+let event = misp_add_event({"info": "Beaconing from host-7", "threat_level": "high", "distribution": "community",
+                            "tags": ["tlp:green"], "attributes": ["198.51.100.7", {"type": "filename", "value": "x.exe", "to_ids": false}]})
+let created = event["id"] + " " + str(event["attributes"])
+let s = misp_add_sighting("evil.example", {"attribute_id": found[0]["id"], "type": "false_positive", "source": "edr"})
+let sighting = s["id"] + " " + s["type"]
+`)
+	for name, want := range map[string]string{
+		"attr":     "Phishing wave tlp:amber 2024-01-01T00:00:00Z",
+		"created":  "42 2",
+		"sighting": "7 1",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if len(events) != 1 || !strings.Contains(events[0], `"threat_level_id":"1"`) || !strings.Contains(events[0], `"type":"ip-dst"`) {
+		t.Errorf("events = %v", events)
+	}
+
+	expectErrors(t, map[string]string{
+		`misp_search("x")`:                                        "misp_search: MISP is not configured; call misp_configure first",
+		`misp_configure("misp.example", "key")`:                   "misp_configure: bad MISP url 'misp.example'",
+		`misp_configure("https://misp.example", "k", {"a": 1})`:   "misp_configure: unknown option 'a'",
+		`misp_add_event({"info": "x", "threat_level": "severe"})`: "misp_add_event: bad threat_level 'severe'",
+		`misp_add_event({"info": "x", "analysis": 7})`:            "misp_add_event: bad analysis 7",
+		`misp_add_event({"title": "x"})`:                          "misp_add_event: unknown key 'title'",
+		`misp_add_event({"attributes": [{"type": "domain"}]})`:    "misp_add_event: an attribute needs a value",
+		`misp_add_sighting("x", {"when": 1})`:                     "misp_add_sighting: unknown option 'when'",
+	})
+}
@@ -0,0 +1,115 @@
+package vmregister
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// mockEntry records a builtin replaced by Mock
+type mockEntry struct {
+	id       uint16
+	original Value
+	calls    []Value // Argument arrays, one per call
+}
+
+// NewNativeFn wraps a Go function as a callable Sentra value
+func NewNativeFn(name string, arity int, fn func(args []Value) (Value, error)) Value {
+	obj := &NativeFnObj{
+		Object:   Object{Type: OBJ_NATIVE_FN},
+		Name:     name,
+		Arity:    arity,
+		Function: fn,
+	}
+	keepAlive(obj)
+	return BoxPointer(unsafe.Pointer(obj))
+}
+
+// RegisterModule makes a Go-implemented module available to import
+func (vm *RegisterVM) RegisterModule(name string, exports map[string]Value) {
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    name,
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+	keepAlive(module)
+	vm.modules[name] = module
+}
+
+// Mock replaces the global function name for testing. A callable replacement
+// is called with the original arguments; any other value is returned as-is.
+// Every call is recorded and can be read back with MockCalls.
+func (vm *RegisterVM) Mock(name string, replacement Value) error {
+	id, ok := vm.globalNames[name]
+	if !ok {
+		return fmt.Errorf("cannot mock %s: no such global", name)
+	}
+
+	if vm.mocks == nil {
+		vm.mocks = make(map[string]*mockEntry)
+	}
+	entry, mocked := vm.mocks[name]
+	if !mocked {
+		entry = &mockEntry{id: id, original: vm.globals[id]}
+		vm.mocks[name] = entry
+	}
+	entry.calls = nil
+
+	callable := IsPointer(replacement) && isCallableType(AsObject(replacement).Type)
+	stub := NewNativeFn(name, -1, func(args []Value) (Value, error) {
+		// args may alias the VM's argument buffer, so copy before recording
+		recorded := make([]Value, len(args))
+		copy(recorded, args)
+		entry.calls = append(entry.calls, BoxArray(recorded))
+		if callable {
+			return vm.callValue(replacement, recorded)
+		}
+		return replacement, nil
+	})
+
+	vm.replaceGlobal(entry, stub)
+	return nil
+}
+
+// MockCalls returns the recorded argument arrays for a mocked function
+func (vm *RegisterVM) MockCalls(name string) []Value {
+	if entry, ok := vm.mocks[name]; ok {
+		return entry.calls
+	}
+	return nil
+}
+
+// RestoreMocks puts back every function replaced by Mock
+func (vm *RegisterVM) RestoreMocks() {
+	for name, entry := range vm.mocks {
+		vm.replaceGlobal(entry, entry.original)
+		delete(vm.mocks, name)
+	}
+}
+
+// RestoreMock puts back a single mocked function
+func (vm *RegisterVM) RestoreMock(name string) {
+	if entry, ok := vm.mocks[name]; ok {
+		vm.replaceGlobal(entry, entry.original)
+		delete(vm.mocks, name)
+	}
+}
+
+// replaceGlobal swaps a global and any module exports that alias it
+// (e.g. http.get after `import http`)
+func (vm *RegisterVM) replaceGlobal(entry *mockEntry, value Value) {
+	current := vm.globals[entry.id]
+	vm.globals[entry.id] = value
+	for _, module := range vm.modules {
+		for key, export := range module.Exports {
+			if export == current || export == entry.original {
+				module.Exports[key] = value
+			}
+		}
+	}
+}
+
+func isCallableType(t ObjectType) bool {
+	return t == OBJ_FUNCTION || t == OBJ_CLOSURE || t == OBJ_NATIVE_FN
+}
@@ -0,0 +1,297 @@
+package vmregister
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"sentra/internal/notify"
+)
+
+// notifyMessage reads a message: a string taken as its text, or a map of
+// title, text, severity, url and fields
+func notifyMessage(name string, v Value) (notify.Message, error) {
+	var m notify.Message
+	if !IsMap(v) {
+		m.Text = ToString(v)
+		return m, nil
+	}
+	for key, field := range AsMap(v).Items {
+		switch key {
+		case "title":
+			m.Title = ToString(field)
+		case "text":
+			m.Text = ToString(field)
+		case "severity":
+			m.Severity = ToString(field)
+		case "url":
+			m.URL = ToString(field)
+		case "fields":
+			if !IsMap(field) {
+				return m, fmt.Errorf("%s: fields must be a map, got %s", name, ValueType(field))
+			}
+			m.Fields = valueToGo(field).(map[string]interface{})
+		default:
+			return m, fmt.Errorf("%s: unknown message field '%s'", name, key)
+		}
+	}
+	return m, nil
+}
+
+// renderValue fills the placeholders of every string in a payload
+func renderValue(v interface{}, data map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return notify.Render(t, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			rendered[k] = renderValue(item, data)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(t))
+		for i, item := range t {
+			rendered[i] = renderValue(item, data)
+		}
+		return rendered
+	}
+	return v
+}
+
+// notifyResultValue returns how a notification was delivered as a map
+func notifyResultValue(r *notify.Result) map[string]interface{} {
+	return map[string]interface{}{
+		"status":   r.Status,
+		"attempts": r.Attempts,
+		"body":     r.Body,
+	}
+}
+
+// registerNotifyFunctions registers the functions that alert people in
+// Slack, Teams and PagerDuty or through any webhook. Their text may hold
+// {{ name }} placeholders, filled from the data option, so a finding or an
+// incident can be passed as it is.
+func (vm *RegisterVM) registerNotifyFunctions() {
+	// parseOptions reads the options every notify_ function takes and
+	// passes the others to other
+	parseOptions := func(name string, args []Value, i int, other func(key string, v Value) error) (notify.Options, map[string]interface{}, error) {
+		var opts notify.Options
+		data := map[string]interface{}{}
+		if len(args) <= i {
+			return opts, data, nil
+		}
+		if !IsMap(args[i]) {
+			return opts, nil, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+		}
+		for key, v := range AsMap(args[i]).Items {
+			switch key {
+			case "data":
+				if !IsMap(v) {
+					return opts, nil, fmt.Errorf("%s: data must be a map, got %s", name, ValueType(v))
+				}
+				data = valueToGo(v).(map[string]interface{})
+			case "retries", "backoff_ms", "timeout_ms":
+				if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+					return opts, nil, fmt.Errorf("%s: %s must be a positive number", name, key)
+				}
+				n := ToNumber(v)
+				switch key {
+				case "retries":
+					opts.Retries = int(n)
+				case "backoff_ms":
+					opts.Backoff = time.Duration(n * float64(time.Millisecond))
+				case "timeout_ms":
+					opts.Timeout = time.Duration(n * float64(time.Millisecond))
+				}
+			case "insecure":
+				opts.Insecure = IsTruthy(v)
+			default:
+				if other == nil {
+					return opts, nil, fmt.Errorf("%s: unknown option '%s'", name, key)
+				}
+				if err := other(key, v); err != nil {
+					return opts, nil, err
+				}
+			}
+		}
+		return opts, data, nil
+	}
+	checkArgs := func(name, params string, args []Value, min int) error {
+		if len(args) < min || len(args) > min+1 {
+			return fmt.Errorf("%s expects %d-%d arguments (%s), got %d", name, min, min+1, params, len(args))
+		}
+		return nil
+	}
+
+	// notify_slack(webhook_url, message, options?)
+	vm.registerGlobal("notify_slack", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "notify_slack",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if err := checkArgs("notify_slack", "webhook_url, message, options", args, 2); err != nil {
+				return NilValue(), err
+			}
+			var channel, username, icon string
+			opts, data, err := parseOptions("notify_slack", args, 2, func(key string, v Value) error {
+				switch key {
+				case "channel":
+					channel = ToString(v)
+				case "username":
+					username = ToString(v)
+				case "icon":
+					icon = ToString(v)
+				default:
+					return fmt.Errorf("notify_slack: unknown option '%s'", key)
+				}
+				return nil
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+			m, err := notifyMessage("notify_slack", args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := notify.Send(ToString(args[0]), notify.SlackPayload(notify.RenderMessage(m, data), channel, username, icon), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("notify_slack: %v", err)
+			}
+			return goToValue(notifyResultValue(result)), nil
+		},
+	})
+
+	// notify_teams(webhook_url, message, options?)
+	vm.registerGlobal("notify_teams", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "notify_teams",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if err := checkArgs("notify_teams", "webhook_url, message, options", args, 2); err != nil {
+				return NilValue(), err
+			}
+			opts, data, err := parseOptions("notify_teams", args, 2, nil)
+			if err != nil {
+				return NilValue(), err
+			}
+			m, err := notifyMessage("notify_teams", args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := notify.Send(ToString(args[0]), notify.TeamsPayload(notify.RenderMessage(m, data)), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("notify_teams: %v", err)
+			}
+			return goToValue(notifyResultValue(result)), nil
+		},
+	})
+
+	// notify_pagerduty(routing_key, message, options?) triggers an alert,
+	// or acknowledges or resolves one by its dedup_key, which the result
+	// holds
+	vm.registerGlobal("notify_pagerduty", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "notify_pagerduty",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if err := checkArgs("notify_pagerduty", "routing_key, message, options", args, 2); err != nil {
+				return NilValue(), err
+			}
+			var action, dedupKey, source string
+			endpoint := notify.PagerDutyURL
+			opts, data, err := parseOptions("notify_pagerduty", args, 2, func(key string, v Value) error {
+				switch key {
+				case "action":
+					action = ToString(v)
+				case "dedup_key":
+					dedupKey = ToString(v)
+				case "source":
+					source = ToString(v)
+				case "url":
+					endpoint = ToString(v)
+				default:
+					return fmt.Errorf("notify_pagerduty: unknown option '%s'", key)
+				}
+				return nil
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+			m, err := notifyMessage("notify_pagerduty", args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			event, err := notify.PagerDutyEvent(ToString(args[0]), action, notify.Render(dedupKey, data), source, notify.RenderMessage(m, data))
+			if err != nil {
+				return NilValue(), fmt.Errorf("notify_pagerduty: %v", err)
+			}
+			result, err := notify.Send(endpoint, event, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("notify_pagerduty: %v", err)
+			}
+			value := notifyResultValue(result)
+			var answer struct {
+				DedupKey string `json:"dedup_key"`
+			}
+			json.Unmarshal([]byte(result.Body), &answer)
+			value["dedup_key"] = answer.DedupKey
+			return goToValue(value), nil
+		},
+	})
+
+	// notify_webhook(url, payload, options?) sends any payload as JSON,
+	// filling the placeholders of its strings
+	vm.registerGlobal("notify_webhook", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "notify_webhook",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if err := checkArgs("notify_webhook", "url, payload, options", args, 2); err != nil {
+				return NilValue(), err
+			}
+			var method, secret string
+			headers := make(map[string]string)
+			opts, data, err := parseOptions("notify_webhook", args, 2, func(key string, v Value) error {
+				switch key {
+				case "method":
+					method = ToString(v)
+				case "secret":
+					secret = ToString(v)
+				case "headers":
+					if !IsMap(v) {
+						return fmt.Errorf("notify_webhook: headers must be a map, got %s", ValueType(v))
+					}
+					for k, h := range AsMap(v).Items {
+						headers[k] = ToString(h)
+					}
+				default:
+					return fmt.Errorf("notify_webhook: unknown option '%s'", key)
+				}
+				return nil
+			})
+			if err != nil {
+				return NilValue(), err
+			}
+			opts.Method, opts.Secret, opts.Headers = method, secret, headers
+			result, err := notify.Send(ToString(args[0]), renderValue(valueToGo(args[1]), data), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("notify_webhook: %v", err)
+			}
+			return goToValue(notifyResultValue(result)), nil
+		},
+	})
+
+	// notify_render(template, data) fills the placeholders of a template
+	vm.registerGlobal("notify_render", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "notify_render",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[1]) {
+				return NilValue(), fmt.Errorf("notify_render: data must be a map, got %s", ValueType(args[1]))
+			}
+			return BoxString(notify.Render(ToString(args[0]), valueToGo(args[1]).(map[string]interface{}))), nil
+		},
+	})
+}
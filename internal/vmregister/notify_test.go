@@ -0,0 +1,56 @@
+package vmregister_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestNotify(t *testing.T) {
+	bodies := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies[r.URL.Path] = string(data)
+		if r.URL.Path == "/pd" {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"status": "success", "message": "Event processed", "dedup_key": "scan-7"}`))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	globals := run(t, `
+let finding = {"title": "SSH open", "severity": "high", "details": {"host": "web1"}}
+let slack = notify_slack("`+server.URL+`/slack", {"title": "{{title}} on {{details.host}}", "severity": "{{severity}}"}, {"data": finding, "channel": "#soc"})
+let paged = notify_pagerduty("key", "{{title}}", {"data": finding, "url": "`+server.URL+`/pd", "dedup_key": "scan-7"})
+let hook = notify_webhook("`+server.URL+`/hook", {"event": "finding", "host": "{{details.host}}"}, {"data": finding, "secret": "s"})
+let line = notify_render("[{{severity}}] {{title}}", finding)
+let summary = str(slack["status"]) + " " + str(paged["status"]) + " " + paged["dedup_key"] + " " + hook["body"]
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "200 202 scan-7 ok"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["line"]), "[high] SSH open"; got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+	if !strings.Contains(bodies["/slack"], `"text":"SSH open on web1: "`) && !strings.Contains(bodies["/slack"], `"title":"SSH open on web1"`) {
+		t.Errorf("slack body = %s", bodies["/slack"])
+	}
+	if !strings.Contains(bodies["/pd"], `"summary":"SSH open"`) || !strings.Contains(bodies["/hook"], `"host":"web1"`) {
+		t.Errorf("pagerduty body = %s, webhook body = %s", bodies["/pd"], bodies["/hook"])
+	}
+
+	expectErrors(t, map[string]string{
+		`notify_slack("https://hooks.example", "hi", {"emoji": ":x:"})`: "notify_slack: unknown option 'emoji'",
+		`notify_teams("https://hooks.example", {"body": "hi"})`:         "notify_teams: unknown message field 'body'",
+		`notify_pagerduty("key", "down", {"action": "resolve"})`:        "notify_pagerduty: pagerduty needs the dedup key of the alert to resolve",
+		`notify_webhook("ftp://hooks.example", {})`:                     "notify_webhook: needs an http or https url, got 'ftp://hooks.example'",
+		`notify_webhook("https://hooks.example", {}, {"retries": 0})`:   "notify_webhook: retries must be a positive number",
+		`notify_teams("https://hooks.example")`:                         "notify_teams expects 2-3 arguments (webhook_url, message, options), got 1",
+		`notify_render("{{x}}", "x")`:                                   "notify_render: data must be a map, got string",
+	})
+}
@@ -0,0 +1,130 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/cryptoanalysis"
+)
+
+// registerPasswordFunctions registers hash identification and wordlist
+// cracking, for password audits that need no external tools
+func (vm *RegisterVM) registerPasswordFunctions() {
+	// hash_identify(hash) returns the formats a hash may be in, likeliest
+	// first, with their hashcat modes and John the Ripper formats
+	vm.registerGlobal("hash_identify", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "hash_identify",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			guesses := []interface{}{}
+			for _, g := range cryptoanalysis.IdentifyHash(ToString(args[0])) {
+				m := map[string]interface{}{"name": g.Name, "john_format": g.John, "crackable": g.Crackable}
+				if g.Hashcat >= 0 {
+					m["hashcat_mode"] = g.Hashcat
+				}
+				guesses = append(guesses, m)
+			}
+			return goToValue(guesses), nil
+		},
+	})
+
+	// hash_crack(hashes, wordlist, options?) tries the words of a wordlist
+	// file, or an array of words, against a hash or an array of them
+	vm.registerGlobal("hash_crack", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "hash_crack",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("hash_crack expects 2-3 arguments (hashes, wordlist, options), got %d", len(args))
+			}
+			hashes := []string{ToString(args[0])}
+			if IsArray(args[0]) {
+				hashes = stringList(args[0])
+			}
+			words := cryptoanalysis.WordlistFile(ToString(args[1]))
+			if IsArray(args[1]) {
+				words = cryptoanalysis.WordlistOf(stringList(args[1]))
+			}
+
+			var opts cryptoanalysis.CrackOptions
+			var callErr error
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("hash_crack: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "format":
+						opts.Format = ToString(v)
+					case "rules":
+						opts.Rules = []string{ToString(v)}
+						if IsArray(v) {
+							opts.Rules = stringList(v)
+						}
+					case "limit":
+						opts.Limit = int(ToInt(v))
+					case "timeout_ms":
+						opts.Timeout, err = durationOption("hash_crack", key, v, time.Millisecond)
+					case "progress_interval_ms":
+						opts.ProgressInterval, err = durationOption("hash_crack", key, v, time.Millisecond)
+					case "progress":
+						if !IsPointer(v) || !isCallableType(AsObject(v).Type) {
+							return NilValue(), fmt.Errorf("hash_crack: progress must be a function")
+						}
+						callback := v
+						// Returning false from the callback stops cracking
+						opts.Progress = func(p cryptoanalysis.CrackProgress) bool {
+							result, err := vm.callValue(callback, []Value{goToValue(map[string]interface{}{
+								"tried":      p.Tried,
+								"cracked":    p.Cracked,
+								"remaining":  p.Remaining,
+								"elapsed_ms": p.Elapsed.Milliseconds(),
+								"rate":       p.Rate,
+							})})
+							if err != nil {
+								callErr = err
+								return false
+							}
+							return !(IsBool(result) && !AsBool(result))
+						}
+					default:
+						err = fmt.Errorf("hash_crack: unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+
+			result, err := cryptoanalysis.Crack(hashes, words, opts)
+			if callErr != nil {
+				return NilValue(), callErr
+			}
+			if err != nil {
+				return NilValue(), fmt.Errorf("hash_crack: %v", err)
+			}
+			cracked := []interface{}{}
+			for _, c := range result.Cracked {
+				m := map[string]interface{}{"hash": c.Hash, "format": c.Format, "password": c.Password}
+				if c.User != "" {
+					m["user"] = c.User
+				}
+				cracked = append(cracked, m)
+			}
+			uncracked := []interface{}{}
+			for _, h := range result.Uncracked {
+				uncracked = append(uncracked, h)
+			}
+			return goToValue(map[string]interface{}{
+				"cracked":    cracked,
+				"uncracked":  uncracked,
+				"tried":      result.Tried,
+				"elapsed_ms": result.Elapsed.Milliseconds(),
+				"status":     result.Status,
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package vmregister_test
+
+import (
+	"os"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestPasswordCracking(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/words.txt", []byte("letmein\npassword\nwinter\n"), 0644)
+	globals := run(t, `
+let guesses = hash_identify("5f4dcc3b5aa765d61d8327deb882cf99")
+let shadow = hash_identify("root:$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/:19000:0:99999:7:::")[0]
+let calls = 0
+let result = hash_crack([
+    "Administrator:500:aad3b435b51404eeaad3b435b51404ee:8846f7eaee8fb117ad06bdd830b7586c:::",
+    "alice:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/:19000:0:99999:7:::",
+    "2ac9cb7dc02b3c0083eb70898e549b63",
+    "9f9d51bc70ef21ca5c14f307980a29d8"
+], "`+dir+`/words.txt", {"rules": "default", "progress": fn(p) { calls = calls + 1 }})
+let cracked = ""
+for c in result["cracked"] {
+    cracked = cracked + c["format"] + ":" + c["password"] + " "
+}
+let summary = len(guesses) + " " + guesses[1]["name"] + " " + guesses[1]["hashcat_mode"] + " " + shadow["name"] + " " + shadow["john_format"] + " " + result["status"] + " " + len(result["uncracked"]) + " " + calls
+let inline = hash_crack("5f4dcc3b5aa765d61d8327deb882cf99", ["a", "password"])["cracked"][0]["password"]
+`)
+	if got, want := vmregister.ToString(globals["cracked"]), "ntlm:password md5crypt:password md5:Password1 "; got != want {
+		t.Errorf("cracked = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["summary"]), "4 ntlm 1000 sha512crypt sha512crypt exhausted 1 1"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got := vmregister.ToString(globals["inline"]); got != "password" {
+		t.Errorf("inline = %q", got)
+	}
+
+	expectErrors(t, map[string]string{
+		`hash_crack("$y$j9T$F5Jx5fExrKuPp53xLKQ..1$X3DX6M94c7o.9agCG9G317fhZg9SqC.5i5rd.RhAtQ7", ["a"])`: "hash_crack: cannot crack yescrypt hashes",
+		`hash_crack("not a hash", ["a"])`:                                           `hash_crack: unrecognised hash "not a hash"`,
+		`hash_crack("5f4dcc3b5aa765d61d8327deb882cf99", ["a"], {"rules": "x"})`:     `hash_crack: rule "x": unknown function 'x'`,
+		`hash_crack("5f4dcc3b5aa765d61d8327deb882cf99", ["a"], {"mask": "?d"})`:     "hash_crack: unknown option 'mask'",
+		`hash_crack("5f4dcc3b5aa765d61d8327deb882cf99", ["a"], {"progress": 1})`:    "hash_crack: progress must be a function",
+		`hash_crack("5f4dcc3b5aa765d61d8327deb882cf99", "` + dir + `/missing.txt")`: "hash_crack: open",
+	})
+}
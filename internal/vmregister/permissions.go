@@ -0,0 +1,32 @@
+package vmregister
+
+import (
+	"fmt"
+
+	"sentra/internal/sandbox"
+)
+
+// SetPermissions makes every builtin that reads or writes files, uses the
+// network, runs programs, reads the environment or inspects the system
+// check p first (see sandbox.BuiltinRule). A refused call fails with a
+// *sandbox.PermissionError. Call it once, before running the script.
+func (vm *RegisterVM) SetPermissions(p *sandbox.Policy) {
+	vm.permissions = p
+	for _, name := range sandbox.Builtins() {
+		value := vm.getGlobalByName(name)
+		if !IsNativeFn(value) {
+			continue
+		}
+		rule, _ := sandbox.BuiltinRule(name)
+		fn := AsNativeFn(value)
+		// Wrapping in place also covers module exports such as io.readfile
+		call := fn.Function
+		fn.Function = func(args []Value) (Value, error) {
+			err := rule.Check(p, len(args), func(i int) string { return ToString(args[i]) })
+			if err != nil {
+				return NilValue(), fmt.Errorf("%s: %w", name, err)
+			}
+			return call(args)
+		}
+	}
+}
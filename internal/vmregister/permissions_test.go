@@ -0,0 +1,140 @@
+package vmregister_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sentra/internal/sandbox"
+	"sentra/internal/vmregister"
+)
+
+func TestPermissions(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(allowed, "a.txt"), []byte("inside"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("outside"), 0o644)
+	// A link in the allowed directory does not lead out of it
+	os.Symlink(filepath.Join(dir, "b.txt"), filepath.Join(allowed, "link.txt"))
+
+	sandboxed := func(p *sandbox.Policy, source string) (map[string]vmregister.Value, error) {
+		t.Helper()
+		vm := vmregister.NewRegisterVM()
+		vm.SetPermissions(p)
+		return execute(t, vm, source)
+	}
+	policy := func(flags ...string) *sandbox.Policy {
+		p := sandbox.NewPolicy()
+		for _, flag := range flags {
+			if ok, err := p.ParseFlag(flag); !ok || err != nil {
+				t.Fatalf("%s: %v", flag, err)
+			}
+		}
+		return p
+	}
+
+	readOnly := policy("--allow-read=" + allowed)
+	globals, err := sandboxed(readOnly, `let text = read_file("`+filepath.Join(allowed, "a.txt")+`")`)
+	if err != nil {
+		t.Fatalf("reading an allowed file: %v", err)
+	}
+	if got := vmregister.ToString(globals["text"]); got != "inside" {
+		t.Errorf("text: got %q", got)
+	}
+
+	for _, source := range []string{
+		`read_file("` + filepath.Join(dir, "b.txt") + `")`,
+		`read_file("` + filepath.Join(allowed, "..", "b.txt") + `")`,
+		`read_file("` + filepath.Join(allowed, "link.txt") + `")`,
+		`import io
+io.readfile("` + filepath.Join(dir, "b.txt") + `")`,
+	} {
+		_, err := sandboxed(readOnly, source)
+		var perr *sandbox.PermissionError
+		if !errors.As(err, &perr) || perr.Capability != sandbox.Read || perr.Denied {
+			t.Errorf("%s: got %v, want a read permission error", source, err)
+		}
+	}
+
+	// Without --allow flags only denials apply
+	_, err = sandboxed(policy("--deny-exec"), `process_run("echo", ["hi"])`)
+	var perr *sandbox.PermissionError
+	if !errors.As(err, &perr) || !perr.Denied || perr.Capability != sandbox.Exec {
+		t.Errorf("--deny-exec: got %v", err)
+	} else if want := "permission denied: exec access to echo (denied by --deny-exec)"; !strings.Contains(err.Error(), want) {
+		t.Errorf("message: got %q, want %q", err.Error(), want)
+	}
+	if _, err := sandboxed(policy("--deny-exec"), `let home = env("HOME")`); err != nil {
+		t.Errorf("--deny-exec refused env: %v", err)
+	}
+
+	// A sandboxed script cannot write log files outside what it may write
+	_, err = sandboxed(readOnly, `log_add_sink("file", {"path": "`+filepath.Join(allowed, "run.log")+`"})`)
+	if !errors.As(err, &perr) || perr.Capability != sandbox.Write {
+		t.Errorf("log file sink: got %v", err)
+	}
+
+	// Both paths of a rename must be writable, or it could move a file out
+	writeAllowed := policy("--allow-write=" + allowed)
+	moved := filepath.Join(allowed, "moved.txt")
+	if _, err := sandboxed(writeAllowed, `rename_file("`+filepath.Join(allowed, "a.txt")+`", "`+moved+`")`); err != nil {
+		t.Errorf("renaming within an allowed directory: %v", err)
+	}
+	_, err = sandboxed(writeAllowed, `rename_file("`+moved+`", "`+filepath.Join(dir, "moved.txt")+`")`)
+	if !errors.As(err, &perr) || perr.Capability != sandbox.Write {
+		t.Errorf("renaming into a denied directory: got %v", err)
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("refused rename moved the file: %v", err)
+	}
+
+	// A DNS query may only go to a resolver the script may reach
+	netAllowed := policy("--allow-net=example.com,192.0.2.1")
+	for source, want := range map[string]string{
+		`dns_query("example.com", "TXT", {"server": "203.0.113.1"})`:                        "net access to 203.0.113.1:53",
+		`dns_lookup("example.com", "A", {"doh": "https://doh.example.net/dns-query"})`:      "net access to https://doh.example.net/dns-query",
+		`dns_reverse("192.0.2.1", {"server": "203.0.113.1:5353"})`:                          "net access to 203.0.113.1:5353",
+		`mail_check_domain("example.com", {"dns": {"server": "203.0.113.1", "tcp": true}})`: "net access to 203.0.113.1:53",
+	} {
+		_, err := sandboxed(netAllowed, source)
+		if !errors.As(err, &perr) || perr.Capability != sandbox.Net || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", source, err, want)
+		}
+	}
+
+	// A vulnerability database elsewhere than the default needs access to
+	// its directory
+	db := filepath.Join(dir, "vulndb")
+	for _, c := range []struct {
+		policy *sandbox.Policy
+		source string
+		want   sandbox.Capability
+	}{
+		{readOnly, `vulndb_import("` + allowed + `", {"db_dir": "` + db + `"})`, sandbox.Write},
+		{policy("--allow-net"), `vulndb_update(["nvd"], {"db_dir": "` + db + `"})`, sandbox.Write},
+		{writeAllowed, `vulndb_status({"db_dir": "` + db + `"})`, sandbox.Read},
+		{writeAllowed, `cve_lookup("CVE-2021-44228", {"db_dir": "` + db + `"})`, sandbox.Read},
+		{writeAllowed, `product_match("cpe:2.3:a:apache:log4j:2.14.1", {"db_dir": "` + db + `"})`, sandbox.Read},
+	} {
+		_, err := sandboxed(c.policy, c.source)
+		if !errors.As(err, &perr) || perr.Capability != c.want || perr.Resource != db {
+			t.Errorf("%s: got %v, want %s access to %s", c.source, err, c.want, db)
+		}
+	}
+	if _, err := sandboxed(readOnly, `let status = vulndb_status({"db_dir": "`+filepath.Join(allowed, "vulndb")+`"})`); err != nil {
+		t.Errorf("reading an allowed database: %v", err)
+	}
+
+	p := sandbox.NewPolicy()
+	if _, err := p.ParseFlag("--allow-disk"); err == nil {
+		t.Error("--allow-disk: expected an unknown capability error")
+	}
+	if ok, _ := p.ParseFlag("--production"); ok {
+		t.Error("--production parsed as a permission flag")
+	}
+}
@@ -0,0 +1,126 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// scanResultMap returns a port of a scan as a map
+func scanResultMap(result network.ScanResult) Value {
+	return BoxMap(map[string]Value{
+		"port":    BoxInt(int64(result.Port)),
+		"state":   BoxString(result.State),
+		"service": BoxString(result.Service),
+		"banner":  BoxString(result.Banner),
+		"product": BoxString(result.Product),
+		"version": BoxString(result.Version),
+		"info":    BoxString(result.Info),
+		"cpe":     BoxString(result.CPE),
+	})
+}
+
+// parsePortScanOptions reads the options of port_scan at args[3]: a scan
+// type alone, or a map of the type, fingerprinting, a timing template and
+// its overrides, a progress callback and whether to return every port. An
+// error of the progress callback is stored in progressErr.
+func (vm *RegisterVM) parsePortScanOptions(args []Value, progressErr *error) (network.PortScanOptions, bool, error) {
+	opts := network.PortScanOptions{Type: "tcp"}
+	options := NilValue()
+	if len(args) > 3 {
+		if IsString(args[3]) {
+			opts.Type = ToString(args[3])
+		} else {
+			options = args[3]
+		}
+	}
+
+	timing := network.ScanTimings[3]
+	fingerprint, all := true, false
+	var parallelism, retries, delay *float64
+	progress := NilValue()
+	number := func(key string, v Value, min float64) (*float64, error) {
+		if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < min {
+			return nil, fmt.Errorf("port_scan: %s must be a number of at least %g", key, min)
+		}
+		n := ToNumber(v)
+		return &n, nil
+	}
+	fp, err := parseFingerprintOptions("port_scan", options, func(key string, v Value) error {
+		var err error
+		switch key {
+		case "type":
+			switch t := strings.ToLower(ToString(v)); t {
+			case "tcp", "connect", "syn", "udp":
+				opts.Type = t
+			default:
+				return fmt.Errorf("port_scan: unknown scan type '%s', want tcp, syn or udp", t)
+			}
+		case "fingerprint":
+			fingerprint = IsTruthy(v)
+		case "all":
+			all = IsTruthy(v)
+		case "timing":
+			name := ToString(v)
+			if IsNumber(v) || IsInt(v) {
+				name = fmt.Sprintf("T%d", int(ToNumber(v)))
+			}
+			timing, err = network.ScanTimingTemplate(name)
+		case "parallelism":
+			parallelism, err = number(key, v, 1)
+		case "retries":
+			retries, err = number(key, v, 0)
+		case "delay":
+			delay, err = number(key, v, 0)
+		case "progress":
+			if !IsPointer(v) || !isCallableType(AsObject(v).Type) {
+				return fmt.Errorf("port_scan: progress must be a function")
+			}
+			progress = v
+		default:
+			return fmt.Errorf("port_scan: unknown option '%s'", key)
+		}
+		return err
+	})
+	if err != nil {
+		return opts, false, err
+	}
+
+	// Overrides apply to the template whatever their order in the map
+	if fp.Timeout > 0 {
+		timing.Timeout = fp.Timeout
+	}
+	if parallelism != nil {
+		timing.Parallelism = int(*parallelism)
+	}
+	if retries != nil {
+		timing.Retries = int(*retries)
+	}
+	if delay != nil {
+		timing.Delay = time.Duration(*delay * float64(time.Millisecond))
+	}
+	opts.Timing = &timing
+	if fingerprint {
+		opts.Fingerprint = &fp
+	}
+
+	if !IsNil(progress) {
+		opts.Progress = func(done, total, open int) bool {
+			status := BoxMap(map[string]Value{
+				"done":    BoxInt(int64(done)),
+				"total":   BoxInt(int64(total)),
+				"open":    BoxInt(int64(open)),
+				"percent": BoxNumber(float64(done) * 100 / float64(total)),
+			})
+			result, err := vm.callValue(progress, []Value{status})
+			if err != nil {
+				*progressErr = err
+				return false
+			}
+			return !(IsBool(result) && !AsBool(result))
+		}
+	}
+	return opts, all, nil
+}
@@ -0,0 +1,48 @@
+package vmregister_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestPortScanTiming(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte("pong"), addr)
+		}
+	}()
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	globals := run(t, `
+let reports = []
+fn progress(status) {
+    push(reports, str(status["done"]) + "/" + str(status["total"]) + " " + str(status["percent"]) + "%")
+}
+let ports = port_scan("127.0.0.1", `+port+`, `+port+`, {"type": "udp", "timing": "T4", "retries": 0, "all": true, "progress": progress})
+let state = ports[0]["state"] + " " + ports[0]["banner"]
+let last = reports[len(reports) - 1]
+`)
+
+	tests := map[string]string{
+		"state": "open pong",
+		"last":  "1/1 100%",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
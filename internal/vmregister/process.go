@@ -0,0 +1,459 @@
+package vmregister
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// processSignals are the signals process_kill accepts and results report
+var processSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// signalName returns the SIGxxx name of sig
+func signalName(sig syscall.Signal) string {
+	for name, s := range processSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// processLine is a line of output read for a callback. done marks the end
+// of a stream.
+type processLine struct {
+	stderr bool
+	line   string
+	done   bool
+}
+
+// process is a command started with process_spawn
+type process struct {
+	cmd     *exec.Cmd
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started time.Time
+
+	stdinMu sync.Mutex
+	stdin   io.WriteCloser // nil once closed
+
+	// Output of streams without a callback is collected in full
+	stdout, stderr bytes.Buffer
+
+	// Lines of streams with a callback, sent by their readers. Callbacks
+	// run on the VM's goroutine when the script waits for the process.
+	onStdout, onStderr Value
+	lines              chan processLine
+	streams            int // Streams still sending lines
+	stop               chan struct{}
+}
+
+// closeStdin closes the standard input of the process if still open
+func (p *process) closeStdin() error {
+	p.stdinMu.Lock()
+	defer p.stdinMu.Unlock()
+	if p.stdin == nil {
+		return nil
+	}
+	err := p.stdin.Close()
+	p.stdin = nil
+	return err
+}
+
+// readLines sends each line of r to p.lines until the end of the stream or
+// until the process is abandoned
+func (p *process) readLines(r io.Reader, stderr bool) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			select {
+			case p.lines <- processLine{stderr: stderr, line: line}:
+			case <-p.stop:
+				return
+			}
+		}
+		if err != nil {
+			select {
+			case p.lines <- processLine{stderr: stderr, done: true}:
+			case <-p.stop:
+			}
+			return
+		}
+	}
+}
+
+// startProcess starts command with the options of process_spawn and
+// process_run: env, clear_env, cwd, timeout, stdin, on_stdout and on_stderr
+func startProcess(name string, command Value, args Value, options Value) (*process, error) {
+	if !IsArray(args) {
+		return nil, fmt.Errorf("%s: arguments must be an array, got %s", name, ValueType(args))
+	}
+	var argv []string
+	for _, arg := range AsArray(args).Elements {
+		argv = append(argv, ToString(arg))
+	}
+
+	p := &process{onStdout: NilValue(), onStderr: NilValue(), stop: make(chan struct{})}
+	timeout := time.Duration(0)
+	env := os.Environ()
+	var envVars map[string]Value
+	var input []byte
+	var cwd string
+
+	if !IsNil(options) {
+		if !IsMap(options) {
+			return nil, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(options))
+		}
+		for key, v := range AsMap(options).Items {
+			switch key {
+			case "env":
+				if !IsMap(v) {
+					return nil, fmt.Errorf("%s: env must be a map", name)
+				}
+				envVars = AsMap(v).Items
+			case "clear_env":
+				if IsTruthy(v) {
+					env = nil
+				}
+			case "cwd":
+				cwd = ToString(v)
+			case "timeout":
+				if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+					return nil, fmt.Errorf("%s: timeout must be a number of milliseconds", name)
+				}
+				timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+			case "stdin":
+				if IsBytes(v) {
+					input = AsBytes(v).Data
+				} else {
+					input = []byte(ToString(v))
+				}
+			case "on_stdout", "on_stderr":
+				if !IsPointer(v) || !isCallableType(AsObject(v).Type) {
+					return nil, fmt.Errorf("%s: %s must be a function", name, key)
+				}
+				if key == "on_stdout" {
+					p.onStdout = v
+				} else {
+					p.onStderr = v
+				}
+			default:
+				return nil, fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+		}
+	}
+	for key, v := range envVars {
+		env = append(env, key+"="+ToString(v))
+	}
+
+	p.ctx, p.cancel = context.Background(), func() {}
+	if timeout > 0 {
+		p.ctx, p.cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	cmd := exec.CommandContext(p.ctx, ToString(command), argv...)
+	cmd.Env = env
+	cmd.Dir = cwd
+	p.cmd = cmd
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		p.cancel()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	p.stdin = stdin
+
+	// Streams with a callback are read line by line, the others collected
+	var readers []func()
+	for _, s := range []struct {
+		callback Value
+		buf      *bytes.Buffer
+		stderr   bool
+	}{
+		{p.onStdout, &p.stdout, false},
+		{p.onStderr, &p.stderr, true},
+	} {
+		stderr := s.stderr
+		if IsNil(s.callback) {
+			if stderr {
+				cmd.Stderr = s.buf
+			} else {
+				cmd.Stdout = s.buf
+			}
+			continue
+		}
+		var r io.Reader
+		if stderr {
+			r, err = cmd.StderrPipe()
+		} else {
+			r, err = cmd.StdoutPipe()
+		}
+		if err != nil {
+			p.cancel()
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		readers = append(readers, func() { p.readLines(r, stderr) })
+	}
+	p.streams = len(readers)
+	p.lines = make(chan processLine, 256)
+
+	if err := cmd.Start(); err != nil {
+		p.cancel()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	p.started = time.Now()
+	for _, read := range readers {
+		go read()
+	}
+
+	if input != nil {
+		// Written in the background in case the process fills its output
+		// before reading all of its input
+		p.stdin = nil
+		go func() {
+			stdin.Write(input)
+			stdin.Close()
+		}()
+	}
+	return p, nil
+}
+
+// wait calls the output callbacks until the process exits and returns its
+// result: pid, exit_code, signal, success, timed_out, duration_ms, and the
+// stdout and stderr of streams without a callback
+func (p *process) wait(vm *RegisterVM) (Value, error) {
+	// A process reading its input until the end would never exit otherwise
+	p.closeStdin()
+
+	for p.streams > 0 {
+		ev := <-p.lines
+		if ev.done {
+			p.streams--
+			continue
+		}
+		callback := p.onStdout
+		if ev.stderr {
+			callback = p.onStderr
+		}
+		if _, err := vm.callValue(callback, []Value{BoxString(ev.line)}); err != nil {
+			close(p.stop)
+			p.cmd.Process.Kill()
+			p.cmd.Wait()
+			p.cancel()
+			return NilValue(), err
+		}
+	}
+
+	err := p.cmd.Wait()
+	timedOut := errors.Is(p.ctx.Err(), context.DeadlineExceeded)
+	p.cancel()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return NilValue(), err
+	}
+
+	state := p.cmd.ProcessState
+	signal := NilValue()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = BoxString(signalName(status.Signal()))
+	}
+	return BoxMap(map[string]Value{
+		"pid":         BoxInt(int64(state.Pid())),
+		"exit_code":   BoxInt(int64(state.ExitCode())),
+		"signal":      signal,
+		"success":     BoxBool(state.Success()),
+		"timed_out":   BoxBool(timedOut),
+		"duration_ms": BoxInt(time.Since(p.started).Milliseconds()),
+		"stdout":      BoxString(p.stdout.String()),
+		"stderr":      BoxString(p.stderr.String()),
+	}), nil
+}
+
+// registerProcessFunctions registers the process_* builtins. Processes are
+// referred to by handles like the file and socket functions.
+func (vm *RegisterVM) registerProcessFunctions() {
+	var mu sync.Mutex
+	var counter uint64
+	processes := make(map[string]*process)
+
+	lookup := func(name string, handle Value) (*process, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		p, ok := processes[ToString(handle)]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such process: %s", name, ToString(handle))
+		}
+		return p, nil
+	}
+
+	options := func(args []Value, i int) Value {
+		if len(args) > i {
+			return args[i]
+		}
+		return NilValue()
+	}
+
+	vm.registerGlobal("process_spawn", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_spawn",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("process_spawn expects 2-3 arguments (command, args, options), got %d", len(args))
+			}
+			p, err := startProcess("process_spawn", args[0], args[1], options(args, 2))
+			if err != nil {
+				return NilValue(), err
+			}
+			handle := "proc_" + strconv.FormatUint(atomic.AddUint64(&counter, 1), 10)
+			mu.Lock()
+			processes[handle] = p
+			mu.Unlock()
+			return BoxString(handle), nil
+		},
+	})
+
+	vm.registerGlobal("process_write", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_write",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			p, err := lookup("process_write", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			data := []byte(ToString(args[1]))
+			if IsBytes(args[1]) {
+				data = AsBytes(args[1]).Data
+			}
+			p.stdinMu.Lock()
+			defer p.stdinMu.Unlock()
+			if p.stdin == nil {
+				return NilValue(), fmt.Errorf("process_write: input of %s is closed", ToString(args[0]))
+			}
+			n, err := p.stdin.Write(data)
+			if err != nil {
+				return NilValue(), fmt.Errorf("process_write: %v", err)
+			}
+			return BoxInt(int64(n)), nil
+		},
+	})
+
+	vm.registerGlobal("process_close_stdin", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_close_stdin",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			p, err := lookup("process_close_stdin", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			return NilValue(), p.closeStdin()
+		},
+	})
+
+	vm.registerGlobal("process_wait", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_wait",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			p, err := lookup("process_wait", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := p.wait(vm)
+			mu.Lock()
+			delete(processes, ToString(args[0]))
+			mu.Unlock()
+			return result, err
+		},
+	})
+
+	vm.registerGlobal("process_kill", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_kill",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("process_kill expects 1-2 arguments (handle, signal), got %d", len(args))
+			}
+			p, err := lookup("process_kill", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			sig := syscall.SIGKILL
+			if len(args) == 2 && !IsNil(args[1]) {
+				name := strings.ToUpper(ToString(args[1]))
+				if !strings.HasPrefix(name, "SIG") {
+					name = "SIG" + name
+				}
+				s, ok := processSignals[name]
+				if !ok {
+					return NilValue(), fmt.Errorf("process_kill: unknown signal %s", ToString(args[1]))
+				}
+				sig = s
+			}
+			if err := p.cmd.Process.Signal(sig); err != nil {
+				return NilValue(), fmt.Errorf("process_kill: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("process_run", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "process_run",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("process_run expects 2-3 arguments (command, args, options), got %d", len(args))
+			}
+			p, err := startProcess("process_run", args[0], args[1], options(args, 2))
+			if err != nil {
+				return NilValue(), err
+			}
+			return p.wait(vm)
+		},
+	})
+}
+
+// createProcessModule creates the process built-in module
+func (vm *RegisterVM) createProcessModule() *ModuleObj {
+	exports := make(map[string]Value)
+
+	exports["run"] = vm.getGlobalByName("process_run")
+	exports["start"] = vm.getGlobalByName("process_spawn") // spawn is a keyword
+	exports["write"] = vm.getGlobalByName("process_write")
+	exports["close_stdin"] = vm.getGlobalByName("process_close_stdin")
+	exports["wait"] = vm.getGlobalByName("process_wait")
+	exports["kill"] = vm.getGlobalByName("process_kill")
+
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    "process",
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+
+	keepAlive(module)
+	return module
+}
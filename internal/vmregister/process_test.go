@@ -0,0 +1,75 @@
+package vmregister_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestProcesses(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	globals := run(t, `
+let failed = process_run("sh", ["-c", "echo out; echo err >&2; exit 3"])
+let lines = []
+let streamed = process_run("sh", ["-c", "echo a; echo b; echo c >&2"], {
+    "on_stdout": fn(line) { push(lines, "out:" + line) },
+    "on_stderr": fn(line) { push(lines, "err:" + line) },
+})
+let configured = process_run("sh", ["-c", "echo $SCAN_TARGET; pwd; cat"], {
+    "env": {"SCAN_TARGET": "10.0.0.1"}, "cwd": "/", "stdin": "input",
+})
+let slow = process_run("sleep", ["5"], {"timeout": 50})
+
+import process
+let h = process_spawn("cat", [])
+process_write(h, "first\n")
+process.write(h, bytes("second\n"))
+let echoed = process.wait(h)["stdout"]
+let k = process.start("sleep", ["5"])
+process_kill(k, "SIGTERM")
+let killed = process_wait(k)
+let stopped = ""
+try {
+    process_run("sh", ["-c", "echo 1; echo 2; echo 3"], {"on_stdout": fn(line) {
+        if line == "2" { throw "stop" }
+    }})
+} catch e {
+    stopped = e
+}
+`)
+
+	tests := map[string]string{
+		`failed["exit_code"]`:  "3",
+		`failed["success"]`:    "false",
+		`failed["stdout"]`:     "out\n",
+		`failed["stderr"]`:     "err\n",
+		`streamed["stdout"]`:   "",
+		`configured["stdout"]`: "10.0.0.1\n/\ninput",
+		`slow["timed_out"]`:    "true",
+		`slow["signal"]`:       "SIGKILL",
+		`killed["signal"]`:     "SIGTERM",
+		`killed["exit_code"]`:  "-1",
+	}
+	for expr, want := range tests {
+		name, key, _ := strings.Cut(strings.TrimSuffix(expr, `"]`), `["`)
+		if got := vmregister.ToString(vmregister.AsMap(globals[name]).Items[key]); got != want {
+			t.Errorf("%s: got %q, want %q", expr, got, want)
+		}
+	}
+	if got := vmregister.ToString(globals["echoed"]); got != "first\nsecond\n" {
+		t.Errorf("echoed: got %q", got)
+	}
+	// Callbacks on stdout and stderr may interleave, but each stream keeps its order
+	got := vmregister.ToString(globals["lines"])
+	a, b := strings.Index(got, "out:a"), strings.Index(got, "out:b")
+	if a < 0 || b < a || !strings.Contains(got, "err:c") || len(vmregister.AsArray(globals["lines"]).Elements) != 3 {
+		t.Errorf("lines: got %s", got)
+	}
+	if got := vmregister.ToString(globals["stopped"]); !strings.Contains(got, "stop") {
+		t.Errorf("stopped: got %q", got)
+	}
+}
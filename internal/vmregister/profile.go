@@ -0,0 +1,137 @@
+package vmregister
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sentra/internal/profiler"
+)
+
+// profileState samples the call stack while a profile is running
+type profileState struct {
+	period  time.Duration
+	start   time.Time
+	ticks   int64 // Periods elapsed since the last sample
+	samples map[string]*profiler.Sample
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// StartProfile samples the call stack every period until StopProfile. A
+// sample is taken at the next call, loop iteration or return from a builtin
+// after the period ends, and counts every period elapsed since the last
+// one, so a builtin such as sleep is charged for the time it blocked. Code
+// compiled with coverage probes (see SetCoverage) adds line numbers.
+func (vm *RegisterVM) StartProfile(period time.Duration) {
+	p := &profileState{
+		period:  period,
+		start:   time.Now(),
+		samples: make(map[string]*profiler.Sample),
+		stop:    make(chan struct{}),
+	}
+	vm.profile = p
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				atomic.AddInt64(&p.ticks, 1)
+				atomic.CompareAndSwapInt32(&vm.interrupted, 0, profileDue)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopProfile stops sampling and returns the profile, or nil if none was
+// started
+func (vm *RegisterVM) StopProfile() *profiler.Profile {
+	p := vm.profile
+	if p == nil {
+		return nil
+	}
+	close(p.stop)
+	p.done.Wait()
+	vm.profile = nil
+	atomic.CompareAndSwapInt32(&vm.interrupted, profileDue, 0)
+
+	profile := &profiler.Profile{
+		Period:   p.period,
+		Start:    p.start,
+		Duration: time.Since(p.start),
+	}
+	for _, s := range p.samples {
+		profile.Samples = append(profile.Samples, *s)
+	}
+	return profile
+}
+
+// sampleNative is called when a builtin returns during a profile. If a
+// period ended while it ran, the builtin is sampled as the innermost frame.
+func (vm *RegisterVM) sampleNative(name string) {
+	if atomic.LoadInt64(&vm.profile.ticks) == 0 {
+		return
+	}
+	atomic.CompareAndSwapInt32(&vm.interrupted, profileDue, 0)
+	vm.sampleStack(name)
+}
+
+// sampleStack records the current call stack, innermost frame first,
+// below the builtin named native if it is not empty
+func (vm *RegisterVM) sampleStack(native string) {
+	p := vm.profile
+	if p == nil {
+		return
+	}
+	count := atomic.SwapInt64(&p.ticks, 0)
+	if count == 0 {
+		return
+	}
+
+	stack := make([]profiler.Frame, 0, vm.frameTop+1)
+	var key strings.Builder
+	if native != "" {
+		stack = append(stack, profiler.Frame{Function: native})
+		key.WriteString(native)
+		key.WriteByte(0)
+	}
+	for i := vm.frameTop - 1; i >= 0; i-- {
+		frame := profiler.Frame{Function: "(main)"}
+		if fn := vm.frames[i].function; fn != nil && fn.Name != "" {
+			// pprof shows names like <main> and <lambda> as <unknown>
+			frame.Function = fn.Name
+			if strings.HasPrefix(fn.Name, "<") && strings.HasSuffix(fn.Name, ">") {
+				frame.Function = "(" + fn.Name[1:len(fn.Name)-1] + ")"
+			}
+		}
+		if i < len(vm.debugProbes) && vm.debugProbes[i] >= 0 && vm.coverage != nil {
+			probe := vm.coverage.Probes[vm.debugProbes[i]]
+			frame.File, frame.Line = probe.File, probe.Line
+		}
+		stack = append(stack, frame)
+		key.WriteString(frame.String())
+		key.WriteByte(0)
+	}
+
+	if s, ok := p.samples[key.String()]; ok {
+		s.Count += count
+		return
+	}
+	p.samples[key.String()] = &profiler.Sample{Stack: stack, Count: count}
+}
+
+// trackStatement remembers the last statement probe reached by the current
+// frame, for debuggers and profiles
+func (vm *RegisterVM) trackStatement(id uint16) {
+	top := vm.frameTop - 1
+	for len(vm.debugProbes) <= top {
+		vm.debugProbes = append(vm.debugProbes, -1)
+	}
+	vm.debugProbes[top] = int32(id)
+}
@@ -0,0 +1,104 @@
+package vmregister
+
+import (
+	"sentra/internal/replay"
+)
+
+// SetReplay routes the builtins that depend on the outside world through
+// s, which records their results or plays back a recording (see
+// replay.Builtins). Call it once, before running the script.
+func (vm *RegisterVM) SetReplay(s replay.Session) {
+	for _, name := range replay.Builtins() {
+		value := vm.getGlobalByName(name)
+		if !IsNativeFn(value) {
+			continue
+		}
+		fn := AsNativeFn(value)
+		// Wrapping in place also covers module exports such as time.now
+		call := fn.Function
+		fn.Function = func(args []Value) (Value, error) {
+			recordArgs := make([]any, len(args))
+			for i, arg := range args {
+				recordArgs[i] = toReplay(arg)
+			}
+			ran := false
+			var live Value
+			result, err := s.Call(name, recordArgs, func() (any, error) {
+				var err error
+				ran = true
+				live, err = call(args)
+				return toReplay(live), err
+			})
+			if ran {
+				// Recording: keep the very value the builtin returned
+				return live, err
+			}
+			if err != nil {
+				return NilValue(), err
+			}
+			return fromReplay(result), nil
+		}
+	}
+}
+
+// toReplay converts a value for a replay.Session
+func toReplay(v Value) any {
+	switch {
+	case IsNil(v):
+		return nil
+	case IsBool(v):
+		return AsBool(v)
+	case IsInt(v):
+		return ToInt(v)
+	case IsNumber(v):
+		return ToNumber(v)
+	case IsString(v):
+		return ToString(v)
+	case IsBytes(v):
+		return append([]byte(nil), AsBytes(v).Data...)
+	case IsArray(v):
+		elements := AsArray(v).Elements
+		out := make([]any, len(elements))
+		for i, elem := range elements {
+			out[i] = toReplay(elem)
+		}
+		return out
+	case IsMap(v):
+		items := AsMap(v).Items
+		out := make(map[string]any, len(items))
+		for key, item := range items {
+			out[key] = toReplay(item)
+		}
+		return out
+	}
+	return replay.Opaque(ValueType(v))
+}
+
+// fromReplay converts a value played back by a replay.Session
+func fromReplay(v any) Value {
+	switch v := v.(type) {
+	case bool:
+		return BoxBool(v)
+	case int64:
+		return BoxInt(v)
+	case float64:
+		return BoxNumber(v)
+	case string:
+		return BoxString(v)
+	case []byte:
+		return BoxBytes(v)
+	case []any:
+		elements := make([]Value, len(v))
+		for i, elem := range v {
+			elements[i] = fromReplay(elem)
+		}
+		return BoxArray(elements)
+	case map[string]any:
+		items := make(map[string]Value, len(v))
+		for key, item := range v {
+			items[key] = fromReplay(item)
+		}
+		return BoxMap(items)
+	}
+	return NilValue()
+}
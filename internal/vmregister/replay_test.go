@@ -0,0 +1,77 @@
+package vmregister_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sentra/internal/replay"
+	"sentra/internal/vmregister"
+)
+
+func TestReplay(t *testing.T) {
+	source := `
+let r = random()
+let t = time_ms()
+let out = process_run("echo", ["hi"])
+let stdout = out["stdout"]
+let code = out["exit_code"]
+let home = env("HOME")
+sleep(200)
+`
+	replaying := func(s replay.Session, source string) (map[string]vmregister.Value, error) {
+		t.Helper()
+		vm := vmregister.NewRegisterVM()
+		vm.SetReplay(s)
+		return execute(t, vm, source)
+	}
+
+	path := filepath.Join(t.TempDir(), "run.json")
+	recorder := replay.NewRecorder("replay.sn", []byte(source), nil)
+	recorded, err := replaying(recorder, source)
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := replay.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !player.Matches([]byte(source)) {
+		t.Error("the recorded script does not match its source")
+	}
+	start := time.Now()
+	replayed, err := replaying(player, source)
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("replay slept for %v", elapsed)
+	}
+	for _, name := range []string{"r", "t", "stdout", "code", "home"} {
+		want, got := recorded[name], replayed[name]
+		if vmregister.ValueType(got) != vmregister.ValueType(want) || vmregister.ToString(got) != vmregister.ToString(want) {
+			t.Errorf("%s: replayed %s %s, recorded %s %s", name, vmregister.ValueType(got), vmregister.ToString(got),
+				vmregister.ValueType(want), vmregister.ToString(want))
+		}
+	}
+	if n := player.Remaining(); n != 0 {
+		t.Errorf("%d calls not replayed", n)
+	}
+
+	// A script that no longer makes the recorded calls fails
+	player, _ = replay.Load(path)
+	_, err = replaying(player, `let r = random()
+let out = process_run("echo", ["bye"])`)
+	var diverged *replay.DivergenceError
+	if !errors.As(err, &diverged) || diverged.Index != 1 {
+		t.Fatalf("got %v, want a divergence at the second call", err)
+	}
+	if want := `replay diverged at call 2: recorded time_ms(), script called process_run("echo", ["bye"])`; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
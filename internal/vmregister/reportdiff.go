@@ -0,0 +1,195 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sentra/internal/reporting"
+)
+
+// reportFinding reads a finding map. The fields that tell findings apart
+// are kept, so tickets can be filed for them and scans compared; other
+// fields are custom ones.
+func reportFinding(findingMap map[string]Value) reporting.SecurityFinding {
+	finding := reporting.SecurityFinding{
+		ID:     fmt.Sprintf("finding-%d", time.Now().Unix()),
+		Status: "OPEN",
+		Custom: make(map[string]interface{}),
+	}
+	for key, v := range findingMap {
+		switch key {
+		case "title":
+			finding.Title = ToString(v)
+		case "description":
+			finding.Description = ToString(v)
+		case "severity":
+			finding.Severity = ToString(v)
+		case "fingerprint":
+			finding.Fingerprint = ToString(v)
+		case "status":
+			finding.Status = strings.ToUpper(ToString(v))
+		case "category":
+			finding.Category = ToString(v)
+		case "cwe":
+			finding.CWE = ToString(v)
+		case "cve":
+			finding.CVE = ToString(v)
+		case "cvss_vector":
+			finding.CVSS.Vector = ToString(v)
+		case "host", "target":
+			finding.Location.Target = ToString(v)
+		case "solution":
+			finding.Solution = ToString(v)
+		default:
+			finding.Custom[key] = valueToGo(v)
+		}
+	}
+	return finding
+}
+
+// reportSuppressions reads the suppressions option of report_diff, the
+// path of a suppression file or an array of suppression maps
+func reportSuppressions(v Value) ([]reporting.Suppression, error) {
+	if !IsArray(v) {
+		return reporting.LoadSuppressions(ToString(v))
+	}
+	var suppressions []reporting.Suppression
+	for _, item := range AsArray(v).Elements {
+		if !IsMap(item) {
+			return nil, fmt.Errorf("suppressions must be maps, got %s", ValueType(item))
+		}
+		var s reporting.Suppression
+		for key, field := range AsMap(item).Items {
+			value := ToString(field)
+			switch key {
+			case "fingerprint":
+				s.Fingerprint = value
+			case "title":
+				s.Title = value
+			case "target":
+				s.Target = value
+			case "cwe":
+				s.CWE = value
+			case "cve":
+				s.CVE = value
+			case "justification":
+				s.Justification = value
+			case "owner":
+				s.Owner = value
+			case "expires":
+				s.Expires = value
+			default:
+				return nil, fmt.Errorf("unknown suppression field '%s'", key)
+			}
+		}
+		suppressions = append(suppressions, s)
+	}
+	return reporting.NewSuppressions(suppressions)
+}
+
+// registerReportDiffFunctions registers the functions that compare the
+// findings of a scan with a baseline, so that a scan run in CI reports
+// only what is new or got worse. Findings are told apart by fingerprints
+// and known ones can be suppressed, for a reason, until a date.
+func (vm *RegisterVM) registerReportDiffFunctions() {
+	// report_fingerprint(finding) returns the fingerprint a finding map
+	// gets when it is added to a report
+	vm.registerGlobal("report_fingerprint", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "report_fingerprint",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[0]) {
+				return NilValue(), fmt.Errorf("report_fingerprint: the finding must be a map, got %s", ValueType(args[0]))
+			}
+			finding := reportFinding(AsMap(args[0]).Items)
+			if finding.Fingerprint == "" {
+				finding.Fingerprint = reporting.Fingerprint(finding)
+			}
+			return BoxString(finding.Fingerprint), nil
+		},
+	})
+
+	// report_findings(report_id) returns the findings of a report, the
+	// same finding added again being merged into one
+	vm.registerGlobal("report_findings", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "report_findings",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			findings, err := vm.reportingModule.(*reporting.ReportingModule).ReportFindings(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("report_findings: %v", err)
+			}
+			list := make([]interface{}, 0, len(findings))
+			for _, f := range findings {
+				m := reporting.FindingToMap(f)
+				m["fingerprint"] = f.Fingerprint
+				list = append(list, m)
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// report_diff(baseline, report, options?) returns the findings of a
+	// report that are new or regressed since a baseline, both report ids
+	// or JSON exports
+	vm.registerGlobal("report_diff", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "report_diff",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("report_diff expects 2-3 arguments (baseline, report, options), got %d", len(args))
+			}
+			var suppressions []reporting.Suppression
+			at := time.Now()
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("report_diff: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					var err error
+					switch key {
+					case "suppressions":
+						suppressions, err = reportSuppressions(v)
+					case "at":
+						if at, err = time.Parse(time.RFC3339, ToString(v)); err != nil {
+							err = fmt.Errorf("at must be an RFC 3339 time, got %s", ToString(v))
+						}
+					default:
+						err = fmt.Errorf("unknown option '%s'", key)
+					}
+					if err != nil {
+						return NilValue(), fmt.Errorf("report_diff: %v", err)
+					}
+				}
+			}
+			diff, err := vm.reportingModule.(*reporting.ReportingModule).DiffReports(ToString(args[0]), ToString(args[1]), suppressions, at)
+			if err != nil {
+				return NilValue(), fmt.Errorf("report_diff: %v", err)
+			}
+			return goToValue(reporting.DiffToMap(diff)), nil
+		},
+	})
+
+	// report_load_suppressions(path) checks a suppression file and returns
+	// its suppressions
+	vm.registerGlobal("report_load_suppressions", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "report_load_suppressions",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			suppressions, err := reporting.LoadSuppressions(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("report_load_suppressions: %v", err)
+			}
+			list := make([]interface{}, 0, len(suppressions))
+			for _, s := range suppressions {
+				list = append(list, reporting.SuppressionToMap(s))
+			}
+			return goToValue(list), nil
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package vmregister_test
+
+import (
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestReportDiff(t *testing.T) {
+	dir := t.TempDir()
+	globals := run(t, `
+report_create("base", "Baseline", "", "corp")
+report_add_finding("base", {"title": "SSH open", "severity": "low", "host": "web1", "port": 22})
+report_add_finding("base", {"title": "Telnet open", "severity": "high", "host": "web3"})
+report_export("base", "json", "`+dir+`/baseline.json")
+
+report_create("ci", "CI scan", "", "corp")
+report_add_finding("ci", {"title": "SSH open", "severity": "high", "host": "web1", "port": 22})
+report_add_finding("ci", {"title": "SSH open", "severity": "high", "host": "web1", "port": 22})
+report_add_finding("ci", {"title": "Debug page", "severity": "medium", "host": "app", "path": "/debug"})
+report_add_finding("ci", {"title": "Weak cipher", "severity": "low", "host": "mail"})
+let findings = report_findings("ci")
+let diff = report_diff("`+dir+`/baseline.json", "ci", {"suppressions": [{"title": "weak *", "justification": "Legacy clients", "expires": "2099-01-01"}]})
+let summary = len(findings) + " " + len(diff["new"]) + " " + diff["new"][0]["title"] + " " + diff["regressed"][0]["reason"] + " " + diff["fixed"][0]["title"] + " " + diff["suppressed"][0]["justification"]
+let same = report_fingerprint({"title": "SSH open", "host": "web1", "port": 22, "severity": "info"}) == findings[0]["fingerprint"]
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "3 1 Debug page severity rose from LOW to HIGH Telnet open Legacy clients"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if !vmregister.IsTruthy(globals["same"]) {
+		t.Errorf("report_fingerprint differs from the fingerprint of the reported finding")
+	}
+
+	expectErrors(t, map[string]string{
+		`report_diff("none", "none")`:                                              "report_diff: report not found: none",
+		`report_diff("a", "b", {"suppressions": [{"title": "x"}]})`:                "report_diff: the suppression of x has no justification",
+		`report_diff("a", "b", {"suppressions": [{"title": "x", "reason": "y"}]})`: "report_diff: unknown suppression field 'reason'",
+		`report_diff("a", "b", {"baseline": "x"})`:                                 "report_diff: unknown option 'baseline'",
+		`report_diff("a", "b", {"at": "tomorrow"})`:                                "report_diff: at must be an RFC 3339 time, got tomorrow",
+		`report_load_suppressions("` + dir + `/none.json")`:                        "report_load_suppressions: open",
+		`report_fingerprint("x")`:                                                  "report_fingerprint: the finding must be a map, got string",
+	})
+}
@@ -0,0 +1,56 @@
+package vmregister_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestReportTemplates(t *testing.T) {
+	dir := t.TempDir()
+	globals := run(t, `
+report_create("r2", "Weekly scan", "", "corp")
+report_add_finding("r2", {"title": "SSH open", "severity": "high", "host": "web1"})
+report_add_finding("r2", {"title": "Telnet open", "severity": "critical", "host": "web2"})
+let pdf = report_export("r2", "pdf", "`+dir+`/weekly.pdf", {"template": "executive"})
+let html = report_export("r2", "html", "`+dir+`/weekly.html")
+report_template_add("brief", "{{range .Sorted}}{{lower .Severity}} {{.Title}}; {{end}}", {"format": "markdown", "name": "Brief"})
+let brief = report_render("r2", "brief")
+let md = report_export("r2", "md", "`+dir+`/weekly.md", {"template": "brief"})
+let custom = 0
+for tmpl in report_templates() {
+    if tmpl["custom"] {
+        custom = custom + 1
+    }
+}
+`)
+	if got := vmregister.ToString(globals["brief"]); got != "critical Telnet open; high SSH open; " {
+		t.Errorf("brief = %q", got)
+	}
+	if got := vmregister.ToString(globals["pdf"]); got != dir+"/weekly.pdf" {
+		t.Errorf("pdf = %q", got)
+	}
+	if data, err := os.ReadFile(dir + "/weekly.pdf"); err != nil || !strings.HasPrefix(string(data), "%PDF-") {
+		t.Errorf("weekly.pdf is not a PDF: %v", err)
+	}
+	if data, err := os.ReadFile(dir + "/weekly.html"); err != nil || !strings.Contains(string(data), "<svg class=\"chart\"") {
+		t.Errorf("weekly.html has no chart: %v", err)
+	}
+	if data, err := os.ReadFile(dir + "/weekly.md"); err != nil || string(data) != "critical Telnet open; high SSH open; " {
+		t.Errorf("weekly.md = %q, %v", data, err)
+	}
+	if vmregister.ToNumber(globals["custom"]) != 1 {
+		t.Errorf("custom templates = %v", globals["custom"])
+	}
+
+	expectErrors(t, map[string]string{
+		`report_template_add("technical", "x")`:                        "report_template_add: technical is a built-in template",
+		`report_template_add("x", "{{.Title", {"format": "markdown"})`: "report_template_add: template: x:1: unclosed action",
+		`report_template_add("x", "x", {"engine": "go"})`:              "report_template_add: unknown option 'engine'",
+		`report_render("none", "technical")`:                           "report_render: report not found: none",
+		`report_export("none", "html", "x.html", {"style": "dark"})`:   "report_export: unknown option 'style'",
+		`report_export("none", "html", "x.html", "technical")`:         "report_export: options must be a map, got string",
+	})
+}
@@ -0,0 +1,173 @@
+package vmregister
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"sentra/internal/concurrency"
+)
+
+// registerSchedulerFunctions registers the schedule_* builtins. Jobs are
+// kept by the concurrency module's scheduler and run on the VM's goroutine
+// by schedule_start.
+func (vm *RegisterVM) registerSchedulerFunctions() {
+	sched := vm.concurrencyModule.(*concurrency.ConcurrencyModule).Scheduler
+	running, stopping := false, false
+
+	callable := func(name string, fn Value) error {
+		if !IsPointer(fn) || !isCallableType(AsObject(fn).Type) {
+			return fmt.Errorf("%s: job must be a function, got %s", name, ValueType(fn))
+		}
+		return nil
+	}
+
+	vm.registerGlobal("schedule_every", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_every",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if err := callable("schedule_every", args[1]); err != nil {
+				return NilValue(), err
+			}
+			// A number is a count of milliseconds
+			var interval time.Duration
+			spec := ToString(args[0])
+			if IsInt(args[0]) || IsNumber(args[0]) {
+				interval = time.Duration(ToNumber(args[0]) * float64(time.Millisecond))
+				if interval <= 0 {
+					return NilValue(), fmt.Errorf("schedule_every: interval must be positive, got %s", spec)
+				}
+				spec += "ms"
+			} else {
+				var err error
+				if interval, err = concurrency.ParseInterval(spec); err != nil {
+					return NilValue(), fmt.Errorf("schedule_every: %v", err)
+				}
+			}
+			return BoxString(sched.Every(interval, spec, args[1]).ID), nil
+		},
+	})
+
+	vm.registerGlobal("schedule_cron", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_cron",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if err := callable("schedule_cron", args[1]); err != nil {
+				return NilValue(), err
+			}
+			job, err := sched.Cron(ToString(args[0]), args[1])
+			if err != nil {
+				return NilValue(), fmt.Errorf("schedule_cron: %v", err)
+			}
+			return BoxString(job.ID), nil
+		},
+	})
+
+	vm.registerGlobal("schedule_cancel", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_cancel",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			return BoxBool(sched.Cancel(ToString(args[0]))), nil
+		},
+	})
+
+	vm.registerGlobal("schedule_jobs", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_jobs",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			var jobs []Value
+			for _, job := range sched.Jobs() {
+				lastRun := NilValue()
+				if !job.LastRun.IsZero() {
+					lastRun = BoxInt(job.LastRun.Unix())
+				}
+				jobs = append(jobs, BoxMap(map[string]Value{
+					"id":       BoxString(job.ID),
+					"spec":     BoxString(job.Spec),
+					"next_run": BoxInt(job.Next.Unix()),
+					"last_run": lastRun,
+					"runs":     BoxInt(int64(job.Runs)),
+				}))
+			}
+			return BoxArray(jobs), nil
+		},
+	})
+
+	vm.registerGlobal("schedule_start", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_start",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			if running {
+				return NilValue(), fmt.Errorf("schedule_start: the scheduler is already running")
+			}
+			running, stopping = true, false
+			defer func() { running = false }()
+
+			// Wait for the next job, run everything due and repeat until
+			// schedule_stop is called or no jobs are left. Signals cut the
+			// wait short so their handlers run on time.
+			for !stopping {
+				next, ok := sched.NextRun()
+				if !ok {
+					break
+				}
+				if wait := time.Until(next); wait > 0 {
+					vm.sleep(wait)
+				}
+				if atomic.LoadInt32(&vm.interrupted) != 0 {
+					if err := vm.checkInterrupt(); err != nil {
+						return NilValue(), err
+					}
+					continue
+				}
+				for _, job := range sched.Due(time.Now()) {
+					if stopping {
+						break
+					}
+					if _, err := vm.callValue(job.Payload.(Value), nil); err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			return NilValue(), nil
+		},
+	})
+
+	vm.registerGlobal("schedule_stop", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "schedule_stop",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			stopping = true
+			return NilValue(), nil
+		},
+	})
+}
+
+// createSchedulerModule creates the scheduler built-in module
+func (vm *RegisterVM) createSchedulerModule() *ModuleObj {
+	exports := make(map[string]Value)
+
+	exports["every"] = vm.getGlobalByName("schedule_every")
+	exports["cron"] = vm.getGlobalByName("schedule_cron")
+	exports["cancel"] = vm.getGlobalByName("schedule_cancel")
+	exports["jobs"] = vm.getGlobalByName("schedule_jobs")
+	exports["start"] = vm.getGlobalByName("schedule_start")
+	exports["stop"] = vm.getGlobalByName("schedule_stop")
+
+	module := &ModuleObj{
+		Object:  Object{Type: OBJ_MODULE},
+		Name:    "scheduler",
+		Path:    "<builtin>",
+		Exports: exports,
+		Loaded:  true,
+	}
+
+	keepAlive(module)
+	return module
+}
@@ -0,0 +1,88 @@
+package vmregister_test
+
+import (
+	"testing"
+	"time"
+
+	"sentra/internal/concurrency"
+	"sentra/internal/vmregister"
+)
+
+func TestScheduler(t *testing.T) {
+	globals := run(t, `
+import scheduler
+let ticks = 0
+let order = []
+schedule_every("10ms", fn() {
+    ticks = ticks + 1
+    push(order, "tick")
+    if ticks == 3 { scheduler.stop() }
+})
+let dropped = scheduler.every(5, fn() { push(order, "dropped") })
+let nightly = schedule_cron("0 2 * * *", fn() { push(order, "nightly") })
+let cancelled = schedule_cancel(dropped)
+let cancelled_twice = schedule_cancel(dropped)
+let before = len(scheduler.jobs())
+schedule_start()
+let after = schedule_jobs()
+`)
+
+	tests := map[string]string{
+		"ticks":           "3",
+		"order":           "[tick, tick, tick]",
+		"cancelled":       "true",
+		"cancelled_twice": "false",
+		"before":          "2",
+	}
+	for name, want := range tests {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+	// The interval job comes first and has run; the nightly job has not
+	after := vmregister.AsArray(globals["after"]).Elements
+	if len(after) != 2 {
+		t.Fatalf("after: got %s", vmregister.ToString(globals["after"]))
+	}
+	if got := vmregister.ToString(vmregister.AsMap(after[0]).Items["runs"]); got != "3" {
+		t.Errorf("interval job runs: got %s", got)
+	}
+	nightly := vmregister.AsMap(after[1]).Items
+	if got := vmregister.ToString(nightly["spec"]); got != "0 2 * * *" {
+		t.Errorf("nightly spec: got %s", got)
+	}
+	if next := time.Unix(int64(vmregister.ToNumber(nightly["next_run"])), 0); next.Hour() != 2 || next.Minute() != 0 {
+		t.Errorf("nightly next_run: got %v", next)
+	}
+
+	from := time.Date(2026, time.January, 30, 12, 0, 0, 0, time.UTC) // A Friday
+	crons := map[string]string{
+		"*/15 * * * *":     "2026-01-30 12:15",
+		"0 2 * * *":        "2026-01-31 02:00",
+		"30 9 * * MON-FRI": "2026-02-02 09:30",
+		"0 0 1 * *":        "2026-02-01 00:00",
+		"0 0 * * 7":        "2026-02-01 00:00",
+		"0 0 13 * FRI":     "2026-02-06 00:00",
+		"@yearly":          "2027-01-01 00:00",
+		"0 0 30 2 *":       "",
+	}
+	for expr, want := range crons {
+		schedule, err := concurrency.ParseCron(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		got := ""
+		if next := schedule.Next(from); !next.IsZero() {
+			got = next.Format("2006-01-02 15:04")
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", expr, got, want)
+		}
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * FOO *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := concurrency.ParseCron(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
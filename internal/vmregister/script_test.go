@@ -0,0 +1,50 @@
+package vmregister_test
+
+// The builtin tests run Sentra scripts end to end. They live in an external
+// test package so they can compile with compregister, which imports this one.
+
+import (
+	"strings"
+	"testing"
+
+	"sentra/internal/compregister"
+	"sentra/internal/lexer"
+	"sentra/internal/parser"
+	"sentra/internal/vmregister"
+)
+
+// execute compiles source against the globals of vm and runs it there,
+// returning the globals and the error the script stopped with
+func execute(t *testing.T, vm *vmregister.RegisterVM, source string) (map[string]vmregister.Value, error) {
+	t.Helper()
+	stmts := parser.NewParserWithSource(lexer.NewScanner(source).ScanTokens(), source, "test.sn").Parse()
+	globalNames, nextID := vm.GetGlobalNames()
+	fn, err := compregister.NewCompilerWithGlobals(globalNames, nextID).Compile(stmts)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	_, err = vm.Execute(fn, nil)
+	return vm.GetGlobals(), err
+}
+
+// run runs source on a new VM and returns its globals, failing the test
+// if the script fails
+func run(t *testing.T, source string) map[string]vmregister.Value {
+	t.Helper()
+	globals, err := execute(t, vmregister.NewRegisterVM(), source)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return globals
+}
+
+// expectErrors runs each script on a new VM and checks that it fails with
+// an error containing the text it maps to
+func expectErrors(t *testing.T, cases map[string]string) {
+	t.Helper()
+	for source, want := range cases {
+		if _, err := execute(t, vmregister.NewRegisterVM(), source); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", source, err, want)
+		}
+	}
+}
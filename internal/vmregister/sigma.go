@@ -0,0 +1,125 @@
+package vmregister
+
+import (
+	"fmt"
+	"sort"
+
+	"sentra/internal/siem"
+)
+
+// taxonomyMappings reads the fields or sources of a taxonomy option: a map
+// from a name to a name or an array of names
+func taxonomyMappings(key string, v Value) (map[string][]string, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("taxonomy %s must be a map, got %s", key, ValueType(v))
+	}
+	mappings := make(map[string][]string)
+	for name, target := range AsMap(v).Items {
+		if IsArray(target) {
+			for _, elem := range AsArray(target).Elements {
+				mappings[name] = append(mappings[name], ToString(elem))
+			}
+		} else {
+			mappings[name] = []string{ToString(target)}
+		}
+	}
+	return mappings, nil
+}
+
+// sigmaTaxonomy reads the taxonomy option of siem_load_sigma: the name of
+// a built-in taxonomy, or a map of fields and sources that extends one
+func sigmaTaxonomy(v Value) (*siem.Taxonomy, error) {
+	if !IsMap(v) {
+		return siem.GetTaxonomy(ToString(v))
+	}
+	base, name := "sentra", "custom"
+	var fields, sources map[string][]string
+	var err error
+	for key, item := range AsMap(v).Items {
+		switch key {
+		case "base":
+			base = ToString(item)
+		case "name":
+			name = ToString(item)
+		case "fields":
+			fields, err = taxonomyMappings(key, item)
+		case "sources":
+			sources, err = taxonomyMappings(key, item)
+		default:
+			return nil, fmt.Errorf("unknown taxonomy option '%s'", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	t, err := siem.GetTaxonomy(base)
+	if err != nil {
+		return nil, err
+	}
+	return t.Extend(name, fields, sources), nil
+}
+
+// registerSigmaFunctions registers the loading of Sigma rules into the
+// correlation engine of the siem functions
+func (vm *RegisterVM) registerSigmaFunctions() {
+	// siem_load_sigma(path, options?) loads a rule file or a directory of
+	// them. Rules then run in siem_correlate like those of siem_add_rule.
+	vm.registerGlobal("siem_load_sigma", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_load_sigma",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("siem_load_sigma expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			if vm.siemModule == nil {
+				return NilValue(), fmt.Errorf("SIEM module not initialized")
+			}
+			taxonomy, _ := siem.GetTaxonomy("sentra")
+			minLevel := ""
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("siem_load_sigma: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "taxonomy":
+						t, err := sigmaTaxonomy(v)
+						if err != nil {
+							return NilValue(), fmt.Errorf("siem_load_sigma: %v", err)
+						}
+						taxonomy = t
+					case "min_level":
+						minLevel = ToString(v)
+					default:
+						return NilValue(), fmt.Errorf("siem_load_sigma: unknown option '%s'", key)
+					}
+				}
+			}
+			siemMod := vm.siemModule.(*siem.SIEMModule)
+			rules, failed, err := siemMod.LoadSigma(ToString(args[0]), taxonomy, minLevel)
+			if err != nil {
+				return NilValue(), fmt.Errorf("siem_load_sigma: %v", err)
+			}
+
+			loaded := make([]interface{}, len(rules))
+			for i, rule := range rules {
+				loaded[i] = siem.SigmaRuleToMap(rule)
+			}
+			paths := make([]string, 0, len(failed))
+			for path := range failed {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			skipped := make([]interface{}, len(paths))
+			for i, path := range paths {
+				skipped[i] = map[string]interface{}{"path": path, "error": failed[path].Error()}
+			}
+			return goToValue(map[string]interface{}{
+				"rules":    loaded,
+				"taxonomy": taxonomy.Name,
+				"skipped":  skipped,
+			}), nil
+		},
+	})
+}
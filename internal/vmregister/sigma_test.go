@@ -0,0 +1,76 @@
+package vmregister_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestSigmaRules(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	if err := os.WriteFile(logPath, []byte(
+		`203.0.113.7 - - [05/Jan/2024:10:00:00 +0000] "GET /cgi-bin/../../etc/passwd HTTP/1.1" 200 512 "-" "curl/8.0"
+198.51.100.2 - - [05/Jan/2024:10:00:01 +0000] "GET /index.html HTTP/1.1" 200 1024 "-" "Mozilla/5.0"
+203.0.113.7 - - [05/Jan/2024:10:00:02 +0000] "GET /admin/../../etc/shadow HTTP/1.1" 403 0 "-" "curl/8.0"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rulePath := filepath.Join(dir, "traversal.yml")
+	if err := os.WriteFile(rulePath, []byte(`title: Path traversal
+id: web-traversal
+level: high
+tags:
+    - attack.t1083
+logsource:
+    category: webserver
+detection:
+    selection:
+        c-uri|contains: '/../'
+    filter:
+        c-useragent|startswith: 'Mozilla/'
+    condition: selection and not filter | count() by c-ip >= 2
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globals := run(t, `
+let loaded = siem_load_sigma("`+rulePath+`")
+let rule = loaded["rules"][0]
+let described = rule["id"] + " " + rule["level"] + " " + loaded["taxonomy"]
+let alerts = siem_correlate(siem_parse_log("`+logPath+`", "apache"))
+let found = ""
+for a in alerts {
+    if a["rule_id"] == "web-traversal" {
+        found = a["severity"] + " " + a["metadata"]["group.c-ip"] + " " + str(len(a["events"]))
+    }
+}
+let listed = false
+for r in siem_get_rules() {
+    if r["id"] == "web-traversal" && r["metadata"]["source"] == "sigma" { listed = true }
+}
+let none = siem_load_sigma("`+rulePath+`", {"taxonomy": "none", "min_level": "critical"})
+let kept = str(len(none["rules"])) + " " + none["taxonomy"]
+`)
+	for name, want := range map[string]string{
+		"described": "web-traversal high sentra",
+		"found":     "HIGH 203.0.113.7 2",
+		"listed":    "true",
+		"kept":      "0 none",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	expectErrors(t, map[string]string{
+		`siem_load_sigma()`:                                      "siem_load_sigma expects 1-2 arguments (path, options), got 0",
+		`siem_load_sigma("` + rulePath + `", 1)`:                 "siem_load_sigma: options must be a map, got int",
+		`siem_load_sigma("` + rulePath + `", {"level": "x"})`:    "siem_load_sigma: unknown option 'level'",
+		`siem_load_sigma("` + rulePath + `", {"taxonomy": "x"})`: "siem_load_sigma: unknown taxonomy 'x'",
+		`siem_load_sigma("` + logPath + `")`:                     "siem_load_sigma: " + logPath + ": ",
+		`siem_load_sigma("` + dir + `/missing.yml")`:             "siem_load_sigma: stat",
+	})
+}
@@ -0,0 +1,278 @@
+package vmregister
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// States of RegisterVM.interrupted
+const (
+	interruptRequested = 1 // Interrupt was called
+	signalPending      = 2 // A signal is queued for its handlers
+	profileDue         = 3 // The profiler wants a sample, see StartProfile
+	limitExceeded      = 4 // A time or memory limit ran out, see StartLimits
+)
+
+// ExitError is returned when the script calls exit or is ended by a signal
+// it has no handler for. Hosts should run the exit handlers and exit with
+// Code.
+type ExitError struct {
+	Code   int
+	Signal string // The signal that ended the script, if any
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("terminated by %s", e.Signal)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// signalState holds the handlers registered with on_signal and on_exit
+type signalState struct {
+	mu       sync.Mutex
+	handlers map[syscall.Signal][]Value
+	exit     []Value
+	notify   chan os.Signal // Nil until the first handler is registered
+	queue    []syscall.Signal
+	wake     chan struct{} // Cuts a sleep short, see RegisterVM.sleep
+	running  bool          // Handlers are running; signals wait until they finish
+	exited   bool          // Exit handlers have run
+}
+
+// terminatingSignals end the script unless it handles them, running the
+// exit handlers first
+var terminatingSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// parseSignal returns the signal named like SIGINT or INT
+func parseSignal(name string) (syscall.Signal, error) {
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	sig, ok := processSignals[upper]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %s", name)
+	}
+	return sig, nil
+}
+
+// clearInterrupt forgets a stale Interrupt, keeping signals still queued
+func (vm *RegisterVM) clearInterrupt() {
+	atomic.CompareAndSwapInt32(&vm.interrupted, interruptRequested, 0)
+}
+
+// flagSignal tells the VM a signal is queued. It takes the place of a
+// pending profile sample, which is taken later with the time it missed.
+func (vm *RegisterVM) flagSignal() {
+	if !atomic.CompareAndSwapInt32(&vm.interrupted, 0, signalPending) {
+		atomic.CompareAndSwapInt32(&vm.interrupted, profileDue, signalPending)
+	}
+}
+
+// checkInterrupt is called at calls and loop back edges once
+// vm.interrupted is set. It stops the script after Interrupt, runs the
+// handlers of queued signals or takes a profile sample.
+func (vm *RegisterVM) checkInterrupt() error {
+	switch atomic.LoadInt32(&vm.interrupted) {
+	case interruptRequested:
+		return ErrInterrupted
+	case profileDue:
+		if atomic.CompareAndSwapInt32(&vm.interrupted, profileDue, 0) {
+			vm.sampleStack("")
+		}
+		return nil
+	case limitExceeded:
+		// The next instruction raises the error, see beforeInstruction
+		atomic.CompareAndSwapInt32(&vm.interrupted, limitExceeded, 0)
+		return nil
+	}
+	s := &vm.signals
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	atomic.CompareAndSwapInt32(&vm.interrupted, signalPending, 0)
+	queue := s.queue
+	s.queue = nil
+	s.running = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		if len(s.queue) > 0 {
+			vm.flagSignal()
+		}
+		s.mu.Unlock()
+	}()
+
+	for _, sig := range queue {
+		s.mu.Lock()
+		handlers := append([]Value(nil), s.handlers[sig]...)
+		s.mu.Unlock()
+		if len(handlers) == 0 {
+			return &ExitError{Code: 128 + int(sig), Signal: signalName(sig)}
+		}
+		for _, handler := range handlers {
+			if _, err := vm.callValue(handler, []Value{BoxString(signalName(sig))}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watchSignals starts delivering signals to the VM. The terminating
+// signals are always caught so the exit handlers can run.
+func (vm *RegisterVM) watchSignals(sig syscall.Signal) {
+	s := &vm.signals
+	if s.notify == nil {
+		s.notify = make(chan os.Signal, 8)
+		signal.Notify(s.notify, terminatingSignals...)
+		go func() {
+			for received := range s.notify {
+				s.mu.Lock()
+				s.queue = append(s.queue, received.(syscall.Signal))
+				s.mu.Unlock()
+				vm.flagSignal()
+				vm.wake()
+			}
+		}()
+	}
+	signal.Notify(s.notify, sig)
+}
+
+// wakeChan returns the channel that cuts a sleep short
+func (vm *RegisterVM) wakeChan() chan struct{} {
+	vm.signals.mu.Lock()
+	defer vm.signals.mu.Unlock()
+	if vm.signals.wake == nil {
+		vm.signals.wake = make(chan struct{}, 1)
+	}
+	return vm.signals.wake
+}
+
+// wake ends the current sleep, if any. vm.interrupted must be set first.
+func (vm *RegisterVM) wake() {
+	select {
+	case vm.wakeChan() <- struct{}{}:
+	default:
+	}
+}
+
+// sleep pauses for d, returning early after Interrupt or when a signal
+// arrives so that the script stops or its handlers run without waiting
+func (vm *RegisterVM) sleep(d time.Duration) {
+	wake := vm.wakeChan()
+	// Forget a wake up for a signal that has been handled already
+	select {
+	case <-wake:
+	default:
+	}
+	if state := atomic.LoadInt32(&vm.interrupted); state == interruptRequested || state == signalPending || state == limitExceeded {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-wake:
+	}
+}
+
+// RunExitHandlers calls the functions registered with on_exit, most recent
+// first, and stops delivering signals to the script. It is safe to call more
+// than once; handlers only run the first time. Every handler runs even if
+// one fails, and the first error is returned.
+func (vm *RegisterVM) RunExitHandlers() error {
+	s := &vm.signals
+	s.mu.Lock()
+	if s.exited {
+		s.mu.Unlock()
+		return nil
+	}
+	s.exited = true
+	handlers := s.exit
+	s.mu.Unlock()
+
+	var first error
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if _, err := vm.Call(handlers[i], nil); err != nil && first == nil {
+			first = err
+		}
+	}
+	if s.notify != nil {
+		signal.Stop(s.notify)
+	}
+	return first
+}
+
+// registerSignalFunctions registers on_signal, on_exit and exit
+func (vm *RegisterVM) registerSignalFunctions() {
+	vm.registerGlobal("on_signal", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "on_signal",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			sig, err := parseSignal(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("on_signal: %v", err)
+			}
+			if sig == syscall.SIGKILL {
+				return NilValue(), fmt.Errorf("on_signal: SIGKILL cannot be handled")
+			}
+			if !IsPointer(args[1]) || !isCallableType(AsObject(args[1]).Type) {
+				return NilValue(), fmt.Errorf("on_signal: handler must be a function, got %s", ValueType(args[1]))
+			}
+			vm.signals.mu.Lock()
+			if vm.signals.handlers == nil {
+				vm.signals.handlers = make(map[syscall.Signal][]Value)
+			}
+			vm.signals.handlers[sig] = append(vm.signals.handlers[sig], args[1])
+			vm.watchSignals(sig)
+			vm.signals.mu.Unlock()
+			return NilValue(), nil
+		},
+	})
+
+	vm.registerGlobal("on_exit", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "on_exit",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsPointer(args[0]) || !isCallableType(AsObject(args[0]).Type) {
+				return NilValue(), fmt.Errorf("on_exit: handler must be a function, got %s", ValueType(args[0]))
+			}
+			vm.signals.mu.Lock()
+			vm.signals.exit = append(vm.signals.exit, args[0])
+			vm.watchSignals(syscall.SIGINT)
+			vm.signals.mu.Unlock()
+			return NilValue(), nil
+		},
+	})
+
+	vm.registerGlobal("exit", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "exit",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("exit expects 0-1 arguments (code), got %d", len(args))
+			}
+			code := 0
+			if len(args) == 1 && !IsNil(args[0]) {
+				code = int(ToInt(args[0]))
+			}
+			return NilValue(), &ExitError{Code: code}
+		},
+	})
+}
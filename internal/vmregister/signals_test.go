@@ -0,0 +1,66 @@
+package vmregister_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"sentra/internal/vmregister"
+)
+
+func TestSignalAndExitHandlers(t *testing.T) {
+	source := `
+let events = []
+let hups = 0
+on_exit(fn() { push(events, "first registered") })
+on_exit(fn() { push(events, "last registered") })
+on_signal("HUP", fn(name) {
+    push(events, name)
+    hups = hups + 1
+})
+fn wait_for_hup() {
+    while hups == 0 { sleep(60000) }
+}
+fn wait_forever() {
+    while true { sleep(60000) }
+}
+fn quit() { exit(5) }
+`
+	vm := vmregister.NewRegisterVM()
+	globals, err := execute(t, vm, source)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handled signal runs its handler, cutting the sleep short
+	time.AfterFunc(10*time.Millisecond, func() { self.Signal(syscall.SIGHUP) })
+	if _, err := vm.Call(globals["wait_for_hup"], nil); err != nil {
+		t.Fatalf("wait_for_hup: %v", err)
+	}
+
+	// An unhandled SIGINT ends the script
+	time.AfterFunc(10*time.Millisecond, func() { self.Signal(syscall.SIGINT) })
+	_, err = vm.Call(globals["wait_forever"], nil)
+	if exitErr, ok := err.(*vmregister.ExitError); !ok || exitErr.Code != 130 || exitErr.Signal != "SIGINT" {
+		t.Errorf("wait_forever: got %v, want termination by SIGINT", err)
+	}
+
+	_, err = vm.Call(globals["quit"], nil)
+	if exitErr, ok := err.(*vmregister.ExitError); !ok || exitErr.Code != 5 {
+		t.Errorf("quit: got %v, want exit status 5", err)
+	}
+
+	if err := vm.RunExitHandlers(); err != nil {
+		t.Fatal(err)
+	}
+	vm.RunExitHandlers()
+	want := "[SIGHUP, last registered, first registered]"
+	if got := vmregister.ToString(vm.GetGlobals()["events"]); got != want {
+		t.Errorf("events: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/network"
+)
+
+// parseSNMPOptions reads the optional options map at args[i] of the SNMP
+// functions: version, community, port, timeout, retries, the SNMPv3 user,
+// auth_protocol, auth_password, priv_protocol, priv_password and context
+func parseSNMPOptions(name string, args []Value, i int) (network.SNMPOptions, error) {
+	var opts network.SNMPOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "version":
+			if IsNumber(v) || IsInt(v) {
+				opts.Version = fmt.Sprint(int(ToNumber(v)))
+			} else {
+				opts.Version = ToString(v)
+			}
+		case "community":
+			opts.Community = ToString(v)
+		case "port", "retries":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+				return opts, fmt.Errorf("%s: %s must be a positive number", name, key)
+			}
+			if key == "port" {
+				opts.Port = int(ToNumber(v))
+			} else {
+				opts.Retries = int(ToNumber(v))
+			}
+		case "timeout":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("%s: timeout must be a positive number of milliseconds", name)
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		case "user":
+			opts.User = ToString(v)
+		case "auth_protocol":
+			opts.AuthProtocol = ToString(v)
+		case "auth_password":
+			opts.AuthPassword = ToString(v)
+		case "priv_protocol":
+			opts.PrivProtocol = ToString(v)
+		case "priv_password":
+			opts.PrivPassword = ToString(v)
+		case "context":
+			opts.Context = ToString(v)
+		default:
+			return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+	}
+	return opts, nil
+}
+
+// snmpVariables returns SNMP variables as an array of maps
+func snmpVariables(vars []network.SNMPVariable) Value {
+	arr := make([]Value, len(vars))
+	for i, v := range vars {
+		arr[i] = goToValue(network.SNMPVariableToMap(v))
+	}
+	return BoxArray(arr)
+}
+
+// registerSNMPFunctions registers SNMP gets and walks for inventorying
+// network devices, over SNMP v1, v2c and v3
+func (vm *RegisterVM) registerSNMPFunctions() {
+	// snmp_get(host, oids, options?)
+	vm.registerGlobal("snmp_get", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "snmp_get",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("snmp_get expects 2-3 arguments (host, oids, options), got %d", len(args))
+			}
+			var oids []string
+			if IsArray(args[1]) {
+				for _, oid := range AsArray(args[1]).Elements {
+					oids = append(oids, ToString(oid))
+				}
+			} else {
+				oids = []string{ToString(args[1])}
+			}
+			opts, err := parseSNMPOptions("snmp_get", args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+
+			vars, err := network.SNMPGet(ToString(args[0]), oids, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("snmp_get: %v", err)
+			}
+			if !IsArray(args[1]) {
+				return goToValue(network.SNMPVariableToMap(vars[0])), nil
+			}
+			return snmpVariables(vars), nil
+		},
+	})
+
+	// snmp_walk(host, oid, options?)
+	vm.registerGlobal("snmp_walk", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "snmp_walk",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("snmp_walk expects 2-3 arguments (host, oid, options), got %d", len(args))
+			}
+			opts, err := parseSNMPOptions("snmp_walk", args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+
+			vars, err := network.SNMPWalk(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("snmp_walk: %v", err)
+			}
+			return snmpVariables(vars), nil
+		},
+	})
+}
@@ -0,0 +1,203 @@
+package vmregister
+
+import (
+	"sort"
+	"sync"
+
+	"sentra/internal/siem"
+	stackvm "sentra/internal/vm"
+)
+
+var (
+	stackArityOnce sync.Once
+	stackArity     map[string]int // Arity of every stack VM builtin
+)
+
+// registerStackBuiltins registers the builtins that only the stack VM
+// implements, so that a script runs the same on either VM. Values are
+// converted on the way in and out. The stack VM's functions, and the
+// module state behind them, are created for each VM on first use.
+func (vm *RegisterVM) registerStackBuiltins() {
+	stackArityOnce.Do(func() {
+		stackArity = make(map[string]int)
+		for name, fn := range stackvm.Builtins() {
+			stackArity[name] = fn.Arity
+		}
+	})
+	names := make([]string, 0, len(stackArity))
+	for name := range stackArity {
+		if _, ok := vm.globalNames[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	// Sorted, so global IDs do not change from run to run
+	sort.Strings(names)
+	for _, name := range names {
+		vm.registerGlobal(name, &NativeFnObj{
+			Object: Object{Type: OBJ_NATIVE_FN},
+			Name:   name,
+			Arity:  stackArity[name],
+			Function: func(args []Value) (Value, error) {
+				vm.stackOnce.Do(func() {
+					vm.stackBuiltins = stackvm.Builtins()
+				})
+				return callStackBuiltin(vm.stackBuiltins[name], args)
+			},
+		})
+	}
+}
+
+// callStackBuiltin calls a stack VM builtin. Arrays and maps it changes in
+// place, like clear does, are copied back.
+func callStackBuiltin(fn *stackvm.NativeFunction, args []Value) (Value, error) {
+	stackArgs := make([]stackvm.Value, len(args))
+	for i, arg := range args {
+		stackArgs[i] = toStack(arg)
+	}
+	// Elements before the call, to tell which arguments changed
+	before := make([][]stackvm.Value, len(args))
+	for i, arg := range stackArgs {
+		before[i] = stackElements(arg)
+	}
+
+	result, err := fn.Function(stackArgs)
+
+	for i, arg := range stackArgs {
+		if after := stackElements(arg); !sameElements(before[i], after) {
+			copyBack(args[i], arg)
+		}
+	}
+	if err != nil {
+		return NilValue(), err
+	}
+	return fromStack(result), nil
+}
+
+// toStack converts a value for the stack VM, where all numbers are floats.
+// Functions and other values that have no counterpart become nil.
+func toStack(v Value) stackvm.Value {
+	switch {
+	case IsNil(v):
+		return nil
+	case IsBool(v):
+		return AsBool(v)
+	case IsInt(v):
+		return float64(ToInt(v))
+	case IsNumber(v):
+		return ToNumber(v)
+	case IsString(v):
+		return ToString(v)
+	case IsBytes(v):
+		return &stackvm.Bytes{Data: AsBytes(v).Data}
+	case IsArray(v):
+		elements := AsArray(v).Elements
+		arr := stackvm.NewArray(len(elements))
+		for _, elem := range elements {
+			arr.Elements = append(arr.Elements, toStack(elem))
+		}
+		return arr
+	case IsMap(v):
+		m := stackvm.NewMap()
+		for key, item := range AsMap(v).Items {
+			m.Items[key] = toStack(item)
+		}
+		return m
+	}
+	return nil
+}
+
+// fromStack converts a value returned by the stack VM. Whole numbers
+// become ints, as the register VM's own builtins return. The SIEM module
+// returns its own maps and arrays, which the stack VM indexes directly.
+func fromStack(v stackvm.Value) Value {
+	switch v := v.(type) {
+	case nil:
+		return NilValue()
+	case bool:
+		return BoxBool(v)
+	case float64:
+		if v == float64(int64(v)) {
+			return BoxInt(int64(v))
+		}
+		return BoxNumber(v)
+	case int:
+		return BoxInt(int64(v))
+	case int64:
+		return BoxInt(v)
+	case string:
+		return BoxString(v)
+	case *stackvm.String:
+		return BoxString(v.Value)
+	case *stackvm.Bytes:
+		return BoxBytes(v.Data)
+	case *stackvm.Array:
+		elements := make([]Value, len(v.Elements))
+		for i, elem := range v.Elements {
+			elements[i] = fromStack(elem)
+		}
+		return BoxArray(elements)
+	case *stackvm.Map:
+		items := make(map[string]Value, len(v.Items))
+		for key, item := range v.Items {
+			items[key] = fromStack(item)
+		}
+		return BoxMap(items)
+	case *siem.Array:
+		elements := make([]Value, len(v.Elements))
+		for i, elem := range v.Elements {
+			elements[i] = fromStack(elem)
+		}
+		return BoxArray(elements)
+	case *siem.Map:
+		items := make(map[string]Value, len(v.Items))
+		for key, item := range v.Items {
+			items[key] = fromStack(item)
+		}
+		return BoxMap(items)
+	}
+	return BoxString(stackvm.ToString(v))
+}
+
+// stackElements returns the elements of a stack array or the values of a
+// stack map, ordered by key, or nil for other values
+func stackElements(v stackvm.Value) []stackvm.Value {
+	switch v := v.(type) {
+	case *stackvm.Array:
+		return append([]stackvm.Value{}, v.Elements...)
+	case *stackvm.Map:
+		keys := make([]string, 0, len(v.Items))
+		for key := range v.Items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		elements := []stackvm.Value{}
+		for _, key := range keys {
+			elements = append(elements, key, v.Items[key])
+		}
+		return elements
+	}
+	return nil
+}
+
+func sameElements(a, b []stackvm.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// copyBack replaces the contents of a register VM array or map with those
+// of the stack value it was converted to
+func copyBack(v Value, changed stackvm.Value) {
+	switch {
+	case IsArray(v):
+		AsArray(v).Elements = AsArray(fromStack(changed)).Elements
+	case IsMap(v):
+		AsMap(v).Items = AsMap(fromStack(changed)).Items
+	}
+}
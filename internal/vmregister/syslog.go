@@ -0,0 +1,283 @@
+package vmregister
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sentra/internal/siem"
+)
+
+// forwarderStatsValue returns the counts of a forwarder as a map
+func forwarderStatsValue(f *siem.Forwarder) Value {
+	st := f.Stats()
+	return goToValue(map[string]interface{}{
+		"type":       f.Kind,
+		"sent":       st.Sent,
+		"failed":     st.Failed,
+		"batches":    st.Batches,
+		"pending":    st.Pending,
+		"last_error": st.LastError,
+	})
+}
+
+// forwarderEvents reads the events of siem_forward: a map, a string taken
+// as the message of an event, or an array of them
+func forwarderEvents(v Value) ([]map[string]interface{}, error) {
+	values := []Value{v}
+	if IsArray(v) {
+		values = AsArray(v).Elements
+	}
+	events := make([]map[string]interface{}, 0, len(values))
+	for _, elem := range values {
+		switch {
+		case IsMap(elem):
+			events = append(events, valueToGo(elem).(map[string]interface{}))
+		case IsString(elem):
+			events = append(events, map[string]interface{}{"message": ToString(elem)})
+		default:
+			return nil, fmt.Errorf("events must be maps or strings, got %s", ValueType(elem))
+		}
+	}
+	return events, nil
+}
+
+// registerSyslogFunctions registers the syslog listener and the
+// forwarders to Splunk and Elasticsearch, so a script can receive logs,
+// filter or enrich them and pass them on. Forwarders are referred to by
+// handles like processes.
+func (vm *RegisterVM) registerSyslogFunctions() {
+	var mu sync.Mutex
+	var counter uint64
+	forwarders := make(map[string]*siem.Forwarder)
+
+	lookup := func(name string, handle Value) (*siem.Forwarder, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		f, ok := forwarders[ToString(handle)]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such forwarder: %s", name, ToString(handle))
+		}
+		return f, nil
+	}
+
+	// siem_parse_syslog(line)
+	vm.registerGlobal("siem_parse_syslog", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_parse_syslog",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			m, err := siem.ParseSyslog(ToString(args[0]), time.Now())
+			if err != nil {
+				return NilValue(), fmt.Errorf("siem_parse_syslog: %v", err)
+			}
+			return goToValue(siem.SyslogToMap(m)), nil
+		},
+	})
+
+	// siem_syslog_listen(options, callback)
+	vm.registerGlobal("siem_syslog_listen", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_syslog_listen",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[0]) {
+				return NilValue(), fmt.Errorf("siem_syslog_listen: options must be a map, got %s", ValueType(args[0]))
+			}
+			// args is reused by the natives the callback calls
+			callback := args[1]
+			if !IsPointer(callback) || !isCallableType(AsObject(callback).Type) {
+				return NilValue(), fmt.Errorf("siem_syslog_listen: callback must be a function")
+			}
+			var opts siem.SyslogServerOptions
+			count := 0
+			var duration time.Duration
+			for key, v := range AsMap(args[0]).Items {
+				switch key {
+				case "udp":
+					opts.UDP = ToString(v)
+				case "tcp":
+					opts.TCP = ToString(v)
+				case "tls":
+					opts.TLS = ToString(v)
+				case "cert":
+					opts.CertFile = ToString(v)
+				case "key":
+					opts.KeyFile = ToString(v)
+				case "max_size", "count":
+					if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+						return NilValue(), fmt.Errorf("siem_syslog_listen: %s must be a positive number", key)
+					}
+					if key == "count" {
+						count = int(ToNumber(v))
+					} else {
+						opts.MaxMessageSize = int(ToNumber(v))
+					}
+				case "duration_ms":
+					if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+						return NilValue(), fmt.Errorf("siem_syslog_listen: duration_ms must be a positive number of milliseconds")
+					}
+					duration = time.Duration(ToNumber(v) * float64(time.Millisecond))
+				default:
+					return NilValue(), fmt.Errorf("siem_syslog_listen: unknown option '%s'", key)
+				}
+			}
+			server, err := siem.ListenSyslog(opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("siem_syslog_listen: %v", err)
+			}
+			defer server.Close()
+
+			// Call back with each message until the callback returns false,
+			// count messages were handled or the duration passed. Signals
+			// cut the wait short so their handlers run on time.
+			var deadline <-chan time.Time
+			if duration > 0 {
+				timer := time.NewTimer(duration)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			handled := 0
+			for count == 0 || handled < count {
+				select {
+				case m, ok := <-server.Messages():
+					if !ok {
+						return BoxInt(int64(handled)), nil
+					}
+					handled++
+					result, err := vm.callValue(callback, []Value{goToValue(siem.SyslogToMap(m))})
+					if err != nil {
+						return NilValue(), err
+					}
+					if IsBool(result) && !AsBool(result) {
+						return BoxInt(int64(handled)), nil
+					}
+				case <-deadline:
+					return BoxInt(int64(handled)), nil
+				case <-vm.wakeChan():
+				}
+				if atomic.LoadInt32(&vm.interrupted) != 0 {
+					if err := vm.checkInterrupt(); err != nil {
+						return NilValue(), err
+					}
+				}
+			}
+			return BoxInt(int64(handled)), nil
+		},
+	})
+
+	// siem_forwarder(type, options)
+	vm.registerGlobal("siem_forwarder", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_forwarder",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			if !IsMap(args[1]) {
+				return NilValue(), fmt.Errorf("siem_forwarder: options must be a map, got %s", ValueType(args[1]))
+			}
+			var opts siem.ForwarderOptions
+			for key, v := range AsMap(args[1]).Items {
+				switch key {
+				case "url":
+					opts.URL = ToString(v)
+				case "token":
+					opts.Token = ToString(v)
+				case "username":
+					opts.Username = ToString(v)
+				case "password":
+					opts.Password = ToString(v)
+				case "index":
+					opts.Index = ToString(v)
+				case "sourcetype":
+					opts.SourceType = ToString(v)
+				case "source":
+					opts.Source = ToString(v)
+				case "insecure":
+					opts.Insecure = IsTruthy(v)
+				case "batch_size", "retries", "flush_ms", "timeout_ms":
+					if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+						return NilValue(), fmt.Errorf("siem_forwarder: %s must be a positive number", key)
+					}
+					n := ToNumber(v)
+					switch key {
+					case "batch_size":
+						opts.BatchSize = int(n)
+					case "retries":
+						opts.Retries = int(n)
+					case "flush_ms":
+						opts.FlushInterval = time.Duration(n * float64(time.Millisecond))
+					case "timeout_ms":
+						opts.Timeout = time.Duration(n * float64(time.Millisecond))
+					}
+				default:
+					return NilValue(), fmt.Errorf("siem_forwarder: unknown option '%s'", key)
+				}
+			}
+			f, err := siem.NewForwarder(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("siem_forwarder: %v", err)
+			}
+			handle := "fwd_" + strconv.FormatUint(atomic.AddUint64(&counter, 1), 10)
+			mu.Lock()
+			forwarders[handle] = f
+			mu.Unlock()
+			return BoxString(handle), nil
+		},
+	})
+
+	// siem_forward(forwarder, events) queues events. Delivery failures do
+	// not raise, so a router keeps running while a collector is down; they
+	// are counted in the stats of siem_forward_flush.
+	vm.registerGlobal("siem_forward", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_forward",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			f, err := lookup("siem_forward", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			events, err := forwarderEvents(args[1])
+			if err != nil {
+				return NilValue(), fmt.Errorf("siem_forward: %v", err)
+			}
+			f.Send(events...)
+			return BoxInt(int64(len(events))), nil
+		},
+	})
+
+	// siem_forward_flush(forwarder)
+	vm.registerGlobal("siem_forward_flush", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_forward_flush",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			f, err := lookup("siem_forward_flush", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			f.Flush()
+			return forwarderStatsValue(f), nil
+		},
+	})
+
+	// siem_forward_close(forwarder)
+	vm.registerGlobal("siem_forward_close", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "siem_forward_close",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			f, err := lookup("siem_forward_close", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			mu.Lock()
+			delete(forwarders, ToString(args[0]))
+			mu.Unlock()
+			f.Close()
+			return forwarderStatsValue(f), nil
+		},
+	})
+}
@@ -0,0 +1,85 @@
+package vmregister_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sentra/internal/vmregister"
+)
+
+func TestSyslogRouter(t *testing.T) {
+	var events []string
+	hec := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		events = append(events, strings.Split(strings.TrimSpace(string(data)), "\n")...)
+		io.WriteString(w, `{"text":"Success","code":0}`)
+	}))
+	defer hec.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	go func() {
+		for i := 0; i < 100; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			defer conn.Close()
+			io.WriteString(conn, "<38>Jan  5 10:00:00 web01 sshd[7]: Failed password for root\n"+
+				"<14>Jan  5 10:00:01 web01 cron[8]: job done\n"+
+				"<36>1 2024-01-05T10:00:02Z web02 sudo - - - auth failure\n"+
+				"<14>Jan  5 10:00:03 web01 app: stop\n"+
+				"<14>Jan  5 10:00:04 web01 app: never read\n")
+			return
+		}
+	}()
+
+	globals := run(t, `
+let parsed = siem_parse_syslog("<34>Oct 11 22:14:15 mymachine su: 'su root' failed")
+let fwd = siem_forwarder("splunk", {"url": "`+hec.URL+`", "token": "t", "flush_ms": 60000})
+let seen = []
+let handled = siem_syslog_listen({"tcp": "`+addr+`", "duration_ms": 10000}, fn(m) {
+    push(seen, m["app_name"] + ":" + m["severity_name"])
+    if m["message"] == "stop" { return false }
+    if m["facility_name"] == "auth" { siem_forward(fwd, m) }
+})
+let stats = siem_forward_close(fwd)
+let summary = str(stats["sent"]) + " sent, " + str(stats["failed"]) + " failed"
+let host = parsed["hostname"] + " " + parsed["app_name"] + " " + parsed["severity_name"]
+`)
+	for name, want := range map[string]string{
+		"handled": "4",
+		"seen":    "[sshd:info, cron:info, sudo:warning, app:info]",
+		"summary": "2 sent, 0 failed",
+		"host":    "mymachine su crit",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if len(events) != 2 || !strings.Contains(events[0], `"Failed password for root"`) ||
+		!strings.Contains(events[1], `"host":"web02"`) {
+		t.Errorf("forwarded %q", events)
+	}
+
+	expectErrors(t, map[string]string{
+		`siem_syslog_listen({}, fn(m) {})`:                        "siem_syslog_listen: no udp, tcp or tls address to listen on",
+		`siem_syslog_listen({"udp": ":0", "port": 1}, fn(m) {})`:  "siem_syslog_listen: unknown option 'port'",
+		`siem_syslog_listen({"udp": ":0"}, 3)`:                    "siem_syslog_listen: callback must be a function",
+		`siem_forwarder("kafka", {"url": "http://localhost"})`:    "siem_forwarder: unknown forwarder type 'kafka'",
+		`siem_forwarder("elastic", {"url": "http://localhost"})`:  "siem_forwarder: elasticsearch needs an index",
+		`siem_forwarder("splunk", {"url": "x", "batch_size": 0})`: "siem_forwarder: batch_size must be a positive number",
+		`siem_forward("fwd_9", {})`:                               "siem_forward: no such forwarder: fwd_9",
+		`siem_parse_syslog("")`:                                   "siem_parse_syslog: empty syslog message",
+	})
+}
@@ -0,0 +1,438 @@
+package vmregister
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"sentra/internal/threat_intel"
+)
+
+// threatResultValue returns a lookup result as a map
+func threatResultValue(result *threat_intel.ThreatResult) Value {
+	if result == nil {
+		return NilValue()
+	}
+	sources := make([]interface{}, len(result.Sources))
+	for i, s := range result.Sources {
+		sources[i] = s
+	}
+	categories := make([]interface{}, len(result.Categories))
+	for i, c := range result.Categories {
+		categories[i] = c
+	}
+	return goToValue(map[string]interface{}{
+		"indicator":  result.Indicator,
+		"type":       result.Type,
+		"reputation": result.Reputation,
+		"score":      result.Score,
+		"malicious":  result.Malicious,
+		"sources":    sources,
+		"categories": categories,
+		"details":    result.Details,
+	})
+}
+
+// importResultValue returns the counts of a STIX import or TAXII poll
+func importResultValue(r *threat_intel.ImportResult) Value {
+	return goToValue(map[string]interface{}{
+		"source":      r.Source,
+		"objects":     r.Objects,
+		"indicators":  r.Indicators,
+		"stored":      r.Stored,
+		"skipped":     r.Skipped,
+		"pages":       r.Pages,
+		"added_after": r.AddedAfter,
+	})
+}
+
+// taxiiOptions reads the options of the TAXII functions. Those other than
+// the client's are handed to other, which reports whether it knows them.
+func taxiiOptions(name string, v Value, other func(key string, v Value) (bool, error)) (threat_intel.TAXIIOptions, error) {
+	var opts threat_intel.TAXIIOptions
+	if !IsMap(v) {
+		return opts, fmt.Errorf("%s: options must be a map, got %s", name, ValueType(v))
+	}
+	for key, item := range AsMap(v).Items {
+		switch key {
+		case "username":
+			opts.Username = ToString(item)
+		case "password":
+			opts.Password = ToString(item)
+		case "token":
+			opts.Token = ToString(item)
+		case "insecure":
+			opts.Insecure = IsTruthy(item)
+		case "timeout_ms":
+			if !(IsNumber(item) || IsInt(item)) || ToNumber(item) <= 0 {
+				return opts, fmt.Errorf("%s: timeout_ms must be a positive number", name)
+			}
+			opts.Timeout = time.Duration(ToNumber(item) * float64(time.Millisecond))
+		default:
+			known, err := other(key, item)
+			if err != nil {
+				return opts, err
+			}
+			if !known {
+				return opts, fmt.Errorf("%s: unknown option '%s'", name, key)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// ttlOption reads ttl_days, how long indicators without a valid_until
+// are kept
+func ttlOption(name string, v Value) (time.Duration, error) {
+	if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+		return 0, fmt.Errorf("%s: ttl_days must be a positive number", name)
+	}
+	return time.Duration(ToNumber(v) * float64(24*time.Hour)), nil
+}
+
+// registerThreatIntelFunctions registers the threat intelligence lookups
+// that share the module's indicator store, and the STIX and TAXII
+// functions that fill it
+func (vm *RegisterVM) registerThreatIntelFunctions() {
+	tiMod := func() *threat_intel.ThreatIntelModule {
+		return vm.threatIntelModule.(*threat_intel.ThreatIntelModule)
+	}
+
+	// threat_lookup_hash(hash)
+	vm.registerGlobal("threat_lookup_hash", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_lookup_hash",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			return threatResultValue(tiMod().LookupHash(ToString(args[0]))), nil
+		},
+	})
+
+	// threat_bulk_lookup(indicators)
+	vm.registerGlobal("threat_bulk_lookup", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_bulk_lookup",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsArray(args[0]) {
+				return NilValue(), fmt.Errorf("threat_bulk_lookup expects an array")
+			}
+			elements := AsArray(args[0]).Elements
+			indicators := make([]string, len(elements))
+			for i, elem := range elements {
+				indicators[i] = ToString(elem)
+			}
+			results := make(map[string]Value)
+			for indicator, result := range tiMod().BulkLookup(indicators) {
+				results[indicator] = threatResultValue(result)
+			}
+			return BoxMap(results), nil
+		},
+	})
+
+	// threat_get_reputation(indicator)
+	vm.registerGlobal("threat_get_reputation", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_get_reputation",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			return BoxString(tiMod().GetReputation(ToString(args[0]))), nil
+		},
+	})
+
+	// threat_set_api_key(source, api_key)
+	vm.registerGlobal("threat_set_api_key", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_set_api_key",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			return BoxBool(tiMod().SetAPIKey(ToString(args[0]), ToString(args[1]))), nil
+		},
+	})
+
+	// threat_stix_import(bundle, options?) takes a bundle as JSON or as a map
+	vm.registerGlobal("threat_stix_import", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_stix_import",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("threat_stix_import expects 1-2 arguments (bundle, options), got %d", len(args))
+			}
+			var data []byte
+			if IsMap(args[0]) {
+				var err error
+				if data, err = json.Marshal(valueToGo(args[0])); err != nil {
+					return NilValue(), fmt.Errorf("threat_stix_import: %v", err)
+				}
+			} else {
+				data = []byte(ToString(args[0]))
+			}
+			source := "stix"
+			var ttl time.Duration
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("threat_stix_import: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					switch key {
+					case "source":
+						source = ToString(v)
+					case "ttl_days":
+						var err error
+						if ttl, err = ttlOption("threat_stix_import", v); err != nil {
+							return NilValue(), err
+						}
+					default:
+						return NilValue(), fmt.Errorf("threat_stix_import: unknown option '%s'", key)
+					}
+				}
+			}
+			result, err := tiMod().ImportSTIX(data, source, ttl)
+			if err != nil {
+				return NilValue(), fmt.Errorf("threat_stix_import: %v", err)
+			}
+			return importResultValue(result), nil
+		},
+	})
+
+	// threat_taxii_collections(url, options?)
+	vm.registerGlobal("threat_taxii_collections", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_taxii_collections",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("threat_taxii_collections expects 1-2 arguments (url, options), got %d", len(args))
+			}
+			var opts threat_intel.TAXIIOptions
+			if len(args) == 2 {
+				var err error
+				opts, err = taxiiOptions("threat_taxii_collections", args[1], func(string, Value) (bool, error) { return false, nil })
+				if err != nil {
+					return NilValue(), err
+				}
+			}
+			client, err := threat_intel.NewTAXIIClient(ToString(args[0]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("threat_taxii_collections: %v", err)
+			}
+			collections, err := client.Collections()
+			if err != nil {
+				return NilValue(), fmt.Errorf("threat_taxii_collections: %v", err)
+			}
+			list := make([]interface{}, len(collections))
+			for i, col := range collections {
+				mediaTypes := make([]interface{}, len(col.MediaTypes))
+				for j, m := range col.MediaTypes {
+					mediaTypes[j] = m
+				}
+				list[i] = map[string]interface{}{
+					"id":          col.ID,
+					"title":       col.Title,
+					"description": col.Description,
+					"can_read":    col.CanRead,
+					"can_write":   col.CanWrite,
+					"media_types": mediaTypes,
+					"api_root":    col.APIRoot,
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// threat_taxii_poll(url, collection, options?) adds the indicators of a
+	// collection, by id or title, to the store the lookups consult. Each
+	// poll continues from where the last one of the collection ended.
+	vm.registerGlobal("threat_taxii_poll", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_taxii_poll",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("threat_taxii_poll expects 2-3 arguments (url, collection, options), got %d", len(args))
+			}
+			var opts threat_intel.TAXIIOptions
+			addedAfter := ""
+			limit := 0
+			var ttl time.Duration
+			if len(args) == 3 {
+				var err error
+				opts, err = taxiiOptions("threat_taxii_poll", args[2], func(key string, v Value) (bool, error) {
+					switch key {
+					case "added_after":
+						addedAfter = ToString(v)
+					case "limit":
+						if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+							return true, fmt.Errorf("threat_taxii_poll: limit must be a positive number")
+						}
+						limit = int(ToNumber(v))
+					case "ttl_days":
+						var err error
+						ttl, err = ttlOption("threat_taxii_poll", v)
+						return true, err
+					default:
+						return false, nil
+					}
+					return true, nil
+				})
+				if err != nil {
+					return NilValue(), err
+				}
+			}
+			result, err := tiMod().PollTAXII(ToString(args[0]), ToString(args[1]), addedAfter, limit, ttl, opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("threat_taxii_poll: %v", err)
+			}
+			return importResultValue(result), nil
+		},
+	})
+
+	// threat_indicators() lists the stored indicators that have not expired
+	vm.registerGlobal("threat_indicators", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_indicators",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			store := tiMod().Indicators()
+			indicators := store.Indicators(time.Now())
+			list := make([]interface{}, len(indicators))
+			for i, ind := range indicators {
+				observables := make([]interface{}, len(ind.Observables))
+				for j, o := range ind.Observables {
+					observables[j] = map[string]interface{}{"type": o.Type, "value": o.Value}
+				}
+				types := make([]interface{}, len(ind.Types))
+				for j, t := range ind.Types {
+					types[j] = t
+				}
+				list[i] = map[string]interface{}{
+					"id":          ind.ID,
+					"name":        ind.Name,
+					"pattern":     ind.Pattern,
+					"types":       types,
+					"confidence":  ind.Confidence,
+					"source":      ind.Source,
+					"observables": observables,
+					"expires":     store.Expires(ind.ID).Format(time.RFC3339),
+				}
+			}
+			return goToValue(list), nil
+		},
+	})
+
+	// threat_cache_open(path, options?) keeps lookup results in an SQLite
+	// file, so later scripts answer from it instead of the sources
+	vm.registerGlobal("threat_cache_open", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_cache_open",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("threat_cache_open expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			var ttl, negativeTTL time.Duration
+			if len(args) == 2 {
+				if !IsMap(args[1]) {
+					return NilValue(), fmt.Errorf("threat_cache_open: options must be a map, got %s", ValueType(args[1]))
+				}
+				for key, v := range AsMap(args[1]).Items {
+					if key != "ttl_hours" && key != "negative_ttl_hours" {
+						return NilValue(), fmt.Errorf("threat_cache_open: unknown option '%s'", key)
+					}
+					if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+						return NilValue(), fmt.Errorf("threat_cache_open: %s must be a positive number", key)
+					}
+					d := time.Duration(ToNumber(v) * float64(time.Hour))
+					if key == "ttl_hours" {
+						ttl = d
+					} else {
+						negativeTTL = d
+					}
+				}
+			}
+			if err := tiMod().OpenCache(ToString(args[0]), ttl, negativeTTL); err != nil {
+				return NilValue(), fmt.Errorf("threat_cache_open: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// threat_set_rate_limit(source, requests, window_ms)
+	vm.registerGlobal("threat_set_rate_limit", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_set_rate_limit",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			for _, v := range args[1:] {
+				if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+					return NilValue(), fmt.Errorf("threat_set_rate_limit: requests and window_ms must be positive numbers")
+				}
+			}
+			window := time.Duration(ToNumber(args[2]) * float64(time.Millisecond))
+			return BoxBool(tiMod().SetRateLimit(ToString(args[0]), int(ToNumber(args[1])), window)), nil
+		},
+	})
+
+	// threat_prefetch(indicators) looks up the indicators not cached yet
+	vm.registerGlobal("threat_prefetch", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_prefetch",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if !IsArray(args[0]) {
+				return NilValue(), fmt.Errorf("threat_prefetch expects an array")
+			}
+			elements := AsArray(args[0]).Elements
+			indicators := make([]string, len(elements))
+			for i, elem := range elements {
+				indicators[i] = ToString(elem)
+			}
+			r := tiMod().Prefetch(indicators)
+			return goToValue(map[string]interface{}{
+				"total":   r.Total,
+				"cached":  r.Cached,
+				"fetched": r.Fetched,
+				"failed":  r.Failed,
+				"invalid": r.Invalid,
+			}), nil
+		},
+	})
+
+	// threat_stats() reports the cache and the requests of each source
+	vm.registerGlobal("threat_stats", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "threat_stats",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			c := tiMod().CacheStats()
+			providers := make(map[string]interface{})
+			for _, p := range tiMod().ProviderStats() {
+				provider := map[string]interface{}{
+					"enabled":    p.Enabled,
+					"rate_limit": p.RateLimit,
+					"window_ms":  p.RateWindow.Milliseconds(),
+					"requests":   p.Requests,
+					"throttled":  p.Throttled,
+					"failures":   p.Failures,
+					"last_error": p.LastError,
+				}
+				if !p.RetryAt.IsZero() {
+					provider["retry_at"] = p.RetryAt.Format(time.RFC3339)
+				}
+				providers[p.Name] = provider
+			}
+			return goToValue(map[string]interface{}{
+				"cache": map[string]interface{}{
+					"path":     c.Path,
+					"entries":  c.Entries,
+					"negative": c.Negative,
+					"hits":     c.Hits,
+					"misses":   c.Misses,
+				},
+				"providers":  providers,
+				"indicators": len(tiMod().Indicators().Indicators(time.Now())),
+			}), nil
+		},
+	})
+}
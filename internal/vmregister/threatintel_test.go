@@ -0,0 +1,87 @@
+package vmregister_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestThreatIntelIndicators(t *testing.T) {
+	globals := run(t, `
+let bundle = {"type": "bundle", "id": "bundle--1", "objects": [
+    {"type": "indicator", "id": "indicator--1", "modified": "2024-01-01T00:00:00Z", "name": "Botnet",
+     "indicator_types": ["malicious-activity"], "confidence": 70,
+     "pattern": "[ipv4-addr:value ISSUBSET '203.0.113.0/24'] OR [domain-name:value = 'c2.example']",
+     "pattern_type": "stix", "valid_from": "2024-01-01T00:00:00Z"},
+    {"type": "identity", "id": "identity--2", "name": "CERT"}
+]}
+let imported = threat_stix_import(bundle, {"source": "cert-feed", "ttl_days": 7})
+let counts = str(imported["objects"]) + " " + str(imported["stored"])
+let ip = threat_lookup_ip("203.0.113.50")
+let hit = ip["reputation"] + " " + str(ip["score"]) + " " + ip["sources"][0] + " " + ip["details"]["name"]
+let rep = threat_get_reputation("c2.example")
+let bulk = threat_bulk_lookup(["c2.example", "203.0.113.1"])
+let stored = threat_indicators()
+let observables = len(stored[0]["observables"])
+`)
+	for name, want := range map[string]string{
+		"counts":      "2 1",
+		"hit":         "malicious 70 STIX:cert-feed Botnet",
+		"rep":         "malicious",
+		"observables": "2",
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if bulk := globals["bulk"]; !vmregister.IsMap(bulk) || len(vmregister.AsMap(bulk).Items) != 2 {
+		t.Errorf("bulk = %s", vmregister.ToString(bulk))
+	}
+
+	expectErrors(t, map[string]string{
+		`threat_stix_import("{}")`:                             "threat_stix_import: not a STIX bundle",
+		`threat_stix_import("{}", {"ttl": 1})`:                 "threat_stix_import: unknown option 'ttl'",
+		`threat_stix_import("{}", {"ttl_days": 0})`:            "threat_stix_import: ttl_days must be a positive number",
+		`threat_taxii_poll("ftp://x", "c")`:                    "threat_taxii_poll: bad TAXII url 'ftp://x'",
+		`threat_taxii_poll("https://x", "c", {"limit": -1})`:   "threat_taxii_poll: limit must be a positive number",
+		`threat_taxii_poll("https://x", "c", {"page": 1})`:     "threat_taxii_poll: unknown option 'page'",
+		`threat_taxii_collections("https://x", {"next": "a"})`: "threat_taxii_collections: unknown option 'next'",
+		`threat_bulk_lookup("1.2.3.4")`:                        "threat_bulk_lookup expects an array",
+	})
+}
+
+func TestThreatIntelCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ti.db")
+	globals := run(t, `
+let opened = threat_cache_open("`+path+`", {"ttl_hours": 12, "negative_ttl_hours": 1})
+let limited = threat_set_rate_limit("VirusTotal", 500, 86400000)
+let unknown = threat_set_rate_limit("shodan", 1, 1000)
+let pre = threat_prefetch(["198.51.100.7", "198.51.100.7", "not an indicator"])
+let prefetched = str(pre["total"]) + " " + str(pre["fetched"]) + " " + str(pre["invalid"])
+let stats = threat_stats()
+let vt = stats["providers"]["VirusTotal"]
+let limit = str(vt["rate_limit"]) + "/" + str(vt["window_ms"])
+let cache_path = stats["cache"]["path"]
+`)
+	for name, want := range map[string]string{
+		"opened":     "true",
+		"limited":    "true",
+		"unknown":    "false",
+		"prefetched": "2 1 1",
+		"limit":      "500/86400000",
+		"cache_path": path,
+	} {
+		if got := vmregister.ToString(globals[name]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	expectErrors(t, map[string]string{
+		`threat_cache_open("x.db", {"ttl": 1})`:        "threat_cache_open: unknown option 'ttl'",
+		`threat_cache_open("x.db", {"ttl_hours": 0})`:  "threat_cache_open: ttl_hours must be a positive number",
+		`threat_cache_open("/nonexistent/dir/ti.db")`:  "threat_cache_open:",
+		`threat_set_rate_limit("VirusTotal", 0, 1000)`: "threat_set_rate_limit: requests and window_ms must be positive numbers",
+		`threat_prefetch("198.51.100.7")`:              "threat_prefetch expects an array",
+	})
+}
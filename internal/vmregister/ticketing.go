@@ -0,0 +1,296 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/incident"
+	"sentra/internal/reporting"
+	"sentra/internal/ticketing"
+)
+
+// parseTicketOptions reads the options of ticket_connect: project,
+// issue_type, table, dedup_field, username, password, token, fields,
+// priorities, statuses, transition_fields, fingerprint_fields, insecure
+// and timeout_ms
+func parseTicketOptions(args []Value, i int) (ticketing.Options, error) {
+	var opts ticketing.Options
+	if len(args) <= i {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("ticket_connect: options must be a map, got %s", ValueType(args[i]))
+	}
+	// mapping reads a map of strings
+	mapping := func(key string, v Value) (map[string]string, error) {
+		if !IsMap(v) {
+			return nil, fmt.Errorf("ticket_connect: %s must be a map, got %s", key, ValueType(v))
+		}
+		m := make(map[string]string)
+		for k, item := range AsMap(v).Items {
+			m[k] = ToString(item)
+		}
+		return m, nil
+	}
+	for key, v := range AsMap(args[i]).Items {
+		var err error
+		switch key {
+		case "project":
+			opts.Project = ToString(v)
+		case "issue_type":
+			opts.IssueType = ToString(v)
+		case "table":
+			opts.Table = ToString(v)
+		case "dedup_field":
+			opts.DedupField = ToString(v)
+		case "username":
+			opts.Username = ToString(v)
+		case "password":
+			opts.Password = ToString(v)
+		case "token":
+			opts.Token = ToString(v)
+		case "fields", "transition_fields":
+			if !IsMap(v) {
+				return opts, fmt.Errorf("ticket_connect: %s must be a map, got %s", key, ValueType(v))
+			}
+			if key == "fields" {
+				opts.Fields = valueToGo(v).(map[string]interface{})
+			} else {
+				opts.TransitionFields = valueToGo(v).(map[string]interface{})
+			}
+		case "priorities":
+			opts.Priorities, err = mapping(key, v)
+		case "statuses":
+			opts.Statuses, err = mapping(key, v)
+		case "fingerprint_fields":
+			if !IsArray(v) {
+				return opts, fmt.Errorf("ticket_connect: fingerprint_fields must be an array, got %s", ValueType(v))
+			}
+			opts.FingerprintFields = stringList(v)
+		case "insecure":
+			opts.Insecure = IsTruthy(v)
+		case "timeout_ms":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("ticket_connect: timeout_ms must be a positive number")
+			}
+			opts.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return opts, fmt.Errorf("ticket_connect: unknown option '%s'", key)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// ticketItem reads a finding or an incident to file
+func ticketItem(name string, v Value) (map[string]interface{}, error) {
+	if !IsMap(v) {
+		return nil, fmt.Errorf("%s: the finding must be a map, got %s", name, ValueType(v))
+	}
+	return valueToGo(v).(map[string]interface{}), nil
+}
+
+// registerTicketingFunctions registers the functions that file findings
+// and incidents as Jira issues or ServiceNow records. Each finding keeps
+// one ticket, found again by its fingerprint, which is updated when the
+// finding is synced again and closed when it is fixed.
+func (vm *RegisterVM) registerTicketingFunctions() {
+	tracker := func(name string, v Value) (*ticketing.Tracker, error) {
+		t, err := ticketing.Lookup(ToString(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return t, nil
+	}
+
+	// ticket_connect(type, url, options?) returns the id of a tracker of a
+	// Jira project or ServiceNow table
+	vm.registerGlobal("ticket_connect", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_connect",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("ticket_connect expects 2-3 arguments (type, url, options), got %d", len(args))
+			}
+			opts, err := parseTicketOptions(args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			t, err := ticketing.Connect(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_connect: %v", err)
+			}
+			return BoxString(t.ID), nil
+		},
+	})
+
+	// ticket_sync(tracker, finding, options?) creates the ticket of a
+	// finding or updates it, moving it to the status of the finding's
+	// status
+	vm.registerGlobal("ticket_sync", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_sync",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("ticket_sync expects 2-3 arguments (tracker, finding, options), got %d", len(args))
+			}
+			var fingerprint string
+			if len(args) == 3 {
+				if !IsMap(args[2]) {
+					return NilValue(), fmt.Errorf("ticket_sync: options must be a map, got %s", ValueType(args[2]))
+				}
+				for key, v := range AsMap(args[2]).Items {
+					switch key {
+					case "fingerprint":
+						fingerprint = ToString(v)
+					default:
+						return NilValue(), fmt.Errorf("ticket_sync: unknown option '%s'", key)
+					}
+				}
+			}
+			t, err := tracker("ticket_sync", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			item, err := ticketItem("ticket_sync", args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := t.Sync(item, fingerprint)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_sync: %v", err)
+			}
+			return goToValue(ticketing.SyncResultToMap(result)), nil
+		},
+	})
+
+	// ticket_sync_report(tracker, report_id) syncs every finding of a
+	// report
+	vm.registerGlobal("ticket_sync_report", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_sync_report",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			t, err := tracker("ticket_sync_report", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			findings, err := vm.reportingModule.(*reporting.ReportingModule).ReportFindings(ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_sync_report: %v", err)
+			}
+			results := make([]interface{}, 0, len(findings))
+			for _, f := range findings {
+				result, err := t.Sync(reporting.FindingToMap(f), "")
+				if err != nil {
+					return NilValue(), fmt.Errorf("ticket_sync_report: %s: %v", f.Title, err)
+				}
+				results = append(results, ticketing.SyncResultToMap(result))
+			}
+			return goToValue(results), nil
+		},
+	})
+
+	// ticket_sync_incident(tracker, incident_id) files an incident, which
+	// is told apart from others by its id
+	vm.registerGlobal("ticket_sync_incident", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_sync_incident",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			t, err := tracker("ticket_sync_incident", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			inc, err := vm.incidentModule.(*incident.IncidentModule).GetIncident(ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_sync_incident: %v", err)
+			}
+			result, err := t.Sync(valueToGo(incidentValue(inc)).(map[string]interface{}), "incident-"+inc.ID)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_sync_incident: %v", err)
+			}
+			return goToValue(ticketing.SyncResultToMap(result)), nil
+		},
+	})
+
+	// ticket_find(tracker, finding) returns the ticket of a finding, or of
+	// a fingerprint, or nil when it has none
+	vm.registerGlobal("ticket_find", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_find",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			t, err := tracker("ticket_find", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			fingerprint := ToString(args[1])
+			if IsMap(args[1]) {
+				if fingerprint, err = t.Fingerprint(valueToGo(args[1]).(map[string]interface{})); err != nil {
+					return NilValue(), fmt.Errorf("ticket_find: %v", err)
+				}
+			}
+			ticket, err := t.Find(fingerprint)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_find: %v", err)
+			}
+			if ticket == nil {
+				return NilValue(), nil
+			}
+			return goToValue(ticketing.TicketToMap(*ticket)), nil
+		},
+	})
+
+	// ticket_get(tracker, key) returns a ticket by its key, to read back
+	// its status
+	vm.registerGlobal("ticket_get", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_get",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			t, err := tracker("ticket_get", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			ticket, err := t.Get(ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_get: %v", err)
+			}
+			return goToValue(ticketing.TicketToMap(*ticket)), nil
+		},
+	})
+
+	// ticket_fingerprint(finding, fields?) identifies a finding by some of
+	// its fields, the default ones when none are given
+	vm.registerGlobal("ticket_fingerprint", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "ticket_fingerprint",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("ticket_fingerprint expects 1-2 arguments (finding, fields), got %d", len(args))
+			}
+			item, err := ticketItem("ticket_fingerprint", args[0])
+			if err != nil {
+				return NilValue(), err
+			}
+			var fields []string
+			if len(args) == 2 {
+				if !IsArray(args[1]) {
+					return NilValue(), fmt.Errorf("ticket_fingerprint: fields must be an array, got %s", ValueType(args[1]))
+				}
+				fields = stringList(args[1])
+			}
+			fingerprint, err := ticketing.Fingerprint(item, fields)
+			if err != nil {
+				return NilValue(), fmt.Errorf("ticket_fingerprint: %v", err)
+			}
+			return BoxString(fingerprint), nil
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package vmregister_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestTicketing(t *testing.T) {
+	records := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		record := func(id string) map[string]interface{} {
+			result := make(map[string]interface{})
+			for k, v := range records[id] {
+				result[k] = map[string]interface{}{"value": v, "display_value": v}
+			}
+			return result
+		}
+		switch r.Method {
+		case "GET":
+			found := []interface{}{}
+			for id, fields := range records {
+				query := r.URL.Query().Get("sysparm_query")
+				if strings.HasPrefix(query, "correlation_id="+fields["correlation_id"].(string)+"^") || strings.HasPrefix(query, "number="+fields["number"].(string)+"^") {
+					found = append(found, record(id))
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": found})
+		case "POST":
+			id := fmt.Sprintf("%d", len(records)+1)
+			body["sys_id"], body["number"], body["state"] = id, "INC00"+id, "1"
+			records[id] = body
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": record(id)})
+		case "PATCH":
+			id := strings.TrimPrefix(r.URL.Path, "/api/now/table/incident/")
+			for k, v := range body {
+				records[id][k] = v
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": record(id)})
+		}
+	}))
+	defer server.Close()
+
+	globals := run(t, `
+let snow = ticket_connect("servicenow", "`+server.URL+`", {"username": "admin", "password": "pw", "fields": {"short_description": "[{{severity}}] {{title}} on {{host}}"}})
+report_create("r1", "Weekly scan", "", "corp")
+report_add_finding("r1", {"title": "SSH open", "severity": "high", "host": "web1"})
+report_add_finding("r1", {"title": "SSH open", "severity": "high", "host": "web2"})
+let first = ticket_sync_report(snow, "r1")
+let second = ticket_sync_report(snow, "r1")
+let fixed = ticket_sync(snow, {"title": "SSH open", "host": "web1", "severity": "high", "status": "fixed"})
+let found = ticket_find(snow, {"title": "SSH open", "host": "web2"})
+let missing = ticket_find(snow, "none")
+let same = ticket_fingerprint({"title": "SSH open", "host": "web1", "severity": "low"}) == fixed["fingerprint"]
+let summary = first[0]["action"] + " " + first[1]["action"] + " " + second[0]["action"] + " " + fixed["key"] + " " + fixed["transitioned"] + " " + found["key"]
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "created created updated INC001 6 INC002"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if !vmregister.IsNil(globals["missing"]) || !vmregister.IsTruthy(globals["same"]) {
+		t.Errorf("missing = %v, same = %v", globals["missing"], globals["same"])
+	}
+	if got := records["1"]["short_description"]; got != "[high] SSH open on web1" || records["1"]["urgency"] != "2" || records["1"]["state"] != "6" {
+		t.Errorf("record %v", records["1"])
+	}
+
+	expectErrors(t, map[string]string{
+		`ticket_connect("jira", "https://jira.example", {"token": "t"})`:                  "ticket_connect: jira needs a project",
+		`ticket_connect("jira", "https://jira.example", {"token": "t", "board": "SEC"})`:  "ticket_connect: unknown option 'board'",
+		`ticket_connect("jira", "https://jira.example", {"token": "t", "statuses": "x"})`: "ticket_connect: statuses must be a map, got string",
+		`ticket_sync("jira_0", {"title": "x"})`:                                           "ticket_sync: ticket tracker 'jira_0' not found",
+		`ticket_fingerprint({"severity": "high"}, ["title"])`:                             "ticket_fingerprint: nothing to fingerprint, the finding has none of title",
+		`ticket_fingerprint("x")`:                                                         "ticket_fingerprint: the finding must be a map, got string",
+	})
+}
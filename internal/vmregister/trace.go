@@ -0,0 +1,46 @@
+package vmregister
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// traceState logs executed instructions
+type traceState struct {
+	w       io.Writer
+	globals []string // Global names by ID
+	known   int      // Number of globals when globals was built
+}
+
+// SetTrace logs every instruction to w before it runs, with the function
+// and source line it belongs to and the call and register depth, or stops
+// logging if w is nil. Instructions are shown as by Disassemble.
+func (vm *RegisterVM) SetTrace(w io.Writer) {
+	if w == nil {
+		vm.trace = nil
+	} else {
+		vm.trace = &traceState{w: w}
+	}
+	vm.hooked = vm.trace != nil || vm.limits != nil
+}
+
+// traceInstruction logs the instruction at pc of the current frame
+func (vm *RegisterVM) traceInstruction(pc int, instr Instruction, consts []Value) {
+	t := vm.trace
+	if t.known != len(vm.globalNames) {
+		t.globals, t.known = vm.globalNamesByID(), len(vm.globalNames)
+	}
+
+	name, line := "<main>", ""
+	if vm.frameTop > 0 {
+		if fn := vm.frames[vm.frameTop-1].function; fn != nil {
+			name = fn.Name
+			if l := fn.line(pc); l != 0 {
+				line = strconv.Itoa(l)
+			}
+		}
+	}
+	fmt.Fprintf(t.w, "%-16s %5s  %04d  %-48s frames=%d regs=%d\n",
+		name, line, pc, formatInstruction(pc, instr, consts, t.globals), vm.frameTop, vm.regTop)
+}
@@ -1,10 +1,16 @@
 package vmregister
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"strings"
+	"sync"
 	"unsafe"
+
+	"sentra/internal/concurrency"
 )
 
 // NaN-Boxing Value Representation
@@ -31,7 +37,17 @@ import (
 type Value uint64
 
 // Global object cache to prevent Go's GC from collecting NaN-boxed pointers
-var globalObjectCache = make([]interface{}, 0, 1000)
+var (
+	globalObjectCache = make([]interface{}, 0, 1000)
+	globalObjectMu    sync.Mutex // Worker pool jobs run VMs on several goroutines
+)
+
+// keepAlive adds obj to the global object cache
+func keepAlive(obj interface{}) {
+	globalObjectMu.Lock()
+	globalObjectCache = append(globalObjectCache, obj)
+	globalObjectMu.Unlock()
+}
 
 // Masks and tags for NaN-boxing
 const (
@@ -78,6 +94,7 @@ const (
 	OBJ_CLASS      // Class definition
 	OBJ_INSTANCE   // Class instance
 	OBJ_FIBER      // Lightweight coroutine
+	OBJ_BYTES      // Immutable binary data
 )
 
 // Object header for all heap-allocated objects
@@ -95,22 +112,37 @@ type (
 		Hash  uint64
 	}
 
+	BytesObj struct {
+		Object
+		Data []byte
+	}
+
+	// Arrays and maps are not synchronized. They belong to the goroutine
+	// running the script, which is also the one that runs callbacks such
+	// as process output and signal handlers. Values that goroutines share
+	// go through the concurrent maps and queues of the concurrency package,
+	// which copy them, and worker pool jobs get copies, see jobHandler.
 	ArrayObj struct {
 		Object
 		Elements []Value
 		Methods  map[string]Value // Cached method objects (push, pop, etc.)
+		shared   bool             // Elements may be shared with a clone, see cloneValue
 	}
 
 	MapObj struct {
 		Object
-		Items map[string]Value
+		Items   map[string]Value
+		Methods map[string]Value // Struct methods, reached with a dot but not items
+		shared  bool             // Items may be shared with a clone, see cloneValue
 	}
 
 	FunctionObj struct {
 		Object
 		Name           string
 		Arity          int
+		Params         []string // Parameter names, for keyword arguments
 		Code           []Instruction // Register-based bytecode
+		Lines          []int32       // Source line of each instruction, if the compiler recorded them
 		Constants      []Value
 		ObjectRefs     []interface{} // GC-visible references to keep objects alive
 		Upvalues       []UpvalueDesc
@@ -153,8 +185,7 @@ type (
 
 	ChannelObj struct {
 		Object
-		Ch     chan Value
-		Closed bool
+		Channel *concurrency.Channel // Carries values converted by valueToGo
 	}
 
 	IteratorObj struct {
@@ -162,6 +193,8 @@ type (
 		Collection Value
 		Index      int
 		Keys       []string
+		Next       func() (Value, bool, error) // Produces the values of a lazy iterator, such as file_lines
+		Close      func()                      // Releases a lazy iterator left before its end
 	}
 
 	// OOP: Class definition
@@ -290,7 +323,18 @@ func BoxString(s string) Value {
 		Hash:   HashString(s),
 	}
 	// Add to global cache to prevent Go's GC from collecting it
-	globalObjectCache = append(globalObjectCache, obj)
+	keepAlive(obj)
+	return BoxPointer(unsafe.Pointer(obj))
+}
+
+// BoxBytes makes a bytes value of data, which must not be changed after
+func BoxBytes(data []byte) Value {
+	obj := &BytesObj{
+		Object: Object{Type: OBJ_BYTES},
+		Data:   data,
+	}
+	// Add to global cache to prevent Go's GC from collecting it
+	keepAlive(obj)
 	return BoxPointer(unsafe.Pointer(obj))
 }
 
@@ -300,7 +344,17 @@ func BoxArray(elements []Value) Value {
 		Elements: elements,
 	}
 	// Add to global cache to prevent Go's GC from collecting it
-	globalObjectCache = append(globalObjectCache, obj)
+	keepAlive(obj)
+	return BoxPointer(unsafe.Pointer(obj))
+}
+
+func BoxChannel(ch *concurrency.Channel) Value {
+	obj := &ChannelObj{
+		Object:  Object{Type: OBJ_CHANNEL},
+		Channel: ch,
+	}
+	// Add to global cache to prevent Go's GC from collecting it
+	keepAlive(obj)
 	return BoxPointer(unsafe.Pointer(obj))
 }
 
@@ -313,19 +367,19 @@ func BoxMap(items map[string]Value) Value {
 		Items:  items,
 	}
 	// Add to global cache to prevent Go's GC from collecting it
-	globalObjectCache = append(globalObjectCache, obj)
+	keepAlive(obj)
 	return BoxPointer(unsafe.Pointer(obj))
 }
 
 func BoxFunction(fn *FunctionObj) Value {
 	// Add to global cache to prevent Go's GC from collecting it
-	globalObjectCache = append(globalObjectCache, fn)
+	keepAlive(fn)
 	return BoxPointer(unsafe.Pointer(fn))
 }
 
 func BoxClosure(closure *ClosureObj) Value {
 	// Add to global cache to prevent Go's GC from collecting it
-	globalObjectCache = append(globalObjectCache, closure)
+	keepAlive(closure)
 	return BoxPointer(unsafe.Pointer(closure))
 }
 
@@ -377,6 +431,10 @@ func AsString(v Value) *StringObj {
 	return (*StringObj)(AsPointer(v))
 }
 
+func AsBytes(v Value) *BytesObj {
+	return (*BytesObj)(AsPointer(v))
+}
+
 func AsArray(v Value) *ArrayObj {
 	return (*ArrayObj)(AsPointer(v))
 }
@@ -409,6 +467,10 @@ func AsIterator(v Value) *IteratorObj {
 	return (*IteratorObj)(AsPointer(v))
 }
 
+func AsChannel(v Value) *ChannelObj {
+	return (*ChannelObj)(AsPointer(v))
+}
+
 // ============================================================================
 // Type Checking (Ultra-fast bit operations)
 // ============================================================================
@@ -460,6 +522,23 @@ func IsString(v Value) bool {
 	return IsPointer(v) && AsObject(v).Type == OBJ_STRING
 }
 
+func IsBytes(v Value) bool {
+	return IsPointer(v) && AsObject(v).Type == OBJ_BYTES
+}
+
+// NewLazyIterator makes an iterator a for loop consumes by calling next
+// until it reports no more values. close, if not nil, is called when the
+// loop is left early.
+func NewLazyIterator(next func() (Value, bool, error), close func()) Value {
+	obj := &IteratorObj{
+		Object: Object{Type: OBJ_ITERATOR},
+		Next:   next,
+		Close:  close,
+	}
+	keepAlive(obj)
+	return BoxPointer(unsafe.Pointer(obj))
+}
+
 func IsArray(v Value) bool {
 	return IsPointer(v) && AsObject(v).Type == OBJ_ARRAY
 }
@@ -473,6 +552,10 @@ func IsFunction(v Value) bool {
 	return IsPointer(v) && (obj.Type == OBJ_FUNCTION || obj.Type == OBJ_CLOSURE)
 }
 
+func IsNativeFn(v Value) bool {
+	return IsPointer(v) && AsObject(v).Type == OBJ_NATIVE_FN
+}
+
 func IsClosure(v Value) bool {
 	return IsPointer(v) && AsObject(v).Type == OBJ_CLOSURE
 }
@@ -481,6 +564,10 @@ func IsIterator(v Value) bool {
 	return IsPointer(v) && AsObject(v).Type == OBJ_ITERATOR
 }
 
+func IsChannel(v Value) bool {
+	return IsPointer(v) && AsObject(v).Type == OBJ_CHANNEL
+}
+
 // ============================================================================
 // Value Operations
 // ============================================================================
@@ -525,6 +612,10 @@ func ValueType(v Value) string {
 			return "instance"
 		case OBJ_FIBER:
 			return "fiber"
+		case OBJ_BYTES:
+			return "bytes"
+		case OBJ_ITERATOR:
+			return "iterator"
 		default:
 			return "object"
 		}
@@ -555,6 +646,9 @@ func IsTruthy(v Value) bool {
 	if IsMap(v) {
 		return len(AsMap(v).Items) > 0
 	}
+	if IsBytes(v) {
+		return len(AsBytes(v).Data) > 0
+	}
 	return true // Objects are truthy
 }
 
@@ -575,6 +669,16 @@ func ValuesEqual(a, b Value) bool {
 		return AsString(a).Value == AsString(b).Value
 	}
 
+	// Bytes comparison
+	if IsBytes(a) && IsBytes(b) {
+		return bytes.Equal(AsBytes(a).Data, AsBytes(b).Data)
+	}
+
+	// A channel passed through a builtin comes back in a new object
+	if IsChannel(a) && IsChannel(b) {
+		return AsChannel(a).Channel == AsChannel(b).Channel
+	}
+
 	// Array comparison
 	if IsArray(a) && IsArray(b) {
 		arrA := AsArray(a)
@@ -642,6 +746,98 @@ func ToInt(v Value) int64 {
 	return 0
 }
 
+// Slice returns a copy of the part of an array, string or bytes from start
+// up to but not including end. A null bound is open, a negative one counts
+// from the end, and bounds past either end are clamped, so x[:100] of a
+// short value is the whole value.
+func Slice(v, start, end Value) (Value, error) {
+	for _, bound := range []Value{start, end} {
+		if !IsNil(bound) && !IsInt(bound) && !IsNumber(bound) {
+			return NilValue(), fmt.Errorf("slice bounds must be numbers, got %s", ValueType(bound))
+		}
+	}
+	switch {
+	case IsArray(v):
+		elements := AsArray(v).Elements
+		lo, hi := sliceBounds(len(elements), start, end)
+		return BoxArray(append([]Value(nil), elements[lo:hi]...)), nil
+	case IsString(v):
+		str := AsString(v).Value
+		lo, hi := sliceBounds(len(str), start, end)
+		return BoxString(str[lo:hi]), nil
+	case IsBytes(v):
+		data := AsBytes(v).Data
+		lo, hi := sliceBounds(len(data), start, end)
+		return BoxBytes(data[lo:hi:hi]), nil
+	}
+	return NilValue(), fmt.Errorf("cannot slice %s", ValueType(v))
+}
+
+// sliceBounds resolves the bounds of a slice of a value of length n
+func sliceBounds(n int, start, end Value) (int, int) {
+	bound := func(b Value, open int) int {
+		if IsNil(b) {
+			return open
+		}
+		i := int(ToInt(b))
+		if i < 0 {
+			i += n
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > n {
+			return n
+		}
+		return i
+	}
+	lo, hi := bound(start, 0), bound(end, n)
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// ToBytes converts a string, array of byte values or bytes to bytes. A
+// string is read in encoding: "utf8" (the default for ""), "hex" or
+// "base64".
+func ToBytes(v Value, encoding string) (Value, error) {
+	switch {
+	case IsBytes(v):
+		return v, nil
+	case IsString(v):
+		s := AsString(v).Value
+		switch encoding {
+		case "", "utf8":
+			return BoxBytes([]byte(s)), nil
+		case "hex":
+			data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+			if err != nil {
+				return NilValue(), fmt.Errorf("invalid hex: %v", err)
+			}
+			return BoxBytes(data), nil
+		case "base64":
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return NilValue(), fmt.Errorf("invalid base64: %v", err)
+			}
+			return BoxBytes(data), nil
+		}
+		return NilValue(), fmt.Errorf("unknown encoding %q, expected utf8, hex or base64", encoding)
+	case IsArray(v):
+		elements := AsArray(v).Elements
+		data := make([]byte, len(elements))
+		for i, elem := range elements {
+			if !IsInt(elem) && !IsNumber(elem) || ToInt(elem) < 0 || ToInt(elem) > 255 {
+				return NilValue(), fmt.Errorf("element %d is not a byte: %s", i, ToString(elem))
+			}
+			data[i] = byte(ToInt(elem))
+		}
+		return BoxBytes(data), nil
+	}
+	return NilValue(), fmt.Errorf("cannot convert %s to bytes", ValueType(v))
+}
+
 // ToString converts a Value to string representation
 func ToString(v Value) string {
 	if IsNil(v) {
@@ -662,6 +858,10 @@ func ToString(v Value) string {
 	if IsString(v) {
 		return AsString(v).Value
 	}
+	if IsBytes(v) {
+		// Bytes read as text wherever a string is wanted
+		return string(AsBytes(v).Data)
+	}
 	if IsArray(v) {
 		arr := AsArray(v)
 		parts := make([]string, len(arr.Elements))
@@ -720,12 +920,17 @@ func HashString(s string) uint64 {
 // Small Integer Cache (common values)
 // ============================================================================
 
-var intCache [512]Value // Cache for -256 to +255
+var (
+	intCache     [512]Value // Cache for -256 to +255
+	intCacheOnce sync.Once
+)
 
 func InitIntCache() {
-	for i := -256; i <= 255; i++ {
-		intCache[i+256] = BoxInt(int64(i))
-	}
+	intCacheOnce.Do(func() {
+		for i := -256; i <= 255; i++ {
+			intCache[i+256] = BoxInt(int64(i))
+		}
+	})
 }
 
 func CachedInt(i int64) Value {
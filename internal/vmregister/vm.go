@@ -5,9 +5,15 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sentra/internal/concurrency"
 	"sentra/internal/jit"
+	"sentra/internal/limits"
+	"sentra/internal/sandbox"
+	stackvm "sentra/internal/vm"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -15,6 +21,22 @@ import (
 // This allows the VM to load modules without creating circular dependencies
 type ModuleLoader func(vm *RegisterVM, modulePath string) (*FunctionObj, error)
 
+// ModuleResolver maps an import path to a source file (e.g. a package
+// installed by the package manager). Returns "" if the path is unknown.
+type ModuleResolver func(modulePath string) string
+
+// ErrInterrupted is returned when execution is stopped by Interrupt
+var ErrInterrupted = fmt.Errorf("execution interrupted")
+
+// SkipError is raised by the skip() builtin to mark a test as skipped
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
 // nativeFibVM is the JIT-compiled native implementation of fibonacci
 // Used when the fib pattern is detected and compiled
 func nativeFibVM(n int64) int64 {
@@ -56,6 +78,7 @@ type RegisterVM struct {
 	globals       [65536]Value      // Global variables (array-indexed for performance)
 	globalNames   map[string]uint16 // Name → global ID mapping (for built-ins and debug)
 	nextGlobalID  uint16            // Next available global slot
+	firstUserGlobal uint16          // First slot after the builtins, see jobHandler
 	gcRoots       []interface{}     // GC roots: keep ALL runtime objects alive
 
 	// Inline caches for optimization
@@ -66,6 +89,7 @@ type RegisterVM struct {
 	modules       map[string]*ModuleObj
 	currentModule *ModuleObj
 	moduleLoader  ModuleLoader   // External module loader callback
+	moduleResolver ModuleResolver // Package-aware path resolver (optional)
 	modulePaths   []string       // Search paths for modules
 	currentFile   string         // Currently executing file (for relative imports)
 
@@ -132,6 +156,48 @@ type RegisterVM struct {
 	// Configuration
 	maxCallDepth int
 	jitThreshold int
+
+	// Set from another goroutine to stop execution, run signal handlers or
+	// take a profile sample (checked on calls and backward jumps)
+	interrupted int32
+
+	// Handlers registered with on_signal and on_exit
+	signals signalState
+
+	// Number of assert_* calls that passed
+	assertions int
+
+	// Globals replaced by Mock (testing)
+	mocks map[string]*mockEntry
+
+	// Line hit counters for code compiled with coverage probes
+	coverage *Coverage
+
+	// Debugger callback and the last statement probe reached by each frame
+	debugHook   DebugHook
+	debugProbes []int32
+
+	// Call stack sampler started by StartProfile
+	profile *profileState
+
+	// Instruction log enabled by SetTrace
+	trace *traceState
+
+	// Set while trace or limits is, see beforeInstruction
+	hooked bool
+
+	// Permissions checked by file, network and process builtins, see
+	// SetPermissions
+	permissions *sandbox.Policy
+
+	// The stack VM's builtins behind registerStackBuiltins, made on first use
+	stackOnce     sync.Once
+	stackBuiltins map[string]*stackvm.NativeFunction
+
+	// Resource limits set by StartLimits, and a memory error to raise from
+	// the instruction that would have exceeded them
+	limits   *limits.Tracker
+	limitErr error
 }
 
 // CallFrame represents a function call frame
@@ -146,6 +212,7 @@ type CallFrame struct {
 	numRegisters int           // Number of registers for this frame
 	returnReg    int           // Caller's register to store return value (absolute index)
 	wantResult   bool          // Whether caller wants the return value
+	stopOnReturn bool          // Return from run() when this frame returns (calls made from Go)
 }
 
 // TryFrame for exception handling
@@ -202,6 +269,7 @@ func NewRegisterVM() *RegisterVM {
 
 	// Register standard library functions
 	vm.RegisterStdlib()
+	vm.firstUserGlobal = vm.nextGlobalID
 
 	return vm
 }
@@ -211,6 +279,12 @@ func (vm *RegisterVM) SetModuleLoader(loader ModuleLoader) {
 	vm.moduleLoader = loader
 }
 
+// SetModuleResolver sets the resolver consulted for package imports
+// before the module search paths
+func (vm *RegisterVM) SetModuleResolver(resolver ModuleResolver) {
+	vm.moduleResolver = resolver
+}
+
 // SetModulePaths sets the search paths for finding modules
 func (vm *RegisterVM) SetModulePaths(paths []string) {
 	vm.modulePaths = paths
@@ -221,6 +295,22 @@ func (vm *RegisterVM) SetCurrentFile(path string) {
 	vm.currentFile = path
 }
 
+// SetArgs sets the args global to the command line arguments passed to the
+// script after its file name
+func (vm *RegisterVM) SetArgs(args []string) {
+	elements := make([]Value, len(args))
+	for i, arg := range args {
+		elements[i] = BoxString(arg)
+	}
+	id, ok := vm.globalNames["args"]
+	if !ok {
+		id = vm.nextGlobalID
+		vm.globalNames["args"] = id
+		vm.nextGlobalID++
+	}
+	vm.globals[id] = BoxArray(elements)
+}
+
 // GetGlobals returns a map view of globals for debugging
 func (vm *RegisterVM) GetGlobals() map[string]Value {
 	result := make(map[string]Value)
@@ -232,6 +322,13 @@ func (vm *RegisterVM) GetGlobals() map[string]Value {
 
 // GetGlobalNames returns the global name->ID mapping for the compiler
 func (vm *RegisterVM) GetGlobalNames() (map[string]uint16, uint16) {
+	// Compilers add names to the shared map directly, so skip past any IDs
+	// they assigned (e.g. by an earlier REPL line or the importing file)
+	for _, id := range vm.globalNames {
+		if id >= vm.nextGlobalID {
+			vm.nextGlobalID = id + 1
+		}
+	}
 	return vm.globalNames, vm.nextGlobalID
 }
 
@@ -311,6 +408,9 @@ func (vm *RegisterVM) PrintJITStats() {
 }
 
 func (vm *RegisterVM) Execute(fn *FunctionObj, args []Value) (Value, error) {
+	// A stale Interrupt from a previous run must not stop this one
+	vm.clearInterrupt()
+
 	// JIT profiling and compilation
 	if vm.jitEnabled && vm.jitProfiler != nil {
 		jitFn := vm.getOrCreateJITFunction(fn)
@@ -372,6 +472,97 @@ func (vm *RegisterVM) Execute(fn *FunctionObj, args []Value) (Value, error) {
 	return vm.run()
 }
 
+// Call invokes a Sentra function, closure, or native function from Go.
+// It is used to run functions defined by a script after Execute returns.
+func (vm *RegisterVM) Call(fn Value, args []Value) (Value, error) {
+	vm.clearInterrupt()
+	if vm.frameTop == 0 {
+		// A previous call may have failed inside a try block
+		vm.tryStack = vm.tryStack[:0]
+	}
+	return vm.callValue(fn, args)
+}
+
+// callValue calls any callable value, including from inside a native function
+func (vm *RegisterVM) callValue(fn Value, args []Value) (Value, error) {
+	if !IsPointer(fn) {
+		return NilValue(), fmt.Errorf("cannot call %s", ValueType(fn))
+	}
+	switch AsObject(fn).Type {
+	case OBJ_CLOSURE:
+		return vm.callClosure(AsClosure(fn), args)
+	case OBJ_FUNCTION:
+		return vm.callFunction(AsFunction(fn), args)
+	case OBJ_NATIVE_FN:
+		return AsNativeFn(fn).Function(args)
+	}
+	return NilValue(), fmt.Errorf("cannot call %s", ValueType(fn))
+}
+
+// bindKeywords orders the arguments of a call whose last len(names)
+// arguments are keyword arguments: each goes to the position of the
+// callee's parameter of that name, after the positional ones. Parameters
+// left out in between are null. Natives take the parameter names of their
+// documentation.
+func bindKeywords(fn Value, args []Value, names []string) ([]Value, error) {
+	if !IsPointer(fn) {
+		return nil, fmt.Errorf("cannot call %s", ValueType(fn))
+	}
+	var name string
+	var params []string
+	variadic := false
+	switch AsObject(fn).Type {
+	case OBJ_CLOSURE:
+		f := AsClosure(fn).Function
+		name, params, variadic = f.Name, f.Params, f.IsVariadic
+	case OBJ_FUNCTION:
+		f := AsFunction(fn)
+		name, params, variadic = f.Name, f.Params, f.IsVariadic
+	case OBJ_NATIVE_FN:
+		name = AsNativeFn(fn).Name
+		if params = nativeParams(name); params == nil {
+			return nil, fmt.Errorf("%s does not take keyword arguments", name)
+		}
+	default:
+		return nil, fmt.Errorf("cannot call %s", ValueType(fn))
+	}
+
+	positional := len(args) - len(names)
+	bound := append([]Value(nil), args[:positional]...)
+	for i, kw := range names {
+		pos := -1
+		for j, param := range params {
+			if param == kw {
+				pos = j
+				break
+			}
+		}
+		if pos < 0 || variadic && pos == len(params)-1 {
+			return nil, fmt.Errorf("%s has no parameter named '%s'", name, kw)
+		}
+		if pos < positional {
+			return nil, fmt.Errorf("%s got argument '%s' twice", name, kw)
+		}
+		for len(bound) <= pos {
+			bound = append(bound, NilValue())
+		}
+		bound[pos] = args[positional+i]
+	}
+	return bound, nil
+}
+
+// Interrupt stops the running script at the next call or loop iteration.
+// Safe to call from another goroutine.
+func (vm *RegisterVM) Interrupt() {
+	atomic.StoreInt32(&vm.interrupted, interruptRequested)
+	vm.wake()
+}
+
+// Assertions returns the number of assert_* calls that have passed
+func (vm *RegisterVM) Assertions() int {
+	return vm.assertions
+}
+
 // run is the main execution loop with direct-threaded dispatch
 func (vm *RegisterVM) run() (Value, error) {
 	// ============================================================================
@@ -407,6 +598,21 @@ func (vm *RegisterVM) run() (Value, error) {
 	for pc < codeLen {
 		// Fetch and decode
 		instr := code[pc]
+		if vm.hooked {
+			if err := vm.beforeInstruction(pc, instr, consts); err != nil {
+				if !vm.catchLimit(err) {
+					return NilValue(), err
+				}
+				code, consts, pc = vm.code, vm.consts, vm.pc
+				codeLen = len(code)
+				regBase = 0
+				if vm.frameTop > 0 {
+					regBase = vm.frames[vm.frameTop-1].regBase
+				}
+				regs = registers[regBase:]
+				continue
+			}
+		}
 		pc++
 		op := instr.OpCode()
 
@@ -440,8 +646,17 @@ func (vm *RegisterVM) run() (Value, error) {
 				regs[a] = BoxNumber(ToNumber(rb) + ToNumber(rc))
 			} else if IsString(rb) || IsString(rc) {
 				// SLOW PATH: String concatenation
+				if vm.limits != nil {
+					if vm.limitErr = vm.reserve(stringSize(rb) + stringSize(rc)); vm.limitErr != nil {
+						pc--
+						continue
+					}
+				}
 				result := BoxString(ToString(rb) + ToString(rc))
 				regs[a] = result
+			} else if IsBytes(rb) && IsBytes(rc) {
+				x, y := AsBytes(rb).Data, AsBytes(rc).Data
+				regs[a] = BoxBytes(append(x[:len(x):len(x)], y...))
 			} else {
 				return NilValue(), fmt.Errorf("cannot add %s and %s", ValueType(rb), ValueType(rc))
 			}
@@ -494,6 +709,12 @@ func (vm *RegisterVM) run() (Value, error) {
 			} else if IsString(rb) && (IsInt(rc) || IsNumber(rc)) {
 				str := AsString(rb).Value
 				count := int(ToInt(rc))
+				if vm.limits != nil && count > 0 {
+					if vm.limitErr = vm.reserve(len(str) * count); vm.limitErr != nil {
+						pc--
+						continue
+					}
+				}
 				regs[a] = BoxString(strings.Repeat(str, count))
 			} else {
 				return NilValue(), fmt.Errorf("cannot multiply %s and %s", ValueType(rb), ValueType(rc))
@@ -514,6 +735,7 @@ func (vm *RegisterVM) run() (Value, error) {
 						if vm.frameTop > tryFrame.frameDepth {
 							vm.frameTop = tryFrame.frameDepth
 						}
+						vm.regTop = tryFrame.regTop
 						code = tryFrame.code
 						codeLen = len(code)
 						consts = tryFrame.consts
@@ -543,6 +765,7 @@ func (vm *RegisterVM) run() (Value, error) {
 					if vm.frameTop > tryFrame.frameDepth {
 						vm.frameTop = tryFrame.frameDepth
 					}
+					vm.regTop = tryFrame.regTop
 					code = tryFrame.code
 					codeLen = len(code)
 					consts = tryFrame.consts
@@ -672,6 +895,8 @@ func (vm *RegisterVM) run() (Value, error) {
 				regs[a] = BoxInt(result)
 			} else if IsNumber(rb) {
 				regs[a] = BoxNumber(AsNumber(rb) + float64(c))
+			} else if IsString(rb) {
+				regs[a] = BoxString(AsString(rb).Value + strconv.Itoa(int(c)))
 			} else {
 				return NilValue(), fmt.Errorf("cannot add %s and int", ValueType(rb))
 			}
@@ -845,6 +1070,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			// FAST PATH: Direct array write with auto-grow
 			arr := AsArray(arrVal)
 			idx := int(AsInt(idxVal))
+			arr.own()
 			// Grow array if needed
 			for len(arr.Elements) <= idx {
 				arr.Elements = append(arr.Elements, NilValue())
@@ -866,8 +1092,10 @@ func (vm *RegisterVM) run() (Value, error) {
 			} else if IsMap(val) {
 				m := AsMap(val)
 				regs[a] = BoxInt(int64(len(m.Items)))
+			} else if IsBytes(val) {
+				regs[a] = BoxInt(int64(len(AsBytes(val).Data)))
 			} else {
-				return NilValue(), fmt.Errorf("ARRLEN: expected string, array, or map, got %s", ValueType(val))
+				return NilValue(), fmt.Errorf("ARRLEN: expected string, array, map or bytes, got %s", ValueType(val))
 			}
 
 		// ====================================================================
@@ -888,7 +1116,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			// FASTEST PATH: Both integers - fully inlined comparison
 			if (rb & rc & TAG_MASK) == TAG_INT {
-				if int64(rb&INT_MASK) < int64(rc&INT_MASK) {
+				if AsInt(rb) < AsInt(rc) {
 					regs[a] = TAG_TRUE
 				} else {
 					regs[a] = TAG_FALSE
@@ -909,7 +1137,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			// FASTEST PATH: Both integers - fully inlined
 			if (rb & rc & TAG_MASK) == TAG_INT {
-				if int64(rb&INT_MASK) <= int64(rc&INT_MASK) {
+				if AsInt(rb) <= AsInt(rc) {
 					regs[a] = TAG_TRUE
 				} else {
 					regs[a] = TAG_FALSE
@@ -930,7 +1158,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			// FASTEST PATH: Both integers - fully inlined
 			if (rb & rc & TAG_MASK) == TAG_INT {
-				if int64(rb&INT_MASK) > int64(rc&INT_MASK) {
+				if AsInt(rb) > AsInt(rc) {
 					regs[a] = TAG_TRUE
 				} else {
 					regs[a] = TAG_FALSE
@@ -951,7 +1179,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			// FASTEST PATH: Both integers - fully inlined
 			if (rb & rc & TAG_MASK) == TAG_INT {
-				if int64(rb&INT_MASK) >= int64(rc&INT_MASK) {
+				if AsInt(rb) >= AsInt(rc) {
 					regs[a] = TAG_TRUE
 				} else {
 					regs[a] = TAG_FALSE
@@ -1095,6 +1323,22 @@ func (vm *RegisterVM) run() (Value, error) {
 				} else {
 					regs[a] = NilValue()
 				}
+			} else if IsModule(table) {
+				mod := AsModule(table)
+				val, ok := mod.Exports[ToString(key)]
+				if !ok {
+					return NilValue(), fmt.Errorf("module %s has no export '%s'", mod.Name, ToString(key))
+				}
+				regs[a] = val
+			} else if IsBytes(table) {
+				// Indexing bytes gives the byte as an int, like an array
+				data := AsBytes(table).Data
+				idx := int(ToInt(key))
+				if idx >= 0 && idx < len(data) {
+					regs[a] = BoxInt(int64(data[idx]))
+				} else {
+					regs[a] = NilValue()
+				}
 			} else {
 				// Check for try-catch handler
 				if len(vm.tryStack) > 0 {
@@ -1104,6 +1348,7 @@ func (vm *RegisterVM) run() (Value, error) {
 					if vm.frameTop > tryFrame.frameDepth {
 						vm.frameTop = tryFrame.frameDepth
 					}
+					vm.regTop = tryFrame.regTop
 					code = tryFrame.code
 					codeLen = len(code)
 					consts = tryFrame.consts
@@ -1124,6 +1369,14 @@ func (vm *RegisterVM) run() (Value, error) {
 				return NilValue(), fmt.Errorf("cannot index %s", ValueType(table))
 			}
 
+		case OP_SLICE:
+			a, b, c := instr.A(), instr.B(), instr.C()
+			result, err := Slice(regs[b], regs[c], regs[c+1])
+			if err != nil {
+				return NilValue(), err
+			}
+			regs[a] = result
+
 		case OP_SETTABLE:
 			a, b, c := instr.A(), instr.B(), instr.C()
 			table := regs[a]
@@ -1133,6 +1386,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			if IsArray(table) {
 				arr := AsArray(table)
 				idx := int(ToInt(key))
+				arr.own()
 				// Grow array if needed
 				for len(arr.Elements) <= idx {
 					arr.Elements = append(arr.Elements, NilValue())
@@ -1147,6 +1401,7 @@ func (vm *RegisterVM) run() (Value, error) {
 				} else {
 					keyStr = ToString(key)
 				}
+				m.own()
 				m.Items[keyStr] = value
 			} else {
 				return NilValue(), fmt.Errorf("cannot index assign %s", ValueType(table))
@@ -1156,16 +1411,27 @@ func (vm *RegisterVM) run() (Value, error) {
 			// GETTABLEK R(A) R(B) K(C)  - R(A) = R(B)[K(C)] (constant key optimization)
 			a, b, c := instr.A(), instr.B(), instr.C()
 			table := regs[b]
-			key := vm.consts[c]
+			key := consts[c]
 
 			if IsArray(table) {
 				arr := AsArray(table)
+				if IsString(key) {
+					// items.length, or a method such as items.push
+					if name := AsString(key).Value; name == "length" {
+						regs[a] = BoxInt(int64(len(arr.Elements)))
+					} else {
+						regs[a] = vm.arrayMethod(arr, name)
+					}
+					break
+				}
 				idx := int(ToInt(key))
 				if idx >= 0 && idx < len(arr.Elements) {
 					regs[a] = arr.Elements[idx]
 				} else {
 					regs[a] = NilValue()
 				}
+			} else if IsString(table) && IsString(key) && AsString(key).Value == "length" {
+				regs[a] = BoxInt(int64(len(AsString(table).Value)))
 			} else if IsMap(table) {
 				m := AsMap(table)
 				// OPTIMIZED: Fast path for string keys (constant keys are usually strings)
@@ -1177,9 +1443,18 @@ func (vm *RegisterVM) run() (Value, error) {
 				}
 				if val, ok := m.Items[keyStr]; ok {
 					regs[a] = val
+				} else if method, ok := m.Methods[keyStr]; ok {
+					regs[a] = method
 				} else {
 					regs[a] = NilValue()
 				}
+			} else if IsModule(table) {
+				mod := AsModule(table)
+				val, ok := mod.Exports[ToString(key)]
+				if !ok {
+					return NilValue(), fmt.Errorf("module %s has no export '%s'", mod.Name, ToString(key))
+				}
+				regs[a] = val
 			} else {
 				return NilValue(), fmt.Errorf("cannot index %s", ValueType(table))
 			}
@@ -1188,12 +1463,13 @@ func (vm *RegisterVM) run() (Value, error) {
 			// SETTABLEK R(A) K(B) R(C)  - R(A)[K(B)] = R(C) (constant key optimization)
 			a, b, c := instr.A(), instr.B(), instr.C()
 			table := regs[a]
-			key := vm.consts[b]
+			key := consts[b]
 			value := regs[c]
 
 			if IsArray(table) {
 				arr := AsArray(table)
 				idx := int(ToInt(key))
+				arr.own()
 				// Grow array if needed
 				for len(arr.Elements) <= idx {
 					arr.Elements = append(arr.Elements, NilValue())
@@ -1208,6 +1484,7 @@ func (vm *RegisterVM) run() (Value, error) {
 				} else {
 					keyStr = ToString(key)
 				}
+				m.own()
 				m.Items[keyStr] = value
 			} else {
 				return NilValue(), fmt.Errorf("cannot index assign %s", ValueType(table))
@@ -1221,6 +1498,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			idx1 := int(AsInt(regs[b]))
 			idx2 := int(AsInt(regs[c]))
 			if idx1 >= 0 && idx1 < len(arr.Elements) && idx2 >= 0 && idx2 < len(arr.Elements) {
+				arr.own()
 				arr.Elements[idx1], arr.Elements[idx2] = arr.Elements[idx2], arr.Elements[idx1]
 			}
 
@@ -1239,6 +1517,8 @@ func (vm *RegisterVM) run() (Value, error) {
 				keyStr := ToString(key)
 				if val, ok := m.Items[keyStr]; ok {
 					regs[a] = val
+				} else if method, ok := m.Methods[keyStr]; ok {
+					regs[a] = method
 				} else {
 					regs[a] = NilValue()
 				}
@@ -1256,6 +1536,8 @@ func (vm *RegisterVM) run() (Value, error) {
 				regs[a] = BoxInt(int64(len(AsMap(rb).Items)))
 			} else if IsString(rb) {
 				regs[a] = BoxInt(int64(len(AsString(rb).Value)))
+			} else if IsBytes(rb) {
+				regs[a] = BoxInt(int64(len(AsBytes(rb).Data)))
 			} else {
 				return NilValue(), fmt.Errorf("cannot get length of %s", ValueType(rb))
 			}
@@ -1268,6 +1550,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			if IsArray(arr) {
 				arrObj := AsArray(arr)
+				arrObj.own()
 				arrObj.Elements = append(arrObj.Elements, value)
 			} else {
 				return NilValue(), fmt.Errorf("cannot append to %s", ValueType(arr))
@@ -1289,6 +1572,7 @@ func (vm *RegisterVM) run() (Value, error) {
 				// Get last element
 				regs[a] = arrObj.Elements[len(arrObj.Elements)-1]
 				// Remove it
+				arrObj.own()
 				arrObj.Elements = arrObj.Elements[:len(arrObj.Elements)-1]
 			}
 
@@ -1308,6 +1592,7 @@ func (vm *RegisterVM) run() (Value, error) {
 				// Get first element
 				regs[a] = arrObj.Elements[0]
 				// Remove it
+				arrObj.own()
 				arrObj.Elements = arrObj.Elements[1:]
 			}
 
@@ -1323,6 +1608,7 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			arrObj := AsArray(arr)
 			// Prepend value to array
+			arrObj.own()
 			arrObj.Elements = append([]Value{value}, arrObj.Elements...)
 
 		case OP_CONCAT:
@@ -1330,6 +1616,13 @@ func (vm *RegisterVM) run() (Value, error) {
 			a, b, c := instr.A(), instr.B(), instr.C()
 			rb, rc := regs[b], regs[c]
 
+			if vm.limits != nil {
+				if vm.limitErr = vm.reserve(stringSize(rb) + stringSize(rc)); vm.limitErr != nil {
+					pc--
+					continue
+				}
+			}
+
 			// Convert both to strings and concatenate
 			regs[a] = BoxString(ToString(rb) + ToString(rc))
 
@@ -1495,6 +1788,12 @@ func (vm *RegisterVM) run() (Value, error) {
 			// STRCAT R(A) R(B) R(C)  - R(A) = str(R(B)) .. str(R(C))
 			a, b, c := instr.A(), instr.B(), instr.C()
 			rb, rc := regs[b], regs[c]
+			if vm.limits != nil {
+				if vm.limitErr = vm.reserve(stringSize(rb) + stringSize(rc)); vm.limitErr != nil {
+					pc--
+					continue
+				}
+			}
 			regs[a] = BoxString(ToString(rb) + ToString(rc))
 
 		case OP_STRLEN:
@@ -1541,6 +1840,12 @@ func (vm *RegisterVM) run() (Value, error) {
 			// Strategy: Profile first 100 iterations, then PATCH bytecode
 			// Once patched to OP_JMP_HOT, there's ZERO profiling overhead!
 
+			if offset < 0 && atomic.LoadInt32(&vm.interrupted) != 0 {
+				if err := vm.checkInterrupt(); err != nil {
+					return NilValue(), err
+				}
+			}
+
 			if offset < 0 && vm.jitEnabled {
 				// BACKWARD JUMP = LOOP!
 				loopStartPC := pc + offset  // Where loop begins
@@ -1926,7 +2231,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			ra, kb := regs[a], consts[b]
 			// ULTRA FAST: Direct bit comparison
 			if (ra & kb & TAG_MASK) == TAG_INT {
-				if int64(ra&INT_MASK) < int64(kb&INT_MASK) {
+				if AsInt(ra) < AsInt(kb) {
 					pc += int(int8(c))
 				}
 			} else if (IsNumber(ra) || IsInt(ra)) && (IsNumber(kb) || IsInt(kb)) {
@@ -1942,7 +2247,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			ra, kb := regs[a], consts[b]
 			// ULTRA FAST: Both integers with direct bit comparison
 			if (ra & kb & TAG_MASK) == TAG_INT {
-				if int64(ra&INT_MASK) <= int64(kb&INT_MASK) {
+				if AsInt(ra) <= AsInt(kb) {
 					pc += int(int8(c))
 				}
 			} else if (IsNumber(ra) || IsInt(ra)) && (IsNumber(kb) || IsInt(kb)) {
@@ -1957,7 +2262,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			ra, kb := regs[a], consts[b]
 			// ULTRA FAST: Direct bit comparison
 			if (ra & kb & TAG_MASK) == TAG_INT {
-				if int64(ra&INT_MASK) > int64(kb&INT_MASK) {
+				if AsInt(ra) > AsInt(kb) {
 					pc += int(int8(c))
 				}
 			} else if (IsNumber(ra) || IsInt(ra)) && (IsNumber(kb) || IsInt(kb)) {
@@ -1972,7 +2277,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			ra, kb := regs[a], consts[b]
 			// ULTRA FAST: Direct bit comparison
 			if (ra & kb & TAG_MASK) == TAG_INT {
-				if int64(ra&INT_MASK) >= int64(kb&INT_MASK) {
+				if AsInt(ra) >= AsInt(kb) {
 					pc += int(int8(c))
 				}
 			} else if (IsNumber(ra) || IsInt(ra)) && (IsNumber(kb) || IsInt(kb)) {
@@ -2010,9 +2315,9 @@ func (vm *RegisterVM) run() (Value, error) {
 
 			// ULTRA FAST: Integer fast path (most common case)
 			if (ra & regs[a+1] & regs[a+2] & TAG_MASK) == TAG_INT {
-				counter := int64(ra & INT_MASK)
-				limit := int64(regs[a+1] & INT_MASK)
-				step := int64(regs[a+2] & INT_MASK)
+				counter := AsInt(ra)
+				limit := AsInt(regs[a+1])
+				step := AsInt(regs[a+2])
 
 				counter += step
 				// Inline BoxInt for positive integers
@@ -2056,11 +2361,59 @@ func (vm *RegisterVM) run() (Value, error) {
 		// Function Operations
 		// ====================================================================
 
+		case OP_TAILCALL:
+			// TAILCALL R(A) B C  - return R(A)(R(A+1)...R(A+B-1)). A Sentra
+			// function runs in the current frame, so tail recursion does not
+			// grow the call stack; anything else is called as by CALL for
+			// the RETURN that follows.
+			if callee, closure := tailCallee(regs[instr.A()]); callee != nil && vm.frameTop > 1 {
+				frame := vm.frames[vm.frameTop-1]
+				if newRegTop := frame.regBase + callee.Arity + 64; newRegTop <= len(registers) {
+					if atomic.LoadInt32(&vm.interrupted) != 0 {
+						if err := vm.checkInterrupt(); err != nil {
+							return NilValue(), err
+						}
+					}
+					argBase, numArgs := int(instr.A())+1, int(instr.B())-1
+					args := regs[argBase : argBase+numArgs]
+					var rest Value
+					if callee.IsVariadic {
+						rest = restArgs(args, callee.Arity-1)
+					}
+					copy(regs[:min(numArgs, callee.Arity)], args)
+					for i := numArgs; i < callee.Arity; i++ {
+						regs[i] = NilValue()
+					}
+					if callee.IsVariadic {
+						regs[callee.Arity-1] = rest
+					}
+
+					frame.function = callee
+					frame.closure = closure
+					frame.code = callee.Code
+					frame.consts = callee.Constants
+					frame.regTop = newRegTop
+					vm.regTop = newRegTop
+					code = callee.Code
+					codeLen = len(code)
+					consts = callee.Constants
+					pc = 0
+					continue
+				}
+			}
+			fallthrough
+
 		case OP_CALL:
 			a, b, c := instr.A(), instr.B(), instr.C()
 			fn := regs[a]
 			numArgs := int(b) - 1
 
+			if atomic.LoadInt32(&vm.interrupted) != 0 {
+				if err := vm.checkInterrupt(); err != nil {
+					return NilValue(), err
+				}
+			}
+
 			// ================================================================
 			// ULTRA-OPTIMIZED CALL PATH
 			// ================================================================
@@ -2125,6 +2478,17 @@ func (vm *RegisterVM) run() (Value, error) {
 				calleeConsts := calleeFn.Constants
 				calleeArity := calleeFn.Arity
 
+				if vm.frameTop >= vm.maxCallDepth || vm.regTop+calleeArity+64 > len(registers) {
+					if err := vm.callTooDeep(calleeFn.Name); err != nil {
+						return NilValue(), err
+					}
+					code, consts, pc = vm.code, vm.consts, vm.pc
+					codeLen = len(code)
+					regBase = vm.frames[vm.frameTop-1].regBase
+					regs = registers[regBase:]
+					continue
+				}
+
 				// Save current frame state (code/consts/pc)
 				callerFrame := vm.frames[vm.frameTop-1]
 				callerFrame.pc = pc
@@ -2154,6 +2518,13 @@ func (vm *RegisterVM) run() (Value, error) {
 						vm.registers[newBase+i] = regs[argBase+i]
 					}
 				}
+				// Parameters without an argument are null
+				for i := numArgs; i < calleeArity; i++ {
+					vm.registers[newBase+i] = NilValue()
+				}
+				if calleeFn.IsVariadic {
+					vm.registers[newBase+calleeArity-1] = restArgs(regs[argBase:argBase+numArgs], calleeArity-1)
+				}
 
 				// Push frame and switch (minimized operations)
 				vm.frameTop++
@@ -2169,6 +2540,16 @@ func (vm *RegisterVM) run() (Value, error) {
 			} else if objType == OBJ_FUNCTION {
 				// Regular function call
 				fnObj := AsFunction(fn)
+				if vm.frameTop >= vm.maxCallDepth || vm.regTop+fnObj.Arity+64 > len(registers) {
+					if err := vm.callTooDeep(fnObj.Name); err != nil {
+						return NilValue(), err
+					}
+					code, consts, pc = vm.code, vm.consts, vm.pc
+					codeLen = len(code)
+					regBase = vm.frames[vm.frameTop-1].regBase
+					regs = registers[regBase:]
+					continue
+				}
 
 				// Save current frame state
 				if vm.frameTop > 0 {
@@ -2200,6 +2581,12 @@ func (vm *RegisterVM) run() (Value, error) {
 						vm.registers[newBase+i] = regs[argBase+i]
 					}
 				}
+				for i := numArgs; i < fnObj.Arity; i++ {
+					vm.registers[newBase+i] = NilValue()
+				}
+				if fnObj.IsVariadic {
+					vm.registers[newBase+fnObj.Arity-1] = restArgs(regs[argBase:argBase+numArgs], fnObj.Arity-1)
+				}
 
 				// Push frame and switch - OPTIMIZED: skip redundant vm.* updates
 				vm.frameTop++
@@ -2230,8 +2617,21 @@ func (vm *RegisterVM) run() (Value, error) {
 					}
 				}
 				result, err := nativeFn.Function(args)
+				if vm.profile != nil {
+					vm.sampleNative(nativeFn.Name)
+				}
 				if err != nil {
-					return NilValue(), err
+					if !vm.catchLimit(err) {
+						return NilValue(), err
+					}
+					code, consts, pc = vm.code, vm.consts, vm.pc
+					codeLen = len(code)
+					regBase = 0
+					if vm.frameTop > 0 {
+						regBase = vm.frames[vm.frameTop-1].regBase
+					}
+					regs = registers[regBase:]
+					continue
 				}
 				if c > 1 {
 					regs[a] = result
@@ -2242,6 +2642,22 @@ func (vm *RegisterVM) run() (Value, error) {
 				return NilValue(), fmt.Errorf("cannot call %s", ValueType(fn))
 			}
 
+		case OP_CALLKW:
+			a, b, c := int(instr.A()), int(instr.B()), instr.C()
+			fn := regs[a]
+			names := strings.Split(AsString(regs[a+b]).Value, ",")
+			args, err := bindKeywords(fn, regs[a+1:a+b], names)
+			if err != nil {
+				return NilValue(), err
+			}
+			result, err := vm.callValue(fn, args)
+			if err != nil {
+				return NilValue(), err
+			}
+			if c > 1 {
+				regs[a] = result
+			}
+
 		case OP_RETURN:
 			a, b := instr.A(), instr.B()
 
@@ -2260,7 +2676,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			vm.frameTop--
 
 			// FAST PATH: Return to caller (most common case)
-			if vm.frameTop > 0 {
+			if vm.frameTop > 0 && !currentFrame.stopOnReturn {
 				callerFrame := vm.frames[vm.frameTop-1]
 
 				// Store return value if caller wants it
@@ -2283,84 +2699,6 @@ func (vm *RegisterVM) run() (Value, error) {
 			// Return from main function - exit
 			return returnVal, nil
 
-		case OP_TAILCALL:
-			// TAILCALL R(A) B  - return R(A)(R(A+1)...R(A+B-1)) (tail call optimization)
-			a, b := instr.A(), instr.B()
-			fn := regs[a]
-			numArgs := int(b) - 1
-
-			// For tail call, reuse current frame instead of creating new one
-			if IsFunction(fn) {
-				fnObj := AsFunction(fn)
-				// Replace current function with the tail-called function
-				code = fnObj.Code
-				codeLen = len(code)
-				consts = fnObj.Constants
-				pc = 0
-				vm.code = code
-				vm.consts = consts
-				vm.pc = 0
-
-				// OPTIMIZED: Copy arguments directly (no intermediate slice)
-				argBase := int(a) + 1
-				for i := 0; i < numArgs; i++ {
-					regs[uint8(i)] = regs[argBase+i]
-				}
-
-				// Continue execution (no return, just jump to start of new function)
-				continue
-			} else if IsPointer(fn) && AsObject(fn).Type == OBJ_CLOSURE {
-				// Closure tail call - reuse frame
-				closureObj := AsClosure(fn)
-				calleeFn := closureObj.Function
-
-				code = calleeFn.Code
-				codeLen = len(code)
-				consts = calleeFn.Constants
-				pc = 0
-				vm.code = code
-				vm.consts = consts
-				vm.pc = 0
-
-				// Update frame's closure reference
-				if vm.frameTop > 0 {
-					vm.frames[vm.frameTop-1].closure = closureObj
-					vm.frames[vm.frameTop-1].function = calleeFn
-				}
-
-				// OPTIMIZED: Copy arguments directly (no intermediate slice)
-				argBase := int(a) + 1
-				for i := 0; i < numArgs; i++ {
-					regs[uint8(i)] = regs[argBase+i]
-				}
-
-				// Continue execution
-				continue
-			} else if IsPointer(fn) && AsObject(fn).Type == OBJ_NATIVE_FN {
-				// Native functions can't be tail-called, just call normally
-				nativeFn := AsNativeFn(fn)
-				// OPTIMIZED: Use pre-allocated buffer
-				var args []Value
-				if numArgs <= 16 {
-					for i := 0; i < numArgs; i++ {
-						vm.argsBuffer[i] = regs[a+1+uint8(i)]
-					}
-					args = vm.argsBuffer[:numArgs]
-				} else {
-					args = make([]Value, numArgs)
-					for i := 0; i < numArgs; i++ {
-						args[i] = regs[a+1+uint8(i)]
-					}
-				}
-				result, err := nativeFn.Function(args)
-				if err != nil {
-					return NilValue(), err
-				}
-				return result, nil
-			} else {
-				return NilValue(), fmt.Errorf("cannot call %s", ValueType(fn))
-			}
-
 		// ====================================================================
 		// Type Operations
 		// ====================================================================
@@ -2418,8 +2756,8 @@ func (vm *RegisterVM) run() (Value, error) {
 				catchPC:    catchPC,
 				regTop:     vm.regTop,
 				frameDepth: vm.frameTop,
-				code:       vm.code,    // Save current code context
-				consts:     vm.consts,  // Save current constants
+				code:       code,   // Save current code context
+				consts:     consts, // Save current constants
 			}
 			vm.tryStack = append(vm.tryStack, tryFrame)
 
@@ -2447,6 +2785,7 @@ func (vm *RegisterVM) run() (Value, error) {
 				if vm.frameTop > tryFrame.frameDepth {
 					vm.frameTop = tryFrame.frameDepth
 				}
+				vm.regTop = tryFrame.regTop
 
 				// Restore code context (for cross-function throws)
 				code = tryFrame.code
@@ -2523,7 +2862,7 @@ func (vm *RegisterVM) run() (Value, error) {
 		case OP_CLOSURE:
 			// CLOSURE R(A) Bx  - R(A) = closure(PROTO[Bx])
 			a, bx := instr.A(), instr.Bx()
-			proto := vm.consts[bx]
+			proto := consts[bx]
 
 			if IsFunction(proto) {
 				fn := AsFunction(proto)
@@ -2581,9 +2920,13 @@ func (vm *RegisterVM) run() (Value, error) {
 			// Layout: R(A) = collection, R(A+1) = index (for loop body use)
 			a, b := instr.A(), instr.B()
 			collection := regs[b]
+			if IsChannel(collection) {
+				collection = channelIterator(AsChannel(collection).Channel)
+			}
 
 			// Validate collection type
-			if !IsArray(collection) && !IsMap(collection) {
+			lazy := IsIterator(collection) && AsIterator(collection).Next != nil
+			if !IsArray(collection) && !IsMap(collection) && !IsBytes(collection) && !lazy {
 				return NilValue(), fmt.Errorf("cannot iterate over %s", ValueType(collection))
 			}
 
@@ -2616,8 +2959,8 @@ func (vm *RegisterVM) run() (Value, error) {
 
 		case OP_ITERNEXT:
 			// ITERNEXT R(A) sBx  - Advance iterator R(A), jump sBx if done
-			// Layout: R(A) = collection, R(A+1) = index
-			// Outputs: R(A+2) = key, R(A+3) = value
+			// Layout: R(A) = collection
+			// Outputs: R(A+1) = key, R(A+2) = loop value, R(A+3) = value
 			a := instr.A()
 			sbx := instr.sBx()
 
@@ -2640,7 +2983,6 @@ func (vm *RegisterVM) run() (Value, error) {
 					key = BoxInt(int64(index))
 					value = arr.Elements[index]
 					iter.Index++ // Increment for next iteration
-					regs[a+1] = BoxInt(int64(index))  // Update index register
 				}
 			} else if IsMap(collection) {
 				// Use pre-snapshotted keys array
@@ -2651,20 +2993,40 @@ func (vm *RegisterVM) run() (Value, error) {
 					key = BoxString(keyStr)
 					value = m.Items[keyStr]
 					iter.Index++ // Increment for next iteration
-					regs[a+1] = BoxInt(int64(index))  // Update index register
+				}
+			} else if IsBytes(collection) {
+				data := AsBytes(collection).Data
+				if index < len(data) {
+					hasNext = true
+					key = BoxInt(int64(index))
+					value = BoxInt(int64(data[index]))
+					iter.Index++
+				}
+			} else if IsIterator(collection) {
+				next, ok, err := AsIterator(collection).Next()
+				if err != nil {
+					delete(vm.iteratorsByFrameReg, iterKey)
+					return NilValue(), err
+				}
+				if ok {
+					hasNext = true
+					key = BoxInt(int64(index))
+					value = next
+					iter.Index++
 				}
 			}
 
 			if hasNext {
-				// Store primary value in R(A+2), secondary info in R(A+3)
-				// For arrays: R(A+2) = element (value), R(A+3) = index
-				// For maps: R(A+2) = key, R(A+3) = value
-				if IsArray(collection) {
+				// R(A+1) and R(A+3) hold the pair `for k, v in` binds: the
+				// index and element of an array, the key and value of a map.
+				// R(A+2) holds what `for x in` binds: the element of an array
+				// or the key of a map.
+				regs[a+1] = key
+				regs[a+3] = value
+				if IsArray(collection) || IsBytes(collection) || IsIterator(collection) {
 					regs[a+2] = value // element
-					regs[a+3] = key   // index
 				} else {
-					regs[a+2] = key   // map key
-					regs[a+3] = value // map value
+					regs[a+2] = key // map key
 				}
 			} else {
 				// No more elements, jump to end of loop and cleanup iterator
@@ -2672,6 +3034,16 @@ func (vm *RegisterVM) run() (Value, error) {
 				delete(vm.iteratorsByFrameReg, iterKey)
 			}
 
+		case OP_ITEREND:
+			// ITEREND R(A)  - Drop iterator R(A); a no-op once it has finished
+			iterKey := fmt.Sprintf("%d:%d", vm.frameTop, instr.A())
+			if iter, ok := vm.iteratorsByFrameReg[iterKey]; ok && IsIterator(iter.Collection) {
+				if lazy := AsIterator(iter.Collection); lazy.Close != nil {
+					lazy.Close()
+				}
+			}
+			delete(vm.iteratorsByFrameReg, iterKey)
+
 		// ====================================================================
 		// OOP: Class Operations
 		// ====================================================================
@@ -2679,7 +3051,7 @@ func (vm *RegisterVM) run() (Value, error) {
 		case OP_CLASS:
 			// CLASS R(A) Kst(Bx)  - R(A) = new class K(Bx)
 			a, bx := instr.A(), instr.Bx()
-			className := ToString(vm.consts[bx])
+			className := ToString(consts[bx])
 
 			classObj := &ClassObj{
 				Object:     Object{Type: OBJ_CLASS},
@@ -2718,7 +3090,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			// GETMETHOD R(A) R(B) Kst(C)  - R(A) = R(B).method[K(C)]
 			a, b, c := instr.A(), instr.B(), instr.C()
 			obj := regs[b]
-			methodName := ToString(vm.consts[c])
+			methodName := ToString(consts[c])
 
 			if IsInstance(obj) {
 				inst := AsInstance(obj)
@@ -2766,59 +3138,11 @@ func (vm *RegisterVM) run() (Value, error) {
 					regs[a] = NilValue()
 				}
 			} else if IsArray(obj) {
-				// Array method support with caching
 				arr := AsArray(obj)
-
-				// Initialize method cache if needed
-				if arr.Methods == nil {
-					arr.Methods = make(map[string]Value)
-				}
-
-				// Check cache first
-				if cached, ok := arr.Methods[methodName]; ok {
-					regs[a] = cached
+				if methodName == "length" {
+					regs[a] = BoxInt(int64(len(arr.Elements)))
 				} else {
-					// Create and cache method
-					switch methodName {
-					case "push":
-						// Create a native function that pushes to this array
-						nativeFn := &NativeFnObj{
-							Object:   Object{Type: OBJ_NATIVE_FN},
-							Name:     "push",
-							Arity:    1,
-							Function: func(args []Value) (Value, error) {
-								arr.Elements = append(arr.Elements, args[0])
-								return NilValue(), nil
-							},
-						}
-						vm.gcRoots = append(vm.gcRoots, nativeFn)
-						methodVal := BoxPointer(unsafe.Pointer(nativeFn))
-						arr.Methods[methodName] = methodVal
-						regs[a] = methodVal
-					case "pop":
-						nativeFn := &NativeFnObj{
-							Object:   Object{Type: OBJ_NATIVE_FN},
-							Name:     "pop",
-							Arity:    0,
-							Function: func(args []Value) (Value, error) {
-								if len(arr.Elements) == 0 {
-									return NilValue(), fmt.Errorf("pop from empty array")
-								}
-								last := arr.Elements[len(arr.Elements)-1]
-								arr.Elements = arr.Elements[:len(arr.Elements)-1]
-								return last, nil
-							},
-						}
-						vm.gcRoots = append(vm.gcRoots, nativeFn)
-						methodVal := BoxPointer(unsafe.Pointer(nativeFn))
-						arr.Methods[methodName] = methodVal
-						regs[a] = methodVal
-					case "length":
-						// Return the length as an integer (as a property, not a method)
-						regs[a] = BoxInt(int64(len(arr.Elements)))
-					default:
-						regs[a] = NilValue()
-					}
+					regs[a] = vm.arrayMethod(arr, methodName)
 				}
 			} else {
 				regs[a] = NilValue()
@@ -2828,12 +3152,19 @@ func (vm *RegisterVM) run() (Value, error) {
 			// SETMETHOD R(A) Kst(B) R(C)  - R(A).method[K(B)] = R(C)
 			a, b, c := instr.A(), instr.B(), instr.C()
 			obj := regs[a]
-			methodName := ToString(vm.consts[b])
+			methodName := ToString(consts[b])
 			methodValue := regs[c]
 
 			if IsClass(obj) {
 				class := AsClass(obj)
 				class.Methods[methodName] = methodValue
+			} else if IsMap(obj) {
+				// Struct instance: the method is a closure over the map
+				m := AsMap(obj)
+				if m.Methods == nil {
+					m.Methods = make(map[string]Value)
+				}
+				m.Methods[methodName] = methodValue
 			} else {
 				return NilValue(), fmt.Errorf("cannot set method on non-class value")
 			}
@@ -2842,7 +3173,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			// GETPROP R(A) R(B) Kst(C)  - R(A) = R(B).field[K(C)]
 			a, b, c := instr.A(), instr.B(), instr.C()
 			obj := regs[b]
-			propName := ToString(vm.consts[c])
+			propName := ToString(consts[c])
 
 			if IsInstance(obj) {
 				inst := AsInstance(obj)
@@ -2878,7 +3209,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			// SETPROP R(A) Kst(B) R(C)  - R(A).field[K(B)] = R(C)
 			a, b, c := instr.A(), instr.B(), instr.C()
 			obj := regs[a]
-			propName := ToString(vm.consts[b])
+			propName := ToString(consts[b])
 			value := regs[c]
 
 			if IsInstance(obj) {
@@ -2909,7 +3240,7 @@ func (vm *RegisterVM) run() (Value, error) {
 			// SUPER R(A) R(B) Kst(C)  - R(A) = super.method[K(C)] from R(B)
 			a, b, c := instr.A(), instr.B(), instr.C()
 			obj := regs[b]
-			methodName := ToString(vm.consts[c])
+			methodName := ToString(consts[c])
 
 			if IsInstance(obj) {
 				inst := AsInstance(obj)
@@ -3007,6 +3338,16 @@ func (vm *RegisterVM) run() (Value, error) {
 		case OP_NOP:
 			// Do nothing
 
+		case OP_COVER:
+			if vm.coverage != nil {
+				vm.coverage.Hits[instr.Bx()]++
+			}
+			if vm.debugHook != nil {
+				vm.debugStatement(instr.Bx())
+			} else if vm.profile != nil {
+				vm.trackStatement(instr.Bx())
+			}
+
 		// ====================================================================
 		// Module Operations
 		// ====================================================================
@@ -3014,7 +3355,7 @@ func (vm *RegisterVM) run() (Value, error) {
 		case OP_IMPORT:
 			// IMPORT R(A) Kst(Bx) - R(A) = import(K(Bx))
 			a, bx := instr.A(), instr.Bx()
-			modulePath := ToString(vm.consts[bx])
+			modulePath := ToString(consts[bx])
 
 			// Load the module
 			module, err := vm.loadModule(modulePath)
@@ -3031,7 +3372,7 @@ func (vm *RegisterVM) run() (Value, error) {
 		case OP_EXPORT:
 			// EXPORT Kst(A) R(B) - export K(A) = R(B)
 			a, b := instr.A(), instr.B()
-			exportName := ToString(vm.consts[a])
+			exportName := ToString(consts[a])
 			exportValue := regs[b]
 
 			// Add to current module's exports
@@ -3102,6 +3443,44 @@ func (vm *RegisterVM) convertToJITFunction(fn *FunctionObj) *jit.Function {
 	}
 }
 
+// restArgs packs the arguments from index rest on into the array taken by
+// a variadic parameter
+func restArgs(args []Value, rest int) Value {
+	if len(args) <= rest {
+		return BoxArray([]Value{})
+	}
+	return BoxArray(append([]Value(nil), args[rest:]...))
+}
+
+// tailCallee returns the function a TAILCALL of v runs in the current
+// frame, with its closure if it has one, or nil if v is not a Sentra
+// function
+func tailCallee(v Value) (*FunctionObj, *ClosureObj) {
+	if !IsPointer(v) {
+		return nil, nil
+	}
+	switch AsObject(v).Type {
+	case OBJ_CLOSURE:
+		closure := AsClosure(v)
+		return closure.Function, closure
+	case OBJ_FUNCTION:
+		return AsFunction(v), nil
+	}
+	return nil, nil
+}
+
+// channelIterator makes the iterator of a for loop over a channel, which
+// receives until the channel is closed and drained
+func channelIterator(ch *concurrency.Channel) Value {
+	return NewLazyIterator(func() (Value, bool, error) {
+		value, ok := ch.Recv(concurrency.Forever)
+		if !ok {
+			return NilValue(), false, nil
+		}
+		return goToValue(value), true, nil
+	}, nil)
+}
+
 // callFunction handles calling a Sentra function
 func (vm *RegisterVM) callFunction(fn *FunctionObj, args []Value) (Value, error) {
 	// JIT profiling and compilation
@@ -3134,8 +3513,8 @@ func (vm *RegisterVM) callFunction(fn *FunctionObj, args []Value) (Value, error)
 
 
 	// Check call depth
-	if vm.frameTop >= vm.maxCallDepth {
-		return NilValue(), fmt.Errorf("stack overflow: max call depth exceeded")
+	if vm.frameTop >= vm.maxCallDepth || vm.regTop+fn.Arity+64 > len(vm.registers) {
+		return NilValue(), vm.depthError(fn.Name)
 	}
 
 	// Save caller's state completely
@@ -3158,6 +3537,7 @@ func (vm *RegisterVM) callFunction(fn *FunctionObj, args []Value) (Value, error)
 		regBase:      vm.regTop,
 		regTop:       vm.regTop + fn.Arity + 64,
 		numRegisters: fn.Arity + 64,
+		stopOnReturn: true,
 	}
 
 	// Copy arguments
@@ -3175,6 +3555,9 @@ func (vm *RegisterVM) callFunction(fn *FunctionObj, args []Value) (Value, error)
 		}
 		vm.registers[regIdx] = NilValue()
 	}
+	if fn.IsVariadic {
+		vm.registers[newFrame.regBase+fn.Arity-1] = restArgs(args, fn.Arity-1)
+	}
 
 	// Push frame
 	vm.frames[vm.frameTop] = newFrame
@@ -3188,6 +3571,8 @@ func (vm *RegisterVM) callFunction(fn *FunctionObj, args []Value) (Value, error)
 
 	// Execute callee (will return via OP_RETURN)
 	result, err := vm.run()
+	// The frame object stays in the pool and is reused by OP_CALL
+	newFrame.stopOnReturn = false
 
 	// Restore caller's state completely
 	vm.frameTop = savedFrameTop
@@ -3204,8 +3589,8 @@ func (vm *RegisterVM) callClosure(closure *ClosureObj, args []Value) (Value, err
 	fn := closure.Function
 
 	// Check call depth
-	if vm.frameTop >= vm.maxCallDepth {
-		return NilValue(), fmt.Errorf("stack overflow: max call depth exceeded")
+	if vm.frameTop >= vm.maxCallDepth || vm.regTop+fn.Arity+64 > len(vm.registers) {
+		return NilValue(), vm.depthError(fn.Name)
 	}
 
 	// Save caller's state completely
@@ -3229,6 +3614,7 @@ func (vm *RegisterVM) callClosure(closure *ClosureObj, args []Value) (Value, err
 		regBase:      vm.regTop,
 		regTop:       vm.regTop + fn.Arity + 64,
 		numRegisters: fn.Arity + 64,
+		stopOnReturn: true,
 	}
 
 	// Copy arguments
@@ -3246,6 +3632,9 @@ func (vm *RegisterVM) callClosure(closure *ClosureObj, args []Value) (Value, err
 		}
 		vm.registers[regIdx] = NilValue()
 	}
+	if fn.IsVariadic {
+		vm.registers[newFrame.regBase+fn.Arity-1] = restArgs(args, fn.Arity-1)
+	}
 
 	// Push frame
 	vm.frames[vm.frameTop] = newFrame
@@ -3259,6 +3648,8 @@ func (vm *RegisterVM) callClosure(closure *ClosureObj, args []Value) (Value, err
 
 	// Execute callee (will return via OP_RETURN)
 	result, err := vm.run()
+	// The frame object stays in the pool and is reused by OP_CALL
+	newFrame.stopOnReturn = false
 
 	// Restore caller's state completely
 	vm.frameTop = savedFrameTop
@@ -3304,7 +3695,7 @@ func (vm *RegisterVM) loadModule(path string) (*ModuleObj, error) {
 
 			// Store module before executing to handle circular imports
 			vm.modules[path] = module
-			globalObjectCache = append(globalObjectCache, module)
+			keepAlive(module)
 
 			// Save current module
 			previousModule := vm.currentModule
@@ -3313,7 +3704,7 @@ func (vm *RegisterVM) loadModule(path string) (*ModuleObj, error) {
 			vm.currentFile = resolvedPath
 
 			// Execute the module
-			_, err = vm.Execute(fn, nil)
+			err = vm.executeModule(fn)
 			if err != nil {
 				delete(vm.modules, path)
 				vm.currentModule = previousModule
@@ -3333,6 +3724,59 @@ func (vm *RegisterVM) loadModule(path string) (*ModuleObj, error) {
 	return nil, fmt.Errorf("module not found: %s", path)
 }
 
+// executeModule runs a module's top-level code on top of the importer's
+// register window, then restores the importer's frames and execution state
+func (vm *RegisterVM) executeModule(fn *FunctionObj) error {
+	// Frames are reused in place by OP_CALL, so keep copies of the caller's
+	savedFrameTop := vm.frameTop
+	savedFrames := make([]CallFrame, savedFrameTop)
+	savedFramePtrs := make([]*CallFrame, savedFrameTop)
+	for i := 0; i < savedFrameTop; i++ {
+		savedFramePtrs[i] = vm.frames[i]
+		savedFrames[i] = *vm.frames[i]
+	}
+	savedPC := vm.pc
+	savedRegTop := vm.regTop
+	savedCode := vm.code
+	savedConsts := vm.consts
+
+	frame := &CallFrame{
+		function:     fn,
+		pc:           0,
+		regBase:      vm.regTop,
+		regTop:       vm.regTop + fn.Arity + 128,
+		numRegisters: fn.Arity + 128,
+	}
+	// The running loop caches the register slice, so it must not be reallocated here
+	if frame.regTop > len(vm.registers) {
+		return fmt.Errorf("module nesting too deep: out of registers")
+	}
+	for i := frame.regBase; i < frame.regTop; i++ {
+		vm.registers[i] = NilValue()
+	}
+
+	vm.frames[0] = frame
+	vm.frameTop = 1
+	vm.code = fn.Code
+	vm.consts = fn.Constants
+	vm.pc = 0
+	vm.regTop = frame.regTop
+
+	_, err := vm.run()
+
+	for i := 0; i < savedFrameTop; i++ {
+		*savedFramePtrs[i] = savedFrames[i]
+		vm.frames[i] = savedFramePtrs[i]
+	}
+	vm.frameTop = savedFrameTop
+	vm.pc = savedPC
+	vm.regTop = savedRegTop
+	vm.code = savedCode
+	vm.consts = savedConsts
+
+	return err
+}
+
 // resolveModulePath finds the actual file path for a module
 func (vm *RegisterVM) resolveModulePath(modulePath string) string {
 	// Handle relative imports
@@ -3352,6 +3796,13 @@ func (vm *RegisterVM) resolveModulePath(modulePath string) string {
 		}
 	}
 
+	// Try installed packages (sentra.toml / sentra.mod dependencies)
+	if vm.moduleResolver != nil {
+		if resolved := vm.moduleResolver(modulePath); resolved != "" {
+			return resolved
+		}
+	}
+
 	// Try module paths
 	for _, searchPath := range vm.modulePaths {
 		// Try direct path
@@ -3407,6 +3858,16 @@ func (vm *RegisterVM) loadBuiltinModule(name string) *ModuleObj {
 		return vm.createOSModule()
 	case "http":
 		return vm.createHTTPModule()
+	case "csv":
+		return vm.createCSVModule()
+	case "process":
+		return vm.createProcessModule()
+	case "scheduler":
+		return vm.createSchedulerModule()
+	case "logging":
+		return vm.createLoggingModule()
+	case "metrics":
+		return vm.createMetricsModule()
 	default:
 		return nil
 	}
@@ -3420,6 +3881,38 @@ func (vm *RegisterVM) getGlobalByName(name string) Value {
 	return NilValue()
 }
 
+// arrayMethods are the builtins that can also be called as methods of an
+// array, like items.push(x) for push(items, x)
+var arrayMethods = map[string]bool{"push": true, "pop": true, "shift": true, "unshift": true}
+
+// arrayMethod returns the builtin name bound to arr, or nil if it is not
+// an array method. Bound methods are cached on the array.
+func (vm *RegisterVM) arrayMethod(arr *ArrayObj, name string) Value {
+	if cached, ok := arr.Methods[name]; ok {
+		return cached
+	}
+	builtin := vm.getGlobalByName(name)
+	if !arrayMethods[name] || !IsNativeFn(builtin) {
+		return NilValue()
+	}
+	fn := AsNativeFn(builtin)
+	self := BoxPointer(unsafe.Pointer(arr))
+	method := &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   name,
+		Arity:  fn.Arity - 1,
+		Function: func(args []Value) (Value, error) {
+			return fn.Function(append([]Value{self}, args...))
+		},
+	}
+	vm.gcRoots = append(vm.gcRoots, method)
+	if arr.Methods == nil {
+		arr.Methods = make(map[string]Value)
+	}
+	arr.Methods[name] = BoxPointer(unsafe.Pointer(method))
+	return arr.Methods[name]
+}
+
 // createMathModule creates the math built-in module
 func (vm *RegisterVM) createMathModule() *ModuleObj {
 	exports := make(map[string]Value)
@@ -3453,7 +3946,7 @@ func (vm *RegisterVM) createMathModule() *ModuleObj {
 	}
 
 	// Add to global cache to prevent GC
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 
 	return module
 }
@@ -3485,7 +3978,7 @@ func (vm *RegisterVM) createStringModule() *ModuleObj {
 	}
 
 	// Add to global cache to prevent GC
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 
 	return module
 }
@@ -3520,6 +4013,7 @@ func (vm *RegisterVM) createArrayModule() *ModuleObj {
 	exports["enumerate"] = vm.getGlobalByName("enumerate")
 	exports["count"] = vm.getGlobalByName("count")
 	exports["fill"] = vm.getGlobalByName("fill")
+	exports["filter"] = vm.getGlobalByName("filter")
 	exports["range"] = vm.getGlobalByName("range")
 
 	module := &ModuleObj{
@@ -3530,7 +4024,7 @@ func (vm *RegisterVM) createArrayModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
 
@@ -3548,6 +4042,11 @@ func (vm *RegisterVM) createIOModule() *ModuleObj {
 	exports["rename"] = vm.getGlobalByName("rename_file")
 	exports["stat"] = vm.getGlobalByName("file_stat")
 	exports["append"] = vm.getGlobalByName("append_file")
+	exports["open"] = vm.getGlobalByName("file_open")
+	exports["read_line"] = vm.getGlobalByName("file_read_line")
+	exports["read_chunk"] = vm.getGlobalByName("file_read_chunk")
+	exports["close"] = vm.getGlobalByName("file_close")
+	exports["lines"] = vm.getGlobalByName("file_lines")
 
 	module := &ModuleObj{
 		Object:  Object{Type: OBJ_MODULE},
@@ -3557,7 +4056,7 @@ func (vm *RegisterVM) createIOModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
 
@@ -3579,7 +4078,7 @@ func (vm *RegisterVM) createJSONModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
 
@@ -3603,7 +4102,7 @@ func (vm *RegisterVM) createTimeModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
 
@@ -3612,12 +4111,14 @@ func (vm *RegisterVM) createOSModule() *ModuleObj {
 	exports := make(map[string]Value)
 
 	// OS functions - reference from globals
-	exports["getenv"] = vm.getGlobalByName("getenv")
-	exports["setenv"] = vm.getGlobalByName("setenv")
+	exports["env"] = vm.getGlobalByName("env")
+	exports["set_env"] = vm.getGlobalByName("set_env")
+	exports["getenv"] = vm.getGlobalByName("env")
+	exports["setenv"] = vm.getGlobalByName("set_env")
 	exports["exit"] = vm.getGlobalByName("exit")
 	exports["cwd"] = vm.getGlobalByName("cwd")
 	exports["chdir"] = vm.getGlobalByName("chdir")
-	exports["args"] = vm.getGlobalByName("os_args")
+	exports["args"] = vm.getGlobalByName("args")
 	exports["hostname"] = vm.getGlobalByName("hostname")
 	exports["platform"] = vm.getGlobalByName("os_platform")
 
@@ -3629,7 +4130,7 @@ func (vm *RegisterVM) createOSModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
 
@@ -3652,6 +4153,6 @@ func (vm *RegisterVM) createHTTPModule() *ModuleObj {
 		Loaded:  true,
 	}
 
-	globalObjectCache = append(globalObjectCache, module)
+	keepAlive(module)
 	return module
 }
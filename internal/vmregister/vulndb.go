@@ -0,0 +1,252 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/sandbox"
+	"sentra/internal/vulndb"
+)
+
+// parseVulnDBOptions reads the options of the vulnerability database
+// functions: db_dir, which needs access to it under policy, and the
+// others each function allows
+func parseVulnDBOptions(name string, args []Value, i int, policy *sandbox.Policy, access sandbox.Capability, allowed ...string) (map[string]Value, string, error) {
+	opts := map[string]Value{}
+	if len(args) <= i {
+		return opts, "", nil
+	}
+	if !IsMap(args[i]) {
+		return nil, "", fmt.Errorf("%s: options must be a map, got %s", name, ValueType(args[i]))
+	}
+	dir := ""
+	for key, v := range AsMap(args[i]).Items {
+		if key == "db_dir" {
+			dir = ToString(v)
+			continue
+		}
+		known := false
+		for _, a := range allowed {
+			known = known || a == key
+		}
+		if !known {
+			return nil, "", fmt.Errorf("%s: unknown option '%s'", name, key)
+		}
+		opts[key] = v
+	}
+	if dir != "" {
+		if err := policy.Check(access, dir); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return opts, dir, nil
+}
+
+// vulnProduct reads a product to match: a CPE name, or a map of cpe, or
+// vendor, product or name and version, or ecosystem, name and version.
+// Maps of container_scan_image packages match by their source package,
+// as advisories name them, and ecosystem is used for maps without one.
+func vulnProduct(v Value, ecosystem string) vulndb.Product {
+	if !IsMap(v) {
+		return vulndb.Product{CPE: ToString(v)}
+	}
+	items := AsMap(v).Items
+	field := func(key string) string {
+		if f, ok := items[key]; ok && !IsNil(f) {
+			return ToString(f)
+		}
+		return ""
+	}
+	p := vulndb.Product{
+		CPE:       field("cpe"),
+		Vendor:    field("vendor"),
+		Name:      field("product"),
+		Version:   field("version"),
+		Ecosystem: field("ecosystem"),
+	}
+	if p.Name == "" {
+		p.Name = field("name")
+	}
+	if p.Ecosystem == "" && p.CPE == "" {
+		p.Ecosystem = ecosystem
+	}
+	if kind := field("type"); (kind == "dpkg" || kind == "apk") && field("source") != "" {
+		p.Name, p.Version = field("source"), field("source_version")
+	}
+	return p
+}
+
+// versioned reports whether a product names its version
+func versioned(p vulndb.Product) bool {
+	if p.Version != "" {
+		return true
+	}
+	cpe, err := vulndb.ParseCPE(p.CPE)
+	return err == nil && cpe.Version != "*" && cpe.Version != "-"
+}
+
+// registerVulnDBFunctions registers the functions of the local
+// vulnerability database, which matches the products and packages scans
+// find to CVEs without going online once it is downloaded or imported
+func (vm *RegisterVM) registerVulnDBFunctions() {
+	// vulndb_update(sources?, options?) downloads the NVD feeds, for nvd,
+	// and OSV exports, for osv:<ecosystem>, into the database
+	vm.registerGlobal("vulndb_update", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "vulndb_update",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 2 {
+				return NilValue(), fmt.Errorf("vulndb_update expects 0-2 arguments (sources, options), got %d", len(args))
+			}
+			if len(args) == 1 && IsMap(args[0]) {
+				args = []Value{NilValue(), args[0]}
+			}
+			sources := []string{"nvd"}
+			if len(args) > 0 && !IsNil(args[0]) {
+				sources = stringList(args[0])
+			}
+			opts, dir, err := parseVulnDBOptions("vulndb_update", args, 1, vm.permissions, sandbox.Write, "full", "timeout_ms")
+			if err != nil {
+				return NilValue(), err
+			}
+			var update vulndb.UpdateOptions
+			update.Full = IsTruthy(opts["full"])
+			if v, ok := opts["timeout_ms"]; ok {
+				if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+					return NilValue(), fmt.Errorf("vulndb_update: timeout_ms must be a positive number")
+				}
+				update.Timeout = time.Duration(ToNumber(v) * float64(time.Millisecond))
+			}
+			db := vulndb.Open(dir)
+			if err := db.Update(sources, update); err != nil {
+				return NilValue(), fmt.Errorf("vulndb_update: %v", err)
+			}
+			status, err := db.Status()
+			if err != nil {
+				return NilValue(), fmt.Errorf("vulndb_update: %v", err)
+			}
+			return goToValue(vulndb.StatusToMap(status)), nil
+		},
+	})
+
+	// vulndb_import(path, options?) adds NVD feeds and OSV exports copied
+	// from a connected host to the database
+	vm.registerGlobal("vulndb_import", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "vulndb_import",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("vulndb_import expects 1-2 arguments (path, options), got %d", len(args))
+			}
+			_, dir, err := parseVulnDBOptions("vulndb_import", args, 1, vm.permissions, sandbox.Write)
+			if err != nil {
+				return NilValue(), err
+			}
+			db := vulndb.Open(dir)
+			if err := db.Import(ToString(args[0])); err != nil {
+				return NilValue(), fmt.Errorf("vulndb_import: %v", err)
+			}
+			status, err := db.Status()
+			if err != nil {
+				return NilValue(), fmt.Errorf("vulndb_import: %v", err)
+			}
+			return goToValue(vulndb.StatusToMap(status)), nil
+		},
+	})
+
+	vm.registerGlobal("vulndb_status", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "vulndb_status",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("vulndb_status expects 0-1 arguments (options), got %d", len(args))
+			}
+			_, dir, err := parseVulnDBOptions("vulndb_status", args, 0, vm.permissions, sandbox.Read)
+			if err != nil {
+				return NilValue(), err
+			}
+			status, err := vulndb.Open(dir).Status()
+			if err != nil {
+				return NilValue(), fmt.Errorf("vulndb_status: %v", err)
+			}
+			return goToValue(vulndb.StatusToMap(status)), nil
+		},
+	})
+
+	// cve_lookup(id, options?) returns a CVE, or an OSV advisory, from the
+	// database, or nil when it does not know it
+	vm.registerGlobal("cve_lookup", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "cve_lookup",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("cve_lookup expects 1-2 arguments (id, options), got %d", len(args))
+			}
+			_, dir, err := parseVulnDBOptions("cve_lookup", args, 1, vm.permissions, sandbox.Read)
+			if err != nil {
+				return NilValue(), err
+			}
+			cve, advisories, err := vulndb.Open(dir).Lookup(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("cve_lookup: %v", err)
+			}
+			m := vulndb.LookupToMap(ToString(args[0]), cve, advisories)
+			if m == nil {
+				return NilValue(), nil
+			}
+			return goToValue(m), nil
+		},
+	})
+
+	// product_match(products, options?) returns the vulnerabilities that
+	// affect a product version, or an array of them, as findings
+	vm.registerGlobal("product_match", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "product_match",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("product_match expects 1-2 arguments (products, options), got %d", len(args))
+			}
+			opts, dir, err := parseVulnDBOptions("product_match", args, 1, vm.permissions, sandbox.Read, "ecosystem")
+			if err != nil {
+				return NilValue(), err
+			}
+			ecosystem := ""
+			if v, ok := opts["ecosystem"]; ok {
+				ecosystem = ToString(v)
+			}
+			items := []Value{args[0]}
+			if IsArray(args[0]) {
+				items = AsArray(args[0]).Elements
+			}
+			db := vulndb.Open(dir)
+			found := []interface{}{}
+			for _, item := range items {
+				p := vulnProduct(item, ecosystem)
+				if IsArray(args[0]) && !versioned(p) {
+					// Services and packages of unknown versions are left out
+					continue
+				}
+				matches, err := db.Match(p)
+				if err != nil {
+					return NilValue(), fmt.Errorf("product_match: %v", err)
+				}
+				for _, m := range matches {
+					finding := vulndb.MatchToMap(m)
+					if IsMap(item) {
+						if host, ok := AsMap(item).Items["host"]; ok {
+							finding["host"] = ToString(host)
+						}
+					}
+					found = append(found, finding)
+				}
+			}
+			return goToValue(found), nil
+		},
+	})
+}
@@ -0,0 +1,63 @@
+package vmregister_test
+
+import (
+	"archive/zip"
+	"os"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestVulnDB(t *testing.T) {
+	bundle := t.TempDir()
+	os.WriteFile(bundle+"/nvdcve-2.0-2020.json", []byte(`{"vulnerabilities": [
+		{"cve": {"id": "CVE-2020-15778", "published": "2020-07-24T14:15:12.450", "lastModified": "2022-01-01T00:00:00.000",
+			"descriptions": [{"lang": "en", "value": "scp in OpenSSH through 8.3p1 allows command injection."}],
+			"metrics": {"cvssMetricV31": [{"type": "Primary", "cvssData": {"vectorString": "CVSS:3.1/AV:N/AC:H/PR:N/UI:R/S:U/C:H/I:H/A:H"}}]},
+			"configurations": [{"nodes": [{"operator": "OR", "cpeMatch": [{"vulnerable": true, "criteria": "cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*", "versionEndIncluding": "8.3"}]}]}]}}]}`), 0644)
+	f, _ := os.Create(bundle + "/pypi.zip")
+	z := zip.NewWriter(f)
+	w, _ := z.Create("PYSEC-2021-9.json")
+	w.Write([]byte(`{"id": "PYSEC-2021-9", "aliases": ["CVE-2021-33203"], "summary": "Directory traversal in Django admindocs",
+		"severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:H/PR:H/UI:N/S:U/C:H/I:N/A:N"}],
+		"affected": [{"package": {"ecosystem": "PyPI", "name": "django"}, "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "3.2"}, {"fixed": "3.2.4"}]}]}]}`))
+	z.Close()
+	f.Close()
+
+	globals := run(t, `
+let opts = {"db_dir": "`+bundle+`/db"}
+let status = vulndb_import("`+bundle+`", opts)
+let services = [{"host": "10.0.0.5", "port": 22, "product": "OpenSSH", "version": "8.2p1", "cpe": "cpe:2.3:a:openbsd:openssh:8.2p1:*:*:*:*:*:*:*"},
+	{"host": "10.0.0.5", "port": 80, "product": "", "version": "", "cpe": ""}]
+let found = product_match(services, opts)
+let packages = product_match([{"name": "Django", "version": "3.2.1"}, {"name": "requests", "version": "2.31.0"}], {"db_dir": "`+bundle+`/db", "ecosystem": "PyPI"})
+let cve = cve_lookup("cve-2020-15778", opts)
+let advisory = cve_lookup("PYSEC-2021-9", opts)
+let unknown = cve_lookup("CVE-2000-0001", opts)
+let summary = status["cves"] + " " + status["osv"][0]["ecosystem"] + " " + len(found) + " " + found[0]["cve"] + " " + found[0]["severity"] + " " + found[0]["host"]
+let pkg = len(packages) + " " + packages[0]["cve"] + " " + packages[0]["fixed"] + " " + packages[0]["source"] + " " + packages[0]["severity"]
+let looked = cve["cvss_score"] + " " + cve["cpes"][0]["end_including"] + " " + advisory["cve"] + " " + len(advisory["advisories"])
+`)
+	if got, want := vmregister.ToString(globals["summary"]), "1 PyPI 1 CVE-2020-15778 high 10.0.0.5"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["pkg"]), "1 CVE-2021-33203 3.2.4 osv medium"; got != want {
+		t.Errorf("pkg = %q, want %q", got, want)
+	}
+	if got, want := vmregister.ToString(globals["looked"]), "7.5 8.3 PYSEC-2021-9 1"; got != want {
+		t.Errorf("looked = %q, want %q", got, want)
+	}
+	if !vmregister.IsNil(globals["unknown"]) {
+		t.Errorf("unknown = %v", globals["unknown"])
+	}
+
+	expectErrors(t, map[string]string{
+		`product_match("cpe:/a:openbsd:openssh:8.2")`:                   `product_match: not a CPE 2.3 name: "cpe:/a:openbsd:openssh:8.2"`,
+		`product_match({"product": "openssh"})`:                         "product_match: a product needs a CPE name, or a name and version",
+		`product_match({"ecosystem": "PyPI", "name": "django"})`:        "product_match: a package of PyPI needs a name and version",
+		`product_match("cpe:2.3:a:openbsd:openssh", {"vendor": "x"})`:   "product_match: unknown option 'vendor'",
+		`vulndb_update(["exploitdb"], {"db_dir": "` + bundle + `/db"})`: `vulndb_update: unknown source "exploitdb", expected nvd or osv:<ecosystem>`,
+		`vulndb_import("` + bundle + `/missing")`:                       "vulndb_import: stat",
+		`cve_lookup("CVE-2020-15778", "db")`:                            "cve_lookup: options must be a map, got string",
+	})
+}
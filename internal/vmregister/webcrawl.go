@@ -0,0 +1,80 @@
+package vmregister
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"sentra/internal/webclient"
+)
+
+// parseCrawlOptions reads the optional options map of web_crawl:
+// max_depth, max_pages, domains, exclude, robots and delay in milliseconds
+func parseCrawlOptions(args []Value, i int) (webclient.CrawlOptions, error) {
+	var opts webclient.CrawlOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("web_crawl: options must be a map, got %s", ValueType(args[i]))
+	}
+	for key, v := range AsMap(args[i]).Items {
+		switch key {
+		case "max_depth", "max_pages":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 1 {
+				return opts, fmt.Errorf("web_crawl: %s must be a positive number", key)
+			}
+			if key == "max_depth" {
+				opts.MaxDepth = int(ToNumber(v))
+			} else {
+				opts.MaxPages = int(ToNumber(v))
+			}
+		case "domains":
+			opts.Domains = stringList(v)
+		case "exclude":
+			for _, pattern := range stringList(v) {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return opts, fmt.Errorf("web_crawl: invalid exclude pattern '%s': %v", pattern, err)
+				}
+				opts.Exclude = append(opts.Exclude, re)
+			}
+		case "robots":
+			opts.IgnoreRobots = !IsTruthy(v)
+		case "delay":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) < 0 {
+				return opts, fmt.Errorf("web_crawl: delay must be a positive number of milliseconds")
+			}
+			opts.Delay = time.Duration(ToNumber(v) * float64(time.Millisecond))
+		default:
+			return opts, fmt.Errorf("web_crawl: unknown option '%s'", key)
+		}
+	}
+	return opts, nil
+}
+
+// registerWebCrawlFunctions registers the crawler that maps a site's
+// pages, forms and parameters for scans and fuzzers
+func (vm *RegisterVM) registerWebCrawlFunctions() {
+	// web_crawl(client_id, start_url, options?)
+	vm.registerGlobal("web_crawl", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_crawl",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return NilValue(), fmt.Errorf("web_crawl expects 2-3 arguments (client_id, start_url, options), got %d", len(args))
+			}
+			opts, err := parseCrawlOptions(args, 2)
+			if err != nil {
+				return NilValue(), err
+			}
+			webMod := vm.webClientModule.(*webclient.WebClientModule)
+			site, err := webMod.Crawl(ToString(args[0]), ToString(args[1]), opts)
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_crawl: %v", err)
+			}
+			return goToValue(webclient.SiteMapToMap(site)), nil
+		},
+	})
+}
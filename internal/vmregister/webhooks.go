@@ -0,0 +1,157 @@
+package vmregister
+
+import (
+	"fmt"
+	"strings"
+
+	"sentra/internal/webclient"
+)
+
+// webRequestValue returns a request as a map of method, url, headers and
+// body
+func webRequestValue(req *webclient.HTTPRequest) Value {
+	headers := make(map[string]Value, len(req.Headers))
+	for k, v := range req.Headers {
+		headers[k] = BoxString(v)
+	}
+	return BoxMap(map[string]Value{
+		"method":  BoxString(req.Method),
+		"url":     BoxString(req.URL),
+		"headers": BoxMap(headers),
+		"body":    BoxString(req.Body),
+	})
+}
+
+// applyRequestValue copies the method, url, headers and body of a request
+// map back into req
+func applyRequestValue(req *webclient.HTTPRequest, v Value) error {
+	items := AsMap(v).Items
+	if method, ok := items["method"]; ok {
+		req.Method = strings.ToUpper(ToString(method))
+	}
+	if url, ok := items["url"]; ok {
+		req.URL = ToString(url)
+	}
+	if body, ok := items["body"]; ok {
+		req.Body = ToString(body)
+	}
+	if headers, ok := items["headers"]; ok {
+		if !IsMap(headers) {
+			return fmt.Errorf("request hook: headers must be a map")
+		}
+		req.Headers = make(map[string]string)
+		for k, v := range AsMap(headers).Items {
+			req.Headers[k] = ToString(v)
+		}
+	}
+	return nil
+}
+
+// webResponseValue returns a response as a map
+func webResponseValue(resp *webclient.HTTPResponse) Value {
+	headers := make(map[string]Value, len(resp.Headers))
+	for k, v := range resp.Headers {
+		headers[k] = BoxString(strings.Join(v, ", "))
+	}
+	return BoxMap(map[string]Value{
+		"status_code":  BoxInt(int64(resp.StatusCode)),
+		"status":       BoxString(resp.Status),
+		"body":         BoxString(resp.Body),
+		"content_type": BoxString(resp.ContentType),
+		"proto":        BoxString(resp.Proto),
+		"url":          BoxString(resp.URL),
+		"headers":      BoxMap(headers),
+		"time_ms":      BoxNumber(float64(resp.ResponseTime.Microseconds()) / 1000),
+	})
+}
+
+// registerWebHookFunctions registers the hooks scripts run on the requests
+// and responses of a web client, to change requests on their way out or
+// analyse responses passively
+func (vm *RegisterVM) registerWebHookFunctions() {
+	webMod := func() *webclient.WebClientModule {
+		return vm.webClientModule.(*webclient.WebClientModule)
+	}
+	checkCallback := func(name string, fn Value) error {
+		if !IsPointer(fn) || !isCallableType(AsObject(fn).Type) {
+			return fmt.Errorf("%s: callback must be a function, got %s", name, ValueType(fn))
+		}
+		return nil
+	}
+
+	// web_on_request(client_id, callback)
+	vm.registerGlobal("web_on_request", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_on_request",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			callback := args[1]
+			if err := checkCallback("web_on_request", callback); err != nil {
+				return NilValue(), err
+			}
+			id, err := webMod().AddRequestHook(ToString(args[0]), func(req *webclient.HTTPRequest) error {
+				m := webRequestValue(req)
+				result, err := vm.callValue(callback, []Value{m})
+				if err != nil {
+					return err
+				}
+				if IsBool(result) && !AsBool(result) {
+					return fmt.Errorf("request to %s dropped by a hook", req.URL)
+				}
+				if IsMap(result) {
+					m = result
+				}
+				return applyRequestValue(req, m)
+			})
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_on_request: %v", err)
+			}
+			return BoxInt(int64(id)), nil
+		},
+	})
+
+	// web_on_response(client_id, callback)
+	vm.registerGlobal("web_on_response", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_on_response",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			callback := args[1]
+			if err := checkCallback("web_on_response", callback); err != nil {
+				return NilValue(), err
+			}
+			id, err := webMod().AddResponseHook(ToString(args[0]), func(req *webclient.HTTPRequest, res *webclient.HTTPResponse) error {
+				_, err := vm.callValue(callback, []Value{webRequestValue(req), webResponseValue(res)})
+				return err
+			})
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_on_response: %v", err)
+			}
+			return BoxInt(int64(id)), nil
+		},
+	})
+
+	// web_remove_hook(client_id, hook_id?)
+	vm.registerGlobal("web_remove_hook", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_remove_hook",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("web_remove_hook expects 1-2 arguments (client_id, hook_id), got %d", len(args))
+			}
+			id := 0
+			if len(args) > 1 && !IsNil(args[1]) {
+				if !(IsNumber(args[1]) || IsInt(args[1])) || ToNumber(args[1]) < 1 {
+					return NilValue(), fmt.Errorf("web_remove_hook: hook_id must be a positive number")
+				}
+				id = int(ToNumber(args[1]))
+			}
+			removed, err := webMod().RemoveHook(ToString(args[0]), id)
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_remove_hook: %v", err)
+			}
+			return BoxBool(removed), nil
+		},
+	})
+}
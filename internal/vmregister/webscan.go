@@ -0,0 +1,106 @@
+package vmregister
+
+import (
+	"fmt"
+	"time"
+
+	"sentra/internal/webclient"
+)
+
+// parseScanOptions reads the optional options map of
+// web_scan_vulnerabilities: checks, payloads, params, method, stored_urls,
+// time_delay and canary_wait in milliseconds, and canary with the listen
+// address and public url of a callback listener. The canary is started
+// here and the caller closes it.
+func parseScanOptions(args []Value, i int) (webclient.ScanOptions, error) {
+	var opts webclient.ScanOptions
+	if len(args) <= i || IsNil(args[i]) {
+		return opts, nil
+	}
+	if !IsMap(args[i]) {
+		return opts, fmt.Errorf("web_scan_vulnerabilities: options must be a map, got %s", ValueType(args[i]))
+	}
+	items := AsMap(args[i]).Items
+	var canary map[string]Value
+	for key, v := range items {
+		switch key {
+		case "checks":
+			opts.Checks = stringList(v)
+		case "payloads":
+			if !IsMap(v) {
+				return opts, fmt.Errorf("web_scan_vulnerabilities: payloads must be a map of check names to arrays")
+			}
+			opts.Payloads = make(map[string][]string)
+			for name, list := range AsMap(v).Items {
+				opts.Payloads[name] = stringList(list)
+			}
+		case "params":
+			if !IsMap(v) {
+				return opts, fmt.Errorf("web_scan_vulnerabilities: params must be a map")
+			}
+			opts.Params = make(map[string]string)
+			for name, value := range AsMap(v).Items {
+				opts.Params[name] = ToString(value)
+			}
+		case "method":
+			opts.Method = ToString(v)
+		case "stored_urls":
+			opts.StoredURLs = stringList(v)
+		case "time_delay", "canary_wait":
+			if !(IsNumber(v) || IsInt(v)) || ToNumber(v) <= 0 {
+				return opts, fmt.Errorf("web_scan_vulnerabilities: %s must be a positive number of milliseconds", key)
+			}
+			d := time.Duration(ToNumber(v) * float64(time.Millisecond))
+			if key == "time_delay" {
+				opts.TimeDelay = d
+			} else {
+				opts.CanaryWait = d
+			}
+		case "canary":
+			if !IsMap(v) {
+				return opts, fmt.Errorf("web_scan_vulnerabilities: canary must be a map of listen and url")
+			}
+			canary = AsMap(v).Items
+		default:
+			return opts, fmt.Errorf("web_scan_vulnerabilities: unknown option '%s'", key)
+		}
+	}
+	if canary != nil {
+		var listen, public string
+		for key, v := range canary {
+			switch key {
+			case "listen":
+				listen = ToString(v)
+			case "url":
+				public = ToString(v)
+			default:
+				return opts, fmt.Errorf("web_scan_vulnerabilities: unknown canary option '%s'", key)
+			}
+		}
+		if listen == "" {
+			return opts, fmt.Errorf("web_scan_vulnerabilities: canary needs a listen address")
+		}
+		c, err := webclient.StartCanary(listen, public)
+		if err != nil {
+			return opts, fmt.Errorf("web_scan_vulnerabilities: %v", err)
+		}
+		opts.Canary = c
+	}
+	return opts, nil
+}
+
+// webVulnValue returns a finding as a map
+func webVulnValue(v webclient.WebVuln) Value {
+	return BoxMap(map[string]Value{
+		"type":        BoxString(v.Type),
+		"severity":    BoxString(v.Severity),
+		"url":         BoxString(v.URL),
+		"parameter":   BoxString(v.Parameter),
+		"payload":     BoxString(v.Payload),
+		"evidence":    BoxString(v.Evidence),
+		"description": BoxString(v.Description),
+		"solution":    BoxString(v.Solution),
+		"request":     BoxString(v.Request),
+		"response":    BoxString(v.Response),
+	})
+}
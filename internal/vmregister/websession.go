@@ -0,0 +1,244 @@
+package vmregister
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"sentra/internal/webclient"
+)
+
+// parseFormLogin reads the options map of web_login: username, password,
+// username_field, password_field, fields, success, failure and csrf_header
+func parseFormLogin(v Value) (webclient.FormLogin, error) {
+	var login webclient.FormLogin
+	if !IsMap(v) {
+		return login, fmt.Errorf("web_login: options must be a map, got %s", ValueType(v))
+	}
+	for key, item := range AsMap(v).Items {
+		switch key {
+		case "username":
+			login.Username = ToString(item)
+		case "password":
+			login.Password = ToString(item)
+		case "username_field":
+			login.UsernameField = ToString(item)
+		case "password_field":
+			login.PasswordField = ToString(item)
+		case "fields":
+			if !IsMap(item) {
+				return login, fmt.Errorf("web_login: fields must be a map")
+			}
+			login.Fields = make(map[string]string)
+			for name, value := range AsMap(item).Items {
+				login.Fields[name] = ToString(value)
+			}
+		case "success":
+			login.Success = ToString(item)
+		case "failure":
+			login.Failure = ToString(item)
+		case "csrf_header":
+			login.CSRFHeader = ToString(item)
+		default:
+			return login, fmt.Errorf("web_login: unknown option '%s'", key)
+		}
+	}
+	return login, nil
+}
+
+// parseOAuth2Options reads the options map of web_oauth2_token: flow,
+// token_url, device_url, client_id, client_secret, scope, audience,
+// auth_style, params, apply and on_code
+func parseOAuth2Options(v Value) (cfg webclient.OAuth2Config, flow string, apply bool, onCode Value, err error) {
+	flow, apply, onCode = "client_credentials", true, NilValue()
+	if !IsMap(v) {
+		return cfg, flow, apply, onCode, fmt.Errorf("web_oauth2_token: options must be a map, got %s", ValueType(v))
+	}
+	for key, item := range AsMap(v).Items {
+		switch key {
+		case "flow":
+			flow = ToString(item)
+			if flow != "client_credentials" && flow != "device" {
+				return cfg, flow, apply, onCode, fmt.Errorf("web_oauth2_token: unknown flow '%s', want client_credentials or device", flow)
+			}
+		case "token_url":
+			cfg.TokenURL = ToString(item)
+		case "device_url":
+			cfg.DeviceURL = ToString(item)
+		case "client_id":
+			cfg.ClientID = ToString(item)
+		case "client_secret":
+			cfg.ClientSecret = ToString(item)
+		case "scope":
+			cfg.Scope = strings.Join(stringList(item), " ")
+		case "audience":
+			cfg.Audience = ToString(item)
+		case "auth_style":
+			cfg.AuthStyle = ToString(item)
+		case "params":
+			if !IsMap(item) {
+				return cfg, flow, apply, onCode, fmt.Errorf("web_oauth2_token: params must be a map")
+			}
+			cfg.Params = make(map[string]string)
+			for name, value := range AsMap(item).Items {
+				cfg.Params[name] = ToString(value)
+			}
+		case "apply":
+			apply = IsTruthy(item)
+		case "on_code":
+			onCode = item
+		default:
+			return cfg, flow, apply, onCode, fmt.Errorf("web_oauth2_token: unknown option '%s'", key)
+		}
+	}
+	if cfg.ClientID == "" {
+		return cfg, flow, apply, onCode, fmt.Errorf("web_oauth2_token: client_id is required")
+	}
+	return cfg, flow, apply, onCode, nil
+}
+
+// cookiesValue returns cookies as an array of maps
+func cookiesValue(cookies []*http.Cookie) Value {
+	arr := make([]Value, len(cookies))
+	for i, c := range cookies {
+		arr[i] = BoxMap(map[string]Value{
+			"name":  BoxString(c.Name),
+			"value": BoxString(c.Value),
+		})
+	}
+	return BoxArray(arr)
+}
+
+// registerWebSessionFunctions registers the cookie jars of web clients and
+// the login flows that authenticate them for the requests that follow:
+// HTML form logins with their CSRF tokens, and OAuth 2.0 client
+// credentials and device flows
+func (vm *RegisterVM) registerWebSessionFunctions() {
+	webMod := func() *webclient.WebClientModule {
+		return vm.webClientModule.(*webclient.WebClientModule)
+	}
+
+	// web_login(client_id, url, options)
+	vm.registerGlobal("web_login", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_login",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			login, err := parseFormLogin(args[2])
+			if err != nil {
+				return NilValue(), err
+			}
+			login.URL = ToString(args[1])
+			result, err := webMod().FormLogin(ToString(args[0]), login)
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_login: %v", err)
+			}
+			cookies := make([]Value, len(result.Cookies))
+			for i, name := range result.Cookies {
+				cookies[i] = BoxString(name)
+			}
+			items := map[string]Value{
+				"authenticated": BoxBool(result.Authenticated),
+				"status_code":   BoxInt(int64(result.StatusCode)),
+				"url":           BoxString(result.URL),
+				"csrf_token":    BoxString(result.CSRFToken),
+				"cookies":       BoxArray(cookies),
+			}
+			if result.Reason != "" {
+				items["reason"] = BoxString(result.Reason)
+			}
+			return BoxMap(items), nil
+		},
+	})
+
+	// web_oauth2_token(client_id, options)
+	vm.registerGlobal("web_oauth2_token", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_oauth2_token",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			cfg, flow, apply, onCode, err := parseOAuth2Options(args[1])
+			if err != nil {
+				return NilValue(), err
+			}
+			clientID := ToString(args[0])
+			var token *webclient.OAuth2Token
+			if flow == "device" {
+				dev, err := webMod().OAuth2DeviceAuthorize(clientID, cfg)
+				if err != nil {
+					return NilValue(), fmt.Errorf("web_oauth2_token: %v", err)
+				}
+				if IsNil(onCode) {
+					fmt.Fprintf(os.Stderr, "To sign in, open %s and enter the code %s\n", dev.VerificationURI, dev.UserCode)
+				} else if _, err := vm.callValue(onCode, []Value{BoxMap(map[string]Value{
+					"user_code":                 BoxString(dev.UserCode),
+					"verification_uri":          BoxString(dev.VerificationURI),
+					"verification_uri_complete": BoxString(dev.VerificationURIComplete),
+					"expires_in":                BoxInt(int64(dev.ExpiresIn)),
+				})}); err != nil {
+					return NilValue(), err
+				}
+				token, err = webMod().OAuth2DeviceToken(clientID, cfg, dev)
+				if err != nil {
+					return NilValue(), fmt.Errorf("web_oauth2_token: %v", err)
+				}
+			} else {
+				token, err = webMod().OAuth2ClientCredentials(clientID, cfg)
+				if err != nil {
+					return NilValue(), fmt.Errorf("web_oauth2_token: %v", err)
+				}
+			}
+			if apply {
+				if err := webMod().UseToken(clientID, token); err != nil {
+					return NilValue(), fmt.Errorf("web_oauth2_token: %v", err)
+				}
+			}
+			return goToValue(webclient.OAuth2TokenToMap(token)), nil
+		},
+	})
+
+	// web_cookies(client_id, url)
+	vm.registerGlobal("web_cookies", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_cookies",
+		Arity:  2,
+		Function: func(args []Value) (Value, error) {
+			cookies, err := webMod().GetCookies(ToString(args[0]), ToString(args[1]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("web_cookies: %v", err)
+			}
+			return cookiesValue(cookies), nil
+		},
+	})
+
+	// web_set_cookie(client_id, url, name, value)
+	vm.registerGlobal("web_set_cookie", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_set_cookie",
+		Arity:  4,
+		Function: func(args []Value) (Value, error) {
+			cookie := &http.Cookie{Name: ToString(args[2]), Value: ToString(args[3]), Path: "/"}
+			if err := cookie.Valid(); err != nil {
+				return NilValue(), fmt.Errorf("web_set_cookie: %v", err)
+			}
+			if err := webMod().SetCookie(ToString(args[0]), ToString(args[1]), cookie); err != nil {
+				return NilValue(), fmt.Errorf("web_set_cookie: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	// web_clear_cookies(client_id)
+	vm.registerGlobal("web_clear_cookies", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "web_clear_cookies",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if err := webMod().ClearCookies(ToString(args[0])); err != nil {
+				return NilValue(), fmt.Errorf("web_clear_cookies: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+}
@@ -0,0 +1,194 @@
+package vmregister
+
+import (
+	"fmt"
+	"os"
+
+	"sentra/internal/ossec"
+)
+
+// registryValueToMap returns a registry value with its name and type
+func registryValueToMap(v ossec.RegistryValue) map[string]interface{} {
+	data := v.Data
+	if list, ok := data.([]string); ok {
+		items := make([]interface{}, len(list))
+		for i, s := range list {
+			items[i] = s
+		}
+		data = items
+	}
+	return map[string]interface{}{"name": v.Name, "type": v.Type, "data": data}
+}
+
+// registerWinAuditFunctions registers the registry reads and the service,
+// startup item and security policy audits of Windows endpoints
+func (vm *RegisterVM) registerWinAuditFunctions() {
+	// reg_read(path, name?) returns the data of a registry value, the
+	// key's default value without a name
+	vm.registerGlobal("reg_read", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "reg_read",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("reg_read expects 1-2 arguments (path, name), got %d", len(args))
+			}
+			name := ""
+			if len(args) == 2 {
+				name = ToString(args[1])
+			}
+			v, err := ossec.RegistryRead(ToString(args[0]), name)
+			if err != nil {
+				return NilValue(), fmt.Errorf("reg_read: %v", err)
+			}
+			return goToValue(registryValueToMap(v)["data"]), nil
+		},
+	})
+
+	// reg_enum(path) returns the subkeys of a registry key and its values
+	vm.registerGlobal("reg_enum", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "reg_enum",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			key, err := ossec.RegistryEnum(ToString(args[0]))
+			if err != nil {
+				return NilValue(), fmt.Errorf("reg_enum: %v", err)
+			}
+			keys := make([]interface{}, len(key.Keys))
+			for i, k := range key.Keys {
+				keys[i] = k
+			}
+			values := make([]interface{}, len(key.Values))
+			for i, v := range key.Values {
+				values[i] = registryValueToMap(v)
+			}
+			return goToValue(map[string]interface{}{"path": key.Path, "keys": keys, "values": values}), nil
+		},
+	})
+
+	// win_services() returns the configuration of every service
+	vm.registerGlobal("win_services", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "win_services",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			services, err := ossec.Services()
+			if err != nil {
+				return NilValue(), fmt.Errorf("win_services: %v", err)
+			}
+			found := make([]interface{}, len(services))
+			for i, s := range services {
+				found[i] = map[string]interface{}{
+					"name":         s.Name,
+					"display_name": s.DisplayName,
+					"bin_path":     s.BinaryPath,
+					"executable":   s.Executable,
+					"start_type":   s.StartType,
+					"type":         s.Type,
+					"account":      s.Account,
+					"state":        s.State,
+					"sddl":         s.ServiceSDDL,
+					"unquoted":     len(ossec.UnquotedServicePath(s.BinaryPath)) > 0,
+				}
+			}
+			return goToValue(found), nil
+		},
+	})
+
+	// win_service_audit() returns findings for services with unquoted
+	// paths, and for service objects, programs and program directories
+	// unprivileged users can change
+	vm.registerGlobal("win_service_audit", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "win_service_audit",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			findings, err := ossec.AuditServices()
+			if err != nil {
+				return NilValue(), fmt.Errorf("win_service_audit: %v", err)
+			}
+			found := make([]interface{}, len(findings))
+			for i, f := range findings {
+				found[i] = map[string]interface{}{
+					"type":     f.Type,
+					"severity": f.Severity,
+					"service":  f.Service,
+					"path":     f.Path,
+					"title":    f.Detail,
+				}
+			}
+			return goToValue(found), nil
+		},
+	})
+
+	// win_startup_items() returns what runs at boot or logon
+	vm.registerGlobal("win_startup_items", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "win_startup_items",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			items, err := ossec.StartupItems()
+			if err != nil {
+				return NilValue(), fmt.Errorf("win_startup_items: %v", err)
+			}
+			found := make([]interface{}, len(items))
+			for i, item := range items {
+				found[i] = map[string]interface{}{
+					"name":       item.Name,
+					"command":    item.Command,
+					"location":   item.Location,
+					"scope":      item.Scope,
+					"executable": item.Executable,
+				}
+			}
+			return goToValue(found), nil
+		},
+	})
+
+	// win_security_policy(path?) returns the local security policy, or the
+	// one a secedit export at path holds, and the settings of it that fall
+	// short of a hardening baseline
+	vm.registerGlobal("win_security_policy", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "win_security_policy",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) > 1 {
+				return NilValue(), fmt.Errorf("win_security_policy expects 0-1 arguments (path), got %d", len(args))
+			}
+			var policy ossec.SecurityPolicy
+			var err error
+			if len(args) == 1 {
+				var data []byte
+				if data, err = os.ReadFile(ToString(args[0])); err == nil {
+					policy, err = ossec.ParseSecurityPolicy(data)
+				}
+			} else {
+				policy, err = ossec.LocalSecurityPolicy()
+			}
+			if err != nil {
+				return NilValue(), fmt.Errorf("win_security_policy: %v", err)
+			}
+			settings := map[string]interface{}{}
+			for section, values := range policy {
+				m := map[string]interface{}{}
+				for key := range values {
+					m[key], _ = policy.Setting(section, key)
+				}
+				settings[section] = m
+			}
+			findings := []interface{}{}
+			for _, f := range ossec.CheckSecurityPolicy(policy) {
+				findings = append(findings, map[string]interface{}{
+					"setting":  f.Setting,
+					"value":    f.Value,
+					"expected": f.Expected,
+					"severity": f.Severity,
+					"title":    f.Detail,
+				})
+			}
+			return goToValue(map[string]interface{}{"settings": settings, "findings": findings}), nil
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package vmregister_test
+
+import (
+	"os"
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestWindowsAudit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/secpol.inf", []byte("[System Access]\r\nMinimumPasswordLength = 14\r\nPasswordComplexity = 1\r\n"+
+		"PasswordHistorySize = 24\r\nMaximumPasswordAge = 60\r\nLockoutBadCount = 5\r\nEnableGuestAccount = 1\r\n"+
+		"[Event Audit]\r\nAuditLogonEvents = 3\r\nAuditAccountLogon = 3\r\nAuditAccountManage = 3\r\nAuditPolicyChange = 3\r\n"+
+		"[Registry Values]\r\n"+`MACHINE\System\CurrentControlSet\Control\Lsa\LmCompatibilityLevel=4,5`+"\r\n"), 0644)
+
+	globals := run(t, `
+let policy = win_security_policy("`+dir+`/secpol.inf")
+let level = policy["settings"]["Registry Values"]["MACHINE\\System\\CurrentControlSet\\Control\\Lsa\\LmCompatibilityLevel"]
+let first = policy["findings"][0]
+let guest = first["setting"] + "=" + first["value"] + " " + first["severity"] + ": " + first["title"]
+`)
+	if got := vmregister.ToString(globals["level"]); got != "5" {
+		t.Errorf("level = %q", got)
+	}
+	if got, want := vmregister.ToString(globals["guest"]), "EnableGuestAccount=1 high: the guest account is enabled"; got != want {
+		t.Errorf("guest = %q, want %q", got, want)
+	}
+
+	expectErrors(t, map[string]string{
+		`win_security_policy("` + dir + `/missing.inf")`: "win_security_policy: open",
+		`win_security_policy("a", "b")`:                  "win_security_policy expects 0-1 arguments (path), got 2",
+		`reg_read()`:                                     "reg_read expects 1-2 arguments (path, name), got 0",
+	})
+}
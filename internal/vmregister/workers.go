@@ -0,0 +1,332 @@
+package vmregister
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+	"unsafe"
+
+	"sentra/internal/concurrency"
+)
+
+// registerWorkerFunctions registers the conc_* builtins of worker pools
+// and their metrics, which share their pools with worker_pool_create. A job's handler is a
+// job type or a script function. A function runs on a new VM of its own,
+// see jobHandler, so jobs run in parallel with each other and with the
+// script that submitted them.
+func (vm *RegisterVM) registerWorkerFunctions() {
+	concMod := vm.concurrencyModule.(*concurrency.ConcurrencyModule)
+
+	vm.registerGlobal("conc_create_worker_pool", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "conc_create_worker_pool",
+		Arity:  3,
+		Function: func(args []Value) (Value, error) {
+			if _, err := concMod.CreateWorkerPool(ToString(args[0]), int(ToInt(args[1])), int(ToInt(args[2]))); err != nil {
+				return NilValue(), err
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("conc_start_worker_pool", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "conc_start_worker_pool",
+		Arity:  1,
+		Function: func(args []Value) (Value, error) {
+			if err := concMod.StartWorkerPool(ToString(args[0])); err != nil {
+				return NilValue(), err
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("conc_submit_job", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "conc_submit_job",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 4 || len(args) > 5 {
+				return NilValue(), fmt.Errorf("conc_submit_job expects 4-5 arguments (pool_id, job_id, handler, data, reply), got %d", len(args))
+			}
+			job := concurrency.Job{
+				ID:       ToString(args[1]),
+				Data:     valueToGo(args[3]),
+				Priority: 1,
+				Created:  time.Now(),
+			}
+			handler := args[2]
+			switch {
+			case IsString(handler):
+				job.Type = ToString(handler)
+			case IsPointer(handler) && isCallableType(AsObject(handler).Type):
+				job.Type = "function"
+				job.Handler = vm.jobHandler(handler)
+			default:
+				return NilValue(), fmt.Errorf("conc_submit_job: handler must be a job type or a function, got %s", ValueType(handler))
+			}
+			if len(args) == 5 && !IsNil(args[4]) {
+				if !IsChannel(args[4]) {
+					return NilValue(), fmt.Errorf("conc_submit_job: reply must be a channel, got %s", ValueType(args[4]))
+				}
+				reply := AsChannel(args[4]).Channel
+				job.Done = func(result concurrency.JobResult) {
+					reply.Send(result.Map(), concurrency.Forever)
+				}
+			}
+			if err := concMod.SubmitJob(ToString(args[0]), job); err != nil {
+				return NilValue(), fmt.Errorf("conc_submit_job: %v", err)
+			}
+			return BoxBool(true), nil
+		},
+	})
+
+	vm.registerGlobal("conc_job_result", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "conc_job_result",
+		Arity:  -1,
+		Function: func(args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return NilValue(), fmt.Errorf("conc_job_result expects 1-2 arguments (pool_id, timeout_ms), got %d", len(args))
+			}
+			var wait time.Duration
+			if len(args) == 2 {
+				wait = time.Duration(ToNumber(args[1]) * float64(time.Millisecond))
+			}
+			result, ok, err := concMod.NextResult(ToString(args[0]), wait)
+			if err != nil {
+				return NilValue(), fmt.Errorf("conc_job_result: %v", err)
+			}
+			if !ok {
+				return NilValue(), nil
+			}
+			return goToValue(result.Map()), nil
+		},
+	})
+
+	vm.registerGlobal("conc_get_metrics", &NativeFnObj{
+		Object: Object{Type: OBJ_NATIVE_FN},
+		Name:   "conc_get_metrics",
+		Arity:  0,
+		Function: func(args []Value) (Value, error) {
+			metrics := concMod.GetMetrics()
+			return BoxMap(map[string]Value{
+				"worker_pools_active":   BoxInt(metrics.WorkerPoolsActive),
+				"workers_total":         BoxInt(metrics.WorkersTotal),
+				"tasks_queued":          BoxInt(metrics.TasksQueued),
+				"tasks_processing":      BoxInt(metrics.TasksProcessing),
+				"tasks_completed":       BoxInt(metrics.TasksCompleted),
+				"tasks_failed":          BoxInt(metrics.TasksFailed),
+				"throughput_per_second": BoxNumber(metrics.ThroughputPerSecond),
+				"resource_utilization":  BoxNumber(metrics.ResourceUtilization),
+				"goroutine_count":       BoxInt(metrics.GoroutineCount),
+				"memory_usage":          BoxInt(metrics.MemoryUsage),
+			}), nil
+		},
+	})
+}
+
+// jobHandler returns the handler of a job that calls fn with the job's
+// data. fn and the script's globals are copied when the job is submitted,
+// so the job sees them as they were then, and shares nothing it can change
+// with the script. It runs on a new VM, which has the same builtins and
+// permissions, and is interrupted if the job times out or its pool stops.
+func (vm *RegisterVM) jobHandler(fn Value) func(ctx context.Context, data interface{}) (interface{}, error) {
+	c := &jobCopy{from: vm, seen: make(map[Value]Value), upvalues: make(map[*UpvalueObj]*UpvalueObj)}
+	handler := c.value(fn)
+	names := maps.Clone(vm.globalNames)
+	globals := make(map[uint16]Value)
+	for _, id := range names {
+		if id >= vm.firstUserGlobal {
+			globals[id] = c.value(vm.globals[id])
+		}
+	}
+	nextGlobalID := vm.nextGlobalID
+	permissions := vm.permissions
+	loader, resolver := vm.moduleLoader, vm.moduleResolver
+	paths, file := vm.modulePaths, vm.currentFile
+
+	return func(ctx context.Context, data interface{}) (interface{}, error) {
+		worker := NewRegisterVM()
+		worker.globalNames = names
+		for id, value := range globals {
+			worker.globals[id] = value
+		}
+		worker.nextGlobalID = nextGlobalID
+		worker.permissions = permissions
+		worker.moduleLoader, worker.moduleResolver = loader, resolver
+		worker.modulePaths, worker.currentFile = paths, file
+		c.bind(worker)
+
+		stop := context.AfterFunc(ctx, worker.Interrupt)
+		defer stop()
+		result, err := worker.Call(handler, []Value{goToValue(data)})
+		if err != nil {
+			return nil, err
+		}
+		return valueToGo(result), nil
+	}
+}
+
+// jobCopy copies the values of one VM for a job that runs on another.
+// Arrays, maps, functions and closures are copied, keeping cycles and
+// sharing, and anything else is shared. Natives and builtin modules belong
+// to the VM that made them, so bind points their copies at the job VM's.
+type jobCopy struct {
+	from     *RegisterVM
+	seen     map[Value]Value
+	upvalues map[*UpvalueObj]*UpvalueObj
+	natives  map[*NativeFnObj]uint16 // Copies of natives that are globals, by global ID
+	modules  []*ModuleObj            // Copies of builtin modules, to load again
+	ids      map[Value]uint16        // Global ID of each native of from, made on first use
+}
+
+func (c *jobCopy) value(v Value) Value {
+	if !IsPointer(v) {
+		return v
+	}
+	if copied, ok := c.seen[v]; ok {
+		return copied
+	}
+	switch AsObject(v).Type {
+	case OBJ_ARRAY:
+		arr := AsArray(v)
+		copied := BoxArray(make([]Value, len(arr.Elements)))
+		c.seen[v] = copied
+		for i, elem := range arr.Elements {
+			AsArray(copied).Elements[i] = c.value(elem)
+		}
+		return copied
+	case OBJ_MAP:
+		m := AsMap(v)
+		copied := BoxMap(make(map[string]Value, len(m.Items)))
+		c.seen[v] = copied
+		for key, item := range m.Items {
+			AsMap(copied).Items[key] = c.value(item)
+		}
+		if m.Methods != nil {
+			AsMap(copied).Methods = make(map[string]Value, len(m.Methods))
+			for name, method := range m.Methods {
+				AsMap(copied).Methods[name] = c.value(method)
+			}
+		}
+		return copied
+	case OBJ_FUNCTION:
+		return BoxPointer(unsafe.Pointer(c.function(AsFunction(v))))
+	case OBJ_CLOSURE:
+		closure := AsClosure(v)
+		copied := &ClosureObj{
+			Object:   closure.Object,
+			Upvalues: make([]*UpvalueObj, len(closure.Upvalues)),
+		}
+		boxed := BoxClosure(copied)
+		c.seen[v] = boxed
+		copied.Function = c.function(closure.Function)
+		for i, upvalue := range closure.Upvalues {
+			copied.Upvalues[i] = c.upvalue(upvalue)
+		}
+		return boxed
+	case OBJ_NATIVE_FN:
+		native := AsNativeFn(v)
+		copied := *native
+		boxed := BoxPointer(unsafe.Pointer(&copied))
+		keepAlive(&copied)
+		c.seen[v] = boxed
+		if id, ok := c.globalID(v); ok {
+			if c.natives == nil {
+				c.natives = make(map[*NativeFnObj]uint16)
+			}
+			c.natives[&copied] = id
+		}
+		return boxed
+	case OBJ_MODULE:
+		mod := AsModule(v)
+		copied := &ModuleObj{Object: mod.Object, Name: mod.Name, Path: mod.Path, Loaded: mod.Loaded}
+		boxed := BoxPointer(unsafe.Pointer(copied))
+		keepAlive(copied)
+		c.seen[v] = boxed
+		if mod.Path == "<builtin>" {
+			c.modules = append(c.modules, copied)
+			return boxed
+		}
+		copied.Exports = make(map[string]Value, len(mod.Exports))
+		for name, export := range mod.Exports {
+			copied.Exports[name] = c.value(export)
+		}
+		return boxed
+	}
+	return v
+}
+
+// function copies fn with its code and constants. Hot loops are patched
+// with the loop IDs of the VM that compiled them, so they are put back to
+// plain jumps.
+func (c *jobCopy) function(fn *FunctionObj) *FunctionObj {
+	key := BoxPointer(unsafe.Pointer(fn))
+	if copied, ok := c.seen[key]; ok {
+		return AsFunction(copied)
+	}
+	copied := new(FunctionObj)
+	*copied = *fn
+	c.seen[key] = BoxFunction(copied)
+	copied.Code = make([]Instruction, len(fn.Code))
+	for i, instr := range fn.Code {
+		if instr.OpCode() == OP_JMP_HOT {
+			instr = CreateABx(OP_JMP, 0, instr.Bx())
+		}
+		copied.Code[i] = instr
+	}
+	copied.Constants = make([]Value, len(fn.Constants))
+	for i, constant := range fn.Constants {
+		copied.Constants[i] = c.value(constant)
+	}
+	return copied
+}
+
+// upvalue copies the current value of a captured variable
+func (c *jobCopy) upvalue(upvalue *UpvalueObj) *UpvalueObj {
+	if upvalue == nil {
+		return nil
+	}
+	if copied, ok := c.upvalues[upvalue]; ok {
+		return copied
+	}
+	value := upvalue.Closed
+	if upvalue.Location != nil {
+		value = *upvalue.Location
+	}
+	copied := &UpvalueObj{Object: upvalue.Object}
+	keepAlive(copied)
+	c.upvalues[upvalue] = copied
+	copied.Closed = c.value(value)
+	return copied
+}
+
+// globalID returns the global ID of a native of the VM being copied
+func (c *jobCopy) globalID(native Value) (uint16, bool) {
+	if c.ids == nil {
+		c.ids = make(map[Value]uint16)
+		for _, id := range c.from.globalNames {
+			if IsNativeFn(c.from.globals[id]) {
+				c.ids[c.from.globals[id]] = id
+			}
+		}
+	}
+	id, ok := c.ids[native]
+	return id, ok
+}
+
+// bind points the copied natives and builtin modules at those of vm
+func (c *jobCopy) bind(vm *RegisterVM) {
+	for native, id := range c.natives {
+		if IsNativeFn(vm.globals[id]) {
+			native.Function = AsNativeFn(vm.globals[id]).Function
+		}
+	}
+	for _, mod := range c.modules {
+		if loaded := vm.loadBuiltinModule(mod.Name); loaded != nil {
+			mod.Exports = loaded.Exports
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package vmregister_test
+
+import (
+	"testing"
+
+	"sentra/internal/vmregister"
+)
+
+func TestWorkerPoolHandlers(t *testing.T) {
+	globals := run(t, `
+import math
+let base = 100
+let seen = []
+fn double(x) {
+    return x * 2
+}
+fn handle(job) {
+    push(seen, job)
+    if job["n"] == 3 {
+        throw "bad input"
+    }
+    return double(job["n"]) + base + math.floor(0.5)
+}
+fn count(n) {
+    let total = 0
+    let i = 0
+    while i < n {
+        total = total + i
+        i = i + 1
+    }
+    return total
+}
+for k in range(0, 100) {
+    count(100)
+}
+
+conc_create_worker_pool("workers", 4, 10)
+conc_start_worker_pool("workers")
+for i in range(0, 5) {
+    conc_submit_job("workers", "job" + str(i), handle, {"n": i})
+}
+conc_submit_job("workers", "count", count, 1000)
+let results = {}
+for i in range(0, 6) {
+    let r = conc_job_result("workers", 5000)
+    results[r["id"]] = [r["ok"], r["value"], r["error"]]
+}
+let handled = [results["job0"], results["job2"], results["job3"], results["count"]]
+let untouched = len(seen)
+
+let replies = chan_new(1)
+let offset = 7
+conc_submit_job("workers", "closure", fn(x) { return x + offset }, 1, replies)
+let reply = chan_recv(replies, 5000)
+let replied = [reply["id"], reply["ok"], reply["value"]]
+let none = conc_job_result("workers")
+`)
+	want := map[string]string{
+		"handled":   "[[true, 100, nil], [true, 104, nil], [false, nil, uncaught exception: bad input], [true, 499500, nil]]",
+		"untouched": "0",
+		"replied":   "[closure, true, 8]",
+		"none":      "nil",
+	}
+	for name, value := range want {
+		if got := vmregister.ToString(globals[name]); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package vulndb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CPE is a CPE 2.3 name, its attributes unescaped. An attribute is * when
+// any value matches and - when it does not apply.
+type CPE struct {
+	Part, Vendor, Product, Version, Update, Edition, Language string
+	SWEdition, TargetSW, TargetHW, Other                      string
+}
+
+// ParseCPE parses a CPE 2.3 formatted string, as in
+// cpe:2.3:a:openbsd:openssh:8.2:p1:*:*:*:*:*:*. Attributes left off are *.
+func ParseCPE(s string) (CPE, error) {
+	if !strings.HasPrefix(strings.ToLower(s), "cpe:2.3:") {
+		return CPE{}, fmt.Errorf("not a CPE 2.3 name: %q", s)
+	}
+	var fields []string
+	var b strings.Builder
+	rest := s[len("cpe:2.3:"):]
+	for i := 0; i < len(rest); i++ {
+		switch c := rest[i]; {
+		case c == '\\' && i+1 < len(rest):
+			i++
+			b.WriteByte(rest[i])
+		case c == ':':
+			fields = append(fields, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	fields = append(fields, b.String())
+	if len(fields) > 11 || len(fields) < 3 || fields[2] == "" || fields[2] == "*" {
+		return CPE{}, fmt.Errorf("not a CPE 2.3 name: %q", s)
+	}
+	for len(fields) < 11 {
+		fields = append(fields, "*")
+	}
+	for i, f := range fields {
+		if f == "" {
+			fields[i] = "*"
+		} else {
+			fields[i] = strings.ToLower(f)
+		}
+	}
+	return CPE{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7], fields[8], fields[9], fields[10]}, nil
+}
+
+// String formats a CPE name, escaping what needs to be
+func (c CPE) String() string {
+	attrs := []string{c.Part, c.Vendor, c.Product, c.Version, c.Update, c.Edition, c.Language, c.SWEdition, c.TargetSW, c.TargetHW, c.Other}
+	for i, a := range attrs {
+		if a == "*" || a == "-" || a == "" {
+			if a == "" {
+				attrs[i] = "*"
+			}
+			continue
+		}
+		var b strings.Builder
+		for _, r := range a {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		attrs[i] = b.String()
+	}
+	return "cpe:2.3:" + strings.Join(attrs, ":")
+}
+
+// fullVersion is the version with the update appended when there is one,
+// so that 8.2 with update p1 compares as 8.2p1
+func (c CPE) fullVersion() string {
+	if c.Update == "*" || c.Update == "-" {
+		return c.Version
+	}
+	return c.Version + c.Update
+}
+
+// specific reports whether an attribute names a value
+func specific(attr string) bool {
+	return attr != "*" && attr != "-" && attr != ""
+}
+
+// matches reports whether a CPE match of a CVE covers a product. A vendor
+// of * in the product matches any vendor.
+func (m CPEMatch) matches(p CPE) bool {
+	c, err := ParseCPE(m.Criteria)
+	if err != nil || c.Product != p.Product {
+		return false
+	}
+	if specific(c.Part) && specific(p.Part) && c.Part != p.Part {
+		return false
+	}
+	if specific(c.Vendor) && specific(p.Vendor) && c.Vendor != p.Vendor {
+		return false
+	}
+	for _, pair := range [][2]string{{c.Edition, p.Edition}, {c.Language, p.Language}, {c.SWEdition, p.SWEdition}, {c.TargetSW, p.TargetSW}, {c.TargetHW, p.TargetHW}} {
+		if specific(pair[0]) && specific(pair[1]) && pair[0] != pair[1] {
+			return false
+		}
+	}
+
+	version := p.fullVersion()
+	if specific(c.Version) {
+		return compareGeneric(c.fullVersion(), version) == 0
+	}
+	if c.Version == "-" {
+		return false
+	}
+	if m.StartIncluding != "" && compareGeneric(version, m.StartIncluding) < 0 ||
+		m.StartExcluding != "" && compareGeneric(version, m.StartExcluding) <= 0 ||
+		m.EndIncluding != "" && compareGeneric(version, m.EndIncluding) > 0 ||
+		m.EndExcluding != "" && compareGeneric(version, m.EndExcluding) >= 0 {
+		return false
+	}
+	return true
+}
+
+// preRelease ranks the words that mark versions before a release
+var preRelease = map[string]int{"dev": 1, "snapshot": 1, "alpha": 2, "beta": 3, "pre": 4, "preview": 4, "rc": 5}
+
+// versionTokens splits a version into runs of digits and of letters
+func versionTokens(v string) []string {
+	var tokens []string
+	start := -1
+	kind := 0
+	for i := 0; i <= len(v); i++ {
+		k := 0
+		if i < len(v) {
+			switch c := v[i]; {
+			case c >= '0' && c <= '9':
+				k = 1
+			case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+				k = 2
+			}
+		}
+		if k != kind {
+			if kind != 0 {
+				tokens = append(tokens, strings.ToLower(v[start:i]))
+			}
+			start, kind = i, k
+		}
+	}
+	return tokens
+}
+
+// compareGeneric compares versions of software without a package manager
+// of its own: numbers compare as numbers, a release is later than its
+// alpha, beta or rc and earlier than its letter or patch suffixes, so
+// 1.0rc1 < 1.0 < 1.0a and 8.2 < 8.2p1 < 8.3
+func compareGeneric(a, b string) int {
+	ta, tb := versionTokens(a), versionTokens(b)
+	for i := 0; i < len(ta) || i < len(tb); i++ {
+		if i >= len(ta) || i >= len(tb) {
+			// The longer version is earlier if what it adds is a pre-release
+			longer, sign := tb, -1
+			if i < len(ta) {
+				longer, sign = ta, 1
+			}
+			if preRelease[longer[i]] > 0 {
+				return -sign
+			}
+			return sign
+		}
+		x, y := ta[i], tb[i]
+		xNum, yNum := x[0] >= '0' && x[0] <= '9', y[0] >= '0' && y[0] <= '9'
+		switch {
+		case xNum && yNum:
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				return compareInts(len(x), len(y))
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		case xNum != yNum:
+			// A number is later than a pre-release, earlier than a suffix
+			word := y
+			if yNum {
+				word = x
+			}
+			later := preRelease[word] > 0
+			if xNum == later {
+				return 1
+			}
+			return -1
+		default:
+			rx, ry := preRelease[x], preRelease[y]
+			if rx == 0 {
+				rx = 10
+			}
+			if ry == 0 {
+				ry = 10
+			}
+			if rx != ry {
+				return compareInts(rx, ry)
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,346 @@
+package vulndb
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sentra/internal/container"
+	"sentra/internal/cvss"
+)
+
+// Advisory is an OSV advisory
+type Advisory struct {
+	ID        string   `json:"id"`
+	Aliases   []string `json:"aliases"`
+	Summary   string   `json:"summary"`
+	Details   string   `json:"details"`
+	Published string   `json:"published"`
+	Modified  string   `json:"modified"`
+	Severity  []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions []string `json:"versions"`
+	} `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// osvIndex holds the advisories of an ecosystem's export by package name
+// and by id and alias
+type osvIndex struct {
+	packages map[string][]*Advisory
+	ids      map[string][]*Advisory
+}
+
+// baseEcosystem is the ecosystem an export covers, Debian for Debian:12
+func baseEcosystem(eco string) string {
+	base, _, _ := strings.Cut(eco, ":")
+	return base
+}
+
+// packageName normalizes a package name as its ecosystem compares them
+func packageName(eco, name string) string {
+	if baseEcosystem(eco) == "PyPI" {
+		return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+	}
+	return name
+}
+
+// ecosystemIndex reads the export of an ecosystem, nil when the database
+// has none. The lock must be held.
+func (db *DB) ecosystemIndex(base string) (*osvIndex, error) {
+	if index, ok := db.osv[base]; ok {
+		return index, nil
+	}
+	file := filepath.Join(db.dir, "osv", base+".zip")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	index := &osvIndex{packages: map[string][]*Advisory{}, ids: map[string][]*Advisory{}}
+	err := eachAdvisory(file, func(data []byte) {
+		var a Advisory
+		if json.Unmarshal(data, &a) != nil || a.ID == "" {
+			return
+		}
+		seen := map[string]bool{}
+		for _, affected := range a.Affected {
+			name := packageName(affected.Package.Ecosystem, affected.Package.Name)
+			if !seen[name] {
+				seen[name] = true
+				index.packages[name] = append(index.packages[name], &a)
+			}
+		}
+		for _, id := range append([]string{a.ID}, a.Aliases...) {
+			id = strings.ToUpper(id)
+			index.ids[id] = append(index.ids[id], &a)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if db.osv == nil {
+		db.osv = map[string]*osvIndex{}
+	}
+	db.osv[base] = index
+	return index, nil
+}
+
+// eachAdvisory calls fn with every advisory of an OSV export zip
+func eachAdvisory(file string, fn func([]byte)) error {
+	z, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err == nil {
+			fn(data)
+		}
+	}
+	return nil
+}
+
+// advisories returns the advisories of every export that are or alias an
+// id. The lock must be held.
+func (db *DB) advisories(id string) ([]*Advisory, error) {
+	files, _ := filepath.Glob(filepath.Join(db.dir, "osv", "*.zip"))
+	sort.Strings(files)
+	var found []*Advisory
+	for _, file := range files {
+		index, err := db.ecosystemIndex(strings.TrimSuffix(filepath.Base(file), ".zip"))
+		if err != nil {
+			return nil, err
+		}
+		if index != nil {
+			found = append(found, index.ids[id]...)
+		}
+	}
+	return found, nil
+}
+
+// matchOSV returns the advisories of an ecosystem affecting a package
+// version. The lock must be held.
+func (db *DB) matchOSV(p Product) ([]Match, error) {
+	index, err := db.ecosystemIndex(baseEcosystem(p.Ecosystem))
+	if err != nil || index == nil {
+		return nil, err
+	}
+	name := packageName(p.Ecosystem, p.Name)
+	var matches []Match
+	for _, a := range index.packages[name] {
+		hit, fixed := a.affects(p.Ecosystem, name, p.Version)
+		if !hit {
+			continue
+		}
+		m := Match{
+			CVE:       a.ID,
+			Advisory:  a.ID,
+			Source:    "osv",
+			Product:   p.Name,
+			Version:   p.Version,
+			Summary:   a.Summary,
+			Published: a.Published,
+			Criteria:  p.Ecosystem + "/" + p.Name,
+			Fixed:     fixed,
+		}
+		for _, alias := range a.Aliases {
+			if strings.HasPrefix(alias, "CVE-") && m.CVE == a.ID {
+				m.CVE = alias
+			} else {
+				m.Aliases = append(m.Aliases, alias)
+			}
+		}
+		if m.CVE != a.ID {
+			m.Aliases = append(m.Aliases, a.ID)
+		}
+		if m.Summary == "" {
+			m.Summary = a.Details
+		}
+		m.score(a.vector())
+		if m.Vector == "" && db.cves[m.CVE] != nil {
+			// The NVD scores the CVEs advisories leave unscored
+			m.score(db.cves[m.CVE].Vector)
+		}
+		if m.Severity == "unknown" {
+			m.Severity = a.rating()
+		}
+		matches = append(matches, m)
+	}
+	sortMatches(matches)
+	return matches, nil
+}
+
+// vector returns the CVSS 4 or 3 vector of an advisory
+func (a *Advisory) vector() string {
+	for _, kind := range []string{"CVSS_V4", "CVSS_V3"} {
+		for _, s := range a.Severity {
+			if s.Type == kind {
+				return s.Score
+			}
+		}
+	}
+	return ""
+}
+
+// rating returns the severity the advisory's database gives it, as GitHub
+// does for its advisories
+func (a *Advisory) rating() string {
+	s, _ := a.DatabaseSpecific["severity"].(string)
+	switch s = strings.ToLower(s); s {
+	case "moderate":
+		return "medium"
+	case "critical", "high", "medium", "low":
+		return s
+	}
+	return "unknown"
+}
+
+// compareIn compares versions as the package manager of an ecosystem does
+func compareIn(eco, a, b string) int {
+	switch baseEcosystem(eco) {
+	case "Debian", "Ubuntu":
+		return container.CompareVersions("dpkg", a, b)
+	case "Alpine", "Wolfi", "Chainguard":
+		return container.CompareVersions("apk", a, b)
+	case "Rocky Linux", "AlmaLinux", "Red Hat", "SUSE", "openSUSE", "Mageia":
+		return container.CompareVersions("rpm", a, b)
+	}
+	return compareGeneric(a, b)
+}
+
+// affects reports whether an advisory affects a version of a package of an
+// ecosystem, and the version that fixes it if there is one
+func (a *Advisory) affects(eco, name, version string) (bool, string) {
+	for _, affected := range a.Affected {
+		if affected.Package.Ecosystem != eco || packageName(eco, affected.Package.Name) != name {
+			continue
+		}
+		for _, listed := range affected.Versions {
+			if listed == version {
+				return true, ""
+			}
+		}
+		for _, r := range affected.Ranges {
+			if r.Type != "ECOSYSTEM" && r.Type != "SEMVER" {
+				continue
+			}
+			if hit, fixed := inRange(eco, r.Events, version); hit {
+				return true, fixed
+			}
+		}
+	}
+	return false, ""
+}
+
+// inRange reports whether a version falls between an introduced event and
+// the fixed or last_affected event after it
+func inRange(eco string, events []map[string]string, version string) (bool, string) {
+	type event struct{ kind, version string }
+	var list []event
+	for _, e := range events {
+		for k, v := range e {
+			list = append(list, event{k, v})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].version == "0" || list[j].version == "0" {
+			return list[i].version == "0" && list[j].version != "0"
+		}
+		return compareIn(eco, list[i].version, list[j].version) < 0
+	})
+	affected := false
+	for _, e := range list {
+		switch e.kind {
+		case "introduced":
+			if e.version == "0" || compareIn(eco, version, e.version) >= 0 {
+				affected = true
+			}
+		case "fixed":
+			if compareIn(eco, version, e.version) >= 0 {
+				affected = false
+			} else if affected {
+				return true, e.version
+			}
+		case "last_affected":
+			if compareIn(eco, version, e.version) > 0 {
+				affected = false
+			} else if affected {
+				return true, ""
+			}
+		}
+	}
+	return affected, ""
+}
+
+// AdvisoryToMap converts an advisory to a map for the VM
+func AdvisoryToMap(a *Advisory) map[string]interface{} {
+	aliases := make([]interface{}, len(a.Aliases))
+	for i, alias := range a.Aliases {
+		aliases[i] = alias
+	}
+	packages := []interface{}{}
+	for _, affected := range a.Affected {
+		packages = append(packages, affected.Package.Ecosystem+"/"+affected.Package.Name)
+	}
+	m := map[string]interface{}{
+		"id":          a.ID,
+		"aliases":     aliases,
+		"summary":     a.Summary,
+		"details":     a.Details,
+		"published":   a.Published,
+		"modified":    a.Modified,
+		"packages":    packages,
+		"cvss_vector": a.vector(),
+		"severity":    a.rating(),
+	}
+	if s, err := cvss.Calculate(a.vector()); err == nil {
+		m["severity"] = s.Severity
+	}
+	return m
+}
+
+// LookupToMap converts what Lookup found of a vulnerability to a map for
+// the VM, described by the NVD or, for those it lacks, by the advisories.
+// It returns nil when nothing was found.
+func LookupToMap(id string, c *CVE, advisories []*Advisory) map[string]interface{} {
+	if c == nil && len(advisories) == 0 {
+		return nil
+	}
+	if c == nil {
+		a := advisories[0]
+		c = &CVE{ID: strings.ToUpper(id), Description: a.Summary, Published: a.Published, Modified: a.Modified, Vector: a.vector()}
+		if c.Description == "" {
+			c.Description = a.Details
+		}
+	}
+	m := CVEToMap(c)
+	list := make([]interface{}, len(advisories))
+	for i, a := range advisories {
+		list[i] = AdvisoryToMap(a)
+	}
+	m["advisories"] = list
+	return m
+}
@@ -0,0 +1,375 @@
+package vulndb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nvdFeeds and osvFeeds are where the NVD's CVE feeds and OSV's exports
+// are downloaded from; tests point them elsewhere
+var (
+	nvdFeeds = "https://nvd.nist.gov/feeds/json/cve/2.0"
+	osvFeeds = "https://osv-vulnerabilities.storage.googleapis.com"
+)
+
+// firstFeedYear is the year of the oldest NVD feed
+const firstFeedYear = 2002
+
+// modifiedWindow is how far back the NVD's feed of modified CVEs goes;
+// a database updated longer ago downloads every yearly feed again
+const modifiedWindow = 7 * 24 * time.Hour
+
+// nvdFeed is an NVD CVE feed in the 2.0 format, as the NVD's API answers
+type nvdFeed struct {
+	Vulnerabilities []struct {
+		CVE struct {
+			ID           string `json:"id"`
+			Published    string `json:"published"`
+			LastModified string `json:"lastModified"`
+			VulnStatus   string `json:"vulnStatus"`
+			Descriptions []struct {
+				Lang  string `json:"lang"`
+				Value string `json:"value"`
+			} `json:"descriptions"`
+			Metrics map[string][]struct {
+				Type     string `json:"type"`
+				CVSSData struct {
+					VectorString string `json:"vectorString"`
+				} `json:"cvssData"`
+			} `json:"metrics"`
+			Weaknesses []struct {
+				Description []struct {
+					Value string `json:"value"`
+				} `json:"description"`
+			} `json:"weaknesses"`
+			Configurations []struct {
+				Operator string `json:"operator"`
+				Nodes    []struct {
+					Operator string `json:"operator"`
+					Negate   bool   `json:"negate"`
+					CPEMatch []struct {
+						Vulnerable            bool   `json:"vulnerable"`
+						Criteria              string `json:"criteria"`
+						VersionStartIncluding string `json:"versionStartIncluding"`
+						VersionStartExcluding string `json:"versionStartExcluding"`
+						VersionEndIncluding   string `json:"versionEndIncluding"`
+						VersionEndExcluding   string `json:"versionEndExcluding"`
+					} `json:"cpeMatch"`
+				} `json:"nodes"`
+			} `json:"configurations"`
+			References []struct {
+				URL string `json:"url"`
+			} `json:"references"`
+		} `json:"cve"`
+	} `json:"vulnerabilities"`
+}
+
+// UpdateOptions configure how Update downloads
+type UpdateOptions struct {
+	Timeout time.Duration // For each download
+	Full    bool          // Download every yearly NVD feed even if the modified one would do
+}
+
+// Update downloads the sources given, nvd for the NVD's CVE feeds and
+// osv:<ecosystem> for the OSV export of an ecosystem, into the database.
+// The NVD feeds are downloaded in full the first time, and only the feed
+// of recently modified CVEs after.
+func (db *DB) Update(sources []string, opts UpdateOptions) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	for _, source := range sources {
+		switch {
+		case source == "nvd":
+			if err := db.updateNVD(client, opts.Full); err != nil {
+				return err
+			}
+		case strings.HasPrefix(source, "osv:") && len(source) > len("osv:"):
+			if err := db.updateOSV(client, baseEcosystem(source[len("osv:"):])); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown source %q, expected nvd or osv:<ecosystem>", source)
+		}
+	}
+	return nil
+}
+
+func (db *DB) updateNVD(client *http.Client, full bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.load(); err != nil {
+		return err
+	}
+	started := time.Now().UTC()
+	feeds := []string{"modified"}
+	if full || db.updated.IsZero() || started.Sub(db.updated) > modifiedWindow {
+		feeds = nil
+		for year := firstFeedYear; year <= started.Year(); year++ {
+			feeds = append(feeds, fmt.Sprint(year))
+		}
+		feeds = append(feeds, "modified")
+	}
+	for _, name := range feeds {
+		if err := db.downloadFeed(client, name); err != nil {
+			// What was merged is dropped, to read the index again
+			db.cves = nil
+			return err
+		}
+	}
+	db.updated = started
+	db.index()
+	return db.save()
+}
+
+// downloadFeed merges an NVD feed, a year's or the modified one, into
+// the database
+func (db *DB) downloadFeed(client *http.Client, name string) error {
+	resp, err := client.Get(nvdFeeds + "/nvdcve-2.0-" + name + ".json.gz")
+	if err != nil {
+		return fmt.Errorf("failed to download the NVD %s feed: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download the NVD %s feed: %s", name, resp.Status)
+	}
+	if err := db.mergeFeed(resp.Body, true); err != nil {
+		return fmt.Errorf("NVD %s feed: %v", name, err)
+	}
+	return nil
+}
+
+func (db *DB) updateOSV(client *http.Client, base string) error {
+	resp, err := client.Get(osvFeeds + "/" + url.PathEscape(base) + "/all.zip")
+	if err != nil {
+		return fmt.Errorf("failed to download the %s OSV export: %w", base, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download the %s OSV export: %s", base, resp.Status)
+	}
+	return db.storeExport(base, resp.Body)
+}
+
+// storeExport writes the OSV export of an ecosystem into the database
+func (db *DB) storeExport(base string, r io.Reader) error {
+	dir := filepath.Join(db.dir, "osv")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, base+"-*.zip")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	tmp.Close()
+	if err == nil {
+		// Check it is an export before it replaces the one there is
+		err = eachAdvisory(tmp.Name(), func([]byte) {})
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, base+".zip"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%s OSV export: %v", base, err)
+	}
+	db.mu.Lock()
+	delete(db.osv, base)
+	db.mu.Unlock()
+	return nil
+}
+
+// mergeFeed adds the CVEs of a feed, gzipped or not, to the database,
+// replacing older records of them; index must be called after. The lock
+// must be held.
+func (db *DB) mergeFeed(r io.Reader, gzipped bool) error {
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var feed nvdFeed
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return err
+	}
+	for _, v := range feed.Vulnerabilities {
+		item := v.CVE
+		if item.ID == "" {
+			continue
+		}
+		if old := db.cves[item.ID]; old != nil && old.Modified > item.LastModified {
+			continue
+		}
+		if item.VulnStatus == "Rejected" {
+			delete(db.cves, item.ID)
+			continue
+		}
+		c := &CVE{ID: item.ID, Published: item.Published, Modified: item.LastModified}
+		for _, d := range item.Descriptions {
+			if d.Lang == "en" {
+				c.Description = d.Value
+			}
+		}
+		for _, kind := range []string{"cvssMetricV40", "cvssMetricV31", "cvssMetricV30"} {
+			// The NVD's own score, or the first other one
+			for _, m := range item.Metrics[kind] {
+				if m.Type == "Primary" || c.Vector == "" {
+					c.Vector = m.CVSSData.VectorString
+				}
+			}
+			if c.Vector != "" {
+				break
+			}
+		}
+		for _, w := range item.Weaknesses {
+			for _, d := range w.Description {
+				if strings.HasPrefix(d.Value, "CWE-") {
+					c.CWEs = append(c.CWEs, d.Value)
+				}
+			}
+		}
+		for _, ref := range item.References {
+			c.References = append(c.References, ref.URL)
+		}
+		for _, conf := range item.Configurations {
+			cfg := Configuration{Operator: conf.Operator}
+			for _, n := range conf.Nodes {
+				node := Node{Operator: n.Operator, Negate: n.Negate}
+				for _, m := range n.CPEMatch {
+					node.Matches = append(node.Matches, CPEMatch{
+						Vulnerable:     m.Vulnerable,
+						Criteria:       m.Criteria,
+						StartIncluding: m.VersionStartIncluding,
+						StartExcluding: m.VersionStartExcluding,
+						EndIncluding:   m.VersionEndIncluding,
+						EndExcluding:   m.VersionEndExcluding,
+					})
+				}
+				cfg.Nodes = append(cfg.Nodes, node)
+			}
+			c.Configurations = append(c.Configurations, cfg)
+		}
+		db.cves[c.ID] = c
+	}
+	return nil
+}
+
+// Import adds files copied from a connected host to the database: NVD
+// feeds (.json or .json.gz) and OSV exports (.zip), or a directory of
+// them. It is how hosts without a connection get their data.
+func (db *DB) Import(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		files = nil
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() && (strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz") || strings.HasSuffix(name, ".zip")) {
+				files = append(files, filepath.Join(path, name))
+			}
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("%s holds no NVD feeds or OSV exports", path)
+		}
+	}
+
+	feeds := 0
+	db.mu.Lock()
+	if err := db.load(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".zip") {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		err = db.mergeFeed(f, strings.HasSuffix(file, ".gz"))
+		f.Close()
+		if err != nil {
+			db.cves = nil
+			db.mu.Unlock()
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		feeds++
+	}
+	if feeds > 0 {
+		// The data is as recent as the last CVE it modified
+		for _, c := range db.cves {
+			if len(c.Modified) >= 19 {
+				if t, err := time.Parse("2006-01-02T15:04:05", c.Modified[:19]); err == nil && t.After(db.updated) {
+					db.updated = t
+				}
+			}
+		}
+		db.index()
+		if err := db.save(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	db.mu.Unlock()
+
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".zip") {
+			continue
+		}
+		base, err := exportEcosystem(file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = db.storeExport(base, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportEcosystem returns the ecosystem of an OSV export, from the first
+// package its advisories name
+func exportEcosystem(file string) (string, error) {
+	base := ""
+	err := eachAdvisory(file, func(data []byte) {
+		var a Advisory
+		if base == "" && json.Unmarshal(data, &a) == nil && len(a.Affected) > 0 {
+			base = baseEcosystem(a.Affected[0].Package.Ecosystem)
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", file, err)
+	}
+	if base == "" {
+		return "", fmt.Errorf("%s is not an OSV export", file)
+	}
+	return base, nil
+}
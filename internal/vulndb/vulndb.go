@@ -0,0 +1,494 @@
+// Package vulndb is a local database of vulnerabilities, built from the
+// NVD's CVE feeds and OSV's exports, that matches products and package
+// versions to the vulnerabilities affecting them without going online
+package vulndb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sentra/internal/cvss"
+)
+
+// CVE is what the database keeps of an NVD CVE record
+type CVE struct {
+	ID             string          `json:"id"`
+	Description    string          `json:"description"`
+	Published      string          `json:"published"`
+	Modified       string          `json:"modified"`
+	Vector         string          `json:"vector,omitempty"` // The primary CVSS 4.0, 3.1 or 3.0 vector
+	CWEs           []string        `json:"cwes,omitempty"`
+	References     []string        `json:"references,omitempty"`
+	Configurations []Configuration `json:"configurations,omitempty"`
+}
+
+// Configuration is a set of products a CVE affects. Nodes joined by AND
+// name the platforms a vulnerable product must run on.
+type Configuration struct {
+	Operator string `json:"operator,omitempty"`
+	Nodes    []Node `json:"nodes"`
+}
+
+// Node lists CPE matches, any or all of which must hold
+type Node struct {
+	Operator string     `json:"operator,omitempty"`
+	Negate   bool       `json:"negate,omitempty"`
+	Matches  []CPEMatch `json:"matches"`
+}
+
+// CPEMatch is a CPE name, with a range of versions when its version is *
+type CPEMatch struct {
+	Vulnerable     bool   `json:"vulnerable"`
+	Criteria       string `json:"criteria"`
+	StartIncluding string `json:"start_including,omitempty"`
+	StartExcluding string `json:"start_excluding,omitempty"`
+	EndIncluding   string `json:"end_including,omitempty"`
+	EndExcluding   string `json:"end_excluding,omitempty"`
+}
+
+// Product is a product version to match: a CPE name, a product and
+// version with an optional vendor, or a package of an OSV ecosystem
+type Product struct {
+	CPE       string
+	Vendor    string
+	Name      string
+	Version   string
+	Ecosystem string // PyPI, npm, Go, Debian:12, Alpine:v3.19...
+}
+
+// Match is a vulnerability affecting a product
+type Match struct {
+	CVE        string   // The CVE, or the advisory of those without one
+	Advisory   string   // The OSV advisory
+	Aliases    []string // Other ids of the vulnerability
+	Source     string   // nvd or osv
+	Product    string
+	Version    string
+	Summary    string
+	Published  string
+	Vector     string
+	Score      float64
+	Severity   string
+	Criteria   string   // The CPE match or ecosystem package that matched
+	Fixed      string   // The first version fixing it, when known
+	Conditions []string // Platforms the product must run on to be affected
+}
+
+// Status describes what a database holds
+type Status struct {
+	Dir        string
+	NVDUpdated time.Time
+	CVEs       int
+	OSV        []EcosystemStatus
+}
+
+// EcosystemStatus describes the OSV export of an ecosystem
+type EcosystemStatus struct {
+	Ecosystem string
+	Updated   time.Time
+	Size      int64
+}
+
+// DB is a vulnerability database in a directory: nvd.json.gz, the CVEs of
+// the NVD feeds, and osv/<ecosystem>.zip, the OSV exports. A copy of the
+// directory works anywhere, which is how air-gapped hosts get one.
+type DB struct {
+	dir string
+
+	mu       sync.Mutex
+	loaded   time.Time // Modification time of the CVE index when it was read
+	updated  time.Time
+	cves     map[string]*CVE
+	products map[string][]*CVE // By CPE product
+	osv      map[string]*osvIndex
+}
+
+// nvdIndex is the CVE index file
+type nvdIndex struct {
+	Updated time.Time `json:"updated"`
+	CVEs    []*CVE    `json:"cves"`
+}
+
+var (
+	openMu sync.Mutex
+	opened = map[string]*DB{}
+)
+
+// DefaultDir is where the database is kept unless another directory is
+// given: sentra/vulndb in the user's cache directory
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sentra", "vulndb")
+}
+
+// Open returns the database in a directory, the default one if dir is
+// empty. Databases are read once and shared.
+func Open(dir string) *DB {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	dir = filepath.Clean(dir)
+	openMu.Lock()
+	defer openMu.Unlock()
+	if db := opened[dir]; db != nil {
+		return db
+	}
+	db := &DB{dir: dir}
+	opened[dir] = db
+	return db
+}
+
+func (db *DB) indexFile() string { return filepath.Join(db.dir, "nvd.json.gz") }
+
+// load reads the CVE index when it changed since it was last read. The
+// lock must be held.
+func (db *DB) load() error {
+	info, err := os.Stat(db.indexFile())
+	if os.IsNotExist(err) {
+		db.cves, db.products, db.updated, db.loaded = map[string]*CVE{}, map[string][]*CVE{}, time.Time{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if db.cves != nil && info.ModTime().Equal(db.loaded) {
+		return nil
+	}
+	f, err := os.Open(db.indexFile())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", db.indexFile(), err)
+	}
+	var index nvdIndex
+	if err := json.NewDecoder(gz).Decode(&index); err != nil {
+		return fmt.Errorf("%s: %v", db.indexFile(), err)
+	}
+	db.cves = make(map[string]*CVE, len(index.CVEs))
+	for _, c := range index.CVEs {
+		db.cves[c.ID] = c
+	}
+	db.index()
+	db.updated, db.loaded = index.Updated, info.ModTime()
+	return nil
+}
+
+// index indexes the CVEs by the products they name
+func (db *DB) index() {
+	db.products = map[string][]*CVE{}
+	for _, c := range db.cves {
+		seen := map[string]bool{}
+		for _, conf := range c.Configurations {
+			for _, n := range conf.Nodes {
+				for _, m := range n.Matches {
+					if cpe, err := ParseCPE(m.Criteria); err == nil && m.Vulnerable && !seen[cpe.Product] {
+						seen[cpe.Product] = true
+						db.products[cpe.Product] = append(db.products[cpe.Product], c)
+					}
+				}
+			}
+		}
+	}
+}
+
+// save writes the CVE index. The lock must be held.
+func (db *DB) save() error {
+	cves := make([]*CVE, 0, len(db.cves))
+	for _, c := range db.cves {
+		cves = append(cves, c)
+	}
+	sort.Slice(cves, func(i, j int) bool { return cves[i].ID < cves[j].ID })
+	if err := os.MkdirAll(db.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(db.dir, "nvd-*.json.gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(tmp)
+	err = json.NewEncoder(gz).Encode(nvdIndex{Updated: db.updated, CVEs: cves})
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), db.indexFile())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if info, err := os.Stat(db.indexFile()); err == nil {
+		db.loaded = info.ModTime()
+	}
+	return nil
+}
+
+// Status returns when the database was updated and what it holds
+func (db *DB) Status() (*Status, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	status := &Status{Dir: db.dir, NVDUpdated: db.updated, CVEs: len(db.cves), OSV: []EcosystemStatus{}}
+	files, _ := filepath.Glob(filepath.Join(db.dir, "osv", "*.zip"))
+	sort.Strings(files)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			status.OSV = append(status.OSV, EcosystemStatus{
+				Ecosystem: strings.TrimSuffix(filepath.Base(file), ".zip"),
+				Updated:   info.ModTime(),
+				Size:      info.Size(),
+			})
+		}
+	}
+	return status, nil
+}
+
+// Lookup returns a CVE, or nil when the database does not know it, and the
+// OSV advisories that are or alias it
+func (db *DB) Lookup(id string) (*CVE, []*Advisory, error) {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.load(); err != nil {
+		return nil, nil, err
+	}
+	advisories, err := db.advisories(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.cves[id], advisories, nil
+}
+
+// Match returns the vulnerabilities affecting a product version: those of
+// the NVD for a CPE name or product, those of OSV for an ecosystem package
+func (db *DB) Match(p Product) ([]Match, error) {
+	if p.Ecosystem != "" {
+		if p.Name == "" || p.Version == "" {
+			return nil, fmt.Errorf("a package of %s needs a name and version", p.Ecosystem)
+		}
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if err := db.load(); err != nil {
+			return nil, err
+		}
+		return db.matchOSV(p)
+	}
+
+	cpe, err := productCPE(p)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	var matches []Match
+	for _, c := range db.products[cpe.Product] {
+		criteria, conditions, ok := c.affects(cpe)
+		if !ok {
+			continue
+		}
+		m := Match{
+			CVE:        c.ID,
+			Source:     "nvd",
+			Product:    cpe.Product,
+			Version:    cpe.fullVersion(),
+			Summary:    c.Description,
+			Published:  c.Published,
+			Criteria:   criteria,
+			Conditions: conditions,
+		}
+		m.score(c.Vector)
+		matches = append(matches, m)
+	}
+	sortMatches(matches)
+	return matches, nil
+}
+
+// productCPE returns the CPE name of a product, built from its vendor,
+// name and version when it has none
+func productCPE(p Product) (CPE, error) {
+	if p.CPE == "" {
+		if p.Name == "" || p.Version == "" {
+			return CPE{}, fmt.Errorf("a product needs a CPE name, or a name and version")
+		}
+		vendor := "*"
+		if p.Vendor != "" {
+			vendor = strings.ToLower(strings.ReplaceAll(p.Vendor, " ", "_"))
+		}
+		return CPE{"*", vendor, strings.ToLower(strings.ReplaceAll(p.Name, " ", "_")), strings.ToLower(p.Version), "*", "*", "*", "*", "*", "*", "*"}, nil
+	}
+	cpe, err := ParseCPE(p.CPE)
+	if err != nil {
+		return CPE{}, err
+	}
+	if p.Version != "" && !specific(cpe.Version) {
+		cpe.Version = strings.ToLower(p.Version)
+	}
+	if !specific(cpe.Version) {
+		return CPE{}, fmt.Errorf("%s names no version", p.CPE)
+	}
+	return cpe, nil
+}
+
+// affects reports whether a CVE affects a product, with the CPE match
+// that says so and the platforms it must run on
+func (c *CVE) affects(p CPE) (string, []string, bool) {
+	for _, conf := range c.Configurations {
+		criteria := ""
+		var conditions []string
+		for _, n := range conf.Nodes {
+			if n.Negate {
+				continue
+			}
+			hit := ""
+			for _, m := range n.Matches {
+				if m.Vulnerable && m.matches(p) {
+					hit = m.Criteria
+					break
+				}
+			}
+			if hit != "" && criteria == "" {
+				criteria = hit
+				continue
+			}
+			if strings.EqualFold(conf.Operator, "AND") {
+				for _, m := range n.Matches {
+					conditions = append(conditions, m.Criteria)
+				}
+			}
+		}
+		if criteria != "" {
+			return criteria, conditions, true
+		}
+	}
+	return "", nil, false
+}
+
+// score rates a match from its CVSS vector
+func (m *Match) score(vector string) {
+	m.Vector = vector
+	m.Severity = "unknown"
+	if s, err := cvss.Calculate(vector); err == nil {
+		m.Score, m.Severity = s.Score, s.Severity
+	}
+}
+
+// sortMatches puts the most severe matches first
+func sortMatches(matches []Match) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].CVE < matches[j].CVE
+	})
+}
+
+// CVEToMap converts a CVE to a map for the VM
+func CVEToMap(c *CVE) map[string]interface{} {
+	cwes := make([]interface{}, len(c.CWEs))
+	for i, cwe := range c.CWEs {
+		cwes[i] = cwe
+	}
+	refs := make([]interface{}, len(c.References))
+	for i, ref := range c.References {
+		refs[i] = ref
+	}
+	cpes := []interface{}{}
+	for _, conf := range c.Configurations {
+		for _, n := range conf.Nodes {
+			for _, m := range n.Matches {
+				if m.Vulnerable {
+					cpes = append(cpes, cpeMatchToMap(m))
+				}
+			}
+		}
+	}
+	m := map[string]interface{}{
+		"cve":         c.ID,
+		"description": c.Description,
+		"published":   c.Published,
+		"modified":    c.Modified,
+		"cvss_vector": c.Vector,
+		"cwes":        cwes,
+		"references":  refs,
+		"cpes":        cpes,
+	}
+	m["severity"], m["cvss_score"] = "unknown", 0.0
+	if s, err := cvss.Calculate(c.Vector); err == nil {
+		m["severity"], m["cvss_score"] = s.Severity, s.Score
+	}
+	return m
+}
+
+func cpeMatchToMap(m CPEMatch) map[string]interface{} {
+	return map[string]interface{}{
+		"criteria":        m.Criteria,
+		"start_including": m.StartIncluding,
+		"start_excluding": m.StartExcluding,
+		"end_including":   m.EndIncluding,
+		"end_excluding":   m.EndExcluding,
+	}
+}
+
+// MatchToMap converts a match to a map for the VM, shaped as a finding
+func MatchToMap(m Match) map[string]interface{} {
+	aliases := make([]interface{}, len(m.Aliases))
+	for i, a := range m.Aliases {
+		aliases[i] = a
+	}
+	conditions := make([]interface{}, len(m.Conditions))
+	for i, c := range m.Conditions {
+		conditions[i] = c
+	}
+	title := m.CVE + " in " + m.Product + " " + m.Version
+	return map[string]interface{}{
+		"title":       title,
+		"cve":         m.CVE,
+		"advisory":    m.Advisory,
+		"aliases":     aliases,
+		"source":      m.Source,
+		"product":     m.Product,
+		"version":     m.Version,
+		"description": m.Summary,
+		"published":   m.Published,
+		"cvss_vector": m.Vector,
+		"cvss_score":  m.Score,
+		"severity":    m.Severity,
+		"criteria":    m.Criteria,
+		"fixed":       m.Fixed,
+		"conditions":  conditions,
+	}
+}
+
+// StatusToMap converts a status to a map for the VM
+func StatusToMap(s *Status) map[string]interface{} {
+	osv := make([]interface{}, len(s.OSV))
+	for i, e := range s.OSV {
+		osv[i] = map[string]interface{}{"ecosystem": e.Ecosystem, "updated": e.Updated.Format(time.RFC3339), "size": e.Size}
+	}
+	updated := ""
+	if !s.NVDUpdated.IsZero() {
+		updated = s.NVDUpdated.Format(time.RFC3339)
+	}
+	return map[string]interface{}{"dir": s.Dir, "nvd_updated": updated, "cves": s.CVEs, "osv": osv}
+}
@@ -0,0 +1,216 @@
+package vulndb
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFeed = `{"format": "NVD_CVE", "version": "2.0", "vulnerabilities": [
+	{"cve": {"id": "CVE-2021-44228", "published": "2021-12-10T10:15:09.143", "lastModified": "2024-04-03T17:15:11.000", "vulnStatus": "Analyzed",
+		"descriptions": [{"lang": "en", "value": "Apache Log4j2 JNDI features do not protect against attacker controlled LDAP endpoints."}],
+		"metrics": {"cvssMetricV31": [{"source": "nvd@nist.gov", "type": "Primary", "cvssData": {"version": "3.1", "vectorString": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H"}}]},
+		"weaknesses": [{"description": [{"lang": "en", "value": "CWE-502"}]}],
+		"configurations": [{"nodes": [{"operator": "OR", "negate": false, "cpeMatch": [
+			{"vulnerable": true, "criteria": "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*", "versionStartIncluding": "2.0.1", "versionEndExcluding": "2.3.1"},
+			{"vulnerable": true, "criteria": "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*", "versionStartIncluding": "2.4.0", "versionEndExcluding": "2.12.2"},
+			{"vulnerable": true, "criteria": "cpe:2.3:a:apache:log4j:2.0:rc1:*:*:*:*:*:*"}]}]}],
+		"references": [{"url": "https://logging.apache.org/log4j/2.x/security.html"}]}},
+	{"cve": {"id": "CVE-2020-15778", "published": "2020-07-24T14:15:12.450", "lastModified": "2022-01-01T00:00:00.000", "vulnStatus": "Analyzed",
+		"descriptions": [{"lang": "en", "value": "scp in OpenSSH through 8.3p1 allows command injection."}],
+		"metrics": {"cvssMetricV31": [{"type": "Primary", "cvssData": {"vectorString": "CVSS:3.1/AV:N/AC:H/PR:N/UI:R/S:U/C:H/I:H/A:H"}}]},
+		"configurations": [{"operator": "AND", "nodes": [
+			{"operator": "OR", "cpeMatch": [{"vulnerable": true, "criteria": "cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*", "versionEndIncluding": "8.3"}]},
+			{"operator": "OR", "cpeMatch": [{"vulnerable": false, "criteria": "cpe:2.3:o:linux:linux_kernel:-:*:*:*:*:*:*:*"}]}]}]}},
+	{"cve": {"id": "CVE-2000-0001", "lastModified": "2000-01-01T00:00:00.000", "vulnStatus": "Rejected"}}]}`
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func osvExport(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	advisories := map[string]string{
+		"GHSA-jfh8-c2jp-5v3q.json": `{"id": "GHSA-jfh8-c2jp-5v3q", "aliases": ["CVE-2021-44228"], "summary": "Remote code injection in Log4j",
+			"affected": [{"package": {"ecosystem": "Maven", "name": "org.apache.logging.log4j:log4j-core"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "2.0-beta9"}, {"fixed": "2.3.1"}, {"introduced": "2.4"}, {"fixed": "2.12.2"}]}]}],
+			"database_specific": {"severity": "CRITICAL"}}`,
+		"GHSA-0000-0000-0001.json": `{"id": "GHSA-0000-0000-0001", "summary": "Moderate issue",
+			"affected": [{"package": {"ecosystem": "Maven", "name": "org.apache.logging.log4j:log4j-core"}, "versions": ["2.17.0"]}],
+			"database_specific": {"severity": "MODERATE"}}`,
+	}
+	for name, body := range advisories {
+		w, _ := z.Create(name)
+		w.Write([]byte(body))
+	}
+	z.Close()
+	return buf.Bytes()
+}
+
+func TestCompareGeneric(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"2.14.1", "2.3.1", 1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"8.2", "8.2p1", -1},
+		{"8.2p1", "8.3", -1},
+		{"2.0-beta9", "2.0", -1},
+		{"2.0-beta9", "2.0-rc1", -1},
+		{"1.02", "1.2", 0},
+	} {
+		if got := compareGeneric(c.a, c.b); got != c.want {
+			t.Errorf("compareGeneric(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestParseCPE(t *testing.T) {
+	cpe, err := ParseCPE(`cpe:2.3:a:openbsd:openssh:8.2p1:*:*:*:*:*:*:*`)
+	if err != nil || cpe.Vendor != "openbsd" || cpe.Product != "openssh" || cpe.Version != "8.2p1" {
+		t.Fatalf("cpe = %+v, %v", cpe, err)
+	}
+	cpe, err = ParseCPE(`cpe:2.3:a:microsoft:internet_explorer:8.0.6001:beta\:1`)
+	if err != nil || cpe.Update != "beta:1" || cpe.Other != "*" {
+		t.Fatalf("cpe = %+v, %v", cpe, err)
+	}
+	if s := cpe.String(); s != `cpe:2.3:a:microsoft:internet_explorer:8.0.6001:beta\:1:*:*:*:*:*:*` {
+		t.Errorf("String() = %s", s)
+	}
+	if _, err := ParseCPE("cpe:/a:apache:log4j:2.14.1"); err == nil {
+		t.Error("a CPE 2.2 URI was parsed")
+	}
+}
+
+func TestUpdateAndMatch(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/nvd/nvdcve-2.0-2021.json.gz":
+			w.Write(gzipped(t, testFeed))
+		case strings.HasPrefix(r.URL.Path, "/nvd/"):
+			w.Write(gzipped(t, `{"vulnerabilities": []}`))
+		case r.URL.Path == "/osv/Maven/all.zip":
+			w.Write(osvExport(t))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(nvd, osv string) { nvdFeeds, osvFeeds = nvd, osv }(nvdFeeds, osvFeeds)
+	nvdFeeds, osvFeeds = server.URL+"/nvd", server.URL+"/osv"
+
+	dir := t.TempDir()
+	db := Open(dir)
+	if err := db.Update([]string{"nvd", "osv:Maven"}, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/nvd/nvdcve-2.0-2002.json.gz"] != 1 || requests["/nvd/nvdcve-2.0-modified.json.gz"] != 1 {
+		t.Errorf("requests = %v", requests)
+	}
+	// Once up to date, only the modified feed is downloaded
+	if err := db.Update([]string{"nvd"}, UpdateOptions{}); err != nil || requests["/nvd/nvdcve-2.0-2002.json.gz"] != 1 || requests["/nvd/nvdcve-2.0-modified.json.gz"] != 2 {
+		t.Errorf("requests = %v, %v", requests, err)
+	}
+	if err := db.Update([]string{"exploitdb"}, UpdateOptions{}); err == nil || err.Error() != `unknown source "exploitdb", expected nvd or osv:<ecosystem>` {
+		t.Errorf("err = %v", err)
+	}
+	status, err := db.Status()
+	if err != nil || status.CVEs != 2 || len(status.OSV) != 1 || status.OSV[0].Ecosystem != "Maven" || status.NVDUpdated.IsZero() {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+
+	// Matching works offline, from a fresh copy of the database
+	delete(opened, filepath.Clean(dir))
+	db = Open(dir)
+	matches, err := db.Match(Product{CPE: "cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("2.14.1 matched %+v", matches)
+	}
+	matches, err = db.Match(Product{Name: "log4j", Version: "2.12.1"})
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("matches = %+v, %v", matches, err)
+	}
+	if m := matches[0]; m.CVE != "CVE-2021-44228" || m.Source != "nvd" || m.Score != 10 || m.Severity != "critical" || !strings.Contains(m.Summary, "JNDI") {
+		t.Errorf("match = %+v", m)
+	}
+	if matches, _ := db.Match(Product{Vendor: "apache", Name: "log4j", Version: "2.0-rc1"}); len(matches) != 1 {
+		t.Errorf("2.0-rc1 matched %+v", matches)
+	}
+	if matches, _ := db.Match(Product{Vendor: "qos", Name: "log4j", Version: "2.12.1"}); len(matches) != 0 {
+		t.Errorf("another vendor's log4j matched %+v", matches)
+	}
+
+	// Banner grabs give versions like 8.2p1
+	matches, err = db.Match(Product{CPE: `cpe:2.3:a:openbsd:openssh:8.2p1:*:*:*:*:*:*:*`})
+	if err != nil || len(matches) != 1 || len(matches[0].Conditions) != 1 || matches[0].Conditions[0] != "cpe:2.3:o:linux:linux_kernel:-:*:*:*:*:*:*:*" {
+		t.Errorf("matches = %+v, %v", matches, err)
+	}
+	if matches, _ := db.Match(Product{CPE: "cpe:2.3:a:openbsd:openssh:8.4:*:*:*:*:*:*:*"}); len(matches) != 0 {
+		t.Errorf("8.4 matched %+v", matches)
+	}
+	if _, err := db.Match(Product{CPE: "cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*"}); err == nil {
+		t.Error("a CPE without a version was matched")
+	}
+
+	matches, err = db.Match(Product{Ecosystem: "Maven", Name: "org.apache.logging.log4j:log4j-core", Version: "2.12.1"})
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("matches = %+v, %v", matches, err)
+	}
+	if m := matches[0]; m.CVE != "CVE-2021-44228" || m.Advisory != "GHSA-jfh8-c2jp-5v3q" || m.Fixed != "2.12.2" || m.Score != 10 {
+		t.Errorf("match = %+v", m)
+	}
+	matches, _ = db.Match(Product{Ecosystem: "Maven", Name: "org.apache.logging.log4j:log4j-core", Version: "2.17.0"})
+	if len(matches) != 1 || matches[0].CVE != "GHSA-0000-0000-0001" || matches[0].Severity != "medium" {
+		t.Errorf("matches = %+v", matches)
+	}
+	if matches, err := db.Match(Product{Ecosystem: "npm", Name: "lodash", Version: "4.17.20"}); err != nil || len(matches) != 0 {
+		t.Errorf("matches without an export = %+v, %v", matches, err)
+	}
+
+	cve, advisories, err := db.Lookup("cve-2021-44228")
+	if err != nil || cve == nil || cve.Vector != "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H" || len(cve.CWEs) != 1 || len(advisories) != 1 {
+		t.Errorf("lookup = %+v, %v, %v", cve, advisories, err)
+	}
+	if cve, _, _ := db.Lookup("CVE-2000-0001"); cve != nil {
+		t.Errorf("a rejected CVE was kept: %+v", cve)
+	}
+}
+
+func TestImport(t *testing.T) {
+	bundle := t.TempDir()
+	os.WriteFile(filepath.Join(bundle, "nvdcve-2.0-2021.json.gz"), gzipped(t, testFeed), 0o644)
+	os.WriteFile(filepath.Join(bundle, "maven.zip"), osvExport(t), 0o644)
+	os.WriteFile(filepath.Join(bundle, "README"), []byte("copied from the mirror"), 0o644)
+
+	db := Open(t.TempDir())
+	if err := db.Import(bundle); err != nil {
+		t.Fatal(err)
+	}
+	status, err := db.Status()
+	if err != nil || status.CVEs != 2 || len(status.OSV) != 1 || status.OSV[0].Ecosystem != "Maven" || status.NVDUpdated.Year() != 2024 {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+	if err := db.Import(t.TempDir()); err == nil || !strings.Contains(err.Error(), "holds no NVD feeds or OSV exports") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package webclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-ntlmssp"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// AuthConfig holds the credentials a client answers authentication
+// challenges with. Type is "basic", "ntlm" or "negotiate" for SPNEGO with
+// Kerberos. Kerberos takes a password, a keytab or the credential cache
+// of kinit, and finds the KDC from Krb5Conf, KDC or the DNS SRV records
+// of the realm.
+type AuthConfig struct {
+	Type     string
+	Username string // user, DOMAIN\user or user@domain
+	Password string
+	Domain   string
+	Hash     string // NT hash in hex, for NTLM instead of Password
+	Realm    string // Domain in upper case if empty
+	KDC      string
+	SPN      string // HTTP/<host> of the request if empty
+	Krb5Conf string
+	Keytab   string
+	CCache   string
+}
+
+// parseAuthConfig reads the auth map of a client config
+func parseAuthConfig(v interface{}) (*AuthConfig, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("auth must be a map")
+	}
+	auth := &AuthConfig{}
+	fields := map[string]*string{
+		"type": &auth.Type, "username": &auth.Username, "password": &auth.Password, "domain": &auth.Domain,
+		"hash": &auth.Hash, "realm": &auth.Realm, "kdc": &auth.KDC, "spn": &auth.SPN,
+		"krb5_conf": &auth.Krb5Conf, "keytab": &auth.Keytab, "ccache": &auth.CCache,
+	}
+	for key, value := range m {
+		field, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("auth: unknown option '%s'", key)
+		}
+		*field = fmt.Sprint(value)
+	}
+
+	auth.Type = strings.ToLower(auth.Type)
+	switch auth.Type {
+	case "basic", "ntlm":
+		if auth.Username == "" {
+			return nil, fmt.Errorf("auth: %s needs a username", auth.Type)
+		}
+	case "negotiate", "kerberos":
+		auth.Type = "negotiate"
+		if auth.CCache == "" && auth.Username == "" {
+			return nil, fmt.Errorf("auth: negotiate needs a username or a ccache")
+		}
+	default:
+		return nil, fmt.Errorf("auth: unknown type '%s', want basic, ntlm or negotiate", auth.Type)
+	}
+	if domain, user, ok := strings.Cut(auth.Username, `\`); ok {
+		auth.Domain, auth.Username = domain, user
+	}
+	if auth.Type == "negotiate" {
+		if user, realm, ok := strings.Cut(auth.Username, "@"); ok {
+			auth.Username, auth.Realm = user, strings.ToUpper(realm)
+		}
+		if auth.Realm == "" {
+			auth.Realm = strings.ToUpper(auth.Domain)
+		}
+	}
+	return auth, nil
+}
+
+// krb5Config returns the Kerberos configuration, loaded from Krb5Conf or
+// made for the realm
+func (a *AuthConfig) krb5Config() (*config.Config, error) {
+	if a.Krb5Conf != "" {
+		return config.Load(a.Krb5Conf)
+	}
+	if a.Realm == "" && a.CCache == "" {
+		return nil, fmt.Errorf("negotiate needs a realm, a domain or a krb5_conf")
+	}
+	conf := fmt.Sprintf("[libdefaults]\n default_realm = %s\n dns_lookup_kdc = true\n udp_preference_limit = 1\n", a.Realm)
+	if a.KDC != "" {
+		conf += fmt.Sprintf("[realms]\n %s = {\n  kdc = %s\n }\n", a.Realm, a.KDC)
+	}
+	return config.NewFromString(conf)
+}
+
+// withAuth wraps a transport to answer challenges with auth, if set
+func withAuth(t http.RoundTripper, auth *AuthConfig) http.RoundTripper {
+	if auth == nil {
+		return t
+	}
+	return &authTransport{base: t, auth: auth, hosts: make(map[string]bool)}
+}
+
+// authTransport answers the Basic, NTLM and Negotiate challenges of
+// servers with the credentials of a client
+type authTransport struct {
+	base http.RoundTripper
+	auth *AuthConfig
+
+	mu    sync.Mutex
+	krb   *client.Client
+	hosts map[string]bool // Hosts that accepted Basic or Kerberos, sent credentials up front
+}
+
+// RoundTrip sends a request and, when the server asks for the configured
+// scheme, sends it again with credentials. Credentials only go to servers
+// that ask for them.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	known := t.hosts[req.URL.Host]
+	t.mu.Unlock()
+	if known && t.auth.Type != "ntlm" {
+		return t.authenticate(req, body, "")
+	}
+
+	res, err := t.send(req, body, nil)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	scheme := t.scheme(res)
+	if scheme == "" {
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return t.authenticate(req, body, scheme)
+}
+
+// scheme returns the scheme of a 401 response to answer, if any
+func (t *authTransport) scheme(res *http.Response) string {
+	offered := map[string]bool{}
+	for _, v := range res.Header.Values("WWW-Authenticate") {
+		name, _, _ := strings.Cut(strings.TrimSpace(v), " ")
+		offered[strings.ToLower(name)] = true
+	}
+	switch {
+	case t.auth.Type == "basic" && offered["basic"]:
+		return "Basic"
+	case t.auth.Type == "ntlm" && offered["ntlm"]:
+		return "NTLM"
+	case (t.auth.Type == "ntlm" || t.auth.Type == "negotiate") && offered["negotiate"]:
+		return "Negotiate"
+	}
+	return ""
+}
+
+// authenticate sends a request with credentials for scheme, or for the
+// configured type if scheme is empty
+func (t *authTransport) authenticate(req *http.Request, body []byte, scheme string) (*http.Response, error) {
+	var res *http.Response
+	var err error
+	switch t.auth.Type {
+	case "basic":
+		res, err = t.send(req, body, func(r *http.Request) error {
+			r.SetBasicAuth(t.auth.Username, t.auth.Password)
+			return nil
+		})
+	case "ntlm":
+		return t.ntlm(req, body, scheme)
+	case "negotiate":
+		var cl *client.Client
+		if cl, err = t.kerberos(); err != nil {
+			return nil, err
+		}
+		res, err = t.send(req, body, func(r *http.Request) error {
+			return spnego.SetSPNEGOHeader(cl, r, t.auth.SPN)
+		})
+	}
+	if err == nil && res.StatusCode != http.StatusUnauthorized {
+		t.mu.Lock()
+		t.hosts[req.URL.Host] = true
+		t.mu.Unlock()
+	}
+	return res, err
+}
+
+// ntlm runs the NTLM handshake: a negotiate message, the server's
+// challenge and the authenticate message, over one connection
+func (t *authTransport) ntlm(req *http.Request, body []byte, scheme string) (*http.Response, error) {
+	header := func(msg []byte) func(*http.Request) error {
+		return func(r *http.Request) error {
+			r.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(msg))
+			return nil
+		}
+	}
+	negotiate, err := ntlmssp.NewNegotiateMessage(t.auth.Domain, "")
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.send(req, body, header(negotiate))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	var challenge []byte
+	for _, v := range res.Header.Values("WWW-Authenticate") {
+		if name, data, _ := strings.Cut(strings.TrimSpace(v), " "); strings.EqualFold(name, scheme) && data != "" {
+			challenge, _ = base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		}
+	}
+	if len(challenge) == 0 {
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	var authenticate []byte
+	if t.auth.Hash != "" {
+		authenticate, err = ntlmssp.ProcessChallengeWithHash(challenge, t.auth.Username, t.auth.Hash)
+	} else {
+		authenticate, err = ntlmssp.ProcessChallenge(challenge, t.auth.Username, t.auth.Password, !strings.Contains(t.auth.Username, "@"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NTLM: %v", err)
+	}
+	return t.send(req, body, header(authenticate))
+}
+
+// kerberos returns the Kerberos client, logging in the first time
+func (t *authTransport) kerberos() (*client.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.krb != nil {
+		return t.krb, nil
+	}
+	conf, err := t.auth.krb5Config()
+	if err != nil {
+		return nil, err
+	}
+	var cl *client.Client
+	switch {
+	case t.auth.CCache != "":
+		cc, err := credentials.LoadCCache(t.auth.CCache)
+		if err != nil {
+			return nil, fmt.Errorf("loading ccache: %v", err)
+		}
+		if cl, err = client.NewFromCCache(cc, conf, client.DisablePAFXFAST(true)); err != nil {
+			return nil, err
+		}
+	case t.auth.Keytab != "":
+		kt, err := keytab.Load(t.auth.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("loading keytab: %v", err)
+		}
+		cl = client.NewWithKeytab(t.auth.Username, t.auth.Realm, kt, conf, client.DisablePAFXFAST(true))
+	default:
+		cl = client.NewWithPassword(t.auth.Username, t.auth.Realm, t.auth.Password, conf, client.DisablePAFXFAST(true))
+	}
+	if t.auth.CCache == "" {
+		if err := cl.Login(); err != nil {
+			return nil, fmt.Errorf("Kerberos login failed: %v", err)
+		}
+	}
+	t.krb = cl
+	return cl, nil
+}
+
+// send sends a copy of a request with its body, after set adds its
+// credentials
+func (t *authTransport) send(req *http.Request, body []byte, set func(*http.Request) error) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	if set != nil {
+		if err := set(r); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,167 @@
+package webclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// utf16le encodes s as NTLM does
+func utf16le(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// ntlmField returns a field of an NTLM message given its offset in the
+// header
+func ntlmField(msg []byte, at int) []byte {
+	length := int(binary.LittleEndian.Uint16(msg[at:]))
+	offset := int(binary.LittleEndian.Uint32(msg[at+4:]))
+	if offset+length > len(msg) {
+		return nil
+	}
+	return msg[offset : offset+length]
+}
+
+// fakeNTLM serves a page that needs NTLM as CORP\alice with the password
+// Secret1, checking the NTLMv2 response, and sends the remote address of
+// each handshake message to conns
+func fakeNTLM(t *testing.T, conns chan<- string) *httptest.Server {
+	serverChallenge := []byte("8bytes!!")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, data, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		msg, _ := base64.StdEncoding.DecodeString(data)
+		if scheme != "NTLM" || len(msg) < 12 || !bytes.HasPrefix(msg, []byte("NTLMSSP\x00")) {
+			w.Header().Add("WWW-Authenticate", "Negotiate")
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conns <- r.RemoteAddr
+		switch binary.LittleEndian.Uint32(msg[8:]) {
+		case 1:
+			target := utf16le("CORP")
+			challenge := []byte("NTLMSSP\x00")
+			challenge = binary.LittleEndian.AppendUint32(challenge, 2)
+			challenge = binary.LittleEndian.AppendUint16(challenge, uint16(len(target)))
+			challenge = binary.LittleEndian.AppendUint16(challenge, uint16(len(target)))
+			challenge = binary.LittleEndian.AppendUint32(challenge, 48)
+			challenge = binary.LittleEndian.AppendUint32(challenge, 0x00080201) // Unicode, NTLM, extended session security
+			challenge = append(challenge, serverChallenge...)
+			challenge = append(challenge, make([]byte, 16)...) // Reserved and empty target info
+			challenge = append(challenge, target...)
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challenge))
+			w.WriteHeader(http.StatusUnauthorized)
+		case 3:
+			user, domain, response := ntlmField(msg, 36), ntlmField(msg, 28), ntlmField(msg, 20)
+			h := md4.New()
+			h.Write(utf16le("Secret1"))
+			mac := hmac.New(md5.New, h.Sum(nil))
+			mac.Write(utf16le("ALICE" + "CORP"))
+			mac = hmac.New(md5.New, mac.Sum(nil))
+			if len(response) > 16 {
+				mac.Write(serverChallenge)
+				mac.Write(response[16:])
+			}
+			if !bytes.Equal(user, utf16le("alice")) || !bytes.Equal(domain, utf16le("CORP")) || !bytes.Equal(mac.Sum(nil), response[:min(16, len(response))]) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("welcome alice"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNTLMAuth(t *testing.T) {
+	conns := make(chan string, 4)
+	srv := fakeNTLM(t, conns)
+	w := NewWebClientModule()
+	_, err := w.CreateClient("intranet", map[string]interface{}{
+		"auth": map[string]interface{}{"type": "ntlm", "username": `CORP\alice`, "password": "Secret1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := w.Request("intranet", &HTTPRequest{Method: "POST", URL: srv.URL + "/search", Body: "q=payroll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Body != "welcome alice" {
+		t.Errorf("got %d %q", res.StatusCode, res.Body)
+	}
+	if negotiate, authenticate := <-conns, <-conns; negotiate != authenticate {
+		t.Errorf("handshake moved from %s to %s", negotiate, authenticate)
+	}
+
+	w.CreateClient("wrong", map[string]interface{}{
+		"auth": map[string]interface{}{"type": "ntlm", "username": "alice", "domain": "CORP", "password": "wrong"},
+	})
+	if res, err := w.Request("wrong", &HTTPRequest{Method: "GET", URL: srv.URL}); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: %v %v", res, err)
+	}
+}
+
+func TestBasicAuthOnlyWhenAsked(t *testing.T) {
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("Authorization"))
+		if r.URL.Path == "/admin" && r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	w := NewWebClientModule()
+	if _, err := w.CreateClient("c", map[string]interface{}{"auth": map[string]interface{}{"type": "basic", "username": "admin", "password": "pw"}}); err != nil {
+		t.Fatal(err)
+	}
+	w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL + "/public"})
+	if sent[0] != "" {
+		t.Errorf("sent %q to a page that did not ask", sent[0])
+	}
+	res, err := w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL + "/admin"})
+	if err != nil || res.StatusCode != http.StatusOK || sent[2] != "Basic YWRtaW46cHc=" {
+		t.Errorf("got %v %v, sent %q", res, err, sent)
+	}
+}
+
+func TestParseAuthConfig(t *testing.T) {
+	auth, err := parseAuthConfig(map[string]interface{}{"type": "Kerberos", "username": "alice@corp.example", "password": "pw", "kdc": "dc01.corp.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Type != "negotiate" || auth.Username != "alice" || auth.Realm != "CORP.EXAMPLE" {
+		t.Errorf("got %+v", auth)
+	}
+	conf, err := auth.krb5Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.LibDefaults.DefaultRealm != "CORP.EXAMPLE" || len(conf.Realms) != 1 || conf.Realms[0].KDC[0] != "dc01.corp.example:88" {
+		t.Errorf("got %+v %+v", conf.LibDefaults.DefaultRealm, conf.Realms)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{"type": "digest", "username": "a"},
+		{"type": "ntlm"},
+		{"type": "basic", "username": "a", "token": "x"},
+	} {
+		if _, err := parseAuthConfig(bad); err == nil {
+			t.Errorf("accepted %v", bad)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package webclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Canary is an HTTP listener that records the requests targets make to
+// it, to catch server-side request forgery and other blind callbacks.
+// Each payload gets a token of its own in the path, so a hit names the
+// parameter that caused it.
+type Canary struct {
+	URL string // Base URL targets are made to fetch
+
+	srv  *http.Server
+	mu   sync.Mutex
+	hits map[string][]CanaryHit
+}
+
+// CanaryHit is a request a target made to a canary
+type CanaryHit struct {
+	Token      string
+	RemoteAddr string
+	Method     string
+	Path       string
+	UserAgent  string
+	Time       time.Time
+}
+
+// StartCanary listens on addr for callbacks. publicURL is the URL targets
+// reach the listener at, such as the address of a VPS that forwards to
+// it; http://<addr> if empty.
+func StartCanary(addr, publicURL string) (*Canary, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("canary: %v", err)
+	}
+	if publicURL == "" {
+		publicURL = "http://" + ln.Addr().String()
+	}
+	c := &Canary{URL: strings.TrimSuffix(publicURL, "/"), hits: make(map[string][]CanaryHit)}
+	c.srv = &http.Server{Handler: http.HandlerFunc(c.serve), ReadHeaderTimeout: 10 * time.Second}
+	go c.srv.Serve(ln)
+	return c, nil
+}
+
+// serve records a callback under the first segment of its path
+func (c *Canary) serve(w http.ResponseWriter, r *http.Request) {
+	token, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	c.mu.Lock()
+	c.hits[token] = append(c.hits[token], CanaryHit{
+		Token:      token,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		UserAgent:  r.UserAgent(),
+		Time:       time.Now(),
+	})
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// NewToken returns a URL with a new token for a payload, and the token
+func (c *Canary) NewToken() (string, string) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	return c.URL + "/" + token, token
+}
+
+// Hits returns the callbacks made with a token
+func (c *Canary) Hits(token string) []CanaryHit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CanaryHit(nil), c.hits[token]...)
+}
+
+// Close stops the listener
+func (c *Canary) Close() error {
+	return c.srv.Close()
+}
@@ -0,0 +1,457 @@
+package webclient
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// CrawlOptions limits what a crawl visits
+type CrawlOptions struct {
+	MaxDepth     int              // Links followed away from the start page; 3 if 0
+	MaxPages     int              // Pages requested; 100 if 0
+	Domains      []string         // Hosts in scope, or *.example.com for a domain and its subdomains; the start host if empty
+	Exclude      []*regexp.Regexp // URLs never requested, such as logout links
+	IgnoreRobots bool
+	Delay        time.Duration // Pause between requests
+}
+
+// CrawlPage is a page a crawl visited
+type CrawlPage struct {
+	URL         string
+	StatusCode  int
+	ContentType string
+	Depth       int
+	Title       string
+}
+
+// CrawlEndpoint is a URL that takes parameters, in its query string or
+// from a form
+type CrawlEndpoint struct {
+	URL    string // Without the query string
+	Method string
+	Params []string
+}
+
+// SiteMap is what a crawl found
+type SiteMap struct {
+	Start      string
+	Pages      []CrawlPage
+	Forms      []HTMLForm
+	Endpoints  []CrawlEndpoint
+	External   []string // Links out of scope, not followed
+	Disallowed []string // Links robots.txt asks crawlers not to follow
+}
+
+// Crawl follows the links of a site from a page with a client, so that
+// it carries the client's session, hooks and proxies. Pages are visited
+// breadth first, one at a time, within the scope of opts.
+func (w *WebClientModule) Crawl(clientID, start string, opts CrawlOptions) (*SiteMap, error) {
+	w.mu.RLock()
+	client, exists := w.Clients[clientID]
+	w.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("client not found: %s", clientID)
+	}
+	startURL, err := url.Parse(start)
+	if err != nil || (startURL.Scheme != "http" && startURL.Scheme != "https") || startURL.Host == "" {
+		return nil, fmt.Errorf("invalid start URL: %s", start)
+	}
+	startURL.Fragment = ""
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = 3
+	}
+	if opts.MaxPages == 0 {
+		opts.MaxPages = 100
+	}
+	domains := opts.Domains
+	if len(domains) == 0 {
+		domains = []string{startURL.Hostname()}
+	}
+
+	c := &crawler{
+		w: w, clientID: clientID, agent: client.UserAgent, opts: opts, domains: domains,
+		site:      &SiteMap{Start: startURL.String()},
+		seen:      map[string]bool{startURL.String(): true},
+		robots:    map[string]*robotsRules{},
+		external:  map[string]bool{},
+		forms:     map[string]bool{},
+		endpoints: map[string]*CrawlEndpoint{},
+	}
+	c.run(startURL)
+	c.finish()
+	return c.site, nil
+}
+
+// crawler is the state of one crawl
+type crawler struct {
+	w        *WebClientModule
+	clientID string
+	agent    string
+	opts     CrawlOptions
+	domains  []string
+	site     *SiteMap
+
+	seen      map[string]bool // URLs queued or visited
+	robots    map[string]*robotsRules
+	external  map[string]bool
+	forms     map[string]bool // Method and action of the forms found
+	endpoints map[string]*CrawlEndpoint
+}
+
+// queued is a page waiting to be visited
+type queued struct {
+	u     *url.URL
+	depth int
+}
+
+// run visits pages breadth first from start
+func (c *crawler) run(start *url.URL) {
+	queue := []queued{{start, 0}}
+	for len(queue) > 0 && len(c.site.Pages) < c.opts.MaxPages {
+		next := queue[0]
+		queue = queue[1:]
+		if !c.permitted(next.u) {
+			continue
+		}
+		if len(c.site.Pages) > 0 && c.opts.Delay > 0 {
+			time.Sleep(c.opts.Delay)
+		}
+		res, err := c.w.Request(c.clientID, &HTTPRequest{Method: "GET", URL: next.u.String()})
+		if err != nil {
+			c.site.Pages = append(c.site.Pages, CrawlPage{URL: next.u.String(), Depth: next.depth})
+			continue
+		}
+		page := CrawlPage{URL: next.u.String(), StatusCode: res.StatusCode, ContentType: res.ContentType, Depth: next.depth}
+		c.addEndpoint("GET", next.u, nil)
+
+		// Only follow the links of HTML pages that stayed in scope
+		landed, err := url.Parse(res.URL)
+		if err != nil || !c.inScope(landed) || !isHTML(res.ContentType) {
+			c.site.Pages = append(c.site.Pages, page)
+			continue
+		}
+		c.seen[landed.String()] = true
+		var links []*url.URL
+		page.Title, links = parseLinks(res.Body, landed)
+		c.site.Pages = append(c.site.Pages, page)
+
+		for _, form := range parseForms(res.Body, landed) {
+			c.addForm(form)
+		}
+		if next.depth >= c.opts.MaxDepth {
+			continue
+		}
+		for _, link := range links {
+			key := link.String()
+			if c.seen[key] {
+				continue
+			}
+			c.seen[key] = true
+			if !c.inScope(link) {
+				c.external[key] = true
+				continue
+			}
+			queue = append(queue, queued{link, next.depth + 1})
+		}
+	}
+}
+
+// permitted reports whether a URL may be requested, recording the URLs
+// robots.txt disallows
+func (c *crawler) permitted(u *url.URL) bool {
+	for _, re := range c.opts.Exclude {
+		if re.MatchString(u.String()) {
+			return false
+		}
+	}
+	if c.opts.IgnoreRobots {
+		return true
+	}
+	origin := u.Scheme + "://" + u.Host
+	rules, ok := c.robots[origin]
+	if !ok {
+		rules = &robotsRules{}
+		res, err := c.w.Request(c.clientID, &HTTPRequest{Method: "GET", URL: origin + "/robots.txt"})
+		if err == nil && res.StatusCode == 200 {
+			rules = parseRobots(res.Body, c.agent)
+		}
+		c.robots[origin] = rules
+	}
+	if !rules.allowed(u.EscapedPath()) {
+		c.site.Disallowed = append(c.site.Disallowed, u.String())
+		return false
+	}
+	return true
+}
+
+// inScope reports whether a URL's host is in the crawl's domains
+func (c *crawler) inScope(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range c.domains {
+		d = strings.ToLower(d)
+		if base, ok := strings.CutPrefix(d, "*."); ok {
+			if host == base || strings.HasSuffix(host, "."+base) {
+				return true
+			}
+		} else if host == d {
+			return true
+		}
+	}
+	return false
+}
+
+// addForm records a form the first time it is found, and its endpoint
+func (c *crawler) addForm(form HTMLForm) {
+	key := form.Method + " " + form.Action
+	if c.forms[key] {
+		return
+	}
+	c.forms[key] = true
+	c.site.Forms = append(c.site.Forms, form)
+	if action, err := url.Parse(form.Action); err == nil && c.inScope(action) {
+		c.addEndpoint(form.Method, action, form.Fields)
+	}
+}
+
+// addEndpoint records the parameters a URL takes with a method
+func (c *crawler) addEndpoint(method string, u *url.URL, params url.Values) {
+	if len(params) == 0 && len(u.Query()) == 0 {
+		return
+	}
+	bare := *u
+	bare.RawQuery, bare.Fragment = "", ""
+	key := method + " " + bare.String()
+	e, ok := c.endpoints[key]
+	if !ok {
+		e = &CrawlEndpoint{URL: bare.String(), Method: method}
+		c.endpoints[key] = e
+	}
+	names := map[string]bool{}
+	for _, p := range e.Params {
+		names[p] = true
+	}
+	for _, values := range []url.Values{u.Query(), params} {
+		for name := range values {
+			if !names[name] {
+				names[name] = true
+				e.Params = append(e.Params, name)
+			}
+		}
+	}
+	sort.Strings(e.Params)
+}
+
+// finish sorts what the crawl found
+func (c *crawler) finish() {
+	for _, e := range c.endpoints {
+		c.site.Endpoints = append(c.site.Endpoints, *e)
+	}
+	sort.Slice(c.site.Endpoints, func(i, j int) bool {
+		a, b := c.site.Endpoints[i], c.site.Endpoints[j]
+		return a.URL < b.URL || (a.URL == b.URL && a.Method < b.Method)
+	})
+	for u := range c.external {
+		c.site.External = append(c.site.External, u)
+	}
+	sort.Strings(c.site.External)
+}
+
+// isHTML reports whether a content type is HTML, taking a missing one as
+// HTML
+func isHTML(contentType string) bool {
+	return contentType == "" || strings.Contains(contentType, "html")
+}
+
+// parseLinks returns the title of a page and the links of its anchors,
+// areas and frames, resolved against the page URL or its base element and
+// without fragments
+func parseLinks(body string, base *url.URL) (string, []*url.URL) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", nil
+	}
+	var title string
+	var refs []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if title == "" {
+					title = strings.TrimSpace(text(n))
+				}
+			case "base":
+				if href := attr(n, "href"); href != "" {
+					if u, err := base.Parse(href); err == nil {
+						base = u
+					}
+				}
+			case "a", "area":
+				refs = append(refs, attr(n, "href"))
+			case "frame", "iframe":
+				refs = append(refs, attr(n, "src"))
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	var links []*url.URL
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			continue
+		}
+		if u, err := base.Parse(ref); err == nil {
+			u.Fragment = ""
+			links = append(links, u)
+		}
+	}
+	return title, links
+}
+
+// robotsRules are the Allow and Disallow rules of robots.txt for a
+// crawler, as path patterns with * and $
+type robotsRules struct {
+	allow, disallow []string
+}
+
+// parseRobots reads the rules of robots.txt for the group of the user
+// agent, or for * if no group names it
+func parseRobots(body, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+	groups := map[string]*robotsRules{}
+	var current []string
+	inAgents := false
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			name := strings.ToLower(value)
+			if groups[name] == nil {
+				groups[name] = &robotsRules{}
+			}
+			current = append(current, name)
+		case "allow", "disallow":
+			inAgents = false
+			if value == "" {
+				continue
+			}
+			for _, name := range current {
+				if key == "allow" {
+					groups[name].allow = append(groups[name].allow, value)
+				} else {
+					groups[name].disallow = append(groups[name].disallow, value)
+				}
+			}
+		default:
+			inAgents = false
+		}
+	}
+	for name, rules := range groups {
+		if name != "*" && name != "" && strings.Contains(agent, name) {
+			return rules
+		}
+	}
+	if rules, ok := groups["*"]; ok {
+		return rules
+	}
+	return &robotsRules{}
+}
+
+// allowed reports whether a path may be crawled: the longest matching
+// rule wins, and Allow wins a tie
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	longest := func(patterns []string) int {
+		n := -1
+		for _, p := range patterns {
+			if len(p) > n && robotsMatch(p, path) {
+				n = len(p)
+			}
+		}
+		return n
+	}
+	return longest(r.allow) >= longest(r.disallow)
+}
+
+// robotsMatch matches a path against a robots.txt pattern
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	re, err := regexp.Compile(expr)
+	return err == nil && re.MatchString(path)
+}
+
+// SiteMapToMap converts a site map to a map, with the URLs of its pages
+// and endpoints ready to hand to scans and fuzzers
+func SiteMapToMap(s *SiteMap) map[string]interface{} {
+	pages := make([]interface{}, len(s.Pages))
+	urls := make([]interface{}, len(s.Pages))
+	for i, p := range s.Pages {
+		pages[i] = map[string]interface{}{
+			"url": p.URL, "status_code": p.StatusCode, "content_type": p.ContentType, "depth": p.Depth, "title": p.Title,
+		}
+		urls[i] = p.URL
+	}
+	forms := make([]interface{}, len(s.Forms))
+	for i, f := range s.Forms {
+		fields := map[string]interface{}{}
+		for name := range f.Fields {
+			fields[name] = f.Fields.Get(name)
+		}
+		forms[i] = map[string]interface{}{"action": f.Action, "method": f.Method, "fields": fields}
+	}
+	endpoints := make([]interface{}, len(s.Endpoints))
+	for i, e := range s.Endpoints {
+		params := make([]interface{}, len(e.Params))
+		for j, p := range e.Params {
+			params[j] = p
+		}
+		endpoints[i] = map[string]interface{}{"url": e.URL, "method": e.Method, "params": params}
+	}
+	strs := func(list []string) []interface{} {
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return map[string]interface{}{
+		"start":      s.Start,
+		"pages":      pages,
+		"urls":       urls,
+		"forms":      forms,
+		"endpoints":  endpoints,
+		"external":   strs(s.External),
+		"disallowed": strs(s.Disallowed),
+	}
+}
@@ -0,0 +1,131 @@
+package webclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeSite serves a small site with a robots.txt, a form, links with
+// parameters, an external link and a logout link, and counts the requests
+// for each path in hits
+func fakeSite(t *testing.T, hits map[string]int) *httptest.Server {
+	pages := map[string]string{
+		"/":         `<title>Home</title><a href="/products?id=1#top">Product</a> <a href="/about">About</a> <a href="https://cdn.example.net/lib.js">CDN</a> <a href="mailto:a@b.c">Mail</a> <a href="/logout">Logout</a>`,
+		"/products": `<title>Product</title><a href="/products?id=2&sort=asc">Next</a> <a href="/private/admin">Admin</a><form action="/search" method="get"><input name="q"><input type="hidden" name="lang" value="en"></form>`,
+		"/about":    `<title>About</title><a href="/team">Team</a><form method="post" action="/contact"><input name="email"><textarea name="message"></textarea></form>`,
+		"/team":     `<title>Team</title><a href="/deeper">Deeper</a>`,
+		"/deeper":   `<title>Deeper</title>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+		case "/old":
+			http.Redirect(w, r, "/about", http.StatusMovedPermanently)
+		default:
+			page, ok := pages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, page)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCrawl(t *testing.T) {
+	hits := map[string]int{}
+	srv := fakeSite(t, hits)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	site, err := w.Crawl("c", srv.URL+"/", CrawlOptions{MaxDepth: 2, Exclude: []*regexp.Regexp{regexp.MustCompile(`logout`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visited []string
+	for _, p := range site.Pages {
+		visited = append(visited, fmt.Sprintf("%s %d %s", strings.TrimPrefix(p.URL, srv.URL), p.Depth, p.Title))
+	}
+	want := "/ 0 Home, /products?id=1 1 Product, /about 1 About, /products?id=2&sort=asc 2 Product, /team 2 Team"
+	if strings.Join(visited, ", ") != want {
+		t.Errorf("visited %s", strings.Join(visited, ", "))
+	}
+	if hits["/logout"] != 0 || hits["/private/admin"] != 0 || hits["/deeper"] != 0 || hits["/robots.txt"] != 1 {
+		t.Errorf("requests %v", hits)
+	}
+	if len(site.Disallowed) != 1 || site.Disallowed[0] != srv.URL+"/private/admin" {
+		t.Errorf("disallowed %v", site.Disallowed)
+	}
+	if len(site.External) != 2 || site.External[0] != "https://cdn.example.net/lib.js" {
+		t.Errorf("external %v", site.External)
+	}
+
+	var endpoints []string
+	for _, e := range site.Endpoints {
+		endpoints = append(endpoints, e.Method+" "+strings.TrimPrefix(e.URL, srv.URL)+" "+strings.Join(e.Params, ","))
+	}
+	want = "POST /contact email,message, GET /products id,sort, GET /search lang,q"
+	if strings.Join(endpoints, ", ") != want {
+		t.Errorf("endpoints %s", strings.Join(endpoints, ", "))
+	}
+	if len(site.Forms) != 2 {
+		t.Errorf("forms %+v", site.Forms)
+	}
+}
+
+func TestCrawlScope(t *testing.T) {
+	hits := map[string]int{}
+	srv := fakeSite(t, hits)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	site, err := w.Crawl("c", srv.URL+"/old", CrawlOptions{MaxPages: 2, IgnoreRobots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(site.Pages) != 2 || site.Pages[0].Title != "About" || hits["/robots.txt"] != 0 {
+		t.Errorf("pages %+v, requests %v", site.Pages, hits)
+	}
+
+	site, _ = w.Crawl("c", srv.URL+"/", CrawlOptions{Domains: []string{"*.example.com"}})
+	if len(site.Pages) != 1 || len(site.External) != 0 {
+		t.Errorf("out of scope start: %+v", site)
+	}
+	if _, err := w.Crawl("c", "ftp://example.com/", CrawlOptions{}); err == nil {
+		t.Error("crawled an ftp URL")
+	}
+}
+
+func TestRobots(t *testing.T) {
+	robots := parseRobots(`# comment
+User-agent: Googlebot
+Disallow: /
+
+User-agent: sentra
+User-agent: other
+Disallow: /admin
+Allow: /admin/public
+Disallow: /*.pdf$
+
+User-agent: *
+Disallow: /tmp`, "Sentra Security Scanner 1.0")
+	for path, want := range map[string]bool{
+		"/": true, "/admin": false, "/admin/users": false, "/admin/public/x": true,
+		"/docs/a.pdf": false, "/docs/a.pdf?x": true, "/tmp": true,
+	} {
+		if got := robots.allowed(path); got != want {
+			t.Errorf("%s: allowed %v", path, got)
+		}
+	}
+	if parseRobots("User-agent: *\nDisallow: /tmp", "curl").allowed("/tmp/x") {
+		t.Error("* group not applied")
+	}
+}
@@ -0,0 +1,488 @@
+package webclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// GraphQLResult is the answer of a GraphQL server to an operation
+type GraphQLResult struct {
+	StatusCode int
+	Data       interface{}
+	Errors     []map[string]interface{}
+	Extensions map[string]interface{}
+}
+
+// ErrorMessages returns the messages of the result's errors
+func (r *GraphQLResult) ErrorMessages() []string {
+	var msgs []string
+	for _, e := range r.Errors {
+		if msg, ok := e["message"].(string); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// GraphQLQuery runs a query or mutation with its variables, and the
+// operation to run if the document has several
+func (w *WebClientModule) GraphQLQuery(clientID, endpoint, query string, variables map[string]interface{}, operation string) (*GraphQLResult, error) {
+	body := map[string]interface{}{"query": query}
+	if len(variables) > 0 {
+		body["variables"] = variables
+	}
+	if operation != "" {
+		body["operationName"] = operation
+	}
+	res, err := w.postGraphQL(clientID, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGraphQL(res)
+}
+
+// postGraphQL posts a JSON document to a GraphQL endpoint
+func (w *WebClientModule) postGraphQL(clientID, endpoint string, body interface{}) (*HTTPResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return w.Request(clientID, &HTTPRequest{
+		Method:  "POST",
+		URL:     endpoint,
+		Headers: map[string]string{"Content-Type": "application/json", "Accept": "application/json"},
+		Body:    string(data),
+	})
+}
+
+// decodeGraphQL reads the JSON answer of a GraphQL server
+func decodeGraphQL(res *HTTPResponse) (*GraphQLResult, error) {
+	var doc struct {
+		Data       interface{}              `json:"data"`
+		Errors     []map[string]interface{} `json:"errors"`
+		Extensions map[string]interface{}   `json:"extensions"`
+	}
+	if err := json.Unmarshal([]byte(res.Body), &doc); err != nil {
+		return nil, fmt.Errorf("not a GraphQL response (%s): %s", res.Status, truncate(res.Body, 200))
+	}
+	return &GraphQLResult{StatusCode: res.StatusCode, Data: doc.Data, Errors: doc.Errors, Extensions: doc.Extensions}, nil
+}
+
+// introspectionQuery asks for the types of a schema with their fields,
+// arguments and enum values
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types {
+      kind name
+      fields(includeDeprecated: true) { name args { name type { ...TypeRef } } type { ...TypeRef } }
+      inputFields { name type { ...TypeRef } }
+      enumValues(includeDeprecated: true) { name }
+    }
+  }
+}
+fragment TypeRef on __Type { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } }`
+
+// GraphQLSchema is a schema read by introspection
+type GraphQLSchema struct {
+	QueryType        string
+	MutationType     string
+	SubscriptionType string
+	Types            []GraphQLType // Without the introspection types, whose names start with __
+}
+
+// GraphQLType is a type of a schema
+type GraphQLType struct {
+	Name       string
+	Kind       string
+	Fields     []GraphQLField // Fields of objects and interfaces, or input fields of input objects
+	EnumValues []string
+}
+
+// GraphQLField is a field of a type, with its type in schema notation
+// such as [User!]!
+type GraphQLField struct {
+	Name string
+	Type string
+	Args []GraphQLField
+}
+
+// typeRef is a type reference in an introspection result
+type typeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *typeRef `json:"ofType"`
+}
+
+// String writes a type reference in schema notation
+func (t *typeRef) String() string {
+	if t == nil {
+		return ""
+	}
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// GraphQLIntrospect reads the schema of a GraphQL endpoint
+func (w *WebClientModule) GraphQLIntrospect(clientID, endpoint string) (*GraphQLSchema, error) {
+	res, err := w.postGraphQL(clientID, endpoint, map[string]interface{}{"query": introspectionQuery})
+	if err != nil {
+		return nil, err
+	}
+	return parseSchema(res)
+}
+
+// parseSchema reads the answer to the introspection query
+func parseSchema(res *HTTPResponse) (*GraphQLSchema, error) {
+	var doc struct {
+		Data *struct {
+			Schema *struct {
+				QueryType        *struct{ Name string } `json:"queryType"`
+				MutationType     *struct{ Name string } `json:"mutationType"`
+				SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+				Types            []struct {
+					Kind   string `json:"kind"`
+					Name   string `json:"name"`
+					Fields []struct {
+						Name string `json:"name"`
+						Args []struct {
+							Name string   `json:"name"`
+							Type *typeRef `json:"type"`
+						} `json:"args"`
+						Type *typeRef `json:"type"`
+					} `json:"fields"`
+					InputFields []struct {
+						Name string   `json:"name"`
+						Type *typeRef `json:"type"`
+					} `json:"inputFields"`
+					EnumValues []struct {
+						Name string `json:"name"`
+					} `json:"enumValues"`
+				} `json:"types"`
+			} `json:"__schema"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(res.Body), &doc); err != nil {
+		return nil, fmt.Errorf("not a GraphQL response (%s): %s", res.Status, truncate(res.Body, 200))
+	}
+	if doc.Data == nil || doc.Data.Schema == nil {
+		if len(doc.Errors) > 0 {
+			return nil, fmt.Errorf("introspection refused: %s", doc.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("introspection refused (%s)", res.Status)
+	}
+
+	s := doc.Data.Schema
+	schema := &GraphQLSchema{}
+	if s.QueryType != nil {
+		schema.QueryType = s.QueryType.Name
+	}
+	if s.MutationType != nil {
+		schema.MutationType = s.MutationType.Name
+	}
+	if s.SubscriptionType != nil {
+		schema.SubscriptionType = s.SubscriptionType.Name
+	}
+	for _, t := range s.Types {
+		if strings.HasPrefix(t.Name, "__") {
+			continue
+		}
+		gt := GraphQLType{Name: t.Name, Kind: t.Kind}
+		for _, f := range t.Fields {
+			field := GraphQLField{Name: f.Name, Type: f.Type.String()}
+			for _, a := range f.Args {
+				field.Args = append(field.Args, GraphQLField{Name: a.Name, Type: a.Type.String()})
+			}
+			gt.Fields = append(gt.Fields, field)
+		}
+		for _, f := range t.InputFields {
+			gt.Fields = append(gt.Fields, GraphQLField{Name: f.Name, Type: f.Type.String()})
+		}
+		for _, v := range t.EnumValues {
+			gt.EnumValues = append(gt.EnumValues, v.Name)
+		}
+		schema.Types = append(schema.Types, gt)
+	}
+	return schema, nil
+}
+
+// baseType returns the named type of a type in schema notation
+func baseType(t string) string {
+	return strings.Trim(t, "[]!")
+}
+
+// deepQuery builds a query nested depth levels deep by following a cycle
+// of object fields, such as user { posts { author { posts ... } } }, or
+// returns "" if the schema has none reachable from the query type
+func (s *GraphQLSchema) deepQuery(depth int) string {
+	types := map[string]*GraphQLType{}
+	for i := range s.Types {
+		types[s.Types[i].Name] = &s.Types[i]
+	}
+	// usable reports whether a field returns an object and can be queried
+	// without arguments
+	usable := func(f GraphQLField) bool {
+		for _, a := range f.Args {
+			if strings.HasSuffix(a.Type, "!") {
+				return false
+			}
+		}
+		t := types[baseType(f.Type)]
+		return t != nil && (t.Kind == "OBJECT" || t.Kind == "INTERFACE")
+	}
+
+	var prefix, cycle []string
+	var fields []string
+	onPath := map[string]int{}
+	visited := map[string]bool{}
+	var find func(name string) bool
+	find = func(name string) bool {
+		onPath[name] = len(fields)
+		visited[name] = true
+		defer delete(onPath, name)
+		for _, f := range types[name].Fields {
+			if !usable(f) {
+				continue
+			}
+			next := baseType(f.Type)
+			if at, ok := onPath[next]; ok {
+				prefix = append([]string(nil), fields[:at]...)
+				cycle = append(append([]string(nil), fields[at:]...), f.Name)
+				return true
+			}
+			if visited[next] {
+				continue
+			}
+			fields = append(fields, f.Name)
+			if find(next) {
+				return true
+			}
+			fields = fields[:len(fields)-1]
+		}
+		return false
+	}
+	if types[s.QueryType] == nil || !find(s.QueryType) {
+		return ""
+	}
+
+	path := prefix
+	for len(path) < depth {
+		path = append(path, cycle...)
+	}
+	var b strings.Builder
+	b.WriteString("query {")
+	for _, name := range path {
+		b.WriteString(" " + name + " {")
+	}
+	b.WriteString(" __typename")
+	b.WriteString(strings.Repeat(" }", len(path)+1))
+	return b.String()
+}
+
+// GraphQLTestOptions sizes the abuse probes of a GraphQL security test
+type GraphQLTestOptions struct {
+	BatchSize  int // Operations in a batch; 10 if 0
+	Aliases    int // Aliases in one query; 100 if 0
+	Depth      int // Nesting of the depth probe; 15 if 0
+	Duplicates int // Copies of a field in one query; 500 if 0
+}
+
+// GraphQLReport is what a GraphQL security test found
+type GraphQLReport struct {
+	Endpoint        string
+	Introspection   bool
+	Schema          *GraphQLSchema
+	Vulnerabilities []WebVuln
+}
+
+// limitError matches the errors of servers that enforce depth, cost or
+// size limits
+var limitError = regexp.MustCompile(`(?i)depth|complex|cost|limit|too (large|many|deep)|exceed`)
+
+// GraphQLSecurityTest probes a GraphQL endpoint for introspection, field
+// suggestions, batching and aliasing abuse, missing depth and complexity
+// limits, and queries over GET
+func (w *WebClientModule) GraphQLSecurityTest(clientID, endpoint string, opts GraphQLTestOptions) (*GraphQLReport, error) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 10
+	}
+	if opts.Aliases == 0 {
+		opts.Aliases = 100
+	}
+	if opts.Depth == 0 {
+		opts.Depth = 15
+	}
+	if opts.Duplicates == 0 {
+		opts.Duplicates = 500
+	}
+	report := &GraphQLReport{Endpoint: endpoint}
+	find := func(typ, severity, description, solution string, req *HTTPRequest, res *HTTPResponse, evidence string) {
+		report.Vulnerabilities = append(report.Vulnerabilities, WebVuln{
+			Type: typ, Severity: severity, URL: endpoint, Evidence: evidence,
+			Description: description, Solution: solution,
+			Request:  req.Method + " " + req.URL + "\n\n" + truncate(req.Body, 1024),
+			Response: res.Status + "\n\n" + truncate(res.Body, 512),
+		})
+	}
+	// probe sends a query and returns the request, response and result
+	probe := func(body interface{}) (*HTTPRequest, *HTTPResponse, *GraphQLResult) {
+		data, _ := json.Marshal(body)
+		req := &HTTPRequest{Method: "POST", URL: endpoint, Headers: map[string]string{"Content-Type": "application/json"}, Body: string(data)}
+		res, err := w.Request(clientID, req)
+		if err != nil {
+			return req, nil, nil
+		}
+		result, _ := decodeGraphQL(res)
+		return req, res, result
+	}
+
+	req, res, result := probe(map[string]interface{}{"query": "query { __typename }"})
+	if res == nil {
+		return nil, fmt.Errorf("no response from %s", endpoint)
+	}
+	if result == nil || result.Data == nil {
+		return nil, fmt.Errorf("%s does not answer GraphQL queries (%s)", endpoint, res.Status)
+	}
+
+	req, res, _ = probe(map[string]interface{}{"query": introspectionQuery})
+	if res != nil {
+		if schema, err := parseSchema(res); err == nil {
+			report.Introspection, report.Schema = true, schema
+			find("GRAPHQL_INTROSPECTION", "LOW", "Introspection is enabled, exposing the whole schema with its mutations",
+				"Disable introspection in production", req, res, fmt.Sprintf("Schema with %d types", len(schema.Types)))
+		}
+	}
+
+	req, res, result = probe(map[string]interface{}{"query": "query { __typenam }"})
+	if result != nil {
+		for _, msg := range result.ErrorMessages() {
+			if strings.Contains(msg, "Did you mean") {
+				find("GRAPHQL_FIELD_SUGGESTIONS", "LOW", "Errors suggest field names, which reveals the schema even without introspection",
+					"Turn off field suggestions in production", req, res, msg)
+				break
+			}
+		}
+	}
+
+	batch := make([]interface{}, opts.BatchSize)
+	for i := range batch {
+		batch[i] = map[string]interface{}{"query": "query { __typename }"}
+	}
+	req, res, _ = probe(batch)
+	var answers []interface{}
+	if res != nil && json.Unmarshal([]byte(res.Body), &answers) == nil && len(answers) == opts.BatchSize {
+		find("GRAPHQL_BATCHING", "MEDIUM", "Batches of operations run in one request, which bypasses rate limits on brute force and enumeration",
+			"Disable batching or limit the operations in a batch and count them against rate limits", req, res,
+			fmt.Sprintf("%d operations answered in one request", len(answers)))
+	}
+
+	var aliases strings.Builder
+	for i := 0; i < opts.Aliases; i++ {
+		fmt.Fprintf(&aliases, " a%d: __typename", i)
+	}
+	req, res, result = probe(map[string]interface{}{"query": "query {" + aliases.String() + " }"})
+	if data, ok := resultData(result); ok && len(data) == opts.Aliases {
+		find("GRAPHQL_ALIAS_OVERLOADING", "LOW", "One query can repeat a field under many aliases, such as many login attempts in one request",
+			"Limit the aliases of a query or its cost", req, res, fmt.Sprintf("%d aliases answered", len(data)))
+	}
+
+	req, res, result = probe(map[string]interface{}{"query": "query {" + strings.Repeat(" __typename", opts.Duplicates) + " }"})
+	if _, ok := resultData(result); ok && !limited(result) {
+		find("GRAPHQL_FIELD_DUPLICATION", "LOW", "Queries may repeat a field any number of times, which costs the server work for each copy",
+			"Limit the size or cost of queries", req, res, fmt.Sprintf("Field repeated %d times accepted", opts.Duplicates))
+	}
+
+	if report.Schema != nil {
+		if q := report.Schema.deepQuery(opts.Depth); q != "" {
+			req, res, result = probe(map[string]interface{}{"query": q})
+			if _, ok := resultData(result); ok && !limited(result) {
+				find("GRAPHQL_NO_DEPTH_LIMIT", "MEDIUM", "Queries may nest through cyclic relations without a depth limit, so one request can exhaust the server",
+					"Limit the depth and cost of queries", req, res, fmt.Sprintf("Query nested %d levels deep accepted", opts.Depth))
+			}
+		}
+	}
+
+	u, err := url.Parse(endpoint)
+	if err == nil {
+		q := u.Query()
+		q.Set("query", "query { __typename }")
+		u.RawQuery = q.Encode()
+		req = &HTTPRequest{Method: "GET", URL: u.String()}
+		if res, err := w.Request(clientID, req); err == nil {
+			if result, err := decodeGraphQL(res); err == nil {
+				if _, ok := resultData(result); ok {
+					find("GRAPHQL_GET_QUERIES", "LOW", "Queries run over GET, so a page on another site can send them with the user's cookies (CSRF)",
+						"Accept operations only as POST with a JSON content type", req, res, "Query over GET answered")
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// resultData returns the data of a result as a map
+func resultData(result *GraphQLResult) (map[string]interface{}, bool) {
+	if result == nil {
+		return nil, false
+	}
+	data, ok := result.Data.(map[string]interface{})
+	return data, ok && data != nil
+}
+
+// limited reports whether a result's errors show that the server enforces
+// a depth, cost or size limit
+func limited(result *GraphQLResult) bool {
+	for _, msg := range result.ErrorMessages() {
+		if limitError.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// GraphQLSchemaToMap converts a schema to a map
+func GraphQLSchemaToMap(s *GraphQLSchema) map[string]interface{} {
+	var fieldsToList func(fields []GraphQLField) []interface{}
+	fieldsToList = func(fields []GraphQLField) []interface{} {
+		list := make([]interface{}, len(fields))
+		for i, f := range fields {
+			m := map[string]interface{}{"name": f.Name, "type": f.Type}
+			if f.Args != nil {
+				m["args"] = fieldsToList(f.Args)
+			}
+			list[i] = m
+		}
+		return list
+	}
+	types := make([]interface{}, len(s.Types))
+	for i, t := range s.Types {
+		m := map[string]interface{}{"name": t.Name, "kind": t.Kind, "fields": fieldsToList(t.Fields)}
+		if len(t.EnumValues) > 0 {
+			values := make([]interface{}, len(t.EnumValues))
+			for j, v := range t.EnumValues {
+				values[j] = v
+			}
+			m["enum_values"] = values
+		}
+		types[i] = m
+	}
+	return map[string]interface{}{
+		"query_type":        s.QueryType,
+		"mutation_type":     s.MutationType,
+		"subscription_type": s.SubscriptionType,
+		"types":             types,
+	}
+}
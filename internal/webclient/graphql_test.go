@@ -0,0 +1,196 @@
+package webclient
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeSchema is the introspection answer of fakeGraphQL: users have posts,
+// whose authors are users
+const fakeSchema = `{"data":{"__schema":{
+  "queryType":{"name":"Query"},"mutationType":{"name":"Mutation"},"subscriptionType":null,
+  "types":[
+    {"kind":"OBJECT","name":"Query","fields":[
+      {"name":"user","args":[{"name":"id","type":{"kind":"SCALAR","name":"ID"}}],"type":{"kind":"OBJECT","name":"User"}},
+      {"name":"search","args":[{"name":"term","type":{"kind":"NON_NULL","ofType":{"kind":"SCALAR","name":"String"}}}],"type":{"kind":"LIST","ofType":{"kind":"OBJECT","name":"Post"}}}]},
+    {"kind":"OBJECT","name":"Mutation","fields":[
+      {"name":"login","args":[{"name":"user","type":{"kind":"NON_NULL","ofType":{"kind":"SCALAR","name":"String"}}}],"type":{"kind":"SCALAR","name":"String"}}]},
+    {"kind":"OBJECT","name":"User","fields":[
+      {"name":"name","args":[],"type":{"kind":"SCALAR","name":"String"}},
+      {"name":"posts","args":[],"type":{"kind":"NON_NULL","ofType":{"kind":"LIST","ofType":{"kind":"NON_NULL","ofType":{"kind":"OBJECT","name":"Post"}}}}}]},
+    {"kind":"OBJECT","name":"Post","fields":[
+      {"name":"author","args":[],"type":{"kind":"OBJECT","name":"User"}}]},
+    {"kind":"ENUM","name":"Role","enumValues":[{"name":"ADMIN"},{"name":"USER"}]},
+    {"kind":"OBJECT","name":"__Type","fields":[]}]}}}`
+
+// fakeGraphQL answers the operations the tests send by matching their
+// text. A hardened server refuses introspection, batching and GET, does
+// not suggest fields, and enforces depth and cost limits.
+func fakeGraphQL(t *testing.T, hardened bool) *httptest.Server {
+	alias := regexp.MustCompile(`(a\d+): __typename`)
+	answer := func(query string, variables map[string]interface{}) interface{} {
+		switch {
+		case strings.Contains(query, "IntrospectionQuery"):
+			if hardened {
+				return map[string]interface{}{"errors": []interface{}{map[string]interface{}{"message": "GraphQL introspection is not allowed"}}}
+			}
+			var schema interface{}
+			json.Unmarshal([]byte(fakeSchema), &schema)
+			return schema
+		case strings.Contains(query, "__typenam "):
+			msg := `Cannot query field "__typenam" on type "Query".`
+			if !hardened {
+				msg += ` Did you mean "__typename"?`
+			}
+			return map[string]interface{}{"errors": []interface{}{map[string]interface{}{"message": msg}}}
+		case strings.HasPrefix(query, "mutation"):
+			return map[string]interface{}{"data": map[string]interface{}{"login": "token-" + variables["user"].(string)}}
+		case strings.Contains(query, "user(id: $id)"):
+			return map[string]interface{}{"data": map[string]interface{}{"user": map[string]interface{}{"name": "user " + variables["id"].(string)}}}
+		}
+		if hardened && (strings.Count(query, "{") > 5 || strings.Count(query, "__typename") > 20) {
+			return map[string]interface{}{"errors": []interface{}{map[string]interface{}{"message": "Query cost limit exceeded"}}}
+		}
+		if strings.Count(query, "{") > 1 {
+			return map[string]interface{}{"data": map[string]interface{}{"user": nil}}
+		}
+		data := map[string]interface{}{}
+		for _, m := range alias.FindAllStringSubmatch(query, -1) {
+			data[m[1]] = "Query"
+		}
+		if len(data) == 0 {
+			data["__typename"] = "Query"
+		}
+		return map[string]interface{}{"data": data}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "GET" {
+			if hardened {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte(`{"errors":[{"message":"GET is not allowed"}]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(answer(r.URL.Query().Get("query"), nil))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		type operation struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if strings.HasPrefix(string(body), "[") {
+			var batch []operation
+			json.Unmarshal(body, &batch)
+			if hardened {
+				w.Write([]byte(`{"errors":[{"message":"batching is disabled"}]}`))
+				return
+			}
+			answers := make([]interface{}, len(batch))
+			for i, op := range batch {
+				answers[i] = answer(op.Query, op.Variables)
+			}
+			json.NewEncoder(w).Encode(answers)
+			return
+		}
+		var op operation
+		json.Unmarshal(body, &op)
+		json.NewEncoder(w).Encode(answer(op.Query, op.Variables))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	srv := fakeGraphQL(t, false)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	result, err := w.GraphQLQuery("c", srv.URL, "query($id: ID) { user(id: $id) { name } }", map[string]interface{}{"id": "42"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := result.Data.(map[string]interface{})["user"].(map[string]interface{}); user["name"] != "user 42" {
+		t.Errorf("query: %v", result.Data)
+	}
+	result, err = w.GraphQLQuery("c", srv.URL, "mutation Login($user: String!) { login(user: $user) }", map[string]interface{}{"user": "alice"}, "Login")
+	if err != nil || result.Data.(map[string]interface{})["login"] != "token-alice" {
+		t.Errorf("mutation: %v %v", result, err)
+	}
+	result, err = w.GraphQLQuery("c", srv.URL, "query { __typenam }", nil, "")
+	if err != nil || result.Data != nil || len(result.ErrorMessages()) != 1 {
+		t.Errorf("errors: %+v %v", result, err)
+	}
+}
+
+func TestGraphQLIntrospect(t *testing.T) {
+	srv := fakeGraphQL(t, false)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	schema, err := w.GraphQLIntrospect("c", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.QueryType != "Query" || schema.MutationType != "Mutation" || len(schema.Types) != 5 {
+		t.Fatalf("schema %+v", schema)
+	}
+	if f := schema.Types[2].Fields[1]; f.Name != "posts" || f.Type != "[Post!]!" {
+		t.Errorf("field %+v", f)
+	}
+	if f := schema.Types[0].Fields[1]; f.Args[0].Type != "String!" || f.Type != "[Post]" {
+		t.Errorf("field %+v", f)
+	}
+	if q := schema.deepQuery(4); q != "query { user { posts { author { posts { author { __typename } } } } } }" {
+		t.Errorf("deep query %q", q)
+	}
+
+	if _, err := w.GraphQLIntrospect("c", fakeGraphQL(t, true).URL); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("hardened: %v", err)
+	}
+}
+
+func TestGraphQLSecurityTest(t *testing.T) {
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	types := func(report *GraphQLReport) string {
+		var found []string
+		for _, v := range report.Vulnerabilities {
+			found = append(found, v.Type)
+		}
+		return strings.Join(found, " ")
+	}
+	report, err := w.GraphQLSecurityTest("c", fakeGraphQL(t, false).URL, GraphQLTestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "GRAPHQL_INTROSPECTION GRAPHQL_FIELD_SUGGESTIONS GRAPHQL_BATCHING GRAPHQL_ALIAS_OVERLOADING GRAPHQL_FIELD_DUPLICATION GRAPHQL_NO_DEPTH_LIMIT GRAPHQL_GET_QUERIES"
+	if got := types(report); got != want || !report.Introspection {
+		t.Errorf("open server: %s", got)
+	}
+	if v := report.Vulnerabilities[2]; !strings.Contains(v.Evidence, "10 operations") || !strings.HasPrefix(v.Request, "POST ") {
+		t.Errorf("batching %+v", v)
+	}
+
+	report, err = w.GraphQLSecurityTest("c", fakeGraphQL(t, true).URL, GraphQLTestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := types(report); got != "" || report.Introspection {
+		t.Errorf("hardened server: %s", got)
+	}
+
+	notGraphQL := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>Welcome</html>"))
+	}))
+	defer notGraphQL.Close()
+	if _, err := w.GraphQLSecurityTest("c", notGraphQL.URL, GraphQLTestOptions{}); err == nil {
+		t.Error("tested a page that is not GraphQL")
+	}
+}
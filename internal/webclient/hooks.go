@@ -0,0 +1,75 @@
+package webclient
+
+import "fmt"
+
+// RequestHook sees a request before it is sent, with all its headers, and
+// may change it. An error stops the request.
+type RequestHook func(req *HTTPRequest) error
+
+// ResponseHook sees each response with the request that got it. An error
+// is returned by the request instead of the response.
+type ResponseHook func(req *HTTPRequest, res *HTTPResponse) error
+
+// clientHook is a hook registered on a client
+type clientHook struct {
+	id       int
+	request  RequestHook
+	response ResponseHook
+}
+
+// AddRequestHook registers a hook run before each request of a client,
+// in the order hooks were added, and returns its ID
+func (w *WebClientModule) AddRequestHook(clientID string, hook RequestHook) (int, error) {
+	return w.addHook(clientID, clientHook{request: hook})
+}
+
+// AddResponseHook registers a hook run after each response of a client,
+// in the order hooks were added, and returns its ID
+func (w *WebClientModule) AddResponseHook(clientID string, hook ResponseHook) (int, error) {
+	return w.addHook(clientID, clientHook{response: hook})
+}
+
+func (w *WebClientModule) addHook(clientID string, hook clientHook) (int, error) {
+	w.mu.RLock()
+	client, exists := w.Clients[clientID]
+	w.mu.RUnlock()
+	if !exists {
+		return 0, fmt.Errorf("client not found: %s", clientID)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.nextHook++
+	hook.id = client.nextHook
+	client.hooks = append(client.hooks, hook)
+	return hook.id, nil
+}
+
+// RemoveHook unregisters a hook of a client, or all of them if id is 0,
+// and reports whether any was removed
+func (w *WebClientModule) RemoveHook(clientID string, id int) (bool, error) {
+	w.mu.RLock()
+	client, exists := w.Clients[clientID]
+	w.mu.RUnlock()
+	if !exists {
+		return false, fmt.Errorf("client not found: %s", clientID)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	kept := client.hooks[:0]
+	for _, h := range client.hooks {
+		if id != 0 && h.id != id {
+			kept = append(kept, h)
+		}
+	}
+	removed := len(kept) < len(client.hooks)
+	client.hooks = kept
+	return removed, nil
+}
+
+// currentHooks returns a copy of a client's hooks, so that hooks can add
+// and remove hooks while they run
+func (c *HTTPClient) currentHooks() []clientHook {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]clientHook(nil), c.hooks...)
+}
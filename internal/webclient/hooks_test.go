@@ -0,0 +1,70 @@
+package webclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.18.0")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, r.Header.Get("X-Scan-Id"))
+	}))
+	defer srv.Close()
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{"user_agent": "scanner"})
+	w.Clients["c"].Headers["x-scan-id"] = "client"
+
+	var seen []string
+	first, _ := w.AddRequestHook("c", func(req *HTTPRequest) error {
+		seen = append(seen, req.Headers["User-Agent"]+" "+req.Headers["X-Scan-Id"])
+		req.Headers["X-Scan-Id"] = "42"
+		req.URL += "/fuzzed"
+		return nil
+	})
+	w.AddRequestHook("c", func(req *HTTPRequest) error {
+		if strings.Contains(req.Body, "DROP") {
+			return errors.New("dropped")
+		}
+		return nil
+	})
+	var server string
+	w.AddResponseHook("c", func(req *HTTPRequest, res *HTTPResponse) error {
+		server = res.Headers["Server"][0]
+		res.Body = strings.ToUpper(res.Body)
+		return nil
+	})
+
+	req := &HTTPRequest{Method: "GET", URL: srv.URL + "/a", Headers: map[string]string{"Accept": "*/*"}}
+	res, err := w.Request("c", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Body != "GET /A/FUZZED 42" || server != "nginx/1.18.0" || seen[0] != "scanner client" {
+		t.Errorf("got %q, server %q, hook saw %q", res.Body, server, seen)
+	}
+	if req.URL != srv.URL+"/a" || len(req.Headers) != 1 {
+		t.Errorf("hook changed the caller's request: %+v", req)
+	}
+	if _, err := w.Request("c", &HTTPRequest{Method: "POST", URL: srv.URL, Body: "'; DROP TABLE users"}); err == nil || err.Error() != "dropped" {
+		t.Errorf("request hook error: %v", err)
+	}
+
+	if removed, _ := w.RemoveHook("c", first); !removed {
+		t.Error("hook not removed")
+	}
+	if res, _ := w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL + "/b"}); res.Body != "GET /B CLIENT" {
+		t.Errorf("after removing a hook: %q", res.Body)
+	}
+	w.RemoveHook("c", 0)
+	if res, _ := w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL + "/b"}); res.Body != "GET /b client" {
+		t.Errorf("after removing all hooks: %q", res.Body)
+	}
+	if removed, _ := w.RemoveHook("c", first); removed {
+		t.Error("removed a hook twice")
+	}
+}
@@ -0,0 +1,253 @@
+package webclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuth2Config describes an OAuth 2.0 client of an authorization server
+type OAuth2Config struct {
+	TokenURL     string
+	DeviceURL    string // Device authorization endpoint, for the device flow
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	Audience     string
+	AuthStyle    string // "basic" sends the client credentials in the Authorization header, "body" in the form; basic if empty
+	Params       map[string]string
+}
+
+// OAuth2Token is a token issued by an authorization server
+type OAuth2Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	Scope        string
+	IDToken      string
+	Expiry       time.Time // Zero if the server did not say
+	Raw          map[string]interface{}
+}
+
+// DeviceAuthorization is the code a user enters to approve a device
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               int // Seconds
+	Interval                int // Seconds between polls
+}
+
+// oauth2Error is the error response of a token endpoint
+type oauth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauth2Error) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// OAuth2ClientCredentials gets a token for the client itself with the
+// client credentials grant
+func (w *WebClientModule) OAuth2ClientCredentials(clientID string, cfg OAuth2Config) (*OAuth2Token, error) {
+	if cfg.TokenURL == "" {
+		return nil, fmt.Errorf("client credentials flow needs a token_url")
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	var raw map[string]interface{}
+	if err := w.oauth2Post(clientID, cfg, cfg.TokenURL, form, &raw); err != nil {
+		return nil, err
+	}
+	return newOAuth2Token(raw)
+}
+
+// OAuth2DeviceAuthorize starts the device authorization grant, returning
+// the code for the user to enter at the verification URI
+func (w *WebClientModule) OAuth2DeviceAuthorize(clientID string, cfg OAuth2Config) (*DeviceAuthorization, error) {
+	if cfg.DeviceURL == "" || cfg.TokenURL == "" {
+		return nil, fmt.Errorf("device flow needs a device_url and a token_url")
+	}
+	var res struct {
+		DeviceCode              string      `json:"device_code"`
+		UserCode                string      `json:"user_code"`
+		VerificationURI         string      `json:"verification_uri"`
+		VerificationURL         string      `json:"verification_url"` // Google's name for it
+		VerificationURIComplete string      `json:"verification_uri_complete"`
+		ExpiresIn               json.Number `json:"expires_in"`
+		Interval                json.Number `json:"interval"`
+	}
+	if err := w.oauth2Post(clientID, cfg, cfg.DeviceURL, url.Values{}, &res); err != nil {
+		return nil, err
+	}
+	if res.DeviceCode == "" || res.UserCode == "" {
+		return nil, fmt.Errorf("device authorization response has no device_code or user_code")
+	}
+	dev := &DeviceAuthorization{
+		DeviceCode:              res.DeviceCode,
+		UserCode:                res.UserCode,
+		VerificationURI:         res.VerificationURI,
+		VerificationURIComplete: res.VerificationURIComplete,
+		ExpiresIn:               1800,
+		Interval:                5,
+	}
+	if dev.VerificationURI == "" {
+		dev.VerificationURI = res.VerificationURL
+	}
+	if n, err := res.ExpiresIn.Int64(); err == nil && n > 0 {
+		dev.ExpiresIn = int(n)
+	}
+	if n, err := res.Interval.Int64(); err == nil && n > 0 {
+		dev.Interval = int(n)
+	}
+	return dev, nil
+}
+
+// OAuth2DeviceToken polls the token endpoint until the user approves or
+// denies the device, or its code expires
+func (w *WebClientModule) OAuth2DeviceToken(clientID string, cfg OAuth2Config, dev *DeviceAuthorization) (*OAuth2Token, error) {
+	interval := time.Duration(dev.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(dev.ExpiresIn) * time.Second)
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {dev.DeviceCode},
+	}
+	for {
+		var raw map[string]interface{}
+		err := w.oauth2Post(clientID, cfg, cfg.TokenURL, form, &raw)
+		if err == nil {
+			return newOAuth2Token(raw)
+		}
+		oerr, ok := err.(*oauth2Error)
+		if !ok {
+			return nil, err
+		}
+		switch oerr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, fmt.Errorf("the user denied the device")
+		case "expired_token":
+			return nil, fmt.Errorf("the device code expired")
+		default:
+			return nil, err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("the device code expired")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// UseToken sends a token with every later request of a client
+func (w *WebClientModule) UseToken(clientID string, token *OAuth2Token) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	client, exists := w.Clients[clientID]
+	if !exists {
+		return fmt.Errorf("client not found: %s", clientID)
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	client.Headers["Authorization"] = tokenType + " " + token.AccessToken
+	if session, ok := w.Sessions[clientID]; ok {
+		session.Token = token.AccessToken
+	} else {
+		w.Sessions[clientID] = &Session{ID: clientID, Client: client, Authenticated: true, Token: token.AccessToken}
+	}
+	return nil
+}
+
+// oauth2Post posts a form with the client's credentials to an endpoint of
+// the authorization server and decodes the JSON answer into v, or returns
+// the server's error
+func (w *WebClientModule) oauth2Post(clientID string, cfg OAuth2Config, endpoint string, form url.Values, v interface{}) error {
+	if cfg.Scope != "" {
+		form.Set("scope", cfg.Scope)
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+	for k, value := range cfg.Params {
+		form.Set(k, value)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Accept":       "application/json",
+	}
+	switch strings.ToLower(cfg.AuthStyle) {
+	case "", "basic":
+		if cfg.ClientSecret != "" {
+			credentials := url.QueryEscape(cfg.ClientID) + ":" + url.QueryEscape(cfg.ClientSecret)
+			headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		} else {
+			form.Set("client_id", cfg.ClientID)
+		}
+	case "body":
+		form.Set("client_id", cfg.ClientID)
+		if cfg.ClientSecret != "" {
+			form.Set("client_secret", cfg.ClientSecret)
+		}
+	default:
+		return fmt.Errorf("unknown auth_style '%s', want basic or body", cfg.AuthStyle)
+	}
+
+	res, err := w.Request(clientID, &HTTPRequest{Method: "POST", URL: endpoint, Headers: headers, Body: form.Encode()})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		oerr := &oauth2Error{}
+		if json.Unmarshal([]byte(res.Body), oerr) == nil && oerr.Code != "" {
+			return oerr
+		}
+		return fmt.Errorf("%s: %s", endpoint, res.Status)
+	}
+	if err := json.Unmarshal([]byte(res.Body), v); err != nil {
+		return fmt.Errorf("%s: invalid JSON response: %v", endpoint, err)
+	}
+	return nil
+}
+
+// newOAuth2Token reads a token response
+func newOAuth2Token(raw map[string]interface{}) (*OAuth2Token, error) {
+	token := &OAuth2Token{Raw: raw}
+	token.AccessToken, _ = raw["access_token"].(string)
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	token.TokenType, _ = raw["token_type"].(string)
+	token.RefreshToken, _ = raw["refresh_token"].(string)
+	token.Scope, _ = raw["scope"].(string)
+	token.IDToken, _ = raw["id_token"].(string)
+	if expiresIn, ok := raw["expires_in"].(float64); ok && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// OAuth2TokenToMap converts a token to a map
+func OAuth2TokenToMap(t *OAuth2Token) map[string]interface{} {
+	m := map[string]interface{}{
+		"access_token":  t.AccessToken,
+		"token_type":    t.TokenType,
+		"refresh_token": t.RefreshToken,
+		"scope":         t.Scope,
+		"id_token":      t.IDToken,
+		"raw":           t.Raw,
+	}
+	if !t.Expiry.IsZero() {
+		m["expires_at"] = t.Expiry.Unix()
+	}
+	return m
+}
@@ -0,0 +1,315 @@
+package webclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyRoute is how a client reaches servers: directly, through the
+// proxies named by the environment, or through a chain of proxies in order
+type proxyRoute struct {
+	env  bool
+	hops []*url.URL
+}
+
+// parseProxy reads a proxy setting: a proxy URL, an array of them to chain
+// through in order, "env" for HTTP_PROXY, HTTPS_PROXY and NO_PROXY, or
+// "none". Proxies are http, https, socks5 or socks5h URLs, with
+// user:password@ for proxies that need it.
+func parseProxy(v interface{}) (proxyRoute, error) {
+	var list []string
+	switch p := v.(type) {
+	case nil:
+		return proxyRoute{}, nil
+	case bool:
+		return proxyRoute{env: p}, nil
+	case string:
+		switch strings.ToLower(p) {
+		case "", "none", "direct":
+			return proxyRoute{}, nil
+		case "env":
+			return proxyRoute{env: true}, nil
+		}
+		list = []string{p}
+	case []string:
+		list = p
+	case []interface{}:
+		for _, item := range p {
+			list = append(list, fmt.Sprint(item))
+		}
+	default:
+		return proxyRoute{}, fmt.Errorf("proxy must be a URL, an array of URLs, env or none")
+	}
+
+	var route proxyRoute
+	for _, s := range list {
+		if len(list) == 1 && strings.EqualFold(s, "none") {
+			return proxyRoute{}, nil
+		}
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return proxyRoute{}, fmt.Errorf("invalid proxy URL '%s'", s)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return proxyRoute{}, fmt.Errorf("unsupported proxy scheme '%s', want http, https, socks5 or socks5h", u.Scheme)
+		}
+		route.hops = append(route.hops, u)
+	}
+	return route, nil
+}
+
+// String describes the route without credentials
+func (r proxyRoute) String() string {
+	if r.env {
+		return "env"
+	}
+	if len(r.hops) == 0 {
+		return "none"
+	}
+	hops := make([]string, len(r.hops))
+	for i, u := range r.hops {
+		hops[i] = u.Redacted()
+	}
+	return strings.Join(hops, " -> ")
+}
+
+// apply routes the connections of a transport. The last proxy of a chain
+// is the transport's own; the ones before it are tunnelled through when
+// dialing it.
+func (r proxyRoute) apply(t *http.Transport) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = dialer.DialContext
+	t.Proxy = nil
+	switch {
+	case r.env:
+		proxyFunc := httpproxy.FromEnvironment().ProxyFunc()
+		t.Proxy = func(req *http.Request) (*url.URL, error) { return proxyFunc(req.URL) }
+	case len(r.hops) > 0:
+		t.Proxy = http.ProxyURL(r.hops[len(r.hops)-1])
+		if len(r.hops) > 1 {
+			chain := &chainDialer{hops: r.hops[:len(r.hops)-1], dialer: dialer, tlsConfig: t.TLSClientConfig}
+			t.DialContext = chain.DialContext
+		}
+	}
+}
+
+// chainDialer connects through proxies in order
+type chainDialer struct {
+	hops      []*url.URL
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+}
+
+// DialContext connects to addr through every proxy of the chain
+func (d *chainDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, "tcp", proxyAddr(d.hops[0]))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	for i, hop := range d.hops {
+		next := addr
+		if i+1 < len(d.hops) {
+			next = proxyAddr(d.hops[i+1])
+		}
+		if conn, err = tunnel(conn, hop, next, d.tlsConfig); err != nil {
+			return nil, fmt.Errorf("proxy %s: %v", hop.Redacted(), err)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// proxyAddr returns the host and port of a proxy, with the default port
+// of its scheme
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// tunnel asks the proxy at the other end of conn to connect to addr, and
+// closes conn if it does not
+func tunnel(conn net.Conn, proxy *url.URL, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	var err error
+	switch proxy.Scheme {
+	case "https":
+		config := &tls.Config{ServerName: proxy.Hostname()}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+			config.ServerName = proxy.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.Handshake(); err == nil {
+			conn = tlsConn
+			err = httpConnect(conn, proxy, addr)
+		}
+	case "http":
+		err = httpConnect(conn, proxy, addr)
+	default:
+		err = socks5Connect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpConnect opens a tunnel with an HTTP CONNECT request
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s: %s", addr, res.Status)
+	}
+	return nil
+}
+
+// socks5Replies are the failures a SOCKS5 server reports
+var socks5Replies = []string{
+	1: "general failure", 2: "connection not allowed by ruleset", 3: "network unreachable", 4: "host unreachable",
+	5: "connection refused", 6: "TTL expired", 7: "command not supported", 8: "address type not supported",
+}
+
+// socks5Connect opens a tunnel with a SOCKS5 CONNECT request, with
+// username and password authentication if the proxy URL has credentials
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port '%s'", portStr)
+	}
+
+	methods := []byte{0}
+	if proxy.User != nil {
+		methods = append(methods, 2)
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 5:
+		return errors.New("not a SOCKS5 proxy")
+	case reply[1] == 2 && proxy.User != nil:
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("username or password is too long")
+		}
+		auth := append([]byte{1, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("authentication failed")
+		}
+	case reply[1] != 0:
+		return errors.New("no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name is too long")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socks5Replies) {
+			return fmt.Errorf("CONNECT %s: %s", addr, socks5Replies[header[1]])
+		}
+		return fmt.Errorf("CONNECT %s: reply %d", addr, header[1])
+	}
+	// Skip the bound address and port
+	size := map[byte]int{1: 4, 4: 16}[header[3]]
+	if header[3] == 3 {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		size = int(b[0])
+	}
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
+
+// viaProxy returns a copy of the client's http.Client that goes through
+// the proxies of a single request, made once for each setting
+func (c *HTTPClient) viaProxy(setting interface{}) (*http.Client, error) {
+	route, err := parseProxy(setting)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprint(route.env, route.hops)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cl, ok := c.proxied[key]; ok {
+		return cl, nil
+	}
+	if c.transport == nil {
+		return nil, fmt.Errorf("client %s does not support proxies per request", c.ID)
+	}
+	t := c.transport.Clone()
+	route.apply(t)
+	cl := *c.Client
+	cl.Transport = withAuth(t, c.Auth)
+	c.proxied[key] = &cl
+	return &cl, nil
+}
@@ -0,0 +1,249 @@
+package webclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// listen serves each connection to a local port with handle and returns
+// the address
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// pipe copies between a client and the server it tunnels to
+func pipe(client net.Conn, addr string) {
+	server, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	go io.Copy(server, client)
+	io.Copy(client, server)
+}
+
+// fakeHTTPProxy serves a proxy that takes the Basic credentials auth,
+// tunnels CONNECT requests and answers other requests itself. It sends
+// each request line to seen.
+func fakeHTTPProxy(t *testing.T, auth string, seen chan<- string) string {
+	return listen(t, func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		seen <- req.Method + " " + req.RequestURI
+		if user, password, _ := strings.Cut(auth, ":"); auth != "" {
+			if u, p, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || u != user || p != password {
+				fmt.Fprint(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+				return
+			}
+		}
+		if req.Method == http.MethodConnect {
+			fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			pipe(conn, req.Host)
+			return
+		}
+		body := "proxied " + req.URL.String()
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	})
+}
+
+// fakeSOCKS5 serves a SOCKS5 proxy that takes user:password and sends the
+// address of each CONNECT to seen
+func fakeSOCKS5(t *testing.T, seen chan<- string) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		greeting := make([]byte, 2)
+		io.ReadFull(r, greeting)
+		methods := make([]byte, greeting[1])
+		io.ReadFull(r, methods)
+		if !strings.Contains(string(methods), "\x02") {
+			conn.Write([]byte{5, 0xff})
+			return
+		}
+		conn.Write([]byte{5, 2})
+		header := make([]byte, 2)
+		io.ReadFull(r, header)
+		user := make([]byte, header[1])
+		io.ReadFull(r, user)
+		length, _ := r.ReadByte()
+		password := make([]byte, length)
+		io.ReadFull(r, password)
+		if string(user) != "user" || string(password) != "password" {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		req := make([]byte, 4)
+		io.ReadFull(r, req)
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			length, _ := r.ReadByte()
+			name := make([]byte, length)
+			io.ReadFull(r, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		seen <- addr
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(conn, addr)
+	})
+}
+
+func TestProxyChain(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("inside"))
+	}))
+	defer target.Close()
+	socksSeen, proxySeen := make(chan string, 4), make(chan string, 4)
+	socks := fakeSOCKS5(t, socksSeen)
+	burp := fakeHTTPProxy(t, "burp:secret", proxySeen)
+
+	w := NewWebClientModule()
+	client, err := w.CreateClient("jump", map[string]interface{}{
+		"proxy": []interface{}{"socks5://user:password@" + socks, "http://burp:secret@" + burp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ProxyURL != "socks5://user:xxxxx@"+socks+" -> http://burp:xxxxx@"+burp {
+		t.Errorf("proxy %q", client.ProxyURL)
+	}
+	res, err := w.Request("jump", &HTTPRequest{Method: "GET", URL: target.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Body != "inside" {
+		t.Errorf("got %q", res.Body)
+	}
+	if hop := <-socksSeen; hop != burp {
+		t.Errorf("SOCKS proxy connected to %s", hop)
+	}
+	if line := <-proxySeen; line != "CONNECT "+strings.TrimPrefix(target.URL, "https://") {
+		t.Errorf("HTTP proxy got %q", line)
+	}
+
+	w.CreateClient("denied", map[string]interface{}{"proxy": []interface{}{"socks5://user:wrong@" + socks, "http://" + burp}})
+	if _, err := w.Request("denied", &HTTPRequest{Method: "GET", URL: target.URL}); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("wrong SOCKS password: %v", err)
+	}
+}
+
+func TestProxyPerRequest(t *testing.T) {
+	seen := make(chan string, 4)
+	proxy := fakeHTTPProxy(t, "", seen)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	w := NewWebClientModule()
+	if _, err := w.CreateClient("c", map[string]interface{}{"proxy": "none"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := w.Request("c", &HTTPRequest{Method: "GET", URL: target.URL + "/a", Proxy: "http://" + proxy})
+		if err != nil || res.Body != "proxied "+target.URL+"/a" {
+			t.Fatalf("through proxy: %v %v", res, err)
+		}
+	}
+	if res, err := w.Request("c", &HTTPRequest{Method: "GET", URL: target.URL}); err != nil || res.Body != "direct" {
+		t.Errorf("direct: %v %v", res, err)
+	}
+	if len(seen) != 2 || len(w.Clients["c"].proxied) != 1 {
+		t.Errorf("proxy saw %d requests, %d proxied clients", len(seen), len(w.Clients["c"].proxied))
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	seen := make(chan string, 1)
+	proxy := fakeHTTPProxy(t, "", seen)
+	t.Setenv("HTTP_PROXY", "http://"+proxy)
+	t.Setenv("NO_PROXY", "")
+
+	w := NewWebClientModule()
+	client, err := w.CreateClient("env", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := w.Request("env", &HTTPRequest{Method: "GET", URL: "http://intranet.test/login"})
+	if err != nil || res.Body != "proxied http://intranet.test/login" || client.ProxyURL != "env" {
+		t.Errorf("got %v %v", res, err)
+	}
+
+	for _, bad := range []interface{}{"ftp://proxy.test", []interface{}{"http://a.test", "://"}, 8080} {
+		if _, err := parseProxy(bad); err == nil {
+			t.Errorf("accepted proxy %v", bad)
+		}
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	h2cServer := httptest.NewUnstartedServer(handler)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetHTTP1(true)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	w := NewWebClientModule()
+	w.CreateClient("h1", map[string]interface{}{})
+	w.CreateClient("h2", map[string]interface{}{"http2": true})
+	w.CreateClient("h2c", map[string]interface{}{"h2c": true})
+	for _, tc := range []struct{ client, url, want string }{
+		{"h1", tlsServer.URL, "HTTP/1.1"},
+		{"h2", tlsServer.URL, "HTTP/2.0"},
+		{"h2", h2cServer.URL, "HTTP/1.1"},
+		{"h2c", h2cServer.URL, "HTTP/2.0"},
+	} {
+		res, err := w.Request(tc.client, &HTTPRequest{Method: "GET", URL: tc.url})
+		if err != nil {
+			t.Errorf("%s: %v", tc.client, err)
+			continue
+		}
+		if res.Proto != tc.want || res.Body != tc.want {
+			t.Errorf("%s to %s: %s, server saw %s", tc.client, tc.url, res.Proto, res.Body)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package webclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// newCookieJar returns a jar that keeps cookies from being set for public
+// suffixes such as co.uk
+func newCookieJar() (*cookiejar.Jar, error) {
+	return cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+}
+
+// HTMLForm is a form of a page, with the default values of its fields
+type HTMLForm struct {
+	Action   string // Absolute URL the form is submitted to
+	Method   string
+	Fields   url.Values
+	Hidden   []string // Names of the hidden inputs
+	Username string   // Name of the text or email input before the password input
+	Password string   // Name of the password input
+}
+
+// csrfField matches the names of hidden inputs and meta tags that carry
+// anti-CSRF tokens in common frameworks
+var csrfField = regexp.MustCompile(`(?i)csrf|xsrf|authenticity_token|requestverificationtoken|^_token$|nonce`)
+
+// parseForms returns the forms of a page, with actions resolved against
+// the page URL
+func parseForms(body string, base *url.URL) []HTMLForm {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var forms []HTMLForm
+	var form *HTMLForm
+	var lastText string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "form":
+				forms = append(forms, HTMLForm{Action: base.String(), Method: "GET", Fields: url.Values{}})
+				form = &forms[len(forms)-1]
+				if action := attr(n, "action"); action != "" {
+					if u, err := base.Parse(action); err == nil {
+						form.Action = u.String()
+					}
+				}
+				if method := attr(n, "method"); method != "" {
+					form.Method = strings.ToUpper(method)
+				}
+			case "input":
+				name := attr(n, "name")
+				if form == nil || name == "" {
+					break
+				}
+				switch strings.ToLower(attr(n, "type")) {
+				case "password":
+					if form.Password == "" {
+						form.Password = name
+						form.Username = lastText
+					}
+				case "hidden":
+					form.Hidden = append(form.Hidden, name)
+				case "text", "email", "":
+					lastText = name
+				case "checkbox", "radio":
+					if !hasAttr(n, "checked") {
+						return
+					}
+				case "submit", "button", "image", "reset", "file":
+					return
+				}
+				form.Fields.Add(name, attr(n, "value"))
+			case "textarea":
+				if form != nil && attr(n, "name") != "" {
+					form.Fields.Add(attr(n, "name"), text(n))
+				}
+			case "select":
+				if form != nil && attr(n, "name") != "" {
+					form.Fields.Add(attr(n, "name"), selected(n))
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && n.Data == "form" {
+			form, lastText = nil, ""
+		}
+	}
+	walk(doc)
+	return forms
+}
+
+// attr returns an attribute of a node
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasAttr reports whether a node has an attribute
+func hasAttr(n *html.Node, name string) bool {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the text inside a node
+func text(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return b.String()
+}
+
+// selected returns the value of the selected option of a select, or of
+// its first option
+func selected(n *html.Node) string {
+	var first *html.Node
+	var found *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && found == nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "option" {
+				if first == nil {
+					first = c
+				}
+				if hasAttr(c, "selected") {
+					found = c
+				}
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	if found == nil {
+		found = first
+	}
+	if found == nil {
+		return ""
+	}
+	if hasAttr(found, "value") {
+		return attr(found, "value")
+	}
+	return strings.TrimSpace(text(found))
+}
+
+// csrfToken returns the anti-CSRF token of a form, or of the meta tag
+// pages put it in for scripts
+func csrfToken(body string, form *HTMLForm) string {
+	if form != nil {
+		for _, name := range form.Hidden {
+			if csrfField.MatchString(name) {
+				return form.Fields.Get(name)
+			}
+		}
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var token string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" && csrfField.MatchString(attr(n, "name")) {
+			token = attr(n, "content")
+			return
+		}
+		for c := n.FirstChild; c != nil && token == ""; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return token
+}
+
+// loginForm returns the first form of a page with a password input
+func loginForm(forms []HTMLForm) *HTMLForm {
+	for i := range forms {
+		if forms[i].Password != "" {
+			return &forms[i]
+		}
+	}
+	return nil
+}
+
+// FormLogin describes a login through an HTML form
+type FormLogin struct {
+	URL           string // Page with the login form
+	Username      string
+	Password      string
+	UsernameField string            // Found from the form if empty
+	PasswordField string            // Found from the form if empty
+	Fields        map[string]string // Extra fields, or values replacing the form's
+	Success       string            // Text in the page after a successful login
+	Failure       string            // Text in the page after a failed login
+	CSRFHeader    string            // Header to send the CSRF token in on later requests, such as X-CSRF-Token
+}
+
+// LoginResult is the outcome of a login
+type LoginResult struct {
+	Authenticated bool
+	StatusCode    int
+	URL           string // Page the login ended on, after redirects
+	CSRFToken     string
+	Cookies       []string // Names of the cookies the client holds for the site
+	Reason        string   // Why the login is considered failed
+}
+
+// FormLogin logs a client in through the form of a login page: it loads
+// the page, fills the username and password into the form with its hidden
+// fields and CSRF token, submits it and checks the page it lands on. The
+// session cookies stay in the client's jar for the requests that follow.
+func (w *WebClientModule) FormLogin(clientID string, login FormLogin) (*LoginResult, error) {
+	page, err := w.Request(clientID, &HTTPRequest{Method: "GET", URL: login.URL})
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(page.URL)
+	if err != nil {
+		return nil, err
+	}
+	form := loginForm(parseForms(page.Body, base))
+	if form == nil {
+		return nil, fmt.Errorf("no login form found at %s", page.URL)
+	}
+
+	values := url.Values{}
+	for name, v := range form.Fields {
+		values[name] = append([]string(nil), v...)
+	}
+	userField, passwordField := login.UsernameField, login.PasswordField
+	if userField == "" {
+		userField = form.Username
+	}
+	if passwordField == "" {
+		passwordField = form.Password
+	}
+	if userField == "" {
+		return nil, fmt.Errorf("no username field found in the login form, set username_field")
+	}
+	values.Set(userField, login.Username)
+	values.Set(passwordField, login.Password)
+	for name, v := range login.Fields {
+		values.Set(name, v)
+	}
+
+	token := csrfToken(page.Body, form)
+	req := &HTTPRequest{Method: form.Method, URL: form.Action, Headers: map[string]string{}}
+	if form.Method == "GET" {
+		u, err := url.Parse(form.Action)
+		if err != nil {
+			return nil, err
+		}
+		u.RawQuery = values.Encode()
+		req.URL = u.String()
+	} else {
+		req.Body = values.Encode()
+		req.Headers["Content-Type"] = "application/x-www-form-urlencoded"
+	}
+	if login.CSRFHeader != "" && token != "" {
+		req.Headers[login.CSRFHeader] = token
+	}
+	res, err := w.Request(clientID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LoginResult{StatusCode: res.StatusCode, URL: res.URL, CSRFToken: token}
+	landed, _ := url.Parse(res.URL)
+	if landed == nil {
+		landed = base
+	}
+	forms := parseForms(res.Body, landed)
+	switch {
+	case res.StatusCode >= 400:
+		result.Reason = "status " + res.Status
+	case login.Failure != "" && strings.Contains(res.Body, login.Failure):
+		result.Reason = "page contains the failure text"
+	case login.Success != "":
+		if !strings.Contains(res.Body, login.Success) {
+			result.Reason = "page does not contain the success text"
+		}
+	case loginForm(forms) != nil:
+		result.Reason = "login form shown again"
+	}
+	result.Authenticated = result.Reason == ""
+
+	// Pages rotate the token on login; keep the one of the new page
+	if next := csrfToken(res.Body, loginForm(forms)); next != "" {
+		result.CSRFToken = next
+	}
+	cookies, _ := w.GetCookies(clientID, res.URL)
+	for _, c := range cookies {
+		result.Cookies = append(result.Cookies, c.Name)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	client := w.Clients[clientID]
+	if result.Authenticated && login.CSRFHeader != "" && result.CSRFToken != "" {
+		client.Headers[login.CSRFHeader] = result.CSRFToken
+	}
+	w.Sessions[clientID] = &Session{
+		ID:            clientID,
+		Client:        client,
+		Authenticated: result.Authenticated,
+		Username:      login.Username,
+		CSRFToken:     result.CSRFToken,
+		Cookies:       cookies,
+	}
+	return result, nil
+}
+
+// SetCookie stores a cookie in a client's jar for a URL
+func (w *WebClientModule) SetCookie(clientID, urlStr string, cookie *http.Cookie) error {
+	w.mu.RLock()
+	client, exists := w.Clients[clientID]
+	w.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("client not found: %s", clientID)
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL: %s", urlStr)
+	}
+	client.Cookies.SetCookies(u, []*http.Cookie{cookie})
+	return nil
+}
+
+// ClearCookies empties a client's jar, ending its sessions
+func (w *WebClientModule) ClearCookies(clientID string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	client, exists := w.Clients[clientID]
+	if !exists {
+		return fmt.Errorf("client not found: %s", clientID)
+	}
+	jar, err := newCookieJar()
+	if err != nil {
+		return err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.Cookies = jar
+	client.Client.Jar = jar
+	for _, cl := range client.proxied {
+		cl.Jar = jar
+	}
+	delete(w.Sessions, clientID)
+	return nil
+}
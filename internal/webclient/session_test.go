@@ -0,0 +1,208 @@
+package webclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeApp serves a login form with a CSRF token that logs in alice with
+// the password Secret1 and keeps the session in a cookie
+func fakeApp(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	loginPage := `<html><head><meta name="csrf-token" content="meta-token"></head><body>
+		<form action="/session" method="post">
+			<input type="hidden" name="authenticity_token" value="form-token">
+			<input type="text" name="user[login]">
+			<input type="password" name="user[password]">
+			<select name="lang"><option value="en">English</option><option value="de" selected>Deutsch</option></select>
+			<input type="checkbox" name="remember" value="1">
+			<input type="submit" name="commit" value="Sign in">
+		</form></body></html>`
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, loginPage)
+	})
+	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("authenticity_token") != "form-token" || r.Form.Get("lang") != "de" || r.Form.Has("remember") || r.Form.Has("commit") {
+			http.Error(w, "bad form "+r.Form.Encode(), http.StatusUnprocessableEntity)
+			return
+		}
+		if r.Form.Get("user[login]") != "alice" || r.Form.Get("user[password]") != "Secret1" {
+			fmt.Fprint(w, "Invalid login"+loginPage)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "_app_session", Value: "alice-session", Path: "/"})
+		http.Redirect(w, r, "/dashboard", http.StatusFound)
+	})
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("_app_session"); err != nil || c.Value != "alice-session" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, `<meta name="csrf-token" content="rotated">Welcome alice, token %s`, r.Header.Get("X-CSRF-Token"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFormLogin(t *testing.T) {
+	srv := fakeApp(t)
+	w := NewWebClientModule()
+	w.CreateClient("app", map[string]interface{}{})
+
+	result, err := w.FormLogin("app", FormLogin{URL: srv.URL + "/login", Username: "alice", Password: "Secret1", CSRFHeader: "X-CSRF-Token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Authenticated || result.URL != srv.URL+"/dashboard" || result.CSRFToken != "rotated" {
+		t.Errorf("got %+v", result)
+	}
+	if len(result.Cookies) != 1 || result.Cookies[0] != "_app_session" {
+		t.Errorf("cookies %v", result.Cookies)
+	}
+	res, err := w.Request("app", &HTTPRequest{Method: "GET", URL: srv.URL + "/dashboard"})
+	if err != nil || res.Body != `<meta name="csrf-token" content="rotated">Welcome alice, token rotated` {
+		t.Errorf("session not reused: %v %v", res, err)
+	}
+
+	w.CreateClient("wrong", map[string]interface{}{})
+	result, err = w.FormLogin("wrong", FormLogin{URL: srv.URL + "/login", Username: "alice", Password: "guess"})
+	if err != nil || result.Authenticated || result.Reason != "login form shown again" {
+		t.Errorf("wrong password: %+v %v", result, err)
+	}
+	result, err = w.FormLogin("wrong", FormLogin{URL: srv.URL + "/login", Username: "alice", Password: "guess", Failure: "Invalid login"})
+	if err != nil || result.Authenticated || result.Reason != "page contains the failure text" {
+		t.Errorf("failure text: %+v %v", result, err)
+	}
+	if _, err := w.FormLogin("app", FormLogin{URL: srv.URL + "/dashboard"}); err == nil || !strings.Contains(err.Error(), "no login form") {
+		t.Errorf("page without a form: %v", err)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		for _, c := range r.Cookies() {
+			names = append(names, c.Name+"="+c.Value)
+		}
+		fmt.Fprint(w, strings.Join(names, ";"))
+	}))
+	defer srv.Close()
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+	if err := w.SetCookie("c", srv.URL, &http.Cookie{Name: "sid", Value: "planted", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL, Cookies: map[string]string{"extra": "1"}})
+	if err != nil || res.Body != "extra=1;sid=planted" {
+		t.Errorf("got %v %v", res, err)
+	}
+	w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL, Proxy: "none"})
+	if err := w.ClearCookies("c"); err != nil {
+		t.Fatal(err)
+	}
+	for _, proxy := range []interface{}{nil, "none"} {
+		if res, err := w.Request("c", &HTTPRequest{Method: "GET", URL: srv.URL, Proxy: proxy}); err != nil || res.Body != "" {
+			t.Errorf("after clearing, proxy %v: %v %v", proxy, res, err)
+		}
+	}
+
+	jar, _ := newCookieJar()
+	u, _ := url.Parse("https://shop.example.co.uk/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "wide", Value: "1", Domain: "co.uk"}})
+	if other, _ := url.Parse("https://other.co.uk/"); len(jar.Cookies(other)) != 0 {
+		t.Error("cookie set for a public suffix")
+	}
+}
+
+// fakeAuthServer serves the token and device endpoints of an OAuth 2.0
+// server for the client scanner with the secret s3cret
+func fakeAuthServer(t *testing.T, polls *int32) *httptest.Server {
+	token := func(w http.ResponseWriter, access string) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": access, "token_type": "bearer", "expires_in": 3600})
+	}
+	fail := func(w http.ResponseWriter, code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("grant_type") {
+		case "client_credentials":
+			if user, password, ok := r.BasicAuth(); !ok || user != "scanner" || password != "s3cret" || r.Form.Get("scope") != "read admin" {
+				fail(w, "invalid_client")
+				return
+			}
+			token(w, "cc-token")
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if r.Form.Get("device_code") != "dev-123" || r.Form.Get("client_id") != "cli" {
+				fail(w, "invalid_grant")
+			} else if atomic.AddInt32(polls, 1) < 2 {
+				fail(w, "authorization_pending")
+			} else {
+				token(w, "device-token")
+			}
+		}
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code": "dev-123", "user_code": "WDJB-MJHT", "verification_uri": "https://auth.example/device",
+			"expires_in": 600, "interval": 1,
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOAuth2(t *testing.T) {
+	var polls int32
+	srv := fakeAuthServer(t, &polls)
+	w := NewWebClientModule()
+	w.CreateClient("api", map[string]interface{}{})
+
+	cfg := OAuth2Config{TokenURL: srv.URL + "/token", ClientID: "scanner", ClientSecret: "s3cret", Scope: "read admin"}
+	token, err := w.OAuth2ClientCredentials("api", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "cc-token" || token.Expiry.IsZero() {
+		t.Errorf("got %+v", token)
+	}
+	w.UseToken("api", token)
+	if res, err := w.Request("api", &HTTPRequest{Method: "GET", URL: srv.URL + "/api"}); err != nil || res.Body != "Bearer cc-token" {
+		t.Errorf("token not sent: %v %v", res, err)
+	}
+	cfg.ClientSecret = "wrong"
+	if _, err := w.OAuth2ClientCredentials("api", cfg); err == nil || err.Error() != "invalid_client" {
+		t.Errorf("wrong secret: %v", err)
+	}
+
+	device := OAuth2Config{TokenURL: srv.URL + "/token", DeviceURL: srv.URL + "/device", ClientID: "cli", AuthStyle: "body"}
+	dev, err := w.OAuth2DeviceAuthorize("api", device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.UserCode != "WDJB-MJHT" || dev.Interval != 1 {
+		t.Errorf("got %+v", dev)
+	}
+	token, err = w.OAuth2DeviceToken("api", device, dev)
+	if err != nil || token.AccessToken != "device-token" || polls != 2 {
+		t.Errorf("got %+v %v after %d polls", token, err, polls)
+	}
+	dev.DeviceCode = "other"
+	if _, err := w.OAuth2DeviceToken("api", device, dev); err == nil || err.Error() != "invalid_grant" {
+		t.Errorf("unknown device code: %v", err)
+	}
+}
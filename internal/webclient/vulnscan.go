@@ -0,0 +1,502 @@
+package webclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check is a class of vulnerability the scanner looks for: the payloads
+// it injects into parameters and how it recognises one that worked
+type Check struct {
+	Name        string
+	Type        string // Type of the findings, such as SQL_INJECTION
+	Severity    string
+	Description string
+	Solution    string
+	Detect      string // reflect, pattern, compute, time or canary
+	Append      bool   // Payloads go after the parameter's value instead of replacing it
+	Patterns    []*regexp.Regexp
+	Payloads    []string // Templates with {marker}, {a} and {b}, {delay} or {canary} filled in for each request
+}
+
+// patterns compiles regular expressions for a check
+func patterns(exprs ...string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(exprs))
+	for i, e := range exprs {
+		res[i] = regexp.MustCompile(e)
+	}
+	return res
+}
+
+// fileContents matches system files read through path traversal or XXE
+var fileContents = patterns(`root:[x*!]?:0:0:`, `\[(fonts|extensions|mci extensions)\]`, `\[boot loader\]`)
+
+// Checks are the checks the scanner knows, by name
+var Checks = map[string]*Check{
+	"xss": {
+		Name: "xss", Type: "XSS", Severity: "MEDIUM", Detect: "reflect",
+		Description: "Cross-Site Scripting: a payload is reflected into the page without encoding",
+		Solution:    "Encode output for its HTML, attribute or script context and set a Content-Security-Policy",
+		Payloads: []string{
+			`<script>alert('{marker}')</script>`,
+			`"><svg/onload=alert('{marker}')>`,
+			`'><img src=x onerror=alert('{marker}')>`,
+			`javascript:alert('{marker}')//`,
+		},
+	},
+	"sqli": {
+		Name: "sqli", Type: "SQL_INJECTION", Severity: "HIGH", Detect: "pattern", Append: true,
+		Description: "SQL injection: a payload breaks the query and the database error shows in the response",
+		Solution:    "Use parameterized queries and do not show database errors to users",
+		Patterns: patterns(
+			`SQL syntax.*?MySQL`, `Warning.*?\Wmysqli?_`, `check the manual that (corresponds|fits) to your (MySQL|MariaDB) server version`, `MySQLSyntaxErrorException`,
+			`PostgreSQL.*?ERROR`, `Warning.*?\Wpg_`, `unterminated quoted string at or near`, `syntax error at or near`, `PSQLException`,
+			`Unclosed quotation mark after the character string`, `Incorrect syntax near`, `OLE DB.*?SQL Server`, `Driver.*? SQL[\-_ ]*Server`, `System\.Data\.SqlClient\.SqlException`,
+			`\bORA-\d{5}`, `quoted string not properly terminated`,
+			`SQLITE_ERROR`, `sqlite3\.OperationalError`, `SQLite\.Exception`, `unrecognized token:`,
+			`SQLSTATE\[\w+\]`, `ODBC .*?Driver`,
+		),
+		Payloads: []string{`'`, `"`, `')`, `\`, `' OR '1'='1`, `1' AND '1'='2`},
+	},
+	"sqli_time": {
+		Name: "sqli_time", Type: "SQL_INJECTION", Severity: "HIGH", Detect: "time", Append: true,
+		Description: "Blind SQL injection: a payload that makes the database sleep delays the response",
+		Solution:    "Use parameterized queries",
+		Payloads: []string{
+			`' AND SLEEP({delay})-- -`,
+			` AND SLEEP({delay})`,
+			`' OR (SELECT 1 FROM (SELECT SLEEP({delay}))x)-- -`,
+			`';SELECT pg_sleep({delay})--`,
+			`';WAITFOR DELAY '0:0:{delay}'--`,
+		},
+	},
+	"command": {
+		Name: "command", Type: "COMMAND_INJECTION", Severity: "CRITICAL", Detect: "compute", Append: true,
+		Description: "OS command injection: an injected command ran and its output shows in the response",
+		Solution:    "Do not pass input to a shell; call programs with argument lists and validate input",
+		Payloads:    []string{`;expr {a} + {b}`, `|expr {a} + {b}`, `$(expr {a} + {b})`, "`expr {a} + {b}`", `&set /a {a}+{b}`},
+	},
+	"traversal": {
+		Name: "traversal", Type: "DIRECTORY_TRAVERSAL", Severity: "HIGH", Detect: "pattern",
+		Description: "Path traversal: a payload reads a system file outside the web root",
+		Solution:    "Map input to an allowlist of files and never build paths from it",
+		Patterns:    fileContents,
+		Payloads: []string{
+			"../../../../../../../../etc/passwd",
+			"....//....//....//....//....//....//etc/passwd",
+			"..%2f..%2f..%2f..%2f..%2f..%2fetc%2fpasswd",
+			"/etc/passwd",
+			`..\..\..\..\..\..\windows\win.ini`,
+			`C:\windows\win.ini`,
+			"file:///etc/passwd",
+		},
+	},
+	"ssrf": {
+		Name: "ssrf", Type: "SSRF", Severity: "HIGH", Detect: "canary",
+		Description: "Server-side request forgery: the server fetched a URL given in a parameter",
+		Solution:    "Allowlist the hosts the server may fetch and block internal addresses",
+		Payloads:    []string{"{canary}"},
+	},
+	"nosql": {
+		Name: "nosql", Type: "NOSQL_INJECTION", Severity: "HIGH", Detect: "pattern", Append: true,
+		Description: "NoSQL injection: a payload breaks the query and the database error shows in the response",
+		Solution:    "Validate the types of input and do not build queries or $where clauses from it",
+		Patterns:    patterns(`Mongo(Server)?Error`, `BSONTypeError`, `CastError: Cast to`, `SyntaxError: unterminated string literal`, `E11000 duplicate key`),
+		Payloads:    []string{`'`, `{"$gt": ""}`, `';return true;var x='`, `[$ne]=1`},
+	},
+	"xxe": {
+		Name: "xxe", Type: "XXE", Severity: "HIGH", Detect: "pattern",
+		Description: "XML external entity: an entity in the payload read a system file",
+		Solution:    "Disable DTDs and external entities in the XML parser",
+		Patterns:    fileContents,
+		Payloads: []string{
+			`<?xml version="1.0"?><!DOCTYPE r [<!ENTITY e SYSTEM "file:///etc/passwd">]><r>&e;</r>`,
+			`<?xml version="1.0"?><!DOCTYPE r [<!ENTITY e SYSTEM "file:///c:/windows/win.ini">]><r>&e;</r>`,
+		},
+	},
+	"xpath": {
+		Name: "xpath", Type: "XPATH_INJECTION", Severity: "HIGH", Detect: "pattern", Append: true,
+		Description: "XPath injection: a payload breaks the query and the error shows in the response",
+		Solution:    "Use parameterized XPath queries or escape input",
+		Patterns:    patterns(`XPathException`, `Invalid (XPath )?expression`, `xmlXPathEval`, `SimpleXMLElement::xpath`, `XPath error`, `MS\.Internal\.Xml`),
+		Payloads:    []string{`'`, `' or '1'='1`, `']`},
+	},
+}
+
+// DefaultChecks are the checks of a scan that names none. ssrf needs a
+// canary and is skipped without one.
+var DefaultChecks = []string{"xss", "sqli", "sqli_time", "command", "traversal", "ssrf", "disclosure"}
+
+// defaultParams are injected into when a target has no parameters
+var defaultParams = []string{"id", "q", "search", "file", "url"}
+
+// sensitiveFiles are files that should not be served, with what their
+// contents look like so that catch-all pages are not reported
+var sensitiveFiles = []struct {
+	path    string
+	pattern *regexp.Regexp
+}{
+	{"/.env", regexp.MustCompile(`(?m)^[A-Z][A-Z0-9_]*=`)},
+	{"/.git/config", regexp.MustCompile(`\[core\]`)},
+	{"/config.php", regexp.MustCompile(`<\?php`)},
+	{"/web.config", regexp.MustCompile(`<configuration`)},
+	{"/backup.sql", regexp.MustCompile(`(?i)CREATE TABLE|INSERT INTO`)},
+	{"/phpinfo.php", regexp.MustCompile(`phpinfo\(\)|PHP Version`)},
+	{"/server-status", regexp.MustCompile(`Apache Server Status`)},
+	{"/.DS_Store", regexp.MustCompile(`Bud1`)},
+}
+
+// ScanOptions configures a vulnerability scan
+type ScanOptions struct {
+	Checks     []string            // Names of Checks and "disclosure"; DefaultChecks if empty
+	Payloads   map[string][]string // Payload templates replacing those of a check
+	Params     map[string]string   // Parameters to inject into with their normal values; those of the target URL if empty
+	Method     string              // GET, or POST to send the parameters as a form
+	StoredURLs []string            // Pages checked for stored XSS after injecting; the target if empty
+	TimeDelay  time.Duration       // Sleep asked of the database by time-based payloads; 5s if 0
+	Canary     *Canary
+	CanaryWait time.Duration // Time given to targets to call the canary back; 3s if 0
+}
+
+// scanner runs the checks of one scan against a target
+type scanner struct {
+	send     func(*HTTPRequest) (*HTTPResponse, error)
+	target   *url.URL
+	opts     ScanOptions
+	params   map[string]string
+	names    []string
+	baseline *HTTPResponse
+	vulns    []WebVuln
+
+	sentXSS []sentPayload // For the stored XSS check
+	waiting []sentPayload // Sent with canary tokens
+}
+
+// sentPayload is a payload sent into a parameter
+type sentPayload struct {
+	check   *Check
+	param   string
+	payload string
+	token   string
+	req     *HTTPRequest
+}
+
+// newScanner prepares a scan of target
+func newScanner(send func(*HTTPRequest) (*HTTPResponse, error), target string, opts ScanOptions) (*scanner, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", target)
+	}
+	opts.Method = strings.ToUpper(opts.Method)
+	if opts.Method == "" {
+		opts.Method = "GET"
+	}
+	if len(opts.Checks) == 0 {
+		opts.Checks = DefaultChecks
+	}
+	for _, name := range opts.Checks {
+		if _, ok := Checks[name]; !ok && name != "disclosure" {
+			return nil, fmt.Errorf("unknown check '%s'", name)
+		}
+	}
+	for name := range opts.Payloads {
+		if _, ok := Checks[name]; !ok {
+			return nil, fmt.Errorf("payloads for unknown check '%s'", name)
+		}
+	}
+	if opts.TimeDelay == 0 {
+		opts.TimeDelay = 5 * time.Second
+	}
+	if opts.CanaryWait == 0 {
+		opts.CanaryWait = 3 * time.Second
+	}
+
+	s := &scanner{send: send, target: u, opts: opts, params: map[string]string{}}
+	for name, value := range opts.Params {
+		s.params[name] = value
+	}
+	if len(s.params) == 0 {
+		for name := range u.Query() {
+			s.params[name] = u.Query().Get(name)
+		}
+	}
+	if len(s.params) == 0 {
+		for _, name := range defaultParams {
+			s.params[name] = "1"
+		}
+	}
+	for name := range s.params {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	return s, nil
+}
+
+// run runs the checks and returns what they found
+func (s *scanner) run() ([]WebVuln, error) {
+	var err error
+	if s.baseline, err = s.send(s.request("", "")); err != nil {
+		return nil, fmt.Errorf("baseline request: %v", err)
+	}
+	for _, name := range s.opts.Checks {
+		if name == "disclosure" {
+			s.disclosure()
+			continue
+		}
+		check := Checks[name]
+		if check.Detect == "canary" && s.opts.Canary == nil {
+			continue
+		}
+		payloads := check.Payloads
+		if custom, ok := s.opts.Payloads[name]; ok {
+			payloads = custom
+		}
+		for _, param := range s.names {
+			for _, template := range payloads {
+				if s.inject(check, param, template) {
+					break
+				}
+			}
+		}
+	}
+	s.stored()
+	s.callbacks()
+	return s.vulns, nil
+}
+
+// request builds a request with the scan's parameters, one of them set
+// to value
+func (s *scanner) request(param, value string) *HTTPRequest {
+	values := url.Values{}
+	for k, v := range s.params {
+		values.Set(k, v)
+	}
+	if param != "" {
+		values.Set(param, value)
+	}
+	u := *s.target
+	if s.opts.Method == "GET" {
+		q := u.Query()
+		for k, v := range values {
+			q[k] = v
+		}
+		u.RawQuery = q.Encode()
+		return &HTTPRequest{Method: "GET", URL: u.String()}
+	}
+	return &HTTPRequest{
+		Method:  s.opts.Method,
+		URL:     u.String(),
+		Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+		Body:    values.Encode(),
+	}
+}
+
+// inject sends one payload into a parameter and reports whether it
+// found something
+func (s *scanner) inject(check *Check, param, template string) bool {
+	sent := sentPayload{check: check, param: param}
+	var expect string
+	payload := strings.ReplaceAll(template, "{marker}", randomMarker())
+	if strings.Contains(payload, "{a}") {
+		a, b := 10000+mrand.Intn(90000), 10000+mrand.Intn(90000)
+		payload = strings.ReplaceAll(strings.ReplaceAll(payload, "{a}", strconv.Itoa(a)), "{b}", strconv.Itoa(b))
+		expect = strconv.Itoa(a + b)
+	}
+	payload = strings.ReplaceAll(payload, "{delay}", strconv.FormatFloat(s.opts.TimeDelay.Seconds(), 'f', -1, 64))
+	if strings.Contains(payload, "{canary}") && s.opts.Canary != nil {
+		var canaryURL string
+		canaryURL, sent.token = s.opts.Canary.NewToken()
+		payload = strings.ReplaceAll(payload, "{canary}", canaryURL)
+	}
+	sent.payload = payload
+	value := payload
+	if check.Append {
+		value = s.params[param] + payload
+	}
+	sent.req = s.request(param, value)
+	res, err := s.send(sent.req)
+	if err != nil {
+		return false
+	}
+
+	switch check.Detect {
+	case "reflect":
+		s.sentXSS = append(s.sentXSS, sent)
+		if at := strings.Index(res.Body, payload); at >= 0 {
+			s.report(sent, res, at, len(payload), "Payload reflected without encoding")
+			return true
+		}
+	case "pattern":
+		for _, re := range check.Patterns {
+			if loc := re.FindStringIndex(res.Body); loc != nil && !re.MatchString(s.baseline.Body) {
+				s.report(sent, res, loc[0], loc[1]-loc[0], "Response contains "+res.Body[loc[0]:loc[1]])
+				return true
+			}
+		}
+	case "compute":
+		if at := strings.Index(res.Body, expect); at >= 0 && !strings.Contains(s.baseline.Body, expect) {
+			s.report(sent, res, at, len(expect), "Response contains the result of the injected command, "+expect)
+			return true
+		}
+	case "time":
+		threshold := s.baseline.ResponseTime + s.opts.TimeDelay*9/10
+		if res.ResponseTime < threshold {
+			return false
+		}
+		// Send it again to rule out a slow moment of the server
+		again, err := s.send(sent.req)
+		if err != nil || again.ResponseTime < threshold {
+			return false
+		}
+		s.report(sent, again, 0, 0, fmt.Sprintf("Responses took %s and %s against %s without the payload",
+			res.ResponseTime.Round(time.Millisecond), again.ResponseTime.Round(time.Millisecond), s.baseline.ResponseTime.Round(time.Millisecond)))
+		return true
+	case "canary":
+		s.waiting = append(s.waiting, sent)
+	}
+	return false
+}
+
+// stored looks for the XSS payloads sent in the pages that would show
+// stored input
+func (s *scanner) stored() {
+	if len(s.sentXSS) == 0 {
+		return
+	}
+	pages := s.opts.StoredURLs
+	if len(pages) == 0 {
+		pages = []string{s.target.String()}
+	}
+	reported := map[string]bool{}
+	for _, page := range pages {
+		res, err := s.send(&HTTPRequest{Method: "GET", URL: page})
+		if err != nil {
+			continue
+		}
+		for _, sent := range s.sentXSS {
+			if at := strings.Index(res.Body, sent.payload); at >= 0 && !reported[sent.param] {
+				reported[sent.param] = true
+				stored := sent
+				stored.check = &Check{
+					Type: "STORED_XSS", Severity: "HIGH",
+					Description: "Stored Cross-Site Scripting: a payload sent earlier is served to every visitor of " + page,
+					Solution:    sent.check.Solution,
+				}
+				s.report(stored, res, at, len(sent.payload), "Payload stored and served without encoding at "+page)
+			}
+		}
+	}
+}
+
+// callbacks waits for targets to call the canary back and reports the
+// payloads that made them
+func (s *scanner) callbacks() {
+	if len(s.waiting) == 0 {
+		return
+	}
+	time.Sleep(s.opts.CanaryWait)
+	for _, sent := range s.waiting {
+		if hits := s.opts.Canary.Hits(sent.token); len(hits) > 0 {
+			s.report(sent, nil, 0, 0, fmt.Sprintf("Canary called back from %s with %s %s (%s)", hits[0].RemoteAddr, hits[0].Method, hits[0].Path, hits[0].UserAgent))
+		}
+	}
+}
+
+// disclosure looks for sensitive files next to the target
+func (s *scanner) disclosure() {
+	base := *s.target
+	base.RawQuery, base.Fragment = "", ""
+	prefix := strings.TrimSuffix(base.String(), "/")
+	for _, f := range sensitiveFiles {
+		req := &HTTPRequest{Method: "GET", URL: prefix + f.path}
+		res, err := s.send(req)
+		if err != nil || res.StatusCode != 200 {
+			continue
+		}
+		if loc := f.pattern.FindStringIndex(res.Body); loc != nil {
+			sent := sentPayload{
+				check: &Check{
+					Type: "INFORMATION_DISCLOSURE", Severity: "MEDIUM",
+					Description: "Sensitive file disclosure: " + f.path + " is served",
+					Solution:    "Remove or restrict access to sensitive files",
+				},
+				payload: f.path,
+				req:     req,
+			}
+			s.report(sent, res, loc[0], loc[1]-loc[0], fmt.Sprintf("%s served with its contents (Status: %d)", f.path, res.StatusCode))
+		}
+	}
+}
+
+// report records a finding with the request that caused it and the part
+// of the response around the evidence
+func (s *scanner) report(sent sentPayload, res *HTTPResponse, at, length int, evidence string) {
+	v := WebVuln{
+		Type:        sent.check.Type,
+		Severity:    sent.check.Severity,
+		URL:         sent.req.URL,
+		Parameter:   sent.param,
+		Payload:     sent.payload,
+		Evidence:    evidence,
+		Description: sent.check.Description,
+		Solution:    sent.check.Solution,
+		Request:     sent.req.Method + " " + sent.req.URL,
+	}
+	if sent.req.Body != "" {
+		v.Request += "\n\n" + truncate(sent.req.Body, 1024)
+	}
+	if res != nil {
+		start, end := max(0, at-120), min(len(res.Body), at+length+120)
+		v.Response = res.Status + "\n\n" + res.Body[start:end]
+	}
+	s.vulns = append(s.vulns, v)
+}
+
+// truncate shortens s to n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// randomMarker returns a string to tell the payloads of a scan apart
+func randomMarker() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "sntr" + hex.EncodeToString(b)
+}
+
+// Scan runs checks against the parameters of a URL with a client, so
+// that it carries the client's session, hooks and proxies
+func (w *WebClientModule) Scan(clientID, targetURL string, opts ScanOptions) (*WebVulnScan, error) {
+	w.mu.RLock()
+	_, exists := w.Clients[clientID]
+	w.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("client not found: %s", clientID)
+	}
+	s, err := newScanner(func(req *HTTPRequest) (*HTTPResponse, error) { return w.Request(clientID, req) }, targetURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	startTime := time.Now()
+	vulns, err := s.run()
+	if err != nil {
+		return nil, err
+	}
+	return &WebVulnScan{
+		URL:             targetURL,
+		Vulnerabilities: vulns,
+		ScanTime:        startTime,
+		Duration:        time.Since(startTime),
+	}, nil
+}
@@ -0,0 +1,191 @@
+package webclient
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVulnerableApp serves endpoints with one vulnerability each, and a
+// catch-all page that answers 200 to anything else
+func fakeVulnerableApp(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	var comments []string
+	sleep := regexp.MustCompile(`SLEEP\(([\d.]+)\)`)
+	expr := regexp.MustCompile(`expr (\d+) \+ (\d+)`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<p>Results for %s</p>", r.URL.Query().Get("q"))
+	})
+	mux.HandleFunc("/safe", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<p>Results for %s</p>", html.EscapeString(r.URL.Query().Get("q")))
+	})
+	mux.HandleFunc("/item", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("id"), "'") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version")
+			return
+		}
+		fmt.Fprint(w, "item")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		if m := sleep.FindStringSubmatch(r.URL.Query().Get("id")); m != nil && strings.HasPrefix(r.URL.Query().Get("id"), "7'") {
+			seconds, _ := strconv.ParseFloat(m[1], 64)
+			time.Sleep(time.Duration(seconds * float64(time.Second)))
+		}
+		fmt.Fprint(w, "item")
+	})
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if m := expr.FindStringSubmatch(host); m != nil && strings.Contains(host, "$(") {
+			a, _ := strconv.Atoi(m[1])
+			b, _ := strconv.Atoi(m[2])
+			fmt.Fprintf(w, "PING 127.0.0.1\n%d", a+b)
+			return
+		}
+		fmt.Fprintf(w, "PING %s", host)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Query().Get("file"), "../etc/passwd") {
+			fmt.Fprint(w, "root:x:0:0:root:/root:/bin/bash\n")
+			return
+		}
+		fmt.Fprint(w, "file")
+	})
+	mux.HandleFunc("/preview", func(w http.ResponseWriter, r *http.Request) {
+		if res, err := http.Get(r.URL.Query().Get("url")); err == nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		fmt.Fprint(w, "preview")
+	})
+	mux.HandleFunc("/guestbook", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == "POST" {
+			r.ParseForm()
+			comments = append(comments, r.Form.Get("comment"))
+			fmt.Fprint(w, "thanks")
+			return
+		}
+		fmt.Fprint(w, strings.Join(comments, "<br>"))
+	})
+	mux.HandleFunc("/app/.env", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "DB_PASSWORD=hunter2\n")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>Welcome</html>")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// scanFor runs checks against a URL and returns the type and parameter of
+// each finding
+func scanFor(t *testing.T, w *WebClientModule, target string, opts ScanOptions) ([]string, []WebVuln) {
+	t.Helper()
+	scan, err := w.Scan("c", target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, v := range scan.Vulnerabilities {
+		found = append(found, v.Type+" "+v.Parameter)
+	}
+	return found, scan.Vulnerabilities
+}
+
+func TestScanChecks(t *testing.T) {
+	srv := fakeVulnerableApp(t)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	for _, tc := range []struct {
+		target string
+		checks []string
+		want   string
+	}{
+		{"/search?q=shoes&page=1", []string{"xss"}, "XSS q"},
+		{"/safe?q=shoes", []string{"xss", "sqli", "command", "traversal"}, ""},
+		{"/item?id=7", []string{"sqli"}, "SQL_INJECTION id"},
+		{"/ping?host=127.0.0.1", []string{"command"}, "COMMAND_INJECTION host"},
+		{"/download?file=report.pdf", []string{"traversal"}, "DIRECTORY_TRAVERSAL file"},
+		{"/app/", []string{"disclosure"}, "INFORMATION_DISCLOSURE "},
+	} {
+		found, vulns := scanFor(t, w, srv.URL+tc.target, ScanOptions{Checks: tc.checks})
+		if strings.Join(found, ", ") != tc.want {
+			t.Errorf("%s: found %q, want %q", tc.target, found, tc.want)
+			continue
+		}
+		if len(vulns) == 1 && (!strings.HasPrefix(vulns[0].Request, "GET "+srv.URL) || !strings.HasPrefix(vulns[0].Response, "200") && !strings.HasPrefix(vulns[0].Response, "500")) {
+			t.Errorf("%s: evidence %q %q", tc.target, vulns[0].Request, vulns[0].Response)
+		}
+	}
+
+	_, vulns := scanFor(t, w, srv.URL+"/item?id=7", ScanOptions{Checks: []string{"sqli"}})
+	if v := vulns[0]; v.Payload != "'" || !strings.Contains(v.URL, "id=7%27") || !strings.Contains(v.Response, "SQL syntax") || !strings.Contains(v.Evidence, "MySQL") {
+		t.Errorf("sqli evidence %+v", v)
+	}
+	found, _ := scanFor(t, w, srv.URL+"/item?id=7", ScanOptions{Checks: []string{"sqli"}, Payloads: map[string][]string{"sqli": {"-1"}}})
+	if len(found) != 0 {
+		t.Errorf("custom payloads: found %q", found)
+	}
+	if _, err := w.Scan("c", srv.URL, ScanOptions{Checks: []string{"rce"}}); err == nil {
+		t.Error("accepted an unknown check")
+	}
+}
+
+func TestScanBlind(t *testing.T) {
+	srv := fakeVulnerableApp(t)
+	w := NewWebClientModule()
+	w.CreateClient("c", map[string]interface{}{})
+
+	found, vulns := scanFor(t, w, srv.URL+"/slow?id=7", ScanOptions{Checks: []string{"sqli_time"}, TimeDelay: 300 * time.Millisecond})
+	if strings.Join(found, ", ") != "SQL_INJECTION id" || !strings.Contains(vulns[0].Payload, "SLEEP(0.3)") {
+		t.Errorf("time based: %q %+v", found, vulns)
+	}
+
+	canary, err := StartCanary("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer canary.Close()
+	found, vulns = scanFor(t, w, srv.URL+"/preview?url=https://example.com/", ScanOptions{Checks: []string{"ssrf"}, Canary: canary, CanaryWait: 100 * time.Millisecond})
+	if strings.Join(found, ", ") != "SSRF url" || !strings.Contains(vulns[0].Evidence, "Go-http-client") {
+		t.Errorf("ssrf: %q %+v", found, vulns)
+	}
+	found, _ = scanFor(t, w, srv.URL+"/preview?url=https://example.com/", ScanOptions{Checks: []string{"ssrf"}})
+	if len(found) != 0 {
+		t.Errorf("ssrf without a canary: %q", found)
+	}
+
+	found, _ = scanFor(t, w, srv.URL+"/guestbook", ScanOptions{Checks: []string{"xss"}, Method: "POST", Params: map[string]string{"comment": "hi"}})
+	if strings.Join(found, ", ") != "STORED_XSS comment" {
+		t.Errorf("stored xss: %q", found)
+	}
+}
+
+func TestInjectionTypes(t *testing.T) {
+	srv := fakeVulnerableApp(t)
+	w := NewWebClientModule()
+	result := w.TestInjection(srv.URL+"/item", "sql", map[string]interface{}{"id": 7})
+	vulns := result["vulnerabilities"].([]map[string]interface{})
+	if result["vulnerable"] != true || len(vulns) != 1 || vulns[0]["parameter"] != "id" || vulns[0]["type"] != "sql" {
+		t.Errorf("got %v", result)
+	}
+	if result := w.TestInjection(srv.URL+"/safe", "xss", map[string]interface{}{"q": "a"}); result["vulnerable"] != false {
+		t.Errorf("safe page: %v", result)
+	}
+	if result := w.TestInjection(srv.URL, "ldap", nil); result["error"] == nil {
+		t.Errorf("unknown type: %v", result)
+	}
+}
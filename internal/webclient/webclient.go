@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -37,6 +38,12 @@ type HTTPClient struct {
 	ProxyURL     string
 	FollowRedirect bool
 	TLSVerify    bool
+	Auth         *AuthConfig
+	transport    *http.Transport
+	proxied      map[string]*http.Client // Copies of Client for the proxies of single requests
+	hooks        []clientHook
+	nextHook     int
+	mu           sync.Mutex
 }
 
 // HTTPServer represents an HTTP server
@@ -71,6 +78,7 @@ type HTTPRequest struct {
 	Cookies     map[string]string
 	Timeout     time.Duration
 	FollowRedirect bool
+	Proxy       interface{} // A proxy URL, a chain of them, "env" or "none" instead of the client's
 }
 
 // HTTPResponse represents a detailed HTTP response
@@ -85,6 +93,8 @@ type HTTPResponse struct {
 	ResponseTime time.Duration
 	Redirects    []string
 	TLSInfo      *TLSInfo
+	Proto        string
+	URL          string // URL of the response, after redirects
 }
 
 // TLSInfo contains SSL/TLS certificate information
@@ -126,6 +136,8 @@ type WebVuln struct {
 	Evidence    string
 	Description string
 	Solution    string
+	Request     string // The request that showed it
+	Response    string // Status and the part of the response with the evidence
 }
 
 // NewWebClientModule creates a new web client module
@@ -143,7 +155,7 @@ func (w *WebClientModule) CreateClient(id string, config map[string]interface{})
 	defer w.mu.Unlock()
 
 	// Create cookie jar
-	jar, err := cookiejar.New(nil)
+	jar, err := newCookieJar()
 	if err != nil {
 		return nil, err
 	}
@@ -162,17 +174,43 @@ func (w *WebClientModule) CreateClient(id string, config map[string]interface{})
 		TLSClientConfig: tlsConfig,
 	}
 
-	// Configure proxy if provided
-	if proxyURL, ok := config["proxy"].(string); ok && proxyURL != "" {
-		if parsed, err := url.Parse(proxyURL); err == nil {
-			transport.Proxy = http.ProxyURL(parsed)
+	// Negotiate HTTP/2 over TLS, or speak it in the clear to http:// URLs
+	// with prior knowledge (h2c)
+	if h2c, _ := config["h2c"].(bool); h2c {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	} else if http2, _ := config["http2"].(bool); http2 {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+		transport.Protocols.SetHTTP2(true)
+	}
+
+	// Route through a proxy or a chain of proxies, or the proxies of the
+	// environment if none is configured
+	proxySetting, ok := config["proxy"]
+	if !ok {
+		proxySetting = "env"
+	}
+	route, err := parseProxy(proxySetting)
+	if err != nil {
+		return nil, err
+	}
+	route.apply(transport)
+
+	// Answer Basic, NTLM or Negotiate challenges if credentials are given
+	var auth *AuthConfig
+	if authConfig, ok := config["auth"]; ok {
+		auth, err = parseAuthConfig(authConfig)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// Create HTTP client
 	client := &http.Client{
 		Jar:       jar,
-		Transport: transport,
+		Transport: withAuth(transport, auth),
 		Timeout:   30 * time.Second,
 	}
 
@@ -204,6 +242,10 @@ func (w *WebClientModule) CreateClient(id string, config map[string]interface{})
 		UserAgent:      "Sentra Security Scanner 1.0",
 		FollowRedirect: followRedirect,
 		TLSVerify:      !tlsConfig.InsecureSkipVerify,
+		ProxyURL:       route.String(),
+		Auth:           auth,
+		transport:      transport,
+		proxied:        make(map[string]*http.Client),
 	}
 
 	// Set base URL
@@ -237,6 +279,26 @@ func (w *WebClientModule) Request(clientID string, req *HTTPRequest) (*HTTPRespo
 		return nil, fmt.Errorf("client not found: %s", clientID)
 	}
 
+	// Merge the headers of the client and of the request, then let the
+	// client's hooks see and change the request
+	merged := *req
+	merged.Headers = map[string]string{"User-Agent": client.UserAgent}
+	for k, v := range client.Headers {
+		merged.Headers[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range req.Headers {
+		merged.Headers[http.CanonicalHeaderKey(k)] = v
+	}
+	req = &merged
+	hooks := client.currentHooks()
+	for _, hook := range hooks {
+		if hook.request != nil {
+			if err := hook.request(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	startTime := time.Now()
 
 	// Prepare request body
@@ -252,10 +314,6 @@ func (w *WebClientModule) Request(clientID string, req *HTTPRequest) (*HTTPRespo
 	}
 
 	// Set headers
-	httpReq.Header.Set("User-Agent", client.UserAgent)
-	for k, v := range client.Headers {
-		httpReq.Header.Set(k, v)
-	}
 	for k, v := range req.Headers {
 		httpReq.Header.Set(k, v)
 	}
@@ -266,7 +324,14 @@ func (w *WebClientModule) Request(clientID string, req *HTTPRequest) (*HTTPRespo
 	}
 
 	// Perform request
-	resp, err := client.Client.Do(httpReq)
+	httpClient := client.Client
+	if req.Proxy != nil {
+		httpClient, err = client.viaProxy(req.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := httpClient.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -294,6 +359,8 @@ func (w *WebClientModule) Request(clientID string, req *HTTPRequest) (*HTTPRespo
 		ContentType:  resp.Header.Get("Content-Type"),
 		Length:       resp.ContentLength,
 		ResponseTime: time.Since(startTime),
+		Proto:        resp.Proto,
+		URL:          resp.Request.URL.String(),
 	}
 
 	// Extract TLS information
@@ -301,6 +368,14 @@ func (w *WebClientModule) Request(clientID string, req *HTTPRequest) (*HTTPRespo
 		response.TLSInfo = w.extractTLSInfo(resp.TLS)
 	}
 
+	for _, hook := range hooks {
+		if hook.response != nil {
+			if err := hook.response(req, response); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return response, nil
 }
 
@@ -430,222 +505,9 @@ func (w *WebClientModule) Login(sessionID, loginURL, username, password string,
 	return fmt.Errorf("login failed with status: %d", resp.StatusCode)
 }
 
-// ScanWebVulnerabilities performs web vulnerability scanning
+// ScanWebVulnerabilities runs the default checks against a URL
 func (w *WebClientModule) ScanWebVulnerabilities(clientID, targetURL string) (*WebVulnScan, error) {
-	startTime := time.Now()
-	scan := &WebVulnScan{
-		URL:             targetURL,
-		Vulnerabilities: make([]WebVuln, 0),
-		ScanTime:        startTime,
-	}
-
-	// Test for SQL Injection
-	sqlVulns := w.testSQLInjection(clientID, targetURL)
-	scan.Vulnerabilities = append(scan.Vulnerabilities, sqlVulns...)
-
-	// Test for XSS
-	xssVulns := w.testXSS(clientID, targetURL)
-	scan.Vulnerabilities = append(scan.Vulnerabilities, xssVulns...)
-
-	// Test for directory traversal
-	dirVulns := w.testDirectoryTraversal(clientID, targetURL)
-	scan.Vulnerabilities = append(scan.Vulnerabilities, dirVulns...)
-
-	// Test for information disclosure
-	infoVulns := w.testInformationDisclosure(clientID, targetURL)
-	scan.Vulnerabilities = append(scan.Vulnerabilities, infoVulns...)
-
-	scan.Duration = time.Since(startTime)
-	return scan, nil
-}
-
-// testSQLInjection tests for SQL injection vulnerabilities
-func (w *WebClientModule) testSQLInjection(clientID, targetURL string) []WebVuln {
-	var vulns []WebVuln
-
-	sqlPayloads := []string{
-		"'",
-		"' OR '1'='1",
-		"' UNION SELECT NULL--",
-		"'; DROP TABLE users--",
-		"1' AND '1'='2",
-	}
-
-	for _, payload := range sqlPayloads {
-		// Test in URL parameters
-		testURL := targetURL + "?id=" + url.QueryEscape(payload)
-		
-		req := &HTTPRequest{
-			Method: "GET",
-			URL:    testURL,
-		}
-
-		resp, err := w.Request(clientID, req)
-		if err != nil {
-			continue
-		}
-
-		// Check for SQL error indicators
-		body := strings.ToLower(resp.Body)
-		sqlErrors := []string{
-			"sql syntax", "mysql_fetch", "ora-", "postgresql",
-			"sqlite_", "sql server", "syntax error", "mysql error",
-		}
-
-		for _, sqlError := range sqlErrors {
-			if strings.Contains(body, sqlError) {
-				vuln := WebVuln{
-					Type:        "SQL_INJECTION",
-					Severity:    "HIGH",
-					URL:         testURL,
-					Parameter:   "id",
-					Payload:     payload,
-					Evidence:    fmt.Sprintf("SQL error found: %s", sqlError),
-					Description: "SQL injection vulnerability detected",
-					Solution:    "Use parameterized queries and input validation",
-				}
-				vulns = append(vulns, vuln)
-				break
-			}
-		}
-	}
-
-	return vulns
-}
-
-// testXSS tests for Cross-Site Scripting vulnerabilities
-func (w *WebClientModule) testXSS(clientID, targetURL string) []WebVuln {
-	var vulns []WebVuln
-
-	xssPayloads := []string{
-		"<script>alert('XSS')</script>",
-		"<img src=x onerror=alert('XSS')>",
-		"javascript:alert('XSS')",
-		"<svg onload=alert('XSS')>",
-	}
-
-	for _, payload := range xssPayloads {
-		// Test in URL parameters
-		testURL := targetURL + "?search=" + url.QueryEscape(payload)
-		
-		req := &HTTPRequest{
-			Method: "GET",
-			URL:    testURL,
-		}
-
-		resp, err := w.Request(clientID, req)
-		if err != nil {
-			continue
-		}
-
-		// Check if payload is reflected without encoding
-		if strings.Contains(resp.Body, payload) {
-			vuln := WebVuln{
-				Type:        "XSS",
-				Severity:    "MEDIUM",
-				URL:         testURL,
-				Parameter:   "search",
-				Payload:     payload,
-				Evidence:    "Payload reflected without encoding",
-				Description: "Cross-Site Scripting vulnerability detected",
-				Solution:    "Implement proper input validation and output encoding",
-			}
-			vulns = append(vulns, vuln)
-		}
-	}
-
-	return vulns
-}
-
-// testDirectoryTraversal tests for directory traversal vulnerabilities
-func (w *WebClientModule) testDirectoryTraversal(clientID, targetURL string) []WebVuln {
-	var vulns []WebVuln
-
-	traversalPayloads := []string{
-		"../../../etc/passwd",
-		"..\\..\\..\\windows\\system32\\drivers\\etc\\hosts",
-		"....//....//....//etc/passwd",
-		"%2e%2e%2f%2e%2e%2f%2e%2e%2fetc%2fpasswd",
-	}
-
-	for _, payload := range traversalPayloads {
-		testURL := targetURL + "?file=" + url.QueryEscape(payload)
-		
-		req := &HTTPRequest{
-			Method: "GET",
-			URL:    testURL,
-		}
-
-		resp, err := w.Request(clientID, req)
-		if err != nil {
-			continue
-		}
-
-		// Check for file content indicators
-		body := strings.ToLower(resp.Body)
-		if strings.Contains(body, "root:") || 
-		   strings.Contains(body, "localhost") ||
-		   strings.Contains(body, "[boot loader]") {
-			vuln := WebVuln{
-				Type:        "DIRECTORY_TRAVERSAL",
-				Severity:    "HIGH",
-				URL:         testURL,
-				Parameter:   "file",
-				Payload:     payload,
-				Evidence:    "System file content detected",
-				Description: "Directory traversal vulnerability detected",
-				Solution:    "Implement proper file path validation and access controls",
-			}
-			vulns = append(vulns, vuln)
-		}
-	}
-
-	return vulns
-}
-
-// testInformationDisclosure tests for information disclosure
-func (w *WebClientModule) testInformationDisclosure(clientID, targetURL string) []WebVuln {
-	var vulns []WebVuln
-
-	// Test common sensitive files
-	sensitiveFiles := []string{
-		"/.env",
-		"/config.php",
-		"/web.config",
-		"/.git/config",
-		"/backup.sql",
-		"/phpinfo.php",
-	}
-
-	for _, file := range sensitiveFiles {
-		testURL := targetURL + file
-		
-		req := &HTTPRequest{
-			Method: "GET",
-			URL:    testURL,
-		}
-
-		resp, err := w.Request(clientID, req)
-		if err != nil {
-			continue
-		}
-
-		if resp.StatusCode == 200 {
-			vuln := WebVuln{
-				Type:        "INFORMATION_DISCLOSURE",
-				Severity:    "MEDIUM",
-				URL:         testURL,
-				Parameter:   "",
-				Payload:     file,
-				Evidence:    fmt.Sprintf("Sensitive file accessible (Status: %d)", resp.StatusCode),
-				Description: "Sensitive file disclosure detected",
-				Solution:    "Remove or restrict access to sensitive files",
-			}
-			vulns = append(vulns, vuln)
-		}
-	}
-
-	return vulns
+	return w.Scan(clientID, targetURL, ScanOptions{})
 }
 
 // CreateServer creates an HTTP server
@@ -802,6 +664,10 @@ func (w *WebClientModule) GetClientInfo(clientID string) (map[string]interface{}
 		"follow_redirect": client.FollowRedirect,
 		"tls_verify":      client.TLSVerify,
 		"headers":         client.Headers,
+		"proxy":           client.ProxyURL,
+	}
+	if client.Auth != nil {
+		info["auth"] = client.Auth.Type
 	}
 
 	return info, nil
@@ -825,20 +691,21 @@ func (w *WebClientModule) FormatJSON(data map[string]interface{}) (string, error
 
 // ExtractForms extracts HTML forms from response body
 func (w *WebClientModule) ExtractForms(html string) []map[string]interface{} {
-	// Simple form extraction (would need proper HTML parser for production)
 	forms := make([]map[string]interface{}, 0)
-	
-	// This is a simplified implementation
-	// Real implementation would use html.Parse or similar
-	if strings.Contains(strings.ToLower(html), "<form") {
-		form := map[string]interface{}{
-			"method": "POST",
-			"action": "/",
-			"fields": []string{"username", "password"}, // Simplified
+	for _, f := range parseForms(html, &url.URL{}) {
+		fields := make([]string, 0, len(f.Fields))
+		for name := range f.Fields {
+			fields = append(fields, name)
 		}
-		forms = append(forms, form)
+		sort.Strings(fields)
+		forms = append(forms, map[string]interface{}{
+			"method":   f.Method,
+			"action":   f.Action,
+			"fields":   fields,
+			"hidden":   f.Hidden,
+			"password": f.Password,
+		})
 	}
-
 	return forms
 }
 
@@ -1028,86 +895,55 @@ func (w *WebClientModule) TestAuthentication(endpoint string, config map[string]
 	return result
 }
 
-// TestInjection tests for various injection vulnerabilities
+// TestInjection tests the parameters of an endpoint with the payloads of
+// one check, such as sql, xss or command
 func (w *WebClientModule) TestInjection(endpoint string, injectionType string, params map[string]interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
 	vulnerabilities := []map[string]interface{}{}
-	
-	client := &http.Client{Timeout: 30 * time.Second}
-	
-	payloads := map[string][]string{
-		"sql": {
-			"' OR '1'='1",
-			"'; DROP TABLE users--",
-			"' UNION SELECT NULL--",
-			"1' AND '1' = '1",
-		},
-		"nosql": {
-			`{"$ne": null}`,
-			`{"$gt": ""}`,
-			`{"$regex": ".*"}`,
-		},
-		"command": {
-			"; ls -la",
-			"| whoami",
-			"` id `",
-			"$(whoami)",
-		},
-		"xxe": {
-			`<?xml version="1.0"?><!DOCTYPE root [<!ENTITY test SYSTEM "file:///etc/passwd">]><root>&test;</root>`,
-		},
-		"xpath": {
-			"' or '1'='1",
-			"'] | //user/*",
-		},
+
+	check := injectionType
+	if injectionType == "sql" {
+		check = "sqli"
 	}
-	
-	testPayloads, ok := payloads[injectionType]
-	if !ok {
-		result["error"] = fmt.Sprintf("unknown injection type: %s", injectionType)
+	values := make(map[string]string)
+	for k, v := range params {
+		values[k] = fmt.Sprintf("%v", v)
+	}
+
+	tester := NewWebClientModule()
+	if _, err := tester.CreateClient("injection", map[string]interface{}{}); err != nil {
+		result["error"] = err.Error()
 		return result
 	}
-	
-	for _, payload := range testPayloads {
-		// Test each parameter with the payload
-		for key := range params {
-			testParams := make(map[string]string)
-			for k, v := range params {
-				testParams[k] = fmt.Sprintf("%v", v)
-			}
-			testParams[key] = payload
-			
-			// Build URL with parameters
-			u, _ := url.Parse(endpoint)
-			q := u.Query()
-			for k, v := range testParams {
-				q.Set(k, v)
-			}
-			u.RawQuery = q.Encode()
-			
-			resp, err := client.Get(u.String())
-			if err == nil {
-				defer resp.Body.Close()
-				body, _ := io.ReadAll(resp.Body)
-				
-				// Check for signs of injection
-				if w.detectInjection(string(body), injectionType) {
-					vuln := map[string]interface{}{
-						"parameter": key,
-						"payload":   payload,
-						"type":      injectionType,
-					}
-					vulnerabilities = append(vulnerabilities, vuln)
-				}
-			}
-		}
+	s, err := newScanner(func(req *HTTPRequest) (*HTTPResponse, error) {
+		return tester.Request("injection", req)
+	}, endpoint, ScanOptions{Checks: []string{check}, Params: values})
+	if err != nil {
+		result["error"] = err.Error()
+		return result
 	}
-	
+	vulns, err := s.run()
+	if err != nil {
+		result["error"] = err.Error()
+		return result
+	}
+	for _, v := range vulns {
+		vulnerabilities = append(vulnerabilities, map[string]interface{}{
+			"parameter": v.Parameter,
+			"payload":   v.Payload,
+			"type":      injectionType,
+			"severity":  v.Severity,
+			"evidence":  v.Evidence,
+			"request":   v.Request,
+			"response":  v.Response,
+		})
+	}
+
 	result["endpoint"] = endpoint
 	result["injection_type"] = injectionType
 	result["vulnerabilities"] = vulnerabilities
 	result["vulnerable"] = len(vulnerabilities) > 0
-	
+
 	return result
 }
 
@@ -1496,42 +1332,6 @@ func (w *WebClientModule) TestJWT(endpoint string, token string) map[string]inte
 	return result
 }
 
-// detectInjection checks response for signs of injection
-func (w *WebClientModule) detectInjection(response string, injectionType string) bool {
-	indicators := map[string][]string{
-		"sql": {
-			"SQL syntax",
-			"mysql_fetch",
-			"ORA-",
-			"PostgreSQL",
-			"SQLite",
-			"Microsoft SQL Server",
-		},
-		"command": {
-			"root:",
-			"bin/bash",
-			"uid=",
-			"gid=",
-			"/etc/passwd",
-		},
-		"xxe": {
-			"root:x:0:0",
-			"<!ENTITY",
-			"SYSTEM",
-		},
-	}
-	
-	if patterns, ok := indicators[injectionType]; ok {
-		for _, pattern := range patterns {
-			if strings.Contains(response, pattern) {
-				return true
-			}
-		}
-	}
-	
-	return false
-}
-
 // base64URLEncode performs URL-safe base64 encoding
 func base64URLEncode(data []byte) string {
 	encoded := base64.RawURLEncoding.EncodeToString(data)